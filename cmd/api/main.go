@@ -1,22 +1,49 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"deploy-platform/internal/activations"
 	"deploy-platform/internal/api"
+	"deploy-platform/internal/assets"
 	"deploy-platform/internal/auth"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/backup"
 	"deploy-platform/internal/build"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/compress"
 	"deploy-platform/internal/config"
+	"deploy-platform/internal/cost"
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/drift"
 	"deploy-platform/internal/github"
+	"deploy-platform/internal/hooks"
 	"deploy-platform/internal/hostname"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/incidents"
 	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/logsink"
+	"deploy-platform/internal/metrics"
 	"deploy-platform/internal/oauth"
+	"deploy-platform/internal/preview"
 	"deploy-platform/internal/queue"
+	"deploy-platform/internal/quota"
 	"deploy-platform/internal/ratelimit"
+	"deploy-platform/internal/registry"
+	"deploy-platform/internal/secretref"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/storage"
+	"deploy-platform/internal/throttle"
 	"deploy-platform/pkg/docker"
 
 	"github.com/gin-gonic/gin"
@@ -24,31 +51,66 @@ import (
 )
 
 func main() {
+	var checkConfig bool
+	flag.BoolVar(&checkConfig, "validate", false, "validate the configuration and exit")
+	flag.BoolVar(&checkConfig, "check-config", false, "same as -validate")
+	flag.Parse()
+
 	// Load .env file (ignore error if file doesn't exist)
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
 	cfg := config.Load()
+	validation := cfg.Validate()
+	for _, warning := range validation.Warnings {
+		log.Printf("⚠️  %s", warning)
+	}
+	if err := validation.Err(); err != nil {
+		log.Fatalf("❌ Invalid configuration, %v", err)
+	}
+	if checkConfig {
+		log.Printf("✅ Configuration is valid (%d warnings)", len(validation.Warnings))
+		return
+	}
 
-	// Validate OAuth config before initializing
-	if cfg.GitHubClientID == "" {
-		log.Fatal("❌ GITHUB_CLIENT_ID is not set! Please check your .env file")
+	// Platform logs, and the logs of builds once done, also go to LOG_SINK_URL
+	logShipper, err := logsink.New(cfg)
+	if err != nil {
+		log.Fatalf("❌ Invalid configuration, %v", err)
 	}
-	if cfg.GitHubClientSecret == "" {
-		log.Fatal("❌ GITHUB_CLIENT_SECRET is not set! Please check your .env file")
+	if logShipper != nil {
+		logShipper.CapturePlatformLogs()
+		logShipper.ShipBuildLogs()
+		logShipper.RegisterMetrics()
+		logShipper.Start(context.Background())
+		log.Printf("✅ Logs shipped to the %s log sink", cfg.LogSinkType)
 	}
 
-	log.Printf("✅ OAuth Config loaded - Client ID: %s...", cfg.GitHubClientID[:10])
+	log.Printf("✅ OAuth Config loaded - Client ID: %s...", cfg.GitHubClientID[:min(len(cfg.GitHubClientID), 10)])
 
-	github.InitOAuth(cfg)
-	github.InitWebhook(cfg)
+	githubOAuth := github.NewOAuthHandler(cfg)
 	oauth.InitGoogleOAuth(cfg)
+	oauth.InitOIDC(cfg)
 
 	// Initialize database
 	if err := database.InitDB(cfg.DatabaseURL); err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
+	// Deployments that went live before activations were recorded
+	if _, err := activations.Backfill(database.DB); err != nil {
+		log.Printf("⚠️  Failed to backfill deployment activations: %v", err)
+	}
+
+	// Initialize encryption of stored credentials
+	if err := secrets.Init(cfg); err != nil {
+		log.Fatal("Failed to initialize secrets encryption:", err)
+	}
+
+	// Initialize plans and quota counters
+	if err := quota.Init(cfg); err != nil {
+		log.Fatal("Failed to initialize quotas:", err)
+	}
 
 	// Initialize Docker client
 	dockerClient, err := docker.NewClient()
@@ -60,30 +122,84 @@ func main() {
 		log.Println("✅ Docker client initialized")
 	}
 
-	// Initialize Kubernetes client (optional)
+	// Initialize Kubernetes clients (optional), one per cluster
 	// Try to initialize even if config is empty (will use in-cluster or default kubeconfig)
-	var k8sClient *kubernetes.Client
-	k8s, err := kubernetes.NewClient(cfg.KubernetesConfig)
-	if err != nil {
-		log.Printf("⚠️  Warning: Failed to initialize Kubernetes client: %v", err)
+	k8sClients := kubernetes.NewClientSet(cfg)
+	if k8sClients == nil {
+		log.Println("⚠️  Warning: No Kubernetes cluster could be reached")
 		log.Println("   Kubernetes deployments will be skipped.")
-	} else {
-		k8sClient = k8s
-		log.Println("✅ Kubernetes client initialized")
 	}
 
 	// Initialize hostname manager
 	hostnameMgr := hostname.NewManager(cfg)
+	api.InitHostnameManager(hostnameMgr)
+	api.InitKubernetes(k8sClients)
 
 	// Initialize JWT
 	auth.InitJWT(cfg)
+	auth.InitAdmins(cfg)
+	auth.InitAllowlist(cfg)
+	auth.InitImpersonation(cfg)
+	auth.InitSession(cfg)
+	auth.InitLoginErrors(cfg)
+	kubernetes.InitIngress(cfg)
+	kubernetes.InitNetworkPolicies(cfg)
+	kubernetes.InitLabels(cfg)
+	kubernetes.InitReleaseJobs(cfg)
+	kubernetes.InitPlaceholder(cfg)
+	kubernetes.InitRegistry(cfg)
+	registry.Init(cfg)
+	build.InitApprovals(cfg)
+	build.InitRuntimeVersions(cfg)
+	if err := kubernetes.InitExec(cfg); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	api.InitExec(cfg.ExecEnabled)
+
+	// Generated Dockerfile templates; a broken override should stop startup, not every build
+	dockerfileTemplates, err := build.LoadTemplates(cfg)
+	if err != nil {
+		log.Fatalf("❌ Failed to load Dockerfile templates: %v", err)
+	}
+	api.InitDockerfileTemplates(dockerfileTemplates)
+
+	// A provenance key that can't be read would leave records unsigned unnoticed
+	provenanceSigner, err := build.LoadSigner(cfg.ProvenanceSigningKey)
+	if err != nil {
+		log.Fatalf("❌ Failed to load provenance signing key: %v", err)
+	}
+	api.InitProvenanceSigner(provenanceSigner)
+
+	// SBOMs and provenance kept outside the database when SUPPLY_CHAIN_STORAGE is set
+	var artifactStorage storage.Storage
+	if cfg.SupplyChainStorage != "" {
+		artifactStorage, err = storage.Open(cfg, "SUPPLY_CHAIN_STORAGE", cfg.SupplyChainStorage)
+		if err != nil {
+			log.Fatalf("❌ Failed to open supply chain storage: %v", err)
+		}
+		api.InitArtifactStorage(artifactStorage)
+	}
+
+	// Command hooks run after any registered by programs embedding the platform
+	if err := hooks.LoadCommands(cfg); err != nil {
+		log.Fatalf("❌ Failed to load hooks: %v", err)
+	}
+
+	// Branch and preview deployments get resources of their own from their
+	// project's preview provisioner, torn down once they are retired
+	previews := preview.NewManager(k8sClients)
+	api.InitPreviews(previews)
+
+	// Env vars may reference secrets in Vault or AWS Secrets Manager, read at deploy time
+	secretResolver := secretref.FromConfig(cfg)
+	api.InitSecretResolver(secretResolver)
 
 	// Initialize build service for webhook handlers
 	var buildService *build.Service
 	if dockerClient != nil {
-		if k8sClient != nil {
+		if k8sClients != nil {
 			// Use build service with Kubernetes support
-			buildService = build.NewServiceWithK8s(dockerClient, k8sClient, hostnameMgr)
+			buildService = build.NewServiceWithK8s(dockerClient, k8sClients, hostnameMgr)
 			log.Println("✅ Build service initialized with Kubernetes support")
 		} else {
 			// Use build service without Kubernetes
@@ -94,109 +210,414 @@ func main() {
 				log.Println("✅ Build service initialized (without Kubernetes)")
 			}
 		}
-		github.InitBuildServiceWithService(buildService)
 	} else {
 		log.Println("⚠️  Build service not initialized (Docker client unavailable)")
 	}
 
 	// Initialize build queue and worker pool
 	var workerPool *queue.WorkerPool
+	var buildQueue queue.BuildQueue
+	var buildSlots, deploySlots *throttle.Semaphore
+	// Output of running builds, written to the database in batches
+	logStreams := buildlogs.NewStreams(database.DB)
 	if buildService != nil {
-		buildQueue := queue.NewInMemoryQueue()
-		github.InitBuildQueue(buildQueue)
+		// Builds and deploys are throttled separately: one saturates the build host, the other the cluster API
+		buildSlots = throttle.New("build", cfg.BuildConcurrency)
+		deploySlots = throttle.New("deploy", cfg.DeployConcurrency)
+		buildService.SetThrottles(buildSlots, deploySlots, cfg.BuildWeightStepMB)
+		buildService.SetTemplates(dockerfileTemplates)
+		buildService.SetImageBudget(cfg.ImageSizeWarnMB, cfg.ImageSizeMaxMB, cfg.ImageMaxLayers)
+		buildService.SetRolloutTimeout(cfg.RolloutTimeout)
+		buildService.SetDeployRetry(cfg.DeployRetryAttempts, cfg.DeployRetryBackoff, cfg.DeployRetryMaxBackoff)
+		buildService.SetReleaseTimeout(cfg.ReleaseTimeout)
+		buildService.SetGitLFS(cfg.GitLFS)
+		buildService.SetCloneCache(cfg.GitCacheDir, cfg.GitCacheMaxMB)
+		buildService.SetWorkDir(cfg.BuildWorkDir, cfg.BuildKeepFailed)
+		buildService.SetRegistry(cfg.RegistryURL, cfg.RegistryUser, cfg.RegistryPassword)
+		buildService.SetSupplyChain(cfg.BaseURL, cfg.SBOMGenerator, provenanceSigner, cfg.SupplyChainStrict)
+		if artifactStorage != nil {
+			buildService.SetArtifactStorage(artifactStorage)
+		}
+		buildService.SetPreviews(previews)
+		buildService.SetSecretResolver(secretResolver)
+		buildService.SetLogStreams(logStreams)
+		api.InitLogStreams(logStreams)
+		api.InitBuildService(buildService)
+
+		buildQueue = queue.NewInMemoryQueue()
 
-		// Start worker pool with 3 workers (configurable)
-		workerPool = queue.NewWorkerPool(buildQueue, buildService, 3)
+		// Start worker pool with 3 workers (configurable), and at least one more
+		// than build slots: a production job arriving while previews fill them
+		// all needs a worker to preempt one
+		workerPool = queue.NewWorkerPool(buildQueue, buildService, max(3, cfg.BuildConcurrency+1))
+		workerPool.SetReservedSlots(cfg.ReservedBuildSlots())
+		workerPool.SetInstance(cfg.InstanceID)
 		workerPool.Start()
+		api.InitWorkerPool(workerPool)
 		log.Println("✅ Build queue and worker pool initialized")
 	}
+	api.InitBuildMonitor(buildQueue, cfg.BuildHeartbeatTimeout, buildSlots, deploySlots)
+	registerThrottleMetrics(buildQueue, buildSlots, deploySlots)
+	httpclient.RegisterMetrics()
+	build.RegisterMetrics()
+
+	// Builds report check runs through the GitHub App, when there is one
+	var checks github.CheckRunner
+	githubApp, err := github.LoadApp(cfg.GitHubAppID, cfg.GitHubAppKey)
+	if err != nil {
+		log.Fatalf("❌ Failed to load the GitHub App key: %v", err)
+	}
+	if githubApp != nil {
+		checks = githubApp
+		log.Printf("✅ Builds reported as check runs of GitHub App %d", cfg.GitHubAppID)
+	}
+
+	// GitHub and generic webhooks, and the deployments they share wiring with
+	webhooks := github.NewWebhookHandler(cfg, github.WebhookDeps{
+		Queue:     buildQueue,
+		Builds:    buildService,
+		Workers:   workerPool,
+		Hostnames: hostnameMgr,
+		Clusters:  k8sClients,
+		Analyzer:  build.NewAnalyzer(dockerfileTemplates, cfg.AnalysisTimeout, cfg.AnalysisMaxRepoMB),
+		Checks:    checks,
+	})
+	webhooks.RegisterHooks()
+	webhooks.RegisterMetrics()
+
+	// Periodic jobs; those that must run once per interval, whichever
+	// replicas are up, run on the replica holding the leader lease
+	elector, err := background.NewElector(cfg, database.DB)
+	if err != nil {
+		log.Fatal("Failed to configure leader election:", err)
+	}
+	jobs := background.NewRunner(cfg, database.DB, elector)
+	api.InitBackground(jobs)
 
-	// Initialize rate limiter (10 requests per minute per IP)
-	rateLimiter := ratelimit.NewLimiter(10, 60*time.Second)
+	// Build again the deployments whose worker stopped heartbeating
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	jobs.Register(build.WatchdogJob(cfg.BuildHeartbeatTimeout))
+	if buildService != nil {
+		logStreams.Start(watchdogCtx)
+		// Workspaces of builds that died with their worker are removed
+		jobs.Register(buildService.WorkspaceJob())
+	}
+
+	// Cancel production deployments not approved in time
+	jobs.Register(build.ApprovalExpiryJob())
+
+	// Queue again the rollout retries lost with a restarted replica's queue,
+	// and the deployments handed back by replicas that stopped
+	if workerPool != nil {
+		jobs.Register(workerPool.LostRolloutsJob())
+		jobs.Register(workerPool.LostDeploymentsJob())
+	}
+
+	// Retry GitHub webhook events whose processing failed
+	webhooks.StartRetries(watchdogCtx)
+
+	// Outages of docker, the clusters, the database and the registry become
+	// incidents users see on the dashboard
+	incidentMonitor := incidents.NewMonitor(cfg, incidents.Checks(cfg, dockerClient, k8sClients))
+	api.InitIncidentMonitor(incidentMonitor)
+	incidentMonitor.Start(watchdogCtx)
+
+	// Objects left in the clusters by deleted projects are orphans, live
+	// deployments whose objects are gone have drifted
+	if k8sClients != nil {
+		driftReconciler := drift.NewReconciler(cfg, k8sClients)
+		driftReconciler.SetPreviews(previews)
+		api.InitDriftReconciler(driftReconciler)
+		driftReconciler.RegisterMetrics()
+		jobs.Register(driftReconciler.Job())
+	}
+
+	// Teardowns of retired preview environments that failed are retried
+	jobs.Register(previews.Job())
+
+	// Cost estimates price what projects' pods request, sampled every period
+	api.InitCosts(cfg)
+	if k8sClients != nil {
+		jobs.Register(cost.NewSampler(cfg, k8sClients).Jobs()...)
+	}
+
+	// Encrypted database backups, on request and on BACKUP_SCHEDULE
+	backupService, err := backup.NewService(cfg, database.DB)
+	if err != nil {
+		log.Printf("⚠️  Warning: Backups disabled: %v", err)
+	} else {
+		api.InitBackups(backupService)
+		backupService.Start(watchdogCtx)
+	}
+
+	// Logs of old builds move to BUILD_LOG_ARCHIVE
+	if cfg.BuildLogArchive != "" {
+		logArchive, err := buildlogs.NewArchive(cfg, database.DB)
+		if err != nil {
+			log.Printf("⚠️  Warning: Build log archiving disabled: %v", err)
+		} else {
+			api.InitBuildLogs(logArchive)
+			jobs.Register(logArchive.Job())
+		}
+	}
+	jobs.Start(watchdogCtx)
+
+	// Initialize rate limiter (10 requests per minute per IP, or per project
+	// token), counted in a store shared by all replicas when one is configured
+	rateLimitStore, storeName, err := ratelimit.NewStore(cfg, database.DB)
+	if err != nil {
+		log.Fatal("Failed to configure rate limiting:", err)
+	}
+	log.Printf("✅ Rate limits counted in %s", storeName)
+	auth.InitLoginThrottle(cfg, rateLimitStore)
+	rateLimiter := ratelimit.NewSharedLimiter(rateLimitStore, 10, 60*time.Second)
+	// Log downloads, per user: resumed and segmented downloads make several requests
+	logDownloadLimiter := ratelimit.NewSharedLimiter(rateLimitStore, 30, 60*time.Second)
+	// Sign-ins and sign-ups, per IP
+	authLimit := gin.HandlerFunc(func(c *gin.Context) { c.Next() })
+	if cfg.AuthRateLimit > 0 {
+		authLimiter := ratelimit.NewSharedLimiter(rateLimitStore, cfg.AuthRateLimit, 60*time.Second)
+		authLimit = ratelimit.Middleware(authLimiter, ratelimit.Scoped("auth", ratelimit.ByIP))
+	}
 
 	// Setup Gin router
 	r := gin.Default()
+	if err := ratelimit.TrustProxies(r, cfg.TrustedProxies); err != nil {
+		log.Fatalf("❌ Invalid TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(compress.Gzip(1024)) // Compress responses of 1KB and up
 
-	// Load HTML templates
-	r.LoadHTMLGlob("web/templates/*")
-	r.Static("/static", "./web/static")
+	// HTML templates and static files, embedded unless WEB_DIR is set
+	webAssets, err := assets.Load(cfg.WebDir)
+	if err != nil {
+		log.Fatalf("❌ Failed to load web assets: %v", err)
+	}
+	if err := webAssets.Register(r, cfg.PlatformName); err != nil {
+		log.Fatalf("❌ Failed to load web templates: %v", err)
+	}
+	api.InitUIConfig(cfg)
 
 	// Public routes
 	r.GET("/", api.ServeIndex)
-	r.GET("/login", api.ServeLogin)
-	r.GET("/dashboard", func(c *gin.Context) {
-		// Try to get from query parameter (OAuth redirect)
-		if queryToken := c.Query("token"); queryToken != "" {
-			// Store token in localStorage via JavaScript redirect
-			c.HTML(http.StatusOK, "dashboard_redirect.html", gin.H{
-				"Token": queryToken,
-			})
-			return
-		}
-		// For regular access, serve the dashboard page
-		// Client-side JavaScript will handle authentication via localStorage
-		c.HTML(http.StatusOK, "index.html", nil)
-	})
+	r.GET("/login", auth.RedirectAuthenticated(), api.ServeLogin)
+	r.GET("/logout", api.ServeLogout)
+	r.GET("/dashboard", auth.PageGuard(), api.ServeDashboard)
 
 	// Auth routes
-	r.GET("/auth/github", github.HandleGitHubLogin)
-	r.GET("/auth/github/callback", github.HandleGitHubCallback)
+	r.GET("/auth/github", githubOAuth.HandleGitHubLogin)
+	r.GET("/auth/github/callback", githubOAuth.HandleGitHubCallback)
 	r.GET("/auth/google", oauth.HandleGoogleLogin)
 	r.GET("/auth/google/callback", oauth.HandleGoogleCallback)
+	r.GET("/auth/oidc", oauth.HandleOIDCLogin)
+	r.GET("/auth/oidc/callback", oauth.HandleOIDCCallback)
 
 	// API routes
 	apiGroup := r.Group("/api")
 	{
 		// Public auth endpoints
-		apiGroup.POST("/auth/register", api.Register)
-		apiGroup.POST("/auth/login", api.Login)
+		apiGroup.POST("/auth/register", authLimit, api.Register)
+		apiGroup.POST("/auth/login", authLimit, api.Login)
+
+		// Sign-in providers and platform name, for the login page
+		apiGroup.GET("/ui-config", api.GetUIConfig)
+
+		// Platform incidents, shown to anyone
+		apiGroup.GET("/platform-status", api.GetPlatformStatus)
+
+		// Public key verifying signed provenance records
+		apiGroup.GET("/provenance/key", api.GetProvenanceKey)
 
 		// Protected endpoints
 		protected := apiGroup.Group("")
 		protected.Use(auth.AuthMiddleware())
 		{
-			protected.GET("/profile", func(c *gin.Context) {
-				userID := c.GetUint("user_id")
-				username := c.GetString("username")
-				c.JSON(http.StatusOK, gin.H{
-					"user_id":  userID,
-					"username": username,
-				})
-			})
+			protected.GET("/profile", api.GetProfile)
+			protected.GET("/profile/security-activity", api.GetSecurityActivity)
+			protected.POST("/auth/github/upgrade-scopes", githubOAuth.HandleUpgradeScopes)
+			protected.GET("/overview", api.GetOverview)
+			protected.GET("/search", api.Search)
 			protected.GET("/projects", api.GetProjects)
 			protected.POST("/projects", api.CreateProject)
 			protected.POST("/projects/:id/link", api.LinkProject)
+			protected.POST("/projects/:id/archive", api.ArchiveProject)
+			protected.POST("/projects/:id/unarchive", api.UnarchiveProject)
+			protected.POST("/projects/:id/deployments", webhooks.HandleDeployRef)
+			protected.GET("/projects/:id/deployments/export", api.ExportProjectDeployments)
+			protected.GET("/projects/:id/live-at", api.GetProjectLiveAt)
+			protected.GET("/projects/:id/cost", api.GetProjectCost)
+			protected.GET("/projects/:id/analyses", api.GetProjectAnalyses)
+			protected.POST("/projects/:id/cluster", webhooks.HandleMigrateCluster)
+			protected.GET("/projects/:id/hostnames", api.GetProjectHostnames)
+			protected.POST("/projects/:id/domains", api.AddProjectDomain)
+			protected.DELETE("/projects/:id/domains/:domain", api.DeleteProjectDomain)
+			protected.GET("/projects/:id/settings", api.GetProjectSettings)
+			protected.PUT("/projects/:id/settings", api.UpdateProjectSettings)
+			protected.POST("/projects/:id/settings/validate", api.ValidateProjectSettings)
+			protected.GET("/projects/:id/manifests", api.GetProjectManifests)
+			protected.POST("/projects/:id/exec", api.RunProjectCommand)
+			protected.GET("/projects/:id/dockerfile", api.GetProjectDockerfile)
+			protected.PUT("/projects/:id/dockerfile", api.UpdateProjectDockerfile)
+			protected.POST("/projects/:id/webhook-token", api.GenerateWebhookToken)
+			protected.POST("/projects/:id/deploy-key", api.GenerateDeployKey)
+			protected.PUT("/projects/:id/clone-token", api.SetCloneToken)
+			protected.GET("/projects/:id/env", api.GetProjectEnv)
+			protected.POST("/projects/:id/env", api.SetProjectEnv)
+			protected.PUT("/projects/:id/env", api.SetProjectEnv) // Alias of POST, for older clients
+			protected.DELETE("/projects/:id/env/:key", api.DeleteProjectEnv)
+			protected.GET("/projects/:id/registry-credentials", api.GetRegistryCredentials)
+			protected.PUT("/projects/:id/registry-credentials", api.SetRegistryCredential)
+			protected.DELETE("/projects/:id/registry-credentials/:credentialID", api.DeleteRegistryCredential)
 			protected.GET("/deployments", api.GetDeployments)
 			protected.GET("/deployments/:id", api.GetDeployment)
+			protected.GET("/deployments/:id/history", api.GetDeploymentHistory)
+			protected.GET("/deployments/:id/status", api.GetDeploymentStatus)
+			protected.GET("/deployments/:id/logs", api.GetDeploymentLogs)
+			protected.GET("/deployments/:id/logs/download", ratelimit.Middleware(logDownloadLimiter, ratelimit.ByUser), api.DownloadDeploymentLogs)
+			protected.GET("/deployments/:id/sbom", api.GetDeploymentSBOM)
+			protected.GET("/deployments/:id/provenance", api.GetDeploymentProvenance)
+			protected.POST("/deployments/:id/approve", webhooks.ApproveDeployment)
+			protected.POST("/deployments/:id/reject", webhooks.RejectDeployment)
+			protected.POST("/deployments/:id/redeploy", webhooks.RedeployDeployment)
+			protected.POST("/projects/:id/rollback/:deploymentID", webhooks.RollbackDeployment)
+			protected.DELETE("/deployments/:id", api.DeleteDeployment)
+			protected.GET("/usage/limits", api.GetUsageLimits)
+		}
+
+		// Admin endpoints
+		admin := apiGroup.Group("/admin")
+		admin.Use(auth.AuthMiddleware(), auth.AdminMiddleware())
+		{
+			admin.GET("/plans", api.GetPlans)
+			admin.POST("/plans", api.SavePlan)
+			admin.PUT("/users/:id/plan", api.SetUserPlan)
+			admin.GET("/users/:id/login-status", api.GetUserLoginStatus)
+			admin.DELETE("/users/:id/lockout", api.UnlockUser)
+			admin.GET("/usage/near-limits", api.GetUsersNearLimits)
+			admin.GET("/stats", api.GetAdminStats)
+			admin.GET("/deployments/export", api.ExportAllDeployments)
+			admin.GET("/incidents", api.GetIncidents)
+			admin.PUT("/incidents/:id", api.UpdateIncident)
+			admin.GET("/workers", api.GetWorkers)
+			admin.POST("/workers/drain", api.DrainWorkers)
+			admin.POST("/workers/resume", api.ResumeWorkers)
+			admin.POST("/workers/:id/restart", api.RestartWorker)
+			admin.GET("/dockerfile-templates", api.GetDockerfileTemplates)
+			admin.GET("/dockerfile-templates/:detector/preview", api.PreviewDockerfileTemplate)
+			admin.GET("/backups", api.GetBackups)
+			admin.POST("/backups", api.TriggerBackup)
+			admin.POST("/impersonate/:userID", api.Impersonate)
+			admin.GET("/impersonations", api.GetImpersonations)
+			admin.DELETE("/impersonations/:id", api.RevokeImpersonation)
+			admin.GET("/webhooks/dead", webhooks.GetDeadWebhookEvents)
+			admin.GET("/webhooks/dead/:id", webhooks.GetDeadWebhookEvent)
+			admin.POST("/webhooks/dead/retry", webhooks.RetryDeadWebhookEvents)
+			admin.POST("/webhooks/dead/:id/retry", webhooks.RetryDeadWebhookEvent)
+			admin.GET("/drift", api.GetDrift)
+			admin.POST("/drift/cleanup", api.CleanupDrift)
+			admin.GET("/costs", api.GetCosts)
+			admin.GET("/jobs", api.GetJobs)
+			admin.GET("/background-jobs", api.GetBackgroundJobs)
+			admin.POST("/background-jobs/:name/run", api.RunBackgroundJob)
+			admin.GET("/consistency", api.GetConsistency)
 		}
 	}
 
 	// Webhook with rate limiting
-	r.POST("/webhooks/github", func(c *gin.Context) {
-		// Simple rate limiting (in production, use a per-IP limiter map)
-		if !rateLimiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
-			c.Abort()
-			return
-		}
-		github.HandleWebhook(c)
-	})
+	r.POST("/webhooks/github", ratelimit.Middleware(rateLimiter, ratelimit.ByIP), webhooks.HandleWebhook)
+
+	// Deploy hook for Git servers without GitHub webhooks, authenticated by project token
+	r.POST("/webhooks/generic/:projectToken", ratelimit.Middleware(rateLimiter, ratelimit.ByParam("projectToken")), webhooks.HandleGenericWebhook)
+
+	r.GET("/metrics", metrics.Handler)
 
+	// Deploy status badges for READMEs, served only for projects that opted in
+	r.GET("/badge/:badge", api.GetBadge)
+
+	// Drained instances answer 503 so load balancers stop routing to them
 	r.GET("/health", func(c *gin.Context) {
+		if workerPool != nil && workerPool.Draining() {
+			status := workerPool.Status()
+			state := "draining"
+			if status.Drained {
+				state = "drained"
+			}
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": state, "in_flight": status.InFlight})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
-	// Graceful shutdown
-	defer func() {
-		if workerPool != nil {
-			workerPool.Stop()
-		}
-	}()
+	// SIGINT and SIGTERM shut the platform down gracefully
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	fmt.Println("🚀 Starting API server on :8080")
 	fmt.Println("📊 Dashboard: http://localhost:8080")
 	fmt.Println("🔐 Login: http://localhost:8080/login")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Failed to start server:", err)
+	servers := []*http.Server{{Addr: ":8080", Handler: r}}
+	servers[0].RegisterOnShutdown(api.CloseStreams)
+	// Placeholder page of hostnames no ready deployment serves, on its own
+	// listener so project hostnames never reach the platform's routes
+	if cfg.PlaceholderBackend != "" {
+		placeholder := gin.New()
+		placeholder.Use(gin.Recovery())
+		placeholder.NoRoute(api.ServePlaceholder)
+		log.Printf("🪧 Serving placeholder pages on %s", cfg.PlaceholderAddr)
+		servers = append(servers, &http.Server{Addr: cfg.PlaceholderAddr, Handler: placeholder})
+	}
+	for _, server := range servers {
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Failed to start server on %s: %v", server.Addr, err)
+			}
+		}()
+	}
+
+	<-stopped.Done()
+	stop()
+	log.Printf("🛑 Shutting down, giving requests and running builds %s to finish", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	// Workers stop taking jobs while requests finish, each has the whole
+	// timeout. Builds that don't finish in time, and queued ones, are handed
+	// back for the lost-deployments job to queue again.
+	var shutdowns sync.WaitGroup
+	if workerPool != nil {
+		shutdowns.Go(func() { workerPool.Shutdown(shutdownCtx) })
+	}
+	for _, server := range servers {
+		shutdowns.Go(func() {
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("⚠️  Server on %s did not shut down cleanly: %v", server.Addr, err)
+			}
+		})
+	}
+	shutdowns.Wait()
+}
+
+// registerThrottleMetrics exposes queue length and build/deploy slot occupancy on /metrics
+func registerThrottleMetrics(q queue.BuildQueue, semaphores ...*throttle.Semaphore) {
+	metrics.RegisterGauge("deploy_build_queue_size", "Deployments waiting for a build slot", func() []metrics.Sample {
+		if q == nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(q.Size())}}
+	})
+	slotGauge := func(value func(throttle.Stats) int64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, sem := range semaphores {
+				if sem == nil {
+					continue
+				}
+				stats := sem.Stats()
+				samples = append(samples, metrics.Sample{Labels: map[string]string{"semaphore": stats.Name}, Value: float64(value(stats))})
+			}
+			return samples
+		}
 	}
+	metrics.RegisterGauge("deploy_slots_capacity", "Slots available to concurrent jobs", slotGauge(func(s throttle.Stats) int64 { return s.Capacity }))
+	metrics.RegisterGauge("deploy_slots_in_use", "Slots held by running jobs", slotGauge(func(s throttle.Stats) int64 { return s.InUse }))
+	metrics.RegisterGauge("deploy_slots_waiting", "Jobs waiting for slots", slotGauge(func(s throttle.Stats) int64 { return s.Waiting }))
 }
@@ -0,0 +1,122 @@
+// Command fsck checks the platform database for rows breaking the
+// invariants spanning its tables (see internal/consistency): deployments of
+// deleted projects, active hostnames of deployments that are gone, builds
+// still building for finished deployments, and so on.
+//
+// It reads DATABASE_URL like the API server (SQLite when unset).
+//
+//	go run ./cmd/fsck                 # report violations, grouped by invariant
+//	go run ./cmd/fsck -json           # the report of GET /api/admin/consistency
+//	go run ./cmd/fsck -fix -dry-run   # what the fixes would change, rolled back
+//	go run ./cmd/fsck -fix            # apply them
+//
+// Only some invariants have a fix, one that can't lose data: deactivating a
+// hostname, failing a build or deployment nothing runs for. Each fix runs
+// in a transaction of its own. The exit status is 1 while violations remain.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/consistency"
+	"deploy-platform/internal/database"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	log.SetFlags(0)
+	fix := flag.Bool("fix", false, "apply the fixes of the invariants that have one")
+	dryRun := flag.Bool("dry-run", false, "with -fix, print what would change and roll it back")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+	if *dryRun && !*fix {
+		log.Fatal("❌ -dry-run goes with -fix")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+	cfg := config.Load()
+	if err := database.InitDB(cfg.DatabaseURL); err != nil {
+		log.Fatalf("❌ Failed to connect to database: %v", err)
+	}
+
+	if *fix {
+		changes, err := consistency.Fix(database.DB, *dryRun)
+		printChanges(changes, *dryRun)
+		if err != nil {
+			log.Fatalf("❌ Fix failed, its transaction was rolled back: %v", err)
+		}
+	}
+
+	report, err := consistency.Check(database.DB)
+	if err != nil {
+		log.Fatalf("❌ Check failed: %v", err)
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printReport(report)
+	}
+	if len(report.Violations) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *consistency.Report) {
+	if len(report.Violations) == 0 {
+		fmt.Printf("✅ %d invariants hold\n", report.Invariants)
+		return
+	}
+	for _, v := range report.Violations {
+		fixable := ""
+		if v.Fixable {
+			fixable = ", fixable"
+		}
+		fmt.Printf("❌ %s: %d %s%s\n   %s\n   ids: %s\n", v.Invariant, v.Count, v.Table, fixable, v.Description, formatIDs(v.IDs, v.Count))
+	}
+	fmt.Printf("%d of %d invariants broken\n", len(report.Violations), report.Invariants)
+}
+
+// printChanges prints the diff of the fixes
+func printChanges(changes []consistency.Change, dryRun bool) {
+	verb := "Fixed"
+	if dryRun {
+		verb = "Would fix"
+	}
+	if len(changes) == 0 {
+		fmt.Println("Nothing to fix")
+		return
+	}
+	for _, change := range changes {
+		fmt.Printf("%s %s: %s %d %s %s -> %s\n", verb, change.Invariant, change.Table, change.ID, change.Column, change.From, change.To)
+	}
+	if dryRun {
+		fmt.Printf("%d changes rolled back (dry run)\n\n", len(changes))
+	} else {
+		fmt.Printf("%d changes applied\n\n", len(changes))
+	}
+}
+
+// formatIDs lists ids, noting those left out
+func formatIDs(ids []uint, count int) string {
+	out := ""
+	for i, id := range ids {
+		if i > 0 {
+			out += ", "
+		}
+		out += fmt.Sprint(id)
+	}
+	if count > len(ids) {
+		out += fmt.Sprintf(" and %d more", count-len(ids))
+	}
+	return out
+}
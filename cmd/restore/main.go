@@ -0,0 +1,166 @@
+// Command restore restores the platform database from a backup written by
+// the backup service (POST /api/admin/backups or BACKUP_SCHEDULE).
+//
+// It reads the same configuration as the API server: BACKUP_DESTINATION and
+// BACKUP_S3_* to find backups, ENCRYPTION_KEY (or JWT_SECRET) to decrypt
+// them and DATABASE_URL to restore into (SQLite when unset). Stop the
+// platform before restoring.
+//
+//	go run ./cmd/restore -list                          # stored backups, oldest first
+//	go run ./cmd/restore -force                         # restore the latest backup
+//	go run ./cmd/restore -name backup-20261016T030000Z-schedule.enc -sha256 <checksum> -force
+//	go run ./cmd/restore -file ./downloaded.enc -force  # restore a backup copied elsewhere
+//
+// The checksum is the one GET /api/admin/backups lists. Postgres backups are
+// loaded in a single transaction, so a failed restore leaves the database
+// as it was.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"deploy-platform/internal/backup"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/storage"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	list := flag.Bool("list", false, "list stored backups and exit")
+	name := flag.String("name", "", "backup to restore (default: the latest)")
+	file := flag.String("file", "", "restore from this local file instead of the backup destination")
+	checksum := flag.String("sha256", "", "expected SHA-256 of the backup, as listed by the API")
+	sqlitePath := flag.String("sqlite-path", database.SQLitePath, "SQLite database to write when DATABASE_URL is unset")
+	force := flag.Bool("force", false, "replace the existing database")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+	cfg := config.Load()
+	ctx := context.Background()
+
+	var store storage.Storage
+	if *file == "" {
+		var err error
+		if store, err = backup.NewStorage(cfg); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+
+	if *list {
+		if store == nil {
+			log.Fatal("❌ -list reads the backup destination, drop -file")
+		}
+		objects, err := backup.List(ctx, store)
+		if err != nil {
+			log.Fatalf("❌ Failed to list backups: %v", err)
+		}
+		for _, object := range objects {
+			fmt.Printf("%s\t%d\t%s\n", object.Key, object.Size, object.ModTime.Format("2006-01-02 15:04:05"))
+		}
+		return
+	}
+
+	if err := secrets.Init(cfg); err != nil {
+		log.Fatalf("❌ Failed to initialize encryption: %v", err)
+	}
+	if cfg.DatabaseURL != "" && !*force {
+		log.Fatal("❌ Restoring replaces every table of the database at DATABASE_URL; run again with -force")
+	}
+
+	// Download first: a backup is verified whole before anything is replaced
+	local, err := fetch(ctx, store, *name, *file)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if local != *file {
+		defer os.Remove(local)
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer f.Close()
+
+	if *checksum != "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); sum != *checksum {
+			log.Fatalf("❌ Checksum mismatch: backup is %s, expected %s", sum, *checksum)
+		}
+		f.Seek(0, io.SeekStart)
+		log.Println("✅ Checksum verified")
+	}
+
+	plaintext, err := secrets.NewDecryptReader(f)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if cfg.DatabaseURL != "" {
+		// Migrating first creates tables added since the backup was taken
+		if err := database.InitDB(cfg.DatabaseURL); err != nil {
+			log.Fatalf("❌ Failed to connect to database: %v", err)
+		}
+	}
+	manifest, err := backup.Restore(ctx, plaintext, database.DB, *sqlitePath, *force)
+	if err != nil {
+		log.Fatalf("❌ Restore failed: %v", err)
+	}
+
+	log.Printf("✅ Restored %s backup taken %s", manifest.Driver, manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	if manifest.Driver == "sqlite" {
+		log.Printf("   Database written to %s", *sqlitePath)
+	}
+}
+
+// fetch returns a local path holding the backup: file itself, or name (the
+// latest backup when empty) downloaded from storage to a temporary file
+func fetch(ctx context.Context, store storage.Storage, name, file string) (string, error) {
+	if file != "" {
+		return file, nil
+	}
+	if name == "" {
+		objects, err := backup.List(ctx, store)
+		if err != nil {
+			return "", fmt.Errorf("failed to list backups: %w", err)
+		}
+		if len(objects) == 0 {
+			return "", fmt.Errorf("no backups found")
+		}
+		name = objects[len(objects)-1].Key
+	}
+	log.Printf("⬇️  Fetching backup %s", name)
+
+	r, err := store.Get(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch backup %s: %w", name, err)
+	}
+	defer r.Close()
+	tmp, err := os.CreateTemp("", "restore-*.enc")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to fetch backup %s: %w", name, err)
+	}
+	return tmp.Name(), nil
+}
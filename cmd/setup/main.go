@@ -0,0 +1,300 @@
+// Command setup configures a new instance of the platform: it generates the
+// secrets, checks the configuration the way the server does, checks the
+// database, Docker and the clusters can be reached, writes the .env (or a
+// Kubernetes Secret) and creates the first admin.
+//
+//	go run ./cmd/setup                           # asks for each setting
+//	go run ./cmd/setup -non-interactive \
+//	    -base-url https://deploy.example.com -base-domain apps.example.com \
+//	    -database-url postgres://deploy:...@db/deploy \
+//	    -github-client-id Iv1.abc -github-client-secret ... \
+//	    -admin-email ops@example.com             # admin on their first sign-in
+//	ADMIN_PASSWORD=... go run ./cmd/setup -non-interactive ... -admin-email ops@example.com
+//	go run ./cmd/setup -non-interactive ... -format secret -output - | kubectl apply -f -
+//
+// Settings default to the environment, so secrets already set are kept
+// rather than generated. Nothing is written unless the configuration is
+// valid and, without -skip-checks, every dependency answered.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/pkg/docker"
+
+	"golang.org/x/term"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const checkTimeout = 10 * time.Second
+
+// options are the command line flags
+type options struct {
+	nonInteractive bool
+	output         string // .env path, "-" = stdout
+	format         string // env or secret
+	secretName     string
+	namespace      string
+	force          bool
+	skipChecks     bool
+
+	adminEmail    string
+	adminUsername string
+}
+
+func main() {
+	log.SetFlags(0)
+	var opts options
+	settings := newSettings()
+	flag.BoolVar(&opts.nonInteractive, "non-interactive", false, "take every setting from flags and the environment, ask nothing")
+	flag.StringVar(&opts.output, "output", ".env", "file to write, - for stdout")
+	flag.StringVar(&opts.format, "format", "env", "env (a .env file) or secret (a Kubernetes Secret manifest)")
+	flag.StringVar(&opts.secretName, "secret-name", "deploy-platform", "name of the Kubernetes Secret, with -format secret")
+	flag.StringVar(&opts.namespace, "namespace", "", "namespace of the Kubernetes Secret, with -format secret")
+	flag.BoolVar(&opts.force, "force", false, "overwrite the output file if it exists")
+	flag.BoolVar(&opts.skipChecks, "skip-checks", false, "write the configuration even if the database, Docker or a cluster can't be reached")
+	flag.StringVar(&opts.adminEmail, "admin-email", "", "email of the first admin: created with ADMIN_PASSWORD when set, else made admin on first sign-in")
+	flag.StringVar(&opts.adminUsername, "admin-username", "", "username of the admin created with ADMIN_PASSWORD (default: the email's local part)")
+	for _, s := range settings {
+		if s.flag != "" {
+			flag.StringVar(&s.value, s.flag, s.value, s.help)
+		}
+	}
+	flag.Parse()
+
+	if opts.format != "env" && opts.format != "secret" {
+		log.Fatalf("❌ -format must be env or secret, got %q", opts.format)
+	}
+	if opts.output != "-" && !opts.force {
+		if _, err := os.Stat(opts.output); err == nil {
+			log.Fatalf("❌ %s exists; run again with -force to replace it", opts.output)
+		}
+	}
+
+	in := &prompter{in: bufio.NewReader(os.Stdin), interactive: !opts.nonInteractive}
+	for _, s := range settings {
+		if s.flag != "" {
+			s.value = in.ask(s, s.value)
+		}
+	}
+	adminPassword := os.Getenv("ADMIN_PASSWORD")
+	if in.interactive {
+		opts.adminEmail = in.ask(&setting{help: "Email of the first admin (empty = none)"}, opts.adminEmail)
+		if opts.adminEmail != "" && in.confirm("Create them with a password now? Otherwise they become admin on their first sign-in", adminPassword != "") {
+			adminPassword = in.askPassword("Admin password")
+		}
+	}
+	if opts.adminEmail != "" && adminPassword == "" {
+		settings.get("ADMIN_EMAILS").add(opts.adminEmail)
+	}
+	settings.complete()
+
+	// The server's own validation decides what "configured" means
+	cfg := settings.apply()
+	validation := cfg.Validate()
+	for _, warning := range validation.Warnings {
+		log.Printf("⚠️  %s", warning)
+	}
+	if err := validation.Err(); err != nil {
+		log.Fatalf("❌ Invalid configuration, %v", err)
+	}
+
+	// gorm logs the migrations' SQL to stdout: send it to stderr with the
+	// rest, so -output - prints nothing but the configuration
+	logger.Default = logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  logger.Warn,
+		IgnoreRecordNotFoundError: true,
+	})
+	if err := database.InitDB(cfg.DatabaseURL); err != nil {
+		if !opts.skipChecks || adminPassword != "" {
+			log.Fatalf("❌ Database unreachable: %v", err)
+		}
+		log.Printf("⚠️  Database unreachable: %v", err)
+	} else if failed := runChecks(cfg); failed > 0 && !opts.skipChecks {
+		log.Fatalf("❌ %d dependencies unreachable; fix them or run again with -skip-checks", failed)
+	}
+
+	if err := writeOutput(settings, opts); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if opts.adminEmail != "" {
+		if adminPassword == "" {
+			log.Printf("👤 %s becomes admin on their first sign-in (ADMIN_EMAILS)", opts.adminEmail)
+		} else if err := createAdmin(opts.adminEmail, opts.adminUsername, adminPassword); err != nil {
+			log.Fatalf("❌ Failed to create the admin: %v", err)
+		}
+	}
+	printGitHubSetup(cfg)
+}
+
+// runChecks probes the database, Docker, the clusters and the base image
+// registry with the server's health checks, returning how many failed.
+// database.DB must be open.
+func runChecks(cfg *config.Config) int {
+	failed := 0
+	dockerClient, err := docker.NewClient()
+	if err != nil {
+		log.Printf("❌ docker: %v", err)
+		failed++
+	}
+	clusters := kubernetes.NewClientSet(cfg)
+	if clusters == nil {
+		log.Printf("❌ kubernetes: no cluster could be configured, see KUBECONFIG or KUBERNETES_CLUSTERS")
+		failed++
+	}
+	for _, check := range incidents.Checks(cfg, dockerClient, clusters) {
+		name := check.Component
+		if check.Target != "" {
+			name += " " + check.Target
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		err := check.Probe(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("❌ %s: %v", name, err)
+			failed++
+			continue
+		}
+		log.Printf("✅ %s reachable", name)
+	}
+	return failed
+}
+
+// createAdmin creates the admin with a password, or makes the existing user
+// of email an admin with that password
+func createAdmin(email, username, password string) error {
+	if err := auth.ValidatePassword(password); err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		username, _, _ = strings.Cut(email, "@")
+	}
+	var user models.User
+	err = database.DB.Where("email = ?", email).First(&user).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user = models.User{Username: username, Email: email}
+	case err != nil:
+		return err
+	}
+	user.PasswordHash = hash
+	user.IsAdmin = true
+	if err := database.DB.Save(&user).Error; err != nil {
+		return err
+	}
+	log.Printf("👤 Admin %s (%s) can sign in with their password", user.Username, user.Email)
+	return nil
+}
+
+// printGitHubSetup prints what to enter on GitHub for sign-in and webhooks
+func printGitHubSetup(cfg *config.Config) {
+	base := strings.TrimSuffix(cfg.BaseURL, "/")
+	log.Printf("\nRegister the GitHub OAuth app at https://github.com/settings/developers:")
+	log.Printf("  Homepage URL:                %s", base)
+	log.Printf("  Authorization callback URL:  %s", cfg.GitHubCallbackURL)
+	log.Printf("\nAdd a webhook to the repositories to deploy (or to their organization):")
+	log.Printf("  Payload URL:   %s/webhooks/github", base)
+	log.Printf("  Content type:  application/json")
+	log.Printf("  Secret:        WEBHOOK_SECRET from the configuration")
+	log.Printf("  Events:        push and pull requests")
+}
+
+// randomSecret returns 32 random bytes, hex encoded, like `openssl rand -hex 32`
+func randomSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("❌ Failed to generate a secret: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// prompter asks for settings on the terminal, or takes them as they are
+// when not interactive
+type prompter struct {
+	in          *bufio.Reader
+	interactive bool
+}
+
+// ask asks for setting s, def being kept when the answer is empty. Secrets
+// aren't echoed.
+func (p *prompter) ask(s *setting, def string) string {
+	if !p.interactive {
+		return def
+	}
+	if s.secret {
+		if def != "" {
+			if answer := p.askPassword(s.help + " (empty = keep the current one)"); answer != "" {
+				return answer
+			}
+			return def
+		}
+		return p.askPassword(s.help)
+	}
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", s.help, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", s.help)
+	}
+	answer, err := p.in.ReadString('\n')
+	if err != nil && answer == "" {
+		log.Fatalf("\n❌ No answer: %v", err)
+	}
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// askPassword asks for a secret without echoing it when stdin is a terminal
+func (p *prompter) askPassword(label string) string {
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		answer, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatalf("❌ No answer: %v", err)
+		}
+		return strings.TrimSpace(string(answer))
+	}
+	answer, _ := p.in.ReadString('\n')
+	return strings.TrimSpace(answer)
+}
+
+// confirm asks a yes/no question
+func (p *prompter) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	fmt.Fprintf(os.Stderr, "%s [%s]: ", question, hint)
+	answer, _ := p.in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"sigs.k8s.io/yaml"
+)
+
+// The tests run the command as a child process of the test binary, which
+// runs main instead of the tests when SETUP_TEST_MAIN is set
+func TestMain(m *testing.M) {
+	if os.Getenv("SETUP_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeDependencies serves a Docker daemon and a Kubernetes API server
+// answering the health checks, returning the environment pointing at them
+func fakeDependencies(t *testing.T) []string {
+	t.Helper()
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("API-Version", "1.43")
+		w.Write([]byte("OK"))
+	}))
+	t.Cleanup(daemon.Close)
+	cluster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/pods") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind": "PodList", "apiVersion": "v1", "metadata": {}, "items": []}`))
+	}))
+	t.Cleanup(cluster.Close)
+
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: fake
+  cluster:
+    server: %s
+users:
+- name: fake
+  user:
+    token: fake-token
+contexts:
+- name: fake
+  context:
+    cluster: fake
+    user: fake
+current-context: fake
+`, cluster.URL)), 0600)
+	return []string{"DOCKER_HOST=tcp://" + strings.TrimPrefix(daemon.URL, "http://"), "KUBECONFIG=" + kubeconfig}
+}
+
+// setup runs the command with args in the test's directory, env adding to
+// an environment without any setting, returning its stdout and stderr
+func setup(t *testing.T, env []string, args ...string) (string, string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "SETUP_TEST_MAIN=1")
+	for _, s := range newSettings() {
+		cmd.Env = append(cmd.Env, s.key+"=")
+	}
+	cmd.Env = append(cmd.Env, "ADMIN_PASSWORD=", "BASE_IMAGE_REGISTRY=", "KUBERNETES_CLUSTERS=")
+	cmd.Env = append(cmd.Env, env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+var production = []string{
+	"-non-interactive",
+	"-base-url", "https://deploy.example.com/",
+	"-base-domain", "apps.example.com",
+	"-github-client-id", "Iv1.abc",
+	"-github-client-secret", "gh secret#1",
+}
+
+var hexSecret = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Without a question asked, setup checks the dependencies, writes a .env
+// the server loads as configured, and prints what to register on GitHub
+func TestNonInteractive(t *testing.T) {
+	testutil.DB(t)
+	env := fakeDependencies(t)
+	_, logged, err := setup(t, env, append(production, "-admin-email", "ops@example.com")...)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, logged)
+	}
+	for _, want := range []string{
+		"✅ database reachable",
+		"✅ docker ",
+		"✅ kubernetes default reachable",
+		"✅ Wrote .env",
+		"👤 ops@example.com becomes admin on their first sign-in (ADMIN_EMAILS)",
+		"Authorization callback URL:  https://deploy.example.com/auth/github/callback",
+		"Payload URL:   https://deploy.example.com/webhooks/github",
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("didn't log %q:\n%s", want, logged)
+		}
+	}
+
+	info, err := os.Stat(".env")
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("%v, %v", info, err)
+	}
+	written, err := godotenv.Read(".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"BASE_URL":             "https://deploy.example.com/",
+		"BASE_DOMAIN":          "apps.example.com",
+		"GITHUB_CLIENT_SECRET": "gh secret#1",
+		"GITHUB_CALLBACK_URL":  "https://deploy.example.com/auth/github/callback",
+		"ADMIN_EMAILS":         "ops@example.com",
+		"DATABASE_URL":         "",
+	} {
+		if written[key] != want {
+			t.Errorf("%s=%q, want %q", key, written[key], want)
+		}
+	}
+	if !hexSecret.MatchString(written["JWT_SECRET"]) || !hexSecret.MatchString(written["ENCRYPTION_KEY"]) || written["JWT_SECRET"] == written["WEBHOOK_SECRET"] {
+		t.Errorf("generated secrets %q, %q, %q", written["JWT_SECRET"], written["WEBHOOK_SECRET"], written["ENCRYPTION_KEY"])
+	}
+	var admins int64
+	database.DB.Model(&models.User{}).Count(&admins)
+	if admins != 0 {
+		t.Errorf("%d users created without a password", admins)
+	}
+
+	// Run again: the .env is kept unless forced, and secrets already set
+	// are kept rather than generated
+	if _, logged, err := setup(t, env, production...); err == nil || !strings.Contains(logged, ".env exists; run again with -force to replace it") {
+		t.Errorf("overwrote the .env: %v\n%s", err, logged)
+	}
+	env = append(env, "JWT_SECRET="+written["JWT_SECRET"], "ADMIN_EMAILS=founder@example.com")
+	if _, logged, err := setup(t, env, append(production, "-force", "-admin-email", "ops@example.com")...); err != nil {
+		t.Fatalf("%v:\n%s", err, logged)
+	}
+	rewritten, _ := godotenv.Read(".env")
+	if rewritten["JWT_SECRET"] != written["JWT_SECRET"] || rewritten["ENCRYPTION_KEY"] == written["ENCRYPTION_KEY"] || rewritten["ADMIN_EMAILS"] != "founder@example.com,ops@example.com" {
+		t.Errorf("rewritten %v", rewritten)
+	}
+}
+
+// With ADMIN_PASSWORD the first admin is created in the database, and is
+// not added to ADMIN_EMAILS
+func TestNonInteractiveAdminPassword(t *testing.T) {
+	testutil.DB(t)
+	env := append(fakeDependencies(t), "ADMIN_PASSWORD=correct horse battery staple")
+	_, logged, err := setup(t, env, append(production, "-admin-email", "ops@example.com", "-admin-username", "ops")...)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, logged)
+	}
+	var user models.User
+	if err := database.DB.Where("email = ?", "ops@example.com").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "ops" || !user.IsAdmin || !auth.CheckPasswordHash("correct horse battery staple", user.PasswordHash) {
+		t.Errorf("admin %+v", user)
+	}
+	if written, _ := godotenv.Read(".env"); written["ADMIN_EMAILS"] != "" {
+		t.Errorf("ADMIN_EMAILS=%q", written["ADMIN_EMAILS"])
+	}
+
+	// A password the server would refuse is refused here too
+	env = append(env, "ADMIN_PASSWORD=short")
+	if _, logged, err := setup(t, env, append(production, "-force", "-admin-email", "dev@example.com")...); err == nil || !strings.Contains(logged, "❌ Failed to create the admin") {
+		t.Errorf("created an admin with a weak password: %v\n%s", err, logged)
+	}
+}
+
+// -format secret prints a Kubernetes Secret of the same settings
+func TestNonInteractiveSecret(t *testing.T) {
+	testutil.DB(t)
+	manifest, logged, err := setup(t, fakeDependencies(t), append(production, "-format", "secret", "-output", "-", "-namespace", "platform")...)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, logged)
+	}
+	var secret struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		StringData map[string]string `json:"stringData"`
+	}
+	if err := yaml.Unmarshal([]byte(manifest), &secret); err != nil {
+		t.Fatalf("%v:\n%s", err, manifest)
+	}
+	if secret.Kind != "Secret" || secret.Metadata.Name != "deploy-platform" || secret.Metadata.Namespace != "platform" {
+		t.Errorf("secret %+v", secret)
+	}
+	if secret.StringData["GITHUB_CLIENT_SECRET"] != "gh secret#1" || !hexSecret.MatchString(secret.StringData["WEBHOOK_SECRET"]) {
+		t.Errorf("data %v", secret.StringData)
+	}
+	if _, ok := secret.StringData["DATABASE_URL"]; ok {
+		t.Errorf("unset settings in the secret: %v", secret.StringData)
+	}
+	if _, err := os.Stat(".env"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wrote a .env: %v", err)
+	}
+}
+
+// Nothing is written when the configuration is invalid or a dependency
+// doesn't answer, unless the checks are skipped
+func TestNonInteractiveFailures(t *testing.T) {
+	testutil.DB(t)
+	env := fakeDependencies(t)
+	tests := []struct {
+		name string
+		env  []string
+		args []string
+		want string
+	}{
+		{"invalid", nil, []string{"-database-url", "mysql://db/deploy"}, "❌ Invalid configuration"},
+		{"unreachable", []string{"BASE_IMAGE_REGISTRY=127.0.0.1:1/library"}, nil, "❌ 1 dependencies unreachable; fix them or run again with -skip-checks"},
+		{"format", nil, []string{"-format", "json"}, `❌ -format must be env or secret, got "json"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, logged, err := setup(t, append(env, tt.env...), append(production, tt.args...)...)
+			if err == nil || !strings.Contains(logged, tt.want) {
+				t.Errorf("%v:\n%s", err, logged)
+			}
+			if _, err := os.Stat(".env"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("wrote a .env: %v", err)
+			}
+		})
+	}
+
+	unreachable := append(env, "BASE_IMAGE_REGISTRY=127.0.0.1:1/library")
+	if _, logged, err := setup(t, unreachable, append(production, "-skip-checks")...); err != nil || !strings.Contains(logged, "❌ registry 127.0.0.1:1") {
+		t.Errorf("-skip-checks: %v\n%s", err, logged)
+	}
+	if _, err := os.Stat(".env"); err != nil {
+		t.Error(err)
+	}
+}
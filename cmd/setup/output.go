@@ -0,0 +1,154 @@
+package main
+
+import (
+	"deploy-platform/internal/config"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// setting is one variable of the configuration written
+type setting struct {
+	key      string
+	flag     string // Flag and question setting it, "" = generated or derived
+	help     string
+	section  string // Comment of the section it starts in the .env
+	secret   bool   // Not echoed when asked
+	generate bool   // A random secret when empty
+	value    string
+}
+
+type settings []*setting
+
+// newSettings lists the settings written, defaulting to the environment
+func newSettings() settings {
+	list := settings{
+		{key: "BASE_URL", flag: "base-url", help: "URL users reach the platform at", section: "Where the platform is served: its own URL and the domain of deployment hostnames"},
+		{key: "BASE_DOMAIN", flag: "base-domain", help: "Domain deployments get hostnames under (localhost = local development)"},
+		{key: "GITHUB_CLIENT_ID", flag: "github-client-id", help: "Client ID of the GitHub OAuth app", section: "GitHub OAuth app users sign in with"},
+		{key: "GITHUB_CLIENT_SECRET", flag: "github-client-secret", help: "Client secret of the GitHub OAuth app", secret: true},
+		{key: "GITHUB_CALLBACK_URL"},
+		{key: "JWT_SECRET", generate: true, section: "Generated secrets: keep them, changing ENCRYPTION_KEY makes stored credentials unreadable"},
+		{key: "WEBHOOK_SECRET", generate: true},
+		{key: "ENCRYPTION_KEY", generate: true},
+		{key: "DATABASE_URL", flag: "database-url", help: "PostgreSQL URL (empty = SQLite, for development)", section: "Storage"},
+		{key: "REDIS_URL", flag: "redis-url", help: "Redis URL for the build queue (empty = in-process queue)"},
+		{key: "KUBECONFIG", flag: "kubeconfig", help: "Kubeconfig of the cluster to deploy to (empty = in-cluster config)", section: "Kubernetes"},
+		{key: "ADMIN_EMAILS", section: "Users made admin on their first sign-in"},
+	}
+	defaults := map[string]string{"BASE_URL": "http://localhost:8080", "BASE_DOMAIN": "localhost"}
+	for _, s := range list {
+		s.value = os.Getenv(s.key)
+		if s.value == "" {
+			s.value = defaults[s.key]
+		}
+	}
+	return list
+}
+
+func (list settings) get(key string) *setting {
+	for _, s := range list {
+		if s.key == key {
+			return s
+		}
+	}
+	return nil
+}
+
+// add adds an item to a comma-separated setting, once
+func (s *setting) add(item string) {
+	var items []string
+	for _, existing := range strings.Split(s.value, ",") {
+		if existing = strings.TrimSpace(existing); existing != "" {
+			items = append(items, existing)
+		}
+	}
+	if !slices.Contains(items, item) {
+		items = append(items, item)
+	}
+	s.value = strings.Join(items, ",")
+}
+
+// complete generates the missing secrets and derives the callback URL
+func (list settings) complete() {
+	for _, s := range list {
+		if s.generate && s.value == "" {
+			s.value = randomSecret()
+		}
+	}
+	if callback := list.get("GITHUB_CALLBACK_URL"); callback.value == "" {
+		callback.value = strings.TrimSuffix(list.get("BASE_URL").value, "/") + "/auth/github/callback"
+	}
+}
+
+// apply sets the settings in the environment and loads the configuration
+// from it, as the server would from the .env written
+func (list settings) apply() *config.Config {
+	for _, s := range list {
+		if s.value == "" {
+			os.Unsetenv(s.key)
+		} else {
+			os.Setenv(s.key, s.value)
+		}
+	}
+	return config.Load()
+}
+
+// writeOutput writes the settings as a .env or a Kubernetes Secret
+func writeOutput(list settings, opts options) error {
+	var content string
+	if opts.format == "secret" {
+		content = list.secretManifest(opts.secretName, opts.namespace)
+	} else {
+		content = list.envFile()
+	}
+	if opts.output == "-" {
+		_, err := os.Stdout.WriteString(content)
+		return err
+	}
+	if err := os.WriteFile(opts.output, []byte(content), 0o600); err != nil {
+		return err
+	}
+	log.Printf("✅ Wrote %s", opts.output)
+	return nil
+}
+
+// envFile renders the settings as a .env file, godotenv syntax
+func (list settings) envFile() string {
+	var b strings.Builder
+	b.WriteString("# Written by cmd/setup. Every other setting keeps its default, see .env.example.\n")
+	for _, s := range list {
+		if s.section != "" {
+			fmt.Fprintf(&b, "\n# %s\n", s.section)
+		}
+		value := s.value
+		if strings.ContainsAny(value, " \t#\"'\\$\n") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", s.key, value)
+	}
+	return b.String()
+}
+
+// secretManifest renders the settings as a Kubernetes Secret, to be loaded
+// into the platform's pods with envFrom
+func (list settings) secretManifest(name, namespace string) string {
+	var b strings.Builder
+	b.WriteString("# Written by cmd/setup: load it into the platform's pods with envFrom\n")
+	b.WriteString("apiVersion: v1\nkind: Secret\nmetadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", name)
+	if namespace != "" {
+		fmt.Fprintf(&b, "  namespace: %s\n", namespace)
+	}
+	b.WriteString("type: Opaque\nstringData:\n")
+	for _, s := range list {
+		if s.value != "" {
+			// A JSON string is a valid YAML double-quoted scalar
+			fmt.Fprintf(&b, "  %s: %s\n", s.key, strconv.Quote(s.value))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,246 @@
+// Package activations records when each deployment served its environment,
+// to answer what was live at a given time. An activation starts when a
+// rollout goes live and ends when a later deployment of the same resources
+// replaces it, which covers promotions and rollbacks (a redeploy of an older
+// commit), or when the resources are removed. Failed rollouts never start
+// one: what was live before stays live.
+package activations
+
+import (
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Record starts the activation of deployment, live since at, ending the one
+// of the deployment it replaces
+func Record(db *gorm.DB, deployment *models.Deployment, imageDigest string, at time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.DeploymentActivation{}).
+			Where("project_id = ? AND resource = ? AND deactivated_at IS NULL AND deployment_id <> ?", deployment.ProjectID, deployment.K8sDeploymentName, deployment.ID).
+			Updates(map[string]interface{}{"deactivated_at": at, "replaced_by_id": deployment.ID, "deactivate_reason": models.DeactivateReplaced}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.DeploymentActivation{
+			ProjectID:    deployment.ProjectID,
+			DeploymentID: deployment.ID,
+			Environment:  kubernetes.Environment(deployment),
+			Resource:     deployment.K8sDeploymentName,
+			Cluster:      deployment.Cluster,
+			Branch:       deployment.Branch,
+			CommitSHA:    deployment.CommitSHA,
+			ImageTag:     deployment.ImageTag,
+			ImageDigest:  imageDigest,
+			Hostname:     deployment.Hostname,
+			Actor:        deployment.Actor,
+			ActivatedAt:  at,
+		}).Error
+	})
+}
+
+// End ends the live activations of the project's resource, or of all its
+// resources when resource is "", for reason (models.Deactivate*)
+func End(db *gorm.DB, projectID uint, resource, reason string) error {
+	query := db.Model(&models.DeploymentActivation{}).Where("project_id = ? AND deactivated_at IS NULL", projectID)
+	if resource != "" {
+		query = query.Where("resource = ?", resource)
+	}
+	return query.Updates(map[string]interface{}{"deactivated_at": time.Now(), "deactivate_reason": reason}).Error
+}
+
+// Backfill reconstructs the activations of the deployments that went live
+// before they were recorded, once, while the table is empty. Each
+// deployment that reached deployed is live from then (its last update
+// without status history) until the next one of the same resources.
+// Projects archived since end their last activation at archiving; the
+// other last ones are taken to be still live.
+func Backfill(db *gorm.DB) (int, error) {
+	var existing int64
+	if err := db.Model(&models.DeploymentActivation{}).Count(&existing).Error; err != nil || existing > 0 {
+		return 0, err
+	}
+
+	var deployments []models.Deployment
+	err := db.Preload("Project", func(db *gorm.DB) *gorm.DB { return db.Select("id", "archived_at") }).
+		Where("k8s_deployment_name <> ''").
+		Where("status = ? OR id IN (?)", models.StatusDeployed,
+			db.Model(&models.DeploymentEvent{}).Select("deployment_id").Where("to_status = ?", models.StatusDeployed)).
+		Order("id").Find(&deployments).Error
+	if err != nil || len(deployments) == 0 {
+		return 0, err
+	}
+
+	liveAt := map[uint]time.Time{}
+	var events []models.DeploymentEvent
+	if err := db.Where("to_status = ?", models.StatusDeployed).Order("id").Find(&events).Error; err != nil {
+		return 0, err
+	}
+	for _, event := range events {
+		if _, seen := liveAt[event.DeploymentID]; !seen {
+			liveAt[event.DeploymentID] = event.CreatedAt
+		}
+	}
+	digests := map[uint]string{}
+	var builds []models.Build
+	if err := db.Select("deployment_id", "image_digest").Where("image_digest <> ''").Order("id").Find(&builds).Error; err != nil {
+		return 0, err
+	}
+	for _, build := range builds {
+		digests[build.DeploymentID] = build.ImageDigest
+	}
+
+	type key struct {
+		project  uint
+		resource string
+	}
+	groups := map[key][]models.DeploymentActivation{}
+	archivedAt := map[uint]*time.Time{}
+	for i := range deployments {
+		d := &deployments[i]
+		at, ok := liveAt[d.ID]
+		if !ok {
+			at = d.UpdatedAt
+		}
+		k := key{d.ProjectID, d.K8sDeploymentName}
+		groups[k] = append(groups[k], models.DeploymentActivation{
+			ProjectID:    d.ProjectID,
+			DeploymentID: d.ID,
+			Environment:  kubernetes.Environment(d),
+			Resource:     d.K8sDeploymentName,
+			Cluster:      d.Cluster,
+			Branch:       d.Branch,
+			CommitSHA:    d.CommitSHA,
+			ImageTag:     d.ImageTag,
+			ImageDigest:  digests[d.ID],
+			Hostname:     d.Hostname,
+			Actor:        d.Actor,
+			ActivatedAt:  at,
+			Backfilled:   true,
+		})
+		archivedAt[d.ProjectID] = d.Project.ArchivedAt
+	}
+
+	var activations []models.DeploymentActivation
+	for k, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool { return group[i].ActivatedAt.Before(group[j].ActivatedAt) })
+		for i := range group {
+			if i+1 < len(group) {
+				next := group[i+1]
+				group[i].DeactivatedAt = &next.ActivatedAt
+				group[i].ReplacedByID = &next.DeploymentID
+				group[i].DeactivateReason = models.DeactivateReplaced
+			} else if archived := archivedAt[k.project]; archived != nil && archived.After(group[i].ActivatedAt) {
+				group[i].DeactivatedAt = archived
+				group[i].DeactivateReason = models.DeactivateArchived
+			}
+		}
+		activations = append(activations, group...)
+	}
+	if err := db.CreateInBatches(activations, 500).Error; err != nil {
+		return 0, err
+	}
+	log.Printf("🕰️  Backfilled %d deployment activations from the status history", len(activations))
+	return len(activations), nil
+}
+
+// Change is an activation starting or ending
+type Change struct {
+	At         time.Time                    `json:"at"`
+	Kind       string                       `json:"kind"` // ChangeActivated or ChangeDeactivated
+	Activation *models.DeploymentActivation `json:"activation"`
+}
+
+// Change kinds. An activation replaced by another ends with the other's
+// start, which is the change reported.
+const (
+	ChangeActivated   = "activated"
+	ChangeDeactivated = "deactivated"
+)
+
+// Timeline is what was live in a project at a time, and the changes around it
+type Timeline struct {
+	At       time.Time                     `json:"at"`
+	Live     []models.DeploymentActivation `json:"live"`     // One per live environment and resource
+	Previous *Change                       `json:"previous"` // Last change at or before At, nil before the first deployment
+	Next     *Change                       `json:"next"`     // First change after At, nil if none since
+}
+
+// LiveAt reconstructs what served the project at time at, in environment
+// ("" = all of them)
+func LiveAt(db *gorm.DB, projectID uint, at time.Time, environment string) (*Timeline, error) {
+	scope := func() *gorm.DB {
+		query := db.Model(&models.DeploymentActivation{}).Where("project_id = ?", projectID)
+		if environment != "" {
+			query = query.Where("environment = ?", environment)
+		}
+		return query
+	}
+
+	timeline := &Timeline{At: at, Live: []models.DeploymentActivation{}}
+	err := scope().Where("activated_at <= ? AND (deactivated_at IS NULL OR deactivated_at > ?)", at, at).
+		Order("environment, resource, activated_at DESC").Find(&timeline.Live).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Ends that are another's start are left to the start
+	var previousStart, previousEnd, nextStart, nextEnd models.DeploymentActivation
+	if err := first(scope().Where("activated_at <= ?", at).Order("activated_at DESC, id DESC"), &previousStart); err != nil {
+		return nil, err
+	}
+	if err := first(scope().Where("deactivated_at <= ? AND replaced_by_id IS NULL", at).Order("deactivated_at DESC, id DESC"), &previousEnd); err != nil {
+		return nil, err
+	}
+	if err := first(scope().Where("activated_at > ?", at).Order("activated_at, id"), &nextStart); err != nil {
+		return nil, err
+	}
+	if err := first(scope().Where("deactivated_at > ? AND replaced_by_id IS NULL", at).Order("deactivated_at, id"), &nextEnd); err != nil {
+		return nil, err
+	}
+	timeline.Previous = latest(started(&previousStart), ended(&previousEnd))
+	timeline.Next = earliest(started(&nextStart), ended(&nextEnd))
+	return timeline, nil
+}
+
+// first reads the query's first row into activation, left zero without one
+func first(query *gorm.DB, activation *models.DeploymentActivation) error {
+	err := query.First(activation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+func started(a *models.DeploymentActivation) *Change {
+	if a.ID == 0 {
+		return nil
+	}
+	return &Change{At: a.ActivatedAt, Kind: ChangeActivated, Activation: a}
+}
+
+func ended(a *models.DeploymentActivation) *Change {
+	if a.ID == 0 {
+		return nil
+	}
+	return &Change{At: *a.DeactivatedAt, Kind: ChangeDeactivated, Activation: a}
+}
+
+func latest(a, b *Change) *Change {
+	if a == nil || (b != nil && b.At.After(a.At)) {
+		return b
+	}
+	return a
+}
+
+func earliest(a, b *Change) *Change {
+	if a == nil || (b != nil && b.At.Before(a.At)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,188 @@
+package activations
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeline records the history of project 1, from base: production went
+// live with a then b, c's rollout failed and d rolled back to a's commit;
+// branch feature/login went live with e until its branch was deleted; a
+// preview went live with f
+func timeline(t *testing.T, base time.Time) {
+	t.Helper()
+	testutil.DB(t)
+	deployment := func(id uint, sha string, target, resource, branch string) *models.Deployment {
+		return &models.Deployment{
+			ID: id, ProjectID: 1, CommitSHA: strings.Repeat(sha, 40), Target: target, K8sDeploymentName: resource,
+			Branch: branch, ImageTag: "app:" + sha, Hostname: resource + ".deploy.example.com", Actor: "ada",
+		}
+	}
+	record := func(d *models.Deployment, at time.Duration) {
+		t.Helper()
+		if err := Record(database.DB, d, "sha256:"+strings.Repeat(d.CommitSHA[:1], 64), base.Add(at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(deployment(1, "a", models.TargetProduction, "project-1", "main"), 0)
+	record(deployment(2, "b", models.TargetProduction, "project-1", "main"), time.Hour)
+	record(deployment(5, "e", models.TargetProduction, "project-1-feature-login", "feature/login"), 90*time.Minute)
+	// c (3) failed at 2h: no activation
+	record(deployment(6, "f", models.TargetPreview, "preview-1-6", "feature/login"), 150*time.Minute)
+	record(deployment(4, "a", models.TargetProduction, "project-1", "main"), 3*time.Hour)
+	if err := End(database.DB, 1, "project-1-feature-login", models.DeactivateBranch); err != nil {
+		t.Fatal(err)
+	}
+	// Another project's history is never part of project 1's
+	other := deployment(9, "9", models.TargetProduction, "project-2", "main")
+	other.ProjectID = 2
+	record(other, 150*time.Minute)
+}
+
+// live is the deployment IDs live in a timeline, by environment
+func live(timeline *Timeline) []uint {
+	var ids []uint
+	for _, a := range timeline.Live {
+		ids = append(ids, a.DeploymentID)
+	}
+	return ids
+}
+
+// change describes a change as its kind and deployment
+func change(c *Change) string {
+	if c == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s %d", c.Kind, c.Activation.DeploymentID)
+}
+
+func TestLiveAt(t *testing.T) {
+	// The branch is deleted now, after the rest of the timeline
+	base := time.Now().Add(-24 * time.Hour).Truncate(time.Hour)
+	timeline(t, base)
+	later := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name        string
+		at          time.Time
+		environment string
+		live        []uint
+		previous    string
+		next        string
+	}{
+		{name: "before the first deploy", at: base.Add(-time.Minute), previous: "none", next: "activated 1"},
+		{name: "at the first deploy", at: base, live: []uint{1}, previous: "activated 1", next: "activated 2"},
+		{name: "promotion", at: base.Add(80 * time.Minute), live: []uint{2}, previous: "activated 2", next: "activated 5"},
+		{name: "failed rollout", at: base.Add(2*time.Hour + time.Minute), environment: "production", live: []uint{2}, previous: "activated 2", next: "activated 4"},
+		{name: "every environment", at: base.Add(160 * time.Minute), live: []uint{5, 6, 2}, previous: "activated 6", next: "activated 4"},
+		{name: "rollback", at: base.Add(4 * time.Hour), live: []uint{5, 6, 4}, previous: "activated 4", next: "deactivated 5"},
+		{name: "preview", at: base.Add(4 * time.Hour), environment: "preview", live: []uint{6}, previous: "activated 6", next: "none"},
+		{name: "branch deleted", at: later, environment: "branch", previous: "deactivated 5", next: "none"},
+		{name: "now", at: later, live: []uint{6, 4}, previous: "deactivated 5", next: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeline, err := LiveAt(database.DB, 1, tt.at, tt.environment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := live(timeline); !slices.Equal(got, tt.live) {
+				t.Errorf("live %v, want %v", got, tt.live)
+			}
+			if got := change(timeline.Previous); got != tt.previous {
+				t.Errorf("previous %s, want %s", got, tt.previous)
+			}
+			if got := change(timeline.Next); got != tt.next {
+				t.Errorf("next %s, want %s", got, tt.next)
+			}
+		})
+	}
+
+	// What was live is what the deployment was
+	timeline, _ := LiveAt(database.DB, 1, base.Add(4*time.Hour), "production")
+	a := timeline.Live[0]
+	if a.CommitSHA != strings.Repeat("a", 40) || a.ImageDigest != "sha256:"+strings.Repeat("a", 64) || a.Hostname != "project-1.deploy.example.com" ||
+		a.Environment != "production" || a.Branch != "main" || a.Actor != "ada" {
+		t.Errorf("live %+v", a)
+	}
+	var replaced models.DeploymentActivation
+	database.DB.Where("deployment_id = ?", 2).First(&replaced)
+	if replaced.DeactivatedAt == nil || !replaced.DeactivatedAt.Equal(base.Add(3*time.Hour)) || replaced.ReplacedByID == nil || *replaced.ReplacedByID != 4 ||
+		replaced.DeactivateReason != models.DeactivateReplaced {
+		t.Errorf("replaced %+v", replaced)
+	}
+}
+
+// Backfilled activations chain the deployments that went live, from their
+// deployed status events, once
+func TestBackfill(t *testing.T) {
+	testutil.DB(t)
+	base := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	archived := base.Add(5 * time.Hour)
+	database.DB.Create(&models.Project{ID: 1, Name: "app", Slug: "app"})
+	database.DB.Create(&models.Project{ID: 2, Name: "old", Slug: "old", ArchivedAt: &archived})
+
+	deployed := func(id, project uint, status models.DeploymentStatus, events ...time.Duration) {
+		t.Helper()
+		d := &models.Deployment{ID: id, ProjectID: project, Status: status, CommitSHA: fmt.Sprintf("%040d", id),
+			K8sDeploymentName: fmt.Sprintf("project-%d", project)}
+		if err := database.DB.Create(d).Error; err != nil {
+			t.Fatal(err)
+		}
+		database.DB.Model(d).UpdateColumn("updated_at", base.Add(4*time.Hour))
+		for _, at := range events {
+			database.DB.Create(&models.DeploymentEvent{DeploymentID: id, FromStatus: models.StatusDeploying, ToStatus: models.StatusDeployed, CreatedAt: base.Add(at)})
+		}
+	}
+	deployed(1, 1, models.StatusDeployed, 0, 30*time.Minute) // Live from its first event
+	deployed(2, 1, models.StatusFailed, time.Hour)           // Went live, then failed
+	deployed(3, 1, models.StatusFailed)                      // Never live
+	deployed(4, 1, models.StatusDeployed)                    // Without history: from its last update
+	deployed(10, 2, models.StatusDeployed, 2*time.Hour)
+	database.DB.Create(&models.Build{DeploymentID: 1, ImageDigest: "sha256:" + strings.Repeat("1", 64)})
+
+	n, err := Backfill(database.DB)
+	if err != nil || n != 4 {
+		t.Fatalf("backfilled %d, %v", n, err)
+	}
+	var activations []models.DeploymentActivation
+	database.DB.Order("deployment_id").Find(&activations)
+	want := []struct {
+		deployment  uint
+		activated   time.Duration
+		deactivated time.Duration // 0 = live
+		replacedBy  uint
+		reason      string
+	}{
+		{1, 0, time.Hour, 2, models.DeactivateReplaced},
+		{2, time.Hour, 4 * time.Hour, 4, models.DeactivateReplaced},
+		{4, 4 * time.Hour, 0, 0, ""},
+		{10, 2 * time.Hour, 5 * time.Hour, 0, models.DeactivateArchived},
+	}
+	if len(activations) != len(want) {
+		t.Fatalf("activations %+v", activations)
+	}
+	for i, w := range want {
+		a := activations[i]
+		ok := a.DeploymentID == w.deployment && a.ActivatedAt.Equal(base.Add(w.activated)) && a.Backfilled && a.DeactivateReason == w.reason &&
+			(w.deactivated == 0) == (a.DeactivatedAt == nil) && (a.DeactivatedAt == nil || a.DeactivatedAt.Equal(base.Add(w.deactivated))) &&
+			(w.replacedBy == 0) == (a.ReplacedByID == nil) && (a.ReplacedByID == nil || *a.ReplacedByID == w.replacedBy)
+		if !ok {
+			t.Errorf("activation of deployment %d: %+v", w.deployment, a)
+		}
+	}
+	if activations[0].ImageDigest != "sha256:"+strings.Repeat("1", 64) || activations[0].Environment != "production" {
+		t.Errorf("activation %+v", activations[0])
+	}
+
+	// Recorded activations are never backfilled over
+	if n, err := Backfill(database.DB); n != 0 || err != nil {
+		t.Errorf("backfilled again %d, %v", n, err)
+	}
+}
@@ -0,0 +1,389 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"deploy-platform/internal/quota"
+	"deploy-platform/internal/throttle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	buildQueue       queue.BuildQueue
+	heartbeatTimeout = 5 * time.Minute
+	throttles        []*throttle.Semaphore
+)
+
+// InitBuildMonitor sets the queue, heartbeat timeout and build/deploy
+// semaphores reported by the admin stats
+func InitBuildMonitor(q queue.BuildQueue, timeout time.Duration, semaphores ...*throttle.Semaphore) {
+	buildQueue = q
+	heartbeatTimeout = timeout
+	throttles = semaphores
+}
+
+// PlanRequest creates or updates a quota plan (limits of 0 mean unlimited)
+type PlanRequest struct {
+	Name                 string `json:"name" binding:"required"`
+	MaxProjects          int    `json:"max_projects" binding:"min=0"`
+	MaxDeploymentsPerDay int    `json:"max_deployments_per_day" binding:"min=0"`
+	MaxCustomDomains     int    `json:"max_custom_domains" binding:"min=0"`
+	MaxEnvVars           int    `json:"max_env_vars" binding:"min=0"`
+}
+
+// SetUserPlanRequest assigns a plan to a user
+type SetUserPlanRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+// GetPlans lists all quota plans
+func GetPlans(c *gin.Context) {
+	var plans []models.Plan
+	if err := database.DB.Order("id").Find(&plans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch plans"})
+		return
+	}
+	c.JSON(http.StatusOK, plans)
+}
+
+// SavePlan creates a plan or updates the limits of an existing one
+func SavePlan(c *gin.Context) {
+	var req PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == quota.DefaultPlanName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The default plan is configured through FREE_PLAN_* settings"})
+		return
+	}
+
+	plan := models.Plan{Name: req.Name}
+	if err := database.DB.Where("name = ?", req.Name).FirstOrCreate(&plan).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save plan"})
+		return
+	}
+	plan.MaxProjects = req.MaxProjects
+	plan.MaxDeploymentsPerDay = req.MaxDeploymentsPerDay
+	plan.MaxCustomDomains = req.MaxCustomDomains
+	plan.MaxEnvVars = req.MaxEnvVars
+	if err := database.DB.Save(&plan).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save plan"})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// SetUserPlan moves a user to another plan
+func SetUserPlan(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req SetUserPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var plan models.Plan
+	if err := database.DB.Where("name = ?", req.Plan).First(&plan).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("plan_id", plan.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update plan"})
+		return
+	}
+	user.Plan = &plan
+
+	c.JSON(http.StatusOK, user)
+}
+
+// GetUsersNearLimits lists users at or above ?threshold (default 0.8) of a quota
+func GetUsersNearLimits(c *gin.Context) {
+	threshold := 0.8
+	if raw := c.Query("threshold"); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+			return
+		}
+		threshold = parsed
+	}
+
+	users, err := quota.UsersNearLimits(threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"threshold": threshold,
+		"users":     users,
+	})
+}
+
+// StaleBuild is a build whose worker has stopped reporting heartbeats
+type StaleBuild struct {
+	BuildID             uint   `json:"build_id"`
+	DeploymentID        uint   `json:"deployment_id"`
+	HeartbeatAgeSeconds *int64 `json:"heartbeat_age_seconds"` // nil if it never beat
+}
+
+// GetAdminStats reports build counts by status, the queue size and builds
+// whose heartbeat is older than BUILD_HEARTBEAT_TIMEOUT
+func GetAdminStats(c *gin.Context) {
+	type statusCount struct {
+		Status string
+		Count  int64
+	}
+	var counts []statusCount
+	if err := database.DB.Model(&models.Build{}).Select("status, COUNT(*) AS count").Group("status").Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build stats"})
+		return
+	}
+	builds := make(map[string]int64, len(counts))
+	for _, sc := range counts {
+		builds[sc.Status] = sc.Count
+	}
+
+	stale, err := build.StaleBuilds(heartbeatTimeout)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build stats"})
+		return
+	}
+	staleBuilds := make([]StaleBuild, 0, len(stale))
+	for _, b := range stale {
+		staleBuilds = append(staleBuilds, StaleBuild{
+			BuildID:             b.ID,
+			DeploymentID:        b.DeploymentID,
+			HeartbeatAgeSeconds: heartbeatAge(&b),
+		})
+	}
+
+	queueSize := 0
+	if buildQueue != nil {
+		queueSize = buildQueue.Size()
+	}
+
+	slots := make([]throttle.Stats, 0, len(throttles))
+	for _, sem := range throttles {
+		if sem != nil {
+			slots = append(slots, sem.Stats())
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"builds":                    builds,
+		"queue_size":                queueSize,
+		"queue_wait":                queueWaitStats(time.Now().Add(-queueWaitWindow)),
+		"slots":                     slots,
+		"stale_builds":              staleBuilds,
+		"heartbeat_timeout_seconds": int64(heartbeatTimeout.Seconds()),
+	})
+}
+
+const queueWaitWindow = 24 * time.Hour
+
+// QueueWait is how long deployments waited for a build since a time: the
+// platform's speed, leaving out deployments an incident delayed
+type QueueWait struct {
+	Deployments     int     `json:"deployments"`
+	AverageSeconds  float64 `json:"average_seconds"`
+	MaxSeconds      float64 `json:"max_seconds"`
+	ExcludedDelayed int     `json:"excluded_delayed"` // Delayed by an incident, not counted
+}
+
+// queueWaitStats measures the wait of the builds started since since, from
+// their deployment's creation
+func queueWaitStats(since time.Time) QueueWait {
+	var waits []struct {
+		CreatedAt         time.Time
+		StartedAt         time.Time
+		DelayedByIncident bool
+	}
+	database.DB.Table("builds").
+		Select("deployments.created_at, builds.started_at, deployments.delayed_by_incident").
+		Joins("JOIN deployments ON deployments.id = builds.deployment_id").
+		Where("builds.started_at > ?", since).
+		Scan(&waits)
+
+	var stats QueueWait
+	var total float64
+	for _, wait := range waits {
+		if wait.DelayedByIncident {
+			stats.ExcludedDelayed++
+			continue
+		}
+		seconds := max(wait.StartedAt.Sub(wait.CreatedAt).Seconds(), 0)
+		total += seconds
+		stats.MaxSeconds = max(stats.MaxSeconds, seconds)
+		stats.Deployments++
+	}
+	if stats.Deployments > 0 {
+		stats.AverageSeconds = total / float64(stats.Deployments)
+	}
+	return stats
+}
+
+// ImpersonateRequest optionally explains and shortens an impersonation session
+type ImpersonateRequest struct {
+	Reason  string `json:"reason"`
+	Minutes int    `json:"minutes" binding:"min=0,max=30"` // 0 = the 30 minute maximum
+}
+
+// Impersonate issues a short-lived token to view the platform as another user
+func Impersonate(c *gin.Context) {
+	targetID, err := strconv.ParseUint(c.Param("userID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req ImpersonateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var admin, target models.User
+	if err := database.DB.First(&admin, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	if err := database.DB.First(&target, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	session, token, err := auth.StartImpersonation(&admin, &target, req.Reason, time.Duration(req.Minutes)*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"session_id": session.ID,
+		"expires_at": session.ExpiresAt,
+		"user":       target,
+	})
+}
+
+// GetImpersonations lists impersonation sessions that are still live
+func GetImpersonations(c *gin.Context) {
+	var sessions []models.ImpersonationSession
+	if err := database.DB.Preload("Admin").Preload("TargetUser").
+		Where("revoked_at IS NULL AND expires_at > ?", time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch impersonation sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeImpersonation ends an impersonation session before it expires
+func RevokeImpersonation(c *gin.Context) {
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := auth.RevokeImpersonation(uint(sessionID), c.GetUint("user_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation session revoked"})
+}
+
+// loginActions are the audit entries of password sign-ins
+var loginActions = []string{"login.success", "login.failed", "login.lockout", "login.unlock"}
+
+// GetUserLoginStatus shows an admin why a user's password sign-in fails: the
+// lock, the failures counted and the recent sign-in attempts with their reasons
+func GetUserLoginStatus(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var attempts []models.AuditLog
+	if err := database.DB.Where("user_id = ? AND action IN ?", user.ID, loginActions).
+		Order("created_at DESC").
+		Limit(50).
+		Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sign-in attempts"})
+		return
+	}
+
+	response := gin.H{
+		"user_id":      user.ID,
+		"locked":       auth.LockedOut(&user),
+		"failures":     auth.LoginFailures(c.Request.Context(), &user),
+		"has_password": user.PasswordHash != "",
+		"attempts":     attempts,
+	}
+	if auth.LockedOut(&user) {
+		response["locked_until"] = user.LockedUntil
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UnlockUser lifts a sign-in lockout before it expires
+func UnlockUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := auth.ClearLockout(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	adminID := c.GetUint("user_id")
+	audit.Record(&models.AuditLog{
+		UserID:  user.ID,
+		Action:  "login.unlock",
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		IP:      c.ClientIP(),
+		Details: fmt.Sprintf("unlocked by admin %d", adminID),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
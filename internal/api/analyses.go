@@ -0,0 +1,36 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProjectAnalyses lists the analyses of the pushes to a project in
+// analysis-only mode, newest first: what a build of each commit would have
+// detected, generated and warned about. ?branch= keeps one branch's.
+func GetProjectAnalyses(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	query := database.DB.Where("project_id = ?", project.ID)
+	if branch := c.Query("branch"); branch != "" {
+		query = query.Where("branch = ?", branch)
+	}
+	analyses := []models.Analysis{}
+	if err := query.Order("id DESC").Limit(limit).Find(&analyses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch analyses"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"analysis_only": project.AnalysisOnly, "analyses": analyses})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"deploy-platform/internal/activations"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/preview"
+	"deploy-platform/internal/quota"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var previewMgr *preview.Manager
+
+// InitPreviews sets the manager tearing down the preview environments of
+// archived projects
+func InitPreviews(m *preview.Manager) {
+	previewMgr = m
+}
+
+// ArchiveProject retires a project without losing its history: its
+// Kubernetes resources are removed from every cluster it runs on and its
+// preview environments torn down, its hostnames are deactivated (kept
+// reserved for it), and from then on pushes and deploys are refused with
+// project_archived. Archived projects don't count toward the owner's
+// project quota.
+func ArchiveProject(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	if project.Archived() {
+		c.JSON(http.StatusConflict, gin.H{"error": "The project is already archived"})
+		return
+	}
+	var inProgress int64
+	database.DB.Model(&models.Deployment{}).
+		Where("project_id = ? AND status IN ?", project.ID, []models.DeploymentStatus{models.StatusPending, models.StatusQueued, models.StatusBuilding, models.StatusAwaitingApproval, models.StatusPolicyBlocked, models.StatusDeploying, models.StatusDeployPending}).
+		Count(&inProgress)
+	if inProgress > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A deployment of the project is in progress, try again once it finishes"})
+		return
+	}
+
+	// A project still moving between clusters has resources on both
+	clusters := []string{k8sClients.ClusterOf(project)}
+	if project.MigratingFrom != "" {
+		clusters = append(clusters, project.MigratingFrom)
+	}
+	for _, cluster := range clusters {
+		client := k8sClients.Client(cluster)
+		if client == nil {
+			continue
+		}
+		if err := client.DeleteProjectResources(c.Request.Context(), project.ID); err != nil {
+			log.Printf("❌ Failed to remove the resources of project %d from cluster %s: %v", project.ID, cluster, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to remove the project's resources from cluster %s, it was not archived: %v", cluster, err)})
+			return
+		}
+	}
+	previewMgr.RetireProject(project.ID)
+	if err := activations.End(database.DB, project.ID, "", models.DeactivateArchived); err != nil {
+		log.Printf("⚠️  Failed to end the activations of project %d: %v", project.ID, err)
+	}
+	if hostnameMgr != nil {
+		if err := hostnameMgr.DeactivateProject(project.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate the project's hostnames"})
+			return
+		}
+	}
+
+	now := time.Now()
+	project.ArchivedAt = &now
+	project.MigratingFrom = ""
+	if err := database.DB.Model(project).Select("archived_at", "migrating_from").Updates(project).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive project"})
+		return
+	}
+	quota.ReleaseProject(project.UserID)
+	log.Printf("📦 Project %d archived", project.ID)
+	audit.FromContext(c, "project.archive", fmt.Sprintf("project %d", project.ID))
+
+	c.JSON(http.StatusOK, project)
+}
+
+// UnarchiveProject lets an archived project deploy again, if the owner's
+// project quota allows it. Nothing runs until its next deployment, which
+// recreates its resources and gets its hostnames back.
+func UnarchiveProject(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	if !project.Archived() {
+		c.JSON(http.StatusConflict, gin.H{"error": "The project is not archived"})
+		return
+	}
+	if err := quota.ReserveProject(project.UserID); err != nil {
+		if !respondQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check project quota"})
+		}
+		return
+	}
+
+	project.ArchivedAt = nil
+	if err := database.DB.Model(project).Select("archived_at").Updates(project).Error; err != nil {
+		quota.ReleaseProject(project.UserID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unarchive project"})
+		return
+	}
+	log.Printf("📦 Project %d unarchived", project.ID)
+	audit.FromContext(c, "project.unarchive", fmt.Sprintf("project %d", project.ID))
+
+	c.JSON(http.StatusOK, project)
+}
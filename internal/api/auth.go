@@ -3,7 +3,9 @@ package api
 import (
 	"deploy-platform/internal/auth"
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/githubscopes"
 	"deploy-platform/internal/models"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -68,6 +70,7 @@ func Register(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	auth.SetSession(c, token)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"user":  user,
@@ -83,22 +86,15 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// Find user by email or username
-	var user models.User
-	if err := database.DB.Where("email = ? OR username = ?", req.Email, req.Email).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email/username or password"})
-		return
-	}
-
-	// Check if user has a password (OAuth users might not have password)
-	if user.PasswordHash == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "This account was created with OAuth. Please use Google or GitHub to sign in."})
-		return
-	}
-
-	// Check password
-	if !auth.CheckPasswordHash(req.Password, user.PasswordHash) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email/username or password"})
+	// Unknown accounts, wrong passwords, OAuth-only and locked accounts all get
+	// the same answer; admins see the reason in the user's login status
+	user, err := auth.CheckLogin(c.Request.Context(), req.Email, req.Password, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidLogin) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email/username or password"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
 		return
 	}
 
@@ -108,9 +104,64 @@ func Login(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	auth.SetSession(c, token)
 
 	c.JSON(http.StatusOK, gin.H{
 		"user":  user,
 		"token": token,
 	})
 }
+
+// GetProfile returns the authenticated user. While an admin impersonates the
+// user it also returns who is impersonating, for the "viewing as" banner.
+func GetProfile(c *gin.Context) {
+	response := gin.H{
+		"user_id":  c.GetUint("user_id"),
+		"username": c.GetString("username"),
+	}
+
+	// GitHub features the user's granted scopes allow
+	var user models.User
+	if err := database.DB.Select("github_id", "github_scopes").First(&user, c.GetUint("user_id")).Error; err == nil && user.GitHubID != nil {
+		granted := githubscopes.Parse(user.GitHubScopes)
+		response["github"] = gin.H{
+			"scopes":   granted,
+			"features": githubscopes.Status(granted),
+		}
+	}
+
+	if session := auth.ActiveImpersonation(c); session != nil {
+		response["impersonation"] = gin.H{
+			"session_id":            session.ID,
+			"impersonator_id":       session.AdminID,
+			"impersonator_username": session.Admin.Username,
+			"expires_at":            session.ExpiresAt,
+			"read_only":             auth.ImpersonationReadOnly(),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSecurityActivity lists the recent password sign-ins to the user's
+// account, failed ones and lockouts included
+func GetSecurityActivity(c *gin.Context) {
+	var entries []models.AuditLog
+	if err := database.DB.Where("user_id = ? AND action IN ?", c.GetUint("user_id"), loginActions).
+		Order("created_at DESC").
+		Limit(50).
+		Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch security activity"})
+		return
+	}
+
+	// Failure reasons are for admins, lockouts say until when
+	activity := make([]gin.H, len(entries))
+	for i, entry := range entries {
+		activity[i] = gin.H{"action": entry.Action, "ip": entry.IP, "created_at": entry.CreatedAt}
+		if entry.Action == "login.lockout" {
+			activity[i]["details"] = entry.Details
+		}
+	}
+	c.JSON(http.StatusOK, activity)
+}
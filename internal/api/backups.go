@@ -0,0 +1,51 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/backup"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var backupService *backup.Service
+
+// InitBackups sets the backup service triggered by the admin API
+func InitBackups(s *backup.Service) {
+	backupService = s
+}
+
+// GetBackups lists backups, newest first, with the size and SHA-256 of each
+// stored object
+func GetBackups(c *gin.Context) {
+	var backups []models.Backup
+	if err := database.DB.Order("created_at DESC, id DESC").Find(&backups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch backups"})
+		return
+	}
+	c.JSON(http.StatusOK, backups)
+}
+
+// TriggerBackup starts a backup; poll GET /api/admin/backups for its outcome
+func TriggerBackup(c *gin.Context) {
+	if backupService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Backups are not configured on this instance"})
+		return
+	}
+
+	record, err := backupService.Trigger()
+	if err != nil {
+		if errors.Is(err, backup.ErrRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A backup is already running"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start backup: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, record)
+	audit.FromContext(c, "backup.trigger", record.Name)
+}
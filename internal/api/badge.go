@@ -0,0 +1,118 @@
+package api
+
+import (
+	"crypto/sha256"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Badge statuses and their colors
+const (
+	BadgeDeployed = "deployed"
+	BadgeFailed   = "failed"
+	BadgeBuilding = "building"
+	BadgeUnknown  = "unknown" // Never deployed
+	BadgePrivate  = "private" // Not opted in, or no such project
+)
+
+var badgeColors = map[string]string{
+	BadgeDeployed: "#4c1",
+	BadgeFailed:   "#e05d44",
+	BadgeBuilding: "#dfb317",
+	BadgeUnknown:  "#9f9f9f",
+	BadgePrivate:  "#9f9f9f",
+}
+
+const (
+	badgeLabel        = "deploy"
+	badgeCacheControl = "public, max-age=300, stale-while-revalidate=3600" // Badge proxies (GitHub's camo) revalidate with the ETag
+)
+
+// GetBadge serves the deploy status badge of a project, for READMEs:
+// /badge/<slug>.svg shows its latest production deployment, ?branch=<name>
+// the latest of a branch. Projects that haven't set public_badge, and slugs
+// that don't exist, get the same "private" badge, so nothing about them leaks.
+func GetBadge(c *gin.Context) {
+	slug, ok := strings.CutSuffix(c.Param("badge"), ".svg")
+	if !ok || slug == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Badges are at /badge/<project>.svg"})
+		return
+	}
+
+	status := BadgePrivate
+	var project models.Project
+	if database.DB.Select("id", "branch", "public_badge").Where("slug = ?", slug).First(&project).Error == nil && project.PublicBadge {
+		status = badgeStatus(&project, c.Query("branch"))
+	}
+
+	svg := renderBadge(badgeLabel, status)
+	sum := sha256.Sum256([]byte(svg))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	c.Header("Cache-Control", badgeCacheControl)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "image/svg+xml; charset=utf-8", []byte(svg))
+}
+
+// badgeStatus is the badge status of the project's latest production
+// deployment, or of branch's latest deployment when given. Cancelled,
+// rejected, skipped and superseded deployments don't count: they never
+// replaced what was live.
+func badgeStatus(project *models.Project, branch string) string {
+	query := database.DB.Select("status").
+		Where("project_id = ? AND status NOT IN ?", project.ID, []models.DeploymentStatus{models.StatusCancelled, models.StatusRejected, models.StatusSkipped, models.StatusSuperseded}).
+		Where("COALESCE(target, '') <> ?", models.TargetPreview)
+	if branch != "" {
+		query = query.Where("branch = ?", branch)
+	} else {
+		query = query.Where("(target = ? OR branch = ? OR branch = '')", models.TargetProduction, project.Branch)
+	}
+
+	var latest models.Deployment
+	if query.Order("id DESC").First(&latest).Error != nil {
+		return BadgeUnknown
+	}
+	switch latest.Status {
+	case models.StatusDeployed:
+		return BadgeDeployed
+	case models.StatusFailed:
+		return BadgeFailed
+	}
+	return BadgeBuilding
+}
+
+// renderBadge draws a flat two-part badge, label on grey and status on its
+// color. Widths are estimated from the text, Verdana 11px averaging 7px a
+// character, which fits the fixed words badges use. The text is escaped.
+func renderBadge(label, status string) string {
+	labelWidth, statusWidth := badgeTextWidth(label), badgeTextWidth(status)
+	color := badgeColors[status]
+	label, status = html.EscapeString(label), html.EscapeString(status)
+	width := labelWidth + statusWidth
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[2]s: %[3]s">`+
+		`<title>%[2]s: %[3]s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[4]d" height="20" fill="#555"/><rect x="%[4]d" width="%[5]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[2]s</text><text x="%[7]d" y="14">%[2]s</text>`+
+		`<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text><text x="%[8]d" y="14">%[3]s</text>`+
+		`</g></svg>`,
+		width, label, status, labelWidth, statusWidth, color, labelWidth/2, labelWidth+statusWidth/2)
+}
+
+// badgeTextWidth is the width of a badge part holding text, padding included
+func badgeTextWidth(text string) int {
+	return utf8.RuneCountInString(text)*7 + 10
+}
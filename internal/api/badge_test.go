@@ -0,0 +1,124 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares got with the golden file at path, rewriting it with -update
+func golden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from the golden file:\n%s", path, got)
+	}
+}
+
+// getBadge requests a badge from a router serving GetBadge
+func getBadge(path string, header http.Header) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/badge/:badge", GetBadge)
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// Each status variant is drawn as testdata/badges/<status>.svg
+func TestBadgeGolden(t *testing.T) {
+	// The golden files are read before testutil.DB changes directory
+	goldenDir, err := filepath.Abs(filepath.Join("testdata", "badges"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		public   bool
+		statuses []models.DeploymentStatus // Of the production branch, oldest first
+		path     string
+		badge    string
+	}{
+		{name: "deployed", public: true, statuses: []models.DeploymentStatus{models.StatusFailed, models.StatusDeployed}, badge: BadgeDeployed},
+		{name: "failed", public: true, statuses: []models.DeploymentStatus{models.StatusDeployed, models.StatusFailed}, badge: BadgeFailed},
+		{name: "building", public: true, statuses: []models.DeploymentStatus{models.StatusDeployed, models.StatusBuilding}, badge: BadgeBuilding},
+		{name: "superseded ignored", public: true, statuses: []models.DeploymentStatus{models.StatusDeployed, models.StatusSuperseded}, badge: BadgeDeployed},
+		{name: "never deployed", public: true, badge: BadgeUnknown},
+		{name: "not opted in", statuses: []models.DeploymentStatus{models.StatusFailed}, badge: BadgePrivate},
+		{name: "no such project", path: "/badge/missing.svg", badge: BadgePrivate},
+		{name: "other branch", public: true, statuses: []models.DeploymentStatus{models.StatusFailed}, path: "/badge/app.svg?branch=staging", badge: BadgeUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.DB(t)
+			project := &models.Project{Name: "app", Slug: "app", Branch: "main", PublicBadge: tt.public}
+			database.DB.Create(project)
+			for _, status := range tt.statuses {
+				database.DB.Create(&models.Deployment{ProjectID: project.ID, Status: status, Branch: "main"})
+			}
+			path := tt.path
+			if path == "" {
+				path = "/badge/app.svg"
+			}
+
+			w := getBadge(path, nil)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml; charset=utf-8" {
+				t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+			}
+			if cache := w.Header().Get("Cache-Control"); cache != badgeCacheControl {
+				t.Errorf("Cache-Control %q", cache)
+			}
+			golden(t, filepath.Join(goldenDir, tt.badge+".svg"), w.Body.Bytes())
+
+			// Proxies revalidating get a 304
+			w = getBadge(path, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+			if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("revalidation got %d with %d bytes", w.Code, w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestBadgeBranch(t *testing.T) {
+	testutil.DB(t)
+	project := &models.Project{Name: "app", Slug: "app", Branch: "main", PublicBadge: true}
+	database.DB.Create(project)
+	database.DB.Create(&models.Deployment{ProjectID: project.ID, Status: models.StatusDeployed, Branch: "main"})
+	database.DB.Create(&models.Deployment{ProjectID: project.ID, Status: models.StatusFailed, Branch: "staging"})
+
+	if status := badgeStatus(project, ""); status != BadgeDeployed {
+		t.Errorf("production badge is %s", status)
+	}
+	if status := badgeStatus(project, "staging"); status != BadgeFailed {
+		t.Errorf("staging badge is %s", status)
+	}
+}
+
+func TestBadgeNotFound(t *testing.T) {
+	if w := getBadge("/badge/app.png", nil); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"deploy-platform/internal/consistency"
+	"deploy-platform/internal/database"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetConsistency checks the invariants spanning the database's tables and
+// reports the rows breaking them, grouped by invariant. Fixes are applied
+// with cmd/fsck -fix, not through the API.
+func GetConsistency(c *gin.Context) {
+	report, err := consistency.Check(database.DB)
+	if err != nil {
+		log.Printf("❌ Consistency check failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the database's consistency"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/cost"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	costDefaultWindow = 30 * 24 * time.Hour
+	costMaxWindow     = 366 * 24 * time.Hour
+)
+
+// costColumns is the CSV header of cost exports, a row per project and environment
+var costColumns = []string{
+	"owner", "project_id", "project", "environment", "cpu_core_hours", "memory_gb_hours",
+	"cpu_cost", "memory_cost", "total", "currency", "from", "to",
+}
+
+var costRates cost.Rates
+
+// InitCosts sets the COST_* rates estimates are priced at
+func InitCosts(cfg *config.Config) {
+	costRates = cost.RatesOf(cfg)
+}
+
+// ProjectCost is the estimate of a project in an admin rollup
+type ProjectCost struct {
+	Slug string `json:"slug"` // Empty for deleted projects
+	*cost.Estimate
+}
+
+// OwnerCost rolls up the estimates of a user's projects
+type OwnerCost struct {
+	UserID   uint   `json:"user_id"` // 0 for deleted projects
+	Username string `json:"username"`
+	cost.Compute
+	Projects []ProjectCost `json:"projects"`
+}
+
+// GetProjectCost estimates what the project cost to run over the last
+// ?window (e.g. "30d", "12h"; 30 days by default), from the CPU and memory
+// its pods requested, by environment. ?format=csv exports it.
+func GetProjectCost(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	from, to, ok := costWindow(c)
+	if !ok {
+		return
+	}
+	estimates, err := cost.Estimates(c.Request.Context(), costRates, []uint{project.ID}, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to estimate costs"})
+		return
+	}
+	estimate := estimates[project.ID]
+	if c.Query("format") == ExportCSV {
+		var owner models.User
+		database.DB.Select("username").First(&owner, project.UserID)
+		writeCostCSV(c, project.Slug, from, to, []OwnerCost{{
+			Username: owner.Username,
+			Projects: []ProjectCost{{Slug: project.Slug, Estimate: estimate}},
+		}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"estimate": estimate, "rates": costRates})
+}
+
+// GetCosts estimates what every project cost to run over the last ?window,
+// rolled up per owner, most expensive first. ?format=csv exports a row per
+// project and environment, for finance.
+func GetCosts(c *gin.Context) {
+	from, to, ok := costWindow(c)
+	if !ok {
+		return
+	}
+	estimates, err := cost.Estimates(c.Request.Context(), costRates, nil, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to estimate costs"})
+		return
+	}
+	owners, err := rollupCosts(estimates)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load projects"})
+		return
+	}
+	if c.Query("format") == ExportCSV {
+		audit.FromContext(c, "cost.export", fmt.Sprintf("all projects %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339)))
+		writeCostCSV(c, "all", from, to, owners)
+		return
+	}
+	all := make([]*cost.Estimate, 0, len(estimates))
+	for _, estimate := range estimates {
+		all = append(all, estimate)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from,
+		"to":     to,
+		"rates":  costRates,
+		"total":  cost.Rollup(costRates, all),
+		"owners": owners,
+	})
+}
+
+// costWindow reads ?window, a number of days ("30d") or a Go duration
+// ("12h"), as the range up to now
+func costWindow(c *gin.Context) (time.Time, time.Time, bool) {
+	window := costDefaultWindow
+	if value := c.Query("window"); value != "" {
+		var err error
+		if days, ok := strings.CutSuffix(value, "d"); ok {
+			var n int
+			n, err = strconv.Atoi(days)
+			window = time.Duration(n) * 24 * time.Hour
+		} else {
+			window, err = time.ParseDuration(value)
+		}
+		if err != nil || window <= 0 || window > costMaxWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a number of days (e.g. 30d) or a duration (e.g. 12h), up to 366d"})
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	to := time.Now().UTC()
+	return to.Add(-window), to, true
+}
+
+// rollupCosts groups the estimates by the owner of their project
+func rollupCosts(estimates map[uint]*cost.Estimate) ([]OwnerCost, error) {
+	ids := make([]uint, 0, len(estimates))
+	for id := range estimates {
+		ids = append(ids, id)
+	}
+	var projects []models.Project
+	if err := database.DB.Select("id", "user_id", "slug").Where("id IN ?", ids).Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*models.Project, len(projects))
+	userIDs := make([]uint, 0, len(projects))
+	for i := range projects {
+		byID[projects[i].ID] = &projects[i]
+		userIDs = append(userIDs, projects[i].UserID)
+	}
+	var users []models.User
+	if err := database.DB.Select("id", "username").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usernames := make(map[uint]string, len(users))
+	for _, user := range users {
+		usernames[user.ID] = user.Username
+	}
+
+	byOwner := make(map[uint]*OwnerCost)
+	ownerEstimates := make(map[uint][]*cost.Estimate)
+	for id, estimate := range estimates {
+		var userID uint
+		slug := ""
+		if project := byID[id]; project != nil {
+			userID, slug = project.UserID, project.Slug
+		}
+		if byOwner[userID] == nil {
+			byOwner[userID] = &OwnerCost{UserID: userID, Username: usernames[userID]}
+		}
+		byOwner[userID].Projects = append(byOwner[userID].Projects, ProjectCost{Slug: slug, Estimate: estimate})
+		ownerEstimates[userID] = append(ownerEstimates[userID], estimate)
+	}
+
+	owners := make([]OwnerCost, 0, len(byOwner))
+	for userID, owner := range byOwner {
+		owner.Compute = cost.Rollup(costRates, ownerEstimates[userID])
+		sort.Slice(owner.Projects, func(i, j int) bool {
+			a, b := owner.Projects[i], owner.Projects[j]
+			return a.Total > b.Total || (a.Total == b.Total && a.ProjectID < b.ProjectID)
+		})
+		owners = append(owners, *owner)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return owners[i].Total > owners[j].Total || (owners[i].Total == owners[j].Total && owners[i].UserID < owners[j].UserID)
+	})
+	return owners, nil
+}
+
+// writeCostCSV sends the estimates of owners as CSV, a row per project and
+// environment it used
+func writeCostCSV(c *gin.Context, name string, from, to time.Time, owners []OwnerCost) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="costs-%s-%s-%s.csv"`, name, from.Format(exportDay), to.Format(exportDay)))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write(costColumns)
+	for _, owner := range owners {
+		for _, project := range owner.Projects {
+			for _, env := range cost.Environments {
+				compute := project.Environments[env]
+				if compute == (cost.Compute{}) {
+					continue
+				}
+				w.Write([]string{
+					csvSafe(owner.Username),
+					strconv.FormatUint(uint64(project.ProjectID), 10),
+					csvSafe(project.Slug),
+					env,
+					strconv.FormatFloat(compute.CPUCoreHours, 'f', 2, 64),
+					strconv.FormatFloat(compute.MemoryGBHours, 'f', 2, 64),
+					strconv.FormatFloat(compute.CPUCost, 'f', 2, 64),
+					strconv.FormatFloat(compute.MemoryCost, 'f', 2, 64),
+					strconv.FormatFloat(compute.Total, 'f', 2, 64),
+					csvSafe(project.Currency),
+					from.Format(time.RFC3339),
+					to.Format(time.RFC3339),
+				})
+			}
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secrets"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CloneTokenRequest sets the HTTPS token used to clone a project's repository
+type CloneTokenRequest struct {
+	Token string `json:"token"` // Empty removes the token
+}
+
+// ownedProject loads the :id project, writing an error response and returning
+// false if it doesn't exist or belongs to someone else
+func ownedProject(c *gin.Context) (*models.Project, bool) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return nil, false
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return nil, false
+	}
+	if project.UserID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	return &project, true
+}
+
+// GenerateWebhookToken creates (or rotates) the token authenticating
+// POST /webhooks/generic/:token for the project. The token is only shown once.
+func GenerateWebhookToken(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+
+	token, err := secrets.GenerateToken(24)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	if err := database.DB.Model(project).Update("webhook_token_hash", secrets.HashToken(token)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":       token,
+		"webhook_url": "/webhooks/generic/" + token,
+		"message":     "Store this token now, it won't be shown again",
+	})
+}
+
+// GenerateDeployKey creates (or rotates) the project's SSH deploy key and
+// returns the public key to add to the Git server
+func GenerateDeployKey(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+
+	publicKey, privateKey, err := secrets.GenerateDeployKey(fmt.Sprintf("deploy-platform-%s", project.Slug))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate deploy key"})
+		return
+	}
+	encrypted, err := secrets.Encrypt(privateKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt deploy key"})
+		return
+	}
+
+	if err := database.DB.Model(project).Updates(map[string]interface{}{
+		"deploy_key_public":  publicKey,
+		"deploy_key_private": encrypted,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save deploy key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"public_key": publicKey,
+		"message":    "Add this key as a read-only deploy key on your Git server",
+	})
+}
+
+// SetCloneToken stores an HTTPS access token used to clone the repository
+func SetCloneToken(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+
+	var req CloneTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	encrypted, err := secrets.Encrypt(req.Token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt token"})
+		return
+	}
+	if err := database.DB.Model(project).Update("clone_token", encrypted).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Clone token updated"})
+}
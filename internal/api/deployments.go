@@ -1,30 +1,77 @@
 package api
 
 import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/buildlogs"
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/jsonstream"
+	"deploy-platform/internal/kubernetes"
 	"deploy-platform/internal/models"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-// GetDeployments returns all deployments for the authenticated user
+var logArchive *buildlogs.Archive
+var logStreams *buildlogs.Streams
+
+// InitBuildLogs sets the archive holding the logs of old builds
+func InitBuildLogs(a *buildlogs.Archive) {
+	logArchive = a
+}
+
+// InitLogStreams sets the output streams of the builds running on this
+// instance, for following their logs
+func InitLogStreams(s *buildlogs.Streams) {
+	logStreams = s
+}
+
+// GetDeployments returns all deployments for the authenticated user, newest
+// first. They are streamed a batch at a time: accounts with a long history
+// would otherwise hold it all in memory.
 func GetDeployments(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
-	var deployments []models.Deployment
-	if err := database.DB.Where("project_id IN (SELECT id FROM projects WHERE user_id = ?)", userID).
-		Preload("Project").
-		Preload("Build").
-		Order("created_at DESC").
-		Find(&deployments).Error; err != nil {
+	var fieldsErr error
+	err := jsonstream.Stream(c, fmt.Sprintf("the deployments of user %d", userID), jsonstream.Array(), func(ctx context.Context, before uint) ([]interface{}, uint, error) {
+		query := database.DB.WithContext(ctx).Where("project_id IN (SELECT id FROM projects WHERE user_id = ?)", userID)
+		if before != 0 {
+			query = query.Where("id < ?", before)
+		}
+		var deployments []models.Deployment
+		if err := query.
+			Preload("Project").
+			Preload("Build", func(db *gorm.DB) *gorm.DB {
+				// Logs can be megabytes per build, the list never shows them
+				return db.Select("id", "deployment_id", "status", "started_at", "completed_at", "framework", "framework_version", "image_size_bytes", "warnings")
+			}).
+			Order("id DESC").Limit(jsonstream.BatchSize).
+			Find(&deployments).Error; err != nil || len(deployments) == 0 {
+			return nil, 0, err
+		}
+
+		summaries := make([]DeploymentSummary, len(deployments))
+		for i := range deployments {
+			summaries[i] = summarizeDeployment(&deployments[i])
+		}
+		rows, err := sparseRows(c, summaries)
+		fieldsErr = err
+		return rows, deployments[len(deployments)-1].ID, err
+	})
+	switch {
+	case fieldsErr != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fieldsErr.Error()})
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deployments"})
-		return
 	}
-
-	c.JSON(http.StatusOK, deployments)
 }
 
 // GetDeployment returns a specific deployment
@@ -49,44 +96,347 @@ func GetDeployment(c *gin.Context) {
 		return
 	}
 
+	// Liveness of the worker, so the dashboard can tell slow builds from dead ones
+	if deployment.Build.Status == "building" {
+		deployment.HeartbeatAgeSeconds = heartbeatAge(&deployment.Build)
+	}
+	deployment.URL = fullURL(deployment.Hostname)
+	estimate := build.EstimateDeployment(&deployment)
+	deployment.ETASeconds, deployment.ProgressHint = estimate.Seconds, estimate.Hint
+
+	// Logs are read back so clients see them wherever they are kept: the
+	// output a running build streamed, or the archive
+	if err := buildlogs.Collect(database.DB, &deployment.Build); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build logs"})
+		return
+	}
+	if deployment.Build.LogsObject != "" {
+		logs, err := logArchive.Load(c.Request.Context(), &deployment.Build)
+		if err != nil {
+			log.Printf("⚠️  Failed to read archived logs of build %d: %v", deployment.Build.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read archived build logs"})
+			return
+		}
+		deployment.Build.Logs = logs
+	}
+
 	c.JSON(http.StatusOK, deployment)
 }
 
-// GetProjects returns all projects for the authenticated user
+// GetDeploymentLogs streams a deployment's build logs as plain text, from the
+// database or, for old builds, decompressed from the log archive. With
+// ?follow=true they are streamed as server-sent events until the build is
+// over, see followDeploymentLogs.
+func GetDeploymentLogs(c *gin.Context) {
+	deployment, ok := ownedDeployment(c)
+	if !ok {
+		return
+	}
+	if c.Query("follow") == "true" {
+		followDeploymentLogs(c, deployment)
+		return
+	}
+
+	var build models.Build
+	// Each attempt has its own build: retried deployments show their latest
+	if err := database.DB.Where("deployment_id = ?", deployment.ID).Order("id DESC").First(&build).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment has no build"})
+		return
+	}
+	if err := buildlogs.Collect(database.DB, &build); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build logs"})
+		return
+	}
+	logs, err := logArchive.Open(c.Request.Context(), &build)
+	if err != nil {
+		log.Printf("⚠️  Failed to read archived logs of build %d: %v", build.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read archived build logs"})
+		return
+	}
+	defer logs.Close()
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, logs); err != nil {
+		log.Printf("⚠️  Streaming logs of build %d failed: %v", build.ID, err)
+	}
+}
+
+// DeleteDeployment deletes a finished deployment that no hostname serves,
+// with its build, history and archived logs
+func DeleteDeployment(c *gin.Context) {
+	deployment, ok := ownedDeployment(c)
+	if !ok {
+		return
+	}
+	if !deployment.Status.Terminal() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only finished deployments can be deleted"})
+		return
+	}
+	var served models.Hostname
+	if database.DB.Where("deployment_id = ?", deployment.ID).First(&served).Error == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment is serving %s", served.Hostname)})
+		return
+	}
+
+	// Archived logs go first: a failure leaves the deployment to delete again
+	var builds []models.Build
+	database.DB.Select("id", "deployment_id", "logs_object").Where("deployment_id = ?", deployment.ID).Find(&builds)
+	for i := range builds {
+		if err := logArchive.Delete(c.Request.Context(), &builds[i]); err != nil {
+			log.Printf("⚠️  Failed to delete archived logs of build %d: %v", builds[i].ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to delete archived build logs"})
+			return
+		}
+	}
+
+	var objects []string
+	database.DB.Model(&models.BuildArtifact{}).
+		Where("object <> '' AND build_id IN (?)", database.DB.Model(&models.Build{}).Select("id").Where("deployment_id = ?", deployment.ID)).
+		Distinct().Pluck("object", &objects)
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		builds := tx.Model(&models.Build{}).Select("id").Where("deployment_id = ?", deployment.ID)
+		if err := tx.Where("build_id IN (?)", builds).Delete(&models.BuildArtifact{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("build_id IN (?)", builds).Delete(&models.BuildLogChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("deployment_id = ?", deployment.ID).Delete(&models.Build{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("deployment_id = ?", deployment.ID).Delete(&models.DeploymentEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(deployment).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete deployment"})
+		return
+	}
+
+	pruneSecrets(c.Request.Context(), deployment.ProjectID)
+	pruneArtifactObjects(c.Request.Context(), objects)
+
+	audit.FromContext(c, "deployment.delete", fmt.Sprintf("deployment %d of project %d", deployment.ID, deployment.ProjectID))
+	c.JSON(http.StatusOK, gin.H{"message": "Deployment deleted"})
+}
+
+// pruneSecrets garbage-collects the env Secrets of the project's resources
+// that none of its remaining deployments use, in each cluster. Failures are
+// only logged: the Secrets are pruned again with the next deletion.
+func pruneSecrets(ctx context.Context, projectID uint) {
+	if k8sClients == nil {
+		return
+	}
+	var deployments []models.Deployment
+	if err := database.DB.Select("k8s_deployment_name", "cluster").
+		Where("project_id = ? AND k8s_deployment_name <> ''", projectID).
+		Find(&deployments).Error; err != nil {
+		log.Printf("⚠️  Failed to list resources of project %d: %v", projectID, err)
+		return
+	}
+	for _, cluster := range k8sClients.Names() {
+		inUse := make(map[string]bool)
+		for _, d := range deployments {
+			if d.Cluster == cluster || (d.Cluster == "" && cluster == k8sClients.Default()) {
+				inUse[d.K8sDeploymentName] = true
+			}
+		}
+		pruned, err := k8sClients.Client(cluster).PruneSecrets(ctx, projectID, inUse)
+		if err != nil {
+			log.Printf("⚠️  Failed to prune env secrets of project %d in cluster %s: %v", projectID, cluster, err)
+		}
+		if pruned > 0 {
+			log.Printf("🧹 Pruned %d env secrets of project %d in cluster %s", pruned, projectID, cluster)
+		}
+	}
+}
+
+// ownedDeployment loads the :id deployment with its project, writing an error
+// response and returning false if it doesn't exist or belongs to someone else
+func ownedDeployment(c *gin.Context) (*models.Deployment, bool) {
+	deploymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deployment ID"})
+		return nil, false
+	}
+	var deployment models.Deployment
+	if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return nil, false
+	}
+	if deployment.Project.UserID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	return &deployment, true
+}
+
+// GetDeploymentHistory returns the status transitions of a deployment, oldest first
+func GetDeploymentHistory(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	deploymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deployment ID"})
+		return
+	}
+
+	var deployment models.Deployment
+	if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if deployment.Project.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var events []models.DeploymentEvent
+	if err := database.DB.Where("deployment_id = ?", deployment.ID).Order("created_at ASC, id ASC").Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deployment history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id": deployment.ID,
+		"status":        deployment.Status,
+		"events":        events,
+	})
+}
+
+// fullURL is the URL a hostname is served on, "" without one
+func fullURL(host string) string {
+	if hostnameMgr == nil {
+		return ""
+	}
+	return hostnameMgr.GetFullURL(host)
+}
+
+// heartbeatAge returns seconds since the build last heartbeat, nil if it never did
+func heartbeatAge(b *models.Build) *int64 {
+	if b.LastHeartbeatAt == nil {
+		return nil
+	}
+	age := int64(time.Since(*b.LastHeartbeatAt).Seconds())
+	return &age
+}
+
+// projectDeploymentsShown is how many of its newest deployments are listed
+// with each project
+const projectDeploymentsShown = 10
+
+// GetProjects returns the authenticated user's projects: active ones, or
+// archived ones with ?state=archived, or both with ?state=all, each with its
+// newest deployments and the one to link as live
 func GetProjects(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
+	query := database.DB.Where("user_id = ?", userID)
+	switch c.DefaultQuery("state", "active") {
+	case "active":
+		query = query.Where("archived_at IS NULL")
+	case "archived":
+		query = query.Where("archived_at IS NOT NULL")
+	case "all":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be active, archived or all"})
+		return
+	}
 	var projects []models.Project
-	if err := database.DB.Where("user_id = ?", userID).
-		Preload("Deployments", func(db *gorm.DB) *gorm.DB {
-			return db.Order("created_at DESC").Limit(10)
-		}).
-		Order("created_at DESC").
-		Find(&projects).Error; err != nil {
+	if err := query.Order("created_at DESC").Find(&projects).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
 		return
 	}
 
-	// Keep only the latest deployment with hostname for each project (for "Live" link)
+	latest, err := latestDeployments(userID, projectDeploymentsShown)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deployments"})
+		return
+	}
 	for i := range projects {
-		// Find latest deployment with hostname
-		var latestDeployment models.Deployment
-		result := database.DB.Where("project_id = ? AND hostname != ? AND hostname != ''", projects[i].ID, "").
-			Order("created_at DESC").
-			First(&latestDeployment)
-
-		// Replace deployments array with just the latest one (if found)
-		if result.Error == nil && latestDeployment.ID > 0 {
-			projects[i].Deployments = []models.Deployment{latestDeployment}
-		} else {
-			// If no deployment with hostname, keep the latest deployment from preload
-			if len(projects[i].Deployments) > 0 {
-				projects[i].Deployments = []models.Deployment{projects[i].Deployments[0]}
-			} else {
-				projects[i].Deployments = []models.Deployment{} // Empty array instead of nil
+		projects[i].Deployments = []models.Deployment{} // Empty array instead of nil
+		if projects[i].Internal() && !projects[i].Worker() {
+			projects[i].InternalURL = kubernetes.ServiceURL(kubernetes.ProjectResourceName(projects[i].ID))
+		}
+		if shown, ok := latest[projects[i].ID]; ok {
+			if !projects[i].Archived() {
+				shown.Live.URL = fullURL(shown.Live.Hostname) // Archived projects serve nothing
 			}
+			projects[i].LatestDeployment = shown.Live
+			projects[i].Deployments = shown.Recent
 		}
 	}
 
-	c.JSON(http.StatusOK, projects)
+	response, err := sparseFields(c, projects)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// projectDeployments are the deployments shown with a project
+type projectDeployments struct {
+	Live   *models.Deployment  // The newest live-linkable one, else the newest
+	Recent []models.Deployment // The newest ones, newest first
+}
+
+// rankedDeployment is a deployment ranked among its project's, see
+// latestDeployments
+type rankedDeployment struct {
+	models.Deployment
+	RecentRank int
+	LiveRank   int
+}
+
+// latestDeployments returns the deployments to show for each of the user's
+// projects in one query: the n newest, and the one to link as "Live", the
+// newest one with a hostname on the production branch or promoted to
+// production, else the newest one of any kind; previews never count as live.
+// ROW_NUMBER() is supported by both Postgres and SQLite (3.25+).
+func latestDeployments(userID uint, n int) (map[uint]*projectDeployments, error) {
+	var ranked []rankedDeployment
+	err := database.DB.Raw(`
+		SELECT * FROM (
+			SELECT deployments.*,
+				ROW_NUMBER() OVER (
+					PARTITION BY deployments.project_id
+					ORDER BY deployments.created_at DESC, deployments.id DESC
+				) AS recent_rank,
+				ROW_NUMBER() OVER (
+					PARTITION BY deployments.project_id
+					ORDER BY
+						CASE WHEN deployments.hostname <> '' AND COALESCE(deployments.target, '') <> 'preview'
+							AND (deployments.target = 'production' OR deployments.branch = projects.branch OR deployments.branch = '') THEN 0 ELSE 1 END,
+						deployments.created_at DESC,
+						deployments.id DESC
+				) AS live_rank
+			FROM deployments
+			JOIN projects ON projects.id = deployments.project_id
+			WHERE projects.user_id = ?
+		) ranked
+		WHERE recent_rank <= ? OR live_rank = 1
+		ORDER BY project_id, recent_rank`, userID, n).Scan(&ranked).Error
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[uint]*projectDeployments)
+	for i := range ranked {
+		d := &ranked[i]
+		project := latest[d.ProjectID]
+		if project == nil {
+			project = &projectDeployments{Recent: []models.Deployment{}}
+			latest[d.ProjectID] = project
+		}
+		if d.LiveRank == 1 {
+			live := d.Deployment
+			project.Live = &live
+		}
+		if d.RecentRank <= n {
+			project.Recent = append(project.Recent, d.Deployment)
+		}
+	}
+	return latest, nil
 }
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/logger"
+)
+
+// statementCounter is a GORM logger counting the statements run
+type statementCounter struct {
+	logger.Interface
+	statements atomic.Int32
+}
+
+func (s *statementCounter) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	s.statements.Add(1)
+}
+
+// With 11 projects or more a global LIMIT left some without deployments:
+// each of 15 projects with 12 deployments, interleaved in time, gets its
+// own newest ones and its live one, in one query
+func TestGetProjectsLatestDeployments(t *testing.T) {
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	other := &models.User{Username: "bob", Email: "bob@example.com"}
+	database.DB.Create(other)
+	database.DB.Create(&models.Project{Name: "bobs", Slug: "bobs", UserID: other.ID, Branch: "main"})
+
+	const projects, perProject = 15, 12
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	var ids []uint
+	for p := 0; p < projects; p++ {
+		project := &models.Project{Name: fmt.Sprintf("app-%d", p), Slug: fmt.Sprintf("app-%d", p), UserID: user.ID, Branch: "main"}
+		if err := database.DB.Create(project).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, project.ID)
+	}
+	for k := 0; k < perProject; k++ {
+		for p, id := range ids {
+			// The 4 newest of each project aren't rolled out yet: live is the 8th
+			deployment := &models.Deployment{ProjectID: id, Status: models.StatusDeployed, Branch: "main", CommitMsg: fmt.Sprintf("%d/%d", p, k),
+				CreatedAt: base.Add(time.Duration(k*projects+p) * time.Minute)}
+			if k < 8 {
+				deployment.Hostname = fmt.Sprintf("app-%d-%d.example.com", p, k)
+			}
+			if err := database.DB.Create(deployment).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	counter := &statementCounter{Interface: logger.Discard}
+	database.DB.Logger = counter
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/projects", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		GetProjects(c)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/projects", nil))
+	database.DB.Logger = logger.Discard
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	// The projects, and the deployments of all of them
+	if n := counter.statements.Load(); n != 2 {
+		t.Fatalf("ran %d statements, want 2", n)
+	}
+
+	var got []models.Project
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != projects {
+		t.Fatalf("got %d projects", len(got))
+	}
+	for _, project := range got {
+		var p int
+		fmt.Sscanf(project.Name, "app-%d", &p)
+		if len(project.Deployments) != projectDeploymentsShown {
+			t.Fatalf("%s: got %d deployments", project.Name, len(project.Deployments))
+		}
+		for i, deployment := range project.Deployments {
+			if want := fmt.Sprintf("%d/%d", p, perProject-1-i); deployment.ProjectID != project.ID || deployment.CommitMsg != want {
+				t.Fatalf("%s: deployment %d is %q of project %d, want %q", project.Name, i, deployment.CommitMsg, deployment.ProjectID, want)
+			}
+		}
+		live := project.LatestDeployment
+		if want := fmt.Sprintf("%d/7", p); live == nil || live.CommitMsg != want || live.Hostname == "" {
+			t.Fatalf("%s: live deployment %+v, want %q", project.Name, live, want)
+		}
+	}
+}
+
+func TestLatestDeploymentsWithoutLive(t *testing.T) {
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", UserID: user.ID, Branch: "main"}
+	database.DB.Create(project)
+	for _, msg := range []string{"first", "second"} {
+		database.DB.Create(&models.Deployment{ProjectID: project.ID, Status: models.StatusFailed, Branch: "main", CommitMsg: msg})
+	}
+
+	latest, err := latestDeployments(user.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shown := latest[project.ID]
+	if shown == nil || shown.Live.CommitMsg != "second" || len(shown.Recent) != 1 || shown.Recent[0].CommitMsg != "second" {
+		t.Fatalf("got %+v", shown)
+	}
+}
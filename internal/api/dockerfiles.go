@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	dockerfileTemplates *build.Templates
+	buildService        *build.Service
+)
+
+// generateTimeout bounds cloning and detection for a Dockerfile preview
+const generateTimeout = 2 * time.Minute
+
+// dockerfileRevisionsShown is how many past overrides GET returns
+const dockerfileRevisionsShown = 20
+
+// InitDockerfileTemplates sets the templates previewed by the admin endpoints
+func InitDockerfileTemplates(t *build.Templates) {
+	dockerfileTemplates = t
+}
+
+// InitBuildService sets the build service generating Dockerfile previews
+func InitBuildService(s *build.Service) {
+	buildService = s
+}
+
+// GetDockerfileTemplates lists the detectors a Dockerfile can be generated for
+func GetDockerfileTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"detectors": build.Detectors})
+}
+
+// PreviewDockerfileTemplate renders a detector's template with the platform
+// variables. Detected values default to what an empty repository would get
+// and can be overridden with query parameters (node_version, port, ...).
+func PreviewDockerfileTemplate(c *gin.Context) {
+	if dockerfileTemplates == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Dockerfile templates not loaded"})
+		return
+	}
+
+	detector := c.Param("detector")
+	data := build.DefaultDockerfileData(detector)
+	if err := c.ShouldBindQuery(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dockerfile, err := dockerfileTemplates.Render(detector, data)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"detector":   detector,
+		"data":       data,
+		"dockerfile": dockerfile,
+	})
+}
+
+// DockerfileOverrideRequest saves a project's Dockerfile override
+type DockerfileOverrideRequest struct {
+	Dockerfile string `json:"dockerfile"` // Empty removes the override
+	Force      bool   `json:"force"`      // Use it even when the repository has a Dockerfile
+}
+
+// GetProjectDockerfile shows the Dockerfile the project's next build would
+// use: the one the platform generates for the latest commit (or finds in the
+// repository), the project's override if any, and its past revisions
+func GetProjectDockerfile(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	override, err := models.DockerfileOverride(database.DB, project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Dockerfile override"})
+		return
+	}
+	var revisions []models.DockerfileRevision
+	if err := database.DB.Where("project_id = ?", project.ID).Order("id DESC").Limit(dockerfileRevisionsShown).Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Dockerfile revisions"})
+		return
+	}
+
+	response := gin.H{
+		"override":  override,
+		"revisions": revisions,
+	}
+	if buildService == nil {
+		response["generated_error"] = "builds are unavailable on this instance"
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), generateTimeout)
+	defer cancel()
+	generated, err := buildService.GenerateDockerfile(ctx, project)
+	if err != nil {
+		log.Printf("⚠️  Failed to generate Dockerfile of project %d: %v", project.ID, err)
+		response["generated_error"] = err.Error()
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	response["generated"] = generated
+
+	// Mirrors detectAndCreateDockerfile: the repository's Dockerfile beats a
+	// non-forced override, unless build commands already replace it
+	effective := generated.Source
+	if override != nil && (override.Force || generated.Source != build.DockerfileSourceRepository || project.BuildCommands.Set()) {
+		effective = build.DockerfileSourceOverride
+	}
+	response["effective"] = effective
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateProjectDockerfile saves a new revision of the project's Dockerfile
+// override, used verbatim by the following builds. It is linted first:
+// parse errors are rejected, warnings returned.
+func UpdateProjectDockerfile(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	var req DockerfileOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Dockerfile) > build.MaxDockerfileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Dockerfile must be at most %d KB", build.MaxDockerfileSize>>10)})
+		return
+	}
+
+	var warnings []string
+	if req.Dockerfile != "" {
+		var err error
+		if warnings, err = build.LintDockerfile(req.Dockerfile); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "warnings": warnings})
+			return
+		}
+	}
+
+	revision := models.DockerfileRevision{
+		ProjectID: project.ID,
+		Content:   req.Dockerfile,
+		Force:     req.Force && req.Dockerfile != "",
+		UserID:    c.GetUint("user_id"),
+	}
+	if err := database.DB.Create(&revision).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save Dockerfile override"})
+		return
+	}
+
+	if revision.Content == "" {
+		audit.FromContext(c, "project.dockerfile", fmt.Sprintf("project %d: override removed (revision %d)", project.ID, revision.ID))
+		c.JSON(http.StatusOK, gin.H{"message": "Dockerfile override removed", "revision": revision})
+		return
+	}
+	audit.FromContext(c, "project.dockerfile", fmt.Sprintf("project %d: override saved (revision %d, force: %t)", project.ID, revision.ID, revision.Force))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Dockerfile override saved, used from the next build",
+		"revision": revision,
+		"warnings": warnings,
+	})
+}
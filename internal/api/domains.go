@@ -0,0 +1,98 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hostname"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/quota"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CustomDomainRequest adds a custom domain to a project
+type CustomDomainRequest struct {
+	Domain string `json:"domain" binding:"required"` // e.g. "www.example.com"
+}
+
+// AddProjectDomain serves a project on a domain of its owner's, e.g.
+// www.example.com pointed at the platform's ingress with a CNAME. Punycode,
+// non-ASCII (homoglyph look-alikes), IP addresses and the platform's own
+// domains are refused with 422, and a domain over the plan's custom domains
+// per project with 402. The domain is routed from the next rollout
+// of the project's production resources and follows them from then on.
+func AddProjectDomain(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	if hostnameMgr == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Hostnames are not configured"})
+		return
+	}
+	var req CustomDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	domain, err := hostnameMgr.CustomDomain(req.Domain)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid domain: " + err.Error()})
+		return
+	}
+
+	var taken int64
+	database.DB.Model(&models.Hostname{}).Where("hostname = ?", domain).Count(&taken)
+	if taken > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The domain is already in use"})
+		return
+	}
+	var count int64
+	database.DB.Model(&models.Hostname{}).Where("project_id = ? AND type = ?", project.ID, hostname.TypeCustom).Count(&count)
+	if err := quota.CheckCustomDomains(project, int(count)); err != nil {
+		if !respondQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check custom domain quota"})
+		}
+		return
+	}
+
+	// Served by the live production deployment, if there is one
+	var production models.Hostname
+	database.DB.Where("project_id = ? AND type = ? AND is_active = ?", project.ID, hostname.TypeProduction, true).Limit(1).Find(&production)
+	record := &models.Hostname{
+		Hostname:     domain,
+		ProjectID:    project.ID,
+		DeploymentID: production.DeploymentID,
+		Type:         hostname.TypeCustom,
+		IsActive:     true,
+	}
+	if err := database.DB.Create(record).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add domain"})
+		return
+	}
+	audit.FromContext(c, "project.domain", fmt.Sprintf("project %d: %s added", project.ID, domain))
+	record.URL = fullURL(domain)
+	c.JSON(http.StatusCreated, record)
+}
+
+// DeleteProjectDomain removes a custom domain of a project; it is no longer
+// routed from the next rollout of the project's production resources
+func DeleteProjectDomain(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	result := database.DB.Where("project_id = ? AND type = ? AND hostname = ?", project.ID, hostname.TypeCustom, c.Param("domain")).Delete(&models.Hostname{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return
+	}
+	audit.FromContext(c, "project.domain", fmt.Sprintf("project %d: %s removed", project.ID, c.Param("domain")))
+	c.JSON(http.StatusOK, gin.H{"message": "Domain deleted"})
+}
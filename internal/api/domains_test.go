@@ -0,0 +1,112 @@
+package api
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hostname"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateSlug(t *testing.T) {
+	tests := []struct {
+		name, slug string
+		valid      bool
+	}{
+		{"My App", "my-app", true},
+		{"my_app--2", "my-app-2", true},
+		{"2048", "2048", true}, // Leading digits are fine in a DNS label
+		{"UPPERCASE", "uppercase", true},
+		{"🚀 Rocket 🚀", "rocket", true},
+		{"🚀🚀", "project", true},
+		{"café", "caf", true},
+		{"api", "api", false}, // Reserved
+		{strings.Repeat("long", 16), strings.Repeat("long", 16), false},
+	}
+	InitHostnameManager(hostname.NewManager(&config.Config{BaseDomain: "deploy.example.com"}))
+	t.Cleanup(func() { InitHostnameManager(nil) })
+	for _, tt := range tests {
+		slug := generateSlug(tt.name)
+		if slug != tt.slug {
+			t.Errorf("generateSlug(%q) = %q, want %q", tt.name, slug, tt.slug)
+		}
+		if err := validateSlug(slug); (err == nil) != tt.valid {
+			t.Errorf("validateSlug(%q) = %v", slug, err)
+		}
+	}
+}
+
+func TestProjectDomains(t *testing.T) {
+	testutil.DB(t)
+	InitHostnameManager(hostname.NewManager(&config.Config{BaseDomain: "deploy.example.com"}))
+	t.Cleanup(func() { InitHostnameManager(nil) })
+	database.DB.Create(&models.Plan{Name: "free", MaxCustomDomains: 2, IsDefault: true})
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", Slug: "app", UserID: user.ID}
+	database.DB.Create(project)
+	live := &models.Deployment{ProjectID: project.ID, Status: models.StatusDeployed}
+	database.DB.Create(live)
+	database.DB.Create(&models.Hostname{Hostname: "app.deploy.example.com", ProjectID: project.ID, DeploymentID: live.ID, Type: hostname.TypeProduction, IsActive: true})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.POST("/projects/:id/domains", AddProjectDomain)
+	r.DELETE("/projects/:id/domains/:domain", DeleteProjectDomain)
+	path := fmt.Sprintf("/projects/%d/domains", project.ID)
+
+	for _, tt := range []struct {
+		domain string
+		code   int
+	}{
+		{"WWW.Example.org", http.StatusCreated},
+		{"www.example.org", http.StatusConflict},
+		{"app.deploy.example.com", http.StatusUnprocessableEntity}, // The platform's
+		{"xn--pple-43d.com", http.StatusUnprocessableEntity},
+		{"\u0430pple.com", http.StatusUnprocessableEntity},
+		{"10.0.0.1", http.StatusUnprocessableEntity},
+		{"example", http.StatusUnprocessableEntity},
+		{"", http.StatusBadRequest},
+	} {
+		w := serveJSON(r, http.MethodPost, path, CustomDomainRequest{Domain: tt.domain})
+		if w.Code != tt.code {
+			t.Errorf("%q: got %d, want %d: %s", tt.domain, w.Code, tt.code, w.Body.String())
+		}
+	}
+
+	var custom models.Hostname
+	if err := database.DB.Where("type = ?", hostname.TypeCustom).First(&custom).Error; err != nil {
+		t.Fatal(err)
+	}
+	if custom.Hostname != "www.example.org" || custom.DeploymentID != live.ID || !custom.IsActive {
+		t.Errorf("added %+v", custom)
+	}
+	var body models.Hostname
+	w := serveJSON(r, http.MethodPost, path, CustomDomainRequest{Domain: "shop.example.org"})
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.URL != "http://shop.example.org" {
+		t.Errorf("added %+v", body)
+	}
+	if domains, err := hostnameMgr.CustomDomains(project.ID); err != nil || strings.Join(domains, " ") != "shop.example.org www.example.org" {
+		t.Errorf("CustomDomains = %v, %v", domains, err)
+	}
+	if w := serveJSON(r, http.MethodPost, path, CustomDomainRequest{Domain: "blog.example.org"}); w.Code != http.StatusPaymentRequired {
+		t.Errorf("a third domain on a plan of 2: got %d", w.Code)
+	}
+
+	if w := serveJSON(r, http.MethodDelete, path+"/www.example.org", nil); w.Code != http.StatusOK {
+		t.Errorf("delete: got %d", w.Code)
+	}
+	// Only custom domains are deleted here
+	if w := serveJSON(r, http.MethodDelete, path+"/app.deploy.example.com", nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleting the production hostname: got %d", w.Code)
+	}
+}
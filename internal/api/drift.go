@@ -0,0 +1,50 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/drift"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var driftReconciler *drift.Reconciler
+
+// InitDriftReconciler sets the sweeps comparing the clusters with the database
+func InitDriftReconciler(r *drift.Reconciler) {
+	driftReconciler = r
+}
+
+// GetDrift returns the report of the last drift sweep: orphaned objects in
+// the clusters and drifted deployments. With ?refresh=true, or before the
+// first sweep, a dry-run sweep runs first; nothing is deleted.
+func GetDrift(c *gin.Context) {
+	if driftReconciler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No Kubernetes cluster is connected"})
+		return
+	}
+	report := driftReconciler.Last()
+	if report == nil || c.Query("refresh") == "true" {
+		report = driftReconciler.Sweep(c.Request.Context(), false)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// CleanupDrift sweeps the clusters now, deleting the orphaned objects older
+// than DRIFT_ORPHAN_MIN_AGE; younger ones are listed, not deleted
+func CleanupDrift(c *gin.Context) {
+	if driftReconciler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No Kubernetes cluster is connected"})
+		return
+	}
+	report := driftReconciler.Sweep(c.Request.Context(), true)
+	deleted := 0
+	for _, orphan := range report.Orphans {
+		if orphan.Deleted {
+			deleted++
+		}
+	}
+	audit.FromContext(c, "drift.cleanup", fmt.Sprintf("%d orphaned objects deleted", deleted))
+	c.JSON(http.StatusOK, report)
+}
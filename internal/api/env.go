@@ -0,0 +1,185 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/quota"
+	"deploy-platform/internal/secretref"
+	"deploy-platform/internal/secrets"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maskedValue stands for the value of a literal env var in responses
+const maskedValue = "********"
+
+var (
+	envKeyPattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	secretResolver *secretref.Resolver
+)
+
+// InitSecretResolver sets the providers env var secret references are
+// checked against
+func InitSecretResolver(r *secretref.Resolver) {
+	secretResolver = r
+}
+
+// EnvVarRequest sets an env var of a project. The value is either a
+// literal, stored encrypted, or a secret reference such as
+// ref+vault://secret/data/app#password, resolved at deploy time.
+type EnvVarRequest struct {
+	Key   string `json:"key" binding:"required"`
+	Value string `json:"value"`
+}
+
+// EnvVarResponse is an env var as shown: literal values are always masked,
+// references aren't secret and are shown as stored
+type EnvVarResponse struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Reference bool      `json:"reference"` // Value is a secret reference
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func envVarResponse(v *models.Environment) EnvVarResponse {
+	if secretref.IsRef(v.Value) {
+		return EnvVarResponse{Key: v.Key, Value: v.Value, Reference: true, UpdatedAt: v.UpdatedAt}
+	}
+	return EnvVarResponse{Key: v.Key, Value: maskedValue, UpdatedAt: v.UpdatedAt}
+}
+
+// envVarChange is an env var as saved, with the request rolling out the
+// live production image again with it, when there is one
+type envVarChange struct {
+	EnvVarResponse
+	Redeploy gin.H `json:"redeploy,omitempty"`
+}
+
+// redeployOffer is the request picking up env var changes without a push:
+// a deploy-only redeploy of the deployment live in production, which rolls
+// out its image again with the project's current env vars. nil when nothing
+// is live in production.
+func redeployOffer(project *models.Project) gin.H {
+	var live models.DeploymentActivation
+	if err := database.DB.Where("project_id = ? AND environment = ? AND deactivated_at IS NULL", project.ID, kubernetes.EnvironmentProduction).
+		Order("activated_at DESC").Limit(1).Find(&live).Error; err != nil || live.ID == 0 || live.ImageTag == "" {
+		return nil
+	}
+	return gin.H{
+		"method": http.MethodPost,
+		"path":   fmt.Sprintf("/api/deployments/%d/redeploy", live.DeploymentID),
+		"body":   gin.H{"deploy_only": true},
+	}
+}
+
+// GetProjectEnv lists a project's env vars
+func GetProjectEnv(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	var vars []models.Environment
+	if err := database.DB.Where("project_id = ?", project.ID).Order("key").Find(&vars).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch env vars"})
+		return
+	}
+	env := make([]EnvVarResponse, 0, len(vars))
+	for i := range vars {
+		env = append(env, envVarResponse(&vars[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"env": env, "secret_providers": secretResolver.Schemes()})
+}
+
+// SetProjectEnv creates or replaces an env var of a project. References
+// must parse and name a provider configured on the platform; they are only
+// resolved when deploying. The next deployment picks the change up, or the
+// redeploy request returned, which needs no push.
+func SetProjectEnv(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	var req EnvVarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !envKeyPattern.MatchString(req.Key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Env var names are letters, digits and underscores, not starting with a digit"})
+		return
+	}
+
+	value := req.Value
+	if secretref.IsRef(value) {
+		if _, err := secretResolver.Validate(value); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		encrypted, err := secrets.Encrypt(value)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt value"})
+			return
+		}
+		value = encrypted
+	}
+
+	var v models.Environment
+	err := database.DB.Where("project_id = ? AND key = ?", project.ID, req.Key).First(&v).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if err := quota.CheckEnvVars(project); err != nil {
+			if !respondQuotaError(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check env var quota"})
+			}
+			return
+		}
+		v = models.Environment{ProjectID: project.ID, Key: req.Key}
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch env vars"})
+		return
+	}
+	v.Value = value
+	if err := database.DB.Save(&v).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save env var"})
+		return
+	}
+	if secretref.IsRef(v.Value) {
+		audit.FromContext(c, "project.env", fmt.Sprintf("project %d: %s set to %s", project.ID, v.Key, v.Value))
+	} else {
+		audit.FromContext(c, "project.env", fmt.Sprintf("project %d: %s set", project.ID, v.Key))
+	}
+	c.JSON(http.StatusOK, envVarChange{EnvVarResponse: envVarResponse(&v), Redeploy: redeployOffer(project)})
+}
+
+// DeleteProjectEnv removes an env var of a project; deployments running
+// keep it until the next one, or the redeploy request returned
+func DeleteProjectEnv(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	result := database.DB.Where("project_id = ? AND key = ?", project.ID, c.Param("key")).Delete(&models.Environment{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete env var"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Env var not found"})
+		return
+	}
+	audit.FromContext(c, "project.env", fmt.Sprintf("project %d: %s removed", project.ID, c.Param("key")))
+	response := gin.H{"message": "Env var deleted"}
+	if redeploy := redeployOffer(project); redeploy != nil {
+		response["redeploy"] = redeploy
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secretref"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envRouter serves the env routes as main registers them, for user
+func envRouter(t *testing.T, user *models.User) *gin.Engine {
+	t.Helper()
+	if err := secrets.Init(&config.Config{EncryptionKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.GET("/projects/:id/env", GetProjectEnv)
+	r.POST("/projects/:id/env", SetProjectEnv)
+	r.PUT("/projects/:id/env", SetProjectEnv)
+	r.DELETE("/projects/:id/env/:key", DeleteProjectEnv)
+	return r
+}
+
+func serveJSON(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var raw []byte
+	if body != nil {
+		raw, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+	return w
+}
+
+func TestProjectEnv(t *testing.T) {
+	testutil.DB(t)
+	database.DB.Create(&models.Plan{Name: "free", MaxEnvVars: 2, IsDefault: true})
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", Slug: "app", UserID: user.ID}
+	database.DB.Create(project)
+	r := envRouter(t, user)
+	path := fmt.Sprintf("/projects/%d/env", project.ID)
+
+	// POST creates, PUT is its alias and replaces
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		w := serveJSON(r, method, path, EnvVarRequest{Key: "API_KEY", Value: "s3cret-" + method})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", method, w.Code, w.Body.String())
+		}
+		var change envVarChange
+		json.Unmarshal(w.Body.Bytes(), &change)
+		if change.Key != "API_KEY" || change.Value != maskedValue || change.Reference {
+			t.Errorf("%s: got %+v", method, change)
+		}
+	}
+	var vars []models.Environment
+	database.DB.Where("project_id = ?", project.ID).Find(&vars)
+	if len(vars) != 1 || !secrets.IsEncrypted(vars[0].Value) {
+		t.Fatalf("stored %+v", vars)
+	}
+	if value, err := secrets.Decrypt(vars[0].Value); err != nil || value != "s3cret-PUT" {
+		t.Errorf("stored %q, %v", value, err)
+	}
+
+	for _, tt := range []struct {
+		req  EnvVarRequest
+		code int
+	}{
+		{EnvVarRequest{Key: "1ST"}, http.StatusBadRequest},
+		{EnvVarRequest{Key: "DB_PASSWORD", Value: "ref+vault://secret/data/app#password"}, http.StatusUnprocessableEntity}, // No provider configured
+		{EnvVarRequest{Key: "PORT", Value: "8080"}, http.StatusOK},
+		{EnvVarRequest{Key: "EXTRA", Value: "x"}, http.StatusPaymentRequired}, // Over the plan's 2
+	} {
+		if w := serveJSON(r, http.MethodPost, path, tt.req); w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d: %s", tt.req.Key, w.Code, tt.code, w.Body.String())
+		}
+	}
+
+	w := serveJSON(r, http.MethodGet, path, nil)
+	var listed struct {
+		Env []EnvVarResponse `json:"env"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Env) != 2 || listed.Env[0].Key != "API_KEY" || listed.Env[1].Value != maskedValue {
+		t.Errorf("listed %+v", listed.Env)
+	}
+
+	if w := serveJSON(r, http.MethodDelete, path+"/PORT", nil); w.Code != http.StatusOK {
+		t.Errorf("delete: got %d", w.Code)
+	}
+	if w := serveJSON(r, http.MethodDelete, path+"/PORT", nil); w.Code != http.StatusNotFound {
+		t.Errorf("delete again: got %d", w.Code)
+	}
+
+	other := &models.User{Username: "bob", Email: "bob@example.com"}
+	database.DB.Create(other)
+	if w := serveJSON(envRouter(t, other), http.MethodPost, path, EnvVarRequest{Key: "X"}); w.Code != http.StatusForbidden {
+		t.Errorf("another user's project: got %d", w.Code)
+	}
+}
+
+// staticSecrets resolves every reference, for the providers to be configured
+type staticSecrets struct{}
+
+func (staticSecrets) Resolve(ctx context.Context, ref secretref.Ref) (string, error) {
+	return "hunter2", nil
+}
+
+// References are stored as sent and shown unmasked: they aren't secret
+func TestProjectEnvReferences(t *testing.T) {
+	testutil.DB(t)
+	database.DB.Create(&models.Plan{Name: "free", MaxEnvVars: 2, IsDefault: true})
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", Slug: "app", UserID: user.ID}
+	database.DB.Create(project)
+	resolver := secretref.NewResolver()
+	resolver.Register("vault", staticSecrets{})
+	InitSecretResolver(resolver)
+	t.Cleanup(func() { InitSecretResolver(nil) })
+	r := envRouter(t, user)
+	path := fmt.Sprintf("/projects/%d/env", project.ID)
+
+	reference := "ref+vault://secret/data/app#password"
+	w := serveJSON(r, http.MethodPut, path, EnvVarRequest{Key: "DB_PASSWORD", Value: reference})
+	var change envVarChange
+	json.Unmarshal(w.Body.Bytes(), &change)
+	if w.Code != http.StatusOK || change.Value != reference || !change.Reference {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var stored models.Environment
+	database.DB.Where("project_id = ? AND key = ?", project.ID, "DB_PASSWORD").First(&stored)
+	if stored.Value != reference {
+		t.Errorf("stored %q", stored.Value)
+	}
+
+	w = serveJSON(r, http.MethodGet, path, nil)
+	var listed struct {
+		Env []EnvVarResponse `json:"env"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Env) != 1 || listed.Env[0].Value != reference || !listed.Env[0].Reference {
+		t.Errorf("listed %+v", listed.Env)
+	}
+
+	for _, value := range []string{"ref+awssm://prod/app", "ref+vault://#password"} {
+		if w := serveJSON(r, http.MethodPut, path, EnvVarRequest{Key: "OTHER", Value: value}); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got %d", value, w.Code)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExecRequest runs a one-off command in a project's image
+type ExecRequest struct {
+	Command string `json:"command" binding:"required"` // Run by /bin/sh -c, e.g. "node scripts/seed.js"
+}
+
+const (
+	maxExecCommand  = 4096
+	execAuditOutput = 4 << 10 // Bytes of output kept in the audit log: the end of it
+)
+
+var execEnabled bool
+
+// InitExec enables one-off commands; they are refused when disabled
+func InitExec(enabled bool) {
+	execEnabled = enabled
+}
+
+// RunProjectCommand runs a one-off command, e.g. a seed script, as a
+// Kubernetes Job in the image of the project's live production deployment,
+// with its env and network identity. The output streams back as server-sent
+// events: "started" with the Job's name, "output" events with chunks of
+// stdout and stderr combined, then "exit" with the exit code (-1 and a
+// reason when the command was killed, e.g. on timeout), or "error" when it
+// couldn't run. Closing the stream kills the command. Only the project's
+// owner and platform admins may run commands, and each one is recorded in
+// the audit log with the end of its output.
+func RunProjectCommand(c *gin.Context) {
+	if !execEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "One-off commands are disabled on this platform"})
+		return
+	}
+	project, ok := execProject(c)
+	if !ok {
+		return
+	}
+
+	var req ExecRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	command := strings.TrimSpace(req.Command)
+	if command == "" || len(command) > maxExecCommand {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("command must be between 1 and %d bytes", maxExecCommand)})
+		return
+	}
+
+	var live models.Deployment
+	if err := database.DB.Where("project_id = ? AND k8s_deployment_name = ? AND status = ?", project.ID, kubernetes.ProjectResourceName(project.ID), models.StatusDeployed).
+		Order("id DESC").First(&live).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "The project has no live production deployment to run the command in"})
+		return
+	}
+	live.Project = *project
+	client := k8sClients.Client(live.Cluster)
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("Cluster %s is not available", live.Cluster)})
+		return
+	}
+
+	details := fmt.Sprintf("project %d, deployment %d: $ %s", project.ID, live.ID, command)
+	name, err := client.StartExec(c.Request.Context(), &live, command)
+	var limitErr *kubernetes.ExecLimitError
+	switch {
+	case errors.As(err, &limitErr):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		audit.FromContext(c, "project.exec", details+"\nrefused: "+err.Error())
+		return
+	case err != nil:
+		log.Printf("❌ Failed to start a command in project %d: %v", project.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start the command: " + err.Error()})
+		audit.FromContext(c, "project.exec", details+"\nfailed: "+err.Error())
+		return
+	}
+	log.Printf("🛠️  Project %d: user %d is running a command as job %s", project.ID, c.GetUint("user_id"), name)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Or nginx holds the output back
+	c.SSEvent("started", gin.H{"job": name, "timeout_seconds": int(kubernetes.ExecTimeout().Seconds())})
+	c.Writer.Flush()
+
+	stream := &execStream{c: c}
+	ctx, cancel := streamContext(c)
+	defer cancel()
+	result, err := client.FollowExec(ctx, name, stream)
+	if errors.Is(context.Cause(ctx), errShuttingDown) {
+		err = errShuttingDown // The command goes on, its job is kept
+	}
+	details += " (job " + name + ")\n"
+	if err != nil {
+		log.Printf("⚠️  Command %s of project %d: %v", name, project.ID, err)
+		c.SSEvent("error", gin.H{"error": err.Error()})
+		details += "failed: " + err.Error()
+	} else {
+		c.SSEvent("exit", result)
+		details += "exit code " + strconv.Itoa(result.ExitCode)
+		if result.Reason != "" {
+			details += " (" + result.Reason + ")"
+		}
+	}
+	c.Writer.Flush()
+	if stream.truncated {
+		details += "\noutput (end):\n…"
+	} else {
+		details += "\noutput:\n"
+	}
+	audit.FromContext(c, "project.exec", details+string(stream.tail))
+}
+
+// execProject loads the :id project for its owner or a platform admin,
+// writing an error response and returning false for anyone else
+func execProject(c *gin.Context) (*models.Project, bool) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return nil, false
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return nil, false
+	}
+	if project.UserID != c.GetUint("user_id") {
+		var user models.User
+		if err := database.DB.First(&user, c.GetUint("user_id")).Error; err != nil || !auth.IsAdmin(&user) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return nil, false
+		}
+	}
+	return &project, true
+}
+
+// execStream sends a command's output as "output" events as it comes,
+// keeping the last execAuditOutput bytes for the audit log
+type execStream struct {
+	c         *gin.Context
+	tail      []byte
+	truncated bool
+}
+
+func (s *execStream) Write(p []byte) (int, error) {
+	s.c.SSEvent("output", gin.H{"data": string(p)})
+	s.c.Writer.Flush()
+	s.tail = append(s.tail, p...)
+	if len(s.tail) > execAuditOutput {
+		s.tail = append(s.tail[:0], s.tail[len(s.tail)-execAuditOutput:]...)
+		s.truncated = true
+	}
+	return len(p), nil
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/jsonstream"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Export formats
+const (
+	ExportCSV  = "csv"
+	ExportJSON = "json" // Newline-delimited, one deployment per line
+)
+
+const (
+	exportMaxRange = 93 * 24 * time.Hour // A quarter; longer ranges are exported a window at a time
+	exportDay      = "2006-01-02"
+)
+
+// exportColumns is the CSV header, in ExportedDeployment order
+var exportColumns = []string{
+	"id", "project", "environment", "status", "commit_sha", "commit_msg", "branch", "ref", "trigger", "actor",
+	"cluster", "hostname", "created_at", "finished_at", "duration_seconds", "live_from", "live_until",
+	"failure_category", "events",
+}
+
+// ExportedDeployment is a deployment as exported for compliance reports:
+// what was deployed where, when and by whom, and when it actually served
+// traffic according to its status history
+type ExportedDeployment struct {
+	ID              uint                     `json:"id"`
+	Project         string                   `json:"project"` // Slug
+	Environment     string                   `json:"environment"`
+	Status          string                   `json:"status"`
+	CommitSHA       string                   `json:"commit_sha"`
+	CommitMsg       string                   `json:"commit_msg"`
+	Branch          string                   `json:"branch"`
+	Ref             string                   `json:"ref,omitempty"`
+	Trigger         string                   `json:"trigger"`
+	Actor           string                   `json:"actor"`
+	Cluster         string                   `json:"cluster,omitempty"`
+	Hostname        string                   `json:"hostname"`
+	CreatedAt       time.Time                `json:"created_at"`
+	FinishedAt      *time.Time               `json:"finished_at"`      // When it reached a final status
+	DurationSeconds *int64                   `json:"duration_seconds"` // From creation to FinishedAt
+	LiveFrom        *time.Time               `json:"live_from"`        // When it started serving, nil if it never did
+	LiveUntil       *time.Time               `json:"live_until"`       // When a later deployment replaced it, nil while live
+	FailureCategory string                   `json:"failure_category,omitempty"`
+	Events          []models.DeploymentEvent `json:"events"` // Status history, oldest first
+}
+
+// ExportProjectDeployments streams the project's deployments created in
+// [from, to) as CSV or newline-delimited JSON, see exportDeployments
+func ExportProjectDeployments(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	exportDeployments(c, project.Slug, func(db *gorm.DB) *gorm.DB {
+		return db.Where("deployments.project_id = ?", project.ID)
+	})
+}
+
+// ExportAllDeployments is ExportProjectDeployments over every project of the
+// platform, for admins
+func ExportAllDeployments(c *gin.Context) {
+	exportDeployments(c, "all", func(db *gorm.DB) *gorm.DB { return db })
+}
+
+// exportDeployments streams the deployments of scope created in [from, to)
+// oldest first, in batches so large histories are never held in memory.
+// from and to are RFC 3339 times or dates (to's day included), by default
+// the last exportMaxRange. Longer ranges are cut to their first
+// exportMaxRange, with a Link header to the next window.
+func exportDeployments(c *gin.Context, name string, scope func(*gorm.DB) *gorm.DB) {
+	format := c.DefaultQuery("format", ExportCSV)
+	if format != ExportCSV && format != ExportJSON {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+	from, to, err := exportRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if to.Sub(from) > exportMaxRange {
+		next := c.Request.URL.Query()
+		next.Set("from", from.Add(exportMaxRange).Format(time.RFC3339))
+		next.Set("to", to.Format(time.RFC3339))
+		c.Header("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, c.Request.URL.Path, next.Encode()))
+		to = from.Add(exportMaxRange)
+	}
+
+	var encoder jsonstream.Encoder = &csvEncoder{}
+	extension := "csv"
+	if format == ExportJSON {
+		encoder, extension = jsonstream.NDJSON(), "ndjson"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="deployments-%s-%s-%s.%s"`, name, from.Format(exportDay), to.Format(exportDay), extension))
+	err = jsonstream.Stream(c, "the deployments of "+name, encoder, func(ctx context.Context, afterID uint) ([]interface{}, uint, error) {
+		var batch []models.Deployment
+		err := scope(database.DB.WithContext(ctx)).
+			Preload("Project", func(db *gorm.DB) *gorm.DB { return db.Select("id", "slug", "branch") }).
+			Where("deployments.created_at >= ? AND deployments.created_at < ? AND deployments.id > ?", from, to, afterID).
+			Order("deployments.id").Limit(jsonstream.BatchSize).Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return nil, 0, err
+		}
+		rows, err := exportRows(batch)
+		if err != nil {
+			return nil, 0, err
+		}
+		out := make([]interface{}, len(rows))
+		for i := range rows {
+			out[i] = &rows[i]
+		}
+		return out, batch[len(batch)-1].ID, nil
+	})
+	if err != nil {
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deployments"})
+		return
+	}
+	audit.FromContext(c, "deployment.export", fmt.Sprintf("%s deployments %s to %s as %s", name, from.Format(time.RFC3339), to.Format(time.RFC3339), format))
+}
+
+// csvEncoder frames exported deployments as CSV with an exportColumns header
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (e *csvEncoder) Begin(w io.Writer) error {
+	e.writer = csv.NewWriter(w)
+	return e.writer.Write(exportColumns)
+}
+
+func (e *csvEncoder) Row(w io.Writer, index int, row interface{}) error {
+	return e.writer.Write(row.(*ExportedDeployment).csvRecord())
+}
+
+func (e *csvEncoder) Flush() {
+	e.writer.Flush()
+}
+
+func (e *csvEncoder) End(w io.Writer) error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// exportRange parses the from and to query parameters, defaulting to the
+// exportMaxRange up to now
+func exportRange(fromParam, toParam string) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if toParam != "" {
+		t, day, err := parseExportTime(toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %v", err)
+		}
+		to = t
+		if day {
+			to = to.AddDate(0, 0, 1) // The whole day is included
+		}
+	}
+	from := to.Add(-exportMaxRange)
+	if fromParam != "" {
+		t, _, err := parseExportTime(fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %v", err)
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// parseExportTime parses an RFC 3339 time or a date (UTC), reporting which it was
+func parseExportTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(exportDay, value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is neither a date (YYYY-MM-DD) nor an RFC 3339 time", value)
+	}
+	return t, false, nil
+}
+
+// exportRows adds the status history of a batch of deployments, and when
+// each served traffic: from its first deployed transition until another
+// deployment of the same resources reached deployed after it
+func exportRows(batch []models.Deployment) ([]ExportedDeployment, error) {
+	ids := make([]uint, len(batch))
+	for i := range batch {
+		ids[i] = batch[i].ID
+	}
+	var events []models.DeploymentEvent
+	if err := database.DB.Where("deployment_id IN ?", ids).Order("created_at ASC, id ASC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	history := make(map[uint][]models.DeploymentEvent, len(batch))
+	for _, event := range events {
+		history[event.DeploymentID] = append(history[event.DeploymentID], event)
+	}
+
+	rows := make([]ExportedDeployment, len(batch))
+	for i := range batch {
+		d := &batch[i]
+		row := ExportedDeployment{
+			ID:              d.ID,
+			Project:         d.Project.Slug,
+			Environment:     exportEnvironment(d),
+			Status:          string(d.Status),
+			CommitSHA:       d.CommitSHA,
+			CommitMsg:       d.CommitMsg,
+			Branch:          d.Branch,
+			Ref:             d.Ref,
+			Trigger:         d.Trigger,
+			Actor:           d.Actor,
+			Cluster:         d.Cluster,
+			Hostname:        d.Hostname,
+			CreatedAt:       d.CreatedAt,
+			FailureCategory: d.FailureCategory,
+			Events:          history[d.ID],
+		}
+		if row.Events == nil {
+			row.Events = []models.DeploymentEvent{}
+		}
+		for j := range row.Events {
+			event := &row.Events[j]
+			if event.ToStatus == models.StatusDeployed && row.LiveFrom == nil {
+				row.LiveFrom = &event.CreatedAt
+			}
+			if event.ToStatus.Terminal() {
+				row.FinishedAt = &event.CreatedAt
+			}
+		}
+		if row.FinishedAt != nil {
+			duration := int64(row.FinishedAt.Sub(d.CreatedAt).Seconds())
+			row.DurationSeconds = &duration
+		}
+		if row.LiveFrom != nil && d.K8sDeploymentName != "" {
+			var next models.DeploymentEvent
+			err := database.DB.Joins("JOIN deployments ON deployments.id = deployment_events.deployment_id").
+				Where("deployments.project_id = ? AND deployments.k8s_deployment_name = ? AND deployments.id <> ?", d.ProjectID, d.K8sDeploymentName, d.ID).
+				Where("deployment_events.to_status = ? AND deployment_events.created_at > ?", models.StatusDeployed, *row.LiveFrom).
+				Order("deployment_events.created_at ASC").First(&next).Error
+			if err == nil {
+				row.LiveUntil = &next.CreatedAt
+			}
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// exportEnvironment is the environment a deployment went (or was going) to;
+// deployments that never reached the cluster are placed by target and branch
+func exportEnvironment(d *models.Deployment) string {
+	switch {
+	case d.K8sDeploymentName != "":
+		return kubernetes.Environment(d)
+	case d.Target == models.TargetPreview:
+		return kubernetes.EnvironmentPreview
+	case d.Target == models.TargetProduction || d.Branch == "" || d.Branch == d.Project.Branch:
+		return kubernetes.EnvironmentProduction
+	}
+	return kubernetes.EnvironmentBranch
+}
+
+// csvRecord is the row's CSV record in exportColumns order. Its events are
+// one cell, "<time> <status> (<reason>)" joined by "; ".
+func (row *ExportedDeployment) csvRecord() []string {
+	events := make([]string, len(row.Events))
+	for i, event := range row.Events {
+		events[i] = event.CreatedAt.UTC().Format(time.RFC3339) + " " + string(event.ToStatus)
+		if event.Reason != "" {
+			events[i] += " (" + event.Reason + ")"
+		}
+	}
+	duration := ""
+	if row.DurationSeconds != nil {
+		duration = strconv.FormatInt(*row.DurationSeconds, 10)
+	}
+	record := []string{
+		strconv.FormatUint(uint64(row.ID), 10), row.Project, row.Environment, row.Status, row.CommitSHA, row.CommitMsg,
+		row.Branch, row.Ref, row.Trigger, row.Actor, row.Cluster, row.Hostname, exportTime(&row.CreatedAt),
+		exportTime(row.FinishedAt), duration, exportTime(row.LiveFrom), exportTime(row.LiveUntil), row.FailureCategory,
+		strings.Join(events, "; "),
+	}
+	for i := range record {
+		record[i] = csvSafe(record[i])
+	}
+	return record
+}
+
+// exportTime formats a CSV time cell, "" for nil
+func exportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvSafe neutralizes cells a spreadsheet would run as a formula (commit
+// messages and branch names are attacker-controlled) by prefixing a quote,
+// after cleaning them of invalid UTF-8 and control characters
+func csvSafe(cell string) string {
+	cell = textutil.Clean(cell)
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProjectHostnames lists a project's hostnames: the production hostname
+// and one alias per branch (type "alias", with the branch it follows)
+func GetProjectHostnames(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if project.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var hostnames []models.Hostname
+	if err := database.DB.Where("project_id = ?", project.ID).
+		Order("type DESC, is_active DESC, branch").
+		Find(&hostnames).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch hostnames"})
+		return
+	}
+
+	for i := range hostnames {
+		hostnames[i].URL = fullURL(hostnames[i].Hostname)
+	}
+
+	c.JSON(http.StatusOK, hostnames)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	statusOperational = "operational"
+	statusDegraded    = "degraded"
+
+	resolvedIncidentsShown = 24 * time.Hour // How long resolved incidents stay on the platform status
+)
+
+var incidentMonitor *incidents.Monitor
+
+// InitIncidentMonitor sets the health checks recording platform incidents
+func InitIncidentMonitor(m *incidents.Monitor) {
+	incidentMonitor = m
+}
+
+// PublicIncident is an incident as users see it: what it means for them and
+// what admins said about it, not the error behind it
+type PublicIncident struct {
+	ID        uint       `json:"id"`
+	Component string     `json:"component"`
+	Summary   string     `json:"summary"`
+	Message   string     `json:"message,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// GetPlatformStatus tells anyone whether the platform is degraded, with its
+// ongoing incidents and those resolved in the last day, newest first. The
+// dashboard shows them as a banner. When the database is the one down, this
+// replica's own incidents are shown.
+func GetPlatformStatus(c *gin.Context) {
+	var recorded []models.Incident
+	err := database.DB.Where("ended_at IS NULL OR ended_at > ?", time.Now().Add(-resolvedIncidentsShown)).
+		Order("started_at DESC").
+		Limit(50).
+		Find(&recorded).Error
+	if err != nil {
+		recorded = incidentMonitor.Open()
+		sort.Slice(recorded, func(i, j int) bool { return recorded[i].StartedAt.After(recorded[j].StartedAt) })
+	}
+
+	status := statusOperational
+	public := make([]PublicIncident, 0, len(recorded))
+	for _, incident := range recorded {
+		if incident.EndedAt == nil {
+			status = statusDegraded
+		}
+		public = append(public, PublicIncident{
+			ID:        incident.ID,
+			Component: incident.Component,
+			Summary:   incidents.Summary(incident.Component),
+			Message:   incident.Message,
+			StartedAt: incident.StartedAt,
+			EndedAt:   incident.EndedAt,
+		})
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"status": status, "incidents": public})
+}
+
+// GetIncidents lists platform incidents for admins, newest first, with the
+// errors that opened them
+func GetIncidents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+	var list []models.Incident
+	if err := database.DB.Order("started_at DESC").Limit(limit).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch incidents"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"incidents": list})
+}
+
+// UpdateIncidentRequest annotates an incident. Resolved closes one the health
+// checks can't, e.g. of a replica that is gone.
+type UpdateIncidentRequest struct {
+	Message  *string `json:"message" binding:"omitempty,max=1000"`
+	Resolved bool    `json:"resolved"`
+}
+
+// UpdateIncident sets the message users see with an incident, or resolves it
+func UpdateIncident(c *gin.Context) {
+	incidentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid incident ID"})
+		return
+	}
+	var req UpdateIncidentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var incident models.Incident
+	if err := database.DB.First(&incident, incidentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Incident not found"})
+		return
+	}
+
+	adminID := c.GetUint("user_id")
+	updates := map[string]interface{}{"updated_by": adminID}
+	if req.Message != nil {
+		updates["message"] = *req.Message
+	}
+	if req.Resolved && incident.EndedAt == nil {
+		updates["ended_at"] = time.Now()
+	}
+	if err := database.DB.Model(&incident).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update incident"})
+		return
+	}
+	database.DB.First(&incident, incident.ID)
+	if _, ok := updates["ended_at"]; ok {
+		log.Printf("✅ Incident %d resolved by admin %d", incident.ID, adminID)
+	}
+	audit.FromContext(c, "incident.update", fmt.Sprintf("incident %d (%s): message=%t resolved=%t", incident.ID, incident.Component, req.Message != nil, req.Resolved))
+	c.JSON(http.StatusOK, incident)
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var jobRunner *background.Runner
+
+// InitBackground sets the runner of the background jobs reported by the admin API
+func InitBackground(r *background.Runner) {
+	jobRunner = r
+}
+
+// GetJobs reports which replica leads the background jobs, the jobs this
+// replica runs, and their recent runs across replicas, newest first
+// (?job=name filters them, ?limit=N up to 500, default 100)
+func GetJobs(c *gin.Context) {
+	if jobRunner == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Background jobs are not running on this instance"})
+		return
+	}
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = n
+	}
+
+	query := database.DB.Order("started_at DESC, id DESC").Limit(limit)
+	if job := c.Query("job"); job != "" {
+		query = query.Where("job = ?", job)
+	}
+	var runs []models.JobRun
+	if err := query.Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job runs"})
+		return
+	}
+
+	leader := gin.H{"holder": nil, "expires_at": nil}
+	holder, expires, err := jobRunner.Elector().Holder(c.Request.Context())
+	if err != nil {
+		leader["error"] = err.Error()
+	} else if holder != "" {
+		leader["holder"] = holder
+		leader["expires_at"] = expires.UTC().Format(time.RFC3339)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"instance":  jobRunner.Instance(),
+		"is_leader": jobRunner.Leader(),
+		"leader":    leader,
+		"jobs":      jobRunner.Jobs(),
+		"runs":      runs,
+	})
+}
+
+// GetBackgroundJobs reports the status of every background job registered
+// on this replica: when it last started and succeeded, its last error, what
+// its last run processed, and whether it went stale
+func GetBackgroundJobs(c *gin.Context) {
+	if jobRunner == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Background jobs are not running on this instance"})
+		return
+	}
+	statuses, err := jobRunner.Statuses(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch background job statuses"})
+		return
+	}
+	stale := 0
+	for _, status := range statuses {
+		if status.Stale {
+			stale++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"instance": jobRunner.Instance(),
+		"jobs":     statuses,
+		"stale":    stale,
+	})
+}
+
+// RunBackgroundJob runs a job supporting it on demand, on this replica; the
+// run goes on after the response, follow it in GET /api/admin/jobs
+func RunBackgroundJob(c *gin.Context) {
+	if jobRunner == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Background jobs are not running on this instance"})
+		return
+	}
+	name := c.Param("name")
+	run, err := jobRunner.Trigger(name)
+	switch {
+	case errors.Is(err, background.ErrUnknownJob):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, background.ErrNotManual), errors.Is(err, background.ErrJobRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, background.ErrNotStarted):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the job"})
+		return
+	}
+	audit.FromContext(c, "background_job.run", fmt.Sprintf("%s run on demand on %s", name, run.Instance))
+	c.JSON(http.StatusAccepted, run)
+}
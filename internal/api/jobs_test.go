@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The admin API lists the background jobs with their status, and runs the
+// manual ones on demand
+func TestBackgroundJobs(t *testing.T) {
+	testutil.DB(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/admin/background-jobs", GetBackgroundJobs)
+	r.POST("/admin/background-jobs/:name/run", RunBackgroundJob)
+	t.Cleanup(func() { jobRunner = nil })
+
+	if w := serveJSON(r, http.MethodGet, "/admin/background-jobs", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a runner: got %d", w.Code)
+	}
+
+	var sweeps atomic.Int64
+	runner := background.NewRunner(&config.Config{InstanceID: "api-1", LeaderLeaseDuration: time.Minute, JobRunRetention: time.Hour},
+		database.DB, background.NewDatabaseLease(database.DB, "background-jobs"))
+	runner.Register(
+		background.Job{Name: "workspaces-sweep", Interval: time.Hour, Manual: true, Run: func(ctx context.Context) error {
+			background.Processed(ctx, 4)
+			sweeps.Add(1)
+			return nil
+		}},
+		background.Job{Name: "cost-sampler", Interval: time.Hour, Run: func(context.Context) error { return nil }},
+	)
+	InitBackground(runner)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner.Start(ctx)
+
+	listed := func() map[string]background.JobStatus {
+		t.Helper()
+		w := serveJSON(r, http.MethodGet, "/admin/background-jobs", nil)
+		var body struct {
+			Instance string                 `json:"instance"`
+			Jobs     []background.JobStatus `json:"jobs"`
+			Stale    int                    `json:"stale"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK || body.Instance != "api-1" || body.Stale != 0 {
+			t.Fatalf("got %d: %s", w.Code, w.Body.String())
+		}
+		jobs := map[string]background.JobStatus{}
+		for _, job := range body.Jobs {
+			jobs[job.Name] = job
+		}
+		return jobs
+	}
+	for deadline := time.Now().Add(time.Second); sweeps.Load() == 0 || listed()["workspaces-sweep"].Running; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the scheduled run")
+		}
+	}
+	jobs := listed()
+	if len(jobs) != 4 || !jobs["workspaces-sweep"].Manual || jobs["workspaces-sweep"].StaleAfter != "2h0m0s" {
+		t.Errorf("jobs %+v", jobs)
+	}
+	if status := jobs["workspaces-sweep"].Status; status == nil || status.ItemsProcessed != 4 || status.Runs != 1 {
+		t.Errorf("status %+v", status)
+	}
+
+	for path, want := range map[string]int{
+		"/admin/background-jobs/registry-gc/run":      http.StatusNotFound,
+		"/admin/background-jobs/cost-sampler/run":     http.StatusConflict,
+		"/admin/background-jobs/workspaces-sweep/run": http.StatusAccepted,
+	} {
+		if w := serveJSON(r, http.MethodPost, path, nil); w.Code != want {
+			t.Errorf("%s: got %d, want %d: %s", path, w.Code, want, w.Body.String())
+		}
+	}
+	for deadline := time.Now().Add(time.Second); sweeps.Load() != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the manual run")
+		}
+	}
+	var manual int64
+	database.DB.Model(&models.JobRun{}).Where("job = ? AND manual", "workspaces-sweep").Count(&manual)
+	if manual != 1 {
+		t.Errorf("%d manual runs recorded", manual)
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeploymentSummary is the list representation of a deployment. It leaves out
+// build logs and most of the project, which GET /deployments/:id still returns.
+type DeploymentSummary struct {
+	ID        uint           `json:"id"`
+	ProjectID uint           `json:"project_id"`
+	Status    string         `json:"status"`
+	CommitSHA string         `json:"commit_sha"`
+	CommitMsg string         `json:"commit_msg"`
+	Branch    string         `json:"branch"`
+	Hostname  string         `json:"hostname"`
+	URL       string         `json:"url,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Project   ProjectSummary `json:"project"`
+	Build     *BuildSummary  `json:"build,omitempty"`
+
+	FailureCategory string `json:"failure_category,omitempty"`
+	FailureDetail   string `json:"failure_detail,omitempty"`
+}
+
+// ProjectSummary identifies the project a listed deployment belongs to
+type ProjectSummary struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// BuildSummary is a build without its logs
+type BuildSummary struct {
+	ID               uint   `json:"id"`
+	Status           string `json:"status"`
+	DurationSeconds  *int64 `json:"duration_seconds,omitempty"` // nil until the build completes
+	Framework        string `json:"framework,omitempty"`
+	FrameworkVersion string `json:"framework_version,omitempty"`
+	Runtime          string `json:"runtime,omitempty"`
+	RuntimeVersion   string `json:"runtime_version,omitempty"`
+
+	ImageSizeBytes int64    `json:"image_size_bytes,omitempty"`
+	Warnings       []string `json:"warnings,omitempty"`
+}
+
+func summarizeDeployment(d *models.Deployment) DeploymentSummary {
+	summary := DeploymentSummary{
+		ID:        d.ID,
+		ProjectID: d.ProjectID,
+		Status:    string(d.Status),
+		CommitSHA: d.CommitSHA,
+		CommitMsg: d.CommitMsg,
+		Branch:    d.Branch,
+		Hostname:  d.Hostname,
+		URL:       fullURL(d.Hostname),
+		CreatedAt: d.CreatedAt,
+		UpdatedAt: d.UpdatedAt,
+		Project:   ProjectSummary{ID: d.Project.ID, Name: d.Project.Name, Slug: d.Project.Slug},
+
+		FailureCategory: d.FailureCategory,
+		FailureDetail:   d.FailureDetail,
+	}
+	if d.Build.ID != 0 {
+		summary.Build = &BuildSummary{
+			ID:               d.Build.ID,
+			Status:           d.Build.Status,
+			Framework:        d.Build.Framework,
+			FrameworkVersion: d.Build.FrameworkVersion,
+			Runtime:          d.Build.Runtime,
+			RuntimeVersion:   d.Build.RuntimeVersion,
+			ImageSizeBytes:   d.Build.ImageSizeBytes,
+			Warnings:         d.Build.Warnings,
+		}
+		if d.Build.StartedAt != nil && d.Build.CompletedAt != nil {
+			duration := int64(d.Build.CompletedAt.Sub(*d.Build.StartedAt).Seconds())
+			summary.Build.DurationSeconds = &duration
+		}
+	}
+	return summary
+}
+
+// sparseFields applies the ?fields=a,b,c sparse fieldset to a list response,
+// keeping only the requested top-level keys of each item. Without the
+// parameter items is returned unchanged.
+func sparseFields(c *gin.Context, items interface{}) (interface{}, error) {
+	param := c.Query("fields")
+	if param == "" {
+		return items, nil
+	}
+
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for _, field := range strings.Split(param, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	// Reject unknown fields instead of silently returning empty objects
+	if len(rows) > 0 {
+		for _, field := range fields {
+			if _, ok := rows[0][field]; !ok {
+				return nil, fmt.Errorf("unknown field %q (available: %s)", field, strings.Join(keysOf(rows[0]), ", "))
+			}
+		}
+	}
+
+	result := make([]map[string]json.RawMessage, len(rows))
+	for i, row := range rows {
+		result[i] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := row[field]; ok {
+				result[i][field] = value
+			}
+		}
+	}
+	return result, nil
+}
+
+// sparseRows is sparseFields for a batch of a streamed list, its items one
+// by one
+func sparseRows(c *gin.Context, items interface{}) ([]interface{}, error) {
+	response, err := sparseFields(c, items)
+	if err != nil {
+		return nil, err
+	}
+	list := reflect.ValueOf(response)
+	rows := make([]interface{}, list.Len())
+	for i := range rows {
+		rows[i] = list.Index(i).Interface()
+	}
+	return rows, nil
+}
+
+func keysOf(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"deploy-platform/internal/compress"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/jsonstream"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logLine marks the build logs, which list responses must leave out
+const logLine = "Step 3/9 : RUN npm ci --no-audit\n"
+
+// listingFixture creates 100 deployments of a user, each with a build of
+// 100KB of logs, and a router serving the deployment routes behind gzip
+func listingFixture(t *testing.T) (*gin.Engine, []models.Deployment) {
+	t.Helper()
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", Slug: "app", UserID: user.ID}
+	database.DB.Create(project)
+
+	logs := strings.Repeat(logLine, 100*1024/len(logLine))
+	started := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	completed := started.Add(95 * time.Second)
+	deployments := make([]models.Deployment, 100)
+	for i := range deployments {
+		deployments[i] = models.Deployment{ProjectID: project.ID, Status: models.StatusDeployed, Branch: "main", CommitSHA: fmt.Sprintf("%040d", i), CommitMsg: "Bump dependencies"}
+		if err := database.DB.Create(&deployments[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+		build := &models.Build{DeploymentID: deployments[i].ID, Status: "success", Logs: logs, StartedAt: &started, CompletedAt: &completed}
+		if err := database.DB.Create(build).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(compress.Gzip(1024))
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.GET("/deployments", GetDeployments)
+	r.GET("/deployments/:id", GetDeployment)
+	return r, deployments
+}
+
+// A listing of 100 deployments with long logs stays small: no logs, only a
+// summary of each build
+func TestDeploymentListingBudget(t *testing.T) {
+	r, _ := listingFixture(t)
+	const budget = 64 * 1024
+
+	w := serveJSON(r, http.MethodGet, "/deployments", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.Len() > budget {
+		t.Errorf("listing of 100 deployments is %d bytes, over %d", w.Body.Len(), budget)
+	}
+	if bytes.Contains(w.Body.Bytes(), []byte("npm ci")) || bytes.Contains(w.Body.Bytes(), []byte(`"logs"`)) {
+		t.Error("the listing includes build logs")
+	}
+
+	var summaries []DeploymentSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 100 {
+		t.Fatalf("listed %d deployments", len(summaries))
+	}
+	build := summaries[0].Build
+	if build == nil || build.Status != "success" || build.DurationSeconds == nil || *build.DurationSeconds != 95 {
+		t.Errorf("build summary %+v", build)
+	}
+}
+
+// The single deployment keeps its build's logs
+func TestGetDeploymentKeepsLogs(t *testing.T) {
+	r, deployments := listingFixture(t)
+	w := serveJSON(r, http.MethodGet, fmt.Sprintf("/deployments/%d", deployments[0].ID), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var deployment models.Deployment
+	if err := json.Unmarshal(w.Body.Bytes(), &deployment); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(deployment.Build.Logs, logLine) || len(deployment.Build.Logs) < 100*1000 {
+		t.Errorf("build logs of %d bytes", len(deployment.Build.Logs))
+	}
+}
+
+func TestDeploymentListingFields(t *testing.T) {
+	r, _ := listingFixture(t)
+
+	w := serveJSON(r, http.MethodGet, "/deployments?fields=id,%20status", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 100 {
+		t.Fatalf("listed %d deployments", len(rows))
+	}
+	for _, row := range rows {
+		if len(row) != 2 || row["id"] == nil || row["status"] != string(models.StatusDeployed) {
+			t.Fatalf("row %v", row)
+		}
+	}
+
+	w = serveJSON(r, http.MethodGet, "/deployments?fields=id,logs", nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `unknown field \"logs\"`) {
+		t.Errorf("unknown field: got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// Clients accepting gzip get the listing compressed, and the same JSON
+func TestDeploymentListingGzip(t *testing.T) {
+	r, _ := listingFixture(t)
+	plain := serveJSON(r, http.MethodGet, "/deployments", nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/deployments", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got %d, Content-Encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if w.Body.Len() >= plain.Body.Len()/4 {
+		t.Errorf("compressed to %d bytes from %d", w.Body.Len(), plain.Body.Len())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, plain.Body.Bytes()) {
+		t.Error("the compressed listing differs")
+	}
+}
+
+// Listings longer than a batch are streamed whole, newest first, with no
+// deployment of another user's
+func TestDeploymentListingBatches(t *testing.T) {
+	r, fixture := listingFixture(t)
+	other := &models.Project{Name: "other", Slug: "other", UserID: 99}
+	database.DB.Create(other)
+	more := make([]models.Deployment, 2*jsonstream.BatchSize)
+	for i := range more {
+		more[i] = models.Deployment{ProjectID: fixture[0].ProjectID, Status: models.StatusDeployed, Branch: "main"}
+		if i%10 == 0 {
+			more[i].ProjectID = other.ID
+		}
+	}
+	if err := database.DB.CreateInBatches(more, 200).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveJSON(r, http.MethodGet, "/deployments", nil)
+	var summaries []DeploymentSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatalf("%v: %.200s", err, w.Body.String())
+	}
+	if want := len(fixture) + len(more) - len(more)/10; len(summaries) != want {
+		t.Fatalf("listed %d deployments, want %d", len(summaries), want)
+	}
+	for i, summary := range summaries {
+		if summary.ProjectID != fixture[0].ProjectID {
+			t.Fatalf("listed deployment %d of project %d", summary.ID, summary.ProjectID)
+		}
+		if i > 0 && summary.ID >= summaries[i-1].ID {
+			t.Fatalf("deployment %d listed after %d", summary.ID, summaries[i-1].ID)
+		}
+	}
+}
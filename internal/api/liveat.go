@@ -0,0 +1,47 @@
+package api
+
+import (
+	"deploy-platform/internal/activations"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProjectLiveAt answers what served the project at ?time= (RFC 3339):
+// the deployment live in each environment then, with its commit, image and
+// hostname, and the changes just before and after. ?environment= narrows
+// it to production, branch or preview.
+func GetProjectLiveAt(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	at, err := time.Parse(time.RFC3339, c.Query("time"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time must be an RFC 3339 time, e.g. 2026-03-05T14:32:00Z"})
+		return
+	}
+	environment := c.Query("environment")
+	switch environment {
+	case "", kubernetes.EnvironmentProduction, kubernetes.EnvironmentBranch, kubernetes.EnvironmentPreview:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "environment must be production, branch or preview"})
+		return
+	}
+
+	timeline, err := activations.LiveAt(database.DB, project.ID, at, environment)
+	if err != nil {
+		log.Printf("❌ Failed to reconstruct what project %d ran at %s: %v", project.ID, at.Format(time.RFC3339), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch the deployment history"})
+		return
+	}
+	response := gin.H{"project_id": project.ID, "environment": environment, "timeline": timeline}
+	if timeline.Previous == nil {
+		response["message"] = "Nothing was deployed yet at that time"
+	}
+	c.JSON(http.StatusOK, response)
+}
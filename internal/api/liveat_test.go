@@ -0,0 +1,71 @@
+package api
+
+import (
+	"deploy-platform/internal/activations"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProjectLiveAt(t *testing.T) {
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", Slug: "app", UserID: user.ID}
+	database.DB.Create(project)
+	other := &models.Project{Name: "other", Slug: "other", UserID: user.ID + 1}
+	database.DB.Create(other)
+	live := time.Date(2026, 10, 8, 14, 0, 0, 0, time.UTC)
+	deployment := &models.Deployment{ID: 7, ProjectID: project.ID, CommitSHA: strings.Repeat("c", 40), Target: models.TargetProduction,
+		K8sDeploymentName: fmt.Sprintf("project-%d", project.ID), Hostname: "app.deploy.example.com"}
+	if err := activations.Record(database.DB, deployment, "sha256:"+strings.Repeat("d", 64), live); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/projects/:id/live-at", func(c *gin.Context) { c.Set("user_id", user.ID) }, GetProjectLiveAt)
+	path := func(id uint, query string) string { return fmt.Sprintf("/projects/%d/live-at?%s", id, query) }
+
+	for query, want := range map[string]int{
+		"time=last+Thursday":                                      http.StatusBadRequest,
+		"time=2026-10-08T14:32:00Z&environment=staging":           http.StatusBadRequest,
+		"time=2026-10-08T14:32:00Z&environment=preview":           http.StatusOK,
+		"time=2026-10-08T16:32:00%2B02:00&environment=production": http.StatusOK,
+	} {
+		if w := serveJSON(r, http.MethodGet, path(project.ID, query), nil); w.Code != want {
+			t.Errorf("%s: got %d: %s", query, w.Code, w.Body.String())
+		}
+	}
+	if w := serveJSON(r, http.MethodGet, path(other.ID, "time=2026-10-08T14:32:00Z"), nil); w.Code != http.StatusForbidden {
+		t.Errorf("another user's project: got %d", w.Code)
+	}
+
+	var body struct {
+		Message  string               `json:"message"`
+		Timeline activations.Timeline `json:"timeline"`
+	}
+	w := serveJSON(r, http.MethodGet, path(project.ID, "time=2026-10-08T16:32:00%2B02:00"), nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Timeline.Live) != 1 || body.Timeline.Live[0].DeploymentID != 7 || body.Timeline.Live[0].ImageDigest != "sha256:"+strings.Repeat("d", 64) ||
+		body.Timeline.Previous == nil || !body.Timeline.Previous.At.Equal(live) || body.Timeline.Next != nil || body.Message != "" {
+		t.Errorf("responded %s", w.Body.String())
+	}
+
+	body.Message = ""
+	w = serveJSON(r, http.MethodGet, path(project.ID, "time=2026-10-08T13:59:59Z"), nil)
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Message != "Nothing was deployed yet at that time" || len(body.Timeline.Live) != 0 {
+		t.Errorf("before the first deploy: %s", w.Body.String())
+	}
+}
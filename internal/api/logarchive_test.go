@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logs of a build finished past the hot period move to the archive, and
+// every logs API keeps serving them from there
+func TestArchivedLogsThroughAPI(t *testing.T) {
+	user, deployment, build := seedDeployment(t, models.StatusDeployed)
+	logs := strings.Repeat("Step 4/9 : RUN go build -o /app ./cmd/api\n", 2000)
+	completed := time.Now().AddDate(0, 0, -40)
+	database.DB.Model(build).Updates(map[string]interface{}{"status": "success", "logs": logs, "completed_at": completed})
+	// A build of yesterday stays in the database
+	recent := time.Now().AddDate(0, 0, -1)
+	newer := &models.Deployment{ProjectID: deployment.ProjectID, Status: models.StatusDeployed}
+	database.DB.Create(newer)
+	hot := &models.Build{DeploymentID: newer.ID, Status: "success", Logs: "hot\n", CompletedAt: &recent}
+	database.DB.Create(hot)
+
+	dir := t.TempDir()
+	archive, err := buildlogs.NewArchive(&config.Config{BuildLogArchive: dir, BuildLogHotDays: 30}, database.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitBuildLogs(archive)
+	t.Cleanup(func() { InitBuildLogs(nil) })
+
+	if n, err := archive.ArchiveExpired(context.Background()); err != nil || n != 1 {
+		t.Fatalf("archived %d builds: %v", n, err)
+	}
+	var stored models.Build
+	database.DB.First(&stored, build.ID)
+	if stored.Logs != "" || stored.LogsObject == "" || stored.LogsSize != int64(len(logs)) || stored.LogsArchivedAt == nil {
+		t.Fatalf("archived build: %d bytes of logs left, object %q of %d bytes", len(stored.Logs), stored.LogsObject, stored.LogsSize)
+	}
+	object := filepath.Join(dir, stored.LogsObject)
+	if info, err := os.Stat(object); err != nil || info.Size() >= int64(len(logs))/10 {
+		t.Fatalf("archived object: %v", err)
+	}
+	var kept models.Build
+	database.DB.First(&kept, hot.ID)
+	if kept.Logs != "hot\n" || kept.LogsObject != "" {
+		t.Errorf("recent build archived: logs %q, object %q", kept.Logs, kept.LogsObject)
+	}
+
+	r := logsRouter(user)
+	owned := func(c *gin.Context) { c.Set("user_id", user.ID) }
+	r.GET("/deployments/:id", owned, GetDeployment)
+	r.GET("/deployments/:id/logs/download", owned, DownloadDeploymentLogs)
+	r.DELETE("/deployments/:id", owned, DeleteDeployment)
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/deployments/%d%s", deployment.ID, path), nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/logs"); w.Code != http.StatusOK || w.Body.String() != logs {
+		t.Errorf("logs: got %d, %d bytes", w.Code, w.Body.Len())
+	}
+	w := get("")
+	var got models.Deployment
+	if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil || got.Build.Logs != logs {
+		t.Errorf("deployment: got %d, %d bytes of logs", w.Code, len(got.Build.Logs))
+	}
+	if w := get("/logs/download", "Range", "bytes=43-85"); w.Code != http.StatusPartialContent || w.Body.String() != logs[43:86] {
+		t.Errorf("range of archived logs: got %d %q", w.Code, w.Body.String())
+	}
+
+	// Deleting the deployment deletes its archived logs
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/deployments/%d", deployment.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(object); !os.IsNotExist(err) {
+		t.Errorf("archived logs left after deleting the deployment: %v", err)
+	}
+}
+
+// Without an archive configured, archived logs can't be read
+func TestArchivedLogsWithoutArchive(t *testing.T) {
+	user, deployment, build := seedDeployment(t, models.StatusDeployed)
+	database.DB.Model(build).Updates(map[string]interface{}{"status": "success", "logs_object": "deployment-1-build-1.log.gz"})
+	InitBuildLogs(nil)
+
+	w := httptest.NewRecorder()
+	logsRouter(user).ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/deployments/%d/logs", deployment.ID), nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
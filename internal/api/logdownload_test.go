@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// downloadLogs are the logs downloaded: lines of every length, multi-byte
+// characters included, which ranges may cut through
+var downloadLogs = func() string {
+	var b strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&b, "Step %d/3000 : RUN npm run build ✓%s\n", i+1, strings.Repeat(".", i%17))
+	}
+	return b.String()
+}()
+
+// downloadSetup seeds a finished build with downloadLogs, in the database or
+// in the archive, and returns a router serving their download to their owner
+func downloadSetup(t *testing.T, archived bool) (*gin.Engine, *models.Deployment, *models.Build) {
+	t.Helper()
+	user, deployment, build := seedDeployment(t, models.StatusDeployed)
+	completed := time.Now().Add(-time.Hour)
+	if archived {
+		completed = time.Now().AddDate(0, 0, -40)
+	}
+	database.DB.Model(build).Updates(map[string]interface{}{"status": "success", "logs": downloadLogs, "completed_at": completed})
+
+	archive, err := buildlogs.NewArchive(&config.Config{BuildLogArchive: t.TempDir(), BuildLogHotDays: 30}, database.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitBuildLogs(archive)
+	t.Cleanup(func() { InitBuildLogs(nil) })
+	if n, err := archive.ArchiveExpired(context.Background()); err != nil || n != map[bool]int{true: 1}[archived] {
+		t.Fatalf("archived %d builds: %v", n, err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/deployments/:id/logs/download", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		DownloadDeploymentLogs(c)
+	})
+	return r, deployment, build
+}
+
+// download requests the logs of deployment with headers as name, value pairs
+func download(r *gin.Engine, deployment *models.Deployment, query string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/deployments/%d/logs/download%s", deployment.ID, query), nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDownloadLogsRanges(t *testing.T) {
+	size := len(downloadLogs)
+	tests := []struct {
+		name  string
+		rng   string
+		code  int
+		start int // Of the part served, with end
+		end   int
+	}{
+		{"whole", "", http.StatusOK, 0, size},
+		{"first byte", "bytes=0-0", http.StatusPartialContent, 0, 1},
+		{"middle", "bytes=1000-1999", http.StatusPartialContent, 1000, 2000},
+		{"through a multi-byte character", "bytes=30-33", http.StatusPartialContent, 30, 34},
+		{"resumed", fmt.Sprintf("bytes=%d-", size/2), http.StatusPartialContent, size / 2, size},
+		{"last byte", fmt.Sprintf("bytes=%d-%d", size-1, size-1), http.StatusPartialContent, size - 1, size},
+		{"suffix", "bytes=-100", http.StatusPartialContent, size - 100, size},
+		{"suffix longer than the logs", fmt.Sprintf("bytes=-%d", size+10), http.StatusPartialContent, 0, size},
+		{"end past the logs", fmt.Sprintf("bytes=%d-%d", size-10, size+1000), http.StatusPartialContent, size - 10, size},
+		{"start at the end", fmt.Sprintf("bytes=%d-", size), http.StatusRequestedRangeNotSatisfiable, 0, 0},
+		{"start past the end", fmt.Sprintf("bytes=%d-%d", size+5, size+10), http.StatusRequestedRangeNotSatisfiable, 0, 0},
+	}
+	for _, tier := range []struct {
+		name     string
+		archived bool
+	}{{"database", false}, {"archive", true}} {
+		t.Run(tier.name, func(t *testing.T) {
+			r, deployment, build := downloadSetup(t, tier.archived)
+			for _, tt := range tests {
+				var header []string
+				if tt.rng != "" {
+					header = []string{"Range", tt.rng}
+				}
+				w := download(r, deployment, "", header...)
+				if w.Code != tt.code {
+					t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.code)
+					continue
+				}
+				switch tt.code {
+				case http.StatusRequestedRangeNotSatisfiable:
+					if got := w.Header().Get("Content-Range"); got != fmt.Sprintf("bytes */%d", size) {
+						t.Errorf("%s: Content-Range %q", tt.name, got)
+					}
+					continue
+				case http.StatusPartialContent:
+					if got, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", tt.start, tt.end-1, size); got != want {
+						t.Errorf("%s: Content-Range %q, want %q", tt.name, got, want)
+					}
+				}
+				if w.Body.String() != downloadLogs[tt.start:tt.end] {
+					t.Errorf("%s: got %d bytes, want bytes %d to %d", tt.name, w.Body.Len(), tt.start, tt.end)
+				}
+				if got := w.Header().Get("Content-Length"); got != strconv.Itoa(tt.end-tt.start) {
+					t.Errorf("%s: Content-Length %s, want %d", tt.name, got, tt.end-tt.start)
+				}
+			}
+
+			w := download(r, deployment, "")
+			etag := fmt.Sprintf(`"build-%d-%d"`, build.ID, size)
+			for name, want := range map[string]string{
+				"Content-Type":        "text/plain; charset=utf-8",
+				"Content-Disposition": fmt.Sprintf(`attachment; filename="deployment-%d-build-%d.log"`, deployment.ID, build.ID),
+				"Accept-Ranges":       "bytes",
+				"ETag":                etag,
+			} {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s %q, want %q", name, got, want)
+				}
+			}
+
+			// A download resumed against the same logs gets the rest, against
+			// others starts over
+			if w := download(r, deployment, "", "Range", "bytes=500-", "If-Range", etag); w.Code != http.StatusPartialContent || w.Body.String() != downloadLogs[500:] {
+				t.Errorf("resumed: got %d, %d bytes", w.Code, w.Body.Len())
+			}
+			if w := download(r, deployment, "", "Range", "bytes=500-", "If-Range", `"build-1-7"`); w.Code != http.StatusOK || w.Body.String() != downloadLogs {
+				t.Errorf("resumed other logs: got %d, %d bytes", w.Code, w.Body.Len())
+			}
+			if w := download(r, deployment, "", "Range", "bytes=abc"); w.Code != http.StatusRequestedRangeNotSatisfiable {
+				t.Errorf("malformed range: got %d", w.Code)
+			}
+		})
+	}
+}
+
+// Several ranges at once come as a multipart response
+func TestDownloadLogsMultipleRanges(t *testing.T) {
+	r, deployment, _ := downloadSetup(t, true)
+	w := download(r, deployment, "", "Range", "bytes=0-9,100-109")
+	if w.Code != http.StatusPartialContent || !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Fatalf("got %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, part := range []string{downloadLogs[0:10], downloadLogs[100:110]} {
+		if !strings.Contains(w.Body.String(), part) {
+			t.Errorf("part %q missing", part)
+		}
+	}
+}
+
+func TestDownloadLogsGzip(t *testing.T) {
+	for _, archived := range []bool{false, true} {
+		r, deployment, _ := downloadSetup(t, archived)
+		// Ranges don't apply to compressed downloads
+		w := download(r, deployment, "?gzip=true", "Range", "bytes=0-99")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("archived %v: got %d, Content-Encoding %q", archived, w.Code, w.Header().Get("Content-Encoding"))
+		}
+		if w.Body.Len() >= len(downloadLogs)/4 {
+			t.Errorf("archived %v: %d bytes compressed of %d", archived, w.Body.Len(), len(downloadLogs))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logs, err := io.ReadAll(zr); err != nil || string(logs) != downloadLogs {
+			t.Errorf("archived %v: %d bytes of logs decompressed, %v", archived, len(logs), err)
+		}
+	}
+}
+
+// Logs archived before their size was recorded are streamed whole
+func TestDownloadLogsSizeUnknown(t *testing.T) {
+	r, deployment, build := downloadSetup(t, true)
+	database.DB.Model(build).Update("logs_size", 0)
+
+	w := download(r, deployment, "", "Range", "bytes=0-99")
+	if w.Code != http.StatusOK || w.Header().Get("Accept-Ranges") != "none" || w.Body.String() != downloadLogs {
+		t.Errorf("got %d, Accept-Ranges %q, %d bytes", w.Code, w.Header().Get("Accept-Ranges"), w.Body.Len())
+	}
+}
+
+// Other users' logs are refused, like their deployments
+func TestDownloadLogsOwnership(t *testing.T) {
+	_, deployment, _ := downloadSetup(t, false)
+	other := &models.User{Username: "bob", Email: "bob@example.com"}
+	database.DB.Create(other)
+
+	r := gin.New()
+	r.GET("/deployments/:id/logs/download", func(c *gin.Context) {
+		c.Set("user_id", other.ID)
+		DownloadDeploymentLogs(c)
+	})
+	if w := download(r, deployment, ""); w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "Step 1/") {
+		t.Errorf("got %d: %.100s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// followPoll is how often a followed deployment is read again while it
+// waits for its build, or builds on another replica
+const followPoll = 2 * time.Second
+
+// followEventSize is the most output a "log" event carries
+const followEventSize = 64 << 10
+
+// errFollowLagged ends the stream of a client that couldn't keep up
+var errFollowLagged = errors.New("fell behind the build's output, follow its logs again")
+
+// followDeploymentLogs streams a deployment's build logs as server-sent
+// events: "log" events with the output written so far, then as the build
+// writes it, until the build is over. The platform's own messages follow,
+// then a "done" event with the deployment's and the build's status. Builds
+// running on this replica are followed write by write, those running on
+// another are read from the database every followPoll. A deployment still
+// waiting for a worker is followed once its build starts. Streams end with
+// an "error" event when the platform shuts down.
+func followDeploymentLogs(c *gin.Context, deployment *models.Deployment) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Or nginx holds the output back
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	follow := &logFollow{c: c, deploymentID: deployment.ID}
+	ctx, cancel := streamContext(c)
+	defer cancel()
+	err := follow.run(ctx)
+	switch {
+	case errors.Is(context.Cause(ctx), errShuttingDown):
+		err = errShuttingDown
+	case err == nil || ctx.Err() != nil:
+		return // Over, or the client went away
+	case !errors.Is(err, errFollowLagged):
+		log.Printf("⚠️  Following the logs of deployment %d failed: %v", deployment.ID, err)
+	}
+	c.SSEvent("error", gin.H{"error": err.Error()})
+	c.Writer.Flush()
+}
+
+// logFollow is a client following a deployment's build logs
+type logFollow struct {
+	c            *gin.Context
+	deploymentID uint
+	sent         int // Bytes of the build's logs sent so far
+}
+
+func (f *logFollow) run(ctx context.Context) error {
+	ticker := time.NewTicker(followPoll)
+	defer ticker.Stop()
+
+	var build models.Build
+	for {
+		status, err := f.status()
+		if err != nil {
+			return err
+		}
+		err = database.DB.Where("deployment_id = ?", f.deploymentID).Order("id DESC").First(&build).Error
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		switch status {
+		case models.StatusPending, models.StatusQueued, models.StatusBuilding:
+		default:
+			// Over without a build, e.g. cancelled while queued
+			f.c.SSEvent("done", gin.H{"status": status})
+			f.c.Writer.Flush()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	follower, err := logStreams.Follow(build.ID)
+	if err != nil {
+		return err
+	}
+	if follower != nil {
+		defer follower.Stop()
+		f.send(follower.Backlog)
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case data, ok := <-follower.Output():
+				if !ok {
+					if follower.Lagged() {
+						return errFollowLagged
+					}
+					return f.finish(ctx, build.ID)
+				}
+				f.send(data)
+			}
+		}
+	}
+
+	// Building on another replica, or already over
+	seq := 0
+	for {
+		status, err := f.status()
+		if err != nil {
+			return err
+		}
+		if status != models.StatusBuilding && status != models.StatusDeploying {
+			return f.finish(ctx, build.ID)
+		}
+		output, next, err := buildlogs.ReadOutput(database.DB, build.ID, seq)
+		if err != nil {
+			return err
+		}
+		seq = next
+		f.send(output)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// status is the followed deployment's current status
+func (f *logFollow) status() (models.DeploymentStatus, error) {
+	var deployment models.Deployment
+	err := database.DB.Select("id", "status").First(&deployment, f.deploymentID).Error
+	return deployment.Status, err
+}
+
+// send sends output as "log" events, split at line ends where it is too big
+// for one
+func (f *logFollow) send(output []byte) {
+	for len(output) > 0 {
+		n := len(output)
+		if n > followEventSize {
+			n = followEventSize
+			if end := bytes.LastIndexByte(output[:n], '\n'); end >= 0 {
+				n = end + 1
+			}
+		}
+		f.c.SSEvent("log", gin.H{"data": string(output[:n])})
+		f.sent += n
+		output = output[n:]
+	}
+	f.c.Writer.Flush()
+}
+
+// finish sends the rest of a finished build's logs, those the platform added
+// after its output included, and the "done" event
+func (f *logFollow) finish(ctx context.Context, buildID uint) error {
+	var build models.Build
+	if err := database.DB.First(&build, buildID).Error; err != nil {
+		return err
+	}
+	if err := buildlogs.Collect(database.DB, &build); err != nil {
+		return err
+	}
+	if build.LogsObject != "" {
+		logs, err := logArchive.Load(ctx, &build)
+		if err != nil {
+			return err
+		}
+		build.Logs = logs
+	}
+	if len(build.Logs) > f.sent {
+		f.send([]byte(build.Logs[f.sent:]))
+	}
+
+	status, err := f.status()
+	if err != nil {
+		return err
+	}
+	f.c.SSEvent("done", gin.H{"status": status, "build_status": build.Status})
+	f.c.Writer.Flush()
+	return nil
+}
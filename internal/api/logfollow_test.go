@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// seedDeployment creates a user's project with a deployment in status and
+// a build of it
+func seedDeployment(t *testing.T, status models.DeploymentStatus) (*models.User, *models.Deployment, *models.Build) {
+	t.Helper()
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", UserID: user.ID}
+	database.DB.Create(project)
+	deployment := &models.Deployment{ProjectID: project.ID, Status: status}
+	database.DB.Create(deployment)
+	build := &models.Build{DeploymentID: deployment.ID, Status: "building"}
+	database.DB.Create(build)
+	return user, deployment, build
+}
+
+// logsRouter serves GET /deployments/:id/logs for user
+func logsRouter(user *models.User) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/deployments/:id/logs", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		GetDeploymentLogs(c)
+	})
+	return r
+}
+
+func TestFollowLocalBuild(t *testing.T) {
+	user, deployment, build := seedDeployment(t, models.StatusBuilding)
+	streams := buildlogs.NewStreams(database.DB)
+	InitLogStreams(streams)
+	t.Cleanup(func() { InitLogStreams(nil) })
+	output := streams.Writer(build.ID)
+	fmt.Fprint(output, "Step 1/2\n")
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(output, "Step 2/2\n")
+		database.DB.Model(build).Updates(map[string]interface{}{"status": "failed", "logs": "Build failed: boom\n"})
+		models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusFailed, "build failed")
+		streams.Finish(build.ID)
+	}()
+
+	w := httptest.NewRecorder()
+	logsRouter(user).ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/deployments/%d/logs?follow=true", deployment.ID), nil))
+	body := w.Body.String()
+	for _, want := range []string{"Step 1/2", "Step 2/2", "Build failed: boom", "event:done", `"status":"failed"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+}
+
+func TestFollowEndsOnShutdown(t *testing.T) {
+	streams, closeStreams = context.WithCancelCause(context.Background())
+	user, deployment, build := seedDeployment(t, models.StatusBuilding)
+	logs := buildlogs.NewStreams(database.DB)
+	InitLogStreams(logs)
+	t.Cleanup(func() { InitLogStreams(nil) })
+	fmt.Fprint(logs.Writer(build.ID), "Step 1/2\n")
+
+	server := httptest.NewServer(logsRouter(user))
+	defer server.Close()
+	server.Config.RegisterOnShutdown(CloseStreams)
+	resp, err := http.Get(fmt.Sprintf("%s/deployments/%d/logs?follow=true", server.URL, deployment.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown waited for the stream: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "event:error") || !strings.Contains(string(body), "shutting down") {
+		t.Fatalf("stream didn't end with a shutdown error:\n%s", body)
+	}
+}
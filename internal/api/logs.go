@@ -0,0 +1,120 @@
+package api
+
+import (
+	"compress/gzip"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DownloadDeploymentLogs serves a deployment's build logs as a text/plain
+// attachment, for logs too big for the browser: with Content-Length and HTTP
+// Range support so downloads resume, from the database or streamed from the
+// log archive. ?gzip=true sends them gzip-encoded instead, without ranges;
+// archived logs are then sent as stored.
+func DownloadDeploymentLogs(c *gin.Context) {
+	deployment, ok := ownedDeployment(c)
+	if !ok {
+		return
+	}
+
+	var build models.Build
+	// Each attempt has its own build: retried deployments show their latest
+	if err := database.DB.Where("deployment_id = ?", deployment.ID).Order("id DESC").First(&build).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment has no build"})
+		return
+	}
+	if err := buildlogs.Collect(database.DB, &build); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build logs"})
+		return
+	}
+
+	filename := fmt.Sprintf("deployment-%d-build-%d.log", deployment.ID, build.ID)
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	if c.Query("gzip") == "true" {
+		downloadGzipLogs(c, &build)
+		return
+	}
+
+	logs, err := logArchive.OpenSeeker(c.Request.Context(), &build)
+	if errors.Is(err, buildlogs.ErrSizeUnknown) {
+		// Archived before sizes were recorded: no length, no ranges
+		streamLogs(c, &build)
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to read archived logs of build %d: %v", build.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read archived build logs"})
+		return
+	}
+	defer logs.Close()
+
+	// The logs of a finished build no longer change, so a resumed download
+	// can check it continues the same file; a running build's grow
+	var modified time.Time
+	if build.CompletedAt != nil {
+		modified = *build.CompletedAt
+		size, _ := logs.Seek(0, io.SeekEnd)
+		logs.Seek(0, io.SeekStart)
+		c.Header("ETag", fmt.Sprintf(`"build-%d-%d"`, build.ID, size))
+	}
+	http.ServeContent(c.Writer, c.Request, filename, modified, logs)
+}
+
+// downloadGzipLogs sends a build's logs gzip-encoded: archived logs as they
+// are stored, logs in the database compressed on the fly
+func downloadGzipLogs(c *gin.Context, build *models.Build) {
+	c.Header("Content-Encoding", "gzip")
+	c.Header("Vary", "Accept-Encoding")
+
+	if build.LogsObject == "" {
+		c.Status(http.StatusOK)
+		zw := gzip.NewWriter(c.Writer)
+		if _, err := io.WriteString(zw, build.Logs); err != nil {
+			log.Printf("⚠️  Sending logs of build %d failed: %v", build.ID, err)
+		}
+		zw.Close()
+		return
+	}
+
+	object, err := logArchive.OpenCompressed(c.Request.Context(), build)
+	if err != nil {
+		log.Printf("⚠️  Failed to read archived logs of build %d: %v", build.ID, err)
+		c.Header("Content-Encoding", "")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read archived build logs"})
+		return
+	}
+	defer object.Close()
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, object); err != nil {
+		log.Printf("⚠️  Streaming logs of build %d failed: %v", build.ID, err)
+	}
+}
+
+// streamLogs sends a build's logs from the start, of unknown length
+func streamLogs(c *gin.Context, build *models.Build) {
+	logs, err := logArchive.Open(c.Request.Context(), build)
+	if err != nil {
+		log.Printf("⚠️  Failed to read archived logs of build %d: %v", build.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read archived build logs"})
+		return
+	}
+	defer logs.Close()
+
+	c.Header("Accept-Ranges", "none")
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, logs); err != nil {
+		log.Printf("⚠️  Streaming logs of build %d failed: %v", build.ID, err)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A retried deployment has a build per attempt; its logs are the latest's
+func TestLogsOfLatestBuild(t *testing.T) {
+	user, deployment, first := seedDeployment(t, models.StatusFailed)
+	database.DB.Model(first).Updates(map[string]interface{}{"status": "failed", "logs": "attempt 1\n"})
+	database.DB.Create(&models.Build{DeploymentID: deployment.ID, Status: "failed", Logs: "attempt 2\n"})
+
+	r := logsRouter(user)
+	r.GET("/deployments/:id/logs/download", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		DownloadDeploymentLogs(c)
+	})
+	for _, path := range []string{"/logs", "/logs/download"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/deployments/%d%s", deployment.ID, path), nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "attempt 2") {
+			t.Errorf("%s: %d %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+// A running build's streamed output reads the same, wherever it is read,
+// before and after it is compacted into the logs column
+func TestLogsOfRunningBuild(t *testing.T) {
+	user, deployment, build := seedDeployment(t, models.StatusBuilding)
+	streams := buildlogs.NewStreams(database.DB)
+	fmt.Fprint(streams.Writer(build.ID), "Step 1/2 : FROM node:20\nStep 2/2 : RUN npm ci")
+	database.DB.Model(build).Update("logs", "Build failed: npm ci exited with 1\n")
+	want := "Step 1/2 : FROM node:20\nStep 2/2 : RUN npm ci\nBuild failed: npm ci exited with 1\n"
+
+	r := logsRouter(user)
+	r.GET("/deployments/:id/logs/download", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		DownloadDeploymentLogs(c)
+	})
+	r.GET("/deployments/:id", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		GetDeployment(c)
+	})
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/deployments/%d%s", deployment.ID, path), nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", path, w.Code, w.Body.String())
+		}
+		if path != "" {
+			return w.Body.String()
+		}
+		var got struct {
+			Build struct {
+				Logs string `json:"logs"`
+			} `json:"build"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return got.Build.Logs
+	}
+
+	// Buffered output isn't stored yet: only the platform's messages read
+	for _, path := range []string{"", "/logs", "/logs/download"} {
+		if got := get(path); got != "Build failed: npm ci exited with 1\n" {
+			t.Errorf("buffered %s: %q", path, got)
+		}
+	}
+
+	// Stopped streams flush once more
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	streams.Start(ctx)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var chunks int64
+		database.DB.Model(&models.BuildLogChunk{}).Where("build_id = ?", build.ID).Count(&chunks)
+		if chunks > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the output was never flushed")
+		}
+	}
+	for _, path := range []string{"", "/logs", "/logs/download"} {
+		if got := get(path); got != want {
+			t.Errorf("flushed %s: %q", path, got)
+		}
+	}
+
+	if err := streams.Finish(build.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"", "/logs", "/logs/download"} {
+		if got := get(path); got != want {
+			t.Errorf("compacted %s: %q", path, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/quota"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rollout health of a project on the overview
+const (
+	HealthHealthy     = "healthy"     // The newest deployment finished and the live one is serving
+	HealthProgressing = "progressing" // A deployment is being built or rolled out
+	HealthFailing     = "failing"     // The newest deployment failed, or nothing is serving
+	HealthNone        = "none"        // Never deployed
+)
+
+// ProjectHealth is one project on the overview
+type ProjectHealth struct {
+	ID              uint       `json:"id"`
+	Name            string     `json:"name"`
+	Slug            string     `json:"slug"`
+	Status          string     `json:"status,omitempty"` // Of the live deployment
+	Health          string     `json:"health"`
+	URL             string     `json:"url,omitempty"`
+	LastDeployAt    *time.Time `json:"last_deploy_at,omitempty"`
+	FailedBuilds24h int        `json:"failed_builds_24h"`
+}
+
+// OverviewCounts are the account-wide numbers of the overview
+type OverviewCounts struct {
+	Projects         int  `json:"projects"` // Archived ones left out
+	ArchivedProjects int  `json:"archived_projects"`
+	DeploymentsToday int  `json:"deployments_today"` // UTC day, as for quotas
+	FailuresToday    int  `json:"failures_today"`
+	BuildMinutes     int  `json:"build_minutes"` // Completed builds this month (UTC), rounded up
+	AllGreen         bool `json:"all_green"`     // No project failing
+}
+
+// GetOverview answers "is everything green?" for the dashboard homepage: the
+// health of each of the user's projects, archived ones left out, and
+// account-wide counts. It takes a
+// fixed number of queries whatever the number of projects and never asks the
+// cluster: health comes from the deployment statuses the build service keeps.
+func GetOverview(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	now := time.Now().UTC()
+	dayStart := now.Truncate(24 * time.Hour)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var projects []models.Project
+	if err := database.DB.Select("id", "name", "slug", "deployments_today", "deployments_day").
+		Where("user_id = ? AND archived_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&projects).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
+		return
+	}
+	latest, err := latestDeployments(userID, 1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deployments"})
+		return
+	}
+	recent, err := recentFailures(userID, now.Add(-24*time.Hour), dayStart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch builds"})
+		return
+	}
+	minutes, err := buildMinutes(userID, monthStart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch builds"})
+		return
+	}
+
+	var archived int64
+	if err := database.DB.Model(&models.Project{}).Where("user_id = ? AND archived_at IS NOT NULL", userID).Count(&archived).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
+		return
+	}
+
+	counts := OverviewCounts{Projects: len(projects), ArchivedProjects: int(archived), BuildMinutes: minutes, AllGreen: true}
+	summaries := make([]ProjectHealth, len(projects))
+	for i := range projects {
+		project := &projects[i]
+		var live, newest *models.Deployment
+		if shown := latest[project.ID]; shown != nil {
+			live, newest = shown.Live, &shown.Recent[0]
+		}
+		summary := ProjectHealth{
+			ID:              project.ID,
+			Name:            project.Name,
+			Slug:            project.Slug,
+			Health:          projectHealth(live, newest),
+			FailedBuilds24h: recent[project.ID].FailedBuilds,
+		}
+		if live != nil {
+			summary.Status = string(live.Status)
+			summary.URL = fullURL(live.Hostname)
+		}
+		if newest != nil {
+			summary.LastDeployAt = &newest.CreatedAt
+		}
+		summaries[i] = summary
+
+		counts.DeploymentsToday += quota.DeploymentsToday(project)
+		counts.FailuresToday += recent[project.ID].FailuresToday
+		if summary.Health == HealthFailing {
+			counts.AllGreen = false
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"counts":   counts,
+		"projects": summaries,
+	})
+}
+
+// projectHealth rates a project from its live and newest deployments
+func projectHealth(live, newest *models.Deployment) string {
+	switch {
+	case newest == nil:
+		return HealthNone
+	case !newest.Status.Terminal():
+		return HealthProgressing
+	case newest.Status == models.StatusFailed, live == nil, live.Status != models.StatusDeployed:
+		return HealthFailing
+	}
+	return HealthHealthy
+}
+
+// projectFailures counts a project's recent failures
+type projectFailures struct {
+	ProjectID     uint
+	FailedBuilds  int // Since the 24 hour cutoff
+	FailuresToday int // Deployments failed since the start of the day
+}
+
+// recentFailures counts, per project of the user, the builds that failed
+// since since and the deployments that failed since dayStart
+func recentFailures(userID uint, since, dayStart time.Time) (map[uint]projectFailures, error) {
+	var rows []projectFailures
+	err := database.DB.Raw(`
+		SELECT deployments.project_id,
+			SUM(CASE WHEN builds.status = 'failed' AND COALESCE(builds.completed_at, builds.updated_at) >= ? THEN 1 ELSE 0 END) AS failed_builds,
+			SUM(CASE WHEN deployments.status = 'failed' AND deployments.updated_at >= ? THEN 1 ELSE 0 END) AS failures_today
+		FROM deployments
+		JOIN projects ON projects.id = deployments.project_id
+		LEFT JOIN builds ON builds.deployment_id = deployments.id
+		WHERE projects.user_id = ? AND deployments.updated_at >= ?
+		GROUP BY deployments.project_id`, since, dayStart, userID, minTime(since, dayStart)).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make(map[uint]projectFailures, len(rows))
+	for _, row := range rows {
+		failures[row.ProjectID] = row
+	}
+	return failures, nil
+}
+
+// buildMinutes is the build time of the user's builds completed since
+// since, in minutes rounded up
+func buildMinutes(userID uint, since time.Time) (int, error) {
+	var builds []models.Build
+	if err := database.DB.Model(&models.Build{}).
+		Select("builds.started_at", "builds.completed_at").
+		Joins("JOIN deployments ON deployments.id = builds.deployment_id").
+		Joins("JOIN projects ON projects.id = deployments.project_id").
+		Where("projects.user_id = ? AND builds.completed_at >= ? AND builds.started_at IS NOT NULL", userID, since).
+		Find(&builds).Error; err != nil {
+		return 0, err
+	}
+
+	var total time.Duration
+	for _, b := range builds {
+		if b.CompletedAt.After(*b.StartedAt) {
+			total += b.CompletedAt.Sub(*b.StartedAt)
+		}
+	}
+	return int((total + time.Minute - 1) / time.Minute), nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
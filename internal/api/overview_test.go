@@ -0,0 +1,188 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/logger"
+)
+
+// overviewResponse is the body of GET /overview
+type overviewResponse struct {
+	Counts   OverviewCounts  `json:"counts"`
+	Projects []ProjectHealth `json:"projects"`
+}
+
+// getOverview serves GET /overview for userID, counting the statements run
+func getOverview(t *testing.T, userID uint) (overviewResponse, int, int) {
+	t.Helper()
+	counter := &statementCounter{Interface: logger.Discard}
+	database.DB.Logger = counter
+	defer func() { database.DB.Logger = logger.Discard }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/overview", func(c *gin.Context) {
+		c.Set("user_id", userID)
+		GetOverview(c)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/overview", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var overview overviewResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &overview); err != nil {
+		t.Fatal(err)
+	}
+	return overview, w.Body.Len(), int(counter.statements.Load())
+}
+
+// overviewDeployment creates a deployment of project created at, with a
+// build that ran for ran until at
+func overviewDeployment(t *testing.T, project *models.Project, status models.DeploymentStatus, hostname string, at time.Time, ran time.Duration) {
+	t.Helper()
+	deployment := &models.Deployment{ProjectID: project.ID, Status: status, Branch: "main", Hostname: hostname, CreatedAt: at, UpdatedAt: at}
+	if err := database.DB.Create(deployment).Error; err != nil {
+		t.Fatal(err)
+	}
+	build := &models.Build{DeploymentID: deployment.ID, Status: "building", CreatedAt: at, UpdatedAt: at}
+	if status.Terminal() {
+		started := at.Add(-ran)
+		build.Status, build.StartedAt, build.CompletedAt = "success", &started, &at
+		if status == models.StatusFailed {
+			build.Status = "failed"
+		}
+	}
+	if err := database.DB.Create(build).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverviewAggregation(t *testing.T) {
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	other := &models.User{Username: "bob", Email: "bob@example.com"}
+	database.DB.Create(other)
+
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	project := func(name string, userID uint, deployedToday int, day string) *models.Project {
+		p := &models.Project{Name: name, Slug: name, UserID: userID, Branch: "main", DeploymentsToday: deployedToday, DeploymentsDay: day}
+		if err := database.DB.Create(p).Error; err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	web := project("web", user.ID, 3, today)
+	api := project("api", user.ID, 2, today)
+	worker := project("worker", user.ID, 0, "")
+	project("docs", user.ID, 5, "2020-01-01") // Counted another day
+	archived := project("old", user.ID, 0, "")
+	database.DB.Model(archived).Update("archived_at", now)
+	bobs := project("bobs", other.ID, 7, today)
+
+	// web is live; a build failing 30 hours ago no longer counts
+	overviewDeployment(t, web, models.StatusFailed, "", now.Add(-30*time.Hour), time.Minute)
+	overviewDeployment(t, web, models.StatusDeployed, "web.example.com", now.Add(-2*time.Minute), 91*time.Second)
+	// api's newest deployment failed, its previous one is still serving
+	overviewDeployment(t, api, models.StatusDeployed, "api.example.com", now.Add(-50*time.Hour), 2*time.Minute)
+	overviewDeployment(t, api, models.StatusFailed, "", now.Add(-time.Minute), 270*time.Second)
+	overviewDeployment(t, worker, models.StatusBuilding, "", now, 0)
+	overviewDeployment(t, bobs, models.StatusFailed, "", now.Add(-time.Minute), time.Hour)
+
+	overview, _, _ := getOverview(t, user.ID)
+
+	// Build time of the month: the builds of the last minutes, and the
+	// older ones unless the month just started
+	ran := 91*time.Second + 270*time.Second
+	for _, build := range []struct {
+		at  time.Time
+		ran time.Duration
+	}{{now.Add(-30 * time.Hour), time.Minute}, {now.Add(-50 * time.Hour), 2 * time.Minute}} {
+		if build.at.Month() == now.Month() {
+			ran += build.ran
+		}
+	}
+	want := OverviewCounts{
+		Projects:         4,
+		ArchivedProjects: 1,
+		DeploymentsToday: 5,
+		FailuresToday:    1,
+		BuildMinutes:     int((ran + time.Minute - 1) / time.Minute),
+		AllGreen:         false,
+	}
+	if overview.Counts != want {
+		t.Errorf("counts %+v, want %+v", overview.Counts, want)
+	}
+
+	health := map[string]string{}
+	failed := map[string]int{}
+	for _, p := range overview.Projects {
+		health[p.Name], failed[p.Name] = p.Health, p.FailedBuilds24h
+	}
+	if want := map[string]string{"web": HealthHealthy, "api": HealthFailing, "worker": HealthProgressing, "docs": HealthNone}; !reflect.DeepEqual(health, want) {
+		t.Errorf("health %v, want %v", health, want)
+	}
+	if want := map[string]int{"web": 0, "api": 1, "worker": 0, "docs": 0}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed builds in 24h %v, want %v", failed, want)
+	}
+	for _, p := range overview.Projects {
+		switch p.Name {
+		case "api":
+			if p.Status != string(models.StatusDeployed) || p.LastDeployAt == nil || now.Sub(*p.LastDeployAt) > 2*time.Minute {
+				t.Errorf("api: status %q, last deployed %v", p.Status, p.LastDeployAt)
+			}
+		case "docs":
+			if p.Status != "" || p.LastDeployAt != nil {
+				t.Errorf("docs: status %q, last deployed %v", p.Status, p.LastDeployAt)
+			}
+		}
+	}
+
+	// Without failures the account is all green
+	database.DB.Model(&models.Deployment{}).Where("project_id = ? AND status = ?", api.ID, models.StatusFailed).Delete(&models.Deployment{})
+	if overview, _, _ := getOverview(t, user.ID); !overview.Counts.AllGreen {
+		t.Errorf("not green: %+v", overview.Projects)
+	}
+}
+
+// 100 projects take the queries one does, and a compact response
+func TestOverviewBudget(t *testing.T) {
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	now := time.Now().UTC()
+
+	_, _, empty := getOverview(t, user.ID)
+	for i := 0; i < 100; i++ {
+		project := &models.Project{Name: fmt.Sprintf("app-%d", i), Slug: fmt.Sprintf("app-%d", i), UserID: user.ID, Branch: "main"}
+		if err := database.DB.Create(project).Error; err != nil {
+			t.Fatal(err)
+		}
+		overviewDeployment(t, project, models.StatusFailed, "", now.Add(-2*time.Minute), time.Minute)
+		overviewDeployment(t, project, models.StatusDeployed, fmt.Sprintf("app-%d.example.com", i), now.Add(-time.Minute), time.Minute)
+	}
+
+	overview, size, statements := getOverview(t, user.ID)
+	if statements != empty {
+		t.Errorf("ran %d statements for 100 projects, %d for none", statements, empty)
+	}
+	if len(overview.Projects) != 100 || overview.Counts.BuildMinutes != 200 || !overview.Counts.AllGreen {
+		t.Errorf("%d projects, counts %+v", len(overview.Projects), overview.Counts)
+	}
+	const budget = 100 * 256
+	if size > budget {
+		t.Errorf("overview of 100 projects is %d bytes, over %d", size, budget)
+	}
+}
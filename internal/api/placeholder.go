@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/netutil"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// placeholderPage is what the placeholder page says about a hostname. Only
+// the project's display name and its last deploy's outcome are ever shown.
+type placeholderPage struct {
+	Name     string // Project display name, "" when unknown or private
+	Headline string
+	Detail   string
+}
+
+var placeholderTemplate = template.Must(template.New("placeholder").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>{{if .Name}}{{.Name}} - {{end}}{{.Headline}}</title>
+    <style>
+        body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; background: #0f172a; color: #e2e8f0; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; }
+        main { max-width: 32rem; padding: 2rem; text-align: center; }
+        h1 { font-size: 1.5rem; margin: 0 0 .5rem; }
+        p { color: #94a3b8; margin: .25rem 0; }
+        .name { color: #e2e8f0; font-weight: 600; }
+        footer { margin-top: 2rem; font-size: .75rem; color: #475569; }
+    </style>
+</head>
+<body>
+    <main>
+        {{if .Name}}<p class="name">{{.Name}}</p>{{end}}
+        <h1>{{.Headline}}</h1>
+        {{if .Detail}}<p>{{.Detail}}</p>{{end}}
+        <footer>Deploy Platform</footer>
+    </main>
+</body>
+</html>
+`))
+
+// ServePlaceholder renders the placeholder page of the requested hostname,
+// which Ingresses route to until a deployment of theirs is ready. It is
+// served on its own listener, reached from clusters through the
+// platform-placeholder Service, and found by its Host header.
+func ServePlaceholder(c *gin.Context) {
+	host := strings.ToLower(netutil.SplitHost(c.Request.Host))
+
+	status := http.StatusNotFound
+	page := placeholderPage{Headline: "Nothing is deployed here"}
+	var record models.Hostname
+	if database.DB.Preload("Project", func(db *gorm.DB) *gorm.DB { return db.Select("id", "name", "placeholder_private") }).
+		Where("hostname = ?", host).First(&record).Error == nil {
+		status = http.StatusServiceUnavailable
+		page = placeholderFor(&record)
+	}
+
+	var body bytes.Buffer
+	if err := placeholderTemplate.Execute(&body, page); err != nil {
+		log.Printf("⚠️  Failed to render the placeholder page of %s: %v", host, err)
+		c.String(http.StatusInternalServerError, "Nothing is deployed here")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	if status == http.StatusServiceUnavailable {
+		c.Header("Retry-After", "30")
+	}
+	c.Data(status, "text/html; charset=utf-8", body.Bytes())
+}
+
+// placeholderFor describes the last deploy of record's hostname, unless its
+// project keeps that private
+func placeholderFor(record *models.Hostname) placeholderPage {
+	page := placeholderPage{Headline: "Nothing deployed yet"}
+	if record.Project.PlaceholderPrivate {
+		return page
+	}
+	page.Name = record.Project.Name
+
+	var deployment models.Deployment
+	if record.DeploymentID == 0 || database.DB.Select("id", "status", "updated_at").First(&deployment, record.DeploymentID).Error != nil {
+		return page
+	}
+	switch deployment.Status {
+	case models.StatusFailed:
+		page.Headline = "The last deploy failed"
+		page.Detail = "It failed at " + deployment.UpdatedAt.UTC().Format(time.RFC1123) + "."
+	case models.StatusPending, models.StatusQueued, models.StatusBuilding, models.StatusDeploying:
+		page.Headline = "Deploying"
+		page.Detail = "A deploy is in progress, this page will be replaced once it is ready."
+	case models.StatusDeployPending:
+		page.Headline = "Deploying"
+		page.Detail = "A deploy is built and its rollout will be retried shortly, this page will be replaced once it is ready."
+	case models.StatusAwaitingApproval:
+		page.Headline = "Waiting for approval"
+		page.Detail = "A deploy is ready and waits for approval, this page will be replaced once it is rolled out."
+	case models.StatusPolicyBlocked:
+		page.Headline = "Waiting for approval"
+		page.Detail = "A deploy waits for approval before it is built, this page will be replaced once it is rolled out."
+	}
+	return page
+}
@@ -2,13 +2,23 @@ package api
 
 import (
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/hostname"
 	"deploy-platform/internal/models"
+	"deploy-platform/internal/quota"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+var hostnameMgr *hostname.Manager
+
+// InitHostnameManager sets the hostname manager used to validate project slugs
+func InitHostnameManager(m *hostname.Manager) {
+	hostnameMgr = m
+}
+
 // CreateProjectRequest represents a project creation request
 type CreateProjectRequest struct {
 	Name      string `json:"name" binding:"required"`
@@ -16,6 +26,11 @@ type CreateProjectRequest struct {
 	RepoOwner string `json:"repo_owner" binding:"required"`
 	RepoName  string `json:"repo_name" binding:"required"`
 	Branch    string `json:"branch"`
+	RepoID    *int64 `json:"repo_id"` // GitHub repository ID, when known
+
+	Visibility  string `json:"visibility"`   // public (default) or internal
+	Cluster     string `json:"cluster"`      // Kubernetes cluster to deploy to, "" = the default one
+	ProcessType string `json:"process_type"` // web (default) or worker
 }
 
 // CreateProject creates a new project
@@ -28,20 +43,75 @@ func CreateProject(c *gin.Context) {
 		return
 	}
 
-	// Check if project already exists
+	// Check if project already exists, by repository ID first: the repository
+	// may have been renamed or transferred since the project was linked
 	var existingProject models.Project
-	if err := database.DB.Where("repo_owner = ? AND repo_name = ?", req.RepoOwner, req.RepoName).First(&existingProject).Error; err == nil {
-		// Project exists, link it to current user if not already linked
+	found := req.RepoID != nil && database.DB.Where("github_repo_id = ?", *req.RepoID).First(&existingProject).Error == nil
+	if !found {
+		found = database.DB.Where("repo_owner = ? AND repo_name = ?", req.RepoOwner, req.RepoName).First(&existingProject).Error == nil
+		if found && req.RepoID != nil && existingProject.GitHubRepoID == nil {
+			existingProject.GitHubRepoID = req.RepoID
+			database.DB.Model(&existingProject).Update("github_repo_id", *req.RepoID)
+		}
+	}
+	if found {
+		// Project exists, link it to current user if not already linked;
+		// archived projects count toward nobody's quota
 		if existingProject.UserID != userID {
+			if err := reserveProject(&existingProject, userID); err != nil {
+				if !respondQuotaError(c, err) {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check project quota"})
+				}
+				return
+			}
+			previousOwner := existingProject.UserID
 			existingProject.UserID = userID
-			database.DB.Save(&existingProject)
+			if err := database.DB.Save(&existingProject).Error; err != nil {
+				releaseProject(&existingProject, userID)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link project"})
+				return
+			}
+			releaseProject(&existingProject, previousOwner)
 		}
 		c.JSON(http.StatusOK, existingProject)
 		return
 	}
 
-	// Generate slug from name
+	if req.Visibility == "" {
+		req.Visibility = models.VisibilityPublic
+	}
+	if req.Visibility != models.VisibilityPublic && req.Visibility != models.VisibilityInternal {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visibility must be public or internal"})
+		return
+	}
+
+	if req.ProcessType == "" {
+		req.ProcessType = models.ProcessWeb
+	}
+	if req.ProcessType != models.ProcessWeb && req.ProcessType != models.ProcessWorker {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "process_type must be web or worker"})
+		return
+	}
+	if req.Cluster != "" {
+		if err := validateCluster(req.Cluster); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Generate slug from name; it becomes the project's subdomain
 	slug := generateSlug(req.Name)
+	if err := validateSlug(slug); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid project name: " + err.Error()})
+		return
+	}
+
+	if err := quota.ReserveProject(userID); err != nil {
+		if !respondQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check project quota"})
+		}
+		return
+	}
 
 	// Create new project
 	project := &models.Project{
@@ -53,12 +123,17 @@ func CreateProject(c *gin.Context) {
 		RepoName:  req.RepoName,
 		Branch:    req.Branch,
 	}
+	project.GitHubRepoID = req.RepoID
+	project.Visibility = req.Visibility
+	project.Cluster = req.Cluster
+	project.ProcessType = req.ProcessType
 
 	if req.Branch == "" {
 		project.Branch = "main"
 	}
 
 	if err := database.DB.Create(project).Error; err != nil {
+		quota.ReleaseProject(userID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create project"})
 		return
 	}
@@ -81,26 +156,65 @@ func LinkProject(c *gin.Context) {
 		return
 	}
 
+	if project.UserID == userID {
+		c.JSON(http.StatusOK, project)
+		return
+	}
+	if err := reserveProject(&project, userID); err != nil {
+		if !respondQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check project quota"})
+		}
+		return
+	}
+
+	previousOwner := project.UserID
 	project.UserID = userID
 	if err := database.DB.Save(&project).Error; err != nil {
+		releaseProject(&project, userID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link project"})
 		return
 	}
+	releaseProject(&project, previousOwner)
 
 	c.JSON(http.StatusOK, project)
 }
 
+// reserveProject counts project for userID, taking it over, if their quota
+// allows it; archived projects count toward nobody's quota
+func reserveProject(project *models.Project, userID uint) error {
+	if project.Archived() {
+		return nil
+	}
+	return quota.ReserveProject(userID)
+}
+
+// releaseProject stops counting project for userID, its previous owner
+func releaseProject(project *models.Project, userID uint) {
+	if !project.Archived() && userID != 0 {
+		quota.ReleaseProject(userID)
+	}
+}
+
 func generateSlug(name string) string {
 	slug := ""
-	for _, char := range name {
-		if (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
+	for _, char := range strings.ToLower(name) {
+		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') {
 			slug += string(char)
-		} else if char == ' ' || char == '-' || char == '_' {
+		} else if (char == ' ' || char == '-' || char == '_') && !strings.HasSuffix(slug, "-") {
 			slug += "-"
 		}
 	}
+	slug = strings.Trim(slug, "-")
 	if slug == "" {
 		slug = "project"
 	}
 	return slug
 }
+
+// validateSlug checks the slug is a usable, non-reserved hostname label
+func validateSlug(slug string) error {
+	if hostnameMgr == nil {
+		return hostname.ValidateLabel(slug)
+	}
+	return hostnameMgr.ValidateSlug(slug)
+}
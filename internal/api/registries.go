@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/registry"
+	"deploy-platform/internal/secrets"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maskedPassword stands for the password of registry credentials in responses
+const maskedPassword = "********"
+
+// RegistryCredentialRequest sets the credentials a project pulls images of a
+// private registry with. Setting them again for the same registry rotates them.
+type RegistryCredentialRequest struct {
+	Host     string `json:"host" binding:"required"`     // e.g. registry.example.com:5000, or docker.io
+	Username string `json:"username" binding:"required"` // Some registries take any username with a token
+	Password string `json:"password" binding:"required"` // Password or access token
+}
+
+// RegistryCredentialResponse is a project's credentials for one registry, as
+// shown: the password is always masked
+type RegistryCredentialResponse struct {
+	ID         uint       `json:"id"`
+	Host       string     `json:"host"`
+	Username   string     `json:"username"`
+	Password   string     `json:"password"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func registryCredentialResponse(credential *models.RegistryCredential) RegistryCredentialResponse {
+	return RegistryCredentialResponse{
+		ID:         credential.ID,
+		Host:       credential.Host,
+		Username:   credential.Username,
+		Password:   maskedPassword,
+		VerifiedAt: credential.VerifiedAt,
+		UpdatedAt:  credential.UpdatedAt,
+	}
+}
+
+// registryCredentials lists a project's registry credentials as shown
+func registryCredentials(projectID uint) ([]RegistryCredentialResponse, error) {
+	var saved []models.RegistryCredential
+	if err := database.DB.Where("project_id = ?", projectID).Order("host").Find(&saved).Error; err != nil {
+		return nil, err
+	}
+	credentials := make([]RegistryCredentialResponse, 0, len(saved))
+	for i := range saved {
+		credentials = append(credentials, registryCredentialResponse(&saved[i]))
+	}
+	return credentials, nil
+}
+
+// GetRegistryCredentials lists the registries a project has credentials for
+func GetRegistryCredentials(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	credentials, err := registryCredentials(project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch registry credentials"})
+		return
+	}
+	c.JSON(http.StatusOK, credentials)
+}
+
+// SetRegistryCredential saves, or rotates, a project's credentials for a
+// registry once the registry accepts them. The next build pulls base images
+// with them, and the pods of the next deploy pull images with them; rotated
+// credentials reach pods already using them right away, through the
+// project's image pull Secret.
+func SetRegistryCredential(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	var req RegistryCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	host, err := registry.NormalizeHost(req.Host)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), httpclient.CallTimeout)
+	defer cancel()
+	if err := registry.Verify(ctx, registry.Credentials{Host: host, Username: req.Username, Password: req.Password}); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, registry.ErrRejected) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": "Registry credentials not saved: " + err.Error()})
+		return
+	}
+
+	encrypted, err := secrets.Encrypt(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt password"})
+		return
+	}
+	now := time.Now()
+	var credential models.RegistryCredential
+	rotated := false
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("project_id = ? AND host = ?", project.ID, host).First(&credential).Error
+		switch {
+		case err == nil:
+			rotated = true
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			credential = models.RegistryCredential{ProjectID: project.ID, Host: host}
+		default:
+			return err
+		}
+		credential.Username = req.Username
+		credential.Password = encrypted
+		credential.VerifiedAt = &now
+		if err := tx.Save(&credential).Error; err != nil {
+			return err
+		}
+		return tx.Model(project).Update("image_pull_secret", kubernetes.PullSecretName(project.ID)).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save registry credentials"})
+		return
+	}
+	audit.FromContext(c, "project.registry_credentials", fmt.Sprintf("project %d: %s set for %s (rotated=%t)", project.ID, req.Username, host, rotated))
+
+	if err := applyPullSecret(c.Request.Context(), project); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Registry credentials saved, but the cluster could not be updated (the next deploy retries): " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"credential": registryCredentialResponse(&credential), "rotated": rotated})
+}
+
+// DeleteRegistryCredential removes a project's credentials for a registry;
+// images already pulled with them stay
+func DeleteRegistryCredential(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	credentialID, err := strconv.ParseUint(c.Param("credentialID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+
+	var credential models.RegistryCredential
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ?", project.ID).First(&credential, credentialID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&credential).Error; err != nil {
+			return err
+		}
+		var remaining int64
+		if err := tx.Model(&models.RegistryCredential{}).Where("project_id = ?", project.ID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return tx.Model(project).Update("image_pull_secret", "").Error
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Registry credential not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete registry credentials"})
+		return
+	}
+	audit.FromContext(c, "project.registry_credentials", fmt.Sprintf("project %d: removed for %s", project.ID, credential.Host))
+
+	if err := applyPullSecret(c.Request.Context(), project); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Registry credentials deleted, but the cluster could not be updated (the next deploy retries): " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Registry credentials deleted"})
+}
+
+// applyPullSecret updates the image pull Secret of project in its cluster
+// after its registry credentials changed. Archived projects and projects
+// whose cluster isn't available get theirs on their next deploy.
+func applyPullSecret(ctx context.Context, project *models.Project) error {
+	client := k8sClients.ForProject(project)
+	if client == nil || project.Archived() {
+		return nil
+	}
+	dockerConfig, err := registry.DockerConfig(project.ID)
+	if err != nil {
+		return err
+	}
+	if err := client.ApplyPullSecret(ctx, project, dockerConfig); err != nil {
+		log.Printf("❌ Failed to update the image pull secret of project %d: %v", project.ID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Passwords never leave the API, and deleting a project's last credentials
+// stops its pods referencing the pull Secret. Saving credentials against a
+// fake registry is covered by the registry package.
+func TestRegistryCredentials(t *testing.T) {
+	testutil.DB(t)
+	if err := secrets.Init(&config.Config{EncryptionKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", Slug: "app", UserID: user.ID, ImagePullSecret: kubernetes.PullSecretName(1)}
+	database.DB.Create(project)
+	var credentials []*models.RegistryCredential
+	for _, host := range []string{"registry.example.com", "ghcr.io"} {
+		encrypted, _ := secrets.Encrypt("s3cret")
+		credential := &models.RegistryCredential{ProjectID: project.ID, Host: host, Username: "ci", Password: encrypted}
+		database.DB.Create(credential)
+		credentials = append(credentials, credential)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.GET("/projects/:id/registry-credentials", GetRegistryCredentials)
+	r.PUT("/projects/:id/registry-credentials", SetRegistryCredential)
+	r.DELETE("/projects/:id/registry-credentials/:credentialID", DeleteRegistryCredential)
+	path := fmt.Sprintf("/projects/%d/registry-credentials", project.ID)
+
+	w := serveJSON(r, http.MethodGet, path, nil)
+	var listed []RegistryCredentialResponse
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if w.Code != http.StatusOK || len(listed) != 2 || listed[0].Host != "ghcr.io" || strings.Contains(w.Body.String(), "s3cret") || strings.Contains(w.Body.String(), "enc:") {
+		t.Fatalf("list: got %d: %s", w.Code, w.Body.String())
+	}
+	for _, credential := range listed {
+		if credential.Password != maskedPassword {
+			t.Errorf("%s: password %q", credential.Host, credential.Password)
+		}
+	}
+
+	// Nothing is saved for a host that isn't one, or a registry that can't
+	// be reached
+	for body, want := range map[string]int{
+		`{"host": "registry.example.com/team/base", "username": "ci", "password": "x"}`: http.StatusBadRequest,
+		`{"host": "registry.example.com"}`:                                              http.StatusBadRequest,
+		`{"host": "127.0.0.1:5000", "username": "ci", "password": "x"}`:                 http.StatusBadGateway,
+	} {
+		var req map[string]any
+		json.Unmarshal([]byte(body), &req)
+		if w := serveJSON(r, http.MethodPut, path, req); w.Code != want {
+			t.Errorf("%s: got %d, want %d: %s", body, w.Code, want, w.Body.String())
+		}
+	}
+	var count int64
+	database.DB.Model(&models.RegistryCredential{}).Count(&count)
+	if count != 2 {
+		t.Errorf("%d credentials saved", count)
+	}
+
+	// The pull Secret stays referenced until the last credentials go
+	var saved models.Project
+	for i, credential := range credentials {
+		if w := serveJSON(r, http.MethodDelete, fmt.Sprintf("%s/%d", path, credential.ID), nil); w.Code != http.StatusOK {
+			t.Fatalf("delete: got %d: %s", w.Code, w.Body.String())
+		}
+		database.DB.First(&saved, project.ID)
+		if last := i == len(credentials)-1; (saved.ImagePullSecret == "") != last {
+			t.Errorf("after deleting %s: image pull secret %q", credential.Host, saved.ImagePullSecret)
+		}
+	}
+	if w := serveJSON(r, http.MethodDelete, fmt.Sprintf("%s/%d", path, credentials[0].ID), nil); w.Code != http.StatusNotFound {
+		t.Errorf("delete again: got %d", w.Code)
+	}
+
+	other := &models.Project{Name: "other", Slug: "other", UserID: user.ID + 1}
+	database.DB.Create(other)
+	if w := serveJSON(r, http.MethodGet, fmt.Sprintf("/projects/%d/registry-credentials", other.ID), nil); w.Code != http.StatusForbidden {
+		t.Errorf("other user's project: got %d", w.Code)
+	}
+}
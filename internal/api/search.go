@@ -0,0 +1,96 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/search"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProjectSearchResult is a project found by GET /search
+type ProjectSearchResult struct {
+	ProjectSummary
+	RepoURL    string             `json:"repo_url"`
+	Archived   bool               `json:"archived,omitempty"`
+	Score      int                `json:"score"`
+	Highlights []search.Highlight `json:"highlights"`
+}
+
+// DeploymentSearchResult is a deployment found by GET /search
+type DeploymentSearchResult struct {
+	DeploymentSummary
+	Score      int                `json:"score"`
+	Highlights []search.Highlight `json:"highlights"`
+}
+
+// SearchProjects is the projects group of a search response
+type SearchProjects struct {
+	Results []ProjectSearchResult `json:"results"`
+	More    bool                  `json:"more"` // More matched than limit
+}
+
+// SearchDeployments is the deployments group of a search response
+type SearchDeployments struct {
+	Results []DeploymentSearchResult `json:"results"`
+	More    bool                     `json:"more"` // More matched than limit
+}
+
+// Search finds the caller's projects (by name, slug and repository) and
+// deployments (by commit message, SHA prefix, branch and failure reason)
+// matching ?q=, best first, grouped by type, at most ?limit= (default 10, up
+// to 50) of each. Besides words and "quoted phrases", every one of which a
+// result must match, q takes type:project|deployment, status:<status>
+// (repeatable), branch:<name> and project:<slug>. Highlights locate the
+// matches in each result's fields, for the dashboard to emphasize. A type
+// left out of the response wasn't searched, e.g. projects when status: is set.
+func Search(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+		return
+	}
+	query, err := search.Parse(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := search.Run(database.DB, c.GetUint("user_id"), query, limit)
+	if err != nil {
+		log.Printf("❌ Search failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	response := gin.H{}
+	if query.Projects() {
+		projects := SearchProjects{Results: []ProjectSearchResult{}, More: results.MoreProjects}
+		for _, hit := range results.Projects {
+			result := ProjectSearchResult{
+				ProjectSummary: ProjectSummary{ID: hit.Project.ID, Name: hit.Project.Name, Slug: hit.Project.Slug},
+				RepoURL:        hit.Project.RepoURL,
+				Archived:       hit.Project.Archived(),
+				Score:          hit.Score,
+				Highlights:     hit.Highlights,
+			}
+			projects.Results = append(projects.Results, result)
+		}
+		response["projects"] = projects
+	}
+	if query.Deployments() {
+		deployments := SearchDeployments{Results: []DeploymentSearchResult{}, More: results.MoreDeployments}
+		for i := range results.Deployments {
+			hit := &results.Deployments[i]
+			deployments.Results = append(deployments.Results, DeploymentSearchResult{
+				DeploymentSummary: summarizeDeployment(&hit.Deployment),
+				Score:             hit.Score,
+				Highlights:        hit.Highlights,
+			})
+		}
+		response["deployments"] = deployments
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/settings"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var k8sClients *kubernetes.ClientSet
+
+// InitKubernetes sets the clusters settings changes are applied to right away
+func InitKubernetes(c *kubernetes.ClientSet) {
+	k8sClients = c
+}
+
+// validateCluster checks that projects can deploy to the named cluster
+func validateCluster(name string) error {
+	if !k8sClients.Has(name) {
+		return fmt.Errorf("unknown cluster %q, available: %s", name, strings.Join(k8sClients.Names(), ", "))
+	}
+	return nil
+}
+
+// GetProjectSettings returns a project's settings
+func GetProjectSettings(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+	credentials, err := registryCredentials(project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch registry credentials"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ingress":                 project.Ingress,
+		"strict_image_budget":     project.StrictImageBudget,
+		"port":                    project.Port,
+		"visibility":              project.Visibility,
+		"custom_labels":           project.Labels,
+		"custom_annotations":      project.Annotations,
+		"build_commands":          project.BuildCommands,
+		"runtime_version":         project.RuntimeVersion,
+		"process_type":            project.ProcessType,
+		"release_command":         project.ReleaseCommand,
+		"public_badge":            project.PublicBadge,
+		"placeholder_private":     project.PlaceholderPrivate,
+		"require_approval":        project.RequireApproval,
+		"approval_window_minutes": project.ApprovalWindowMinutes,
+		"manifest_patches":        project.ManifestPatches,
+		"keep_build_logs_local":   project.KeepBuildLogsLocal,
+		"preview_provisioner":     project.PreviewProvisioner,
+		"analysis_only":           project.AnalysisOnly,
+		"branch_deploys":          project.BranchDeploys,
+		"deploy_policy":           project.DeployPolicy,
+		"cluster":                 k8sClients.ClusterOf(project),
+		"migrating_from":          project.MigratingFrom,
+		// Set through /registry-credentials, passwords masked
+		"registry_credentials": credentials,
+	})
+}
+
+// UpdateProjectSettings replaces a project's settings; they take effect on
+// the next deployment, except visibility, which adds or removes the
+// project's Ingresses right away, and custom labels and annotations, patched
+// onto its existing objects. A change of build commands offers a rebuild.
+// Switching to a worker removes the Service and Ingress on the next deploy.
+// Leaving analysis-only mode offers to deploy the latest analyzed commit.
+func UpdateProjectSettings(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+
+	var req settings.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if problems := settings.Validate(&req); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problems[0].Message, "problems": problems})
+		return
+	}
+
+	previous := *project
+	changes := settings.Diff(project, &req)
+	settings.Apply(project, &req)
+	// Select lists the columns so nil settings are written as NULL
+	if err := database.DB.Model(project).Select(settings.Columns).Updates(project).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings"})
+		return
+	}
+
+	// Archived projects have nothing in the cluster to update
+	if changes.Has("visibility") && !project.Archived() {
+		if err := applyVisibility(c.Request.Context(), project); err != nil {
+			log.Printf("❌ Failed to apply visibility %s to project %d: %v", project.Visibility, project.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Settings saved, but the cluster could not be updated (the next deploy retries): " + err.Error()})
+			return
+		}
+		log.Printf("🔒 Project %d is now %s", project.ID, project.Visibility)
+	}
+	if client := k8sClients.ForProject(project); changes.Has("custom_labels", "custom_annotations") && client != nil && !project.Archived() {
+		if err := client.RelabelProject(c.Request.Context(), project, &previous); err != nil {
+			log.Printf("❌ Failed to relabel the objects of project %d: %v", project.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Settings saved, but the cluster could not be updated (the next deploy retries): " + err.Error()})
+			return
+		}
+	}
+
+	response := gin.H{
+		"ingress":                 project.Ingress,
+		"annotations":             kubernetes.IngressAnnotations(project.Ingress),
+		"strict_image_budget":     project.StrictImageBudget,
+		"port":                    project.Port,
+		"visibility":              project.Visibility,
+		"custom_labels":           project.Labels,
+		"custom_annotations":      project.Annotations,
+		"build_commands":          project.BuildCommands,
+		"runtime_version":         project.RuntimeVersion,
+		"process_type":            project.ProcessType,
+		"release_command":         project.ReleaseCommand,
+		"public_badge":            project.PublicBadge,
+		"placeholder_private":     project.PlaceholderPrivate,
+		"require_approval":        project.RequireApproval,
+		"approval_window_minutes": project.ApprovalWindowMinutes,
+		"manifest_patches":        project.ManifestPatches,
+		"keep_build_logs_local":   project.KeepBuildLogsLocal,
+		"preview_provisioner":     project.PreviewProvisioner,
+		"analysis_only":           project.AnalysisOnly,
+		"branch_deploys":          project.BranchDeploys,
+		"deploy_policy":           project.DeployPolicy,
+		"cluster":                 k8sClients.ClusterOf(project),
+	}
+	// Build commands and runtime versions only apply to new builds: offer to rebuild the production branch
+	if changes.Has("build_commands", "runtime_version") {
+		response["rebuild"] = rebuildOffer(project)
+	}
+	// Leaving analysis-only mode: offer to deploy what was analyzed last
+	if changes.Has("analysis_only") && !project.AnalysisOnly {
+		if offer := deployAnalyzedOffer(project); offer != nil {
+			response["deploy_analyzed"] = offer
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ValidateProjectSettings is a dry run of UpdateProjectSettings: it takes the
+// same body, checks it the same way and, without saving anything, lists the
+// problems the update would be refused for, the settings it would change and
+// what changing each one does, and warnings about settings that would have
+// no effect or a rebuild that couldn't run
+func ValidateProjectSettings(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+
+	var req settings.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	problems := settings.Validate(&req)
+	if len(problems) > 0 {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "problems": problems})
+		return
+	}
+
+	changes := settings.Diff(project, &req)
+	response := gin.H{
+		"valid":    true,
+		"problems": []settings.Problem{},
+		"changes":  append(settings.Changes{}, changes...),
+		"warnings": append([]string{}, settings.Warnings(project, &req, changes)...),
+	}
+	if changes.Has("build_commands", "runtime_version") {
+		response["rebuild"] = rebuildOffer(project)
+	}
+	if changes.Has("analysis_only") && !req.AnalysisOnly {
+		if offer := deployAnalyzedOffer(project); offer != nil {
+			response["deploy_analyzed"] = offer
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// rebuildOffer is the request rebuilding the project's production branch,
+// for clients to offer once build commands or the runtime version changed
+func rebuildOffer(project *models.Project) gin.H {
+	return gin.H{
+		"method": http.MethodPost,
+		"path":   fmt.Sprintf("/api/projects/%d/deployments", project.ID),
+		"body":   gin.H{"ref": project.Branch, "promote": true},
+	}
+}
+
+// deployAnalyzedOffer is the request deploying the latest commit analyzed
+// successfully on the project's production branch, for clients to offer when
+// the project leaves analysis-only mode; nil when there is none
+func deployAnalyzedOffer(project *models.Project) gin.H {
+	var analysis models.Analysis
+	if err := database.DB.Where("project_id = ? AND branch = ? AND status = ?", project.ID, project.Branch, models.AnalysisSucceeded).
+		Order("id DESC").Limit(1).Find(&analysis).Error; err != nil || analysis.ID == 0 {
+		return nil
+	}
+	return gin.H{
+		"method":   http.MethodPost,
+		"path":     fmt.Sprintf("/api/projects/%d/deployments", project.ID),
+		"body":     gin.H{"ref": analysis.CommitSHA, "promote": true},
+		"analysis": analysis,
+	}
+}
+
+// applyVisibility adds or removes the Ingress (and NetworkPolicy) of each of
+// the project's running resources. Hostnames stay reserved while the project
+// is internal, so it gets them back when made public again; a production
+// deployment rolled out while internal is given its hostname then.
+func applyVisibility(ctx context.Context, project *models.Project) error {
+	if k8sClients == nil {
+		return nil
+	}
+
+	var hostnames []models.Hostname
+	if err := database.DB.Preload("Deployment").Where("project_id = ? AND is_active = ?", project.ID, true).Find(&hostnames).Error; err != nil {
+		return err
+	}
+	if hostnameMgr != nil {
+		var err error
+		if project.CustomDomains, err = hostnameMgr.CustomDomains(project.ID); err != nil {
+			return err
+		}
+	}
+	applied := map[string]bool{}
+	for _, h := range hostnames {
+		name := h.Deployment.K8sDeploymentName
+		client := k8sClients.Client(h.Deployment.Cluster)
+		if name == "" || applied[name] || client == nil {
+			continue
+		}
+		applied[name] = true
+		h.Deployment.Project = *project
+		if err := client.ApplyVisibility(ctx, &h.Deployment, h.Hostname); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	production := kubernetes.ProjectResourceName(project.ID)
+	if applied[production] {
+		return nil
+	}
+	var live models.Deployment
+	if database.DB.Where("project_id = ? AND k8s_deployment_name = ? AND status = ?", project.ID, production, models.StatusDeployed).
+		Order("id DESC").First(&live).Error != nil {
+		return nil // Never rolled out
+	}
+	host := ""
+	if project.Served() && hostnameMgr != nil {
+		var err error
+		if host, err = hostnameMgr.AssignHostname(project.ID, live.ID, live.CommitSHA); err != nil {
+			return fmt.Errorf("failed to assign hostname: %w", err)
+		}
+	}
+	live.Project = *project
+	client := k8sClients.Client(live.Cluster)
+	if client == nil {
+		return nil
+	}
+	if err := client.ApplyVisibility(ctx, &live, host); err != nil {
+		return fmt.Errorf("%s: %w", production, err)
+	}
+	return nil
+}
+
+// GetProjectManifests is a dry run of the project's production rollout: the
+// Deployment, Service and Ingress the next deploy would apply, built from
+// the current settings and the latest image with the manifest patches
+// applied, and the Job running its release command, if any
+func GetProjectManifests(c *gin.Context) {
+	project, ok := ownedProject(c)
+	if !ok {
+		return
+	}
+
+	deployment := models.Deployment{
+		ProjectID:         project.ID,
+		ImageTag:          "<next build>",
+		K8sDeploymentName: kubernetes.ProjectResourceName(project.ID),
+		Project:           *project,
+	}
+	var latest models.Deployment
+	if database.DB.Where("project_id = ? AND image_tag <> ''", project.ID).Order("id DESC").First(&latest).Error == nil {
+		deployment.ImageTag = latest.ImageTag
+	}
+
+	host := ""
+	if hostnameMgr != nil && project.Served() {
+		host = hostnameMgr.ProductionHostname(project)
+		deployment.Project.CustomDomains, _ = hostnameMgr.CustomDomains(project.ID)
+	}
+
+	// Variables detected at build time (PORT, compose environment) are only known then
+	envVars := map[string]string{"PORT": "8080"}
+	if project.Port > 0 {
+		envVars["PORT"] = strconv.Itoa(project.Port)
+	}
+	siblings, err := models.InternalSiblings(database.DB, project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up internal services"})
+		return
+	}
+	for k, v := range kubernetes.ServiceEnv(siblings) {
+		envVars[k] = v
+	}
+
+	// Shown as patched; patches that don't apply are shown with the unpatched manifests
+	deployment.ManifestPatches = project.ManifestPatches
+	rendered, patchErr := kubernetes.BuildPatchedManifests(&deployment, host, envVars)
+	if patchErr != nil {
+		rendered = kubernetes.BuildManifests(&deployment, host, envVars)
+	}
+	manifests := rendered.Redacted()
+	response := gin.H{
+		"visibility": project.Visibility,
+		"manifests":  manifests,
+	}
+	if patchErr != nil {
+		response["manifest_patch_error"] = patchErr.Error()
+	}
+	switch {
+	case manifests.Ingress != nil:
+		response["annotations"] = manifests.Ingress.Annotations
+	case manifests.Service != nil:
+		response["internal_url"] = kubernetes.ServiceURL(deployment.K8sDeploymentName)
+	}
+	if project.ReleaseCommand != "" {
+		response["release_job"] = kubernetes.BuildReleaseJob(&deployment, project.ReleaseCommand, kubernetes.SecretName(kubernetes.ReleaseJobName(deployment.ID)))
+	}
+	c.JSON(http.StatusOK, response)
+}
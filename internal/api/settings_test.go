@@ -0,0 +1,96 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/settings"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The dry run and the update check settings the same way: what one refuses
+// the other refuses with the same problems, and what one accepts the other
+// saves, with the changes the dry run described
+func TestValidateProjectSettings(t *testing.T) {
+	testutil.DB(t)
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	project := &models.Project{Name: "app", Slug: "app", UserID: user.ID, Branch: "main", Port: 8080, Visibility: models.VisibilityPublic, ProcessType: models.ProcessWeb}
+	database.DB.Create(project)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.PUT("/projects/:id/settings", UpdateProjectSettings)
+	r.POST("/projects/:id/settings/validate", ValidateProjectSettings)
+	path := fmt.Sprintf("/projects/%d/settings", project.ID)
+
+	type result struct {
+		Valid    bool               `json:"valid"`
+		Problems []settings.Problem `json:"problems"`
+		Changes  []settings.Change  `json:"changes"`
+		Warnings []string           `json:"warnings"`
+	}
+	check := func(body map[string]any) (result, result, int) {
+		t.Helper()
+		w := serveJSON(r, http.MethodPost, path+"/validate", body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("validate: got %d: %s", w.Code, w.Body.String())
+		}
+		var dryRun, update result
+		json.Unmarshal(w.Body.Bytes(), &dryRun)
+		w = serveJSON(r, http.MethodPut, path, body)
+		json.Unmarshal(w.Body.Bytes(), &update)
+		return dryRun, update, w.Code
+	}
+
+	invalid := map[string]any{"port": 70000, "process_type": "cron", "manifest_patches": []map[string]any{
+		{"kind": "Deployment", "type": "json6902", "patch": `[{"op": "add", "path": "/spec/template/spec/hostNetwork", "value": true}]`},
+	}}
+	dryRun, update, code := check(invalid)
+	if dryRun.Valid || len(dryRun.Problems) != 3 || code != http.StatusBadRequest || !reflect.DeepEqual(dryRun.Problems, update.Problems) {
+		t.Errorf("invalid: dry run %+v, update %d %+v", dryRun, code, update)
+	}
+	var saved models.Project
+	database.DB.First(&saved, project.ID)
+	if saved.Port != 8080 {
+		t.Errorf("invalid settings saved: port %d", saved.Port)
+	}
+
+	// Validating saves nothing
+	valid := map[string]any{"port": 3000, "process_type": "worker", "release_command": "npm run migrate"}
+	w := serveJSON(r, http.MethodPost, path+"/validate", valid)
+	json.Unmarshal(w.Body.Bytes(), &dryRun)
+	database.DB.First(&saved, project.ID)
+	if !dryRun.Valid || len(dryRun.Changes) != 3 || saved.Port != 8080 || saved.ProcessType != models.ProcessWeb {
+		t.Fatalf("dry run %+v, saved %+v", dryRun, saved)
+	}
+	if want := []string{"workers listen on no port: port 3000 is ignored"}; !reflect.DeepEqual(dryRun.Warnings, want) {
+		t.Errorf("warnings %q, want %q", dryRun.Warnings, want)
+	}
+	if w := serveJSON(r, http.MethodPut, path, valid); w.Code != http.StatusOK {
+		t.Fatalf("update: got %d: %s", w.Code, w.Body.String())
+	}
+	database.DB.First(&saved, project.ID)
+	if saved.Port != 3000 || saved.ProcessType != models.ProcessWorker || saved.ReleaseCommand != "npm run migrate" {
+		t.Errorf("saved %+v", saved)
+	}
+
+	// Once saved, the same settings change nothing
+	w = serveJSON(r, http.MethodPost, path+"/validate", valid)
+	json.Unmarshal(w.Body.Bytes(), &dryRun)
+	if !dryRun.Valid || len(dryRun.Changes) != 0 {
+		t.Errorf("after saving: %+v", dryRun)
+	}
+
+	other := &models.Project{Name: "other", Slug: "other", UserID: user.ID + 1}
+	database.DB.Create(other)
+	if w := serveJSON(r, http.MethodPost, fmt.Sprintf("/projects/%d/settings/validate", other.ID), valid); w.Code != http.StatusForbidden {
+		t.Errorf("other user's project: got %d", w.Code)
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errShuttingDown ends the streams open when the platform shuts down
+var errShuttingDown = errors.New("the platform is shutting down, connect again")
+
+// streams is cancelled by CloseStreams
+var streams, closeStreams = context.WithCancelCause(context.Background())
+
+// CloseStreams ends the responses that last as long as their client wants,
+// i.e. followed logs and commands, so that a server shutting down doesn't
+// wait for them
+func CloseStreams() {
+	closeStreams(errShuttingDown)
+}
+
+// streamContext is the context of a streamed response: the request's, also
+// cancelled with errShuttingDown as its cause by CloseStreams
+func streamContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(c.Request.Context())
+	stop := context.AfterFunc(streams, func() { cancel(context.Cause(streams)) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/storage"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	provenanceSigner *build.Signer
+	artifactStorage  storage.Storage
+)
+
+// InitProvenanceSigner sets the key provenance records are signed with, nil
+// when they are unsigned
+func InitProvenanceSigner(signer *build.Signer) {
+	provenanceSigner = signer
+}
+
+// InitArtifactStorage sets the storage supply chain records are kept in, nil
+// when they are kept in the database
+func InitArtifactStorage(store storage.Storage) {
+	artifactStorage = store
+}
+
+// GetDeploymentSBOM returns the CycloneDX SBOM of a deployment's image
+func GetDeploymentSBOM(c *gin.Context) {
+	serveBuildArtifact(c, models.ArtifactSBOM, "SBOM")
+}
+
+// GetDeploymentProvenance returns the provenance of a deployment's image: a
+// DSSE envelope of an in-toto statement, signed when the platform has a key
+func GetDeploymentProvenance(c *gin.Context) {
+	serveBuildArtifact(c, models.ArtifactProvenance, "provenance")
+}
+
+// GetProvenanceKey returns the public key verifying provenance signatures
+func GetProvenanceKey(c *gin.Context) {
+	if provenanceSigner == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provenance is not signed"})
+		return
+	}
+	c.Header("X-Key-ID", provenanceSigner.KeyID())
+	c.Data(http.StatusOK, "application/x-pem-file", provenanceSigner.PublicKeyPEM())
+}
+
+// serveBuildArtifact sends the record of kind of the deployment's latest
+// build having one: as stored to clients accepting gzip, decompressed to
+// others
+func serveBuildArtifact(c *gin.Context, kind, name string) {
+	deployment, ok := ownedDeployment(c)
+	if !ok {
+		return
+	}
+
+	var artifact models.BuildArtifact
+	builds := database.DB.Model(&models.Build{}).Select("id").Where("deployment_id = ?", deployment.ID)
+	if err := database.DB.Where("kind = ? AND build_id IN (?)", kind, builds).Order("build_id DESC").First(&artifact).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Deployment has no %s", name)})
+		return
+	}
+
+	etag := `"` + artifact.SHA256 + `"`
+	c.Header("ETag", etag)
+	c.Header("Vary", "Accept-Encoding")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="deployment-%d-%s.json"`, deployment.ID, kind))
+
+	var content io.Reader = bytes.NewReader(artifact.Data)
+	if artifact.Object != "" {
+		object, err := openArtifactObject(c.Request.Context(), artifact.Object)
+		if err != nil {
+			log.Printf("❌ Failed to read %s of build %d from %s: %v", kind, artifact.BuildID, artifact.Object, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to read %s", name)})
+			return
+		}
+		defer object.Close()
+		content = object
+	}
+
+	if strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		c.Header("Content-Encoding", "gzip")
+		c.DataFromReader(http.StatusOK, -1, artifact.MediaType, content, nil)
+		return
+	}
+	zr, err := gzip.NewReader(content)
+	if err != nil {
+		log.Printf("⚠️  Stored %s of build %d is corrupt: %v", kind, artifact.BuildID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s", name)})
+		return
+	}
+	c.Header("Content-Type", artifact.MediaType)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, zr); err != nil {
+		log.Printf("⚠️  Sending %s of build %d failed: %v", kind, artifact.BuildID, err)
+	}
+}
+
+// openArtifactObject opens a record kept in the artifact storage
+func openArtifactObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	if artifactStorage == nil {
+		return nil, errors.New("SUPPLY_CHAIN_STORAGE is not configured")
+	}
+	return artifactStorage.Get(ctx, key)
+}
+
+// pruneArtifactObjects deletes the stored records no build refers to anymore,
+// after theirs were deleted. Failures leave an orphan object behind.
+func pruneArtifactObjects(ctx context.Context, keys []string) {
+	if artifactStorage == nil {
+		return
+	}
+	for _, key := range keys {
+		var count int64
+		if database.DB.Model(&models.BuildArtifact{}).Where("object = ?", key).Count(&count).Error != nil || count > 0 {
+			continue
+		}
+		if err := artifactStorage.Delete(ctx, key); err != nil {
+			log.Printf("⚠️  Failed to delete supply chain record %s: %v", key, err)
+		}
+	}
+}
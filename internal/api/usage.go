@@ -0,0 +1,37 @@
+package api
+
+import (
+	"deploy-platform/internal/quota"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUsageLimits returns the user's plan with current usage of every quota
+func GetUsageLimits(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	plan, usage, perProject, err := quota.UsageForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan":     plan,
+		"usage":    usage,
+		"projects": perProject,
+	})
+}
+
+// respondQuotaError writes the quota error response if err is a quota
+// violation, returning false for any other error
+func respondQuotaError(c *gin.Context, err error) bool {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+	c.JSON(exceeded.StatusCode(), exceeded.Body())
+	return true
+}
@@ -1,23 +1,70 @@
 package api
 
 import (
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/oauth"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-// ServeLogin serves the login page
+// UIConfig is what the login page and dashboard need to know about the
+// platform; nothing in it is secret
+type UIConfig struct {
+	PlatformName string      `json:"platform_name"`
+	BaseDomain   string      `json:"base_domain"`
+	Providers    UIProviders `json:"providers"`
+}
+
+// UIProviders tells which sign-in providers are configured
+type UIProviders struct {
+	GitHub   bool   `json:"github"`
+	Google   bool   `json:"google"`
+	OIDC     bool   `json:"oidc"`
+	OIDCName string `json:"oidc_name,omitempty"` // Label of the SSO button
+}
+
+var uiConfig UIConfig
+
+// InitUIConfig sets what GetUIConfig tells the frontend
+func InitUIConfig(cfg *config.Config) {
+	uiConfig = UIConfig{
+		PlatformName: cfg.PlatformName,
+		BaseDomain:   cfg.BaseDomain,
+		Providers: UIProviders{
+			GitHub: cfg.GitHubClientID != "" && cfg.GitHubClientSecret != "",
+			Google: cfg.GoogleClientID != "" && cfg.GoogleClientSecret != "",
+		},
+	}
+}
+
+// GetUIConfig returns the UIConfig, to anyone: the login page needs it
+func GetUIConfig(c *gin.Context) {
+	config := uiConfig
+	// OIDC is only enabled once its provider's discovery succeeded
+	config.Providers.OIDCName = oauth.OIDCProviderName()
+	config.Providers.OIDC = config.Providers.OIDCName != ""
+	c.JSON(http.StatusOK, config)
+}
+
+// ServeLogin serves the login page; signed-in users never reach it (see
+// auth.RedirectAuthenticated). Its sign-in buttons follow GetUIConfig.
 func ServeLogin(c *gin.Context) {
-	c.HTML(http.StatusOK, "login.html", nil)
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"Next": auth.SafeNext(c.Query("next")),
+	})
 }
 
-// ServeDashboard serves the dashboard page
+// ServeDashboard serves the dashboard page behind auth.PageGuard
 func ServeDashboard(c *gin.Context) {
-	// Check if user is authenticated
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		// Try to get from cookie or redirect
-		c.Redirect(http.StatusTemporaryRedirect, "/login")
+	// OAuth redirect: hand the token to the browser for API calls, then
+	// continue to the page the user started from
+	if queryToken := c.Query("token"); queryToken != "" {
+		c.HTML(http.StatusOK, "dashboard_redirect.html", gin.H{
+			"Token": queryToken,
+			"Next":  auth.SafeNext(c.Query("next")),
+		})
 		return
 	}
 
@@ -26,6 +73,17 @@ func ServeDashboard(c *gin.Context) {
 
 // ServeIndex redirects to dashboard or login
 func ServeIndex(c *gin.Context) {
-	// Check authentication via middleware or cookie
-	c.Redirect(http.StatusTemporaryRedirect, "/dashboard")
+	if auth.HasSession(c) {
+		c.Redirect(http.StatusFound, "/dashboard")
+		return
+	}
+	c.Redirect(http.StatusFound, "/login")
+}
+
+// ServeLogout clears the session cookie and returns to the login page. The
+// dashboard also sends users here when its stored token stops working, so
+// a stale cookie can't bounce them straight back.
+func ServeLogout(c *gin.Context) {
+	auth.ClearSession(c)
+	c.Redirect(http.StatusFound, "/login")
 }
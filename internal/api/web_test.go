@@ -0,0 +1,85 @@
+package api
+
+import (
+	"deploy-platform/internal/assets"
+	"deploy-platform/internal/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The pages and the UI config are served as the server routes them, from a
+// directory without web/
+func TestWebUI(t *testing.T) {
+	t.Chdir(t.TempDir())
+	gin.SetMode(gin.TestMode)
+	InitUIConfig(&config.Config{
+		PlatformName:       "Acme Deploy",
+		BaseDomain:         "apps.acme.com",
+		GitHubClientID:     "Iv1.abc",
+		GitHubClientSecret: "s3cret",
+		GoogleClientID:     "only-the-id.apps.googleusercontent.com",
+	})
+	t.Cleanup(func() { InitUIConfig(&config.Config{}) })
+	web, err := assets.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	if err := web.Register(r, "Acme Deploy"); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/login", ServeLogin)
+	r.GET("/dashboard", ServeDashboard)
+	r.GET("/api/ui-config", GetUIConfig)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/api/ui-config")
+	var ui map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &ui)
+	want := map[string]interface{}{
+		"platform_name": "Acme Deploy",
+		"base_domain":   "apps.acme.com",
+		"providers":     map[string]interface{}{"github": true, "google": false, "oidc": false},
+	}
+	if w.Code != http.StatusOK || !reflect.DeepEqual(ui, want) {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	pages := map[string]struct {
+		title string
+		files int // Static files linked
+	}{
+		"/login":     {"Login - Acme Deploy", 1},
+		"/dashboard": {"Dashboard - Acme Deploy", 2},
+	}
+	for path, want := range pages {
+		page := get(path).Body.String()
+		if !strings.Contains(page, "<title>"+want.title+"</title>") {
+			t.Errorf("%s: %s", path, page)
+		}
+		// Every static file the page links to is served
+		links := strings.Split(page, `"/static/`)[1:]
+		if len(links) != want.files {
+			t.Errorf("%s links to %d static files", path, len(links))
+		}
+		for _, link := range links {
+			url := "/static/" + link[:strings.IndexByte(link, '"')]
+			if w := get(url); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+				t.Errorf("%s links to %s: %d", path, url, w.Code)
+			}
+		}
+	}
+	if page := get("/login?next=/projects/3").Body.String(); !strings.Contains(page, "/projects/3") {
+		t.Errorf("login page lost where to go next")
+	}
+}
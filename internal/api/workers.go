@@ -0,0 +1,85 @@
+package api
+
+import (
+	"deploy-platform/internal/queue"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var workerPool *queue.WorkerPool
+
+// InitWorkerPool sets the build worker pool controlled by the admin API
+func InitWorkerPool(pool *queue.WorkerPool) {
+	workerPool = pool
+}
+
+// requireWorkerPool answers 503 when this instance runs no build workers
+func requireWorkerPool(c *gin.Context) bool {
+	if workerPool == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No build workers on this instance"})
+		return false
+	}
+	return true
+}
+
+// GetWorkers lists the build workers and whether they are drained
+func GetWorkers(c *gin.Context) {
+	if !requireWorkerPool(c) {
+		return
+	}
+	c.JSON(http.StatusOK, workerPool.Status())
+}
+
+// DrainWorkers stops workers from taking new jobs before a platform upgrade.
+// Running builds finish; poll GET /api/admin/workers until "drained".
+func DrainWorkers(c *gin.Context) {
+	if !requireWorkerPool(c) {
+		return
+	}
+	workerPool.Drain()
+	c.JSON(http.StatusAccepted, workerPool.Status())
+}
+
+// ResumeWorkers lets drained workers take jobs again
+func ResumeWorkers(c *gin.Context) {
+	if !requireWorkerPool(c) {
+		return
+	}
+	workerPool.Resume()
+	c.JSON(http.StatusOK, workerPool.Status())
+}
+
+// RestartWorker replaces a wedged worker: its job is cancelled and put back
+// at the front of the queue
+func RestartWorker(c *gin.Context) {
+	if !requireWorkerPool(c) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid worker ID"})
+		return
+	}
+
+	requeued, replacement, err := workerPool.Restart(id)
+	if err != nil {
+		if errors.Is(err, queue.ErrWorkerNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Worker not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"message":     "Worker restarted",
+		"replacement": replacement,
+	}
+	if requeued != 0 {
+		response["requeued_deployment_id"] = requeued
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDeploymentStatus returns how the pods of the resources a deployment was
+// rolled out to are doing right now, read from its cluster: ready replicas,
+// restarts, each pod's state and last termination, and the latest event
+// about them. Live tells whether those pods still run this deployment or
+// another one of the same resources took over since.
+func GetDeploymentStatus(c *gin.Context) {
+	deployment, ok := ownedDeployment(c)
+	if !ok {
+		return
+	}
+	if deployment.K8sDeploymentName == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment %d was never rolled out", deployment.ID)})
+		return
+	}
+	client := k8sClients.Client(deployment.Cluster)
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("Cluster %s is not available", deployment.Cluster)})
+		return
+	}
+
+	workload, err := client.WorkloadStatus(c.Request.Context(), deployment.K8sDeploymentName)
+	if errors.Is(err, kubernetes.ErrWorkloadNotFound) {
+		c.JSON(http.StatusGone, gin.H{"error": fmt.Sprintf("Resources %s are gone from the cluster", deployment.K8sDeploymentName)})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to read the pods of deployment %d: %v", deployment.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read the pods from the cluster: " + err.Error()})
+		return
+	}
+
+	var live int64
+	if err := database.DB.Model(&models.DeploymentActivation{}).
+		Where("deployment_id = ? AND deactivated_at IS NULL", deployment.ID).
+		Count(&live).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up the live deployment"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id": deployment.ID,
+		"status":        deployment.Status,
+		"cluster":       deployment.Cluster,
+		"live":          live > 0,
+		"workload":      workload,
+	})
+}
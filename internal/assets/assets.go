@@ -0,0 +1,145 @@
+// Package assets serves the dashboard's templates and static files, from
+// the binary or, for frontend development, from a directory on disk
+package assets
+
+import (
+	"crypto/sha256"
+	"deploy-platform/web"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	hashLength     = 10                                    // Hex digits of the content hash in static URLs
+	immutableCache = "public, max-age=31536000, immutable" // Hashed URLs change with their content
+)
+
+// Assets are the templates and static files of the web UI
+type Assets struct {
+	templates fs.FS
+	static    fs.FS
+	dir       string // Directory they are read from, "" = embedded
+
+	urls   map[string]string // Static file name -> its hashed URL, "app.js" -> "/static/app.1a2b3c4d5e.js"
+	hashed map[string]string // Hashed file name -> static file name
+	etags  map[string]string // Static file name -> its ETag
+}
+
+// Load returns the embedded assets, or those of dir (with templates/ and
+// static/ like web/) when set. Files of dir are read on every request, so
+// edits show on reload; their URLs aren't hashed nor cached.
+func Load(dir string) (*Assets, error) {
+	root := fs.FS(web.Files)
+	if dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, "templates")); err != nil {
+			return nil, fmt.Errorf("WEB_DIR %s has no templates directory: %v", dir, err)
+		}
+		root = os.DirFS(dir)
+	}
+	templates, err := fs.Sub(root, "templates")
+	if err != nil {
+		return nil, err
+	}
+	static, err := fs.Sub(root, "static")
+	if err != nil {
+		return nil, err
+	}
+	a := &Assets{templates: templates, static: static, dir: dir}
+	if dir == "" {
+		if err := a.hash(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// hash names every static file after its content
+func (a *Assets) hash() error {
+	a.urls = make(map[string]string)
+	a.hashed = make(map[string]string)
+	a.etags = make(map[string]string)
+	return fs.WalkDir(a.static, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(a.static, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		digest := hex.EncodeToString(sum[:])[:hashLength]
+		ext := path.Ext(name)
+		hashedName := strings.TrimSuffix(name, ext) + "." + digest + ext
+		a.urls[name] = "/static/" + hashedName
+		a.hashed[hashedName] = name
+		a.etags[name] = `"` + digest + `"`
+		return nil
+	})
+}
+
+// URL is the URL of the static file name, e.g. "app.js". Embedded files get
+// their content hash in the URL, so browsers keep them until they change.
+func (a *Assets) URL(name string) string {
+	if url, ok := a.urls[name]; ok {
+		return url
+	}
+	return "/static/" + name
+}
+
+// FuncMap is what templates can call: {{asset "app.js"}} for the URL of a
+// static file, {{platformName}} for the name the platform goes by
+func (a *Assets) FuncMap(platformName string) template.FuncMap {
+	return template.FuncMap{
+		"asset":        a.URL,
+		"platformName": func() string { return platformName },
+	}
+}
+
+// Register has r render the templates and serve the static files under
+// /static. Files of a directory are reloaded on every request, which gin
+// does in debug mode.
+func (a *Assets) Register(r *gin.Engine, platformName string) error {
+	if a.dir != "" {
+		r.SetFuncMap(a.FuncMap(platformName))
+		r.LoadHTMLGlob(filepath.Join(a.dir, "templates", "*"))
+	} else {
+		templates, err := template.New("").Funcs(a.FuncMap(platformName)).ParseFS(a.templates, "*.html")
+		if err != nil {
+			return err
+		}
+		r.SetHTMLTemplate(templates)
+	}
+	r.GET("/static/*filepath", a.serveStatic)
+	r.HEAD("/static/*filepath", a.serveStatic)
+	return nil
+}
+
+// serveStatic serves a static file. Hashed URLs are cached for a year; plain
+// ones, from old pages or a directory, are revalidated on every use.
+func (a *Assets) serveStatic(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("filepath"), "/")
+	if original, ok := a.hashed[name]; ok {
+		c.Header("Cache-Control", immutableCache)
+		name = original
+	} else {
+		c.Header("Cache-Control", "no-cache")
+	}
+	if etag, ok := a.etags[name]; ok {
+		c.Header("ETag", etag)
+	}
+	// No directory listings
+	if info, err := fs.Stat(a.static, name); err != nil || info.IsDir() {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	http.ServeFileFS(c.Writer, c.Request, a.static, name)
+}
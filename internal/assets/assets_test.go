@@ -0,0 +1,123 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serve has a router serving assets render page at /
+func serve(t *testing.T, assets *Assets, page string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := assets.Register(r, "Acme Deploy"); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/", func(c *gin.Context) { c.HTML(http.StatusOK, page, nil) })
+	return r
+}
+
+func get(r *gin.Engine, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+var hashedScript = regexp.MustCompile(`src="(/static/auth\.[0-9a-f]{10}\.js)"`)
+
+// The embedded assets don't need a web/ directory where the server runs.
+// Pages link static files by the hash of their content, cached for a year.
+func TestEmbedded(t *testing.T) {
+	t.Chdir(t.TempDir())
+	assets, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := serve(t, assets, "login.html")
+
+	page := get(r, "/")
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "<title>Login - Acme Deploy</title>") {
+		t.Fatalf("got %d: %s", page.Code, page.Body.String())
+	}
+	match := hashedScript.FindStringSubmatch(page.Body.String())
+	if match == nil || assets.URL("auth.js") != match[1] {
+		t.Fatalf("script %v, URL %s", match, assets.URL("auth.js"))
+	}
+
+	w := get(r, match[1])
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != immutableCache || w.Body.Len() == 0 || !strings.Contains(match[1], strings.Trim(etag, `"`)) {
+		t.Errorf("hashed URL: %d, %v", w.Code, w.Header())
+	}
+	if w := get(r, "/static/auth.js"); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" || w.Header().Get("ETag") != etag {
+		t.Errorf("plain URL: %d, %v", w.Code, w.Header())
+	}
+	if w := get(r, "/static/auth.js", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("revalidated: %d", w.Code)
+	}
+	for _, path := range []string{"/static/", "/static/auth.0000000000.js", "/static/../templates/login.html", "/static/missing.js"} {
+		if w := get(r, path); w.Code != http.StatusNotFound {
+			t.Errorf("%s: %d", path, w.Code)
+		}
+	}
+	if url := assets.URL("missing.js"); url != "/static/missing.js" {
+		t.Errorf("URL of a missing file %s", url)
+	}
+}
+
+// WEB_DIR is served as it is on disk, edits showing on the next request
+func TestDirectory(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	os.MkdirAll(filepath.Join(dir, "static"), 0755)
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("templates/page.html", `<title>{{platformName}}</title><script src="{{asset "app.js"}}"></script>`)
+	write("static/app.js", "v1")
+
+	assets, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.DebugMode) // Reloads templates on every request
+	t.Cleanup(func() { gin.SetMode(gin.TestMode) })
+	r := gin.New()
+	if err := assets.Register(r, "Acme Deploy"); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/", func(c *gin.Context) { c.HTML(http.StatusOK, "page.html", nil) })
+
+	if body := get(r, "/").Body.String(); body != `<title>Acme Deploy</title><script src="/static/app.js"></script>` {
+		t.Errorf("page %s", body)
+	}
+	if w := get(r, "/static/app.js"); w.Body.String() != "v1" || w.Header().Get("Cache-Control") != "no-cache" || w.Header().Get("ETag") != "" {
+		t.Errorf("got %q, %v", w.Body.String(), w.Header())
+	}
+
+	write("templates/page.html", `<h1>{{platformName}}</h1>`)
+	write("static/app.js", "v2")
+	if body := get(r, "/").Body.String(); body != "<h1>Acme Deploy</h1>" {
+		t.Errorf("edited page %s", body)
+	}
+	if body := get(r, "/static/app.js").Body.String(); body != "v2" {
+		t.Errorf("edited script %q", body)
+	}
+
+	if _, err := Load(filepath.Join(dir, "static")); err == nil || !strings.Contains(err.Error(), "has no templates directory") {
+		t.Errorf("got %v", err)
+	}
+}
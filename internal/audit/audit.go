@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Record writes an audit log entry. Failures are logged, never returned, so
+// auditing can't break the action being audited.
+func Record(entry *models.AuditLog) {
+	if err := database.DB.Create(entry).Error; err != nil {
+		log.Printf("⚠️  Failed to write audit log (%s by user %d): %v", entry.Action, entry.UserID, err)
+	}
+}
+
+// FromContext records action for the authenticated user of the request,
+// including the impersonating admin if there is one
+func FromContext(c *gin.Context, action, details string) {
+	entry := &models.AuditLog{
+		UserID:     c.GetUint("user_id"),
+		Action:     action,
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		StatusCode: c.Writer.Status(),
+		IP:         c.ClientIP(),
+		Details:    details,
+	}
+	if impersonatorID := c.GetUint("impersonator_id"); impersonatorID != 0 {
+		entry.ImpersonatorID = &impersonatorID
+	}
+	Record(entry)
+}
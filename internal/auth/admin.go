@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var adminEmails = map[string]bool{}
+
+// InitAdmins loads the admin email list from config
+func InitAdmins(cfg *config.Config) {
+	for _, email := range cfg.AdminEmails {
+		adminEmails[strings.ToLower(email)] = true
+	}
+}
+
+// IsAdmin reports whether the user is a platform admin, either flagged in the
+// database or listed in ADMIN_EMAILS
+func IsAdmin(user *models.User) bool {
+	return user.IsAdmin || (user.Email != "" && adminEmails[strings.ToLower(user.Email)])
+}
+
+// AdminMiddleware restricts a route group to platform admins.
+// Must be used after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user models.User
+		if err := database.DB.First(&user, c.GetUint("user_id")).Error; err != nil || !IsAdmin(&user) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"deploy-platform/internal/config"
+)
+
+var (
+	allowedEmailDomains []string
+	allowedGitHubOrgs   []string
+	strictRevalidate    bool
+)
+
+// ErrEmailNotVerified is returned when a provider reports an unverified email
+var ErrEmailNotVerified = errors.New("your email address is not verified with the identity provider")
+
+// InitAllowlist loads the sign-in restrictions from config
+func InitAllowlist(cfg *config.Config) {
+	allowedEmailDomains = nil
+	for _, domain := range cfg.AllowedEmailDomains {
+		allowedEmailDomains = append(allowedEmailDomains, strings.ToLower(strings.TrimPrefix(domain, "@")))
+	}
+	allowedGitHubOrgs = cfg.AllowedGitHubOrgs
+	strictRevalidate = cfg.StrictRevalidate
+}
+
+// EmailDomainsRestricted reports whether ALLOWED_EMAIL_DOMAINS is set
+func EmailDomainsRestricted() bool {
+	return len(allowedEmailDomains) > 0
+}
+
+// AllowedGitHubOrgs returns the orgs a GitHub user must belong to (empty = any)
+func AllowedGitHubOrgs() []string {
+	return allowedGitHubOrgs
+}
+
+// StrictRevalidate reports whether existing users are re-checked on every login
+func StrictRevalidate() bool {
+	return strictRevalidate
+}
+
+// CheckEmailDomain verifies a provider-supplied identity against
+// ALLOWED_EMAIL_DOMAINS. hostedDomain is the Google Workspace domain ("hd"),
+// empty for consumer accounts; when set it must match as well.
+func CheckEmailDomain(email string, verified bool, hostedDomain string) error {
+	if !EmailDomainsRestricted() {
+		return nil
+	}
+	if !verified {
+		return ErrEmailNotVerified
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return fmt.Errorf("invalid email address %q", email)
+	}
+	domain := strings.ToLower(email[at+1:])
+	if !domainAllowed(domain) {
+		return fmt.Errorf("accounts from %s are not allowed to sign in", domain)
+	}
+	if hostedDomain != "" && !domainAllowed(strings.ToLower(hostedDomain)) {
+		return fmt.Errorf("accounts from %s are not allowed to sign in", hostedDomain)
+	}
+	return nil
+}
+
+// CheckGitHubOrgs verifies that at least one of the user's active org
+// memberships is in ALLOWED_GITHUB_ORGS
+func CheckGitHubOrgs(memberOf []string) error {
+	if len(allowedGitHubOrgs) == 0 {
+		return nil
+	}
+	for _, org := range memberOf {
+		for _, allowed := range allowedGitHubOrgs {
+			if strings.EqualFold(org, allowed) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("you must be a member of one of these GitHub organizations: %s", strings.Join(allowedGitHubOrgs, ", "))
+}
+
+func domainAllowed(domain string) bool {
+	for _, allowed := range allowedEmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
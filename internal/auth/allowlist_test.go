@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"deploy-platform/internal/config"
+	"errors"
+	"testing"
+)
+
+// allowlist restricts sign-in as cfg does until the test ends
+func allowlist(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	InitAllowlist(cfg)
+	t.Cleanup(func() { InitAllowlist(&config.Config{}) })
+}
+
+func TestCheckEmailDomain(t *testing.T) {
+	allowlist(t, &config.Config{AllowedEmailDomains: []string{"@MyCompany.com", "partner.io"}})
+
+	tests := []struct {
+		name         string
+		email        string
+		verified     bool
+		hostedDomain string
+		allowed      bool
+	}{
+		{"allowed", "ada@mycompany.com", true, "", true},
+		{"allowed, other case", "Ada@MYCOMPANY.COM", true, "", true},
+		{"second domain", "bob@partner.io", true, "", true},
+		{"workspace account", "ada@mycompany.com", true, "mycompany.com", true},
+		{"disallowed domain", "eve@gmail.com", true, "", false},
+		{"subdomain", "eve@eng.mycompany.com", true, "", false},
+		{"suffix of another domain", "eve@notmycompany.com", true, "", false},
+		{"allowed email, other workspace", "eve@mycompany.com", true, "evil.com", false},
+		{"no domain", "mycompany.com", true, "", false},
+		{"unverified", "ada@mycompany.com", false, "mycompany.com", false},
+	}
+	for _, tt := range tests {
+		err := CheckEmailDomain(tt.email, tt.verified, tt.hostedDomain)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: CheckEmailDomain(%q, %v, %q) = %v", tt.name, tt.email, tt.verified, tt.hostedDomain, err)
+		}
+	}
+
+	if err := CheckEmailDomain("ada@mycompany.com", false, ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("unverified email: got %v", err)
+	}
+}
+
+// Without ALLOWED_EMAIL_DOMAINS anyone signs in, verified or not
+func TestCheckEmailDomainUnrestricted(t *testing.T) {
+	allowlist(t, &config.Config{})
+	if EmailDomainsRestricted() {
+		t.Error("restricted without ALLOWED_EMAIL_DOMAINS")
+	}
+	for _, email := range []string{"eve@gmail.com", "not an email"} {
+		if err := CheckEmailDomain(email, false, ""); err != nil {
+			t.Errorf("%q: %v", email, err)
+		}
+	}
+}
+
+func TestCheckGitHubOrgs(t *testing.T) {
+	allowlist(t, &config.Config{AllowedGitHubOrgs: []string{"MyCompany", "partner"}, StrictRevalidate: true})
+	if !StrictRevalidate() {
+		t.Error("STRICT_REVALIDATE not loaded")
+	}
+
+	tests := []struct {
+		memberOf []string
+		allowed  bool
+	}{
+		{[]string{"mycompany"}, true},
+		{[]string{"oss-project", "Partner"}, true},
+		{[]string{"oss-project"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if err := CheckGitHubOrgs(tt.memberOf); (err == nil) != tt.allowed {
+			t.Errorf("CheckGitHubOrgs(%v) = %v", tt.memberOf, err)
+		}
+	}
+
+	allowlist(t, &config.Config{})
+	if err := CheckGitHubOrgs(nil); err != nil {
+		t.Errorf("no ALLOWED_GITHUB_ORGS: %v", err)
+	}
+}
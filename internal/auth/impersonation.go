@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MaxImpersonationDuration caps how long an impersonation session lasts
+const MaxImpersonationDuration = 30 * time.Minute
+
+var allowImpersonationWrites bool
+
+// InitImpersonation loads the impersonation settings from config
+func InitImpersonation(cfg *config.Config) {
+	allowImpersonationWrites = cfg.ImpersonationAllowWrites
+}
+
+// StartImpersonation opens a session for admin to act as target and returns a
+// token for it. duration is capped at MaxImpersonationDuration.
+func StartImpersonation(admin, target *models.User, reason string, duration time.Duration) (*models.ImpersonationSession, string, error) {
+	if admin.ID == target.ID {
+		return nil, "", errors.New("cannot impersonate yourself")
+	}
+	if IsAdmin(target) {
+		return nil, "", errors.New("cannot impersonate another admin")
+	}
+	if duration <= 0 || duration > MaxImpersonationDuration {
+		duration = MaxImpersonationDuration
+	}
+
+	session := &models.ImpersonationSession{
+		AdminID:      admin.ID,
+		TargetUserID: target.ID,
+		Reason:       reason,
+		ExpiresAt:    time.Now().Add(duration),
+	}
+	if err := database.DB.Create(session).Error; err != nil {
+		return nil, "", err
+	}
+
+	token, err := signClaims(&Claims{
+		UserID:         target.ID,
+		Username:       target.Username,
+		ImpersonatorID: admin.ID,
+		SessionID:      session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	audit.Record(&models.AuditLog{
+		UserID:         target.ID,
+		ImpersonatorID: &admin.ID,
+		Action:         "impersonation.start",
+		Details:        fmt.Sprintf("session %d, reason: %s", session.ID, reason),
+	})
+	return session, token, nil
+}
+
+// RevokeImpersonation ends a session early; tokens issued for it stop working immediately
+func RevokeImpersonation(sessionID, revokedBy uint) error {
+	var session models.ImpersonationSession
+	if err := database.DB.First(&session, sessionID).Error; err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(&session).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	audit.Record(&models.AuditLog{
+		UserID:         session.TargetUserID,
+		ImpersonatorID: &session.AdminID,
+		Action:         "impersonation.revoke",
+		Details:        fmt.Sprintf("session %d revoked by user %d", session.ID, revokedBy),
+	})
+	return nil
+}
+
+// ActiveImpersonation returns the session behind the request, nil when the
+// caller isn't impersonating
+func ActiveImpersonation(c *gin.Context) *models.ImpersonationSession {
+	sessionID := c.GetUint("impersonation_session_id")
+	if sessionID == 0 {
+		return nil
+	}
+	var session models.ImpersonationSession
+	if err := database.DB.Preload("Admin").First(&session, sessionID).Error; err != nil {
+		return nil
+	}
+	return &session
+}
+
+// handleImpersonation finishes AuthMiddleware for impersonation tokens: the
+// session must still be live, writes are refused unless allowed by config, and
+// every request lands in the audit log with both identities.
+func handleImpersonation(c *gin.Context, claims *Claims) {
+	if !impersonationLive(claims) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Impersonation session expired or revoked"})
+		c.Abort()
+		return
+	}
+
+	c.Set("impersonator_id", claims.ImpersonatorID)
+	c.Set("impersonation_session_id", claims.SessionID)
+
+	if !allowImpersonationWrites && isWriteMethod(c.Request.Method) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Changes are not allowed while impersonating a user"})
+		c.Abort()
+		audit.FromContext(c, "impersonation.write_blocked", "")
+		return
+	}
+
+	c.Next()
+	audit.FromContext(c, "impersonation.request", "")
+}
+
+// impersonationLive reports whether the session of an impersonation token
+// is still live: neither revoked nor expired, and opened by its impersonator
+// for its user
+func impersonationLive(claims *Claims) bool {
+	var session models.ImpersonationSession
+	err := database.DB.First(&session, claims.SessionID).Error
+	return err == nil && session.RevokedAt == nil && time.Now().Before(session.ExpiresAt) &&
+		session.AdminID == claims.ImpersonatorID && session.TargetUserID == claims.UserID
+}
+
+// ImpersonationReadOnly reports whether impersonation sessions are read-only
+func ImpersonationReadOnly() bool {
+	return !allowImpersonationWrites
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
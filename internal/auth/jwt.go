@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -9,25 +11,83 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret []byte
+// TokenTTL is how long sign-in tokens (and the session cookie holding them) last
+const TokenTTL = 24 * time.Hour
 
-// InitJWT initializes JWT with secret from config
+const (
+	tokenIssuer = "deploy-platform"
+	// tokenLeeway tolerates clocks of the platform's replicas a little apart
+	tokenLeeway = 30 * time.Second
+)
+
+// Why a token was refused
+var (
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenInvalid = errors.New("invalid token")
+)
+
+// signingKey is a key of the keyring, identified in tokens by its kid
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+var (
+	signingKeys   []signingKey // Newest first: it signs, all of them validate
+	tokenAudience string
+	tokenParser   *jwt.Parser
+)
+
+// InitJWT sets the keyring from JWT_SECRETS, or JWT_SECRET alone, and the
+// audience of tokens: the platform's BASE_URL, so platforms sharing a secret
+// (staging and production) don't accept each other's tokens
 func InitJWT(cfg *config.Config) {
-	if cfg == nil || cfg.JWTSecret == "" {
+	if cfg == nil {
 		panic("JWT secret is not set in config")
 	}
-	jwtSecret = []byte(cfg.JWTSecret)
+	secrets := cfg.JWTKeys()
+	if len(secrets) == 0 {
+		panic("JWT secret is not set in config")
+	}
+	// Tokens without an audience would be accepted by any platform sharing a secret
+	if cfg.BaseURL == "" {
+		panic("BASE_URL is not set in config: it is the audience of tokens")
+	}
+	signingKeys = make([]signingKey, 0, len(secrets))
+	for _, secret := range secrets {
+		signingKeys = append(signingKeys, signingKey{id: keyID(secret), secret: []byte(secret)})
+	}
+	tokenAudience = cfg.BaseURL
+	tokenParser = jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(tokenAudience),
+		jwt.WithLeeway(tokenLeeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+}
+
+// keyID names a secret without giving it away
+func keyID(secret string) string {
+	sum := sha256.Sum256([]byte("kid:" + secret))
+	return hex.EncodeToString(sum[:])[:12]
 }
 
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
+
+	// Set on impersonation tokens only
+	ImpersonatorID uint `json:"impersonator_id,omitempty"`
+	SessionID      uint `json:"impersonation_session_id,omitempty"`
+
 	jwt.RegisteredClaims
 }
 
 // GenerateToken creates a JWT token for a user
 func GenerateToken(userID uint, username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
+	expirationTime := time.Now().Add(TokenTTL)
 
 	claims := &Claims{
 		UserID:   userID,
@@ -36,12 +96,20 @@ func GenerateToken(userID uint, username string) (string, error) {
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "deploy-platform",
 		},
 	}
 
+	return signClaims(claims)
+}
+
+// signClaims signs claims with the newest key, naming it in the kid header
+func signClaims(claims *Claims) (string, error) {
+	key := signingKeys[0]
+	claims.Issuer = tokenIssuer
+	claims.Audience = jwt.ClaimStrings{tokenAudience}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	token.Header["kid"] = key.id
+	tokenString, err := token.SignedString(key.secret)
 	if err != nil {
 		return "", err
 	}
@@ -49,24 +117,31 @@ func GenerateToken(userID uint, username string) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// ValidateToken validates a JWT token and returns the claims. Tokens must be
+// HS256, signed by a key of the keyring named by their kid, and issued by
+// and for this platform. Refused tokens return ErrTokenExpired, once past
+// their expiry and the leeway, or ErrTokenInvalid.
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
+	token, err := tokenParser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range signingKeys {
+			if key.id == kid {
+				return key.secret, nil
+			}
 		}
-		return jwtSecret, nil
+		return nil, errors.New("unknown signing key")
 	})
 
-	if err != nil {
-		return nil, err
+	// The signature is checked before the expiry, so forged tokens are
+	// never reported as expired
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
 	}
-
-	if !token.Valid {
-		return nil, errors.New("invalid token")
+	if err != nil || !token.Valid {
+		return nil, ErrTokenInvalid
 	}
 
 	return claims, nil
-}
\ No newline at end of file
+}
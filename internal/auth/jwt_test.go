@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"deploy-platform/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testBaseURL = "https://deploy.example.com"
+
+func initKeys(t *testing.T, secrets ...string) {
+	t.Helper()
+	InitJWT(&config.Config{JWTSecrets: secrets, BaseURL: testBaseURL})
+}
+
+// Tokens signed by a key stay valid while it is in the keyring, after a
+// newer key took over signing, and are refused once it is dropped
+func TestKeyRotation(t *testing.T) {
+	initKeys(t, "old-secret")
+	old, err := GenerateToken(1, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initKeys(t, "new-secret", "old-secret")
+	if claims, err := ValidateToken(old); err != nil || claims.UserID != 1 {
+		t.Fatalf("token of the previous key: %+v, %v", claims, err)
+	}
+	current, err := GenerateToken(2, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(current, &Claims{})
+	if err != nil || token.Header["kid"] != keyID("new-secret") {
+		t.Errorf("signed with kid %v, %v", token.Header["kid"], err)
+	}
+
+	initKeys(t, "new-secret")
+	if _, err := ValidateToken(old); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("token of a dropped key: got %v", err)
+	}
+	if _, err := ValidateToken(current); err != nil {
+		t.Errorf("token of the current key: %v", err)
+	}
+
+	// A kid naming a key of the keyring doesn't let another key sign
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Audience:  jwt.ClaimStrings{testBaseURL},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	forged.Header["kid"] = keyID("new-secret")
+	raw, _ := forged.SignedString([]byte("old-secret"))
+	if _, err := ValidateToken(raw); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("token signed by another key: got %v", err)
+	}
+}
+
+// withHeader replaces the header of a token, keeping its claims and signature
+func withHeader(token, header string) string {
+	parts := strings.Split(token, ".")
+	parts[0] = base64.RawURLEncoding.EncodeToString([]byte(header))
+	return strings.Join(parts, ".")
+}
+
+func TestTamperedAlgorithm(t *testing.T) {
+	initKeys(t, "secret")
+	token, err := GenerateToken(1, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := keyID("secret")
+	parts := strings.Split(token, ".")
+
+	hs512 := jwt.NewWithClaims(jwt.SigningMethodHS512, &Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Audience:  jwt.ClaimStrings{testBaseURL},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	hs512.Header["kid"] = kid
+	signed512, _ := hs512.SignedString([]byte("secret"))
+
+	for name, tampered := range map[string]string{
+		"none":            withHeader(parts[0]+"."+parts[1]+".", `{"alg":"none","typ":"JWT","kid":"`+kid+`"}`),
+		"none, unsigned":  withHeader(parts[0]+"."+parts[1], `{"alg":"none","typ":"JWT"}`),
+		"RS256":           withHeader(token, `{"alg":"RS256","typ":"JWT","kid":"`+kid+`"}`),
+		"HS512 relabeled": withHeader(token, `{"alg":"HS512","typ":"JWT","kid":"`+kid+`"}`),
+		"HS512 signed":    signed512,
+	} {
+		if _, err := ValidateToken(tampered); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	if _, err := ValidateToken(token); err != nil {
+		t.Errorf("untampered token: %v", err)
+	}
+}
+
+func TestExpiryLeeway(t *testing.T) {
+	initKeys(t, "secret")
+	sign := func(expiresIn time.Duration) string {
+		t.Helper()
+		token, err := signClaims(&Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	if _, err := ValidateToken(sign(-tokenLeeway / 3)); err != nil {
+		t.Errorf("expired within the leeway: %v", err)
+	}
+	if _, err := ValidateToken(sign(-2 * tokenLeeway)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expired past the leeway: got %v", err)
+	}
+
+	// A token forged with an expiry in the past is invalid, not expired
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Audience:  jwt.ClaimStrings{testBaseURL},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+	}})
+	forged.Header["kid"] = keyID("secret")
+	raw, _ := forged.SignedString([]byte("guessed"))
+	if _, err := ValidateToken(raw); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("forged expired token: got %v", err)
+	}
+}
+
+// Platforms sharing a secret don't accept each other's tokens, and one
+// without a BASE_URL doesn't start
+func TestAudience(t *testing.T) {
+	InitJWT(&config.Config{JWTSecret: "shared", BaseURL: "https://staging.example.com"})
+	staging, err := GenerateToken(1, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitJWT(&config.Config{JWTSecret: "shared", BaseURL: testBaseURL})
+	if _, err := ValidateToken(staging); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("token of another platform: got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("InitJWT accepted an empty BASE_URL")
+		}
+	}()
+	InitJWT(&config.Config{JWTSecret: "shared"})
+}
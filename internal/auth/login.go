@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/ratelimit"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	loginWindow   = time.Hour        // Failed sign-ins older than this are forgotten
+	maxLoginDelay = 30 * time.Second // Cap of the escalating delay
+	storeTimeout  = 200 * time.Millisecond
+)
+
+// Reasons a sign-in failed, recorded in the audit log for admins; clients
+// only ever see ErrInvalidLogin
+const (
+	LoginReasonUnknownAccount = "unknown account"
+	LoginReasonNoPassword     = "account has no password" // Created through OAuth
+	LoginReasonWrongPassword  = "wrong password"
+	LoginReasonLocked         = "account locked"
+)
+
+// ErrInvalidLogin is the one sign-in failure clients see: it doesn't tell
+// unknown accounts, wrong passwords and locked accounts apart
+var ErrInvalidLogin = errors.New("invalid email/username or password")
+
+var (
+	loginStore        ratelimit.Store = ratelimit.NewMemoryStore()
+	loginDelayAfter                   = 5
+	loginLockoutAfter                 = 10
+	loginLockout                      = 15 * time.Minute
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// InitLoginThrottle counts failed sign-ins in store, shared by every replica
+// when the rate limits are
+func InitLoginThrottle(cfg *config.Config, store ratelimit.Store) {
+	loginStore = store
+	loginDelayAfter = cfg.LoginDelayAfter
+	loginLockoutAfter = cfg.LoginLockoutAfter
+	loginLockout = cfg.LoginLockoutDuration
+}
+
+// CheckLogin verifies a password sign-in by email or username from ip.
+// Failures are counted per account and per IP: past LOGIN_DELAY_AFTER each
+// attempt waits longer before being checked, at LOGIN_LOCKOUT_AFTER the
+// account is locked for LOGIN_LOCKOUT_DURATION. Every failure returns
+// ErrInvalidLogin after a bcrypt comparison, so neither the response nor its
+// timing tells whether the account exists or is locked.
+func CheckLogin(ctx context.Context, identifier, password, ip string) (*models.User, error) {
+	var user models.User
+	err := database.DB.WithContext(ctx).Where("email = ? OR username = ?", identifier, identifier).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	exists := err == nil
+
+	ipKey := "login-fail:ip:" + ip
+	failures := loginFailures(ctx, ipKey, false)
+	if exists {
+		failures = max(failures, loginFailures(ctx, accountKey(&user), false))
+	}
+	if err := loginDelay(ctx, failures); err != nil {
+		return nil, err
+	}
+
+	// The hash is compared in every case, against a dummy one when there is
+	// no real hash to compare
+	hash := user.PasswordHash
+	if hash == "" {
+		hash = getDummyHash()
+	}
+	valid := CheckPasswordHash(password, hash)
+
+	reason := ""
+	switch {
+	case !exists:
+		reason = LoginReasonUnknownAccount
+	case user.PasswordHash == "":
+		reason = LoginReasonNoPassword
+	case LockedOut(&user):
+		reason = LoginReasonLocked
+	case !valid:
+		reason = LoginReasonWrongPassword
+	}
+	if reason == "" {
+		loginSucceeded(ctx, &user, ip)
+		return &user, nil
+	}
+
+	loginFailures(ctx, ipKey, true)
+	if exists && reason != LoginReasonLocked {
+		loginFailed(ctx, &user, ip, reason)
+	}
+	return nil, ErrInvalidLogin
+}
+
+// LockedOut reports whether user's password sign-in is locked
+func LockedOut(user *models.User) bool {
+	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+}
+
+// LoginFailures returns the failed sign-ins counted against user's account
+func LoginFailures(ctx context.Context, user *models.User) int {
+	return loginFailures(ctx, accountKey(user), false)
+}
+
+// ClearLockout unlocks user's account and restarts its count of failures.
+// Admins call it, and so must anything that resets a password.
+func ClearLockout(user *models.User) error {
+	return database.DB.Model(user).Updates(map[string]interface{}{
+		"locked_until": nil,
+		"login_epoch":  gorm.Expr("login_epoch + 1"),
+	}).Error
+}
+
+// accountKey counts the failures of user's account since its epoch last changed
+func accountKey(user *models.User) string {
+	return fmt.Sprintf("login-fail:account:%d:%d", user.ID, user.LoginEpoch)
+}
+
+// loginFailures returns the failures counted under key over the last hour,
+// adding one first when add is set. The count slides like the rate limits do;
+// a broken store counts nothing, it must not lock everyone out.
+func loginFailures(ctx context.Context, key string, add bool) int {
+	now := time.Now()
+	windowStart := now.Truncate(loginWindow)
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	count := loginStore.Peek
+	if add {
+		count = loginStore.Increment
+	}
+	current, previous, err := count(ctx, key, windowStart, loginWindow)
+	if err != nil {
+		log.Printf("⚠️  Failed to count sign-in failures: %v", err)
+		return 0
+	}
+	overlap := 1 - float64(now.Sub(windowStart))/float64(loginWindow)
+	return int(float64(current) + float64(previous)*overlap)
+}
+
+// loginDelay waits before checking an attempt once failures reach
+// LOGIN_DELAY_AFTER: one second, doubling with each further failure
+func loginDelay(ctx context.Context, failures int) error {
+	if failures < loginDelayAfter {
+		return nil
+	}
+	delay := maxLoginDelay
+	if steps := failures - loginDelayAfter; steps < 5 {
+		delay = min(time.Second<<steps, maxLoginDelay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// loginFailed records a failed sign-in to user's account, locking it once
+// the failures reach LOGIN_LOCKOUT_AFTER. The lock bumps the epoch, so the
+// account starts over from zero when it ends.
+func loginFailed(ctx context.Context, user *models.User, ip, reason string) {
+	failures := loginFailures(ctx, accountKey(user), true)
+	audit.Record(&models.AuditLog{
+		UserID:  user.ID,
+		Action:  "login.failed",
+		IP:      ip,
+		Details: fmt.Sprintf("reason: %s, %d failures in the last hour", reason, failures),
+	})
+	if failures < loginLockoutAfter {
+		return
+	}
+
+	until := time.Now().Add(loginLockout)
+	if err := database.DB.Model(user).Updates(map[string]interface{}{
+		"locked_until": until,
+		"login_epoch":  gorm.Expr("login_epoch + 1"),
+	}).Error; err != nil {
+		log.Printf("⚠️  Failed to lock account of user %d: %v", user.ID, err)
+		return
+	}
+	log.Printf("🔒 Locked password sign-in of user %d until %s after %d failures", user.ID, until.Format(time.RFC3339), failures)
+	audit.Record(&models.AuditLog{
+		UserID:  user.ID,
+		Action:  "login.lockout",
+		IP:      ip,
+		Details: fmt.Sprintf("%d failed sign-ins in the last hour, locked until %s", failures, until.Format(time.RFC3339)),
+	})
+}
+
+// loginSucceeded records a sign-in; failures before it no longer count
+// against the account
+func loginSucceeded(ctx context.Context, user *models.User, ip string) {
+	if loginFailures(ctx, accountKey(user), false) > 0 || user.LockedUntil != nil {
+		if err := ClearLockout(user); err != nil {
+			log.Printf("⚠️  Failed to reset sign-in failures of user %d: %v", user.ID, err)
+		}
+	}
+	audit.Record(&models.AuditLog{UserID: user.ID, Action: "login.success", IP: ip})
+}
+
+// getDummyHash returns a bcrypt hash of a random password, hashed once with
+// the cost of real ones
+func getDummyHash() string {
+	dummyHashOnce.Do(func() {
+		password, err := GenerateRandomPassword(32)
+		if err == nil {
+			dummyHash, err = HashPassword(password)
+		}
+		if err != nil {
+			log.Printf("⚠️  Failed to create the dummy password hash: %v", err)
+		}
+	})
+	return dummyHash
+}
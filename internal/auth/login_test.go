@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/ratelimit"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"testing"
+	"time"
+)
+
+// setupLogin creates a user with password "correct horse" and a throttle
+// locking after 4 failures, without delays
+func setupLogin(t *testing.T, store ratelimit.Store) *models.User {
+	t.Helper()
+	testutil.DB(t)
+	InitLoginThrottle(&config.Config{LoginDelayAfter: 100, LoginLockoutAfter: 4, LoginLockoutDuration: time.Hour}, store)
+	t.Cleanup(func() { loginStore = ratelimit.NewMemoryStore() })
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Email: "ada@example.com", Username: "ada", PasswordHash: hash}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// Password guessing with the auth rate limiter counting in the same store,
+// as main wires them, must still lock the account
+func TestLoginLockoutWithSharedStore(t *testing.T) {
+	ctx := context.Background()
+	store := ratelimit.NewMemoryStore()
+	user := setupLogin(t, store)
+	authLimiter := ratelimit.NewSharedLimiter(store, 1000, time.Minute)
+
+	for i := 0; i < 4; i++ {
+		authLimiter.Take(ctx, "auth:ip:10.0.0.1")
+		if _, err := CheckLogin(ctx, "ada", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidLogin) {
+			t.Fatalf("attempt %d: got %v", i, err)
+		}
+	}
+	database.DB.First(user, user.ID)
+	if !LockedOut(user) {
+		t.Fatal("account not locked after 4 failures")
+	}
+
+	// Locked accounts refuse the right password too, without saying why
+	authLimiter.Take(ctx, "auth:ip:10.0.0.1")
+	if _, err := CheckLogin(ctx, "ada", "correct horse", "10.0.0.1"); !errors.Is(err, ErrInvalidLogin) {
+		t.Fatalf("locked account: got %v", err)
+	}
+
+	// Until the lockout is cleared, e.g. by a password reset
+	if err := ClearLockout(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckLogin(ctx, "ada", "correct horse", "10.0.0.1"); err != nil {
+		t.Fatalf("after clearing the lockout: %v", err)
+	}
+}
+
+func TestLoginUnknownAccount(t *testing.T) {
+	setupLogin(t, ratelimit.NewMemoryStore())
+	if _, err := CheckLogin(context.Background(), "nobody", "correct horse", "10.0.0.2"); !errors.Is(err, ErrInvalidLogin) {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto/rand"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/incidents"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// Why an OAuth callback could not sign the user in: the "code" of its JSON
+// error responses
+const (
+	LoginCancelled     = "login_cancelled"     // The user declined consent at the provider
+	LoginExpired       = "login_expired"       // The state or code expired, or the flow was started elsewhere
+	LoginEmailMissing  = "login_email_missing" // The provider shared no email address
+	LoginUnavailable   = "login_unavailable"   // The provider failed or timed out
+	LoginMisconfigured = "login_misconfigured" // The platform's OAuth settings are wrong: admins are alerted
+	LoginInternal      = "login_internal"      // The platform failed, e.g. its database
+	LoginNotAllowed    = "login_denied"        // The sign-in restrictions refuse the user
+)
+
+// loginAlertInterval spaces the alerts of a provider's misconfiguration:
+// every sign-in attempt fails the same way until it is fixed
+const loginAlertInterval = 15 * time.Minute
+
+// OAuth error codes of the provider that mean the platform's client ID,
+// secret or callback URL are wrong, in redirects and token responses
+var misconfiguredCodes = map[string]bool{
+	"invalid_client":               true,
+	"unauthorized_client":          true,
+	"unsupported_response_type":    true,
+	"invalid_scope":                true,
+	"redirect_uri_mismatch":        true,
+	"incorrect_client_credentials": true, // GitHub
+	"application_suspended":        true, // GitHub
+}
+
+// OAuth error codes of a token response that mean the code expired or was
+// already used: starting over fixes it
+var expiredCodes = map[string]bool{
+	"invalid_grant":         true,
+	"bad_verification_code": true, // GitHub
+}
+
+var (
+	loginAlerts = incidents.NewNotifier("")
+
+	loginAlertMu   sync.Mutex
+	loginAlertedAt = make(map[string]time.Time) // By provider
+)
+
+// InitLoginErrors alerts ADMIN_ALERT_WEBHOOK of misconfigured sign-ins
+func InitLoginErrors(cfg *config.Config) {
+	loginAlerts = incidents.NewNotifier(cfg.AdminAlertWebhook)
+}
+
+// loginPage is what a failed sign-in tells the user
+type loginPage struct {
+	status  int
+	title   string
+	message string
+	hint    string
+	retry   bool // Starting over can work: show the link that does
+}
+
+func loginPageOf(code, provider string) loginPage {
+	switch code {
+	case LoginCancelled:
+		return loginPage{http.StatusBadRequest, "Sign-in cancelled",
+			"You cancelled signing in with " + provider + ".",
+			"Nothing was changed on your account. You can try again whenever you like.", true}
+	case LoginExpired:
+		return loginPage{http.StatusBadRequest, "Sign-in expired",
+			"Your sign-in with " + provider + " expired, or was started in another tab or browser.",
+			"This happens when the sign-in page stays open for too long. Try again to start over.", true}
+	case LoginEmailMissing:
+		return loginPage{http.StatusBadRequest, "Email address needed",
+			provider + " didn't share an email address with us.",
+			"Make sure your " + provider + " account has a verified email address and that you allow sharing it, then try again.", true}
+	case LoginUnavailable:
+		return loginPage{http.StatusBadGateway, "Sign-in failed",
+			provider + " could not complete the sign-in.",
+			"This is usually temporary. Nothing was changed on your account, you can safely try again.", true}
+	case LoginMisconfigured:
+		return loginPage{http.StatusInternalServerError, "Sign-in unavailable",
+			"Sign-in with " + provider + " is not set up correctly on this platform.",
+			"Trying again won't help. Your administrator has been notified; contact them with the reference below.", false}
+	case LoginNotAllowed:
+		return loginPage{http.StatusForbidden, "Sign-in not allowed", "",
+			"This platform is restricted to members of the organization. If you think this is a mistake, contact your administrator.", false}
+	default:
+		return loginPage{http.StatusInternalServerError, "Sign-in failed",
+			"Something went wrong on our side while signing you in.",
+			"Try again in a moment. If it keeps happening, contact your administrator with the reference below.", true}
+	}
+}
+
+// LoginError answers an OAuth callback, or the start of a flow, that can't
+// sign the user in. Browsers get a page explaining what happened, with a
+// link starting over at retryURL when that can help; requests negotiating
+// application/json (API-driven mobile flows) get the error as JSON. err is
+// only logged, under a reference shown to the user so admins can find it.
+// Misconfigurations alert the admins.
+func LoginError(c *gin.Context, code, provider, retryURL string, err error) {
+	failLogin(c, code, loginPageOf(code, provider), provider, retryURL, err)
+}
+
+// LoginDenied answers an OAuth callback whose user the sign-in restrictions
+// refuse, telling them why
+func LoginDenied(c *gin.Context, identity string, err error) {
+	page := loginPageOf(LoginNotAllowed, "")
+	page.message = err.Error()
+	reference := newReference()
+	log.Printf("⚠️  Sign-in rejected for %s (ref %s): %v", identity, reference, err)
+	renderLoginError(c, LoginNotAllowed, page, "", reference)
+}
+
+// LoginFailed answers an OAuth callback whose calls to provider failed,
+// telling apart a provider refusing the platform's client credentials, an
+// expired code, timeouts and other failures
+func LoginFailed(c *gin.Context, provider, retryURL string, err error) {
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.As(err, &retrieveErr) && misconfiguredCodes[retrieveErr.ErrorCode]:
+		LoginError(c, LoginMisconfigured, provider, retryURL, err)
+	case errors.As(err, &retrieveErr) && expiredCodes[retrieveErr.ErrorCode]:
+		LoginError(c, LoginExpired, provider, retryURL, err)
+	case httpclient.IsTimeout(err):
+		page := loginPageOf(LoginUnavailable, provider)
+		page.status, page.message = http.StatusGatewayTimeout, provider+" took too long to answer."
+		failLogin(c, LoginUnavailable, page, provider, retryURL, err)
+	default:
+		LoginError(c, LoginUnavailable, provider, retryURL, err)
+	}
+}
+
+// LoginProviderError answers an OAuth callback the provider sent back with
+// ?error=, returning false when there is none. Declined consent is the
+// user's choice; other errors are the platform's settings.
+func LoginProviderError(c *gin.Context, provider, retryURL string) bool {
+	providerErr := c.Query("error")
+	if providerErr == "" {
+		return false
+	}
+	err := errors.New(providerErr)
+	if description := c.Query("error_description"); description != "" {
+		err = fmt.Errorf("%s: %s", providerErr, description)
+	}
+	switch {
+	case providerErr == "access_denied":
+		LoginError(c, LoginCancelled, provider, retryURL, err)
+	case misconfiguredCodes[providerErr]:
+		LoginError(c, LoginMisconfigured, provider, retryURL, err)
+	default:
+		LoginError(c, LoginUnavailable, provider, retryURL, err)
+	}
+	return true
+}
+
+// failLogin logs err under a new reference, alerts admins of
+// misconfigurations and renders page
+func failLogin(c *gin.Context, code string, page loginPage, provider, retryURL string, err error) {
+	reference := newReference()
+	log.Printf("⚠️  Sign-in with %s failed (%s, ref %s): %v", provider, code, reference, err)
+	if code == LoginMisconfigured {
+		alertMisconfigured(provider, reference, err)
+	}
+	renderLoginError(c, code, page, retryURL, reference)
+}
+
+func renderLoginError(c *gin.Context, code string, page loginPage, retryURL, reference string) {
+	if !page.retry {
+		retryURL = ""
+	}
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(page.status, gin.H{
+			"error":          page.message,
+			"code":           code,
+			"hint":           page.hint,
+			"retry_url":      retryURL,
+			"correlation_id": reference,
+		})
+		return
+	}
+	c.HTML(page.status, "login_error.html", gin.H{
+		"Title":     page.title,
+		"Message":   page.message,
+		"Hint":      page.hint,
+		"RetryURL":  retryURL,
+		"Reference": reference,
+	})
+}
+
+// alertMisconfigured alerts admins that sign-in with provider is broken, at
+// most once per loginAlertInterval
+func alertMisconfigured(provider, reference string, err error) {
+	loginAlertMu.Lock()
+	alert := time.Since(loginAlertedAt[provider]) >= loginAlertInterval
+	if alert {
+		loginAlertedAt[provider] = time.Now()
+	}
+	loginAlertMu.Unlock()
+	if !alert {
+		return
+	}
+	text := fmt.Sprintf("🚨 Sign-in with %s is failing: check its OAuth client ID, secret and callback URL", provider)
+	log.Print(text)
+	loginAlerts.Alert(incidents.Alert{
+		Event:   "login.misconfigured",
+		Text:    text,
+		Details: gin.H{"provider": provider, "error": err.Error(), "correlation_id": reference},
+	})
+}
+
+// newReference returns the correlation ID of a failed sign-in
+func newReference() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
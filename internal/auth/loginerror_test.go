@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/assets"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/incidents"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// loginAlertsTo sends the alerts of misconfigured sign-ins to a webhook
+// whose alerts are returned, and forgets those already sent
+func loginAlertsTo(t *testing.T) <-chan incidents.Alert {
+	t.Helper()
+	alerts := make(chan incidents.Alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert incidents.Alert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	t.Cleanup(server.Close)
+	InitLoginErrors(&config.Config{AdminAlertWebhook: server.URL})
+	t.Cleanup(func() {
+		InitLoginErrors(&config.Config{})
+		loginAlertMu.Lock()
+		clear(loginAlertedAt)
+		loginAlertMu.Unlock()
+	})
+	return alerts
+}
+
+// captureLog returns what is logged for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+var referenceInPage = regexp.MustCompile(`Reference: <span class="font-mono">([0-9a-f]{12})</span>`)
+
+// Every way a sign-in fails explains itself to browsers with the error page
+// and to API clients with JSON, under a reference admins find in the logs;
+// only what starting over can fix links to it
+func TestLoginErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	web, err := assets.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cause := errors.New("the cause, for admins only")
+	timeout := fmt.Errorf("exchanging the code for a token: %w", context.DeadlineExceeded)
+
+	tests := []struct {
+		name    string
+		query   string // Of the callback, for provider errors
+		fail    func(c *gin.Context)
+		status  int
+		code    string
+		title   string
+		message string
+		retry   string // Where starting over links to, "" = nowhere
+		alert   bool
+	}{
+		{
+			name:   "consent denied",
+			query:  "error=access_denied&error_description=The+user+has+denied+your+application+access.",
+			fail:   func(c *gin.Context) { LoginProviderError(c, "GitHub", "/auth/github") },
+			status: http.StatusBadRequest, code: LoginCancelled, title: "Sign-in cancelled",
+			message: "You cancelled signing in with GitHub.", retry: "/auth/github",
+		},
+		{
+			name:   "callback URL mismatch",
+			query:  "error=redirect_uri_mismatch",
+			fail:   func(c *gin.Context) { LoginProviderError(c, "GitHub", "/auth/github") },
+			status: http.StatusInternalServerError, code: LoginMisconfigured, title: "Sign-in unavailable",
+			message: "Sign-in with GitHub is not set up correctly on this platform.", alert: true,
+		},
+		{
+			name:   "provider failure",
+			query:  "error=temporarily_unavailable",
+			fail:   func(c *gin.Context) { LoginProviderError(c, "Google", "/auth/google") },
+			status: http.StatusBadGateway, code: LoginUnavailable, title: "Sign-in failed",
+			message: "Google could not complete the sign-in.", retry: "/auth/google",
+		},
+		{
+			name: "state mismatch",
+			fail: func(c *gin.Context) {
+				LoginError(c, LoginExpired, "GitHub", "/auth/github", errors.New("invalid state"))
+			},
+			status: http.StatusBadRequest, code: LoginExpired, title: "Sign-in expired",
+			message: "Your sign-in with GitHub expired, or was started in another tab or browser.", retry: "/auth/github",
+		},
+		{
+			name:   "email missing",
+			fail:   func(c *gin.Context) { LoginError(c, LoginEmailMissing, "Google", "/auth/google", cause) },
+			status: http.StatusBadRequest, code: LoginEmailMissing, title: "Email address needed",
+			message: "Google didn't share an email address with us.", retry: "/auth/google",
+		},
+		{
+			name:   "provider not configured",
+			fail:   func(c *gin.Context) { LoginError(c, LoginMisconfigured, "Google", "", cause) },
+			status: http.StatusInternalServerError, code: LoginMisconfigured, title: "Sign-in unavailable",
+			message: "Sign-in with Google is not set up correctly on this platform.", alert: true,
+		},
+		{
+			name:   "platform failure",
+			fail:   func(c *gin.Context) { LoginError(c, LoginInternal, "GitHub", "/auth/github", cause) },
+			status: http.StatusInternalServerError, code: LoginInternal, title: "Sign-in failed",
+			message: "Something went wrong on our side while signing you in.", retry: "/auth/github",
+		},
+		{
+			name: "bad client secret",
+			fail: func(c *gin.Context) {
+				LoginFailed(c, "GitHub", "/auth/github", &oauth2.RetrieveError{ErrorCode: "incorrect_client_credentials"})
+			},
+			status: http.StatusInternalServerError, code: LoginMisconfigured, title: "Sign-in unavailable",
+			message: "Sign-in with GitHub is not set up correctly on this platform.", alert: true,
+		},
+		{
+			name: "code expired",
+			fail: func(c *gin.Context) {
+				LoginFailed(c, "Google", "/auth/google", fmt.Errorf("exchanging the code for a token: %w", &oauth2.RetrieveError{ErrorCode: "invalid_grant"}))
+			},
+			status: http.StatusBadRequest, code: LoginExpired, title: "Sign-in expired",
+			message: "Your sign-in with Google expired, or was started in another tab or browser.", retry: "/auth/google",
+		},
+		{
+			name:   "provider timeout",
+			fail:   func(c *gin.Context) { LoginFailed(c, "GitHub", "/auth/github", timeout) },
+			status: http.StatusGatewayTimeout, code: LoginUnavailable, title: "Sign-in failed",
+			message: "GitHub took too long to answer.", retry: "/auth/github",
+		},
+		{
+			name:   "provider error",
+			fail:   func(c *gin.Context) { LoginFailed(c, "GitHub", "/auth/github", cause) },
+			status: http.StatusBadGateway, code: LoginUnavailable, title: "Sign-in failed",
+			message: "GitHub could not complete the sign-in.", retry: "/auth/github",
+		},
+		{
+			name:   "not allowed",
+			fail:   func(c *gin.Context) { LoginDenied(c, "mallory@example.net", ErrEmailNotVerified) },
+			status: http.StatusForbidden, code: LoginNotAllowed, title: "Sign-in not allowed",
+			message: ErrEmailNotVerified.Error(),
+		},
+	}
+	for _, tt := range tests {
+		for _, mode := range []string{gin.MIMEJSON, gin.MIMEHTML} {
+			t.Run(tt.name+" as "+mode, func(t *testing.T) {
+				alerts := loginAlertsTo(t)
+				logged := captureLog(t)
+				r := gin.New()
+				if err := web.Register(r, "Deploy Platform"); err != nil {
+					t.Fatal(err)
+				}
+				r.GET("/auth/callback", tt.fail)
+				req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+tt.query, nil)
+				req.Header.Set("Accept", mode)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if w.Code != tt.status || !strings.HasPrefix(w.Header().Get("Content-Type"), mode) {
+					t.Fatalf("got %d %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+				}
+				var reference string
+				if mode == gin.MIMEJSON {
+					var body struct {
+						Error         string `json:"error"`
+						Code          string `json:"code"`
+						Hint          string `json:"hint"`
+						RetryURL      string `json:"retry_url"`
+						CorrelationID string `json:"correlation_id"`
+					}
+					json.Unmarshal(w.Body.Bytes(), &body)
+					if body.Error != tt.message || body.Code != tt.code || body.Hint == "" || body.RetryURL != tt.retry {
+						t.Errorf("got %+v, want %q, %q, retry %q", body, tt.message, tt.code, tt.retry)
+					}
+					reference = body.CorrelationID
+				} else {
+					page := w.Body.String()
+					if !strings.Contains(page, "<title>"+tt.title+" - Deploy Platform</title>") {
+						t.Errorf("page titled %q", regexp.MustCompile(`<title>(.*)</title>`).FindStringSubmatch(page))
+					}
+					if !strings.Contains(page, template.HTMLEscapeString(tt.message)) {
+						t.Errorf("page doesn't say %q", tt.message)
+					}
+					if tt.retry != "" && !strings.Contains(page, `href="`+tt.retry+`"`) {
+						t.Errorf("page doesn't retry at %s", tt.retry)
+					}
+					if tries := strings.Count(page, "Try again\n"); tries != map[bool]int{true: 1}[tt.retry != ""] {
+						t.Errorf("page has %d retry links", tries)
+					}
+					if match := referenceInPage.FindStringSubmatch(page); match != nil {
+						reference = match[1]
+					}
+				}
+
+				if len(reference) != 12 || !strings.Contains(logged.String(), "ref "+reference) {
+					t.Errorf("reference %q not logged: %s", reference, logged)
+				}
+				if strings.Contains(w.Body.String(), cause.Error()) {
+					t.Errorf("the cause reached the user: %s", w.Body.String())
+				}
+				select {
+				case alert := <-alerts:
+					if !tt.alert {
+						t.Errorf("alerted %+v", alert)
+					} else if details, _ := alert.Details.(map[string]any); alert.Event != "login.misconfigured" || details["correlation_id"] != reference {
+						t.Errorf("alert %+v", alert)
+					}
+				case <-time.After(200 * time.Millisecond):
+					if tt.alert {
+						t.Error("admins not alerted")
+					}
+				}
+			})
+		}
+	}
+}
+
+// A broken provider alerts admins once, not at every sign-in attempt
+func TestLoginAlertsThrottled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	alerts := loginAlertsTo(t)
+	captureLog(t)
+	r := gin.New()
+	r.GET("/auth/callback", func(c *gin.Context) { LoginProviderError(c, c.Query("provider"), "") })
+	fail := func(provider string) {
+		req := httptest.NewRequest(http.MethodGet, "/auth/callback?error=invalid_client&provider="+provider, nil)
+		req.Header.Set("Accept", gin.MIMEJSON)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for range 3 {
+		fail("GitHub")
+	}
+	fail("Google")
+	got := map[string]int{}
+	for range 2 {
+		select {
+		case alert := <-alerts:
+			got[alert.Details.(map[string]any)["provider"].(string)]++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("alerts %v", got)
+		}
+	}
+	select {
+	case alert := <-alerts:
+		t.Errorf("alerted again: %+v", alert)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if got["GitHub"] != 1 || got["Google"] != 1 {
+		t.Errorf("alerts %v", got)
+	}
+
+	// Not a provider error: nothing answered
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/auth/callback?state=s1&code=c1", nil)
+	if LoginProviderError(c, "GitHub", "/auth/github") || w.Body.Len() != 0 {
+		t.Errorf("answered a callback without error: %s", w.Body.String())
+	}
+}
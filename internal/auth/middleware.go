@@ -1,12 +1,20 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Codes of 401 responses, telling an expired token from one that will never
+// be accepted: forged, tampered with, or signed by a retired key
+const (
+	CodeTokenExpired = "token_expired"
+	CodeTokenInvalid = "token_invalid"
+)
+
 // AuthMiddleware validates JWT token and sets user context
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -27,8 +35,14 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		tokenString := parts[1]
 		claims, err := ValidateToken(tokenString)
+		if errors.Is(err, ErrTokenExpired) {
+			// Clients can sign in again rather than report an error
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token expired", "code": CodeTokenExpired})
+			c.Abort()
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "code": CodeTokenInvalid})
 			c.Abort()
 			return
 		}
@@ -36,7 +50,12 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Set user info in context for use in handlers
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
-		
+
+		if claims.ImpersonatorID != 0 {
+			handleImpersonation(c, claims)
+			return
+		}
+
 		c.Next()
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"deploy-platform/internal/config"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page navigations can't carry the Authorization header the API uses, so
+// sign-in also sets the token as an HttpOnly cookie that only guards pages
+const (
+	SessionCookie = "session"
+	nextCookie    = "login_next" // Where to go after an OAuth round trip
+)
+
+// DefaultNext is where users land after signing in without a deep link
+const DefaultNext = "/dashboard"
+
+var secureCookies bool
+
+// InitSession marks cookies Secure when the platform is served over HTTPS
+func InitSession(cfg *config.Config) {
+	secureCookies = cfg.PublicScheme == "https"
+}
+
+// SetCookie sets an HttpOnly cookie of the platform, Secure when it is served
+// over HTTPS; a negative maxAge removes it
+func SetCookie(c *gin.Context, name, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, "/", "", secureCookies, true)
+}
+
+// SetSession stores a sign-in token in the session cookie
+func SetSession(c *gin.Context, token string) {
+	SetCookie(c, SessionCookie, token, int(TokenTTL.Seconds()))
+}
+
+// ClearSession removes the session cookie
+func ClearSession(c *gin.Context) {
+	SetCookie(c, SessionCookie, "", -1)
+}
+
+// sessionClaims returns the claims of a valid session cookie, nil otherwise.
+// The session of an impersonation token must still be live, as for the API.
+func sessionClaims(c *gin.Context) *Claims {
+	token, err := c.Cookie(SessionCookie)
+	if err != nil || token == "" {
+		return nil
+	}
+	claims, err := ValidateToken(token)
+	if err != nil {
+		return nil
+	}
+	if claims.ImpersonatorID != 0 && !impersonationLive(claims) {
+		return nil
+	}
+	return claims
+}
+
+// HasSession reports whether the request carries a valid session cookie
+func HasSession(c *gin.Context) bool {
+	return sessionClaims(c) != nil
+}
+
+// PageGuard sends visitors without a valid session to /login, remembering
+// the page they asked for in ?next=
+func PageGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := sessionClaims(c)
+		if claims == nil {
+			if _, err := c.Cookie(SessionCookie); err == nil {
+				ClearSession(c) // Expired, forged, or of a revoked impersonation
+			}
+			c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", claims.UserID)
+		c.Set("username", claims.Username)
+		if claims.ImpersonatorID != 0 {
+			c.Set("impersonator_id", claims.ImpersonatorID)
+			c.Set("impersonation_session_id", claims.SessionID)
+		}
+		c.Next()
+	}
+}
+
+// RedirectAuthenticated sends users who are already signed in on to ?next=
+// (or the dashboard) instead of showing them the login page
+func RedirectAuthenticated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if HasSession(c) {
+			c.Redirect(http.StatusFound, SafeNext(c.Query("next")))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// SafeNext returns next if it is a path on this site, DefaultNext otherwise.
+// Absolute URLs, protocol-relative ("//evil.com") and backslash tricks are
+// refused so ?next= can't be used as an open redirect.
+func SafeNext(next string) string {
+	if next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return DefaultNext
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return DefaultNext
+	}
+	if u.Path == "/login" || strings.HasPrefix(u.Path, "/auth/") {
+		return DefaultNext
+	}
+	return u.RequestURI()
+}
+
+// RememberNext keeps ?next= across an OAuth round trip
+func RememberNext(c *gin.Context) {
+	if next := c.Query("next"); next != "" {
+		SetCookie(c, nextCookie, SafeNext(next), 600)
+	}
+}
+
+// CompleteLogin signs the user in after an OAuth callback: it sets the
+// session cookie and hands the token to the dashboard, which stores it for
+// API calls and continues to the page remembered by RememberNext
+func CompleteLogin(c *gin.Context, token string) {
+	next := DefaultNext
+	if remembered, err := c.Cookie(nextCookie); err == nil {
+		next = SafeNext(remembered)
+		SetCookie(c, nextCookie, "", -1)
+	}
+
+	SetSession(c, token)
+	c.Redirect(http.StatusTemporaryRedirect, "/dashboard?token="+url.QueryEscape(token)+"&next="+url.QueryEscape(next))
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PageGuard refuses impersonation sessions the API refuses: revoked or
+// expired ones send the visitor to sign in again
+func TestPageGuardImpersonation(t *testing.T) {
+	testutil.DB(t)
+	initKeys(t, "secret")
+	admin := &models.User{Username: "root", Email: "root@example.com"}
+	target := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(admin)
+	database.DB.Create(target)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/dashboard", PageGuard(), func(c *gin.Context) {
+		c.String(http.StatusOK, "%d as %d", c.GetUint("impersonator_id"), c.GetUint("user_id"))
+	})
+	r.GET("/api/me", AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	page := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+		req.AddCookie(&http.Cookie{Name: SessionCookie, Value: token})
+		r.ServeHTTP(w, req)
+		return w
+	}
+	api := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	session, token, err := StartImpersonation(admin, target, "support ticket", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := page(token); w.Code != http.StatusOK || w.Body.String() != "1 as 2" {
+		t.Fatalf("live session: got %d %q", w.Code, w.Body.String())
+	}
+
+	if err := RevokeImpersonation(session.ID, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	w := page(token)
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), "/login") {
+		t.Errorf("revoked session: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Header().Get("Set-Cookie"), SessionCookie+"=;") {
+		t.Errorf("revoked session's cookie kept: %q", w.Header().Get("Set-Cookie"))
+	}
+	if code := api(token); code != http.StatusUnauthorized {
+		t.Errorf("revoked session on the API: got %d", code)
+	}
+
+	// Expired, the token itself still valid
+	session, token, err = StartImpersonation(admin, target, "support ticket", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Model(session).Update("expires_at", time.Now().Add(-time.Minute))
+	if w := page(token); w.Code != http.StatusFound {
+		t.Errorf("expired session: got %d", w.Code)
+	}
+
+	// The user's own session is unaffected
+	own, _ := GenerateToken(target.ID, target.Username)
+	if w := page(own); w.Code != http.StatusOK || w.Body.String() != "0 as 2" {
+		t.Errorf("own session: got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,127 @@
+package background
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// leaseName names the lease whose holder runs the singleton jobs
+const leaseName = "background-jobs"
+
+// Elector elects one replica, the holder of a lease, among those contending
+// for it. A holder keeps the lease by acquiring it again before it expires;
+// once it expires any replica may take it.
+type Elector interface {
+	// Acquire takes the lease for holder, or renews it, for ttl. It reports
+	// false while another holder has it.
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if holder has it
+	Release(ctx context.Context, holder string) error
+	// Holder returns who holds the lease and until when, "" when nobody does
+	Holder(ctx context.Context) (string, time.Time, error)
+}
+
+// NewElector creates the elector configured by LEADER_ELECTION: a row of
+// the leases table, or a coordination Lease of the cluster the platform
+// runs in
+func NewElector(cfg *config.Config, db *gorm.DB) (Elector, error) {
+	switch cfg.LeaderElection {
+	case "kubernetes":
+		client, err := kubernetes.NewClient("")
+		if err != nil {
+			return nil, fmt.Errorf("LEADER_ELECTION=kubernetes needs the platform to run in a cluster: %w", err)
+		}
+		return &kubernetesElector{client: client, name: "deploy-platform-" + leaseName}, nil
+	default:
+		return NewDatabaseLease(db, leaseName), nil
+	}
+}
+
+// NewDatabaseLease returns the lease called name in the leases table, for
+// roles other than running the singleton jobs whose holders are replicas
+// or the work they do, such as building one project at a time
+func NewDatabaseLease(db *gorm.DB, name string) Elector {
+	return &databaseElector{db: db, name: name}
+}
+
+// databaseElector keeps the lease in a row of the leases table. Taking it
+// is a conditional update, so of replicas racing for an expired lease one
+// updates the row.
+type databaseElector struct {
+	db   *gorm.DB
+	name string
+}
+
+func (e *databaseElector) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	// Postgres and SQLite evaluate every SET against the row as it was
+	result := e.db.WithContext(ctx).Model(&models.Lease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", e.name, holder, now).
+		Updates(map[string]interface{}{
+			"holder":      holder,
+			"expires_at":  now.Add(ttl),
+			"acquired_at": gorm.Expr("CASE WHEN holder = ? THEN acquired_at ELSE ? END", holder, now),
+			"transitions": gorm.Expr("CASE WHEN holder = ? THEN transitions ELSE transitions + 1 END", holder),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// The first replica ever to contend creates the row
+	result = e.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Lease{
+		Name:       e.name,
+		Holder:     holder,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (e *databaseElector) Release(ctx context.Context, holder string) error {
+	return e.db.WithContext(ctx).Model(&models.Lease{}).
+		Where("name = ? AND holder = ?", e.name, holder).
+		Update("expires_at", time.Now()).Error
+}
+
+func (e *databaseElector) Holder(ctx context.Context) (string, time.Time, error) {
+	var lease models.Lease
+	if err := e.db.WithContext(ctx).Where("name = ?", e.name).Limit(1).Find(&lease).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	if lease.Holder == "" || !lease.ExpiresAt.After(time.Now()) {
+		return "", time.Time{}, nil
+	}
+	return lease.Holder, lease.ExpiresAt, nil
+}
+
+// kubernetesElector keeps the lease in a coordination Lease, the way
+// controllers of the cluster elect theirs
+type kubernetesElector struct {
+	client *kubernetes.Client
+	name   string
+}
+
+func (e *kubernetesElector) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	return e.client.AcquireLease(ctx, e.name, holder, ttl)
+}
+
+func (e *kubernetesElector) Release(ctx context.Context, holder string) error {
+	return e.client.ReleaseLease(ctx, e.name, holder)
+}
+
+func (e *kubernetesElector) Holder(ctx context.Context) (string, time.Time, error) {
+	return e.client.LeaseHolder(ctx, e.name)
+}
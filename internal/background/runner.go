@@ -0,0 +1,295 @@
+package background
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pruneInterval is how often records of job runs past the retention are deleted
+const pruneInterval = time.Hour
+
+// Job is work the platform does periodically, once per interval. Intervals
+// start at multiples of Interval, the same on every replica. Every run
+// updates the job's status (see BackgroundJob); Run reports what it
+// processed with Processed.
+type Job struct {
+	Name     string
+	Interval time.Duration // A job without an interval is not run
+	// Singleton jobs run on the leader only, once per interval whichever
+	// replicas are up; other jobs run once per interval on every replica
+	Singleton bool
+	// Manual jobs can also be run on demand, see Runner.Trigger: running
+	// them more often than their interval does no harm
+	Manual bool
+	// StaleAfter is how long the job may go without succeeding before it
+	// is stale and admins are alerted, 0 = two intervals
+	StaleAfter time.Duration
+	Run        func(ctx context.Context) error
+}
+
+// JobInfo describes a registered job
+type JobInfo struct {
+	Name      string `json:"name"`
+	Interval  string `json:"interval"`
+	Singleton bool   `json:"singleton"`
+	Manual    bool   `json:"manual"`
+}
+
+// Runner runs the registered jobs of this replica. Replicas elect a leader,
+// which runs the singleton jobs; when it stops renewing its lease another
+// replica takes over once the lease expires. Each run is recorded as a
+// JobRun whose insertion claims the interval, so a job runs at most once
+// per interval even while a former leader still believes it leads.
+type Runner struct {
+	db        *gorm.DB
+	elector   Elector
+	instance  string
+	ttl       time.Duration
+	retention time.Duration
+
+	alerts *incidents.Notifier
+
+	mu          sync.Mutex
+	jobs        []Job
+	leaderUntil time.Time // This replica leads until then, unless it renews
+	started     bool
+	startedAt   time.Time
+	ctx         context.Context // Of Start, which manual runs run in too
+	running     map[string]bool // Jobs running on this replica
+}
+
+// NewRunner creates the runner of this replica, named INSTANCE_ID in
+// leases and job runs
+func NewRunner(cfg *config.Config, db *gorm.DB, elector Elector) *Runner {
+	r := &Runner{
+		db:        db,
+		elector:   elector,
+		instance:  cfg.InstanceID,
+		ttl:       cfg.LeaderLeaseDuration,
+		retention: cfg.JobRunRetention,
+		alerts:    incidents.NewNotifier(cfg.AdminAlertWebhook),
+		running:   map[string]bool{},
+	}
+	r.Register(
+		Job{Name: "job-runs-prune", Interval: pruneInterval, Singleton: true, Manual: true, Run: r.prune},
+		Job{Name: "background-jobs-stale", Interval: staleCheckInterval, Singleton: true, Manual: true, Run: r.checkStale},
+	)
+	return r
+}
+
+// Register adds jobs to run once the runner starts. Jobs registered after
+// Start are not run.
+func (r *Runner) Register(jobs ...Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, job := range jobs {
+		if job.Interval <= 0 {
+			continue
+		}
+		if r.started {
+			log.Printf("⚠️  Background job %s registered after the jobs started, not run", job.Name)
+			continue
+		}
+		r.jobs = append(r.jobs, job)
+	}
+}
+
+// Start contends for leadership and runs the jobs until ctx is done, then
+// gives up the lease so another replica takes over without waiting for it
+// to expire
+func (r *Runner) Start(ctx context.Context) {
+	r.mu.Lock()
+	r.started = true
+	r.startedAt = time.Now()
+	r.ctx = ctx
+	jobs := append([]Job(nil), r.jobs...)
+	r.mu.Unlock()
+
+	// Singleton jobs wait for the first election
+	r.renew(ctx)
+	go r.lead(ctx)
+	for _, job := range jobs {
+		go r.loop(ctx, job)
+	}
+	log.Printf("✅ %d background jobs started on %s", len(jobs), r.instance)
+}
+
+// Leader reports whether this replica holds the lease
+func (r *Runner) Leader() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Now().Before(r.leaderUntil)
+}
+
+// Instance names this replica
+func (r *Runner) Instance() string {
+	return r.instance
+}
+
+// Elector returns the elector the replicas contend through
+func (r *Runner) Elector() Elector {
+	return r.elector
+}
+
+// Jobs describes the registered jobs
+func (r *Runner) Jobs() []JobInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]JobInfo, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, JobInfo{Name: job.Name, Interval: job.Interval.String(), Singleton: job.Singleton, Manual: job.Manual})
+	}
+	return jobs
+}
+
+// lead renews the lease, or contends for it, three times per lease
+// duration, and releases it when ctx is done
+func (r *Runner) lead(ctx context.Context) {
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if r.Leader() {
+				release, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := r.elector.Release(release, r.instance); err != nil {
+					log.Printf("⚠️  Failed to release the background jobs lease: %v", err)
+				}
+				cancel()
+			}
+			return
+		case <-ticker.C:
+			r.renew(ctx)
+		}
+	}
+}
+
+// renew acquires the lease. A leader failing to renew leads until its
+// lease would expire; it stops before another replica may take over.
+func (r *Runner) renew(ctx context.Context) {
+	start := time.Now()
+	acquired, err := r.elector.Acquire(ctx, r.instance, r.ttl)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("⚠️  Failed to renew the background jobs lease: %v", err)
+		}
+		return
+	}
+
+	wasLeader := r.Leader()
+	r.mu.Lock()
+	if acquired {
+		// Counted from before the request, so it ends before the stored expiry
+		r.leaderUntil = start.Add(r.ttl)
+	} else {
+		r.leaderUntil = time.Time{}
+	}
+	r.mu.Unlock()
+
+	switch {
+	case acquired && !wasLeader:
+		log.Printf("👑 %s leads the background jobs", r.instance)
+	case !acquired && wasLeader:
+		log.Printf("⚠️  %s lost the background jobs lease", r.instance)
+	}
+}
+
+// loop runs a job at the start of each of its intervals until ctx is done
+func (r *Runner) loop(ctx context.Context, job Job) {
+	for {
+		slot := time.Now().Truncate(job.Interval)
+		if !job.Singleton || r.Leader() {
+			r.run(ctx, job, slot)
+		}
+		timer := time.NewTimer(time.Until(slot.Add(job.Interval)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// run claims the interval starting at slot for the job and runs it,
+// recording when it finished and how. An interval claimed already, by
+// another replica or before a restart, is skipped, as is one whose start
+// finds the job still running on this replica, e.g. on demand.
+func (r *Runner) run(ctx context.Context, job Job, slot time.Time) {
+	if !r.begin(job.Name) {
+		return
+	}
+	defer r.end(job.Name)
+	claim := job.Name + "@" + slot.UTC().Format(time.RFC3339)
+	if !job.Singleton {
+		claim += "@" + r.instance
+	}
+	record := models.JobRun{Job: job.Name, Claim: claim, Slot: slot, Instance: r.instance, StartedAt: time.Now()}
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		if ctx.Err() == nil {
+			log.Printf("⚠️  Failed to claim background job %s: %v", job.Name, result.Error)
+		}
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	r.execute(ctx, job, &record)
+}
+
+// execute runs the job of a claimed run and records how it went, on the
+// run and on the job's status
+func (r *Runner) execute(ctx context.Context, job Job, record *models.JobRun) {
+	r.recordStart(job.Name, record.StartedAt)
+	var items atomic.Int64
+	err := runJob(context.WithValue(ctx, itemsKey{}, &items), job)
+	finished := time.Now()
+	record.FinishedAt = &finished
+	record.Items = items.Load()
+	if err != nil {
+		record.Error = err.Error()
+		log.Printf("⚠️  Background job %s failed: %v", job.Name, err)
+	}
+	// Recorded even when ctx is done: the run did finish
+	if err := r.db.Select("finished_at", "error", "items").Updates(record).Error; err != nil {
+		log.Printf("⚠️  Failed to record the run of background job %s: %v", job.Name, err)
+	}
+	r.recordFinish(record)
+}
+
+// runJob runs a job, turning a panic into its error so one job can't stop
+// the others
+func runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("❌ Background job %s panicked: %v\n%s", job.Name, p, debug.Stack())
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// prune deletes the records of job runs older than the retention
+func (r *Runner) prune(ctx context.Context) error {
+	result := r.db.WithContext(ctx).Where("started_at < ?", time.Now().Add(-r.retention)).Delete(&models.JobRun{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("🧹 Pruned %d background job runs older than %s", result.RowsAffected, r.retention)
+	}
+	Processed(ctx, int(result.RowsAffected))
+	return nil
+}
@@ -0,0 +1,135 @@
+package background
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runners creates two replicas contending for the same lease
+func runners(t *testing.T, ttl time.Duration) (*Runner, *Runner) {
+	t.Helper()
+	testutil.DB(t)
+	newRunner := func(instance string) *Runner {
+		cfg := &config.Config{InstanceID: instance, LeaderLeaseDuration: ttl, JobRunRetention: time.Hour}
+		return NewRunner(cfg, database.DB, NewDatabaseLease(database.DB, leaseName))
+	}
+	return newRunner("replica-a"), newRunner("replica-b")
+}
+
+// waitFor polls cond until it holds or within went by
+func waitFor(t *testing.T, what string, within time.Duration, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(within); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// Of two replicas started together one leads and runs the singleton job;
+// jobs that aren't singletons run on both
+func TestRunnersContendForLease(t *testing.T) {
+	a, b := runners(t, time.Minute)
+	var singleton, everywhere atomic.Int64
+	for _, r := range []*Runner{a, b} {
+		r.Register(
+			Job{Name: "singleton", Interval: time.Hour, Singleton: true, Run: func(context.Context) error {
+				singleton.Add(1)
+				return nil
+			}},
+			Job{Name: "everywhere", Interval: time.Hour, Run: func(context.Context) error {
+				everywhere.Add(1)
+				return nil
+			}},
+		)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, r := range []*Runner{a, b} {
+		wg.Add(1)
+		go func(r *Runner) {
+			defer wg.Done()
+			r.Start(ctx)
+		}(r)
+	}
+	wg.Wait()
+
+	if a.Leader() == b.Leader() {
+		t.Fatalf("leaders: a %v, b %v", a.Leader(), b.Leader())
+	}
+	waitFor(t, "the jobs to run", time.Second, func() bool { return singleton.Load() == 1 && everywhere.Load() == 2 })
+	time.Sleep(100 * time.Millisecond)
+	if n := singleton.Load(); n != 1 {
+		t.Errorf("the singleton job ran %d times", n)
+	}
+	var runs []models.JobRun
+	database.DB.Where("job = ?", "singleton").Find(&runs)
+	if len(runs) != 1 || (runs[0].Instance == a.Instance()) != a.Leader() {
+		t.Errorf("singleton runs %+v", runs)
+	}
+}
+
+// Replicas that both believe they lead, a former leader whose lease
+// expired and the new one, claim the interval once between them
+func TestRunnersClaimIntervalOnce(t *testing.T) {
+	a, b := runners(t, time.Minute)
+	var ran atomic.Int64
+	job := Job{Name: "singleton", Interval: time.Hour, Singleton: true, Run: func(context.Context) error {
+		ran.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}}
+	slot := time.Now().Truncate(job.Interval)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		for _, r := range []*Runner{a, b} {
+			wg.Add(1)
+			go func(r *Runner) {
+				defer wg.Done()
+				r.run(context.Background(), job, slot)
+			}(r)
+		}
+	}
+	wg.Wait()
+	if n := ran.Load(); n != 1 {
+		t.Errorf("the job ran %d times in one interval", n)
+	}
+}
+
+// A leader stopping releases the lease; the other replica takes it at its
+// next renewal rather than once the lease expired
+func TestLeaseHandover(t *testing.T) {
+	const ttl = 900 * time.Millisecond
+	a, b := runners(t, ttl)
+	ctxA, stopA := context.WithCancel(context.Background())
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	a.Start(ctxA)
+	b.Start(ctxB)
+	if !a.Leader() || b.Leader() {
+		t.Fatalf("leaders: a %v, b %v", a.Leader(), b.Leader())
+	}
+
+	stopA()
+	// b renews every ttl/3
+	waitFor(t, "b to lead", ttl/2, b.Leader)
+	holder, _, err := b.Elector().Holder(context.Background())
+	if err != nil || holder != b.Instance() {
+		t.Errorf("lease held by %q, %v", holder, err)
+	}
+	var lease models.Lease
+	database.DB.Where("name = ?", leaseName).First(&lease)
+	if lease.Transitions != 1 {
+		t.Errorf("%d transitions", lease.Transitions)
+	}
+}
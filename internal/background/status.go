@@ -0,0 +1,227 @@
+package background
+
+import (
+	"context"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// staleCheckInterval is how often jobs are checked for going without success
+const staleCheckInterval = 5 * time.Minute
+
+// Errors of Trigger
+var (
+	ErrUnknownJob = errors.New("no such background job on this replica")
+	ErrNotManual  = errors.New("the job can't be run on demand")
+	ErrJobRunning = errors.New("the job is already running on this replica")
+	ErrNotStarted = errors.New("background jobs are not running yet")
+)
+
+type itemsKey struct{}
+
+// Processed counts n more items processed by the job running in ctx, shown
+// as its items_processed; outside a job it does nothing
+func Processed(ctx context.Context, n int) {
+	if items, ok := ctx.Value(itemsKey{}).(*atomic.Int64); ok {
+		items.Add(int64(n))
+	}
+}
+
+// JobStatus is a registered job and how its runs went
+type JobStatus struct {
+	JobInfo
+	StaleAfter string                `json:"stale_after"`
+	Stale      bool                  `json:"stale"`   // It went without succeeding for longer than StaleAfter
+	Running    bool                  `json:"running"` // On this replica
+	Status     *models.BackgroundJob `json:"status"`  // nil until it first runs
+}
+
+// staleAfter is how long job may go without succeeding
+func staleAfter(job Job) time.Duration {
+	if job.StaleAfter > 0 {
+		return job.StaleAfter
+	}
+	return 2 * job.Interval
+}
+
+// Statuses reports the status of the jobs registered on this replica
+func (r *Runner) Statuses(ctx context.Context) ([]JobStatus, error) {
+	r.mu.Lock()
+	jobs := append([]Job(nil), r.jobs...)
+	running := make(map[string]bool, len(r.running))
+	for name := range r.running {
+		running[name] = true
+	}
+	startedAt := r.startedAt
+	r.mu.Unlock()
+
+	var rows []models.BackgroundJob
+	if err := r.db.WithContext(ctx).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*models.BackgroundJob, len(rows))
+	for i := range rows {
+		byName[rows[i].Name] = &rows[i]
+	}
+
+	now := time.Now()
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		status := JobStatus{
+			JobInfo:    JobInfo{Name: job.Name, Interval: job.Interval.String(), Singleton: job.Singleton, Manual: job.Manual},
+			StaleAfter: staleAfter(job).String(),
+			Running:    running[job.Name],
+			Status:     byName[job.Name],
+		}
+		// Counted from the first run at the earliest, and from this
+		// replica's start: jobs get a chance to run before they are stale
+		since := startedAt
+		if s := status.Status; s != nil {
+			last := s.CreatedAt
+			if s.LastSucceededAt != nil {
+				last = *s.LastSucceededAt
+			}
+			if last.After(since) {
+				since = last
+			}
+		}
+		status.Stale = !since.IsZero() && now.Sub(since) > staleAfter(job)
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Trigger runs a manual job now, on this replica, in the background. The
+// run is recorded like the scheduled ones, marked manual; it doesn't take
+// the place of the run of the current interval.
+func (r *Runner) Trigger(name string) (*models.JobRun, error) {
+	r.mu.Lock()
+	ctx := r.ctx
+	var job *Job
+	for i := range r.jobs {
+		if r.jobs[i].Name == name {
+			job = &r.jobs[i]
+			break
+		}
+	}
+	r.mu.Unlock()
+	switch {
+	case job == nil:
+		return nil, ErrUnknownJob
+	case !job.Manual:
+		return nil, ErrNotManual
+	case ctx == nil:
+		return nil, ErrNotStarted
+	}
+	if !r.begin(name) {
+		return nil, ErrJobRunning
+	}
+
+	now := time.Now()
+	record := models.JobRun{
+		Job:       name,
+		Claim:     name + "@manual@" + now.UTC().Format(time.RFC3339Nano) + "@" + r.instance,
+		Slot:      now,
+		Instance:  r.instance,
+		StartedAt: now,
+		Manual:    true,
+	}
+	if err := r.db.Create(&record).Error; err != nil {
+		r.end(name)
+		return nil, err
+	}
+	started := record
+	log.Printf("▶️  Background job %s run on demand on %s", name, r.instance)
+	go func() {
+		defer r.end(name)
+		r.execute(ctx, *job, &record)
+	}()
+	return &started, nil
+}
+
+// begin marks a job running on this replica, false if it already is
+func (r *Runner) begin(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[name] {
+		return false
+	}
+	r.running[name] = true
+	return true
+}
+
+func (r *Runner) end(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, name)
+}
+
+// recordStart records that a run of the job started
+func (r *Runner) recordStart(name string, at time.Time) {
+	err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_started_at", "last_instance"}),
+	}).Create(&models.BackgroundJob{Name: name, LastStartedAt: &at, LastInstance: r.instance, CreatedAt: at}).Error
+	if err != nil {
+		log.Printf("⚠️  Failed to record the start of background job %s: %v", name, err)
+	}
+}
+
+// recordFinish records how a run went on the status of its job
+func (r *Runner) recordFinish(record *models.JobRun) {
+	updates := map[string]interface{}{"runs": gorm.Expr("runs + 1")}
+	if record.Error == "" {
+		updates["last_succeeded_at"] = record.FinishedAt
+		updates["items_processed"] = record.Items
+		updates["stale_alerted_at"] = nil
+	} else {
+		updates["last_error"] = record.Error
+		updates["last_error_at"] = record.FinishedAt
+		updates["failures"] = gorm.Expr("failures + 1")
+	}
+	if err := r.db.Model(&models.BackgroundJob{}).Where("name = ?", record.Job).Updates(updates).Error; err != nil {
+		log.Printf("⚠️  Failed to record the status of background job %s: %v", record.Job, err)
+	}
+}
+
+// checkStale alerts admins of the jobs that went stale, once until they
+// succeed again
+func (r *Runner) checkStale(ctx context.Context) error {
+	statuses, err := r.Statuses(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if !status.Stale || (status.Status != nil && status.Status.StaleAlertedAt != nil) {
+			continue
+		}
+		text := fmt.Sprintf("🚨 Background job %s hasn't succeeded for over %s (it runs every %s)", status.Name, status.StaleAfter, status.Interval)
+		switch {
+		case status.Status == nil || status.Status.LastStartedAt == nil:
+			text += ": it never ran"
+		case status.Status.LastError != "":
+			text += ": its last error was " + status.Status.LastError
+		}
+		log.Print(text)
+		r.alerts.Alert(incidents.Alert{Event: "background_job.stale", Text: text, Details: status})
+
+		now := time.Now()
+		err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"stale_alerted_at"}),
+		}).Create(&models.BackgroundJob{Name: status.Name, StaleAlertedAt: &now, CreatedAt: r.startedAt}).Error
+		if err != nil {
+			return err
+		}
+		Processed(ctx, 1)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package background
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertWebhook collects the alerts posted to ADMIN_ALERT_WEBHOOK
+type alertWebhook struct {
+	*httptest.Server
+	mu     sync.Mutex
+	alerts []incidents.Alert
+}
+
+func newAlertWebhook(t *testing.T) *alertWebhook {
+	w := &alertWebhook{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var alert incidents.Alert
+		json.NewDecoder(r.Body).Decode(&alert)
+		w.mu.Lock()
+		w.alerts = append(w.alerts, alert)
+		w.mu.Unlock()
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *alertWebhook) received() []incidents.Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]incidents.Alert(nil), w.alerts...)
+}
+
+// jobStatus is the status of the named job
+func jobStatus(t *testing.T, r *Runner, name string) JobStatus {
+	t.Helper()
+	statuses, err := r.Statuses(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Name == name {
+			return status
+		}
+	}
+	t.Fatalf("no job %s in %+v", name, statuses)
+	return JobStatus{}
+}
+
+// Runs update the status of their job: the last success and what it
+// processed, the last error, kept once it succeeds again
+func TestJobStatus(t *testing.T) {
+	r, _ := runners(t, time.Minute)
+	fail := errors.New("registry unreachable")
+	var err error
+	job := Job{Name: "registry-gc", Interval: time.Hour, Run: func(ctx context.Context) error {
+		Processed(ctx, 3)
+		Processed(ctx, 4)
+		return err
+	}}
+	r.Register(job)
+	slot := time.Now().Truncate(time.Hour)
+
+	r.run(context.Background(), job, slot)
+	status := jobStatus(t, r, "registry-gc").Status
+	if status == nil || status.Runs != 1 || status.ItemsProcessed != 7 || status.LastSucceededAt == nil || status.LastInstance != "replica-a" {
+		t.Fatalf("status %+v", status)
+	}
+
+	err = fail
+	r.run(context.Background(), job, slot.Add(-time.Hour))
+	status = jobStatus(t, r, "registry-gc").Status
+	if status.Runs != 2 || status.Failures != 1 || status.LastError != "registry unreachable" || status.LastErrorAt == nil || status.ItemsProcessed != 7 {
+		t.Fatalf("status after a failure %+v", status)
+	}
+
+	err = nil
+	r.run(context.Background(), job, slot.Add(-2*time.Hour))
+	status = jobStatus(t, r, "registry-gc").Status
+	if status.Runs != 3 || status.Failures != 1 || status.LastError != "registry unreachable" || !status.LastSucceededAt.After(*status.LastErrorAt) {
+		t.Errorf("status after recovering %+v", status)
+	}
+	var run models.JobRun
+	database.DB.Where("job = ? AND slot = ?", "registry-gc", slot).First(&run)
+	if run.Items != 7 || run.Manual {
+		t.Errorf("run %+v", run)
+	}
+
+	// The status survives restarts: a new runner reads it
+	restarted := NewRunner(&config.Config{InstanceID: "replica-a", LeaderLeaseDuration: time.Minute}, database.DB, NewDatabaseLease(database.DB, leaseName))
+	restarted.Register(job)
+	if status := jobStatus(t, restarted, "registry-gc").Status; status == nil || status.Runs != 3 {
+		t.Errorf("status after a restart %+v", status)
+	}
+}
+
+// Jobs going without success for StaleAfter, two intervals by default,
+// are stale, and admins are alerted once until they succeed again
+func TestStaleJobs(t *testing.T) {
+	webhook := newAlertWebhook(t)
+	r, _ := runners(t, time.Minute)
+	r.alerts = incidents.NewNotifier(webhook.URL)
+	var err error
+	failing := Job{Name: "uptime-prober", Interval: time.Minute, Run: func(context.Context) error { return err }}
+	r.Register(
+		failing,
+		Job{Name: "schedule-runner", Interval: time.Minute, StaleAfter: time.Hour, Run: func(context.Context) error { return nil }},
+		Job{Name: "token-health", Interval: time.Minute, Run: func(context.Context) error { return nil }},
+	)
+
+	// Up for long enough to have run; the prober succeeded 3 minutes ago
+	// and failed since, the schedule runner and token health never ran
+	now := time.Now()
+	r.startedAt = now.Add(-5 * time.Minute)
+	r.run(context.Background(), failing, now.Add(-3*time.Minute).Truncate(time.Minute))
+	database.DB.Model(&models.BackgroundJob{}).Where("name = ?", "uptime-prober").Update("last_succeeded_at", now.Add(-3*time.Minute))
+	err = errors.New("probe timed out")
+	r.run(context.Background(), failing, now.Add(-2*time.Minute).Truncate(time.Minute))
+
+	for name, want := range map[string]bool{"uptime-prober": true, "schedule-runner": false, "token-health": true, "job-runs-prune": false} {
+		if status := jobStatus(t, r, name); status.Stale != want {
+			t.Errorf("%s: stale %v after %s", name, status.Stale, status.StaleAfter)
+		}
+	}
+	if status := jobStatus(t, r, "schedule-runner"); status.StaleAfter != "1h0m0s" {
+		t.Errorf("stale after %s", status.StaleAfter)
+	}
+
+	// Jobs aren't stale before the replica had a chance to run them
+	r.startedAt = now.Add(-time.Minute)
+	if status := jobStatus(t, r, "token-health"); status.Stale {
+		t.Error("token-health is stale right after a start")
+	}
+	r.startedAt = now.Add(-5 * time.Minute)
+
+	if err := r.checkStale(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the alerts", time.Second, func() bool { return len(webhook.received()) == 2 })
+	texts := map[string]string{}
+	for _, alert := range webhook.received() {
+		if alert.Event != "background_job.stale" {
+			t.Errorf("event %s", alert.Event)
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(alert.Text, "🚨 Background job "), " ")
+		texts[name] = alert.Text
+	}
+	if !strings.HasSuffix(texts["uptime-prober"], "hasn't succeeded for over 2m0s (it runs every 1m0s): its last error was probe timed out") ||
+		!strings.HasSuffix(texts["token-health"], ": it never ran") {
+		t.Errorf("alerts %q", texts)
+	}
+
+	// Once per stale period
+	if err := r.checkStale(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(webhook.received()); n != 2 {
+		t.Errorf("%d alerts after checking again", n)
+	}
+
+	// A success clears the alert; going stale again alerts again
+	err = nil
+	r.run(context.Background(), failing, now.Add(-time.Minute).Truncate(time.Minute))
+	if status := jobStatus(t, r, "uptime-prober"); status.Stale || status.Status.StaleAlertedAt != nil {
+		t.Fatalf("status after succeeding %+v", status.Status)
+	}
+	database.DB.Model(&models.BackgroundJob{}).Where("name = ?", "uptime-prober").Update("last_succeeded_at", now.Add(-5*time.Minute))
+	r.checkStale(context.Background())
+	waitFor(t, "the alert of going stale again", time.Second, func() bool { return len(webhook.received()) == 3 })
+}
+
+// Manual jobs run on demand, recorded as manual runs, once at a time on a
+// replica; scheduled runs finding the job running are skipped
+func TestTrigger(t *testing.T) {
+	r, _ := runners(t, time.Minute)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	runs := 0
+	workspaces := Job{Name: "workspaces-sweep", Interval: time.Hour, Manual: true, Run: func(ctx context.Context) error {
+		mu.Lock()
+		runs++
+		first := runs == 1
+		mu.Unlock()
+		if !first {
+			<-release
+		}
+		Processed(ctx, 2)
+		return nil
+	}}
+	r.Register(workspaces, Job{Name: "cost-sampler", Interval: time.Hour, Run: func(context.Context) error { return nil }})
+	if _, err := r.Trigger("workspaces-sweep"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("before the start: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Start(ctx)
+	waitFor(t, "the scheduled run", time.Second, func() bool {
+		status := jobStatus(t, r, "workspaces-sweep")
+		return !status.Running && status.Status != nil && status.Status.Runs == 1
+	})
+
+	for name, want := range map[string]error{"registry-gc": ErrUnknownJob, "cost-sampler": ErrNotManual} {
+		if _, err := r.Trigger(name); !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", name, err, want)
+		}
+	}
+
+	run, err := r.Trigger("workspaces-sweep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.Manual || run.Instance != "replica-a" || run.FinishedAt != nil {
+		t.Errorf("run %+v", run)
+	}
+	if _, err := r.Trigger("workspaces-sweep"); !errors.Is(err, ErrJobRunning) {
+		t.Errorf("while running: %v", err)
+	}
+	if !jobStatus(t, r, "workspaces-sweep").Running {
+		t.Error("not reported running")
+	}
+	// The next interval starts while it runs on demand
+	r.run(ctx, workspaces, time.Now().Truncate(time.Hour).Add(time.Hour))
+
+	close(release)
+	waitFor(t, "the manual run", time.Second, func() bool {
+		status := jobStatus(t, r, "workspaces-sweep")
+		return !status.Running && status.Status.Runs == 2
+	})
+	var recorded models.JobRun
+	database.DB.First(&recorded, run.ID)
+	if !recorded.Manual || recorded.FinishedAt == nil || recorded.Items != 2 || recorded.Error != "" {
+		t.Errorf("recorded %+v", recorded)
+	}
+	var claimed int64
+	database.DB.Model(&models.JobRun{}).Where("job = ?", "workspaces-sweep").Count(&claimed)
+	if claimed != 2 || runs != 2 {
+		t.Errorf("%d runs recorded, %d ran", claimed, runs)
+	}
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/storage"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrRunning is returned when a backup is requested while one runs
+var ErrRunning = errors.New("a backup is already running")
+
+// Service dumps the platform database, encrypts the dump with the platform
+// encryption key and writes it to storage, keeping the last retention backups
+type Service struct {
+	db        *gorm.DB
+	storage   storage.Storage
+	retention int       // 0 = keep all
+	schedule  *Schedule // nil = only on request
+
+	running atomic.Bool
+}
+
+// NewStorage opens the BACKUP_DESTINATION
+func NewStorage(cfg *config.Config) (storage.Storage, error) {
+	return storage.Open(cfg, "BACKUP_DESTINATION", cfg.BackupDestination)
+}
+
+// List returns the backups kept in store, oldest first: their names sort by
+// time, which survives copies between storages unlike modification times
+func List(ctx context.Context, store storage.Storage) ([]storage.Object, error) {
+	return store.List(ctx, namePrefix)
+}
+
+// NewService configures backups from BACKUP_* settings. secrets.Init must
+// have been called.
+func NewService(cfg *config.Config, db *gorm.DB) (*Service, error) {
+	store, err := NewStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{db: db, storage: store, retention: cfg.BackupRetention}
+	if cfg.BackupSchedule != "" {
+		if s.schedule, err = ParseSchedule(cfg.BackupSchedule); err != nil {
+			return nil, fmt.Errorf("invalid BACKUP_SCHEDULE: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Start runs scheduled backups until ctx is done. Every replica runs the
+// schedule; the backup's unique name lets only one of them take each slot.
+func (s *Service) Start(ctx context.Context) {
+	if s.schedule == nil {
+		return
+	}
+	go func() {
+		for {
+			next := s.schedule.Next(time.Now())
+			if next.IsZero() {
+				log.Println("⚠️  Backup schedule never fires, scheduled backups disabled")
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			// Another replica took this slot (its insert of the name won)
+			var taken int64
+			s.db.Model(&models.Backup{}).Where("name = ?", backupName(models.BackupTriggerSchedule, next)).Count(&taken)
+			if taken > 0 {
+				continue
+			}
+			record, err := s.begin(models.BackupTriggerSchedule, next)
+			if err != nil {
+				log.Printf("⚠️  Scheduled backup not started: %v", err)
+				continue
+			}
+			s.run(ctx, record)
+		}
+	}()
+}
+
+// Trigger starts a backup in the background and returns its record
+func (s *Service) Trigger() (*models.Backup, error) {
+	record, err := s.begin(models.BackupTriggerManual, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	go s.run(context.Background(), record)
+	return record, nil
+}
+
+// begin claims the running flag and records the backup about to run
+func (s *Service) begin(trigger string, at time.Time) (*models.Backup, error) {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil, ErrRunning
+	}
+	record := &models.Backup{
+		Name:    backupName(trigger, at),
+		Trigger: trigger,
+		Status:  models.BackupRunning,
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		s.running.Store(false)
+		return nil, err
+	}
+	return record, nil
+}
+
+// namePrefix starts the names of backups
+const namePrefix = "backup-"
+
+// backupName names the backup started at; names sort by time
+func backupName(trigger string, at time.Time) string {
+	return fmt.Sprintf("%s%s-%s.enc", namePrefix, at.UTC().Format("20060102T150405Z"), trigger)
+}
+
+// run writes the backup begun as record and applies retention
+func (s *Service) run(ctx context.Context, record *models.Backup) {
+	defer s.running.Store(false)
+
+	started := time.Now()
+	size, checksum, err := s.write(ctx, record.Name)
+	completed := time.Now()
+	record.CompletedAt = &completed
+	if err != nil {
+		log.Printf("❌ Backup %s failed: %v", record.Name, err)
+		record.Status = models.BackupFailed
+		record.Error = err.Error()
+		s.db.Model(record).Select("status", "error", "completed_at").Updates(record)
+		return
+	}
+
+	log.Printf("✅ Backup %s written (%d bytes in %s)", record.Name, size, time.Since(started).Round(time.Second))
+	record.Status = models.BackupCompleted
+	record.SizeBytes = size
+	record.SHA256 = checksum
+	s.db.Model(record).Select("status", "size_bytes", "sha256", "completed_at").Updates(record)
+
+	s.prune(ctx)
+}
+
+// write streams dump -> encryption -> storage without holding the dump in
+// memory, returning the stored size and checksum
+func (s *Service) write(ctx context.Context, name string) (int64, string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		enc, err := secrets.NewEncryptWriter(pw)
+		if err == nil {
+			if _, err = Dump(ctx, s.db, enc); err == nil {
+				err = enc.Close()
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	hash := sha256.New()
+	size, err := s.storage.Put(ctx, name, io.TeeReader(pr, hash))
+	pr.CloseWithError(err) // Unblocks the dump if storage gave up first
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// prune deletes completed backups beyond the retention count, oldest first
+func (s *Service) prune(ctx context.Context) {
+	if s.retention <= 0 {
+		return
+	}
+	var expired []models.Backup
+	if err := s.db.Where("status = ?", models.BackupCompleted).
+		Order("created_at DESC, id DESC").
+		Offset(s.retention).
+		Find(&expired).Error; err != nil {
+		log.Printf("⚠️  Failed to list backups to prune: %v", err)
+		return
+	}
+	for i := range expired {
+		if err := s.storage.Delete(ctx, expired[i].Name); err != nil {
+			log.Printf("⚠️  Failed to delete backup %s: %v", expired[i].Name, err)
+			continue
+		}
+		s.db.Model(&expired[i]).Update("status", models.BackupPruned)
+		log.Printf("🗑️  Pruned backup %s", expired[i].Name)
+	}
+}
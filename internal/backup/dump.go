@@ -0,0 +1,328 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"deploy-platform/internal/database"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+// A dump is a gzipped tar: manifest.json first, then the SQLite database
+// file or one COPY text file per Postgres table, in manifest order. Dumps
+// hold the rows exactly as stored, so secrets stay in their encrypted
+// column form; the dump as a whole is encrypted on top of that.
+const (
+	manifestEntry = "manifest.json"
+	sqliteEntry   = "database.sqlite"
+	tablesDir     = "tables/"
+)
+
+// Manifest describes a dump
+type Manifest struct {
+	Version   int       `json:"version"`
+	Driver    string    `json:"driver"` // sqlite or postgres
+	CreatedAt time.Time `json:"created_at"`
+	Tables    []Table   `json:"tables,omitempty"` // Postgres only
+}
+
+// Table is a dumped Postgres table and its columns in COPY order
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+}
+
+// Dump writes a consistent snapshot of db to w
+func Dump(ctx context.Context, db *gorm.DB, w io.Writer) (*Manifest, error) {
+	manifest := &Manifest{Version: 1, Driver: db.Dialector.Name(), CreatedAt: time.Now().UTC()}
+
+	// Table files are spooled to disk: tar needs each entry's size up front
+	spool, err := os.MkdirTemp("", "backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(spool)
+
+	var files []string
+	switch manifest.Driver {
+	case "sqlite":
+		path := filepath.Join(spool, sqliteEntry)
+		if err := dumpSQLite(ctx, db, path); err != nil {
+			return nil, err
+		}
+		files = []string{path}
+	case "postgres":
+		if manifest.Tables, files, err = dumpPostgres(ctx, db, spool); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("backups of %s databases are not supported", manifest.Driver)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, manifestEntry, manifestJSON); err != nil {
+		return nil, err
+	}
+	for i, path := range files {
+		name := sqliteEntry
+		if manifest.Driver == "postgres" {
+			name = tablesDir + manifest.Tables[i].Name
+		}
+		if err := writeFileEntry(tw, name, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, gz.Close()
+}
+
+// dumpSQLite checkpoints the WAL into the database file, then copies it with
+// VACUUM INTO, which reads a consistent snapshot while writers carry on
+func dumpSQLite(ctx context.Context, db *gorm.DB, path string) error {
+	db = db.WithContext(ctx)
+	if err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+		return fmt.Errorf("failed to checkpoint the WAL: %w", err)
+	}
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("failed to copy the database: %w", err)
+	}
+	return nil
+}
+
+// dumpPostgres copies every table out through the driver's COPY protocol,
+// all within one read-only repeatable-read transaction so tables agree with
+// each other. Returns the tables and the file each was written to.
+func dumpPostgres(ctx context.Context, db *gorm.DB, dir string) ([]Table, []string, error) {
+	tables, err := modelTables(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []string
+	err = withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+		tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		for i := range tables {
+			rows, err := tx.Query(ctx,
+				"SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position",
+				tables[i].Name)
+			if err != nil {
+				return err
+			}
+			if tables[i].Columns, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+				return err
+			}
+
+			path := filepath.Join(dir, fmt.Sprintf("%03d.copy", i))
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			_, err = conn.PgConn().CopyTo(ctx, f, copySQL(tables[i], "TO STDOUT"))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to dump table %s: %w", tables[i].Name, err)
+			}
+			files = append(files, path)
+		}
+		return tx.Commit(ctx)
+	})
+	return tables, files, err
+}
+
+// Restore replaces the database with the dump read from r. SQLite dumps are
+// written to sqlitePath, which must not exist unless force is set. Postgres
+// dumps are loaded into db (migrated to the current schema) in a single
+// transaction: every table of the dump is emptied, then refilled.
+func Restore(ctx context.Context, r io.Reader, db *gorm.DB, sqlitePath string, force bool) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestEntry {
+		return nil, errors.New("not a backup: missing manifest")
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if manifest.Version != 1 {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+
+	switch manifest.Driver {
+	case "sqlite":
+		return &manifest, restoreSQLite(tr, sqlitePath, force)
+	case "postgres":
+		if db == nil || db.Dialector.Name() != "postgres" {
+			return nil, errors.New("this is a Postgres backup: set DATABASE_URL to the database to restore into")
+		}
+		return &manifest, restorePostgres(ctx, tr, db, manifest.Tables)
+	}
+	return nil, fmt.Errorf("unsupported backup driver %q", manifest.Driver)
+}
+
+func restoreSQLite(tr *tar.Reader, path string, force bool) error {
+	header, err := tr.Next()
+	if err != nil || header.Name != sqliteEntry {
+		return errors.New("invalid backup: missing database file")
+	}
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s exists; stop the platform and restore with -force to replace it", path)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, tr)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// A leftover WAL of the replaced database would be replayed into the restored one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func restorePostgres(ctx context.Context, tr *tar.Reader, db *gorm.DB, tables []Table) error {
+	return withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		names := make([]string, len(tables))
+		for i, table := range tables {
+			names[i] = pgx.Identifier{table.Name}.Sanitize()
+		}
+		if _, err := tx.Exec(ctx, "TRUNCATE "+strings.Join(names, ", ")+" CASCADE"); err != nil {
+			return fmt.Errorf("failed to empty tables: %w", err)
+		}
+
+		// Tables are in dependency order, parents first
+		for i, table := range tables {
+			header, err := tr.Next()
+			if err != nil || header.Name != tablesDir+table.Name {
+				return fmt.Errorf("invalid backup: missing data of table %s", table.Name)
+			}
+			if _, err := conn.PgConn().CopyFrom(ctx, tr, copySQL(table, "FROM STDIN")); err != nil {
+				return fmt.Errorf("failed to restore table %s: %w", table.Name, err)
+			}
+			// Serial IDs carry on after the restored rows
+			if !slices.Contains(table.Columns, "id") {
+				continue
+			}
+			if _, err := tx.Exec(ctx, fmt.Sprintf(
+				"SELECT setval(seq, COALESCE((SELECT MAX(id) FROM %[1]s), 1), EXISTS (SELECT 1 FROM %[1]s)) FROM pg_get_serial_sequence($1, 'id') AS seq WHERE seq IS NOT NULL",
+				names[i]), names[i]); err != nil {
+				return fmt.Errorf("failed to reset the ID sequence of %s: %w", table.Name, err)
+			}
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// modelTables lists the tables of the platform's models, parents first
+func modelTables(db *gorm.DB) ([]Table, error) {
+	tables := make([]Table, 0, len(database.Models))
+	for _, model := range database.Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		tables = append(tables, Table{Name: stmt.Schema.Table})
+	}
+	return tables, nil
+}
+
+// copySQL is the COPY statement moving table's columns in direction
+func copySQL(table Table, direction string) string {
+	columns := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return fmt.Sprintf("COPY %s (%s) %s", pgx.Identifier{table.Name}.Sanitize(), strings.Join(columns, ", "), direction)
+}
+
+// withPgxConn runs fn on a pgx connection taken from db's pool
+func withPgxConn(ctx context.Context, db *gorm.DB, fn func(*pgx.Conn) error) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected Postgres driver %T", driverConn)
+		}
+		return fn(stdConn.Conn())
+	})
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeFileEntry(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
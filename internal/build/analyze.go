@@ -0,0 +1,164 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"deploy-platform/internal/throttle"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// analysisConcurrency bounds the analyses running at once, across projects
+const analysisConcurrency = 2
+
+// ErrCommitMoved is returned when the analyzed branch moved past the commit
+// before it was cloned: the newer push gets its own analysis
+var ErrCommitMoved = errors.New("the branch moved past the commit before it was analyzed")
+
+// Analyzer runs what a build would detect on pushed commits of projects in
+// analysis-only mode, against a shallow clone, with time and size budgets.
+// It never builds: its detector has no Docker client.
+type Analyzer struct {
+	detector *Service
+	timeout  time.Duration
+	maxBytes int64
+	slots    *throttle.Semaphore
+}
+
+// AnalysisResult is what a build of the analyzed commit would use
+type AnalysisResult struct {
+	Detection  *Detection
+	Source     string // DockerfileSource*
+	Dockerfile string
+	Port       int // Port the app would be told to listen on, 0 for workers
+	Warnings   []string
+}
+
+// NewAnalyzer creates an analyzer generating Dockerfiles from templates
+// (nil = built-in), giving up on analyses running longer than timeout or
+// cloning more than maxMB
+func NewAnalyzer(templates *Templates, timeout time.Duration, maxMB int) *Analyzer {
+	return &Analyzer{
+		detector: &Service{templates: templates},
+		timeout:  timeout,
+		maxBytes: int64(maxMB) << 20,
+		slots:    throttle.New("analysis", analysisConcurrency),
+	}
+}
+
+// Analyze clones commit sha of the project's branch, only that commit, and
+// runs detection against it with the project's Dockerfile override and
+// build commands, the way its build would. Submodules and Git LFS files
+// are not fetched.
+func (a *Analyzer) Analyze(ctx context.Context, project *models.Project, branch, sha string) (*AnalysisResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	if err := a.slots.Acquire(ctx, 1); err != nil {
+		return nil, a.budgetError(ctx, err)
+	}
+	defer a.slots.Release(1)
+
+	dir, err := os.MkdirTemp("", "analysis-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	auth, err := cloneAuth(project)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+		URL:           project.RepoURL,
+		Auth:          auth,
+		Depth:         1,
+		SingleBranch:  true,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return nil, a.budgetError(ctx, fmt.Errorf("failed to clone repository: %w", explainCloneError(project, auth, err)))
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	// Generic webhooks may push abbreviated hashes
+	if !strings.HasPrefix(head.Hash().String(), sha) {
+		return nil, fmt.Errorf("%w: %s is at %s", ErrCommitMoved, branch, textutil.ShortSHA(head.Hash().String()))
+	}
+	if size := dirSize(dir); size > a.maxBytes {
+		return nil, fmt.Errorf("the checked out commit is %s, over the analysis budget of %s", formatSize(size), formatSize(a.maxBytes))
+	}
+
+	var warnings []string
+	if fileExists(filepath.Join(dir, ".gitmodules")) {
+		warnings = append(warnings, "submodules are not checked out for analyses: detection only saw the repository's own files")
+	}
+	if usesLFS(dir) {
+		warnings = append(warnings, "Git LFS files are not fetched for analyses: detection only saw their pointers")
+	}
+
+	override, err := models.DockerfileOverride(database.DB, project.ID)
+	if err != nil {
+		return nil, err
+	}
+	var commands *models.BuildCommands
+	if project.BuildCommands.Set() {
+		commands = &project.BuildCommands
+	}
+	detection, err := a.detector.detectAndCreateDockerfile(dir, commands, project.RuntimeVersion, override)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AnalysisResult{
+		Detection:  detection,
+		Source:     DockerfileSourceGenerated,
+		Dockerfile: dockerfileUsed(dir, detection),
+		Port:       8080,
+	}
+	switch detection.Type {
+	case "dockerfile":
+		result.Source = DockerfileSourceRepository
+	case "override":
+		result.Source = DockerfileSourceOverride
+	}
+	// PORT is picked the way deployToKubernetes does
+	if detection.Port > 0 {
+		result.Port = detection.Port
+	}
+	if project.Port > 0 {
+		result.Port = project.Port
+	}
+	if project.Worker() {
+		result.Port = 0
+	}
+
+	warnings = append(warnings, detection.Warnings...)
+	warnings = append(warnings, detection.missingEnvWarnings(definedEnv(project.ID))...)
+	if lint, err := LintDockerfile(result.Dockerfile); err != nil {
+		warnings = append(warnings, "the Dockerfile would fail to build: "+err.Error())
+	} else {
+		warnings = append(warnings, lint...)
+	}
+	result.Warnings = warnings
+	return result, nil
+}
+
+// budgetError explains err when the analysis ran out of time
+func (a *Analyzer) budgetError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the analysis took longer than its budget of %s", a.timeout)
+	}
+	return err
+}
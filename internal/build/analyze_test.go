@@ -0,0 +1,195 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtureFiles are the files of the fixture repository at testdata/<name>,
+// by path
+func fixtureFiles(t *testing.T, name string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	root := filepath.Join("testdata", filepath.FromSlash(name))
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// noDocker fails the test on any call to the Docker daemon
+func noDocker(t *testing.T) {
+	t.Helper()
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("called the Docker daemon: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(daemon.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(daemon.URL, "http://"))
+}
+
+// Analyses detect what a build of the pushed commit would, from the fixture
+// repositories, without building anything
+func TestAnalyze(t *testing.T) {
+	noDocker(t)
+	server := newGitServer(t, "127.0.0.1")
+	dockerfile := "FROM alpine:3.19\nEXPOSE 5000\nCMD [\"./app\"]\n"
+	repositories := map[string]map[string]string{
+		"nextjs":     fixtureFiles(t, "frameworks/nextjs"),
+		"django":     fixtureFiles(t, "frameworks/django"),
+		"dockerfile": {"Dockerfile": dockerfile, "app": "#!/bin/sh\n"},
+	}
+	goldens := map[string]string{}
+	for _, name := range []string{"nextjs", "django"} {
+		golden, err := os.ReadFile(filepath.Join("testdata", "frameworks", name+".Dockerfile"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		goldens[name] = string(golden)
+	}
+	testutil.DB(t)
+
+	heads := map[string]string{}
+	urls := map[string]string{}
+	for name, files := range repositories {
+		urls[name], heads[name] = server.repository(t, name, files, nil)
+	}
+	database.DB.Create(&models.Environment{ProjectID: 7, Key: "DJANGO_SECRET_KEY"})
+	database.DB.Create(&models.DockerfileRevision{ProjectID: 8, Content: "FROM python:3.12-slim\nCMD [\"python\", \"manage.py\", \"runserver\"]\n", Force: true})
+
+	tests := []struct {
+		name       string
+		repository string
+		project    models.Project
+		framework  string
+		source     string
+		dockerfile string
+		port       int
+		warnings   []string
+	}{
+		{
+			name: "generated", repository: "nextjs", project: models.Project{},
+			framework: "nextjs", source: DockerfileSourceGenerated, dockerfile: goldens["nextjs"], port: 3000,
+		},
+		{
+			name: "env defined", repository: "django", project: models.Project{ID: 7, Port: 9000},
+			framework: "django", source: DockerfileSourceGenerated, dockerfile: goldens["django"], port: 9000,
+			warnings: []string{"STATIC_ROOT is not set in settings.py, collectstatic will be skipped"},
+		},
+		{
+			name: "override", repository: "django", project: models.Project{ID: 8},
+			source: DockerfileSourceOverride, dockerfile: "FROM python:3.12-slim\nCMD [\"python\", \"manage.py\", \"runserver\"]\n", port: 8080,
+		},
+		{
+			name: "repository", repository: "dockerfile", project: models.Project{ProcessType: models.ProcessWorker},
+			source: DockerfileSourceRepository, dockerfile: dockerfile, port: 0,
+		},
+	}
+	analyzer := NewAnalyzer(nil, time.Minute, 10)
+	if analyzer.detector.dockerClient != nil {
+		t.Fatal("the analyzer has a Docker client")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project := tt.project
+			project.RepoURL = urls[tt.repository]
+			result, err := analyzer.Analyze(context.Background(), &project, "main", heads[tt.repository])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Detection.Framework != tt.framework || result.Source != tt.source || result.Port != tt.port {
+				t.Errorf("detected %q, %s Dockerfile, port %d", result.Detection.Framework, result.Source, result.Port)
+			}
+			if result.Dockerfile != tt.dockerfile {
+				t.Errorf("Dockerfile:\n%s", result.Dockerfile)
+			}
+			if strings.Join(result.Warnings, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("warnings %q, want %q", result.Warnings, tt.warnings)
+			}
+		})
+	}
+
+	// Abbreviated hashes of generic webhooks are analyzed too
+	project := &models.Project{RepoURL: urls["nextjs"]}
+	if _, err := analyzer.Analyze(context.Background(), project, "main", heads["nextjs"][:7]); err != nil {
+		t.Error(err)
+	}
+	// Missing environment variables are warned about
+	project = &models.Project{RepoURL: urls["django"]}
+	if result, err := analyzer.Analyze(context.Background(), project, "main", heads["django"]); err != nil || len(result.Warnings) != 2 || !strings.Contains(result.Warnings[1], "SECRET_KEY") {
+		t.Errorf("%v: warnings %q", err, result.Warnings)
+	}
+}
+
+// What the analysis leaves out is warned about
+func TestAnalyzeWarnings(t *testing.T) {
+	noDocker(t)
+	testutil.DB(t)
+	server := newGitServer(t, "127.0.0.1")
+	libURL, libHead := server.repository(t, "lib", map[string]string{"lib.py": "\n"}, nil)
+	appURL, head := server.repository(t, "app", map[string]string{
+		".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"model.bin":      lfsPointerVersion + "\noid sha256:" + strings.Repeat("0", 64) + "\nsize 16\n",
+		"Dockerfile":     "FROM alpine:3.19\n",
+	}, map[string][2]string{"lib": {libURL, libHead}})
+
+	result, err := NewAnalyzer(nil, time.Minute, 10).Analyze(context.Background(), &models.Project{RepoURL: appURL}, "main", head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"submodules are not checked out for analyses: detection only saw the repository's own files",
+		"Git LFS files are not fetched for analyses: detection only saw their pointers",
+	}
+	if len(result.Warnings) < 2 || result.Warnings[0] != want[0] || result.Warnings[1] != want[1] {
+		t.Errorf("warnings %q", result.Warnings)
+	}
+}
+
+// Analyses stay within their budgets, and a commit the branch moved past is
+// left to the analysis of the newer push
+func TestAnalyzeLimits(t *testing.T) {
+	noDocker(t)
+	testutil.DB(t)
+	server := newGitServer(t, "127.0.0.1")
+	url, head := server.repository(t, "app", map[string]string{"main.py": "print('hi')\n"}, nil)
+	project := &models.Project{RepoURL: url}
+
+	newer := server.commit(t, "app", "app.py", "print('hello')\n")
+	_, err := NewAnalyzer(nil, time.Minute, 10).Analyze(context.Background(), project, "main", head)
+	if !errors.Is(err, ErrCommitMoved) || !strings.Contains(err.Error(), "main is at "+newer[:7]) {
+		t.Errorf("got %v", err)
+	}
+
+	server.commit(t, "app", "data.txt", strings.Repeat("0123456789abcdef", 80<<10))
+	head = runGit(t, filepath.Join(server.root, "app.git"), "rev-parse", "main")
+	_, err = NewAnalyzer(nil, time.Minute, 1).Analyze(context.Background(), project, "main", head)
+	if err == nil || !strings.Contains(err.Error(), "over the analysis budget of 1 MB") {
+		t.Errorf("got %v", err)
+	}
+
+	_, err = NewAnalyzer(nil, time.Nanosecond, 10).Analyze(context.Background(), project, "main", head)
+	if err == nil || err.Error() != "the analysis took longer than its budget of 1ns" {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hooks"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// approvalCheckInterval is how often deployments awaiting approval past
+// their window are cancelled
+const approvalCheckInterval = time.Minute
+
+var (
+	approvalWindow    = 24 * time.Hour
+	maxApprovalWindow = 7 * 24 * time.Hour
+)
+
+// InitApprovals sets how long production deployments wait for approval
+func InitApprovals(cfg *config.Config) {
+	approvalWindow = cfg.ApprovalWindow
+	maxApprovalWindow = cfg.ApprovalMaxWindow
+}
+
+// ValidateApprovalWindow checks a project's approval window, in minutes
+// (0 = APPROVAL_WINDOW)
+func ValidateApprovalWindow(minutes int) error {
+	if minutes < 0 || time.Duration(minutes)*time.Minute > maxApprovalWindow {
+		return fmt.Errorf("approval_window_minutes must be between 1 and %d, or 0 for the platform default", int(maxApprovalWindow.Minutes()))
+	}
+	return nil
+}
+
+// ApprovalWindow is how long the production deployments of project wait for
+// approval before they are cancelled
+func ApprovalWindow(project *models.Project) time.Duration {
+	if project.ApprovalWindowMinutes > 0 {
+		return time.Duration(project.ApprovalWindowMinutes) * time.Minute
+	}
+	return approvalWindow
+}
+
+// Approvers are the users who may approve or reject the deployments of
+// project awaiting approval: its owner, until organizations bring roles
+func Approvers(project *models.Project) ([]models.User, error) {
+	var owner models.User
+	if err := database.DB.Select("id", "username", "email").First(&owner, project.UserID).Error; err != nil {
+		return nil, err
+	}
+	return []models.User{owner}, nil
+}
+
+// CanApprove reports whether userID is one of the Approvers of project
+func CanApprove(project *models.Project, userID uint) bool {
+	approvers, err := Approvers(project)
+	if err != nil {
+		return false
+	}
+	for _, approver := range approvers {
+		if approver.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// needsApproval reports whether deployment waits for an approver before its
+// rollout: a production deployment of a project requiring approval, not
+// approved yet. Previews and branch deployments (staging) never wait.
+func needsApproval(deployment *models.Deployment) bool {
+	return deployment.Project.RequireApproval && deployment.ApprovedAt == nil && ForProduction(deployment)
+}
+
+// awaitApproval parks a built deployment until it is approved, rejected or
+// its window runs out, and notifies its approvers. The job ends here, so the
+// deployment holds no worker nor slot while it waits; approving it queues
+// it again for its rollout, with what detection found (kept with its image).
+func awaitApproval(deployment *models.Deployment, reason string) error {
+	expiresAt := time.Now().Add(ApprovalWindow(&deployment.Project))
+	deployment.ApprovalExpiresAt = &expiresAt
+	if err := database.DB.Model(deployment).Select("approval_expires_at").Updates(deployment).Error; err != nil {
+		return err
+	}
+	reason += ", awaiting approval until " + expiresAt.UTC().Format(time.RFC3339)
+	if err := models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusAwaitingApproval, reason); err != nil {
+		return err
+	}
+
+	names := approverNames(deployment)
+	log.Printf("🔐 Deployment %d awaits approval from %v until %s", deployment.ID, names, expiresAt.Format(time.RFC3339))
+	hooks.AwaitingApproval(deployment.ID, names)
+	return nil
+}
+
+// HoldForPolicy blocks a pushed deployment its project's deploy policy
+// refuses, before it is built, and notifies its approvers. Approving it
+// overrides the policy and queues its build; like deployments awaiting
+// approval, it is cancelled once its window runs out.
+func HoldForPolicy(deployment *models.Deployment, violations []string) error {
+	expiresAt := time.Now().Add(ApprovalWindow(&deployment.Project))
+	deployment.ApprovalExpiresAt = &expiresAt
+	deployment.PolicyViolations = violations
+	if err := database.DB.Model(deployment).Select("approval_expires_at", "policy_violations").Updates(deployment).Error; err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("blocked by the deploy policy: %s, awaiting approval until %s", strings.Join(violations, "; "), expiresAt.UTC().Format(time.RFC3339))
+	if err := models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusPolicyBlocked, reason); err != nil {
+		return err
+	}
+	deployment.Status = models.StatusPolicyBlocked
+
+	names := approverNames(deployment)
+	log.Printf("🛑 Deployment %d blocked by the deploy policy, awaiting an override from %v until %s", deployment.ID, names, expiresAt.Format(time.RFC3339))
+	hooks.PolicyBlocked(deployment.ID, names, violations)
+	return nil
+}
+
+// approverNames are the usernames of the approvers of deployment's project
+func approverNames(deployment *models.Deployment) []string {
+	approvers, err := Approvers(&deployment.Project)
+	if err != nil {
+		log.Printf("⚠️  Deployment %d: failed to look up its approvers: %v", deployment.ID, err)
+	}
+	names := make([]string, 0, len(approvers))
+	for _, approver := range approvers {
+		names = append(names, approver.Username)
+	}
+	return names
+}
+
+// ExpireApprovals cancels the deployments awaiting approval, or an override
+// of their policy block, whose window ran out, returning how many
+func ExpireApprovals() (int, error) {
+	var expired []models.Deployment
+	if err := database.DB.Select("id", "status", "approval_expires_at").
+		Where("status IN ? AND approval_expires_at < ?", []models.DeploymentStatus{models.StatusAwaitingApproval, models.StatusPolicyBlocked}, time.Now()).
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+	cancelled := 0
+	for _, d := range expired {
+		// Approved or rejected in the meantime
+		if err := models.SetDeploymentStatusFrom(database.DB, d.ID, d.Status, models.StatusCancelled, "not approved in time"); err != nil {
+			continue
+		}
+		log.Printf("⌛ Deployment %d cancelled: not approved in time", d.ID)
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// ApprovalExpiryJob cancels deployments not approved in time every minute,
+// on the leader
+func ApprovalExpiryJob() background.Job {
+	return background.Job{
+		Name:      "approval-expiry",
+		Interval:  approvalCheckInterval,
+		Singleton: true,
+		Manual:    true,
+		Run: func(ctx context.Context) error {
+			cancelled, err := ExpireApprovals()
+			background.Processed(ctx, cancelled)
+			return err
+		},
+	}
+}
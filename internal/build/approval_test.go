@@ -0,0 +1,136 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"deploy-platform/internal/throttle"
+	"testing"
+	"time"
+)
+
+func TestNeedsApproval(t *testing.T) {
+	project := models.Project{ID: 7, Branch: "main", RequireApproval: true}
+	approved := time.Now()
+	tests := []struct {
+		name       string
+		deployment models.Deployment
+		want       bool
+	}{
+		{"production branch", models.Deployment{Branch: "main"}, true},
+		{"promoted to production", models.Deployment{Branch: "release", Target: models.TargetProduction}, true},
+		{"staging branch", models.Deployment{Branch: "staging"}, false},
+		{"preview", models.Deployment{Branch: "main", Target: models.TargetPreview}, false},
+		{"approved", models.Deployment{Branch: "main", ApprovedAt: &approved}, false},
+	}
+	for _, tt := range tests {
+		tt.deployment.ProjectID, tt.deployment.Project = project.ID, project
+		if got := needsApproval(&tt.deployment); got != tt.want {
+			t.Errorf("%s: needsApproval = %v", tt.name, got)
+		}
+		tt.deployment.Project.RequireApproval = false
+		if needsApproval(&tt.deployment) {
+			t.Errorf("%s: waits for approval in a project that doesn't require it", tt.name)
+		}
+	}
+}
+
+// A deployment waiting for approval ends its job: its build slot is free
+// for other builds until it is approved, and then it rolls out its image
+// without waiting again
+func TestAwaitApprovalHoldsNoSlot(t *testing.T) {
+	testutil.DB(t)
+	owner := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(owner)
+	project := &models.Project{Name: "app", Slug: "app", UserID: owner.ID, Branch: "main", RequireApproval: true, ApprovalWindowMinutes: 30}
+	database.DB.Create(project)
+	source := &models.Deployment{ProjectID: project.ID, Branch: "main", CommitSHA: "abc1234", Status: models.StatusDeployed, ImageTag: "deploy-1:abc1234"}
+	database.DB.Create(source)
+	database.DB.Create(&models.Build{DeploymentID: source.ID, Status: "success"})
+	// Redeploys roll out the image built earlier, they are the deployments
+	// that wait for approval without a build
+	redeploy := &models.Deployment{ProjectID: project.ID, Branch: "main", CommitSHA: "abc1234", Status: models.StatusQueued, ImageTag: source.ImageTag, DeployOnly: true, RedeployOf: &source.ID}
+	database.DB.Create(redeploy)
+
+	s := &Service{}
+	slots := throttle.New("build", 1)
+	s.SetThrottles(slots, nil, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := s.BuildDeployment(ctx, redeploy.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var parked models.Deployment
+	database.DB.First(&parked, redeploy.ID)
+	if parked.Status != models.StatusAwaitingApproval || parked.ApprovalExpiresAt == nil {
+		t.Fatalf("deployment is %s, expiring at %v", parked.Status, parked.ApprovalExpiresAt)
+	}
+	if window := parked.ApprovalExpiresAt.Sub(started); window < 30*time.Minute || window > 31*time.Minute {
+		t.Errorf("approval window of %s", window)
+	}
+	if !slots.TryAcquire(1) {
+		t.Fatal("the build slot is held while the deployment awaits approval")
+	}
+	slots.Release(1)
+
+	// Approved, as the approve endpoint does
+	approvedAt := time.Now()
+	if err := models.SetDeploymentStatusFrom(database.DB, redeploy.ID, models.StatusAwaitingApproval, models.StatusQueued, "approved by ada"); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Model(redeploy).Updates(map[string]interface{}{"approved_by": "ada", "approved_at": approvedAt})
+	if err := s.BuildDeployment(ctx, redeploy.ID); err != nil {
+		t.Fatal(err)
+	}
+	var rolled models.Deployment
+	database.DB.First(&rolled, redeploy.ID)
+	if rolled.Status != models.StatusDeploying {
+		t.Errorf("approved deployment is %s", rolled.Status)
+	}
+	if !slots.TryAcquire(1) {
+		t.Error("the build slot is held after the rollout")
+	}
+}
+
+// Deployments not decided on in time are cancelled, those decided on or
+// still within their window stay as they are
+func TestExpireApprovals(t *testing.T) {
+	testutil.DB(t)
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	deployments := map[string]*models.Deployment{
+		"expired":        {Status: models.StatusAwaitingApproval, ApprovalExpiresAt: &past},
+		"policy expired": {Status: models.StatusPolicyBlocked, ApprovalExpiresAt: &past},
+		"within window":  {Status: models.StatusAwaitingApproval, ApprovalExpiresAt: &future},
+		"approved":       {Status: models.StatusQueued, ApprovalExpiresAt: &past},
+		"rejected":       {Status: models.StatusRejected, ApprovalExpiresAt: &past},
+	}
+	for _, d := range deployments {
+		d.ProjectID = 7
+		database.DB.Create(d)
+	}
+
+	cancelled, err := ExpireApprovals()
+	if err != nil || cancelled != 2 {
+		t.Fatalf("cancelled %d: %v", cancelled, err)
+	}
+	want := map[string]models.DeploymentStatus{
+		"expired":        models.StatusCancelled,
+		"policy expired": models.StatusCancelled,
+		"within window":  models.StatusAwaitingApproval,
+		"approved":       models.StatusQueued,
+		"rejected":       models.StatusRejected,
+	}
+	for name, d := range deployments {
+		var current models.Deployment
+		database.DB.First(&current, d.ID)
+		if current.Status != want[name] {
+			t.Errorf("%s: %s, want %s", name, current.Status, want[name])
+		}
+	}
+	if cancelled, _ := ExpireApprovals(); cancelled != 0 {
+		t.Errorf("cancelled %d again", cancelled)
+	}
+}
@@ -0,0 +1,202 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/githubscopes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secrets"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// cloneAuth returns the credentials for cloning the project's repository:
+// its deploy key for SSH URLs, its access token for HTTPS, nil for public repos
+func cloneAuth(project *models.Project) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(project.RepoURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository URL: %w", err)
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		if project.DeployKeyPrivate == "" {
+			return nil, fmt.Errorf("repository %s uses SSH but the project has no deploy key: generate one and add it to the Git server", project.RepoURL)
+		}
+		privateKey, err := secrets.Decrypt(project.DeployKeyPrivate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt deploy key: %w", err)
+		}
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+		keys, err := gitssh.NewPublicKeys(user, []byte(privateKey), "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid deploy key: %w", err)
+		}
+		// Self-hosted servers aren't in any known_hosts file; the key only grants
+		// read access to this one repository
+		keys.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return keys, nil
+
+	case "http", "https":
+		if project.CloneToken == "" {
+			if endpoint.Host == "github.com" {
+				return ownerGitHubAuth(project), nil
+			}
+			return nil, nil
+		}
+		token, err := secrets.Decrypt(project.CloneToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt clone token: %w", err)
+		}
+		// Gitea, GitLab and GitHub all accept the token as the password
+		return &githttp.BasicAuth{Username: "git", Password: token}, nil
+	}
+	return nil, nil
+}
+
+// checkoutCommit moves the work tree to sha, which must be reachable from the
+// cloned ref (any branch when ref is empty)
+func checkoutCommit(repo *git.Repository, ref plumbing.ReferenceName, sha string) error {
+	hash, err := repo.ResolveRevision(plumbing.Revision(sha))
+	if err != nil {
+		if ref == "" {
+			return fmt.Errorf("commit %s is not on any branch of the repository", sha)
+		}
+		return fmt.Errorf("commit %s does not exist on %s: push it before triggering the deployment", sha, ref.Short())
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: *hash}); err != nil {
+		return fmt.Errorf("failed to check out commit %s: %w", sha, err)
+	}
+	return nil
+}
+
+// ownerGitHubAuth clones GitHub repositories with the project owner's OAuth
+// token, if they granted the scope for private repositories; nil otherwise
+// (public repositories need no credentials)
+func ownerGitHubAuth(project *models.Project) transport.AuthMethod {
+	var owner models.User
+	if err := database.DB.Select("github_token", "github_scopes").First(&owner, project.UserID).Error; err != nil {
+		return nil
+	}
+	if owner.GitHubToken == "" || githubscopes.Require(githubscopes.Parse(owner.GitHubScopes), githubscopes.FeaturePrivateRepos) != nil {
+		return nil
+	}
+	return &githttp.BasicAuth{Username: "x-access-token", Password: owner.GitHubToken}
+}
+
+// explainCloneError turns an authentication failure on an anonymous clone
+// into instructions for getting access
+func explainCloneError(project *models.Project, auth transport.AuthMethod, err error) error {
+	if auth != nil || (!errors.Is(err, transport.ErrAuthenticationRequired) && !errors.Is(err, transport.ErrRepositoryNotFound)) {
+		return err
+	}
+	if endpoint, epErr := transport.NewEndpoint(project.RepoURL); epErr == nil && endpoint.Host == "github.com" {
+		var owner models.User
+		if database.DB.Select("github_scopes").First(&owner, project.UserID).Error == nil {
+			if scopeErr := githubscopes.Require(githubscopes.Parse(owner.GitHubScopes), githubscopes.FeaturePrivateRepos); scopeErr != nil {
+				return fmt.Errorf("%w: the repository looks private. %s, or set a clone token on the project", err, scopeErr)
+			}
+		}
+	}
+	return fmt.Errorf("%w: the repository looks private, set a clone token or deploy key on the project", err)
+}
+
+// maxSubmoduleDepth is how deeply nested submodules are checked out
+const maxSubmoduleDepth = 5
+
+// updateSubmodules checks out the submodules of repo, cloned from repoURL,
+// and theirs down to maxSubmoduleDepth. Submodules on the same host as
+// repoURL are fetched with auth, the parent's credentials; others
+// anonymously, so credentials never reach another server.
+func updateSubmodules(ctx context.Context, repo *git.Repository, repoURL string, auth transport.AuthMethod, depth int, progress io.Writer) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("failed to read .gitmodules: %w", err)
+	}
+	if len(submodules) > 0 && depth >= maxSubmoduleDepth {
+		fmt.Fprintf(progress, "Submodules nested more than %d levels deep are not checked out\n", maxSubmoduleDepth)
+		return nil
+	}
+
+	for _, sub := range submodules {
+		cfg := sub.Config()
+		url := resolveSubmoduleURL(repoURL, cfg.URL)
+		// go-git only resolves relative URLs against local parents: the
+		// submodule is fetched from the URL resolved here
+		cfg.URL = url
+		var subAuth transport.AuthMethod
+		if sameHost(repoURL, url) {
+			subAuth = auth
+		}
+		fmt.Fprintf(progress, "Checking out submodule %s from %s\n", cfg.Path, url)
+		if err := sub.UpdateContext(ctx, &git.SubmoduleUpdateOptions{Init: true, Auth: subAuth}); err != nil {
+			return explainSubmoduleError(cfg.Path, url, repoURL, subAuth, err)
+		}
+		subRepo, err := sub.Repository()
+		if err != nil {
+			return err
+		}
+		if err := updateSubmodules(ctx, subRepo, url, subAuth, depth+1, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSubmoduleURL resolves a submodule URL relative to its parent
+// repository ("../lib.git"), as Git does
+func resolveSubmoduleURL(parentURL, url string) string {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url
+	}
+	parent, err := transport.NewEndpoint(parentURL)
+	if err != nil {
+		return url
+	}
+	parent.Path = path.Join(parent.Path, url)
+	return parent.String()
+}
+
+// sameHost reports whether two repository URLs are on the same server
+func sameHost(a, b string) bool {
+	ea, errA := transport.NewEndpoint(a)
+	eb, errB := transport.NewEndpoint(b)
+	return errA == nil && errB == nil && ea.Host != "" && strings.EqualFold(ea.Host, eb.Host)
+}
+
+// explainSubmoduleError names the submodule that couldn't be fetched and
+// what would give the platform access to it
+func explainSubmoduleError(subPath, url, parentURL string, auth transport.AuthMethod, err error) error {
+	if !errors.Is(err, transport.ErrAuthenticationRequired) && !errors.Is(err, transport.ErrAuthorizationFailed) && !errors.Is(err, transport.ErrRepositoryNotFound) {
+		return fmt.Errorf("failed to check out submodule %s (%s): %w", subPath, url, err)
+	}
+	if !sameHost(parentURL, url) {
+		return fmt.Errorf("submodule %s (%s) looks private: it is on another host than the repository, so the project's credentials aren't sent to it. Make it public, or move it next to the repository", subPath, url)
+	}
+	if auth == nil {
+		return fmt.Errorf("submodule %s (%s) looks private: set a clone token on the project that can read it", subPath, url)
+	}
+	return fmt.Errorf("submodule %s (%s) can't be read with the project's credentials: give its clone token or deploy key access to it (deploy keys only grant one repository, use a clone token)", subPath, url)
+}
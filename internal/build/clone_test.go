@@ -0,0 +1,239 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secrets"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const cloneToken = "glpat-test"
+
+// gitServer serves the bare repositories under root over smart HTTP with
+// git http-backend, and their Git LFS objects. Repositories in private
+// need the clone token.
+type gitServer struct {
+	*httptest.Server
+	root    string
+	private map[string]bool
+	lfs     map[string][]byte // LFS objects by OID
+}
+
+// newGitServer starts a Git server listening on host, 127.0.0.1 or localhost
+// to tell two servers apart
+func newGitServer(t *testing.T, host string) *gitServer {
+	t.Helper()
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	s := &gitServer{root: t.TempDir(), private: map[string]bool{}, lfs: map[string][]byte{}}
+	backend := &cgi.Handler{Path: git, Args: []string{"http-backend"}, Env: []string{"GIT_PROJECT_ROOT=" + s.root, "GIT_HTTP_EXPORT_ALL=1"}}
+
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), ".git/")
+		if _, password, _ := r.BasicAuth(); s.private[repo] && password != cloneToken {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info/lfs/objects/batch"):
+			s.lfsBatch(w, r)
+		case strings.HasPrefix(r.URL.Path, "/lfs/"):
+			w.Write(s.lfs[strings.TrimPrefix(r.URL.Path, "/lfs/")])
+		default:
+			backend.ServeHTTP(w, r)
+		}
+	}))
+	listener, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", host+":0")
+	if err != nil {
+		t.Skipf("can't listen on %s: %v", host, err)
+	}
+	s.Listener.Close()
+	s.Listener = listener
+	s.Start()
+	s.URL = strings.Replace(s.URL, "127.0.0.1", host, 1)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// lfsBatch answers an LFS batch download request with links to the objects
+func (s *gitServer) lfsBatch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Objects []lfsObject `json:"objects"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+	for i := range request.Objects {
+		object := &request.Objects[i]
+		object.Actions.Download = &struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		}{Href: s.URL + "/lfs/" + object.OID}
+	}
+	w.Header().Set("Content-Type", lfsMediaType)
+	json.NewEncoder(w).Encode(map[string]interface{}{"transfer": "basic", "objects": request.Objects})
+}
+
+// runGit runs git in dir, failing the test if it fails
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Ada", "GIT_AUTHOR_EMAIL=ada@example.com", "GIT_COMMITTER_NAME=Ada", "GIT_COMMITTER_EMAIL=ada@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// repository commits files to a new repository name on the server, with
+// submodules (path to URL and commit), returning its URL and head commit
+func (s *gitServer) repository(t *testing.T, name string, files map[string]string, submodules map[string][2]string) (string, string) {
+	t.Helper()
+	work := t.TempDir()
+	runGit(t, work, "init", "-q", "-b", "main")
+	for path, content := range files {
+		os.MkdirAll(filepath.Join(work, filepath.Dir(path)), 0755)
+		if err := os.WriteFile(filepath.Join(work, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var gitmodules strings.Builder
+	for path, sub := range submodules {
+		fmt.Fprintf(&gitmodules, "[submodule %q]\n\tpath = %s\n\turl = %s\n", path, path, sub[0])
+	}
+	if gitmodules.Len() > 0 {
+		os.WriteFile(filepath.Join(work, ".gitmodules"), []byte(gitmodules.String()), 0644)
+	}
+	runGit(t, work, "add", ".")
+	// Submodules are recorded at their commit, without checking them out
+	for path, sub := range submodules {
+		runGit(t, work, "update-index", "--add", "--cacheinfo", "160000,"+sub[1]+","+path)
+	}
+	runGit(t, work, "commit", "-q", "-m", "Initial commit")
+	runGit(t, s.root, "clone", "-q", "--bare", work, name+".git")
+	return s.URL + "/" + name + ".git", runGit(t, work, "rev-parse", "HEAD")
+}
+
+// clone clones the project's repository as a build does
+func clone(t *testing.T, s *Service, project *models.Project) (string, string, error) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "repo")
+	var progress bytes.Buffer
+	_, err := s.cloneRepo(context.Background(), project, dir, "", "", &progress)
+	return dir, progress.String(), err
+}
+
+// privateProject is a project of the repository at url with a clone token
+func privateProject(t *testing.T, url string) *models.Project {
+	t.Helper()
+	if err := secrets.Init(&config.Config{EncryptionKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := secrets.Encrypt(cloneToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Project{RepoURL: url, CloneToken: token}
+}
+
+func TestCloneSubmodules(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	other := newGitServer(t, "localhost")
+
+	// A public submodule on another host, and a private one next to the
+	// repository, referenced relatively, fetched with the project's token
+	vendorURL, vendorHead := other.repository(t, "vendor", map[string]string{"LICENSE": "MIT\n"}, nil)
+	server.private["lib"], server.private["app"] = true, true
+	_, libHead := server.repository(t, "lib", map[string]string{"lib.go": "package lib\n"}, nil)
+	appURL, _ := server.repository(t, "app", map[string]string{"main.go": "package main\n"}, map[string][2]string{
+		"third_party/vendor": {vendorURL, vendorHead},
+		"lib":                {"../lib.git", libHead},
+	})
+
+	dir, progress, err := clone(t, &Service{}, privateProject(t, appURL))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, progress)
+	}
+	for _, file := range []string{"main.go", "lib/lib.go", "third_party/vendor/LICENSE"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("%s not checked out: %v", file, err)
+		}
+	}
+	if !strings.Contains(progress, "Checking out submodule lib from "+server.URL+"/lib.git") {
+		t.Errorf("progress:\n%s", progress)
+	}
+}
+
+// The project's credentials aren't sent to another host: a private submodule
+// there fails the clone, naming it
+func TestClonePrivateSubmoduleOnOtherHost(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	other := newGitServer(t, "localhost")
+	other.private["internal"] = true
+	internalURL, internalHead := other.repository(t, "internal", map[string]string{"README": "internal\n"}, nil)
+	appURL, _ := server.repository(t, "app", map[string]string{"main.go": "package main\n"}, map[string][2]string{
+		"internal": {internalURL, internalHead},
+	})
+
+	_, _, err := clone(t, &Service{}, privateProject(t, appURL))
+	want := fmt.Sprintf("submodule internal (%s) looks private: it is on another host", internalURL)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+func TestCloneLFS(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	model := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 4096)
+	sum := sha256.Sum256(model)
+	oid := hex.EncodeToString(sum[:])
+	server.lfs[oid] = model
+	server.private["app"] = true
+	appURL, _ := server.repository(t, "app", map[string]string{
+		".gitattributes":  "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"models/tiny.bin": fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, oid, len(model)),
+		"main.py":         "print('hi')\n",
+	}, nil)
+	project := privateProject(t, appURL)
+
+	dir, progress, err := clone(t, &Service{}, project)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, progress)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "models", "tiny.bin")); !bytes.Equal(got, model) {
+		t.Errorf("LFS file has %d bytes, want its %d bytes of content", len(got), len(model))
+	}
+	if !strings.Contains(progress, "Fetching 1 Git LFS files") {
+		t.Errorf("progress:\n%s", progress)
+	}
+
+	// Content not matching its pointer is refused
+	server.lfs[oid] = []byte("tampered")
+	if _, _, err := clone(t, &Service{}, project); err == nil || !strings.Contains(err.Error(), "doesn't match its pointer") {
+		t.Errorf("tampered object: %v", err)
+	}
+
+	disabled := &Service{}
+	disabled.SetGitLFS(false)
+	if _, _, err := clone(t, disabled, project); !errors.Is(err, ErrLFSNotSupported) {
+		t.Errorf("GIT_LFS=false: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package build
+
+import (
+	"deploy-platform/internal/models"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Runtimes build commands can run on
+var Runtimes = []string{"node", "python", "go", "ruby"}
+
+// StaticPort is the port static sites (build commands with an output
+// directory) are served on
+const StaticPort = 80
+
+const maxCommandLength = 1024
+
+var rubyVersionPattern = regexp.MustCompile(`^[\d.]+$`)
+
+// ValidateCommands checks a project's build commands, and that they make
+// sense together and with the project's port
+func ValidateCommands(commands models.BuildCommands, port int) error {
+	if !commands.Set() {
+		return nil
+	}
+	if commands.Runtime != "" && !slices.Contains(Runtimes, commands.Runtime) {
+		return fmt.Errorf("runtime must be one of %s, or empty to detect it", strings.Join(Runtimes, ", "))
+	}
+	for name, command := range map[string]string{
+		"install command": commands.InstallCommand,
+		"build command":   commands.BuildCommand,
+		"start command":   commands.StartCommand,
+	} {
+		if len(command) > maxCommandLength {
+			return fmt.Errorf("the %s must be at most %d characters", name, maxCommandLength)
+		}
+		if strings.IndexFunc(command, unicode.IsControl) >= 0 {
+			return fmt.Errorf("the %s must be a single line: join commands with &&", name)
+		}
+	}
+
+	switch {
+	case commands.OutputDir != "" && commands.StartCommand != "":
+		return fmt.Errorf("set either an output directory (static site) or a start command (app), not both")
+	case commands.OutputDir == "" && commands.StartCommand == "":
+		return fmt.Errorf("set a start command, or an output directory for a static site")
+	case commands.OutputDir != "" && commands.BuildCommand == "":
+		return fmt.Errorf("static sites need a build command producing the output directory")
+	case commands.OutputDir != "" && port != 0 && port != StaticPort:
+		return fmt.Errorf("static sites are served on port %d: set the port to %d or 0", StaticPort, StaticPort)
+	}
+	if commands.OutputDir != "" {
+		dir := path.Clean(commands.OutputDir)
+		if path.IsAbs(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, "../") || strings.IndexFunc(dir, unicode.IsControl) >= 0 || strings.ContainsAny(dir, " \\") {
+			return fmt.Errorf("the output directory must be a path inside the repository, e.g. dist")
+		}
+	}
+	return nil
+}
+
+// detectCommands generates the Dockerfile of a project with build commands,
+// on an image of their runtime or the one detected in dir, in the project's
+// runtimeVersion or the one dir asks for. The commands replace detection
+// entirely: a Dockerfile in the repository is overwritten.
+func (s *Service) detectCommands(dir string, commands *models.BuildCommands, runtimeVersion string) (*Detection, error) {
+	runtime := commands.Runtime
+	if runtime == "" {
+		if runtime = detectRuntime(dir); runtime == "" {
+			return nil, fmt.Errorf("could not detect the runtime of the build commands: set it in the project settings (%s)", strings.Join(Runtimes, ", "))
+		}
+	}
+
+	if !slices.Contains(Runtimes, runtime) {
+		return nil, fmt.Errorf("unknown runtime %q", runtime)
+	}
+	data, selected, warnings := runtimeDockerfileData(dir, "commands", runtime, runtimeVersion)
+	data.Image = selected.image()
+	data.InstallCommand = commands.InstallCommand
+	data.BuildCommand = commands.BuildCommand
+	data.StartCommand = commands.StartCommand
+	if commands.OutputDir != "" {
+		data.OutputDir = path.Clean(commands.OutputDir)
+	}
+
+	detection := &Detection{Type: runtime, Dockerfile: "Dockerfile", Runtime: selected, Warnings: warnings}
+	if data.OutputDir != "" {
+		detection.Port = StaticPort
+	}
+	if fileExists(filepath.Join(dir, "Dockerfile")) {
+		detection.Warnings = append(detection.Warnings, "the repository's Dockerfile is ignored: the project's build commands are used instead")
+	}
+	if err := s.writeDockerfile(dir, "commands", data); err != nil {
+		return nil, err
+	}
+	return detection, nil
+}
+
+// detectRuntime guesses the runtime of build commands from the repository's
+// manifests, "" if there is none
+func detectRuntime(dir string) string {
+	switch {
+	case fileExists(filepath.Join(dir, "package.json")):
+		return "node"
+	case fileExists(filepath.Join(dir, "requirements.txt")), fileExists(filepath.Join(dir, "pyproject.toml")):
+		return "python"
+	case fileExists(filepath.Join(dir, "go.mod")):
+		return "go"
+	case fileExists(filepath.Join(dir, "Gemfile")):
+		return "ruby"
+	}
+	return ""
+}
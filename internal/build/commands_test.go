@@ -0,0 +1,124 @@
+package build
+
+import (
+	"deploy-platform/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Each fixture repository under testdata/commands is detected as something
+// it is not; with the project's build commands it gets the Dockerfile in
+// testdata/commands/<name>.Dockerfile instead
+func TestBuildCommandsReplaceDetection(t *testing.T) {
+	tests := []struct {
+		name     string
+		detected string // What detection alone makes of it
+		commands models.BuildCommands
+		runtime  string
+		port     int
+	}{
+		{
+			// A Flask app with a package.json for its CSS tooling
+			name: "flask-tailwind", detected: "node",
+			commands: models.BuildCommands{
+				Runtime:        "python",
+				InstallCommand: "pip install --no-cache-dir -r requirements.txt",
+				StartCommand:   "gunicorn --bind 0.0.0.0:$PORT app:app",
+			},
+			runtime: "python",
+		},
+		{
+			// A static site: there's nothing to npm start
+			name: "vite-site", detected: "node",
+			commands: models.BuildCommands{InstallCommand: "npm ci", BuildCommand: "npm run build", OutputDir: "./dist/"},
+			runtime:  "node", port: StaticPort,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := filepath.Join("testdata", "commands", tt.name)
+			detectedDir, dir := t.TempDir(), t.TempDir()
+			for _, d := range []string{detectedDir, dir} {
+				if err := os.CopyFS(d, os.DirFS(fixture)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			detected, err := (&Service{}).detectAndCreateDockerfile(detectedDir, nil, "", nil)
+			if err != nil || detected.Type != tt.detected {
+				t.Fatalf("without build commands: detected %+v, %v", detected, err)
+			}
+
+			if err := ValidateCommands(tt.commands, tt.port); err != nil {
+				t.Fatal(err)
+			}
+			detection, err := (&Service{}).detectAndCreateDockerfile(dir, &tt.commands, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Type != tt.runtime || detection.Port != tt.port || detection.Runtime == nil || detection.Runtime.Runtime != tt.runtime {
+				t.Errorf("detected %+v, want %s on port %d", detection, tt.runtime, tt.port)
+			}
+			dockerfile, err := os.ReadFile(filepath.Join(dir, "Dockerfile"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, fixture+".Dockerfile", dockerfile)
+		})
+	}
+}
+
+// The repository's own Dockerfile gives way to build commands, with a warning
+func TestBuildCommandsIgnoreRepositoryDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/api\n\ngo 1.22\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)
+
+	commands := &models.BuildCommands{BuildCommand: "go build -o /bin/api .", StartCommand: "/bin/api"}
+	detection, err := (&Service{}).detectAndCreateDockerfile(dir, commands, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detection.Type != "go" || len(detection.Warnings) == 0 || !strings.Contains(detection.Warnings[len(detection.Warnings)-1], "Dockerfile is ignored") {
+		t.Errorf("detected %+v", detection)
+	}
+	dockerfile, _ := os.ReadFile(filepath.Join(dir, "Dockerfile"))
+	if !strings.HasPrefix(string(dockerfile), "FROM golang:") || !strings.Contains(string(dockerfile), `CMD ["sh", "-c", "/bin/api"]`) {
+		t.Errorf("Dockerfile:\n%s", dockerfile)
+	}
+
+	// Nothing to tell the runtime from
+	if _, err := (&Service{}).detectAndCreateDockerfile(t.TempDir(), commands, "", nil); err == nil || !strings.Contains(err.Error(), "could not detect the runtime") {
+		t.Errorf("no runtime: %v", err)
+	}
+}
+
+func TestValidateCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands models.BuildCommands
+		port     int
+		err      string // "" when valid
+	}{
+		{"none", models.BuildCommands{}, 8080, ""},
+		{"app", models.BuildCommands{InstallCommand: "npm ci", StartCommand: "node server.js"}, 8080, ""},
+		{"static site", models.BuildCommands{BuildCommand: "npm run build", OutputDir: "dist"}, 0, ""},
+		{"static site with a start command", models.BuildCommands{BuildCommand: "npm run build", OutputDir: "dist", StartCommand: "npx serve dist"}, 80, "not both"},
+		{"neither", models.BuildCommands{InstallCommand: "npm ci"}, 8080, "set a start command"},
+		{"static site not built", models.BuildCommands{OutputDir: "dist"}, 80, "need a build command"},
+		{"static site on another port", models.BuildCommands{BuildCommand: "make", OutputDir: "public"}, 3000, "served on port 80"},
+		{"output outside the repository", models.BuildCommands{BuildCommand: "make", OutputDir: "../etc"}, 80, "inside the repository"},
+		{"absolute output", models.BuildCommands{BuildCommand: "make", OutputDir: "/srv/www"}, 80, "inside the repository"},
+		{"unknown runtime", models.BuildCommands{Runtime: "php", StartCommand: "php -S 0.0.0.0:8080"}, 8080, "runtime must be one of"},
+		{"several lines", models.BuildCommands{StartCommand: "npm ci\nnpm start"}, 8080, "single line"},
+		{"too long", models.BuildCommands{StartCommand: strings.Repeat("x", maxCommandLength+1)}, 8080, "at most"},
+	}
+	for _, tt := range tests {
+		err := ValidateCommands(tt.commands, tt.port)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// composeFileNames are checked in the order docker compose itself uses
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image       string        `yaml:"image"`
+	Build       *composeBuild `yaml:"build"`
+	Ports       []interface{} `yaml:"ports"`
+	Environment composeEnv    `yaml:"environment"`
+	EnvFile     stringOrList  `yaml:"env_file"`
+}
+
+// composeBuild accepts both `build: ./dir` and the long form with context/dockerfile
+type composeBuild struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+func (b *composeBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var context string
+	if err := unmarshal(&context); err == nil {
+		b.Context = context
+		return nil
+	}
+	type plain composeBuild
+	return unmarshal((*plain)(b))
+}
+
+// composeEnv accepts both the map and the "KEY=value" list forms
+type composeEnv map[string]string
+
+func (e *composeEnv) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	env := composeEnv{}
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, item := range list {
+			key, value, _ := strings.Cut(item, "=")
+			env[key] = value
+		}
+		*e = env
+		return nil
+	}
+
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	for key, value := range m {
+		if value == nil {
+			env[key] = ""
+		} else {
+			env[key] = fmt.Sprint(value)
+		}
+	}
+	*e = env
+	return nil
+}
+
+type stringOrList []string
+
+func (s *stringOrList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*s = []string{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// ComposeServiceSummary describes one compose service in a rejection message
+type ComposeServiceSummary struct {
+	Name      string `json:"name"`
+	Image     string `json:"image,omitempty"`
+	Buildable bool   `json:"buildable"`
+}
+
+// ComposeError explains why a compose file can't be deployed as-is
+type ComposeError struct {
+	File     string                  `json:"file"`
+	Reason   string                  `json:"reason"`
+	Services []ComposeServiceSummary `json:"services"`
+}
+
+func (e *ComposeError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s\n", e.File, e.Reason)
+	b.WriteString("Services found:\n")
+	for _, svc := range e.Services {
+		switch {
+		case svc.Buildable:
+			fmt.Fprintf(&b, "  - %s (built from source)\n", svc.Name)
+		case svc.Image != "":
+			fmt.Fprintf(&b, "  - %s (image %s)\n", svc.Name, svc.Image)
+		default:
+			fmt.Fprintf(&b, "  - %s\n", svc.Name)
+		}
+	}
+	b.WriteString("Only compose files with exactly one service that has a `build:` section are supported. ")
+	b.WriteString("Databases and caches (postgres, redis, ...) are not started by the platform: ")
+	b.WriteString("provision them separately and pass their URLs as environment variables, ")
+	b.WriteString("or add a Dockerfile at the repository root to skip compose detection.")
+	return b.String()
+}
+
+func findComposeFile(repoPath string) string {
+	for _, name := range composeFileNames {
+		path := filepath.Join(repoPath, name)
+		if fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// detectCompose extracts the single buildable service from a compose file
+func (s *Service) detectCompose(repoPath, composePath, runtimeVersion string) (*Detection, error) {
+	data, err := os.ReadFile(composePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+	detection, err := parseCompose(repoPath, composePath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	contextPath := filepath.Join(repoPath, detection.ContextDir)
+	if !fileExists(filepath.Join(contextPath, detection.Dockerfile)) {
+		// build: points at a directory without a Dockerfile, fall back to language detection there
+		generated, err := s.detectLanguage(contextPath, runtimeVersion)
+		if err != nil {
+			return nil, fmt.Errorf("compose service %q: %w", detection.Service, err)
+		}
+		detection.Dockerfile = generated.Dockerfile
+		detection.Framework = generated.Framework
+		detection.FrameworkVersion = generated.FrameworkVersion
+		detection.Runtime = generated.Runtime
+		detection.Warnings = generated.Warnings
+		detection.requiredEnv = generated.requiredEnv
+		if detection.Port == 0 {
+			detection.Port = generated.Port
+		}
+	}
+
+	return detection, nil
+}
+
+// parseCompose turns compose file contents into a Detection, or a ComposeError
+// when the file describes something the platform can't run
+func parseCompose(repoPath, composePath string, data []byte) (*Detection, error) {
+	fileName := filepath.Base(composePath)
+
+	var file composeFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: invalid compose file: %w", fileName, err)
+	}
+	if len(file.Services) == 0 {
+		return nil, &ComposeError{File: fileName, Reason: "no services defined"}
+	}
+
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var summaries []ComposeServiceSummary
+	var buildable []string
+	for _, name := range names {
+		svc := file.Services[name]
+		summaries = append(summaries, ComposeServiceSummary{Name: name, Image: svc.Image, Buildable: svc.Build != nil})
+		if svc.Build != nil {
+			buildable = append(buildable, name)
+		}
+	}
+
+	switch {
+	case len(buildable) == 0:
+		return nil, &ComposeError{File: fileName, Reason: "no service has a build section, nothing to build from this repository", Services: summaries}
+	case len(buildable) > 1:
+		return nil, &ComposeError{File: fileName, Reason: fmt.Sprintf("%d services are built from source, only one is supported", len(buildable)), Services: summaries}
+	case len(file.Services) > 1:
+		return nil, &ComposeError{File: fileName, Reason: "the app depends on additional services the platform does not run", Services: summaries}
+	}
+
+	name := buildable[0]
+	svc := file.Services[name]
+	composeDir := filepath.Dir(composePath)
+
+	contextDir, err := relativeToRepo(repoPath, filepath.Join(composeDir, svc.Build.Context))
+	if err != nil {
+		return nil, fmt.Errorf("compose service %q: %w", name, err)
+	}
+
+	dockerfile := svc.Build.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if _, err := relativeToRepo(repoPath, filepath.Join(repoPath, contextDir, dockerfile)); err != nil {
+		return nil, fmt.Errorf("compose service %q: %w", name, err)
+	}
+
+	// env_file entries first, explicit environment wins
+	env := map[string]string{}
+	for _, envFile := range svc.EnvFile {
+		path := filepath.Join(composeDir, envFile)
+		if _, err := relativeToRepo(repoPath, path); err != nil {
+			return nil, fmt.Errorf("compose service %q: %w", name, err)
+		}
+		values, err := readEnvFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("compose service %q: failed to read env_file %s: %w", name, envFile, err)
+		}
+		for k, v := range values {
+			env[k] = v
+		}
+	}
+	for k, v := range svc.Environment {
+		env[k] = v
+	}
+
+	detection := &Detection{
+		Type:       "compose",
+		Service:    name,
+		ContextDir: contextDir,
+		Dockerfile: dockerfile,
+		Port:       firstContainerPort(svc.Ports),
+	}
+	if len(env) > 0 {
+		detection.Env = env
+	}
+	return detection, nil
+}
+
+// relativeToRepo returns path relative to repoPath, refusing paths that escape the repository
+func relativeToRepo(repoPath, path string) (string, error) {
+	rel, err := filepath.Rel(repoPath, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside the repository", path)
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return rel, nil
+}
+
+// firstContainerPort returns the container side of the first port mapping,
+// accepting "3000", "8080:3000", "127.0.0.1:8080:3000/tcp", 3000 and {target: 3000}
+func firstContainerPort(ports []interface{}) int {
+	for _, p := range ports {
+		var raw string
+		switch v := p.(type) {
+		case int:
+			return v
+		case string:
+			raw = v
+		case map[interface{}]interface{}:
+			raw = fmt.Sprint(v["target"])
+		default:
+			continue
+		}
+
+		raw, _, _ = strings.Cut(raw, "/")
+		if i := strings.LastIndex(raw, ":"); i >= 0 {
+			raw = raw[i+1:]
+		}
+		raw, _, _ = strings.Cut(raw, "-") // ranges: take the first port
+		if port, err := strconv.Atoi(raw); err == nil && port > 0 && port < 65536 {
+			return port
+		}
+	}
+	return 0
+}
+
+// readEnvFile parses a dotenv-style file (KEY=value lines, # comments)
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[strings.TrimSpace(key)] = value
+	}
+	return env, scanner.Err()
+}
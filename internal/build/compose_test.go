@@ -0,0 +1,138 @@
+package build
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// composeFixture copies the repository testdata/compose/<name> to a
+// temporary directory and returns it with its compose file
+func composeFixture(t *testing.T, name string) (string, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "repo")
+	if err := os.CopyFS(dir, os.DirFS(filepath.Join("testdata", "compose", name))); err != nil {
+		t.Fatal(err)
+	}
+	composePath := findComposeFile(dir)
+	if composePath == "" {
+		t.Fatalf("no compose file in %s", name)
+	}
+	return dir, composePath
+}
+
+func TestComposeOneService(t *testing.T) {
+	dir, composePath := composeFixture(t, "one-service")
+	detection, err := (&Service{}).detectCompose(dir, composePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Detection{
+		Type:       "compose",
+		Service:    "web",
+		ContextDir: "app",
+		Dockerfile: "Dockerfile.prod",
+		Port:       3000,
+		Env:        map[string]string{"NODE_ENV": "production", "WORKERS": "4"},
+	}
+	if !reflect.DeepEqual(detection, want) {
+		t.Errorf("detected %+v, want %+v", detection, want)
+	}
+}
+
+// env_file entries are read in order, the service's environment winning
+func TestComposeEnvFile(t *testing.T) {
+	dir, composePath := composeFixture(t, "env-file")
+	detection, err := (&Service{}).detectCompose(dir, composePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detection.Service != "api" || detection.ContextDir != "" || detection.Dockerfile != "Dockerfile" || detection.Port != 8000 {
+		t.Errorf("detected %+v", detection)
+	}
+	want := map[string]string{"LOG_LEVEL": "debug", "SECRET_KEY": "from-env-file", "DEBUG": "true"}
+	if !reflect.DeepEqual(detection.Env, want) {
+		t.Errorf("env %v, want %v", detection.Env, want)
+	}
+
+	os.Remove(filepath.Join(dir, ".env.local"))
+	if _, err := (&Service{}).detectCompose(dir, composePath, ""); err == nil || !strings.Contains(err.Error(), ".env.local") {
+		t.Errorf("missing env_file: got %v", err)
+	}
+}
+
+// A service depending on a database and a cache is refused, listing them
+func TestComposeMultiService(t *testing.T) {
+	dir, composePath := composeFixture(t, "multi-service")
+	_, err := (&Service{}).detectCompose(dir, composePath, "")
+	var composeErr *ComposeError
+	if !errors.As(err, &composeErr) {
+		t.Fatalf("got %v", err)
+	}
+	want := []ComposeServiceSummary{
+		{Name: "cache", Image: "redis:7"},
+		{Name: "db", Image: "postgres:16"},
+		{Name: "web", Buildable: true},
+	}
+	if composeErr.File != "compose.yaml" || !reflect.DeepEqual(composeErr.Services, want) {
+		t.Errorf("got %+v", composeErr)
+	}
+	for _, line := range []string{"  - db (image postgres:16)", "  - web (built from source)", "provision them separately"} {
+		if !strings.Contains(err.Error(), line) {
+			t.Errorf("message lacks %q:\n%s", line, err)
+		}
+	}
+}
+
+func TestParseCompose(t *testing.T) {
+	repo := t.TempDir()
+	composePath := filepath.Join(repo, "docker-compose.yml")
+	tests := []struct {
+		name, yaml, reason string
+	}{
+		{"no services", "services: {}\n", "no services defined"},
+		{"images only", "services:\n  db:\n    image: postgres\n", "no service has a build section"},
+		{"two built", "services:\n  web:\n    build: ./web\n  worker:\n    build: ./worker\n", "2 services are built from source"},
+	}
+	for _, tt := range tests {
+		_, err := parseCompose(repo, composePath, []byte(tt.yaml))
+		var composeErr *ComposeError
+		if !errors.As(err, &composeErr) || !strings.HasPrefix(composeErr.Reason, tt.reason) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+
+	for name, yaml := range map[string]string{
+		"invalid":          "services: [",
+		"context outside":  "services:\n  web:\n    build: ../..\n",
+		"env_file outside": "services:\n  web:\n    build: .\n    env_file: ../secrets.env\n",
+	} {
+		if _, err := parseCompose(repo, composePath, []byte(yaml)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestFirstContainerPort(t *testing.T) {
+	tests := []struct {
+		ports []interface{}
+		want  int
+	}{
+		{[]interface{}{"3000"}, 3000},
+		{[]interface{}{"8080:3000"}, 3000},
+		{[]interface{}{"127.0.0.1:8080:3000/tcp"}, 3000},
+		{[]interface{}{"9000-9002:9000-9002"}, 9000},
+		{[]interface{}{5000}, 5000},
+		{[]interface{}{map[interface{}]interface{}{"target": 8000, "published": 80}}, 8000},
+		{[]interface{}{"not-a-port", "4000"}, 4000},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := firstContainerPort(tt.ports); got != tt.want {
+			t.Errorf("firstContainerPort(%v) = %d, want %d", tt.ports, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/metrics"
+	"deploy-platform/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Phases a deployment fails in: building its image, or rolling it out
+const (
+	PhaseBuild  = "build"
+	PhaseDeploy = "deploy"
+)
+
+// DeployError is the failure of a deployment's rollout, once its image was
+// built
+type DeployError struct {
+	Err error
+}
+
+func (e *DeployError) Error() string { return e.Err.Error() }
+func (e *DeployError) Unwrap() error { return e.Err }
+
+// DeployDeferredError is returned for a rollout the cluster API was
+// unavailable for: the deployment is deploy_pending, its rollout retried
+// with the same image at RetryAt, without cloning or building again
+type DeployDeferredError struct {
+	DeploymentID uint
+	Attempt      int // Rollouts that failed so far
+	RetryAt      time.Time
+	Err          error
+}
+
+func (e *DeployDeferredError) Error() string {
+	return fmt.Sprintf("rollout %d of deployment %d deferred to %s: %v", e.Attempt, e.DeploymentID, e.RetryAt.UTC().Format(time.RFC3339), e.Err)
+}
+
+func (e *DeployDeferredError) Unwrap() error { return e.Err }
+
+// deployRetry is how rollouts failing on transient cluster API errors are
+// retried
+type deployRetry struct {
+	maxAttempts int           // Rollouts before the deployment fails, 0 = not retried
+	backoff     time.Duration // Wait before the first retry, doubled after each attempt
+	maxBackoff  time.Duration // Longest wait between attempts
+}
+
+// SetDeployRetry retries rollouts failing on transient cluster API errors,
+// up to maxAttempts rollouts in all, waiting backoff doubled after each
+// attempt up to maxBackoff; maxAttempts <= 1 fails them right away
+func (s *Service) SetDeployRetry(maxAttempts int, backoff, maxBackoff time.Duration) {
+	s.deployRetry = deployRetry{maxAttempts: maxAttempts, backoff: backoff, maxBackoff: maxBackoff}
+}
+
+// wait is the wait after attempts failed rollouts
+func (r deployRetry) wait(attempts int) time.Duration {
+	wait := r.backoff
+	for i := 1; i < attempts && wait < r.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > r.maxBackoff {
+		wait = r.maxBackoff
+	}
+	return wait
+}
+
+// deferRollout parks a deployment whose rollout failed on a transient
+// cluster API error in deploy_pending, its image and detection already kept,
+// and returns the DeployDeferredError its worker schedules the retry from.
+// Once its attempts are used up, it fails as cluster_unavailable instead;
+// its image is kept for a deploy-only redeploy.
+func (s *Service) deferRollout(deployment *models.Deployment, build *models.Build, err error) error {
+	deployment.DeployAttempts++
+	if deployment.DeployAttempts >= s.deployRetry.maxAttempts {
+		deployment.FailureCategory = models.FailureClusterUnavailable
+		deployment.FailureDetail = fmt.Sprintf("The cluster API was unavailable for %d attempts of the rollout. Image %s is kept: redeploy this deployment with deploy_only once the cluster is back, nothing is rebuilt.", deployment.DeployAttempts, deployment.ImageTag)
+		database.DB.Model(deployment).Select("deploy_attempts", "failure_category", "failure_detail").Updates(deployment)
+		countDeferral("exhausted")
+		return &DeployError{Err: fmt.Errorf("cluster API unavailable after %d attempts: %w", deployment.DeployAttempts, err)}
+	}
+
+	retryAt := time.Now().Add(s.deployRetry.wait(deployment.DeployAttempts))
+	deployment.DeployOnly = true
+	deployment.DeployRetryAt = &retryAt
+	if err := database.DB.Model(deployment).Select("deploy_only", "deploy_attempts", "deploy_retry_at").Updates(deployment).Error; err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("cluster API unavailable (%v), rollout %d of %d retried at %s", err, deployment.DeployAttempts+1, s.deployRetry.maxAttempts, retryAt.UTC().Format(time.RFC3339))
+	if err := models.SetDeploymentStatusFrom(database.DB, deployment.ID, models.StatusDeploying, models.StatusDeployPending, reason); err != nil {
+		return err
+	}
+	deployment.Status = models.StatusDeployPending
+	finishSteps(build)
+	countDeferral("deferred")
+
+	log.Printf("⏳ Deployment %d: %s", deployment.ID, reason)
+	return &DeployDeferredError{DeploymentID: deployment.ID, Attempt: deployment.DeployAttempts, RetryAt: retryAt, Err: err}
+}
+
+// deferrable reports whether the failed rollout of deployment is retried
+// later rather than failing it
+func (s *Service) deferrable(ctx context.Context, err error) bool {
+	return s.deployRetry.maxAttempts > 1 && kubernetes.IsTransient(ctx, err)
+}
+
+// rolloutBuilt rolls out a deployment whose image was built earlier:
+// approved, retried after the cluster API was unavailable, or redeployed
+// deploy-only
+func (s *Service) rolloutBuilt(ctx context.Context, deployment *models.Deployment) error {
+	var reason string
+	switch {
+	case deployment.Status == models.StatusDeployPending:
+		reason = fmt.Sprintf("retrying the rollout of %s, attempt %d", deployment.ImageTag, deployment.DeployAttempts+1)
+	case deployment.ApprovedAt != nil:
+		reason = fmt.Sprintf("approved by %s, rolling out %s", deployment.ApprovedBy, deployment.ImageTag)
+	case deployment.RedeployOf != nil:
+		reason = fmt.Sprintf("redeploying %s of deployment %d, nothing rebuilt", deployment.ImageTag, *deployment.RedeployOf)
+	default:
+		reason = "rolling out " + deployment.ImageTag + ", built earlier"
+	}
+	// Redeploys to production wait for approval like builds do
+	if deployment.RedeployOf != nil && needsApproval(deployment) {
+		return awaitApproval(deployment, reason)
+	}
+	if err := models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusDeploying, reason); err != nil {
+		return err
+	}
+	deployment.Status = models.StatusDeploying
+	build, err := deployBuild(deployment)
+	if err != nil {
+		return err
+	}
+	detection := &Detection{Port: deployment.DetectedPort, Env: deployment.DetectedEnv}
+	return s.rollout(ctx, deployment, build, detection)
+}
+
+// deployBuild is the build deployment rolls out. A deploy-only redeploy gets
+// one of its own, a copy of the build of the deployment it redeploys.
+func deployBuild(deployment *models.Deployment) (*models.Build, error) {
+	var build models.Build
+	err := database.DB.Where("deployment_id = ?", deployment.ID).Order("id DESC").First(&build).Error
+	if err == nil || deployment.RedeployOf == nil {
+		return &build, err
+	}
+
+	var source models.Build
+	if err := database.DB.Where("deployment_id = ?", *deployment.RedeployOf).Order("id DESC").First(&source).Error; err != nil {
+		return nil, fmt.Errorf("no build of deployment %d to redeploy: %w", *deployment.RedeployOf, err)
+	}
+	now := time.Now()
+	build = models.Build{
+		DeploymentID:         deployment.ID,
+		Status:               "success",
+		Logs:                 fmt.Sprintf("Deploy-only redeploy of deployment %d: image %s reused, nothing was built", *deployment.RedeployOf, deployment.ImageTag),
+		StartedAt:            &now,
+		CompletedAt:          &now,
+		Framework:            source.Framework,
+		FrameworkVersion:     source.FrameworkVersion,
+		Runtime:              source.Runtime,
+		RuntimeVersion:       source.RuntimeVersion,
+		RuntimeSource:        source.RuntimeSource,
+		ImageDigest:          source.ImageDigest,
+		ImageSizeBytes:       source.ImageSizeBytes,
+		ImageLayers:          source.ImageLayers,
+		Dockerfile:           source.Dockerfile,
+		DockerfileRevisionID: source.DockerfileRevisionID,
+		DockerfilePath:       source.DockerfilePath,
+	}
+	if err := database.DB.Create(&build).Error; err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// ImageExists reports whether the image deployments were built as, e.g.
+// deploy-12:abc1234, is still on the build host for a deploy-only redeploy
+func (s *Service) ImageExists(ctx context.Context, imageTag string) (bool, error) {
+	if imageTag == "" {
+		return false, nil
+	}
+	return s.dockerClient.ImageExists(ctx, imageTag)
+}
+
+var phaseStats = struct {
+	mu        sync.Mutex
+	failures  map[string]int64 // By phase
+	deferrals map[string]int64 // By outcome: deferred, exhausted
+}{failures: map[string]int64{}, deferrals: map[string]int64{}}
+
+// FailurePhase is the phase a deployment failing with err failed in
+func FailurePhase(err error) string {
+	var deployErr *DeployError
+	if errors.As(err, &deployErr) {
+		return PhaseDeploy
+	}
+	return PhaseBuild
+}
+
+// CountFailure counts a deployment failed with err in its phase's failures
+func CountFailure(err error) {
+	phaseStats.mu.Lock()
+	phaseStats.failures[FailurePhase(err)]++
+	phaseStats.mu.Unlock()
+}
+
+func countDeferral(outcome string) {
+	phaseStats.mu.Lock()
+	phaseStats.deferrals[outcome]++
+	phaseStats.mu.Unlock()
+}
+
+// RegisterMetrics exposes failed deployments by phase, deferred rollouts
+// and the deployments waiting for the retry of theirs
+func RegisterMetrics() {
+	counter := func(counts map[string]int64, label string) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			phaseStats.mu.Lock()
+			defer phaseStats.mu.Unlock()
+			samples := make([]metrics.Sample, 0, len(counts))
+			for key, count := range counts {
+				samples = append(samples, metrics.Sample{Labels: map[string]string{label: key}, Value: float64(count)})
+			}
+			return samples
+		}
+	}
+	metrics.RegisterGauge("deploy_deployment_failures_total", "Deployments failed since start, by phase: building the image or rolling it out", counter(phaseStats.failures, "phase"))
+	metrics.RegisterGauge("deploy_rollout_deferrals_total", "Rollouts that failed on a transient cluster API error since start, by outcome: deferred for a retry or out of attempts", counter(phaseStats.deferrals, "outcome"))
+	metrics.RegisterGauge("deploy_deployments_deploy_pending", "Built deployments waiting to retry their rollout", func() []metrics.Sample {
+		var pending int64
+		if err := database.DB.Model(&models.Deployment{}).Where("status = ?", models.StatusDeployPending).Count(&pending).Error; err != nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(pending)}}
+	})
+}
@@ -0,0 +1,171 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"deploy-platform/internal/throttle"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// errRefused is the cluster API refusing connections, e.g. during an upgrade
+var errRefused = fmt.Errorf("failed to apply deployment: %w", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)})
+
+// builtDeployment creates a deployment of a project whose repository can't
+// be cloned, rolling out its built image
+func builtDeployment(t *testing.T, attempts int) (*models.Deployment, *models.Build) {
+	t.Helper()
+	project := &models.Project{Name: "app", Slug: "app", Branch: "main", RepoURL: "https://git.invalid/acme/app.git"}
+	database.DB.Create(project)
+	deployment := &models.Deployment{
+		ProjectID: project.ID, Project: *project, Branch: "main", CommitSHA: strings.Repeat("a", 40),
+		Status: models.StatusDeploying, ImageTag: fmt.Sprintf("deploy-%d:aaaaaaa", project.ID), DeployAttempts: attempts,
+	}
+	if err := database.DB.Create(deployment).Error; err != nil {
+		t.Fatal(err)
+	}
+	build := &models.Build{DeploymentID: deployment.ID, Status: "success", ImageDigest: "sha256:" + strings.Repeat("1", 64)}
+	database.DB.Create(build)
+	return deployment, build
+}
+
+func TestDeployRetryWait(t *testing.T) {
+	retry := deployRetry{maxAttempts: 10, backoff: 10 * time.Second, maxBackoff: time.Minute}
+	for attempts, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: time.Minute, 9: time.Minute} {
+		if got := retry.wait(attempts); got != want {
+			t.Errorf("after %d attempts: wait %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// Only transient cluster API errors are retried, and only when retries are
+// on
+func TestDeferrable(t *testing.T) {
+	s := &Service{}
+	s.SetDeployRetry(3, time.Second, time.Minute)
+	ctx := context.Background()
+	if !s.deferrable(ctx, errRefused) {
+		t.Error("connection refused not retried")
+	}
+	if s.deferrable(ctx, errors.New("admission webhook denied the request")) {
+		t.Error("permanent error retried")
+	}
+	s.SetDeployRetry(1, time.Second, time.Minute)
+	if s.deferrable(ctx, errRefused) {
+		t.Error("retried with DEPLOY_RETRY_ATTEMPTS=1")
+	}
+}
+
+// A rollout the cluster API was unavailable for leaves the deployment
+// deploy_pending; its retry rolls the built image out again without cloning
+// or building anything
+func TestDeferredRolloutRetriedWithoutRebuild(t *testing.T) {
+	noDocker(t)
+	testutil.DB(t)
+	s := &Service{}
+	s.SetDeployRetry(3, time.Minute, 5*time.Minute)
+	s.SetThrottles(throttle.New("build", 1), nil, 0)
+	deployment, build := builtDeployment(t, 0)
+	deferrals := phaseStats.deferrals["deferred"]
+
+	started := time.Now()
+	err := s.deferRollout(deployment, build, errRefused)
+	var deferred *DeployDeferredError
+	if !errors.As(err, &deferred) || deferred.DeploymentID != deployment.ID || deferred.Attempt != 1 || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("got %v", err)
+	}
+	if wait := deferred.RetryAt.Sub(started); wait < time.Minute || wait > time.Minute+5*time.Second {
+		t.Errorf("retried in %s", wait)
+	}
+	var pending models.Deployment
+	database.DB.First(&pending, deployment.ID)
+	if pending.Status != models.StatusDeployPending || !pending.DeployOnly || pending.DeployAttempts != 1 || pending.DeployRetryAt == nil {
+		t.Fatalf("deployment %+v", pending)
+	}
+	if phaseStats.deferrals["deferred"] != deferrals+1 {
+		t.Error("deferral not counted")
+	}
+
+	// The retry: the repository can't be cloned and the Docker daemon fails
+	// the test if called, so only the rollout runs
+	if err := s.BuildDeployment(context.Background(), deployment.ID); err != nil {
+		t.Fatal(err)
+	}
+	var retried models.Deployment
+	database.DB.First(&retried, deployment.ID)
+	var event models.DeploymentEvent
+	database.DB.Where("deployment_id = ?", deployment.ID).Last(&event)
+	if retried.Status != models.StatusDeploying || event.Reason != fmt.Sprintf("retrying the rollout of %s, attempt 2", deployment.ImageTag) {
+		t.Errorf("deployment is %s: %q", retried.Status, event.Reason)
+	}
+	var builds int64
+	database.DB.Model(&models.Build{}).Where("deployment_id = ?", deployment.ID).Count(&builds)
+	if builds != 1 {
+		t.Errorf("%d builds", builds)
+	}
+}
+
+// Once its attempts are used up the deployment fails in the deploy phase,
+// its image kept for a deploy-only redeploy
+func TestDeferredRolloutExhausted(t *testing.T) {
+	testutil.DB(t)
+	s := &Service{}
+	s.SetDeployRetry(3, time.Minute, 5*time.Minute)
+	deployment, build := builtDeployment(t, 2)
+	failures := phaseStats.failures[PhaseDeploy]
+
+	err := s.deferRollout(deployment, build, errRefused)
+	var deployErr *DeployError
+	if !errors.As(err, &deployErr) || FailurePhase(err) != PhaseDeploy || !strings.Contains(err.Error(), "cluster API unavailable after 3 attempts") {
+		t.Fatalf("got %v", err)
+	}
+	var failed models.Deployment
+	database.DB.First(&failed, deployment.ID)
+	if failed.FailureCategory != models.FailureClusterUnavailable || failed.DeployAttempts != 3 ||
+		!strings.Contains(failed.FailureDetail, "Image "+deployment.ImageTag+" is kept: redeploy this deployment with deploy_only") {
+		t.Errorf("deployment %+v", failed)
+	}
+
+	// Workers count failures by phase
+	CountFailure(err)
+	CountFailure(errors.New("docker build failed"))
+	if phaseStats.failures[PhaseDeploy] != failures+1 || FailurePhase(errors.New("docker build failed")) != PhaseBuild {
+		t.Errorf("failures %v", phaseStats.failures)
+	}
+}
+
+// Deploy-only redeploys roll out the image of the deployment they redeploy,
+// with a copy of its build and nothing built
+func TestDeployOnlyRedeploy(t *testing.T) {
+	noDocker(t)
+	testutil.DB(t)
+	source, sourceBuild := builtDeployment(t, 3)
+	database.DB.Model(source).Update("status", models.StatusFailed)
+	redeploy := &models.Deployment{
+		ProjectID: source.ProjectID, Branch: "main", CommitSHA: source.CommitSHA, Status: models.StatusQueued,
+		ImageTag: source.ImageTag, DeployOnly: true, RedeployOf: &source.ID,
+	}
+	database.DB.Create(redeploy)
+
+	s := &Service{}
+	s.SetThrottles(throttle.New("build", 1), nil, 0)
+	if err := s.BuildDeployment(context.Background(), redeploy.ID); err != nil {
+		t.Fatal(err)
+	}
+	var rolled models.Deployment
+	database.DB.First(&rolled, redeploy.ID)
+	var copied models.Build
+	database.DB.Where("deployment_id = ?", redeploy.ID).First(&copied)
+	if rolled.Status != models.StatusDeploying || copied.ImageDigest != sourceBuild.ImageDigest || copied.Status != "success" ||
+		copied.Logs != fmt.Sprintf("Deploy-only redeploy of deployment %d: image %s reused, nothing was built", source.ID, source.ImageTag) {
+		t.Errorf("deployment is %s, build %+v", rolled.Status, copied)
+	}
+}
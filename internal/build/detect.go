@@ -0,0 +1,126 @@
+package build
+
+import (
+	"deploy-platform/internal/models"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Detection describes how a repository will be built and run
+type Detection struct {
+	Type       string            `json:"type"`                  // dockerfile, override, compose, node, python, go
+	Dockerfile string            `json:"dockerfile"`            // Dockerfile path, relative to ContextDir
+	ContextDir string            `json:"context_dir,omitempty"` // Build context, relative to the repo root ("" = root)
+	Port       int               `json:"port,omitempty"`        // Port the app listens on (0 = platform default)
+	Env        map[string]string `json:"env,omitempty"`         // Env vars picked up during detection
+	Service    string            `json:"service,omitempty"`     // Compose service that was extracted
+
+	Framework        string            `json:"framework,omitempty"`         // nextjs, django, rails
+	FrameworkVersion string            `json:"framework_version,omitempty"` // As pinned by the app, if found
+	Runtime          *RuntimeSelection `json:"runtime,omitempty"`           // Version of the language a generated Dockerfile builds on
+	Warnings         []string          `json:"warnings,omitempty"`          // Hints about settings the app is likely missing
+
+	requiredEnv [][]string // Env vars the framework needs at runtime (one of each group)
+}
+
+// detectAndCreateDockerfile picks the Dockerfile a repository is built
+// with: the project's override, the repository's, or one generated from the
+// project's build commands, a compose file or the detected language. The
+// override only replaces the repository's Dockerfile when forced, or when
+// build commands already do. Generated Dockerfiles build on the project's
+// runtimeVersion of their language, "" = the one the repository asks for.
+func (s *Service) detectAndCreateDockerfile(repoPath string, commands *models.BuildCommands, runtimeVersion string, override *models.DockerfileRevision) (*Detection, error) {
+	repoDockerfile := fileExists(filepath.Join(repoPath, "Dockerfile"))
+	var warnings []string
+	if override != nil {
+		if !repoDockerfile || override.Force || commands != nil {
+			if err := os.WriteFile(filepath.Join(repoPath, "Dockerfile"), []byte(override.Content), 0644); err != nil {
+				return nil, err
+			}
+			return &Detection{Type: "override", Dockerfile: "Dockerfile", Port: exposedPort(override.Content)}, nil
+		}
+		warnings = append(warnings, "the project's Dockerfile override is ignored: the repository has a Dockerfile (save the override with force to use it anyway)")
+	}
+
+	// The project's build commands replace detection
+	if commands != nil {
+		return s.detectCommands(repoPath, commands, runtimeVersion)
+	}
+
+	// Check if Dockerfile exists
+	if repoDockerfile {
+		return &Detection{Type: "dockerfile", Dockerfile: "Dockerfile", Warnings: warnings}, nil
+	}
+
+	// docker-compose.yml with a single buildable service
+	if composePath := findComposeFile(repoPath); composePath != "" {
+		return s.detectCompose(repoPath, composePath, runtimeVersion)
+	}
+
+	return s.detectLanguage(repoPath, runtimeVersion)
+}
+
+// detectLanguage auto-generates a Dockerfile based on the detected language
+func (s *Service) detectLanguage(dir, runtimeVersion string) (*Detection, error) {
+	// Frameworks get tuned Dockerfiles, layered on top of their language
+	if fw := detectFramework(dir, runtimeVersion); fw != nil {
+		if err := s.writeDockerfile(dir, fw.Template, fw.Data); err != nil {
+			return nil, err
+		}
+		return &Detection{
+			Type:             fw.Language,
+			Dockerfile:       "Dockerfile",
+			Port:             fw.Port,
+			Framework:        fw.Name,
+			FrameworkVersion: fw.Version,
+			Runtime:          fw.Runtime,
+			Warnings:         fw.Warnings,
+			requiredEnv:      fw.RequiredEnv,
+		}, nil
+	}
+
+	// This is simplified - you can expand this
+	var lang string
+	switch {
+	case fileExists(filepath.Join(dir, "package.json")):
+		lang = "node"
+	case fileExists(filepath.Join(dir, "requirements.txt")):
+		lang = "python"
+	case fileExists(filepath.Join(dir, "go.mod")):
+		lang = "go"
+	default:
+		return nil, fmt.Errorf("could not detect project type: add a Dockerfile, or one of package.json, requirements.txt or go.mod")
+	}
+	data, runtime, warnings := runtimeDockerfileData(dir, lang, lang, runtimeVersion)
+	if err := s.writeDockerfile(dir, lang, data); err != nil {
+		return nil, err
+	}
+
+	return &Detection{Type: lang, Dockerfile: "Dockerfile", Runtime: runtime, Warnings: warnings}, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// missingEnvWarnings lists the framework's required env vars that the project
+// doesn't define
+func (d *Detection) missingEnvWarnings(defined map[string]bool) []string {
+	var warnings []string
+	for _, alternatives := range d.requiredEnv {
+		found := false
+		for _, key := range alternatives {
+			if defined[key] || d.Env[key] != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("%s apps need %s: add it to the project's environment variables", d.Framework, strings.Join(alternatives, " or ")))
+		}
+	}
+	return warnings
+}
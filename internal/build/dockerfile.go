@@ -0,0 +1,191 @@
+package build
+
+import (
+	"bytes"
+	"deploy-platform/internal/config"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// defaultTemplates are the generated Dockerfiles shipped with the platform;
+// files with the same name in DOCKERFILE_TEMPLATE_DIR replace them
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Detectors lists the templates a Dockerfile can be generated from
+var Detectors = []string{"node", "python", "go", "nextjs", "nextjs-standalone", "django", "rails", "commands"}
+
+// PlatformVars are instance-wide settings every template can use
+type PlatformVars struct {
+	Registry string            `json:"registry"` // Base image registry prefix, "" or ending in "/"
+	Labels   map[string]string `json:"labels"`   // LABELs added to the final image
+	Proxy    map[string]string `json:"proxy"`    // Proxy build args (HTTP_PROXY, ...)
+}
+
+// DockerfileData is what a template is rendered with: the platform settings
+// plus the values detected in the repository
+type DockerfileData struct {
+	PlatformVars
+
+	NodeVersion   string `json:"node_version" form:"node_version"`
+	PythonVersion string `json:"python_version" form:"python_version"`
+	GoVersion     string `json:"go_version" form:"go_version"`
+	RubyVersion   string `json:"ruby_version" form:"ruby_version"`
+	Port          int    `json:"port" form:"port"`
+	Entrypoint    string `json:"entrypoint" form:"entrypoint"` // Python script or Django project module
+	HasPublic     bool   `json:"has_public" form:"has_public"` // Next.js public/ directory
+
+	// A project's build commands (see models.BuildCommands), on Image
+	Image          string `json:"image,omitempty" form:"image"` // Runtime image, e.g. "node:18-alpine"
+	InstallCommand string `json:"install_command,omitempty" form:"install_command"`
+	BuildCommand   string `json:"build_command,omitempty" form:"build_command"`
+	OutputDir      string `json:"output_dir,omitempty" form:"output_dir"` // Served by nginx instead of running StartCommand
+	StartCommand   string `json:"start_command,omitempty" form:"start_command"`
+}
+
+// DefaultDockerfileData returns the values used when nothing is detected
+func DefaultDockerfileData(detector string) DockerfileData {
+	data := DockerfileData{NodeVersion: "18", PythonVersion: "3.11", GoVersion: "1.21", RubyVersion: "3.2"}
+	switch detector {
+	case "node", "nextjs", "nextjs-standalone", "rails":
+		data.Port = 3000
+	case "python", "django":
+		data.Port = 8000
+	default:
+		data.Port = 8080
+	}
+	switch detector {
+	case "python":
+		data.Entrypoint = "app.py"
+	case "django":
+		data.Entrypoint = "config"
+	case "commands":
+		data.Image = "node:" + data.NodeVersion + "-alpine"
+		data.InstallCommand = "npm install"
+		data.StartCommand = "npm start"
+	}
+	return data
+}
+
+var (
+	majorVersionPattern      = regexp.MustCompile(`\d+`)
+	majorMinorVersionPattern = regexp.MustCompile(`\d+\.\d+`)
+	goDirectivePattern       = regexp.MustCompile(`(?m)^go\s+(\d+\.\d+)`)
+)
+
+// runtimeDockerfileData is the default data of detector, built on the
+// version of runtime selected for dir (see selectRuntime)
+func runtimeDockerfileData(dir, detector, runtime, runtimeVersion string) (DockerfileData, *RuntimeSelection, []string) {
+	data := DefaultDockerfileData(detector)
+	selected, warnings := selectRuntime(dir, runtime, runtimeVersion)
+	selected.apply(&data)
+	return data, selected, warnings
+}
+
+// Templates renders generated Dockerfiles
+type Templates struct {
+	set      *template.Template
+	platform PlatformVars
+}
+
+// defaultTemplateSet is used by services that weren't given templates
+var defaultTemplateSet = mustLoadDefaultTemplates()
+
+func mustLoadDefaultTemplates() *Templates {
+	t, err := LoadTemplates(&config.Config{})
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// LoadTemplates parses the embedded templates, the overrides from
+// cfg.DockerfileTemplateDir and the platform variables. Every detector is
+// rendered once so a broken template fails startup instead of builds.
+func LoadTemplates(cfg *config.Config) (*Templates, error) {
+	set, err := template.New("dockerfiles").Option("missingkey=error").ParseFS(defaultTemplates, "templates/*.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse built-in Dockerfile templates: %w", err)
+	}
+
+	if cfg.DockerfileTemplateDir != "" {
+		overrides, err := filepath.Glob(filepath.Join(cfg.DockerfileTemplateDir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(overrides) > 0 {
+			if set, err = set.ParseFiles(overrides...); err != nil {
+				return nil, fmt.Errorf("failed to parse Dockerfile templates in %s: %w", cfg.DockerfileTemplateDir, err)
+			}
+		}
+	}
+
+	t := &Templates{set: set, platform: platformVars(cfg)}
+	for _, detector := range Detectors {
+		if _, err := t.Render(detector, DefaultDockerfileData(detector)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func platformVars(cfg *config.Config) PlatformVars {
+	vars := PlatformVars{Labels: map[string]string{}, Proxy: map[string]string{}}
+	if registry := strings.TrimSuffix(cfg.BaseImageRegistry, "/"); registry != "" {
+		vars.Registry = registry + "/"
+	}
+	for _, label := range cfg.DockerfileLabels {
+		if key, value, found := strings.Cut(label, "="); found {
+			vars.Labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	for key, value := range map[string]string{
+		"HTTP_PROXY":  cfg.BuildHTTPProxy,
+		"HTTPS_PROXY": cfg.BuildHTTPSProxy,
+		"NO_PROXY":    cfg.BuildNoProxy,
+	} {
+		if value != "" {
+			vars.Proxy[key] = value
+		}
+	}
+	return vars
+}
+
+// Render produces the Dockerfile for detector from the detected values
+func (t *Templates) Render(detector string, data DockerfileData) (string, error) {
+	tmpl := t.set.Lookup(detector + ".tmpl")
+	if tmpl == nil {
+		return "", fmt.Errorf("no Dockerfile template for %q", detector)
+	}
+	data.PlatformVars = t.platform
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("Dockerfile template %s: %w", detector, err)
+	}
+	return buf.String(), nil
+}
+
+// SetTemplates replaces the built-in Dockerfile templates
+func (s *Service) SetTemplates(t *Templates) {
+	s.templates = t
+}
+
+// writeDockerfile renders detector's template into dir/Dockerfile
+func (s *Service) writeDockerfile(dir, detector string, data DockerfileData) error {
+	templates := s.templates
+	if templates == nil {
+		templates = defaultTemplateSet
+	}
+	dockerfile, err := templates.Render(detector, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644)
+}
@@ -0,0 +1,107 @@
+package build
+
+import (
+	"deploy-platform/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nodeApp copies the fixture repository testdata/templates/node-app, a
+// plain Node.js app, to a temporary directory
+func nodeApp(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "repo")
+	if err := os.CopyFS(dir, os.DirFS(filepath.Join("testdata", "templates", "node-app"))); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// buildDockerfile detects the repository in dir with templates and returns
+// the Dockerfile generated for it
+func buildDockerfile(t *testing.T, templates *Templates, dir string) string {
+	t.Helper()
+	s := &Service{}
+	s.SetTemplates(templates)
+	detection, err := s.detectLanguage(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detection.Type != "node" || detection.Dockerfile != "Dockerfile" {
+		t.Fatalf("detected %+v", detection)
+	}
+	dockerfile, err := os.ReadFile(filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(dockerfile)
+}
+
+// A node.tmpl in DOCKERFILE_TEMPLATE_DIR replaces the built-in one in
+// builds, rendered with the detected values and the platform variables
+func TestTemplateOverride(t *testing.T) {
+	builtIn, err := LoadTemplates(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dockerfile := buildDockerfile(t, builtIn, nodeApp(t)); !strings.HasPrefix(dockerfile, "FROM node:20-alpine\n") {
+		t.Errorf("built-in template produced:\n%s", dockerfile)
+	}
+
+	overridden, err := LoadTemplates(&config.Config{
+		DockerfileTemplateDir: filepath.Join("testdata", "templates", "override"),
+		BaseImageRegistry:     "artifactory.internal/docker/",
+		DockerfileLabels:      []string{"org.example.team = platform"},
+		BuildHTTPProxy:        "http://proxy.internal:3128",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `FROM artifactory.internal/docker/node:20-bookworm-slim
+ARG HTTP_PROXY=http://proxy.internal:3128
+LABEL org.example.team="platform"
+WORKDIR /app
+COPY package*.json ./
+RUN npm ci --registry=https://artifactory.internal/api/npm/npm-remote/
+COPY . .
+RUN npm run build
+USER node
+EXPOSE 3000
+CMD ["npm", "start"]
+`
+	if dockerfile := buildDockerfile(t, overridden, nodeApp(t)); dockerfile != want {
+		t.Errorf("overridden template produced:\n%s\nwant:\n%s", dockerfile, want)
+	}
+
+	// Templates not overridden still get the platform variables
+	python, err := overridden.Render("python", DefaultDockerfileData("python"))
+	if err != nil || !strings.HasPrefix(python, "FROM artifactory.internal/docker/python:3.11") || !strings.Contains(python, `LABEL org.example.team="platform"`) {
+		t.Errorf("python template: %v\n%s", err, python)
+	}
+}
+
+// Broken templates fail loading, which happens at startup, not builds
+func TestLoadTemplatesErrors(t *testing.T) {
+	for name, template := range map[string]string{
+		"syntax":        "FROM node:{{.NodeVersion\n",
+		"unknown field": "FROM node:{{.NodeVerison}}\n",
+		"unknown partial": `FROM node{{template "labelz" .}}
+`,
+	} {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "node.tmpl"), []byte(template), 0644)
+		if _, err := LoadTemplates(&config.Config{DockerfileTemplateDir: dir}); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+
+	templates, err := LoadTemplates(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := templates.Render("cobol", DockerfileData{}); err == nil {
+		t.Error("rendered a detector without template")
+	}
+}
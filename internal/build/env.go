@@ -0,0 +1,61 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secretref"
+	"deploy-platform/internal/secrets"
+	"fmt"
+	"log"
+)
+
+// SetSecretResolver sets the providers env vars referencing a secret are
+// resolved with
+func (s *Service) SetSecretResolver(resolver *secretref.Resolver) {
+	s.secretRefs = resolver
+}
+
+// loadProjectEnv adds the project's env vars to envVars, decrypted, and
+// with their secret references resolved. The references are recorded on
+// the deployment with a hash of what they resolved to; the values only go
+// to the deployment's Secret. A reference that can't be resolved marks the
+// deployment secret_resolution_failed: it never rolls out without it.
+func (s *Service) loadProjectEnv(ctx context.Context, deployment *models.Deployment, envVars map[string]string) error {
+	var vars []models.Environment
+	if err := database.DB.Where("project_id = ?", deployment.ProjectID).Order("key").Find(&vars).Error; err != nil {
+		return fmt.Errorf("failed to load the project's env vars: %w", err)
+	}
+
+	var refs []models.SecretRef
+	for _, v := range vars {
+		if !secretref.IsRef(v.Value) {
+			value, err := secrets.Decrypt(v.Value)
+			if err != nil {
+				return fmt.Errorf("env var %s: %w", v.Key, err)
+			}
+			envVars[v.Key] = value
+			continue
+		}
+
+		value, err := s.secretRefs.Resolve(ctx, v.Value)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Printf("❌ Deployment %d: env var %s: failed to resolve %s: %v", deployment.ID, v.Key, v.Value, err)
+			deployment.FailureCategory = models.FailureSecretResolution
+			deployment.FailureDetail = fmt.Sprintf("The env var %s references a secret that couldn't be resolved (%s), so nothing was rolled out: %v", v.Key, v.Value, err)
+			database.DB.Model(deployment).Select("failure_category", "failure_detail").Updates(deployment)
+			return fmt.Errorf("env var %s: failed to resolve its secret reference: %w", v.Key, err)
+		}
+		envVars[v.Key] = value
+		refs = append(refs, models.SecretRef{Key: v.Key, Reference: v.Value, Hash: secretref.Hash(value)})
+	}
+
+	if len(refs) > 0 {
+		deployment.SecretRefs = refs
+		database.DB.Model(deployment).Select("secret_refs").Updates(deployment)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secretref"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// vaultSecrets resolves references to the values by path, failing for
+// those it doesn't have
+type vaultSecrets map[string]string
+
+func (v vaultSecrets) Resolve(ctx context.Context, ref secretref.Ref) (string, error) {
+	value, ok := v[ref.Path]
+	if !ok {
+		return "", errors.New("vault /v1/" + ref.Path + ": 404 Not Found")
+	}
+	return value, nil
+}
+
+// Literals are decrypted and references resolved into the env the Secret
+// gets; the deployment records the references with a hash of their value,
+// never the value
+func TestLoadProjectEnv(t *testing.T) {
+	testutil.DB(t)
+	if err := secrets.Init(&config.Config{EncryptionKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	resolver := secretref.NewResolver()
+	resolver.Register("vault", vaultSecrets{"secret/data/db": "hunter2"})
+	s := &Service{secretRefs: resolver}
+
+	deployment := &models.Deployment{ProjectID: 3, Status: models.StatusBuilding}
+	database.DB.Create(deployment)
+	literal, _ := secrets.Encrypt("sk_live_123")
+	database.DB.Create(&models.Environment{ProjectID: 3, Key: "STRIPE_KEY", Value: literal})
+	database.DB.Create(&models.Environment{ProjectID: 3, Key: "DB_PASSWORD", Value: "ref+vault://secret/data/db#password"})
+
+	env := map[string]string{"PORT": "3000", "STRIPE_KEY": "detected"}
+	if err := s.loadProjectEnv(context.Background(), deployment, env); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"PORT": "3000", "STRIPE_KEY": "sk_live_123", "DB_PASSWORD": "hunter2"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("env %v", env)
+	}
+	var stored models.Deployment
+	database.DB.First(&stored, deployment.ID)
+	want := []models.SecretRef{{Key: "DB_PASSWORD", Reference: "ref+vault://secret/data/db#password", Hash: secretref.Hash("hunter2")}}
+	if !reflect.DeepEqual(stored.SecretRefs, want) {
+		t.Errorf("secret refs %+v", stored.SecretRefs)
+	}
+
+	// A reference that can't be resolved fails the deployment, naming the
+	// env var and the reference
+	database.DB.Create(&models.Environment{ProjectID: 3, Key: "API_TOKEN", Value: "ref+vault://secret/data/gone"})
+	failing := &models.Deployment{ProjectID: 3, Status: models.StatusBuilding}
+	database.DB.Create(failing)
+	err := s.loadProjectEnv(context.Background(), failing, map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "env var API_TOKEN: failed to resolve its secret reference") {
+		t.Errorf("got %v", err)
+	}
+	var failed models.Deployment
+	database.DB.First(&failed, failing.ID)
+	if failed.FailureCategory != models.FailureSecretResolution ||
+		!strings.Contains(failed.FailureDetail, "The env var API_TOKEN references a secret that couldn't be resolved (ref+vault://secret/data/gone)") {
+		t.Errorf("failure %s: %s", failed.FailureCategory, failed.FailureDetail)
+	}
+
+	// Without a provider for it
+	s.secretRefs = nil
+	unconfigured := &models.Deployment{ProjectID: 3, Status: models.StatusBuilding}
+	database.DB.Create(unconfigured)
+	if err := s.loadProjectEnv(context.Background(), unconfigured, map[string]string{}); err == nil || !strings.Contains(err.Error(), `no secret provider "vault"`) {
+		t.Errorf("got %v", err)
+	}
+
+	// The resolved values are nowhere in the database
+	var rows int64
+	database.DB.Raw("SELECT count(*) FROM deployments WHERE secret_refs LIKE ? OR failure_detail LIKE ?", "%hunter2%", "%hunter2%").Scan(&rows)
+	if rows != 0 {
+		t.Error("a resolved value was stored")
+	}
+}
@@ -0,0 +1,189 @@
+package build
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	etaHistory    = 10 // Recent successful builds estimates are drawn from
+	etaMinSamples = 3  // Fewer builds and there is no estimate
+)
+
+// stepHints tell what a build is doing on each step
+var stepHints = map[string]string{
+	models.BuildStepClone:  "cloning the repository",
+	models.BuildStepDetect: "detecting how to build it",
+	models.BuildStepImage:  "building the image",
+	models.BuildStepVerify: "checking the image",
+	models.BuildStepPush:   "pushing the image",
+	models.BuildStepDeploy: "rolling out",
+}
+
+// StepStats are the median durations of the build steps of recent builds.
+// Steps none of them had, like the rollout of projects without a cluster,
+// take no time.
+type StepStats map[string]time.Duration
+
+// NewStepStats computes the StepStats of builds, nil when there are fewer
+// than etaMinSamples with step timings
+func NewStepStats(builds []models.Build) StepStats {
+	samples := make(map[string][]int64)
+	timed := 0
+	for _, b := range builds {
+		if len(b.StepMs) == 0 {
+			continue
+		}
+		timed++
+		for step, ms := range b.StepMs {
+			samples[step] = append(samples[step], ms)
+		}
+	}
+	if timed < etaMinSamples {
+		return nil
+	}
+	stats := make(StepStats, len(samples))
+	for step, ms := range samples {
+		sort.Slice(ms, func(i, j int) bool { return ms[i] < ms[j] })
+		stats[step] = time.Duration(ms[len(ms)/2]) * time.Millisecond
+	}
+	return stats
+}
+
+// Remaining is how long a build on step, for elapsed so far, has to go:
+// what is left of the step, at least nothing once it overran, and the
+// steps after it. An empty step means the build didn't start.
+func (s StepStats) Remaining(step string, elapsed time.Duration) time.Duration {
+	var remaining time.Duration
+	started := step == ""
+	for _, st := range models.BuildSteps {
+		switch {
+		case st == step:
+			started = true
+			remaining += max(s[st]-elapsed, 0)
+		case started:
+			remaining += s[st]
+		}
+	}
+	return remaining
+}
+
+// stepStatsFor returns the StepStats of a project's recent builds, falling
+// back to recent builds of framework on the platform, e.g. for its first
+// builds. nil when neither has enough history or framework isn't detected yet.
+func stepStatsFor(projectID uint, framework string) StepStats {
+	var builds []models.Build
+	err := database.DB.Select("builds.step_ms").
+		Joins("JOIN deployments ON deployments.id = builds.deployment_id").
+		Where("deployments.project_id = ? AND builds.status = ? AND builds.step_ms IS NOT NULL", projectID, "success").
+		Order("builds.id DESC").Limit(etaHistory).Find(&builds).Error
+	if err != nil {
+		return nil
+	}
+	if stats := NewStepStats(builds); stats != nil || framework == "" {
+		return stats
+	}
+	builds = nil
+	err = database.DB.Select("step_ms").
+		Where("framework = ? AND status = ? AND step_ms IS NOT NULL", framework, "success").
+		Order("id DESC").Limit(etaHistory).Find(&builds).Error
+	if err != nil {
+		return nil
+	}
+	return NewStepStats(builds)
+}
+
+// Estimate is how long a deployment has to go until it is live
+type Estimate struct {
+	Seconds *int64 // nil = not enough history
+	Hint    string // What it is doing
+}
+
+// EstimateDeployment estimates how long deployment has to go, refined as
+// its build goes through its steps. Deployments that are done, or waiting
+// for someone, get no estimate. deployment.Build must be loaded.
+func EstimateDeployment(deployment *models.Deployment) Estimate {
+	var step string
+	var elapsed time.Duration
+	var estimate Estimate
+	switch deployment.Status {
+	case models.StatusPending, models.StatusQueued:
+		estimate.Hint = "waiting for a build slot"
+	case models.StatusBuilding, models.StatusDeploying:
+		step = deployment.Build.Step
+		if step == "" && deployment.Status == models.StatusDeploying {
+			step = models.BuildStepDeploy // Not timed yet
+		}
+		if deployment.Build.StepStartedAt != nil {
+			elapsed = time.Since(*deployment.Build.StepStartedAt)
+		}
+		estimate.Hint = stepHints[step]
+	case models.StatusAwaitingApproval:
+		return Estimate{Hint: "awaiting approval"}
+	case models.StatusPolicyBlocked:
+		return Estimate{Hint: "blocked by the deploy policy"}
+	case models.StatusDeployPending:
+		if deployment.DeployRetryAt == nil {
+			return Estimate{Hint: "waiting for the cluster to retry the rollout"}
+		}
+		return Estimate{Hint: "waiting for the cluster, rollout retried at " + deployment.DeployRetryAt.UTC().Format(time.Kitchen) + " UTC"}
+	default:
+		return Estimate{}
+	}
+	if stats := stepStatsFor(deployment.ProjectID, deployment.Build.Framework); stats != nil {
+		seconds := int64(stats.Remaining(step, elapsed).Round(time.Second).Seconds())
+		estimate.Seconds = &seconds
+	}
+	return estimate
+}
+
+// startStep moves build on to step, recording how long the step it was on
+// took. Timings are best effort: failing to save them never fails a build.
+func startStep(build *models.Build, step string) {
+	now := time.Now()
+	recordStep(build, now)
+	build.Step = step
+	build.StepStartedAt = &now
+	saveSteps(build)
+}
+
+// finishSteps records how long the step build was on took, once it is done
+func finishSteps(build *models.Build) {
+	recordStep(build, time.Now())
+	build.Step = ""
+	build.StepStartedAt = nil
+	saveSteps(build)
+}
+
+func recordStep(build *models.Build, now time.Time) {
+	if build.Step == "" || build.StepStartedAt == nil {
+		return
+	}
+	if build.StepMs == nil {
+		build.StepMs = make(map[string]int64, len(models.BuildSteps))
+	}
+	build.StepMs[build.Step] = now.Sub(*build.StepStartedAt).Milliseconds()
+}
+
+func saveSteps(build *models.Build) {
+	if err := database.DB.Model(build).Select("step", "step_started_at", "step_ms").Updates(build).Error; err != nil {
+		log.Printf("⚠️  Failed to save the step timings of build %d: %v", build.ID, err)
+	}
+}
+
+// estimateBuild records how long build of deployment is estimated to take
+// as it starts
+func estimateBuild(build *models.Build, deployment *models.Deployment) {
+	stats := stepStatsFor(deployment.ProjectID, "")
+	if stats == nil {
+		return
+	}
+	estimated := stats.Remaining("", 0)
+	seconds := int64(estimated.Round(time.Second).Seconds())
+	build.EstimatedSeconds = &seconds
+	database.DB.Model(build).Update("estimated_seconds", seconds)
+	log.Printf("⏱️  Deployment %d estimated to take %s", deployment.ID, estimated.Round(time.Second))
+}
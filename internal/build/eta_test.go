@@ -0,0 +1,198 @@
+package build
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"testing"
+	"time"
+)
+
+// timings are the step durations of a build, in seconds
+type timings map[string]int64
+
+func (t timings) ms() map[string]int64 {
+	ms := make(map[string]int64, len(t))
+	for step, seconds := range t {
+		ms[step] = seconds * 1000
+	}
+	return ms
+}
+
+// history creates a successful deployment of project for each of builds,
+// built for framework
+func history(t *testing.T, projectID uint, framework string, builds ...timings) {
+	t.Helper()
+	for _, steps := range builds {
+		deployment := &models.Deployment{ProjectID: projectID, Status: models.StatusDeployed}
+		database.DB.Create(deployment)
+		if err := database.DB.Create(&models.Build{DeploymentID: deployment.ID, Status: "success", Framework: framework, StepMs: steps.ms()}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewStepStats(t *testing.T) {
+	builds := []models.Build{
+		{StepMs: timings{"clone": 4, "image": 60, "deploy": 20}.ms()},
+		{StepMs: timings{"clone": 2, "image": 90, "deploy": 10}.ms()},
+		{StepMs: timings{"clone": 3, "image": 30}.ms()}, // No cluster to roll out to
+		{}, // Built before steps were timed
+	}
+	stats := NewStepStats(builds)
+	want := StepStats{"clone": 3 * time.Second, "image": 60 * time.Second, "deploy": 20 * time.Second}
+	if len(stats) != len(want) {
+		t.Fatalf("stats %v, want %v", stats, want)
+	}
+	for step, d := range want {
+		if stats[step] != d {
+			t.Errorf("%s: %s, want %s", step, stats[step], d)
+		}
+	}
+	if stats := NewStepStats(builds[1:]); stats != nil {
+		t.Errorf("two timed builds: %v", stats)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	stats := StepStats{"clone": 5 * time.Second, "detect": time.Second, "image": 60 * time.Second, "verify": 4 * time.Second, "deploy": 30 * time.Second}
+	tests := []struct {
+		step    string
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{"", 0, 100 * time.Second},
+		{"clone", 0, 100 * time.Second},
+		{"clone", 2 * time.Second, 98 * time.Second},
+		{"image", 20 * time.Second, 74 * time.Second},
+		// Overran: nothing left of the step, the next ones still to go
+		{"image", 5 * time.Minute, 34 * time.Second},
+		// Not timed by the history: takes no time
+		{"push", 10 * time.Second, 30 * time.Second},
+		{"deploy", 10 * time.Second, 20 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := stats.Remaining(tt.step, tt.elapsed); got != tt.want {
+			t.Errorf("Remaining(%q, %s) = %s, want %s", tt.step, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+// The estimate starts from the project's history, or its framework's, and
+// is refined as the build goes through its steps
+func TestEstimateDeployment(t *testing.T) {
+	testutil.DB(t)
+	project := &models.Project{Name: "app", Slug: "app"}
+	database.DB.Create(project)
+	history(t, project.ID, "nextjs",
+		timings{"clone": 10, "detect": 2, "image": 100, "verify": 8, "deploy": 40},
+		timings{"clone": 12, "detect": 2, "image": 120, "verify": 10, "deploy": 30},
+		timings{"clone": 8, "detect": 2, "image": 80, "verify": 6, "deploy": 50},
+	)
+	// Other projects' builds don't count, unless as a fallback
+	history(t, project.ID+1, "django", timings{"clone": 100, "image": 1000})
+
+	deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusQueued}
+	database.DB.Create(deployment)
+	seconds := func() int64 {
+		t.Helper()
+		var d models.Deployment
+		database.DB.Preload("Build").First(&d, deployment.ID)
+		estimate := EstimateDeployment(&d)
+		if estimate.Seconds == nil {
+			t.Fatalf("no estimate while %s (%s)", d.Status, estimate.Hint)
+		}
+		return *estimate.Seconds
+	}
+	if got := seconds(); got != 10+2+100+8+40 {
+		t.Errorf("queued: %ds", got)
+	}
+
+	// The build starts with an estimate of the whole
+	models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusBuilding, "")
+	build := &models.Build{DeploymentID: deployment.ID, Status: "building"}
+	database.DB.Create(build)
+	estimateBuild(build, deployment)
+	if build.EstimatedSeconds == nil || *build.EstimatedSeconds != 160 {
+		t.Fatalf("estimated %v", build.EstimatedSeconds)
+	}
+	var saved models.Build
+	database.DB.First(&saved, build.ID)
+	if saved.EstimatedSeconds == nil || *saved.EstimatedSeconds != 160 {
+		t.Errorf("saved estimate %v", saved.EstimatedSeconds)
+	}
+
+	// Each step is timed as the build moves on, and the estimate follows
+	var estimates []int64
+	for _, step := range []string{models.BuildStepClone, models.BuildStepDetect, models.BuildStepImage} {
+		startStep(build, step)
+		estimates = append(estimates, seconds())
+	}
+	if estimates[0] != 160 || estimates[1] != 150 || estimates[2] != 148 {
+		t.Errorf("estimates %v", estimates)
+	}
+	database.DB.First(&saved, build.ID)
+	if saved.Step != models.BuildStepImage || saved.StepStartedAt == nil || len(saved.StepMs) != 2 {
+		t.Errorf("saved step %q since %v, timings %v", saved.Step, saved.StepStartedAt, saved.StepMs)
+	}
+
+	// Half way through the image
+	halfway := time.Now().Add(-50 * time.Second)
+	database.DB.Model(build).Update("step_started_at", halfway)
+	if got := seconds(); got != 50+8+40 {
+		t.Errorf("half way through the image: %ds", got)
+	}
+	var d models.Deployment
+	database.DB.Preload("Build").First(&d, deployment.ID)
+	if hint := EstimateDeployment(&d).Hint; hint != "building the image" {
+		t.Errorf("hint %q", hint)
+	}
+
+	// Done: no estimate, and the timings are history for the next builds
+	finishSteps(build)
+	database.DB.First(&saved, build.ID)
+	if saved.Step != "" || saved.StepMs[models.BuildStepImage] < 50_000 {
+		t.Errorf("finished: step %q, timings %v", saved.Step, saved.StepMs)
+	}
+	models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusDeploying, "")
+	models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusDeployed, "")
+	database.DB.Preload("Build").First(&d, deployment.ID)
+	if estimate := EstimateDeployment(&d); estimate.Seconds != nil || estimate.Hint != "" {
+		t.Errorf("deployed: %+v", estimate)
+	}
+}
+
+// First builds fall back to the history of their framework, and without
+// enough of either there is no estimate rather than a wrong one
+func TestEstimateWithoutHistory(t *testing.T) {
+	testutil.DB(t)
+	history(t, 90, "nextjs",
+		timings{"clone": 5, "image": 50},
+		timings{"clone": 5, "image": 70},
+		timings{"clone": 5, "image": 60},
+	)
+	history(t, 91, "django", timings{"clone": 5, "image": 50})
+
+	estimate := func(framework string, status models.DeploymentStatus) Estimate {
+		return EstimateDeployment(&models.Deployment{ProjectID: 1, Status: status, Build: models.Build{Framework: framework, Step: models.BuildStepImage}})
+	}
+	if e := estimate("nextjs", models.StatusBuilding); e.Seconds == nil || *e.Seconds != 60 || e.Hint != "building the image" {
+		t.Errorf("framework fallback: %+v", e)
+	}
+	for _, framework := range []string{"", "django", "rails"} {
+		if e := estimate(framework, models.StatusBuilding); e.Seconds != nil || e.Hint != "building the image" {
+			t.Errorf("%q: %+v", framework, e)
+		}
+	}
+
+	// Waiting on someone: a hint, never an estimate
+	for status, hint := range map[models.DeploymentStatus]string{
+		models.StatusAwaitingApproval: "awaiting approval",
+		models.StatusPolicyBlocked:    "blocked by the deploy policy",
+		models.StatusDeployPending:    "waiting for the cluster to retry the rollout",
+	} {
+		if e := estimate("nextjs", status); e.Seconds != nil || e.Hint != hint {
+			t.Errorf("%s: %+v", status, e)
+		}
+	}
+}
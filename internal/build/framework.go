@@ -0,0 +1,204 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// framework is a web framework detected on top of a language, with the
+// Dockerfile tuned for it
+type framework struct {
+	Name        string
+	Language    string
+	Version     string
+	Port        int
+	Template    string            // Dockerfile template, see Detectors
+	Data        DockerfileData    // Values the template is rendered with
+	Runtime     *RuntimeSelection // Version of Language the template builds on
+	RequiredEnv [][]string        // Each entry lists alternatives, one of which should be set
+	Warnings    []string
+}
+
+// detectFramework recognizes Next.js, Django and Rails apps in dir; nil means
+// none was found and the plain language detectors apply. The app builds on
+// the project's runtimeVersion of its language when set.
+func detectFramework(dir, runtimeVersion string) *framework {
+	var fw *framework
+	switch {
+	case isNextApp(dir):
+		fw = nextFramework(dir)
+	case fileExists(filepath.Join(dir, "manage.py")) && fileExists(filepath.Join(dir, "requirements.txt")):
+		fw = djangoFramework(dir)
+	case fileExists(filepath.Join(dir, "config", "application.rb")) && fileExists(filepath.Join(dir, "Gemfile")):
+		fw = railsFramework(dir)
+	default:
+		return nil
+	}
+	runtime, warnings := selectRuntime(dir, fw.Language, runtimeVersion)
+	runtime.apply(&fw.Data)
+	fw.Runtime = runtime
+	fw.Warnings = append(fw.Warnings, warnings...)
+	return fw
+}
+
+// Next.js
+
+var nextConfigNames = []string{"next.config.js", "next.config.mjs", "next.config.ts"}
+
+type packageJSON struct {
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+	Engines         map[string]string `json:"engines"`
+}
+
+func isNextApp(dir string) bool {
+	if findNextConfig(dir) != "" {
+		return true
+	}
+	_, ok := nodeDependency(dir, "next")
+	return ok
+}
+
+func findNextConfig(dir string) string {
+	for _, name := range nextConfigNames {
+		if path := filepath.Join(dir, name); fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func nodeDependency(dir, name string) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return "", false
+	}
+	var pkg packageJSON
+	if json.Unmarshal(data, &pkg) != nil {
+		return "", false
+	}
+	if version, ok := pkg.Dependencies[name]; ok {
+		return version, true
+	}
+	version, ok := pkg.DevDependencies[name]
+	return version, ok
+}
+
+func nextFramework(dir string) *framework {
+	fw := &framework{Name: "nextjs", Language: "node", Port: 3000, Template: "nextjs"}
+	if version, ok := nodeDependency(dir, "next"); ok {
+		fw.Version = cleanVersion(version)
+	}
+	fw.Data = DefaultDockerfileData(fw.Template)
+
+	if path := findNextConfig(dir); path != "" {
+		if data, err := os.ReadFile(path); err == nil && regexp.MustCompile(`output\s*:\s*["']standalone["']`).Match(data) {
+			fw.Template = "nextjs-standalone"
+			fw.Data.HasPublic = fileExists(filepath.Join(dir, "public"))
+		}
+	}
+	return fw
+}
+
+// Django
+
+func djangoFramework(dir string) *framework {
+	fw := &framework{
+		Name:        "django",
+		Language:    "python",
+		Port:        8000,
+		Template:    "django",
+		Version:     requirementVersion(filepath.Join(dir, "requirements.txt"), "django"),
+		RequiredEnv: [][]string{{"SECRET_KEY", "DJANGO_SECRET_KEY"}},
+	}
+
+	module := djangoProjectModule(dir)
+	if module == "" {
+		module = "config"
+		fw.Warnings = append(fw.Warnings, "Could not find the Django project module (DJANGO_SETTINGS_MODULE in manage.py), assuming config.wsgi")
+	}
+	if settings, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(module, ".", "/")), "settings.py")); err == nil && !strings.Contains(string(settings), "STATIC_ROOT") {
+		fw.Warnings = append(fw.Warnings, "STATIC_ROOT is not set in settings.py, collectstatic will be skipped")
+	}
+
+	fw.Data = DefaultDockerfileData(fw.Template)
+	fw.Data.Entrypoint = module
+	return fw
+}
+
+var djangoSettingsPattern = regexp.MustCompile(`DJANGO_SETTINGS_MODULE["']\s*,\s*["']([\w.]+)\.settings["']`)
+
+// djangoProjectModule finds the package holding settings.py and wsgi.py
+func djangoProjectModule(dir string) string {
+	if data, err := os.ReadFile(filepath.Join(dir, "manage.py")); err == nil {
+		if m := djangoSettingsPattern.FindSubmatch(data); m != nil {
+			return string(m[1])
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", "wsgi.py"))
+	if len(matches) == 1 {
+		return filepath.Base(filepath.Dir(matches[0]))
+	}
+	return ""
+}
+
+// requirementVersion returns the pinned version of pkg in a requirements file
+func requirementVersion(path, pkg string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	pattern := regexp.MustCompile(`(?i)^` + regexp.QuoteMeta(pkg) + `\s*(?:\[[^\]]*\])?\s*(==|~=|>=)\s*([\w.]+)`)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := pattern.FindStringSubmatch(strings.TrimSpace(scanner.Text())); m != nil {
+			return m[2]
+		}
+	}
+	return ""
+}
+
+// Rails
+
+var (
+	railsLockPattern    = regexp.MustCompile(`(?m)^\s{4}rails \(([\d.]+)\)`)
+	railsGemfilePattern = regexp.MustCompile(`(?m)^\s*gem\s+["']rails["']\s*,\s*["'][~>=\s]*([\d.]+)["']`)
+)
+
+func railsFramework(dir string) *framework {
+	fw := &framework{
+		Name:        "rails",
+		Language:    "ruby",
+		Port:        3000,
+		Template:    "rails",
+		RequiredEnv: [][]string{{"SECRET_KEY_BASE", "RAILS_MASTER_KEY"}},
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "Gemfile.lock")); err == nil {
+		if m := railsLockPattern.FindSubmatch(data); m != nil {
+			fw.Version = string(m[1])
+		}
+	} else {
+		fw.Warnings = append(fw.Warnings, "Gemfile.lock is missing, gem versions will be resolved at build time")
+	}
+	if fw.Version == "" {
+		if data, err := os.ReadFile(filepath.Join(dir, "Gemfile")); err == nil {
+			if m := railsGemfilePattern.FindSubmatch(data); m != nil {
+				fw.Version = string(m[1])
+			}
+		}
+	}
+
+	fw.Data = DefaultDockerfileData(fw.Template)
+	return fw
+}
+
+// cleanVersion strips range operators from a package.json version ("^14.1.0" -> "14.1.0")
+func cleanVersion(version string) string {
+	return strings.TrimLeft(strings.TrimSpace(version), "^~>=<v ")
+}
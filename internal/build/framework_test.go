@@ -0,0 +1,106 @@
+package build
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares got with the golden file at path, rewriting it with -update
+func golden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from the golden file %s:\n%s", filepath.Base(path), path, got)
+	}
+}
+
+// Each fixture repository under testdata/frameworks is detected as its
+// framework, and gets the Dockerfile in testdata/frameworks/<name>.Dockerfile
+func TestFrameworkDockerfiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		framework string
+		version   string
+		port      int
+		runtime   RuntimeSelection
+		warnings  []string
+		required  [][]string
+	}{
+		{
+			name: "nextjs", framework: "nextjs", version: "14.1.0", port: 3000,
+			runtime: RuntimeSelection{Runtime: "node", Version: "18", Source: RuntimeSourceDefault},
+		},
+		{
+			name: "nextjs-standalone", framework: "nextjs", version: "14.2.3", port: 3000,
+			runtime: RuntimeSelection{Runtime: "node", Version: "20", Source: "package.json engines"},
+		},
+		{
+			name: "django", framework: "django", version: "5.0.2", port: 8000,
+			runtime:  RuntimeSelection{Runtime: "python", Version: "3.11", Source: RuntimeSourceDefault},
+			warnings: []string{"STATIC_ROOT is not set in settings.py, collectstatic will be skipped"},
+			required: [][]string{{"SECRET_KEY", "DJANGO_SECRET_KEY"}},
+		},
+		{
+			name: "rails", framework: "rails", version: "7.1.3", port: 3000,
+			runtime:  RuntimeSelection{Runtime: "ruby", Version: "3.3", Source: ".ruby-version"},
+			required: [][]string{{"SECRET_KEY_BASE", "RAILS_MASTER_KEY"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.CopyFS(dir, os.DirFS(filepath.Join("testdata", "frameworks", tt.name))); err != nil {
+				t.Fatal(err)
+			}
+
+			detection, err := (&Service{}).detectLanguage(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Framework != tt.framework || detection.FrameworkVersion != tt.version || detection.Port != tt.port {
+				t.Errorf("detected %s %s on port %d, want %s %s on port %d",
+					detection.Framework, detection.FrameworkVersion, detection.Port, tt.framework, tt.version, tt.port)
+			}
+			if detection.Runtime == nil || *detection.Runtime != tt.runtime {
+				t.Errorf("runtime %+v, want %+v", detection.Runtime, tt.runtime)
+			}
+			if !reflect.DeepEqual(detection.Warnings, tt.warnings) {
+				t.Errorf("warnings %q, want %q", detection.Warnings, tt.warnings)
+			}
+			if !reflect.DeepEqual(detection.requiredEnv, tt.required) {
+				t.Errorf("required env %q, want %q", detection.requiredEnv, tt.required)
+			}
+
+			dockerfile, err := os.ReadFile(filepath.Join(dir, "Dockerfile"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, filepath.Join("testdata", "frameworks", tt.name+".Dockerfile"), dockerfile)
+		})
+	}
+}
+
+func TestMissingEnvWarnings(t *testing.T) {
+	detection := &Detection{Framework: "rails", requiredEnv: [][]string{{"SECRET_KEY_BASE", "RAILS_MASTER_KEY"}}}
+	if warnings := detection.missingEnvWarnings(map[string]bool{"RAILS_MASTER_KEY": true}); len(warnings) != 0 {
+		t.Errorf("got %q with RAILS_MASTER_KEY set", warnings)
+	}
+	want := []string{"rails apps need SECRET_KEY_BASE or RAILS_MASTER_KEY: add it to the project's environment variables"}
+	if warnings := detection.missingEnvWarnings(map[string]bool{"DATABASE_URL": true}); !reflect.DeepEqual(warnings, want) {
+		t.Errorf("got %q, want %q", warnings, want)
+	}
+}
@@ -0,0 +1,154 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hooks"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// HeartbeatInterval is how often a running build reports that it is alive
+const HeartbeatInterval = 30 * time.Second
+
+// maxBuildAttempts is how many builds a deployment gets: one whose worker
+// dies building it is built again until it has this many, rather than
+// failing, so a replica killed mid-build loses nothing
+const maxBuildAttempts = 3
+
+// minBeatGap throttles beats coming from progress streams (clone output,
+// docker build chunks) so chatty builds don't turn into a DB write per line
+const minBeatGap = 10 * time.Second
+
+// heartbeat keeps Build.LastHeartbeatAt fresh while a build runs. It beats on
+// a ticker and whenever progress output is written to it.
+type heartbeat struct {
+	buildID uint
+	mu      sync.Mutex
+	last    time.Time
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func startHeartbeat(buildID uint) *heartbeat {
+	h := &heartbeat{buildID: buildID, stop: make(chan struct{})}
+	h.beat(true)
+
+	go func() {
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.beat(true)
+			}
+		}
+	}()
+	return h
+}
+
+// Write lets the heartbeat be used as a progress sink (io.Writer)
+func (h *heartbeat) Write(p []byte) (int, error) {
+	h.beat(false)
+	return len(p), nil
+}
+
+func (h *heartbeat) beat(force bool) {
+	h.mu.Lock()
+	now := time.Now()
+	if !force && now.Sub(h.last) < minBeatGap {
+		h.mu.Unlock()
+		return
+	}
+	h.last = now
+	h.mu.Unlock()
+
+	database.DB.Model(&models.Build{}).Where("id = ?", h.buildID).Update("last_heartbeat_at", now)
+}
+
+// Stop ends the ticker; safe to call more than once
+func (h *heartbeat) Stop() {
+	h.once.Do(func() { close(h.stop) })
+}
+
+// StaleBuilds returns builds still marked "building" whose last heartbeat (or
+// start time, if they never beat) is older than timeout
+func StaleBuilds(timeout time.Duration) ([]models.Build, error) {
+	cutoff := time.Now().Add(-timeout)
+	var builds []models.Build
+	err := database.DB.Where("status = ?", "building").
+		Where("last_heartbeat_at < ? OR (last_heartbeat_at IS NULL AND started_at < ?)", cutoff, cutoff).
+		Find(&builds).Error
+	return builds, err
+}
+
+// ReclaimStaleBuilds fails builds whose worker stopped heartbeating. Their
+// deployments are handed back, pending to be queued again, or fail once
+// they used up their maxBuildAttempts builds, e.g. one that kills its
+// worker each time.
+func ReclaimStaleBuilds(timeout time.Duration) (int, error) {
+	builds, err := StaleBuilds(timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	reclaimed := 0
+	for _, b := range builds {
+		if reclaimBuild(b, "build worker stopped responding (no heartbeat for "+timeout.String()+")") {
+			reclaimed++
+		}
+	}
+	return reclaimed, nil
+}
+
+// reclaimBuild fails b, found stale, and hands its deployment back. It does
+// nothing if b is no longer building: finished since it was found, or
+// reclaimed by another replica, which requeued its deployment already.
+func reclaimBuild(b models.Build, message string) bool {
+	result := database.DB.Model(&models.Build{}).Where("id = ? AND status = ?", b.ID, "building").Updates(map[string]interface{}{
+		"status": "failed",
+		"logs":   b.Logs + "\n" + message,
+	})
+	if result.Error != nil {
+		log.Printf("⚠️  Failed to reclaim stale build %d: %v", b.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected != 1 {
+		return false
+	}
+	// Its worker never will: compact the output it wrote
+	if err := buildlogs.Compact(database.DB, b.ID); err != nil {
+		log.Printf("⚠️  Failed to compact the output of build %d: %v", b.ID, err)
+	}
+	var attempts int64
+	database.DB.Model(&models.Build{}).Where("deployment_id = ?", b.DeploymentID).Count(&attempts)
+	if attempts < maxBuildAttempts {
+		models.SetDeploymentStatus(database.DB, b.DeploymentID, models.StatusPending, fmt.Sprintf("%s, built again (build %d of %d)", message, attempts+1, maxBuildAttempts))
+	} else if models.SetDeploymentStatus(database.DB, b.DeploymentID, models.StatusFailed, message) == nil {
+		hooks.Failed(b.DeploymentID)
+	}
+	log.Printf("🪦 Reclaimed stale build %d (deployment %d): %s", b.ID, b.DeploymentID, message)
+	return true
+}
+
+// WatchdogJob reclaims stale builds every heartbeat interval, on the leader
+func WatchdogJob(timeout time.Duration) background.Job {
+	return background.Job{
+		Name:      "build-watchdog",
+		Interval:  HeartbeatInterval,
+		Singleton: true,
+		Manual:    true,
+		Run: func(ctx context.Context) error {
+			reclaimed, err := ReclaimStaleBuilds(timeout)
+			background.Processed(ctx, reclaimed)
+			return err
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package build
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"testing"
+	"time"
+)
+
+// startBuild starts a build of deployment as a worker does, beating, and
+// returns it with its heartbeat stopped as if its worker died
+func startBuild(t *testing.T, deployment *models.Deployment) *models.Build {
+	t.Helper()
+	if err := models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusBuilding, ""); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	b := &models.Build{DeploymentID: deployment.ID, Status: "building", StartedAt: &now}
+	database.DB.Create(b)
+	startHeartbeat(b.ID).Stop()
+	return b
+}
+
+func deploymentStatus(t *testing.T, id uint) models.DeploymentStatus {
+	t.Helper()
+	var deployment models.Deployment
+	if err := database.DB.First(&deployment, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return deployment.Status
+}
+
+// A build whose worker stopped beating is failed and its deployment built
+// again, up to maxBuildAttempts builds
+func TestReclaimStaleBuilds(t *testing.T) {
+	testutil.DB(t)
+	project := &models.Project{Name: "app", Slug: "app"}
+	database.DB.Create(project)
+	deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusPending}
+	database.DB.Create(deployment)
+	const timeout = 50 * time.Millisecond
+
+	for attempt := 1; attempt <= maxBuildAttempts; attempt++ {
+		b := startBuild(t, deployment)
+		if reclaimed, err := ReclaimStaleBuilds(timeout); err != nil || reclaimed != 0 {
+			t.Fatalf("build %d reclaimed while beating: %d, %v", attempt, reclaimed, err)
+		}
+		time.Sleep(2 * timeout)
+		if reclaimed, err := ReclaimStaleBuilds(timeout); err != nil || reclaimed != 1 {
+			t.Fatalf("build %d: reclaimed %d, %v", attempt, reclaimed, err)
+		}
+		database.DB.First(b, b.ID)
+		if b.Status != "failed" {
+			t.Errorf("build %d is %s", attempt, b.Status)
+		}
+
+		want := models.StatusPending
+		if attempt == maxBuildAttempts {
+			want = models.StatusFailed
+		}
+		if got := deploymentStatus(t, deployment.ID); got != want {
+			t.Fatalf("after build %d the deployment is %s, want %s", attempt, got, want)
+		}
+	}
+	if reclaimed, err := ReclaimStaleBuilds(timeout); err != nil || reclaimed != 0 {
+		t.Errorf("reclaimed %d failed builds again, %v", reclaimed, err)
+	}
+}
+
+// A build found stale that finished before it was reclaimed, or that
+// another replica reclaimed first, leaves its deployment alone
+func TestReclaimFinishedBuild(t *testing.T) {
+	testutil.DB(t)
+	project := &models.Project{Name: "app", Slug: "app"}
+	database.DB.Create(project)
+	deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusPending}
+	database.DB.Create(deployment)
+	b := startBuild(t, deployment)
+	stale := *b
+
+	database.DB.Model(b).Update("status", "success")
+	if err := models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusDeploying, ""); err != nil {
+		t.Fatal(err)
+	}
+	if reclaimBuild(stale, "no heartbeat") {
+		t.Error("a finished build was reclaimed")
+	}
+	if got := deploymentStatus(t, deployment.ID); got != models.StatusDeploying {
+		t.Errorf("the deployment is %s", got)
+	}
+
+	// Two replicas' watchdogs finding the same build: one requeues it
+	database.DB.Model(b).Update("status", "building")
+	database.DB.Model(deployment).Update("status", models.StatusBuilding)
+	if !reclaimBuild(stale, "no heartbeat") || reclaimBuild(stale, "no heartbeat") {
+		t.Error("the build was not reclaimed exactly once")
+	}
+	var events int64
+	database.DB.Model(&models.DeploymentEvent{}).Where("deployment_id = ? AND to_status = ?", deployment.ID, models.StatusPending).Count(&events)
+	if events != 1 {
+		t.Errorf("the deployment was requeued %d times", events)
+	}
+}
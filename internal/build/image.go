@@ -0,0 +1,198 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"deploy-platform/pkg/docker"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// imageBudget bounds built images; zero fields are not checked
+type imageBudget struct {
+	warnBytes int64 // Warn above this size
+	maxBytes  int64 // Fail strict projects above this size
+	maxLayers int   // Warn above this many filesystem layers
+}
+
+// SetImageBudget sets the image size builds are warned about (warnMB), the
+// size deployments of strict projects fail above (maxMB) and the layer count
+// builds are warned about. 0 disables a check.
+func (s *Service) SetImageBudget(warnMB, maxMB, maxLayers int) {
+	s.imageBudget = imageBudget{
+		warnBytes: int64(warnMB) << 20,
+		maxBytes:  int64(maxMB) << 20,
+		maxLayers: maxLayers,
+	}
+}
+
+// ImageTooLargeError fails a strict project's deployment whose image is over
+// the hard budget
+type ImageTooLargeError struct {
+	Size int64
+	Max  int64
+}
+
+func (e *ImageTooLargeError) Error() string {
+	return fmt.Sprintf("image is %s, over the %s limit for this project", formatSize(e.Size), formatSize(e.Max))
+}
+
+// checkImage records the built image's digest, size, layer count and advice
+// on the build, and enforces the hard budget on projects that opted into it.
+// Images that can't be inspected are let through.
+func (s *Service) checkImage(ctx context.Context, build *models.Build, deployment *models.Deployment, imageTag string) error {
+	info, err := s.dockerClient.InspectImage(ctx, imageTag)
+	if err != nil {
+		log.Printf("⚠️  Deployment %d: could not inspect image %s: %v", deployment.ID, imageTag, err)
+		return nil
+	}
+
+	warnings := adviseImage(info, s.imageBudget)
+	for _, warning := range warnings {
+		log.Printf("⚠️  Deployment %d: %s", deployment.ID, warning)
+	}
+	build.ImageDigest = info.ID
+	build.ImageSizeBytes = info.Size
+	build.ImageLayers = info.Layers
+	build.Warnings = append(build.Warnings, warnings...)
+	database.DB.Model(build).Select("image_digest", "image_size_bytes", "image_layers", "warnings").Updates(build)
+
+	if deployment.Project.StrictImageBudget && s.imageBudget.maxBytes > 0 && info.Size > s.imageBudget.maxBytes {
+		deployment.FailureCategory = models.FailureImageTooLarge
+		database.DB.Model(deployment).Update("failure_category", deployment.FailureCategory)
+		return &ImageTooLargeError{Size: info.Size, Max: s.imageBudget.maxBytes}
+	}
+	return nil
+}
+
+// adviseImage compares an image against the budget. Images over the soft
+// size budget get advice derived from the instructions in their history.
+func adviseImage(info *docker.ImageInfo, budget imageBudget) []string {
+	var warnings []string
+	if budget.maxLayers > 0 && info.Layers > budget.maxLayers {
+		warnings = append(warnings, fmt.Sprintf("Image has %d layers (budget %d); combine consecutive RUN steps", info.Layers, budget.maxLayers))
+	}
+	if budget.warnBytes <= 0 || info.Size <= budget.warnBytes {
+		return warnings
+	}
+
+	warnings = append(warnings, fmt.Sprintf("Image is %s, over the %s budget", formatSize(info.Size), formatSize(budget.warnBytes)))
+	return append(warnings, imageAdvice(info)...)
+}
+
+// toolchains are recognized by the ENV their official base images set
+var toolchains = []struct {
+	envs    []string // Lowercase, matched in ENV steps
+	install []string // Dependency install commands, lowercase
+	advice  string
+}{
+	{
+		envs:    []string{"node_version="},
+		install: []string{"npm install", "npm ci", "yarn install", "yarn --frozen-lockfile", "pnpm install"},
+		advice:  "Image contains node_modules and a full node toolchain; consider a multi-stage build that copies only the build output and production dependencies",
+	},
+	{
+		envs:    []string{"golang_version=", "go_version="},
+		install: []string{"go build", "go mod download"},
+		advice:  "Image contains the full Go toolchain; consider a multi-stage build that copies only the binary into a distroless or alpine image",
+	},
+	{
+		envs:    []string{"rust_version=", "rustup_home="},
+		install: []string{"cargo build"},
+		advice:  "Image contains the full Rust toolchain and build cache; consider a multi-stage build that copies only the binary",
+	},
+	{
+		envs:    []string{"maven_home=", "gradle_home="},
+		install: []string{"mvn ", "gradle "},
+		advice:  "Image contains a JDK and build tool; consider a multi-stage build that copies only the jar into a JRE image",
+	},
+}
+
+// largeLayerShare is the share of the image a single COPY may take before
+// it's worth pointing out; smaller RUN layers than minAdviceLayer aren't
+const (
+	largeLayerShare = 0.5
+	minAdviceLayer  = 10 << 20
+)
+
+// imageAdvice explains what makes an image large from its history
+func imageAdvice(info *docker.ImageInfo) []string {
+	var advice []string
+
+	for _, tc := range toolchains {
+		if historyContains(info.History, "env", tc.envs) && historyContains(info.History, "run", tc.install) {
+			advice = append(advice, tc.advice)
+		}
+	}
+
+	for _, layer := range info.History {
+		step := instruction(layer.CreatedBy)
+		command := strings.ToLower(step)
+		isRun := strings.HasPrefix(command, "run") && layer.Size >= minAdviceLayer
+		switch {
+		case isRun && strings.Contains(command, "apt-get install") && !strings.Contains(command, "/var/lib/apt/lists"):
+			advice = append(advice, fmt.Sprintf("`%s` keeps the apt package lists (%s); remove /var/lib/apt/lists/* in the same RUN", abbreviate(step), formatSize(layer.Size)))
+		case isRun && strings.Contains(command, "pip install") && !strings.Contains(command, "--no-cache-dir"):
+			advice = append(advice, fmt.Sprintf("`%s` keeps the pip cache (%s); add --no-cache-dir", abbreviate(step), formatSize(layer.Size)))
+		case (strings.HasPrefix(command, "copy") || strings.HasPrefix(command, "add")) && float64(layer.Size) >= largeLayerShare*float64(info.Size):
+			advice = append(advice, fmt.Sprintf("`%s` adds %s; check that .dockerignore excludes node_modules, .git and build output", abbreviate(step), formatSize(layer.Size)))
+		}
+	}
+	return advice
+}
+
+// historyContains reports whether a step of kind ("env", "run") mentions
+// any of needles
+func historyContains(history []docker.ImageLayer, kind string, needles []string) bool {
+	for _, layer := range history {
+		command := strings.ToLower(instruction(layer.CreatedBy))
+		if !strings.HasPrefix(command, kind) {
+			continue
+		}
+		for _, needle := range needles {
+			if strings.Contains(command, needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// instruction turns a history entry back into its Dockerfile instruction.
+// The legacy builder records "/bin/sh -c #(nop)  ENV ..." for metadata and
+// "/bin/sh -c npm ci" for RUN (prefixed with "|N ARG=..." when build args
+// are set); BuildKit records "RUN /bin/sh -c npm ci # buildkit".
+func instruction(createdBy string) string {
+	s := strings.TrimSpace(strings.TrimSuffix(createdBy, "# buildkit"))
+	if strings.HasPrefix(s, "|") {
+		if i := strings.Index(s, "/bin/sh -c "); i >= 0 {
+			s = s[i:]
+		}
+	}
+	if rest, ok := strings.CutPrefix(s, "/bin/sh -c #(nop)"); ok {
+		return strings.TrimSpace(rest)
+	}
+	if rest, ok := strings.CutPrefix(s, "/bin/sh -c "); ok {
+		return "RUN " + strings.TrimSpace(rest)
+	}
+	if rest, ok := strings.CutPrefix(s, "RUN /bin/sh -c "); ok {
+		return "RUN " + strings.TrimSpace(rest)
+	}
+	return s
+}
+
+// abbreviate shortens an instruction for a warning
+func abbreviate(step string) string {
+	return textutil.Truncate(step, 60)
+}
+
+// formatSize renders bytes as MB, or GB from 1 GB on
+func formatSize(bytes int64) string {
+	if bytes >= 1<<30 {
+		return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+	}
+	return fmt.Sprintf("%d MB", bytes>>20)
+}
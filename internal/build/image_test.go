@@ -0,0 +1,99 @@
+package build
+
+import (
+	"deploy-platform/pkg/docker"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// imageFixture reads testdata/images/<name>.json, the inspection of a
+// built image as docker.Client.InspectImage returns it
+func imageFixture(t *testing.T, name string) *docker.ImageInfo {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", "images", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info docker.ImageInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		t.Fatal(err)
+	}
+	return &info
+}
+
+func TestAdviseImage(t *testing.T) {
+	s := &Service{}
+	s.SetImageBudget(500, 4096, 8)
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{
+			name: "node-single-stage",
+			want: []string{
+				"Image has 9 layers (budget 8); combine consecutive RUN steps",
+				"Image is 2.0 GB, over the 500 MB budget",
+				"Image contains node_modules and a full node toolchain; consider a multi-stage build that copies only the build output and production dependencies",
+				"`COPY dir:3f9a1c2b4d5e6f7a8b9c0d1e2f3a4b5c in .` adds 1.2 GB; check that .dockerignore excludes node_modules, .git and build output",
+			},
+		},
+		{
+			name: "python-apt-pip",
+			want: []string{
+				"Image is 1.1 GB, over the 500 MB budget",
+				"`RUN pip install -r requirements.txt` keeps the pip cache (350 MB); add --no-cache-dir",
+				"`RUN apt-get update && apt-get install -y build-essential…` keeps the apt package lists (270 MB); remove /var/lib/apt/lists/* in the same RUN",
+			},
+		},
+		{
+			// Over the budget, but nothing in its history to point at
+			name: "python-slim",
+			want: []string{"Image is 600 MB, over the 500 MB budget"},
+		},
+		{
+			name: "go-toolchain",
+			want: []string{
+				"Image is 870 MB, over the 500 MB budget",
+				"Image contains the full Go toolchain; consider a multi-stage build that copies only the binary into a distroless or alpine image",
+			},
+		},
+		{name: "go-distroless"},
+	}
+	for _, tt := range tests {
+		if got := adviseImage(imageFixture(t, tt.name), s.imageBudget); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: advice\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+
+	// Without a budget nothing is checked
+	if got := adviseImage(imageFixture(t, "node-single-stage"), imageBudget{}); got != nil {
+		t.Errorf("no budget: %q", got)
+	}
+}
+
+func TestInstruction(t *testing.T) {
+	for createdBy, want := range map[string]string{
+		"/bin/sh -c #(nop)  ENV NODE_VERSION=18.19.0":               "ENV NODE_VERSION=18.19.0",
+		"/bin/sh -c npm ci":                                         "RUN npm ci",
+		"|2 VERSION=1.4.2 TARGET=prod /bin/sh -c make $TARGET":      "RUN make $TARGET",
+		"RUN /bin/sh -c pip install -r requirements.txt # buildkit": "RUN pip install -r requirements.txt",
+		"COPY . . # buildkit":                                       "COPY . .",
+	} {
+		if got := instruction(createdBy); got != want {
+			t.Errorf("instruction(%q) = %q, want %q", createdBy, got, want)
+		}
+	}
+}
+
+func TestImageTooLargeError(t *testing.T) {
+	var err error = &ImageTooLargeError{Size: 5 << 30, Max: 4 << 30}
+	var tooLarge *ImageTooLargeError
+	if !errors.As(err, &tooLarge) || err.Error() != "image is 5.0 GB, over the 4.0 GB limit for this project" {
+		t.Errorf("got %v", err)
+	}
+}
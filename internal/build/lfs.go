@@ -0,0 +1,291 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	lfsMaxPointerSize = 1024 // Pointer files are tiny, anything larger holds real content
+	lfsBatchSize      = 100  // Objects asked for per batch request
+	lfsMediaType      = "application/vnd.git-lfs+json"
+)
+
+// ErrLFSNotSupported fails builds of repositories using Git LFS on instances
+// with GIT_LFS disabled
+var ErrLFSNotSupported = errors.New("the repository stores files in Git LFS, which this platform has disabled (GIT_LFS=false): commit the files to Git or ask an admin to enable LFS")
+
+var lfsOIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var lfsClient = &http.Client{Timeout: 10 * time.Minute}
+
+// SetGitLFS enables or disables fetching Git LFS files during clones; with it
+// disabled, repositories using LFS fail with ErrLFSNotSupported
+func (s *Service) SetGitLFS(enabled bool) {
+	s.lfsDisabled = !enabled
+}
+
+// lfsPointer is a file checked out as a Git LFS pointer
+type lfsPointer struct {
+	Path string // In the work tree
+	OID  string // SHA-256 of the content
+	Size int64
+}
+
+// usesLFS reports whether any .gitattributes of the work tree in dir routes
+// files through the LFS filter
+func usesLFS(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if outsideWorktree(dir, path, d) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == ".gitattributes" {
+			if data, err := os.ReadFile(path); err == nil && bytes.Contains(data, []byte("filter=lfs")) {
+				found = true
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// outsideWorktree reports whether the directory path, met while walking the
+// work tree in dir, is .git or the work tree of a submodule
+func outsideWorktree(dir, path string, d fs.DirEntry) bool {
+	return d.Name() == ".git" || (path != dir && fileExists(filepath.Join(path, ".git")))
+}
+
+// fetchLFS replaces the LFS pointers checked out in dir, the work tree of
+// repoURL, with their content, downloaded through the LFS batch API with
+// auth. Submodules are left alone: their objects live on their own servers.
+func fetchLFS(ctx context.Context, dir, repoURL string, auth transport.AuthMethod, progress io.Writer) error {
+	pointers, err := findLFSPointers(dir)
+	if err != nil || len(pointers) == 0 {
+		return err
+	}
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return err
+	}
+	if endpoint.Protocol != "http" && endpoint.Protocol != "https" {
+		return fmt.Errorf("Git LFS files can only be fetched over HTTPS: clone %s with an HTTPS URL and a clone token", repoURL)
+	}
+	lfsURL := strings.TrimSuffix(repoURL, "/")
+	if !strings.HasSuffix(lfsURL, ".git") {
+		lfsURL += ".git"
+	}
+	lfsURL += "/info/lfs/objects/batch"
+	basic, _ := auth.(*githttp.BasicAuth)
+
+	fmt.Fprintf(progress, "Fetching %d Git LFS files\n", len(pointers))
+	for start := 0; start < len(pointers); start += lfsBatchSize {
+		batch := pointers[start:min(start+lfsBatchSize, len(pointers))]
+		if err := fetchLFSBatch(ctx, lfsURL, basic, batch); err != nil {
+			return fmt.Errorf("failed to fetch Git LFS files: %w", err)
+		}
+	}
+	return nil
+}
+
+// findLFSPointers lists the pointer files in dir, skipping .git and
+// submodule work trees
+func findLFSPointers(dir string) ([]lfsPointer, error) {
+	var pointers []lfsPointer
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if outsideWorktree(dir, path, d) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > lfsMaxPointerSize {
+			return err
+		}
+		if pointer, ok := readLFSPointer(path); ok {
+			pointers = append(pointers, pointer)
+		}
+		return nil
+	})
+	return pointers, err
+}
+
+// readLFSPointer parses the pointer file at path
+func readLFSPointer(path string) (lfsPointer, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return lfsPointer{}, false
+	}
+	defer file.Close()
+
+	pointer := lfsPointer{Path: path, Size: -1}
+	scanner := bufio.NewScanner(file)
+	for first := true; scanner.Scan(); first = false {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch {
+		case first && scanner.Text() != lfsPointerVersion:
+			return lfsPointer{}, false
+		case key == "oid" && strings.HasPrefix(value, "sha256:"):
+			pointer.OID = strings.TrimPrefix(value, "sha256:")
+		case key == "size":
+			pointer.Size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return pointer, lfsOIDPattern.MatchString(pointer.OID) && pointer.Size >= 0
+}
+
+type lfsObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions struct {
+		Download *struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		} `json:"download"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// fetchLFSBatch asks the LFS server where to download pointers' objects from
+// and writes each over its pointer, checking its size and hash
+func fetchLFSBatch(ctx context.Context, batchURL string, auth *githttp.BasicAuth, pointers []lfsPointer) error {
+	objects := make([]map[string]interface{}, len(pointers))
+	for i, p := range pointers {
+		objects[i] = map[string]interface{}{"oid": p.OID, "size": p.Size}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"operation": "download",
+		"transfers": []string{"basic"},
+		"objects":   objects,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, batchURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	if auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := lfsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("the LFS server refused access (%s): set a clone token that can read the repository", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("LFS batch request failed: %s", resp.Status)
+	}
+	var result struct {
+		Objects []lfsObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid LFS batch response: %w", err)
+	}
+
+	byOID := make(map[string]lfsObject, len(result.Objects))
+	for _, object := range result.Objects {
+		byOID[object.OID] = object
+	}
+	for _, p := range pointers {
+		object, ok := byOID[p.OID]
+		switch {
+		case !ok:
+			return fmt.Errorf("the LFS server didn't return %s", p.Path)
+		case object.Error != nil:
+			return fmt.Errorf("%s: %s (%d)", p.Path, object.Error.Message, object.Error.Code)
+		case object.Actions.Download == nil:
+			return fmt.Errorf("%s: the LFS server gave no download link", p.Path)
+		}
+		if err := downloadLFSObject(ctx, object.Actions.Download.Href, object.Actions.Download.Header, p); err != nil {
+			return fmt.Errorf("%s: %w", p.Path, err)
+		}
+	}
+	return nil
+}
+
+// downloadLFSObject writes the object at href over pointer's file, once its
+// content checks out
+func downloadLFSObject(ctx context.Context, href string, header map[string]string, pointer lfsPointer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, href, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := lfsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(pointer.Path), ".lfs-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, pointer.Size+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != pointer.Size || hex.EncodeToString(hash.Sum(nil)) != pointer.OID {
+		return fmt.Errorf("downloaded content doesn't match its pointer")
+	}
+	info, err := os.Stat(pointer.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), pointer.Path)
+}
@@ -0,0 +1,201 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxDockerfileSize bounds a Dockerfile override
+const MaxDockerfileSize = 64 << 10
+
+var dockerfileInstructions = map[string]bool{
+	"FROM": true, "RUN": true, "CMD": true, "LABEL": true, "MAINTAINER": true, "EXPOSE": true,
+	"ENV": true, "ADD": true, "COPY": true, "ENTRYPOINT": true, "VOLUME": true, "USER": true,
+	"WORKDIR": true, "ARG": true, "ONBUILD": true, "STOPSIGNAL": true, "HEALTHCHECK": true, "SHELL": true,
+}
+
+var (
+	heredocPattern      = regexp.MustCompile(`<<-?["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+	exposePattern       = regexp.MustCompile(`^(\d+)(-\d+)?(/(tcp|udp|sctp))?$`)
+	escapeDirective     = regexp.MustCompile(`^#\s*escape\s*=\s*(\S)\s*$`)
+	stageAliasPattern   = regexp.MustCompile(`(?i)\s+AS\s+(\S+)\s*$`)
+	fromPlatformPattern = regexp.MustCompile(`^--platform=\S+\s+`)
+)
+
+// dockerfileInstruction is one instruction, continuation lines joined
+type dockerfileInstruction struct {
+	Line int    // Where it starts, from 1
+	Name string // Upper case
+	Args string
+}
+
+// LintDockerfile checks a Dockerfile the way docker build parses it. Parse
+// errors (unknown instructions, missing FROM, malformed JSON forms) make it
+// fail, all listed with their line; questionable but valid constructs are
+// returned as warnings.
+func LintDockerfile(content string) ([]string, error) {
+	instructions, problems := parseDockerfile(content)
+
+	var warnings []string
+	seenFrom, cmds, entrypoints := false, 0, 0
+	stages := map[string]bool{}
+	for _, in := range instructions {
+		if !seenFrom && dockerfileInstructions[in.Name] && in.Name != "FROM" && in.Name != "ARG" {
+			problems = append(problems, fmt.Sprintf("line %d: %s before the first FROM, only ARG may come first", in.Line, in.Name))
+		}
+		if strings.TrimSpace(in.Args) == "" {
+			problems = append(problems, fmt.Sprintf("line %d: %s needs arguments", in.Line, in.Name))
+			continue
+		}
+
+		switch in.Name {
+		case "FROM":
+			seenFrom = true
+			image := fromPlatformPattern.ReplaceAllString(in.Args, "")
+			if m := stageAliasPattern.FindStringSubmatch(image); m != nil {
+				stages[strings.ToLower(m[1])] = true
+				image = strings.TrimSpace(image[:len(image)-len(m[0])])
+			}
+			if warning := imageTagWarning(image, stages); warning != "" {
+				warnings = append(warnings, fmt.Sprintf("line %d: %s", in.Line, warning))
+			}
+		case "CMD":
+			cmds++
+		case "ENTRYPOINT":
+			entrypoints++
+		case "MAINTAINER":
+			warnings = append(warnings, fmt.Sprintf("line %d: MAINTAINER is deprecated, use LABEL org.opencontainers.image.authors", in.Line))
+		case "EXPOSE":
+			for _, port := range strings.Fields(in.Args) {
+				if !exposePattern.MatchString(port) && !strings.Contains(port, "$") {
+					problems = append(problems, fmt.Sprintf("line %d: EXPOSE %q is not a port", in.Line, port))
+				}
+			}
+		case "SHELL":
+			if !isJSONArray(in.Args) {
+				problems = append(problems, fmt.Sprintf("line %d: SHELL must be a JSON array, e.g. [\"/bin/sh\", \"-c\"]", in.Line))
+			}
+		}
+		if in.Name == "CMD" || in.Name == "ENTRYPOINT" || in.Name == "RUN" {
+			if args := strings.TrimSpace(in.Args); strings.HasPrefix(args, "[") && !isJSONArray(args) {
+				problems = append(problems, fmt.Sprintf("line %d: %s looks like a JSON array but isn't one: use double quotes, e.g. [\"npm\", \"start\"]", in.Line, in.Name))
+			}
+		}
+	}
+
+	if !seenFrom && len(problems) == 0 {
+		problems = append(problems, "no FROM instruction")
+	}
+	if len(problems) > 0 {
+		return warnings, fmt.Errorf("invalid Dockerfile: %s", strings.Join(problems, "; "))
+	}
+	if cmds+entrypoints == 0 {
+		warnings = append(warnings, "no CMD or ENTRYPOINT: the container runs the base image's default command")
+	}
+	if cmds > 1 {
+		warnings = append(warnings, "several CMD instructions: only the last one takes effect")
+	}
+	return warnings, nil
+}
+
+// parseDockerfile splits content into instructions, joining continuation
+// lines and skipping comments and heredoc bodies
+func parseDockerfile(content string) ([]dockerfileInstruction, []string) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	escape := `\`
+	if len(lines) > 0 {
+		if m := escapeDirective.FindStringSubmatch(lines[0]); m != nil && (m[1] == "`" || m[1] == `\`) {
+			escape = m[1]
+		}
+	}
+
+	var (
+		instructions []dockerfileInstruction
+		problems     []string
+		current      *dockerfileInstruction
+	)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "#") || (line == "" && current == nil) {
+			continue
+		}
+		if current == nil {
+			name, args, _ := strings.Cut(line, " ")
+			if tab, rest, ok := strings.Cut(name, "\t"); ok {
+				name, args = tab, rest+" "+args
+			}
+			current = &dockerfileInstruction{Line: i + 1, Name: strings.ToUpper(name)}
+			line = args
+		}
+		if strings.HasSuffix(line, escape) {
+			current.Args += strings.TrimSuffix(line, escape) + " "
+			continue
+		}
+		current.Args = strings.TrimSpace(current.Args + line)
+
+		// Heredocs (RUN <<EOF) run until their terminator
+		for _, m := range heredocPattern.FindAllStringSubmatch(current.Args, -1) {
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != m[1]; i++ {
+			}
+			if i >= len(lines) {
+				problems = append(problems, fmt.Sprintf("line %d: heredoc %s is never terminated", current.Line, m[1]))
+			}
+		}
+
+		if !dockerfileInstructions[current.Name] {
+			problems = append(problems, fmt.Sprintf("line %d: unknown instruction %s", current.Line, current.Name))
+		}
+		instructions = append(instructions, *current)
+		current = nil
+	}
+	if current != nil {
+		problems = append(problems, fmt.Sprintf("line %d: the file ends in a line continuation", current.Line))
+	}
+	return instructions, problems
+}
+
+// imageTagWarning advises against FROM images whose version can change
+// under the build
+func imageTagWarning(image string, stages map[string]bool) string {
+	if image == "scratch" || stages[strings.ToLower(image)] || strings.Contains(image, "$") || strings.Contains(image, "@sha256:") {
+		return ""
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		tag = name[i+1:]
+	}
+	switch tag {
+	case "":
+		return fmt.Sprintf("%s has no tag, builds get whatever latest is: pin a version", image)
+	case "latest":
+		return fmt.Sprintf("%s changes under your builds: pin a version", image)
+	}
+	return ""
+}
+
+// isJSONArray reports whether s is a JSON array of strings, the exec form
+func isJSONArray(s string) bool {
+	var values []string
+	return json.Unmarshal([]byte(s), &values) == nil
+}
+
+// exposedPort is the first port a Dockerfile EXPOSEs, 0 if none
+func exposedPort(content string) int {
+	instructions, _ := parseDockerfile(content)
+	for _, in := range instructions {
+		if in.Name != "EXPOSE" {
+			continue
+		}
+		for _, port := range strings.Fields(in.Args) {
+			if m := exposePattern.FindStringSubmatch(port); m != nil {
+				n, _ := strconv.Atoi(m[1])
+				return n
+			}
+		}
+	}
+	return 0
+}
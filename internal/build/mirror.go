@@ -0,0 +1,344 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// mirrorScheme is the URL scheme work trees are cloned from the cache with:
+// served in process, go-git's file transport would need the git binary
+const mirrorScheme = "mirror"
+
+// Ports left out of mirror paths, so a repository has one mirror however its
+// URL is spelled
+var defaultPorts = map[string]int{"ssh": 22, "http": 80, "https": 443}
+
+// Mirrors hold every branch and tag
+var mirrorRefSpecs = []gitconfig.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// cloneStats tells how a clone used the mirror cache
+type cloneStats struct {
+	CacheHit bool          // Cloned from an existing mirror
+	Fetch    time.Duration // Updating the mirror, 0 when it wasn't used
+}
+
+// mirrorCache keeps a bare mirror of each repository built on this host,
+// under dir/<host>/<path>.git, fetched before every build so clones copy from
+// disk and only what changed comes over the network. Credentials are never
+// stored: each fetch uses those of the project building, and a build whose
+// fetch fails doesn't use the mirror, so it can't read what it has no access
+// to. A lock file next to each mirror, flocked, serializes fetches and keeps
+// eviction away from mirrors in use.
+type mirrorCache struct {
+	dir      string
+	maxBytes int64 // 0 = unbounded
+	evicting atomic.Bool
+}
+
+// SetCloneCache makes clones go through bare mirrors in dir, evicting the
+// least recently used ones above maxMB (0 = unbounded). An empty dir, or one
+// that can't be created, disables the cache.
+func (s *Service) SetCloneCache(dir string, maxMB int) {
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("⚠️  Clone cache disabled: %v", err)
+		return
+	}
+	s.mirrors = &mirrorCache{dir: dir, maxBytes: int64(maxMB) << 20}
+	client.InstallProtocol(mirrorScheme, server.NewClient(server.NewFilesystemLoader(osfs.New(dir))))
+	log.Printf("✅ Clone cache in %s", dir)
+}
+
+// mirrorPath is where repoURL's mirror lives, relative to the cache; only
+// repositories on a server are cached
+func mirrorPath(repoURL string) (string, error) {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return "", err
+	}
+	if endpoint.Host == "" {
+		return "", fmt.Errorf("%s is not on a server", repoURL)
+	}
+	// Cleaning a rooted path drops any .. leading out of the cache
+	repoPath := strings.TrimSuffix(strings.Trim(path.Clean("/"+endpoint.Path), "/"), ".git")
+	if repoPath == "" {
+		return "", fmt.Errorf("%s names no repository", repoURL)
+	}
+	host := strings.ToLower(endpoint.Host)
+	if endpoint.Port != 0 && endpoint.Port != defaultPorts[endpoint.Protocol] {
+		host += "_" + strconv.Itoa(endpoint.Port)
+	}
+	return path.Join(host, repoPath+".git"), nil
+}
+
+// mirror is a mirror fetched for a build, read-locked until released
+type mirror struct {
+	relPath string
+	lock    *os.File
+}
+
+// URL clones the mirror through the in-process transport
+func (m *mirror) URL() string {
+	return mirrorScheme + ":///" + m.relPath
+}
+
+// release lets the mirror be fetched into or evicted again
+func (m *mirror) release() {
+	syscall.Flock(int(m.lock.Fd()), syscall.LOCK_UN)
+	m.lock.Close()
+}
+
+// update fetches repoURL into its mirror with auth, creating it if needed and
+// recreating it if it turns out corrupt, and returns it read-locked. Errors
+// mean the build should clone from the network.
+func (c *mirrorCache) update(ctx context.Context, repoURL string, auth transport.AuthMethod, progress io.Writer, stats *cloneStats) (*mirror, error) {
+	relPath, err := mirrorPath(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(c.dir, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(dir+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// Builds of the same repository wait for each other's fetch
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	m := &mirror{relPath: relPath, lock: lock}
+
+	started := time.Now()
+	repo, err := git.PlainOpen(dir)
+	stats.CacheHit = err == nil
+	if err != nil && !errors.Is(err, git.ErrRepositoryNotExists) {
+		log.Printf("⚠️  Mirror of %s is corrupt, cloning it again: %v", repoURL, err)
+		os.RemoveAll(dir)
+	}
+	if !stats.CacheHit {
+		repo, err = initMirror(dir, repoURL)
+	}
+	if err == nil {
+		err = fetchMirror(ctx, repo, repoURL, auth, progress)
+	}
+	var corrupt *corruptMirrorError
+	if errors.As(err, &corrupt) && ctx.Err() == nil {
+		log.Printf("⚠️  Mirror of %s is corrupt, cloning it again: %v", repoURL, err)
+		stats.CacheHit = false
+		os.RemoveAll(dir)
+		if repo, err = initMirror(dir, repoURL); err == nil {
+			err = fetchMirror(ctx, repo, repoURL, auth, progress)
+		}
+	}
+	stats.Fetch = time.Since(started)
+	if err != nil {
+		m.release()
+		return nil, err
+	}
+
+	now := time.Now()
+	os.Chtimes(lock.Name(), now, now) // Last used, for eviction
+	syscall.Flock(int(lock.Fd()), syscall.LOCK_SH)
+	return m, nil
+}
+
+// discard removes a mirror a clone failed from, the next build recreates it
+func (c *mirrorCache) discard(m *mirror) {
+	syscall.Flock(int(m.lock.Fd()), syscall.LOCK_EX)
+	os.RemoveAll(filepath.Join(c.dir, filepath.FromSlash(m.relPath)))
+	m.release()
+}
+
+func initMirror(dir, repoURL string) (*git.Repository, error) {
+	repo, err := git.PlainInit(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{repoURL}, Fetch: mirrorRefSpecs})
+	return repo, err
+}
+
+// setOrigin points a work tree cloned from a mirror back at the repository
+func setOrigin(repo *git.Repository, repoURL string) error {
+	if err := repo.DeleteRemote("origin"); err != nil {
+		return err
+	}
+	_, err := repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name:  "origin",
+		URLs:  []string{repoURL},
+		Fetch: []gitconfig.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+	})
+	return err
+}
+
+// corruptMirrorError is a fetch failing on the mirror's side: the remote
+// answered, so the mirror is to blame
+type corruptMirrorError struct {
+	err error
+}
+
+func (e *corruptMirrorError) Error() string { return e.err.Error() }
+func (e *corruptMirrorError) Unwrap() error { return e.err }
+
+// fetchMirror brings the mirror up to date with repoURL, HEAD included. The
+// remote's refs are listed first: that checks auth can read the repository,
+// tells its default branch, and a fetch failing after it points at the mirror.
+func fetchMirror(ctx context.Context, repo *git.Repository, repoURL string, auth transport.AuthMethod, progress io.Writer) error {
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return &corruptMirrorError{err}
+	}
+	// The project's URL may have changed scheme, e.g. to SSH with a deploy key
+	if urls := remote.Config().URLs; len(urls) != 1 || urls[0] != repoURL {
+		if err := repo.DeleteRemote("origin"); err != nil {
+			return &corruptMirrorError{err}
+		}
+		if remote, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{repoURL}, Fetch: mirrorRefSpecs}); err != nil {
+			return &corruptMirrorError{err}
+		}
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return err
+	}
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   mirrorRefSpecs,
+		Auth:       auth,
+		Progress:   progress,
+		Tags:       git.NoTags, // Already in the refspecs
+		Force:      true,
+		Prune:      true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &corruptMirrorError{err}
+	}
+
+	if head := defaultBranch(refs); head != "" {
+		if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head)); err != nil {
+			return &corruptMirrorError{err}
+		}
+	}
+	return nil
+}
+
+// defaultBranch is the branch a remote's HEAD points at, "" if unknown
+func defaultBranch(refs []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		}
+	}
+	if head == nil {
+		return ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+	// Servers not advertising symrefs: the branch at HEAD's commit
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && ref.Hash() == head.Hash() {
+			return ref.Name()
+		}
+	}
+	return ""
+}
+
+// evict removes the least recently used mirrors until the cache fits its
+// budget, skipping those in use. One eviction runs at a time.
+func (c *mirrorCache) evict() {
+	if c.maxBytes <= 0 || !c.evicting.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.evicting.Store(false)
+
+	type cached struct {
+		dir      string
+		size     int64
+		lastUsed time.Time
+	}
+	var mirrors []cached
+	var total int64
+	filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".git.lock") {
+			return nil
+		}
+		dir := strings.TrimSuffix(p, ".lock")
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size := dirSize(dir)
+		total += size
+		mirrors = append(mirrors, cached{dir: dir, size: size, lastUsed: info.ModTime()})
+		return nil
+	})
+	sort.Slice(mirrors, func(i, j int) bool { return mirrors[i].lastUsed.Before(mirrors[j].lastUsed) })
+
+	for _, m := range mirrors {
+		if total <= c.maxBytes {
+			return
+		}
+		if m.size == 0 {
+			continue
+		}
+		lock, err := os.OpenFile(m.dir+".lock", os.O_RDWR, 0600)
+		if err != nil {
+			continue
+		}
+		// Lock files stay: removing one would let two builds lock different files
+		if syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil {
+			if err := os.RemoveAll(m.dir); err == nil {
+				total -= m.size
+				log.Printf("🧹 Evicted mirror %s (%s) from the clone cache", strings.TrimPrefix(m.dir, c.dir+string(filepath.Separator)), formatSize(m.size))
+			}
+			syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		}
+		lock.Close()
+	}
+}
+
+// dirSize is the size of the files under dir, 0 if it doesn't exist
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
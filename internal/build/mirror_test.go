@@ -0,0 +1,292 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/models"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// commit pushes a commit adding file to the main branch of repository name
+// on the server, returning it
+func (s *gitServer) commit(t *testing.T, name, file, content string) string {
+	t.Helper()
+	work := t.TempDir()
+	runGit(t, work, "clone", "-q", filepath.Join(s.root, name+".git"), ".")
+	if err := os.WriteFile(filepath.Join(work, file), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", ".")
+	runGit(t, work, "commit", "-q", "-m", "Add "+file)
+	runGit(t, work, "push", "-q", "origin", "HEAD:main")
+	return runGit(t, work, "rev-parse", "HEAD")
+}
+
+// cachedClone clones the project's repository at commitSHA as a build does
+func cachedClone(t *testing.T, s *Service, project *models.Project, commitSHA string) (string, cloneStats, error) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "repo")
+	var progress bytes.Buffer
+	stats, err := s.cloneRepo(context.Background(), project, dir, "refs/heads/main", commitSHA, &progress)
+	return dir, stats, err
+}
+
+// mirrorDir is where the cache in dir keeps the mirror of repoURL
+func mirrorDir(t *testing.T, dir, repoURL string) string {
+	t.Helper()
+	relPath, err := mirrorPath(repoURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, filepath.FromSlash(relPath))
+}
+
+func TestMirrorPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/acme/app.git", "github.com/acme/app.git"},
+		{"https://GitHub.com/acme/app", "github.com/acme/app.git"},
+		{"https://github.com:443/acme/app.git", "github.com/acme/app.git"},
+		{"git@github.com:acme/app.git", "github.com/acme/app.git"},
+		{"ssh://git@gitlab.example.com:2222/group/sub/app.git", "gitlab.example.com_2222/group/sub/app.git"},
+		{"https://gitlab.example.com/../../etc/app.git", "gitlab.example.com/etc/app.git"},
+	}
+	for _, tt := range tests {
+		if got, err := mirrorPath(tt.url); err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.url, got, err, tt.want)
+		}
+	}
+	for _, url := range []string{"/srv/git/app.git", "file:///srv/git/app.git", "https://github.com/"} {
+		if got, err := mirrorPath(url); err == nil {
+			t.Errorf("%s: cached as %s", url, got)
+		}
+	}
+}
+
+// A repository built again is cloned from its mirror, fetched with only
+// what changed, and the work tree points at the repository, not the mirror
+func TestCloneCacheReuse(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	server.private["app"] = true
+	appURL, first := server.repository(t, "app", map[string]string{"main.go": "package main\n"}, nil)
+	project := privateProject(t, appURL)
+	cache := t.TempDir()
+	s := &Service{}
+	s.SetCloneCache(cache, 0)
+
+	dir, stats, err := cachedClone(t, s, project, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CacheHit || stats.Fetch <= 0 {
+		t.Errorf("first clone: %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(mirrorDir(t, cache, appURL), "HEAD")); err != nil {
+		t.Errorf("no mirror: %v", err)
+	}
+
+	second := server.commit(t, "app", "handler.go", "package main\n\nfunc handle() {}\n")
+	dir, stats, err = cachedClone(t, s, project, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.CacheHit {
+		t.Errorf("second clone: %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "handler.go")); err != nil {
+		t.Errorf("new commit not checked out: %v", err)
+	}
+	if head := runGit(t, dir, "rev-parse", "HEAD"); head != second {
+		t.Errorf("checked out %s, want %s", head, second)
+	}
+	if origin := runGit(t, dir, "remote", "get-url", "origin"); origin != appURL {
+		t.Errorf("origin is %s", origin)
+	}
+	// The mirror holds no credentials
+	config, _ := os.ReadFile(filepath.Join(mirrorDir(t, cache, appURL), "config"))
+	if strings.Contains(string(config), cloneToken) {
+		t.Errorf("token in the mirror's config:\n%s", config)
+	}
+}
+
+// Each fetch uses the credentials of the project building: without them,
+// what the mirror holds stays out of reach, and a renewed token works
+func TestCloneCacheAuth(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	server.private["app"] = true
+	appURL, head := server.repository(t, "app", map[string]string{"main.go": "package main\n"}, nil)
+	s := &Service{}
+	s.SetCloneCache(t.TempDir(), 0)
+	if _, _, err := cachedClone(t, s, privateProject(t, appURL), head); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := cachedClone(t, s, &models.Project{RepoURL: appURL}, head); err == nil {
+		t.Fatal("cloned a private repository from its mirror without credentials")
+	}
+	// A token changed, e.g. rotated, fetches into the same mirror
+	if _, stats, err := cachedClone(t, s, privateProject(t, appURL), head); err != nil || !stats.CacheHit {
+		t.Errorf("renewed token: %+v, %v", stats, err)
+	}
+}
+
+// A corrupt mirror is cloned again, or skipped for the network, and the
+// build goes on
+func TestCloneCacheCorruption(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	appURL, head := server.repository(t, "app", map[string]string{"main.go": "package main\n"}, nil)
+	project := &models.Project{RepoURL: appURL}
+
+	corruptions := map[string]func(mirror string){
+		"config": func(mirror string) {
+			os.WriteFile(filepath.Join(mirror, "config"), []byte("[core\n\tbare = maybe"), 0644)
+		},
+		"objects": func(mirror string) {
+			filepath.WalkDir(filepath.Join(mirror, "objects"), func(p string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					os.WriteFile(p, []byte("garbage"), 0644)
+				}
+				return nil
+			})
+		},
+		"removed": func(mirror string) {
+			os.RemoveAll(filepath.Join(mirror, "refs"))
+			os.Remove(filepath.Join(mirror, "HEAD"))
+		},
+	}
+	for name, corrupt := range corruptions {
+		cache := t.TempDir()
+		s := &Service{}
+		s.SetCloneCache(cache, 0)
+		if _, _, err := cachedClone(t, s, project, head); err != nil {
+			t.Fatal(err)
+		}
+		corrupt(mirrorDir(t, cache, appURL))
+
+		dir, stats, err := cachedClone(t, s, project, head)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if stats.CacheHit {
+			t.Errorf("%s: cloned from the corrupt mirror", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		// Repaired, or recreated by this build or the next
+		cachedClone(t, s, project, head)
+		if _, stats, err := cachedClone(t, s, project, head); err != nil || !stats.CacheHit {
+			t.Errorf("%s: mirror not repaired: %+v, %v", name, stats, err)
+		}
+	}
+}
+
+// Builds of the same repository at once share its mirror, one fetching at a
+// time
+func TestCloneCacheConcurrent(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	appURL, head := server.repository(t, "app", map[string]string{"main.go": "package main\n"}, nil)
+	s := &Service{}
+	s.SetCloneCache(t.TempDir(), 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dir := filepath.Join(t.TempDir(), "repo")
+			if _, err := s.cloneRepo(context.Background(), &models.Project{RepoURL: appURL}, dir, "refs/heads/main", head, &bytes.Buffer{}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// Above its budget, the cache evicts the least recently used mirrors not in
+// use
+func TestCloneCacheEviction(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	cache := t.TempDir()
+	s := &Service{}
+	s.SetCloneCache(cache, 0)
+	var mirrors []string
+	for i, name := range []string{"old", "busy", "recent"} {
+		url, head := server.repository(t, name, map[string]string{"README": fmt.Sprintf("%s\n", strings.Repeat(name, 1000))}, nil)
+		if _, _, err := cachedClone(t, s, &models.Project{RepoURL: url}, head); err != nil {
+			t.Fatal(err)
+		}
+		mirror := mirrorDir(t, cache, url)
+		lastUsed := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(mirror+".lock", lastUsed, lastUsed)
+		mirrors = append(mirrors, mirror)
+	}
+	exists := func(dir string) bool {
+		_, err := os.Stat(dir)
+		return err == nil
+	}
+
+	// A build holds the mirror of busy
+	busy, err := os.Open(mirrors[1] + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	if err := syscall.Flock(int(busy.Fd()), syscall.LOCK_SH); err != nil {
+		t.Fatal(err)
+	}
+	m := &mirror{lock: busy}
+
+	// Room for two mirrors: old goes
+	(&mirrorCache{dir: cache, maxBytes: dirSize(mirrors[1]) + dirSize(mirrors[2]) + 1}).evict()
+	if exists(mirrors[0]) || !exists(mirrors[1]) || !exists(mirrors[2]) {
+		t.Errorf("old %v, busy %v, recent %v left", exists(mirrors[0]), exists(mirrors[1]), exists(mirrors[2]))
+	}
+	if !exists(mirrors[0] + ".lock") {
+		t.Errorf("lock file of an evicted mirror removed")
+	}
+	// Room for one: busy stays however old while in use
+	(&mirrorCache{dir: cache, maxBytes: dirSize(mirrors[2]) + 1}).evict()
+	if !exists(mirrors[1]) || exists(mirrors[2]) {
+		t.Errorf("busy %v, recent %v left while busy was in use", exists(mirrors[1]), exists(mirrors[2]))
+	}
+	m.release()
+	restored, head := server.repository(t, "restored", map[string]string{"README": "restored\n"}, nil)
+	if _, _, err := cachedClone(t, s, &models.Project{RepoURL: restored}, head); err != nil {
+		t.Fatal(err)
+	}
+	mirrors[2] = mirrorDir(t, cache, restored)
+
+	(&mirrorCache{dir: cache, maxBytes: dirSize(mirrors[2]) + 1}).evict()
+	if exists(mirrors[1]) || !exists(mirrors[2]) {
+		t.Errorf("busy %v, restored %v left once released", exists(mirrors[1]), exists(mirrors[2]))
+	}
+
+	// Unbounded, nothing goes
+	(&mirrorCache{dir: cache}).evict()
+	if !exists(mirrors[2]) {
+		t.Errorf("unbounded cache evicted")
+	}
+}
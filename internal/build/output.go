@@ -0,0 +1,96 @@
+package build
+
+import (
+	"bytes"
+	"deploy-platform/internal/buildlogs"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+)
+
+// maxOutputLine bounds a line of daemon output buffered until its newline
+const maxOutputLine = 1 << 20
+
+// SetLogStreams sets where the output of builds is written while they run.
+// Without streams builds only log the platform's messages.
+func (s *Service) SetLogStreams(streams *buildlogs.Streams) {
+	s.logStreams = streams
+}
+
+// finishLogs compacts the output of a finished build into its logs
+func (s *Service) finishLogs(buildID uint) {
+	if err := s.logStreams.Finish(buildID); err != nil {
+		log.Printf("⚠️  Failed to store the output of build %d: %v", buildID, err)
+	}
+}
+
+// daemonOutput turns the Docker daemon's JSON build messages into the text
+// docker build prints. Progress bars are left out.
+type daemonOutput struct {
+	w       io.Writer
+	partial []byte // Start of a message not ended yet
+	failure string // First error the daemon reported
+}
+
+// err is the error the daemon failed the build with, once all its output
+// was written. The daemon reports a failed step in the output rather than
+// failing the request, so the build would otherwise go on without an image.
+func (o *daemonOutput) err() error {
+	if len(o.partial) > 0 {
+		o.message(o.partial)
+		o.partial = o.partial[:0]
+	}
+	if o.failure == "" {
+		return nil
+	}
+	return fmt.Errorf("docker build failed: %s", o.failure)
+}
+
+func (o *daemonOutput) Write(p []byte) (int, error) {
+	o.partial = append(o.partial, p...)
+	for {
+		i := bytes.IndexByte(o.partial, '\n')
+		if i < 0 {
+			break
+		}
+		o.message(o.partial[:i])
+		o.partial = append(o.partial[:0], o.partial[i+1:]...)
+	}
+	if len(o.partial) > maxOutputLine {
+		o.message(o.partial)
+		o.partial = o.partial[:0]
+	}
+	return len(p), nil
+}
+
+func (o *daemonOutput) message(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	var message struct {
+		Stream   string `json:"stream"`
+		Status   string `json:"status"`
+		ID       string `json:"id"`
+		Progress string `json:"progress"`
+		Error    string `json:"error"`
+	}
+	if json.Unmarshal(line, &message) != nil {
+		fmt.Fprintf(o.w, "%s\n", line)
+		return
+	}
+	switch {
+	case message.Error != "":
+		if o.failure == "" {
+			o.failure = message.Error
+		}
+		fmt.Fprintf(o.w, "ERROR: %s\n", message.Error)
+	case message.Stream != "":
+		io.WriteString(o.w, message.Stream)
+	case message.Status != "" && message.Progress == "" && message.ID != "":
+		fmt.Fprintf(o.w, "%s: %s\n", message.ID, message.Status)
+	case message.Status != "" && message.Progress == "":
+		fmt.Fprintf(o.w, "%s\n", message.Status)
+	}
+}
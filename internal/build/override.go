@@ -0,0 +1,158 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Where the Dockerfile of a build comes from
+const (
+	DockerfileSourceRepository = "repository" // The repository's own Dockerfile
+	DockerfileSourceGenerated  = "generated"  // Generated by the platform
+	DockerfileSourceOverride   = "override"   // The project's Dockerfile override
+)
+
+const maxGeneratedDockerfiles = 256 // Cached generations, across projects
+
+// GeneratedDockerfile is the Dockerfile the platform would build a commit
+// of a project with, were the project to have no override
+type GeneratedDockerfile struct {
+	CommitSHA   string     `json:"commit_sha"`
+	Source      string     `json:"source"` // DockerfileSourceRepository or DockerfileSourceGenerated
+	Dockerfile  string     `json:"dockerfile"`
+	Detection   *Detection `json:"detection"`
+	GeneratedAt time.Time  `json:"generated_at"`
+}
+
+// generatedCache keeps generations per project, commit and build commands:
+// the same inputs always generate the same Dockerfile
+type generatedCache struct {
+	mu      sync.Mutex
+	entries map[string]*GeneratedDockerfile
+}
+
+func (c *generatedCache) get(key string) *GeneratedDockerfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+func (c *generatedCache) put(key string, generated *GeneratedDockerfile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*GeneratedDockerfile)
+	}
+	// Evicting any entry is fine: old commits are rarely asked for again
+	for k := range c.entries {
+		if len(c.entries) < maxGeneratedDockerfiles {
+			break
+		}
+		delete(c.entries, k)
+	}
+	c.entries[key] = generated
+}
+
+// GenerateDockerfile runs detection against the latest commit of the
+// project's branch and returns the Dockerfile it picks, ignoring the
+// project's override. Results are cached per commit and build commands.
+func (s *Service) GenerateDockerfile(ctx context.Context, project *models.Project) (*GeneratedDockerfile, error) {
+	sha, err := remoteHead(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	var commands *models.BuildCommands
+	if project.BuildCommands.Set() {
+		commands = &project.BuildCommands
+	}
+	key := fmt.Sprintf("%d:%s:%+v:%s", project.ID, sha, project.BuildCommands, project.RuntimeVersion)
+	if generated := s.generated.get(key); generated != nil {
+		return generated, nil
+	}
+
+	dir, err := os.MkdirTemp("", "dockerfile-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	var ref plumbing.ReferenceName
+	if project.Branch != "" {
+		ref = plumbing.NewBranchReferenceName(project.Branch)
+	}
+	if _, err := s.cloneRepo(ctx, project, dir, ref, sha, io.Discard); err != nil {
+		return nil, err
+	}
+	detection, err := s.detectAndCreateDockerfile(dir, commands, project.RuntimeVersion, nil)
+	if err != nil {
+		return nil, err
+	}
+	dockerfile, err := os.ReadFile(filepath.Join(dir, detection.ContextDir, detection.Dockerfile))
+	if err != nil {
+		return nil, err
+	}
+
+	generated := &GeneratedDockerfile{
+		CommitSHA:   sha,
+		Source:      DockerfileSourceGenerated,
+		Dockerfile:  string(dockerfile),
+		Detection:   detection,
+		GeneratedAt: time.Now(),
+	}
+	if detection.Type == "dockerfile" {
+		generated.Source = DockerfileSourceRepository
+	}
+	s.generated.put(key, generated)
+	return generated, nil
+}
+
+// remoteHead returns the commit the project's branch (the default branch
+// when unset) points to, without cloning
+func remoteHead(ctx context.Context, project *models.Project) (string, error) {
+	auth, err := cloneAuth(project)
+	if err != nil {
+		return "", err
+	}
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{project.RepoURL}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", fmt.Errorf("failed to list the repository's branches: %w", explainCloneError(project, auth, err))
+	}
+
+	want := plumbing.HEAD
+	if project.Branch != "" {
+		want = plumbing.NewBranchReferenceName(project.Branch)
+	}
+	byName := make(map[plumbing.ReferenceName]*plumbing.Reference, len(refs))
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+	ref := byName[want]
+	if ref != nil && ref.Type() == plumbing.SymbolicReference {
+		ref = byName[ref.Target()]
+	}
+	if ref == nil {
+		return "", fmt.Errorf("branch %s does not exist in the repository", want.Short())
+	}
+	return ref.Hash().String(), nil
+}
+
+// dockerfileUsed reads the Dockerfile detection picked in repoPath, for the
+// build's record
+func dockerfileUsed(repoPath string, detection *Detection) string {
+	dockerfile, err := os.ReadFile(filepath.Join(repoPath, detection.ContextDir, detection.Dockerfile))
+	if err != nil {
+		return ""
+	}
+	return string(dockerfile)
+}
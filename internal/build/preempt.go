@@ -0,0 +1,68 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"errors"
+	"sync"
+)
+
+// ErrPreempted stops a build for a production deployment needing its slot;
+// the deployment goes back to the queue
+var ErrPreempted = errors.New("preempted by a production deployment")
+
+// ForProduction reports whether deployment rolls out to its project's
+// production resources. Its Project must be loaded.
+func ForProduction(deployment *models.Deployment) bool {
+	return resourceName(deployment) == kubernetes.ProjectResourceName(deployment.ProjectID)
+}
+
+// Preemption lets a worker pool take back the build slot of a running job.
+// The job can only be preempted while it holds the slot, cloning and
+// building its image: once the image is built nothing is saved by stopping
+// it, and its rollout must not be interrupted halfway.
+type Preemption struct {
+	mu        sync.Mutex
+	cancel    context.CancelCauseFunc
+	released  bool // The job gave its slot back, too late to preempt it
+	preempted bool
+}
+
+// NewPreemption creates the preemption of the job cancel cancels
+func NewPreemption(cancel context.CancelCauseFunc) *Preemption {
+	return &Preemption{cancel: cancel}
+}
+
+// Preempt cancels the job with ErrPreempted, unless it gave its build slot
+// back already. Reports whether it was cancelled.
+func (p *Preemption) Preempt() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.released {
+		return false
+	}
+	if !p.preempted {
+		p.preempted = true
+		p.cancel(ErrPreempted)
+	}
+	return true
+}
+
+// Preempted reports whether Preempt cancelled the job
+func (p *Preemption) Preempted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.preempted
+}
+
+// release records that the job gave its build slot back; a nil Preemption
+// is a job nobody can preempt
+func (p *Preemption) release() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.released = true
+}
@@ -0,0 +1,62 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/preview"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const previewLogHeader = "=== Preview provisioning ==="
+
+// SetPreviews sets the manager provisioning the preview environments of
+// branch and preview deployments
+func (s *Service) SetPreviews(previews *preview.Manager) {
+	s.previews = previews
+}
+
+// provisionPreview sets up the preview environment of a branch or preview
+// deployment of a project with a preview provisioner, applying its env
+// overrides to envVars. A failure marks the deployment
+// preview_provisioning_failed: it never rolls out with production's env.
+func (s *Service) provisionPreview(ctx context.Context, client *kubernetes.Client, deployment *models.Deployment, envVars map[string]string) error {
+	provisioner := deployment.Project.PreviewProvisioner
+	if provisioner == nil || kubernetes.EnvironmentOf(deployment) == kubernetes.EnvironmentProduction {
+		return nil
+	}
+	log.Printf("🧪 Deployment %d: provisioning preview environment %s", deployment.ID, deployment.K8sDeploymentName)
+
+	overrides, logs, err := s.previews.Provision(ctx, client, deployment, envVars)
+	command := provisioner.Type + " provisioner"
+	if provisioner.Type == models.PreviewProvisionerCommand {
+		command = strings.TrimSpace(provisioner.Command)
+	}
+	appendPhaseLogs(deployment.ID, previewLogHeader, command, logs, err, "Preview environment provisioned, overriding "+describeKeys(overrides))
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Printf("❌ Deployment %d: preview provisioning failed: %v", deployment.ID, err)
+		deployment.FailureCategory = models.FailurePreviewProvisioning
+		deployment.FailureDetail = "The project's preview provisioner failed, so the deployment was not rolled out rather than run with production's env: " + err.Error()
+		database.DB.Model(deployment).Select("failure_category", "failure_detail").Updates(deployment)
+		return err
+	}
+	for k, v := range overrides {
+		envVars[k] = v
+	}
+	return nil
+}
+
+// describeKeys lists the keys of env, sorted: their values may be secret
+func describeKeys(env map[string]string) string {
+	if len(env) == 0 {
+		return "no env vars"
+	}
+	return strings.Join(slices.Sorted(maps.Keys(env)), ", ")
+}
@@ -0,0 +1,328 @@
+package build
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"deploy-platform/internal/models"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statementType   = "https://in-toto.io/Statement/v1"
+	provenanceType  = "https://slsa.dev/provenance/v1"
+	dssePayloadType = "application/vnd.in-toto+json"
+)
+
+// The provenance record is an in-toto statement with a SLSA v1 provenance
+// predicate: the image as subject, what it was built from as resolved
+// dependencies, each pinned by digest
+type statement struct {
+	Type          string               `json:"_type"`
+	Subject       []resourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     provenance           `json:"predicate"`
+}
+
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type provenance struct {
+	BuildDefinition buildDefinition `json:"buildDefinition"`
+	RunDetails      runDetails      `json:"runDetails"`
+}
+
+type buildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   externalParameters   `json:"externalParameters"`
+	InternalParameters   internalParameters   `json:"internalParameters"`
+	ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies"`
+}
+
+type externalParameters struct {
+	Repository string `json:"repository"`
+	Ref        string `json:"ref,omitempty"`
+	Dockerfile string `json:"dockerfile"`        // Path in the repository
+	Detected   string `json:"detected"`          // How the Dockerfile came to be: dockerfile, override, compose, node...
+	Service    string `json:"service,omitempty"` // Compose service built
+}
+
+type internalParameters struct {
+	DeploymentID uint `json:"deploymentId"`
+	BuildID      uint `json:"buildId"`
+	// sha256 chaining every resolved dependency, see inputsDigest: one
+	// digest to compare two builds' inputs by
+	InputsDigest string `json:"inputsDigest"`
+}
+
+type runDetails struct {
+	Builder  builder  `json:"builder"`
+	Metadata metadata `json:"metadata"`
+}
+
+type builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version"`
+}
+
+type metadata struct {
+	InvocationID string     `json:"invocationId"`
+	StartedOn    *time.Time `json:"startedOn,omitempty"`
+	FinishedOn   time.Time  `json:"finishedOn"`
+}
+
+// envelope is a DSSE envelope, the provenance as it is stored and served
+type envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     []byte      `json:"payload"` // The statement, base64 in JSON
+	Signatures  []signature `json:"signatures"`
+}
+
+type signature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// recordProvenance stores the provenance of the image built for deployment.
+// Inputs whose digest couldn't be resolved are left without one and
+// returned as problems.
+func (s *Service) recordProvenance(ctx context.Context, build *models.Build, deployment *models.Deployment, detection *Detection, imageTag string) ([]string, error) {
+	if build.Dockerfile == "" {
+		return nil, fmt.Errorf("the Dockerfile used is unknown")
+	}
+	info, err := s.dockerClient.InspectImage(ctx, imageTag)
+	if err != nil {
+		return nil, fmt.Errorf("could not inspect image %s: %w", imageTag, err)
+	}
+
+	var problems []string
+	dockerfileSum := sha256.Sum256([]byte(build.Dockerfile))
+	dockerfilePath := path.Join(detection.ContextDir, detection.Dockerfile)
+	repository := redactURL(deployment.Project.RepoURL)
+	dependencies := []resourceDescriptor{
+		{URI: "git+" + repository, Digest: map[string]string{"gitCommit": deployment.CommitSHA}},
+		{Name: dockerfilePath, Digest: map[string]string{"sha256": hex.EncodeToString(dockerfileSum[:])}},
+	}
+	for _, image := range baseImages(build.Dockerfile) {
+		dependency := resourceDescriptor{URI: "docker-image://" + image}
+		if digest, err := s.baseImageDigest(ctx, image); err != nil {
+			problems = append(problems, fmt.Sprintf("base image %s has no digest: %v", image, err))
+		} else {
+			algorithm, value, _ := strings.Cut(digest, ":")
+			dependency.Digest = map[string]string{algorithm: value}
+		}
+		dependencies = append(dependencies, dependency)
+	}
+
+	versions := map[string]string{"deploy-platform": platformVersion()}
+	if dockerVersion, err := s.dockerClient.ServerVersion(ctx); err == nil {
+		versions["docker"] = dockerVersion
+	}
+	_, imageDigest, _ := strings.Cut(info.ID, ":")
+	record := statement{
+		Type:          statementType,
+		Subject:       []resourceDescriptor{{Name: imageTag, Digest: map[string]string{"sha256": imageDigest}}},
+		PredicateType: provenanceType,
+		Predicate: provenance{
+			BuildDefinition: buildDefinition{
+				BuildType: s.supplyChain.builderID + "/build-types/docker/v1",
+				ExternalParameters: externalParameters{
+					Repository: repository,
+					Ref:        string(cloneReference(deployment)),
+					Dockerfile: dockerfilePath,
+					Detected:   detection.Type,
+					Service:    detection.Service,
+				},
+				InternalParameters: internalParameters{
+					DeploymentID: deployment.ID,
+					BuildID:      build.ID,
+					InputsDigest: inputsDigest(dependencies),
+				},
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: runDetails{
+				Builder:  builder{ID: s.supplyChain.builderID + "/builders/docker", Version: versions},
+				Metadata: metadata{InvocationID: fmt.Sprintf("deployment-%d-build-%d", deployment.ID, build.ID), StartedOn: build.StartedAt, FinishedOn: time.Now().UTC()},
+			},
+		},
+	}
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return problems, err
+	}
+	signed := envelope{PayloadType: dssePayloadType, Payload: payload, Signatures: []signature{}}
+	if s.supplyChain.signer != nil {
+		signed.Signatures = append(signed.Signatures, s.supplyChain.signer.sign(dssePayloadType, payload))
+	}
+	content, err := json.Marshal(signed)
+	if err != nil {
+		return problems, err
+	}
+	return problems, s.storeArtifact(ctx, build.ID, models.ArtifactProvenance, ProvenanceMediaType, content)
+}
+
+// baseImages lists the images a Dockerfile's stages start from, in order and
+// once each, leaving out scratch and earlier stages
+func baseImages(dockerfile string) []string {
+	instructions, _ := parseDockerfile(dockerfile)
+	stages := map[string]bool{}
+	seen := map[string]bool{}
+	var images []string
+	for _, in := range instructions {
+		if in.Name != "FROM" {
+			continue
+		}
+		image := fromPlatformPattern.ReplaceAllString(in.Args, "")
+		alias := ""
+		if m := stageAliasPattern.FindStringSubmatch(image); m != nil {
+			alias = strings.ToLower(m[1])
+			image = strings.TrimSpace(image[:len(image)-len(m[0])])
+		}
+		if image != "" && image != "scratch" && !stages[strings.ToLower(image)] && !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+		if alias != "" {
+			stages[alias] = true
+		}
+	}
+	return images
+}
+
+// baseImageDigest returns the registry digest a base image was built from:
+// the one it is pinned to, or the one of the local image docker pulled
+func (s *Service) baseImageDigest(ctx context.Context, image string) (string, error) {
+	if strings.Contains(image, "$") {
+		return "", fmt.Errorf("set by a build argument")
+	}
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		return digest, nil
+	}
+	return s.dockerClient.RepoDigest(ctx, image)
+}
+
+// inputsDigest chains the digests of dependencies in order: each line is a
+// dependency's URI or name followed by its digests sorted by algorithm, and
+// the result is the sha256 of the lines. Anyone holding the same inputs
+// computes the same value.
+func inputsDigest(dependencies []resourceDescriptor) string {
+	h := sha256.New()
+	for _, dependency := range dependencies {
+		id := dependency.URI
+		if id == "" {
+			id = dependency.Name
+		}
+		algorithms := make([]string, 0, len(dependency.Digest))
+		for algorithm := range dependency.Digest {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+		line := id
+		for _, algorithm := range algorithms {
+			line += " " + algorithm + ":" + dependency.Digest[algorithm]
+		}
+		fmt.Fprintln(h, line)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// redactURL drops credentials from a repository URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+// platformVersion is the platform's module version, or the VCS revision it
+// was built from for development builds
+var platformVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+})
+
+// Signer signs provenance records with the platform's ed25519 key, so they
+// can be verified outside the platform
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// LoadSigner reads the PKCS#8 PEM ed25519 private key at path (as written by
+// openssl genpkey -algorithm ed25519). An empty path means unsigned
+// provenance: nil, nil.
+func LoadSigner(path string) (*Signer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(public)
+	return &Signer{key: key, keyID: hex.EncodeToString(sum[:8])}, nil
+}
+
+// KeyID identifies the key in signatures: the start of its public key's
+// sha256
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// PublicKeyPEM returns the public key verifying signatures, PKIX PEM
+func (s *Signer) PublicKeyPEM() []byte {
+	public, _ := x509.MarshalPKIXPublicKey(s.key.Public())
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+}
+
+// sign signs payload the DSSE way, over its pre-authentication encoding
+func (s *Signer) sign(payloadType string, payload []byte) signature {
+	pae := fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	message := append([]byte(pae), payload...)
+	return signature{KeyID: s.keyID, Sig: ed25519.Sign(s.key, message)}
+}
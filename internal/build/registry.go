@@ -0,0 +1,119 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/registry"
+	"deploy-platform/pkg/docker"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// pushRegistry is the registry built images are pushed to for clusters to
+// pull them from
+type pushRegistry struct {
+	url  string               // host[:port][/path]
+	auth *docker.RegistryAuth // nil = pushed and pulled anonymously
+
+	applied sync.Map // Clusters the platform pull Secret was applied to since start
+}
+
+// SetRegistry pushes built images to url (host[:port][/path]) with user and
+// password, the credentials pods pull them with too; without a url clusters
+// run images off the build host's Docker daemon
+func (s *Service) SetRegistry(url, user, password string) {
+	if url == "" {
+		s.registry = nil
+		return
+	}
+	s.registry = &pushRegistry{url: strings.TrimSuffix(url, "/")}
+	if user != "" {
+		s.registry.auth = &docker.RegistryAuth{Username: user, Password: password}
+	}
+}
+
+// imageName is the name the image of deployment is built as, tag being its
+// short commit SHA: deploy-<deployment ID>:<tag> on the build host, or
+// <registry>/<project slug>:<tag>-<deployment ID> when images are pushed.
+// Each deployment gets a tag of its own, so rebuilding a commit never
+// changes the image an earlier deployment rolls back to.
+func (s *Service) imageName(deployment *models.Deployment, tag string) string {
+	if s.registry == nil {
+		return fmt.Sprintf("deploy-%d:%s", deployment.ID, tag)
+	}
+	repository := deployment.Project.Slug
+	if repository == "" {
+		repository = kubernetes.ProjectResourceName(deployment.ProjectID)
+	}
+	return fmt.Sprintf("%s/%s:%s-%d", s.registry.url, repository, tag, deployment.ID)
+}
+
+// pushImage pushes imageTag to the registry, writing the daemon's output to
+// output; nothing is pushed without one
+func (s *Service) pushImage(ctx context.Context, imageTag string, output io.Writer) error {
+	if s.registry == nil {
+		return nil
+	}
+	fmt.Fprintf(output, "Pushing %s\n", imageTag)
+	daemon := &daemonOutput{w: output}
+	err := s.dockerClient.PushImage(ctx, imageTag, s.registry.auth, daemon)
+	if err == nil {
+		err = daemon.err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push image %s: %w", imageTag, err)
+	}
+	return nil
+}
+
+// applyPlatformPullSecret creates or refreshes the Secret pods pull pushed
+// images with in cluster, once per cluster after the platform starts
+func (s *Service) applyPlatformPullSecret(ctx context.Context, cluster string, client *kubernetes.Client) error {
+	if s.registry == nil || s.registry.auth == nil {
+		return nil
+	}
+	if _, done := s.registry.applied.Load(cluster); done {
+		return nil
+	}
+	server, _, _ := strings.Cut(s.registry.url, "/")
+	dockerConfig, err := registry.DockerConfigJSON([]registry.Credentials{{Host: server, Username: s.registry.auth.Username, Password: s.registry.auth.Password}})
+	if err != nil {
+		return err
+	}
+	if err := client.ApplyPlatformPullSecret(ctx, dockerConfig); err != nil {
+		return fmt.Errorf("failed to apply the platform's image pull secret: %w", err)
+	}
+	s.registry.applied.Store(cluster, true)
+	return nil
+}
+
+// registryAuths are the registry credentials of a project, as the auth
+// configs of its docker builds
+func registryAuths(projectID uint) (map[string]docker.RegistryAuth, error) {
+	creds, err := registry.Load(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registry credentials: %w", err)
+	}
+	auths := make(map[string]docker.RegistryAuth, len(creds))
+	for _, c := range creds {
+		auths[registry.AuthKey(c.Host)] = docker.RegistryAuth{Username: c.Username, Password: c.Password}
+	}
+	return auths, nil
+}
+
+// applyPullSecret brings the project's image pull Secret in the cluster in
+// line with its registry credentials, before its pods start: it may be
+// missing after an archive or a cluster migration
+func applyPullSecret(ctx context.Context, client *kubernetes.Client, project *models.Project) error {
+	dockerConfig, err := registry.DockerConfig(project.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load registry credentials: %w", err)
+	}
+	if err := client.ApplyPullSecret(ctx, project, dockerConfig); err != nil {
+		return fmt.Errorf("failed to apply the image pull secret: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package build
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/registry"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/testutil"
+	"deploy-platform/pkg/docker"
+	"reflect"
+	"testing"
+)
+
+// Builds pull base images with the project's credentials, keyed the way
+// the daemon looks them up
+func TestRegistryAuths(t *testing.T) {
+	testutil.DB(t)
+	if err := secrets.Init(&config.Config{EncryptionKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []registry.Credentials{{Host: registry.DockerHub, Username: "acme", Password: "hub-token"}, {Host: "registry.example.com:5000", Username: "ci", Password: "s3cret"}} {
+		encrypted, _ := secrets.Encrypt(c.Password)
+		database.DB.Create(&models.RegistryCredential{ProjectID: 7, Host: c.Host, Username: c.Username, Password: encrypted})
+	}
+
+	auths, err := registryAuths(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]docker.RegistryAuth{
+		"https://index.docker.io/v1/": {Username: "acme", Password: "hub-token"},
+		"registry.example.com:5000":   {Username: "ci", Password: "s3cret"},
+	}
+	if !reflect.DeepEqual(auths, want) {
+		t.Errorf("auths %+v, want %+v", auths, want)
+	}
+	if auths, err := registryAuths(8); err != nil || len(auths) != 0 {
+		t.Errorf("project without credentials: %+v, %v", auths, err)
+	}
+}
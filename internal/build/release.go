@@ -0,0 +1,101 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/throttle"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const releaseLogHeader = "=== Release phase ==="
+
+// SetReleaseTimeout sets how long a project's release command may run
+// before its deployment fails
+func (s *Service) SetReleaseTimeout(timeout time.Duration) {
+	s.releaseTimeout = timeout
+}
+
+// projectLocks serialize the deploy phase (release command and rollout) of
+// each project, so two deployments never run their release commands at once
+type projectLocks struct {
+	mu    sync.Mutex
+	locks map[uint]*throttle.Semaphore
+}
+
+// lock waits for the project's lock, returning the function releasing it
+func (l *projectLocks) lock(ctx context.Context, projectID uint) (func(), error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[uint]*throttle.Semaphore)
+	}
+	sem, ok := l.locks[projectID]
+	if !ok {
+		sem = throttle.New("project", 1)
+		l.locks[projectID] = sem
+	}
+	l.mu.Unlock()
+
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { sem.Release(1) }, nil
+}
+
+// runRelease runs the project's release command in the new image before
+// its rollout, its output appended to the build logs. A failure marks the
+// deployment release_failed; the running version is left alone.
+func (s *Service) runRelease(ctx context.Context, client *kubernetes.Client, deployment *models.Deployment, envVars map[string]string) error {
+	command := strings.TrimSpace(deployment.Project.ReleaseCommand)
+	if command == "" {
+		return nil
+	}
+	timeout := s.releaseTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	log.Printf("🛠️  Deployment %d: running release command", deployment.ID)
+
+	logs, err := client.RunRelease(ctx, deployment, envVars, command, timeout)
+	appendPhaseLogs(deployment.ID, releaseLogHeader, command, logs, err, "Release command succeeded")
+	var releaseErr *kubernetes.ReleaseError
+	if errors.As(err, &releaseErr) {
+		log.Printf("❌ Deployment %d: %v", deployment.ID, err)
+		deployment.FailureCategory = models.FailureReleaseFailed
+		deployment.FailureDetail = "The release command (" + command + ") " + releaseErr.Reason + ", the running version was left in place: see the Release phase of the build logs"
+		database.DB.Model(deployment).Select("failure_category", "failure_detail").Updates(deployment)
+	}
+	return err
+}
+
+// appendPhaseLogs adds the section of a deploy phase, titled header, that
+// ran command to the deployment's build logs
+func appendPhaseLogs(deploymentID uint, header, command, logs string, err error, success string) {
+	section := header + "\n$ " + command + "\n" + logs
+	if !strings.HasSuffix(section, "\n") {
+		section += "\n"
+	}
+	if err != nil {
+		section += "❌ " + err.Error() + "\n"
+	} else {
+		section += "✅ " + success + "\n"
+	}
+	appendLogSection(deploymentID, section)
+}
+
+// appendLogSection adds section to the logs of the deployment's latest build
+func appendLogSection(deploymentID uint, section string) {
+	var build models.Build
+	if database.DB.Where("deployment_id = ?", deploymentID).Order("id DESC").First(&build).Error != nil {
+		return
+	}
+	if build.Logs != "" {
+		section = build.Logs + "\n\n" + section
+	}
+	database.DB.Model(&build).Update("logs", section)
+}
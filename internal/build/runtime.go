@@ -0,0 +1,192 @@
+package build
+
+import (
+	"deploy-platform/internal/config"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// runtimeVersionPattern is what the runtime versions base images are tagged
+// with must match, detected or set by projects, so neither can point
+// builds at arbitrary images
+var runtimeVersionPattern = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+// InitRuntimeVersions sets the pattern runtime versions must match
+func InitRuntimeVersions(cfg *config.Config) {
+	if pattern, err := regexp.Compile(cfg.RuntimeVersionPattern); err == nil {
+		runtimeVersionPattern = pattern
+	}
+}
+
+// ValidateRuntimeVersion checks a project's runtime version ("" = detect it)
+func ValidateRuntimeVersion(version string) error {
+	if version != "" && !runtimeVersionPattern.MatchString(version) {
+		return fmt.Errorf("runtime_version %q is not allowed on this platform: it must match %s", version, runtimeVersionPattern)
+	}
+	return nil
+}
+
+// Where a runtime version was selected from, besides the repository's files
+const (
+	RuntimeSourceProject = "project settings"
+	RuntimeSourceDefault = "default"
+)
+
+// RuntimeSelection is the version of the language runtime a generated
+// Dockerfile builds on: the tag of its base image
+type RuntimeSelection struct {
+	Runtime string `json:"runtime"` // node, python, go, ruby
+	Version string `json:"version"` // e.g. "20" for node:20-alpine
+	Source  string `json:"source"`  // File it was read from, or RuntimeSource*
+}
+
+// runtimeCandidate is a version a repository file asks for
+type runtimeCandidate struct {
+	source  string
+	version string
+}
+
+var pyprojectPythonPattern = regexp.MustCompile(`(?m)^\s*(?:requires-python|python)\s*=\s*["'][^"'\d]*(\d+\.\d+)`)
+
+// runtimeLabels name runtimes in warnings
+var runtimeLabels = map[string]string{"node": "Node.js", "python": "Python", "go": "Go", "ruby": "Ruby"}
+
+// runtimeCandidates reads the versions of runtime the files in dir ask for,
+// most specific file first. Node.js versions are majors, Python and Go
+// ones major.minor, the granularity their base images are tagged with.
+func runtimeCandidates(dir, runtime string) []runtimeCandidate {
+	var candidates []runtimeCandidate
+	add := func(source, version string) {
+		if version != "" {
+			candidates = append(candidates, runtimeCandidate{source: source, version: version})
+		}
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	switch runtime {
+	case "node":
+		// nvm accepts "v20.11.0" and aliases such as "lts/iron", which have no version to read
+		for _, name := range []string{".nvmrc", ".node-version"} {
+			if v := strings.TrimPrefix(strings.TrimSpace(read(name)), "v"); v != "" && v[0] >= '0' && v[0] <= '9' {
+				add(name, majorVersionPattern.FindString(v))
+			}
+		}
+		var pkg packageJSON
+		if json.Unmarshal([]byte(read("package.json")), &pkg) == nil {
+			add("package.json engines", majorVersionPattern.FindString(pkg.Engines["node"]))
+		}
+	case "python":
+		for _, name := range []string{".python-version", "runtime.txt"} {
+			add(name, majorMinorVersionPattern.FindString(read(name)))
+		}
+		if m := pyprojectPythonPattern.FindStringSubmatch(read("pyproject.toml")); m != nil {
+			add("pyproject.toml", m[1])
+		}
+	case "go":
+		if m := goDirectivePattern.FindStringSubmatch(read("go.mod")); m != nil {
+			add("go.mod", m[1])
+		}
+	case "ruby":
+		if v := strings.TrimPrefix(strings.TrimSpace(read(".ruby-version")), "ruby-"); rubyVersionPattern.MatchString(v) {
+			add(".ruby-version", v)
+		}
+	}
+	return candidates
+}
+
+// selectRuntime picks the version of runtime the Dockerfile generated for
+// dir builds on: the project's runtimeVersion when set, else the first
+// version the repository's files ask for, else the platform default.
+// Versions not matching RUNTIME_VERSION_PATTERN are skipped. The warnings
+// explain files disagreeing and versions that were skipped.
+func selectRuntime(dir, runtime, runtimeVersion string) (*RuntimeSelection, []string) {
+	label := runtimeLabels[runtime]
+	candidates := runtimeCandidates(dir, runtime)
+	var warnings []string
+
+	var selected *RuntimeSelection
+	if runtimeVersion != "" {
+		if err := ValidateRuntimeVersion(runtimeVersion); err == nil {
+			selected = &RuntimeSelection{Runtime: runtime, Version: runtimeVersion, Source: RuntimeSourceProject}
+		} else {
+			warnings = append(warnings, fmt.Sprintf("the project's runtime version %s is no longer allowed on this platform (RUNTIME_VERSION_PATTERN), it is ignored", runtimeVersion))
+		}
+	}
+	for _, c := range candidates {
+		if selected != nil {
+			break
+		}
+		if ValidateRuntimeVersion(c.version) != nil {
+			warnings = append(warnings, fmt.Sprintf("%s asks for %s %s, which is not allowed on this platform (RUNTIME_VERSION_PATTERN): it is ignored", c.source, label, c.version))
+			continue
+		}
+		selected = &RuntimeSelection{Runtime: runtime, Version: c.version, Source: c.source}
+	}
+	if selected == nil {
+		selected = &RuntimeSelection{Runtime: runtime, Version: defaultRuntimeVersion(runtime), Source: RuntimeSourceDefault}
+	}
+
+	for _, c := range candidates {
+		if c.source != selected.Source && c.version != selected.Version && ValidateRuntimeVersion(c.version) == nil {
+			warnings = append(warnings, fmt.Sprintf("%s asks for %s %s: building on %s %s, from %s", c.source, label, c.version,
+				label, selected.Version, selected.Source))
+		}
+	}
+	return selected, warnings
+}
+
+// defaultRuntimeVersion is the version of runtime builds use when nothing
+// asks for one
+func defaultRuntimeVersion(runtime string) string {
+	data := DefaultDockerfileData("")
+	switch runtime {
+	case "node":
+		return data.NodeVersion
+	case "python":
+		return data.PythonVersion
+	case "go":
+		return data.GoVersion
+	case "ruby":
+		return data.RubyVersion
+	}
+	return ""
+}
+
+// apply sets the version on the template data
+func (r *RuntimeSelection) apply(data *DockerfileData) {
+	switch r.Runtime {
+	case "node":
+		data.NodeVersion = r.Version
+	case "python":
+		data.PythonVersion = r.Version
+	case "go":
+		data.GoVersion = r.Version
+	case "ruby":
+		data.RubyVersion = r.Version
+	}
+}
+
+// image is the runtime's base image build commands run on
+func (r *RuntimeSelection) image() string {
+	switch r.Runtime {
+	case "node":
+		return "node:" + r.Version + "-alpine"
+	case "python":
+		return "python:" + r.Version + "-slim"
+	case "go":
+		return "golang:" + r.Version + "-alpine"
+	case "ruby":
+		return "ruby:" + r.Version + "-slim"
+	}
+	return ""
+}
@@ -0,0 +1,192 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runtimePattern sets RUNTIME_VERSION_PATTERN for the test
+func runtimePattern(t *testing.T, pattern string) {
+	t.Helper()
+	previous := runtimeVersionPattern
+	InitRuntimeVersions(&config.Config{RuntimeVersionPattern: pattern})
+	t.Cleanup(func() { runtimeVersionPattern = previous })
+}
+
+// The fixture repositories under testdata/runtimes get the runtime version
+// their version files ask for, the project's when set, and warnings for
+// the files disagreeing or asking for versions the platform doesn't allow
+func TestSelectRuntime(t *testing.T) {
+	tests := []struct {
+		name           string
+		fixture        string
+		pattern        string // RUNTIME_VERSION_PATTERN, "" = the default
+		runtimeVersion string // Of the project
+		want           RuntimeSelection
+		from           string // First line of the Dockerfile
+		warnings       []string
+	}{
+		{
+			name: "nvmrc over engines", fixture: "node-conflict",
+			want: RuntimeSelection{Runtime: "node", Version: "20", Source: ".nvmrc"},
+			from: "FROM node:20-alpine",
+			warnings: []string{
+				"package.json engines asks for Node.js 18: building on Node.js 20, from .nvmrc",
+			},
+		},
+		{
+			name: "nvm alias", fixture: "node-alias",
+			want: RuntimeSelection{Runtime: "node", Version: "22", Source: ".node-version"},
+			from: "FROM node:22-alpine",
+			warnings: []string{
+				"package.json engines asks for Node.js 20: building on Node.js 22, from .node-version",
+			},
+		},
+		{
+			name: "node default", fixture: "node-default",
+			want: RuntimeSelection{Runtime: "node", Version: "18", Source: RuntimeSourceDefault},
+			from: "FROM node:18-alpine",
+		},
+		{
+			name: "runtime.txt over pyproject", fixture: "python",
+			want: RuntimeSelection{Runtime: "python", Version: "3.12", Source: "runtime.txt"},
+			from: "FROM python:3.12-slim",
+			warnings: []string{
+				"pyproject.toml asks for Python 3.10: building on Python 3.12, from runtime.txt",
+			},
+		},
+		{
+			name: "go directive", fixture: "go",
+			want: RuntimeSelection{Runtime: "go", Version: "1.22", Source: "go.mod"},
+			from: "FROM golang:1.22-alpine AS builder",
+		},
+		{
+			name: "project setting", fixture: "node-conflict", runtimeVersion: "22",
+			want: RuntimeSelection{Runtime: "node", Version: "22", Source: RuntimeSourceProject},
+			from: "FROM node:22-alpine",
+			warnings: []string{
+				".nvmrc asks for Node.js 20: building on Node.js 22, from project settings",
+				"package.json engines asks for Node.js 18: building on Node.js 22, from project settings",
+			},
+		},
+		{
+			name: "disallowed file version", fixture: "node-conflict", pattern: `^18$`,
+			want: RuntimeSelection{Runtime: "node", Version: "18", Source: "package.json engines"},
+			from: "FROM node:18-alpine",
+			warnings: []string{
+				".nvmrc asks for Node.js 20, which is not allowed on this platform (RUNTIME_VERSION_PATTERN): it is ignored",
+			},
+		},
+		{
+			name: "disallowed project setting", fixture: "node-conflict", pattern: `^(18|20)$`, runtimeVersion: "22",
+			want: RuntimeSelection{Runtime: "node", Version: "20", Source: ".nvmrc"},
+			from: "FROM node:20-alpine",
+			warnings: []string{
+				"the project's runtime version 22 is no longer allowed on this platform (RUNTIME_VERSION_PATTERN), it is ignored",
+				"package.json engines asks for Node.js 18: building on Node.js 20, from .nvmrc",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.pattern != "" {
+				runtimePattern(t, tt.pattern)
+			}
+			dir := t.TempDir()
+			if err := os.CopyFS(dir, os.DirFS(filepath.Join("testdata", "runtimes", tt.fixture))); err != nil {
+				t.Fatal(err)
+			}
+
+			detection, err := (&Service{}).detectLanguage(dir, tt.runtimeVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Runtime == nil || *detection.Runtime != tt.want {
+				t.Errorf("runtime %+v, want %+v", detection.Runtime, tt.want)
+			}
+			if !reflect.DeepEqual(detection.Warnings, tt.warnings) {
+				t.Errorf("warnings %q, want %q", detection.Warnings, tt.warnings)
+			}
+			dockerfile, err := os.ReadFile(filepath.Join(dir, "Dockerfile"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from, _, _ := strings.Cut(string(dockerfile), "\n"); from != tt.from {
+				t.Errorf("Dockerfile starts with %q, want %q", from, tt.from)
+			}
+		})
+	}
+}
+
+func TestValidateRuntimeVersion(t *testing.T) {
+	for _, version := range []string{"", "20", "3.12", "1.22.3"} {
+		if err := ValidateRuntimeVersion(version); err != nil {
+			t.Errorf("%q: %v", version, err)
+		}
+	}
+	for _, version := range []string{"latest", "20-bookworm", "20 AS evil", "../ubuntu", "1.2.3.4"} {
+		if err := ValidateRuntimeVersion(version); err == nil || !strings.Contains(err.Error(), "is not allowed on this platform") {
+			t.Errorf("%q: got %v", version, err)
+		}
+	}
+
+	// Platforms can allow more
+	runtimePattern(t, `^\d+(\.\d+)?(-bookworm)?$`)
+	if err := ValidateRuntimeVersion("20-bookworm"); err != nil {
+		t.Error(err)
+	}
+}
+
+// Builds record the selection
+func TestRecordRuntime(t *testing.T) {
+	testutil.DB(t)
+	deployment := &models.Deployment{ProjectID: 1, Status: models.StatusBuilding}
+	database.DB.Create(deployment)
+	build := &models.Build{DeploymentID: deployment.ID, Status: "building"}
+	database.DB.Create(build)
+
+	(&Service{}).recordDetection(build, deployment, &Detection{
+		Type:    "node",
+		Runtime: &RuntimeSelection{Runtime: "node", Version: "20", Source: ".nvmrc"},
+	})
+	var stored models.Build
+	database.DB.First(&stored, build.ID)
+	if stored.Runtime != "node" || stored.RuntimeVersion != "20" || stored.RuntimeSource != ".nvmrc" {
+		t.Errorf("recorded %s %s from %s", stored.Runtime, stored.RuntimeVersion, stored.RuntimeSource)
+	}
+}
+
+// The generated Dockerfile endpoint shows the selection, regenerated when
+// the project's runtime version changes
+func TestGenerateDockerfileRuntime(t *testing.T) {
+	server := newGitServer(t, "127.0.0.1")
+	url, sha := server.repository(t, "api", fixtureFiles(t, "runtimes/node-conflict"), nil)
+	s := &Service{}
+	project := &models.Project{ID: 1, RepoURL: url}
+
+	generated, err := s.GenerateDockerfile(context.Background(), project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated.CommitSHA != sha || *generated.Detection.Runtime != (RuntimeSelection{Runtime: "node", Version: "20", Source: ".nvmrc"}) ||
+		!strings.HasPrefix(generated.Dockerfile, "FROM node:20-alpine") {
+		t.Errorf("generated %+v on %.30q", generated.Detection.Runtime, generated.Dockerfile)
+	}
+
+	project.RuntimeVersion = "22"
+	generated, err = s.GenerateDockerfile(context.Background(), project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated.Detection.Runtime.Source != RuntimeSourceProject || !strings.HasPrefix(generated.Dockerfile, "FROM node:22-alpine") {
+		t.Errorf("generated %+v on %.30q", generated.Detection.Runtime, generated.Dockerfile)
+	}
+}
@@ -7,26 +7,64 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"deploy-platform/internal/activations"
+	"deploy-platform/internal/buildlogs"
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/hooks"
 	"deploy-platform/internal/hostname"
 	"deploy-platform/internal/kubernetes"
 	"deploy-platform/internal/models"
+	"deploy-platform/internal/preview"
+	"deploy-platform/internal/secretref"
+	"deploy-platform/internal/textutil"
+	"deploy-platform/internal/throttle"
 	"deploy-platform/pkg/docker"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
 type Service struct {
 	dockerClient *docker.Client
-	k8sClient    *kubernetes.Client
+	k8sClients   *kubernetes.ClientSet
 	hostnameMgr  *hostname.Manager
+
+	buildSlots   *throttle.Semaphore // nil = unlimited
+	deploySlots  *throttle.Semaphore // nil = unlimited
+	weightStepMB int64
+
+	templates *Templates    // nil = built-in Dockerfile templates
+	registry  *pushRegistry // nil = images stay on the build host
+
+	imageBudget imageBudget // Zero = images aren't checked
+	supplyChain supplyChain // Zero = unsigned provenance only
+
+	rolloutTimeout time.Duration // 0 = deploys don't wait for pods to become ready
+	deployRetry    deployRetry   // Zero = rollouts failing on transient cluster API errors aren't retried
+	releaseTimeout time.Duration // How long release commands may run
+	projectLocks   projectLocks  // One deploy phase per project at a time
+
+	previews   *preview.Manager    // Provisions the preview environments of branch and preview deployments
+	secretRefs *secretref.Resolver // Resolves env vars referencing secrets, nil = none can be
+
+	workDir     string        // Empty = builds check out under /tmp/builds
+	keepFailed  time.Duration // How long workspaces of failed builds are kept, 0 = not kept
+	lfsDisabled bool          // Repositories using Git LFS fail instead of fetching their files
+	mirrors     *mirrorCache  // nil = clones always come from the network
+
+	generated generatedCache // Dockerfiles generated for GET /api/projects/:id/dockerfile
+
+	logStreams *buildlogs.Streams // nil = the output of builds isn't kept
 }
 
 func NewService() (*Service, error) {
@@ -38,19 +76,64 @@ func NewService() (*Service, error) {
 	return &Service{dockerClient: dc}, nil
 }
 
-func NewServiceWithK8s(dockerClient *docker.Client, k8sClient *kubernetes.Client, hostnameMgr *hostname.Manager) *Service {
+func NewServiceWithK8s(dockerClient *docker.Client, k8sClients *kubernetes.ClientSet, hostnameMgr *hostname.Manager) *Service {
 	return &Service{
 		dockerClient: dockerClient,
-		k8sClient:    k8sClient,
+		k8sClients:   k8sClients,
 		hostnameMgr:  hostnameMgr,
 	}
 }
 
+// BuildDeployment waits for a build slot, then builds and deploys
 func (s *Service) BuildDeployment(ctx context.Context, deploymentID uint) error {
+	if err := s.buildSlots.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	return s.BuildDeploymentInSlot(ctx, deploymentID)
+}
+
+// BuildDeploymentInSlot is BuildDeployment for callers already holding one
+// build slot, which is released once the image is built
+func (s *Service) BuildDeploymentInSlot(ctx context.Context, deploymentID uint) error {
+	return s.BuildDeploymentPreemptible(ctx, deploymentID, nil)
+}
+
+// BuildDeploymentPreemptible is BuildDeploymentInSlot for a job preemption
+// may cancel until it releases its build slot; nil = it can't be preempted
+func (s *Service) BuildDeploymentPreemptible(ctx context.Context, deploymentID uint, preemption *Preemption) error {
+	held := 1
+	defer func() {
+		preemption.release()
+		s.buildSlots.Release(held)
+	}()
+
 	var deployment models.Deployment
 	if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
 		return err
 	}
+	// Approved deployments were built before they awaited approval, retried
+	// rollouts and deploy-only redeploys earlier still
+	if deployment.ImageTag != "" && (deployment.ApprovedAt != nil || deployment.DeployOnly) {
+		preemption.release()
+		s.buildSlots.Release(held)
+		held = 0
+		return s.rolloutBuilt(ctx, &deployment)
+	}
+	// Refuses deployments that were cancelled, failed or skipped while queued
+	if err := models.SetDeploymentStatus(database.DB, deploymentID, models.StatusBuilding, ""); err != nil {
+		return err
+	}
+
+	// The build commands and manifest patches in effect are kept with the
+	// deployment, later changes to the project's don't rewrite what it was
+	// built and rolled out with
+	deployment.BuildCommands = nil
+	if deployment.Project.BuildCommands.Set() {
+		commands := deployment.Project.BuildCommands
+		deployment.BuildCommands = &commands
+	}
+	deployment.ManifestPatches = deployment.Project.ManifestPatches
+	database.DB.Model(&deployment).Select("build_commands", "manifest_patches").Updates(&deployment)
 
 	// Create build record
 	build := &models.Build{
@@ -59,55 +142,195 @@ func (s *Service) BuildDeployment(ctx context.Context, deploymentID uint) error
 		StartedAt:    &[]time.Time{time.Now()}[0],
 	}
 	database.DB.Create(build)
+	estimateBuild(build, &deployment)
+
+	// Report liveness until the build finishes, so the watchdog can spot dead workers
+	hb := startHeartbeat(build.ID)
+	defer hb.Stop()
+	// The clone and image build output goes to the build's logs as it streams
+	output := s.logStreams.Writer(build.ID)
+	defer s.finishLogs(build.ID)
+
+	deployment.Status = models.StatusBuilding
+	if err := s.runHooks(ctx, hooks.EventBeforeBuild, build, &deployment); err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
 
 	// Clone repository
-	repoPath := fmt.Sprintf("/tmp/builds/%d", deploymentID)
-	if err := s.cloneRepo(deployment.Project.RepoURL, repoPath, deployment.Branch); err != nil {
+	startStep(build, models.BuildStepClone)
+	repoPath, removeWorkspace, err := s.workspace(build)
+	if err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
+	defer removeWorkspace()
+	cloned, err := s.cloneRepo(ctx, &deployment.Project, repoPath, cloneReference(&deployment), deployment.CommitSHA, io.MultiWriter(hb, output))
+	if s.mirrors != nil {
+		build.CloneCacheHit = cloned.CacheHit
+		build.CloneFetchMs = cloned.Fetch.Milliseconds()
+		database.DB.Model(build).Select("clone_cache_hit", "clone_fetch_ms").Updates(build)
+	}
+	if err != nil {
+		if errors.Is(err, ErrLFSNotSupported) {
+			deployment.FailureCategory = models.FailureLFSNotSupported
+			database.DB.Model(&deployment).Update("failure_category", deployment.FailureCategory)
+		}
 		s.updateBuildStatus(build.ID, "failed", err.Error())
 		return err
 	}
 
 	// Detect build type and create Dockerfile if needed
-	dockerfile, err := s.detectAndCreateDockerfile(repoPath)
+	startStep(build, models.BuildStepDetect)
+	override, err := models.DockerfileOverride(database.DB, deployment.ProjectID)
 	if err != nil {
 		s.updateBuildStatus(build.ID, "failed", err.Error())
 		return err
 	}
+	detection, err := s.detectAndCreateDockerfile(repoPath, deployment.BuildCommands, deployment.Project.RuntimeVersion, override)
+	if err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
+	// The build keeps the exact Dockerfile it used
+	build.Dockerfile = dockerfileUsed(repoPath, detection)
+	switch detection.Type {
+	case "override":
+		build.DockerfileRevisionID = &override.ID
+	case "dockerfile":
+		build.DockerfilePath = filepath.ToSlash(filepath.Join(detection.ContextDir, detection.Dockerfile))
+	}
+	database.DB.Model(build).Select("dockerfile", "dockerfile_revision_id", "dockerfile_path").Updates(build)
+	s.recordDetection(build, &deployment, detection)
+
+	// Large repositories make heavy builds: trade the single slot for as many
+	// as the build weighs. Releasing first keeps two large builds from
+	// deadlocking on partial reservations.
+	if weight := s.buildSlots.Clamp(s.buildWeight(repoPath)); weight > held {
+		s.buildSlots.Release(held)
+		held = 0
+		log.Printf("⚖️  Deployment %d weighs %d build slots, waiting for them", deploymentID, weight)
+		if err := s.buildSlots.Acquire(ctx, weight); err != nil {
+			s.updateBuildStatus(build.ID, "failed", err.Error())
+			return err
+		}
+		held = weight
+	}
 
 	// Build Docker image
-	imageTag := fmt.Sprintf("deploy-%d:%s", deploymentID, deployment.CommitSHA[:7])
-	buildContext, err := s.createBuildContext(repoPath)
+	startStep(build, models.BuildStepImage)
+	tag := textutil.ShortSHA(deployment.CommitSHA)
+	if tag == "" {
+		tag = "latest" // No commit to name it after
+	}
+	imageTag := s.imageName(&deployment, tag)
+	buildContext, err := s.createBuildContext(filepath.Join(repoPath, detection.ContextDir))
 	if err != nil {
 		s.updateBuildStatus(build.ID, "failed", err.Error())
 		return err
 	}
 
-	if err := s.dockerClient.BuildImage(ctx, buildContext, imageTag, dockerfile); err != nil {
+	// Base images of private registries are pulled with the project's credentials
+	auths, err := registryAuths(deployment.ProjectID)
+	if err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
+	daemon := &daemonOutput{w: output}
+	err = s.dockerClient.BuildImage(ctx, buildContext, imageTag, detection.Dockerfile, auths, io.MultiWriter(hb, daemon))
+	if err == nil {
+		err = daemon.err()
+	}
+	if err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
+	preemption.release()
+	s.buildSlots.Release(held)
+	held = 0
+	startStep(build, models.BuildStepVerify)
+	// A cancelled job must not move on, it may already be re-queued elsewhere
+	if err := ctx.Err(); err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
+	if err := s.checkImage(ctx, build, &deployment, imageTag); err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
+	if err := s.recordSupplyChain(ctx, build, &deployment, detection, imageTag); err != nil {
+		s.updateBuildStatus(build.ID, "failed", err.Error())
+		return err
+	}
+	startStep(build, models.BuildStepPush)
+	if err := s.pushImage(ctx, imageTag, output); err != nil {
 		s.updateBuildStatus(build.ID, "failed", err.Error())
 		return err
 	}
 
 	// Update build and deployment
+	finishSteps(build)
 	completed := time.Now()
 	build.CompletedAt = &completed
 	build.Status = "success"
-	database.DB.Save(build)
+	database.DB.Model(build).Select("status", "completed_at").Updates(build)
 
-	deployment.Status = "deploying"
+	// Kept with the image for rollouts after the work tree is gone
 	deployment.ImageTag = imageTag
-	database.DB.Save(deployment)
-
-	// Deploy to Kubernetes if client is available
-	if s.k8sClient != nil && s.hostnameMgr != nil {
-		if err := s.deployToKubernetes(ctx, &deployment); err != nil {
-			log.Printf("❌ Kubernetes deployment failed for deployment %d: %v", deploymentID, err)
-			deployment.Status = "failed"
-			database.DB.Save(deployment)
-			return fmt.Errorf("kubernetes deployment failed: %w", err)
+	deployment.DetectedPort = detection.Port
+	deployment.DetectedEnv = detection.Env
+	database.DB.Model(&deployment).Select("image_tag", "detected_port", "detected_env").Updates(&deployment)
+	reason := "image " + imageTag + " built"
+	if build.ImageSizeBytes > 0 {
+		reason += fmt.Sprintf(" (%s, %d warnings)", formatSize(build.ImageSizeBytes), len(build.Warnings))
+	}
+	if needsApproval(&deployment) {
+		return awaitApproval(&deployment, reason)
+	}
+	if err := models.SetDeploymentStatus(database.DB, deploymentID, models.StatusDeploying, reason); err != nil {
+		return err
+	}
+	return s.rollout(ctx, &deployment, build, detection)
+}
+
+// rollout deploys a built deployment to Kubernetes, if a client is available,
+// and marks it deployed
+func (s *Service) rollout(ctx context.Context, deployment *models.Deployment, build *models.Build, detection *Detection) error {
+	if s.k8sClients != nil && s.hostnameMgr != nil {
+		startStep(build, models.BuildStepDeploy)
+		if err := s.deploySlots.Acquire(ctx, 1); err != nil {
+			return err
+		}
+		defer s.deploySlots.Release(1)
+		if err := s.deployToKubernetes(ctx, deployment, detection); err != nil {
+			log.Printf("❌ Kubernetes deployment failed for deployment %d: %v", deployment.ID, err)
+			// The image stays built: a cluster API that is briefly
+			// unavailable only delays the rollout
+			if s.deferrable(ctx, err) {
+				return s.deferRollout(deployment, build, err)
+			}
+			return &DeployError{Err: fmt.Errorf("kubernetes deployment failed: %w", err)}
+		}
+		deployment.Status = models.StatusDeploying
+		if err := s.runHooks(ctx, hooks.EventDeployed, build, deployment); err != nil {
+			return &DeployError{Err: err}
+		}
+		reason := "live at " + deployment.Hostname
+		switch {
+		case deployment.Project.Worker():
+			reason = "worker " + deployment.K8sDeploymentName + " running"
+		case deployment.Project.Internal():
+			reason = "live at " + kubernetes.ServiceURL(deployment.K8sDeploymentName)
 		}
-		log.Printf("✅ Successfully deployed to Kubernetes: %s", deployment.Hostname)
-		deployment.Status = "deployed"
-		database.DB.Save(deployment)
+		log.Printf("✅ Successfully deployed to Kubernetes: %s", reason)
+		finishSteps(build)
+		if err := models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusDeployed, reason); err != nil {
+			return err
+		}
+		if err := activations.Record(database.DB, deployment, build.ImageDigest, time.Now()); err != nil {
+			log.Printf("⚠️  Deployment %d: failed to record its activation: %v", deployment.ID, err)
+		}
+		s.finishMigration(ctx, deployment)
 	} else {
 		log.Println("⚠️  Kubernetes client not available, skipping deployment")
 	}
@@ -115,117 +338,462 @@ func (s *Service) BuildDeployment(ctx context.Context, deploymentID uint) error
 	return nil
 }
 
-func (s *Service) deployToKubernetes(ctx context.Context, deployment *models.Deployment) error {
-	// Always assign/update hostname (Vercel-style: persistent per project)
-	hostname, err := s.hostnameMgr.AssignHostname(deployment.ProjectID, deployment.ID, deployment.CommitSHA)
+func (s *Service) deployToKubernetes(ctx context.Context, deployment *models.Deployment, detection *Detection) error {
+	// Deploy to the project's cluster, recorded so later operations find the resources
+	client := s.k8sClients.ForProject(&deployment.Project)
+	if client == nil {
+		return fmt.Errorf("cluster %s is not available", s.k8sClients.ClusterOf(&deployment.Project))
+	}
+	deployment.Cluster = s.k8sClients.ClusterOf(&deployment.Project)
+
+	unlock, err := s.projectLocks.lock(ctx, deployment.ProjectID)
 	if err != nil {
-		return fmt.Errorf("failed to assign hostname: %w", err)
+		return err
+	}
+	defer unlock()
+
+	// A deployment that finishes building after a newer one of the same
+	// resources went live must not roll them back
+	deployment.K8sDeploymentName = resourceName(deployment)
+	if err := checkNewerWins(deployment); err != nil {
+		return err
+	}
+
+	// Always assign/update hostname (Vercel-style: persistent per project).
+	// Branches other than the production branch get their own resources behind a stable alias.
+	// Manual deployments may name their target instead. Internal projects and workers get no hostname.
+	var hostname string
+	if deployment.Project.Served() {
+		switch {
+		case deployment.Target == models.TargetPreview:
+			hostname, err = s.hostnameMgr.AssignPreview(deployment.ProjectID, deployment.ID, deployment.Hostname)
+		case deployment.Target != models.TargetProduction && isBranchDeployment(deployment):
+			hostname, err = s.hostnameMgr.AssignBranchAlias(deployment.ProjectID, deployment.Branch, deployment.ID)
+		default:
+			if hostname, err = s.hostnameMgr.AssignHostname(deployment.ProjectID, deployment.ID, deployment.CommitSHA); err == nil {
+				deployment.Project.CustomDomains, err = s.hostnameMgr.CustomDomains(deployment.ProjectID)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to assign hostname: %w", err)
+		}
 	}
 	deployment.Hostname = hostname
-	database.DB.Save(deployment)
+	database.DB.Model(deployment).Select("hostname", "k8s_deployment_name", "cluster").Updates(deployment)
 
-	// Prepare environment variables (can be extended to load from project settings)
+	// Prepare environment variables
 	envVars := map[string]string{
 		"PORT": "8080",
 	}
+	// Values found during detection (e.g. compose environment/ports) override defaults
+	for k, v := range detection.Env {
+		envVars[k] = v
+	}
+	// The project's env vars override detection, secret references resolved
+	if err := s.loadProjectEnv(ctx, deployment, envVars); err != nil {
+		return err
+	}
+	if detection.Port > 0 {
+		envVars["PORT"] = strconv.Itoa(detection.Port)
+	}
+	// The project's port setting overrides detection
+	if deployment.Project.Port > 0 {
+		envVars["PORT"] = strconv.Itoa(deployment.Project.Port)
+	}
+	// Workers listen on no port
+	port := envVars["PORT"]
+	if deployment.Project.Worker() {
+		delete(envVars, "PORT")
+		port = ""
+	}
+	// The owner's internal projects are reachable at SERVICE_<SLUG>_URL
+	siblings, err := models.InternalSiblings(database.DB, &deployment.Project)
+	if err != nil {
+		return fmt.Errorf("failed to look up internal services: %w", err)
+	}
+	for k, v := range kubernetes.ServiceEnv(siblings) {
+		if _, set := envVars[k]; !set {
+			envVars[k] = v
+		}
+	}
+
+	// Previews get resources of their own, e.g. a database, before anything
+	// runs with their env
+	if err := s.provisionPreview(ctx, client, deployment, envVars); err != nil {
+		return err
+	}
+
+	// Broken manifest patches fail the deploy before anything is applied
+	if _, err := kubernetes.BuildPatchedManifests(deployment, hostname, envVars); err != nil {
+		deployment.FailureCategory = models.FailurePatchInvalid
+		deployment.FailureDetail = "The project's manifest patches no longer apply: " + err.Error()
+		database.DB.Model(deployment).Select("failure_category", "failure_detail").Updates(deployment)
+		return err
+	}
+
+	if err := applyPullSecret(ctx, client, &deployment.Project); err != nil {
+		return err
+	}
+	if err := s.applyPlatformPullSecret(ctx, deployment.Cluster, client); err != nil {
+		return err
+	}
+
+	// Migrations and the like run against the new image before it serves traffic
+	if err := s.runRelease(ctx, client, deployment, envVars); err != nil {
+		return err
+	}
 
 	// Update Kubernetes deployment (or create if doesn't exist)
 	// This will update the existing deployment to point to the new image
-	if err := s.k8sClient.CreateOrUpdateDeployment(ctx, deployment, hostname, envVars); err != nil {
+	live := hasLiveDeployment(deployment)
+	if err := client.CreateOrUpdateDeployment(ctx, deployment, hostname, envVars); err != nil {
 		return fmt.Errorf("failed to create/update kubernetes resources: %w", err)
 	}
+	// Until the first deployment of these resources is ready, visitors get
+	// the placeholder page; if it fails, they keep getting it
+	if !live {
+		if err := client.ShowPlaceholder(ctx, deployment, hostname); err != nil {
+			log.Printf("⚠️  Deployment %d: failed to show the placeholder page: %v", deployment.ID, err)
+		}
+	}
 
+	if err := s.waitForRollout(ctx, client, deployment, port); err != nil {
+		return err
+	}
+	if !live {
+		if err := client.ShowDeployment(ctx, deployment, hostname); err != nil {
+			return fmt.Errorf("failed to route %s to the deployment: %w", hostname, err)
+		}
+	}
 	return nil
 }
 
-func (s *Service) cloneRepo(repoURL, path, branch string) error {
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+// hasLiveDeployment reports whether another deployment of the same resources
+// is live, so they already serve traffic while this one rolls out
+func hasLiveDeployment(deployment *models.Deployment) bool {
+	var live int64
+	database.DB.Model(&models.Deployment{}).
+		Where("project_id = ? AND k8s_deployment_name = ? AND status = ? AND id <> ?", deployment.ProjectID, deployment.K8sDeploymentName, models.StatusDeployed, deployment.ID).
+		Count(&live)
+	return live > 0
+}
+
+// runHooks runs the hooks of eventType for deployment, adding the failures
+// of non-blocking ones to the build's warnings. A blocking hook's failure is
+// returned, with the deployment's failure category set.
+func (s *Service) runHooks(ctx context.Context, eventType string, build *models.Build, deployment *models.Deployment) error {
+	warnings, err := hooks.Dispatch(ctx, hooks.NewEvent(eventType, deployment))
+	if len(warnings) > 0 {
+		build.Warnings = append(build.Warnings, warnings...)
+		database.DB.Model(build).Select("warnings").Updates(build)
+	}
+	var blocking *hooks.BlockingError
+	if errors.As(err, &blocking) {
+		deployment.FailureCategory = models.FailureHookFailed
+		deployment.FailureDetail = "The " + blocking.Hook + " hook failed: " + blocking.Err.Error()
+		database.DB.Model(deployment).Select("failure_category", "failure_detail").Updates(deployment)
 	}
+	return err
+}
 
-	// Clone repository using go-git
-	_, err := git.PlainClone(path, false, &git.CloneOptions{
-		URL:           repoURL,
-		SingleBranch:  true,
-		ReferenceName: plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch)),
-		Progress:      os.Stdout, // Optional: show clone progress
-	})
+// SetRolloutTimeout sets how long deploys wait for the app's pods to become
+// ready before failing with a diagnosis; 0 doesn't wait
+func (s *Service) SetRolloutTimeout(timeout time.Duration) {
+	s.rolloutTimeout = timeout
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
+// waitForRollout waits for the deployment's pods to accept connections on
+// port, or with no port (workers) for their container to be running. A rollout that times out fails the deployment with its diagnosis,
+// e.g. the app listening on another port, recorded as its failure detail.
+// One whose pods crash-loop fails without waiting out the timeout. Either
+// way the newest pod's last termination and logs go to the build logs.
+func (s *Service) waitForRollout(ctx context.Context, client *kubernetes.Client, deployment *models.Deployment, port string) error {
+	if s.rolloutTimeout <= 0 {
+		return nil
+	}
+	if port == "" {
+		log.Printf("⏳ Deployment %d: waiting for worker pods to start", deployment.ID)
+	} else {
+		log.Printf("⏳ Deployment %d: waiting for pods to become ready on port %s", deployment.ID, port)
 	}
 
-	return nil
+	portNumber, _ := strconv.Atoi(port)
+	err := client.WaitForRollout(ctx, deployment.K8sDeploymentName, int32(portNumber), s.rolloutTimeout)
+	var rolloutErr *kubernetes.RolloutError
+	if errors.As(err, &rolloutErr) {
+		log.Printf("❌ Deployment %d: rollout not ready: %s", deployment.ID, rolloutErr.Reason)
+		appendLogSection(deployment.ID, rolloutLogs(rolloutErr))
+		deployment.FailureCategory = rolloutErr.Category()
+		switch {
+		case rolloutErr.Diagnosis != nil:
+			deployment.FailureDetail = rolloutErr.Diagnosis.Detail
+		case rolloutErr.CrashLoop:
+			exit := rolloutErr.Termination
+			if exit == "" {
+				exit = rolloutErr.Reason
+			}
+			deployment.FailureDetail = "The app kept exiting at startup (" + exit + "): see the Rollout section of the build logs"
+		}
+		database.DB.Model(deployment).Select("failure_category", "failure_detail").Updates(deployment)
+	}
+	return err
 }
 
-func (s *Service) detectAndCreateDockerfile(repoPath string) (string, error) {
-	// Check if Dockerfile exists
-	if _, err := os.Stat(filepath.Join(repoPath, "Dockerfile")); err == nil {
-		return "Dockerfile", nil
+const rolloutLogHeader = "=== Rollout ==="
+
+// rolloutLogs is the build logs section of a failed rollout
+func rolloutLogs(rolloutErr *kubernetes.RolloutError) string {
+	section := rolloutLogHeader + "\n❌ " + rolloutErr.Error() + "\n"
+	if rolloutErr.Pod == "" {
+		return section + "No pod was created\n"
+	}
+	section += "Pod: " + rolloutErr.Pod + "\n"
+	if rolloutErr.Termination != "" {
+		section += "Last termination: " + rolloutErr.Termination + "\n"
+	}
+	if logs := strings.TrimRight(rolloutErr.Logs, "\n"); logs != "" {
+		section += "Recent logs:\n" + logs + "\n"
+	}
+	return section
+}
+
+// recordDetection stores the detected framework on the build and writes
+// framework hints (such as missing secrets) to the build logs
+func (s *Service) recordDetection(build *models.Build, deployment *models.Deployment, detection *Detection) {
+	warnings := append(detection.Warnings, detection.missingEnvWarnings(definedEnv(deployment.ProjectID))...)
+	for _, warning := range warnings {
+		log.Printf("⚠️  Deployment %d: %s", deployment.ID, warning)
 	}
 
-	// Auto-generate Dockerfile based on detected language
-	// This is simplified - you can expand this
-	if _, err := os.Stat(filepath.Join(repoPath, "package.json")); err == nil {
-		return s.createNodeDockerfile(repoPath)
+	build.Framework = detection.Framework
+	build.FrameworkVersion = detection.FrameworkVersion
+	if detection.Runtime != nil {
+		build.Runtime = detection.Runtime.Runtime
+		build.RuntimeVersion = detection.Runtime.Version
+		build.RuntimeSource = detection.Runtime.Source
+	}
+	build.Warnings = warnings
+	if len(warnings) > 0 {
+		build.Logs = "⚠️  " + strings.Join(warnings, "\n⚠️  ")
 	}
+	database.DB.Model(build).Select("framework", "framework_version", "runtime", "runtime_version", "runtime_source", "logs", "warnings").Updates(build)
+}
+
+// definedEnv is the set of env var keys a project defines
+func definedEnv(projectID uint) map[string]bool {
+	var keys []string
+	database.DB.Model(&models.Environment{}).Where("project_id = ?", projectID).Pluck("key", &keys)
+	defined := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		defined[key] = true
+	}
+	return defined
+}
+
+// TeardownBranch removes the Kubernetes resources of a deleted branch, from
+// the cluster its last deployment went to, and retires its preview
+// environment. Its alias gets the placeholder page until the branch is
+// deployed again.
+func (s *Service) TeardownBranch(ctx context.Context, projectID uint, branch string) error {
+	if s.k8sClients == nil {
+		return nil
+	}
+	name := branchResourceName(projectID, branch)
+	var last models.Deployment
+	database.DB.Preload("Project").Where("project_id = ? AND k8s_deployment_name = ?", projectID, name).Order("id DESC").First(&last)
+	client := s.k8sClients.Client(last.Cluster)
+	if client == nil {
+		return fmt.Errorf("cluster %s is not available", last.Cluster)
+	}
+	if err := client.DeleteDeployment(ctx, name); err != nil {
+		return err
+	}
+	if err := activations.End(database.DB, projectID, name, models.DeactivateBranch); err != nil {
+		log.Printf("⚠️  Failed to end the activation of branch %s (project %d): %v", branch, projectID, err)
+	}
+	s.previews.Retire(projectID, name)
+	if last.ID != 0 {
+		return client.ShowPlaceholder(ctx, &last, last.Hostname)
+	}
+	return nil
+}
 
-	if _, err := os.Stat(filepath.Join(repoPath, "requirements.txt")); err == nil {
-		return s.createPythonDockerfile(repoPath)
+// finishMigration tears down the cluster a project is moving away from once
+// its production deployment is live on the new one. Failures are logged and
+// leave MigratingFrom set, so the next production deploy retries.
+func (s *Service) finishMigration(ctx context.Context, deployment *models.Deployment) {
+	from := deployment.Project.MigratingFrom
+	if from == "" || deployment.K8sDeploymentName != kubernetes.ProjectResourceName(deployment.ProjectID) {
+		return
+	}
+	if old := s.k8sClients.Client(from); old != nil {
+		if err := old.DeleteProjectResources(ctx, deployment.ProjectID); err != nil {
+			log.Printf("❌ Project %d: failed to tear down cluster %s: %v", deployment.ProjectID, from, err)
+			return
+		}
+	} else {
+		log.Printf("⚠️  Project %d: cluster %s is not available, leaving its resources behind", deployment.ProjectID, from)
 	}
+	database.DB.Model(&models.Project{}).Where("id = ? AND migrating_from = ?", deployment.ProjectID, from).Update("migrating_from", "")
+	log.Printf("🚚 Project %d moved from cluster %s to %s", deployment.ProjectID, from, deployment.Cluster)
+}
 
-	if _, err := os.Stat(filepath.Join(repoPath, "go.mod")); err == nil {
-		return s.createGoDockerfile(repoPath)
+// resourceName names the Kubernetes resources a deployment rolls out to:
+// its own for previews, its branch's for other branches (unless it targets
+// production), the project's otherwise
+func resourceName(deployment *models.Deployment) string {
+	switch {
+	case deployment.Target == models.TargetPreview:
+		return previewResourceName(deployment.ProjectID, deployment.CommitSHA)
+	case deployment.Target != models.TargetProduction && isBranchDeployment(deployment):
+		return branchResourceName(deployment.ProjectID, deployment.Branch)
 	}
+	return kubernetes.ProjectResourceName(deployment.ProjectID)
+}
 
-	return "", fmt.Errorf("could not detect project type")
+// isBranchDeployment reports whether the deployment is for a branch other than
+// the project's production branch
+func isBranchDeployment(deployment *models.Deployment) bool {
+	return deployment.Branch != "" && deployment.Project.Branch != "" && deployment.Branch != deployment.Project.Branch
 }
 
-func (s *Service) createNodeDockerfile(repoPath string) (string, error) {
-	dockerfile := `FROM node:18-alpine
-WORKDIR /app
-COPY package*.json ./
-RUN npm install
-COPY . .
-RUN npm run build
-EXPOSE 3000
-CMD ["npm", "start"]`
-
-	path := filepath.Join(repoPath, "Dockerfile")
-	return "Dockerfile", os.WriteFile(path, []byte(dockerfile), 0644)
+// branchResourceName names the Kubernetes resources of a branch deployment,
+// kept within the 63 character limit of a Service name
+func branchResourceName(projectID uint, branch string) string {
+	prefix := kubernetes.ProjectResourceName(projectID) + "-"
+	return prefix + hostname.BranchLabel(branch, hostname.MaxLabelLength-len(prefix))
+}
+
+// previewResourceName names the Kubernetes resources of a preview deployment
+func previewResourceName(projectID uint, sha string) string {
+	return fmt.Sprintf("%s-sha-%s", kubernetes.ProjectResourceName(projectID), textutil.ShortSHA(sha))
+}
+
+// cloneReference is the ref to clone for a deployment: its explicit ref, its
+// branch, or "" (every branch) for a commit deployed on its own
+func cloneReference(deployment *models.Deployment) plumbing.ReferenceName {
+	if deployment.Ref != "" {
+		return plumbing.ReferenceName(deployment.Ref)
+	}
+	if deployment.Branch != "" {
+		return plumbing.NewBranchReferenceName(deployment.Branch)
+	}
+	return ""
+}
+
+// cloneRepo clones ref of the project's repository into path (every branch
+// when ref is empty) and checks out commitSHA (the ref's head when empty),
+// with its submodules and Git LFS files. With the clone cache on, the work
+// tree is cloned from the repository's mirror once it is fetched.
+func (s *Service) cloneRepo(ctx context.Context, project *models.Project, path string, ref plumbing.ReferenceName, commitSHA string, progress io.Writer) (cloneStats, error) {
+	var stats cloneStats
+	// Create directory if it doesn't exist
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return stats, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	auth, err := cloneAuth(project)
+	if err != nil {
+		return stats, err
+	}
+
+	var repo *git.Repository
+	if s.mirrors != nil {
+		repo, err = s.cloneFromMirror(ctx, project, path, ref, commitSHA, auth, progress, &stats)
+		if err != nil {
+			return stats, err
+		}
+	}
+	if repo == nil {
+		// Clone repository using go-git
+		repo, err = git.PlainCloneContext(ctx, path, false, &git.CloneOptions{
+			URL:           project.RepoURL,
+			Auth:          auth,
+			SingleBranch:  ref != "",
+			ReferenceName: ref,
+			Progress:      io.MultiWriter(os.Stdout, progress), // Show clone progress
+		})
+		if err != nil {
+			return stats, fmt.Errorf("failed to clone repository: %w", explainCloneError(project, auth, err))
+		}
+		if commitSHA != "" {
+			if err := checkoutCommit(repo, ref, commitSHA); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	// Submodules are checked out at the commits the checked out tree records
+	if err := updateSubmodules(ctx, repo, project.RepoURL, auth, 0, progress); err != nil {
+		return stats, err
+	}
+
+	if usesLFS(path) {
+		if s.lfsDisabled {
+			return stats, ErrLFSNotSupported
+		}
+		if err := fetchLFS(ctx, path, project.RepoURL, auth, progress); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
 }
 
-func (s *Service) createPythonDockerfile(repoPath string) (string, error) {
-	dockerfile := `FROM python:3.11-slim
-WORKDIR /app
-COPY requirements.txt .
-RUN pip install -r requirements.txt
-COPY . .
-EXPOSE 8000
-CMD ["python", "app.py"]`
-
-	path := filepath.Join(repoPath, "Dockerfile")
-	return "Dockerfile", os.WriteFile(path, []byte(dockerfile), 0644)
+// cloneFromMirror clones the work tree from the repository's mirror and
+// checks out commitSHA. It returns no repository when the network has to be
+// used instead: the mirror couldn't be fetched, or cloning from it failed,
+// which discards it.
+func (s *Service) cloneFromMirror(ctx context.Context, project *models.Project, path string, ref plumbing.ReferenceName, commitSHA string, auth transport.AuthMethod, progress io.Writer, stats *cloneStats) (*git.Repository, error) {
+	m, err := s.mirrors.update(ctx, project.RepoURL, auth, progress, stats)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("⚠️  Clone cache unavailable for %s, cloning from the network: %v", project.RepoURL, err)
+		return nil, nil
+	}
+	fmt.Fprintf(progress, "Fetched %s into the clone cache in %s\n", project.RepoURL, stats.Fetch.Round(time.Millisecond))
+
+	repo, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{
+		URL:           m.URL(),
+		SingleBranch:  ref != "",
+		ReferenceName: ref,
+	})
+	if err == nil && commitSHA != "" {
+		if err = checkoutCommit(repo, ref, commitSHA); err != nil {
+			// Missing from a fresh fetch means missing upstream too
+			m.release()
+			return nil, err
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			m.release()
+			return nil, ctx.Err()
+		}
+		log.Printf("⚠️  Cloning %s from its mirror failed, discarding the mirror: %v", project.RepoURL, err)
+		s.mirrors.discard(m)
+		stats.CacheHit = false
+		if err := resetDir(path); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	m.release()
+	go s.mirrors.evict()
+	// Relative submodule URLs resolve against origin
+	if err := setOrigin(repo, project.RepoURL); err != nil {
+		return nil, err
+	}
+	return repo, nil
 }
 
-func (s *Service) createGoDockerfile(repoPath string) (string, error) {
-	dockerfile := `FROM golang:1.21-alpine AS builder
-WORKDIR /app
-COPY go.mod go.sum ./
-RUN go mod download
-COPY . .
-RUN go build -o app .
-
-FROM alpine:latest
-RUN apk --no-cache add ca-certificates
-WORKDIR /root/
-COPY --from=builder /app/app .
-EXPOSE 8080
-CMD ["./app"]`
-
-	path := filepath.Join(repoPath, "Dockerfile")
-	return "Dockerfile", os.WriteFile(path, []byte(dockerfile), 0644)
+// resetDir empties dir for another clone
+func resetDir(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0755)
 }
 
 func (s *Service) createBuildContext(repoPath string) (io.Reader, error) {
@@ -0,0 +1,69 @@
+package build
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrSuperseded stops a deployment for a newer deployment of its branch
+var ErrSuperseded = errors.New("superseded by a newer deployment")
+
+// SupersedeBuilds marks superseded the builds still running, and the
+// deployments awaiting approval or blocked by policy, for older deployments of deployment's
+// branch and target, returning their IDs so the caller can cancel their
+// jobs. Those not started yet, pending or queued, are skipped: their workers
+// refuse them. Deployments already rolling out are left to finish: checkNewerWins
+// keeps them from replacing the newer one once it is live. Previews deploy
+// each commit on its own and never supersede anything.
+func SupersedeBuilds(deployment *models.Deployment) []uint {
+	if deployment.Target == models.TargetPreview {
+		return nil
+	}
+	var older []models.Deployment
+	database.DB.Select("id", "status").
+		Where("project_id = ? AND branch = ? AND id < ? AND commit_sha <> ? AND status IN ?",
+			deployment.ProjectID, deployment.Branch, deployment.ID, deployment.CommitSHA,
+			[]models.DeploymentStatus{models.StatusPending, models.StatusQueued, models.StatusBuilding, models.StatusAwaitingApproval, models.StatusPolicyBlocked, models.StatusDeployPending}).
+		Where("COALESCE(target, '') = ?", deployment.Target).
+		Find(&older)
+
+	reason := fmt.Sprintf("superseded by deployment %d (%s)", deployment.ID, textutil.ShortSHA(deployment.CommitSHA))
+	var ids []uint
+	for _, d := range older {
+		to := models.StatusSuperseded
+		if d.Status == models.StatusPending || d.Status == models.StatusQueued {
+			to = models.StatusSkipped
+		}
+		// Moved on to its rollout in the meantime: checkNewerWins takes over
+		if err := models.SetDeploymentStatusFrom(database.DB, d.ID, d.Status, to, reason); err != nil {
+			continue
+		}
+		log.Printf("⏭️  Deployment %d %s: %s", d.ID, to, reason)
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+// checkNewerWins returns ErrSuperseded when a newer deployment of the same
+// resources is already live: rolling this one out would bring back older
+// code. Callers hold the project lock, so no newer rollout is in progress.
+// Previews have resources of their own.
+func checkNewerWins(deployment *models.Deployment) error {
+	if deployment.Target == models.TargetPreview {
+		return nil
+	}
+	var newer models.Deployment
+	err := database.DB.Select("id", "commit_sha").
+		Where("project_id = ? AND k8s_deployment_name = ? AND id > ? AND status = ?",
+			deployment.ProjectID, deployment.K8sDeploymentName, deployment.ID, models.StatusDeployed).
+		Order("id DESC").First(&newer).Error
+	if err != nil {
+		return nil
+	}
+	log.Printf("⏭️  Deployment %d not rolled out: deployment %d is already live", deployment.ID, newer.ID)
+	return fmt.Errorf("%w: deployment %d (%s) is already live", ErrSuperseded, newer.ID, textutil.ShortSHA(newer.CommitSHA))
+}
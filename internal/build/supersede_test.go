@@ -0,0 +1,99 @@
+package build
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// A new deployment supersedes what its branch still has on the way, and
+// leaves alone rollouts, other branches, other targets and previews
+func TestSupersedeBuilds(t *testing.T) {
+	testutil.DB(t)
+	project := &models.Project{Name: "app", Slug: "app", Branch: "main"}
+	database.DB.Create(project)
+	create := func(branch, sha, target string, status models.DeploymentStatus) *models.Deployment {
+		d := &models.Deployment{ProjectID: project.ID, Branch: branch, CommitSHA: sha, Target: target, Status: status}
+		if err := database.DB.Create(d).Error; err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	sha := func(n int) string { return fmt.Sprintf("%040x", n) }
+
+	queued := create("main", sha(1), "", models.StatusQueued)
+	building := create("main", sha(2), "", models.StatusBuilding)
+	approval := create("main", sha(3), "", models.StatusAwaitingApproval)
+	retried := create("main", sha(4), "", models.StatusDeployPending)
+	deploying := create("main", sha(5), "", models.StatusDeploying)
+	redeploy := create("main", sha(9), "", models.StatusBuilding) // Same commit
+	staging := create("staging", sha(6), "", models.StatusBuilding)
+	production := create("main", sha(7), models.TargetProduction, models.StatusBuilding)
+	preview := create("main", sha(8), models.TargetPreview, models.StatusBuilding)
+	newest := create("main", sha(9), "", models.StatusQueued)
+
+	ids := SupersedeBuilds(newest)
+	want := map[uint]models.DeploymentStatus{
+		queued.ID:     models.StatusSkipped,
+		building.ID:   models.StatusSuperseded,
+		approval.ID:   models.StatusSuperseded,
+		retried.ID:    models.StatusSuperseded,
+		deploying.ID:  models.StatusDeploying,
+		redeploy.ID:   models.StatusBuilding,
+		staging.ID:    models.StatusBuilding,
+		production.ID: models.StatusBuilding,
+		preview.ID:    models.StatusBuilding,
+		newest.ID:     models.StatusQueued,
+	}
+	for id, status := range want {
+		if got := deploymentStatus(t, id); got != status {
+			t.Errorf("deployment %d is %s, want %s", id, got, status)
+		}
+	}
+	if fmt.Sprint(ids) != fmt.Sprint([]uint{queued.ID, building.ID, approval.ID, retried.ID}) {
+		t.Errorf("jobs to cancel %v", ids)
+	}
+
+	// A preview supersedes nothing, not even an older preview of its branch
+	if ids := SupersedeBuilds(create("main", sha(10), models.TargetPreview, models.StatusQueued)); len(ids) != 0 || deploymentStatus(t, preview.ID) != models.StatusBuilding {
+		t.Errorf("preview superseded %v", ids)
+	}
+}
+
+// A deployment that finishes its build after a newer one of the same
+// resources went live isn't rolled out
+func TestCheckNewerWins(t *testing.T) {
+	testutil.DB(t)
+	project := &models.Project{Name: "app", Slug: "app", Branch: "main"}
+	database.DB.Create(project)
+	name := kubernetes.ProjectResourceName(project.ID)
+	older := &models.Deployment{ProjectID: project.ID, Branch: "main", CommitSHA: fmt.Sprintf("%040x", 1), Status: models.StatusDeploying, K8sDeploymentName: name}
+	database.DB.Create(older)
+
+	if err := checkNewerWins(older); err != nil {
+		t.Fatalf("nothing newer: %v", err)
+	}
+	// Newer deployments of other resources, or not live, don't count
+	database.DB.Create(&models.Deployment{ProjectID: project.ID, Branch: "staging", Status: models.StatusDeployed, K8sDeploymentName: name + "-staging"})
+	database.DB.Create(&models.Deployment{ProjectID: project.ID, Branch: "main", Status: models.StatusFailed, K8sDeploymentName: name})
+	if err := checkNewerWins(older); err != nil {
+		t.Fatalf("nothing newer live: %v", err)
+	}
+
+	newer := &models.Deployment{ProjectID: project.ID, Branch: "main", CommitSHA: fmt.Sprintf("%040x", 2), Status: models.StatusDeployed, K8sDeploymentName: name}
+	database.DB.Create(newer)
+	err := checkNewerWins(older)
+	if want := fmt.Sprintf("deployment %d (%.7s) is already live", newer.ID, newer.CommitSHA); !errors.Is(err, ErrSuperseded) || err.Error() != ErrSuperseded.Error()+": "+want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+
+	// Previews have resources of their own
+	older.Target = models.TargetPreview
+	if err := checkNewerWins(older); err != nil {
+		t.Errorf("preview: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/storage"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Media types of the supply chain records
+const (
+	SBOMMediaType       = "application/vnd.cyclonedx+json"
+	ProvenanceMediaType = "application/vnd.dsse.envelope.v1+json"
+)
+
+// ArtifactKeyPrefix prefixes the storage keys of supply chain records
+const ArtifactKeyPrefix = "supply-chain"
+
+const (
+	sbomTimeout     = 5 * time.Minute
+	maxSBOMSize     = 64 << 20
+	sbomErrorOutput = 4 << 10 // Bytes of the generator's stderr kept in errors
+)
+
+// supplyChain configures the records kept of successful builds
+type supplyChain struct {
+	builderID     string          // URI identifying this platform as the builder
+	sbomGenerator string          // syft binary, empty = no SBOMs
+	signer        *Signer         // nil = unsigned provenance
+	strict        bool            // Builds whose records can't be made fail
+	artifacts     storage.Storage // Where records are kept, nil = in the database
+}
+
+// SetSupplyChain makes successful builds record an SBOM of their image
+// generated by sbomGenerator (syft, empty = none) and a provenance record
+// naming builderID as the builder, signed by signer (nil = unsigned). When
+// strict, builds whose records can't be made fail; otherwise they are warned.
+func (s *Service) SetSupplyChain(builderID, sbomGenerator string, signer *Signer, strict bool) {
+	s.supplyChain = supplyChain{builderID: builderID, sbomGenerator: sbomGenerator, signer: signer, strict: strict, artifacts: s.supplyChain.artifacts}
+}
+
+// SetArtifactStorage keeps supply chain records in store, under keys named
+// after their content, rather than in the database
+func (s *Service) SetArtifactStorage(store storage.Storage) {
+	s.supplyChain.artifacts = store
+}
+
+// SupplyChainError fails a build whose SBOM or provenance couldn't be
+// recorded under SUPPLY_CHAIN_STRICT
+type SupplyChainError struct {
+	Problems []string
+}
+
+func (e *SupplyChainError) Error() string {
+	return "supply chain records incomplete: " + strings.Join(e.Problems, "; ")
+}
+
+// recordSupplyChain stores the SBOM and provenance of the image built for
+// deployment. Problems are build warnings, unless strict mode makes them fail
+// the deployment.
+func (s *Service) recordSupplyChain(ctx context.Context, build *models.Build, deployment *models.Deployment, detection *Detection, imageTag string) error {
+	var problems []string
+	if s.supplyChain.sbomGenerator != "" {
+		if err := s.recordSBOM(ctx, build, imageTag); err != nil {
+			problems = append(problems, "SBOM: "+err.Error())
+		}
+	}
+	incomplete, err := s.recordProvenance(ctx, build, deployment, detection, imageTag)
+	if err != nil {
+		problems = append(problems, "provenance: "+err.Error())
+	}
+	problems = append(problems, incomplete...)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	if s.supplyChain.strict {
+		deployment.FailureCategory = models.FailureSupplyChain
+		database.DB.Model(deployment).Update("failure_category", deployment.FailureCategory)
+		return &SupplyChainError{Problems: problems}
+	}
+	for _, problem := range problems {
+		log.Printf("⚠️  Deployment %d: %s", deployment.ID, problem)
+		build.Warnings = append(build.Warnings, "Supply chain: "+problem)
+	}
+	database.DB.Model(build).Select("warnings").Updates(build)
+	return nil
+}
+
+// recordSBOM stores the CycloneDX SBOM of the local image imageTag
+func (s *Service) recordSBOM(ctx context.Context, build *models.Build, imageTag string) error {
+	sbom, err := generateSBOM(ctx, s.supplyChain.sbomGenerator, imageTag)
+	if err != nil {
+		return err
+	}
+	return s.storeArtifact(ctx, build.ID, models.ArtifactSBOM, SBOMMediaType, sbom)
+}
+
+// generateSBOM runs syft on the local image imageTag and checks it produced
+// a CycloneDX document
+func generateSBOM(ctx context.Context, generator, imageTag string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, sbomTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	stderr := &cappedBuffer{limit: sbomErrorOutput}
+	cmd := exec.CommandContext(ctx, generator, "docker:"+imageTag, "-o", "cyclonedx-json", "-q")
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("%s is not installed", generator)
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s timed out after %s", generator, sbomTimeout)
+		}
+		if out := strings.TrimSpace(stderr.String()); out != "" {
+			return nil, fmt.Errorf("%s failed: %v: %s", generator, err, out)
+		}
+		return nil, fmt.Errorf("%s failed: %v", generator, err)
+	}
+	if stdout.Len() > maxSBOMSize {
+		return nil, fmt.Errorf("SBOM is %s, over the %s limit", formatSize(int64(stdout.Len())), formatSize(maxSBOMSize))
+	}
+
+	var document struct {
+		BOMFormat  string            `json:"bomFormat"`
+		Components []json.RawMessage `json:"components"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &document); err != nil || document.BOMFormat != "CycloneDX" {
+		return nil, fmt.Errorf("%s did not output a CycloneDX document", generator)
+	}
+	return stdout.Bytes(), nil
+}
+
+// storeArtifact stores content gzipped as the build's record of kind, in the
+// artifact storage when there is one
+func (s *Service) storeArtifact(ctx context.Context, buildID uint, kind, mediaType string, content []byte) error {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(content); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	artifact := models.BuildArtifact{
+		BuildID:   buildID,
+		Kind:      kind,
+		MediaType: mediaType,
+		Data:      compressed.Bytes(),
+		Size:      int64(len(content)),
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+	if store := s.supplyChain.artifacts; store != nil {
+		// Identical records, e.g. the SBOMs of rebuilds of an unchanged
+		// image, share an object
+		key := storage.ContentKey(ArtifactKeyPrefix, artifact.SHA256, ".json.gz")
+		if _, err := store.Put(ctx, key, bytes.NewReader(artifact.Data)); err != nil {
+			return fmt.Errorf("failed to store %s: %w", kind, err)
+		}
+		artifact.Object = key
+		artifact.Data = nil
+	}
+	return database.DB.Create(&artifact).Error
+}
+
+// cappedBuffer keeps the first limit bytes written to it, discarding the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
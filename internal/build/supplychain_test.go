@@ -0,0 +1,202 @@
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/storage"
+	"deploy-platform/internal/testutil"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSyft writes a generator in place of syft: a script recording its
+// arguments and running body, given the path of the fixture SBOM of a
+// node:20-alpine app image as $1
+func fakeSyft(t *testing.T, body string) (generator, args string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	fixture, err := filepath.Abs(filepath.Join("testdata", "supplychain", "node-sbom.cdx.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	args = filepath.Join(dir, "args")
+	generator = filepath.Join(dir, "syft")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\nset -- " + fixture + "\n" + body + "\n"
+	if err := os.WriteFile(generator, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return generator, args
+}
+
+// storedArtifact reads back the record of kind of a build, gunzipped
+func storedArtifact(t *testing.T, store storage.Storage, buildID uint, kind string) (models.BuildArtifact, []byte) {
+	t.Helper()
+	var artifact models.BuildArtifact
+	if err := database.DB.Where("build_id = ? AND kind = ?", buildID, kind).First(&artifact).Error; err != nil {
+		t.Fatal(err)
+	}
+	var r io.Reader = bytes.NewReader(artifact.Data)
+	if artifact.Object != "" {
+		rc, err := store.Get(context.Background(), artifact.Object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		r = rc
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return artifact, content
+}
+
+// The SBOM recorded is the generator's CycloneDX document of the image,
+// listing the packages of every layer, with their package URLs
+func TestRecordSBOM(t *testing.T) {
+	generator, args := fakeSyft(t, `cat "$1"`)
+	fixture, err := os.ReadFile(filepath.Join("testdata", "supplychain", "node-sbom.cdx.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stored := range []bool{false, true} {
+		testutil.DB(t)
+		s := &Service{}
+		s.SetSupplyChain("https://deploy.example.com", generator, nil, false)
+		var store storage.Storage
+		if stored {
+			local, err := storage.NewLocal(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = local
+			s.SetArtifactStorage(store)
+		}
+		build := &models.Build{DeploymentID: 1, Status: "success"}
+		database.DB.Create(build)
+
+		if err := s.recordSBOM(context.Background(), build, "deploy-platform/app-7:0d1a26e"); err != nil {
+			t.Fatalf("stored %v: %v", stored, err)
+		}
+		if got, _ := os.ReadFile(args); strings.TrimSpace(string(got)) != "docker:deploy-platform/app-7:0d1a26e -o cyclonedx-json -q" {
+			t.Errorf("generator ran with %q", got)
+		}
+
+		artifact, content := storedArtifact(t, store, build.ID, models.ArtifactSBOM)
+		sum := sha256.Sum256(fixture)
+		if !bytes.Equal(content, fixture) || artifact.Size != int64(len(fixture)) || artifact.SHA256 != hex.EncodeToString(sum[:]) || artifact.MediaType != SBOMMediaType {
+			t.Fatalf("stored %v: artifact %+v, %d bytes", stored, artifact, len(content))
+		}
+		if stored && (len(artifact.Data) != 0 || artifact.Object != storage.ContentKey(ArtifactKeyPrefix, artifact.SHA256, ".json.gz")) {
+			t.Errorf("artifact kept in the database as well as %q", artifact.Object)
+		}
+
+		var sbom struct {
+			Components []struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+				PURL    string `json:"purl"`
+			} `json:"components"`
+		}
+		if err := json.Unmarshal(content, &sbom); err != nil {
+			t.Fatal(err)
+		}
+		purls := map[string]bool{}
+		for _, component := range sbom.Components {
+			purls[component.PURL] = true
+		}
+		for _, purl := range []string{
+			"pkg:npm/express@4.19.2",   // The app's dependencies
+			"pkg:npm/npm@10.5.0",       // The node image's
+			"pkg:generic/node@20.12.2", // Its runtime
+			"pkg:apk/alpine/musl@1.2.4_git20230717-r4?arch=x86_64&distro=alpine-3.19.1", // Its base's
+		} {
+			if !purls[purl] {
+				t.Errorf("stored %v: SBOM without %s", stored, purl)
+			}
+		}
+	}
+}
+
+func TestGenerateSBOMErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"exit", "echo 'could not fetch image: no such image' >&2; exit 1", "failed: exit status 1: could not fetch image: no such image"},
+		{"silent exit", "exit 2", "failed: exit status 2"},
+		{"spdx", `echo '{"spdxVersion": "SPDX-2.3"}'`, "did not output a CycloneDX document"},
+		{"not json", "echo 'Cataloged 412 packages'", "did not output a CycloneDX document"},
+	}
+	for _, tt := range tests {
+		generator, _ := fakeSyft(t, tt.body)
+		sbom, err := generateSBOM(context.Background(), generator, "app:latest")
+		if err == nil || !strings.HasSuffix(err.Error(), tt.err) || sbom != nil {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	if _, err := generateSBOM(context.Background(), "syft-not-installed", "app:latest"); err == nil || err.Error() != "syft-not-installed is not installed" {
+		t.Errorf("missing generator: %v", err)
+	}
+}
+
+// Records that can't be made warn the build, or fail it in strict mode
+func TestRecordSupplyChainProblems(t *testing.T) {
+	generator, _ := fakeSyft(t, "exit 1")
+	for _, strict := range []bool{false, true} {
+		testutil.DB(t)
+		deployment := &models.Deployment{ProjectID: 1, Status: models.StatusBuilding}
+		database.DB.Create(deployment)
+		// Without the Dockerfile it was built from, there is no provenance
+		build := &models.Build{DeploymentID: deployment.ID, Status: "running", Warnings: []string{"image is 812 MB"}}
+		database.DB.Create(build)
+		s := &Service{}
+		s.SetSupplyChain("https://deploy.example.com", generator, nil, strict)
+
+		err := s.recordSupplyChain(context.Background(), build, deployment, &Detection{Type: "dockerfile", Dockerfile: "Dockerfile"}, "app:latest")
+		var current models.Build
+		database.DB.First(&current, build.ID)
+		var failed models.Deployment
+		database.DB.First(&failed, deployment.ID)
+		problems := []string{"SBOM: " + generator + " failed: exit status 1", "provenance: the Dockerfile used is unknown"}
+
+		if strict {
+			var incomplete *SupplyChainError
+			if !errors.As(err, &incomplete) || strings.Join(incomplete.Problems, "\n") != strings.Join(problems, "\n") {
+				t.Fatalf("strict: got %v", err)
+			}
+			if failed.FailureCategory != models.FailureSupplyChain || len(current.Warnings) != 1 {
+				t.Errorf("strict: failure category %q, warnings %q", failed.FailureCategory, current.Warnings)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %v", err)
+		}
+		want := []string{"image is 812 MB", "Supply chain: " + problems[0], "Supply chain: " + problems[1]}
+		if strings.Join(current.Warnings, "\n") != strings.Join(want, "\n") || failed.FailureCategory != "" {
+			t.Errorf("warnings %q, failure category %q", current.Warnings, failed.FailureCategory)
+		}
+	}
+}
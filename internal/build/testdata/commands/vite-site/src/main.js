@@ -0,0 +1 @@
+document.querySelector('#app').innerHTML = '<h1>Docs</h1>'
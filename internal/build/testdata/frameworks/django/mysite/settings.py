@@ -0,0 +1,3 @@
+SECRET_KEY = None
+INSTALLED_APPS = ["django.contrib.staticfiles"]
+STATIC_URL = "static/"
@@ -0,0 +1 @@
+console.log("worker started");
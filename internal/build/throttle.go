@@ -0,0 +1,43 @@
+package build
+
+import (
+	"deploy-platform/internal/throttle"
+	"io/fs"
+	"path/filepath"
+)
+
+// SetThrottles limits concurrent docker builds and Kubernetes deploys.
+// Repositories take one extra build slot per full weightStepMB of size
+// (0 = every build takes one slot).
+func (s *Service) SetThrottles(builds, deploys *throttle.Semaphore, weightStepMB int) {
+	s.buildSlots = builds
+	s.deploySlots = deploys
+	s.weightStepMB = int64(weightStepMB)
+}
+
+// BuildSlots is the semaphore workers reserve a slot from before taking a job
+func (s *Service) BuildSlots() *throttle.Semaphore {
+	return s.buildSlots
+}
+
+// buildWeight estimates how heavy building the cloned repository will be
+// from its size on disk, git history excluded
+func (s *Service) buildWeight(repoPath string) int {
+	if s.weightStepMB <= 0 {
+		return 1
+	}
+	var size int64
+	filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return 1 + int(size/(s.weightStepMB<<20))
+}
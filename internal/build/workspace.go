@@ -0,0 +1,176 @@
+package build
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultWorkDir is where builds check out when no work dir is set
+const defaultWorkDir = "/tmp/builds"
+
+// workspaceSweepInterval is how often workspaces left behind by builds that
+// are no longer running are removed
+const workspaceSweepInterval = time.Hour
+
+// SetWorkDir makes builds check out under dir, each attempt in a directory of
+// its own: <dir>/<deployment ID>/<build ID>-<random>. The workspaces of failed
+// builds are kept for keepFailed to look into them, 0 = not kept.
+func (s *Service) SetWorkDir(dir string, keepFailed time.Duration) {
+	s.workDir = dir
+	s.keepFailed = keepFailed
+}
+
+func (s *Service) workRoot() string {
+	if s.workDir == "" {
+		return defaultWorkDir
+	}
+	return s.workDir
+}
+
+// workspace creates the directory build checks out and builds in. It is new
+// to the attempt, so a retry or a concurrent build of the same deployment
+// never starts from files another attempt left half written. remove deletes
+// it, and the deployment's directory once no attempt is left in it.
+func (s *Service) workspace(build *models.Build) (dir string, remove func(), err error) {
+	parent := filepath.Join(s.workRoot(), strconv.FormatUint(uint64(build.DeploymentID), 10))
+	// Another attempt finishing may remove the deployment's directory
+	// between the two calls: create it again then
+	for try := 0; try < 2; try++ {
+		if err = os.MkdirAll(parent, 0700); err != nil {
+			break
+		}
+		if dir, err = os.MkdirTemp(parent, fmt.Sprintf("%d-", build.ID)); !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create build workspace: %w", err)
+	}
+	return dir, func() {
+		if s.keepFailed > 0 && buildFailed(build.ID) {
+			// Kept from now on, the sweep goes by its modification time
+			now := time.Now()
+			os.Chtimes(dir, now, now)
+			log.Printf("🔍 Keeping the workspace of failed build %d for %s: %s", build.ID, s.keepFailed, dir)
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("⚠️  Failed to remove the workspace of build %d: %v", build.ID, err)
+		}
+		os.Remove(parent) // Fails while another attempt still uses it
+	}, nil
+}
+
+// buildFailed reports whether the build failed, as the deferred removal of
+// its workspace finds it
+func buildFailed(buildID uint) bool {
+	var failed int64
+	database.DB.Model(&models.Build{}).Where("id = ? AND status = ?", buildID, "failed").Count(&failed)
+	return failed > 0
+}
+
+// SweepWorkspaces removes the workspaces of builds that are no longer
+// building: those a crashed or reclaimed worker never removed, and those of
+// failed builds once kept for longer than keepFailed
+func (s *Service) SweepWorkspaces() (int, error) {
+	root := s.workRoot()
+	deployments, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	attempts := make(map[uint][]string) // Build ID -> its workspaces
+	for _, deployment := range deployments {
+		if !deployment.IsDir() {
+			continue
+		}
+		parent := filepath.Join(root, deployment.Name())
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			id, _, found := strings.Cut(entry.Name(), "-")
+			buildID, err := strconv.ParseUint(id, 10, 64)
+			if !entry.IsDir() || !found || err != nil {
+				continue
+			}
+			attempts[uint(buildID)] = append(attempts[uint(buildID)], filepath.Join(parent, entry.Name()))
+		}
+		if len(entries) == 0 {
+			os.Remove(parent)
+		}
+	}
+	if len(attempts) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]uint, 0, len(attempts))
+	for id := range attempts {
+		ids = append(ids, id)
+	}
+	var kept []models.Build // Still building, or failed and maybe kept
+	if err := database.DB.Select("id", "status").Where("id IN ? AND status IN ?", ids, []string{"building", "failed"}).Find(&kept).Error; err != nil {
+		return 0, err
+	}
+	keptSince := time.Now().Add(-s.keepFailed)
+	for _, build := range kept {
+		if build.Status == "building" {
+			delete(attempts, build.ID)
+			continue
+		}
+		if s.keepFailed <= 0 {
+			continue
+		}
+		var expired []string
+		for _, dir := range attempts[build.ID] {
+			if info, err := os.Stat(dir); err == nil && info.ModTime().Before(keptSince) {
+				expired = append(expired, dir)
+			}
+		}
+		attempts[build.ID] = expired
+	}
+
+	removed := 0
+	for buildID, dirs := range attempts {
+		for _, dir := range dirs {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Printf("⚠️  Failed to remove the workspace of build %d: %v", buildID, err)
+				continue
+			}
+			os.Remove(filepath.Dir(dir))
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("🧹 Removed %d workspaces of builds no longer running", removed)
+	}
+	return removed, nil
+}
+
+// WorkspaceJob sweeps the workspaces of this replica's builds every hour;
+// work dirs aren't shared, so it runs on every replica
+func (s *Service) WorkspaceJob() background.Job {
+	return background.Job{
+		Name:     "build-workspace-sweep",
+		Interval: workspaceSweepInterval,
+		Manual:   true,
+		Run: func(ctx context.Context) error {
+			removed, err := s.SweepWorkspaces()
+			background.Processed(ctx, removed)
+			return err
+		},
+	}
+}
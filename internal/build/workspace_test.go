@@ -0,0 +1,123 @@
+package build
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Concurrent attempts of a deployment, including two of the same build,
+// build in directories of their own and remove them, and the deployment's
+// directory after the last of them
+func TestWorkspacesOfConcurrentAttempts(t *testing.T) {
+	testutil.DB(t)
+	root := t.TempDir()
+	s := &Service{}
+	s.SetWorkDir(root, 0)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		dirs = map[string]bool{}
+	)
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(buildID uint) {
+			defer wg.Done()
+			<-start
+			b := &models.Build{ID: buildID, DeploymentID: 7}
+			dir, remove, err := s.workspace(b)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			if dirs[dir] {
+				t.Errorf("%s used twice", dir)
+			}
+			dirs[dir] = true
+			mu.Unlock()
+
+			if filepath.Dir(dir) != filepath.Join(root, "7") {
+				t.Errorf("workspace %s not under the deployment's directory", dir)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%s starts with %d files", dir, len(entries))
+			}
+			os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0600)
+			remove()
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("%s left behind: %v", dir, err)
+			}
+		}(uint(1 + i%10))
+	}
+	close(start)
+	wg.Wait()
+
+	if len(dirs) != 20 {
+		t.Errorf("%d workspaces for 20 attempts", len(dirs))
+	}
+	if _, err := os.Stat(filepath.Join(root, "7")); !os.IsNotExist(err) {
+		t.Errorf("the deployment's directory is left: %v", err)
+	}
+}
+
+// The workspace of a failed build is kept for keepFailed, then swept with
+// those of builds whose worker never removed them; running builds' stay
+func TestSweepWorkspaces(t *testing.T) {
+	testutil.DB(t)
+	root := t.TempDir()
+	s := &Service{}
+	s.SetWorkDir(root, time.Hour)
+
+	workspace := func(status string) (*models.Build, string, func()) {
+		t.Helper()
+		b := &models.Build{DeploymentID: 7, Status: "building"}
+		database.DB.Create(b)
+		dir, remove, err := s.workspace(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.DB.Model(b).Update("status", status)
+		return b, dir, remove
+	}
+	_, failedDir, removeFailed := workspace("failed")
+	_, runningDir, _ := workspace("building")
+	_, crashedDir, _ := workspace("success") // Its worker died before removing it
+	_, succeededDir, removeSucceeded := workspace("success")
+
+	removeFailed()
+	removeSucceeded()
+	if _, err := os.Stat(failedDir); err != nil {
+		t.Errorf("failed build's workspace not kept: %v", err)
+	}
+	if _, err := os.Stat(succeededDir); !os.IsNotExist(err) {
+		t.Errorf("succeeded build's workspace kept: %v", err)
+	}
+
+	if removed, err := s.SweepWorkspaces(); err != nil || removed != 1 {
+		t.Errorf("swept %d, %v", removed, err)
+	}
+	if _, err := os.Stat(crashedDir); !os.IsNotExist(err) {
+		t.Errorf("crashed build's workspace not swept: %v", err)
+	}
+
+	// Once kept for longer than keepFailed
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(failedDir, old, old)
+	if removed, err := s.SweepWorkspaces(); err != nil || removed != 1 {
+		t.Errorf("swept %d, %v", removed, err)
+	}
+	if _, err := os.Stat(failedDir); !os.IsNotExist(err) {
+		t.Errorf("failed build's workspace kept past keepFailed: %v", err)
+	}
+	if _, err := os.Stat(runningDir); err != nil {
+		t.Errorf("running build's workspace swept: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package buildlogs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/storage"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	archiveInterval  = time.Hour
+	archiveBatchSize = 50 // Builds loaded at once: each holds its whole log
+)
+
+// ErrNoArchive is returned when reading archived logs on an instance
+// without BUILD_LOG_ARCHIVE
+var ErrNoArchive = errors.New("build log archive is not configured")
+
+// ErrSizeUnknown is returned by OpenSeeker for logs archived before their
+// size was recorded: they can only be read from the start
+var ErrSizeUnknown = errors.New("size of the archived logs is unknown")
+
+// Archive moves the logs of builds finished more than hotFor ago out of the
+// builds table into object storage, gzipped, and reads them back from either
+// place
+type Archive struct {
+	db      *gorm.DB
+	storage storage.Storage
+	hotFor  time.Duration
+}
+
+// NewArchive configures the archive from BUILD_LOG_* settings
+func NewArchive(cfg *config.Config, db *gorm.DB) (*Archive, error) {
+	if cfg.BuildLogHotDays < 1 {
+		return nil, fmt.Errorf("invalid BUILD_LOG_HOT_DAYS %d: must be at least 1", cfg.BuildLogHotDays)
+	}
+	store, err := storage.Open(cfg, "BUILD_LOG_ARCHIVE", cfg.BuildLogArchive)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{db: db, storage: store, hotFor: time.Duration(cfg.BuildLogHotDays) * 24 * time.Hour}, nil
+}
+
+// Job archives expired logs every hour, on the leader
+func (a *Archive) Job() background.Job {
+	log.Printf("✅ Build logs archived after %s", a.hotFor)
+	return background.Job{
+		Name:      "build-log-archive",
+		Interval:  archiveInterval,
+		Singleton: true,
+		Manual:    true,
+		Run: func(ctx context.Context) error {
+			n, err := a.ArchiveExpired(ctx)
+			if n > 0 {
+				log.Printf("📦 Archived the logs of %d builds", n)
+			}
+			background.Processed(ctx, n)
+			return err
+		},
+	}
+}
+
+// ArchiveExpired archives the logs of every finished build older than the
+// hot period, returning how many were archived
+func (a *Archive) ArchiveExpired(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-a.hotFor)
+	finished := a.db.Model(&models.Build{}).Select("id").
+		Where("status NOT IN ? AND COALESCE(completed_at, updated_at) < ?", []string{"pending", "building"}, cutoff)
+
+	// Output a build wrote but never compacted, its worker gone, is archived with the rest
+	var leftover []uint
+	if err := a.db.WithContext(ctx).Model(&models.BuildLogChunk{}).Distinct("build_id").
+		Where("build_id IN (?)", finished).Pluck("build_id", &leftover).Error; err != nil {
+		return 0, err
+	}
+	for _, id := range leftover {
+		if err := Compact(a.db.WithContext(ctx), id); err != nil {
+			return 0, fmt.Errorf("build %d: %w", id, err)
+		}
+	}
+
+	archived, lastID := 0, uint(0)
+	for {
+		var builds []models.Build
+		if err := a.db.WithContext(ctx).
+			Select("id", "deployment_id", "logs").
+			Where("id > ? AND status NOT IN ? AND COALESCE(completed_at, updated_at) < ?", lastID, []string{"pending", "building"}, cutoff).
+			Where("COALESCE(logs_object, '') = '' AND logs IS NOT NULL AND logs <> ''").
+			Order("id").
+			Limit(archiveBatchSize).
+			Find(&builds).Error; err != nil {
+			return archived, err
+		}
+		for i := range builds {
+			if err := a.archive(ctx, &builds[i]); err != nil {
+				return archived, fmt.Errorf("build %d: %w", builds[i].ID, err)
+			}
+			archived++
+		}
+		if len(builds) < archiveBatchSize {
+			return archived, nil
+		}
+		lastID = builds[len(builds)-1].ID
+	}
+}
+
+// archive uploads a build's logs, then records the object and clears the
+// column. The object is written first so the logs are never lost; if another
+// replica got there first it wrote the same object.
+func (a *Archive) archive(ctx context.Context, build *models.Build) error {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := io.WriteString(zw, build.Logs); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	name := objectName(build)
+	if _, err := a.storage.Put(ctx, name, &compressed); err != nil {
+		return fmt.Errorf("failed to upload logs: %w", err)
+	}
+	now := time.Now()
+	return a.db.WithContext(ctx).Model(&models.Build{}).
+		Where("id = ? AND COALESCE(logs_object, '') = ''", build.ID).
+		Updates(map[string]interface{}{"logs": gorm.Expr("NULL"), "logs_object": name, "logs_archived_at": now, "logs_size": len(build.Logs)}).Error
+}
+
+// objectName names the archived logs of a build
+func objectName(build *models.Build) string {
+	return fmt.Sprintf("deployment-%d-build-%d.log.gz", build.DeploymentID, build.ID)
+}
+
+// Open reads a build's logs from the database or, once archived, streams and
+// decompresses them from storage. a may be nil when no archive is
+// configured: only logs still in the database can be read then.
+func (a *Archive) Open(ctx context.Context, build *models.Build) (io.ReadCloser, error) {
+	if build.LogsObject == "" {
+		return io.NopCloser(strings.NewReader(build.Logs)), nil
+	}
+	if a == nil {
+		return nil, ErrNoArchive
+	}
+	object, err := a.storage.Get(ctx, build.LogsObject)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(object)
+	if err != nil {
+		object.Close()
+		return nil, fmt.Errorf("archived logs %s are corrupt: %w", build.LogsObject, err)
+	}
+	return &gzipObject{Reader: zr, object: object}, nil
+}
+
+// OpenCompressed streams a build's archived logs as stored, gzipped,
+// without decompressing them
+func (a *Archive) OpenCompressed(ctx context.Context, build *models.Build) (io.ReadCloser, error) {
+	if a == nil {
+		return nil, ErrNoArchive
+	}
+	return a.storage.Get(ctx, build.LogsObject)
+}
+
+// OpenSeeker reads a build's logs like Open, seekable to serve byte ranges.
+// Archived logs are still streamed: nothing is fetched before the first Read,
+// seeking forward skips what's in between and seeking back reopens the object.
+func (a *Archive) OpenSeeker(ctx context.Context, build *models.Build) (io.ReadSeekCloser, error) {
+	if build.LogsObject == "" {
+		return nopSeekCloser{strings.NewReader(build.Logs)}, nil
+	}
+	if a == nil {
+		return nil, ErrNoArchive
+	}
+	if build.LogsSize <= 0 {
+		return nil, ErrSizeUnknown
+	}
+	return &archivedSeeker{ctx: ctx, archive: a, build: build, size: build.LogsSize}, nil
+}
+
+// Load returns a build's logs from either tier
+func (a *Archive) Load(ctx context.Context, build *models.Build) (string, error) {
+	r, err := a.Open(ctx, build)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// Delete removes a build's archived logs, if any
+func (a *Archive) Delete(ctx context.Context, build *models.Build) error {
+	if build.LogsObject == "" {
+		return nil
+	}
+	if a == nil {
+		return ErrNoArchive
+	}
+	return a.storage.Delete(ctx, build.LogsObject)
+}
+
+// gzipObject closes both the decompressor and the object it reads
+type gzipObject struct {
+	*gzip.Reader
+	object io.ReadCloser
+}
+
+func (g *gzipObject) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.object.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// archivedSeeker reads archived logs of a known size from offset, opening
+// the object lazily
+type archivedSeeker struct {
+	ctx     context.Context
+	archive *Archive
+	build   *models.Build
+	size    int64
+
+	r      io.ReadCloser
+	pos    int64 // Where r is in the logs
+	offset int64 // Where the next Read starts
+}
+
+func (s *archivedSeeker) Read(p []byte) (int, error) {
+	if s.r != nil && s.offset < s.pos {
+		s.r.Close()
+		s.r = nil
+	}
+	if s.r == nil {
+		r, err := s.archive.Open(s.ctx, s.build)
+		if err != nil {
+			return 0, err
+		}
+		s.r, s.pos = r, 0
+	}
+	if s.offset > s.pos {
+		skipped, err := io.CopyN(io.Discard, s.r, s.offset-s.pos)
+		s.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.r.Read(p)
+	s.pos += int64(n)
+	s.offset = s.pos
+	return n, err
+}
+
+func (s *archivedSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the logs")
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+func (s *archivedSeeker) Close() error {
+	if s.r == nil {
+		return nil
+	}
+	return s.r.Close()
+}
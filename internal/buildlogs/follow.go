@@ -0,0 +1,124 @@
+package buildlogs
+
+// followBuffer is how many writes a follower may fall behind by before it
+// is dropped, rather than holding the build back
+const followBuffer = 256
+
+// Follower receives the output of a build running on this instance as the
+// build writes it, e.g. for a dashboard tab tailing its logs. Any number of
+// followers may follow a build.
+type Follower struct {
+	// Backlog is the output the build wrote before Follow, stored or still
+	// buffered: Output continues right after it
+	Backlog []byte
+
+	streams *Streams
+	buildID uint
+	output  chan []byte
+	lagged  bool // Set before output is closed, when it was dropped
+}
+
+// Follow starts following a build's output, nil when the build doesn't run
+// on this instance, e.g. it runs on another replica or it finished
+func (s *Streams) Follow(buildID uint) (*Follower, error) {
+	if s == nil {
+		return nil, nil
+	}
+	// No flush in between: the output stored and the output buffered add up
+	// to all of it
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	running := s.pending[buildID] != nil
+	s.mu.Unlock()
+	if !running {
+		return nil, nil
+	}
+	stored, err := loadOutput(s.db, buildID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.pending[buildID]
+	if out == nil {
+		return nil, nil
+	}
+	f := &Follower{
+		Backlog: append([]byte(stored), out.data...),
+		streams: s,
+		buildID: buildID,
+		output:  make(chan []byte, followBuffer),
+	}
+	s.followers[buildID] = append(s.followers[buildID], f)
+	return f, nil
+}
+
+// Output delivers what the build writes, one write at a time. It is closed
+// when the build finishes, or when the follower fell behind (Lagged).
+func (f *Follower) Output() <-chan []byte {
+	return f.output
+}
+
+// Lagged reports whether Output was closed because the follower fell
+// behind rather than because the build finished; valid once it is closed
+func (f *Follower) Lagged() bool {
+	return f.lagged
+}
+
+// Stop stops following the build; safe to call once Output is closed
+func (f *Follower) Stop() {
+	s := f.streams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.drop(f) {
+		close(f.output)
+	}
+}
+
+// publish hands a build's write to its followers, dropping those whose
+// buffer is full; callers hold s.mu
+func (s *Streams) publish(buildID uint, p []byte) {
+	followers := s.followers[buildID]
+	if len(followers) == 0 {
+		return
+	}
+	data := append([]byte(nil), p...)
+	for _, f := range followers {
+		select {
+		case f.output <- data:
+		default:
+			f.lagged = true
+			s.drop(f)
+			close(f.output)
+		}
+	}
+}
+
+// drop removes f from the followers of its build, reporting false if it
+// was already removed; callers hold s.mu
+func (s *Streams) drop(f *Follower) bool {
+	followers := s.followers[f.buildID]
+	for i, other := range followers {
+		if other == f {
+			followers = append(followers[:i:i], followers[i+1:]...)
+			if len(followers) == 0 {
+				delete(s.followers, f.buildID)
+			} else {
+				s.followers[f.buildID] = followers
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// release closes the output of a finished build's followers; callers hold
+// s.mu
+func (s *Streams) release(buildID uint) {
+	for _, f := range s.followers[buildID] {
+		close(f.output)
+	}
+	delete(s.followers, buildID)
+}
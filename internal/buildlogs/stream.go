@@ -0,0 +1,269 @@
+package buildlogs
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	flushInterval = 2 * time.Second
+	chunkSize     = 256 << 10 // Output is stored in chunks of at most this
+	flushEarly    = 4 << 20   // A build buffering this much is flushed before the next tick
+)
+
+// Streams persists the output of the builds running on this instance as
+// build_log_chunks. Output is buffered in memory and written by one
+// transaction per flush for all builds, so the database sees a bounded
+// write rate however chatty the builds are, and each byte is written once
+// instead of the whole log being rewritten with every line.
+type Streams struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	pending   map[uint]*pendingOutput // By build, until it finishes
+	followers map[uint][]*Follower    // By build, until it finishes
+	kick      chan struct{}
+
+	flushMu sync.Mutex // One flush at a time, so chunks are numbered in order
+}
+
+// pendingOutput is the output of a build not written yet
+type pendingOutput struct {
+	data []byte
+	next int // Seq of its next chunk, only touched under flushMu
+}
+
+// NewStreams creates the build output streams, written to db once started
+func NewStreams(db *gorm.DB) *Streams {
+	return &Streams{db: db, pending: make(map[uint]*pendingOutput), followers: make(map[uint][]*Follower), kick: make(chan struct{}, 1)}
+}
+
+// Start writes the buffered output every flushInterval, or sooner when a
+// build buffers a lot, until ctx is done; then once more
+func (s *Streams) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := s.flush(); err != nil {
+					log.Printf("⚠️  Failed to write build output: %v", err)
+				}
+				return
+			case <-ticker.C:
+			case <-s.kick:
+			}
+			if err := s.flush(); err != nil {
+				log.Printf("⚠️  Failed to write build output, retrying: %v", err)
+			}
+		}
+	}()
+	log.Printf("✅ Build output written every %s", flushInterval)
+}
+
+// Writer returns the writer appending to a build's output until Finish.
+// Without streams the output is discarded.
+func (s *Streams) Writer(buildID uint) io.Writer {
+	if s == nil {
+		return io.Discard
+	}
+	s.mu.Lock()
+	if s.pending[buildID] == nil {
+		s.pending[buildID] = &pendingOutput{}
+	}
+	s.mu.Unlock()
+	return &streamWriter{streams: s, buildID: buildID}
+}
+
+type streamWriter struct {
+	streams *Streams
+	buildID uint
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	s := w.streams
+	s.mu.Lock()
+	out := s.pending[w.buildID]
+	if out == nil {
+		// Output after Finish has nowhere to go
+		s.mu.Unlock()
+		return len(p), nil
+	}
+	out.data = append(out.data, p...)
+	s.publish(w.buildID, p)
+	full := len(out.data) >= flushEarly
+	s.mu.Unlock()
+	if full {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Finish writes what's left of a build's output and compacts it into the
+// build's logs, then ends the output of its followers
+func (s *Streams) Finish(buildID uint) error {
+	if s == nil {
+		return nil
+	}
+	s.flushMu.Lock()
+	s.mu.Lock()
+	out := s.pending[buildID]
+	delete(s.pending, buildID)
+	s.mu.Unlock()
+	var err error
+	if out != nil && len(out.data) > 0 {
+		err = s.write(map[uint]*pendingOutput{buildID: out}, map[uint][]byte{buildID: out.data})
+	}
+	s.flushMu.Unlock()
+	if err == nil {
+		err = Compact(s.db, buildID)
+	}
+	// Once it is all stored, for followers to read the platform's messages
+	// after it
+	s.mu.Lock()
+	s.release(buildID)
+	s.mu.Unlock()
+	return err
+}
+
+// flush writes the output buffered since the last flush. Output that fails
+// to be written is kept for the next one.
+func (s *Streams) flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	outputs := make(map[uint]*pendingOutput)
+	batch := make(map[uint][]byte)
+	s.mu.Lock()
+	for id, out := range s.pending {
+		if len(out.data) > 0 {
+			outputs[id], batch[id] = out, out.data
+			out.data = nil
+		}
+	}
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := s.write(outputs, batch)
+	if err != nil {
+		s.mu.Lock()
+		for id, data := range batch {
+			if out := s.pending[id]; out != nil {
+				out.data = append(data, out.data...)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// write stores batch, the output of each build, in one transaction, in
+// chunks numbered after those already written
+func (s *Streams) write(outputs map[uint]*pendingOutput, batch map[uint][]byte) error {
+	var chunks []models.BuildLogChunk
+	for id, data := range batch {
+		seq := outputs[id].next
+		for len(data) > 0 {
+			n := min(len(data), chunkSize)
+			chunks = append(chunks, models.BuildLogChunk{BuildID: id, Seq: seq, Data: data[:n:n]})
+			data = data[n:]
+			seq++
+		}
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(chunks, 100).Error
+	}); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		outputs[chunk.BuildID].next = chunk.Seq + 1
+	}
+	return nil
+}
+
+// Compact moves a build's written output into its Logs column, ahead of
+// what the column holds, and deletes the chunks
+func Compact(db *gorm.DB, buildID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		output, err := loadOutput(tx, buildID)
+		if err != nil || output == "" {
+			return err
+		}
+		var build models.Build
+		if err := tx.Select("id", "logs").First(&build, buildID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&build).Update("logs", joinLogs(output, build.Logs)).Error; err != nil {
+			return err
+		}
+		return tx.Where("build_id = ?", buildID).Delete(&models.BuildLogChunk{}).Error
+	})
+}
+
+// Collect puts the output a build wrote and that isn't compacted yet ahead
+// of build.Logs, as Compact stores it, for reads of running builds' logs
+func Collect(db *gorm.DB, build *models.Build) error {
+	if build.LogsObject != "" {
+		return nil
+	}
+	output, err := loadOutput(db, build.ID)
+	if err != nil {
+		return err
+	}
+	build.Logs = joinLogs(output, build.Logs)
+	return nil
+}
+
+// ReadOutput returns the output a build stored in chunks from seq on, and
+// the seq to read from next; for following builds running on another
+// instance. Nothing is left to read once the build finished and its output
+// was compacted.
+func ReadOutput(db *gorm.DB, buildID uint, seq int) ([]byte, int, error) {
+	var chunks []models.BuildLogChunk
+	if err := db.Select("seq", "data").Where("build_id = ? AND seq >= ?", buildID, seq).Order("seq").Find(&chunks).Error; err != nil {
+		return nil, seq, err
+	}
+	var output []byte
+	for _, chunk := range chunks {
+		output = append(output, chunk.Data...)
+		seq = chunk.Seq + 1
+	}
+	return output, seq, nil
+}
+
+// loadOutput concatenates the chunks of a build's output
+func loadOutput(db *gorm.DB, buildID uint) (string, error) {
+	var chunks []models.BuildLogChunk
+	if err := db.Select("data").Where("build_id = ?", buildID).Order("seq").Find(&chunks).Error; err != nil {
+		return "", err
+	}
+	var output strings.Builder
+	for _, chunk := range chunks {
+		output.Write(chunk.Data)
+	}
+	return output.String(), nil
+}
+
+// joinLogs is a build's logs: its output, then the platform's messages
+func joinLogs(output, messages string) string {
+	if output == "" || messages == "" {
+		return output + messages
+	}
+	if !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+	return output + messages
+}
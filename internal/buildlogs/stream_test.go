@@ -0,0 +1,269 @@
+package buildlogs
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// statements counts the statements writing to the database
+type statements struct {
+	logger.Interface
+	mu     sync.Mutex
+	writes int
+}
+
+func (s *statements) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	verb, _, _ := strings.Cut(sql, " ")
+	if verb == "INSERT" || verb == "UPDATE" || verb == "DELETE" {
+		s.mu.Lock()
+		s.writes++
+		s.mu.Unlock()
+	}
+}
+
+// countWrites counts the statements writing to database.DB from now on
+func countWrites(tb testing.TB) *statements {
+	tb.Helper()
+	counter := &statements{Interface: logger.Discard}
+	database.DB.Logger = counter
+	tb.Cleanup(func() { database.DB.Logger = logger.Discard })
+	return counter
+}
+
+func runningBuild(tb testing.TB) *models.Build {
+	tb.Helper()
+	build := &models.Build{DeploymentID: 1, Status: "building"}
+	if err := database.DB.Create(build).Error; err != nil {
+		tb.Fatal(err)
+	}
+	return build
+}
+
+// chunks returns the seqs and total size of the chunks stored for a build
+func chunks(t *testing.T, buildID uint) ([]int, int) {
+	t.Helper()
+	var stored []models.BuildLogChunk
+	database.DB.Where("build_id = ?", buildID).Order("seq").Find(&stored)
+	var seqs []int
+	size := 0
+	for _, chunk := range stored {
+		seqs = append(seqs, chunk.Seq)
+		size += len(chunk.Data)
+	}
+	return seqs, size
+}
+
+// Output is stored as it is flushed, read back ahead of the platform's
+// messages while the build runs, and compacted into its logs once it
+// finishes
+func TestStreams(t *testing.T) {
+	testutil.DB(t)
+	build := runningBuild(t)
+	streams := NewStreams(database.DB)
+	output := streams.Writer(build.ID)
+
+	fmt.Fprint(output, "Step 1/2 : FROM node:20\n")
+	database.DB.Model(build).Update("logs", "Building image\n")
+	if err := streams.flush(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(output, "Step 2/2 : RUN npm ci")
+	if err := streams.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := streams.flush(); err != nil { // Nothing new
+		t.Fatal(err)
+	}
+	if seqs, _ := chunks(t, build.ID); fmt.Sprint(seqs) != "[0 1]" {
+		t.Errorf("chunks %v", seqs)
+	}
+
+	read, next, err := ReadOutput(database.DB, build.ID, 1)
+	if err != nil || string(read) != "Step 2/2 : RUN npm ci" || next != 2 {
+		t.Errorf("read %q up to %d: %v", read, next, err)
+	}
+	var stored models.Build
+	database.DB.First(&stored, build.ID)
+	if err := Collect(database.DB, &stored); err != nil {
+		t.Fatal(err)
+	}
+	want := "Step 1/2 : FROM node:20\nStep 2/2 : RUN npm ci\nBuilding image\n"
+	if stored.Logs != want {
+		t.Errorf("collected %q", stored.Logs)
+	}
+
+	fmt.Fprint(output, "\nadded 312 packages\n")
+	if err := streams.Finish(build.ID); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.First(&stored, build.ID)
+	if want := "Step 1/2 : FROM node:20\nStep 2/2 : RUN npm ci\nadded 312 packages\nBuilding image\n"; stored.Logs != want {
+		t.Errorf("compacted %q", stored.Logs)
+	}
+	if seqs, _ := chunks(t, build.ID); len(seqs) != 0 {
+		t.Errorf("chunks left %v", seqs)
+	}
+	// Collecting compacted logs changes nothing, output after Finish is dropped
+	logs := stored.Logs
+	if err := Collect(database.DB, &stored); err != nil || stored.Logs != logs {
+		t.Errorf("collected %q: %v", stored.Logs, err)
+	}
+	fmt.Fprint(output, "late\n")
+	if err := streams.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := chunks(t, build.ID); len(seqs) != 0 {
+		t.Errorf("stored output after Finish %v", seqs)
+	}
+
+	var none *Streams
+	if none.Writer(build.ID) != io.Discard || none.Finish(build.ID) != nil {
+		t.Error("nil streams")
+	}
+}
+
+// Output that fails to be written is kept, in order, for the next flush
+func TestStreamsFlushFailure(t *testing.T) {
+	testutil.DB(t)
+	build := runningBuild(t)
+	streams := NewStreams(database.DB)
+	output := streams.Writer(build.ID)
+
+	fmt.Fprint(output, "one\n")
+	if err := streams.flush(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(output, "two\n")
+	database.DB.Migrator().RenameTable(&models.BuildLogChunk{}, "build_log_chunks_away")
+	if err := streams.flush(); err == nil {
+		t.Fatal("flushed without a table")
+	}
+	fmt.Fprint(output, "three\n")
+	database.DB.Migrator().RenameTable("build_log_chunks_away", &models.BuildLogChunk{})
+	if err := streams.Finish(build.ID); err != nil {
+		t.Fatal(err)
+	}
+	var stored models.Build
+	database.DB.First(&stored, build.ID)
+	if stored.Logs != "one\ntwo\nthree\n" {
+		t.Errorf("logs %q", stored.Logs)
+	}
+}
+
+// A build writing a line at a time flushes early once it buffered
+// flushEarly, as Start does when kicked
+func writeLog(streams *Streams, buildID uint, size int, line []byte) {
+	output := streams.Writer(buildID)
+	buffered := 0
+	for written := 0; written < size; written += len(line) {
+		output.Write(line)
+		if buffered += len(line); buffered >= flushEarly {
+			streams.flush()
+			buffered = 0
+		}
+	}
+}
+
+var logLine = []byte(strings.Repeat("x", 99) + "\n")
+
+// However large the log, each byte is written once while the build runs, in
+// a number of statements bounded by its size over flushEarly. Rewriting the logs column
+// per line would take one statement per line, each rewriting all of the
+// log so far.
+func TestStreamsWriteAmplification(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 50MB log")
+	}
+	testutil.DB(t)
+	build := runningBuild(t)
+	streams := NewStreams(database.DB)
+	counter := countWrites(t)
+
+	const size = 50 << 20
+	writeLog(streams, build.ID, size, logLine)
+	streams.flush()
+	if counter.writes > size/flushEarly+1 {
+		t.Errorf("%d statements", counter.writes)
+	}
+	// Each flush ends with at most one partial chunk
+	if seqs, stored := chunks(t, build.ID); stored != size || len(seqs) > size/chunkSize+counter.writes || seqs[len(seqs)-1] != len(seqs)-1 {
+		t.Errorf("stored %d bytes in %d chunks", stored, len(seqs))
+	}
+
+	// The naive approach
+	lines := size / len(logLine)
+	naive := int64(0)
+	for i := 1; i <= lines; i++ {
+		naive += int64(i * len(logLine))
+	}
+	t.Logf("%d statements writing %d bytes; per line updates: %d statements writing %d bytes", counter.writes, size, lines, naive)
+
+	if err := streams.Finish(build.ID); err != nil {
+		t.Fatal(err)
+	}
+	var logs int
+	database.DB.Model(&models.Build{}).Select("length(logs)").Where("id = ?", build.ID).Scan(&logs)
+	if logs != size {
+		t.Errorf("compacted %d bytes", logs)
+	}
+}
+
+// go test -bench . -benchtime 1x ./internal/buildlogs/ compares the
+// statements and bytes written to store a benchLog bytes log both ways
+
+const benchLog = 256 << 10
+
+// builds creates n running builds before the writes are counted
+func builds(b *testing.B) []*models.Build {
+	testutil.DB(b)
+	builds := make([]*models.Build, b.N)
+	for i := range builds {
+		builds[i] = runningBuild(b)
+	}
+	return builds
+}
+
+func BenchmarkStreams(b *testing.B) {
+	builds := builds(b)
+	counter := countWrites(b)
+	b.ResetTimer()
+	for _, build := range builds {
+		streams := NewStreams(database.DB)
+		writeLog(streams, build.ID, benchLog, logLine)
+		if err := streams.Finish(build.ID); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(counter.writes)/float64(b.N), "statements/op")
+	// Once in chunks, once more compacted into the logs column
+	b.ReportMetric(2*benchLog, "written-B/op")
+}
+
+func BenchmarkPerLineUpdates(b *testing.B) {
+	builds := builds(b)
+	counter := countWrites(b)
+	written := int64(0)
+	b.ResetTimer()
+	for _, build := range builds {
+		var logs bytes.Buffer
+		for logs.Len() < benchLog {
+			logs.Write(logLine)
+			database.DB.Model(build).Update("logs", logs.String())
+			written += int64(logs.Len())
+		}
+	}
+	b.ReportMetric(float64(counter.writes)/float64(b.N), "statements/op")
+	b.ReportMetric(float64(written)/float64(b.N), "written-B/op")
+}
@@ -0,0 +1,47 @@
+package compress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGzip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Gzip(1024))
+	large := strings.Repeat("deploy ", 1000)
+	r.GET("/small", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/large", func(c *gin.Context) { c.String(http.StatusOK, large) })
+	r.GET("/range", func(c *gin.Context) {
+		c.Header("Accept-Ranges", "bytes")
+		c.String(http.StatusOK, large)
+	})
+
+	tests := []struct {
+		path, acceptEncoding string
+		compressed           bool
+	}{
+		{"/large", "gzip", true},
+		{"/large", "", false},
+		{"/small", "gzip", false},
+		{"/range", "gzip", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if compressed := w.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Errorf("%s with Accept-Encoding %q: compressed %v", tt.path, tt.acceptEncoding, compressed)
+		}
+		if !tt.compressed && w.Body.String() != "ok" && w.Body.String() != large {
+			t.Errorf("%s: body %.40q", tt.path, w.Body.String())
+		}
+	}
+}
@@ -3,22 +3,256 @@ package config
 // Configuration management will be here
 // This will load environment variables and application config
 
-import "os"
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
 	GitHubClientID     string
 	GitHubClientSecret string
 	GitHubCallbackURL  string
+	GitHubScopes       []string // OAuth scopes requested at sign-in
+	GitHubAppID        int      // GitHub App posting check runs on built commits, 0 = commit statuses instead
+	GitHubAppKey       string   // PEM file of the GitHub App's private key
 	GoogleClientID     string
 	GoogleClientSecret string
 	GoogleCallbackURL  string
 	BaseURL            string
 	BaseDomain         string // e.g., "deploy.example.com" or "localhost" for development
-	PublicURL          string // Public URL prefix, e.g., "https://" or "http://"
+	PublicScheme       string // Scheme deployed apps are served on, "http" or "https"
+	PublicPort         int    // Port the ingress is exposed on, 0 = the scheme's default
 	DatabaseURL        string
-	KubernetesConfig   string // Path to kubeconfig
-	JWTSecret          string // Add this
-	WebhookSecret      string // Add this
+	RedisURL           string          // Shared store for rate limits across replicas
+	TrustedProxies     []string        // Proxies (IPs or CIDRs) whose X-Forwarded-For gives client IPs, empty = none
+	AuthRateLimit      int             // Sign-in and sign-up requests per minute per IP, 0 = unlimited
+	KubernetesConfig   string          // Path to kubeconfig
+	Clusters           []ClusterConfig // Clusters projects deploy to, from KUBERNETES_CLUSTERS or KubernetesConfig alone
+	DefaultCluster     string          // Cluster of projects that don't choose one
+	JWTSecret          string          // Add this
+	JWTSecrets         []string        // Keyring replacing JWTSecret for rotation, newest first: it signs, all validate
+	WebhookSecret      string          // Add this
+	EncryptionKey      string          // Key for secrets stored in the database (deploy keys, tokens)
+	ReservedSubdomains []string        // Extra subdomains projects may not claim (on top of the built-in list)
+	AdminEmails        []string        // Users with these emails are treated as platform admins
+
+	BuildHeartbeatTimeout time.Duration // Builds without a heartbeat for this long are reclaimed by the watchdog
+	ShutdownTimeout       time.Duration // On SIGTERM, requests and running builds get this long to finish
+	RolloutTimeout        time.Duration // Deploys whose pods aren't ready after this long fail with a diagnosis
+	DeployRetryAttempts   int           // Rollouts of a built image tried while the cluster API is unavailable, 1 = never retried
+	DeployRetryBackoff    time.Duration // Wait before the first retry of such a rollout, doubled after each attempt
+	DeployRetryMaxBackoff time.Duration // Longest wait between its attempts
+	ReleaseTimeout        time.Duration // Release commands running longer than this fail their deployment
+	ReleaseJobRetention   time.Duration // Release Jobs are kept this long for inspection
+	ApprovalWindow        time.Duration // Production deployments awaiting approval are cancelled after this long, unless their project sets its own window
+	ApprovalMaxWindow     time.Duration // Longest approval window a project may set
+	GitLFS                bool          // Fetch Git LFS files of repositories using LFS, or fail their builds
+	GitCacheDir           string        // Bare mirrors of built repositories clones copy from, empty = clone from the network
+	GitCacheMaxMB         int           // Least recently used mirrors are evicted above this, 0 = unbounded
+	BuildWorkDir          string        // Builds check out and build in a workspace of their own under this
+	BuildKeepFailed       time.Duration // Workspaces of failed builds are kept this long for debugging, 0 = removed right away
+	AnalysisTimeout       time.Duration // Analyses of pushes to analysis-only projects are given up after this long
+	AnalysisMaxRepoMB     int           // Commits checking out larger than this are not analyzed
+
+	// Placeholder page of hostnames no ready deployment serves yet
+	PlaceholderBackend string // host:port clusters reach the platform's placeholder listener at, empty = disabled
+	PlaceholderAddr    string // Address the placeholder listener binds
+
+	// Web UI
+	PlatformName string // Name the login page and dashboard show
+	WebDir       string // Directory with templates/ and static/ served instead of the embedded ones, reloaded on every request; for frontend development
+
+	// Health checks of the platform's dependencies, recording outages as incidents
+	HealthCheckInterval time.Duration // How often docker, the clusters, the database and the registry are checked
+	AdminAlertWebhook   string        // URL incidents opening and closing are POSTed to, empty = logged only
+
+	// Supply chain records of built images
+	SBOMGenerator        string // syft binary generating SBOMs of built images, empty = none
+	ProvenanceSigningKey string // PEM file of the ed25519 key signing provenance, empty = unsigned
+	SupplyChainStrict    bool   // Builds whose SBOM or provenance can't be recorded fail instead of warning
+	SupplyChainStorage   string // Directory or s3://bucket/prefix the records are kept in, empty = the database
+
+	// One-off commands run in a project's image and env (POST /api/projects/:id/exec)
+	ExecEnabled       bool          // Let project owners and admins run them
+	ExecTimeout       time.Duration // Commands are killed after running this long
+	ExecMaxPerProject int           // Commands a project may run at once
+	ExecCPULimit      string        // CPU of their pods, e.g. "500m"
+	ExecMemoryLimit   string        // Memory of their pods, e.g. "512Mi"
+
+	// Retries of GitHub webhook events whose processing failed
+	WebhookMaxAttempts        int           // Attempts before an event is dead, left for admins to retry
+	WebhookRetryBackoff       time.Duration // Wait before the first retry, doubled after each attempt
+	WebhookRetryMaxBackoff    time.Duration // Longest wait between attempts
+	WebhookDeadAlertThreshold int           // Dead events admins are alerted at, 0 = never
+	WebhookEventRetention     time.Duration // Processed events are deleted after this
+
+	// Deployment lifecycle hooks
+	HooksFile   string        // JSON file of command hooks, empty = none
+	HookTimeout time.Duration // How long a hook may run unless it sets its own timeout
+
+	// Resource throttling: builds are heavy, deploys to the cluster are light
+	BuildConcurrency  int // Build slots shared by all workers
+	DeployConcurrency int // Concurrent deploys to Kubernetes
+	BuildWeightStepMB int // Each full step of repository size makes a build take one more slot
+	// Build slots kept for production deployments: previews and branch
+	// deployments only borrow them while no production build runs or waits
+	BuildReservedSlots    int
+	BuildReservedFraction float64 // Of BUILD_CONCURRENCY, rounded up; used when BuildReservedSlots is 0
+
+	// Bounds for per-project ingress settings
+	IngressMaxTimeoutSeconds int // Longest proxy read/send timeout a project may set
+	IngressMaxBodySizeMB     int // Largest request body a project may allow
+
+	NetworkPolicies bool     // Restrict internal projects to their owner's pods with NetworkPolicies
+	LabelDenylist   []string // Custom label/annotation keys ("team") and prefixes ("example.com/") projects may not set
+
+	// Image budgets, 0 = not checked
+	ImageSizeWarnMB int // Builds with larger images get a warning and advice
+	ImageSizeMaxMB  int // Deployments of strict projects with larger images fail
+	ImageMaxLayers  int // Builds with more filesystem layers get a warning
+
+	// Generated Dockerfiles
+	DockerfileTemplateDir string   // Directory with <detector>.tmpl files replacing the built-in templates
+	BaseImageRegistry     string   // Registry mirror prefixed to base images, e.g. "artifactory.internal/docker"
+	DockerfileLabels      []string // key=value LABELs added to generated images
+	BuildHTTPProxy        string   // Proxy build args passed to generated Dockerfiles
+	BuildHTTPSProxy       string
+	BuildNoProxy          string
+	RuntimeVersionPattern string // Runtime versions base images may be tagged with, detected or set by projects
+
+	// Registry credentials of projects pulling private images
+	RegistryPrivateHosts []string // Registries checked despite resolving to private addresses, e.g. a corporate one
+
+	// Registry builds push images to for clusters to pull, empty = clusters
+	// use the build host's Docker daemon
+	RegistryURL      string // host[:port][/path], images are pushed as <RegistryURL>/<project slug>:<tag>
+	RegistryUser     string
+	RegistryPassword string // Password or token, pods pull with the same credentials
+
+	// Database backups
+	BackupDestination string // Directory, or s3://bucket/prefix for an S3-compatible bucket
+	BackupSchedule    string // Cron expression (e.g. "0 3 * * *"), empty = only on request
+	BackupRetention   int    // Completed backups kept, 0 = all
+	BackupS3Endpoint  string // e.g. "https://minio.internal:9000", empty = AWS S3
+	BackupS3Region    string
+	BackupS3AccessKey string
+	BackupS3SecretKey string
+	BackupS3PathStyle bool // Buckets addressed as {endpoint}/{bucket}, rather than {bucket}.{endpoint}
+
+	// Providers of the secrets env vars reference (ref+vault://, ref+awssm://)
+	VaultAddr          string // e.g. "https://vault.internal:8200", empty = no vault provider
+	VaultNamespace     string // Vault Enterprise namespace, empty = none
+	VaultToken         string // Static token; empty = log in with VaultRoleID and VaultSecretID
+	VaultRoleID        string
+	VaultSecretID      string
+	AWSRegion          string // Region of secrets referenced by name rather than ARN
+	AWSAccessKeyID     string // Empty = no awssm provider
+	AWSSecretAccessKey string
+	AWSSessionToken    string // For temporary credentials
+	AWSSecretsEndpoint string // e.g. a LocalStack URL, empty = AWS
+
+	// Build logs older than BuildLogHotDays move from the database to
+	// BuildLogArchive, a directory or s3://bucket/prefix; empty = kept in the database
+	BuildLogArchive string
+	BuildLogHotDays int
+
+	// Platform logs, and the logs of builds once done, are also shipped to
+	// an external log sink: loki (its push API) or http (JSON batches)
+	LogSinkType          string        // loki or http, empty = logs stay local
+	LogSinkURL           string        // e.g. "http://loki:3100/loki/api/v1/push"
+	LogSinkToken         string        // Sent as a bearer token, empty = none
+	LogSinkBuffer        int           // Entries held for the sink, the oldest dropped beyond
+	LogSinkBatchSize     int           // Entries sent at once
+	LogSinkFlushInterval time.Duration // Longest an entry waits for a batch to fill
+
+	// Sweeps comparing the platform's objects in the clusters with the database
+	DriftCheckInterval time.Duration // How often the clusters are swept, 0 = only on request
+	DriftAutoDelete    bool          // Delete orphaned objects found by the sweeps, instead of only reporting them
+	DriftOrphanMinAge  time.Duration // Orphaned objects younger than this are never deleted
+
+	// Cost estimates, pricing the CPU and memory projects' pods request
+	CostCPUHour        float64       // Price of a vCPU (1000 millicores) for an hour
+	CostMemoryGBHour   float64       // Price of a GiB of memory for an hour
+	CostCurrency       string        // Currency of the prices, e.g. "USD"
+	CostSampleInterval time.Duration // How often the requests are sampled, 0 = not sampled
+	CostRetention      time.Duration // Samples are deleted after this
+
+	// Background jobs: replicas elect a leader running the jobs that must
+	// run once per interval, whichever replicas are up
+	InstanceID          string        // Names this replica in leases and job runs, default its hostname
+	LeaderElection      string        // database (a lease row) or kubernetes (a coordination Lease, in-cluster)
+	LeaderLeaseDuration time.Duration // A leader that stops renewing is replaced after this
+	JobRunRetention     time.Duration // Records of job runs are deleted after this
+
+	// Single sign-on through an OpenID Connect provider, enabled by the issuer URL
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCCallbackURL  string
+	OIDCProviderName string   // Label of the login button
+	OIDCTrustEmail   bool     // Treat emails as verified when the provider sends no email_verified (Azure AD)
+	OIDCGroupsClaim  string   // ID token claim listing the user's groups
+	OIDCAdminGroups  []string // Members of these groups are platform admins, empty = groups aren't mapped
+
+	// Sign-in restrictions, empty = anyone may sign in
+	AllowedEmailDomains []string // Google accounts must belong to one of these domains
+	AllowedGitHubOrgs   []string // GitHub users must be a member of one of these orgs
+	StrictRevalidate    bool     // Also reject existing users who no longer match
+
+	ImpersonationAllowWrites bool // Let admins make changes while impersonating a user
+
+	// Password sign-in throttling, failures counted over the last hour
+	LoginDelayAfter      int           // Failures after which each attempt is slowed down
+	LoginLockoutAfter    int           // Failures after which the account is locked
+	LoginLockoutDuration time.Duration // How long a locked account stays locked
+
+	// Default ("free") plan quotas, 0 = unlimited
+	FreePlanMaxProjects          int
+	FreePlanMaxDeploymentsPerDay int
+	FreePlanMaxCustomDomains     int
+	FreePlanMaxEnvVars           int
+}
+
+// ClusterConfig is a Kubernetes cluster projects can deploy to
+type ClusterConfig struct {
+	Name       string
+	Kubeconfig string // Path to its kubeconfig, "" = the in-cluster config
+	Context    string // Context of the kubeconfig, "" = its current context
+	BaseDomain string // Domain of its deployments' hostnames, "" = BASE_DOMAIN
+}
+
+// InCluster names the cluster the platform runs in, in KUBERNETES_CLUSTERS
+const InCluster = "in-cluster"
+
+// getEnvClusters reads KUBERNETES_CLUSTERS ("production=in-cluster,
+// staging=/etc/kube/config#staging": name=kubeconfig[#context]) with their
+// base domains from KUBERNETES_CLUSTER_DOMAINS ("staging=staging.example.com").
+// Without it there is one cluster, "default", from KUBECONFIG.
+func getEnvClusters() []ClusterConfig {
+	domains := make(map[string]string)
+	for _, entry := range getEnvList("KUBERNETES_CLUSTER_DOMAINS") {
+		name, domain, _ := strings.Cut(entry, "=")
+		domains[strings.TrimSpace(name)] = strings.TrimSpace(domain)
+	}
+
+	entries := getEnvList("KUBERNETES_CLUSTERS")
+	if len(entries) == 0 {
+		return []ClusterConfig{{Name: "default", Kubeconfig: getEnv("KUBECONFIG", ""), BaseDomain: domains["default"]}}
+	}
+	clusters := make([]ClusterConfig, 0, len(entries))
+	for _, entry := range entries {
+		name, source, _ := strings.Cut(entry, "=")
+		cluster := ClusterConfig{Name: strings.TrimSpace(name), BaseDomain: domains[strings.TrimSpace(name)]}
+		source, cluster.Context, _ = strings.Cut(strings.TrimSpace(source), "#")
+		if source != InCluster {
+			cluster.Kubeconfig = source
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters
 }
 
 func getEnv(key, defaultValue string) string {
@@ -28,20 +262,272 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// hostname is the machine's name, empty when it is unknown
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// getEnvList reads a comma-separated list, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvInt reads an integer, falling back to defaultValue if unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvFloat reads a decimal number, falling back to defaultValue if unset or invalid
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvBool reads a boolean ("true", "1", ...), falling back to defaultValue if unset or invalid
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDuration reads a Go duration (e.g. "5m"), falling back to defaultValue if unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDurationOrZero is getEnvDuration for settings where 0 turns
+// something off
+func getEnvDurationOrZero(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value >= 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// ReservedBuildSlots is how many build slots are kept for production
+// deployments: BUILD_RESERVED_SLOTS, or BUILD_RESERVED_FRACTION of them
+func (c *Config) ReservedBuildSlots() int {
+	if c.BuildReservedSlots > 0 {
+		return c.BuildReservedSlots
+	}
+	return int(math.Ceil(c.BuildReservedFraction * float64(c.BuildConcurrency)))
+}
+
+// JWTKeys returns the secrets of sign-in tokens, newest first: JWT_SECRETS,
+// or JWT_SECRET alone
+func (c *Config) JWTKeys() []string {
+	if len(c.JWTSecrets) > 0 {
+		return c.JWTSecrets
+	}
+	if c.JWTSecret == "" {
+		return nil
+	}
+	return []string{c.JWTSecret}
+}
+
+// publicScheme reads PUBLIC_SCHEME, falling back to the scheme of the older
+// PUBLIC_URL prefix ("https://") and then to http
+func publicScheme() string {
+	if scheme := os.Getenv("PUBLIC_SCHEME"); scheme != "" {
+		return strings.ToLower(scheme)
+	}
+	if prefix := os.Getenv("PUBLIC_URL"); prefix != "" {
+		return strings.ToLower(strings.TrimSuffix(prefix, "://"))
+	}
+	return "http"
+}
+
 func Load() *Config {
+	clusters := getEnvClusters()
 	return &Config{
 		GitHubClientID:     getEnv("GITHUB_CLIENT_ID", ""),
 		GitHubClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
 		GitHubCallbackURL:  getEnv("GITHUB_CALLBACK_URL", "http://localhost:8080/auth/github/callback"),
+		GitHubScopes:       getEnvList("GITHUB_SCOPES"),
+		GitHubAppID:        getEnvInt("GITHUB_APP_ID", 0),
+		GitHubAppKey:       getEnv("GITHUB_APP_PRIVATE_KEY", ""),
 		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
 		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
 		GoogleCallbackURL:  getEnv("GOOGLE_CALLBACK_URL", "http://localhost:8080/auth/google/callback"),
 		BaseURL:            getEnv("BASE_URL", "http://localhost:8080"),
 		BaseDomain:         getEnv("BASE_DOMAIN", "localhost"),
-		PublicURL:          getEnv("PUBLIC_URL", "http://"), // http:// for localhost, https:// for production
+		PublicScheme:       publicScheme(),
+		PublicPort:         getEnvInt("PUBLIC_PORT", 0),
 		DatabaseURL:        getEnv("DATABASE_URL", ""),
+		RedisURL:           getEnv("REDIS_URL", ""),
+		TrustedProxies:     getEnvList("TRUSTED_PROXIES"),
+		AuthRateLimit:      getEnvInt("AUTH_RATE_LIMIT", 20),
 		KubernetesConfig:   getEnv("KUBECONFIG", ""),
-		JWTSecret:          getEnv("JWT_SECRET", "bbdjvcbjfebvjebvjbejvhbejbvjfnvkj"),
+		Clusters:           clusters,
+		DefaultCluster:     getEnv("DEFAULT_CLUSTER", clusters[0].Name),
+		JWTSecret:          getEnv("JWT_SECRET", DevJWTSecret),
+		JWTSecrets:         getEnvList("JWT_SECRETS"),
+		EncryptionKey:      getEnv("ENCRYPTION_KEY", ""),
 		WebhookSecret:      getEnv("WEBHOOK_SECRET", ""), // Add this
+		ReservedSubdomains: getEnvList("RESERVED_SUBDOMAINS"),
+		AdminEmails:        getEnvList("ADMIN_EMAILS"),
+
+		BuildHeartbeatTimeout: getEnvDuration("BUILD_HEARTBEAT_TIMEOUT", 5*time.Minute),
+		ShutdownTimeout:       getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		RolloutTimeout:        getEnvDuration("ROLLOUT_TIMEOUT", 3*time.Minute),
+		DeployRetryAttempts:   getEnvInt("DEPLOY_RETRY_ATTEMPTS", 5),
+		DeployRetryBackoff:    getEnvDuration("DEPLOY_RETRY_BACKOFF", 30*time.Second),
+		DeployRetryMaxBackoff: getEnvDuration("DEPLOY_RETRY_MAX_BACKOFF", 10*time.Minute),
+		ReleaseTimeout:        getEnvDuration("RELEASE_TIMEOUT", 10*time.Minute),
+		ReleaseJobRetention:   getEnvDuration("RELEASE_JOB_RETENTION", 24*time.Hour),
+		ApprovalWindow:        getEnvDuration("APPROVAL_WINDOW", 24*time.Hour),
+		ApprovalMaxWindow:     getEnvDuration("APPROVAL_MAX_WINDOW", 7*24*time.Hour),
+		GitLFS:                getEnvBool("GIT_LFS", true),
+		GitCacheDir:           getEnv("GIT_CACHE_DIR", "/var/cache/deploy-platform/git"),
+		GitCacheMaxMB:         getEnvInt("GIT_CACHE_MAX_MB", 10240),
+		BuildWorkDir:          getEnv("BUILD_WORK_DIR", "/tmp/builds"),
+		BuildKeepFailed:       getEnvDurationOrZero("BUILD_KEEP_FAILED_WORKSPACES", 0),
+		AnalysisTimeout:       getEnvDuration("ANALYSIS_TIMEOUT", time.Minute),
+		AnalysisMaxRepoMB:     getEnvInt("ANALYSIS_MAX_REPO_MB", 200),
+
+		PlaceholderBackend: getEnv("PLACEHOLDER_BACKEND", ""),
+		PlaceholderAddr:    getEnv("PLACEHOLDER_ADDR", ":8081"),
+
+		PlatformName: getEnv("PLATFORM_NAME", "Deploy Platform"),
+		WebDir:       getEnv("WEB_DIR", ""),
+
+		HealthCheckInterval: getEnvDuration("HEALTH_CHECK_INTERVAL", 30*time.Second),
+		AdminAlertWebhook:   getEnv("ADMIN_ALERT_WEBHOOK", ""),
+
+		SBOMGenerator:        getEnv("SBOM_GENERATOR", "syft"),
+		ProvenanceSigningKey: getEnv("PROVENANCE_SIGNING_KEY", ""),
+		SupplyChainStrict:    getEnvBool("SUPPLY_CHAIN_STRICT", false),
+		SupplyChainStorage:   getEnv("SUPPLY_CHAIN_STORAGE", ""),
+
+		ExecEnabled:       getEnvBool("EXEC_ENABLED", true),
+		ExecTimeout:       getEnvDuration("EXEC_TIMEOUT", 15*time.Minute),
+		ExecMaxPerProject: getEnvInt("EXEC_MAX_PER_PROJECT", 2),
+		ExecCPULimit:      getEnv("EXEC_CPU_LIMIT", "500m"),
+		ExecMemoryLimit:   getEnv("EXEC_MEMORY_LIMIT", "512Mi"),
+
+		WebhookMaxAttempts:        getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		WebhookRetryBackoff:       getEnvDuration("WEBHOOK_RETRY_BACKOFF", 30*time.Second),
+		WebhookRetryMaxBackoff:    getEnvDuration("WEBHOOK_RETRY_MAX_BACKOFF", time.Hour),
+		WebhookDeadAlertThreshold: getEnvInt("WEBHOOK_DEAD_ALERT_THRESHOLD", 10),
+		WebhookEventRetention:     getEnvDuration("WEBHOOK_EVENT_RETENTION", 7*24*time.Hour),
+
+		HooksFile:   getEnv("HOOKS_FILE", ""),
+		HookTimeout: getEnvDuration("HOOK_TIMEOUT", 30*time.Second),
+
+		BuildConcurrency:  getEnvInt("BUILD_CONCURRENCY", 2),
+		DeployConcurrency: getEnvInt("DEPLOY_CONCURRENCY", 5),
+		BuildWeightStepMB: getEnvInt("BUILD_WEIGHT_STEP_MB", 250),
+
+		BuildReservedSlots:    getEnvInt("BUILD_RESERVED_SLOTS", 0),
+		BuildReservedFraction: getEnvFloat("BUILD_RESERVED_FRACTION", 0),
+
+		IngressMaxTimeoutSeconds: getEnvInt("INGRESS_MAX_TIMEOUT_SECONDS", 3600),
+		IngressMaxBodySizeMB:     getEnvInt("INGRESS_MAX_BODY_SIZE_MB", 100),
+
+		NetworkPolicies: getEnvBool("NETWORK_POLICIES", false),
+		LabelDenylist:   getEnvList("LABEL_DENYLIST"),
+
+		ImageSizeWarnMB: getEnvInt("IMAGE_SIZE_WARN_MB", 500),
+		ImageSizeMaxMB:  getEnvInt("IMAGE_SIZE_MAX_MB", 4096),
+		ImageMaxLayers:  getEnvInt("IMAGE_MAX_LAYERS", 50),
+
+		DockerfileTemplateDir: getEnv("DOCKERFILE_TEMPLATE_DIR", ""),
+		BaseImageRegistry:     getEnv("BASE_IMAGE_REGISTRY", ""),
+		DockerfileLabels:      getEnvList("DOCKERFILE_LABELS"),
+		BuildHTTPProxy:        getEnv("BUILD_HTTP_PROXY", ""),
+		BuildHTTPSProxy:       getEnv("BUILD_HTTPS_PROXY", ""),
+		BuildNoProxy:          getEnv("BUILD_NO_PROXY", ""),
+		RuntimeVersionPattern: getEnv("RUNTIME_VERSION_PATTERN", `^\d+(\.\d+){0,2}$`),
+
+		RegistryPrivateHosts: getEnvList("REGISTRY_PRIVATE_HOSTS"),
+
+		RegistryURL:      strings.TrimSuffix(getEnv("REGISTRY_URL", ""), "/"),
+		RegistryUser:     getEnv("REGISTRY_USER", ""),
+		RegistryPassword: getEnv("REGISTRY_PASSWORD", ""),
+
+		BackupDestination: getEnv("BACKUP_DESTINATION", "backups"),
+		BackupSchedule:    getEnv("BACKUP_SCHEDULE", ""),
+		BackupRetention:   getEnvInt("BACKUP_RETENTION", 7),
+		BackupS3Endpoint:  getEnv("BACKUP_S3_ENDPOINT", ""),
+		BackupS3Region:    getEnv("BACKUP_S3_REGION", "us-east-1"),
+		BackupS3AccessKey: getEnv("BACKUP_S3_ACCESS_KEY", ""),
+		BackupS3SecretKey: getEnv("BACKUP_S3_SECRET_KEY", ""),
+		BackupS3PathStyle: getEnvBool("BACKUP_S3_PATH_STYLE", true),
+
+		VaultAddr:          getEnv("VAULT_ADDR", ""),
+		VaultNamespace:     getEnv("VAULT_NAMESPACE", ""),
+		VaultToken:         getEnv("VAULT_TOKEN", ""),
+		VaultRoleID:        getEnv("VAULT_ROLE_ID", ""),
+		VaultSecretID:      getEnv("VAULT_SECRET_ID", ""),
+		AWSRegion:          getEnv("AWS_REGION", "us-east-1"),
+		AWSAccessKeyID:     getEnv("AWS_ACCESS_KEY_ID", ""),
+		AWSSecretAccessKey: getEnv("AWS_SECRET_ACCESS_KEY", ""),
+		AWSSessionToken:    getEnv("AWS_SESSION_TOKEN", ""),
+		AWSSecretsEndpoint: getEnv("AWS_SECRETS_MANAGER_ENDPOINT", ""),
+
+		BuildLogArchive: getEnv("BUILD_LOG_ARCHIVE", ""),
+		BuildLogHotDays: getEnvInt("BUILD_LOG_HOT_DAYS", 30),
+
+		LogSinkType:          getEnv("LOG_SINK_TYPE", ""),
+		LogSinkURL:           getEnv("LOG_SINK_URL", ""),
+		LogSinkToken:         getEnv("LOG_SINK_TOKEN", ""),
+		LogSinkBuffer:        getEnvInt("LOG_SINK_BUFFER", 10000),
+		LogSinkBatchSize:     getEnvInt("LOG_SINK_BATCH_SIZE", 500),
+		LogSinkFlushInterval: getEnvDuration("LOG_SINK_FLUSH_INTERVAL", 2*time.Second),
+
+		DriftCheckInterval: getEnvDurationOrZero("DRIFT_CHECK_INTERVAL", time.Hour),
+		DriftAutoDelete:    getEnvBool("DRIFT_AUTO_DELETE", false),
+		DriftOrphanMinAge:  getEnvDurationOrZero("DRIFT_ORPHAN_MIN_AGE", 24*time.Hour),
+
+		CostCPUHour:        getEnvFloat("COST_CPU_HOUR", 0.04),
+		CostMemoryGBHour:   getEnvFloat("COST_MEMORY_GB_HOUR", 0.005),
+		CostCurrency:       getEnv("COST_CURRENCY", "USD"),
+		CostSampleInterval: getEnvDurationOrZero("COST_SAMPLE_INTERVAL", 5*time.Minute),
+		CostRetention:      getEnvDuration("COST_RETENTION", 400*24*time.Hour),
+
+		InstanceID:          getEnv("INSTANCE_ID", hostname()),
+		LeaderElection:      getEnv("LEADER_ELECTION", "database"),
+		LeaderLeaseDuration: getEnvDuration("LEADER_LEASE_DURATION", 15*time.Second),
+		JobRunRetention:     getEnvDuration("JOB_RUN_RETENTION", 7*24*time.Hour),
+
+		OIDCIssuerURL:    getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCCallbackURL:  getEnv("OIDC_CALLBACK_URL", "http://localhost:8080/auth/oidc/callback"),
+		OIDCProviderName: getEnv("OIDC_PROVIDER_NAME", "SSO"),
+		OIDCTrustEmail:   getEnvBool("OIDC_TRUST_EMAIL", false),
+		OIDCGroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCAdminGroups:  getEnvList("OIDC_ADMIN_GROUPS"),
+
+		AllowedEmailDomains: getEnvList("ALLOWED_EMAIL_DOMAINS"),
+		AllowedGitHubOrgs:   getEnvList("ALLOWED_GITHUB_ORGS"),
+		StrictRevalidate:    getEnvBool("STRICT_REVALIDATE", false),
+
+		ImpersonationAllowWrites: getEnvBool("IMPERSONATION_ALLOW_WRITES", false),
+
+		LoginDelayAfter:      getEnvInt("LOGIN_DELAY_AFTER", 5),
+		LoginLockoutAfter:    getEnvInt("LOGIN_LOCKOUT_AFTER", 10),
+		LoginLockoutDuration: getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+
+		FreePlanMaxProjects:          getEnvInt("FREE_PLAN_MAX_PROJECTS", 5),
+		FreePlanMaxDeploymentsPerDay: getEnvInt("FREE_PLAN_MAX_DEPLOYMENTS_PER_DAY", 50),
+		FreePlanMaxCustomDomains:     getEnvInt("FREE_PLAN_MAX_CUSTOM_DOMAINS", 1),
+		FreePlanMaxEnvVars:           getEnvInt("FREE_PLAN_MAX_ENV_VARS", 50),
 	}
 }
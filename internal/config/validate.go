@@ -0,0 +1,452 @@
+package config
+
+import (
+	"deploy-platform/internal/netutil"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Known insecure secrets: the development fallbacks and the placeholders of
+// tutorials. They are fine on localhost and refused anywhere else.
+const (
+	DevJWTSecret     = "bbdjvcbjfebvjebvjbejvhbejbvjfnvkj"
+	DevWebhookSecret = "nncfebvjhebhjvrevjejrvhjelv"
+)
+
+var insecureSecrets = []string{
+	DevJWTSecret, DevWebhookSecret,
+	"secret", "changeme", "change-me", "changethis", "your-secret-key", "your_secret_key", "jwt-secret", "supersecret",
+}
+
+// minSecretLength is the length below which a secret is likely guessable
+const minSecretLength = 32
+
+var dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// imageRepositoryPattern matches a registry host, its port and a path of
+// repository components, as images are named
+var imageRepositoryPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?(:[0-9]{1,5})?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+
+// Validation lists what is wrong with a configuration. Errors stop the
+// platform from starting; warnings are logged.
+type Validation struct {
+	Errors   []string
+	Warnings []string
+}
+
+func (v *Validation) errorf(format string, args ...interface{}) {
+	v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+}
+
+func (v *Validation) warnf(format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Err returns the errors as one readable block, or nil when there are none
+func (v *Validation) Err() error {
+	if len(v.Errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d configuration problem(s):\n  - %s", len(v.Errors), strings.Join(v.Errors, "\n  - "))
+}
+
+// Validate checks the whole configuration and reports every problem at
+// once: missing and insecure secrets, malformed URLs, options that must be
+// set together, and numbers out of range
+func (c *Config) Validate() *Validation {
+	v := &Validation{}
+	c.validateSecrets(v)
+	c.validateURLs(v)
+	c.validateDependencies(v)
+	c.validateRanges(v)
+	return v
+}
+
+// Local reports whether the platform runs for local development, where the
+// development fallbacks of secrets are accepted
+func (c *Config) Local() bool {
+	return c.BaseDomain == "localhost" || strings.HasSuffix(c.BaseDomain, ".localhost")
+}
+
+func (c *Config) validateSecrets(v *Validation) {
+	if c.GitHubClientID == "" || c.GitHubClientSecret == "" {
+		v.errorf("GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET are required: create an OAuth app at https://github.com/settings/developers")
+	}
+
+	// Insecure secrets are refused outside local development, where they
+	// are only warned about
+	insecure := v.errorf
+	if c.Local() {
+		insecure = v.warnf
+	}
+	jwtKey := "JWT_SECRET"
+	if len(c.JWTSecrets) > 0 {
+		jwtKey = "JWT_SECRETS"
+		if c.EncryptionKey == "" {
+			v.warnf("JWT_SECRETS is set without ENCRYPTION_KEY: stored credentials are still encrypted with a key derived from JWT_SECRET, keep it or set ENCRYPTION_KEY to it")
+		}
+	}
+	keys := c.JWTKeys()
+	if len(keys) == 0 {
+		v.errorf("JWT_SECRET is required: generate one with `openssl rand -hex 32`")
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		switch {
+		case seen[key]:
+			v.warnf("%s lists the same secret twice", jwtKey)
+		case isInsecureSecret(key):
+			insecure("%s has a known default, anyone can forge sessions: generate one with `openssl rand -hex 32`", jwtKey)
+		case len(key) < minSecretLength:
+			v.warnf("%s has a secret shorter than %d characters: generate one with `openssl rand -hex 32`", jwtKey, minSecretLength)
+		}
+		seen[key] = true
+	}
+	switch {
+	case c.WebhookSecret == "":
+		insecure("WEBHOOK_SECRET is not set, webhooks are checked against a known default: set it to the secret of the GitHub webhook")
+	case isInsecureSecret(c.WebhookSecret):
+		insecure("WEBHOOK_SECRET is a known default, anyone can trigger deployments: set it to a random secret, also on the GitHub webhook")
+	}
+	switch {
+	case c.EncryptionKey == "":
+		v.warnf("ENCRYPTION_KEY is not set, stored credentials are encrypted with a key derived from JWT_SECRET: rotating JWT_SECRET would make them unreadable")
+	case isInsecureSecret(c.EncryptionKey):
+		insecure("ENCRYPTION_KEY is a known default: generate one with `openssl rand -hex 32`")
+	}
+}
+
+func (c *Config) validateURLs(v *Validation) {
+	checkURL(v, "BASE_URL", c.BaseURL, "http", "https")
+	checkURL(v, "GITHUB_CALLBACK_URL", c.GitHubCallbackURL, "http", "https")
+	if c.GoogleClientID != "" {
+		checkURL(v, "GOOGLE_CALLBACK_URL", c.GoogleCallbackURL, "http", "https")
+	}
+	if c.OIDCIssuerURL != "" {
+		checkURL(v, "OIDC_ISSUER_URL", c.OIDCIssuerURL, "https")
+		checkURL(v, "OIDC_CALLBACK_URL", c.OIDCCallbackURL, "http", "https")
+	}
+	if c.DatabaseURL != "" && strings.Contains(c.DatabaseURL, "://") {
+		checkURL(v, "DATABASE_URL", c.DatabaseURL, "postgres", "postgresql")
+	}
+	if c.RedisURL != "" {
+		checkURL(v, "REDIS_URL", c.RedisURL, "redis", "rediss")
+	}
+	if c.BackupS3Endpoint != "" {
+		checkURL(v, "BACKUP_S3_ENDPOINT", c.BackupS3Endpoint, "http", "https")
+	}
+	if c.VaultAddr != "" {
+		checkURL(v, "VAULT_ADDR", c.VaultAddr, "http", "https")
+	}
+	if c.AWSSecretsEndpoint != "" {
+		checkURL(v, "AWS_SECRETS_MANAGER_ENDPOINT", c.AWSSecretsEndpoint, "http", "https")
+	}
+	if c.AdminAlertWebhook != "" {
+		checkURL(v, "ADMIN_ALERT_WEBHOOK", c.AdminAlertWebhook, "http", "https")
+	}
+	if c.LogSinkURL != "" {
+		checkURL(v, "LOG_SINK_URL", c.LogSinkURL, "http", "https")
+	}
+	if c.BuildHTTPProxy != "" {
+		checkURL(v, "BUILD_HTTP_PROXY", c.BuildHTTPProxy, "http", "https", "socks5")
+	}
+	if c.BuildHTTPSProxy != "" {
+		checkURL(v, "BUILD_HTTPS_PROXY", c.BuildHTTPSProxy, "http", "https", "socks5")
+	}
+
+	if c.PublicScheme != "http" && c.PublicScheme != "https" {
+		v.errorf("PUBLIC_SCHEME must be http or https, got %q", c.PublicScheme)
+	} else if c.PublicScheme == "http" && !c.Local() {
+		v.warnf("PUBLIC_SCHEME is http: deployed apps on %s are served without TLS", c.BaseDomain)
+	}
+	if err := checkDomain(c.BaseDomain); err != nil {
+		v.errorf("BASE_DOMAIN %q is not a valid DNS name: %v", c.BaseDomain, err)
+	}
+	for _, cluster := range c.Clusters {
+		if cluster.BaseDomain == "" {
+			continue
+		}
+		if err := checkDomain(cluster.BaseDomain); err != nil {
+			v.errorf("KUBERNETES_CLUSTER_DOMAINS: %q of cluster %s is not a valid DNS name: %v", cluster.BaseDomain, cluster.Name, err)
+		}
+	}
+}
+
+func (c *Config) validateDependencies(v *Validation) {
+	names := make(map[string]bool, len(c.Clusters))
+	for _, cluster := range c.Clusters {
+		switch {
+		case !dnsLabelPattern.MatchString(cluster.Name) || len(cluster.Name) > 63:
+			v.errorf("KUBERNETES_CLUSTERS: cluster name %q must be a lowercase DNS label, e.g. staging", cluster.Name)
+		case names[cluster.Name]:
+			v.errorf("KUBERNETES_CLUSTERS: cluster %s is listed twice", cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+	if !names[c.DefaultCluster] {
+		v.errorf("DEFAULT_CLUSTER %q is not one of KUBERNETES_CLUSTERS", c.DefaultCluster)
+	}
+	for _, entry := range getEnvList("KUBERNETES_CLUSTER_DOMAINS") {
+		if name, _, _ := strings.Cut(entry, "="); !names[strings.TrimSpace(name)] {
+			v.errorf("KUBERNETES_CLUSTER_DOMAINS names cluster %q, which is not in KUBERNETES_CLUSTERS", strings.TrimSpace(name))
+		}
+	}
+	if (c.GoogleClientID == "") != (c.GoogleClientSecret == "") {
+		v.errorf("GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET must be set together, or Google sign-in stays disabled")
+	}
+	if c.OIDCIssuerURL != "" && (c.OIDCClientID == "" || c.OIDCClientSecret == "") {
+		v.errorf("OIDC_ISSUER_URL needs OIDC_CLIENT_ID and OIDC_CLIENT_SECRET, or single sign-on stays disabled")
+	}
+	if c.OIDCIssuerURL == "" && (c.OIDCClientID != "" || len(c.OIDCAdminGroups) > 0) {
+		v.warnf("OIDC_CLIENT_ID and OIDC_ADMIN_GROUPS have no effect without OIDC_ISSUER_URL")
+	}
+	if (c.BackupS3AccessKey == "") != (c.BackupS3SecretKey == "") {
+		v.errorf("BACKUP_S3_ACCESS_KEY and BACKUP_S3_SECRET_KEY must be set together")
+	}
+	if c.VaultAddr != "" && c.VaultToken == "" && (c.VaultRoleID == "" || c.VaultSecretID == "") {
+		v.errorf("VAULT_ADDR needs VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID for an AppRole login")
+	}
+	if c.VaultAddr == "" && (c.VaultToken != "" || c.VaultRoleID != "") {
+		v.warnf("VAULT_TOKEN and VAULT_ROLE_ID have no effect without VAULT_ADDR: ref+vault:// env vars can't be resolved")
+	}
+	if (c.AWSAccessKeyID == "") != (c.AWSSecretAccessKey == "") {
+		v.errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together, or ref+awssm:// env vars can't be resolved")
+	}
+	if c.GitHubAppID < 0 {
+		v.errorf("GITHUB_APP_ID must be the numeric ID of a GitHub App")
+	}
+	if (c.GitHubAppID == 0) != (c.GitHubAppKey == "") {
+		v.errorf("GITHUB_APP_ID and GITHUB_APP_PRIVATE_KEY must be set together, or builds report commit statuses instead of check runs")
+	}
+	if c.BackupS3Endpoint != "" && !strings.HasPrefix(c.BackupDestination, "s3://") && !strings.HasPrefix(c.BuildLogArchive, "s3://") && !strings.HasPrefix(c.SupplyChainStorage, "s3://") {
+		v.warnf("BACKUP_S3_ENDPOINT is set but none of BACKUP_DESTINATION, BUILD_LOG_ARCHIVE and SUPPLY_CHAIN_STORAGE is an s3:// URL")
+	}
+	switch c.LogSinkType {
+	case "":
+		if c.LogSinkURL != "" {
+			v.warnf("LOG_SINK_URL has no effect without LOG_SINK_TYPE (loki or http)")
+		}
+	case "loki", "http":
+		if c.LogSinkURL == "" {
+			v.errorf("LOG_SINK_TYPE %s needs LOG_SINK_URL", c.LogSinkType)
+		}
+	default:
+		v.errorf("LOG_SINK_TYPE must be loki or http, got %q", c.LogSinkType)
+	}
+	if (c.RegistryUser == "") != (c.RegistryPassword == "") {
+		v.errorf("REGISTRY_USER and REGISTRY_PASSWORD must be set together")
+	}
+	if c.RegistryURL == "" && c.RegistryUser != "" {
+		v.warnf("REGISTRY_USER has no effect without REGISTRY_URL: images aren't pushed")
+	}
+	if c.RegistryURL != "" && !imageRepositoryPattern.MatchString(c.RegistryURL) {
+		v.errorf("REGISTRY_URL must be host[:port][/path] in lowercase, without a scheme, got %q", c.RegistryURL)
+	}
+	if c.StrictRevalidate && len(c.AllowedEmailDomains) == 0 && len(c.AllowedGitHubOrgs) == 0 {
+		v.warnf("STRICT_REVALIDATE has no effect without ALLOWED_EMAIL_DOMAINS or ALLOWED_GITHUB_ORGS")
+	}
+}
+
+func (c *Config) validateRanges(v *Validation) {
+	if c.PublicPort < 0 || c.PublicPort > 65535 {
+		v.errorf("PUBLIC_PORT must be between 1 and 65535, got %d", c.PublicPort)
+	}
+	if c.LoginDelayAfter < 1 || c.LoginLockoutAfter <= c.LoginDelayAfter {
+		v.errorf("LOGIN_LOCKOUT_AFTER (%d) must be greater than LOGIN_DELAY_AFTER (%d), which must be at least 1", c.LoginLockoutAfter, c.LoginDelayAfter)
+	}
+	atLeast(v, "AUTH_RATE_LIMIT", c.AuthRateLimit, 0)
+	atLeast(v, "BUILD_CONCURRENCY", c.BuildConcurrency, 1)
+	atLeast(v, "DEPLOY_CONCURRENCY", c.DeployConcurrency, 1)
+	atLeast(v, "BUILD_WEIGHT_STEP_MB", c.BuildWeightStepMB, 0)
+	atLeast(v, "BUILD_RESERVED_SLOTS", c.BuildReservedSlots, 0)
+	if c.BuildReservedFraction < 0 || c.BuildReservedFraction >= 1 {
+		v.errorf("BUILD_RESERVED_FRACTION must be at least 0 and less than 1, got %g", c.BuildReservedFraction)
+	}
+	if c.BuildReservedSlots > 0 && c.BuildReservedFraction > 0 {
+		v.errorf("BUILD_RESERVED_SLOTS and BUILD_RESERVED_FRACTION are exclusive, set one of them")
+	}
+	if reserved := c.ReservedBuildSlots(); c.BuildConcurrency >= 1 && reserved >= c.BuildConcurrency {
+		v.errorf("%d of the %d build slots (BUILD_CONCURRENCY) are reserved for production deployments, leaving none for the others", reserved, c.BuildConcurrency)
+	}
+	atLeast(v, "INGRESS_MAX_TIMEOUT_SECONDS", c.IngressMaxTimeoutSeconds, 1)
+	atLeast(v, "INGRESS_MAX_BODY_SIZE_MB", c.IngressMaxBodySizeMB, 1)
+	atLeast(v, "IMAGE_SIZE_WARN_MB", c.ImageSizeWarnMB, 0)
+	atLeast(v, "IMAGE_SIZE_MAX_MB", c.ImageSizeMaxMB, 0)
+	atLeast(v, "IMAGE_MAX_LAYERS", c.ImageMaxLayers, 0)
+	atLeast(v, "BACKUP_RETENTION", c.BackupRetention, 0)
+	atLeast(v, "BUILD_LOG_HOT_DAYS", c.BuildLogHotDays, 1)
+	atLeast(v, "GIT_CACHE_MAX_MB", c.GitCacheMaxMB, 0)
+	atLeast(v, "ANALYSIS_MAX_REPO_MB", c.AnalysisMaxRepoMB, 1)
+	atLeast(v, "EXEC_MAX_PER_PROJECT", c.ExecMaxPerProject, 1)
+	atLeast(v, "WEBHOOK_MAX_ATTEMPTS", c.WebhookMaxAttempts, 1)
+	atLeast(v, "DEPLOY_RETRY_ATTEMPTS", c.DeployRetryAttempts, 1)
+	atLeast(v, "WEBHOOK_DEAD_ALERT_THRESHOLD", c.WebhookDeadAlertThreshold, 0)
+	atLeast(v, "FREE_PLAN_MAX_PROJECTS", c.FreePlanMaxProjects, 0)
+	atLeast(v, "FREE_PLAN_MAX_DEPLOYMENTS_PER_DAY", c.FreePlanMaxDeploymentsPerDay, 0)
+	atLeast(v, "FREE_PLAN_MAX_CUSTOM_DOMAINS", c.FreePlanMaxCustomDomains, 0)
+	atLeast(v, "FREE_PLAN_MAX_ENV_VARS", c.FreePlanMaxEnvVars, 0)
+	atLeast(v, "LOG_SINK_BATCH_SIZE", c.LogSinkBatchSize, 1)
+	if c.LogSinkBuffer < c.LogSinkBatchSize {
+		v.errorf("LOG_SINK_BUFFER (%d) must be at least LOG_SINK_BATCH_SIZE (%d)", c.LogSinkBuffer, c.LogSinkBatchSize)
+	}
+	if c.LogSinkFlushInterval <= 0 {
+		v.errorf("LOG_SINK_FLUSH_INTERVAL must be positive, got %s", c.LogSinkFlushInterval)
+	}
+	if c.DriftCheckInterval < 0 || (c.DriftCheckInterval > 0 && c.DriftCheckInterval < time.Minute) {
+		v.errorf("DRIFT_CHECK_INTERVAL must be 0 or at least 1m, got %s", c.DriftCheckInterval)
+	}
+	if c.DriftOrphanMinAge < 0 {
+		v.errorf("DRIFT_ORPHAN_MIN_AGE must not be negative, got %s", c.DriftOrphanMinAge)
+	}
+	if c.DriftAutoDelete && c.DriftOrphanMinAge < time.Hour {
+		v.warnf("DRIFT_AUTO_DELETE with DRIFT_ORPHAN_MIN_AGE %s may delete objects whose deployment is still being recorded", c.DriftOrphanMinAge)
+	}
+	if c.CostCPUHour < 0 || c.CostMemoryGBHour < 0 {
+		v.errorf("COST_CPU_HOUR and COST_MEMORY_GB_HOUR must not be negative")
+	}
+	if c.CostSampleInterval < 0 || (c.CostSampleInterval > 0 && c.CostSampleInterval < time.Minute) {
+		v.errorf("COST_SAMPLE_INTERVAL must be 0 or at least 1m, got %s", c.CostSampleInterval)
+	}
+	if c.CostSampleInterval > 0 && c.CostRetention < 24*time.Hour {
+		v.warnf("COST_RETENTION %s keeps less than a day of usage, estimates of longer windows will be too low", c.CostRetention)
+	}
+	if c.LeaderElection != "database" && c.LeaderElection != "kubernetes" {
+		v.errorf("LEADER_ELECTION must be database or kubernetes, got %q", c.LeaderElection)
+	}
+	if c.LeaderLeaseDuration < 3*time.Second {
+		v.errorf("LEADER_LEASE_DURATION must be at least 3s, got %s", c.LeaderLeaseDuration)
+	}
+	if c.InstanceID == "" {
+		v.errorf("INSTANCE_ID must be set when the hostname is unknown")
+	}
+
+	if c.ImageSizeWarnMB > 0 && c.ImageSizeMaxMB > 0 && c.ImageSizeWarnMB > c.ImageSizeMaxMB {
+		v.warnf("IMAGE_SIZE_WARN_MB (%d) is above IMAGE_SIZE_MAX_MB (%d): images are refused before anyone is warned", c.ImageSizeWarnMB, c.ImageSizeMaxMB)
+	}
+	if _, err := regexp.Compile(c.RuntimeVersionPattern); err != nil {
+		v.errorf("RUNTIME_VERSION_PATTERN is not a valid regular expression: %v", err)
+	} else if !strings.HasPrefix(c.RuntimeVersionPattern, "^") || !strings.HasSuffix(c.RuntimeVersionPattern, "$") {
+		v.warnf("RUNTIME_VERSION_PATTERN (%s) isn't anchored with ^ and $: versions only need to contain a match, so projects can tag base images with almost anything", c.RuntimeVersionPattern)
+	}
+	if c.AnalysisTimeout <= 0 {
+		v.errorf("ANALYSIS_TIMEOUT must be positive, got %s", c.AnalysisTimeout)
+	}
+	if c.ShutdownTimeout <= 0 {
+		v.errorf("SHUTDOWN_TIMEOUT must be positive, got %s", c.ShutdownTimeout)
+	}
+	if c.ReleaseTimeout <= 0 {
+		v.errorf("RELEASE_TIMEOUT must be positive, got %s", c.ReleaseTimeout)
+	}
+	if c.ApprovalWindow < time.Minute {
+		v.errorf("APPROVAL_WINDOW must be at least 1m, got %s", c.ApprovalWindow)
+	}
+	if c.ApprovalMaxWindow < c.ApprovalWindow {
+		v.errorf("APPROVAL_MAX_WINDOW (%s) must be at least APPROVAL_WINDOW (%s)", c.ApprovalMaxWindow, c.ApprovalWindow)
+	}
+	if c.PlaceholderBackend != "" {
+		if host, port, err := net.SplitHostPort(c.PlaceholderBackend); err != nil || host == "" || port == "" {
+			v.errorf("PLACEHOLDER_BACKEND must be host:port, got %q", c.PlaceholderBackend)
+		} else if port, err := strconv.Atoi(port); err != nil || port < 1 || port > 65535 {
+			v.errorf("PLACEHOLDER_BACKEND port must be between 1 and 65535, got %q", c.PlaceholderBackend)
+		} else if netutil.IsIPLiteral(host) && strings.Contains(host, ":") {
+			// Clusters reach it through an ExternalName Service, whose target
+			// must look like a DNS name; IPv4 addresses happen to
+			v.errorf("PLACEHOLDER_BACKEND can't be an IPv6 address, use a name with an AAAA record instead, got %q", c.PlaceholderBackend)
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			v.errorf("TRUSTED_PROXIES must list IP addresses or CIDR ranges, got %q", proxy)
+		}
+	}
+	if c.HealthCheckInterval < 5*time.Second {
+		v.errorf("HEALTH_CHECK_INTERVAL must be at least 5s, got %s", c.HealthCheckInterval)
+	}
+	if c.SupplyChainStrict && c.SBOMGenerator == "" {
+		v.warnf("SUPPLY_CHAIN_STRICT is on without SBOM_GENERATOR: builds are only held to their provenance")
+	}
+	if c.ExecTimeout < time.Second {
+		v.errorf("EXEC_TIMEOUT must be at least 1s, got %s", c.ExecTimeout)
+	}
+	if c.WebhookRetryBackoff < time.Second {
+		v.errorf("WEBHOOK_RETRY_BACKOFF must be at least 1s, got %s", c.WebhookRetryBackoff)
+	}
+	if c.WebhookRetryMaxBackoff < c.WebhookRetryBackoff {
+		v.errorf("WEBHOOK_RETRY_MAX_BACKOFF (%s) must be at least WEBHOOK_RETRY_BACKOFF (%s)", c.WebhookRetryMaxBackoff, c.WebhookRetryBackoff)
+	}
+	if c.DeployRetryBackoff < time.Second {
+		v.errorf("DEPLOY_RETRY_BACKOFF must be at least 1s, got %s", c.DeployRetryBackoff)
+	}
+	if c.DeployRetryMaxBackoff < c.DeployRetryBackoff {
+		v.errorf("DEPLOY_RETRY_MAX_BACKOFF (%s) must be at least DEPLOY_RETRY_BACKOFF (%s)", c.DeployRetryMaxBackoff, c.DeployRetryBackoff)
+	}
+	if c.WebhookEventRetention < time.Hour {
+		v.errorf("WEBHOOK_EVENT_RETENTION must be at least 1h, got %s", c.WebhookEventRetention)
+	}
+	if c.HookTimeout <= 0 {
+		v.errorf("HOOK_TIMEOUT must be positive, got %s", c.HookTimeout)
+	}
+	if c.BuildConcurrency > 16 {
+		v.warnf("BUILD_CONCURRENCY is %d: each build may use several CPUs and GBs of memory", c.BuildConcurrency)
+	}
+}
+
+// checkURL reports value, the setting name, unless it is an absolute URL
+// with one of schemes
+func checkURL(v *Validation, name, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		v.errorf("%s is not a valid URL: %v", name, err)
+		return
+	}
+	scheme := strings.ToLower(u.Scheme)
+	valid := false
+	for _, s := range schemes {
+		valid = valid || scheme == s
+	}
+	switch {
+	case !valid:
+		v.errorf("%s must be a %s:// URL, got %q", name, strings.Join(schemes, ":// or "), u.Redacted())
+	case u.Host == "":
+		v.errorf("%s has no host, got %q", name, u.Redacted())
+	}
+}
+
+// checkDomain checks name is a lowercase DNS name
+func checkDomain(name string) error {
+	if name == "" {
+		return fmt.Errorf("it is empty")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("it is longer than 253 characters")
+	}
+	if strings.Contains(name, "://") || strings.ContainsAny(name, "/:") {
+		return fmt.Errorf("give the domain alone, without scheme, port or path")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > 63 || !dnsLabelPattern.MatchString(label) {
+			return fmt.Errorf("%q must be 1 to 63 lowercase letters, digits and hyphens", label)
+		}
+	}
+	return nil
+}
+
+func atLeast(v *Validation, name string, value, min int) {
+	if value < min {
+		v.errorf("%s must be at least %d, got %d", name, min, value)
+	}
+}
+
+func isInsecureSecret(secret string) bool {
+	for _, s := range insecureSecrets {
+		if strings.EqualFold(secret, s) {
+			return true
+		}
+	}
+	return false
+}
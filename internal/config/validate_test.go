@@ -0,0 +1,228 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// productionConfig loads a configuration without problems for a platform
+// on example.com, from the environment
+func productionConfig(t *testing.T) *Config {
+	t.Helper()
+	for key, value := range map[string]string{
+		"GITHUB_CLIENT_ID":           "Iv1.0123456789abcdef",
+		"GITHUB_CLIENT_SECRET":       "0123456789abcdef0123456789abcdef01234567",
+		"GITHUB_CALLBACK_URL":        "https://deploy.example.com/auth/github/callback",
+		"BASE_URL":                   "https://deploy.example.com",
+		"BASE_DOMAIN":                "apps.example.com",
+		"PUBLIC_SCHEME":              "https",
+		"JWT_SECRET":                 "3f1c2b9e8d7a6f5e4d3c2b1a0f9e8d7c6b5a4f3e2d1c0b9a",
+		"JWT_SECRETS":                "",
+		"WEBHOOK_SECRET":             "9b8a7f6e5d4c3b2a1f0e9d8c7b6a5f4e",
+		"ENCRYPTION_KEY":             "a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3",
+		"INSTANCE_ID":                "api-0",
+		"KUBERNETES_CLUSTERS":        "",
+		"KUBERNETES_CLUSTER_DOMAINS": "",
+		"DEFAULT_CLUSTER":            "",
+	} {
+		t.Setenv(key, value)
+	}
+	cfg := Load()
+	if v := cfg.Validate(); len(v.Errors) > 0 || len(v.Warnings) > 0 {
+		t.Fatalf("errors %q, warnings %q", v.Errors, v.Warnings)
+	}
+	return cfg
+}
+
+func TestValidateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Config)
+		err    string
+	}{
+		// Secrets
+		{"no OAuth app", func(c *Config) { c.GitHubClientSecret = "" }, "GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET are required"},
+		{"no JWT secret", func(c *Config) { c.JWTSecret = "" }, "JWT_SECRET is required"},
+		{"development JWT secret", func(c *Config) { c.JWTSecret = DevJWTSecret }, "JWT_SECRET has a known default"},
+		{"placeholder JWT secret", func(c *Config) { c.JWTSecret = "ChangeMe" }, "JWT_SECRET has a known default"},
+		{"placeholder in JWT_SECRETS", func(c *Config) { c.JWTSecrets = []string{c.JWTSecret, "supersecret"} }, "JWT_SECRETS has a known default"},
+		{"no webhook secret", func(c *Config) { c.WebhookSecret = "" }, "WEBHOOK_SECRET is not set"},
+		{"development webhook secret", func(c *Config) { c.WebhookSecret = DevWebhookSecret }, "WEBHOOK_SECRET is a known default"},
+		{"placeholder encryption key", func(c *Config) { c.EncryptionKey = "secret" }, "ENCRYPTION_KEY is a known default"},
+
+		// URLs
+		{"relative base URL", func(c *Config) { c.BaseURL = "/deploy" }, "BASE_URL must be a http:// or https:// URL"},
+		{"callback without host", func(c *Config) { c.GitHubCallbackURL = "https:///auth/github/callback" }, "GITHUB_CALLBACK_URL has no host"},
+		{"OIDC issuer over http", func(c *Config) {
+			c.OIDCIssuerURL, c.OIDCClientID, c.OIDCClientSecret = "http://sso.example.com", "deploy", "secret"
+		}, "OIDC_ISSUER_URL must be a https:// URL"},
+		{"MySQL database", func(c *Config) { c.DatabaseURL = "mysql://deploy@db/deploy" }, "DATABASE_URL must be a postgres:// or postgresql:// URL"},
+		{"Redis over http", func(c *Config) { c.RedisURL = "http://redis:6379" }, "REDIS_URL must be a redis:// or rediss:// URL"},
+		{"proxy without scheme", func(c *Config) { c.BuildHTTPProxy = "proxy:3128" }, "BUILD_HTTP_PROXY must be"},
+		{"URL with credentials redacted", func(c *Config) { c.RedisURL = "tcp://:hunter2@redis:6379" }, `got "tcp://:xxxxx@redis:6379"`},
+		{"unknown public scheme", func(c *Config) { c.PublicScheme = "ftp" }, "PUBLIC_SCHEME must be http or https"},
+
+		// Domains
+		{"empty base domain", func(c *Config) { c.BaseDomain = "" }, "BASE_DOMAIN \"\" is not a valid DNS name: it is empty"},
+		{"base domain as a URL", func(c *Config) { c.BaseDomain = "https://apps.example.com" }, "without scheme, port or path"},
+		{"base domain with a port", func(c *Config) { c.BaseDomain = "apps.example.com:8443" }, "without scheme, port or path"},
+		{"uppercase base domain", func(c *Config) { c.BaseDomain = "Apps.example.com" }, `"Apps" must be 1 to 63 lowercase letters`},
+		{"underscore in base domain", func(c *Config) { c.BaseDomain = "my_apps.example.com" }, `"my_apps" must be`},
+		{"empty label", func(c *Config) { c.BaseDomain = "apps..example.com" }, `"" must be`},
+		{"label too long", func(c *Config) { c.BaseDomain = strings.Repeat("a", 64) + ".example.com" }, "must be 1 to 63"},
+		{"invalid cluster domain", func(c *Config) { c.Clusters[0].BaseDomain = "staging.example.com/" }, "KUBERNETES_CLUSTER_DOMAINS: \"staging.example.com/\" of cluster default"},
+
+		// Options set together
+		{"cluster name", func(c *Config) { c.Clusters[0].Name, c.DefaultCluster = "Production", "Production" }, "must be a lowercase DNS label"},
+		{"cluster listed twice", func(c *Config) { c.Clusters = append(c.Clusters, c.Clusters[0]) }, "cluster default is listed twice"},
+		{"unknown default cluster", func(c *Config) { c.DefaultCluster = "staging" }, `DEFAULT_CLUSTER "staging" is not one of KUBERNETES_CLUSTERS`},
+		{"Google client without secret", func(c *Config) { c.GoogleClientID = "1234.apps.googleusercontent.com" }, "GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET must be set together"},
+		{"OIDC without client", func(c *Config) { c.OIDCIssuerURL = "https://sso.example.com" }, "OIDC_ISSUER_URL needs OIDC_CLIENT_ID and OIDC_CLIENT_SECRET"},
+		{"S3 access key alone", func(c *Config) { c.BackupS3AccessKey = "AKIA0123" }, "BACKUP_S3_ACCESS_KEY and BACKUP_S3_SECRET_KEY must be set together"},
+		{"Vault without credentials", func(c *Config) { c.VaultAddr, c.VaultRoleID = "https://vault.example.com", "deploy" }, "VAULT_ADDR needs VAULT_TOKEN"},
+		{"AWS key alone", func(c *Config) { c.AWSSecretAccessKey = "wJalrXUtnFEMI" }, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together"},
+		{"GitHub App without key", func(c *Config) { c.GitHubAppID = 123456 }, "GITHUB_APP_ID and GITHUB_APP_PRIVATE_KEY must be set together"},
+		{"negative GitHub App", func(c *Config) { c.GitHubAppID, c.GitHubAppKey = -1, "key" }, "GITHUB_APP_ID must be the numeric ID"},
+		{"log sink without URL", func(c *Config) { c.LogSinkType = "loki" }, "LOG_SINK_TYPE loki needs LOG_SINK_URL"},
+		{"unknown log sink", func(c *Config) { c.LogSinkType = "syslog" }, "LOG_SINK_TYPE must be loki or http"},
+		{"registry user without password", func(c *Config) { c.RegistryURL, c.RegistryUser = "registry.example.com", "deploy" }, "REGISTRY_USER and REGISTRY_PASSWORD must be set together"},
+		{"registry with a scheme", func(c *Config) { c.RegistryURL = "https://registry.example.com" }, "REGISTRY_URL must be host[:port][/path]"},
+		{"uppercase registry path", func(c *Config) { c.RegistryURL = "registry.example.com/Deploy" }, "REGISTRY_URL must be host[:port][/path]"},
+
+		// Ranges
+		{"public port out of range", func(c *Config) { c.PublicPort = 70000 }, "PUBLIC_PORT must be between 1 and 65535"},
+		{"lockout before delay", func(c *Config) { c.LoginLockoutAfter = c.LoginDelayAfter }, "LOGIN_LOCKOUT_AFTER"},
+		{"no build slot", func(c *Config) { c.BuildConcurrency = 0 }, "BUILD_CONCURRENCY must be at least 1, got 0"},
+		{"reserved fraction of 1", func(c *Config) { c.BuildReservedFraction = 1 }, "BUILD_RESERVED_FRACTION must be at least 0 and less than 1"},
+		{"reserved slots and fraction", func(c *Config) { c.BuildReservedSlots, c.BuildReservedFraction = 1, 0.5 }, "are exclusive"},
+		{"every slot reserved", func(c *Config) { c.BuildReservedSlots = c.BuildConcurrency }, "leaving none for the others"},
+		{"log sink buffer below a batch", func(c *Config) { c.LogSinkBuffer = c.LogSinkBatchSize - 1 }, "LOG_SINK_BUFFER (499) must be at least LOG_SINK_BATCH_SIZE (500)"},
+		{"drift checked every second", func(c *Config) { c.DriftCheckInterval = time.Second }, "DRIFT_CHECK_INTERVAL must be 0 or at least 1m"},
+		{"negative cost", func(c *Config) { c.CostCPUHour = -0.01 }, "COST_CPU_HOUR and COST_MEMORY_GB_HOUR must not be negative"},
+		{"unknown leader election", func(c *Config) { c.LeaderElection = "etcd" }, "LEADER_ELECTION must be database or kubernetes"},
+		{"short lease", func(c *Config) { c.LeaderLeaseDuration = time.Second }, "LEADER_LEASE_DURATION must be at least 3s"},
+		{"no instance ID", func(c *Config) { c.InstanceID = "" }, "INSTANCE_ID must be set"},
+		{"invalid version pattern", func(c *Config) { c.RuntimeVersionPattern = `^\d+(` }, "RUNTIME_VERSION_PATTERN is not a valid regular expression"},
+		{"approval window below the default", func(c *Config) { c.ApprovalMaxWindow = time.Hour }, "APPROVAL_MAX_WINDOW (1h0m0s) must be at least APPROVAL_WINDOW (24h0m0s)"},
+		{"placeholder without port", func(c *Config) { c.PlaceholderBackend = "placeholder.example.com" }, "PLACEHOLDER_BACKEND must be host:port"},
+		{"placeholder port out of range", func(c *Config) { c.PlaceholderBackend = "placeholder.example.com:0" }, "PLACEHOLDER_BACKEND port must be between 1 and 65535"},
+		{"placeholder on IPv6", func(c *Config) { c.PlaceholderBackend = "[2001:db8::1]:8081" }, "PLACEHOLDER_BACKEND can't be an IPv6 address"},
+		{"trusted proxy by name", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "lb.internal"} }, `TRUSTED_PROXIES must list IP addresses or CIDR ranges, got "lb.internal"`},
+		{"frequent health checks", func(c *Config) { c.HealthCheckInterval = time.Second }, "HEALTH_CHECK_INTERVAL must be at least 5s"},
+		{"webhook backoff above its maximum", func(c *Config) { c.WebhookRetryMaxBackoff = time.Second }, "WEBHOOK_RETRY_MAX_BACKOFF (1s) must be at least WEBHOOK_RETRY_BACKOFF (30s)"},
+		{"short webhook event retention", func(c *Config) { c.WebhookEventRetention = time.Minute }, "WEBHOOK_EVENT_RETENTION must be at least 1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := productionConfig(t)
+			tt.change(cfg)
+			v := cfg.Validate()
+			found := false
+			for _, problem := range v.Errors {
+				found = found || strings.Contains(problem, tt.err)
+			}
+			if !found {
+				t.Errorf("errors %q, want %q", v.Errors, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateWarnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*Config)
+		warning string
+	}{
+		{"short JWT secret", func(c *Config) { c.JWTSecret = "0123456789abcdef" }, "JWT_SECRET has a secret shorter than 32 characters"},
+		{"JWT secret listed twice", func(c *Config) { c.JWTSecrets = []string{c.JWTSecret, c.JWTSecret} }, "JWT_SECRETS lists the same secret twice"},
+		{"JWT_SECRETS without encryption key", func(c *Config) { c.JWTSecrets, c.EncryptionKey = []string{c.JWTSecret}, "" }, "JWT_SECRETS is set without ENCRYPTION_KEY"},
+		{"no encryption key", func(c *Config) { c.EncryptionKey = "" }, "ENCRYPTION_KEY is not set"},
+		{"apps without TLS", func(c *Config) { c.PublicScheme = "http" }, "PUBLIC_SCHEME is http: deployed apps on apps.example.com are served without TLS"},
+		{"OIDC groups without issuer", func(c *Config) { c.OIDCAdminGroups = []string{"platform-admins"} }, "OIDC_CLIENT_ID and OIDC_ADMIN_GROUPS have no effect"},
+		{"Vault token without address", func(c *Config) { c.VaultToken = "hvs.0123" }, "VAULT_TOKEN and VAULT_ROLE_ID have no effect without VAULT_ADDR"},
+		{"S3 endpoint unused", func(c *Config) { c.BackupS3Endpoint = "https://s3.example.com" }, "BACKUP_S3_ENDPOINT is set but none of"},
+		{"log sink URL without type", func(c *Config) { c.LogSinkURL = "https://loki.example.com" }, "LOG_SINK_URL has no effect without LOG_SINK_TYPE"},
+		{"registry user without registry", func(c *Config) { c.RegistryUser, c.RegistryPassword = "deploy", "secret" }, "REGISTRY_USER has no effect without REGISTRY_URL"},
+		{"strict revalidation without restrictions", func(c *Config) { c.StrictRevalidate = true }, "STRICT_REVALIDATE has no effect"},
+		{"orphans deleted when recent", func(c *Config) { c.DriftAutoDelete, c.DriftOrphanMinAge = true, time.Minute }, "DRIFT_AUTO_DELETE with DRIFT_ORPHAN_MIN_AGE 1m0s"},
+		{"cost retention below a day", func(c *Config) { c.CostRetention = time.Hour }, "COST_RETENTION 1h0m0s keeps less than a day"},
+		{"image warning above maximum", func(c *Config) { c.ImageSizeWarnMB, c.ImageSizeMaxMB = 2048, 1024 }, "IMAGE_SIZE_WARN_MB (2048) is above IMAGE_SIZE_MAX_MB (1024)"},
+		{"unanchored version pattern", func(c *Config) { c.RuntimeVersionPattern = `\d+` }, "isn't anchored with ^ and $"},
+		{"strict supply chain without SBOM", func(c *Config) { c.SupplyChainStrict, c.SBOMGenerator = true, "" }, "SUPPLY_CHAIN_STRICT is on without SBOM_GENERATOR"},
+		{"many build slots", func(c *Config) { c.BuildConcurrency = 32 }, "BUILD_CONCURRENCY is 32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := productionConfig(t)
+			tt.change(cfg)
+			v := cfg.Validate()
+			found := false
+			for _, warning := range v.Warnings {
+				found = found || strings.Contains(warning, tt.warning)
+			}
+			if len(v.Errors) > 0 || !found {
+				t.Errorf("errors %q, warnings %q, want warning %q", v.Errors, v.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+// Known defaults of secrets are only warned about in local development
+func TestValidateLocalDevelopment(t *testing.T) {
+	for key, value := range map[string]string{
+		"GITHUB_CLIENT_ID": "Iv1.0123456789abcdef", "GITHUB_CLIENT_SECRET": "0123456789abcdef",
+		"BASE_DOMAIN": "", "JWT_SECRET": "", "JWT_SECRETS": "", "WEBHOOK_SECRET": "", "ENCRYPTION_KEY": "",
+		"PUBLIC_SCHEME": "", "PUBLIC_URL": "", "BASE_URL": "", "GITHUB_CALLBACK_URL": "",
+		"KUBERNETES_CLUSTERS": "", "KUBERNETES_CLUSTER_DOMAINS": "", "DEFAULT_CLUSTER": "", "INSTANCE_ID": "api-0",
+	} {
+		t.Setenv(key, value)
+	}
+	cfg := Load()
+	if !cfg.Local() {
+		t.Fatalf("%s is not local", cfg.BaseDomain)
+	}
+	v := cfg.Validate()
+	if len(v.Errors) > 0 || v.Err() != nil {
+		t.Errorf("errors %q", v.Errors)
+	}
+	if len(v.Warnings) != 3 {
+		t.Errorf("warnings %q, want the development JWT secret, webhook secret and encryption key", v.Warnings)
+	}
+
+	cfg.BaseDomain = "dev.localhost"
+	if v := cfg.Validate(); len(v.Errors) > 0 {
+		t.Errorf("dev.localhost: errors %q", v.Errors)
+	}
+	cfg.BaseDomain = "apps.example.com"
+	if v := cfg.Validate(); len(v.Errors) != 2 {
+		t.Errorf("apps.example.com: errors %q, want the development JWT and webhook secrets", v.Errors)
+	}
+}
+
+// Every problem is reported at once, in one block
+func TestValidateReportsEverything(t *testing.T) {
+	cfg := productionConfig(t)
+	if err := cfg.Validate().Err(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.JWTSecret = ""
+	cfg.BaseDomain = "https://apps.example.com"
+	cfg.RegistryURL = "Registry.example.com"
+	cfg.BuildConcurrency = 0
+
+	v := cfg.Validate()
+	if len(v.Errors) != 4 {
+		t.Fatalf("errors %q", v.Errors)
+	}
+	err := v.Err().Error()
+	if !strings.HasPrefix(err, "4 configuration problem(s):\n  - JWT_SECRET is required") || strings.Count(err, "\n  - ") != 4 {
+		t.Errorf("got:\n%s", err)
+	}
+	for _, setting := range []string{"BASE_DOMAIN", "REGISTRY_URL", "BUILD_CONCURRENCY"} {
+		if !strings.Contains(err, setting) {
+			t.Errorf("%s not reported:\n%s", setting, err)
+		}
+	}
+}
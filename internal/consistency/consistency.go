@@ -0,0 +1,120 @@
+// Package consistency checks invariants spanning the platform's tables:
+// rows whose parent is gone, statuses contradicting each other. Each
+// invariant reports the rows breaking it; a few have a fix safe to apply
+// unattended.
+package consistency
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxIDs caps the row IDs a violation lists; Count has them all
+const maxIDs = 1000
+
+// Invariant is a property every row of Table must have
+type Invariant struct {
+	Name        string
+	Table       string
+	Description string
+	// Violations returns the IDs of the rows of Table breaking the invariant
+	Violations func(db *gorm.DB) ([]uint, error)
+	// Fix repairs the rows ids within a transaction and returns what it
+	// changed; nil for invariants whose violations are only reported
+	Fix func(tx *gorm.DB, ids []uint) ([]Change, error)
+}
+
+// Violation is an invariant some rows break
+type Violation struct {
+	Invariant   string `json:"invariant"`
+	Table       string `json:"table"`
+	Description string `json:"description"`
+	Count       int    `json:"count"`
+	IDs         []uint `json:"ids"` // The first maxIDs
+	Fixable     bool   `json:"fixable"`
+}
+
+// Report is the outcome of a check
+type Report struct {
+	CheckedAt  time.Time   `json:"checked_at"`
+	Invariants int         `json:"invariants"` // How many were checked
+	Violations []Violation `json:"violations"` // Invariants broken, in the order of Invariants
+}
+
+// Change is a column of a row a fix changed, or would change on a dry run
+type Change struct {
+	Invariant string `json:"invariant"`
+	Table     string `json:"table"`
+	ID        uint   `json:"id"`
+	Column    string `json:"column"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
+// Check runs every invariant against db
+func Check(db *gorm.DB) (*Report, error) {
+	report := &Report{CheckedAt: time.Now(), Invariants: len(Invariants), Violations: []Violation{}}
+	for _, invariant := range Invariants {
+		ids, err := invariant.Violations(db)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", invariant.Name, err)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		violation := Violation{
+			Invariant:   invariant.Name,
+			Table:       invariant.Table,
+			Description: invariant.Description,
+			Count:       len(ids),
+			IDs:         ids,
+			Fixable:     invariant.Fix != nil,
+		}
+		if len(ids) > maxIDs {
+			violation.IDs = ids[:maxIDs]
+		}
+		report.Violations = append(report.Violations, violation)
+	}
+	return report, nil
+}
+
+// Fix applies the fix of every fixable invariant, each in a transaction of
+// its own that finds the violations again, and returns what changed. A dry
+// run rolls every transaction back, returning what would have changed.
+func Fix(db *gorm.DB, dryRun bool) ([]Change, error) {
+	changes := []Change{}
+	for _, invariant := range Invariants {
+		if invariant.Fix == nil {
+			continue
+		}
+		var fixed []Change
+		err := db.Transaction(func(tx *gorm.DB) error {
+			ids, err := invariant.Violations(tx)
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+			if fixed, err = invariant.Fix(tx, ids); err != nil {
+				return err
+			}
+			if dryRun {
+				return errDryRun
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errDryRun) {
+			return changes, fmt.Errorf("%s: %w", invariant.Name, err)
+		}
+		for i := range fixed {
+			fixed[i].Invariant = invariant.Name
+			fixed[i].Table = invariant.Table
+		}
+		changes = append(changes, fixed...)
+	}
+	return changes, nil
+}
@@ -0,0 +1,268 @@
+package consistency
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// healthy seeds rows breaking no invariant: a project with a live
+// deployment, its finished build, env var and hostname
+func healthy(t *testing.T) *models.Project {
+	t.Helper()
+	project := &models.Project{Name: "app", Slug: "app"}
+	create(t, project)
+	deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusDeployed}
+	create(t, deployment)
+	create(t, &models.Build{DeploymentID: deployment.ID, Status: "success"})
+	create(t, &models.Environment{ProjectID: project.ID, Key: "PORT", Value: "8080"})
+	create(t, &models.Hostname{Hostname: "app.example.com", ProjectID: project.ID, DeploymentID: deployment.ID, IsActive: true})
+	// A deployment building with its build running, recently
+	building := &models.Deployment{ProjectID: project.ID, Status: models.StatusBuilding}
+	create(t, building)
+	create(t, &models.Build{DeploymentID: building.ID, Status: "building"})
+	return project
+}
+
+func create(t *testing.T, value interface{}) {
+	t.Helper()
+	if err := database.DB.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// missing is an ID no row has
+const missing = 999
+
+// Each invariant is seeded with rows breaking it, which the check reports
+// and, for the fixable ones, the fix repairs
+func TestInvariants(t *testing.T) {
+	tests := []struct {
+		invariant string
+		// seed creates the rows breaking the invariant and returns their IDs
+		seed func(t *testing.T, project *models.Project) []uint
+		// fixed checks the rows after the fix, nil for invariants only reported
+		fixed func(t *testing.T, ids []uint)
+	}{
+		{
+			invariant: "deployment-without-project",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				deployment := &models.Deployment{ProjectID: missing, Status: models.StatusDeployed}
+				create(t, deployment)
+				return []uint{deployment.ID}
+			},
+		},
+		{
+			invariant: "build-without-deployment",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				build := &models.Build{DeploymentID: missing, Status: "success"}
+				create(t, build)
+				return []uint{build.ID}
+			},
+		},
+		{
+			invariant: "environment-without-project",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				env := &models.Environment{ProjectID: missing, Key: "SECRET", Value: "x"}
+				create(t, env)
+				return []uint{env.ID}
+			},
+		},
+		{
+			invariant: "hostname-without-project",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				hostname := &models.Hostname{Hostname: "gone.example.com", ProjectID: missing, IsActive: true}
+				create(t, hostname)
+				// Inactive ones are fine
+				create(t, &models.Hostname{Hostname: "old.example.com", ProjectID: missing})
+				database.DB.Model(&models.Hostname{}).Where("hostname = ?", "old.example.com").Update("is_active", false)
+				return []uint{hostname.ID}
+			},
+			fixed: inactive,
+		},
+		{
+			invariant: "hostname-without-deployment",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				hostname := &models.Hostname{Hostname: "dangling.example.com", ProjectID: project.ID, DeploymentID: missing, IsActive: true}
+				create(t, hostname)
+				// Reserved hostnames, not deployed yet, are fine
+				create(t, &models.Hostname{Hostname: "reserved.example.com", ProjectID: project.ID, IsActive: true})
+				return []uint{hostname.ID}
+			},
+			fixed: inactive,
+		},
+		{
+			invariant: "hostname-on-failed-deployment",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusFailed}
+				create(t, deployment)
+				hostname := &models.Hostname{Hostname: "failed.example.com", ProjectID: project.ID, DeploymentID: deployment.ID, IsActive: true}
+				create(t, hostname)
+				return []uint{hostname.ID}
+			},
+		},
+		{
+			invariant: "build-running-for-finished-deployment",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusCancelled}
+				create(t, deployment)
+				build := &models.Build{DeploymentID: deployment.ID, Status: "building"}
+				create(t, build)
+				superseded := &models.Deployment{ProjectID: project.ID, Status: models.StatusSuperseded}
+				create(t, superseded)
+				other := &models.Build{DeploymentID: superseded.ID, Status: "building"}
+				create(t, other)
+				return []uint{build.ID, other.ID}
+			},
+			fixed: func(t *testing.T, ids []uint) {
+				var builds []models.Build
+				database.DB.Find(&builds, ids)
+				for _, build := range builds {
+					if build.Status != "failed" || build.CompletedAt == nil {
+						t.Errorf("build %d is %s, completed %v", build.ID, build.Status, build.CompletedAt)
+					}
+				}
+			},
+		},
+		{
+			invariant: "deployment-building-without-build",
+			seed: func(t *testing.T, project *models.Project) []uint {
+				deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusBuilding}
+				create(t, deployment)
+				create(t, &models.Build{DeploymentID: deployment.ID, Status: "failed"})
+				database.DB.Model(deployment).UpdateColumn("updated_at", time.Now().Add(-2*stuckAfter))
+				return []uint{deployment.ID}
+			},
+			fixed: func(t *testing.T, ids []uint) {
+				var deployment models.Deployment
+				database.DB.First(&deployment, ids[0])
+				if deployment.Status != models.StatusFailed {
+					t.Errorf("deployment is %s", deployment.Status)
+				}
+				var event models.DeploymentEvent
+				if err := database.DB.Where("deployment_id = ?", ids[0]).Last(&event).Error; err != nil || event.Reason != fixedDeploymentReason {
+					t.Errorf("event %+v, %v", event, err)
+				}
+			},
+		},
+	}
+
+	tested := map[string]bool{}
+	for _, tt := range tests {
+		tested[tt.invariant] = true
+		t.Run(tt.invariant, func(t *testing.T) {
+			testutil.DB(t)
+			project := healthy(t)
+			ids := tt.seed(t, project)
+
+			report := check(t)
+			if len(report.Violations) != 1 {
+				t.Fatalf("got violations %+v", report.Violations)
+			}
+			violation := report.Violations[0]
+			if violation.Invariant != tt.invariant || violation.Count != len(ids) || !reflect.DeepEqual(violation.IDs, ids) {
+				t.Errorf("got %s of %v, want %s of %v", violation.Invariant, violation.IDs, tt.invariant, ids)
+			}
+			if violation.Fixable != (tt.fixed != nil) {
+				t.Errorf("fixable: %v", violation.Fixable)
+			}
+
+			// A dry run changes nothing
+			dryRun, err := Fix(database.DB, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fixed == nil && len(dryRun) != 0 {
+				t.Errorf("changes for an invariant without a fix: %+v", dryRun)
+			}
+			if len(check(t).Violations) != 1 {
+				t.Fatal("the dry run changed rows")
+			}
+
+			changes, err := Fix(database.DB, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changes, dryRun) {
+				t.Errorf("fixed %+v, the dry run said %+v", changes, dryRun)
+			}
+			if tt.fixed == nil {
+				if len(check(t).Violations) != 1 {
+					t.Error("a violation without a fix went away")
+				}
+				return
+			}
+			if len(changes) != len(ids) {
+				t.Errorf("changes %+v", changes)
+			}
+			for i, change := range changes {
+				if change.Invariant != tt.invariant || change.ID != ids[i] || change.From == change.To {
+					t.Errorf("change %+v", change)
+				}
+			}
+			tt.fixed(t, ids)
+			if report := check(t); len(report.Violations) != 0 {
+				t.Errorf("after the fix: %+v", report.Violations)
+			}
+		})
+	}
+
+	// New invariants come with their test
+	for _, invariant := range Invariants {
+		if !tested[invariant.Name] {
+			t.Errorf("invariant %s isn't seeded by this test", invariant.Name)
+		}
+	}
+}
+
+func TestHealthyDatabase(t *testing.T) {
+	testutil.DB(t)
+	healthy(t)
+	report := check(t)
+	if report.Invariants != len(Invariants) || len(report.Violations) != 0 {
+		t.Errorf("got %+v", report)
+	}
+	if changes, err := Fix(database.DB, false); err != nil || len(changes) != 0 {
+		t.Errorf("Fix = %+v, %v", changes, err)
+	}
+}
+
+func TestViolationIDsCapped(t *testing.T) {
+	testutil.DB(t)
+	envs := make([]models.Environment, maxIDs+5)
+	for i := range envs {
+		envs[i] = models.Environment{ProjectID: missing, Key: "K"}
+	}
+	database.DB.Session(&gorm.Session{CreateBatchSize: 500}).Create(&envs)
+
+	violations := check(t).Violations
+	if len(violations) != 1 || violations[0].Count != maxIDs+5 || len(violations[0].IDs) != maxIDs {
+		t.Errorf("got %d violations, the first of %d rows listing %d", len(violations), violations[0].Count, len(violations[0].IDs))
+	}
+}
+
+func check(t *testing.T) *Report {
+	t.Helper()
+	report, err := Check(database.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// inactive checks the hostnames ids were deactivated
+func inactive(t *testing.T, ids []uint) {
+	t.Helper()
+	var hostnames []models.Hostname
+	database.DB.Find(&hostnames, ids)
+	for _, hostname := range hostnames {
+		if hostname.IsActive {
+			t.Errorf("hostname %s still active", hostname.Hostname)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package consistency
+
+import (
+	"deploy-platform/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// stuckAfter is how long a deployment may stay building without a running
+// build before it counts as stuck: the build row is created just after
+// the deployment moves to building
+const stuckAfter = time.Hour
+
+// fixedDeploymentReason is recorded with the status of deployments the
+// check failed
+const fixedDeploymentReason = "failed by the consistency check: no build was running for it"
+
+// Invariants lists everything Check verifies. Features adding a table or a
+// status that must agree with another add their invariant here; fixes are
+// kept to changes that can't lose data: deactivating, marking failed.
+var Invariants = []Invariant{
+	{
+		Name:        "deployment-without-project",
+		Table:       "deployments",
+		Description: "Deployments of a project that no longer exists",
+		Violations:  orphans("deployments", "project_id", "projects"),
+	},
+	{
+		Name:        "build-without-deployment",
+		Table:       "builds",
+		Description: "Builds of a deployment that no longer exists",
+		Violations:  orphans("builds", "deployment_id", "deployments"),
+	},
+	{
+		Name:        "environment-without-project",
+		Table:       "environments",
+		Description: "Env vars of a project that no longer exists",
+		Violations:  orphans("environments", "project_id", "projects"),
+	},
+	{
+		Name:        "hostname-without-project",
+		Table:       "hostnames",
+		Description: "Active hostnames of a project that no longer exists",
+		Violations: func(db *gorm.DB) ([]uint, error) {
+			var ids []uint
+			err := activeHostnames(db).Joins("LEFT JOIN projects AS p ON p.id = t.project_id").
+				Where("p.id IS NULL").Pluck("t.id", &ids).Error
+			return ids, err
+		},
+		Fix: deactivateHostnames,
+	},
+	{
+		Name:        "hostname-without-deployment",
+		Table:       "hostnames",
+		Description: "Active hostnames pointing at a deployment that no longer exists",
+		Violations: func(db *gorm.DB) ([]uint, error) {
+			var ids []uint
+			err := activeHostnames(db).Joins("LEFT JOIN deployments AS d ON d.id = t.deployment_id").
+				Where("t.deployment_id <> 0 AND d.id IS NULL").Pluck("t.id", &ids).Error
+			return ids, err
+		},
+		Fix: deactivateHostnames,
+	},
+	{
+		Name:        "hostname-on-failed-deployment",
+		Table:       "hostnames",
+		Description: "Active hostnames pointing at a deployment that never went live; reported only, the hostname may still serve an older deployment",
+		Violations: func(db *gorm.DB) ([]uint, error) {
+			var ids []uint
+			err := activeHostnames(db).Joins("JOIN deployments AS d ON d.id = t.deployment_id").
+				Where("d.status IN ?", []models.DeploymentStatus{models.StatusFailed, models.StatusCancelled, models.StatusRejected, models.StatusSkipped}).
+				Pluck("t.id", &ids).Error
+			return ids, err
+		},
+	},
+	{
+		Name:        "build-running-for-finished-deployment",
+		Table:       "builds",
+		Description: "Builds still building while their deployment finished or is gone",
+		Violations: func(db *gorm.DB) ([]uint, error) {
+			var ids []uint
+			err := db.Table("builds AS t").Joins("LEFT JOIN deployments AS d ON d.id = t.deployment_id").
+				Where("t.status = ?", "building").
+				Where("d.id IS NULL OR d.status IN ?", finished).
+				Order("t.id").Pluck("t.id", &ids).Error
+			return ids, err
+		},
+		Fix: func(tx *gorm.DB, ids []uint) ([]Change, error) {
+			if err := tx.Model(&models.Build{}).Where("id IN ?", ids).
+				Updates(map[string]interface{}{"status": "failed", "completed_at": time.Now()}).Error; err != nil {
+				return nil, err
+			}
+			return changed(ids, "status", "building", "failed"), nil
+		},
+	},
+	{
+		Name:        "deployment-building-without-build",
+		Table:       "deployments",
+		Description: "Deployments building for over an hour without a build running",
+		Violations: func(db *gorm.DB) ([]uint, error) {
+			var ids []uint
+			err := db.Table("deployments AS t").
+				Where("t.status = ? AND t.updated_at < ?", models.StatusBuilding, time.Now().Add(-stuckAfter)).
+				Where("NOT EXISTS (SELECT 1 FROM builds AS b WHERE b.deployment_id = t.id AND b.status = ?)", "building").
+				Order("t.id").Pluck("t.id", &ids).Error
+			return ids, err
+		},
+		Fix: func(tx *gorm.DB, ids []uint) ([]Change, error) {
+			for _, id := range ids {
+				if err := models.SetDeploymentStatusFrom(tx, id, models.StatusBuilding, models.StatusFailed, fixedDeploymentReason); err != nil {
+					return nil, err
+				}
+			}
+			return changed(ids, "status", string(models.StatusBuilding), string(models.StatusFailed)), nil
+		},
+	},
+}
+
+// orphans finds the rows of table whose column names a row of parent that
+// doesn't exist
+func orphans(table, column, parent string) func(db *gorm.DB) ([]uint, error) {
+	return func(db *gorm.DB) ([]uint, error) {
+		var ids []uint
+		err := db.Table(table+" AS t").Joins("LEFT JOIN "+parent+" AS p ON p.id = t."+column).
+			Where("p.id IS NULL").Order("t.id").Pluck("t.id", &ids).Error
+		return ids, err
+	}
+}
+
+// activeHostnames selects the active hostnames, as t
+func activeHostnames(db *gorm.DB) *gorm.DB {
+	return db.Table("hostnames AS t").Where("t.is_active = ?", true).Order("t.id")
+}
+
+func deactivateHostnames(tx *gorm.DB, ids []uint) ([]Change, error) {
+	if err := tx.Model(&models.Hostname{}).Where("id IN ?", ids).Update("is_active", false).Error; err != nil {
+		return nil, err
+	}
+	return changed(ids, "is_active", "true", "false"), nil
+}
+
+// changed lists the same change to column of every row of ids
+func changed(ids []uint, column, from, to string) []Change {
+	changes := make([]Change, 0, len(ids))
+	for _, id := range ids {
+		changes = append(changes, Change{ID: id, Column: column, From: from, To: to})
+	}
+	return changes
+}
+
+// finished lists the statuses deployments end in
+var finished = []models.DeploymentStatus{
+	models.StatusDeployed, models.StatusFailed, models.StatusCancelled,
+	models.StatusSkipped, models.StatusSuperseded, models.StatusRejected,
+}
@@ -0,0 +1,142 @@
+// Package cost estimates what projects cost to run, pricing the CPU and
+// memory their pods request of the clusters. The requests are sampled
+// every COST_SAMPLE_INTERVAL; periods no sample was taken for, while the
+// platform or a cluster was down, cost nothing.
+package cost
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"math"
+	"time"
+)
+
+// Environments usage is broken down by, in display order
+var Environments = []string{kubernetes.EnvironmentProduction, kubernetes.EnvironmentBranch, kubernetes.EnvironmentPreview}
+
+// Rates price the resources requested
+type Rates struct {
+	CPUHour      float64 `json:"cpu_hour"`       // A vCPU for an hour
+	MemoryGBHour float64 `json:"memory_gb_hour"` // A GiB of memory for an hour
+	Currency     string  `json:"currency"`
+}
+
+// RatesOf returns the COST_* rates of cfg
+func RatesOf(cfg *config.Config) Rates {
+	return Rates{CPUHour: cfg.CostCPUHour, MemoryGBHour: cfg.CostMemoryGBHour, Currency: cfg.CostCurrency}
+}
+
+// Usage is resources requested over time: each sample adds what its pods
+// requested times the length of its period, so scaling changes how much a
+// period adds and scaled-to-zero periods add nothing
+type Usage struct {
+	CPUMilliSeconds  int64 // Millicores requested, times the seconds they were
+	MemoryMiBSeconds int64
+}
+
+// Compute is compute used and its estimated cost, rounded to cents
+type Compute struct {
+	CPUCoreHours  float64 `json:"cpu_core_hours"`
+	MemoryGBHours float64 `json:"memory_gb_hours"` // GiB-hours
+	CPUCost       float64 `json:"cpu_cost"`
+	MemoryCost    float64 `json:"memory_cost"`
+	Total         float64 `json:"total"`
+}
+
+// Price converts usage to core-hours and GiB-hours and prices them
+func (r Rates) Price(u Usage) Compute {
+	coreHours := float64(u.CPUMilliSeconds) / 1000 / 3600
+	gbHours := float64(u.MemoryMiBSeconds) / 1024 / 3600
+	cpuCost := coreHours * r.CPUHour
+	memoryCost := gbHours * r.MemoryGBHour
+	return Compute{
+		CPUCoreHours:  round(coreHours),
+		MemoryGBHours: round(gbHours),
+		CPUCost:       round(cpuCost),
+		MemoryCost:    round(memoryCost),
+		Total:         round(cpuCost + memoryCost),
+	}
+}
+
+func round(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// Estimate is the estimated spend of a project over a window, in total and
+// by environment: previews and branches are attributed apart from production
+type Estimate struct {
+	ProjectID uint      `json:"project_id"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Currency  string    `json:"currency"`
+	Compute
+	Environments map[string]Compute `json:"environments"`
+
+	usage Usage
+	byEnv map[string]Usage
+}
+
+// Estimates estimates the spend of projectIDs (nil = every project) over
+// [from, to), keyed by project ID. Projects without samples in the window
+// get a zero estimate when asked for, none otherwise.
+func Estimates(ctx context.Context, rates Rates, projectIDs []uint, from, to time.Time) (map[uint]*Estimate, error) {
+	// Summed in the database: a project has a sample per period per resources
+	var rows []struct {
+		ProjectID        uint
+		Environment      string
+		CPUMilliSeconds  int64
+		MemoryMiBSeconds int64 `gorm:"column:memory_mib_seconds"`
+	}
+	query := database.DB.WithContext(ctx).Model(&models.UsageSample{}).
+		Select("project_id, environment, SUM(cpu_millicores * seconds) AS cpu_milli_seconds, SUM(memory_mib * seconds) AS memory_mib_seconds").
+		Where("period_start >= ? AND period_start < ?", from, to).
+		Group("project_id, environment")
+	if projectIDs != nil {
+		query = query.Where("project_id IN ?", projectIDs)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	estimates := make(map[uint]*Estimate)
+	get := func(id uint) *Estimate {
+		if estimates[id] == nil {
+			estimates[id] = &Estimate{ProjectID: id, From: from, To: to, Currency: rates.Currency, byEnv: make(map[string]Usage)}
+		}
+		return estimates[id]
+	}
+	for _, id := range projectIDs {
+		get(id)
+	}
+	for _, row := range rows {
+		estimate := get(row.ProjectID)
+		estimate.usage.CPUMilliSeconds += row.CPUMilliSeconds
+		estimate.usage.MemoryMiBSeconds += row.MemoryMiBSeconds
+		env := estimate.byEnv[row.Environment]
+		env.CPUMilliSeconds += row.CPUMilliSeconds
+		env.MemoryMiBSeconds += row.MemoryMiBSeconds
+		estimate.byEnv[row.Environment] = env
+	}
+	for _, estimate := range estimates {
+		estimate.Compute = rates.Price(estimate.usage)
+		estimate.Environments = make(map[string]Compute, len(Environments))
+		for _, env := range Environments {
+			estimate.Environments[env] = rates.Price(estimate.byEnv[env])
+		}
+	}
+	return estimates, nil
+}
+
+// Rollup prices the usage of several estimates together, e.g. the projects
+// of an owner; summing their rounded costs would drift
+func Rollup(rates Rates, estimates []*Estimate) Compute {
+	var total Usage
+	for _, estimate := range estimates {
+		total.CPUMilliSeconds += estimate.usage.CPUMilliSeconds
+		total.MemoryMiBSeconds += estimate.usage.MemoryMiBSeconds
+	}
+	return rates.Price(total)
+}
@@ -0,0 +1,132 @@
+package cost
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const interval = 10 * time.Minute
+
+var rates = Rates{CPUHour: 0.08, MemoryGBHour: 0.04, Currency: "USD"}
+
+// record stores samples the way Sample does, a period of a workload being
+// recorded once whichever replica samples it
+func record(t *testing.T, samples ...models.UsageSample) {
+	t.Helper()
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&samples).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// running is a sample of the resources name of project, replicas pods each
+// requesting cpu millicores and memory MiB during the period at start
+func running(projectID uint, name, environment string, start time.Time, replicas int, cpu, memory int64) models.UsageSample {
+	return models.UsageSample{
+		ProjectID: projectID, Cluster: "default", Name: name, Environment: environment,
+		PeriodStart: start, Seconds: int(interval.Seconds()), Replicas: replicas,
+		CPUMillicores: int64(replicas) * cpu, MemoryMiB: int64(replicas) * memory,
+	}
+}
+
+func TestPrice(t *testing.T) {
+	// 2 cores and 4 GiB for 90 minutes
+	usage := Usage{CPUMilliSeconds: 2000 * 5400, MemoryMiBSeconds: 4096 * 5400}
+	want := Compute{CPUCoreHours: 3, MemoryGBHours: 6, CPUCost: 0.24, MemoryCost: 0.24, Total: 0.48}
+	if got := rates.Price(usage); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := rates.Price(Usage{}); got != (Compute{}) {
+		t.Errorf("nothing used: %+v", got)
+	}
+}
+
+// Each period adds what was requested during it: scaling changes what a
+// period adds, and periods without a sample add nothing
+func TestEstimates(t *testing.T) {
+	testutil.DB(t)
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	period := func(i int) time.Time { return from.Add(time.Duration(i) * interval) }
+	production, preview := kubernetes.EnvironmentProduction, kubernetes.EnvironmentPreview
+
+	for i := 0; i < 3; i++ {
+		record(t, running(3, "project-3", production, period(i), 2, 500, 512))
+	}
+	// Scaled up
+	for i := 3; i < 5; i++ {
+		record(t, running(3, "project-3", production, period(i), 4, 500, 512))
+	}
+	// Periods 5 and 6: the cluster or the platform was down. Period 7:
+	// scaled to zero, which Sample doesn't record. Then one pod.
+	record(t, running(3, "project-3", production, period(8), 1, 500, 512))
+	// Another replica sampling a period already recorded counts it once
+	record(t, running(3, "project-3", production, period(3), 4, 500, 512))
+	for i := 0; i < 6; i++ {
+		record(t, running(3, "project-3-pr-12", preview, period(i), 1, 250, 256))
+	}
+	// Outside the window, and another project
+	record(t,
+		running(3, "project-3", production, period(-1), 10, 1000, 1024),
+		running(3, "project-3", production, period(144), 10, 1000, 1024),
+		running(4, "project-4", production, period(0), 1, 300, 0),
+	)
+
+	to := from.Add(24 * time.Hour)
+	estimates, err := Estimates(context.Background(), rates, []uint{3, 5}, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(estimates) != 2 || estimates[5] == nil || estimates[5].Total != 0 || estimates[5].Environments[production] != (Compute{}) {
+		t.Fatalf("estimates %+v", estimates)
+	}
+	got := estimates[3]
+	// Production: 3 periods of 1 core and 1 GiB, 2 of 2, 1 of half, 10
+	// minutes each = 1.25 core-hours and GiB-hours
+	want := map[string]Compute{
+		production:                   {CPUCoreHours: 1.25, MemoryGBHours: 1.25, CPUCost: 0.1, MemoryCost: 0.05, Total: 0.15},
+		preview:                      {CPUCoreHours: 0.25, MemoryGBHours: 0.25, CPUCost: 0.02, MemoryCost: 0.01, Total: 0.03},
+		kubernetes.EnvironmentBranch: {},
+	}
+	if !reflect.DeepEqual(got.Environments, want) {
+		t.Errorf("environments %+v, want %+v", got.Environments, want)
+	}
+	if total := (Compute{CPUCoreHours: 1.5, MemoryGBHours: 1.5, CPUCost: 0.12, MemoryCost: 0.06, Total: 0.18}); got.Compute != total || got.Currency != "USD" || !got.From.Equal(from) || !got.To.Equal(to) {
+		t.Errorf("estimate %+v", got)
+	}
+
+	// Every project with samples in the window
+	all, err := Estimates(context.Background(), rates, nil, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[3].Compute != got.Compute || all[4].CPUCoreHours != 0.05 {
+		t.Errorf("all projects: %+v", all)
+	}
+}
+
+// Rolled up usage is priced as a whole: summing rounded costs would drift
+func TestRollup(t *testing.T) {
+	// 0.05 core-hours each, 0.004 rounding to nothing
+	estimate := func() *Estimate {
+		e := &Estimate{usage: Usage{CPUMilliSeconds: 300 * 600}}
+		e.Compute = rates.Price(e.usage)
+		return e
+	}
+	estimates := []*Estimate{estimate(), estimate()}
+	if estimates[0].Total != 0 {
+		t.Fatalf("one project costs %v", estimates[0].Total)
+	}
+	if got := Rollup(rates, estimates); got.CPUCoreHours != 0.1 || got.Total != 0.01 {
+		t.Errorf("rollup %+v", got)
+	}
+	if got := Rollup(rates, nil); got != (Compute{}) {
+		t.Errorf("no projects: %+v", got)
+	}
+}
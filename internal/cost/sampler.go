@@ -0,0 +1,105 @@
+package cost
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const pruneInterval = 24 * time.Hour
+
+// Sampler records what the pods of every project request of the clusters,
+// once per period
+type Sampler struct {
+	clusters  *kubernetes.ClientSet
+	interval  time.Duration
+	retention time.Duration
+}
+
+// NewSampler samples clusters every COST_SAMPLE_INTERVAL
+func NewSampler(cfg *config.Config, clusters *kubernetes.ClientSet) *Sampler {
+	return &Sampler{clusters: clusters, interval: cfg.CostSampleInterval, retention: cfg.CostRetention}
+}
+
+// Jobs sample the clusters at the start of every period and delete samples
+// older than the retention daily, on the leader. Without an interval
+// nothing is sampled.
+func (s *Sampler) Jobs() []background.Job {
+	if s.interval <= 0 {
+		return nil
+	}
+	log.Printf("✅ Resource requests sampled for cost estimates every %s", s.interval)
+	return []background.Job{{
+		Name:      "cost-sample",
+		Interval:  s.interval,
+		Singleton: true,
+		Run: func(ctx context.Context) error {
+			// Periods start at multiples of the interval, as job intervals do
+			s.Sample(ctx, time.Now().Truncate(s.interval))
+			return nil
+		},
+	}, {
+		Name:      "cost-prune",
+		Interval:  pruneInterval,
+		Singleton: true,
+		Manual:    true,
+		Run:       s.prune,
+	}}
+}
+
+// Sample records the requests of the running pods of every connected
+// cluster for the period starting at period. A period already sampled,
+// by another replica or before a restart, is left as it is; a cluster
+// that can't be listed is skipped, its period costing nothing.
+func (s *Sampler) Sample(ctx context.Context, period time.Time) {
+	for _, cluster := range s.clusters.Names() {
+		usages, err := s.clusters.Client(cluster).ListWorkloadUsage(ctx)
+		if err != nil {
+			log.Printf("⚠️  Failed to sample resource requests of cluster %s: %v", cluster, err)
+			continue
+		}
+		samples := make([]models.UsageSample, 0, len(usages))
+		for _, usage := range usages {
+			if usage.Replicas == 0 {
+				continue // Scaled to zero costs nothing
+			}
+			samples = append(samples, models.UsageSample{
+				ProjectID:     usage.ProjectID,
+				Cluster:       cluster,
+				Name:          usage.Name,
+				Environment:   usage.Environment,
+				PeriodStart:   period,
+				Seconds:       int(s.interval.Seconds()),
+				Replicas:      usage.Replicas,
+				CPUMillicores: usage.CPUMillicores,
+				MemoryMiB:     usage.MemoryMiB,
+			})
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		if err := database.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&samples, 200).Error; err != nil {
+			log.Printf("⚠️  Failed to record resource requests of cluster %s: %v", cluster, err)
+		}
+	}
+}
+
+// prune deletes the samples older than the retention
+func (s *Sampler) prune(ctx context.Context) error {
+	result := database.DB.WithContext(ctx).Where("period_start < ?", time.Now().Add(-s.retention)).Delete(&models.UsageSample{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("🧹 Pruned %d usage samples older than %s", result.RowsAffected, s.retention)
+	}
+	background.Processed(ctx, int(result.RowsAffected))
+	return nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// searchIndexes are the trigram indexes serving the case-insensitive
+// substring matches of search on Postgres, one per column searched anywhere
+// in its values (an OR over the columns only uses indexes if each has one)
+var searchIndexes = map[string]string{
+	"idx_projects_name_trgm":                "projects (LOWER(name) gin_trgm_ops)",
+	"idx_projects_slug_trgm":                "projects (LOWER(slug) gin_trgm_ops)",
+	"idx_projects_repo_url_trgm":            "projects (LOWER(repo_url) gin_trgm_ops)",
+	"idx_deployments_commit_msg_trgm":       "deployments (LOWER(commit_msg) gin_trgm_ops)",
+	"idx_deployments_branch_trgm":           "deployments (LOWER(branch) gin_trgm_ops)",
+	"idx_deployments_failure_category_trgm": "deployments (LOWER(failure_category) gin_trgm_ops)",
+	"idx_deployments_failure_detail_trgm":   "deployments (LOWER(failure_detail) gin_trgm_ops)",
+}
+
+// createSearchIndexes adds the trigram indexes of search on Postgres.
+// Without the pg_trgm extension (creating it takes privileges the
+// platform's role may lack) search still works, scanning instead. SQLite
+// has no such indexes and always scans, which its sizes allow.
+func createSearchIndexes(db *gorm.DB) {
+	if db.Dialector.Name() != "postgres" {
+		return
+	}
+	// SHA prefixes need no trigrams: text_pattern_ops lets LIKE 'abc1%' use a
+	// plain index whatever the database's collation
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_deployments_commit_sha_prefix ON deployments (LOWER(commit_sha) text_pattern_ops)").Error; err != nil {
+		log.Printf("⚠️  Failed to create search index idx_deployments_commit_sha_prefix: %v", err)
+	}
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("⚠️  Search runs without trigram indexes: failed to enable pg_trgm: %v", err)
+		return
+	}
+	for name, definition := range searchIndexes {
+		if err := db.Exec("CREATE INDEX IF NOT EXISTS " + name + " ON " + definition).Error; err != nil {
+			log.Printf("⚠️  Failed to create search index %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Package drift compares the objects the platform created in the clusters
+// with the database, both ways: objects no project or deployment accounts
+// for any more are orphans, to report and optionally delete; production
+// deployments the database says are live whose objects are gone are
+// flagged as drifted.
+package drift
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/metrics"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/preview"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// settleTime leaves alone deployments updated this recently: their rollout,
+// or the archiving of their project, may still be under way
+const settleTime = 5 * time.Minute
+
+// Orphan is a platform object no live project or deployment accounts for
+type Orphan struct {
+	Cluster string `json:"cluster"`
+	kubernetes.PlatformObject
+	Reason    string `json:"reason"`
+	Deletable bool   `json:"deletable"` // Old enough to be deleted, see DRIFT_ORPHAN_MIN_AGE
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// Drifted is a production deployment the database says is live whose
+// objects are gone from its cluster
+type Drifted struct {
+	DeploymentID uint     `json:"deployment_id"`
+	ProjectID    uint     `json:"project_id"`
+	Cluster      string   `json:"cluster"`
+	Name         string   `json:"name"`    // Name of its resources
+	Missing      []string `json:"missing"` // Kinds of the objects gone
+}
+
+// Report is the outcome of a sweep
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DryRun     bool      `json:"dry_run"` // Orphans were only listed
+	Orphans    []Orphan  `json:"orphans"`
+	Drifted    []Drifted `json:"drifted"`
+	Errors     []string  `json:"errors,omitempty"` // Clusters or deployments that couldn't be checked
+}
+
+// Reconciler sweeps the clusters for orphans and drifted deployments
+type Reconciler struct {
+	clusters   *kubernetes.ClientSet
+	interval   time.Duration
+	autoDelete bool
+	minAge     time.Duration
+	previews   *preview.Manager // Retires the preview environments of deleted orphans
+
+	sweeping sync.Mutex // One sweep at a time
+	mu       sync.Mutex
+	last     *Report
+}
+
+// NewReconciler configures the sweeps of clusters from the DRIFT_* settings
+func NewReconciler(cfg *config.Config, clusters *kubernetes.ClientSet) *Reconciler {
+	return &Reconciler{
+		clusters:   clusters,
+		interval:   cfg.DriftCheckInterval,
+		autoDelete: cfg.DriftAutoDelete,
+		minAge:     cfg.DriftOrphanMinAge,
+	}
+}
+
+// SetPreviews sets the manager retiring the preview environments of the
+// orphaned resources sweeps delete
+func (r *Reconciler) SetPreviews(previews *preview.Manager) {
+	r.previews = previews
+}
+
+// Job sweeps the clusters every interval, on the leader, deleting orphans
+// when auto-delete is on. Without an interval it isn't run.
+func (r *Reconciler) Job() background.Job {
+	if r.interval > 0 {
+		mode := "reported"
+		if r.autoDelete {
+			mode = fmt.Sprintf("deleted after %s", r.minAge)
+		}
+		log.Printf("✅ Clusters swept for drift every %s, orphans %s", r.interval, mode)
+	}
+	return background.Job{
+		Name:      "drift-sweep",
+		Interval:  r.interval,
+		Singleton: true,
+		Manual:    true,
+		Run: func(ctx context.Context) error {
+			report := r.Sweep(ctx, r.autoDelete)
+			background.Processed(ctx, len(report.Orphans)+len(report.Drifted))
+			if len(report.Orphans) > 0 || len(report.Drifted) > 0 {
+				log.Printf("🧭 Drift sweep: %d orphaned objects, %d drifted deployments", len(report.Orphans), len(report.Drifted))
+			}
+			for _, err := range report.Errors {
+				log.Printf("⚠️  Drift sweep: %s", err)
+			}
+			if len(report.Errors) > 0 {
+				return fmt.Errorf("%d errors, first: %s", len(report.Errors), report.Errors[0])
+			}
+			return nil
+		},
+	}
+}
+
+// Last returns the report of the last sweep, nil before the first
+func (r *Reconciler) Last() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// Sweep compares every connected cluster with the database, flagging
+// drifted deployments and clearing the flag of those whose objects are
+// back. Orphans old enough are deleted when deleteOrphans is set, only
+// listed otherwise.
+func (r *Reconciler) Sweep(ctx context.Context, deleteOrphans bool) *Report {
+	r.sweeping.Lock()
+	defer r.sweeping.Unlock()
+
+	report := &Report{StartedAt: time.Now(), DryRun: !deleteOrphans, Orphans: []Orphan{}, Drifted: []Drifted{}}
+	for _, cluster := range r.clusters.Names() {
+		orphans, err := r.findOrphans(ctx, cluster)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("cluster %s: %v", cluster, err))
+			continue
+		}
+		if deleteOrphans {
+			r.deleteOrphans(ctx, cluster, orphans, report)
+		}
+		report.Orphans = append(report.Orphans, orphans...)
+	}
+	r.checkDeployments(ctx, report)
+	report.FinishedAt = time.Now()
+
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+	return report
+}
+
+// findOrphans lists the objects of cluster that belong to a project that
+// no longer exists, is archived or deploys to another cluster, or that no
+// deployment of their project rolls out to
+func (r *Reconciler) findOrphans(ctx context.Context, cluster string) ([]Orphan, error) {
+	objects, err := r.clusters.Client(cluster).ListPlatformObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, 0, len(objects))
+	for _, object := range objects {
+		ids = append(ids, object.ProjectID)
+	}
+	var projects []models.Project
+	if err := database.DB.WithContext(ctx).Select("id", "cluster", "migrating_from", "archived_at").Where("id IN ?", ids).Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*models.Project, len(projects))
+	for i := range projects {
+		byID[projects[i].ID] = &projects[i]
+	}
+	// Names of the resources each project's deployments roll out to
+	var used []models.Deployment
+	if err := database.DB.WithContext(ctx).Model(&models.Deployment{}).Distinct("project_id", "k8s_deployment_name").
+		Where("project_id IN ? AND k8s_deployment_name <> ''", ids).Find(&used).Error; err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool, len(used))
+	for _, d := range used {
+		inUse[resourceKey(d.ProjectID, d.K8sDeploymentName)] = true
+	}
+
+	var orphans []Orphan
+	for _, object := range objects {
+		reason := ""
+		project := byID[object.ProjectID]
+		switch {
+		case project == nil:
+			reason = fmt.Sprintf("project %d no longer exists", object.ProjectID)
+		case project.Archived():
+			reason = fmt.Sprintf("project %d is archived", object.ProjectID)
+		case cluster != r.clusters.ClusterOf(project) && cluster != project.MigratingFrom:
+			reason = fmt.Sprintf("project %d deploys to cluster %s", object.ProjectID, r.clusters.ClusterOf(project))
+		case !inUse[resourceKey(object.ProjectID, object.App)]:
+			reason = fmt.Sprintf("no deployment of project %d rolls out to %s", object.ProjectID, object.App)
+		default:
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			Cluster:        cluster,
+			PlatformObject: object,
+			Reason:         reason,
+			Deletable:      time.Since(object.CreatedAt) >= r.minAge,
+		})
+	}
+	return orphans, nil
+}
+
+func resourceKey(projectID uint, name string) string {
+	return fmt.Sprintf("%d/%s", projectID, name)
+}
+
+// deleteOrphans deletes the resources of cluster whose objects are all
+// deletable orphans, along with their Secret and NetworkPolicy
+func (r *Reconciler) deleteOrphans(ctx context.Context, cluster string, orphans []Orphan, report *Report) {
+	deletable := make(map[string]bool)
+	projectIDs := make(map[string]uint)
+	for _, orphan := range orphans {
+		projectIDs[orphan.App] = orphan.ProjectID
+		if done, seen := deletable[orphan.App]; seen {
+			deletable[orphan.App] = done && orphan.Deletable
+		} else {
+			deletable[orphan.App] = orphan.Deletable
+		}
+	}
+	client := r.clusters.Client(cluster)
+	for _, app := range sortedKeys(deletable) {
+		if !deletable[app] {
+			continue
+		}
+		if err := client.DeleteDeployment(ctx, app); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("cluster %s: failed to delete orphaned %s: %v", cluster, app, err))
+			deletable[app] = false
+			continue
+		}
+		log.Printf("🧹 Deleted orphaned resources %s from cluster %s", app, cluster)
+		r.previews.Retire(projectIDs[app], app)
+	}
+	for i := range orphans {
+		orphans[i].Deleted = orphans[i].Deletable && deletable[orphans[i].App]
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkDeployments flags the live production deployments whose objects are
+// gone as drifted, and clears the flag of those whose objects are back.
+// Branch and preview resources are left out: deleted branches and closed
+// pull requests tear them down without their deployments changing.
+func (r *Reconciler) checkDeployments(ctx context.Context, report *Report) {
+	latest := database.DB.Model(&models.Deployment{}).Select("MAX(id)").
+		Where("status = ?", models.StatusDeployed).Group("project_id, k8s_deployment_name")
+	var deployments []models.Deployment
+	if err := database.DB.WithContext(ctx).Preload("Project").Where("id IN (?)", latest).
+		Where("updated_at < ?", time.Now().Add(-settleTime)).Find(&deployments).Error; err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to list live deployments: %v", err))
+		return
+	}
+	for i := range deployments {
+		deployment := &deployments[i]
+		project := &deployment.Project
+		if project.ID == 0 || project.Archived() || deployment.K8sDeploymentName != kubernetes.ProjectResourceName(project.ID) {
+			continue
+		}
+		client := r.clusters.Client(deployment.Cluster)
+		if client == nil {
+			continue
+		}
+		missing, err := client.MissingObjects(ctx, project, deployment.K8sDeploymentName)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("deployment %d: %v", deployment.ID, err))
+			continue
+		}
+		if len(missing) == 0 {
+			if deployment.DriftedAt != nil {
+				database.DB.Model(deployment).UpdateColumn("drifted_at", nil)
+				log.Printf("✅ Deployment %d of project %d: its objects are back", deployment.ID, project.ID)
+			}
+			continue
+		}
+		report.Drifted = append(report.Drifted, Drifted{
+			DeploymentID: deployment.ID,
+			ProjectID:    project.ID,
+			Cluster:      deployment.Cluster,
+			Name:         deployment.K8sDeploymentName,
+			Missing:      missing,
+		})
+		if deployment.DriftedAt == nil {
+			// Not touching updated_at, which tells when it went live
+			database.DB.Model(deployment).UpdateColumn("drifted_at", time.Now())
+			log.Printf("🧭 Deployment %d of project %d drifted: %s gone from the cluster", deployment.ID, project.ID, strings.Join(missing, ", "))
+		}
+	}
+}
+
+// RegisterMetrics exports the findings of the last sweep
+func (r *Reconciler) RegisterMetrics() {
+	metrics.RegisterGauge("deploy_drift_orphaned_objects", "Platform objects in the clusters no project or deployment accounts for, as of the last sweep", func() []metrics.Sample {
+		report := r.Last()
+		if report == nil {
+			return nil
+		}
+		counts := make(map[string]int)
+		for _, orphan := range report.Orphans {
+			if !orphan.Deleted {
+				counts[orphan.Cluster]++
+			}
+		}
+		samples := make([]metrics.Sample, 0, len(counts))
+		for cluster, n := range counts {
+			samples = append(samples, metrics.Sample{Labels: map[string]string{"cluster": cluster}, Value: float64(n)})
+		}
+		return samples
+	})
+	metrics.RegisterGauge("deploy_drift_drifted_deployments", "Live production deployments whose objects are gone from their cluster, as of the last sweep", func() []metrics.Sample {
+		report := r.Last()
+		if report == nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(len(report.Drifted))}}
+	})
+}
@@ -0,0 +1,227 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/githubscopes"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v56/github"
+)
+
+// analysisContext names the platform's commit status on analyzed commits
+const analysisContext = "deploy-platform/analysis"
+
+// maxAnalyses is how many analyses are kept per project, newest first
+const maxAnalyses = 100
+
+// maxStatusDescription is the longest description GitHub accepts on a commit status
+const maxStatusDescription = 140
+
+// Commit status states
+const (
+	statusPending = "pending"
+	statusSuccess = "success"
+	statusFailure = "failure"
+	statusError   = "error"
+)
+
+// CommitStatus is one of the platform's commit statuses on a commit
+type CommitStatus struct {
+	Context     string // analysisContext or buildContext
+	State       string
+	Description string // At most maxStatusDescription
+	TargetURL   string
+}
+
+// StatusPoster sets the platform's commit statuses on a commit
+type StatusPoster interface {
+	CreateStatus(ctx context.Context, owner, repo, sha string, status *CommitStatus) error
+}
+
+type apiStatusPoster struct {
+	client *github.Client
+}
+
+func (a *apiStatusPoster) CreateStatus(ctx context.Context, owner, repo, sha string, status *CommitStatus) error {
+	_, _, err := a.client.Repositories.CreateStatus(ctx, owner, repo, sha, &github.RepoStatus{
+		State:       &status.State,
+		Description: &status.Description,
+		TargetURL:   &status.TargetURL,
+		Context:     &status.Context,
+	})
+	return err
+}
+
+// analyzePush records an analysis of a commit pushed to a project in
+// analysis-only mode and runs it in the background. Nothing is built and no
+// deployment is created, so it doesn't count against the deployment quota.
+func (h *WebhookHandler) analyzePush(c *gin.Context, project *models.Project, push *pushedCommit) {
+	if project.Archived() {
+		c.JSON(http.StatusConflict, gin.H{"error": "The project is archived, unarchive it to analyze pushes again", "code": ProjectArchived})
+		return
+	}
+	if h.analyzer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Push analyses are not available on this platform"})
+		return
+	}
+
+	// Redelivered pushes are not analyzed twice
+	var existing models.Analysis
+	if err := database.DB.Where("project_id = ? AND branch = ? AND commit_sha = ? AND status IN ?",
+		project.ID, push.Branch, push.SHA, []string{models.AnalysisRunning, models.AnalysisSucceeded}).
+		Order("id DESC").Limit(1).Find(&existing).Error; err == nil && existing.ID != 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Push already analyzed", "analysis": existing})
+		return
+	}
+
+	analysis := &models.Analysis{
+		ProjectID: project.ID,
+		CommitSHA: push.SHA,
+		CommitMsg: textutil.Truncate(textutil.Clean(push.Message), models.MaxCommitMsgBytes),
+		Branch:    push.Branch,
+		Actor:     push.Actor,
+		Status:    models.AnalysisRunning,
+	}
+	if err := database.DB.Create(analysis).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create analysis: " + err.Error()})
+		return
+	}
+	pruneAnalyses(project.ID)
+
+	go h.runAnalysis(*project, *analysis)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Push analyzed, not deployed: the project is in analysis-only mode",
+		"analysis": analysis,
+	})
+}
+
+// runAnalysis analyzes the commit, stores the result and reports it as a
+// commit status
+func (h *WebhookHandler) runAnalysis(project models.Project, analysis models.Analysis) {
+	h.postAnalysisStatus(&project, &analysis)
+
+	started := h.now()
+	result, err := h.analyzer.Analyze(context.Background(), &project, analysis.Branch, analysis.CommitSHA)
+	analysis.DurationMs = h.now().Sub(started).Milliseconds()
+	switch {
+	case errors.Is(err, build.ErrCommitMoved):
+		analysis.Status = models.AnalysisSuperseded
+		analysis.Error = err.Error()
+	case err != nil:
+		analysis.Status = models.AnalysisFailed
+		analysis.Error = err.Error()
+	default:
+		analysis.Status = models.AnalysisSucceeded
+		analysis.Detector = result.Detection.Type
+		analysis.Framework = result.Detection.Framework
+		analysis.FrameworkVersion = result.Detection.FrameworkVersion
+		if runtime := result.Detection.Runtime; runtime != nil {
+			analysis.Runtime = runtime.Runtime
+			analysis.RuntimeVersion = runtime.Version
+		}
+		analysis.DockerfileSource = result.Source
+		analysis.Dockerfile = result.Dockerfile
+		analysis.Port = result.Port
+		analysis.Warnings = result.Warnings
+	}
+	if err := database.DB.Select("status", "detector", "framework", "framework_version", "runtime", "runtime_version",
+		"dockerfile_source", "dockerfile", "port", "warnings", "error", "duration_ms").Updates(&analysis).Error; err != nil {
+		log.Printf("⚠️  Failed to store analysis %d of project %d: %v", analysis.ID, project.ID, err)
+	}
+	log.Printf("🔎 Analysis of %s@%s (project %d): %s in %dms", analysis.Branch, textutil.ShortSHA(analysis.CommitSHA),
+		project.ID, analysis.Status, analysis.DurationMs)
+
+	h.postAnalysisStatus(&project, &analysis)
+}
+
+// postAnalysisStatus reports an analysis as the commit status of its commit,
+// with the owner's GitHub token. Projects not on GitHub, or whose owner didn't
+// grant the scope, keep their analyses in GET /api/projects/:id/analyses only.
+func (h *WebhookHandler) postAnalysisStatus(project *models.Project, analysis *models.Analysis) {
+	// Statuses need the full hash; generic webhooks may push abbreviated ones
+	if project.RepoOwner == "" || project.RepoName == "" || len(analysis.CommitSHA) != 40 {
+		return
+	}
+	var owner models.User
+	if err := database.DB.Select("id", "github_token", "github_scopes").First(&owner, project.UserID).Error; err != nil || owner.GitHubToken == "" {
+		return
+	}
+	if err := githubscopes.Require(githubscopes.Parse(owner.GitHubScopes), githubscopes.FeatureCommitStatus); err != nil {
+		log.Printf("⚠️  Analysis %d of project %d: not posting a commit status, %v", analysis.ID, project.ID, err)
+		return
+	}
+
+	state, description := analysisStatus(analysis)
+	ctx, cancel := context.WithTimeout(context.Background(), httpclient.CallTimeout)
+	defer cancel()
+	err := h.newStatusPoster(owner.GitHubToken).CreateStatus(ctx, project.RepoOwner, project.RepoName, analysis.CommitSHA, &CommitStatus{
+		Context:     analysisContext,
+		State:       state,
+		Description: description,
+		TargetURL:   h.dashboardURL(),
+	})
+	if err != nil {
+		log.Printf("⚠️  Failed to post the commit status of analysis %d (project %d): %v", analysis.ID, project.ID, err)
+	}
+}
+
+// dashboardURL is where commit statuses and check runs link to
+func (h *WebhookHandler) dashboardURL() string {
+	return strings.TrimRight(h.baseURL, "/") + "/dashboard"
+}
+
+// analysisStatus is the commit status state and description of an analysis
+func analysisStatus(analysis *models.Analysis) (state, description string) {
+	switch analysis.Status {
+	case models.AnalysisRunning:
+		return statusPending, "Analyzing: analysis-only mode, nothing is built"
+	case models.AnalysisSuperseded:
+		return statusError, "Not analyzed: the branch moved on, its newer commit is analyzed"
+	case models.AnalysisFailed:
+		return statusFailure, textutil.Truncate("A build would fail: "+analysis.Error, maxStatusDescription)
+	}
+
+	var parts []string
+	switch {
+	case analysis.Framework != "" && analysis.FrameworkVersion != "":
+		parts = append(parts, analysis.Framework+" "+analysis.FrameworkVersion)
+	case analysis.Framework != "":
+		parts = append(parts, analysis.Framework)
+	default:
+		parts = append(parts, analysis.Detector)
+	}
+	parts = append(parts, analysis.DockerfileSource+" Dockerfile")
+	if analysis.Port > 0 {
+		parts = append(parts, fmt.Sprintf("port %d", analysis.Port))
+	} else {
+		parts = append(parts, "worker")
+	}
+	switch len(analysis.Warnings) {
+	case 0:
+	case 1:
+		parts = append(parts, "1 warning")
+	default:
+		parts = append(parts, fmt.Sprintf("%d warnings", len(analysis.Warnings)))
+	}
+	return statusSuccess, textutil.Truncate("Would deploy: "+strings.Join(parts, ", "), maxStatusDescription)
+}
+
+// pruneAnalyses deletes the analyses of a project past the newest maxAnalyses
+func pruneAnalyses(projectID uint) {
+	var cutoff models.Analysis
+	if err := database.DB.Select("id").Where("project_id = ?", projectID).
+		Order("id DESC").Offset(maxAnalyses).Limit(1).Find(&cutoff).Error; err != nil || cutoff.ID == 0 {
+		return
+	}
+	database.DB.Where("project_id = ? AND id <= ?", projectID, cutoff.ID).Delete(&models.Analysis{})
+}
@@ -0,0 +1,181 @@
+package github
+
+import (
+	"context"
+	"crypto/rsa"
+	"deploy-platform/internal/httpclient"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v56/github"
+)
+
+// tokenMargin is how long before it expires an installation token is replaced
+const tokenMargin = 5 * time.Minute
+
+// App authenticates as a GitHub App, to post check runs on the repositories
+// it is installed on. The installation of each repository and its token are
+// looked up once and kept until the token nearly expires.
+type App struct {
+	id     int64
+	key    *rsa.PrivateKey
+	client *http.Client
+
+	mu            sync.Mutex
+	installations map[string]int64 // "owner/repo" -> installation ID
+	tokens        map[int64]installationToken
+}
+
+type installationToken struct {
+	token   string
+	expires time.Time
+}
+
+// errNotInstalled is returned for a repository the App isn't installed on
+var errNotInstalled = errors.New("the GitHub App is not installed on the repository")
+
+// LoadApp loads the GitHub App of GITHUB_APP_ID and its private key, nil
+// when no App is configured
+func LoadApp(id int, keyPath string) (*App, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	return &App{
+		id:            int64(id),
+		key:           key,
+		client:        httpclient.New("github"),
+		installations: make(map[string]int64),
+		tokens:        make(map[int64]installationToken),
+	}, nil
+}
+
+// appClient authenticates as the App itself, with a JWT valid for minutes
+func (a *App) appClient() (*github.Client, error) {
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    strconv.FormatInt(a.id, 10),
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)), // GitHub's clock may be behind
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+	}).SignedString(a.key)
+	if err != nil {
+		return nil, err
+	}
+	return github.NewClient(a.client).WithAuthToken(token), nil
+}
+
+// repoClient authenticates as the App's installation on owner/repo
+func (a *App) repoClient(ctx context.Context, owner, repo string) (*github.Client, error) {
+	fullName := owner + "/" + repo
+	a.mu.Lock()
+	installation, found := a.installations[fullName]
+	cached := a.tokens[installation]
+	a.mu.Unlock()
+	if found && time.Until(cached.expires) > tokenMargin {
+		return github.NewClient(a.client).WithAuthToken(cached.token), nil
+	}
+
+	client, err := a.appClient()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		installed, _, err := client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+		var errResp *github.ErrorResponse
+		if errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+			return nil, errNotInstalled
+		}
+		if err != nil {
+			return nil, err
+		}
+		installation = installed.GetID()
+	}
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installation, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.installations[fullName] = installation
+	a.tokens[installation] = installationToken{token: token.GetToken(), expires: token.GetExpiresAt().Time}
+	a.mu.Unlock()
+	return github.NewClient(a.client).WithAuthToken(token.GetToken()), nil
+}
+
+func (a *App) CreateCheckRun(ctx context.Context, owner, repo string, run *CheckRun) (int64, error) {
+	client, err := a.repoClient(ctx, owner, repo)
+	if err != nil {
+		return 0, err
+	}
+	created, _, err := client.Checks.CreateCheckRun(ctx, owner, repo, github.CreateCheckRunOptions{
+		Name:       checkRunName,
+		HeadSHA:    run.HeadSHA,
+		DetailsURL: &run.DetailsURL,
+		ExternalID: &run.ExternalID,
+		Status:     &run.Status,
+		Conclusion: conclusionOption(run),
+		Output:     run.output(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return created.GetID(), nil
+}
+
+func (a *App) UpdateCheckRun(ctx context.Context, owner, repo string, id int64, run *CheckRun) error {
+	client, err := a.repoClient(ctx, owner, repo)
+	if err != nil {
+		return err
+	}
+	_, _, err = client.Checks.UpdateCheckRun(ctx, owner, repo, id, github.UpdateCheckRunOptions{
+		Name:       checkRunName,
+		DetailsURL: &run.DetailsURL,
+		ExternalID: &run.ExternalID,
+		Status:     &run.Status,
+		Conclusion: conclusionOption(run),
+		Output:     run.output(),
+	})
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+		return errCheckRunGone
+	}
+	return err
+}
+
+// conclusionOption is the conclusion of a completed check run, GitHub
+// refusing one on the others
+func conclusionOption(run *CheckRun) *string {
+	if run.Status != checkCompleted {
+		return nil
+	}
+	return &run.Conclusion
+}
+
+// output is the check run's output on GitHub
+func (run *CheckRun) output() *github.CheckRunOutput {
+	output := &github.CheckRunOutput{Title: &run.Title, Summary: &run.Summary}
+	for _, annotation := range run.Annotations {
+		output.Annotations = append(output.Annotations, &github.CheckRunAnnotation{
+			Path:            &annotation.Path,
+			StartLine:       &annotation.Line,
+			EndLine:         &annotation.Line,
+			AnnotationLevel: &annotation.Level,
+			Message:         &annotation.Message,
+			Title:           &annotation.Title,
+		})
+	}
+	return output
+}
@@ -0,0 +1,148 @@
+package github
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxRejectReason bounds the reason recorded with a rejection
+const maxRejectReason = 500
+
+// RejectRequest optionally says why a deployment is rejected
+type RejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ApproveDeployment lets a production deployment awaiting approval roll out.
+// It is queued again ahead of pushed commits, and its worker only rolls out
+// the image built before it waited. Approving a deployment blocked by its
+// project's deploy policy overrides the policy: it is queued for its build,
+// and rolled out without waiting for approval again.
+func (h *WebhookHandler) ApproveDeployment(c *gin.Context) {
+	deployment, ok := h.awaitingDeployment(c)
+	if !ok {
+		return
+	}
+	username := c.GetString("username")
+	reason, action := "approved by "+username, "deployment.approve"
+	if deployment.Status == models.StatusPolicyBlocked {
+		reason, action = "deploy policy overridden by "+username, "deployment.policy_override"
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := models.SetDeploymentStatusFrom(tx, deployment.ID, deployment.Status, models.StatusQueued, reason); err != nil {
+			return err
+		}
+		return tx.Model(deployment).Updates(map[string]interface{}{"approved_by": username, "approved_at": h.now()}).Error
+	})
+	if !decided(c, deployment, err) {
+		return
+	}
+	audit.FromContext(c, action, fmt.Sprintf("deployment %d of project %d (%s)", deployment.ID, deployment.ProjectID, textutil.ShortSHA(deployment.CommitSHA)))
+	log.Printf("✅ Deployment %d %s", deployment.ID, reason)
+
+	h.enqueueDeployment(&deployment.Project, deployment, true)
+	respondDeployment(c, deployment.ID)
+}
+
+// RejectDeployment refuses a production deployment awaiting approval, or
+// blocked by its deploy policy: it is never rolled out
+func (h *WebhookHandler) RejectDeployment(c *gin.Context) {
+	var req RejectRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > maxRejectReason {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("reason must be at most %d bytes", maxRejectReason)})
+		return
+	}
+	deployment, ok := h.awaitingDeployment(c)
+	if !ok {
+		return
+	}
+
+	username := c.GetString("username")
+	reason := "rejected by " + username
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+	err := models.SetDeploymentStatusFrom(database.DB, deployment.ID, deployment.Status, models.StatusRejected, reason)
+	if !decided(c, deployment, err) {
+		return
+	}
+	audit.FromContext(c, "deployment.reject", fmt.Sprintf("deployment %d of project %d (%s): %s", deployment.ID, deployment.ProjectID, textutil.ShortSHA(deployment.CommitSHA), reason))
+	log.Printf("🚫 Deployment %d %s", deployment.ID, reason)
+	respondDeployment(c, deployment.ID)
+}
+
+// awaitingDeployment loads the :id deployment for its approver, writing the
+// error response and returning false unless it awaits approval or an
+// override of its policy block. A deployment whose window ran out is
+// cancelled right away.
+func (h *WebhookHandler) awaitingDeployment(c *gin.Context) (*models.Deployment, bool) {
+	deploymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deployment ID"})
+		return nil, false
+	}
+	var deployment models.Deployment
+	if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return nil, false
+	}
+	if !build.CanApprove(&deployment.Project, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	if deployment.Status != models.StatusAwaitingApproval && deployment.Status != models.StatusPolicyBlocked {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment is %s, not awaiting approval", deployment.Status)})
+		return nil, false
+	}
+	if deployment.ApprovalExpiresAt != nil && h.now().After(*deployment.ApprovalExpiresAt) {
+		models.SetDeploymentStatusFrom(database.DB, deployment.ID, deployment.Status, models.StatusCancelled, "not approved in time")
+		c.JSON(http.StatusConflict, gin.H{"error": "The approval window has passed, the deployment was cancelled"})
+		return nil, false
+	}
+	return &deployment, true
+}
+
+// decided writes the error response of an approval or rejection that
+// failed, e.g. because another approver decided first
+func decided(c *gin.Context, deployment *models.Deployment, err error) bool {
+	var transitionErr *models.TransitionError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment is %s, not awaiting approval", transitionErr.From)})
+	default:
+		log.Printf("❌ Failed to decide on deployment %d: %v", deployment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update deployment"})
+	}
+	return false
+}
+
+// respondDeployment answers with the deployment as it is now
+func respondDeployment(c *gin.Context, deploymentID uint) {
+	var deployment models.Deployment
+	if err := database.DB.First(&deployment, deploymentID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deployment"})
+		return
+	}
+	c.JSON(http.StatusOK, deployment)
+}
@@ -0,0 +1,139 @@
+package github
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// approve has the project owner approve a deployment of s's project awaiting
+// approval until expires, with the handler's clock at now
+func approve(t *testing.T, s *webhookSetup, expires, now time.Time) (*httptest.ResponseRecorder, *models.Deployment) {
+	t.Helper()
+	deployment := &models.Deployment{
+		ProjectID:         s.project.ID,
+		Status:            models.StatusAwaitingApproval,
+		CommitSHA:         "0123456789abcdef0123456789abcdef01234567",
+		Branch:            "main",
+		ApprovalExpiresAt: &expires,
+	}
+	if err := database.DB.Create(deployment).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.handler = s.newHandler(WebhookDeps{
+		Deployments: s.deployments,
+		Queue:       s.queue,
+		Clock:       func() time.Time { return now },
+	})
+
+	r := gin.New()
+	r.POST("/deployments/:id/approve", func(c *gin.Context) {
+		c.Set("user_id", s.project.UserID)
+		c.Set("username", "ada")
+	}, s.handler.ApproveDeployment)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/deployments/%d/approve", deployment.ID), nil))
+	database.DB.First(deployment, deployment.ID)
+	return w, deployment
+}
+
+func TestApproveWithinWindow(t *testing.T) {
+	s := newWebhookSetup(t)
+	expires := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	w, deployment := approve(t, s, expires, expires.Add(-time.Minute))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if deployment.Status != models.StatusQueued || deployment.ApprovedBy != "ada" {
+		t.Fatalf("deployment is %s, approved by %q", deployment.Status, deployment.ApprovedBy)
+	}
+	// Approved at the handler's clock, and queued ahead of pushed commits
+	if deployment.ApprovedAt == nil || !deployment.ApprovedAt.Equal(expires.Add(-time.Minute)) {
+		t.Fatalf("approved at %v", deployment.ApprovedAt)
+	}
+	if job, ok := s.queue.TryDequeue(); !ok || job.DeploymentID != deployment.ID || job.Priority != queue.PriorityHigh {
+		t.Fatalf("got job %+v", job)
+	}
+	// The audit log records who approved
+	var entry models.AuditLog
+	if err := database.DB.Where("action = ?", "deployment.approve").First(&entry).Error; err != nil || entry.UserID != s.project.UserID || !strings.HasPrefix(entry.Details, fmt.Sprintf("deployment %d of project %d", deployment.ID, s.project.ID)) {
+		t.Errorf("audit log %+v: %v", entry, err)
+	}
+}
+
+func TestApproveAfterWindow(t *testing.T) {
+	s := newWebhookSetup(t)
+	expires := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	w, deployment := approve(t, s, expires, expires.Add(time.Minute))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if deployment.Status != models.StatusCancelled {
+		t.Fatalf("deployment is %s, want cancelled", deployment.Status)
+	}
+	if s.queue.Size() != 0 {
+		t.Fatal("expired deployment queued")
+	}
+}
+
+func TestRejectDeployment(t *testing.T) {
+	s := newWebhookSetup(t)
+	stranger := &models.User{Email: "mallory@example.com", Username: "mallory"}
+	database.DB.Create(stranger)
+	expires := time.Now().Add(time.Hour)
+	deployment := &models.Deployment{ProjectID: s.project.ID, Status: models.StatusAwaitingApproval, Branch: "main", ApprovalExpiresAt: &expires}
+	database.DB.Create(deployment)
+	decide := func(action string, userID uint, body string) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.POST("/deployments/:id/"+action, func(c *gin.Context) {
+			c.Set("user_id", userID)
+			c.Set("username", "ada")
+		}, map[string]gin.HandlerFunc{"approve": s.handler.ApproveDeployment, "reject": s.handler.RejectDeployment}[action])
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/deployments/%d/%s", deployment.ID, action), strings.NewReader(body)))
+		return w
+	}
+
+	// Only approvers decide, within bounds
+	if w := decide("reject", stranger.ID, ""); w.Code != http.StatusForbidden {
+		t.Errorf("stranger: got %d", w.Code)
+	}
+	if w := decide("approve", stranger.ID, ""); w.Code != http.StatusForbidden {
+		t.Errorf("stranger approving: got %d", w.Code)
+	}
+	if w := decide("reject", s.project.UserID, `{"reason": "`+strings.Repeat("x", maxRejectReason+1)+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("long reason: got %d", w.Code)
+	}
+
+	if w := decide("reject", s.project.UserID, `{"reason": " failing smoke tests "}`); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var event models.DeploymentEvent
+	database.DB.First(deployment, deployment.ID)
+	database.DB.Where("deployment_id = ?", deployment.ID).Last(&event)
+	if deployment.Status != models.StatusRejected || event.Reason != "rejected by ada: failing smoke tests" {
+		t.Errorf("deployment is %s: %q", deployment.Status, event.Reason)
+	}
+	if s.queue.Size() != 0 {
+		t.Error("rejected deployment queued")
+	}
+	var entry models.AuditLog
+	if err := database.DB.Where("action = ?", "deployment.reject").First(&entry).Error; err != nil || entry.UserID != s.project.UserID || !strings.HasSuffix(entry.Details, "rejected by ada: failing smoke tests") {
+		t.Errorf("audit log %+v: %v", entry, err)
+	}
+
+	// Decided once and for all
+	for _, action := range []string{"approve", "reject"} {
+		if w := decide(action, s.project.UserID, ""); w.Code != http.StatusConflict {
+			t.Errorf("%s after rejecting: got %d", action, w.Code)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/githubscopes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkRunName names the platform's check run on built commits
+const checkRunName = "deploy-platform"
+
+// buildContext names the platform's commit status on built commits, when
+// no GitHub App posts check runs
+const buildContext = "deploy-platform/build"
+
+// Check run statuses and conclusions
+const (
+	checkQueued     = "queued"
+	checkInProgress = "in_progress"
+	checkCompleted  = "completed"
+
+	conclusionSuccess   = "success"
+	conclusionFailure   = "failure"
+	conclusionCancelled = "cancelled"
+	conclusionSkipped   = "skipped"
+)
+
+// Bounds of a check run's output: GitHub takes at most 65535 characters of
+// summary and 50 annotations per request
+const (
+	maxCheckSummary     = 60 << 10
+	maxCheckAnnotations = 50
+	maxCheckWarnings    = 10 // Listed in the summary, the rest counted
+	logTailLines        = 40 // Of a failed build's logs, in its summary
+	logTailBytes        = 16 << 10
+)
+
+// CheckRunner posts and updates the platform's check run on a commit
+type CheckRunner interface {
+	CreateCheckRun(ctx context.Context, owner, repo string, run *CheckRun) (int64, error)
+	UpdateCheckRun(ctx context.Context, owner, repo string, id int64, run *CheckRun) error
+}
+
+// errCheckRunGone is returned by UpdateCheckRun for a check run GitHub doesn't know
+var errCheckRunGone = errors.New("check run not found")
+
+// CheckRun is the platform's check run on the commit of a deployment
+type CheckRun struct {
+	HeadSHA     string
+	ExternalID  string // The deployment's ID
+	DetailsURL  string
+	Status      string // check*
+	Conclusion  string // conclusion*, once completed
+	Title       string
+	Summary     string // Markdown
+	Annotations []CheckAnnotation
+}
+
+// CheckAnnotation points at a line of a file of the commit
+type CheckAnnotation struct {
+	Path    string
+	Line    int
+	Level   string // notice, warning or failure
+	Title   string
+	Message string
+}
+
+var lintLinePattern = regexp.MustCompile(`^line (\d+): (.+)$`)
+
+// reportBuild reports how a deployment's build is doing on its commit: as a
+// check run when a GitHub App is configured, otherwise as a commit status
+// with the owner's token. status overrides the stored one, for hooks running
+// before it is stored; "" reports the stored one. Reports are rendered from
+// the database, so a late report doesn't bring back an older state.
+func (h *WebhookHandler) reportBuild(ctx context.Context, deploymentID uint, status models.DeploymentStatus) {
+	unlock := h.lockDeployment(deploymentID)
+	defer unlock()
+
+	var deployment models.Deployment
+	if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
+		return
+	}
+	project := &deployment.Project
+	// Both need the full hash; generic webhooks may push abbreviated ones
+	if project.RepoOwner == "" || project.RepoName == "" || len(deployment.CommitSHA) != 40 {
+		return
+	}
+	if status != "" {
+		deployment.Status = status
+	}
+	var record models.Build
+	if err := database.DB.Where("deployment_id = ?", deployment.ID).Order("id DESC").Limit(1).Find(&record).Error; err != nil {
+		return
+	}
+	if record.ID != 0 {
+		if err := buildlogs.Collect(database.DB, &record); err != nil {
+			log.Printf("⚠️  Failed to read the output of build %d: %v", record.ID, err)
+		}
+	}
+
+	url := ""
+	if deployment.Hostname != "" && h.hostnames != nil {
+		url = h.hostnames.GetFullURL(deployment.Hostname)
+	}
+	run := renderCheckRun(&deployment, &record, url, h.now())
+	run.DetailsURL = h.dashboardURL()
+
+	if h.checks != nil {
+		h.postCheckRun(ctx, &deployment, run)
+	} else {
+		h.postBuildStatus(ctx, &deployment, run)
+	}
+}
+
+// reportBuildAsync reports a deployment's stored status, for callers that
+// don't wait on GitHub
+func (h *WebhookHandler) reportBuildAsync(deploymentID uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), commentTimeout)
+	defer cancel()
+	h.reportBuild(ctx, deploymentID, "")
+}
+
+// lockDeployment serializes the reports of a deployment, returning the
+// function unlocking it
+func (h *WebhookHandler) lockDeployment(deploymentID uint) func() {
+	value, _ := h.deploymentLocks.LoadOrStore(deploymentID, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// postCheckRun updates the deployment's check run, creating it the first
+// time. Its ID is stored, so retried builds and redelivered events update
+// the same check run rather than adding one.
+func (h *WebhookHandler) postCheckRun(ctx context.Context, deployment *models.Deployment, run *CheckRun) {
+	project := &deployment.Project
+	if deployment.CheckRunID > 0 {
+		err := h.checks.UpdateCheckRun(ctx, project.RepoOwner, project.RepoName, deployment.CheckRunID, run)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, errCheckRunGone) {
+			log.Printf("⚠️  Failed to update the check run of deployment %d: %v", deployment.ID, err)
+			return
+		}
+		// Deleted with its check suite: post it again
+		database.DB.Model(&models.Deployment{}).Where("id = ? AND check_run_id = ?", deployment.ID, deployment.CheckRunID).Update("check_run_id", 0)
+	}
+
+	// Claimed first, so replicas reporting the same deployment don't both post one
+	claim := database.DB.Model(&models.Deployment{}).Where("id = ? AND check_run_id = 0", deployment.ID).Update("check_run_id", -1)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+	id, err := h.checks.CreateCheckRun(ctx, project.RepoOwner, project.RepoName, run)
+	if err != nil {
+		if errors.Is(err, errNotInstalled) {
+			log.Printf("⚠️  Deployment %d: no check run, the GitHub App is not installed on %s/%s", deployment.ID, project.RepoOwner, project.RepoName)
+		} else {
+			log.Printf("⚠️  Failed to create the check run of deployment %d: %v", deployment.ID, err)
+		}
+		database.DB.Model(&models.Deployment{}).Where("id = ?", deployment.ID).Update("check_run_id", 0)
+		return
+	}
+	database.DB.Model(&models.Deployment{}).Where("id = ?", deployment.ID).Update("check_run_id", id)
+}
+
+// postBuildStatus reports the check run as a commit status, for platforms
+// without a GitHub App. It needs the owner's token with the repo:status scope.
+func (h *WebhookHandler) postBuildStatus(ctx context.Context, deployment *models.Deployment, run *CheckRun) {
+	project := &deployment.Project
+	var owner models.User
+	if err := database.DB.Select("id", "github_token", "github_scopes").First(&owner, project.UserID).Error; err != nil || owner.GitHubToken == "" {
+		return
+	}
+	if githubscopes.Require(githubscopes.Parse(owner.GitHubScopes), githubscopes.FeatureCommitStatus) != nil {
+		return
+	}
+
+	state := statusPending
+	switch {
+	case run.Status != checkCompleted:
+	case run.Conclusion == conclusionSuccess:
+		state = statusSuccess
+	case run.Conclusion == conclusionFailure:
+		state = statusFailure
+	default:
+		state = statusError
+	}
+	err := h.newStatusPoster(owner.GitHubToken).CreateStatus(ctx, project.RepoOwner, project.RepoName, deployment.CommitSHA, &CommitStatus{
+		Context:     buildContext,
+		State:       state,
+		Description: textutil.Truncate(run.Title, maxStatusDescription),
+		TargetURL:   run.DetailsURL,
+	})
+	if err != nil {
+		log.Printf("⚠️  Failed to post the commit status of deployment %d: %v", deployment.ID, err)
+	}
+}
+
+// renderCheckRun writes the check run of a deployment from its latest
+// build: what was detected and built, its warnings, and the tail of its
+// logs once it failed. url is where it is live, "" for none.
+func renderCheckRun(deployment *models.Deployment, record *models.Build, url string, now time.Time) *CheckRun {
+	run := &CheckRun{
+		HeadSHA:    deployment.CommitSHA,
+		ExternalID: strconv.FormatUint(uint64(deployment.ID), 10),
+		Status:     checkInProgress,
+	}
+	short := textutil.ShortSHA(deployment.CommitSHA)
+	switch deployment.Status {
+	case models.StatusPending, models.StatusQueued:
+		run.Status = checkQueued
+		run.Title = "Queued: waiting for a build slot"
+	case models.StatusBuilding:
+		run.Title = "Building " + short
+	case models.StatusDeploying:
+		run.Title = "Built, rolling out " + short
+	case models.StatusAwaitingApproval:
+		run.Title = "Built, awaiting approval to deploy"
+	case models.StatusPolicyBlocked:
+		run.Title = "Blocked by the deploy policy, awaiting an approver's override"
+	case models.StatusDeployPending:
+		run.Title = "Built, the cluster is unavailable: rollout of " + short + " retried shortly"
+	case models.StatusDeployed:
+		run.Status, run.Conclusion = checkCompleted, conclusionSuccess
+		run.Title = "Deployed"
+		if url != "" {
+			run.Title = "Deployed to " + url
+		}
+	case models.StatusFailed:
+		run.Status, run.Conclusion = checkCompleted, conclusionFailure
+		run.Title = "Failed"
+		if deployment.FailureCategory != "" {
+			run.Title = "Failed: " + strings.ReplaceAll(deployment.FailureCategory, "_", " ")
+		}
+	case models.StatusSkipped:
+		run.Status, run.Conclusion = checkCompleted, conclusionSkipped
+		run.Title = "Skipped"
+	default:
+		// Superseded, cancelled or rejected
+		run.Status, run.Conclusion = checkCompleted, conclusionCancelled
+		run.Title = strings.ToUpper(string(deployment.Status[:1])) + string(deployment.Status[1:])
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Deployment #%d of `%s`", deployment.ID, short)
+	if deployment.Branch != "" {
+		fmt.Fprintf(&b, " on `%s`", deployment.Branch)
+	}
+	b.WriteString(".\n")
+
+	if record.ID != 0 {
+		var rows []string
+		switch {
+		case record.Framework != "" && record.FrameworkVersion != "":
+			rows = append(rows, "| Framework | "+record.Framework+" "+record.FrameworkVersion+" |")
+		case record.Framework != "":
+			rows = append(rows, "| Framework | "+record.Framework+" |")
+		}
+		switch {
+		case record.DockerfilePath != "":
+			rows = append(rows, "| Dockerfile | `"+record.DockerfilePath+"` |")
+		case record.DockerfileRevisionID != nil:
+			rows = append(rows, "| Dockerfile | The project's override |")
+		case record.Dockerfile != "":
+			rows = append(rows, "| Dockerfile | Generated |")
+		}
+		if record.ImageSizeBytes > 0 {
+			rows = append(rows, fmt.Sprintf("| Image | %s, %d layers |", formatImageSize(record.ImageSizeBytes), record.ImageLayers))
+		}
+		if record.StartedAt != nil {
+			end := now
+			if record.CompletedAt != nil {
+				end = *record.CompletedAt
+			}
+			rows = append(rows, "| Duration | "+end.Sub(*record.StartedAt).Round(time.Second).String()+" |")
+		}
+		if url != "" && deployment.Status == models.StatusDeployed {
+			rows = append(rows, "| URL | "+url+" |")
+		}
+		if len(rows) > 0 {
+			b.WriteString("\n| | |\n|---|---|\n" + strings.Join(rows, "\n") + "\n")
+		}
+	}
+
+	if deployment.Status == models.StatusFailed && deployment.FailureDetail != "" {
+		b.WriteString("\n" + deployment.FailureDetail + "\n")
+	}
+
+	if len(deployment.PolicyViolations) > 0 {
+		b.WriteString("\n### Deploy policy\n\n")
+		for _, violation := range deployment.PolicyViolations {
+			b.WriteString("- " + oneLine(violation) + "\n")
+		}
+	}
+
+	annotations, lintWarnings := dockerfileAnnotations(record)
+	run.Annotations = annotations
+	warnings := append(append([]string(nil), record.Warnings...), lintWarnings...)
+	if len(warnings) > 0 {
+		shown := warnings[:min(len(warnings), maxCheckWarnings)]
+		fmt.Fprintf(&b, "\n### Warnings (%d)\n\n", len(warnings))
+		for _, warning := range shown {
+			b.WriteString("- " + oneLine(warning) + "\n")
+		}
+		if more := len(warnings) - len(shown); more > 0 {
+			fmt.Fprintf(&b, "- …and %d more in the build logs\n", more)
+		}
+	}
+
+	if deployment.Status == models.StatusFailed && record.Logs != "" {
+		tail := logTail(record.Logs)
+		fence := codeFence(tail)
+		fmt.Fprintf(&b, "\n### Last lines of the build logs\n\n%stext\n%s\n%s\n", fence, tail, fence)
+	}
+
+	run.Summary = textutil.Truncate(b.String(), maxCheckSummary)
+	return run
+}
+
+// dockerfileAnnotations turns the lint findings of the repository's own
+// Dockerfile into annotations on its lines. Findings on generated and
+// override Dockerfiles, not in the repository, or without a line, are
+// returned as warnings for the summary instead.
+func dockerfileAnnotations(record *models.Build) ([]CheckAnnotation, []string) {
+	if record.Dockerfile == "" {
+		return nil, nil
+	}
+	warnings, err := build.LintDockerfile(record.Dockerfile)
+	findings := make([]CheckAnnotation, 0, len(warnings))
+	for _, warning := range warnings {
+		findings = append(findings, CheckAnnotation{Level: "warning", Message: warning})
+	}
+	if err != nil {
+		for _, problem := range strings.Split(strings.TrimPrefix(err.Error(), "invalid Dockerfile: "), "; ") {
+			findings = append(findings, CheckAnnotation{Level: "failure", Message: problem})
+		}
+	}
+
+	var annotations []CheckAnnotation
+	var rest []string
+	for _, finding := range findings {
+		m := lintLinePattern.FindStringSubmatch(finding.Message)
+		if record.DockerfilePath == "" || m == nil || len(annotations) == maxCheckAnnotations {
+			rest = append(rest, "Dockerfile: "+finding.Message)
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		finding.Path, finding.Line, finding.Title, finding.Message = record.DockerfilePath, line, "Dockerfile lint", m[2]
+		annotations = append(annotations, finding)
+	}
+	return annotations, rest
+}
+
+// logTail is the end of a build's logs, at most logTailLines lines and
+// logTailBytes bytes
+func logTail(logs string) string {
+	logs = strings.TrimRight(logs, "\n")
+	lines := strings.Split(logs, "\n")
+	lines = lines[max(0, len(lines)-logTailLines):]
+	tail := strings.Join(lines, "\n")
+	if len(tail) > logTailBytes {
+		tail = tail[len(tail)-logTailBytes:]
+		// Start at a whole line
+		if i := strings.IndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+	}
+	return strings.ToValidUTF8(tail, "")
+}
+
+// codeFence is a Markdown fence longer than any run of backticks in text
+func codeFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// oneLine keeps a warning on its list item
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// formatImageSize renders bytes as MB, or GB from 1 GB on, as builds log them
+func formatImageSize(bytes int64) string {
+	if bytes >= 1<<30 {
+		return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+	}
+	return fmt.Sprintf("%d MB", bytes>>20)
+}
@@ -0,0 +1,140 @@
+package github
+
+import (
+	"deploy-platform/internal/models"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares got with the golden file at path, rewriting it with -update
+func golden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from the golden file:\n%s", path, got)
+	}
+}
+
+// The summaries of a deployed, a failed and a warning-heavy build are
+// testdata/checkruns/<name>.md
+func TestRenderCheckRunGolden(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	started, completed := now.Add(-3*time.Minute), now.Add(-time.Minute-17*time.Second)
+	var logs strings.Builder
+	for i := 1; i <= 60; i++ {
+		fmt.Fprintf(&logs, "Step %d/60 : RUN make step-%d\n", i, i)
+	}
+	logs.WriteString("make: *** [Makefile:12: build] Error 2 ```\n")
+
+	tests := []struct {
+		name        string
+		deployment  models.Deployment
+		build       models.Build
+		url         string
+		title       string
+		conclusion  string
+		annotations int
+	}{
+		{
+			name: "success",
+			deployment: models.Deployment{ID: 41, Status: models.StatusDeployed, Branch: "main",
+				CommitSHA: "0123456789abcdef0123456789abcdef01234567"},
+			build: models.Build{ID: 7, Framework: "nextjs", FrameworkVersion: "14.1.0", Dockerfile: "FROM node:20-alpine\nCMD [\"npm\", \"start\"]\n",
+				ImageSizeBytes: 187 << 20, ImageLayers: 9, StartedAt: &started, CompletedAt: &completed},
+			url:        "https://app.example.com",
+			title:      "Deployed to https://app.example.com",
+			conclusion: conclusionSuccess,
+		},
+		{
+			name: "failure",
+			deployment: models.Deployment{ID: 42, Status: models.StatusFailed, Branch: "feature/login",
+				CommitSHA: "89abcdef0123456789abcdef0123456789abcdef", FailureCategory: "build_error", FailureDetail: "The build command exited with code 2."},
+			build: models.Build{ID: 8, DockerfilePath: "Dockerfile", Dockerfile: "FROM golang:1.22\nRUN make build\nCMD [\"./app\"]\n",
+				StartedAt: &started, CompletedAt: &completed, Logs: logs.String()},
+			title:      "Failed: build error",
+			conclusion: conclusionFailure,
+		},
+		{
+			name: "warnings",
+			deployment: models.Deployment{ID: 43, Status: models.StatusDeployed, Branch: "main",
+				CommitSHA:        "fedcba9876543210fedcba9876543210fedcba98",
+				PolicyViolations: []string{"The commit isn't signed\n(require_verified_commits)"}},
+			build: models.Build{ID: 9, Framework: "django", DockerfilePath: "deploy/Dockerfile",
+				Dockerfile: "FROM python\nMAINTAINER ops@example.com\nFROM python:latest\nCMD [\"gunicorn\"]\nCMD [\"gunicorn\", \"app.wsgi\"]\n",
+				Warnings: []string{
+					"STATIC_ROOT is not set in settings.py, collectstatic will be skipped",
+					"django apps need SECRET_KEY or DJANGO_SECRET_KEY: add it to the project's environment variables",
+					"requirements.txt asks for Python 3.9: building on Python 3.11, from project settings",
+					"warning 4", "warning 5", "warning 6", "warning 7", "warning 8", "warning 9", "warning 10",
+				},
+				StartedAt: &started},
+			title:       "Deployed",
+			conclusion:  conclusionSuccess,
+			annotations: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := renderCheckRun(&tt.deployment, &tt.build, tt.url, now)
+			if run.Status != checkCompleted || run.Conclusion != tt.conclusion || run.Title != tt.title {
+				t.Errorf("got %s/%s %q, want completed/%s %q", run.Status, run.Conclusion, run.Title, tt.conclusion, tt.title)
+			}
+			if run.HeadSHA != tt.deployment.CommitSHA || run.ExternalID != fmt.Sprint(tt.deployment.ID) {
+				t.Errorf("check run of %s, external ID %s", run.HeadSHA, run.ExternalID)
+			}
+			if len(run.Annotations) != tt.annotations {
+				t.Errorf("got %d annotations: %+v", len(run.Annotations), run.Annotations)
+			}
+			for _, annotation := range run.Annotations {
+				if annotation.Path != tt.build.DockerfilePath || annotation.Line == 0 {
+					t.Errorf("annotation %+v not on a line of %s", annotation, tt.build.DockerfilePath)
+				}
+			}
+			golden(t, filepath.Join("testdata", "checkruns", tt.name+".md"), []byte(run.Summary))
+		})
+	}
+}
+
+func TestRenderCheckRunStates(t *testing.T) {
+	tests := []struct {
+		status            models.DeploymentStatus
+		state, conclusion string
+	}{
+		{models.StatusQueued, checkQueued, ""},
+		{models.StatusBuilding, checkInProgress, ""},
+		{models.StatusAwaitingApproval, checkInProgress, ""},
+		{models.StatusSkipped, checkCompleted, conclusionSkipped},
+		{models.StatusSuperseded, checkCompleted, conclusionCancelled},
+		{models.StatusRejected, checkCompleted, conclusionCancelled},
+	}
+	for _, tt := range tests {
+		run := renderCheckRun(&models.Deployment{ID: 1, Status: tt.status}, &models.Build{}, "", time.Now())
+		if run.Status != tt.state || run.Conclusion != tt.conclusion {
+			t.Errorf("%s: got %s/%s, want %s/%s", tt.status, run.Status, run.Conclusion, tt.state, tt.conclusion)
+		}
+	}
+}
+
+func TestCodeFence(t *testing.T) {
+	for text, want := range map[string]string{"plain": "```", "a ``` b": "````", "````": "`````"} {
+		if got := codeFence(text); got != want {
+			t.Errorf("codeFence(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
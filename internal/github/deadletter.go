@@ -0,0 +1,155 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/jsonstream"
+	"deploy-platform/internal/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DeadEventFilter selects dead webhook events, by query parameters when
+// listing them and by JSON body when retrying them in bulk. Zero fields
+// select all.
+type DeadEventFilter struct {
+	Class string    `form:"class" json:"class"` // models.WebhookFailure*
+	Event string    `form:"event" json:"event"` // e.g. push
+	Since time.Time `form:"since" json:"since"` // Received at or after, RFC 3339
+	Until time.Time `form:"until" json:"until"` // Received before, RFC 3339
+}
+
+func (f *DeadEventFilter) scope(db *gorm.DB) *gorm.DB {
+	db = db.Model(&models.WebhookEvent{}).Where("status = ?", models.WebhookEventDead)
+	if f.Class != "" {
+		db = db.Where("failure_class = ?", f.Class)
+	}
+	if f.Event != "" {
+		db = db.Where("event = ?", f.Event)
+	}
+	if !f.Since.IsZero() {
+		db = db.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		db = db.Where("created_at < ?", f.Until)
+	}
+	return db
+}
+
+// GetDeadWebhookEvents lists dead webhook events for admins, newest first,
+// with how many the filter selects
+func (h *WebhookHandler) GetDeadWebhookEvents(c *gin.Context) {
+	var filter DeadEventFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	var total int64
+	if err := filter.scope(database.DB).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count dead webhook events"})
+		return
+	}
+	// Streamed without their payloads, which can be megabytes each
+	listed := 0
+	err = jsonstream.Stream(c, "dead webhook events", jsonstream.Envelope("events", gin.H{"total": total}), func(ctx context.Context, before uint) ([]interface{}, uint, error) {
+		if listed == limit {
+			return nil, 0, nil
+		}
+		query := filter.scope(database.DB.WithContext(ctx)).Omit("payload")
+		if before != 0 {
+			query = query.Where("id < ?", before)
+		}
+		var events []models.WebhookEvent
+		if err := query.Order("id DESC").Limit(min(jsonstream.BatchSize, limit-listed)).Find(&events).Error; err != nil || len(events) == 0 {
+			return nil, 0, err
+		}
+		listed += len(events)
+		rows := make([]interface{}, len(events))
+		for i := range events {
+			rows[i] = &events[i]
+		}
+		return rows, events[len(events)-1].ID, nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dead webhook events"})
+	}
+}
+
+// GetDeadWebhookEvent returns a dead webhook event with its payload, to find
+// out what is wrong with it
+func (h *WebhookHandler) GetDeadWebhookEvent(c *gin.Context) {
+	event, ok := deadEvent(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": event, "payload": string(event.Payload)})
+}
+
+// RetryDeadWebhookEvent processes a dead webhook event again, at once, and
+// returns it as it ends up. Failing again, it is retried maxAttempts times
+// like a new event.
+func (h *WebhookHandler) RetryDeadWebhookEvent(c *gin.Context) {
+	event, ok := deadEvent(c)
+	if !ok {
+		return
+	}
+	if !claimEvent(database.DB.Where("status = ?", models.WebhookEventDead), event, 1) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The webhook event is already being retried"})
+		return
+	}
+	h.processEvent(event)
+	audit.FromContext(c, "webhook.retry", fmt.Sprintf("webhook event %d (%s): %s", event.ID, event.Event, event.Status))
+	c.JSON(http.StatusOK, event)
+}
+
+// RetryDeadWebhookEvents queues the dead webhook events a filter selects for
+// the retries, each retried maxAttempts times like a new event, and returns
+// how many
+func (h *WebhookHandler) RetryDeadWebhookEvents(c *gin.Context) {
+	var filter DeadEventFilter
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	result := filter.scope(database.DB).Updates(map[string]interface{}{
+		"status":          models.WebhookEventFailed,
+		"attempts":        0,
+		"next_attempt_at": h.now(),
+	})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry dead webhook events"})
+		return
+	}
+	h.checkDeadEvents()
+	audit.FromContext(c, "webhook.retry", fmt.Sprintf("%d dead webhook events (class=%q event=%q)", result.RowsAffected, filter.Class, filter.Event))
+	c.JSON(http.StatusOK, gin.H{"retried": result.RowsAffected})
+}
+
+// deadEvent loads the dead event of the id param, or writes the error response
+func deadEvent(c *gin.Context) (*models.WebhookEvent, bool) {
+	eventID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook event ID"})
+		return nil, false
+	}
+	var event models.WebhookEvent
+	if err := database.DB.Where("status = ?", models.WebhookEventDead).First(&event, eventID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead webhook event not found"})
+		return nil, false
+	}
+	return &event, true
+}
@@ -0,0 +1,138 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeployRefRequest asks for a deployment of a branch, tag or commit SHA
+type DeployRefRequest struct {
+	Ref     string `json:"ref" binding:"required"`
+	Promote bool   `json:"promote"` // Deploy to the production hostname instead of a preview one
+}
+
+// HandleDeployRef deploys any ref of a project's GitHub repository on
+// request, e.g. an older commit while bisecting or a branch without an alias.
+// The ref is resolved with the owner's stored GitHub token; the deployment
+// gets a preview hostname of its own unless promoted, and jumps the queue.
+func (h *WebhookHandler) HandleDeployRef(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var req DeployRefRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Ref = strings.TrimSpace(req.Ref)
+
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if project.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if project.RepoOwner == "" || project.RepoName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project is not linked to a GitHub repository"})
+		return
+	}
+
+	var owner models.User
+	if err := database.DB.Select("id", "github_token").First(&owner, project.UserID).Error; err != nil || owner.GitHubToken == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Sign in with GitHub to deploy by ref, no GitHub token is stored for this account"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), httpclient.CallTimeout)
+	defer cancel()
+	resolved, err := h.newRefResolver(owner.GitHubToken).ResolveRef(ctx, project.RepoOwner, project.RepoName, req.Ref)
+	if err != nil {
+		var ambiguous *AmbiguousRefError
+		switch {
+		case errors.As(err, &ambiguous):
+			c.JSON(http.StatusBadRequest, gin.H{"error": ambiguous.Error(), "candidates": ambiguous.Candidates})
+		case errors.Is(err, ErrRefNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "No branch, tag or commit " + req.Ref + " in " + project.RepoOwner + "/" + project.RepoName})
+		case httpclient.IsTimeout(err):
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "GitHub took too long to resolve the ref, try again"})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resolve ref with GitHub: " + err.Error()})
+		}
+		return
+	}
+
+	deployment := &models.Deployment{
+		ProjectID: project.ID,
+		Status:    models.StatusPending,
+		CommitSHA: resolved.SHA,
+		CommitMsg: resolved.Message,
+		Trigger:   models.TriggerManual,
+		Actor:     c.GetString("username"),
+		Target:    models.TargetPreview,
+	}
+	switch resolved.Kind {
+	case RefBranch:
+		deployment.Branch = resolved.Name
+	case RefTag:
+		deployment.Ref = "refs/tags/" + resolved.Name
+	}
+	if req.Promote {
+		deployment.Target = models.TargetProduction
+	}
+	if !h.createDeployment(c, &project, deployment) {
+		return
+	}
+
+	// Hostnames are normally assigned at deploy time; this one is promised now.
+	// Internal projects and workers have none.
+	var host string
+	if h.hostnames != nil && project.Served() {
+		if deployment.Target == models.TargetPreview {
+			host, err = h.hostnames.ReservePreview(&project, deployment.CommitSHA, deployment.ID)
+			if err != nil {
+				models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusFailed, "failed to reserve preview hostname: "+err.Error())
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve preview hostname: " + err.Error()})
+				return
+			}
+			deployment.Hostname = host
+			database.DB.Model(deployment).Update("hostname", host)
+		} else {
+			host = h.hostnames.ProductionHostname(&project)
+		}
+	}
+
+	h.enqueueDeployment(&project, deployment, true)
+	log.Printf("🚀 Manual deployment %d of %s %s (%s) for project %d", deployment.ID, resolved.Kind, resolved.Name, deployment.CommitSHA, project.ID)
+
+	response := gin.H{
+		"message":    "Deployment triggered",
+		"deployment": deployment,
+		"ref":        resolved,
+		"sha":        deployment.CommitSHA,
+		"hostname":   host,
+	}
+	if project.Internal() && !project.Worker() {
+		response["internal_url"] = kubernetes.ServiceURL(kubernetes.ProjectResourceName(project.ID))
+	} else if h.hostnames != nil {
+		response["url"] = h.hostnames.GetFullURL(host)
+		deployment.URL = h.hostnames.GetFullURL(deployment.Hostname)
+	}
+	c.JSON(http.StatusCreated, response)
+}
@@ -0,0 +1,196 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hostname"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeRefResolver resolves refs of acme/app among its branches, tags and
+// commits, as the GitHub API would
+type fakeRefResolver struct {
+	t        *testing.T
+	branches map[string]string // Name -> SHA
+	tags     map[string]string
+	commits  []string
+}
+
+func (f *fakeRefResolver) ResolveRef(ctx context.Context, owner, repo, ref string) (*ResolvedRef, error) {
+	if owner != "acme" || repo != "app" {
+		f.t.Errorf("resolved %s in %s/%s", ref, owner, repo)
+		return nil, ErrRefNotFound
+	}
+	if sha, ok := f.branches[ref]; ok {
+		return &ResolvedRef{Kind: RefBranch, Name: ref, SHA: sha, Message: "Branch head"}, nil
+	}
+	if sha, ok := f.tags[ref]; ok {
+		return &ResolvedRef{Kind: RefTag, Name: ref, SHA: sha, Message: "Release"}, nil
+	}
+	var candidates []RefCandidate
+	for _, sha := range f.commits {
+		if isHex(ref, 4, 40) && strings.HasPrefix(sha, strings.ToLower(ref)) {
+			candidates = append(candidates, RefCandidate{SHA: sha, Message: "Commit " + sha[:7]})
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, ErrRefNotFound
+	case 1:
+		return &ResolvedRef{Kind: RefCommit, Name: ref, SHA: candidates[0].SHA, Message: candidates[0].Message}, nil
+	default:
+		return nil, &AmbiguousRefError{Ref: ref, Candidates: candidates}
+	}
+}
+
+// deployRefSetup is a webhook setup whose handler resolves refs with a
+// fake resolver and reserves hostnames under deploy.example.com
+func deployRefSetup(t *testing.T) *webhookSetup {
+	t.Helper()
+	s := newWebhookSetup(t)
+	database.DB.Model(&models.User{}).Where("id = ?", s.project.UserID).Update("github_token", "gho_ada")
+	s.project.Slug = "app"
+	database.DB.Save(s.project)
+
+	resolver := &fakeRefResolver{
+		t:        t,
+		branches: map[string]string{"main": strings.Repeat("a", 40), "feature/login": strings.Repeat("b", 40)},
+		tags:     map[string]string{"v1.2.0": strings.Repeat("c", 40)},
+		commits: []string{
+			"0123456789abcdef0123456789abcdef01234567",
+			"0123fedcba9876543210fedcba9876543210fedc",
+			"89abcdef0123456789abcdef0123456789abcdef",
+		},
+	}
+	s.handler = s.newHandler(WebhookDeps{
+		Secret:      StaticSecret(testSecret),
+		Projects:    &fakeProjects{project: s.project},
+		Deployments: s.deployments,
+		Queue:       s.queue,
+		Hostnames:   hostname.NewManager(&config.Config{BaseDomain: "deploy.example.com", PublicScheme: "https"}),
+		RefResolver: func(token string) RefResolver {
+			if token != "gho_ada" {
+				t.Errorf("resolving with token %q", token)
+			}
+			return resolver
+		},
+	})
+	return s
+}
+
+// deployRef posts body to the project's deployments as userID
+func (s *webhookSetup) deployRef(userID uint, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/api/projects/:id/deployments", func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("username", "ada")
+	}, s.handler.HandleDeployRef)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/projects/%d/deployments", s.project.ID), strings.NewReader(body)))
+	return w
+}
+
+func TestDeployRef(t *testing.T) {
+	tests := []struct {
+		name, ref      string
+		sha, kind      string
+		branch, gitRef string
+	}{
+		{name: "branch", ref: "feature/login", sha: strings.Repeat("b", 40), kind: RefBranch, branch: "feature/login"},
+		{name: "tag", ref: "v1.2.0", sha: strings.Repeat("c", 40), kind: RefTag, gitRef: "refs/tags/v1.2.0"},
+		{name: "full SHA", ref: "89abcdef0123456789abcdef0123456789abcdef", sha: "89abcdef0123456789abcdef0123456789abcdef", kind: RefCommit},
+		{name: "short SHA", ref: "89ABCDE", sha: "89abcdef0123456789abcdef0123456789abcdef", kind: RefCommit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := deployRefSetup(t)
+			w := s.deployRef(s.project.UserID, fmt.Sprintf(`{"ref": %q}`, tt.ref))
+			if w.Code != http.StatusCreated {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+			body := response(t, w)
+			// The preview hostname of the commit
+			host, _ := body["hostname"].(string)
+			if body["sha"] != tt.sha || !strings.HasSuffix(host, "--"+tt.sha[:7]+".deploy.example.com") || body["url"] != "https://"+host {
+				t.Errorf("responded %v", body)
+			}
+			if ref, _ := body["ref"].(map[string]any); ref["kind"] != tt.kind {
+				t.Errorf("resolved %v, want a %s", body["ref"], tt.kind)
+			}
+
+			if len(s.deployments.created) != 1 {
+				t.Fatalf("created %d deployments", len(s.deployments.created))
+			}
+			d := s.deployments.created[0]
+			if d.CommitSHA != tt.sha || d.Branch != tt.branch || d.Ref != tt.gitRef || d.Trigger != models.TriggerManual || d.Target != models.TargetPreview {
+				t.Errorf("created %+v", d)
+			}
+			var reserved models.Hostname
+			if err := database.DB.Where("hostname = ?", host).First(&reserved).Error; err != nil || reserved.DeploymentID != d.ID || reserved.Type != hostname.TypePreview {
+				t.Errorf("reserved %+v, %v", reserved, err)
+			}
+			if job, ok := s.queue.TryDequeue(); !ok || job.DeploymentID != d.ID || job.Priority != queue.PriorityHigh {
+				t.Errorf("queued %+v", job)
+			}
+		})
+	}
+}
+
+func TestDeployRefPromote(t *testing.T) {
+	s := deployRefSetup(t)
+	w := s.deployRef(s.project.UserID, `{"ref": "main", "promote": true}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	production := hostname.NewManager(&config.Config{BaseDomain: "deploy.example.com"}).ProductionHostname(s.project)
+	if body := response(t, w); body["hostname"] != production || body["url"] != "https://"+production {
+		t.Errorf("responded %v", body)
+	}
+	if d := s.deployments.created[0]; d.Target != models.TargetProduction || d.Branch != "main" {
+		t.Errorf("created %+v", d)
+	}
+}
+
+func TestDeployRefUnresolved(t *testing.T) {
+	s := deployRefSetup(t)
+
+	// 0123 starts two commits: both are listed
+	w := s.deployRef(s.project.UserID, `{"ref": "0123"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("ambiguous: got %d: %s", w.Code, w.Body.String())
+	}
+	candidates, _ := response(t, w)["candidates"].([]any)
+	if len(candidates) != 2 {
+		t.Errorf("candidates %v", candidates)
+	}
+	for _, c := range candidates {
+		if sha, _ := c.(map[string]any)["sha"].(string); !strings.HasPrefix(sha, "0123") || len(sha) != 40 {
+			t.Errorf("candidate %v", c)
+		}
+	}
+
+	for _, ref := range []string{"no-such-branch", "fedcba98"} {
+		if w := s.deployRef(s.project.UserID, fmt.Sprintf(`{"ref": %q}`, ref)); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d: %s", ref, w.Code, w.Body.String())
+		}
+	}
+	if w := s.deployRef(s.project.UserID+1, `{"ref": "main"}`); w.Code != http.StatusForbidden {
+		t.Errorf("another user's project: got %d", w.Code)
+	}
+	if w := s.deployRef(s.project.UserID, `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("no ref: got %d", w.Code)
+	}
+
+	if len(s.deployments.created) != 0 || s.queue.Size() != 0 {
+		t.Errorf("created %d deployments, queued %d", len(s.deployments.created), s.queue.Size())
+	}
+}
@@ -0,0 +1,332 @@
+package github
+
+// Recorded GitHub webhook events: processed when they arrive, retried with
+// backoff when that fails, and left dead for admins after too many attempts
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/metrics"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	retryInterval   = 15 * time.Second // How often due events are looked for
+	retryBatch      = 20               // Events retried per look
+	staleProcessing = 10 * time.Minute // Events processing this long were left by a replica that stopped
+	maxErrorLength  = 1000
+)
+
+// recordedEvent reports whether event is recorded and retried; the others
+// are ignored
+func recordedEvent(event string) bool {
+	switch event {
+	case "push", "delete", "repository", "pull_request":
+		return true
+	}
+	return false
+}
+
+// capturedResponse keeps what the processing of an event answered, to record
+// its outcome
+type capturedResponse struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturedResponse) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// recordEvent records an event being processed for the first time. Failing
+// to record it is logged: the event is processed anyway, only not retried.
+func recordEvent(event, deliveryID string, body []byte) *models.WebhookEvent {
+	record := &models.WebhookEvent{
+		Event:      event,
+		DeliveryID: deliveryID,
+		Payload:    body,
+		Status:     models.WebhookEventProcessing,
+		Attempts:   1,
+	}
+	if err := database.DB.Create(record).Error; err != nil {
+		log.Printf("⚠️  Failed to record %s webhook event %s: %v", event, deliveryID, err)
+		return nil
+	}
+	return record
+}
+
+// classifyResponse tells from the status processing an event answered why it
+// failed, "" when it didn't, and whether retrying it may help. Refusals of
+// the platform's rules (archived project, quotas) are answers, not failures.
+func classifyResponse(status int) (class string, retry bool) {
+	switch {
+	case status < http.StatusBadRequest,
+		status == http.StatusPaymentRequired,
+		status == http.StatusConflict,
+		status == http.StatusTooManyRequests:
+		return "", false
+	case status == http.StatusNotFound:
+		return models.WebhookFailureProjectNotFound, true
+	case status < http.StatusInternalServerError:
+		return models.WebhookFailureMalformed, false
+	default:
+		return models.WebhookFailureInternal, true
+	}
+}
+
+// finishEvent records the outcome of an attempt at processing event: processed,
+// failed and retried after a backoff, or dead once retrying can't help or it
+// was attempted maxAttempts times
+func (h *WebhookHandler) finishEvent(event *models.WebhookEvent, status int, response []byte) {
+	now := h.now()
+	class, retry := classifyResponse(status)
+	updates := map[string]interface{}{"last_status": status, "failure_class": class}
+	switch {
+	case class == "":
+		event.Status = models.WebhookEventProcessed
+		event.NextAttemptAt = nil
+		updates["processed_at"] = now
+		updates["last_error"] = ""
+	case retry && event.Attempts < h.maxAttempts:
+		next := now.Add(h.backoff(event.Attempts))
+		event.Status = models.WebhookEventFailed
+		event.NextAttemptAt = &next
+		updates["last_error"] = responseError(status, response)
+	default:
+		event.Status = models.WebhookEventDead
+		event.NextAttemptAt = nil
+		updates["last_error"] = responseError(status, response)
+	}
+	updates["status"] = event.Status
+	updates["next_attempt_at"] = event.NextAttemptAt
+	if err := database.DB.Model(event).Updates(updates).Error; err != nil {
+		log.Printf("❌ Failed to record the outcome of webhook event %d: %v", event.ID, err)
+		return
+	}
+
+	switch event.Status {
+	case models.WebhookEventFailed:
+		log.Printf("⚠️  Webhook event %d (%s) failed attempt %d/%d with %d, retried at %s", event.ID, event.Event, event.Attempts, h.maxAttempts, status, event.NextAttemptAt.Format(time.RFC3339))
+	case models.WebhookEventDead:
+		log.Printf("❌ Webhook event %d (%s) is dead after %d attempts: %s", event.ID, event.Event, event.Attempts, updates["last_error"])
+		h.deadMu.Lock()
+		h.deadTotal[class]++
+		h.deadMu.Unlock()
+		h.checkDeadEvents()
+	}
+}
+
+// backoff is the wait after a failed attempt: retryBackoff doubled after each
+// attempt, up to retryMaxBackoff
+func (h *WebhookHandler) backoff(attempts int) time.Duration {
+	wait := h.retryBackoff
+	for i := 1; i < attempts && wait < h.retryMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > h.retryMaxBackoff {
+		wait = h.retryMaxBackoff
+	}
+	return wait
+}
+
+// responseError is the error of a failed attempt's response
+func responseError(status int, response []byte) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	message := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	if json.Unmarshal(response, &body) == nil && body.Error != "" {
+		message += ": " + body.Error
+	}
+	return textutil.Truncate(message, maxErrorLength)
+}
+
+// StartRetries retries failed webhook events once due, including
+// those a stopped replica left processing, and deletes old processed ones,
+// until ctx is done. Every replica runs it; an event is claimed by one.
+func (h *WebhookHandler) StartRetries(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(retryInterval)
+		defer ticker.Stop()
+		for {
+			h.retryDueEvents(ctx)
+			h.pruneEvents()
+			h.checkDeadEvents()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("✅ Failed webhook events retried up to %d times, from %s apart", h.maxAttempts, h.retryBackoff)
+}
+
+func (h *WebhookHandler) retryDueEvents(ctx context.Context) {
+	now := h.now()
+	var due []models.WebhookEvent
+	err := database.DB.Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND updated_at < ?)",
+		models.WebhookEventFailed, now, models.WebhookEventProcessing, now.Add(-staleProcessing)).
+		Order("next_attempt_at").
+		Limit(retryBatch).
+		Find(&due).Error
+	if err != nil {
+		log.Printf("⚠️  Failed to look for webhook events to retry: %v", err)
+		return
+	}
+	for i := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		event := &due[i]
+		if !claimEvent(database.DB.Where("status = ? AND attempts = ?", event.Status, event.Attempts), event, event.Attempts+1) {
+			continue // Another replica got it
+		}
+		h.processEvent(event)
+	}
+}
+
+// claimEvent marks event, matched by scope, processing its attempts-th time.
+// It reports false when scope matched nothing: the event changed since read.
+func claimEvent(scope *gorm.DB, event *models.WebhookEvent, attempts int) bool {
+	result := scope.Model(&models.WebhookEvent{}).
+		Where("id = ?", event.ID).
+		Updates(map[string]interface{}{"status": models.WebhookEventProcessing, "attempts": attempts})
+	if result.Error != nil {
+		log.Printf("⚠️  Failed to claim webhook event %d: %v", event.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	event.Status = models.WebhookEventProcessing
+	event.Attempts = attempts
+	return true
+}
+
+// processEvent processes a claimed event again, the way HandleWebhook did
+// when it arrived, and records the outcome
+func (h *WebhookHandler) processEvent(event *models.WebhookEvent) {
+	status, response := h.replayEvent(event)
+	h.finishEvent(event, status, response)
+}
+
+// replayEvent runs the handler of an event without a request of GitHub's,
+// answering into a recorder. A panic is an internal failure.
+func (h *WebhookHandler) replayEvent(event *models.WebhookEvent) (status int, response []byte) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(event.Payload))
+	c.Request.Header.Set("X-GitHub-Event", event.Event)
+	c.Request.Header.Set("X-GitHub-Delivery", event.DeliveryID)
+
+	defer func() {
+		if r := recover(); r != nil {
+			status = http.StatusInternalServerError
+			response, _ = json.Marshal(gin.H{"error": fmt.Sprintf("panic: %v", r)})
+		}
+	}()
+	h.dispatchEvent(c, event.Event, event.Payload)
+	return recorder.Code, recorder.Body.Bytes()
+}
+
+// pruneEvents deletes processed events past their retention; failed and dead
+// ones are kept
+func (h *WebhookHandler) pruneEvents() {
+	result := database.DB.Where("status = ? AND created_at < ?", models.WebhookEventProcessed, h.now().Add(-h.eventRetention)).
+		Delete(&models.WebhookEvent{})
+	if result.Error != nil {
+		log.Printf("⚠️  Failed to delete old webhook events: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("🧹 Deleted %d processed webhook events", result.RowsAffected)
+	}
+}
+
+// checkDeadEvents alerts admins when the dead events reach the threshold, once
+// until they go below it again
+func (h *WebhookHandler) checkDeadEvents() {
+	if h.deadAlertThreshold <= 0 {
+		return
+	}
+	byClass, err := countEvents("failure_class", models.WebhookEventDead)
+	if err != nil {
+		log.Printf("⚠️  Failed to count dead webhook events: %v", err)
+		return
+	}
+	dead := 0
+	for _, count := range byClass {
+		dead += count
+	}
+
+	h.deadMu.Lock()
+	alert := dead >= h.deadAlertThreshold && !h.deadAlerted
+	h.deadAlerted = dead >= h.deadAlertThreshold
+	h.deadMu.Unlock()
+	if alert {
+		text := fmt.Sprintf("🚨 %d GitHub webhook events are dead: their pushes and branch deletions were not processed", dead)
+		log.Print(text)
+		h.alerts.Alert(incidents.Alert{Event: "webhooks.dead", Text: text, Details: gin.H{"dead": dead, "by_class": byClass}})
+	}
+}
+
+// countEvents counts the events in status, "" = any, grouped by column
+func countEvents(column, status string) (map[string]int, error) {
+	var rows []struct {
+		Name  string
+		Count int
+	}
+	query := database.DB.Model(&models.WebhookEvent{}).Select(column + " AS name, COUNT(*) AS count").Group(column)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Name] = row.Count
+	}
+	return counts, nil
+}
+
+// RegisterMetrics exposes recorded webhook events by status, dead ones
+// by failure class, and how many went dead since start
+func (h *WebhookHandler) RegisterMetrics() {
+	fromDatabase := func(column, status, label string) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			counts, err := countEvents(column, status)
+			if err != nil {
+				return nil
+			}
+			samples := make([]metrics.Sample, 0, len(counts))
+			for key, count := range counts {
+				samples = append(samples, metrics.Sample{Labels: map[string]string{label: key}, Value: float64(count)})
+			}
+			return samples
+		}
+	}
+	metrics.RegisterGauge("deploy_webhook_events", "Recorded GitHub webhook events, by status", fromDatabase("status", "", "status"))
+	metrics.RegisterGauge("deploy_webhook_events_dead", "Dead GitHub webhook events, by failure class", fromDatabase("failure_class", models.WebhookEventDead, "class"))
+	metrics.RegisterGauge("deploy_webhook_events_dead_total", "GitHub webhook events gone dead since start, by failure class", func() []metrics.Sample {
+		h.deadMu.Lock()
+		defer h.deadMu.Unlock()
+		samples := make([]metrics.Sample, 0, len(h.deadTotal))
+		for class, count := range h.deadTotal {
+			samples = append(samples, metrics.Sample{Labels: map[string]string{"class": class}, Value: float64(count)})
+		}
+		return samples
+	})
+}
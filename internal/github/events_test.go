@@ -0,0 +1,373 @@
+package github
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/models"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failingDeployments fails to store deployments, or panics doing so
+type failingDeployments struct {
+	fakeDeployments
+	panics bool
+}
+
+func (f *failingDeployments) Create(deployment *models.Deployment) error {
+	if f.panics {
+		panic("deployments table gone")
+	}
+	return errors.New("database is locked")
+}
+
+// retrySetup is a webhook setup retrying failed events 3 times, 1m then 2m
+// apart, on a clock the test moves, alerting alerts of 2 dead events
+type retrySetup struct {
+	*webhookSetup
+	projects *fakeProjects
+	now      time.Time
+	alerts   chan incidents.Alert
+}
+
+func newRetrySetup(t *testing.T) *retrySetup {
+	t.Helper()
+	s := &retrySetup{webhookSetup: newWebhookSetup(t), now: time.Now(), alerts: make(chan incidents.Alert, 4)}
+	s.projects = &fakeProjects{project: s.project}
+	alerts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert incidents.Alert
+		json.NewDecoder(r.Body).Decode(&alert)
+		s.alerts <- alert
+	}))
+	t.Cleanup(alerts.Close)
+	s.handler = NewWebhookHandler(&config.Config{
+		WebhookMaxAttempts:        3,
+		WebhookRetryBackoff:       time.Minute,
+		WebhookRetryMaxBackoff:    2 * time.Minute,
+		WebhookDeadAlertThreshold: 2,
+		WebhookEventRetention:     24 * time.Hour,
+		AdminAlertWebhook:         alerts.URL,
+	}, WebhookDeps{
+		Secret:      StaticSecret(testSecret),
+		Projects:    s.projects,
+		Deployments: s.deployments,
+		Queue:       s.queue,
+		Clock:       func() time.Time { return s.now },
+	})
+	t.Cleanup(s.handler.reports.Wait)
+	return s
+}
+
+// deliverRaw sends a signed GitHub event with body as is
+func (s *retrySetup) deliverRaw(event string, body []byte) *httptest.ResponseRecorder {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write(body)
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", fmt.Sprintf("delivery-%d", len(body)))
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return s.serve(req)
+}
+
+// event reads back the recorded event of id
+func (s *retrySetup) event(id uint) models.WebhookEvent {
+	s.t.Helper()
+	var event models.WebhookEvent
+	if err := database.DB.First(&event, id).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return event
+}
+
+// lastEvent is the event recorded last
+func (s *retrySetup) lastEvent() models.WebhookEvent {
+	s.t.Helper()
+	var event models.WebhookEvent
+	if err := database.DB.Order("id DESC").First(&event).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return event
+}
+
+// alerted waits for the alert posted to admins
+func (s *retrySetup) alerted() incidents.Alert {
+	s.t.Helper()
+	select {
+	case alert := <-s.alerts:
+		return alert
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("admins not alerted")
+		return incidents.Alert{}
+	}
+}
+
+// admin serves the admin endpoints of dead events
+func (s *retrySetup) admin(method, path, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", s.project.UserID) })
+	r.GET("/webhooks/dead", s.handler.GetDeadWebhookEvents)
+	r.GET("/webhooks/dead/:id", s.handler.GetDeadWebhookEvent)
+	r.POST("/webhooks/dead/retry", s.handler.RetryDeadWebhookEvents)
+	r.POST("/webhooks/dead/:id/retry", s.handler.RetryDeadWebhookEvent)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestClassifyResponse(t *testing.T) {
+	tests := []struct {
+		status int
+		class  string
+		retry  bool
+	}{
+		{http.StatusOK, "", false},
+		{http.StatusAccepted, "", false},
+		{http.StatusPaymentRequired, "", false}, // Over quota
+		{http.StatusConflict, "", false},        // Archived project
+		{http.StatusTooManyRequests, "", false},
+		{http.StatusNotFound, models.WebhookFailureProjectNotFound, true},
+		{http.StatusBadRequest, models.WebhookFailureMalformed, false},
+		{http.StatusUnprocessableEntity, models.WebhookFailureMalformed, false},
+		{http.StatusInternalServerError, models.WebhookFailureInternal, true},
+		{http.StatusServiceUnavailable, models.WebhookFailureInternal, true},
+	}
+	for _, tt := range tests {
+		if class, retry := classifyResponse(tt.status); class != tt.class || retry != tt.retry {
+			t.Errorf("%d: got %q, %v, want %q, %v", tt.status, class, retry, tt.class, tt.retry)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	h := &WebhookHandler{retryBackoff: 30 * time.Second, retryMaxBackoff: 5 * time.Minute}
+	for attempts, want := range map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		3: 2 * time.Minute,
+		4: 4 * time.Minute,
+		5: 5 * time.Minute,
+		9: 5 * time.Minute,
+	} {
+		if got := h.backoff(attempts); got != want {
+			t.Errorf("after %d attempts: %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// An event for a project deleted mid-processing is retried with backoff,
+// goes dead after the last attempt, and processes once an admin retries it
+// with the project back
+func TestDeadLetterLifecycle(t *testing.T) {
+	s := newRetrySetup(t)
+	s.projects.project = nil
+
+	if w := s.deliver("push", pushPayload("refs/heads/main")); w.Code != http.StatusNotFound {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	id := s.lastEvent().ID
+	expect := func(attempt int, status string, next time.Duration) {
+		t.Helper()
+		event := s.event(id)
+		if event.Status != status || event.Attempts != attempt || event.FailureClass != models.WebhookFailureProjectNotFound || event.LastStatus != http.StatusNotFound {
+			t.Fatalf("attempt %d: %+v", attempt, event)
+		}
+		if event.LastError != "404 Not Found: Project not found for repository" {
+			t.Errorf("attempt %d: last error %q", attempt, event.LastError)
+		}
+		if next == 0 && event.NextAttemptAt != nil || next != 0 && (event.NextAttemptAt == nil || !event.NextAttemptAt.Equal(s.now.Add(next))) {
+			t.Errorf("attempt %d: next attempt at %v, want in %s", attempt, event.NextAttemptAt, next)
+		}
+	}
+	expect(1, models.WebhookEventFailed, time.Minute)
+
+	// Not retried before it is due, e.g. by a replica starting
+	s.handler.retryDueEvents(t.Context())
+	expect(1, models.WebhookEventFailed, time.Minute)
+
+	s.now = s.now.Add(time.Minute)
+	s.handler.retryDueEvents(t.Context())
+	expect(2, models.WebhookEventFailed, 2*time.Minute)
+	s.now = s.now.Add(2 * time.Minute)
+	s.handler.retryDueEvents(t.Context())
+	expect(3, models.WebhookEventDead, 0)
+
+	// Dead, it stays so
+	s.now = s.now.Add(time.Hour)
+	s.handler.retryDueEvents(t.Context())
+	expect(3, models.WebhookEventDead, 0)
+	if s.handler.deadTotal[models.WebhookFailureProjectNotFound] != 1 {
+		t.Errorf("dead counted %v", s.handler.deadTotal)
+	}
+
+	// Listed, readable with its payload, and retried at once
+	w := s.admin(http.MethodGet, "/webhooks/dead", "")
+	var listed struct {
+		Total  int                   `json:"total"`
+		Events []models.WebhookEvent `json:"events"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if listed.Total != 1 || len(listed.Events) != 1 || listed.Events[0].ID != id {
+		t.Fatalf("listed %s", w.Body.String())
+	}
+	if w := s.admin(http.MethodGet, fmt.Sprintf("/webhooks/dead/%d", id), ""); !strings.Contains(w.Body.String(), `refs/heads/main`) {
+		t.Errorf("dead event %s", w.Body.String())
+	}
+	s.projects.project = s.project
+	w = s.admin(http.MethodPost, fmt.Sprintf("/webhooks/dead/%d/retry", id), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry: got %d: %s", w.Code, w.Body.String())
+	}
+	event := s.event(id)
+	if event.Status != models.WebhookEventProcessed || event.Attempts != 1 || event.FailureClass != "" || event.LastError != "" || event.ProcessedAt == nil {
+		t.Errorf("retried: %+v", event)
+	}
+	if len(s.deployments.created) != 1 {
+		t.Errorf("retry created %d deployments", len(s.deployments.created))
+	}
+	if w := s.admin(http.MethodPost, fmt.Sprintf("/webhooks/dead/%d/retry", id), ""); w.Code != http.StatusNotFound {
+		t.Errorf("retrying a processed event: got %d", w.Code)
+	}
+	var logged int64
+	database.DB.Model(&models.AuditLog{}).Where("action = ?", "webhook.retry").Count(&logged)
+	if logged != 1 {
+		t.Errorf("%d retries audited", logged)
+	}
+}
+
+// Malformed payloads go dead at once: retrying them can't help
+func TestDeadLetterMalformed(t *testing.T) {
+	s := newRetrySetup(t)
+	if w := s.deliverRaw("push", []byte(`{"ref": 42}`)); w.Code != http.StatusBadRequest {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	event := s.lastEvent()
+	if event.Status != models.WebhookEventDead || event.Attempts != 1 || event.FailureClass != models.WebhookFailureMalformed || event.NextAttemptAt != nil {
+		t.Errorf("malformed: %+v", event)
+	}
+	if !strings.HasPrefix(event.LastError, "400 Bad Request: Failed to parse webhook") {
+		t.Errorf("last error %q", event.LastError)
+	}
+
+	// Refusals of the platform's rules are answers: archived projects
+	s.project.ArchivedAt = &s.now
+	w := s.deliver("push", pushPayload("refs/heads/main"))
+	if event := s.lastEvent(); event.Status != models.WebhookEventProcessed || event.LastStatus != w.Code {
+		t.Errorf("archived project (%d): %+v", w.Code, event)
+	}
+}
+
+// Internal failures, including a panic while retrying, are retried then
+// dead with their class
+func TestDeadLetterInternal(t *testing.T) {
+	s := newRetrySetup(t)
+	failing := &failingDeployments{}
+	s.handler.deployments = failing
+	if w := s.deliver("push", pushPayload("refs/heads/main")); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	id := s.lastEvent().ID
+	if event := s.event(id); event.Status != models.WebhookEventFailed || event.FailureClass != models.WebhookFailureInternal {
+		t.Fatalf("first attempt: %+v", event)
+	}
+
+	failing.panics = true
+	for i := 0; i < 2; i++ {
+		s.now = s.now.Add(2 * time.Minute)
+		s.handler.retryDueEvents(t.Context())
+	}
+	event := s.event(id)
+	if event.Status != models.WebhookEventDead || event.Attempts != 3 || event.FailureClass != models.WebhookFailureInternal {
+		t.Fatalf("after retries: %+v", event)
+	}
+	if event.LastError != "500 Internal Server Error: panic: deployments table gone" {
+		t.Errorf("last error %q", event.LastError)
+	}
+}
+
+// Admins are alerted once when dead events reach the threshold, again only
+// after they went below it; bulk retries select by filter
+func TestDeadLetterAlertAndBulkRetry(t *testing.T) {
+	s := newRetrySetup(t)
+	s.deliverRaw("push", []byte(`{"ref": 1}`))
+	select {
+	case alert := <-s.alerts:
+		t.Fatalf("alerted below the threshold: %+v", alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.deliverRaw("push", []byte(`{"ref": 22}`))
+	alert := s.alerted()
+	if alert.Event != "webhooks.dead" || !strings.HasPrefix(alert.Text, "🚨 2 GitHub webhook events are dead") {
+		t.Errorf("alert %+v", alert)
+	}
+	s.deliverRaw("delete", []byte(`{"ref": 333}`))
+	s.handler.checkDeadEvents()
+	select {
+	case alert := <-s.alerts:
+		t.Fatalf("alerted again: %+v", alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Filtered by event: the delete stays dead
+	w := s.admin(http.MethodPost, "/webhooks/dead/retry", `{"class": "malformed", "event": "push"}`)
+	if w.Code != http.StatusOK || response(t, w)["retried"] != float64(2) {
+		t.Fatalf("bulk retry: %d %s", w.Code, w.Body.String())
+	}
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	database.DB.Model(&models.WebhookEvent{}).Select("status, COUNT(*) AS count").Group("status").Order("status").Scan(&counts)
+	if fmt.Sprint(counts) != "[{dead 1} {failed 2}]" {
+		t.Errorf("after bulk retry: %v", counts)
+	}
+	// Queued for the retries, now due; malformed, they are dead again
+	s.handler.retryDueEvents(t.Context())
+	if w := s.admin(http.MethodGet, "/webhooks/dead?event=push", ""); response(t, w)["total"] != float64(2) {
+		t.Errorf("dead pushes: %s", w.Body.String())
+	}
+	// Below the threshold in between, so alerted again
+	if alert := s.alerted(); !strings.HasPrefix(alert.Text, "🚨 2 GitHub webhook events are dead") {
+		t.Errorf("second alert %+v", alert)
+	}
+}
+
+// Events a stopped replica left processing are picked up once stale;
+// processed ones are pruned after their retention
+func TestStaleAndPrunedEvents(t *testing.T) {
+	s := newRetrySetup(t)
+	s.deliver("push", pushPayload("refs/heads/main"))
+	processed := s.lastEvent()
+	stale := &models.WebhookEvent{Event: "push", Payload: processed.Payload, Status: models.WebhookEventProcessing, Attempts: 1}
+	database.DB.Create(stale)
+
+	s.handler.retryDueEvents(t.Context())
+	if event := s.event(stale.ID); event.Status != models.WebhookEventProcessing {
+		t.Fatalf("taken over while fresh: %+v", event)
+	}
+	s.now = s.now.Add(staleProcessing + time.Minute)
+	s.handler.retryDueEvents(t.Context())
+	if event := s.event(stale.ID); event.Status != models.WebhookEventProcessed || event.Attempts != 2 {
+		t.Errorf("stale event: %+v", event)
+	}
+
+	s.now = s.now.Add(24 * time.Hour)
+	s.handler.pruneEvents()
+	var left int64
+	database.DB.Model(&models.WebhookEvent{}).Count(&left)
+	if left != 0 {
+		t.Errorf("%d processed events left", left)
+	}
+}
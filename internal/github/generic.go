@@ -0,0 +1,65 @@
+package github
+
+import (
+	"deploy-platform/internal/textutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GenericPushRequest is the body of POST /webhooks/generic/:projectToken, for
+// Git servers without GitHub-style webhooks (Gitea, bare repositories with a
+// post-receive hook, CI jobs)
+type GenericPushRequest struct {
+	Ref     string `json:"ref" binding:"required"` // Branch name or refs/heads/<branch>
+	SHA     string `json:"sha" binding:"required"` // Commit to deploy
+	Message string `json:"message"`
+	Pusher  string `json:"pusher"`
+}
+
+// HandleGenericWebhook deploys a commit to the project the token belongs to.
+// It shares the build queue wiring with the GitHub webhook.
+func (h *WebhookHandler) HandleGenericWebhook(c *gin.Context) {
+	token := c.Param("projectToken")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid project token"})
+		return
+	}
+
+	project, err := h.projects.FindByWebhookToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid project token"})
+		return
+	}
+
+	var req GenericPushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sha, err := textutil.NormalizeSHA(strings.TrimSpace(req.SHA))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": InvalidCommitSHA})
+		return
+	}
+	branch := strings.TrimPrefix(strings.TrimSpace(req.Ref), "refs/heads/")
+	if branch == "" || strings.HasPrefix(branch, "refs/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ref must be a branch"})
+		return
+	}
+
+	log.Printf("📨 Generic webhook for project %d: %s@%s pushed by %q", project.ID, branch, sha, req.Pusher)
+	push := &pushedCommit{SHA: sha, Message: req.Message, Branch: branch, Actor: strings.TrimSpace(req.Pusher)}
+	if ignoredBranch(project, branch) {
+		c.JSON(http.StatusOK, gin.H{"message": "branch ignored", "branch": branch})
+		return
+	}
+	if project.AnalysisOnly {
+		h.analyzePush(c, project, push)
+		return
+	}
+	h.triggerDeployment(c, project, push)
+}
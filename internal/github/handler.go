@@ -0,0 +1,201 @@
+package github
+
+import (
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hostname"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/incidents"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"deploy-platform/internal/secrets"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v56/github"
+)
+
+// SecretProvider returns the secret webhook signatures are verified with. It
+// is asked on every delivery, so a rotated secret applies at once.
+type SecretProvider func() string
+
+// StaticSecret provides secret, or the development secret when it is empty
+func StaticSecret(secret string) SecretProvider {
+	if secret == "" {
+		secret = config.DevWebhookSecret
+	}
+	return func() string { return secret }
+}
+
+// ProjectRepository finds the projects webhooks deploy
+type ProjectRepository interface {
+	// FindByRepo finds the project of a webhook's repository, see findRepoProject
+	FindByRepo(repo RepoIdentity, previous ...RepoIdentity) (*models.Project, error)
+	// FindByWebhookToken finds the project a generic webhook token belongs to
+	FindByWebhookToken(token string) (*models.Project, error)
+}
+
+// DeploymentRepository stores the deployments webhooks trigger
+type DeploymentRepository interface {
+	Create(deployment *models.Deployment) error
+	SetStatus(id uint, status models.DeploymentStatus, reason string) error
+}
+
+// WebhookDeps are what a WebhookHandler works with. Nil repositories use the
+// database, a nil clock time.Now, nil factories the GitHub API; the others
+// are optional and their features are skipped without them.
+type WebhookDeps struct {
+	Secret      SecretProvider // nil = the config's WEBHOOK_SECRET
+	Projects    ProjectRepository
+	Deployments DeploymentRepository
+	Queue       queue.BuildQueue // Without one deployments are built directly by Builds
+	Clock       func() time.Time
+
+	Builds    *build.Service
+	Workers   *queue.WorkerPool // Cancels the builds newer deployments supersede
+	Hostnames *hostname.Manager
+	Clusters  *kubernetes.ClientSet
+	Analyzer  *build.Analyzer // Analyzes pushes to projects in analysis-only mode
+	Checks    CheckRunner     // Reports builds as check runs, nil = as commit statuses with the owner's token
+
+	// Built with a user's stored GitHub token, replaceable so GitHub can be faked
+	Commenter   func(token string) Commenter
+	RefResolver func(token string) RefResolver
+	Statuses    func(token string) StatusPoster
+	Verifier    func(token string) CommitVerifier
+}
+
+// WebhookHandler serves GitHub and generic webhooks, and the requests
+// deploying refs, moving projects between clusters, deciding on approvals
+// and retrying dead webhook events, which share their deployment wiring
+type WebhookHandler struct {
+	secret      SecretProvider
+	projects    ProjectRepository
+	deployments DeploymentRepository
+	queue       queue.BuildQueue
+	now         func() time.Time
+
+	builds    *build.Service
+	workers   *queue.WorkerPool
+	hostnames *hostname.Manager
+	clusters  *kubernetes.ClientSet
+	analyzer  *build.Analyzer
+	checks    CheckRunner
+	baseURL   string // Commit statuses and check runs link to the dashboard
+
+	newCommenter      func(token string) Commenter
+	newRefResolver    func(token string) RefResolver
+	newStatusPoster   func(token string) StatusPoster
+	newCommitVerifier func(token string) CommitVerifier
+
+	// Retries of failed events
+	maxAttempts        int
+	retryBackoff       time.Duration
+	retryMaxBackoff    time.Duration
+	deadAlertThreshold int
+	eventRetention     time.Duration
+	alerts             *incidents.Notifier
+
+	deadMu      sync.Mutex
+	deadTotal   map[string]int // Events gone dead since start, by failure class
+	deadAlerted bool           // Admins were alerted and the dead events haven't gone below the threshold since
+
+	pullRequestLocks sync.Map // "<project>/<number>" -> *sync.Mutex, serializing comment updates
+	deploymentLocks  sync.Map // Deployment ID -> *sync.Mutex, serializing build reports
+
+	reports sync.WaitGroup // Build reports and pull request comments posted after the response
+}
+
+// NewWebhookHandler creates the webhook handler, its event retries
+// configured from cfg
+func NewWebhookHandler(cfg *config.Config, deps WebhookDeps) *WebhookHandler {
+	h := &WebhookHandler{
+		secret:             deps.Secret,
+		projects:           deps.Projects,
+		deployments:        deps.Deployments,
+		queue:              deps.Queue,
+		now:                deps.Clock,
+		builds:             deps.Builds,
+		workers:            deps.Workers,
+		hostnames:          deps.Hostnames,
+		clusters:           deps.Clusters,
+		analyzer:           deps.Analyzer,
+		checks:             deps.Checks,
+		baseURL:            cfg.BaseURL,
+		newCommenter:       deps.Commenter,
+		newRefResolver:     deps.RefResolver,
+		newStatusPoster:    deps.Statuses,
+		newCommitVerifier:  deps.Verifier,
+		maxAttempts:        cfg.WebhookMaxAttempts,
+		retryBackoff:       cfg.WebhookRetryBackoff,
+		retryMaxBackoff:    cfg.WebhookRetryMaxBackoff,
+		deadAlertThreshold: cfg.WebhookDeadAlertThreshold,
+		eventRetention:     cfg.WebhookEventRetention,
+		alerts:             incidents.NewNotifier(cfg.AdminAlertWebhook),
+		deadTotal:          make(map[string]int),
+	}
+	if h.secret == nil {
+		h.secret = StaticSecret(cfg.WebhookSecret)
+	}
+	if h.projects == nil {
+		h.projects = dbProjects{}
+	}
+	if h.deployments == nil {
+		h.deployments = dbDeployments{}
+	}
+	if h.now == nil {
+		h.now = time.Now
+	}
+	if h.newCommenter == nil || h.newRefResolver == nil || h.newStatusPoster == nil || h.newCommitVerifier == nil {
+		client := httpclient.New("github")
+		if h.newCommenter == nil {
+			h.newCommenter = func(token string) Commenter {
+				return &apiCommenter{client: github.NewClient(client).WithAuthToken(token)}
+			}
+		}
+		if h.newRefResolver == nil {
+			h.newRefResolver = func(token string) RefResolver {
+				return &apiRefResolver{client: github.NewClient(client).WithAuthToken(token)}
+			}
+		}
+		if h.newStatusPoster == nil {
+			h.newStatusPoster = func(token string) StatusPoster {
+				return &apiStatusPoster{client: github.NewClient(client).WithAuthToken(token)}
+			}
+		}
+		if h.newCommitVerifier == nil {
+			h.newCommitVerifier = func(token string) CommitVerifier {
+				return &apiCommitVerifier{client: github.NewClient(client).WithAuthToken(token)}
+			}
+		}
+	}
+	return h
+}
+
+// dbProjects finds projects in the database
+type dbProjects struct{}
+
+func (dbProjects) FindByRepo(repo RepoIdentity, previous ...RepoIdentity) (*models.Project, error) {
+	return findRepoProject(repo, previous...)
+}
+
+func (dbProjects) FindByWebhookToken(token string) (*models.Project, error) {
+	var project models.Project
+	if err := database.DB.Where("webhook_token_hash = ?", secrets.HashToken(token)).First(&project).Error; err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// dbDeployments stores deployments in the database
+type dbDeployments struct{}
+
+func (dbDeployments) Create(deployment *models.Deployment) error {
+	return database.DB.Create(deployment).Error
+}
+
+func (dbDeployments) SetStatus(id uint, status models.DeploymentStatus, reason string) error {
+	return models.SetDeploymentStatus(database.DB, id, status, reason)
+}
@@ -0,0 +1,141 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// MigrateClusterRequest moves a project to another cluster
+type MigrateClusterRequest struct {
+	Cluster string `json:"cluster" binding:"required"`
+}
+
+// HandleMigrateCluster moves a project to another Kubernetes cluster: its
+// live production commit is redeployed there, and once that deployment is
+// live the project's resources on the old cluster are torn down. Branch and
+// preview deployments are not moved; their next deployment goes to the new
+// cluster. Hostnames are re-created when the clusters' base domains differ.
+func (h *WebhookHandler) HandleMigrateCluster(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var req MigrateClusterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if project.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if !h.clusters.Has(req.Cluster) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("unknown cluster %q, available: %s", req.Cluster, strings.Join(h.clusters.Names(), ", "))})
+		return
+	}
+	from := h.clusters.ClusterOf(&project)
+	if req.Cluster == from {
+		c.JSON(http.StatusConflict, gin.H{"error": "The project already deploys to cluster " + from})
+		return
+	}
+	if project.MigratingFrom != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "The project is still moving away from cluster " + project.MigratingFrom + ", wait for its production deployment to finish"})
+		return
+	}
+	var inProgress int64
+	database.DB.Model(&models.Deployment{}).
+		Where("project_id = ? AND status IN ?", projectID, []models.DeploymentStatus{models.StatusPending, models.StatusQueued, models.StatusBuilding, models.StatusAwaitingApproval, models.StatusPolicyBlocked, models.StatusDeploying, models.StatusDeployPending}).
+		Count(&inProgress)
+	if inProgress > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A deployment of the project is in progress, try again once it finishes"})
+		return
+	}
+
+	moved := project
+	moved.Cluster = req.Cluster
+	domainChanged := h.hostnames != nil && h.hostnames.Domain(&project) != h.hostnames.Domain(&moved)
+
+	// Redeploy what is live now; with nothing live there's nothing to move
+	var live models.Deployment
+	hasLive := database.DB.Where("project_id = ? AND k8s_deployment_name = ? AND status = ?", project.ID, kubernetes.ProjectResourceName(project.ID), models.StatusDeployed).
+		Order("id DESC").First(&live).Error == nil
+	var deployment *models.Deployment
+	if hasLive {
+		deployment = &models.Deployment{
+			ProjectID:       project.ID,
+			Status:          models.StatusPending,
+			CommitSHA:       live.CommitSHA,
+			CommitMsg:       live.CommitMsg,
+			CommitMsgDetail: live.CommitMsgDetail,
+			Branch:          live.Branch,
+			Ref:             live.Ref,
+			Trigger:         models.TriggerManual,
+			Actor:           c.GetString("username"),
+			Target:          models.TargetProduction,
+		}
+		if !h.createDeployment(c, &project, deployment) {
+			return
+		}
+		moved.MigratingFrom = from
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&project).Select("cluster", "migrating_from").Updates(&moved).Error; err != nil {
+			return err
+		}
+		// Hostnames under the old domain can't serve the new cluster
+		if domainChanged {
+			return tx.Where("project_id = ?", project.ID).Delete(&models.Hostname{}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		if deployment != nil {
+			models.SetDeploymentStatus(database.DB, deployment.ID, models.StatusFailed, "failed to move the project: "+err.Error())
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move the project"})
+		return
+	}
+	audit.FromContext(c, "project.cluster", fmt.Sprintf("project %d: %s -> %s", project.ID, from, req.Cluster))
+
+	if deployment == nil {
+		if old := h.clusters.Client(from); old != nil {
+			if err := old.DeleteProjectResources(context.Background(), project.ID); err != nil {
+				log.Printf("⚠️  Project %d: failed to tear down cluster %s: %v", project.ID, from, err)
+			}
+		}
+		log.Printf("🚚 Project %d moved from cluster %s to %s", project.ID, from, req.Cluster)
+		c.JSON(http.StatusOK, gin.H{"message": "Project moved, its next deployment goes to cluster " + req.Cluster, "cluster": req.Cluster})
+		return
+	}
+
+	h.enqueueDeployment(&moved, deployment, true)
+	log.Printf("🚚 Project %d moving from cluster %s to %s with deployment %d", project.ID, from, req.Cluster, deployment.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":        "Redeploying on cluster " + req.Cluster + ", cluster " + from + " is torn down once it is live",
+		"cluster":        req.Cluster,
+		"migrating_from": from,
+		"deployment":     deployment,
+	})
+}
@@ -9,10 +9,16 @@ import (
 	"deploy-platform/internal/auth"
 	"deploy-platform/internal/config"
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/githubscopes"
+	"deploy-platform/internal/httpclient"
 	"deploy-platform/internal/models"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-github/v56/github"
@@ -20,61 +26,85 @@ import (
 	githubOAuth "golang.org/x/oauth2/github"
 )
 
-var oauthConfig *oauth2.Config
+// OAuthHandler signs users in with GitHub
+type OAuthHandler struct {
+	config *oauth2.Config
+	http   *http.Client // Carries every call to GitHub's OAuth endpoints and API
+}
 
-func InitOAuth(cfg *config.Config) {
-	oauthConfig = &oauth2.Config{
+// NewOAuthHandler creates the GitHub sign-in handler of the OAuth app cfg
+// configures
+func NewOAuthHandler(cfg *config.Config) *OAuthHandler {
+	oauthConfig := &oauth2.Config{
 		ClientID:     cfg.GitHubClientID,
 		ClientSecret: cfg.GitHubClientSecret,
 		RedirectURL:  cfg.GitHubCallbackURL,
 
-		Scopes:   []string{"repo", "user:email"},
+		Scopes:   cfg.GitHubScopes,
 		Endpoint: githubOAuth.Endpoint,
 	}
+	if len(oauthConfig.Scopes) == 0 {
+		oauthConfig.Scopes = githubscopes.Default
+	}
+	// Org membership checks need to see private memberships too
+	if len(cfg.AllowedGitHubOrgs) > 0 && !githubscopes.Has(oauthConfig.Scopes, githubscopes.ReadOrg) {
+		oauthConfig.Scopes = append(oauthConfig.Scopes, githubscopes.ReadOrg)
+	}
+	return &OAuthHandler{config: oauthConfig, http: httpclient.New("github")}
 }
 
 // HandleGitHubLogin initiates OAuth flow
-func HandleGitHubLogin(c *gin.Context) {
+func (h *OAuthHandler) HandleGitHubLogin(c *gin.Context) {
 	state := generateState()
-	c.SetCookie("oauth_state", state, 600, "/", "", false, true)
+	auth.SetCookie(c, "oauth_state", state, 600)
+	auth.RememberNext(c)
 
-	url := oauthConfig.AuthCodeURL(state)
+	url := h.config.AuthCodeURL(state)
 	c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
 // HandleGitHubCallback handles OAuth callback (fixed function name)
-func HandleGitHubCallback(c *gin.Context) {
+func (h *OAuthHandler) HandleGitHubCallback(c *gin.Context) {
+	if auth.LoginProviderError(c, "GitHub", "/auth/github") {
+		return
+	}
+
 	state := c.Query("state")
 	cookieState, _ := c.Cookie("oauth_state")
 
-	if state != cookieState {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+	if state == "" || state != cookieState {
+		auth.LoginError(c, auth.LoginExpired, "GitHub", "/auth/github", errors.New("invalid state"))
 		return
 	}
 
 	code := c.Query("code")
 	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code not provided"})
+		auth.LoginError(c, auth.LoginExpired, "GitHub", "/auth/github", errors.New("authorization code not provided"))
 		return
 	}
 
-	token, err := oauthConfig.Exchange(context.Background(), code)
+	// The user is waiting: every call to GitHub shares one budget
+	ctx, cancel := context.WithTimeout(c.Request.Context(), httpclient.CallTimeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, h.http)
+
+	token, err := h.config.Exchange(ctx, code)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code for token: " + err.Error()})
+		auth.LoginFailed(c, "GitHub", "/auth/github", fmt.Errorf("exchanging the code for a token: %w", err))
 		return
 	}
 
 	// Get user info from GitHub
-	client := github.NewClient(oauthConfig.Client(context.Background(), token))
-	user, _, err := client.Users.Get(context.Background(), "")
+	client := github.NewClient(h.config.Client(ctx, token))
+	user, resp, err := client.Users.Get(ctx, "")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info: " + err.Error()})
+		auth.LoginFailed(c, "GitHub", "/auth/github", fmt.Errorf("getting user info: %w", err))
 		return
 	}
 
 	// Handle nil pointers safely
 	if user.ID == nil || user.Login == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user data from GitHub"})
+		auth.LoginFailed(c, "GitHub", "/auth/github", errors.New("invalid user data from GitHub"))
 		return
 	}
 
@@ -84,7 +114,7 @@ func HandleGitHubCallback(c *gin.Context) {
 		email = *user.Email
 	} else {
 		// Try to get email from user emails endpoint
-		emails, _, err := client.Users.ListEmails(context.Background(), nil)
+		emails, _, err := client.Users.ListEmails(ctx, nil)
 		if err == nil && len(emails) > 0 {
 			for _, e := range emails {
 				if e.Primary != nil && *e.Primary {
@@ -103,8 +133,31 @@ func HandleGitHubCallback(c *gin.Context) {
 		avatarURL = *user.AvatarURL
 	}
 
-	// Create or update user in database
+	// The user may have granted fewer scopes than requested
+	granted := grantedScopes(resp, token)
+
+	// Enforce ALLOWED_GITHUB_ORGS (existing users only with STRICT_REVALIDATE)
 	githubID := int64(*user.ID)
+	if len(auth.AllowedGitHubOrgs()) > 0 {
+		if !githubscopes.Has(granted, githubscopes.ReadOrg) {
+			log.Printf("⚠️ %s did not grant %s, only public org memberships are checked", *user.Login, githubscopes.ReadOrg)
+		}
+		memberOf, err := listUserOrgs(ctx, client)
+		if err != nil {
+			auth.LoginFailed(c, "GitHub", "/auth/github", fmt.Errorf("checking organization membership: %w", err))
+			return
+		}
+		if allowErr := auth.CheckGitHubOrgs(memberOf); allowErr != nil {
+			var existing int64
+			database.DB.Model(&models.User{}).Where("github_id = ?", githubID).Count(&existing)
+			if existing == 0 || auth.StrictRevalidate() {
+				auth.LoginDenied(c, *user.Login, allowErr)
+				return
+			}
+		}
+	}
+
+	// Create or update user in database
 	dbUser := &models.User{
 		GitHubID:  &githubID,
 		Username:  *user.Login,
@@ -114,25 +167,117 @@ func HandleGitHubCallback(c *gin.Context) {
 
 	result := database.DB.Where("github_id = ?", *dbUser.GitHubID).FirstOrCreate(dbUser, models.User{GitHubID: dbUser.GitHubID})
 	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + result.Error.Error()})
+		auth.LoginError(c, auth.LoginInternal, "GitHub", "/auth/github", fmt.Errorf("saving the user: %w", result.Error))
 		return
 	}
 
-	// Update GitHub token (store encrypted in production!)
-	if err := database.DB.Model(dbUser).Update("github_token", token.AccessToken).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update token: " + err.Error()})
+	// Update GitHub token (store encrypted in production!) and what it may do
+	if err := database.DB.Model(dbUser).Updates(map[string]interface{}{
+		"github_token":  token.AccessToken,
+		"github_scopes": strings.Join(granted, ","),
+	}).Error; err != nil {
+		auth.LoginError(c, auth.LoginInternal, "GitHub", "/auth/github", fmt.Errorf("saving the token: %w", err))
 		return
 	}
 
 	// Generate JWT token instead of returning GitHub token
 	jwtToken, err := auth.GenerateToken(dbUser.ID, dbUser.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate JWT token: " + err.Error()})
+		auth.LoginError(c, auth.LoginInternal, "GitHub", "/auth/github", fmt.Errorf("generating the JWT: %w", err))
+		return
+	}
+
+	// Redirect to dashboard (or the deep link the user started from) with token
+	auth.CompleteLogin(c, jwtToken)
+}
+
+// grantedScopes reads the scopes the user actually granted: GitHub reports
+// them on every API response, the token response is the fallback
+func grantedScopes(resp *github.Response, token *oauth2.Token) []string {
+	if resp != nil && resp.Response != nil {
+		if values := resp.Header.Values("X-OAuth-Scopes"); values != nil {
+			return githubscopes.Parse(strings.Join(values, ","))
+		}
+	}
+	if scope, ok := token.Extra("scope").(string); ok {
+		return githubscopes.Parse(scope)
+	}
+	return nil
+}
+
+// UpgradeScopesRequest is the body of POST /api/auth/github/upgrade-scopes
+type UpgradeScopesRequest struct {
+	Features []string `json:"features"` // Feature names, see githubscopes.Features
+	Scopes   []string `json:"scopes"`   // Raw scopes, for anything not covered by a feature
+}
+
+// HandleUpgradeScopes starts a new OAuth round trip asking for the scopes the
+// requested features need on top of what the user already granted. It returns
+// the GitHub authorization URL for the client to navigate to; the regular
+// callback then stores the new token and scopes.
+func (h *OAuthHandler) HandleUpgradeScopes(c *gin.Context) {
+	var req UpgradeScopesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.GitHubID == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Sign in with GitHub first, this account has no GitHub identity"})
 		return
 	}
 
-	// Redirect to dashboard with token (same as Google OAuth)
-	c.Redirect(http.StatusTemporaryRedirect, "/dashboard?token="+jwtToken)
+	granted := githubscopes.Parse(user.GitHubScopes)
+	requested := append([]string{}, h.config.Scopes...)
+	for _, name := range req.Features {
+		feature, ok := githubscopes.FeatureByName(name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown feature: " + name})
+			return
+		}
+		if !feature.Enabled(granted) {
+			requested = append(requested, feature.Scope)
+		}
+	}
+	requested = append(requested, req.Scopes...)
+	// GitHub replaces the grant, keep everything the user already allowed
+	requested = githubscopes.Parse(strings.Join(append(requested, granted...), ","))
+
+	state := generateState()
+	auth.SetCookie(c, "oauth_state", state, 600)
+
+	upgraded := *h.config
+	upgraded.Scopes = requested
+	c.JSON(http.StatusOK, gin.H{
+		"authorize_url": upgraded.AuthCodeURL(state),
+		"scopes":        requested,
+	})
+}
+
+// listUserOrgs returns the logins of all orgs the authenticated user belongs to
+func listUserOrgs(ctx context.Context, client *github.Client) ([]string, error) {
+	var logins []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		orgs, resp, err := client.Organizations.List(ctx, "", opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, org := range orgs {
+			if org.Login != nil {
+				logins = append(logins, *org.Login)
+			}
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return logins, nil
+		}
+		opts.Page = resp.NextPage
+	}
 }
 
 func generateState() string {
@@ -0,0 +1,299 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/assets"
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/githubscopes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeGitHub answers the OAuth token exchange and the user API calls of a
+// sign-in: the token response grants tokenScope, API responses report
+// headerScopes in X-OAuth-Scopes unless it is empty, or tokenError the
+// way GitHub refuses a code. Requests to slowPath hang until hung is closed.
+type fakeGitHub struct {
+	tokenScope   string
+	headerScopes string
+	tokenError   string
+	slowPath     string
+	hung         chan struct{}
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == f.slowPath {
+		<-f.hung
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/login/oauth/access_token" && f.tokenError != "" {
+		json.NewEncoder(w).Encode(map[string]string{"error": f.tokenError, "error_description": "refused"})
+		return
+	}
+	if r.URL.Path == "/login/oauth/access_token" {
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_test", "token_type": "bearer", "scope": f.tokenScope})
+		return
+	}
+	if f.headerScopes != "" {
+		w.Header().Set("X-OAuth-Scopes", f.headerScopes)
+	}
+	switch r.URL.Path {
+	case "/user":
+		w.Write([]byte(`{"id": 4242, "login": "ada", "email": "ada@example.com"}`))
+	case "/user/orgs":
+		w.Write([]byte(`[]`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// toServer sends every request to the test server, whatever its host
+type toServer struct {
+	server *httptest.Server
+}
+
+func (t toServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(t.server.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+	return t.server.Client().Transport.RoundTrip(req)
+}
+
+// oauthSetup returns a sign-in handler talking to fake instead of GitHub,
+// with the database and JWT keys signing in needs
+func oauthSetup(t *testing.T, fake *fakeGitHub) *OAuthHandler {
+	t.Helper()
+	testutil.DB(t)
+	auth.InitJWT(&config.Config{JWTSecrets: []string{"test"}, BaseURL: "https://deploy.example.com"})
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	h := NewOAuthHandler(&config.Config{GitHubClientID: "client", GitHubClientSecret: "secret", GitHubScopes: []string{"public_repo", "user:email"}})
+	h.http = &http.Client{Transport: toServer{server}}
+	return h
+}
+
+// signIn completes a sign-in with h as GitHub redirects back to the platform
+func signIn(t *testing.T, h *OAuthHandler) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/auth/github/callback", h.HandleGitHubCallback)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/auth/github/callback?state=s1&code=c1", nil)
+	req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "s1"})
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusTemporaryRedirect || !strings.HasPrefix(w.Header().Get("Location"), "/dashboard?token=") {
+		t.Fatalf("sign-in: got %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	return w
+}
+
+// The scopes stored are those the user granted, read from X-OAuth-Scopes
+// and else from the token response, and they are updated at each sign-in
+func TestCallbackGrantedScopes(t *testing.T) {
+	fake := &fakeGitHub{tokenScope: "repo,user:email", headerScopes: "public_repo, user:email"}
+	h := oauthSetup(t, fake)
+	storedScopes := func() string {
+		t.Helper()
+		var user models.User
+		if err := database.DB.Where("github_id = ?", 4242).First(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.GitHubScopes
+	}
+
+	tests := []struct {
+		name                     string
+		tokenScope, headerScopes string
+		want                     string
+		privateRepos, prComments bool
+	}{
+		{"header", "repo,user:email", "public_repo, user:email", "public_repo,user:email", false, true},
+		{"all requested", "", "admin:repo_hook, repo, user:email", "admin:repo_hook,repo,user:email", true, true},
+		{"token response", "user:email", "", "user:email", false, false},
+		{"none", "", "", "", false, false},
+	}
+	for _, tt := range tests {
+		fake.tokenScope, fake.headerScopes = tt.tokenScope, tt.headerScopes
+		signIn(t, h)
+		stored := storedScopes()
+		if stored != tt.want {
+			t.Errorf("%s: stored scopes %q, want %q", tt.name, stored, tt.want)
+		}
+		granted := githubscopes.Parse(stored)
+		if err := githubscopes.Require(granted, githubscopes.FeaturePrivateRepos); (err == nil) != tt.privateRepos {
+			t.Errorf("%s: private repositories: %v", tt.name, err)
+		}
+		if err := githubscopes.Require(granted, githubscopes.FeaturePRComments); (err == nil) != tt.prComments {
+			t.Errorf("%s: pull request comments: %v", tt.name, err)
+		}
+	}
+
+	var users int64
+	database.DB.Model(&models.User{}).Count(&users)
+	if users != 1 {
+		t.Errorf("%d users after signing in again", users)
+	}
+}
+
+// Upgrading asks for the scopes the features need on top of those granted
+func TestUpgradeScopes(t *testing.T) {
+	h := oauthSetup(t, &fakeGitHub{})
+	githubID := int64(4242)
+	user := &models.User{Username: "ada", Email: "ada@example.com", GitHubID: &githubID, GitHubScopes: "public_repo,user:email"}
+	database.DB.Create(user)
+	local := &models.User{Username: "bob", Email: "bob@example.com"}
+	database.DB.Create(local)
+
+	upgrade := func(userID uint, body string) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/api/auth/github/upgrade-scopes", func(c *gin.Context) {
+			c.Set("user_id", userID)
+			h.HandleUpgradeScopes(c)
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/auth/github/upgrade-scopes", strings.NewReader(body)))
+		return w
+	}
+
+	w := upgrade(user.ID, `{"features": ["webhooks", "pr_comments"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		AuthorizeURL string   `json:"authorize_url"`
+		Scopes       []string `json:"scopes"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	// pr_comments is enabled by public_repo already
+	if want := []string{"admin:repo_hook", "public_repo", "user:email"}; strings.Join(resp.Scopes, " ") != strings.Join(want, " ") {
+		t.Errorf("requested %v, want %v", resp.Scopes, want)
+	}
+	authorize, err := url.Parse(resp.AuthorizeURL)
+	if err != nil || authorize.Query().Get("scope") != "admin:repo_hook public_repo user:email" || authorize.Query().Get("state") == "" {
+		t.Errorf("authorize URL %q", resp.AuthorizeURL)
+	}
+
+	if w := upgrade(user.ID, `{"features": ["telepathy"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown feature: got %d", w.Code)
+	}
+	if w := upgrade(local.ID, `{"features": ["webhooks"]}`); w.Code != http.StatusConflict {
+		t.Errorf("user without GitHub: got %d", w.Code)
+	}
+}
+
+// A GitHub too slow to answer fails the sign-in within the request's
+// budget, asking to try again, whichever of its calls hangs
+func TestCallbackSlowGitHub(t *testing.T) {
+	for _, slowPath := range []string{"/login/oauth/access_token", "/user"} {
+		fake := &fakeGitHub{slowPath: slowPath, hung: make(chan struct{})}
+		h := oauthSetup(t, fake)
+		t.Cleanup(func() { close(fake.hung) })
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.GET("/auth/github/callback", h.HandleGitHubCallback)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		req := httptest.NewRequest(http.MethodGet, "/auth/github/callback?state=s1&code=c1", nil).WithContext(ctx)
+		req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "s1"})
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		started := time.Now()
+		r.ServeHTTP(w, req)
+		cancel()
+
+		if elapsed := time.Since(started); elapsed > 3*time.Second {
+			t.Errorf("%s: callback took %s", slowPath, elapsed)
+		}
+		var body struct {
+			Error    string `json:"error"`
+			Code     string `json:"code"`
+			RetryURL string `json:"retry_url"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusGatewayTimeout || body.Code != auth.LoginUnavailable || body.Error != "GitHub took too long to answer." || body.RetryURL != "/auth/github" {
+			t.Errorf("%s: got %d: %s", slowPath, w.Code, w.Body.String())
+		}
+		var users int64
+		database.DB.Model(&models.User{}).Count(&users)
+		if users != 0 {
+			t.Errorf("%s: %d users signed in", slowPath, users)
+		}
+	}
+}
+
+// Callbacks that can't sign in answer browsers with the error page and API
+// clients with JSON, alike
+func TestCallbackErrors(t *testing.T) {
+	fake := &fakeGitHub{}
+	h := oauthSetup(t, fake)
+	web, err := assets.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := web.Register(r, "Deploy Platform"); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/auth/github/callback", h.HandleGitHubCallback)
+
+	tests := []struct {
+		name       string
+		query      string
+		tokenError string
+		status     int
+		code       string
+		title      string
+	}{
+		{"consent denied", "error=access_denied", "", http.StatusBadRequest, auth.LoginCancelled, "Sign-in cancelled"},
+		{"callback URL mismatch", "error=redirect_uri_mismatch", "", http.StatusInternalServerError, auth.LoginMisconfigured, "Sign-in unavailable"},
+		{"state mismatch", "state=other&code=c1", "", http.StatusBadRequest, auth.LoginExpired, "Sign-in expired"},
+		{"no code", "state=s1", "", http.StatusBadRequest, auth.LoginExpired, "Sign-in expired"},
+		{"code already used", "state=s1&code=c1", "bad_verification_code", http.StatusBadRequest, auth.LoginExpired, "Sign-in expired"},
+		{"bad client secret", "state=s1&code=c1", "incorrect_client_credentials", http.StatusInternalServerError, auth.LoginMisconfigured, "Sign-in unavailable"},
+	}
+	for _, tt := range tests {
+		fake.tokenError = tt.tokenError
+		for _, mode := range []string{gin.MIMEJSON, gin.MIMEHTML} {
+			req := httptest.NewRequest(http.MethodGet, "/auth/github/callback?"+tt.query, nil)
+			req.AddCookie(&http.Cookie{Name: "oauth_state", Value: "s1"})
+			req.Header.Set("Accept", mode)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status || !strings.HasPrefix(w.Header().Get("Content-Type"), mode) {
+				t.Errorf("%s as %s: got %d %s", tt.name, mode, w.Code, w.Header().Get("Content-Type"))
+				continue
+			}
+			var body struct {
+				Code string `json:"code"`
+			}
+			if mode == gin.MIMEJSON && (json.Unmarshal(w.Body.Bytes(), &body) != nil || body.Code != tt.code) {
+				t.Errorf("%s: got %s", tt.name, w.Body.String())
+			}
+			if mode == gin.MIMEHTML && !strings.Contains(w.Body.String(), "<title>"+tt.title+" - Deploy Platform</title>") {
+				t.Errorf("%s: got page %s", tt.name, w.Body.String())
+			}
+		}
+	}
+	var users int64
+	database.DB.Model(&models.User{}).Count(&users)
+	if users != 0 {
+		t.Errorf("%d users signed in", users)
+	}
+}
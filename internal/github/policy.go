@@ -0,0 +1,108 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/policy"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v56/github"
+)
+
+// PolicyBlocked is the code of the response to a push whose deployment the
+// project's deploy policy blocks
+const PolicyBlocked = "policy_blocked"
+
+// CommitVerifier asks GitHub whether it verified a commit's signature,
+// returning why not when it didn't (e.g. "unsigned")
+type CommitVerifier interface {
+	VerifyCommit(ctx context.Context, owner, repo, sha string) (bool, string, error)
+}
+
+type apiCommitVerifier struct {
+	client *github.Client
+}
+
+func (v *apiCommitVerifier) VerifyCommit(ctx context.Context, owner, repo, sha string) (bool, string, error) {
+	commit, _, err := v.client.Repositories.GetCommit(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return false, "", err
+	}
+	verification := commit.GetCommit().GetVerification()
+	if verification == nil {
+		return false, "no signature information", nil
+	}
+	return verification.GetVerified(), verification.GetReason(), nil
+}
+
+// applyPolicy judges the deployment of a push to production against its
+// project's deploy policy. The rules it breaks are recorded on it; under a
+// blocking policy it is then held until an approver overrides it, and true
+// is returned. Other deployments, and pushes breaking no rule, go ahead.
+func (h *WebhookHandler) applyPolicy(ctx context.Context, project *models.Project, deployment *models.Deployment, push *pushedCommit) bool {
+	rules := project.DeployPolicy
+	if rules == nil || len(policy.Enabled(rules)) == 0 {
+		return false
+	}
+	withProject := *deployment
+	withProject.Project = *project
+	if !build.ForProduction(&withProject) {
+		return false
+	}
+
+	facts := &policy.Push{Forced: push.Forced, Pusher: push.Actor}
+	if rules.RequireVerifiedCommits {
+		facts.Verified, facts.Reason = h.verifyCommit(ctx, project, deployment.CommitSHA)
+	}
+	result := policy.Evaluate(rules, facts)
+	if len(result.Violations) == 0 {
+		return false
+	}
+	violations := result.Messages()
+
+	if !result.Blocked {
+		log.Printf("⚠️  Deployment %d breaks the deploy policy of project %d: %s", deployment.ID, project.ID, strings.Join(violations, "; "))
+		deployment.PolicyViolations = violations
+		if err := database.DB.Model(deployment).Select("policy_violations").Updates(deployment).Error; err != nil {
+			log.Printf("⚠️  Failed to record the policy violations of deployment %d: %v", deployment.ID, err)
+		}
+		return false
+	}
+	if err := build.HoldForPolicy(&withProject, violations); err != nil {
+		// Not deploying is the safe way to fail
+		log.Printf("❌ Failed to block deployment %d: %v", deployment.ID, err)
+		h.deployments.SetStatus(deployment.ID, models.StatusFailed, "failed to apply the deploy policy: "+err.Error())
+	}
+	deployment.Status = withProject.Status
+	deployment.ApprovalExpiresAt = withProject.ApprovalExpiresAt
+	deployment.PolicyViolations = violations
+	deploymentID := deployment.ID
+	h.reports.Go(func() { h.reportBuildAsync(deploymentID) })
+	return true
+}
+
+// verifyCommit asks GitHub, with the project owner's token, whether it
+// verified the signature of the commit. It returns nil, and why, when it
+// can't be asked.
+func (h *WebhookHandler) verifyCommit(ctx context.Context, project *models.Project, sha string) (*bool, string) {
+	if project.RepoOwner == "" || project.RepoName == "" {
+		return nil, "the repository isn't on GitHub"
+	}
+	var owner models.User
+	if err := database.DB.Select("id", "github_token").First(&owner, project.UserID).Error; err != nil || owner.GitHubToken == "" {
+		return nil, "no GitHub token is stored for the project owner"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, httpclient.CallTimeout)
+	defer cancel()
+	verified, reason, err := h.newCommitVerifier(owner.GitHubToken).VerifyCommit(ctx, project.RepoOwner, project.RepoName, sha)
+	if err != nil {
+		log.Printf("⚠️  Failed to check the signature of %s/%s@%s: %v", project.RepoOwner, project.RepoName, sha, err)
+		return nil, "GitHub could not be asked"
+	}
+	return &verified, reason
+}
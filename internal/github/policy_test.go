@@ -0,0 +1,164 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeVerifier answers every commit the same, recording the token asking
+type fakeVerifier struct {
+	verified bool
+	reason   string
+	tokens   []string
+}
+
+func (f *fakeVerifier) VerifyCommit(ctx context.Context, owner, repo, sha string) (bool, string, error) {
+	return f.verified, f.reason, nil
+}
+
+// policySetup is a webhook setup whose project has deploy policy rules,
+// storing deployments in the test database and asking verifier about
+// commit signatures
+func policySetup(t *testing.T, rules *models.DeployPolicy, verifier *fakeVerifier) *webhookSetup {
+	t.Helper()
+	s := newWebhookSetup(t)
+	database.DB.Model(&models.User{}).Where("id = ?", s.project.UserID).Update("github_token", "gho_owner")
+	s.project.DeployPolicy = rules
+	database.DB.Save(s.project)
+	s.handler = s.newHandler(WebhookDeps{
+		Secret:      StaticSecret(testSecret),
+		Projects:    &fakeProjects{project: s.project},
+		Deployments: dbDeployments{},
+		Queue:       s.queue,
+		Verifier: func(token string) CommitVerifier {
+			verifier.tokens = append(verifier.tokens, token)
+			return verifier
+		},
+	})
+	return s
+}
+
+// forcedPush is a forced push to main by pusher
+func forcedPush(pusher string) map[string]any {
+	payload := pushPayload("refs/heads/main")
+	payload["forced"] = true
+	payload["sender"] = map[string]any{"login": pusher}
+	return payload
+}
+
+// A blocking policy holds the deployment of a push breaking it, for an
+// approver to override; nothing is queued until then
+func TestPolicyBlocksPush(t *testing.T) {
+	verifier := &fakeVerifier{reason: "unsigned"}
+	s := policySetup(t, &models.DeployPolicy{
+		BlockForcePushes: true, RequireVerifiedCommits: true, AllowedPushers: []string{"ada"}, Enforcement: models.PolicyBlock,
+	}, verifier)
+
+	w := s.deliver("push", forcedPush("mallory"))
+	if body := response(t, w); w.Code != http.StatusOK || body["code"] != PolicyBlocked {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var deployment models.Deployment
+	database.DB.Last(&deployment)
+	want := []string{
+		"the push was forced, rewriting the branch's history",
+		"GitHub did not verify the commit's signature (unsigned)",
+		"mallory may not deploy to production, only ada",
+	}
+	if deployment.Status != models.StatusPolicyBlocked || deployment.ApprovalExpiresAt == nil || !reflect.DeepEqual(deployment.PolicyViolations, want) {
+		t.Fatalf("deployment is %s, violations %q", deployment.Status, deployment.PolicyViolations)
+	}
+	if s.queue.Size() != 0 {
+		t.Fatal("blocked deployment queued")
+	}
+	if len(verifier.tokens) != 1 || verifier.tokens[0] != "gho_owner" {
+		t.Errorf("verified with %q", verifier.tokens)
+	}
+
+	// The owner overrides the block through the approval gate
+	r := gin.New()
+	r.POST("/deployments/:id/approve", func(c *gin.Context) {
+		c.Set("user_id", s.project.UserID)
+		c.Set("username", "ada")
+	}, s.handler.ApproveDeployment)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/deployments/%d/approve", deployment.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("override: got %d: %s", w.Code, w.Body.String())
+	}
+	var overridden models.Deployment
+	database.DB.First(&overridden, deployment.ID)
+	var event models.DeploymentEvent
+	database.DB.Where("deployment_id = ?", deployment.ID).Last(&event)
+	if overridden.Status != models.StatusQueued || overridden.ApprovedBy != "ada" || event.Reason != "deploy policy overridden by ada" {
+		t.Errorf("deployment is %s, approved by %q: %q", overridden.Status, overridden.ApprovedBy, event.Reason)
+	}
+	if job, ok := s.queue.TryDequeue(); !ok || job.DeploymentID != deployment.ID || job.Priority != queue.PriorityHigh {
+		t.Errorf("got job %+v", job)
+	}
+	var entry models.AuditLog
+	if err := database.DB.Where("action = ?", "deployment.policy_override").First(&entry).Error; err != nil || entry.UserID != s.project.UserID {
+		t.Errorf("audit log %+v: %v", entry, err)
+	}
+}
+
+// A warning policy records what the push broke, and deploys it
+func TestPolicyWarnsPush(t *testing.T) {
+	s := policySetup(t, &models.DeployPolicy{BlockForcePushes: true, Enforcement: models.PolicyWarn}, &fakeVerifier{})
+
+	w := s.deliver("push", forcedPush("ada"))
+	if body := response(t, w); w.Code != http.StatusOK || body["code"] != nil {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var deployment models.Deployment
+	database.DB.Last(&deployment)
+	if deployment.Status != models.StatusQueued || !reflect.DeepEqual(deployment.PolicyViolations, []string{"the push was forced, rewriting the branch's history"}) {
+		t.Errorf("deployment is %s, violations %q", deployment.Status, deployment.PolicyViolations)
+	}
+	if job, ok := s.queue.TryDequeue(); !ok || job.DeploymentID != deployment.ID {
+		t.Errorf("got job %+v", job)
+	}
+}
+
+// Pushes complying with the policy, and pushes not deploying to production,
+// aren't held; signatures that can't be checked are violations
+func TestPolicyAllows(t *testing.T) {
+	verifier := &fakeVerifier{verified: true, reason: "valid"}
+	s := policySetup(t, &models.DeployPolicy{RequireVerifiedCommits: true, AllowedPushers: []string{"Ada"}, Enforcement: models.PolicyBlock}, verifier)
+	s.deliver("push", pushPayload("refs/heads/main"))
+	// Branch deployments aren't production's
+	s.project.BranchDeploys = true
+	branch := forcedPush("mallory")
+	branch["ref"] = "refs/heads/feature/login"
+	s.deliver("push", branch)
+	var deployments []models.Deployment
+	database.DB.Order("id").Find(&deployments)
+	if len(deployments) != 2 || s.queue.Size() != 2 {
+		t.Fatalf("%d deployments, %d queued", len(deployments), s.queue.Size())
+	}
+	for _, d := range deployments {
+		if d.Status != models.StatusQueued || len(d.PolicyViolations) != 0 {
+			t.Errorf("deployment %d is %s, violations %q", d.ID, d.Status, d.PolicyViolations)
+		}
+	}
+
+	// Without the owner's token GitHub can't be asked
+	database.DB.Model(&models.User{}).Where("id = ?", s.project.UserID).Update("github_token", "")
+	w := s.deliver("push", pushPayload("refs/heads/main"))
+	var blocked models.Deployment
+	database.DB.Last(&blocked)
+	if blocked.Status != models.StatusPolicyBlocked || len(blocked.PolicyViolations) != 1 ||
+		!strings.HasSuffix(blocked.PolicyViolations[0], "no GitHub token is stored for the project owner") {
+		t.Errorf("got %d, deployment is %s, violations %q", w.Code, blocked.Status, blocked.PolicyViolations)
+	}
+}
@@ -0,0 +1,403 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/githubscopes"
+	"deploy-platform/internal/hooks"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v56/github"
+	"gorm.io/gorm/clause"
+)
+
+// commentMarker starts the platform's comment on a pull request
+const commentMarker = "<!-- deploy-platform:pull-request -->"
+
+const commentTimeout = 15 * time.Second
+
+// Commenter posts and edits the platform's comment on a pull request
+type Commenter interface {
+	CreateComment(ctx context.Context, owner, repo string, number int, body string) (int64, error)
+	EditComment(ctx context.Context, owner, repo string, commentID int64, body string) error
+}
+
+// errCommentGone is returned by EditComment for a comment that was deleted
+var errCommentGone = errors.New("comment not found")
+
+type apiCommenter struct {
+	client *github.Client
+}
+
+func (a *apiCommenter) CreateComment(ctx context.Context, owner, repo string, number int, body string) (int64, error) {
+	comment, _, err := a.client.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: &body})
+	if err != nil {
+		return 0, err
+	}
+	return comment.GetID(), nil
+}
+
+func (a *apiCommenter) EditComment(ctx context.Context, owner, repo string, commentID int64, body string) error {
+	_, _, err := a.client.Issues.EditComment(ctx, owner, repo, commentID, &github.IssueComment{Body: &body})
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+		return errCommentGone
+	}
+	return err
+}
+
+// lockPullRequest serializes the comment updates of a pull request,
+// returning the function unlocking it
+func (h *WebhookHandler) lockPullRequest(projectID uint, number int) func() {
+	value, _ := h.pullRequestLocks.LoadOrStore(fmt.Sprintf("%d/%d", projectID, number), &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// RegisterHooks keeps pull request comments up to date as their deployments
+// go live or fail, and reports builds on their commits as they go
+func (h *WebhookHandler) RegisterHooks() {
+	hooks.Register(hooks.Hook{
+		Name:   "pull-request-comments",
+		Events: []string{hooks.EventDeployed, hooks.EventFailed},
+		Run: func(ctx context.Context, event *hooks.Event) error {
+			var deployment models.Deployment
+			if err := database.DB.Select("id", "project_id", "pull_request").First(&deployment, event.Deployment.ID).Error; err != nil || deployment.PullRequest == 0 {
+				return nil
+			}
+			// Deployed hooks run just before the deployment is marked deployed
+			status := models.DeploymentStatus(event.Deployment.Status)
+			if event.Type == hooks.EventDeployed {
+				status = models.StatusDeployed
+			}
+			h.refreshComment(deployment.ProjectID, deployment.PullRequest, statusOverride{deployment.ID, status})
+			return nil
+		},
+	})
+	hooks.Register(hooks.Hook{
+		Name:   "build-reports",
+		Events: []string{hooks.EventBeforeBuild, hooks.EventAwaitingApproval, hooks.EventDeployed, hooks.EventFailed},
+		Run: func(ctx context.Context, event *hooks.Event) error {
+			// Before build and deployed hooks run before the status is stored
+			var status models.DeploymentStatus
+			switch event.Type {
+			case hooks.EventBeforeBuild:
+				status = models.StatusBuilding
+			case hooks.EventDeployed:
+				status = models.StatusDeployed
+			}
+			h.reportBuild(ctx, event.Deployment.ID, status)
+			return nil
+		},
+	})
+}
+
+// handlePullRequestEvent tracks the pull requests of a project's repository.
+// Their preview is the deployment of their head branch: opening one links
+// it, deploying the head commit if it never was, and closing one tears it
+// down. Merged pull requests are linked to the deployment of their merge
+// commit. Pull requests from forks are ignored: their code would build with
+// the project's environment variables.
+func (h *WebhookHandler) handlePullRequestEvent(c *gin.Context, body []byte) {
+	event, err := github.ParseWebHook("pull_request", body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse webhook: " + err.Error()})
+		return
+	}
+	prEvent, ok := event.(*github.PullRequestEvent)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unexpected event type"})
+		return
+	}
+	if prEvent.Repo == nil || prEvent.Repo.Owner == nil || prEvent.Repo.Owner.Login == nil || prEvent.Repo.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repository information missing"})
+		return
+	}
+	pr := prEvent.PullRequest
+	if pr == nil || pr.GetNumber() == 0 || pr.GetHead() == nil || pr.GetHead().GetRef() == "" || pr.GetHead().GetSHA() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pull request information missing"})
+		return
+	}
+
+	action := prEvent.GetAction()
+	if action != "opened" && action != "reopened" && action != "synchronize" && action != "closed" {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+	if head := pr.GetHead().GetRepo(); head == nil || head.GetID() != prEvent.Repo.GetID() {
+		c.JSON(http.StatusOK, gin.H{"message": "Pull requests from forks get no preview"})
+		return
+	}
+
+	project, err := h.projects.FindByRepo(repositoryIdentity(prEvent.Repo))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found for repository"})
+		return
+	}
+	if pr.GetHead().GetRef() == project.Branch {
+		c.JSON(http.StatusOK, gin.H{"message": "Pull requests from the production branch get no preview"})
+		return
+	}
+
+	unlock := h.lockPullRequest(project.ID, pr.GetNumber())
+	record, err := savePullRequest(project, pr, action)
+	if err != nil {
+		unlock()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record pull request: " + err.Error()})
+		return
+	}
+
+	var deployment *models.Deployment
+	if action == "closed" {
+		err = h.closePullRequest(project, record)
+	} else {
+		deployment, err = h.previewPullRequest(c, project, record, action, prEvent.GetSender().GetLogin())
+	}
+	unlock()
+	if err != nil {
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	h.reports.Go(func() { h.refreshComment(project.ID, record.Number, statusOverride{}) })
+	log.Printf("🔀 Pull request #%d of project %d %s", record.Number, project.ID, action)
+	c.JSON(http.StatusOK, gin.H{"message": "Pull request " + action, "pull_request": record, "deployment": deployment})
+}
+
+// savePullRequest records the state of a pull request as of the event
+func savePullRequest(project *models.Project, pr *github.PullRequest, action string) (*models.PullRequestPreview, error) {
+	record := models.PullRequestPreview{
+		ProjectID:  project.ID,
+		Number:     pr.GetNumber(),
+		Title:      pr.GetTitle(),
+		HeadBranch: pr.GetHead().GetRef(),
+		HeadSHA:    pr.GetHead().GetSHA(),
+		State:      models.PullRequestOpen,
+	}
+	if action == "closed" {
+		record.State = models.PullRequestClosed
+		if pr.GetMerged() {
+			record.State = models.PullRequestMerged
+			record.MergeCommitSHA = pr.GetMergeCommitSHA()
+		}
+	}
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}, {Name: "number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"title", "head_branch", "head_sha", "state", "merge_commit_sha", "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	return &record, database.DB.Where("project_id = ? AND number = ?", project.ID, record.Number).First(&record).Error
+}
+
+// previewPullRequest links the deployment of the head commit to the pull
+// request. A pull request opened on a commit that was never deployed, or
+// reopened after its preview was torn down, deploys it; on other pushes the
+// push event deploys it and linkPullRequest links it. With branch
+// deployments off, push events ignore the head branch, so the synchronize
+// event deploys the pushed commit instead. Projects in analysis-only mode get no preview: their push events analyze
+// the commit.
+func (h *WebhookHandler) previewPullRequest(c *gin.Context, project *models.Project, record *models.PullRequestPreview, action, actor string) (*models.Deployment, error) {
+	var deployment models.Deployment
+	found := database.DB.Where("project_id = ? AND branch = ? AND commit_sha = ?", project.ID, record.HeadBranch, record.HeadSHA).
+		Order("id DESC").Limit(1).Find(&deployment).Error == nil && deployment.ID != 0
+	if found && (action != "reopened" || project.AnalysisOnly) {
+		return &deployment, database.DB.Model(&deployment).Update("pull_request", record.Number).Error
+	}
+	if !found && ((action == "synchronize" && project.BranchDeploys) || project.AnalysisOnly) {
+		return nil, nil
+	}
+
+	deployment = models.Deployment{
+		ProjectID:   project.ID,
+		Status:      models.StatusPending,
+		CommitSHA:   record.HeadSHA,
+		CommitMsg:   record.Title,
+		Branch:      record.HeadBranch,
+		Trigger:     models.TriggerPullRequest,
+		Actor:       actor,
+		PullRequest: record.Number,
+	}
+	if !h.createDeployment(c, project, &deployment) {
+		return nil, errors.New("deployment not created")
+	}
+	h.enqueueDeployment(project, &deployment, false)
+	return &deployment, nil
+}
+
+// closePullRequest tears down the preview of a closed or merged pull request,
+// unless another open pull request previews the same branch, and links a
+// merged one to the deployment of its merge commit
+func (h *WebhookHandler) closePullRequest(project *models.Project, record *models.PullRequestPreview) error {
+	var others int64
+	database.DB.Model(&models.PullRequestPreview{}).
+		Where("project_id = ? AND head_branch = ? AND state = ? AND id <> ?", project.ID, record.HeadBranch, models.PullRequestOpen, record.ID).
+		Count(&others)
+	if others == 0 {
+		if err := h.retireBranch(project, record.HeadBranch); err != nil {
+			return fmt.Errorf("failed to tear down the preview: %w", err)
+		}
+	}
+
+	if record.State == models.PullRequestMerged && record.MergeCommitSHA != "" {
+		return database.DB.Model(&models.Deployment{}).
+			Where("project_id = ? AND commit_sha = ? AND pull_request = 0", project.ID, record.MergeCommitSHA).
+			Update("pull_request", record.Number).Error
+	}
+	return nil
+}
+
+// linkPullRequest links a pushed deployment to its pull request: the open
+// one of its branch, or the merged one whose merge commit it deploys. Either
+// may not be recorded yet; handlePullRequestEvent links it then.
+func (h *WebhookHandler) linkPullRequest(project *models.Project, deployment *models.Deployment) {
+	var record models.PullRequestPreview
+	err := database.DB.Where("project_id = ?", project.ID).
+		Where("(state = ? AND head_branch = ?) OR (state = ? AND merge_commit_sha = ?)",
+			models.PullRequestOpen, deployment.Branch, models.PullRequestMerged, deployment.CommitSHA).
+		Order("updated_at DESC").Limit(1).Find(&record).Error
+	if err != nil || record.ID == 0 {
+		return
+	}
+	deployment.PullRequest = record.Number
+	database.DB.Model(deployment).Update("pull_request", record.Number)
+	h.reports.Go(func() { h.refreshComment(project.ID, record.Number, statusOverride{}) })
+}
+
+// statusOverride is the status a deployment is about to have, for comments
+// written just before it is stored
+type statusOverride struct {
+	deploymentID uint
+	status       models.DeploymentStatus
+}
+
+// refreshComment brings the platform's comment on a pull request up to date
+// with what's stored, posting it the first time. The comment is rendered from
+// the database alone, so redelivered webhooks render the same comment, which
+// isn't edited again.
+func (h *WebhookHandler) refreshComment(projectID uint, number int, override statusOverride) {
+	unlock := h.lockPullRequest(projectID, number)
+	defer unlock()
+
+	var record models.PullRequestPreview
+	if err := database.DB.Where("project_id = ? AND number = ?", projectID, number).First(&record).Error; err != nil {
+		return
+	}
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		return
+	}
+	var owner models.User
+	if err := database.DB.Select("id", "github_token", "github_scopes").First(&owner, project.UserID).Error; err != nil || owner.GitHubToken == "" {
+		return
+	}
+	if err := githubscopes.Require(githubscopes.Parse(owner.GitHubScopes), githubscopes.FeaturePRComments); err != nil {
+		log.Printf("⚠️  Pull request #%d of project %d: not commenting, %v", number, projectID, err)
+		return
+	}
+
+	body := h.renderComment(&record, override)
+	if record.CommentID != 0 && body == record.CommentBody {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commentTimeout)
+	defer cancel()
+	commenter := h.newCommenter(owner.GitHubToken)
+	if record.CommentID > 0 {
+		err := commenter.EditComment(ctx, project.RepoOwner, project.RepoName, record.CommentID, body)
+		if err == nil {
+			database.DB.Model(&record).Update("comment_body", body)
+			return
+		}
+		if !errors.Is(err, errCommentGone) {
+			log.Printf("⚠️  Failed to update the comment on pull request #%d of project %d: %v", number, projectID, err)
+			return
+		}
+		// Deleted on GitHub: post it again
+		database.DB.Model(&record).Where("comment_id = ?", record.CommentID).Update("comment_id", 0)
+	}
+
+	// Claimed first, so replicas handling a redelivery don't both post one
+	claim := database.DB.Model(&models.PullRequestPreview{}).Where("id = ? AND comment_id = 0", record.ID).Update("comment_id", -1)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+	commentID, err := commenter.CreateComment(ctx, project.RepoOwner, project.RepoName, number, body)
+	if err != nil {
+		log.Printf("⚠️  Failed to comment on pull request #%d of project %d: %v", number, projectID, err)
+		database.DB.Model(&record).Update("comment_id", 0)
+		return
+	}
+	database.DB.Model(&record).Updates(map[string]interface{}{"comment_id": commentID, "comment_body": body})
+}
+
+// renderComment writes the comment of a pull request: its preview while
+// open, the deployment of its merge commit once merged
+func (h *WebhookHandler) renderComment(record *models.PullRequestPreview, override statusOverride) string {
+	var b strings.Builder
+	b.WriteString(commentMarker + "\n")
+
+	switch record.State {
+	case models.PullRequestMerged:
+		fmt.Fprintf(&b, "**Merged** as `%s`, the preview was torn down.\n\n", textutil.ShortSHA(record.MergeCommitSHA))
+		var production models.Deployment
+		if database.DB.Where("project_id = ? AND pull_request = ? AND commit_sha = ?", record.ProjectID, record.Number, record.MergeCommitSHA).
+			Order("id DESC").Limit(1).Find(&production).Error == nil && production.ID != 0 {
+			fmt.Fprintf(&b, "Deployment #%d of the merge commit: %s\n", production.ID, h.describeDeployment(&production, override))
+		} else {
+			b.WriteString("The deployment of the merge commit is linked here once it starts.\n")
+		}
+	case models.PullRequestClosed:
+		b.WriteString("**Closed** without merging: the preview was abandoned and torn down.\n")
+	default:
+		var preview models.Deployment
+		if database.DB.Where("project_id = ? AND pull_request = ? AND branch = ?", record.ProjectID, record.Number, record.HeadBranch).
+			Order("id DESC").Limit(1).Find(&preview).Error == nil && preview.ID != 0 {
+			fmt.Fprintf(&b, "**Preview** of `%s` (deployment #%d): %s\n", textutil.ShortSHA(preview.CommitSHA), preview.ID, h.describeDeployment(&preview, override))
+		} else {
+			fmt.Fprintf(&b, "**Preview** of `%s`: waiting for its deployment.\n", textutil.ShortSHA(record.HeadSHA))
+		}
+	}
+	return b.String()
+}
+
+// describeDeployment tells how a deployment is doing, with its URL once live
+func (h *WebhookHandler) describeDeployment(deployment *models.Deployment, override statusOverride) string {
+	status := deployment.Status
+	if override.deploymentID == deployment.ID {
+		status = override.status
+	}
+	switch status {
+	case models.StatusDeployed:
+		if deployment.Hostname != "" && h.hostnames != nil {
+			return "✅ Ready at " + h.hostnames.GetFullURL(deployment.Hostname)
+		}
+		return "✅ Ready"
+	case models.StatusFailed:
+		if deployment.FailureDetail != "" {
+			return "❌ Failed: " + deployment.FailureDetail
+		}
+		return "❌ Failed, see the build logs"
+	case models.StatusCancelled, models.StatusSkipped, models.StatusSuperseded:
+		return "⏭️ " + strings.ToUpper(string(status[:1])) + string(status[1:])
+	case models.StatusBuilding, models.StatusDeploying:
+		return "🔨 " + strings.ToUpper(string(status[:1])) + string(status[1:])
+	case models.StatusDeployPending:
+		return "⏳ Built, rollout retried once the cluster is reachable"
+	}
+	return "⏳ Queued"
+}
@@ -0,0 +1,245 @@
+package github
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCommenter records the comments posted and edited
+type fakeCommenter struct {
+	mu      sync.Mutex
+	created []string
+	edits   []string
+}
+
+func (f *fakeCommenter) CreateComment(ctx context.Context, owner, repo string, number int, body string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if owner != "acme" || repo != "app" || number != 42 {
+		return 0, fmt.Errorf("commented on %s/%s#%d", owner, repo, number)
+	}
+	f.created = append(f.created, body)
+	return int64(9000 + len(f.created)), nil
+}
+
+func (f *fakeCommenter) EditComment(ctx context.Context, owner, repo string, commentID int64, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if commentID != 9001 {
+		return errCommentGone
+	}
+	f.edits = append(f.edits, body)
+	return nil
+}
+
+// comment is the body of the comment as last posted or edited
+func (f *fakeCommenter) comment() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.edits) > 0 {
+		return f.edits[len(f.edits)-1]
+	}
+	if len(f.created) > 0 {
+		return f.created[0]
+	}
+	return ""
+}
+
+// pullRequestSetup is a webhook setup storing deployments in the test
+// database, whose owner may comment on pull requests
+type pullRequestSetup struct {
+	*webhookSetup
+	commenter *fakeCommenter
+	payloads  map[string]json.RawMessage
+}
+
+func newPullRequestSetup(t *testing.T) *pullRequestSetup {
+	t.Helper()
+	// Read before the test database moves to its own directory
+	payloads := map[string]json.RawMessage{}
+	for _, name := range []string{"opened", "closed", "reopened", "merged"} {
+		payloads[name] = fixture(t, "pull_request_"+name+".json")
+	}
+	s := &pullRequestSetup{webhookSetup: newWebhookSetup(t), commenter: &fakeCommenter{}, payloads: payloads}
+	database.DB.Model(&models.User{}).Where("id = ?", s.project.UserID).
+		Updates(map[string]interface{}{"github_token": "gho_test", "github_scopes": "repo,read:org"})
+	s.handler = s.newHandler(WebhookDeps{
+		Secret:    StaticSecret(testSecret),
+		Projects:  &fakeProjects{project: s.project},
+		Queue:     s.queue,
+		Commenter: func(token string) Commenter { return s.commenter },
+	})
+	return s
+}
+
+// event delivers a fixture pull request event, waiting for its comment
+func (s *pullRequestSetup) event(name string) map[string]any {
+	s.t.Helper()
+	w := s.deliver("pull_request", s.payloads[name])
+	s.handler.reports.Wait()
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("%s: got %d: %s", name, w.Code, w.Body.String())
+	}
+	return response(s.t, w)
+}
+
+// pushMain delivers the push of commit sha to the production branch
+func (s *pullRequestSetup) pushMain(sha string) {
+	s.t.Helper()
+	w := s.deliver("push", map[string]any{
+		"ref":         "refs/heads/main",
+		"head_commit": map[string]any{"id": sha, "message": "Merge pull request #42 from acme/feature/login"},
+		"repository":  map[string]any{"id": 1296269, "name": "app", "owner": map[string]any{"login": "acme"}},
+		"sender":      map[string]any{"login": "grace"},
+	})
+	s.handler.reports.Wait()
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("push: got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func (s *pullRequestSetup) record() models.PullRequestPreview {
+	s.t.Helper()
+	var record models.PullRequestPreview
+	if err := database.DB.Where("project_id = ? AND number = ?", s.project.ID, 42).First(&record).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return record
+}
+
+// An opened pull request deploys its head commit with one comment, edited
+// once merged to link the production deployment of its merge commit
+func TestPullRequestMerged(t *testing.T) {
+	s := newPullRequestSetup(t)
+
+	body := s.event("opened")
+	if body["message"] != "Pull request opened" {
+		t.Fatalf("response %v", body)
+	}
+	var preview models.Deployment
+	database.DB.Where("project_id = ? AND pull_request = ?", s.project.ID, 42).First(&preview)
+	if preview.ID == 0 || preview.Branch != "feature/login" || preview.CommitSHA != "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c" || preview.Trigger != models.TriggerPullRequest {
+		t.Fatalf("preview %+v", preview)
+	}
+	if want := fmt.Sprintf("**Preview** of `0d1a26e` (deployment #%d): ⏳ Queued", preview.ID); len(s.commenter.created) != 1 || !strings.Contains(s.commenter.comment(), want) {
+		t.Fatalf("comments %q, want %q", s.commenter.created, want)
+	}
+	if !strings.HasPrefix(s.commenter.comment(), commentMarker) {
+		t.Errorf("comment without its marker: %q", s.commenter.comment())
+	}
+
+	// Redelivered, the event changes nothing
+	s.event("opened")
+	if len(s.commenter.created) != 1 || len(s.commenter.edits) != 0 {
+		t.Errorf("redelivery: %d comments, %d edits", len(s.commenter.created), len(s.commenter.edits))
+	}
+	var previews int64
+	database.DB.Model(&models.Deployment{}).Where("pull_request = ?", 42).Count(&previews)
+	if previews != 1 {
+		t.Errorf("redelivery deployed again: %d previews", previews)
+	}
+
+	s.event("merged")
+	record := s.record()
+	if record.State != models.PullRequestMerged || record.MergeCommitSHA != "9f8e7d6c5b4a39281706f5e4d3c2b1a098765432" || record.CommentID != 9001 {
+		t.Errorf("record %+v", record)
+	}
+	if want := "**Merged** as `9f8e7d6`, the preview was torn down.\n\nThe deployment of the merge commit is linked here once it starts."; !strings.Contains(s.commenter.comment(), want) {
+		t.Errorf("comment %q, want %q", s.commenter.comment(), want)
+	}
+
+	// The push of the merge commit deploys production, linked to the pull request
+	s.pushMain("9f8e7d6c5b4a39281706f5e4d3c2b1a098765432")
+	var production models.Deployment
+	database.DB.Where("project_id = ? AND commit_sha = ?", s.project.ID, "9f8e7d6c5b4a39281706f5e4d3c2b1a098765432").First(&production)
+	if production.ID == 0 || production.Branch != "main" || production.PullRequest != 42 {
+		t.Fatalf("production deployment %+v", production)
+	}
+	if want := fmt.Sprintf("Deployment #%d of the merge commit: ⏳ Queued", production.ID); !strings.Contains(s.commenter.comment(), want) {
+		t.Errorf("comment %q, want %q", s.commenter.comment(), want)
+	}
+
+	edits := len(s.commenter.edits)
+	s.event("merged")
+	if len(s.commenter.created) != 1 || len(s.commenter.edits) != edits {
+		t.Errorf("redelivered merge: %d comments, %d edits instead of %d", len(s.commenter.created), len(s.commenter.edits), edits)
+	}
+}
+
+// The merge commit may be pushed before the pull request event arrives:
+// closing the pull request links the deployment then
+func TestPullRequestMergedAfterPush(t *testing.T) {
+	s := newPullRequestSetup(t)
+	s.event("opened")
+	s.pushMain("9f8e7d6c5b4a39281706f5e4d3c2b1a098765432")
+	var production models.Deployment
+	database.DB.Where("project_id = ? AND branch = ?", s.project.ID, "main").First(&production)
+	if production.PullRequest != 0 {
+		t.Fatalf("linked to pull request %d before the merge", production.PullRequest)
+	}
+
+	s.event("merged")
+	database.DB.First(&production, production.ID)
+	if production.PullRequest != 42 {
+		t.Errorf("production deployment linked to %d", production.PullRequest)
+	}
+	if want := fmt.Sprintf("Deployment #%d of the merge commit", production.ID); !strings.Contains(s.commenter.comment(), want) {
+		t.Errorf("comment %q, want %q", s.commenter.comment(), want)
+	}
+}
+
+// A pull request closed without merging is noted abandoned, with no merge
+// commit; reopened, it deploys its head commit again
+func TestPullRequestClosedAndReopened(t *testing.T) {
+	s := newPullRequestSetup(t)
+	s.event("opened")
+
+	s.event("closed")
+	record := s.record()
+	if record.State != models.PullRequestClosed || record.MergeCommitSHA != "" {
+		t.Errorf("record %+v", record)
+	}
+	if want := "**Closed** without merging: the preview was abandoned and torn down."; !strings.Contains(s.commenter.comment(), want) {
+		t.Errorf("comment %q, want %q", s.commenter.comment(), want)
+	}
+	// Its test merge commit, pushed to production, isn't linked to it
+	s.pushMain("b7e2a0c41d5f6e3a9c8b7d6e5f4a3b2c1d0e9f8a")
+	var linked int64
+	database.DB.Model(&models.Deployment{}).Where("branch = ? AND pull_request = ?", "main", 42).Count(&linked)
+	if linked != 0 {
+		t.Errorf("test merge commit of a closed pull request linked")
+	}
+
+	body := s.event("reopened")
+	deployment, _ := body["deployment"].(map[string]any)
+	var previews []models.Deployment
+	database.DB.Where("pull_request = ? AND branch = ?", 42, "feature/login").Order("id").Find(&previews)
+	if len(previews) != 2 || deployment["id"] != float64(previews[1].ID) {
+		t.Fatalf("reopened: %d previews, response %v", len(previews), body)
+	}
+	if s.record().State != models.PullRequestOpen {
+		t.Errorf("record %+v", s.record())
+	}
+	if want := fmt.Sprintf("**Preview** of `0d1a26e` (deployment #%d)", previews[1].ID); len(s.commenter.created) != 1 || !strings.Contains(s.commenter.comment(), want) {
+		t.Errorf("%d comments, last %q, want %q", len(s.commenter.created), s.commenter.comment(), want)
+	}
+}
+
+// A comment deleted on GitHub is posted again rather than left stale
+func TestPullRequestCommentDeleted(t *testing.T) {
+	s := newPullRequestSetup(t)
+	s.event("opened")
+	database.DB.Model(&models.PullRequestPreview{}).Where("number = ?", 42).Update("comment_id", 8000)
+
+	s.event("closed")
+	if len(s.commenter.created) != 2 || s.record().CommentID != 9002 {
+		t.Errorf("%d comments, comment ID %d", len(s.commenter.created), s.record().CommentID)
+	}
+}
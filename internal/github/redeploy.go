@@ -0,0 +1,137 @@
+package github
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RedeployRequest asks for a new deployment of what another one deployed
+type RedeployRequest struct {
+	// Roll out the image the deployment was built as instead of building its
+	// commit again; the image must still be on the build host
+	DeployOnly bool `json:"deploy_only"`
+}
+
+// RedeployDeployment deploys the commit of a deployment again, with the
+// project's current env vars and settings, as a new deployment that jumps
+// the queue. Deploy-only redeploys skip the clone and build and roll out the
+// image of the deployment, e.g. one that failed as cluster_unavailable.
+func (h *WebhookHandler) RedeployDeployment(c *gin.Context) {
+	deploymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deployment ID"})
+		return
+	}
+	var req RedeployRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var source models.Deployment
+	if err := database.DB.Preload("Project").First(&source, deploymentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	if source.Project.UserID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	project := source.Project
+
+	deployment := redeployment(c, &source)
+	if req.DeployOnly {
+		if !h.redeployableImage(c, &source, http.StatusGone) {
+			return
+		}
+		deployImage(deployment, &source)
+	}
+	if !h.createDeployment(c, &project, deployment) {
+		return
+	}
+
+	mode := "rebuilt"
+	if req.DeployOnly {
+		mode = "deploy-only, image " + source.ImageTag
+	}
+	audit.FromContext(c, "deployment.redeploy", fmt.Sprintf("deployment %d of project %d (%s) as deployment %d, %s", source.ID, project.ID, textutil.ShortSHA(source.CommitSHA), deployment.ID, mode))
+	log.Printf("🔁 Deployment %d redeploys deployment %d of project %d (%s)", deployment.ID, source.ID, project.ID, mode)
+
+	h.enqueueDeployment(&project, deployment, true)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Redeployment triggered",
+		"deployment":  deployment,
+		"redeploy_of": source.ID,
+		"deploy_only": req.DeployOnly,
+	})
+}
+
+// redeployment is a new deployment of the commit of source, to the same
+// target, with the project's current env vars and settings
+func redeployment(c *gin.Context, source *models.Deployment) *models.Deployment {
+	deployment := &models.Deployment{
+		ProjectID:       source.ProjectID,
+		Status:          models.StatusPending,
+		CommitSHA:       source.CommitSHA,
+		CommitMsg:       source.CommitMsg,
+		CommitMsgDetail: source.CommitMsgDetail,
+		Branch:          source.Branch,
+		Ref:             source.Ref,
+		Target:          source.Target,
+		PullRequest:     source.PullRequest,
+		Trigger:         models.TriggerManual,
+		Actor:           c.GetString("username"),
+	}
+	if source.Target == models.TargetPreview {
+		deployment.Hostname = source.Hostname // Moved to the redeploy once it is live
+	}
+	return deployment
+}
+
+// deployImage makes deployment roll out the image of source instead of
+// building its commit again
+func deployImage(deployment, source *models.Deployment) {
+	redeployOf := source.ID
+	deployment.DeployOnly = true
+	deployment.RedeployOf = &redeployOf
+	deployment.ImageTag = source.ImageTag
+	deployment.DetectedPort = source.DetectedPort
+	deployment.DetectedEnv = source.DetectedEnv
+	deployment.BuildCommands = source.BuildCommands
+	deployment.ManifestPatches = source.Project.ManifestPatches
+}
+
+// redeployableImage checks that the image deployment was built as is still
+// on the build host, writing the error response and returning false when it
+// isn't: goneStatus when the image was pruned
+func (h *WebhookHandler) redeployableImage(c *gin.Context, deployment *models.Deployment, goneStatus int) bool {
+	if deployment.ImageTag == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment %d was never built, redeploy it without deploy_only", deployment.ID)})
+		return false
+	}
+	if h.builds == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Build service not available"})
+		return false
+	}
+	exists, err := h.builds.ImageExists(c.Request.Context(), deployment.ImageTag)
+	if err != nil {
+		log.Printf("❌ Failed to look up image %s: %v", deployment.ImageTag, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up the deployment's image"})
+		return false
+	}
+	if !exists {
+		c.JSON(goneStatus, gin.H{"error": fmt.Sprintf("Image %s is gone from the build host, redeploy without deploy_only to build it again", deployment.ImageTag)})
+		return false
+	}
+	return true
+}
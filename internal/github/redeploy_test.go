@@ -0,0 +1,139 @@
+package github
+
+import (
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageInspect matches the Docker API's image inspect path
+var imageInspect = regexp.MustCompile(`^(/v[0-9.]+)?/images/(.+)/json$`)
+
+// dockerImages is a Docker daemon holding the images tagged tags, answering
+// image lookups only
+func dockerImages(t *testing.T, tags ...string) *build.Service {
+	t.Helper()
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Api-Version", "1.43")
+		if strings.HasSuffix(r.URL.Path, "/_ping") {
+			return
+		}
+		match := imageInspect.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			t.Errorf("unexpected Docker call %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, tag := range tags {
+			if match[2] == tag {
+				fmt.Fprintf(w, `{"Id": "sha256:%s", "RepoTags": [%q]}`, strings.Repeat("1", 64), tag)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": "No such image: %s"}`, match[2])
+	}))
+	t.Cleanup(daemon.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(daemon.URL, "http://"))
+	builds, err := build.NewService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return builds
+}
+
+// redeploy has user redeploy source with body, returning the response
+func (s *webhookSetup) redeploy(userID uint, source *models.Deployment, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/deployments/:id/redeploy", func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("username", "ada")
+	}, s.handler.RedeployDeployment)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/deployments/%d/redeploy", source.ID), strings.NewReader(body)))
+	return w
+}
+
+// A deployment that failed as cluster_unavailable is redeployed deploy-only
+// while its image is on the build host: the new deployment rolls it out,
+// nothing is cloned or built
+func TestRedeployDeployOnly(t *testing.T) {
+	s := newWebhookSetup(t)
+	s.handler = s.newHandler(WebhookDeps{
+		Deployments: s.deployments,
+		Queue:       s.queue,
+		Builds:      dockerImages(t, "deploy-1:0123456"),
+	})
+	source := &models.Deployment{
+		ProjectID: s.project.ID, Status: models.StatusFailed, FailureCategory: models.FailureClusterUnavailable,
+		CommitSHA: "0123456789abcdef0123456789abcdef01234567", Branch: "main", ImageTag: "deploy-1:0123456", DetectedPort: 5000,
+	}
+	database.DB.Create(source)
+
+	w := s.redeploy(s.project.UserID, source, `{"deploy_only": true}`)
+	if body := response(t, w); w.Code != http.StatusCreated || body["deploy_only"] != true {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	deployment := s.deployments.created[0]
+	if !deployment.DeployOnly || deployment.RedeployOf == nil || *deployment.RedeployOf != source.ID || deployment.ImageTag != source.ImageTag ||
+		deployment.DetectedPort != 5000 || deployment.CommitSHA != source.CommitSHA || deployment.Trigger != models.TriggerManual {
+		t.Errorf("deployment %+v", deployment)
+	}
+	if job, ok := s.queue.TryDequeue(); !ok || job.DeploymentID != deployment.ID || job.Priority != queue.PriorityHigh {
+		t.Errorf("got job %+v", job)
+	}
+	var entry models.AuditLog
+	if err := database.DB.Where("action = ?", "deployment.redeploy").First(&entry).Error; err != nil || !strings.HasSuffix(entry.Details, "deploy-only, image deploy-1:0123456") {
+		t.Errorf("audit log %+v: %v", entry, err)
+	}
+
+	// Without deploy_only the commit is built again
+	if w := s.redeploy(s.project.UserID, source, ""); w.Code != http.StatusCreated {
+		t.Fatalf("rebuild: got %d: %s", w.Code, w.Body.String())
+	}
+	if rebuilt := s.deployments.created[1]; rebuilt.DeployOnly || rebuilt.ImageTag != "" || rebuilt.RedeployOf != nil {
+		t.Errorf("rebuilt %+v", rebuilt)
+	}
+}
+
+// Deploy-only redeploys need the deployment's image
+func TestRedeployDeployOnlyRefused(t *testing.T) {
+	s := newWebhookSetup(t)
+	stranger := &models.User{Email: "mallory@example.com", Username: "mallory"}
+	database.DB.Create(stranger)
+	pruned := &models.Deployment{ProjectID: s.project.ID, Status: models.StatusFailed, CommitSHA: strings.Repeat("a", 40), Branch: "main", ImageTag: "deploy-1:aaaaaaa"}
+	unbuilt := &models.Deployment{ProjectID: s.project.ID, Status: models.StatusFailed, CommitSHA: strings.Repeat("b", 40), Branch: "main"}
+	database.DB.Create(pruned)
+	database.DB.Create(unbuilt)
+
+	if w := s.redeploy(s.project.UserID, pruned, `{"deploy_only": true}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a build service: got %d", w.Code)
+	}
+	s.handler = s.newHandler(WebhookDeps{Deployments: s.deployments, Queue: s.queue, Builds: dockerImages(t)})
+	for name, tt := range map[string]struct {
+		userID uint
+		source *models.Deployment
+		want   int
+	}{
+		"image pruned": {s.project.UserID, pruned, http.StatusGone},
+		"never built":  {s.project.UserID, unbuilt, http.StatusConflict},
+		"stranger":     {stranger.ID, pruned, http.StatusForbidden},
+	} {
+		if w := s.redeploy(tt.userID, tt.source, `{"deploy_only": true}`); w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", name, w.Code, tt.want, w.Body.String())
+		}
+	}
+	if len(s.deployments.created) != 0 || s.queue.Size() != 0 {
+		t.Errorf("created %d deployments, queued %d", len(s.deployments.created), s.queue.Size())
+	}
+}
@@ -0,0 +1,184 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v56/github"
+)
+
+// Kinds of ref a manual deployment can name
+const (
+	RefBranch = "branch"
+	RefTag    = "tag"
+	RefCommit = "commit"
+)
+
+// ResolvedRef is a branch, tag or commit SHA resolved to a full commit SHA
+type ResolvedRef struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"` // As requested (branch or tag name, or the SHA)
+	SHA     string `json:"sha"`  // Full commit SHA
+	Message string `json:"-"`    // Commit message
+}
+
+// RefCandidate is one of the commits an ambiguous short SHA matches
+type RefCandidate struct {
+	SHA     string `json:"sha"`
+	Message string `json:"message"`
+}
+
+// ErrRefNotFound is returned for a ref that is neither a branch, a tag nor a
+// commit of the repository
+var ErrRefNotFound = errors.New("ref not found")
+
+// AmbiguousRefError is returned for a short SHA matching several commits
+type AmbiguousRefError struct {
+	Ref        string
+	Candidates []RefCandidate
+}
+
+func (e *AmbiguousRefError) Error() string {
+	return fmt.Sprintf("short SHA %s matches %d commits, use a longer prefix", e.Ref, len(e.Candidates))
+}
+
+// RefResolver resolves a ref of a repository to the commit it points at
+type RefResolver interface {
+	ResolveRef(ctx context.Context, owner, repo, ref string) (*ResolvedRef, error)
+}
+
+// apiRefResolver resolves refs with the GitHub REST API
+type apiRefResolver struct {
+	client *github.Client
+}
+
+// ResolveRef looks ref up as a branch, then a tag, then (if it looks like
+// one) a commit SHA. All lookups go through the repository's endpoints, so a
+// ref of another repository is never accepted.
+func (r *apiRefResolver) ResolveRef(ctx context.Context, owner, repo, ref string) (*ResolvedRef, error) {
+	branch, _, err := r.client.Repositories.GetBranch(ctx, owner, repo, ref, 0)
+	if err == nil {
+		commit := branch.GetCommit()
+		return &ResolvedRef{Kind: RefBranch, Name: ref, SHA: commit.GetSHA(), Message: commit.GetCommit().GetMessage()}, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	if resolved, err := r.resolveTag(ctx, owner, repo, ref); !errors.Is(err, ErrRefNotFound) {
+		return resolved, err
+	}
+
+	if !isHex(ref, 4, 40) {
+		return nil, ErrRefNotFound
+	}
+	sha := strings.ToLower(ref)
+	if len(sha) < 40 {
+		return r.resolveShortSHA(ctx, owner, repo, sha)
+	}
+	return r.resolveCommit(ctx, owner, repo, sha)
+}
+
+// resolveTag follows a lightweight or annotated tag to its commit
+func (r *apiRefResolver) resolveTag(ctx context.Context, owner, repo, name string) (*ResolvedRef, error) {
+	tagRef, _, err := r.client.Git.GetRef(ctx, owner, repo, "tags/"+name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrRefNotFound
+		}
+		return nil, err
+	}
+
+	object := tagRef.GetObject()
+	// Annotated tags point at a tag object, which may itself point at a tag
+	for i := 0; object.GetType() == "tag" && i < 5; i++ {
+		tag, _, err := r.client.Git.GetTag(ctx, owner, repo, object.GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		object = tag.GetObject()
+	}
+	if object.GetType() != "commit" {
+		return nil, fmt.Errorf("tag %s points at a %s, not a commit", name, object.GetType())
+	}
+
+	resolved, err := r.resolveCommit(ctx, owner, repo, object.GetSHA())
+	if err != nil {
+		return nil, err
+	}
+	resolved.Kind, resolved.Name = RefTag, name
+	return resolved, nil
+}
+
+// resolveShortSHA finds the commits starting with prefix. Commit search
+// lists every match; commits it hasn't indexed yet are tried directly.
+func (r *apiRefResolver) resolveShortSHA(ctx context.Context, owner, repo, prefix string) (*ResolvedRef, error) {
+	query := fmt.Sprintf("repo:%s/%s hash:%s", owner, repo, prefix)
+	result, _, err := r.client.Search.Commits(ctx, query, &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 10}})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []RefCandidate
+	for _, commit := range result.Commits {
+		if strings.HasPrefix(commit.GetSHA(), prefix) {
+			candidates = append(candidates, RefCandidate{SHA: commit.GetSHA(), Message: firstLine(commit.GetCommit().GetMessage())})
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		resolved, err := r.resolveCommit(ctx, owner, repo, prefix)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Name = prefix
+		return resolved, nil
+	case 1:
+		return r.resolveCommit(ctx, owner, repo, candidates[0].SHA)
+	default:
+		return nil, &AmbiguousRefError{Ref: prefix, Candidates: candidates}
+	}
+}
+
+// resolveCommit looks a commit SHA (or unambiguous prefix) up in the repository
+func (r *apiRefResolver) resolveCommit(ctx context.Context, owner, repo, sha string) (*ResolvedRef, error) {
+	commit, _, err := r.client.Repositories.GetCommit(ctx, owner, repo, sha, nil)
+	if err != nil {
+		// GitHub answers 422 for SHAs it can't resolve
+		if isNotFound(err) || isStatus(err, http.StatusUnprocessableEntity) {
+			return nil, ErrRefNotFound
+		}
+		return nil, err
+	}
+	return &ResolvedRef{Kind: RefCommit, Name: sha, SHA: commit.GetSHA(), Message: commit.GetCommit().GetMessage()}, nil
+}
+
+func isNotFound(err error) bool {
+	return isStatus(err, http.StatusNotFound)
+}
+
+func isStatus(err error, status int) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == status
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// isHex reports whether s is min to max hex digits, e.g. a commit SHA
+func isHex(s string, min, max int) bool {
+	if len(s) < min || len(s) > max {
+		return false
+	}
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package github
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v56/github"
+)
+
+// RepoIdentity is the repository a webhook payload is about
+type RepoIdentity struct {
+	ID       int64 // 0 when the payload has none
+	Owner    string
+	Name     string
+	HTMLURL  string
+	CloneURL string
+}
+
+func (r RepoIdentity) fullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+func pushRepoIdentity(repo *github.PushEventRepository) RepoIdentity {
+	id := RepoIdentity{Name: *repo.Name, Owner: *repo.Owner.Login}
+	if repo.ID != nil {
+		id.ID = *repo.ID
+	}
+	if repo.HTMLURL != nil {
+		id.HTMLURL = *repo.HTMLURL
+	}
+	if repo.CloneURL != nil {
+		id.CloneURL = *repo.CloneURL
+	}
+	return id
+}
+
+func repositoryIdentity(repo *github.Repository) RepoIdentity {
+	id := RepoIdentity{Name: *repo.Name, Owner: *repo.Owner.Login}
+	if repo.ID != nil {
+		id.ID = *repo.ID
+	}
+	if repo.HTMLURL != nil {
+		id.HTMLURL = *repo.HTMLURL
+	}
+	if repo.CloneURL != nil {
+		id.CloneURL = *repo.CloneURL
+	}
+	return id
+}
+
+// findRepoProject finds the project of a webhook's repository: by GitHub
+// repository ID first, which survives renames and transfers, then by
+// owner/name (the current one, then any previous names given) for projects
+// linked before IDs were stored. The project's stored repository is brought
+// up to date with repo.
+func findRepoProject(repo RepoIdentity, previous ...RepoIdentity) (*models.Project, error) {
+	var project models.Project
+	if repo.ID != 0 {
+		err := database.DB.Where("github_repo_id = ?", repo.ID).First(&project).Error
+		if err == nil {
+			return &project, syncRepoIdentity(&project, repo)
+		}
+	}
+
+	var err error
+	for _, name := range append([]RepoIdentity{repo}, previous...) {
+		// GitHub owner and repository names are case-insensitive
+		if err = database.DB.Where("LOWER(repo_owner) = LOWER(?) AND LOWER(repo_name) = LOWER(?)", name.Owner, name.Name).First(&project).Error; err == nil {
+			return &project, syncRepoIdentity(&project, repo)
+		}
+	}
+	return nil, err
+}
+
+// syncRepoIdentity stores the repository ID of a project matched by name and
+// follows renames and transfers: the new owner/name replace the stored ones
+// and the clone URL is rewritten to match
+func syncRepoIdentity(project *models.Project, repo RepoIdentity) error {
+	updates := map[string]interface{}{}
+	if repo.ID != 0 && (project.GitHubRepoID == nil || *project.GitHubRepoID != repo.ID) {
+		updates["github_repo_id"] = repo.ID
+		project.GitHubRepoID = &repo.ID
+	}
+
+	previous := project.RepoOwner + "/" + project.RepoName
+	moved := !strings.EqualFold(previous, repo.fullName())
+	if moved {
+		project.RepoURL = movedRepoURL(project.RepoURL, previous, repo)
+		project.RepoOwner, project.RepoName = repo.Owner, repo.Name
+		updates["repo_owner"] = project.RepoOwner
+		updates["repo_name"] = project.RepoName
+		updates["repo_url"] = project.RepoURL
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := database.DB.Model(project).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if moved {
+		log.Printf("🔀 Repository of project %d moved: %s -> %s", project.ID, previous, repo.fullName())
+		audit.Record(&models.AuditLog{
+			UserID:  project.UserID,
+			Action:  "project.repository_moved",
+			Details: fmt.Sprintf("project %d: %s -> %s", project.ID, previous, repo.fullName()),
+		})
+	}
+	return nil
+}
+
+// movedRepoURL rewrites a project's repository URL for the repository's new
+// owner/name, keeping its form (HTTPS, .git suffix, SSH)
+func movedRepoURL(url, previous string, repo RepoIdentity) string {
+	if i := strings.Index(strings.ToLower(url), strings.ToLower(previous)); i >= 0 {
+		return url[:i] + repo.fullName() + url[i+len(previous):]
+	}
+	// Not recognizably the old repository's URL: use the one GitHub reports
+	if strings.HasSuffix(url, ".git") && repo.CloneURL != "" {
+		return repo.CloneURL
+	}
+	if repo.HTMLURL != "" {
+		return repo.HTMLURL
+	}
+	return url
+}
+
+// repositoryChanges is the part of a repository event's "changes" telling
+// where a renamed or transferred repository came from
+type repositoryChanges struct {
+	Changes struct {
+		Repository struct {
+			Name struct {
+				From string `json:"from"`
+			} `json:"name"`
+		} `json:"repository"`
+		Owner struct {
+			From struct {
+				User struct {
+					Login string `json:"login"`
+				} `json:"user"`
+				Organization struct {
+					Login string `json:"login"`
+				} `json:"organization"`
+			} `json:"from"`
+		} `json:"owner"`
+	} `json:"changes"`
+}
+
+// handleRepositoryEvent follows renamed and transferred repositories, so
+// pushes keep deploying to their project
+func (h *WebhookHandler) handleRepositoryEvent(c *gin.Context, body []byte) {
+	event, err := github.ParseWebHook("repository", body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse webhook: " + err.Error()})
+		return
+	}
+
+	repoEvent, ok := event.(*github.RepositoryEvent)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unexpected event type"})
+		return
+	}
+
+	if repoEvent.Action == nil || (*repoEvent.Action != "renamed" && *repoEvent.Action != "transferred") {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	if repoEvent.Repo == nil || repoEvent.Repo.Owner == nil || repoEvent.Repo.Owner.Login == nil || repoEvent.Repo.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repository information missing"})
+		return
+	}
+	repo := repositoryIdentity(repoEvent.Repo)
+
+	// Projects linked before repository IDs were stored are only known by the old name
+	var changes repositoryChanges
+	json.Unmarshal(body, &changes)
+	previous := repo
+	if from := changes.Changes.Repository.Name.From; from != "" {
+		previous.Name = from
+	}
+	if from := changes.Changes.Owner.From.User.Login; from != "" {
+		previous.Owner = from
+	} else if from := changes.Changes.Owner.From.Organization.Login; from != "" {
+		previous.Owner = from
+	}
+
+	project, err := h.projects.FindByRepo(repo, previous)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found for repository"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Repository " + *repoEvent.Action,
+		"project_id": project.ID,
+		"repository": project.RepoOwner + "/" + project.RepoName,
+	})
+}
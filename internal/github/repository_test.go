@@ -0,0 +1,115 @@
+package github
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// repositorySetup is a webhook setup whose projects are found in the test
+// database, with acme/app linked before repository IDs were stored
+func repositorySetup(t *testing.T) *webhookSetup {
+	t.Helper()
+	s := newWebhookSetup(t)
+	s.project.RepoURL = "https://github.com/acme/app.git"
+	if err := database.DB.Save(s.project).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.handler = s.newHandler(WebhookDeps{Secret: StaticSecret(testSecret), Deployments: s.deployments, Queue: s.queue})
+	return s
+}
+
+// storedProject reloads the project from the database
+func (s *webhookSetup) storedProject() models.Project {
+	s.t.Helper()
+	var project models.Project
+	if err := database.DB.First(&project, s.project.ID).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return project
+}
+
+// A renamed then transferred repository keeps deploying to its project,
+// whose repository follows it
+func TestRepositoryRenameAndTransfer(t *testing.T) {
+	// Read before the test database moves to its own directory
+	push := fixture(t, "push_transferred.json")
+	payloads := map[string][]byte{}
+	for _, name := range []string{"repository_renamed.json", "repository_transferred.json"} {
+		payloads[name] = fixture(t, name)
+	}
+	s := repositorySetup(t)
+
+	tests := []struct {
+		fixture, message, repository, repoURL string
+	}{
+		// Matched by its previous name, the repository ID is stored
+		{"repository_renamed.json", "Repository renamed", "acme/shop", "https://github.com/acme/shop.git"},
+		// Matched by the repository ID
+		{"repository_transferred.json", "Repository transferred", "Acme-Retail/shop", "https://github.com/Acme-Retail/shop.git"},
+	}
+	for _, tt := range tests {
+		w := s.deliver("repository", json.RawMessage(payloads[tt.fixture]))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", tt.fixture, w.Code, w.Body.String())
+		}
+		body := response(t, w)
+		if body["message"] != tt.message || body["repository"] != tt.repository || body["project_id"] != float64(s.project.ID) {
+			t.Errorf("%s: response %v", tt.fixture, body)
+		}
+		project := s.storedProject()
+		if project.RepoOwner+"/"+project.RepoName != tt.repository || project.RepoURL != tt.repoURL {
+			t.Errorf("%s: project repository %s/%s at %s", tt.fixture, project.RepoOwner, project.RepoName, project.RepoURL)
+		}
+		if project.GitHubRepoID == nil || *project.GitHubRepoID != 1296269 {
+			t.Errorf("%s: GitHub repository ID %v", tt.fixture, project.GitHubRepoID)
+		}
+	}
+
+	var moves []models.AuditLog
+	database.DB.Where("action = ?", "project.repository_moved").Order("id").Find(&moves)
+	want := []string{"acme/app -> acme/shop", "acme/shop -> Acme-Retail/shop"}
+	if len(moves) != len(want) {
+		t.Fatalf("%d moves audited", len(moves))
+	}
+	for i, move := range moves {
+		if move.UserID != s.project.UserID || move.Details != fmt.Sprintf("project %d: %s", s.project.ID, want[i]) {
+			t.Errorf("audited %+v", move)
+		}
+	}
+
+	w := s.deliver("push", json.RawMessage(push))
+	if w.Code != http.StatusOK || len(s.deployments.created) != 1 {
+		t.Fatalf("push after transfer: got %d: %s", w.Code, w.Body.String())
+	}
+	if deployment := s.deployments.created[0]; deployment.ProjectID != s.project.ID {
+		t.Errorf("push deployed project %d", deployment.ProjectID)
+	}
+}
+
+func TestRepositoryEventIgnored(t *testing.T) {
+	renamed := fixture(t, "repository_renamed.json")
+	s := repositorySetup(t)
+
+	var edited map[string]any
+	json.Unmarshal(renamed, &edited)
+	edited["action"] = "edited"
+	if w := s.deliver("repository", edited); w.Code != http.StatusOK || response(t, w)["message"] != "Event ignored" {
+		t.Errorf("edited: got %d: %s", w.Code, w.Body.String())
+	}
+	if project := s.storedProject(); project.RepoName != "app" || project.GitHubRepoID != nil {
+		t.Errorf("edited repository changed the project: %+v", project)
+	}
+
+	// A renamed repository no project was linked to
+	var other map[string]any
+	json.Unmarshal(renamed, &other)
+	other["repository"].(map[string]any)["id"] = 42
+	other["changes"] = map[string]any{"repository": map[string]any{"name": map[string]any{"from": "blog"}}}
+	if w := s.deliver("repository", other); w.Code != http.StatusNotFound {
+		t.Errorf("unknown repository: got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,103 @@
+package github
+
+import (
+	"deploy-platform/internal/audit"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RollbackDeployment rolls a project back to the image of one of its earlier
+// deployments: a deploy-only redeploy of it, nothing is cloned or built. The
+// new deployment records the one live before it (RolledBackFromID), takes
+// over its hostname once it is live, and waits for approval like any other
+// rollout to production.
+func (h *WebhookHandler) RollbackDeployment(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	deploymentID, err := strconv.ParseUint(c.Param("deploymentID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deployment ID"})
+		return
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if project.UserID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	var target models.Deployment
+	if err := database.DB.Where("project_id = ?", project.ID).First(&target, deploymentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	target.Project = project
+	if !h.redeployableImage(c, &target, http.StatusConflict) {
+		return
+	}
+
+	live, err := liveDeployment(&target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up the live deployment: " + err.Error()})
+		return
+	}
+	if live != nil && *live == target.ID {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Deployment %d is already live", target.ID)})
+		return
+	}
+
+	deployment := redeployment(c, &target)
+	deployImage(deployment, &target)
+	deployment.RolledBackFromID = live
+	if !h.createDeployment(c, &project, deployment) {
+		return
+	}
+
+	from := "nothing live"
+	if live != nil {
+		from = fmt.Sprintf("deployment %d", *live)
+	}
+	audit.FromContext(c, "deployment.rollback", fmt.Sprintf("project %d from %s to deployment %d (%s, image %s) as deployment %d", project.ID, from, target.ID, textutil.ShortSHA(target.CommitSHA), target.ImageTag, deployment.ID))
+	log.Printf("⏪ Deployment %d rolls project %d back from %s to deployment %d (%s)", deployment.ID, project.ID, from, target.ID, target.ImageTag)
+
+	h.enqueueDeployment(&project, deployment, true)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":             "Rollback triggered",
+		"deployment":          deployment,
+		"rollback_to":         target.ID,
+		"rolled_back_from_id": live,
+	})
+}
+
+// liveDeployment is the ID of the deployment live in the resources target
+// was rolled out to, nil when none is
+func liveDeployment(target *models.Deployment) (*uint, error) {
+	if target.K8sDeploymentName == "" {
+		return nil, nil
+	}
+	var activation models.DeploymentActivation
+	err := database.DB.Where("project_id = ? AND resource = ? AND deactivated_at IS NULL", target.ProjectID, target.K8sDeploymentName).
+		Order("activated_at DESC").First(&activation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &activation.DeploymentID, nil
+}
@@ -8,56 +8,39 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"deploy-platform/internal/build"
-	"deploy-platform/internal/config"
-	"deploy-platform/internal/database"
+	"deploy-platform/internal/hooks"
+	"deploy-platform/internal/incidents"
 	"deploy-platform/internal/models"
 	"deploy-platform/internal/queue"
+	"deploy-platform/internal/quota"
+	"deploy-platform/internal/textutil"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-github/v56/github"
 )
 
-var (
-	webhookSecret string
-	buildService  *build.Service
-	buildQueue    queue.BuildQueue
-)
-
-// InitWebhook initializes webhook secret from config
-func InitWebhook(cfg *config.Config) {
-	webhookSecret = cfg.WebhookSecret
-	if webhookSecret == "" {
-		webhookSecret = "nncfebvjhebhjvrevjejrvhjelv" // Default for development
-	}
-}
-
-// InitBuildService initializes the build service for webhook handlers
-func InitBuildService() error {
-	bs, err := build.NewService()
-	if err != nil {
-		return fmt.Errorf("failed to initialize build service: %w", err)
-	}
-	buildService = bs
-	return nil
-}
+// ProjectArchived is the code of the response refusing deployments of an
+// archived project
+const ProjectArchived = "project_archived"
 
-// InitBuildServiceWithService sets the build service instance directly
-func InitBuildServiceWithService(bs *build.Service) {
-	buildService = bs
-}
+// InvalidCommitSHA is the code of the response refusing to deploy or analyze
+// a commit whose SHA isn't one
+const InvalidCommitSHA = "invalid_commit_sha"
 
-// InitBuildQueue sets the build queue instance
-func InitBuildQueue(q queue.BuildQueue) {
-	buildQueue = q
-}
+// errInvalidSHA marks parsePush errors about the pushed commit's SHA
+var errInvalidSHA = errors.New("invalid commit SHA")
 
-func HandleWebhook(c *gin.Context) {
+// HandleWebhook verifies and processes a GitHub webhook delivery, recording
+// the events that are retried when processing them fails
+func (h *WebhookHandler) HandleWebhook(c *gin.Context) {
 	// Verify webhook signature
 	signature := c.GetHeader("X-Hub-Signature-256")
 	body, err := io.ReadAll(c.Request.Body)
@@ -66,148 +49,378 @@ func HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	if !verifySignature(signature, body) {
+	if !verifySignature(h.secret(), signature, body) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
 		return
 	}
 
 	event := c.GetHeader("X-GitHub-Event")
+	if !recordedEvent(event) {
+		h.dispatchEvent(c, event, body)
+		return
+	}
 
+	// Recorded before processing, so a failure can be retried
+	record := recordEvent(event, c.GetHeader("X-GitHub-Delivery"), body)
+	response := &capturedResponse{ResponseWriter: c.Writer}
+	c.Writer = response
+	h.dispatchEvent(c, event, body)
+	if record != nil {
+		h.finishEvent(record, response.Status(), response.body.Bytes())
+	}
+}
+
+// dispatchEvent processes a verified event, writing the response
+func (h *WebhookHandler) dispatchEvent(c *gin.Context, event string, body []byte) {
 	switch event {
 	case "push":
-		handlePushEvent(c, body)
+		h.handlePushEvent(c, body)
+	case "delete":
+		h.handleDeleteEvent(c, body)
+	case "repository":
+		h.handleRepositoryEvent(c, body)
+	case "pull_request":
+		h.handlePullRequestEvent(c, body)
 	default:
 		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
 	}
 }
 
-func handlePushEvent(c *gin.Context, body []byte) {
+// pushedCommit is what a push event is about: a branch's new head commit,
+// or the deletion of the branch
+type pushedCommit struct {
+	Repo    RepoIdentity
+	Ref     string
+	Deleted bool // The branch was deleted; the other fields are empty
+	SHA     string
+	Message string
+	Branch  string
+	Actor   string
+	Forced  bool // The push rewrote the branch's history
+}
+
+// parsePush reads a push event. Its errors are about the payload, which
+// retrying won't fix.
+func parsePush(body []byte) (*pushedCommit, error) {
 	event, err := github.ParseWebHook("push", body)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse webhook: " + err.Error()})
-		return
+		return nil, errors.New("Failed to parse webhook: " + err.Error())
 	}
 
 	// Type assert to PushEvent
 	pushEvent, ok := event.(*github.PushEvent)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unexpected event type"})
-		return
+		return nil, errors.New("Unexpected event type")
 	}
 
 	// Handle nil pointers safely
 	if pushEvent.Repo == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Repository information missing"})
-		return
+		return nil, errors.New("Repository information missing")
 	}
-
 	if pushEvent.Repo.Owner == nil || pushEvent.Repo.Owner.Login == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Repository owner information missing"})
-		return
+		return nil, errors.New("Repository owner information missing")
 	}
-
 	if pushEvent.Repo.Name == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Repository name missing"})
-		return
+		return nil, errors.New("Repository name missing")
+	}
+	push := &pushedCommit{Repo: pushRepoIdentity(pushEvent.Repo)}
+	if pushEvent.Ref != nil {
+		push.Ref = *pushEvent.Ref
 	}
 
-	if pushEvent.HeadCommit == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Head commit information missing"})
-		return
+	// A push that deletes a branch carries no head commit
+	if pushEvent.Deleted != nil && *pushEvent.Deleted && pushEvent.Ref != nil {
+		push.Deleted = true
+		return push, nil
 	}
 
+	if pushEvent.HeadCommit == nil {
+		return nil, errors.New("Head commit information missing")
+	}
 	if pushEvent.HeadCommit.ID == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Commit SHA missing"})
+		return nil, errors.New("Commit SHA missing")
+	}
+	if push.SHA, err = textutil.NormalizeSHA(*pushEvent.HeadCommit.ID); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSHA, err)
+	}
+	if pushEvent.HeadCommit.Message != nil {
+		push.Message = *pushEvent.HeadCommit.Message
+	}
+
+	// Parse branch from ref (e.g., "refs/heads/feature/x" -> "feature/x")
+	push.Branch = strings.TrimPrefix(push.Ref, "refs/heads/")
+	if push.Branch == "" {
+		push.Branch = "main" // Default branch
+	}
+
+	push.Forced = pushEvent.GetForced()
+
+	// Who pushed, for the deployment history and deploy policies
+	if pushEvent.Sender != nil {
+		push.Actor = pushEvent.Sender.GetLogin()
+	}
+	if push.Actor == "" && pushEvent.Pusher != nil && pushEvent.Pusher.Name != nil {
+		push.Actor = *pushEvent.Pusher.Name
+	}
+	return push, nil
+}
+
+// pushDeployment is the deployment of a pushed commit. Its hostname is
+// assigned when it is deployed.
+func pushDeployment(project *models.Project, push *pushedCommit) *models.Deployment {
+	return &models.Deployment{
+		ProjectID: project.ID,
+		Status:    models.StatusPending,
+		CommitSHA: push.SHA,
+		CommitMsg: push.Message,
+		Branch:    push.Branch,
+		Trigger:   models.TriggerPush,
+		Actor:     push.Actor,
+	}
+}
+
+func (h *WebhookHandler) handlePushEvent(c *gin.Context, body []byte) {
+	push, err := parsePush(body)
+	if errors.Is(err, errInvalidSHA) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": InvalidCommitSHA})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only branches deploy: pushing or deleting a tag changes none of them
+	if push.Ref != "" && !strings.HasPrefix(push.Ref, "refs/heads/") {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored", "reason": push.Ref + " is not a branch"})
+		return
+	}
+	if push.Deleted {
+		h.teardownBranch(c, push.Repo, push.Ref)
 		return
 	}
 
 	// Find project by repo
-	var project models.Project
-	result := database.DB.Where("repo_owner = ? AND repo_name = ?",
-		*pushEvent.Repo.Owner.Login, *pushEvent.Repo.Name).First(&project)
-
-	if result.Error != nil {
+	project, err := h.projects.FindByRepo(push.Repo)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found for repository"})
 		return
 	}
-
-	// Parse branch from ref (e.g., "refs/heads/main" -> "main")
-	branch := ""
-	if pushEvent.Ref != nil {
-		parts := strings.Split(*pushEvent.Ref, "/")
-		if len(parts) > 0 {
-			branch = parts[len(parts)-1]
-		}
+	if ignoredBranch(project, push.Branch) {
+		c.JSON(http.StatusOK, gin.H{"message": "branch ignored", "branch": push.Branch})
+		return
 	}
-	if branch == "" {
-		branch = "main" // Default branch
+	if project.AnalysisOnly {
+		h.analyzePush(c, project, push)
+		return
 	}
+	h.triggerDeployment(c, project, push)
+}
 
-	// Get commit message safely
-	commitMsg := ""
-	if pushEvent.HeadCommit.Message != nil {
-		commitMsg = *pushEvent.HeadCommit.Message
+// ignoredBranch reports whether pushes to branch are ignored: only the
+// project's branch deploys, unless it opted into branch deployments
+func ignoredBranch(project *models.Project, branch string) bool {
+	return !project.BranchDeploys && project.Branch != "" && branch != project.Branch
+}
+
+// triggerDeployment creates the deployment of a pushed commit and queues its
+// build, unless the project's deploy policy blocks it
+func (h *WebhookHandler) triggerDeployment(c *gin.Context, project *models.Project, push *pushedCommit) {
+	deployment := pushDeployment(project, push)
+	if !h.createDeployment(c, project, deployment) {
+		return
 	}
+	h.linkPullRequest(project, deployment)
+	if h.applyPolicy(c.Request.Context(), project, deployment, push) {
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Deployment blocked by the deploy policy, awaiting approval",
+			"code":       PolicyBlocked,
+			"deployment": deployment,
+		})
+		return
+	}
+	h.enqueueDeployment(project, deployment, false)
 
-	// Hostname will be assigned during deployment by hostname manager
-	// For now, leave it empty - it will be set when deployment is processed
-	hostname := ""
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Deployment triggered",
+		"deployment": deployment,
+	})
+}
 
-	// Create deployment
-	deployment := &models.Deployment{
-		ProjectID: project.ID,
-		Status:    "pending",
-		CommitSHA: *pushEvent.HeadCommit.ID,
-		CommitMsg: commitMsg,
-		Branch:    branch,
-		Hostname:  hostname,
+// createDeployment stores a new deployment after checking its commit SHA,
+// that the project isn't archived and the owner's daily quota. On failure it
+// writes the error response and returns false.
+func (h *WebhookHandler) createDeployment(c *gin.Context, project *models.Project, deployment *models.Deployment) bool {
+	if err := deployment.NormalizeCommitSHA(); err != nil {
+		log.Printf("⚠️  Deployment for project %d rejected: %v", project.ID, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": InvalidCommitSHA})
+		return false
+	}
+	if project.Archived() {
+		log.Printf("⚠️  Deployment for project %d rejected: the project is archived", project.ID)
+		c.JSON(http.StatusConflict, gin.H{"error": "The project is archived, unarchive it to deploy again", "code": ProjectArchived})
+		return false
 	}
 
-	if err := database.DB.Create(deployment).Error; err != nil {
+	// Enforce the owner's daily deployment quota
+	if err := quota.CheckDeployment(project); err != nil {
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			log.Printf("⚠️  Deployment for project %d rejected: %v", project.ID, err)
+			c.JSON(exceeded.StatusCode(), exceeded.Body())
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check deployment quota: " + err.Error()})
+		return false
+	}
+
+	// Commit messages are the pusher's: never stored raw
+	deployment.NormalizeCommitMsg()
+	// Its wait is explained by the incident, not counted against the platform
+	deployment.DelayedByIncident = incidents.Ongoing()
+	if err := h.deployments.Create(deployment); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create deployment: " + err.Error()})
-		return
+		return false
 	}
+	quota.RecordDeployment(project.ID)
 
-	// Enqueue build job (will be processed by worker pool)
-	if buildQueue != nil {
-		if err := buildQueue.Enqueue(deployment.ID); err != nil {
-			log.Printf("❌ Failed to enqueue deployment %d: %v", deployment.ID, err)
-			database.DB.Model(&models.Deployment{}).Where("id = ?", deployment.ID).Update("status", "failed")
+	// The new deployment wins over builds of older commits of its branch
+	for _, id := range build.SupersedeBuilds(deployment) {
+		if h.workers != nil {
+			h.workers.CancelJob(id, build.ErrSuperseded)
+		}
+		h.reports.Go(func() { h.reportBuildAsync(id) })
+	}
+	return true
+}
+
+// enqueueDeployment hands a created deployment of project to the build
+// queue, ahead of pushed commits when high is set, or builds it directly
+// without a queue
+func (h *WebhookHandler) enqueueDeployment(project *models.Project, deployment *models.Deployment, high bool) {
+	deploymentID := deployment.ID
+	// Marked queued first: a worker may pick it up before Enqueue even returns.
+	if h.queue != nil {
+		h.deployments.SetStatus(deploymentID, models.StatusQueued, "waiting for a build slot")
+		job := queue.Job{DeploymentID: deploymentID, Priority: queue.PriorityNormal}
+		if high {
+			job.Priority = queue.PriorityHigh
+		}
+		// Production deployments go first and may take the reserved slots
+		withProject := *deployment
+		withProject.Project = *project
+		job.Production = build.ForProduction(&withProject)
+		if err := h.queue.Enqueue(job); err != nil {
+			log.Printf("❌ Failed to enqueue deployment %d: %v", deploymentID, err)
+			h.deployments.SetStatus(deploymentID, models.StatusFailed, "failed to enqueue: "+err.Error())
 		} else {
-			log.Printf("✅ Deployment %d enqueued for build", deployment.ID)
+			log.Printf("✅ Deployment %d enqueued for build", deploymentID)
 		}
-	} else if buildService != nil {
+	} else if h.builds != nil {
 		// Fallback to direct build if queue not available
-		go func(deploymentID uint) {
-			ctx := context.Background()
-			if err := buildService.BuildDeployment(ctx, deploymentID); err != nil {
-				log.Printf("❌ Build failed for deployment %d: %v", deploymentID, err)
-				database.DB.Model(&models.Deployment{}).Where("id = ?", deploymentID).Update("status", "failed")
-			} else {
-				log.Printf("✅ Build completed successfully for deployment %d", deploymentID)
-			}
-		}(deployment.ID)
+		go h.buildDirectly(deploymentID)
 	} else {
 		log.Println("⚠️  Build service not initialized, skipping build")
 	}
+	h.reports.Go(func() { h.reportBuildAsync(deploymentID) })
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":    "Deployment triggered",
-		"deployment": deployment,
-	})
+// buildDirectly builds and deploys a deployment without the build queue.
+// A rollout deferred while the cluster API is unavailable is retried once
+// it is due.
+func (h *WebhookHandler) buildDirectly(deploymentID uint) {
+	err := h.builds.BuildDeployment(context.Background(), deploymentID)
+	var deferred *build.DeployDeferredError
+	switch {
+	case errors.As(err, &deferred):
+		time.AfterFunc(time.Until(deferred.RetryAt), func() { h.buildDirectly(deploymentID) })
+	case err != nil:
+		log.Printf("❌ Build failed for deployment %d: %v", deploymentID, err)
+		status := models.StatusFailed
+		if errors.Is(err, build.ErrSuperseded) {
+			status = models.StatusSuperseded
+		}
+		if h.deployments.SetStatus(deploymentID, status, err.Error()) == nil && status == models.StatusFailed {
+			build.CountFailure(err)
+			hooks.Failed(deploymentID)
+		}
+	default:
+		log.Printf("✅ Build completed successfully for deployment %d", deploymentID)
+	}
+}
+
+func (h *WebhookHandler) handleDeleteEvent(c *gin.Context, body []byte) {
+	event, err := github.ParseWebHook("delete", body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse webhook: " + err.Error()})
+		return
+	}
+
+	deleteEvent, ok := event.(*github.DeleteEvent)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unexpected event type"})
+		return
+	}
+
+	if deleteEvent.RefType == nil || *deleteEvent.RefType != "branch" || deleteEvent.Ref == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	if deleteEvent.Repo == nil || deleteEvent.Repo.Owner == nil || deleteEvent.Repo.Owner.Login == nil || deleteEvent.Repo.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repository information missing"})
+		return
+	}
+
+	h.teardownBranch(c, repositoryIdentity(deleteEvent.Repo), *deleteEvent.Ref)
+}
+
+// teardownBranch retires the alias and resources of a deleted branch
+func (h *WebhookHandler) teardownBranch(c *gin.Context, repo RepoIdentity, ref string) {
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+
+	project, err := h.projects.FindByRepo(repo)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found for repository"})
+		return
+	}
+
+	if err := h.retireBranch(project, branch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate branch alias: " + err.Error()})
+		return
+	}
+
+	log.Printf("✅ Branch %s of project %d deleted, alias deactivated", branch, project.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Branch alias deactivated"})
+}
+
+// retireBranch deactivates the alias of a branch and removes its resources;
+// failing to remove them is only logged
+func (h *WebhookHandler) retireBranch(project *models.Project, branch string) error {
+	if h.hostnames != nil {
+		if err := h.hostnames.DeactivateBranchAlias(project.ID, branch); err != nil {
+			return err
+		}
+	}
+	if h.builds != nil && branch != project.Branch {
+		if err := h.builds.TeardownBranch(context.Background(), project.ID, branch); err != nil {
+			log.Printf("⚠️  Failed to remove resources of branch %s (project %d): %v", branch, project.ID, err)
+		}
+	}
+	return nil
 }
 
-func verifySignature(signature string, body []byte) bool {
+// verifySignature checks the signature GitHub computed of body with secret
+func verifySignature(secret, signature string, body []byte) bool {
 	if signature == "" {
 		return false
 	}
 
-	if webhookSecret == "" {
+	if secret == "" {
 		// In development, allow requests without secret
 		return true
 	}
 
-	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac := hmac.New(sha256.New, []byte(secret))
 	mac.Write(body)
 	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
 
@@ -0,0 +1,510 @@
+package github
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"deploy-platform/internal/quota"
+	"deploy-platform/internal/testutil"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSecret = "webhook-secret"
+
+// fakeProjects finds the one project it holds
+type fakeProjects struct {
+	project *models.Project
+}
+
+func (f *fakeProjects) FindByRepo(repo RepoIdentity, previous ...RepoIdentity) (*models.Project, error) {
+	if f.project == nil || repo.Owner != f.project.RepoOwner || repo.Name != f.project.RepoName {
+		return nil, errors.New("record not found")
+	}
+	return f.project, nil
+}
+
+func (f *fakeProjects) FindByWebhookToken(token string) (*models.Project, error) {
+	if f.project == nil || token != "token-"+f.project.Name {
+		return nil, errors.New("record not found")
+	}
+	return f.project, nil
+}
+
+// fakeDeployments keeps the deployments created and their statuses
+type fakeDeployments struct {
+	mu       sync.Mutex
+	created  []*models.Deployment
+	statuses map[uint]models.DeploymentStatus
+}
+
+func (f *fakeDeployments) Create(deployment *models.Deployment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deployment.ID = uint(1000 + len(f.created))
+	f.created = append(f.created, deployment)
+	return nil
+}
+
+func (f *fakeDeployments) SetStatus(id uint, status models.DeploymentStatus, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.statuses == nil {
+		f.statuses = make(map[uint]models.DeploymentStatus)
+	}
+	f.statuses[id] = status
+	return nil
+}
+
+// webhookSetup is a handler whose projects, deployments and queue are fakes
+type webhookSetup struct {
+	t           *testing.T
+	handler     *WebhookHandler
+	project     *models.Project
+	deployments *fakeDeployments
+	queue       *queue.InMemoryQueue
+}
+
+// newWebhookSetup creates a handler deploying project acme/app from main.
+// The quota is checked against its owner in the test database.
+func newWebhookSetup(t *testing.T) *webhookSetup {
+	t.Helper()
+	testutil.DB(t)
+	gin.SetMode(gin.TestMode)
+	if err := quota.Init(&config.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	owner := &models.User{Email: "ada@example.com", Username: "ada"}
+	if err := database.DB.Create(owner).Error; err != nil {
+		t.Fatal(err)
+	}
+	s := &webhookSetup{
+		t:           t,
+		project:     &models.Project{UserID: owner.ID, Name: "app", RepoOwner: "acme", RepoName: "app", Branch: "main"},
+		deployments: &fakeDeployments{},
+		queue:       queue.NewInMemoryQueue(),
+	}
+	if err := database.DB.Create(s.project).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.handler = s.newHandler(WebhookDeps{
+		Secret:      StaticSecret(testSecret),
+		Projects:    &fakeProjects{project: s.project},
+		Deployments: s.deployments,
+		Queue:       s.queue,
+	})
+	return s
+}
+
+// newHandler creates a handler with deps, whose build reports are done
+// before the test database is closed
+func (s *webhookSetup) newHandler(deps WebhookDeps) *WebhookHandler {
+	h := NewWebhookHandler(&config.Config{}, deps)
+	s.t.Cleanup(h.reports.Wait)
+	return h
+}
+
+// deliver sends a signed GitHub event, returning the response
+func (s *webhookSetup) deliver(event string, payload any) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write(body)
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return s.serve(req)
+}
+
+func (s *webhookSetup) serve(req *http.Request) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/webhooks/github", s.handler.HandleWebhook)
+	r.POST("/webhooks/generic/:projectToken", s.handler.HandleGenericWebhook)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// pushPayload is a push of a new commit to ref of acme/app
+func pushPayload(ref string) map[string]any {
+	return map[string]any{
+		"ref":         ref,
+		"head_commit": map[string]any{"id": "0123456789abcdef0123456789abcdef01234567", "message": "Fix the build"},
+		"repository":  map[string]any{"name": "app", "owner": map[string]any{"login": "acme"}},
+		"sender":      map[string]any{"login": "ada"},
+	}
+}
+
+// response decodes a JSON response
+func response(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+func TestPushRefs(t *testing.T) {
+	tests := []struct {
+		ref           string
+		branchDeploys bool
+		message       string
+		branch        string // Of the deployment, "" if none is created
+	}{
+		{ref: "refs/heads/main", message: "Deployment triggered", branch: "main"},
+		{ref: "refs/heads/feature/foo", message: "branch ignored"},
+		{ref: "refs/heads/mainline", message: "branch ignored"},
+		{ref: "refs/heads/feature/foo", branchDeploys: true, message: "Deployment triggered", branch: "feature/foo"},
+		{ref: "refs/tags/v1.0", message: "Event ignored"},
+		{ref: "refs/tags/v1.0", branchDeploys: true, message: "Event ignored"},
+		{ref: "refs/tags/main", message: "Event ignored"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s branch_deploys=%t", tt.ref, tt.branchDeploys), func(t *testing.T) {
+			s := newWebhookSetup(t)
+			s.project.BranchDeploys = tt.branchDeploys
+
+			w := s.deliver("push", pushPayload(tt.ref))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+			body := response(t, w)
+			if body["message"] != tt.message {
+				t.Fatalf("got message %q, want %q", body["message"], tt.message)
+			}
+			if tt.branch == "" {
+				if len(s.deployments.created) != 0 || s.queue.Size() != 0 {
+					t.Fatalf("push created %d deployments, queued %d", len(s.deployments.created), s.queue.Size())
+				}
+				return
+			}
+			if len(s.deployments.created) != 1 {
+				t.Fatalf("push created %d deployments", len(s.deployments.created))
+			}
+			if deployment := s.deployments.created[0]; deployment.Branch != tt.branch {
+				t.Fatalf("deployed branch %q, want %q", deployment.Branch, tt.branch)
+			}
+			if s.queue.Size() != 1 {
+				t.Fatalf("queued %d builds", s.queue.Size())
+			}
+		})
+	}
+}
+
+func TestPushIgnoredBranchResponse(t *testing.T) {
+	s := newWebhookSetup(t)
+	body := response(t, s.deliver("push", pushPayload("refs/heads/feature/foo")))
+	if body["branch"] != "feature/foo" {
+		t.Fatalf("got branch %q", body["branch"])
+	}
+}
+
+func TestGenericWebhookBranches(t *testing.T) {
+	for _, tt := range []struct {
+		ref, message string
+	}{
+		{"main", "Deployment triggered"},
+		{"refs/heads/main", "Deployment triggered"},
+		{"refs/heads/feature/foo", "branch ignored"},
+		{"feature/foo", "branch ignored"},
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			s := newWebhookSetup(t)
+			payload, _ := json.Marshal(GenericPushRequest{Ref: tt.ref, SHA: "0123456789abcdef0123456789abcdef01234567"})
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/generic/token-app", bytes.NewReader(payload))
+			w := s.serve(req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", w.Code, w.Body.String())
+			}
+			if body := response(t, w); body["message"] != tt.message {
+				t.Fatalf("got message %q, want %q", body["message"], tt.message)
+			}
+		})
+	}
+}
+
+func TestWebhookInvalidSignature(t *testing.T) {
+	s := newWebhookSetup(t)
+	body, _ := json.Marshal(pushPayload("refs/heads/main"))
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(make([]byte, sha256.Size)))
+	if w := s.serve(req); w.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if len(s.deployments.created) != 0 {
+		t.Fatal("unsigned push created a deployment")
+	}
+}
+
+// fixture reads a payload from testdata
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestParsePush(t *testing.T) {
+	push, err := parsePush(fixture(t, "push.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pushedCommit{
+		Repo:    RepoIdentity{ID: 1296269, Owner: "acme", Name: "app", HTMLURL: "https://github.com/acme/app", CloneURL: "https://github.com/acme/app.git"},
+		Ref:     "refs/heads/feature/login",
+		SHA:     "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+		Message: "Add the login form\n\nWith a remember-me box.",
+		Branch:  "feature/login",
+		Actor:   "ada",
+		Forced:  true,
+	}
+	if *push != *want {
+		t.Fatalf("got %+v\nwant %+v", push, want)
+	}
+}
+
+func TestParsePushDeletedBranch(t *testing.T) {
+	push, err := parsePush(fixture(t, "push_deleted.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !push.Deleted || push.Ref != "refs/heads/feature/login" || push.SHA != "" {
+		t.Fatalf("got %+v", push)
+	}
+}
+
+func TestParsePushGuards(t *testing.T) {
+	const repo = `"repository": {"name": "app", "owner": {"login": "acme"}}`
+	const commit = `"head_commit": {"id": "0123456789abcdef0123456789abcdef01234567"}`
+	tests := []struct {
+		name    string
+		payload string
+		err     string
+	}{
+		{"not JSON", `{"ref": `, "Failed to parse webhook"},
+		{"no repository", `{"ref": "refs/heads/main", ` + commit + `}`, "Repository information missing"},
+		{"no owner", `{"ref": "refs/heads/main", "repository": {"name": "app"}, ` + commit + `}`, "Repository owner information missing"},
+		{"no owner login", `{"ref": "refs/heads/main", "repository": {"name": "app", "owner": {}}, ` + commit + `}`, "Repository owner information missing"},
+		{"no repository name", `{"ref": "refs/heads/main", "repository": {"owner": {"login": "acme"}}, ` + commit + `}`, "Repository name missing"},
+		{"no head commit", `{"ref": "refs/heads/main", ` + repo + `}`, "Head commit information missing"},
+		{"deleted without ref", `{"deleted": true, ` + repo + `}`, "Head commit information missing"},
+		{"no commit SHA", `{"ref": "refs/heads/main", ` + repo + `, "head_commit": {"message": "x"}}`, "Commit SHA missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePush([]byte(tt.payload))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+			if errors.Is(err, errInvalidSHA) {
+				t.Fatal("payload error reported as an invalid SHA")
+			}
+		})
+	}
+
+	_, err := parsePush([]byte(`{"ref": "refs/heads/main", ` + repo + `, "head_commit": {"id": "not-a-sha"}}`))
+	if !errors.Is(err, errInvalidSHA) {
+		t.Fatalf("got %v, want errInvalidSHA", err)
+	}
+}
+
+func TestParsePushDefaults(t *testing.T) {
+	// Without a ref the push is to main; without a sender the pusher pushed it
+	push, err := parsePush([]byte(`{"repository": {"name": "app", "owner": {"login": "acme"}},
+		"head_commit": {"id": "0123456789abcdef0123456789abcdef01234567"}, "pusher": {"name": "ci-bot"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if push.Branch != "main" || push.Actor != "ci-bot" || push.Forced {
+		t.Fatalf("got %+v", push)
+	}
+}
+
+func TestPushDeployment(t *testing.T) {
+	project := &models.Project{ID: 7}
+	push := &pushedCommit{SHA: "0123456789abcdef0123456789abcdef01234567", Message: "Fix", Branch: "main", Actor: "ada"}
+	deployment := pushDeployment(project, push)
+	if deployment.ProjectID != 7 || deployment.Status != models.StatusPending || deployment.Trigger != models.TriggerPush ||
+		deployment.CommitSHA != push.SHA || deployment.CommitMsg != "Fix" || deployment.Branch != "main" || deployment.Actor != "ada" {
+		t.Fatalf("got %+v", deployment)
+	}
+	if deployment.Hostname != "" {
+		t.Fatalf("hostname %q assigned before the deployment is deployed", deployment.Hostname)
+	}
+}
+
+// failingQueue refuses every job
+type failingQueue struct {
+	*queue.InMemoryQueue
+}
+
+func (failingQueue) Enqueue(queue.Job) error {
+	return errors.New("queue full")
+}
+
+func TestPushQueuesDeployment(t *testing.T) {
+	s := newWebhookSetup(t)
+	s.deliver("push", pushPayload("refs/heads/main"))
+
+	deployment := s.deployments.created[0]
+	if status := s.deployments.statuses[deployment.ID]; status != models.StatusQueued {
+		t.Fatalf("deployment is %s, want queued", status)
+	}
+	job, ok := s.queue.TryDequeue()
+	if !ok || job.DeploymentID != deployment.ID || job.Priority != queue.PriorityNormal || !job.Production {
+		t.Fatalf("got job %+v", job)
+	}
+}
+
+func TestPushEnqueueFailure(t *testing.T) {
+	s := newWebhookSetup(t)
+	s.handler.queue = failingQueue{s.queue}
+	s.deliver("push", pushPayload("refs/heads/main"))
+
+	deployment := s.deployments.created[0]
+	if status := s.deployments.statuses[deployment.ID]; status != models.StatusFailed {
+		t.Fatalf("deployment is %s, want failed", status)
+	}
+}
+
+func TestWebhookSecretRotation(t *testing.T) {
+	s := newWebhookSetup(t)
+	secret := testSecret
+	s.handler.secret = func() string { return secret }
+	if w := s.deliver("push", pushPayload("refs/heads/main")); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Deliveries signed with the old secret are refused at once
+	secret = "rotated"
+	if w := s.deliver("push", pushPayload("refs/heads/main")); w.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// Two handlers with their own wiring, as two test servers would have, don't
+// see each other's deployments
+func TestHandlersWiredIndependently(t *testing.T) {
+	s := newWebhookSetup(t)
+	other := &fakeDeployments{}
+	otherQueue := queue.NewInMemoryQueue()
+	s.handler = s.newHandler(WebhookDeps{
+		Secret:      StaticSecret(testSecret),
+		Projects:    &fakeProjects{project: s.project},
+		Deployments: other,
+		Queue:       otherQueue,
+	})
+	s.deliver("push", pushPayload("refs/heads/main"))
+
+	s.handler = s.newHandler(WebhookDeps{
+		Secret:      StaticSecret(testSecret),
+		Projects:    &fakeProjects{project: s.project},
+		Deployments: s.deployments,
+		Queue:       s.queue,
+	})
+	s.deliver("push", pushPayload("refs/heads/main"))
+
+	if len(other.created) != 1 || otherQueue.Size() != 1 || len(s.deployments.created) != 1 || s.queue.Size() != 1 {
+		t.Fatalf("deployments %d and %d, queued %d and %d", len(other.created), len(s.deployments.created), otherQueue.Size(), s.queue.Size())
+	}
+}
+
+// invalidSHAs are commit SHAs no entry point may create a deployment of
+var invalidSHAs = map[string]string{
+	"empty":     "",
+	"short":     "01234a",
+	"non-hex":   "0123456789abcdefg123456789abcdef01234567",
+	"over-long": strings.Repeat("ab", 33),
+}
+
+// rejectedSHA checks a response refused an invalid SHA, creating and
+// queueing nothing
+func (s *webhookSetup) rejectedSHA(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if w.Code != http.StatusUnprocessableEntity || response(t, w)["code"] != InvalidCommitSHA {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	if len(s.deployments.created) != 0 || s.queue.Size() != 0 {
+		t.Errorf("created %d deployments, queued %d", len(s.deployments.created), s.queue.Size())
+	}
+}
+
+// Pushes, generic webhooks, manual deploys and redeploys refuse invalid
+// commit SHAs with a 422 before anything reaches the builder
+func TestInvalidCommitSHAs(t *testing.T) {
+	for name, sha := range invalidSHAs {
+		t.Run(name, func(t *testing.T) {
+			t.Run("push", func(t *testing.T) {
+				s := newWebhookSetup(t)
+				payload := pushPayload("refs/heads/main")
+				payload["head_commit"] = map[string]any{"id": sha, "message": "Fix the build"}
+				s.rejectedSHA(t, s.deliver("push", payload))
+			})
+			t.Run("generic", func(t *testing.T) {
+				s := newWebhookSetup(t)
+				payload, _ := json.Marshal(GenericPushRequest{Ref: "main", SHA: sha})
+				w := s.serve(httptest.NewRequest(http.MethodPost, "/webhooks/generic/token-app", bytes.NewReader(payload)))
+				if sha == "" {
+					// A request without its required sha is malformed
+					if w.Code != http.StatusBadRequest || len(s.deployments.created) != 0 {
+						t.Errorf("got %d: %s", w.Code, w.Body.String())
+					}
+					return
+				}
+				s.rejectedSHA(t, w)
+			})
+			t.Run("manual", func(t *testing.T) {
+				// A resolver answering with something else than a SHA
+				s := deployRefSetup(t)
+				resolver := &fakeRefResolver{t: t, branches: map[string]string{"main": sha}}
+				s.handler.newRefResolver = func(string) RefResolver { return resolver }
+				s.rejectedSHA(t, s.deployRef(s.project.UserID, `{"ref": "main"}`))
+			})
+			t.Run("redeploy", func(t *testing.T) {
+				// Of a deployment stored before SHAs were checked
+				s := newWebhookSetup(t)
+				source := &models.Deployment{ProjectID: s.project.ID, Status: models.StatusFailed, CommitSHA: sha, Branch: "main"}
+				database.DB.Create(source)
+				r := gin.New()
+				r.POST("/deployments/:id/redeploy", func(c *gin.Context) { c.Set("user_id", s.project.UserID) }, s.handler.RedeployDeployment)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/deployments/%d/redeploy", source.ID), nil))
+				s.rejectedSHA(t, w)
+			})
+		})
+	}
+}
+
+// Valid SHAs are stored lowercased with their short form, abbreviated and
+// SHA-256 ones included
+func TestCommitSHANormalized(t *testing.T) {
+	for _, sha := range []string{"0123456789ABCDEF0123456789ABCDEF01234567", "89AbCdE", strings.Repeat("Ab", 32)} {
+		s := newWebhookSetup(t)
+		payload, _ := json.Marshal(GenericPushRequest{Ref: "main", SHA: sha})
+		if w := s.serve(httptest.NewRequest(http.MethodPost, "/webhooks/generic/token-app", bytes.NewReader(payload))); w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", sha, w.Code, w.Body.String())
+		}
+		d := s.deployments.created[0]
+		if d.CommitSHA != strings.ToLower(sha) || d.CommitShortSHA != strings.ToLower(sha[:7]) {
+			t.Errorf("%s: stored %s, short %s", sha, d.CommitSHA, d.CommitShortSHA)
+		}
+	}
+}
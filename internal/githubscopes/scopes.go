@@ -0,0 +1,153 @@
+package githubscopes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GitHub OAuth scopes the platform knows how to use
+const (
+	Repo          = "repo"
+	AdminRepoHook = "admin:repo_hook"
+	ReadOrg       = "read:org"
+	UserEmail     = "user:email"
+	RepoStatus    = "repo:status"
+)
+
+// Default is requested when GITHUB_SCOPES is not set
+var Default = []string{Repo, UserEmail}
+
+// implied lists the scopes a broader scope grants on its own
+var implied = map[string][]string{
+	"repo":            {"repo:status", "repo_deployment", "public_repo", "repo:invite", "security_events", "write:repo_hook", "read:repo_hook"},
+	"admin:repo_hook": {"write:repo_hook", "read:repo_hook"},
+	"write:repo_hook": {"read:repo_hook"},
+	"admin:org":       {"write:org", "read:org"},
+	"write:org":       {"read:org"},
+	"user":            {"read:user", "user:email", "user:follow"},
+}
+
+// Feature is something the platform does with the user's GitHub token
+type Feature struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Scope       string   `json:"scope"` // Scope to request when enabling the feature
+	AnyOf       []string `json:"-"`     // Granted scopes that are enough, besides Scope
+}
+
+// Feature names
+const (
+	FeatureWebhooks      = "webhooks"
+	FeaturePrivateRepos  = "private_repos"
+	FeatureOrgMembership = "org_membership"
+	FeaturePRComments    = "pr_comments"
+	FeatureCommitStatus  = "commit_statuses"
+)
+
+// Features degrade individually: without their scope they are disabled, the
+// rest of the platform keeps working
+var Features = []Feature{
+	{Name: FeatureWebhooks, Description: "Automatic webhook registration", Scope: AdminRepoHook, AnyOf: []string{"write:repo_hook"}},
+	{Name: FeaturePrivateRepos, Description: "Cloning private repositories with your GitHub account", Scope: Repo},
+	{Name: FeatureOrgMembership, Description: "Checking private organization memberships at sign-in", Scope: ReadOrg},
+	{Name: FeaturePRComments, Description: "Preview comments on pull requests", Scope: Repo, AnyOf: []string{"public_repo"}},
+	{Name: FeatureCommitStatus, Description: "Build and analysis results as commit statuses", Scope: RepoStatus},
+}
+
+// FeatureByName returns the feature called name
+func FeatureByName(name string) (Feature, bool) {
+	for _, f := range Features {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Feature{}, false
+}
+
+// Parse splits a scope list as sent in the X-OAuth-Scopes header ("repo, user:email")
+// or the token response ("repo,user:email"), sorted and without duplicates
+func Parse(s string) []string {
+	seen := map[string]bool{}
+	var scopes []string
+	for _, scope := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// Has reports whether scope is granted directly or through a broader scope
+func Has(granted []string, scope string) bool {
+	for _, g := range granted {
+		if g == scope {
+			return true
+		}
+		for _, sub := range implied[g] {
+			if sub == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Enabled reports whether the granted scopes are enough for f
+func (f Feature) Enabled(granted []string) bool {
+	if Has(granted, f.Scope) {
+		return true
+	}
+	for _, scope := range f.AnyOf {
+		if Has(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingScopeError explains how to enable a feature the user hasn't granted
+// the scope for
+type MissingScopeError struct {
+	Feature Feature
+}
+
+func (e *MissingScopeError) Error() string {
+	return fmt.Sprintf("%s needs the %s GitHub scope: grant it with POST /api/auth/github/upgrade-scopes {\"features\": [%q]}",
+		e.Feature.Description, e.Feature.Scope, e.Feature.Name)
+}
+
+// Require returns a MissingScopeError if the granted scopes don't cover the
+// feature called name
+func Require(granted []string, name string) error {
+	f, ok := FeatureByName(name)
+	if !ok {
+		return fmt.Errorf("unknown feature %q", name)
+	}
+	if !f.Enabled(granted) {
+		return &MissingScopeError{Feature: f}
+	}
+	return nil
+}
+
+// FeatureStatus is a feature as shown to the user
+type FeatureStatus struct {
+	Feature
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"` // How to enable it, when disabled
+}
+
+// Status lists every feature and whether the granted scopes enable it
+func Status(granted []string) []FeatureStatus {
+	statuses := make([]FeatureStatus, 0, len(Features))
+	for _, f := range Features {
+		status := FeatureStatus{Feature: f, Enabled: f.Enabled(granted)}
+		if !status.Enabled {
+			status.Message = (&MissingScopeError{Feature: f}).Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,59 @@
+package githubscopes
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for header, want := range map[string][]string{
+		"repo, user:email":             {"repo", "user:email"},
+		"user:email,repo,repo":         {"repo", "user:email"},
+		" admin:repo_hook ,, read:org": {"admin:repo_hook", "read:org"},
+		"":                             nil,
+	} {
+		if got := Parse(header); !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+// Features degrade one by one with the scopes granted, broader scopes
+// granting the narrower ones they include
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		granted string
+		enabled []string
+	}{
+		{"repo, user:email", []string{FeatureWebhooks, FeaturePrivateRepos, FeaturePRComments, FeatureCommitStatus}},
+		{"public_repo, user:email", []string{FeaturePRComments}},
+		{"write:repo_hook, repo:status", []string{FeatureWebhooks, FeatureCommitStatus}},
+		{"admin:org", []string{FeatureOrgMembership}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		granted := Parse(tt.granted)
+		var enabled []string
+		for _, f := range Features {
+			err := Require(granted, f.Name)
+			var missing *MissingScopeError
+			switch {
+			case err == nil:
+				enabled = append(enabled, f.Name)
+			case !errors.As(err, &missing) || missing.Feature.Name != f.Name:
+				t.Errorf("%q: %s: %v", tt.granted, f.Name, err)
+			case !strings.Contains(err.Error(), f.Scope) || !strings.Contains(err.Error(), "upgrade-scopes"):
+				t.Errorf("%q: %s: message %q says not how to enable it", tt.granted, f.Name, err)
+			}
+		}
+		if !reflect.DeepEqual(enabled, tt.enabled) {
+			t.Errorf("%q enables %v, want %v", tt.granted, enabled, tt.enabled)
+		}
+	}
+
+	if err := Require(Parse("repo"), "telepathy"); err == nil {
+		t.Error("unknown feature required")
+	}
+}
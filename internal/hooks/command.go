@@ -0,0 +1,126 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/config"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const commandOutputBytes = 64 << 10 // Cap on the hook output logged
+
+// CommandHook is an external command hook of the HOOKS_FILE, e.g.
+//
+//	{"name": "cmdb", "events": ["deployment.deployed"], "command": ["/opt/hooks/cmdb", "--env", "prod"], "timeout": "1m"}
+type CommandHook struct {
+	Name     string   `json:"name"`
+	Events   []string `json:"events"`            // Empty = all
+	Command  []string `json:"command"`           // Program and arguments, run without a shell
+	Blocking bool     `json:"blocking"`          // See Hook.Blocking
+	Timeout  string   `json:"timeout,omitempty"` // Duration, "" = HOOK_TIMEOUT
+}
+
+// LoadCommands sets the default hook timeout and registers the command hooks
+// of cfg.HooksFile, after any in-process hooks. A broken file fails startup
+// rather than silently skipping hooks.
+func LoadCommands(cfg *config.Config) error {
+	defaultTimeout = cfg.HookTimeout
+	if cfg.HooksFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(cfg.HooksFile)
+	if err != nil {
+		return err
+	}
+	var commands []CommandHook
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", cfg.HooksFile, err)
+	}
+
+	for i, command := range commands {
+		hook, err := command.hook()
+		if err != nil {
+			return fmt.Errorf("hook %d of %s: %w", i+1, cfg.HooksFile, err)
+		}
+		Register(hook)
+	}
+	log.Printf("🪝 Loaded %d command hooks from %s", len(commands), cfg.HooksFile)
+	return nil
+}
+
+// hook validates the command hook and turns it into a Hook
+func (c CommandHook) hook() (Hook, error) {
+	if c.Name == "" {
+		return Hook{}, fmt.Errorf("name is required")
+	}
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return Hook{}, fmt.Errorf("%s: command is required", c.Name)
+	}
+	for _, event := range c.Events {
+		if !slices.Contains(Events, event) {
+			return Hook{}, fmt.Errorf("%s: unknown event %q, available: %s", c.Name, event, strings.Join(Events, ", "))
+		}
+	}
+	var timeout time.Duration
+	if c.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(c.Timeout); err != nil || timeout <= 0 {
+			return Hook{}, fmt.Errorf("%s: invalid timeout %q", c.Name, c.Timeout)
+		}
+	}
+	return Hook{
+		Name:     c.Name,
+		Events:   c.Events,
+		Blocking: c.Blocking,
+		Timeout:  timeout,
+		Run:      runCommand(c.Name, c.Command),
+	}, nil
+}
+
+// runCommand runs command with the event JSON on stdin and its type in
+// $DEPLOY_EVENT; a non-zero exit fails the hook. What it prints is logged.
+func runCommand(name string, command []string) Func {
+	return func(ctx context.Context, event *Event) error {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Env = append(os.Environ(), "DEPLOY_EVENT="+event.Type)
+		output := &cappedBuffer{limit: commandOutputBytes}
+		cmd.Stdout = output
+		cmd.Stderr = output
+		cmd.WaitDelay = time.Second // Don't wait on pipes held open by a killed command's children
+
+		err = cmd.Run()
+		if out := strings.TrimSpace(output.String()); out != "" {
+			log.Printf("🪝 Hook %s on %s of deployment %d:\n%s", name, event.Type, event.Deployment.ID, out)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written to it, discarding the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,97 @@
+package hooks
+
+import (
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"time"
+)
+
+// Lifecycle events hooks run on
+const (
+	EventPolicyBlocked    = "deployment.policy_blocked"    // The push broke the project's deploy policy, its deployment waits for an approver to override it
+	EventBeforeBuild      = "deployment.before_build"      // The build is about to clone the repository
+	EventAwaitingApproval = "deployment.awaiting_approval" // The production deployment was built and waits for an approver
+	EventDeployed         = "deployment.deployed"          // The rollout succeeded, before the deployment is marked deployed
+	EventFailed           = "deployment.failed"            // The deployment was marked failed
+)
+
+// Events lists every event, in lifecycle order
+var Events = []string{EventPolicyBlocked, EventBeforeBuild, EventAwaitingApproval, EventDeployed, EventFailed}
+
+// Event is the payload hooks receive, command hooks as JSON on stdin. It is
+// also the payload outgoing deployment notifications are to send, so tools
+// can be shared between the two.
+type Event struct {
+	Type       string            `json:"event"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Deployment DeploymentPayload `json:"deployment"`
+	Project    ProjectPayload    `json:"project"`
+	Approvers  []string          `json:"approvers,omitempty"`  // Usernames who may approve, on awaiting_approval and policy_blocked
+	Violations []string          `json:"violations,omitempty"` // Deploy policy rules the push broke, on policy_blocked
+}
+
+// DeploymentPayload is the deployment an event is about
+type DeploymentPayload struct {
+	ID              uint      `json:"id"`
+	Status          string    `json:"status"`
+	CommitSHA       string    `json:"commit_sha"`
+	CommitMsg       string    `json:"commit_msg"`
+	Branch          string    `json:"branch"`
+	Ref             string    `json:"ref,omitempty"`
+	Trigger         string    `json:"trigger"`
+	Actor           string    `json:"actor,omitempty"`
+	Target          string    `json:"target,omitempty"`
+	Hostname        string    `json:"hostname,omitempty"`
+	Cluster         string    `json:"cluster,omitempty"`
+	ImageTag        string    `json:"image_tag,omitempty"`
+	FailureCategory string    `json:"failure_category,omitempty"`
+	FailureDetail   string    `json:"failure_detail,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+
+	ApprovalExpiresAt *time.Time `json:"approval_expires_at,omitempty"`
+	ApprovedBy        string     `json:"approved_by,omitempty"`
+}
+
+// ProjectPayload is the project of the deployment an event is about
+type ProjectPayload struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	RepoOwner string `json:"repo_owner,omitempty"`
+	RepoName  string `json:"repo_name,omitempty"`
+}
+
+// NewEvent builds the eventType event of deployment, whose Project is loaded
+func NewEvent(eventType string, deployment *models.Deployment) *Event {
+	return &Event{
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Deployment: DeploymentPayload{
+			ID:              deployment.ID,
+			Status:          string(deployment.Status),
+			CommitSHA:       deployment.CommitSHA,
+			CommitMsg:       textutil.Truncate(textutil.Clean(deployment.CommitMsg), models.MaxCommitMsgBytes), // Older deployments stored it raw
+			Branch:          deployment.Branch,
+			Ref:             deployment.Ref,
+			Trigger:         deployment.Trigger,
+			Actor:           deployment.Actor,
+			Target:          deployment.Target,
+			Hostname:        deployment.Hostname,
+			Cluster:         deployment.Cluster,
+			ImageTag:        deployment.ImageTag,
+			FailureCategory: deployment.FailureCategory,
+			FailureDetail:   deployment.FailureDetail,
+			CreatedAt:       deployment.CreatedAt,
+
+			ApprovalExpiresAt: deployment.ApprovalExpiresAt,
+			ApprovedBy:        deployment.ApprovedBy,
+		},
+		Project: ProjectPayload{
+			ID:        deployment.Project.ID,
+			Name:      deployment.Project.Name,
+			Slug:      deployment.Project.Slug,
+			RepoOwner: deployment.Project.RepoOwner,
+			RepoName:  deployment.Project.RepoName,
+		},
+	}
+}
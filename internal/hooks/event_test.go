@@ -0,0 +1,38 @@
+package hooks
+
+import (
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Deployments stored before commit messages were cleaned still go out in
+// events as valid, bounded JSON
+func FuzzNewEventCommitMsg(f *testing.F) {
+	for _, s := range []string{
+		"Fix the build",
+		strings.Repeat("🎉 שלום עולם مرحبا ", 600),
+		"\xff\xfe broken \xc3",
+		"evil\u202egnp.exe\x00\x1b[31m ",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, msg string) {
+		event := NewEvent(EventDeployed, &models.Deployment{ID: 1, CommitMsg: msg})
+		got := event.Deployment.CommitMsg
+		if !utf8.ValidString(got) || len(got) > models.MaxCommitMsgBytes {
+			t.Fatalf("NewEvent kept %q (%d bytes)", got, len(got))
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Event
+		if err := json.Unmarshal(body, &decoded); err != nil || decoded.Deployment.CommitMsg != got {
+			t.Fatalf("round trip gave %q, %v", decoded.Deployment.CommitMsg, err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package hooks
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Func is the code of a hook. Returning an error fails the hook.
+type Func func(ctx context.Context, event *Event) error
+
+// Hook runs custom code at deployment lifecycle events, e.g. warming a cache
+// once a deployment is live or recording it in a CMDB
+type Hook struct {
+	Name     string
+	Events   []string      // Events it runs on, empty = all
+	Blocking bool          // Its failure fails the deployment on before_build and deployed
+	Timeout  time.Duration // 0 = the default timeout
+	Run      Func
+}
+
+// BlockingError fails a deployment whose blocking hook failed
+type BlockingError struct {
+	Hook  string
+	Event string
+	Err   error
+}
+
+func (e *BlockingError) Error() string {
+	return fmt.Sprintf("blocking hook %s failed on %s: %v", e.Hook, e.Event, e.Err)
+}
+
+func (e *BlockingError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	mu             sync.RWMutex
+	registered     []Hook
+	defaultTimeout = 30 * time.Second
+)
+
+// Register adds an in-process hook, for programs embedding the platform.
+// Hooks run one at a time in the order they were registered; call Register
+// before the server starts.
+func Register(hook Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered = append(registered, hook)
+}
+
+// subscribed returns the hooks running on eventType, in registration order
+func subscribed(eventType string) []Hook {
+	mu.RLock()
+	defer mu.RUnlock()
+	var hooks []Hook
+	for _, hook := range registered {
+		if len(hook.Events) == 0 || slices.Contains(hook.Events, eventType) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// Dispatch runs the hooks of event in order, each bounded by its timeout.
+// Failures of non-blocking hooks are logged and returned as warnings; the
+// first blocking hook to fail stops the hooks after it and is returned as a
+// *BlockingError.
+func Dispatch(ctx context.Context, event *Event) ([]string, error) {
+	var warnings []string
+	for _, hook := range subscribed(event.Type) {
+		timeout := hook.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		hookCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := runHook(hookCtx, hook, event)
+		cancel()
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		log.Printf("🪝 Hook %s failed on %s of deployment %d: %v", hook.Name, event.Type, event.Deployment.ID, err)
+		if hook.Blocking {
+			return warnings, &BlockingError{Hook: hook.Name, Event: event.Type, Err: err}
+		}
+		warnings = append(warnings, fmt.Sprintf("hook %s failed on %s: %v", hook.Name, event.Type, err))
+	}
+	return warnings, nil
+}
+
+// runHook runs one hook, turning a panic into its failure so a broken hook
+// can't take the worker down
+func runHook(ctx context.Context, hook Hook, event *Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if err := hook.Run(ctx, event); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Failed runs the failed hooks of a deployment in the background, once it
+// has been marked failed. Their failures are added to the warnings of its
+// build; a failed deployment can't be failed further, so blocking doesn't
+// apply.
+func Failed(deploymentID uint) {
+	if len(subscribed(EventFailed)) == 0 {
+		return
+	}
+	go func() {
+		var deployment models.Deployment
+		if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
+			log.Printf("⚠️  Failed hooks of deployment %d not run: %v", deploymentID, err)
+			return
+		}
+		warnings, err := Dispatch(context.Background(), NewEvent(EventFailed, &deployment))
+		if err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		recordWarnings(deployment.ID, warnings)
+	}()
+}
+
+// AwaitingApproval runs the awaiting_approval hooks of a deployment in the
+// background, e.g. to notify its approvers. Like failed hooks, their
+// failures are added to the warnings of its build.
+func AwaitingApproval(deploymentID uint, approvers []string) {
+	if len(subscribed(EventAwaitingApproval)) == 0 {
+		return
+	}
+	go func() {
+		var deployment models.Deployment
+		if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
+			log.Printf("⚠️  Awaiting approval hooks of deployment %d not run: %v", deploymentID, err)
+			return
+		}
+		event := NewEvent(EventAwaitingApproval, &deployment)
+		event.Approvers = approvers
+		warnings, err := Dispatch(context.Background(), event)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		recordWarnings(deployment.ID, warnings)
+	}()
+}
+
+// PolicyBlocked runs the policy_blocked hooks of a deployment in the
+// background, e.g. to tell its approvers and security what the push broke.
+// It has no build yet, so their failures are only logged.
+func PolicyBlocked(deploymentID uint, approvers, violations []string) {
+	if len(subscribed(EventPolicyBlocked)) == 0 {
+		return
+	}
+	go func() {
+		var deployment models.Deployment
+		if err := database.DB.Preload("Project").First(&deployment, deploymentID).Error; err != nil {
+			log.Printf("⚠️  Policy blocked hooks of deployment %d not run: %v", deploymentID, err)
+			return
+		}
+		event := NewEvent(EventPolicyBlocked, &deployment)
+		event.Approvers = approvers
+		event.Violations = violations
+		if _, err := Dispatch(context.Background(), event); err != nil {
+			log.Printf("⚠️  Policy blocked hooks of deployment %d: %v", deploymentID, err)
+		}
+	}()
+}
+
+// recordWarnings adds hook failures to the warnings of the deployment's latest build
+func recordWarnings(deploymentID uint, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	var build models.Build
+	if database.DB.Select("id", "warnings").Where("deployment_id = ?", deploymentID).Order("id DESC").First(&build).Error != nil {
+		return
+	}
+	build.Warnings = append(build.Warnings, warnings...)
+	database.DB.Model(&build).Select("warnings").Updates(&build)
+}
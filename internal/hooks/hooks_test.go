@@ -0,0 +1,241 @@
+package hooks
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetHooks unregisters every hook once the test is over
+func resetHooks(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	saved, savedTimeout := registered, defaultTimeout
+	registered = nil
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		registered, defaultTimeout = saved, savedTimeout
+		mu.Unlock()
+	})
+}
+
+func testEvent(eventType string) *Event {
+	return NewEvent(eventType, &models.Deployment{ID: 42, Branch: "main", CommitSHA: "0123456789abcdef", Project: models.Project{ID: 7, Name: "app", Slug: "app"}})
+}
+
+// Hooks run one after the other, in the order they were registered, on
+// the events they subscribed to
+func TestDispatchOrder(t *testing.T) {
+	resetHooks(t)
+	var ran []string
+	record := func(name string, events ...string) {
+		Register(Hook{Name: name, Events: events, Run: func(ctx context.Context, event *Event) error {
+			ran = append(ran, name+" "+event.Type)
+			return nil
+		}})
+	}
+	record("cache", EventDeployed)
+	record("audit")
+	record("gate", EventBeforeBuild)
+	record("cmdb", EventDeployed, EventFailed)
+
+	for _, event := range []string{EventBeforeBuild, EventDeployed, EventFailed} {
+		if warnings, err := Dispatch(context.Background(), testEvent(event)); err != nil || len(warnings) > 0 {
+			t.Fatalf("%s: warnings %q, %v", event, warnings, err)
+		}
+	}
+	want := []string{
+		"audit " + EventBeforeBuild, "gate " + EventBeforeBuild,
+		"cache " + EventDeployed, "audit " + EventDeployed, "cmdb " + EventDeployed,
+		"audit " + EventFailed, "cmdb " + EventFailed,
+	}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+}
+
+// A failing hook is a warning unless it is blocking: then the hooks after
+// it don't run and the deployment fails
+func TestDispatchBlocking(t *testing.T) {
+	resetHooks(t)
+	var ran []string
+	hook := func(name string, blocking bool, err error) {
+		Register(Hook{Name: name, Blocking: blocking, Run: func(ctx context.Context, event *Event) error {
+			ran = append(ran, name)
+			return err
+		}})
+	}
+	hook("warm-cache", false, errors.New("cache unreachable"))
+	Register(Hook{Name: "broken", Run: func(ctx context.Context, event *Event) error { panic("nil map") }})
+	hook("smoke-test", true, nil)
+	hook("approve", true, errors.New("change freeze"))
+	hook("cmdb", false, nil)
+
+	warnings, err := Dispatch(context.Background(), testEvent(EventDeployed))
+	var blocking *BlockingError
+	if !errors.As(err, &blocking) || blocking.Hook != "approve" || blocking.Event != EventDeployed || err.Error() != "blocking hook approve failed on deployment.deployed: change freeze" {
+		t.Fatalf("got %v", err)
+	}
+	want := []string{
+		"hook warm-cache failed on deployment.deployed: cache unreachable",
+		"hook broken failed on deployment.deployed: panic: nil map",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings %q, want %q", warnings, want)
+	}
+	if !reflect.DeepEqual(ran, []string{"warm-cache", "smoke-test", "approve"}) {
+		t.Errorf("ran %q: hooks after the failed blocking one must not run", ran)
+	}
+}
+
+// Each hook is bounded by its timeout, or the default one
+func TestDispatchTimeout(t *testing.T) {
+	resetHooks(t)
+	defaultTimeout = 50 * time.Millisecond
+	hang := func(ctx context.Context, event *Event) error {
+		<-ctx.Done()
+		return nil // Stopped waiting, yet didn't finish
+	}
+	Register(Hook{Name: "slow", Run: hang})
+	Register(Hook{Name: "slower", Timeout: 100 * time.Millisecond, Blocking: true, Run: hang})
+
+	started := time.Now()
+	warnings, err := Dispatch(context.Background(), testEvent(EventBeforeBuild))
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("hooks ran %s", elapsed)
+	}
+	if len(warnings) != 1 || warnings[0] != "hook slow failed on deployment.before_build: timed out after 50ms" {
+		t.Errorf("warnings %q", warnings)
+	}
+	if err == nil || err.Error() != "blocking hook slower failed on deployment.before_build: timed out after 100ms" {
+		t.Errorf("got %v", err)
+	}
+}
+
+// writeHooksFile writes the command hooks of HOOKS_FILE
+func writeHooksFile(t *testing.T, hooks string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hooks.json")
+	if err := os.WriteFile(path, []byte(hooks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &config.Config{HooksFile: path, HookTimeout: time.Second}
+}
+
+// Command hooks get the event as JSON on stdin and its type in
+// $DEPLOY_EVENT; a non-zero exit or a timeout fails them
+func TestCommandHooks(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	resetHooks(t)
+	out := filepath.Join(t.TempDir(), "event.json")
+	cfg := writeHooksFile(t, `[
+		{"name": "record", "events": ["deployment.deployed"], "command": ["sh", "-c", "echo $DEPLOY_EVENT > `+out+`.type && cat > `+out+`"]},
+		{"name": "exit", "command": ["sh", "-c", "echo cmdb down >&2; exit 3"]},
+		{"name": "sleep", "command": ["sleep", "10"], "timeout": "100ms", "blocking": true}
+	]`)
+	if err := LoadCommands(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	warnings, err := Dispatch(context.Background(), testEvent(EventDeployed))
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("hooks ran %s", elapsed)
+	}
+	if len(warnings) != 1 || warnings[0] != "hook exit failed on deployment.deployed: exit status 3" {
+		t.Errorf("warnings %q", warnings)
+	}
+	var blocking *BlockingError
+	if !errors.As(err, &blocking) || blocking.Hook != "sleep" || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("got %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil || event.Type != EventDeployed || event.Deployment.ID != 42 || event.Project.Slug != "app" {
+		t.Errorf("stdin %s: %v", data, err)
+	}
+	if eventType, _ := os.ReadFile(out + ".type"); strings.TrimSpace(string(eventType)) != EventDeployed {
+		t.Errorf("$DEPLOY_EVENT is %q", eventType)
+	}
+
+	// Only the subscribed event runs record
+	os.Remove(out)
+	Dispatch(context.Background(), testEvent(EventFailed))
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("record ran on %s: %v", EventFailed, err)
+	}
+}
+
+// A broken hooks file fails startup rather than skipping hooks
+func TestLoadCommandsErrors(t *testing.T) {
+	tests := []struct {
+		hooks string
+		err   string
+	}{
+		{`{"name": "cmdb"}`, "failed to parse"},
+		{`[{"command": ["true"]}]`, "hook 1 of"},
+		{`[{"name": "cmdb", "command": []}]`, "cmdb: command is required"},
+		{`[{"name": "cmdb", "command": ["true"], "events": ["deployment.started"]}]`, `unknown event "deployment.started"`},
+		{`[{"name": "cmdb", "command": ["true"], "timeout": "-1s"}]`, `invalid timeout "-1s"`},
+	}
+	for _, tt := range tests {
+		resetHooks(t)
+		err := LoadCommands(writeHooksFile(t, tt.hooks))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.hooks, err, tt.err)
+		}
+	}
+	if err := LoadCommands(&config.Config{HookTimeout: time.Second, HooksFile: filepath.Join(t.TempDir(), "missing.json")}); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+// A deployment already failed can't fail further: the failures of its
+// failed hooks, blocking or not, end up in the warnings of its build
+func TestFailedHookWarnings(t *testing.T) {
+	resetHooks(t)
+	testutil.DB(t)
+	project := &models.Project{Name: "app", Slug: "app"}
+	database.DB.Create(project)
+	deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusFailed}
+	database.DB.Create(deployment)
+	build := &models.Build{DeploymentID: deployment.ID, Status: "failed", Warnings: []string{"image is 812 MB"}}
+	database.DB.Create(build)
+
+	Register(Hook{Name: "pager", Events: []string{EventFailed}, Blocking: true, Run: func(ctx context.Context, event *Event) error {
+		return errors.New("pager unreachable")
+	}})
+	Failed(deployment.ID)
+
+	want := []string{"image is 812 MB", "blocking hook pager failed on deployment.failed: pager unreachable"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var current models.Build
+		database.DB.First(&current, build.ID)
+		if reflect.DeepEqual([]string(current.Warnings), want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("warnings %q, want %q", current.Warnings, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,139 @@
+package hostname
+
+import (
+	"crypto/sha1"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/netutil"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Hostname record types
+const (
+	TypeProduction = "production" // <project>.<base-domain>, follows the project's production branch
+	TypeAlias      = "alias"      // <project>--<branch>.<base-domain>, follows the newest deployment of a branch
+	TypeCustom     = "custom"     // A domain of the project's owner, follows the project's production deployment
+)
+
+// aliasSeparator joins the project and branch parts of an alias label.
+// Production labels never contain it (SanitizeLabel collapses repeated hyphens
+// and ValidateSlug rejects them), so an alias can never collide with another
+// project's production hostname.
+const aliasSeparator = "--"
+
+// minBranchLabel is the room kept for the branch part when the project label is long
+const minBranchLabel = 12
+
+// BranchLabel turns a branch name into a DNS label of at most max characters.
+// Names that had to be shortened get a hash suffix so distinct branches stay distinct.
+func BranchLabel(branch string, max int) string {
+	label := SanitizeLabel(branch)
+	if label == "" {
+		label = "branch"
+	}
+	if len(label) <= max {
+		return label
+	}
+	hash := branchHash(branch)
+	return truncateLabel(label, max-len(hash)-1) + "-" + hash
+}
+
+// AliasHostname returns the alias hostname under domain for branch of the
+// project whose production label is projectLabel
+func (m *Manager) AliasHostname(projectLabel, branch, domain string) string {
+	maxProject := MaxLabelLength - len(aliasSeparator) - minBranchLabel
+	projectLabel = truncateLabel(projectLabel, maxProject)
+	branchLabel := BranchLabel(branch, MaxLabelLength-len(projectLabel)-len(aliasSeparator))
+	return fmt.Sprintf("%s%s%s.%s", projectLabel, aliasSeparator, branchLabel, domain)
+}
+
+// AssignBranchAlias points the stable alias hostname of a branch at deploymentID,
+// creating the alias on the branch's first deployment
+func (m *Manager) AssignBranchAlias(projectID uint, branch string, deploymentID uint) (string, error) {
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		return "", err
+	}
+
+	var alias models.Hostname
+	err := database.DB.Where("project_id = ? AND type = ? AND branch = ?", projectID, TypeAlias, branch).First(&alias).Error
+	if err == nil {
+		alias.DeploymentID = deploymentID
+		alias.IsActive = true
+		if err := database.DB.Save(&alias).Error; err != nil {
+			return "", err
+		}
+	} else {
+		hostname := m.AliasHostname(m.projectLabel(&project), branch, m.Domain(&project))
+		// Branches that sanitize to the same label (feature/x vs feature-x) get told apart by hash
+		var taken models.Hostname
+		if database.DB.Where("hostname = ?", hostname).First(&taken).Error == nil {
+			label := strings.Split(hostname, ".")[0]
+			hostname = fmt.Sprintf("%s.%s", withSuffix(label, branchHash(branch)), domainOf(hostname))
+		}
+
+		alias = models.Hostname{
+			Hostname:     m.uniqueHostname(hostname),
+			ProjectID:    projectID,
+			DeploymentID: deploymentID,
+			Type:         TypeAlias,
+			Branch:       branch,
+			IsActive:     true,
+		}
+		if err := database.DB.Create(&alias).Error; err != nil {
+			return "", err
+		}
+		log.Printf("✅ Created branch alias %s for project %d (%s)", alias.Hostname, projectID, branch)
+	}
+
+	database.DB.Model(&models.Deployment{}).Where("id = ?", deploymentID).Update("hostname", alias.Hostname)
+	return alias.Hostname, nil
+}
+
+// DeactivateBranchAlias disables the alias of a deleted branch. The record is
+// kept so the same hostname comes back if the branch is pushed again.
+func (m *Manager) DeactivateBranchAlias(projectID uint, branch string) error {
+	return database.DB.Model(&models.Hostname{}).
+		Where("project_id = ? AND type = ? AND branch = ?", projectID, TypeAlias, branch).
+		Update("is_active", false).Error
+}
+
+// DeactivateProject disables all the hostnames of an archived project. The
+// records are kept, so nobody else claims them and its next deployment gets
+// them back.
+func (m *Manager) DeactivateProject(projectID uint) error {
+	return database.DB.Model(&models.Hostname{}).
+		Where("project_id = ? AND is_active = ?", projectID, true).
+		Update("is_active", false).Error
+}
+
+// Resolve returns the active hostname record (with its deployment) serving host
+func (m *Manager) Resolve(host string) (*models.Hostname, error) {
+	host = strings.ToLower(strings.TrimSuffix(netutil.SplitHost(host), "."))
+
+	var record models.Hostname
+	if err := database.DB.Preload("Deployment").
+		Where("hostname = ? AND is_active = ?", host, true).
+		First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// projectLabel is the first label of the project's production hostname, which
+// is already unique across projects, falling back to its slug
+func (m *Manager) projectLabel(project *models.Project) string {
+	var production models.Hostname
+	if database.DB.Where("project_id = ? AND type = ?", project.ID, TypeProduction).Order("is_active DESC, id DESC").First(&production).Error == nil {
+		return strings.Split(production.Hostname, ".")[0]
+	}
+	return m.safeLabel(project.Slug)
+}
+
+func branchHash(branch string) string {
+	sum := sha1.Sum([]byte(branch))
+	return hex.EncodeToString(sum[:3])
+}
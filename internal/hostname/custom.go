@@ -0,0 +1,62 @@
+package hostname
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"fmt"
+	"strings"
+)
+
+// CustomDomain checks a domain a user wants to serve a project on and returns
+// it in canonical form: ASCII letters lowercased, no trailing dot. Besides
+// ValidateCustomDomain, domains under one of the platform's base domains are
+// refused: those are the platform's to hand out.
+func (m *Manager) CustomDomain(domain string) (string, error) {
+	// Only ASCII is lowercased: Unicode case folding maps some non-ASCII
+	// letters, such as the Kelvin sign, to ASCII ones
+	domain = strings.Map(func(char rune) rune {
+		if char >= 'A' && char <= 'Z' {
+			return char + 'a' - 'A'
+		}
+		return char
+	}, strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if err := ValidateCustomDomain(domain); err != nil {
+		return "", err
+	}
+	for _, base := range m.baseDomains() {
+		if domain == base || strings.HasSuffix(domain, "."+base) {
+			return "", fmt.Errorf("%s: %w", base, ErrPlatformDomain)
+		}
+	}
+	return domain, nil
+}
+
+// baseDomains are the platform's domains: BASE_DOMAIN and those of clusters
+func (m *Manager) baseDomains() []string {
+	var domains []string
+	if m.baseDomain != "" {
+		domains = append(domains, strings.ToLower(m.baseDomain))
+	}
+	for _, domain := range m.clusterDomains {
+		domains = append(domains, strings.ToLower(domain))
+	}
+	return domains
+}
+
+// CustomDomains lists the custom domains of a project, routed by the Ingress
+// of its production resources
+func (m *Manager) CustomDomains(projectID uint) ([]string, error) {
+	var domains []string
+	err := database.DB.Model(&models.Hostname{}).
+		Where("project_id = ? AND type = ?", projectID, TypeCustom).
+		Order("hostname").Pluck("hostname", &domains).Error
+	return domains, err
+}
+
+// followProduction points the custom domains of a project at its production
+// deployment deploymentID
+func (m *Manager) followProduction(projectID, deploymentID uint) {
+	database.DB.Model(&models.Hostname{}).
+		Where("project_id = ? AND type = ?", projectID, TypeCustom).
+		Updates(map[string]interface{}{"deployment_id": deploymentID, "is_active": true})
+}
@@ -0,0 +1,45 @@
+package hostname
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"testing"
+)
+
+// Custom domains follow the project's production deployment, and come back
+// with it after archiving
+func TestCustomDomainsFollowProduction(t *testing.T) {
+	testutil.DB(t)
+	m := NewManager(&config.Config{BaseDomain: "deploy.example.com"})
+	project := &models.Project{Name: "app", Slug: "app", Branch: "main"}
+	database.DB.Create(project)
+	custom := &models.Hostname{Hostname: "www.example.org", ProjectID: project.ID, Type: TypeCustom, IsActive: true}
+	database.DB.Create(custom)
+
+	for _, id := range []uint{11, 12} {
+		if _, err := m.AssignHostname(project.ID, id, "abc"); err != nil {
+			t.Fatal(err)
+		}
+		database.DB.First(custom, custom.ID)
+		if custom.DeploymentID != id || !custom.IsActive {
+			t.Errorf("after deployment %d: %+v", id, custom)
+		}
+	}
+	// Branch aliases don't move it
+	if _, err := m.AssignBranchAlias(project.ID, "feature", 13); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.First(custom, custom.ID)
+	if custom.DeploymentID != 12 {
+		t.Errorf("follows a branch: %+v", custom)
+	}
+
+	m.DeactivateProject(project.ID)
+	m.AssignHostname(project.ID, 14, "def")
+	database.DB.First(custom, custom.ID)
+	if custom.DeploymentID != 14 || !custom.IsActive {
+		t.Errorf("after unarchiving: %+v", custom)
+	}
+}
@@ -5,47 +5,101 @@ import (
 	"deploy-platform/internal/config"
 	"deploy-platform/internal/database"
 	"deploy-platform/internal/models"
+	"deploy-platform/internal/netutil"
 	"encoding/hex"
 	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
 type Manager struct {
-	baseDomain string
-	publicURL  string
+	baseDomain     string
+	clusterDomains map[string]string // Base domains of clusters with their own, by name
+	defaultCluster string
+	scheme         string
+	port           int // 0 = the scheme's default
+	reserved       map[string]struct{}
 }
 
 func NewManager(cfg *config.Config) *Manager {
+	reserved := make(map[string]struct{})
+	for _, name := range DefaultReservedNames {
+		reserved[name] = struct{}{}
+	}
+	for _, name := range cfg.ReservedSubdomains {
+		reserved[strings.ToLower(name)] = struct{}{}
+	}
+
+	clusterDomains := make(map[string]string)
+	for _, cluster := range cfg.Clusters {
+		if cluster.BaseDomain != "" {
+			clusterDomains[cluster.Name] = cluster.BaseDomain
+		}
+	}
+
 	return &Manager{
-		baseDomain: cfg.BaseDomain,
-		publicURL:  cfg.PublicURL,
+		baseDomain:     cfg.BaseDomain,
+		clusterDomains: clusterDomains,
+		defaultCluster: cfg.DefaultCluster,
+		scheme:         cfg.PublicScheme,
+		port:           cfg.PublicPort,
+		reserved:       reserved,
 	}
 }
 
 // GenerateProjectHostname generates a persistent hostname for a project (Vercel-style)
 // Format: project-slug.base-domain (no commit SHA - persistent per project)
-func (m *Manager) GenerateProjectHostname(projectSlug string) string {
-	// Create slug-safe hostname
-	slug := strings.ToLower(strings.ReplaceAll(projectSlug, " ", "-"))
-	slug = strings.ReplaceAll(slug, "_", "-")
-
-	// Remove special characters
-	slug = strings.ReplaceAll(slug, ".", "-")
-	slug = strings.ReplaceAll(slug, "/", "-")
-
+// Slugs that aren't valid DNS labels are sanitized, and reserved ones get a suffix
+func (m *Manager) GenerateProjectHostname(projectSlug, domain string) string {
 	// Format: project-slug.base-domain (persistent, like Vercel)
-	hostname := fmt.Sprintf("%s.%s", slug, m.baseDomain)
+	hostname := fmt.Sprintf("%s.%s", m.safeLabel(projectSlug), domain)
 	return hostname
 }
 
-// GetFullURL returns the full accessible URL for a hostname
+// Domain returns the base domain of the project's hostnames: its cluster's
+// when the cluster has one, BASE_DOMAIN otherwise
+func (m *Manager) Domain(project *models.Project) string {
+	cluster := project.Cluster
+	if cluster == "" {
+		cluster = m.defaultCluster
+	}
+	if domain := m.clusterDomains[cluster]; domain != "" {
+		return domain
+	}
+	return m.baseDomain
+}
+
+// domainOf is the base domain of hostname, everything after its first label
+func domainOf(hostname string) string {
+	_, domain, _ := strings.Cut(hostname, ".")
+	return domain
+}
+
+// GetFullURL returns the full accessible URL for a hostname, e.g.
+// "https://app.deploy.example.com" or "http://app.localhost:30080" when the
+// ingress is exposed on a nonstandard port in development
 func (m *Manager) GetFullURL(hostname string) string {
 	if hostname == "" {
 		return ""
 	}
-	return fmt.Sprintf("%s%s", m.publicURL, hostname)
+	scheme := m.scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := netutil.URLHost(hostname)
+	if m.port != 0 && m.port != defaultPorts[scheme] {
+		host = net.JoinHostPort(hostname, strconv.Itoa(m.port))
+	}
+	u := url.URL{Scheme: scheme, Host: host}
+	return u.String()
 }
 
+// defaultPorts are left out of URLs
+var defaultPorts = map[string]int{"http": 80, "https": 443}
+
 // AssignHostname assigns a persistent hostname to a project (Vercel-style)
 // Reuses the same hostname for the project, updating it to point to the latest deployment
 func (m *Manager) AssignHostname(projectID uint, deploymentID uint, commitSHA string) (string, error) {
@@ -54,31 +108,26 @@ func (m *Manager) AssignHostname(projectID uint, deploymentID uint, commitSHA st
 		return "", err
 	}
 
-	// Generate project slug
-	projectSlug := project.Slug
-	if projectSlug == "" {
-		projectSlug = strings.ToLower(project.Name)
-		if projectSlug == "" {
-			// Use repo name as fallback
-			projectSlug = strings.ToLower(project.RepoName)
-			if projectSlug == "" {
-				projectSlug = "deploy"
-			}
-		}
+	projectSlug := hostnameSlug(&project)
+
+	// Projects created before slug validation may carry reserved or invalid slugs
+	if err := m.ValidateSlug(projectSlug); err != nil {
+		log.Printf("⚠️  Project %d slug %q can't be used as a hostname (%v), falling back to a suffixed hostname", projectID, projectSlug, err)
 	}
 
 	// Generate persistent hostname for project (no commit SHA)
-	hostname := m.GenerateProjectHostname(projectSlug)
+	hostname := m.GenerateProjectHostname(projectSlug, m.Domain(&project))
 
-	// Check if project already has an active hostname
+	// Check if project already has a hostname: the active one, or the one it
+	// had when it was archived
 	var existingHostname models.Hostname
-	result := database.DB.Where("project_id = ? AND is_active = ?", projectID, true).First(&existingHostname)
+	result := database.DB.Where("project_id = ? AND type = ?", projectID, TypeProduction).Order("is_active DESC, id DESC").First(&existingHostname)
 
 	if result.Error == nil {
 		// Project already has a hostname - reuse it and update to point to new deployment
 		// Mark old deployment's hostname as inactive
 		database.DB.Model(&models.Hostname{}).
-			Where("project_id = ? AND deployment_id != ? AND is_active = ?", projectID, deploymentID, true).
+			Where("project_id = ? AND type = ? AND deployment_id != ? AND is_active = ?", projectID, TypeProduction, deploymentID, true).
 			Update("is_active", false)
 
 		// Update existing hostname to point to new deployment
@@ -88,27 +137,18 @@ func (m *Manager) AssignHostname(projectID uint, deploymentID uint, commitSHA st
 
 		// Also update the deployment record
 		database.DB.Model(&models.Deployment{}).Where("id = ?", deploymentID).Update("hostname", hostname)
+		m.followProduction(projectID, deploymentID)
 
 		return hostname, nil
 	}
 
 	// New project - create hostname
 	// Ensure uniqueness across all projects
-	originalHostname := hostname
-	counter := 0
-	for {
-		var check models.Hostname
-		if database.DB.Where("hostname = ?", hostname).First(&check).Error != nil {
-			break // Hostname is unique
-		}
-		// Add counter suffix if hostname exists (for different projects)
-		counter++
-		hostname = fmt.Sprintf("%s-%d.%s", strings.Split(originalHostname, ".")[0], counter, m.baseDomain)
-	}
+	hostname = m.uniqueHostname(hostname)
 
-	// Mark any old hostnames for this project as inactive
+	// Mark any old hostnames for this project as inactive (branch aliases live on)
 	database.DB.Model(&models.Hostname{}).
-		Where("project_id = ?", projectID).
+		Where("project_id = ? AND type = ?", projectID, TypeProduction).
 		Update("is_active", false)
 
 	// Create new hostname record
@@ -116,16 +156,60 @@ func (m *Manager) AssignHostname(projectID uint, deploymentID uint, commitSHA st
 		Hostname:     hostname,
 		ProjectID:    projectID,
 		DeploymentID: deploymentID,
+		Type:         TypeProduction,
 		IsActive:     true,
 	}
 	database.DB.Create(hostnameRecord)
 
 	// Update deployment record with hostname
 	database.DB.Model(&models.Deployment{}).Where("id = ?", deploymentID).Update("hostname", hostname)
+	m.followProduction(projectID, deploymentID)
 
 	return hostname, nil
 }
 
+// hostnameSlug is the slug a project's production hostname is made from
+func hostnameSlug(project *models.Project) string {
+	projectSlug := project.Slug
+	if projectSlug == "" {
+		projectSlug = strings.ToLower(project.Name)
+		if projectSlug == "" {
+			// Use repo name as fallback
+			projectSlug = strings.ToLower(project.RepoName)
+			if projectSlug == "" {
+				projectSlug = "deploy"
+			}
+		}
+	}
+	return projectSlug
+}
+
+// ProductionHostname returns the hostname AssignHostname will give the
+// project's next production deployment
+func (m *Manager) ProductionHostname(project *models.Project) string {
+	var existing models.Hostname
+	if database.DB.Where("project_id = ? AND type = ?", project.ID, TypeProduction).Order("is_active DESC, id DESC").First(&existing).Error == nil {
+		return existing.Hostname
+	}
+	return m.uniqueHostname(m.GenerateProjectHostname(hostnameSlug(project), m.Domain(project)))
+}
+
+// uniqueHostname returns hostname, or hostname with a counter suffix on its
+// first label if it's already taken (by another project)
+func (m *Manager) uniqueHostname(hostname string) string {
+	originalLabel, domain, _ := strings.Cut(hostname, ".")
+	counter := 0
+	for {
+		var check models.Hostname
+		if database.DB.Where("hostname = ?", hostname).First(&check).Error != nil {
+			return hostname // Hostname is unique
+		}
+		// Add counter suffix if hostname exists
+		counter++
+		hostname = fmt.Sprintf("%s.%s", withSuffix(originalLabel, fmt.Sprint(counter)), domain)
+	}
+}
+
 func generateShortHash() string {
 	b := make([]byte, 3) // 6 hex characters
 	rand.Read(b)
@@ -0,0 +1,63 @@
+package hostname
+
+import (
+	"deploy-platform/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestGetFullURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   string
+		port     int
+		hostname string
+		want     string
+	}{
+		{"production", "https", 0, "app.deploy.example.com", "https://app.deploy.example.com"},
+		{"production, explicit default port", "https", 443, "app.deploy.example.com", "https://app.deploy.example.com"},
+		{"https on a custom port", "https", 8443, "app.deploy.example.com", "https://app.deploy.example.com:8443"},
+		{"localhost", "http", 0, "app.localhost", "http://app.localhost"},
+		{"localhost on port 80", "http", 80, "app.localhost", "http://app.localhost"},
+		{"localhost on a NodePort", "http", 30080, "app.localhost", "http://app.localhost:30080"},
+		{"http default port over https", "https", 80, "app.deploy.example.com", "https://app.deploy.example.com:80"},
+		{"no scheme", "", 0, "app.localhost", "http://app.localhost"},
+		{"IPv6 literal", "http", 0, "fd00::1", "http://[fd00::1]"},
+		{"IPv6 literal with port", "http", 30080, "fd00::1", "http://[fd00::1]:30080"},
+		{"no hostname", "https", 8443, "", ""},
+	}
+	for _, tt := range tests {
+		m := NewManager(&config.Config{PublicScheme: tt.scheme, PublicPort: tt.port})
+		if got := m.GetFullURL(tt.hostname); got != tt.want {
+			t.Errorf("%s: GetFullURL(%q) = %q, want %q", tt.name, tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePublicURL(t *testing.T) {
+	tests := []struct {
+		scheme     string
+		port       int
+		baseDomain string
+		valid      bool
+	}{
+		{"https", 0, "deploy.example.com", true},
+		{"http", 30080, "localhost", true},
+		{"ftp", 0, "localhost", false},
+		{"HTTPS", 0, "deploy.example.com", false}, // Lowercased when read from the environment
+		{"https", 70000, "deploy.example.com", false},
+		{"https", -1, "deploy.example.com", false},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{PublicScheme: tt.scheme, PublicPort: tt.port, BaseDomain: tt.baseDomain}
+		var invalid bool
+		for _, problem := range cfg.Validate().Errors {
+			if strings.Contains(problem, "PUBLIC_SCHEME") || strings.Contains(problem, "PUBLIC_PORT") {
+				invalid = true
+			}
+		}
+		if invalid == tt.valid {
+			t.Errorf("scheme %q, port %d on %s: valid %v", tt.scheme, tt.port, tt.baseDomain, !invalid)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package hostname
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// TypePreview hostnames serve a single commit deployed on request:
+// <project>--<sha>.<base-domain>
+const TypePreview = "preview"
+
+// previewSHALength is how much of the commit SHA goes into a preview label
+const previewSHALength = 7
+
+// ReservePreview returns the preview hostname of commit sha, creating an
+// inactive record for deploymentID so the name is known (and kept) before the
+// deployment is live. Deploying the same commit again reuses the hostname.
+func (m *Manager) ReservePreview(project *models.Project, sha string, deploymentID uint) (string, error) {
+	short := sha
+	if len(short) > previewSHALength {
+		short = short[:previewSHALength]
+	}
+	hostname := m.AliasHostname(m.projectLabel(project), short, m.Domain(project))
+	// A branch alias may already use the name (a branch that looks like a
+	// SHA): fall back to a suffixed one, checked the same way
+	label := strings.Split(hostname, ".")[0]
+	for _, candidate := range []string{hostname, fmt.Sprintf("%s.%s", withSuffix(label, "sha"), domainOf(hostname))} {
+		var existing models.Hostname
+		if database.DB.Where("hostname = ?", candidate).First(&existing).Error != nil {
+			hostname = candidate
+			break
+		}
+		if existing.ProjectID == project.ID && existing.Type == TypePreview {
+			return existing.Hostname, nil
+		}
+		hostname = m.uniqueHostname(candidate)
+	}
+
+	record := models.Hostname{
+		Hostname:     hostname,
+		ProjectID:    project.ID,
+		DeploymentID: deploymentID,
+		Type:         TypePreview,
+	}
+	// Inactive until AssignPreview points it at a running deployment; is_active
+	// defaults to true, so it's cleared after the insert
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		return tx.Model(&record).Update("is_active", false).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("✅ Reserved preview hostname %s for project %d (%s)", hostname, project.ID, short)
+	return hostname, nil
+}
+
+// AssignPreview points the preview hostname reserved by ReservePreview at
+// deploymentID and activates it
+func (m *Manager) AssignPreview(projectID uint, deploymentID uint, hostname string) (string, error) {
+	result := database.DB.Model(&models.Hostname{}).
+		Where("hostname = ? AND project_id = ? AND type = ?", hostname, projectID, TypePreview).
+		Updates(map[string]interface{}{"deployment_id": deploymentID, "is_active": true})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", fmt.Errorf("preview hostname %s is not reserved for project %d", hostname, projectID)
+	}
+
+	database.DB.Model(&models.Deployment{}).Where("id = ?", deploymentID).Update("hostname", hostname)
+	return hostname, nil
+}
@@ -0,0 +1,163 @@
+package hostname
+
+import (
+	"deploy-platform/internal/netutil"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultReservedNames are subdomains no project may claim, either because they
+// collide with the platform's own endpoints or because they look "official"
+var DefaultReservedNames = []string{
+	"www", "api", "admin", "dashboard", "app", "auth", "login", "oauth",
+	"mail", "email", "smtp", "imap", "pop", "pop3", "mx", "ftp", "sftp", "ssh",
+	"ns", "ns1", "ns2", "ns3", "ns4", "dns", "cdn", "static", "assets",
+	"status", "support", "help", "docs", "blog", "billing", "security",
+	"webhook", "webhooks", "registry", "git", "vpn", "localhost", "internal",
+}
+
+// MaxLabelLength is the RFC 1123 limit for a single DNS label
+const MaxLabelLength = 63
+
+// MaxDomainLength is the RFC 1123 limit for a full domain name
+const MaxDomainLength = 253
+
+var (
+	ErrEmptyLabel       = errors.New("name is empty")
+	ErrLabelTooLong     = fmt.Errorf("name must be at most %d characters", MaxLabelLength)
+	ErrLabelInvalidChar = errors.New("name may only contain lowercase letters, digits and hyphens")
+	ErrLabelHyphenEdge  = errors.New("name must not start or end with a hyphen")
+	ErrReservedName     = errors.New("name is reserved by the platform")
+	ErrDoubleHyphen     = errors.New("name must not contain consecutive hyphens")
+	ErrPunycodeDomain   = errors.New("internationalized (punycode) domains are not allowed")
+	ErrNonASCIIDomain   = errors.New("domain contains non-ASCII characters")
+	ErrIPLiteralDomain  = errors.New("domain must be a DNS name, not an IP address")
+	ErrPlatformDomain   = errors.New("domain is under the platform's own domain")
+)
+
+// ValidateLabel checks that label is a valid RFC 1123 DNS label in canonical
+// (lowercase) form
+func ValidateLabel(label string) error {
+	if label == "" {
+		return ErrEmptyLabel
+	}
+	if len(label) > MaxLabelLength {
+		return ErrLabelTooLong
+	}
+	for _, char := range label {
+		if !isLabelChar(char) {
+			return ErrLabelInvalidChar
+		}
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return ErrLabelHyphenEdge
+	}
+	return nil
+}
+
+// ValidateCustomDomain checks a user-supplied domain (e.g. "www.example.com").
+// Punycode labels and non-ASCII characters are rejected outright since they are
+// the usual vehicle for homoglyph look-alike domains. IP addresses, v4 or v6,
+// are not domains, however valid their labels look.
+func ValidateCustomDomain(domain string) error {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return ErrEmptyLabel
+	}
+	if netutil.IsIPLiteral(domain) {
+		return ErrIPLiteralDomain
+	}
+	if len(domain) > MaxDomainLength {
+		return fmt.Errorf("domain must be at most %d characters", MaxDomainLength)
+	}
+	for _, char := range domain {
+		if char > 127 {
+			return ErrNonASCIIDomain
+		}
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return errors.New("domain must contain at least two labels")
+	}
+	for _, label := range labels {
+		if strings.HasPrefix(label, "xn--") {
+			return ErrPunycodeDomain
+		}
+		if err := ValidateLabel(label); err != nil {
+			return fmt.Errorf("invalid label %q: %w", label, err)
+		}
+	}
+	return nil
+}
+
+// SanitizeLabel turns an arbitrary string into a DNS label: lowercase, invalid
+// characters replaced by hyphens, no repeated or edge hyphens, at most 63 chars.
+// The result may be empty if the input had no usable characters.
+func SanitizeLabel(s string) string {
+	var b strings.Builder
+	lastHyphen := true // suppresses leading hyphens
+	for _, char := range strings.ToLower(s) {
+		if isLabelChar(char) && char != '-' {
+			b.WriteRune(char)
+			lastHyphen = false
+		} else if !lastHyphen {
+			b.WriteByte('-')
+			lastHyphen = true
+		}
+	}
+	return truncateLabel(b.String(), MaxLabelLength)
+}
+
+// IsReserved reports whether label is on the platform's reserved list
+func (m *Manager) IsReserved(label string) bool {
+	_, reserved := m.reserved[strings.ToLower(label)]
+	return reserved
+}
+
+// ValidateSlug checks that a project slug can be used as-is as the hostname label
+func (m *Manager) ValidateSlug(slug string) error {
+	if err := ValidateLabel(slug); err != nil {
+		return err
+	}
+	if m.IsReserved(slug) {
+		return fmt.Errorf("%q: %w", slug, ErrReservedName)
+	}
+	// "--" separates project and branch in alias hostnames
+	if strings.Contains(slug, aliasSeparator) {
+		return ErrDoubleHyphen
+	}
+	return nil
+}
+
+// safeLabel returns a label derived from slug that always passes ValidateSlug,
+// suffixing it when the sanitized slug is reserved
+func (m *Manager) safeLabel(slug string) string {
+	label := SanitizeLabel(slug)
+	if label == "" {
+		label = "project"
+	}
+	if m.IsReserved(label) {
+		label = withSuffix(label, "app")
+	}
+	return label
+}
+
+// withSuffix appends "-suffix" to label, shortening label so the result still
+// fits in a single DNS label
+func withSuffix(label, suffix string) string {
+	label = truncateLabel(label, MaxLabelLength-len(suffix)-1)
+	return label + "-" + suffix
+}
+
+func truncateLabel(label string, max int) string {
+	if len(label) > max {
+		label = label[:max]
+	}
+	return strings.TrimRight(label, "-")
+}
+
+func isLabelChar(char rune) bool {
+	return (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '-'
+}
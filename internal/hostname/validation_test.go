@@ -0,0 +1,146 @@
+package hostname
+
+import (
+	"deploy-platform/internal/config"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		err   error
+	}{
+		{"app", nil},
+		{"my-app-2", nil},
+		{"2048", nil}, // Leading digits are fine in RFC 1123
+		{"9lives", nil},
+		{strings.Repeat("a", 63), nil},
+		{strings.Repeat("a", 64), ErrLabelTooLong},
+		{"", ErrEmptyLabel},
+		{"App", ErrLabelInvalidChar},
+		{"MYAPP", ErrLabelInvalidChar},
+		{"my_app", ErrLabelInvalidChar},
+		{"my.app", ErrLabelInvalidChar},
+		{"rocket-🚀", ErrLabelInvalidChar},
+		{"café", ErrLabelInvalidChar},
+		{"-app", ErrLabelHyphenEdge},
+		{"app-", ErrLabelHyphenEdge},
+	}
+	for _, tt := range tests {
+		if err := ValidateLabel(tt.label); !errors.Is(err, tt.err) {
+			t.Errorf("ValidateLabel(%q) = %v, want %v", tt.label, err, tt.err)
+		}
+	}
+}
+
+func TestSanitizeLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"app", "app"},
+		{"My App", "my-app"},
+		{"UPPER_case", "upper-case"},
+		{"  --lots   of -- hyphens--  ", "lots-of-hyphens"},
+		{"🚀🚀🚀", ""},
+		{"rocket 🚀 ship", "rocket-ship"},
+		{"café", "caf"},
+		{"2048 game", "2048-game"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+		{strings.Repeat("a", 62) + "-b", strings.Repeat("a", 62)}, // No hyphen left at the cut
+	}
+	for _, tt := range tests {
+		got := SanitizeLabel(tt.in)
+		if got != tt.want {
+			t.Errorf("SanitizeLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got != "" {
+			if err := ValidateLabel(got); err != nil {
+				t.Errorf("SanitizeLabel(%q) = %q: %v", tt.in, got, err)
+			}
+		}
+	}
+}
+
+func TestValidateCustomDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		err    error
+	}{
+		{"www.example.com", nil},
+		{"example.com.", nil},
+		{"a.b.c.example.co.uk", nil},
+		{"xn--pple-43d.com", ErrPunycodeDomain}, // apple.com spelled with a Cyrillic a
+		{"shop.xn--80ak6aa92e.com", ErrPunycodeDomain},
+		{"\u0430pple.com", ErrNonASCIIDomain},
+		{"paypal.c\u03bfm", ErrNonASCIIDomain}, // Greek omicron
+		{"🚀.example.com", ErrNonASCIIDomain},
+		{"192.168.1.10", ErrIPLiteralDomain},
+		{"[::1]", ErrIPLiteralDomain},
+		{"2001:db8::1", ErrIPLiteralDomain},
+		{"", ErrEmptyLabel},
+		{"www." + strings.Repeat("a", 64) + ".com", ErrLabelTooLong},
+		{"-bad.example.com", ErrLabelHyphenEdge},
+		{"WWW.Example.com", ErrLabelInvalidChar},
+	}
+	for _, tt := range tests {
+		if err := ValidateCustomDomain(tt.domain); !errors.Is(err, tt.err) {
+			t.Errorf("ValidateCustomDomain(%q) = %v, want %v", tt.domain, err, tt.err)
+		}
+	}
+	for _, domain := range []string{"localhost", strings.Repeat("abcdefghi.", 26) + "com"} {
+		if err := ValidateCustomDomain(domain); err == nil {
+			t.Errorf("ValidateCustomDomain(%q) accepted", domain)
+		}
+	}
+}
+
+func TestCustomDomain(t *testing.T) {
+	m := NewManager(&config.Config{BaseDomain: "deploy.example.com", Clusters: []config.ClusterConfig{{Name: "eu", BaseDomain: "eu.example.net"}}})
+	tests := []struct {
+		domain, want string
+		err          error
+	}{
+		{"www.example.org", "www.example.org", nil},
+		{" WWW.Example.ORG. ", "www.example.org", nil},
+		{"app.deploy.example.com", "", ErrPlatformDomain},
+		{"deploy.example.com", "", ErrPlatformDomain},
+		{"app.EU.example.net", "", ErrPlatformDomain},
+		{"notdeploy.example.com", "notdeploy.example.com", nil},
+		{"xn--pple-43d.com", "", ErrPunycodeDomain},
+		// The Kelvin sign folds to k in Unicode, not here
+		{"\u212aelvin.example.org", "", ErrNonASCIIDomain},
+	}
+	for _, tt := range tests {
+		got, err := m.CustomDomain(tt.domain)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("CustomDomain(%q) = %q, %v, want %q, %v", tt.domain, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestProjectHostnames(t *testing.T) {
+	m := NewManager(&config.Config{BaseDomain: "deploy.example.com", ReservedSubdomains: []string{"Billing-Portal"}})
+	tests := []struct {
+		slug, want string
+	}{
+		{"app-store", "app-store.deploy.example.com"},
+		{"api", "api-app.deploy.example.com"},
+		{"billing-portal", "billing-portal-app.deploy.example.com"},
+		{"My_Project", "my-project.deploy.example.com"},
+		{"🚀", "project.deploy.example.com"},
+		{strings.Repeat("x", 80), strings.Repeat("x", 63) + ".deploy.example.com"},
+		{"www" + strings.Repeat("-", 3), "www-app.deploy.example.com"},
+	}
+	for _, tt := range tests {
+		if got := m.GenerateProjectHostname(tt.slug, "deploy.example.com"); got != tt.want {
+			t.Errorf("GenerateProjectHostname(%q) = %s, want %s", tt.slug, got, tt.want)
+		}
+	}
+	for slug, want := range map[string]error{"api": ErrReservedName, "a--b": ErrDoubleHyphen, "Api": ErrLabelInvalidChar, "ok-1": nil} {
+		if err := m.ValidateSlug(slug); !errors.Is(err, want) {
+			t.Errorf("ValidateSlug(%q) = %v, want %v", slug, err, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package httpclient
+
+import (
+	"context"
+	"deploy-platform/internal/metrics"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// CallTimeout bounds a call to a provider made while a user waits for
+	// the answer, e.g. during a sign-in callback
+	CallTimeout = 10 * time.Second
+
+	clientTimeout = 30 * time.Second // Backstop for callers passing no deadline
+	maxRetries    = 2
+	retryBackoff  = 250 * time.Millisecond // Doubled on each retry
+)
+
+// transport is shared by every provider: one connection pool, bounded dials
+var transport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   5 * time.Second,
+	ResponseHeaderTimeout: CallTimeout,
+	ExpectContinueTimeout: time.Second,
+}
+
+// New returns the client for calls to provider (github, google, oidc): the
+// shared connection pool, idempotent requests retried on network errors and
+// gateway errors, and request counts and latency exported as metrics
+func New(provider string) *http.Client {
+	return &http.Client{
+		Transport: &instrumented{provider: provider, base: transport},
+		Timeout:   clientTimeout,
+	}
+}
+
+// instrumented retries and counts the requests of one provider
+type instrumented struct {
+	provider string
+	base     http.RoundTripper
+}
+
+func (t *instrumented) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	for attempt := 0; attempt < maxRetries && retryable(req, resp, err); attempt++ {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			resp, err = nil, req.Context().Err()
+		case <-time.After(retryBackoff << attempt):
+			resp, err = t.base.RoundTrip(req)
+		}
+	}
+
+	outcome := "ok"
+	switch {
+	case IsTimeout(err):
+		outcome = "timeout"
+	case err != nil:
+		outcome = "error"
+	case resp.StatusCode >= 500:
+		outcome = "5xx"
+	case resp.StatusCode >= 400:
+		outcome = "4xx"
+	}
+	record(t.provider, outcome, time.Since(started))
+	return resp, err
+}
+
+// retryable reports whether a failed attempt is worth repeating: only
+// requests without a body that can be sent twice, after a network error or
+// a gateway error, and never once the caller gave up
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsTimeout reports whether err is a provider call running out of time, for
+// callers to tell users to try again
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// Metrics: request counts per provider and outcome, and the time spent
+type counter struct {
+	requests int64
+	seconds  float64
+}
+
+var (
+	statsMu sync.Mutex
+	stats   = map[[2]string]*counter{} // By provider and outcome
+)
+
+func record(provider, outcome string, took time.Duration) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	key := [2]string{provider, outcome}
+	if stats[key] == nil {
+		stats[key] = &counter{}
+	}
+	stats[key].requests++
+	stats[key].seconds += took.Seconds()
+}
+
+func collect(value func(*counter) float64) func() []metrics.Sample {
+	return func() []metrics.Sample {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		samples := make([]metrics.Sample, 0, len(stats))
+		for key, c := range stats {
+			samples = append(samples, metrics.Sample{
+				Labels: map[string]string{"provider": key[0], "outcome": key[1]},
+				Value:  value(c),
+			})
+		}
+		return samples
+	}
+}
+
+// RegisterMetrics exports the provider request counts and latency
+func RegisterMetrics() {
+	metrics.RegisterGauge("deploy_provider_requests_total", "Requests to external providers since start, by outcome", collect(func(c *counter) float64 { return float64(c.requests) }))
+	metrics.RegisterGauge("deploy_provider_request_seconds_total", "Time spent in requests to external providers since start, retries included", collect(func(c *counter) float64 { return c.seconds }))
+}
@@ -0,0 +1,123 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flaky answers with statuses in turn, the last one from then on, counting
+// the requests
+type flaky struct {
+	statuses []int
+	requests atomic.Int32
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(f.requests.Add(1))
+	if n > len(f.statuses) {
+		n = len(f.statuses)
+	}
+	w.WriteHeader(f.statuses[n-1])
+}
+
+// requests is the count of provider's requests that ended with outcome
+func requests(provider, outcome string) int64 {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if c := stats[[2]string{provider, outcome}]; c != nil {
+		return c.requests
+	}
+	return 0
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int
+		status   int   // Of the response returned
+		attempts int32 // Requests the server got
+		outcome  string
+	}{
+		{"gateway errors", http.MethodGet, []int{503, 502, 200}, 200, 3, "ok"},
+		{"head", http.MethodHead, []int{504, 200}, 200, 2, "ok"},
+		{"gives up", http.MethodGet, []int{503}, 503, 1 + maxRetries, "5xx"},
+		{"post", http.MethodPost, []int{503, 200}, 503, 1, "5xx"},
+		{"internal error", http.MethodGet, []int{500, 200}, 500, 1, "5xx"},
+		{"not found", http.MethodGet, []int{404, 200}, 404, 1, "4xx"},
+	}
+	for i, tt := range tests {
+		upstream := &flaky{statuses: tt.statuses}
+		server := httptest.NewServer(upstream)
+		provider := fmt.Sprintf("retries-%d", i)
+
+		req, _ := http.NewRequest(tt.method, server.URL, strings.NewReader(""))
+		resp, err := New(provider).Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		server.Close()
+		if resp.StatusCode != tt.status || upstream.requests.Load() != tt.attempts {
+			t.Errorf("%s: got %d after %d requests, want %d after %d", tt.name, resp.StatusCode, upstream.requests.Load(), tt.status, tt.attempts)
+		}
+		if got := requests(provider, tt.outcome); got != 1 {
+			t.Errorf("%s: %d requests counted %s", tt.name, got, tt.outcome)
+		}
+	}
+}
+
+// A slow provider fails the call once the caller's deadline passes, counted
+// as a timeout and not retried
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	started := time.Now()
+	_, err := New("slow").Do(req)
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("call took %s", elapsed)
+	}
+	if !IsTimeout(err) {
+		t.Errorf("got %v", err)
+	}
+	if attempts.Load() != 1 || requests("slow", "timeout") != 1 {
+		t.Errorf("%d attempts, %d timeouts counted", attempts.Load(), requests("slow", "timeout"))
+	}
+}
+
+type netTimeout struct{}
+
+func (netTimeout) Error() string   { return "i/o timeout" }
+func (netTimeout) Timeout() bool   { return true }
+func (netTimeout) Temporary() bool { return true }
+
+func TestIsTimeout(t *testing.T) {
+	for err, want := range map[error]bool{
+		fmt.Errorf("getting user info: %w", context.DeadlineExceeded): true,
+		fmt.Errorf("dial: %w", netTimeout{}):                          true,
+		context.Canceled:                                              false,
+		errors.New("connection refused"):                              false,
+		nil:                                                           false,
+	} {
+		if got := IsTimeout(err); got != want {
+			t.Errorf("%v: got %v", err, got)
+		}
+	}
+}
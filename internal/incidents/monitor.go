@@ -0,0 +1,266 @@
+package incidents
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/pkg/docker"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	failureThreshold = 2 // Consecutive failed checks opening an incident, so one blip doesn't
+	checkTimeout     = 10 * time.Second
+)
+
+// Check is the health check of one dependency
+type Check struct {
+	Component string // models.Incident* component
+	Target    string // Which one, e.g. the cluster name
+	Probe     func(ctx context.Context) error
+}
+
+// Checks returns the health checks of what the platform is configured with;
+// dockerClient and clusters may be nil
+func Checks(cfg *config.Config, dockerClient *docker.Client, clusters *kubernetes.ClientSet) []Check {
+	checks := []Check{{Component: models.IncidentDatabase, Probe: pingDatabase}}
+	if dockerClient != nil {
+		// Every replica builds on its own daemon
+		host, _ := os.Hostname()
+		checks = append(checks, Check{Component: models.IncidentDocker, Target: host, Probe: dockerClient.Ping})
+	}
+	for _, name := range clusters.Names() {
+		checks = append(checks, Check{Component: models.IncidentKubernetes, Target: name, Probe: clusters.Client(name).Ping})
+	}
+	if cfg.BaseImageRegistry != "" {
+		host, _, _ := strings.Cut(cfg.BaseImageRegistry, "/")
+		checks = append(checks, Check{Component: models.IncidentRegistry, Target: host, Probe: registryProbe(host)})
+	}
+	return checks
+}
+
+func pingDatabase(ctx context.Context) error {
+	db, err := database.DB.DB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
+// registryProbe checks the registry at host answers the Docker Registry
+// API. Any answer but a server error will do: /v2/ usually wants credentials.
+func registryProbe(host string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/v2/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("registry answered %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// Monitor runs the health checks, opening an incident when a dependency
+// fails failureThreshold checks in a row and resolving it when it passes one.
+// Every replica runs one; they share the incidents of shared dependencies.
+type Monitor struct {
+	checks   []Check
+	interval time.Duration
+	notifier *Notifier
+
+	mu       sync.Mutex
+	failures map[string]int              // Consecutive failures per check
+	since    map[string]time.Time        // When the current run of failures started
+	open     map[string]*models.Incident // Incidents this replica holds open, ID 0 until recorded
+}
+
+// NewMonitor configures the monitor of checks from HEALTH_CHECK_INTERVAL
+// and ADMIN_ALERT_WEBHOOK
+func NewMonitor(cfg *config.Config, checks []Check) *Monitor {
+	return &Monitor{
+		checks:   checks,
+		interval: cfg.HealthCheckInterval,
+		notifier: NewNotifier(cfg.AdminAlertWebhook),
+		failures: make(map[string]int),
+		since:    make(map[string]time.Time),
+		open:     make(map[string]*models.Incident),
+	}
+}
+
+// Start runs the checks every interval until ctx is done
+func (m *Monitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			m.runChecks(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("✅ Health of %d dependencies checked every %s", len(m.checks), m.interval)
+}
+
+// runChecks runs every check at once, each bounded by checkTimeout, and
+// records the results
+func (m *Monitor) runChecks(ctx context.Context) {
+	results := make([]error, len(m.checks))
+	var wg sync.WaitGroup
+	for i, check := range m.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			results[i] = check.Probe(checkCtx)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, check := range m.checks {
+		if results[i] != nil {
+			m.failed(check, results[i])
+		} else {
+			m.passed(check)
+		}
+	}
+}
+
+func checkKey(check Check) string {
+	return check.Component + "/" + check.Target
+}
+
+// failed counts a failed check, opening its incident at the threshold.
+// Callers hold m.mu.
+func (m *Monitor) failed(check Check, err error) {
+	key := checkKey(check)
+	if m.failures[key] == 0 {
+		m.since[key] = time.Now()
+	}
+	m.failures[key]++
+	if m.failures[key] < failureThreshold {
+		return
+	}
+
+	if incident, ok := m.open[key]; ok {
+		// Not recorded yet, e.g. while the database was the one down
+		if incident.ID == 0 && database.DB.Create(incident).Error == nil {
+			markDelayed()
+		}
+		return
+	}
+
+	// Another replica may have opened it already
+	var incident models.Incident
+	if database.DB.Where("component = ? AND target = ? AND ended_at IS NULL", check.Component, check.Target).
+		Order("id").Limit(1).Find(&incident).Error == nil && incident.ID != 0 {
+		m.open[key] = &incident
+		return
+	}
+
+	incident = models.Incident{Component: check.Component, Target: check.Target, Error: err.Error(), StartedAt: m.since[key]}
+	m.open[key] = &incident
+	if database.DB.Create(&incident).Error == nil {
+		markDelayed()
+	}
+	log.Printf("🚨 Incident: %s%s unavailable since %s: %v", check.Component, targetSuffix(check.Target), incident.StartedAt.Format(time.RFC3339), err)
+	m.notifier.send(EventOpened, &incident)
+}
+
+// passed resolves the check's incident, the one this replica holds open or
+// one another replica left open. Callers hold m.mu.
+func (m *Monitor) passed(check Check) {
+	key := checkKey(check)
+	m.failures[key] = 0
+	now := time.Now()
+
+	if incident, ok := m.open[key]; ok {
+		delete(m.open, key)
+		incident.EndedAt = &now
+		if incident.ID == 0 {
+			database.DB.Create(incident)
+			m.resolved(incident)
+			return
+		}
+		result := database.DB.Model(&models.Incident{}).Where("id = ? AND ended_at IS NULL", incident.ID).Update("ended_at", now)
+		if result.Error == nil && result.RowsAffected == 1 {
+			m.resolved(incident)
+		}
+		return
+	}
+
+	var stale []models.Incident
+	database.DB.Where("component = ? AND target = ? AND ended_at IS NULL", check.Component, check.Target).Find(&stale)
+	for i := range stale {
+		result := database.DB.Model(&models.Incident{}).Where("id = ? AND ended_at IS NULL", stale[i].ID).Update("ended_at", now)
+		if result.Error == nil && result.RowsAffected == 1 {
+			stale[i].EndedAt = &now
+			m.resolved(&stale[i])
+		}
+	}
+}
+
+func (m *Monitor) resolved(incident *models.Incident) {
+	log.Printf("✅ Incident resolved: %s%s is back after %s", incident.Component, targetSuffix(incident.Target), incident.EndedAt.Sub(incident.StartedAt).Round(time.Second))
+	m.notifier.send(EventResolved, incident)
+}
+
+// Open returns the incidents this replica holds open, including those it
+// couldn't record
+func (m *Monitor) Open() []models.Incident {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	incidents := make([]models.Incident, 0, len(m.open))
+	for _, incident := range m.open {
+		incidents = append(incidents, *incident)
+	}
+	return incidents
+}
+
+// markDelayed flags the deployments waiting to build when an incident opens
+func markDelayed() {
+	database.DB.Model(&models.Deployment{}).
+		Where("status IN ? AND delayed_by_incident = ?", []models.DeploymentStatus{models.StatusPending, models.StatusQueued}, false).
+		Update("delayed_by_incident", true)
+}
+
+// Ongoing reports whether an incident is open, for deployments created now
+// to be flagged delayed
+func Ongoing() bool {
+	var count int64
+	database.DB.Model(&models.Incident{}).Where("ended_at IS NULL").Count(&count)
+	return count > 0
+}
+
+func targetSuffix(target string) string {
+	if target == "" {
+		return ""
+	}
+	return " (" + target + ")"
+}
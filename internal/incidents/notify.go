@@ -0,0 +1,112 @@
+package incidents
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notification events
+const (
+	EventOpened   = "incident.opened"
+	EventResolved = "incident.resolved"
+)
+
+const notifyTimeout = 10 * time.Second
+
+var summaries = map[string]string{
+	models.IncidentDocker:     "Builds are delayed: container runtime unavailable",
+	models.IncidentKubernetes: "Deploys are delayed: cluster unavailable",
+	models.IncidentDatabase:   "The platform is degraded: database unavailable",
+	models.IncidentRegistry:   "Builds are delayed: image registry unavailable",
+}
+
+// Summary tells users what an incident of component means for them
+func Summary(component string) string {
+	if summary, ok := summaries[component]; ok {
+		return summary
+	}
+	return "The platform is degraded: " + component + " unavailable"
+}
+
+// Notification is POSTed to ADMIN_ALERT_WEBHOOK. Text makes it readable by
+// Slack-compatible webhooks as is.
+type Notification struct {
+	Event    string          `json:"event"`
+	Text     string          `json:"text"`
+	Incident models.Incident `json:"incident"`
+}
+
+// Alert is a notification of something other than an incident that admins
+// should look into, POSTed to ADMIN_ALERT_WEBHOOK like incident notifications
+type Alert struct {
+	Event   string      `json:"event"`
+	Text    string      `json:"text"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Notifier sends notifications to admins; without a webhook they are only
+// logged
+type Notifier struct {
+	webhook string
+	client  *http.Client
+}
+
+// NewNotifier returns a notifier posting to webhook, ADMIN_ALERT_WEBHOOK
+func NewNotifier(webhook string) *Notifier {
+	return &Notifier{webhook: webhook, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+// send posts the notification of an incident in the background
+func (n *Notifier) send(event string, incident *models.Incident) {
+	notification := Notification{Event: event, Text: notificationText(event, incident), Incident: *incident}
+	n.post(fmt.Sprintf("incident %d", incident.ID), notification)
+}
+
+// Alert posts an alert in the background
+func (n *Notifier) Alert(alert Alert) {
+	n.post(alert.Event, alert)
+}
+
+// post posts payload as JSON in the background; failures are logged
+func (n *Notifier) post(what string, payload interface{}) {
+	if n.webhook == "" {
+		return
+	}
+	go func() {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhook, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("⚠️  Failed to notify admins of %s: %v", what, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.client.Do(req)
+		if err != nil {
+			log.Printf("⚠️  Failed to notify admins of %s: %v", what, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("⚠️  Failed to notify admins of %s: webhook answered %s", what, resp.Status)
+		}
+	}()
+}
+
+func notificationText(event string, incident *models.Incident) string {
+	what := incident.Component + targetSuffix(incident.Target)
+	if event == EventResolved && incident.EndedAt != nil {
+		return fmt.Sprintf("✅ Resolved: %s is back after %s", what, incident.EndedAt.Sub(incident.StartedAt).Round(time.Second))
+	}
+	return fmt.Sprintf("🚨 %s unavailable since %s: %s", what, incident.StartedAt.UTC().Format("15:04 MST"), incident.Error)
+}
@@ -0,0 +1,189 @@
+// Package jsonstream writes large listings as they are read from the
+// database, a batch at a time, instead of building the whole response in
+// memory first. A listing is read with keyset pagination: each batch starts
+// after the last row of the previous one, so the database never has to
+// skip rows nor keep a cursor open while the client reads slowly.
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BatchSize is how many rows a batch should read
+const BatchSize = 500
+
+// Next reads the batch of rows following the row cursor, the key of the
+// last row of the previous batch (0 for the first). It returns the rows to
+// write and the key of the last row read, which is the cursor of the next
+// batch. A batch shorter than BatchSize is the last one.
+type Next func(ctx context.Context, cursor uint) (rows []interface{}, last uint, err error)
+
+// Encoder frames the rows of a listing. Encoders buffering rows also have
+// a Flush method, called after each batch.
+type Encoder interface {
+	ContentType() string
+	Begin(w io.Writer) error
+	Row(w io.Writer, index int, row interface{}) error
+	End(w io.Writer) error
+}
+
+// Stream writes the rows next reads, framed by encoder, with status 200.
+// The first batch is read before anything is written: its error is
+// returned for the caller to answer with an error response. Later errors
+// can only cut the response short, they are logged. Between batches the
+// response is flushed, and streaming stops once the client went away.
+func Stream(c *gin.Context, name string, encoder Encoder, next Next) error {
+	ctx := c.Request.Context()
+	rows, last, err := next(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	c.Header("Content-Type", encoder.ContentType())
+	c.Status(http.StatusOK)
+	w := c.Writer
+	if err := encoder.Begin(w); err != nil {
+		return nil
+	}
+	index := 0
+	for {
+		for _, row := range rows {
+			if err := encoder.Row(w, index, row); err != nil {
+				// The client went away
+				return nil
+			}
+			index++
+		}
+		if len(rows) < BatchSize {
+			break
+		}
+		if f, ok := encoder.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+		w.Flush()
+		if ctx.Err() != nil {
+			log.Printf("⚠️  Listing of %s stopped after %d rows: the client went away", name, index)
+			return nil
+		}
+		if rows, last, err = next(ctx, last); err != nil {
+			log.Printf("⚠️  Listing of %s interrupted after %d rows: %v", name, index, err)
+			return nil
+		}
+	}
+	encoder.End(w)
+	w.Flush()
+	return nil
+}
+
+// Array frames rows as a JSON array, the framing of lists that aren't paginated
+func Array() Encoder {
+	return &envelope{}
+}
+
+// Envelope frames rows as the array key of a JSON object that also has
+// fields, e.g. {"events": [...], "total": 1234}: the framing of paginated
+// lists. The fields are written before the rows.
+func Envelope(key string, fields gin.H) Encoder {
+	return &envelope{key: key, fields: fields}
+}
+
+// NDJSON frames rows as newline-delimited JSON, the framing of exports
+func NDJSON() Encoder {
+	return ndjson{}
+}
+
+// envelope is Array without key, Envelope with
+type envelope struct {
+	key    string
+	fields gin.H
+}
+
+func (e *envelope) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (e *envelope) Begin(w io.Writer) error {
+	if e.key == "" {
+		_, err := io.WriteString(w, "[")
+		return err
+	}
+	if _, err := io.WriteString(w, "{"); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := writeField(w, k, e.fields[k]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	key, _ := json.Marshal(e.key)
+	_, err := w.Write(append(key, ":["...))
+	return err
+}
+
+func (e *envelope) Row(w io.Writer, index int, row interface{}) error {
+	encoded, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if index > 0 {
+		encoded = append([]byte{','}, encoded...)
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+func (e *envelope) End(w io.Writer) error {
+	end := "]"
+	if e.key != "" {
+		end = "]}"
+	}
+	_, err := io.WriteString(w, end)
+	return err
+}
+
+type ndjson struct{}
+
+func (ndjson) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (ndjson) Begin(w io.Writer) error {
+	return nil
+}
+
+func (ndjson) Row(w io.Writer, index int, row interface{}) error {
+	return json.NewEncoder(w).Encode(row)
+}
+
+func (ndjson) End(w io.Writer) error {
+	return nil
+}
+
+// writeField writes "key":value
+func writeField(w io.Writer, key string, value interface{}) error {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(append(k, ':'), v...))
+	return err
+}
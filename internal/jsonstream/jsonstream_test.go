@@ -0,0 +1,297 @@
+package jsonstream
+
+import (
+	"bufio"
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type item struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// items reads n items with IDs 1 to n, in batches, counting the batches
+// read
+func items(n int, batches *int) Next {
+	return func(ctx context.Context, cursor uint) ([]interface{}, uint, error) {
+		*batches++
+		var rows []interface{}
+		for id := cursor + 1; id <= uint(n) && len(rows) < BatchSize; id++ {
+			rows = append(rows, item{ID: id, Name: fmt.Sprintf("item-%d", id)})
+		}
+		return rows, cursor + uint(len(rows)), nil
+	}
+}
+
+func streamed(t *testing.T, encoder Encoder, next Next) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := Stream(c, "items", encoder, next); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// Every row is written once, in order, whichever framing and however many
+// batches it takes
+func TestFramings(t *testing.T) {
+	for _, n := range []int{0, 1, BatchSize, 2*BatchSize + 3} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			check := func(framing string, rows []item) {
+				t.Helper()
+				if len(rows) != n {
+					t.Fatalf("%s: %d rows", framing, len(rows))
+				}
+				for i, row := range rows {
+					if row.ID != uint(i+1) {
+						t.Fatalf("%s: row %d is %+v", framing, i, row)
+					}
+				}
+			}
+
+			batches := 0
+			w := streamed(t, Array(), items(n, &batches))
+			var array []item
+			if err := json.Unmarshal(w.Body.Bytes(), &array); err != nil || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+				t.Fatalf("%v: %s", err, w.Body.String())
+			}
+			check("array", array)
+			if want := n/BatchSize + 1; batches != want {
+				t.Errorf("read %d batches, want %d", batches, want)
+			}
+
+			w = streamed(t, Envelope("items", gin.H{"total": n, "next": nil}), items(n, &batches))
+			var envelope struct {
+				Total int    `json:"total"`
+				Items []item `json:"items"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Total != n {
+				t.Fatalf("%v: %s", err, w.Body.String())
+			}
+			if !strings.HasPrefix(w.Body.String(), fmt.Sprintf(`{"next":null,"total":%d,"items":[`, n)) {
+				t.Errorf("envelope %.60s", w.Body.String())
+			}
+			check("envelope", envelope.Items)
+
+			w = streamed(t, NDJSON(), items(n, &batches))
+			var lines []item
+			scanner := bufio.NewScanner(w.Body)
+			for scanner.Scan() {
+				var row item
+				if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+					t.Fatalf("%v: %q", err, scanner.Text())
+				}
+				lines = append(lines, row)
+			}
+			if w.Header().Get("Content-Type") != "application/x-ndjson" {
+				t.Errorf("content type %s", w.Header().Get("Content-Type"))
+			}
+			check("ndjson", lines)
+		})
+	}
+}
+
+// buffered is an encoder buffering rows until flushed
+type buffered struct {
+	ndjson
+	pending []interface{}
+	flushes int
+}
+
+func (b *buffered) Row(w io.Writer, index int, row interface{}) error {
+	b.pending = append(b.pending, row)
+	return nil
+}
+
+func (b *buffered) Flush() {
+	b.pending = nil
+	b.flushes++
+}
+
+func TestFlushesBufferingEncoders(t *testing.T) {
+	batches := 0
+	encoder := &buffered{}
+	streamed(t, encoder, items(2*BatchSize+1, &batches))
+	if encoder.flushes != 2 {
+		t.Errorf("flushed %d times", encoder.flushes)
+	}
+}
+
+// An error reading the first batch is the caller's to answer; a later one
+// can only cut the response short
+func TestErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	failing := errors.New("database is locked")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	err := Stream(c, "items", Array(), func(ctx context.Context, cursor uint) ([]interface{}, uint, error) {
+		return nil, 0, failing
+	})
+	if !errors.Is(err, failing) || w.Body.Len() != 0 || c.Writer.Written() {
+		t.Errorf("got %v, wrote %q", err, w.Body.String())
+	}
+
+	batches := 0
+	read := items(3*BatchSize, &batches)
+	w = streamed(t, Array(), func(ctx context.Context, cursor uint) ([]interface{}, uint, error) {
+		if cursor > 0 {
+			return nil, 0, failing
+		}
+		return read(ctx, cursor)
+	})
+	var rows []item
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &rows) == nil || strings.Count(w.Body.String(), `"id"`) != BatchSize {
+		t.Errorf("got %d with %d rows", w.Code, strings.Count(w.Body.String(), `"id"`))
+	}
+}
+
+// Once the client went away no more batches are read
+func TestClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := 0
+	read := items(10*BatchSize, &batches)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	Stream(c, "items", NDJSON(), func(ctx context.Context, cursor uint) ([]interface{}, uint, error) {
+		if cursor == 2*BatchSize {
+			cancel()
+		}
+		return read(ctx, cursor)
+	})
+	if batches != 3 || strings.Count(w.Body.String(), "\n") != 3*BatchSize {
+		t.Errorf("read %d batches, wrote %d rows", batches, strings.Count(w.Body.String(), "\n"))
+	}
+}
+
+// record is a listed row as large as a deployment's
+type record struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	CommitSHA string    `json:"commit_sha"`
+	CommitMsg string    `json:"commit_msg"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const benchRows = 50000
+
+// records creates benchRows records
+func records(b *testing.B) {
+	b.Helper()
+	testutil.DB(b)
+	if err := database.DB.AutoMigrate(&record{}); err != nil {
+		b.Fatal(err)
+	}
+	batch := make([]record, 1000)
+	for i := 0; i < benchRows; i += len(batch) {
+		for j := range batch {
+			batch[j] = record{
+				Name: fmt.Sprintf("app-%d", i+j), Status: "deployed", CommitSHA: fmt.Sprintf("%040x", i+j),
+				CommitMsg: strings.Repeat("Bump dependencies ", 8), CreatedAt: time.Now(),
+			}
+		}
+		if err := database.DB.Create(&batch).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// discard is a response writer keeping nothing of the response
+type discard struct{ header http.Header }
+
+func (d *discard) Header() http.Header         { return d.header }
+func (d *discard) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discard) WriteHeader(int)             {}
+func (d *discard) Flush()                      {}
+
+// peakHeap runs handler b.N times, reporting the most heap it used on top
+// of what was in use before
+func peakHeap(b *testing.B, handler gin.HandlerFunc) {
+	gin.SetMode(gin.TestMode)
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	base, peak := stats.HeapAlloc, stats.HeapAlloc
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+		}
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, _ := gin.CreateTestContext(&discard{header: http.Header{}})
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		handler(c)
+	}
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+	b.ReportMetric(float64(peak-base)/(1<<20), "peak-heap-MB")
+}
+
+// go test -run - -bench . ./internal/jsonstream/ compares the heap listing
+// 50k rows takes when streamed and when loaded whole, as listings did
+// before: streaming stays flat, a batch at a time.
+
+func BenchmarkStream(b *testing.B) {
+	records(b)
+	peakHeap(b, func(c *gin.Context) {
+		Stream(c, "records", Array(), func(ctx context.Context, cursor uint) ([]interface{}, uint, error) {
+			var batch []record
+			if err := database.DB.WithContext(ctx).Where("id > ?", cursor).Order("id").Limit(BatchSize).Find(&batch).Error; err != nil || len(batch) == 0 {
+				return nil, 0, err
+			}
+			rows := make([]interface{}, len(batch))
+			for i := range batch {
+				rows[i] = &batch[i]
+			}
+			return rows, batch[len(batch)-1].ID, nil
+		})
+	})
+}
+
+func BenchmarkLoadAll(b *testing.B) {
+	records(b)
+	peakHeap(b, func(c *gin.Context) {
+		var all []record
+		if err := database.DB.Order("id").Find(&all).Error; err != nil {
+			b.Fatal(err)
+		}
+		c.JSON(http.StatusOK, all)
+	})
+}
@@ -0,0 +1,299 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Field ownership of the Deployments, Services and Ingresses the platform
+// applies. They are rendered from scratch on every deploy, but other platform
+// features and cluster admins change them too, so applying merges what was
+// rendered into the live object, rather than replacing it, and retries when
+// someone else wrote it in between:
+//
+//   - Labels and annotations, of objects and of pod templates: the platform
+//     owns the keys it renders, and those it rendered last time (recorded in
+//     AnnotationManaged), so a key it stops rendering, like a removed ingress
+//     setting or custom annotation, is removed. Other keys are left alone,
+//     e.g. kubectl rollout restart's.
+//   - Ingress rules: the platform owns the paths it renders, by host and
+//     path, and those it rendered last time. Other paths and rules, and the
+//     TLS and class of the Ingress, are left alone.
+//   - Deployment spec: the platform owns it, except its pod template's
+//     labels and annotations.
+//   - Service spec: the platform owns its type, selector and ports; cluster
+//     IPs and the rest are the cluster's.
+//
+// Objects applied before ownership was recorded are taken over as a whole
+// once, as every deploy did until then.
+
+// AnnotationManaged records, as JSON, the fields of an object the platform
+// owns
+const AnnotationManaged = "deploy-platform.io/managed"
+
+// managedFields are the fields recorded in AnnotationManaged
+type managedFields struct {
+	Labels              []string `json:"labels,omitempty"`
+	Annotations         []string `json:"annotations,omitempty"`
+	TemplateLabels      []string `json:"template_labels,omitempty"`
+	TemplateAnnotations []string `json:"template_annotations,omitempty"`
+	Paths               []string `json:"paths,omitempty"` // Of Ingress rules, as host+path
+}
+
+// readManaged returns the fields the platform owned in the live object meta,
+// or false when it doesn't record them
+func readManaged(meta *metav1.ObjectMeta) (managedFields, bool) {
+	var managed managedFields
+	recorded, ok := meta.Annotations[AnnotationManaged]
+	if !ok || json.Unmarshal([]byte(recorded), &managed) != nil {
+		return managedFields{}, false
+	}
+	return managed, true
+}
+
+// writeManaged records managed in meta
+func writeManaged(meta *metav1.ObjectMeta, managed managedFields) {
+	recorded, _ := json.Marshal(managed)
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string, 1)
+	}
+	meta.Annotations[AnnotationManaged] = string(recorded)
+}
+
+// mergeKeys returns live with the keys of rendered set and those of previous
+// no longer rendered removed, and the keys now owned
+func mergeKeys(live, rendered map[string]string, previous []string) (map[string]string, []string) {
+	merged := make(map[string]string, len(live)+len(rendered))
+	for k, v := range live {
+		merged[k] = v
+	}
+	for _, k := range previous {
+		delete(merged, k)
+	}
+	owned := make([]string, 0, len(rendered))
+	for k, v := range rendered {
+		merged[k] = v
+		owned = append(owned, k)
+	}
+	sort.Strings(owned)
+	if len(merged) == 0 {
+		return nil, owned
+	}
+	return merged, owned
+}
+
+// keysOf lists the keys of m: all of a live object's labels or annotations
+// are the platform's before ownership was recorded
+func keysOf(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// mergeMeta merges the labels and annotations of rendered into live, as
+// owned by managed or, when not recorded, entirely
+func mergeMeta(live, rendered *metav1.ObjectMeta, managed *managedFields, recorded bool) {
+	previousLabels, previousAnnotations := managed.Labels, managed.Annotations
+	if !recorded {
+		previousLabels, previousAnnotations = keysOf(live.Labels), keysOf(live.Annotations)
+	}
+	live.Labels, managed.Labels = mergeKeys(live.Labels, rendered.Labels, previousLabels)
+	live.Annotations, managed.Annotations = mergeKeys(live.Annotations, rendered.Annotations, previousAnnotations)
+}
+
+// mergeDeployment merges rendered into the live Deployment
+func mergeDeployment(live, rendered *appsv1.Deployment) {
+	managed, recorded := readManaged(&live.ObjectMeta)
+	mergeMeta(&live.ObjectMeta, &rendered.ObjectMeta, &managed, recorded)
+
+	template := live.Spec.Template.ObjectMeta
+	live.Spec = rendered.Spec
+	previousLabels, previousAnnotations := managed.TemplateLabels, managed.TemplateAnnotations
+	if !recorded {
+		previousLabels, previousAnnotations = keysOf(template.Labels), keysOf(template.Annotations)
+	}
+	live.Spec.Template.Labels, managed.TemplateLabels = mergeKeys(template.Labels, rendered.Spec.Template.Labels, previousLabels)
+	live.Spec.Template.Annotations, managed.TemplateAnnotations = mergeKeys(template.Annotations, rendered.Spec.Template.Annotations, previousAnnotations)
+	writeManaged(&live.ObjectMeta, managed)
+}
+
+// mergeService merges rendered into the live Service
+func mergeService(live, rendered *corev1.Service) {
+	managed, recorded := readManaged(&live.ObjectMeta)
+	mergeMeta(&live.ObjectMeta, &rendered.ObjectMeta, &managed, recorded)
+	live.Spec.Type = rendered.Spec.Type
+	live.Spec.Selector = rendered.Spec.Selector
+	live.Spec.Ports = rendered.Spec.Ports
+	live.Spec.ExternalName = rendered.Spec.ExternalName
+	writeManaged(&live.ObjectMeta, managed)
+}
+
+// mergeIngress merges rendered into the live Ingress
+func mergeIngress(live, rendered *networkingv1.Ingress) {
+	managed, recorded := readManaged(&live.ObjectMeta)
+	mergeMeta(&live.ObjectMeta, &rendered.ObjectMeta, &managed, recorded)
+	previous := managed.Paths
+	if !recorded {
+		previous = ingressPaths(live.Spec.Rules)
+	}
+	live.Spec.Rules, managed.Paths = mergeRules(live.Spec.Rules, rendered.Spec.Rules, previous)
+	writeManaged(&live.ObjectMeta, managed)
+}
+
+// ingressPaths lists the paths of rules as host+path
+func ingressPaths(rules []networkingv1.IngressRule) []string {
+	var paths []string
+	for _, rule := range rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			paths = append(paths, rule.Host+path.Path)
+		}
+	}
+	return paths
+}
+
+// mergeRules returns the rendered rules followed by the paths of live not
+// in previous nor rendered, grouped by host, and the paths now owned
+func mergeRules(live, rendered []networkingv1.IngressRule, previous []string) ([]networkingv1.IngressRule, []string) {
+	owned := ingressPaths(rendered)
+	replaced := make(map[string]bool, len(previous)+len(owned))
+	for _, path := range previous {
+		replaced[path] = true
+	}
+	for _, path := range owned {
+		replaced[path] = true
+	}
+
+	merged := make([]networkingv1.IngressRule, 0, len(live)+len(rendered))
+	byHost := make(map[string]int, len(rendered))
+	for _, rule := range rendered {
+		if rule.HTTP != nil {
+			rule.HTTP = &networkingv1.HTTPIngressRuleValue{Paths: append([]networkingv1.HTTPIngressPath(nil), rule.HTTP.Paths...)}
+			byHost[rule.Host] = len(merged)
+		}
+		merged = append(merged, rule)
+	}
+	for _, rule := range live {
+		if rule.HTTP == nil {
+			merged = append(merged, rule)
+			continue
+		}
+		var kept []networkingv1.HTTPIngressPath
+		for _, path := range rule.HTTP.Paths {
+			if !replaced[rule.Host+path.Path] {
+				kept = append(kept, path)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		if i, ok := byHost[rule.Host]; ok {
+			merged[i].HTTP.Paths = append(merged[i].HTTP.Paths, kept...)
+			continue
+		}
+		byHost[rule.Host] = len(merged)
+		merged = append(merged, networkingv1.IngressRule{
+			Host:             rule.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: kept}},
+		})
+	}
+	return merged, owned
+}
+
+// retryApply runs apply again while someone else wrote the object between
+// its read and its write
+func retryApply(apply func() error) error {
+	return retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}, apply)
+}
+
+// applyDeployment creates deployment, or merges it into the live one
+func (c *Client) applyDeployment(ctx context.Context, deployment *appsv1.Deployment) error {
+	deployments := c.clientset.AppsV1().Deployments(Namespace)
+	err := retryApply(func() error {
+		live, err := deployments.Get(ctx, deployment.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			live = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: deployment.Name, Namespace: Namespace}}
+			mergeDeployment(live, deployment)
+			_, err = deployments.Create(ctx, live, metav1.CreateOptions{FieldManager: ManagedBy})
+			return err
+		} else if err != nil {
+			return err
+		}
+		mergeDeployment(live, deployment)
+		_, err = deployments.Update(ctx, live, metav1.UpdateOptions{FieldManager: ManagedBy})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply deployment: %w", err)
+	}
+	return nil
+}
+
+// applyService creates service, or merges it into the live one
+func (c *Client) applyService(ctx context.Context, service *corev1.Service) error {
+	services := c.clientset.CoreV1().Services(Namespace)
+	err := retryApply(func() error {
+		live, err := services.Get(ctx, service.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			live = &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: service.Name, Namespace: Namespace}}
+			mergeService(live, service)
+			_, err = services.Create(ctx, live, metav1.CreateOptions{FieldManager: ManagedBy})
+			return err
+		} else if err != nil {
+			return err
+		}
+		mergeService(live, service)
+		_, err = services.Update(ctx, live, metav1.UpdateOptions{FieldManager: ManagedBy})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply service %s: %w", service.Name, err)
+	}
+	return nil
+}
+
+// applyIngress creates or merges ingress into the live one, or deletes the
+// Ingress named name when ingress is nil
+func (c *Client) applyIngress(ctx context.Context, name string, ingress *networkingv1.Ingress) error {
+	ingresses := c.clientset.NetworkingV1().Ingresses(Namespace)
+	if ingress == nil {
+		if err := ingresses.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete ingress: %w", err)
+		}
+		return nil
+	}
+
+	err := retryApply(func() error {
+		live, err := ingresses.Get(ctx, ingress.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			live = &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: ingress.Name, Namespace: Namespace}}
+			mergeIngress(live, ingress)
+			_, err = ingresses.Create(ctx, live, metav1.CreateOptions{FieldManager: ManagedBy})
+			return err
+		} else if err != nil {
+			return err
+		}
+		mergeIngress(live, ingress)
+		_, err = ingresses.Update(ctx, live, metav1.UpdateOptions{FieldManager: ManagedBy})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply ingress: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// errModified is the API server's reason for a conflicting write
+var errModified = fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again")
+
+// routes lists the paths of an Ingress as host+path -> backend Service
+func routes(ingress *networkingv1.Ingress) map[string]string {
+	routes := map[string]string{}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			routes[rule.Host+path.Path] = path.Backend.Service.Name
+		}
+	}
+	return routes
+}
+
+func getIngress(t *testing.T, clientset *fake.Clientset, name string) *networkingv1.Ingress {
+	t.Helper()
+	ingress, err := clientset.NetworkingV1().Ingresses(Namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ingress
+}
+
+// addRoute is another feature, or a cluster admin, routing host+path of the
+// live Ingress to service
+func addRoute(ingress *networkingv1.Ingress, host, path, service string) {
+	route := ingressRule(host, service).HTTP.Paths[0]
+	route.Path = path
+	for i := range ingress.Spec.Rules {
+		if ingress.Spec.Rules[i].Host == host {
+			ingress.Spec.Rules[i].HTTP.Paths = append(ingress.Spec.Rules[i].HTTP.Paths, route)
+			return
+		}
+	}
+	rule := ingressRule(host, service)
+	rule.HTTP.Paths[0] = route
+	ingress.Spec.Rules = append(ingress.Spec.Rules, rule)
+}
+
+// A routine deploy keeps what others added to the objects since the last
+// one, and only changes or removes what the platform rendered
+func TestDeployKeepsOthersChanges(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient()
+	deployment := webDeployment(7)
+	bodySize := 10
+	deployment.Project.Ingress.MaxBodySizeMB = &bodySize
+	deployment.Project.CustomDomains = []string{"www.acme.com", "shop.acme.com"}
+	name := ProjectResourceName(7)
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", map[string]string{"PORT": "8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Others change the Ingress, the Deployment and the Service
+	ingress := getIngress(t, clientset, name)
+	addRoute(ingress, "app.example.com", "/docs", "docs")
+	addRoute(ingress, "status.acme.com", "/", "statuspage")
+	ingress.Annotations["cert-manager.io/cluster-issuer"] = "letsencrypt"
+	ingress.Labels["team"] = "payments"
+	className := "nginx-internal"
+	ingress.Spec.IngressClassName = &className
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"app.example.com"}, SecretName: "app-tls"}}
+	if _, err := clientset.NetworkingV1().Ingresses(Namespace).Update(ctx, ingress, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	live, _ := clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{})
+	live.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2026-10-16T12:00:00Z"
+	clientset.AppsV1().Deployments(Namespace).Update(ctx, live, metav1.UpdateOptions{})
+	service, _ := clientset.CoreV1().Services(Namespace).Get(ctx, name, metav1.GetOptions{})
+	service.Spec.ClusterIP = "10.96.0.42"
+	clientset.CoreV1().Services(Namespace).Update(ctx, service, metav1.UpdateOptions{})
+
+	// The next deploy drops a setting and a custom domain, and changes the image
+	deployment.Project.Ingress.MaxBodySizeMB = nil
+	deployment.Project.CustomDomains = []string{"www.acme.com"}
+	deployment.ImageTag = "registry.example.com/app:2"
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", map[string]string{"PORT": "8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ingress = getIngress(t, clientset, name)
+	want := map[string]string{
+		"app.example.com/":     name,
+		"www.acme.com/":        name,
+		"app.example.com/docs": "docs",
+		"status.acme.com/":     "statuspage",
+	}
+	if got := routes(ingress); !reflect.DeepEqual(got, want) {
+		t.Errorf("routes %v, want %v", got, want)
+	}
+	if ingress.Annotations["cert-manager.io/cluster-issuer"] != "letsencrypt" || ingress.Labels["team"] != "payments" {
+		t.Errorf("others' metadata dropped: %v, %v", ingress.Annotations, ingress.Labels)
+	}
+	if _, ok := ingress.Annotations[annotationProxyBodySize]; ok {
+		t.Errorf("removed setting still annotated: %v", ingress.Annotations)
+	}
+	if ingress.Spec.IngressClassName == nil || *ingress.Spec.IngressClassName != className || len(ingress.Spec.TLS) != 1 {
+		t.Errorf("class %v, TLS %v", ingress.Spec.IngressClassName, ingress.Spec.TLS)
+	}
+
+	live, _ = clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{})
+	if live.Spec.Template.Spec.Containers[0].Image != "registry.example.com/app:2" || live.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] == "" {
+		t.Errorf("deployment image %s, template annotations %v", live.Spec.Template.Spec.Containers[0].Image, live.Spec.Template.Annotations)
+	}
+	if service, _ = clientset.CoreV1().Services(Namespace).Get(ctx, name, metav1.GetOptions{}); service.Spec.ClusterIP != "10.96.0.42" {
+		t.Errorf("cluster IP %q", service.Spec.ClusterIP)
+	}
+}
+
+// Objects the platform applied before it recorded what it owns are taken
+// over as a whole once
+func TestMergeIngressWithoutOwnership(t *testing.T) {
+	deployment := webDeployment(7)
+	name := ProjectResourceName(7)
+	live := BuildIngress(deployment, name, "old.example.com")
+	live.Annotations = map[string]string{annotationProxyBodySize: "10m"}
+	rendered := BuildIngress(deployment, name, "app.example.com")
+
+	mergeIngress(live, rendered)
+	if got := routes(live); !reflect.DeepEqual(got, map[string]string{"app.example.com/": name}) {
+		t.Errorf("routes %v", got)
+	}
+	managed, ok := readManaged(&live.ObjectMeta)
+	if !ok || !reflect.DeepEqual(managed.Paths, []string{"app.example.com/"}) {
+		t.Errorf("managed %+v", managed)
+	}
+	if _, ok := live.Annotations[annotationProxyBodySize]; ok {
+		t.Errorf("annotations %v", live.Annotations)
+	}
+}
+
+// Another writer between a deploy's read and its write makes the write
+// conflict: the deploy reads again and merges, dropping nothing
+func TestApplyRetriesOnConflict(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient()
+	deployment := webDeployment(7)
+	name := ProjectResourceName(7)
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	gvr := networkingv1.SchemeGroupVersion.WithResource("ingresses")
+	conflicts := 0
+	clientset.PrependReactor("update", "ingresses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 2 {
+			return false, nil, nil
+		}
+		conflicts++
+		// A custom domain and a cluster admin each add a route first
+		obj, err := clientset.Tracker().Get(gvr, Namespace, name)
+		if err != nil {
+			return true, nil, err
+		}
+		live := obj.(*networkingv1.Ingress)
+		addRoute(live, map[int]string{1: "www.acme.com", 2: "status.acme.com"}[conflicts], "/", "other")
+		if err := clientset.Tracker().Update(gvr, live, Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, nil, errors.NewConflict(schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}, name, errModified)
+	})
+
+	deployment.Project.Ingress.MaxBodySizeMB = new(int)
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	ingress := getIngress(t, clientset, name)
+	var got []string
+	for path := range routes(ingress) {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	if want := []string{"app.example.com/", "status.acme.com/", "www.acme.com/"}; conflicts != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("after %d conflicts: routes %v, want %v", conflicts, got, want)
+	}
+	if ingress.Annotations[annotationProxyBodySize] != "0m" {
+		t.Errorf("the deploy's change was lost: %v", ingress.Annotations)
+	}
+}
+
+// A write that keeps conflicting fails the deploy rather than forcing it
+func TestApplyGivesUpOnConflicts(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: ProjectResourceName(7), Namespace: Namespace}})
+	clientset.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, ProjectResourceName(7), errModified)
+	})
+	err := client.CreateDeployment(ctx, webDeployment(7), "app.example.com", nil)
+	if !errors.IsConflict(err) {
+		t.Errorf("got %v", err)
+	}
+}
@@ -1,26 +1,40 @@
 package kubernetes
 
 import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type Client struct {
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	config    *rest.Config
 }
 
 func NewClient(kubeconfigPath string) (*Client, error) {
+	return NewClientWithContext(kubeconfigPath, "")
+}
+
+// NewClientWithContext connects to the cluster of context in the kubeconfig
+// at kubeconfigPath, its current context when empty
+func NewClientWithContext(kubeconfigPath, context string) (*Client, error) {
 	var config *rest.Config
 	var err error
 
 	if kubeconfigPath == "" {
 		// In-cluster config
 		config, err = rest.InClusterConfig()
-	} else {
+	} else if context == "" {
 		// Out-of-cluster config
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	} else {
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+			&clientcmd.ConfigOverrides{CurrentContext: context},
+		).ClientConfig()
 	}
 
 	if err != nil {
@@ -37,3 +51,10 @@ func NewClient(kubeconfigPath string) (*Client, error) {
 		config:    config,
 	}, nil
 }
+
+// Ping checks the cluster's API server answers, listing at most one pod of
+// the platform's namespace
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.clientset.CoreV1().Pods(Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	return err
+}
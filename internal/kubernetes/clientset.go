@@ -0,0 +1,81 @@
+package kubernetes
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"log"
+)
+
+// ClientSet holds a client per configured cluster projects can deploy to.
+// Its methods are safe on a nil ClientSet, which has no clusters.
+type ClientSet struct {
+	clients        map[string]*Client
+	names          []string // Connected clusters, in configuration order
+	defaultCluster string
+}
+
+// NewClientSet connects to the clusters of cfg. Clusters that can't be
+// reached are logged and left out; nil if none could.
+func NewClientSet(cfg *config.Config) *ClientSet {
+	set := &ClientSet{clients: make(map[string]*Client), defaultCluster: cfg.DefaultCluster}
+	for _, cluster := range cfg.Clusters {
+		client, err := NewClientWithContext(cluster.Kubeconfig, cluster.Context)
+		if err != nil {
+			log.Printf("⚠️  Warning: Failed to initialize Kubernetes client for cluster %s: %v", cluster.Name, err)
+			continue
+		}
+		set.clients[cluster.Name] = client
+		set.names = append(set.names, cluster.Name)
+		log.Printf("✅ Kubernetes client initialized for cluster %s", cluster.Name)
+	}
+	if len(set.names) == 0 {
+		return nil
+	}
+	return set
+}
+
+// Client returns the client of the named cluster, the default one when
+// name is empty; nil if it isn't connected
+func (s *ClientSet) Client(name string) *Client {
+	if s == nil {
+		return nil
+	}
+	if name == "" {
+		name = s.defaultCluster
+	}
+	return s.clients[name]
+}
+
+// ForProject returns the client of the cluster the project deploys to
+func (s *ClientSet) ForProject(project *models.Project) *Client {
+	return s.Client(project.Cluster)
+}
+
+// ClusterOf names the cluster the project deploys to
+func (s *ClientSet) ClusterOf(project *models.Project) string {
+	if project.Cluster != "" || s == nil {
+		return project.Cluster
+	}
+	return s.defaultCluster
+}
+
+// Has reports whether name is a connected cluster
+func (s *ClientSet) Has(name string) bool {
+	return s.Client(name) != nil && name != ""
+}
+
+// Names lists the connected clusters
+func (s *ClientSet) Names() []string {
+	if s == nil {
+		return nil
+	}
+	return s.names
+}
+
+// Default names the cluster of projects that don't choose one
+func (s *ClientSet) Default() string {
+	if s == nil {
+		return ""
+	}
+	return s.defaultCluster
+}
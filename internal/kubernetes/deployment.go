@@ -4,6 +4,7 @@ import (
 	"context"
 	"deploy-platform/internal/models"
 	"fmt"
+	"strconv"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -19,41 +20,116 @@ func (c *Client) CreateOrUpdateDeployment(ctx context.Context, deployment *model
 	return c.CreateDeployment(ctx, deployment, hostname, envVars)
 }
 
+// CreateDeployment applies the manifests of deployment, with its manifest
+// patches, the env Secret first. The Deployment, Service and Ingress are
+// merged into the live ones (see apply.go). The Ingress and NetworkPolicy follow the project's
+// visibility: internal projects have their Ingress removed. Workers have
+// their Service and Ingress removed.
 func (c *Client) CreateDeployment(ctx context.Context, deployment *models.Deployment, hostname string, envVars map[string]string) error {
-	namespace := "default" // Or create per-project namespace
-	// Use project-based name (Vercel-style: one deployment per project that updates)
-	deploymentName := fmt.Sprintf("project-%d", deployment.ProjectID)
+	namespace := Namespace
+	manifests, err := BuildPatchedManifests(deployment, hostname, envVars)
+	if err != nil {
+		return err
+	}
+	k8sDeployment, service := manifests.Deployment, manifests.Service
+
+	if err := c.applySecret(ctx, manifests.Secret); err != nil {
+		return err
+	}
+
+	if err := c.applyDeployment(ctx, k8sDeployment); err != nil {
+		return err
+	}
+
+	if service == nil {
+		if err := c.clientset.CoreV1().Services(namespace).Delete(ctx, k8sDeployment.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete service: %v", err)
+		}
+		return c.applyIngress(ctx, k8sDeployment.Name, nil)
+	}
+
+	if err := c.applyService(ctx, service); err != nil {
+		return err
+	}
+
+	if err := c.applyIngress(ctx, k8sDeployment.Name, manifests.Ingress); err != nil {
+		return err
+	}
+	return c.applyNetworkPolicy(ctx, k8sDeployment.Name, manifests.NetworkPolicy)
+}
+
+// Manifests are the Kubernetes resources a deployment is rolled out as
+type Manifests struct {
+	Deployment    *appsv1.Deployment          `json:"deployment"`
+	Secret        *corev1.Secret              `json:"secret"`                   // Env vars of the pods
+	Service       *corev1.Service             `json:"service"`                  // nil for workers
+	Ingress       *networkingv1.Ingress       `json:"ingress"`                  // nil for internal projects and workers
+	NetworkPolicy *networkingv1.NetworkPolicy `json:"network_policy,omitempty"` // Internal projects, when enabled
+}
+
+// BuildManifests renders the resources for deployment without touching the
+// cluster; the ingress carries the project's ingress settings as annotations.
+// Every object carries ResourceLabels and the project's custom annotations.
+// envVars go in a Secret whose hash annotates the pod template, so updating
+// the Deployment restarts pods when, and only when, the values change.
+// Workers get neither port nor probe, only the Deployment and its Secret.
+func BuildManifests(deployment *models.Deployment, hostname string, envVars map[string]string) *Manifests {
+	namespace := Namespace
+	// Use project-based name (Vercel-style: one deployment per project that updates),
+	// branch deployments bring their own name
+	deploymentName := deployment.K8sDeploymentName
+	if deploymentName == "" {
+		deploymentName = ProjectResourceName(deployment.ProjectID)
+	}
+	port := containerPort(envVars)
+	secret := BuildSecret(deployment, deploymentName, envVars)
 
 	// Create Deployment
 	k8sDeployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      deploymentName,
-			Namespace: namespace,
+			Name:        deploymentName,
+			Namespace:   namespace,
+			Labels:      ResourceLabels(deployment, deploymentName),
+			Annotations: resourceAnnotations(&deployment.Project, nil),
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: int32Ptr(1),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
-					"app": deploymentName,
+					LabelApp: deploymentName,
 				},
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": deploymentName,
-					},
+					Labels:      ResourceLabels(deployment, deploymentName),
+					Annotations: resourceAnnotations(&deployment.Project, map[string]string{AnnotationEnvHash: EnvHash(envVars)}),
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets: imagePullSecrets(&deployment.Project),
 					Containers: []corev1.Container{
 						{
 							Name:  "app",
 							Image: deployment.ImageTag,
 							Ports: []corev1.ContainerPort{
 								{
-									ContainerPort: 8080,
+									ContainerPort: port,
+								},
+							},
+							EnvFrom: []corev1.EnvFromSource{
+								{
+									SecretRef: &corev1.SecretEnvSource{
+										LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+									},
+								},
+							},
+							// Traffic only reaches pods accepting connections on the app's port
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(int(port))},
 								},
+								InitialDelaySeconds: 2,
+								PeriodSeconds:       5,
 							},
-							Env: convertEnvVars(envVars),
 							Resources: corev1.ResourceRequirements{
 								Limits: corev1.ResourceList{
 									corev1.ResourceCPU:    resource.MustParse("500m"),
@@ -71,67 +147,91 @@ func (c *Client) CreateDeployment(ctx context.Context, deployment *models.Deploy
 		},
 	}
 
-	_, err := c.clientset.AppsV1().Deployments(namespace).Create(ctx, k8sDeployment, metav1.CreateOptions{})
-	if err != nil {
-		return err
+	if deployment.Project.Worker() {
+		// Without a readiness probe pods are ready once started; staying up
+		// workerMinReadySeconds makes them available, so crash loops fail the rollout
+		container := &k8sDeployment.Spec.Template.Spec.Containers[0]
+		container.Ports = nil
+		container.ReadinessProbe = nil
+		k8sDeployment.Spec.MinReadySeconds = workerMinReadySeconds
+		return &Manifests{
+			Deployment:    k8sDeployment,
+			Secret:        secret,
+			NetworkPolicy: BuildNetworkPolicy(deploymentName, &deployment.Project, ResourceLabels(deployment, deploymentName)),
+		}
 	}
 
 	// Create Service
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      deploymentName,
-			Namespace: namespace,
+			Name:        deploymentName,
+			Namespace:   namespace,
+			Labels:      ResourceLabels(deployment, deploymentName),
+			Annotations: resourceAnnotations(&deployment.Project, nil),
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: map[string]string{
-				"app": deploymentName,
+				LabelApp: deploymentName,
 			},
 			Ports: []corev1.ServicePort{
 				{
 					Port:       80,
-					TargetPort: intstr.FromInt(8080),
+					TargetPort: intstr.FromInt(int(port)),
 				},
 			},
 		},
 	}
 
-	// Try to create service, if exists, update it
-	_, err = c.clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
-	if err != nil {
-		if errors.IsAlreadyExists(err) {
-			_, updateErr := c.clientset.CoreV1().Services(namespace).Update(ctx, service, metav1.UpdateOptions{})
-			if updateErr != nil {
-				return fmt.Errorf("failed to update service: %v", updateErr)
-			}
-		} else {
-			return fmt.Errorf("failed to create service: %v", err)
-		}
+	manifests := &Manifests{
+		Deployment:    k8sDeployment,
+		Secret:        secret,
+		Service:       service,
+		NetworkPolicy: BuildNetworkPolicy(deploymentName, &deployment.Project, ResourceLabels(deployment, deploymentName)),
+	}
+	if !deployment.Project.Internal() {
+		manifests.Ingress = BuildIngress(deployment, deploymentName, hostname)
 	}
+	return manifests
+}
 
-	// Create Ingress
-	ingress := &networkingv1.Ingress{
+// BuildIngress renders the Ingress routing hostname to the Service named
+// name, with the project's ingress settings as annotations. The Ingress of
+// the project's production resources routes its custom domains too.
+func BuildIngress(deployment *models.Deployment, name, hostname string) *networkingv1.Ingress {
+	rules := []networkingv1.IngressRule{ingressRule(hostname, name)}
+	if name == ProjectResourceName(deployment.ProjectID) {
+		for _, domain := range deployment.Project.CustomDomains {
+			rules = append(rules, ingressRule(domain, name))
+		}
+	}
+	return &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      deploymentName,
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   Namespace,
+			Labels:      ResourceLabels(deployment, name),
+			Annotations: resourceAnnotations(&deployment.Project, IngressAnnotations(deployment.Project.Ingress)),
 		},
 		Spec: networkingv1.IngressSpec{
-			Rules: []networkingv1.IngressRule{
-				{
-					Host: hostname,
-					IngressRuleValue: networkingv1.IngressRuleValue{
-						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									Path:     "/",
-									PathType: func() *networkingv1.PathType { p := networkingv1.PathTypePrefix; return &p }(),
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: deploymentName,
-											Port: networkingv1.ServiceBackendPort{
-												Number: 80,
-											},
-										},
-									},
+			Rules: rules,
+		},
+	}
+}
+
+// ingressRule routes every path of host to the Service named name
+func ingressRule(host, name string) networkingv1.IngressRule {
+	return networkingv1.IngressRule{
+		Host: host,
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{
+					{
+						Path:     "/",
+						PathType: func() *networkingv1.PathType { p := networkingv1.PathTypePrefix; return &p }(),
+						Backend: networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: name,
+								Port: networkingv1.ServiceBackendPort{
+									Number: 80,
 								},
 							},
 						},
@@ -140,31 +240,54 @@ func (c *Client) CreateDeployment(ctx context.Context, deployment *models.Deploy
 			},
 		},
 	}
+}
 
-	// Try to create ingress, if exists, update it
-	_, err = c.clientset.NetworkingV1().Ingresses(namespace).Create(ctx, ingress, metav1.CreateOptions{})
-	if err != nil {
-		if errors.IsAlreadyExists(err) {
-			_, updateErr := c.clientset.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{})
-			if updateErr != nil {
-				return fmt.Errorf("failed to update ingress: %v", updateErr)
-			}
-		} else {
-			return fmt.Errorf("failed to create ingress: %v", err)
-		}
+// workerMinReadySeconds is how long a worker's container must run before its
+// pod counts as available
+const workerMinReadySeconds = 10
+
+// ProjectResourceName is the name of a project's production Deployment, Service and Ingress
+func ProjectResourceName(projectID uint) string {
+	return fmt.Sprintf("project-%d", projectID)
+}
+
+// Redacted returns the manifests with the Secret's values blanked, to be shown
+func (m *Manifests) Redacted() *Manifests {
+	redacted := *m
+	secret := *m.Secret
+	secret.Data = make(map[string][]byte, len(m.Secret.Data))
+	for k := range m.Secret.Data {
+		secret.Data[k] = nil
+	}
+	redacted.Secret = &secret
+	return &redacted
+}
+
+// DeleteDeployment removes the Ingress, NetworkPolicy, Service, Deployment
+// and env Secret named after name, ignoring the ones that are already gone
+func (c *Client) DeleteDeployment(ctx context.Context, name string) error {
+	namespace := Namespace
+	if err := c.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ingress: %v", err)
+	}
+	if err := c.applyNetworkPolicy(ctx, name, nil); err != nil {
+		return err
+	}
+	if err := c.clientset.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete service: %v", err)
+	}
+	if err := c.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete deployment: %v", err)
 	}
-	return nil
+	return c.deleteSecret(ctx, name)
 }
 
-func convertEnvVars(envVars map[string]string) []corev1.EnvVar {
-	var env []corev1.EnvVar
-	for k, v := range envVars {
-		env = append(env, corev1.EnvVar{
-			Name:  k,
-			Value: v,
-		})
+// containerPort is the port the app listens on, taken from its PORT env var
+func containerPort(envVars map[string]string) int32 {
+	if port, err := strconv.Atoi(envVars["PORT"]); err == nil && port > 0 && port < 65536 {
+		return int32(port)
 	}
-	return env
+	return 8080
 }
 
 func int32Ptr(i int32) *int32 { return &i }
@@ -0,0 +1,30 @@
+package kubernetes
+
+import (
+	"deploy-platform/internal/models"
+	"reflect"
+	"testing"
+)
+
+// The production Ingress routes the project's custom domains too, the
+// Ingress of a branch doesn't
+func TestBuildIngressCustomDomains(t *testing.T) {
+	deployment := &models.Deployment{ProjectID: 7, Project: models.Project{ID: 7, CustomDomains: []string{"example.org", "www.example.org"}}}
+
+	ingress := BuildIngress(deployment, ProjectResourceName(7), "app.deploy.example.com")
+	var hosts []string
+	for _, rule := range ingress.Spec.Rules {
+		hosts = append(hosts, rule.Host)
+		if backend := rule.HTTP.Paths[0].Backend.Service; backend.Name != "project-7" || backend.Port.Number != 80 {
+			t.Errorf("%s routed to %+v", rule.Host, backend)
+		}
+	}
+	if want := []string{"app.deploy.example.com", "example.org", "www.example.org"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("production hosts %v, want %v", hosts, want)
+	}
+
+	ingress = BuildIngress(deployment, "project-7-feature", "app--feature.deploy.example.com")
+	if len(ingress.Spec.Rules) != 1 || ingress.Spec.Rules[0].Host != "app--feature.deploy.example.com" {
+		t.Errorf("branch rules %+v", ingress.Spec.Rules)
+	}
+}
@@ -0,0 +1,116 @@
+package kubernetes
+
+import (
+	"deploy-platform/internal/models"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnosis explains why an app's pods never became ready
+type Diagnosis struct {
+	Category string // models.Failure* category
+	Port     int    // Port the app listens on, or failed to bind, when known
+	Detail   string // What went wrong and how to fix it, for the user
+}
+
+// listenPatterns find the address an app says it listens on in its logs.
+// Each captures an optional host and the port; together they cover the
+// startup lines of the common Node, Python, Go, Ruby and JVM servers.
+var listenPatterns = []*regexp.Regexp{
+	// Flask/Werkzeug "Running on http://127.0.0.1:5000", uvicorn "Uvicorn running on
+	// http://0.0.0.0:8000", gunicorn "Listening at: http://127.0.0.1:8000",
+	// Django "Starting development server at http://127.0.0.1:8000/", Vite
+	// "Local:   http://localhost:5173/", Express "Server running at http://localhost:3000"
+	regexp.MustCompile(`(?i)\b(?:listen(?:ing)?|running|started|serving|server|available|ready|bound|local)\b[^\n]*?\bhttps?://(\[[0-9a-f:.]*\]|[\w.\-]+):(\d{2,5})\b`),
+	// Gin "Listening and serving HTTP on :8080", Echo "http server started on [::]:1323",
+	// Next.js "started server on 0.0.0.0:3000", Puma "Listening on tcp://0.0.0.0:9292"
+	regexp.MustCompile(`(?i)\b(?:listen(?:ing)?|serving|started|bound|running)\b[^\n]*?\b(?:on|at)\s+(?:tcp://|address\s+)?(\[[0-9a-f:.]*\]|(?:\d{1,3}\.){3}\d{1,3}|localhost|::)?:(\d{2,5})\b`),
+	// Express "Example app listening on port 5000", Spring "Tomcat started on port 8081 (http)",
+	// "Server started on port 3000", "listening at port 4000"
+	regexp.MustCompile(`(?i)\b(?:listen(?:ing)?|running|started|serving|server|app|bound)\b[^\n]*?\bports?\s*[:=(]?\s*()(\d{2,5})\b`),
+}
+
+// addressInUsePatterns find a failed bind: Node "listen EADDRINUSE: address
+// already in use :::3000", Go "listen tcp :8080: bind: address already in
+// use", Python "[Errno 98] Address already in use" (no port). The port is
+// captured when the line has one.
+var addressInUsePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)EADDRINUSE\b[^\n]*?:(\d{2,5})\b`),
+	regexp.MustCompile(`(?i)\blisten tcp[^\n]*?:(\d{2,5}): bind: address already in use`),
+	regexp.MustCompile(`(?i)\baddress already in use()`),
+}
+
+// DiagnoseLogs looks for the reason an app isn't reachable on port in the
+// recent lines of its logs: a failed bind, a different port, or an address
+// only reachable from inside the container. Returns nil if nothing matches.
+func DiagnoseLogs(logs string, port int) *Diagnosis {
+	for _, pattern := range addressInUsePatterns {
+		if m := lastMatch(pattern, logs); m != nil {
+			inUse, _ := strconv.Atoi(m[1])
+			where := "its port"
+			if inUse > 0 {
+				where = fmt.Sprintf("port %d", inUse)
+			}
+			return &Diagnosis{
+				Category: models.FailureAddressInUse,
+				Port:     inUse,
+				Detail: fmt.Sprintf("your app failed to start because %s is already in use inside its container — "+
+					"make sure only one process listens on it (e.g. the start command doesn't launch the server twice)", where),
+			}
+		}
+	}
+
+	// The last address announced wins: apps restart, and some log their
+	// port twice (e.g. "Local: http://localhost:3000" after "started server on 0.0.0.0:3000")
+	host, listening, end := "", 0, -1
+	for _, pattern := range listenPatterns {
+		for _, loc := range pattern.FindAllStringSubmatchIndex(logs, -1) {
+			p, err := strconv.Atoi(logs[loc[4]:loc[5]])
+			if err != nil || p < 1 || p > 65535 || loc[1] < end {
+				continue
+			}
+			end, listening, host = loc[1], p, ""
+			if loc[2] >= 0 {
+				host = logs[loc[2]:loc[3]]
+			}
+		}
+	}
+	switch {
+	case listening == 0:
+		return nil
+	case listening != port:
+		return &Diagnosis{
+			Category: models.FailurePortMismatch,
+			Port:     listening,
+			Detail: fmt.Sprintf("your app appears to listen on port %d but the project is configured for %d — "+
+				"make the app listen on the PORT env var or change the project port setting", listening, port),
+		}
+	case isLoopback(host):
+		return &Diagnosis{
+			Category: models.FailureLoopbackOnly,
+			Port:     listening,
+			Detail: fmt.Sprintf("your app listens on %s:%d, which is only reachable from inside its container — "+
+				"bind it to 0.0.0.0 instead (e.g. --host 0.0.0.0)", host, listening),
+		}
+	}
+	return nil
+}
+
+// lastMatch returns the submatches of the last match of pattern in s
+func lastMatch(pattern *regexp.Regexp, s string) []string {
+	matches := pattern.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	return matches[len(matches)-1]
+}
+
+// isLoopback reports whether a logged host only accepts connections from the
+// container itself. "localhost" doesn't count: many servers bound to every
+// interface print it in the URL they suggest opening.
+func isLoopback(host string) bool {
+	host = strings.Trim(host, "[]")
+	return strings.HasPrefix(host, "127.") || host == "::1"
+}
@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"deploy-platform/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The logs in testdata/logs are captured from Node, Python and Go apps
+// starting up in their containers
+func TestDiagnoseLogs(t *testing.T) {
+	tests := []struct {
+		log      string
+		port     int
+		category string // "" when nothing is diagnosed
+		listens  int
+	}{
+		{"node-express-port.log", 8080, models.FailurePortMismatch, 5000},
+		{"node-next.log", 8080, models.FailurePortMismatch, 3000},
+		{"node-next.log", 3000, "", 0}, // localhost in a suggested URL isn't loopback only
+		{"node-eaddrinuse.log", 8080, models.FailureAddressInUse, 8080},
+		{"python-flask-loopback.log", 8080, models.FailureLoopbackOnly, 8080},
+		{"python-uvicorn.log", 8080, "", 0},
+		{"python-gunicorn-port.log", 8080, models.FailurePortMismatch, 8000},
+		{"python-address-in-use.log", 8080, models.FailureAddressInUse, 0},
+		{"go-gin-port.log", 8080, models.FailurePortMismatch, 3000},
+		{"go-bind-in-use.log", 8080, models.FailureAddressInUse, 8080},
+		{"go-no-address.log", 8080, "", 0},
+	}
+	for _, tt := range tests {
+		logs, err := os.ReadFile(filepath.Join("testdata", "logs", tt.log))
+		if err != nil {
+			t.Fatal(err)
+		}
+		diagnosis := DiagnoseLogs(string(logs), tt.port)
+		if tt.category == "" {
+			if diagnosis != nil {
+				t.Errorf("%s on port %d: diagnosed %+v", tt.log, tt.port, diagnosis)
+			}
+			continue
+		}
+		if diagnosis == nil || diagnosis.Category != tt.category || diagnosis.Port != tt.listens {
+			t.Errorf("%s on port %d: diagnosed %+v, want %s on port %d", tt.log, tt.port, diagnosis, tt.category, tt.listens)
+		}
+	}
+}
+
+func TestDiagnoseLogsDetail(t *testing.T) {
+	diagnosis := DiagnoseLogs("Example app listening on port 5000\n", 8080)
+	want := "your app appears to listen on port 5000 but the project is configured for 8080"
+	if diagnosis == nil || !strings.HasPrefix(diagnosis.Detail, want) || !strings.Contains(diagnosis.Detail, "PORT env var") {
+		t.Errorf("diagnosed %+v", diagnosis)
+	}
+
+	// An app that restarted on another port is diagnosed from its last start
+	logs := "Server started on port 5000\nshutting down\nServer started on port 8080\n"
+	if diagnosis := DiagnoseLogs(logs, 8080); diagnosis != nil {
+		t.Errorf("restarted app: diagnosed %+v", diagnosis)
+	}
+
+	diagnosis = DiagnoseLogs("Error: listen EADDRINUSE: address already in use :::3000\n", 3000)
+	if diagnosis == nil || !strings.Contains(diagnosis.Detail, "port 3000 is already in use") {
+		t.Errorf("address in use: diagnosed %+v", diagnosis)
+	}
+	diagnosis = DiagnoseLogs(" * Running on http://[::1]:8080\n", 8080)
+	if diagnosis == nil || !strings.Contains(diagnosis.Detail, "listens on [::1]:8080") {
+		t.Errorf("IPv6 loopback: diagnosed %+v", diagnosis)
+	}
+}
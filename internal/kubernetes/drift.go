@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlatformObject is a Deployment, Service or Ingress the platform created
+// for a project
+type PlatformObject struct {
+	Kind         string    `json:"kind"`
+	Name         string    `json:"name"`
+	App          string    `json:"app"`                     // Name of the resources it belongs to (LabelApp), for DeleteDeployment
+	ProjectID    uint      `json:"project_id"`              // From LabelProjectID
+	DeploymentID uint      `json:"deployment_id,omitempty"` // Deployment that last rolled it out, 0 = not labeled
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// platformObjectSelector lists the objects the platform created for any
+// project, leaving out shared ones like the placeholder Service
+var platformObjectSelector = metav1.ListOptions{LabelSelector: LabelManagedBy + "=" + ManagedBy + "," + LabelProjectID}
+
+// ListPlatformObjects lists the Deployments, Services and Ingresses the
+// platform created for projects in the cluster
+func (c *Client) ListPlatformObjects(ctx context.Context) ([]PlatformObject, error) {
+	var objects []PlatformObject
+	add := func(kind string, meta metav1.ObjectMeta) {
+		object := PlatformObject{Kind: kind, Name: meta.Name, App: meta.Labels[LabelApp], CreatedAt: meta.CreationTimestamp.Time}
+		if object.App == "" {
+			object.App = meta.Name
+		}
+		if id, err := strconv.ParseUint(meta.Labels[LabelProjectID], 10, 64); err == nil {
+			object.ProjectID = uint(id)
+		}
+		if id, err := strconv.ParseUint(meta.Labels[LabelDeploymentID], 10, 64); err == nil {
+			object.DeploymentID = uint(id)
+		}
+		objects = append(objects, object)
+	}
+
+	deployments, err := c.clientset.AppsV1().Deployments(Namespace).List(ctx, platformObjectSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %v", err)
+	}
+	for _, d := range deployments.Items {
+		add(KindDeployment, d.ObjectMeta)
+	}
+	services, err := c.clientset.CoreV1().Services(Namespace).List(ctx, platformObjectSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, s := range services.Items {
+		add(KindService, s.ObjectMeta)
+	}
+	ingresses, err := c.clientset.NetworkingV1().Ingresses(Namespace).List(ctx, platformObjectSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses: %v", err)
+	}
+	for _, i := range ingresses.Items {
+		add(KindIngress, i.ObjectMeta)
+	}
+	return objects, nil
+}
+
+// MissingObjects names which of the objects a deployment of project rolls
+// out to the resources named name are gone from the cluster: its
+// Deployment, and its Service and Ingress unless the project has none
+func (c *Client) MissingObjects(ctx context.Context, project *models.Project, name string) ([]string, error) {
+	var missing []string
+	check := func(kind string, err error) error {
+		if errors.IsNotFound(err) {
+			missing = append(missing, kind)
+		} else if err != nil {
+			return fmt.Errorf("failed to get %s %s: %v", strings.ToLower(kind), name, err)
+		}
+		return nil
+	}
+	_, err := c.clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err := check(KindDeployment, err); err != nil {
+		return nil, err
+	}
+	if !project.Worker() {
+		_, err := c.clientset.CoreV1().Services(Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err := check(KindService, err); err != nil {
+			return nil, err
+		}
+	}
+	if project.Served() {
+		_, err := c.clientset.NetworkingV1().Ingresses(Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err := check(KindIngress, err); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var deploymentsResource = schema.GroupResource{Group: "apps", Resource: "deployments"}
+
+// refused is the error of dialing an API server that isn't listening
+func refused() error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+}
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// The cluster API being unavailable is transient, its refusals aren't
+func TestIsTransient(t *testing.T) {
+	transient := map[string]error{
+		"server timeout":      errors.NewServerTimeout(deploymentsResource, "create", 2),
+		"timeout":             errors.NewTimeoutError("request did not complete", 2),
+		"too many requests":   errors.NewTooManyRequests("slow down", 1),
+		"internal error":      errors.NewInternalError(fmt.Errorf("etcdserver: leader changed")),
+		"service unavailable": errors.NewServiceUnavailable("apiserver is shutting down"),
+		"connection refused":  refused(),
+		"connection reset":    &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+		"unexpected EOF":      fmt.Errorf("watch: %w", io.ErrUnexpectedEOF),
+		"deadline exceeded":   fmt.Errorf("get deployment: %w", context.DeadlineExceeded),
+		"network timeout":     &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}},
+		"temporary DNS":       &net.DNSError{Err: "server misbehaving", Name: "kubernetes.default", IsTemporary: true},
+	}
+	for name, err := range transient {
+		if !IsTransient(context.Background(), err) {
+			t.Errorf("%s (%v) is not transient", name, err)
+		}
+	}
+
+	permanent := map[string]error{
+		"none":          nil,
+		"forbidden":     errors.NewForbidden(deploymentsResource, "project-7", fmt.Errorf("exceeded quota")),
+		"bad request":   errors.NewBadRequest("invalid image reference"),
+		"not found":     errors.NewNotFound(deploymentsResource, "project-7"),
+		"conflict":      errors.NewConflict(deploymentsResource, "project-7", errModified),
+		"unknown host":  &net.DNSError{Err: "no such host", Name: "cluster.example.com", IsNotFound: true},
+		"admission":     fmt.Errorf("admission webhook denied the request"),
+		"unauthorized":  errors.NewUnauthorized("token expired"),
+		"unconnectable": fmt.Errorf("cluster %s is not available", "eu-west"),
+	}
+	for name, err := range permanent {
+		if IsTransient(context.Background(), err) {
+			t.Errorf("%s (%v) is transient", name, err)
+		}
+	}
+
+	// Cancelled deploys aren't retried, whatever failed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if IsTransient(ctx, errors.NewServiceUnavailable("apiserver is shutting down")) {
+		t.Error("transient after the deploy was cancelled")
+	}
+}
+
+// The client wraps the errors of the API, so the failures of a rollout can
+// be classified
+func TestCreateDeploymentTransientErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		err       error
+		transient bool
+	}{
+		"service unavailable": {errors.NewServiceUnavailable("apiserver is shutting down"), true},
+		"server timeout":      {errors.NewServerTimeout(deploymentsResource, "create", 2), true},
+		"connection refused":  {refused(), true},
+		"forbidden":           {errors.NewForbidden(deploymentsResource, ProjectResourceName(7), fmt.Errorf("exceeded quota")), false},
+		"bad request":         {errors.NewBadRequest("invalid image reference"), false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, clientset := fakeClient()
+			clientset.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tt.err
+			})
+			err := client.CreateDeployment(context.Background(), webDeployment(7), "app.example.com", nil)
+			if err == nil {
+				t.Fatal("created")
+			}
+			if got := IsTransient(context.Background(), err); got != tt.transient {
+				t.Errorf("%v: transient %v, want %v", err, got, tt.transient)
+			}
+		})
+	}
+}
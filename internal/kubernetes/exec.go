@@ -0,0 +1,282 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/rand"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ComponentExec tells the Jobs running one-off commands in a project's image
+// from its other resources
+const ComponentExec = "exec"
+
+const (
+	execPollInterval = time.Second
+	execStartTimeout = 5 * time.Minute // Pods not running after this long (unschedulable...) fail the command
+	execJobTTL       = time.Hour       // Finished exec Jobs are deleted by Kubernetes after this long
+)
+
+var (
+	execTimeout       = 15 * time.Minute
+	execMaxPerProject = 2
+	execResources     = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("500m"),
+		corev1.ResourceMemory: resource.MustParse("512Mi"),
+	}
+
+	// execStarts makes counting a project's running commands and starting
+	// one atomic, within this process
+	execStarts sync.Mutex
+)
+
+// Waiting reasons of a container that won't start without a change
+var execStartFailures = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// InitExec sets how long one-off commands may run, how many may run at once
+// in a project, and the CPU and memory their pods get
+func InitExec(cfg *config.Config) error {
+	cpu, err := resource.ParseQuantity(cfg.ExecCPULimit)
+	if err != nil {
+		return fmt.Errorf("invalid EXEC_CPU_LIMIT %q: %v", cfg.ExecCPULimit, err)
+	}
+	memory, err := resource.ParseQuantity(cfg.ExecMemoryLimit)
+	if err != nil {
+		return fmt.Errorf("invalid EXEC_MEMORY_LIMIT %q: %v", cfg.ExecMemoryLimit, err)
+	}
+	execTimeout = cfg.ExecTimeout
+	execMaxPerProject = cfg.ExecMaxPerProject
+	execResources = corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory}
+	return nil
+}
+
+// ExecTimeout is how long a one-off command may run before it is killed
+func ExecTimeout() time.Duration {
+	return execTimeout
+}
+
+// ExecLimitError refuses a command while the project already runs as many
+// as it may at once
+type ExecLimitError struct {
+	Limit int
+}
+
+func (e *ExecLimitError) Error() string {
+	return fmt.Sprintf("%d commands are already running in this project, the most allowed at once", e.Limit)
+}
+
+// ExecResult is how a one-off command ended
+type ExecResult struct {
+	ExitCode int    `json:"exit_code"`        // -1 when it was killed
+	Reason   string `json:"reason,omitempty"` // Why it was, e.g. "timed out after 15m0s"
+}
+
+// BuildExecJob renders the Job named name running command once in the image
+// of deployment, the project's live production deployment, with the env
+// Secret and the labels of its pods: the same env, and the same network
+// identity. The pod gets execResources, and is killed after execTimeout.
+func BuildExecJob(deployment *models.Deployment, name, command string) *batchv1.Job {
+	labels := ResourceLabels(deployment, name)
+	labels[LabelEnvironment] = environment(deployment, deployment.K8sDeploymentName)
+	labels[LabelComponent] = ComponentExec
+	deadline := int64(execTimeout.Seconds())
+	ttl := int32(execJobTTL.Seconds())
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   Namespace,
+			Labels:      labels,
+			Annotations: resourceAnnotations(&deployment.Project, nil),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            int32Ptr(0), // A failed command is not retried
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: imagePullSecrets(&deployment.Project),
+					Containers: []corev1.Container{
+						{
+							Name:    ComponentExec,
+							Image:   deployment.ImageTag,
+							Command: []string{"/bin/sh", "-c", command},
+							EnvFrom: []corev1.EnvFromSource{
+								{
+									SecretRef: &corev1.SecretEnvSource{
+										LocalObjectReference: corev1.LocalObjectReference{Name: SecretName(deployment.K8sDeploymentName)},
+									},
+								},
+							},
+							Resources: corev1.ResourceRequirements{
+								Limits:   execResources,
+								Requests: execResources,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// StartExec starts command in deployment's image (see BuildExecJob) and
+// returns the name of its Job, for FollowExec. A project already running
+// execMaxPerProject commands gets an *ExecLimitError.
+func (c *Client) StartExec(ctx context.Context, deployment *models.Deployment, command string) (string, error) {
+	execStarts.Lock()
+	defer execStarts.Unlock()
+
+	selector := projectSelector(deployment.ProjectID)
+	selector.LabelSelector += "," + LabelComponent + "=" + ComponentExec
+	jobs, err := c.clientset.BatchV1().Jobs(Namespace).List(ctx, selector)
+	if err != nil {
+		return "", fmt.Errorf("failed to list exec jobs: %v", err)
+	}
+	running := 0
+	for _, job := range jobs.Items {
+		if job.Status.Succeeded == 0 && job.Status.Failed == 0 && job.DeletionTimestamp == nil {
+			running++
+		}
+	}
+	if running >= execMaxPerProject {
+		return "", &ExecLimitError{Limit: execMaxPerProject}
+	}
+
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	name := fmt.Sprintf("exec-%d-%s", deployment.ProjectID, hex.EncodeToString(suffix))
+	if _, err := c.clientset.BatchV1().Jobs(Namespace).Create(ctx, BuildExecJob(deployment, name, command), metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create exec job: %v", err)
+	}
+	return name, nil
+}
+
+// FollowExec copies what the command of the exec Job named name prints,
+// stdout and stderr combined, to output as it runs, and returns how it
+// ended. Errors are the command not running at all. When ctx is done first
+// (the caller went away) the command is killed.
+func (c *Client) FollowExec(ctx context.Context, name string, output io.Writer) (*ExecResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, execTimeout+time.Minute) // Backstop: the Job's deadline kills the command first
+	defer cancel()
+	done := false
+	defer func() {
+		if !done {
+			c.deleteJob(context.Background(), name)
+		}
+	}()
+
+	pod, err := c.waitForExecPod(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.clientset.CoreV1().Pods(Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: ComponentExec,
+		Follow:    true,
+	}).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream the output of %s: %v", name, err)
+	}
+	_, copyErr := io.Copy(output, stream)
+	stream.Close()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("failed to stream the output of %s: %v", name, copyErr)
+	}
+
+	result, err := c.waitForExecJob(ctx, name)
+	done = err == nil
+	return result, err
+}
+
+// waitForExecPod waits for the container of the exec Job named name to run
+// (or to have run already), failing when it can't start
+func (c *Client) waitForExecPod(ctx context.Context, name string) (*corev1.Pod, error) {
+	deadline := time.Now().Add(execStartTimeout)
+	ticker := time.NewTicker(execPollInterval)
+	defer ticker.Stop()
+	for {
+		if pod := c.jobPod(ctx, name); pod != nil {
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name != ComponentExec {
+					continue
+				}
+				if status.State.Running != nil || status.State.Terminated != nil {
+					return pod, nil
+				}
+				if waiting := status.State.Waiting; waiting != nil && execStartFailures[waiting.Reason] {
+					return nil, fmt.Errorf("the command could not start: %s: %s", waiting.Reason, waiting.Message)
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the command did not start within %s", execStartTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForExecJob waits for the exec Job named name, whose container exited,
+// to finish, and returns how its command ended
+func (c *Client) waitForExecJob(ctx context.Context, name string) (*ExecResult, error) {
+	ticker := time.NewTicker(execPollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := c.clientset.BatchV1().Jobs(Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get exec job status: %v", err)
+		}
+		switch {
+		case job.Status.Succeeded > 0:
+			return &ExecResult{ExitCode: 0}, nil
+		case job.Status.Failed > 0:
+			for _, condition := range job.Status.Conditions {
+				if condition.Type == batchv1.JobFailed && condition.Reason == "DeadlineExceeded" {
+					return &ExecResult{ExitCode: -1, Reason: fmt.Sprintf("timed out after %s", execTimeout)}, nil
+				}
+			}
+			if pod := c.jobPod(ctx, name); pod != nil {
+				for _, status := range pod.Status.ContainerStatuses {
+					if terminated := status.State.Terminated; status.Name == ComponentExec && terminated != nil {
+						result := &ExecResult{ExitCode: int(terminated.ExitCode)}
+						if memory := execResources[corev1.ResourceMemory]; terminated.Reason == "OOMKilled" {
+							result.Reason = fmt.Sprintf("killed for using more than %s of memory", memory.String())
+						}
+						return result, nil
+					}
+				}
+			}
+			return &ExecResult{ExitCode: -1, Reason: jobFailure(job, nil, ComponentExec)}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
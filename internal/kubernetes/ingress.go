@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"fmt"
+	"strconv"
+)
+
+// nginx-ingress annotations rendered from models.IngressSettings
+const (
+	annotationPrefix           = "nginx.ingress.kubernetes.io/"
+	annotationProxyReadTimeout = annotationPrefix + "proxy-read-timeout"
+	annotationProxySendTimeout = annotationPrefix + "proxy-send-timeout"
+	annotationProxyHTTPVersion = annotationPrefix + "proxy-http-version"
+	annotationAffinity         = annotationPrefix + "affinity"
+	annotationAffinityMode     = annotationPrefix + "affinity-mode"
+	annotationSessionCookie    = annotationPrefix + "session-cookie-name"
+	annotationProxyBodySize    = annotationPrefix + "proxy-body-size"
+)
+
+// affinityCookie names the cookie nginx-ingress pins sessions with
+const affinityCookie = "deploy_affinity"
+
+// webSocketTimeout is used for WebSocket projects that set no timeout of
+// their own (nginx closes idle upgraded connections after 60s by default)
+const webSocketTimeout = 3600
+
+var (
+	maxIngressTimeout    = 3600
+	maxIngressBodySizeMB = 100
+)
+
+// InitIngress sets the bounds of per-project ingress settings
+func InitIngress(cfg *config.Config) {
+	if cfg.IngressMaxTimeoutSeconds > 0 {
+		maxIngressTimeout = cfg.IngressMaxTimeoutSeconds
+	}
+	if cfg.IngressMaxBodySizeMB > 0 {
+		maxIngressBodySizeMB = cfg.IngressMaxBodySizeMB
+	}
+}
+
+// ValidateIngressSettings checks settings against the platform bounds
+func ValidateIngressSettings(s models.IngressSettings) error {
+	for name, timeout := range map[string]*int{"proxy_read_timeout": s.ProxyReadTimeout, "proxy_send_timeout": s.ProxySendTimeout} {
+		if timeout != nil && (*timeout < 1 || *timeout > maxIngressTimeout) {
+			return fmt.Errorf("%s must be between 1 and %d seconds", name, maxIngressTimeout)
+		}
+	}
+	if s.MaxBodySizeMB != nil && (*s.MaxBodySizeMB < 1 || *s.MaxBodySizeMB > maxIngressBodySizeMB) {
+		return fmt.Errorf("max_body_size_mb must be between 1 and %d", maxIngressBodySizeMB)
+	}
+	return nil
+}
+
+// IngressAnnotations renders settings as nginx-ingress annotations. Unset
+// settings produce no annotation, and the platform owns the annotations it
+// rendered last time, so removing a setting removes its annotation.
+func IngressAnnotations(s models.IngressSettings) map[string]string {
+	annotations := map[string]string{}
+
+	readTimeout, sendTimeout := s.ProxyReadTimeout, s.ProxySendTimeout
+	if s.WebSockets != nil && *s.WebSockets {
+		annotations[annotationProxyHTTPVersion] = "1.1" // Required for the Upgrade handshake
+		longest := min(webSocketTimeout, maxIngressTimeout)
+		if readTimeout == nil {
+			readTimeout = &longest
+		}
+		if sendTimeout == nil {
+			sendTimeout = &longest
+		}
+	}
+	if readTimeout != nil {
+		annotations[annotationProxyReadTimeout] = strconv.Itoa(*readTimeout)
+	}
+	if sendTimeout != nil {
+		annotations[annotationProxySendTimeout] = strconv.Itoa(*sendTimeout)
+	}
+
+	if s.SessionAffinity != nil && *s.SessionAffinity {
+		annotations[annotationAffinity] = "cookie"
+		annotations[annotationAffinityMode] = "persistent"
+		annotations[annotationSessionCookie] = affinityCookie
+	}
+	if s.MaxBodySizeMB != nil {
+		annotations[annotationProxyBodySize] = fmt.Sprintf("%dm", *s.MaxBodySizeMB)
+	}
+
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
@@ -0,0 +1,242 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Labels the platform puts on the objects it creates. LabelApp selects a
+// deployment's pods; selectors are immutable, so it is the only one they use.
+const (
+	LabelApp          = "app"
+	LabelManagedBy    = "app.kubernetes.io/managed-by"
+	LabelProject      = "deploy-platform.io/project" // Project slug
+	LabelProjectID    = "deploy-platform.io/project-id"
+	LabelDeploymentID = "deploy-platform.io/deployment-id"
+	LabelOwner        = "deploy-platform.io/owner"       // ID of the user owning the project
+	LabelEnvironment  = "deploy-platform.io/environment" // Environment*
+	LabelCommit       = "deploy-platform.io/commit"      // Short commit SHA
+
+	ManagedBy = "deploy-platform" // Value of LabelManagedBy
+)
+
+// Environments of a deployment's resources
+const (
+	EnvironmentProduction = "production"
+	EnvironmentBranch     = "branch"
+	EnvironmentPreview    = "preview"
+)
+
+const (
+	maxCustomEntries    = 32        // Custom labels, and annotations, per project
+	maxAnnotationsBytes = 256 << 10 // Kubernetes' limit on an object's annotations
+)
+
+// reservedDomains can't be used in custom label and annotation keys: they
+// belong to Kubernetes, its add-ons (nginx.ingress.kubernetes.io) and the platform
+var reservedDomains = []string{"kubernetes.io", "k8s.io", "deploy-platform.io"}
+
+const recommendedLabelPrefix = "app.kubernetes.io"
+
+// labelDenylist holds further keys ("team") and prefixes ("example.com/")
+// projects may not set, from LABEL_DENYLIST
+var labelDenylist []string
+
+// InitLabels sets the platform denylist of custom label and annotation keys
+func InitLabels(cfg *config.Config) {
+	labelDenylist = cfg.LabelDenylist
+}
+
+var (
+	labelNamePattern = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	dnsPrefixPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// ResourceLabels returns the labels of the resources named name that roll
+// out deployment: the project's custom labels, then the platform's.
+func ResourceLabels(deployment *models.Deployment, name string) map[string]string {
+	labels := make(map[string]string, len(deployment.Project.Labels)+8)
+	for k, v := range deployment.Project.Labels {
+		labels[k] = v
+	}
+	labels[LabelApp] = name
+	labels[LabelManagedBy] = ManagedBy
+	labels[LabelProjectID] = strconv.FormatUint(uint64(deployment.ProjectID), 10)
+	labels[LabelOwner] = ownerLabelValue(&deployment.Project)
+	labels[LabelEnvironment] = environment(deployment, name)
+	if deployment.Project.Slug != "" {
+		labels[LabelProject] = deployment.Project.Slug
+	}
+	if deployment.ID != 0 {
+		labels[LabelDeploymentID] = strconv.FormatUint(uint64(deployment.ID), 10)
+	}
+	if sha := deployment.CommitSHA; sha != "" {
+		labels[LabelCommit] = textutil.ShortSHA(sha)
+	}
+	return labels
+}
+
+// EnvironmentOf is the environment of the resources deployment rolls out to
+func EnvironmentOf(deployment *models.Deployment) string {
+	return environment(deployment, deployment.K8sDeploymentName)
+}
+
+// environment tells production resources from those of branches and previews
+func environment(deployment *models.Deployment, name string) string {
+	switch {
+	case deployment.Target == models.TargetPreview:
+		return EnvironmentPreview
+	case name == ProjectResourceName(deployment.ProjectID):
+		return EnvironmentProduction
+	}
+	return EnvironmentBranch
+}
+
+// resourceAnnotations returns the project's custom annotations merged with
+// the object's own (e.g. ingress settings), which win
+func resourceAnnotations(project *models.Project, own map[string]string) map[string]string {
+	if len(project.Annotations) == 0 {
+		return own
+	}
+	annotations := make(map[string]string, len(project.Annotations)+len(own))
+	for k, v := range project.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range own {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// ValidateCustomMetadata checks a project's custom labels and annotations
+// against the Kubernetes syntax and the platform denylist
+func ValidateCustomMetadata(labels, annotations map[string]string) error {
+	if len(labels) > maxCustomEntries || len(annotations) > maxCustomEntries {
+		return fmt.Errorf("at most %d labels and %d annotations are allowed", maxCustomEntries, maxCustomEntries)
+	}
+	for key, value := range labels {
+		if err := validateKey(key); err != nil {
+			return fmt.Errorf("invalid label %q: %w", key, err)
+		}
+		if value != "" && (len(value) > 63 || !labelNamePattern.MatchString(value)) {
+			return fmt.Errorf("invalid value for label %q: at most 63 letters, digits, '-', '_' or '.', starting and ending with a letter or digit", key)
+		}
+	}
+	size := 0
+	for key, value := range annotations {
+		if err := validateKey(key); err != nil {
+			return fmt.Errorf("invalid annotation %q: %w", key, err)
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxAnnotationsBytes {
+		return fmt.Errorf("annotations must total at most %d bytes", maxAnnotationsBytes)
+	}
+	return nil
+}
+
+// validateKey checks a label or annotation key: an optional DNS subdomain
+// prefix and a name, e.g. "example.com/team", outside the reserved keys
+func validateKey(key string) error {
+	prefix, name, hasPrefix := strings.Cut(key, "/")
+	if !hasPrefix {
+		prefix, name = "", key
+	}
+	if len(name) > 63 || !labelNamePattern.MatchString(name) {
+		return fmt.Errorf("the name must be at most 63 letters, digits, '-', '_' or '.', starting and ending with a letter or digit")
+	}
+	if hasPrefix && (len(prefix) > 253 || !dnsPrefixPattern.MatchString(prefix)) {
+		return fmt.Errorf("the prefix must be a lowercase DNS subdomain")
+	}
+
+	if key == LabelApp || key == LabelManagedBy {
+		return fmt.Errorf("reserved by the platform")
+	}
+	// The recommended labels (app.kubernetes.io/name, /part-of...) are the user's
+	for _, domain := range reservedDomains {
+		if prefix != recommendedLabelPrefix && (prefix == domain || strings.HasSuffix(prefix, "."+domain)) {
+			return fmt.Errorf("the %s prefix is reserved", domain)
+		}
+	}
+	for _, denied := range labelDenylist {
+		if key == denied || (strings.HasSuffix(denied, "/") && strings.HasPrefix(key, denied)) {
+			return fmt.Errorf("not allowed on this platform")
+		}
+	}
+	return nil
+}
+
+// RelabelProject brings the custom labels and annotations of a project's
+// existing Deployments, Services and Ingresses from previous to the
+// project's current ones. The objects are patched in place, not recreated;
+// pod templates are left alone so no pod restarts, their pods get the new
+// labels on the next deploy.
+func (c *Client) RelabelProject(ctx context.Context, project *models.Project, previous *models.Project) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      metadataPatch(previous.Labels, project.Labels),
+			"annotations": metadataPatch(previous.Annotations, project.Annotations),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	selector := metav1.ListOptions{LabelSelector: LabelProjectID + "=" + strconv.FormatUint(uint64(project.ID), 10)}
+
+	deployments, err := c.clientset.AppsV1().Deployments(Namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %v", err)
+	}
+	for _, d := range deployments.Items {
+		if _, err := c.clientset.AppsV1().Deployments(Namespace).Patch(ctx, d.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to relabel deployment %s: %v", d.Name, err)
+		}
+	}
+	services, err := c.clientset.CoreV1().Services(Namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, s := range services.Items {
+		if _, err := c.clientset.CoreV1().Services(Namespace).Patch(ctx, s.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to relabel service %s: %v", s.Name, err)
+		}
+	}
+	ingresses, err := c.clientset.NetworkingV1().Ingresses(Namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list ingresses: %v", err)
+	}
+	for _, i := range ingresses.Items {
+		if _, err := c.clientset.NetworkingV1().Ingresses(Namespace).Patch(ctx, i.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to relabel ingress %s: %v", i.Name, err)
+		}
+	}
+	return nil
+}
+
+// metadataPatch is the merge patch from previous to current custom entries:
+// removed keys are set to null, the platform's own keys are never touched
+func metadataPatch(previous, current map[string]string) map[string]interface{} {
+	patch := make(map[string]interface{}, len(previous)+len(current))
+	for k := range previous {
+		patch[k] = nil
+	}
+	for k, v := range current {
+		patch[k] = v
+	}
+	return patch
+}
+
+// Environment is the environment of the resources a deployment rolled out to
+func Environment(deployment *models.Deployment) string {
+	return environment(deployment, deployment.K8sDeploymentName)
+}
@@ -0,0 +1,124 @@
+package kubernetes
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AcquireLease takes the coordination Lease called name in the platform's
+// namespace for holder, or renews it, for ttl. It reports false while
+// another holder's lease hasn't expired, or when another replica updated it
+// first.
+func (c *Client) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	leases := c.clientset.CoordinationV1().Leases(Namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(ttl.Seconds())
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		transitions := int32(0)
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: Namespace, Labels: map[string]string{LabelManagedBy: ManagedBy}},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+				LeaseTransitions:     &transitions,
+			},
+		}, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	spec := &lease.Spec
+	if current := leaseHolder(spec); current != holder {
+		if current != "" && !leaseExpired(spec, now.Time) {
+			return false, nil
+		}
+		transitions := int32(1)
+		if spec.LeaseTransitions != nil {
+			transitions += *spec.LeaseTransitions
+		}
+		spec.LeaseTransitions = &transitions
+		spec.AcquireTime = &now
+	}
+	spec.HolderIdentity = &holder
+	spec.LeaseDurationSeconds = &seconds
+	spec.RenewTime = &now
+	// The update carries the version read: a replica taking it meanwhile wins
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseLease gives up the Lease called name if holder has it, so another
+// replica takes it without waiting for it to expire
+func (c *Client) ReleaseLease(ctx context.Context, name, holder string) error {
+	leases := c.clientset.CoordinationV1().Leases(Namespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if leaseHolder(&lease.Spec) != holder {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// LeaseHolder returns who holds the Lease called name and until when, ""
+// when nobody does
+func (c *Client) LeaseHolder(ctx context.Context, name string) (string, time.Time, error) {
+	lease, err := c.clientset.CoordinationV1().Leases(Namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	holder := leaseHolder(&lease.Spec)
+	if holder == "" || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return "", time.Time{}, nil
+	}
+	expires := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	if !expires.After(time.Now()) {
+		return "", time.Time{}, nil
+	}
+	return holder, expires, nil
+}
+
+func leaseHolder(spec *coordinationv1.LeaseSpec) string {
+	if spec.HolderIdentity == nil {
+		return ""
+	}
+	return *spec.HolderIdentity
+}
+
+// leaseExpired reports whether a lease wasn't renewed within its duration
+func leaseExpired(spec *coordinationv1.LeaseSpec, now time.Time) bool {
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
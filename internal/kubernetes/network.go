@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"fmt"
+	"strconv"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Namespace holds the resources of every project
+const Namespace = "default"
+
+var networkPolicies bool
+
+// InitNetworkPolicies enables NetworkPolicies restricting internal projects
+// to their owner's pods
+func InitNetworkPolicies(cfg *config.Config) {
+	networkPolicies = cfg.NetworkPolicies
+}
+
+// ServiceURL is the in-cluster URL of the Service named name, e.g.
+// "http://project-4.default.svc"
+func ServiceURL(name string) string {
+	return fmt.Sprintf("http://%s.%s.svc", name, Namespace)
+}
+
+// ServiceEnv returns a SERVICE_<SLUG>_URL variable for each internal
+// project, pointing at its production Service: a project with slug "orders-api"
+// is reached at $SERVICE_ORDERS_API_URL.
+func ServiceEnv(projects []models.Project) map[string]string {
+	env := make(map[string]string, len(projects))
+	for _, project := range projects {
+		if project.Slug == "" {
+			continue
+		}
+		env[ServiceEnvName(project.Slug)] = ServiceURL(ProjectResourceName(project.ID))
+	}
+	return env
+}
+
+// ServiceEnvName is the variable holding the URL of the project with slug
+func ServiceEnvName(slug string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, slug)
+	return "SERVICE_" + name + "_URL"
+}
+
+// BuildNetworkPolicy renders the policy of an internal project's pods: only
+// pods of the same owner may connect. Every project shares Namespace, so the
+// owner label stands in for a per-owner namespace. Returns nil for public
+// projects or when network policies are disabled.
+func BuildNetworkPolicy(name string, project *models.Project, labels map[string]string) *networkingv1.NetworkPolicy {
+	if !networkPolicies || !project.Internal() {
+		return nil
+	}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Namespace,
+			Labels:    labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{LabelApp: name},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{LabelOwner: ownerLabelValue(project)},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// ownerLabelValue is the LabelOwner of project's resources
+func ownerLabelValue(project *models.Project) string {
+	return strconv.FormatUint(uint64(project.UserID), 10)
+}
+
+// ApplyVisibility brings the Ingress and NetworkPolicy of deployment's
+// resources in line with its project's visibility: public projects get an
+// Ingress for hostname, internal ones lose it and get their policy. Workers
+// never have one. deployment.Project must be loaded.
+func (c *Client) ApplyVisibility(ctx context.Context, deployment *models.Deployment, hostname string) error {
+	name := deployment.K8sDeploymentName
+	var ingress *networkingv1.Ingress
+	if deployment.Project.Served() {
+		var err error
+		if ingress, err = PatchIngress(deployment, BuildIngress(deployment, name, hostname)); err != nil {
+			return err
+		}
+	}
+	if err := c.applyIngress(ctx, name, ingress); err != nil {
+		return err
+	}
+	return c.applyNetworkPolicy(ctx, name, BuildNetworkPolicy(name, &deployment.Project, ResourceLabels(deployment, name)))
+}
+
+// applyNetworkPolicy creates or updates policy, or deletes the policy named
+// name when policy is nil. Policies are left alone while disabled: the
+// platform may not be allowed to manage them.
+func (c *Client) applyNetworkPolicy(ctx context.Context, name string, policy *networkingv1.NetworkPolicy) error {
+	if !networkPolicies {
+		return nil
+	}
+	policies := c.clientset.NetworkingV1().NetworkPolicies(Namespace)
+	if policy == nil {
+		if err := policies.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete network policy: %v", err)
+		}
+		return nil
+	}
+
+	_, err := policies.Create(ctx, policy, metav1.CreateOptions{})
+	if err != nil {
+		if errors.IsAlreadyExists(err) {
+			_, updateErr := policies.Update(ctx, policy, metav1.UpdateOptions{})
+			if updateErr != nil {
+				return fmt.Errorf("failed to update network policy: %v", updateErr)
+			}
+		} else {
+			return fmt.Errorf("failed to create network policy: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package kubernetes
+
+import (
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	maxManifestPatches = 10
+	maxPatchBytes      = 32 << 10
+)
+
+// Kinds of the objects manifest patches apply to, and drift sweeps compare
+const (
+	KindDeployment = "Deployment"
+	KindService    = "Service"
+	KindIngress    = "Ingress"
+)
+
+// guardedFields are the fields of each kind the platform owns: a patch
+// changing them, or setting them when the platform doesn't, is refused.
+// Paths are into the object's JSON, "containers[app]" being the container
+// named app. Labels are set with custom_labels, which the platform checks.
+var guardedFields = map[string][]string{
+	KindDeployment: {
+		"metadata.name", "metadata.namespace", "metadata.labels",
+		"spec.selector", "spec.template.metadata.labels",
+		"spec.template.spec.containers[app].image",
+		"spec.template.spec.containers[app].envFrom",
+		"spec.template.spec.imagePullSecrets",
+		"spec.template.spec.serviceAccountName",
+		"spec.template.spec.automountServiceAccountToken",
+		"spec.template.spec.hostNetwork", "spec.template.spec.hostPID", "spec.template.spec.hostIPC",
+	},
+	KindService: {
+		"metadata.name", "metadata.namespace", "metadata.labels",
+		"spec.selector", "spec.type", "spec.externalName", "spec.externalIPs",
+	},
+	KindIngress: {
+		"metadata.name", "metadata.namespace", "metadata.labels",
+		"spec.rules", "spec.tls", "spec.defaultBackend",
+	},
+}
+
+// ManifestPatchError is returned when a project's manifest patches don't
+// apply to its manifests or change fields the platform owns
+type ManifestPatchError struct {
+	Index int // Of the patch in the project's list
+	Kind  string
+	Err   error
+}
+
+func (e *ManifestPatchError) Error() string {
+	return fmt.Sprintf("manifest patch %d (%s): %v", e.Index, e.Kind, e.Err)
+}
+
+func (e *ManifestPatchError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateManifestPatches checks a project's manifest patches by applying
+// them to the manifests of a sample public web deployment, so every kind is
+// rendered
+func ValidateManifestPatches(patches []models.ManifestPatch) error {
+	if len(patches) > maxManifestPatches {
+		return fmt.Errorf("at most %d manifest patches are allowed", maxManifestPatches)
+	}
+	sample := &models.Deployment{
+		ID:        1,
+		ProjectID: 1,
+		ImageTag:  "deploy-1:sample",
+		Project:   models.Project{ID: 1, UserID: 1, Visibility: models.VisibilityPublic, ProcessType: models.ProcessWeb},
+	}
+	manifests := BuildManifests(sample, "sample.example.com", map[string]string{"PORT": "8080"})
+	return PatchManifests(manifests, patches)
+}
+
+// BuildPatchedManifests is BuildManifests with the deployment's manifest
+// patches applied
+func BuildPatchedManifests(deployment *models.Deployment, hostname string, envVars map[string]string) (*Manifests, error) {
+	manifests := BuildManifests(deployment, hostname, envVars)
+	if err := PatchManifests(manifests, deployment.ManifestPatches); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+// PatchIngress applies the deployment's Ingress patches to ingress, rendered
+// apart from the other manifests (placeholder, visibility changes)
+func PatchIngress(deployment *models.Deployment, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
+	manifests := &Manifests{Ingress: ingress}
+	if err := PatchManifests(manifests, deployment.ManifestPatches); err != nil {
+		return nil, err
+	}
+	return manifests.Ingress, nil
+}
+
+// PatchManifests applies patches, in order, to the objects of manifests.
+// Patches of kinds manifests lack, like the Ingress of an internal project,
+// are skipped. Returns a *ManifestPatchError.
+func PatchManifests(manifests *Manifests, patches []models.ManifestPatch) error {
+	for i, patch := range patches {
+		var err error
+		switch patch.Kind {
+		case KindDeployment:
+			if manifests.Deployment != nil {
+				manifests.Deployment, err = patchObject(manifests.Deployment, patch)
+			}
+		case KindService:
+			if manifests.Service != nil {
+				manifests.Service, err = patchObject(manifests.Service, patch)
+			}
+		case KindIngress:
+			if manifests.Ingress != nil {
+				manifests.Ingress, err = patchObject(manifests.Ingress, patch)
+			}
+		default:
+			err = fmt.Errorf("kind must be %s, %s or %s", KindDeployment, KindService, KindIngress)
+		}
+		if err != nil {
+			return &ManifestPatchError{Index: i, Kind: patch.Kind, Err: err}
+		}
+	}
+	return nil
+}
+
+// patchObject returns object with patch applied, refusing changes to its
+// guarded fields
+func patchObject[T *appsv1.Deployment | *corev1.Service | *networkingv1.Ingress](object T, patch models.ManifestPatch) (T, error) {
+	if len(patch.Patch) > maxPatchBytes {
+		return nil, fmt.Errorf("patch must be at most %d bytes", maxPatchBytes)
+	}
+	patchJSON, err := yaml.YAMLToJSON([]byte(patch.Patch))
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML or JSON: %v", err)
+	}
+	original, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	switch patch.Type {
+	case models.ManifestPatchStrategic:
+		patched, err = strategicpatch.StrategicMergePatch(original, patchJSON, reflect.New(reflect.TypeOf(object).Elem()).Interface())
+	case models.ManifestPatchJSON6902:
+		var operations jsonpatch.Patch
+		if operations, err = jsonpatch.DecodePatch(patchJSON); err == nil {
+			patched, err = operations.Apply(original)
+		}
+	default:
+		return nil, fmt.Errorf("type must be %s or %s", models.ManifestPatchStrategic, models.ManifestPatchJSON6902)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply: %v", err)
+	}
+	if err := checkGuarded(patch.Kind, original, patched); err != nil {
+		return nil, err
+	}
+
+	result := reflect.New(reflect.TypeOf(object).Elem()).Interface().(T)
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, fmt.Errorf("the patched %s is invalid: %v", patch.Kind, err)
+	}
+	return result, nil
+}
+
+// checkGuarded refuses patched if it changes the guarded fields of kind, or
+// runs privileged containers or mounts host paths
+func checkGuarded(kind string, original, patched []byte) error {
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(original, &before); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return fmt.Errorf("the patched %s is invalid: %v", kind, err)
+	}
+	for _, path := range guardedFields[kind] {
+		if !reflect.DeepEqual(lookupField(before, path), lookupField(after, path)) {
+			return fmt.Errorf("%s is owned by the platform and can't be patched", path)
+		}
+	}
+	if kind != KindDeployment {
+		return nil
+	}
+
+	podSpec, _ := lookupField(after, "spec.template.spec").(map[string]interface{})
+	for _, field := range []string{"containers", "initContainers"} {
+		containers, _ := podSpec[field].([]interface{})
+		for _, c := range containers {
+			container, _ := c.(map[string]interface{})
+			if privileged, _ := lookupField(container, "securityContext.privileged").(bool); privileged {
+				return fmt.Errorf("privileged containers are not allowed")
+			}
+		}
+	}
+	volumes, _ := podSpec["volumes"].([]interface{})
+	for _, v := range volumes {
+		if volume, _ := v.(map[string]interface{}); volume["hostPath"] != nil {
+			return fmt.Errorf("hostPath volumes are not allowed")
+		}
+	}
+	return nil
+}
+
+// lookupField returns the value at path in object, nil when it is unset
+func lookupField(object map[string]interface{}, path string) interface{} {
+	var value interface{} = object
+	for _, key := range strings.Split(path, ".") {
+		name, item, isItem := strings.Cut(strings.TrimSuffix(key, "]"), "[")
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = fields[name]
+		if !isItem {
+			continue
+		}
+		list, _ := value.([]interface{})
+		value = nil
+		for _, element := range list {
+			if e, ok := element.(map[string]interface{}); ok && e["name"] == item {
+				value = e
+				break
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,218 @@
+package kubernetes
+
+import (
+	"deploy-platform/internal/models"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares got with the golden file at path, rewriting it with -update
+func golden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from the golden file:\n%s", path, got)
+	}
+}
+
+// patchedFields is what patching changed in each kind of manifests, as JSON
+// merge patches from the rendered objects to the patched ones
+func patchedFields(t *testing.T, rendered, patched *Manifests) []byte {
+	t.Helper()
+	changes := map[string]json.RawMessage{}
+	for kind, objects := range map[string][2]interface{}{
+		KindDeployment: {rendered.Deployment, patched.Deployment},
+		KindService:    {rendered.Service, patched.Service},
+		KindIngress:    {rendered.Ingress, patched.Ingress},
+	} {
+		before, _ := json.Marshal(objects[0])
+		after, _ := json.Marshal(objects[1])
+		diff, err := jsonpatch.CreateMergePatch(before, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(diff) != "{}" {
+			changes[kind] = diff
+		}
+	}
+	out, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(out, '\n')
+}
+
+// Representative patches, in YAML or JSON, change the rendered manifests as
+// in testdata/patches/<name>.json
+func TestManifestPatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		patches []models.ManifestPatch
+	}{
+		{
+			name: "tolerations",
+			patches: []models.ManifestPatch{{Kind: KindDeployment, Type: models.ManifestPatchJSON6902, Patch: `
+- op: add
+  path: /spec/template/spec/tolerations
+  value:
+    - key: dedicated
+      operator: Equal
+      value: gpu
+      effect: NoSchedule
+`}},
+		},
+		{
+			name: "volumes",
+			patches: []models.ManifestPatch{{Kind: KindDeployment, Type: models.ManifestPatchJSON6902, Patch: `[
+				{"op": "add", "path": "/spec/template/spec/volumes", "value": [
+					{"name": "cache", "emptyDir": {}},
+					{"name": "settings", "configMap": {"name": "app-settings"}}
+				]}
+			]`}},
+		},
+		{
+			name: "annotations",
+			patches: []models.ManifestPatch{
+				{Kind: KindService, Type: models.ManifestPatchJSON6902, Patch: `[{"op": "add", "path": "/metadata/annotations", "value": {"prometheus.io/scrape": "true"}}]`},
+				{Kind: KindIngress, Type: models.ManifestPatchJSON6902, Patch: `
+- op: add
+  path: /metadata/annotations
+  value:
+    nginx.ingress.kubernetes.io/whitelist-source-range: 10.0.0.0/8
+`},
+			},
+		},
+		{
+			// Each patch applies to what the ones before it made
+			name: "in-order",
+			patches: []models.ManifestPatch{
+				{Kind: KindDeployment, Type: models.ManifestPatchJSON6902, Patch: `[{"op": "add", "path": "/spec/template/spec/tolerations", "value": [{"key": "spot", "operator": "Exists"}]}]`},
+				{Kind: KindDeployment, Type: models.ManifestPatchJSON6902, Patch: `[{"op": "add", "path": "/spec/template/spec/tolerations/0/effect", "value": "NoExecute"}]`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := webDeployment(7)
+			env := map[string]string{"PORT": "8080"}
+			rendered := BuildManifests(deployment, "app.example.com", env)
+			deployment.ManifestPatches = tt.patches
+			if err := ValidateManifestPatches(tt.patches); err != nil {
+				t.Fatal(err)
+			}
+			patched, err := BuildPatchedManifests(deployment, "app.example.com", env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, filepath.Join("testdata", "patches", tt.name+".json"), patchedFields(t, rendered, patched))
+		})
+	}
+}
+
+// Patches of kinds a deployment isn't rolled out with are skipped, and the
+// Ingress rendered apart is patched like the others
+func TestPatchSkipsMissingKinds(t *testing.T) {
+	patches := []models.ManifestPatch{
+		{Kind: KindService, Type: models.ManifestPatchJSON6902, Patch: `[{"op": "replace", "path": "/spec/ports/0/port", "value": 8443}]`},
+		{Kind: KindIngress, Type: models.ManifestPatchJSON6902, Patch: `[{"op": "add", "path": "/metadata/annotations", "value": {"team": "web"}}]`},
+	}
+	worker := webDeployment(7)
+	worker.Project.ProcessType = models.ProcessWorker
+	worker.ManifestPatches = patches
+	manifests, err := BuildPatchedManifests(worker, "", nil)
+	if err != nil || manifests.Service != nil || manifests.Ingress != nil {
+		t.Fatalf("worker: %+v, %v", manifests, err)
+	}
+
+	web := webDeployment(7)
+	web.ManifestPatches = patches
+	ingress, err := PatchIngress(web, BuildIngress(web, ProjectResourceName(7), "app.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ingress.Annotations["team"] != "web" {
+		t.Errorf("ingress annotations %v", ingress.Annotations)
+	}
+}
+
+// Patches touching what the platform owns, or running pods with access to
+// the node, are refused with the patch at fault
+func TestManifestPatchDenylist(t *testing.T) {
+	op := func(kind, operations string) models.ManifestPatch {
+		return models.ManifestPatch{Kind: kind, Type: models.ManifestPatchJSON6902, Patch: operations}
+	}
+	tests := []struct {
+		name  string
+		patch models.ManifestPatch
+		err   string
+	}{
+		{"image", op(KindDeployment, `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "evil:latest"}]`), "spec.template.spec.containers[app].image is owned by the platform and can't be patched"},
+		{"env from", op(KindDeployment, `[{"op": "remove", "path": "/spec/template/spec/containers/0/envFrom"}]`), "spec.template.spec.containers[app].envFrom is owned by the platform"},
+		{"selector", op(KindDeployment, `[{"op": "replace", "path": "/spec/selector/matchLabels/app", "value": "other"}]`), "spec.selector is owned by the platform"},
+		{"namespace", op(KindDeployment, `[{"op": "replace", "path": "/metadata/namespace", "value": "kube-system"}]`), "metadata.namespace is owned by the platform"},
+		{"labels", op(KindDeployment, `[{"op": "add", "path": "/metadata/labels/team", "value": "web"}]`), "metadata.labels is owned by the platform"},
+		{"pod labels", op(KindDeployment, `[{"op": "add", "path": "/spec/template/metadata/labels/team", "value": "web"}]`), "spec.template.metadata.labels is owned by the platform"},
+		{"service account", op(KindDeployment, `[{"op": "add", "path": "/spec/template/spec/serviceAccountName", "value": "admin"}]`), "spec.template.spec.serviceAccountName is owned by the platform"},
+		{"host network", op(KindDeployment, `[{"op": "add", "path": "/spec/template/spec/hostNetwork", "value": true}]`), "spec.template.spec.hostNetwork is owned by the platform"},
+		{"host PID", op(KindDeployment, `[{"op": "add", "path": "/spec/template/spec/hostPID", "value": true}]`), "spec.template.spec.hostPID is owned by the platform"},
+		{"privileged", op(KindDeployment, `[{"op": "add", "path": "/spec/template/spec/containers/0/securityContext", "value": {"privileged": true}}]`), "privileged containers are not allowed"},
+		{"privileged init container", op(KindDeployment, `[{"op": "add", "path": "/spec/template/spec/initContainers", "value": [{"name": "setup", "image": "busybox", "securityContext": {"privileged": true}}]}]`), "privileged containers are not allowed"},
+		{"host path", op(KindDeployment, `[{"op": "add", "path": "/spec/template/spec/volumes", "value": [{"name": "docker", "hostPath": {"path": "/var/run/docker.sock"}}]}]`), "hostPath volumes are not allowed"},
+		{"service type", op(KindService, `[{"op": "replace", "path": "/spec/type", "value": "LoadBalancer"}]`), "spec.type is owned by the platform"},
+		{"external IPs", op(KindService, `[{"op": "add", "path": "/spec/externalIPs", "value": ["203.0.113.7"]}]`), "spec.externalIPs is owned by the platform"},
+		{"ingress rules", op(KindIngress, `[{"op": "replace", "path": "/spec/rules/0/host", "value": "evil.example.com"}]`), "spec.rules is owned by the platform"},
+		{"ingress TLS", op(KindIngress, `[{"op": "add", "path": "/spec/tls", "value": [{"hosts": ["app.example.com"], "secretName": "stolen"}]}]`), "spec.tls is owned by the platform"},
+		{"default backend", op(KindIngress, `[{"op": "add", "path": "/spec/defaultBackend", "value": {"service": {"name": "other", "port": {"number": 80}}}}]`), "spec.defaultBackend is owned by the platform"},
+		{"kind", op("ConfigMap", `[]`), "kind must be Deployment, Service or Ingress"},
+		{"type", models.ManifestPatch{Kind: KindDeployment, Type: "merge", Patch: `{}`}, "type must be strategic or json6902"},
+		{"invalid", op(KindDeployment, `[{"op": "add", "path": `), "invalid YAML or JSON"},
+		{"too large", op(KindDeployment, `[{"op": "add", "path": "/metadata/annotations/x", "value": "`+strings.Repeat("x", maxPatchBytes)+`"}]`), "patch must be at most 32768 bytes"},
+		{"missing path", op(KindDeployment, `[{"op": "remove", "path": "/spec/template/spec/volumes/0"}]`), "failed to apply"},
+	}
+	allowed := op(KindDeployment, `[{"op": "add", "path": "/spec/template/spec/tolerations", "value": [{"key": "spot", "operator": "Exists"}]}]`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateManifestPatches([]models.ManifestPatch{allowed, tt.patch})
+			var patchErr *ManifestPatchError
+			if !errors.As(err, &patchErr) {
+				t.Fatalf("got %v", err)
+			}
+			if patchErr.Index != 1 || patchErr.Kind != tt.patch.Kind || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q (patch %d, %s), want %q", err, patchErr.Index, patchErr.Kind, tt.err)
+			}
+		})
+	}
+
+	// Refused at deploy time too, should the project's patches predate a rule
+	deployment := webDeployment(7)
+	deployment.ManifestPatches = []models.ManifestPatch{tests[0].patch}
+	if _, err := BuildPatchedManifests(deployment, "app.example.com", nil); err == nil {
+		t.Error("deployed a patched image")
+	}
+
+	patches := make([]models.ManifestPatch, maxManifestPatches+1)
+	for i := range patches {
+		patches[i] = allowed
+	}
+	if err := ValidateManifestPatches(patches); err == nil || err.Error() != "at most 10 manifest patches are allowed" {
+		t.Errorf("%d patches: %v", len(patches), err)
+	}
+}
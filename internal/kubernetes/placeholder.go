@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlaceholderName names the Service, shared by every project of a cluster,
+// routing to the platform's placeholder page
+const PlaceholderName = "platform-placeholder"
+
+var (
+	placeholderHost string // "" = no placeholder page
+	placeholderPort int32
+)
+
+// InitPlaceholder sets where clusters reach the platform's placeholder page
+func InitPlaceholder(cfg *config.Config) {
+	host, port, err := net.SplitHostPort(cfg.PlaceholderBackend)
+	if err != nil {
+		return
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		placeholderHost, placeholderPort = host, int32(p)
+	}
+}
+
+// PlaceholderEnabled reports whether hostnames without a ready deployment
+// get the placeholder page
+func PlaceholderEnabled() bool {
+	return placeholderHost != ""
+}
+
+// BuildPlaceholderService renders the ExternalName Service pointing at the
+// platform's placeholder listener. The page is served by the platform, which
+// looks the hostname up, so no pod with database access runs next to projects.
+func BuildPlaceholderService() *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PlaceholderName,
+			Namespace: Namespace,
+			Labels:    map[string]string{LabelManagedBy: ManagedBy},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: placeholderHost,
+		},
+	}
+}
+
+// BuildPlaceholderIngress is the Ingress of deployment's resources (see
+// BuildIngress) routing hostname to the placeholder page instead
+func BuildPlaceholderIngress(deployment *models.Deployment, name, hostname string) *networkingv1.Ingress {
+	ingress := BuildIngress(deployment, name, hostname)
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service = &networkingv1.IngressServiceBackend{
+		Name: PlaceholderName,
+		Port: networkingv1.ServiceBackendPort{Number: placeholderPort},
+	}
+	return ingress
+}
+
+// ShowPlaceholder points the Ingress of deployment's resources at the
+// placeholder page, while none of their deployments is ready. Does nothing
+// when the placeholder is disabled or the project isn't served.
+func (c *Client) ShowPlaceholder(ctx context.Context, deployment *models.Deployment, hostname string) error {
+	if !PlaceholderEnabled() || !deployment.Project.Served() || hostname == "" {
+		return nil
+	}
+	if err := c.applyPlaceholderService(ctx); err != nil {
+		return err
+	}
+	ingress, err := PatchIngress(deployment, BuildPlaceholderIngress(deployment, deployment.K8sDeploymentName, hostname))
+	if err != nil {
+		return err
+	}
+	return c.applyIngress(ctx, deployment.K8sDeploymentName, ingress)
+}
+
+// ShowDeployment points the Ingress of deployment's resources back at their
+// Service, once the deployment is ready
+func (c *Client) ShowDeployment(ctx context.Context, deployment *models.Deployment, hostname string) error {
+	if !PlaceholderEnabled() || !deployment.Project.Served() || hostname == "" {
+		return nil
+	}
+	ingress, err := PatchIngress(deployment, BuildIngress(deployment, deployment.K8sDeploymentName, hostname))
+	if err != nil {
+		return err
+	}
+	return c.applyIngress(ctx, deployment.K8sDeploymentName, ingress)
+}
+
+// applyPlaceholderService creates or updates the placeholder Service
+func (c *Client) applyPlaceholderService(ctx context.Context) error {
+	return c.applyService(ctx, BuildPlaceholderService())
+}
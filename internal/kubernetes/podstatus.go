@@ -0,0 +1,180 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrWorkloadNotFound is returned for resources that are gone from the
+// cluster, e.g. torn down with their branch
+var ErrWorkloadNotFound = errors.New("deployment not found in the cluster")
+
+// WorkloadStatus is how the pods of a platform Deployment are doing right now
+type WorkloadStatus struct {
+	Name              string      `json:"name"`
+	Image             string      `json:"image"`    // Of the app container in the current pod template
+	Replicas          int32       `json:"replicas"` // Desired
+	ReadyReplicas     int32       `json:"ready_replicas"`
+	UpdatedReplicas   int32       `json:"updated_replicas"` // Running the current pod template
+	AvailableReplicas int32       `json:"available_replicas"`
+	RolledOut         bool        `json:"rolled_out"`
+	Restarts          int32       `json:"restarts"` // Of all the pods' app containers
+	Pods              []PodStatus `json:"pods"`     // Newest first
+	LastEvent         *PodEvent   `json:"last_event,omitempty"`
+}
+
+// PodStatus is the state of one pod of a workload
+type PodStatus struct {
+	Name     string    `json:"name"`
+	Phase    string    `json:"phase"`
+	Ready    bool      `json:"ready"`
+	Restarts int32     `json:"restarts"`
+	State    string    `json:"state"`                      // "running", or why the app container isn't, e.g. "CrashLoopBackOff"
+	LastExit string    `json:"last_termination,omitempty"` // How the app container last exited, see lastTermination
+	Created  time.Time `json:"created_at"`
+}
+
+// PodEvent is an event Kubernetes reported about a pod
+type PodEvent struct {
+	Pod     string    `json:"pod"`
+	Type    string    `json:"type"` // Normal or Warning
+	Reason  string    `json:"reason"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// WorkloadStatus reads the replicas of Deployment name, the state of each of
+// its pods and the latest event about them from the cluster
+func (c *Client) WorkloadStatus(ctx context.Context, name string) (*WorkloadStatus, error) {
+	d, err := c.clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, ErrWorkloadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	status := &WorkloadStatus{
+		Name:              name,
+		Replicas:          1,
+		ReadyReplicas:     d.Status.ReadyReplicas,
+		UpdatedReplicas:   d.Status.UpdatedReplicas,
+		AvailableReplicas: d.Status.AvailableReplicas,
+		RolledOut:         rolledOut(d),
+		Pods:              []PodStatus{},
+	}
+	if d.Spec.Replicas != nil {
+		status.Replicas = *d.Spec.Replicas
+	}
+	for _, container := range d.Spec.Template.Spec.Containers {
+		if container.Name == "app" {
+			status.Image = container.Image
+		}
+	}
+
+	pods, err := c.clientset.CoreV1().Pods(Namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelApp + "=" + name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.After(pods.Items[j].CreationTimestamp.Time)
+	})
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		podStatus := PodStatus{
+			Name:    pod.Name,
+			Phase:   string(pod.Status.Phase),
+			State:   "pending",
+			Created: pod.CreationTimestamp.Time,
+		}
+		if pod.DeletionTimestamp != nil {
+			podStatus.State = "terminating"
+		}
+		if app := appStatus(pod); app != nil {
+			podStatus.Ready = app.Ready
+			podStatus.Restarts = app.RestartCount
+			podStatus.LastExit = lastTermination(app)
+			switch {
+			case pod.DeletionTimestamp != nil:
+			case app.State.Running != nil:
+				podStatus.State = "running"
+			case app.State.Waiting != nil:
+				podStatus.State = app.State.Waiting.Reason
+			case app.State.Terminated != nil:
+				podStatus.State = app.State.Terminated.Reason
+			}
+		}
+		status.Restarts += podStatus.Restarts
+		status.Pods = append(status.Pods, podStatus)
+	}
+
+	status.LastEvent = c.latestPodEvent(ctx, status.Pods)
+	return status, nil
+}
+
+// latestPodEvent is the latest event Kubernetes reported about any of pods,
+// nil if none was: events are best effort, they expire after an hour and
+// reading them may be forbidden
+func (c *Client) latestPodEvent(ctx context.Context, pods []PodStatus) *PodEvent {
+	var latest *PodEvent
+	for _, pod := range pods {
+		events, err := c.clientset.CoreV1().Events(Namespace).List(ctx, metav1.ListOptions{
+			FieldSelector: "involvedObject.kind=Pod,involvedObject.name=" + pod.Name,
+		})
+		if err != nil {
+			return latest
+		}
+		for _, event := range events.Items {
+			at := event.LastTimestamp.Time
+			if at.IsZero() {
+				at = event.EventTime.Time
+			}
+			if latest == nil || at.After(latest.At) {
+				latest = &PodEvent{
+					Pod:     pod.Name,
+					Type:    event.Type,
+					Reason:  event.Reason,
+					Message: strings.TrimSpace(event.Message),
+					At:      at,
+				}
+			}
+		}
+	}
+	return latest
+}
+
+// appStatus is the status of the pod's app container, nil before it is
+// scheduled
+func appStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == "app" {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// lastTermination describes how a container last exited, e.g. "Error, exit
+// code 1: listen EADDRINUSE" with its termination message, or "" if it
+// never did
+func lastTermination(status *corev1.ContainerStatus) string {
+	terminated := status.LastTerminationState.Terminated
+	if terminated == nil {
+		terminated = status.State.Terminated
+	}
+	if terminated == nil {
+		return ""
+	}
+	description := fmt.Sprintf("%s, exit code %d", terminated.Reason, terminated.ExitCode)
+	if message := strings.TrimSpace(terminated.Message); message != "" {
+		description += ": " + message
+	}
+	return description
+}
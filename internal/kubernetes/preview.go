@@ -0,0 +1,136 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ComponentPreview tells the Jobs provisioning and tearing down the
+// resources of previews (see the preview package) from the others
+const ComponentPreview = "preview"
+
+// PreviewJobError fails a preview whose provisioner command failed or timed out
+type PreviewJobError struct {
+	Job    string
+	Reason string
+}
+
+func (e *PreviewJobError) Error() string {
+	return fmt.Sprintf("preview command failed (job %s): %s", e.Job, e.Reason)
+}
+
+// PreviewJob is a provisioner command run for the preview whose resources
+// are named Preview. It carries everything it runs with: teardowns run
+// after the preview's deployments, even its project, may be gone.
+type PreviewJob struct {
+	ProjectID  uint
+	Preview    string
+	Action     string // What the command does, e.g. "provision", part of the Job's name
+	Image      string
+	PullSecret string // Secret Image is pulled with, "" = none
+	Command    string
+	Env        map[string]string
+}
+
+// Name is the name of the Job running j, within the 63 characters of a label
+func (j *PreviewJob) Name() string {
+	sum := sha256.Sum256([]byte(strconv.FormatUint(uint64(j.ProjectID), 10) + "/" + j.Preview))
+	return fmt.Sprintf("preview-%s-%s", j.Action, hex.EncodeToString(sum[:])[:12])
+}
+
+func (j *PreviewJob) labels() map[string]string {
+	return map[string]string{
+		LabelApp:       j.Name(),
+		LabelManagedBy: ManagedBy,
+		LabelProjectID: strconv.FormatUint(uint64(j.ProjectID), 10),
+		LabelComponent: ComponentPreview,
+	}
+}
+
+// BuildPreviewJob renders the Job running j's command once, with the env
+// vars of the Secret named secretName
+func BuildPreviewJob(j *PreviewJob, secretName string) *batchv1.Job {
+	labels := j.labels()
+	var pullSecrets []corev1.LocalObjectReference
+	if j.PullSecret != "" {
+		pullSecrets = []corev1.LocalObjectReference{{Name: j.PullSecret}}
+	}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.Name(),
+			Namespace: Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: int32Ptr(0), // The platform retries teardowns itself
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: pullSecrets,
+					Containers: []corev1.Container{
+						{
+							Name:    ComponentPreview,
+							Image:   j.Image,
+							Command: []string{"/bin/sh", "-c", j.Command},
+							EnvFrom: []corev1.EnvFromSource{
+								{
+									SecretRef: &corev1.SecretEnvSource{
+										LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// RunPreviewJob runs j as a Job and waits up to timeout for it to finish,
+// returning what it logged. A command that fails or doesn't finish in time
+// returns a *PreviewJobError. The Job is replaced by the next run of the
+// same action for the preview and deleted with the project's others; its
+// env Secret is deleted as soon as it's done.
+func (c *Client) RunPreviewJob(ctx context.Context, j *PreviewJob, timeout time.Duration) (string, error) {
+	name := j.Name()
+	data := make(map[string][]byte, len(j.Env))
+	for k, v := range j.Env {
+		data[k] = []byte(v)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: SecretName(name), Namespace: Namespace, Labels: j.labels()},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       data,
+	}
+	if err := c.applySecret(ctx, secret); err != nil {
+		return "", err
+	}
+	defer c.deleteSecret(context.Background(), name)
+
+	if err := c.deleteJob(ctx, name); err != nil {
+		return "", err
+	}
+	if _, err := c.clientset.BatchV1().Jobs(Namespace).Create(ctx, BuildPreviewJob(j, secret.Name), metav1.CreateOptions{}); err != nil {
+		if errors.IsAlreadyExists(err) {
+			return "", fmt.Errorf("preview job %s is still being deleted, retry later", name)
+		}
+		return "", fmt.Errorf("failed to create preview job: %w", err)
+	}
+
+	logs, failure, err := c.waitForJob(ctx, name, ComponentPreview, timeout)
+	if err == nil && failure != "" {
+		err = &PreviewJobError{Job: name, Reason: failure}
+	}
+	return logs, err
+}
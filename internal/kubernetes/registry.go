@@ -0,0 +1,95 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ComponentRegistry is the LabelComponent of a project's image pull Secret
+const ComponentRegistry = "registry-auth"
+
+// PlatformPullSecretName is the Secret pods pull the images builds pushed to
+// REGISTRY_URL with
+const PlatformPullSecretName = "deploy-platform-registry"
+
+// platformPullSecret is whether pods reference PlatformPullSecretName
+var platformPullSecret bool
+
+// InitRegistry makes pods pull with the platform's registry credentials
+// when builds push to a registry that needs them
+func InitRegistry(cfg *config.Config) {
+	platformPullSecret = cfg.RegistryURL != "" && cfg.RegistryUser != ""
+}
+
+// PullSecretName is the name of the Secret holding the registry credentials
+// the pods of a project pull images with
+func PullSecretName(projectID uint) string {
+	return ProjectResourceName(projectID) + "-registry"
+}
+
+// BuildPullSecret renders the image pull Secret of project, dockerConfig
+// being its .dockerconfigjson. It is shared by all the project's pods.
+func BuildPullSecret(project *models.Project, dockerConfig []byte) *corev1.Secret {
+	name := PullSecretName(project.ID)
+	labels := ResourceLabels(&models.Deployment{ProjectID: project.ID, Project: *project}, name)
+	delete(labels, LabelApp) // Not the env Secret of resources named name
+	delete(labels, LabelEnvironment)
+	labels[LabelComponent] = ComponentRegistry
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   Namespace,
+			Labels:      labels,
+			Annotations: resourceAnnotations(project, nil),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+	}
+}
+
+// ApplyPullSecret creates or updates the image pull Secret of project, or
+// deletes it when dockerConfig is nil. Pods already running keep their
+// image; the next pulls use the new credentials.
+func (c *Client) ApplyPullSecret(ctx context.Context, project *models.Project, dockerConfig []byte) error {
+	if dockerConfig == nil {
+		err := c.clientset.CoreV1().Secrets(Namespace).Delete(ctx, PullSecretName(project.ID), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete secret %s: %w", PullSecretName(project.ID), err)
+		}
+		return nil
+	}
+	return c.applySecret(ctx, BuildPullSecret(project, dockerConfig))
+}
+
+// ApplyPlatformPullSecret creates or updates the Secret pods pull the images
+// builds pushed with, dockerConfig being its .dockerconfigjson
+func (c *Client) ApplyPlatformPullSecret(ctx context.Context, dockerConfig []byte) error {
+	return c.applySecret(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PlatformPullSecretName,
+			Namespace: Namespace,
+			Labels:    map[string]string{LabelManagedBy: ManagedBy, LabelComponent: ComponentRegistry},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+	})
+}
+
+// imagePullSecrets references the image pull Secret of project, if it has
+// one, and the platform's when images are pulled from its registry
+func imagePullSecrets(project *models.Project) []corev1.LocalObjectReference {
+	var secrets []corev1.LocalObjectReference
+	if project.ImagePullSecret != "" {
+		secrets = append(secrets, corev1.LocalObjectReference{Name: project.ImagePullSecret})
+	}
+	if platformPullSecret {
+		secrets = append(secrets, corev1.LocalObjectReference{Name: PlatformPullSecretName})
+	}
+	return secrets
+}
@@ -0,0 +1,93 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The pull Secret is created, rotated in place and deleted with the
+// project's credentials
+func TestApplyPullSecret(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient()
+	project := &webDeployment(7).Project
+	get := func() (*corev1.Secret, error) {
+		return clientset.CoreV1().Secrets(Namespace).Get(ctx, PullSecretName(7), metav1.GetOptions{})
+	}
+
+	first := []byte(`{"auths":{"registry.example.com":{"auth":"Y2k6Zmlyc3Q="}}}`)
+	if err := client.ApplyPullSecret(ctx, project, first); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Name != "project-7-registry" || secret.Type != corev1.SecretTypeDockerConfigJson || string(secret.Data[corev1.DockerConfigJsonKey]) != string(first) {
+		t.Errorf("secret %s of type %s: %s", secret.Name, secret.Type, secret.Data)
+	}
+	if secret.Labels[LabelComponent] != ComponentRegistry || secret.Labels[LabelApp] != "" {
+		t.Errorf("labels %v", secret.Labels)
+	}
+
+	rotated := []byte(`{"auths":{"registry.example.com":{"auth":"Y2k6c2Vjb25k"}}}`)
+	if err := client.ApplyPullSecret(ctx, project, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if secret, _ := get(); string(secret.Data[corev1.DockerConfigJsonKey]) != string(rotated) {
+		t.Errorf("after rotation: %s", secret.Data)
+	}
+
+	for range 2 { // Deleting a missing Secret is not an error
+		if err := client.ApplyPullSecret(ctx, project, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := get(); !errors.IsNotFound(err) {
+		t.Errorf("after delete: %v", err)
+	}
+}
+
+// Every pod of a project pulls with its Secret, and with the platform's
+// when builds push to a registry with credentials
+func TestImagePullSecrets(t *testing.T) {
+	deployment := webDeployment(7)
+	deployment.Project.ImagePullSecret = PullSecretName(7)
+	podSpecs := func() map[string]corev1.PodSpec {
+		return map[string]corev1.PodSpec{
+			"deployment": BuildManifests(deployment, "app.example.com", nil).Deployment.Spec.Template.Spec,
+			"release":    BuildReleaseJob(deployment, "npm run migrate", SecretName(ProjectResourceName(7))).Spec.Template.Spec,
+			"exec":       BuildExecJob(deployment, "exec-1", "ls").Spec.Template.Spec,
+		}
+	}
+
+	want := []corev1.LocalObjectReference{{Name: "project-7-registry"}}
+	for kind, spec := range podSpecs() {
+		if !reflect.DeepEqual(spec.ImagePullSecrets, want) {
+			t.Errorf("%s pulls with %v, want %v", kind, spec.ImagePullSecrets, want)
+		}
+	}
+
+	InitRegistry(&config.Config{RegistryURL: "registry.example.com/acme", RegistryUser: "builder"})
+	t.Cleanup(func() { InitRegistry(&config.Config{}) })
+	want = append(want, corev1.LocalObjectReference{Name: PlatformPullSecretName})
+	for kind, spec := range podSpecs() {
+		if !reflect.DeepEqual(spec.ImagePullSecrets, want) {
+			t.Errorf("%s pulls with %v, want %v", kind, spec.ImagePullSecrets, want)
+		}
+	}
+
+	deployment.Project.ImagePullSecret = ""
+	InitRegistry(&config.Config{})
+	for kind, spec := range podSpecs() {
+		if len(spec.ImagePullSecrets) != 0 {
+			t.Errorf("%s pulls with %v", kind, spec.ImagePullSecrets)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/models"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelComponent tells a deployment's release Jobs (and their Secrets)
+// from the resources serving it
+const (
+	LabelComponent   = "app.kubernetes.io/component"
+	ComponentRelease = "release"
+)
+
+const jobLogBytes = 256 << 10 // Cap on the logs of release and preview Jobs kept
+
+var releaseJobRetention = 24 * time.Hour
+
+// InitReleaseJobs sets how long finished release Jobs are kept
+func InitReleaseJobs(cfg *config.Config) {
+	releaseJobRetention = cfg.ReleaseJobRetention
+}
+
+// ReleaseError fails a deployment whose release command failed or timed out
+type ReleaseError struct {
+	Job    string
+	Reason string
+}
+
+func (e *ReleaseError) Error() string {
+	return fmt.Sprintf("release command failed (job %s): %s", e.Job, e.Reason)
+}
+
+// ReleaseJobName is the name of the Job running the release command of a deployment
+func ReleaseJobName(deploymentID uint) string {
+	return fmt.Sprintf("release-%d", deploymentID)
+}
+
+// BuildReleaseJob renders the Job running command once in deployment's
+// image, with the env vars of the Secret named secretName
+func BuildReleaseJob(deployment *models.Deployment, command, secretName string) *batchv1.Job {
+	name := ReleaseJobName(deployment.ID)
+	labels := releaseLabels(deployment, name)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   Namespace,
+			Labels:      labels,
+			Annotations: resourceAnnotations(&deployment.Project, nil),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: int32Ptr(0), // A failed migration is not retried
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: imagePullSecrets(&deployment.Project),
+					Containers: []corev1.Container{
+						{
+							Name:    ComponentRelease,
+							Image:   deployment.ImageTag,
+							Command: []string{"/bin/sh", "-c", command},
+							EnvFrom: []corev1.EnvFromSource{
+								{
+									SecretRef: &corev1.SecretEnvSource{
+										LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// releaseLabels are the ResourceLabels of the release Job named name, in the
+// environment of the resources it releases
+func releaseLabels(deployment *models.Deployment, name string) map[string]string {
+	labels := ResourceLabels(deployment, name)
+	labels[LabelEnvironment] = environment(deployment, deployment.K8sDeploymentName)
+	labels[LabelComponent] = ComponentRelease
+	return labels
+}
+
+// RunRelease runs command in deployment's image with envVars as a Job and
+// waits up to timeout for it to finish, returning what it logged. A command
+// that fails or doesn't finish in time returns a *ReleaseError; the Job is
+// kept for inspection and garbage-collected with the project's others after
+// the retention period. Its env Secret is deleted as soon as it's done.
+func (c *Client) RunRelease(ctx context.Context, deployment *models.Deployment, envVars map[string]string, command string, timeout time.Duration) (string, error) {
+	c.pruneReleaseJobs(ctx, deployment.ProjectID)
+
+	name := ReleaseJobName(deployment.ID)
+	secret := BuildSecret(deployment, name, envVars)
+	secret.Labels = releaseLabels(deployment, name)
+	if err := c.applySecret(ctx, secret); err != nil {
+		return "", err
+	}
+	defer c.deleteSecret(context.Background(), name)
+
+	// A retried deployment runs its release again
+	jobs := c.clientset.BatchV1().Jobs(Namespace)
+	if err := c.deleteJob(ctx, name); err != nil {
+		return "", err
+	}
+	if _, err := jobs.Create(ctx, BuildReleaseJob(deployment, command, secret.Name), metav1.CreateOptions{}); err != nil {
+		if errors.IsAlreadyExists(err) {
+			return "", fmt.Errorf("release job %s is still being deleted, retry the deployment", name)
+		}
+		return "", fmt.Errorf("failed to create release job: %w", err)
+	}
+
+	logs, failure, err := c.waitForJob(ctx, name, ComponentRelease, timeout)
+	if err == nil && failure != "" {
+		err = &ReleaseError{Job: name, Reason: failure}
+	}
+	return logs, err
+}
+
+// waitForJob waits up to timeout for the Job named name, whose command runs
+// in container, to finish, and returns what it logged and, when the command
+// failed or didn't finish in time, why. A Job still running at the deadline,
+// or when ctx is done, is deleted; failed ones are kept for inspection.
+func (c *Client) waitForJob(ctx context.Context, name, container string, timeout time.Duration) (logs, failure string, err error) {
+	jobs := c.clientset.BatchV1().Jobs(Namespace)
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get job %s status: %w", name, err)
+		}
+		switch {
+		case job.Status.Succeeded > 0:
+			return c.jobLogs(ctx, name, container), "", nil
+		case job.Status.Failed > 0:
+			logs := c.jobLogs(ctx, name, container)
+			return logs, jobFailure(job, c.jobPod(ctx, name), container), nil
+		case time.Now().After(deadline):
+			logs := c.jobLogs(ctx, name, container)
+			c.deleteJob(context.Background(), name)
+			return logs, fmt.Sprintf("did not finish within %s", timeout), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			c.deleteJob(context.Background(), name)
+			return "", "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// jobFailure describes why a Job whose command runs in container failed,
+// e.g. "exit code 1"
+func jobFailure(job *batchv1.Job, pod *corev1.Pod, container string) string {
+	if pod != nil {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == container && status.State.Terminated != nil {
+				return fmt.Sprintf("exit code %d", status.State.Terminated.ExitCode)
+			}
+		}
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Message != "" {
+			return condition.Message
+		}
+	}
+	return "the job failed"
+}
+
+// jobPod returns the newest pod of the Job named name, nil if none
+func (c *Client) jobPod(ctx context.Context, name string) *corev1.Pod {
+	pods, err := c.clientset.CoreV1().Pods(Namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelApp + "=" + name})
+	if err != nil || len(pods.Items) == 0 {
+		return nil
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.After(pods.Items[j].CreationTimestamp.Time)
+	})
+	return &pods.Items[0]
+}
+
+// jobLogs reads what container of the Job named name logged, up to
+// jobLogBytes; errors give no logs
+func (c *Client) jobLogs(ctx context.Context, name, container string) string {
+	pod := c.jobPod(ctx, name)
+	if pod == nil {
+		return ""
+	}
+	limit := int64(jobLogBytes)
+	stream, err := c.clientset.CoreV1().Pods(Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  container,
+		LimitBytes: &limit,
+	}).Stream(ctx)
+	if err != nil {
+		return ""
+	}
+	defer stream.Close()
+	data, _ := io.ReadAll(io.LimitReader(stream, limit))
+	return string(data)
+}
+
+// deleteJob deletes the Job named name with its pods, if any
+func (c *Client) deleteJob(ctx context.Context, name string) error {
+	background := metav1.DeletePropagationBackground
+	err := c.clientset.BatchV1().Jobs(Namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &background})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete job %s: %v", name, err)
+	}
+	return nil
+}
+
+// pruneReleaseJobs deletes the project's release Jobs older than the
+// retention period, and the Secrets of interrupted ones. Best effort: what
+// fails is pruned on the project's next release.
+func (c *Client) pruneReleaseJobs(ctx context.Context, projectID uint) {
+	selector := projectSelector(projectID)
+	selector.LabelSelector += "," + LabelComponent + "=" + ComponentRelease
+	jobs, err := c.clientset.BatchV1().Jobs(Namespace).List(ctx, selector)
+	if err != nil {
+		return
+	}
+	for _, job := range jobs.Items {
+		if time.Since(job.CreationTimestamp.Time) > releaseJobRetention {
+			c.deleteJob(ctx, job.Name)
+			c.deleteSecret(ctx, job.Name)
+		}
+	}
+}
+
+// deleteReleaseJobs deletes all the project's Jobs: release commands and
+// one-off commands
+func (c *Client) deleteReleaseJobs(ctx context.Context, projectID uint) error {
+	jobs, err := c.clientset.BatchV1().Jobs(Namespace).List(ctx, projectSelector(projectID))
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %v", err)
+	}
+	for _, job := range jobs.Items {
+		if err := c.deleteJob(ctx, job.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
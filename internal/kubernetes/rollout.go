@@ -0,0 +1,254 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	rolloutPollInterval = 2 * time.Second
+	logSampleLines      = 200      // Recent log lines read from a pod to diagnose it
+	logSampleBytes      = 64 << 10 // Cap on the log sample
+
+	// crashLoopRestarts is how many times the app container of a pod the
+	// rollout started may restart in CrashLoopBackOff before the rollout
+	// fails, without waiting out its timeout
+	crashLoopRestarts = 3
+)
+
+// RolloutError fails a deployment whose pods didn't become ready in time
+type RolloutError struct {
+	Name      string
+	Reason    string     // What Kubernetes reported, e.g. the last readiness probe failure
+	Diagnosis *Diagnosis // nil when the cause wasn't recognized
+
+	CrashLoop   bool   // The newest pod's app container is in CrashLoopBackOff
+	Pod         string // The newest pod, "" when there was none
+	Termination string // How its app container last exited, "" if it never did
+	Logs        string // Its app container's recent logs
+}
+
+func (e *RolloutError) Error() string {
+	if e.Diagnosis != nil {
+		return e.Diagnosis.Detail
+	}
+	return fmt.Sprintf("rollout of %s did not become ready: %s", e.Name, e.Reason)
+}
+
+// Category is the models.Failure* category of the failed rollout
+func (e *RolloutError) Category() string {
+	if e.Diagnosis != nil {
+		return e.Diagnosis.Category
+	}
+	if e.CrashLoop {
+		return models.FailureCrashLoop
+	}
+	return models.FailureRolloutTimeout
+}
+
+// WaitForRollout waits until every replica of Deployment name runs the
+// current pod template and passes its readiness probe, or for workers (port
+// 0, no probe) until their app container has started and is running. If
+// that doesn't happen within timeout, or a pod it started crash-loops, the
+// newest pod's probe failures and recent logs are examined and a
+// *RolloutError is returned.
+func (c *Client) WaitForRollout(ctx context.Context, name string, port int32, timeout time.Duration) error {
+	namespace := Namespace
+	started := time.Now().Truncate(time.Second) // Pod creation times are in seconds
+	deadline := started.Add(timeout)
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+
+	for {
+		d, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get deployment status: %w", err)
+		}
+		if rolledOut(d) && (port != 0 || c.containersStarted(ctx, namespace, name)) {
+			return nil
+		}
+		if time.Now().After(deadline) || c.crashLooping(ctx, namespace, name, started) {
+			return c.diagnoseRollout(ctx, namespace, name, int(port))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// rolledOut reports whether d's controller has caught up with its spec and
+// all its replicas are updated and available, with no old ones left
+func rolledOut(d *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	s := d.Status
+	return s.ObservedGeneration >= d.Generation &&
+		s.UpdatedReplicas == replicas &&
+		s.Replicas == replicas &&
+		s.AvailableReplicas == replicas
+}
+
+// containersStarted reports whether the app container of every pod of
+// Deployment name has started and is running
+func (c *Client) containersStarted(ctx context.Context, namespace, name string) bool {
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelApp + "=" + name})
+	if err != nil || len(pods.Items) == 0 {
+		return false
+	}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue // Old pods on their way out
+		}
+		started := false
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == "app" {
+				started = status.Started != nil && *status.Started && status.State.Running != nil
+			}
+		}
+		if !started {
+			return false
+		}
+	}
+	return true
+}
+
+// crashLooping reports whether the app container of a pod of Deployment name
+// created since started is in CrashLoopBackOff and restarted
+// crashLoopRestarts times: it won't come up by retrying. Pods of the
+// previous rollout are left out, they may keep serving.
+func (c *Client) crashLooping(ctx context.Context, namespace, name string, started time.Time) bool {
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelApp + "=" + name})
+	if err != nil {
+		return false
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || pod.CreationTimestamp.Time.Before(started) {
+			continue
+		}
+		app := appStatus(pod)
+		if app != nil && app.State.Waiting != nil && app.State.Waiting.Reason == "CrashLoopBackOff" && app.RestartCount >= crashLoopRestarts {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnoseRollout builds the RolloutError of a rollout that timed out or
+// crash-loops from the newest pod: its readiness probe failures, its state,
+// how it last exited and its logs
+func (c *Client) diagnoseRollout(ctx context.Context, namespace, name string, port int) error {
+	rolloutErr := &RolloutError{Name: name, Reason: fmt.Sprintf("pods not ready on port %d", port)}
+	if port == 0 {
+		rolloutErr.Reason = "worker container not running"
+	}
+
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelApp + "=" + name})
+	if err != nil || len(pods.Items) == 0 {
+		return rolloutErr
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.After(pods.Items[j].CreationTimestamp.Time)
+	})
+	pod := &pods.Items[0]
+	rolloutErr.Pod = pod.Name
+	if app := appStatus(pod); app != nil {
+		rolloutErr.Termination = lastTermination(app)
+		rolloutErr.CrashLoop = app.State.Waiting != nil && app.State.Waiting.Reason == "CrashLoopBackOff"
+	}
+
+	if reason := containerProblem(pod); reason != "" {
+		rolloutErr.Reason = reason
+	} else if probe := c.probeFailure(ctx, namespace, pod.Name); probe != "" {
+		rolloutErr.Reason = probe
+	}
+
+	rolloutErr.Logs = c.sampleLogs(ctx, namespace, pod)
+	// Workers listen on no port, there's nothing in their logs to diagnose
+	if port != 0 {
+		rolloutErr.Diagnosis = DiagnoseLogs(rolloutErr.Logs, port)
+	}
+	return rolloutErr
+}
+
+// containerProblem describes why the app container isn't running, e.g.
+// "CrashLoopBackOff (exit code 1)", or "" when it is
+func containerProblem(pod *corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != "app" || status.State.Waiting == nil {
+			continue
+		}
+		problem := status.State.Waiting.Reason
+		if last := status.LastTerminationState.Terminated; last != nil {
+			problem += fmt.Sprintf(" (exit code %d)", last.ExitCode)
+		}
+		return problem
+	}
+	return ""
+}
+
+// probeFailure returns the message of the pod's latest readiness probe
+// failure, e.g. "Readiness probe failed: dial tcp 10.1.0.7:8080: connect:
+// connection refused", or "" if none was reported
+func (c *Client) probeFailure(ctx context.Context, namespace, podName string) string {
+	events, err := c.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod,involvedObject.name=" + podName + ",reason=Unhealthy",
+	})
+	if err != nil {
+		return ""
+	}
+	message := ""
+	var latest time.Time
+	for _, event := range events.Items {
+		if strings.HasPrefix(event.Message, "Readiness probe failed") && !event.LastTimestamp.Before(latest) {
+			message, latest = strings.TrimSpace(event.Message), event.LastTimestamp.Time
+		}
+	}
+	return message
+}
+
+// sampleLogs reads the last lines the app container logged. A container that
+// restarted and has logged nothing since is sampled from its previous run.
+func (c *Client) sampleLogs(ctx context.Context, namespace string, pod *corev1.Pod) string {
+	logs := c.podLogs(ctx, namespace, pod.Name, false)
+	if strings.TrimSpace(logs) == "" {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == "app" && status.RestartCount > 0 {
+				return c.podLogs(ctx, namespace, pod.Name, true)
+			}
+		}
+	}
+	return logs
+}
+
+// podLogs reads a bounded sample of the app container's recent logs; errors
+// give an empty sample, diagnosis being best effort
+func (c *Client) podLogs(ctx context.Context, namespace, podName string, previous bool) string {
+	tail, limit := int64(logSampleLines), int64(logSampleBytes)
+	stream, err := c.clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container:  "app",
+		Previous:   previous,
+		TailLines:  &tail,
+		LimitBytes: &limit,
+	}).Stream(ctx)
+	if err != nil {
+		return ""
+	}
+	defer stream.Close()
+	data, _ := io.ReadAll(io.LimitReader(stream, limit))
+	return string(data)
+}
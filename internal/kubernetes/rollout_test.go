@@ -0,0 +1,125 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"errors"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// rolloutFixture creates Deployment name in namespace with one replica,
+// rolled out or still waiting for its pod to become ready
+func rolloutFixture(t *testing.T, clientset *fake.Clientset, namespace, name string, ready bool) {
+	t.Helper()
+	replicas := int32(1)
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1},
+	}
+	if ready {
+		d.Status.AvailableReplicas = 1
+	}
+	if _, err := clientset.AppsV1().Deployments(namespace).Create(context.Background(), d, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// appPod creates a pod of Deployment name whose app container is in state
+func appPod(t *testing.T, clientset *fake.Clientset, name, pod string, created time.Time, app corev1.ContainerStatus) {
+	t.Helper()
+	app.Name = "app"
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: pod, Namespace: Namespace, Labels: map[string]string{LabelApp: name}, CreationTimestamp: metav1.NewTime(created)},
+		Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{app}},
+	}
+	if _, err := clientset.CoreV1().Pods(Namespace).Create(context.Background(), p, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// probeFailed records a readiness probe failure of pod at time at
+func probeFailed(t *testing.T, clientset *fake.Clientset, pod, event, message string, at time.Time) {
+	t.Helper()
+	e := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: event, Namespace: Namespace},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod, Namespace: Namespace},
+		Reason:         "Unhealthy",
+		Message:        message,
+		LastTimestamp:  metav1.NewTime(at),
+	}
+	if _, err := clientset.CoreV1().Events(Namespace).Create(context.Background(), e, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForRolloutReady(t *testing.T) {
+	client, clientset := fakeClient()
+	rolloutFixture(t, clientset, Namespace, "project-7", true)
+	if err := client.WaitForRollout(context.Background(), "project-7", 8080, time.Second); err != nil {
+		t.Errorf("rolled out: %v", err)
+	}
+}
+
+// The wait only looks at the platform's namespace: a Deployment of the same
+// name rolled out elsewhere doesn't count, and the timed out rollout is
+// explained by the newest pod's last readiness probe failure
+func TestWaitForRolloutProbeFailure(t *testing.T) {
+	client, clientset := fakeClient()
+	rolloutFixture(t, clientset, "previews", "project-7", true)
+	rolloutFixture(t, clientset, Namespace, "project-7", false)
+
+	now := time.Now()
+	running := corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	appPod(t, clientset, "project-7", "project-7-old", now.Add(-time.Hour), running)
+	appPod(t, clientset, "project-7", "project-7-new", now.Add(-time.Minute), running)
+	probeFailed(t, clientset, "project-7-new", "e1", "Readiness probe failed: Get \"http://10.1.0.7:8080/\": context deadline exceeded", now.Add(-50*time.Second))
+	probeFailed(t, clientset, "project-7-new", "e2", "Readiness probe failed: dial tcp 10.1.0.7:8080: connect: connection refused\n", now.Add(-10*time.Second))
+
+	err := client.WaitForRollout(context.Background(), "project-7", 8080, 0)
+	var rolloutErr *RolloutError
+	if !errors.As(err, &rolloutErr) {
+		t.Fatalf("got %v", err)
+	}
+	if rolloutErr.Pod != "project-7-new" || rolloutErr.Reason != "Readiness probe failed: dial tcp 10.1.0.7:8080: connect: connection refused" {
+		t.Errorf("pod %s: %s", rolloutErr.Pod, rolloutErr.Reason)
+	}
+	// The fake clientset's logs say nothing of a port
+	if rolloutErr.Logs == "" || rolloutErr.Diagnosis != nil || rolloutErr.Category() != models.FailureRolloutTimeout {
+		t.Errorf("logs %q, diagnosis %+v, category %s", rolloutErr.Logs, rolloutErr.Diagnosis, rolloutErr.Category())
+	}
+}
+
+// A pod of the rollout crash-looping fails it before the timeout, with how
+// its app container last exited
+func TestWaitForRolloutCrashLoop(t *testing.T) {
+	client, clientset := fakeClient()
+	rolloutFixture(t, clientset, Namespace, "project-7", false)
+	appPod(t, clientset, "project-7", "project-7-abc12", time.Now().Add(time.Second), corev1.ContainerStatus{
+		RestartCount:         crashLoopRestarts,
+		State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- client.WaitForRollout(context.Background(), "project-7", 8080, time.Hour) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waited for the rollout timeout")
+	}
+	var rolloutErr *RolloutError
+	if !errors.As(err, &rolloutErr) {
+		t.Fatalf("got %v", err)
+	}
+	if !rolloutErr.CrashLoop || rolloutErr.Category() != models.FailureCrashLoop || rolloutErr.Reason != "CrashLoopBackOff (exit code 1)" {
+		t.Errorf("crash loop %v, category %s: %s", rolloutErr.CrashLoop, rolloutErr.Category(), rolloutErr.Reason)
+	}
+}
@@ -0,0 +1,154 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"deploy-platform/internal/models"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnnotationEnvHash on a pod template is the hash of the env Secret its pods
+// were started with: pods restart exactly when the values change
+const AnnotationEnvHash = "deploy-platform.io/env-hash"
+
+// SecretName is the name of the Secret holding the env vars of the resources
+// named name, e.g. project-<id>-env for a project's production Deployment
+func SecretName(name string) string {
+	return name + "-env"
+}
+
+// BuildSecret renders the Secret holding envVars for the resources named
+// name, annotated with EnvHash
+func BuildSecret(deployment *models.Deployment, name string, envVars map[string]string) *corev1.Secret {
+	data := make(map[string][]byte, len(envVars))
+	for k, v := range envVars {
+		data[k] = []byte(v)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        SecretName(name),
+			Namespace:   Namespace,
+			Labels:      ResourceLabels(deployment, name),
+			Annotations: resourceAnnotations(&deployment.Project, map[string]string{AnnotationEnvHash: EnvHash(envVars)}),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
+
+// EnvHash is a digest of envVars, independent of their order
+func EnvHash(envVars map[string]string) string {
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, k := range keys {
+		// Lengths first, so "A=B" "C" and "A" "B=C" differ
+		fmt.Fprintf(hash, "%d:%s%d:%s", len(k), k, len(envVars[k]), envVars[k])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// applySecret creates or updates secret. Its values are never logged nor
+// put in errors.
+func (c *Client) applySecret(ctx context.Context, secret *corev1.Secret) error {
+	secrets := c.clientset.CoreV1().Secrets(Namespace)
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil {
+		if errors.IsAlreadyExists(err) {
+			_, updateErr := secrets.Update(ctx, secret, metav1.UpdateOptions{})
+			if updateErr != nil {
+				return fmt.Errorf("failed to update secret %s: %w", secret.Name, updateErr)
+			}
+		} else {
+			return fmt.Errorf("failed to create secret %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteSecret deletes the env Secret of the resources named name, if any
+func (c *Client) deleteSecret(ctx context.Context, name string) error {
+	err := c.clientset.CoreV1().Secrets(Namespace).Delete(ctx, SecretName(name), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s: %v", SecretName(name), err)
+	}
+	return nil
+}
+
+// PruneSecrets deletes the env Secrets of a project whose resources no
+// deployment uses any more: not in inUse (resource names of the project's
+// remaining deployments) and with no Deployment left in the cluster, so
+// running pods never lose their Secret. Returns how many were deleted.
+func (c *Client) PruneSecrets(ctx context.Context, projectID uint, inUse map[string]bool) (int, error) {
+	secrets, err := c.clientset.CoreV1().Secrets(Namespace).List(ctx, projectSelector(projectID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list secrets: %v", err)
+	}
+	pruned := 0
+	for _, secret := range secrets.Items {
+		name := secret.Labels[LabelApp]
+		// Release and preview Secrets only live while their Job runs
+		if name == "" || secret.Name != SecretName(name) || inUse[name] || secret.Labels[LabelComponent] == ComponentRelease || secret.Labels[LabelComponent] == ComponentPreview {
+			continue
+		}
+		_, err := c.clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			return pruned, fmt.Errorf("failed to get deployment %s: %v", name, err)
+		}
+		if err := c.deleteSecret(ctx, name); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// DeleteProjectResources tears down everything the platform created for a
+// project: the resources of its production, branch and preview deployments,
+// its release Jobs and their env Secrets
+func (c *Client) DeleteProjectResources(ctx context.Context, projectID uint) error {
+	if err := c.deleteReleaseJobs(ctx, projectID); err != nil {
+		return err
+	}
+	selector := projectSelector(projectID)
+	deployments, err := c.clientset.AppsV1().Deployments(Namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %v", err)
+	}
+	for _, d := range deployments.Items {
+		if err := c.DeleteDeployment(ctx, d.Name); err != nil {
+			return err
+		}
+	}
+
+	// Secrets whose Deployment was already gone
+	secrets, err := c.clientset.CoreV1().Secrets(Namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %v", err)
+	}
+	for _, secret := range secrets.Items {
+		if err := c.clientset.CoreV1().Secrets(Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete secret %s: %v", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// projectSelector lists the objects the platform created for a project
+func projectSelector(projectID uint) metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: LabelManagedBy + "=" + ManagedBy + "," + LabelProjectID + "=" + strconv.FormatUint(uint64(projectID), 10)}
+}
@@ -0,0 +1,133 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeClient returns a Client of a fake clientset holding objects
+func fakeClient(objects ...runtime.Object) (*Client, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset(objects...)
+	return &Client{clientset: clientset}, clientset
+}
+
+func webDeployment(projectID uint) *models.Deployment {
+	return &models.Deployment{
+		ProjectID: projectID,
+		ImageTag:  "registry.example.com/app:1",
+		Project:   models.Project{ID: projectID, Slug: "app", ProcessType: models.ProcessWeb},
+	}
+}
+
+// Applying the same values again leaves the pod template as it was, so
+// pods don't restart; changing one value changes it and rolls them
+func TestEnvSecretRollsPodsOnChange(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient()
+	deployment := webDeployment(7)
+	name := ProjectResourceName(7)
+	template := func() metav1.ObjectMeta {
+		t.Helper()
+		d, err := clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Spec.Template.ObjectMeta
+	}
+	secretValue := func(key string) string {
+		t.Helper()
+		secret, err := clientset.CoreV1().Secrets(Namespace).Get(ctx, SecretName(name), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(secret.Data[key])
+	}
+
+	env := map[string]string{"PORT": "8080", "API_KEY": "first"}
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", env); err != nil {
+		t.Fatal(err)
+	}
+	first := template()
+	if first.Annotations[AnnotationEnvHash] != EnvHash(env) || secretValue("API_KEY") != "first" {
+		t.Fatalf("template %v, secret %q", first.Annotations, secretValue("API_KEY"))
+	}
+
+	// The same values, in a map built in another order
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", map[string]string{"API_KEY": "first", "PORT": "8080"}); err != nil {
+		t.Fatal(err)
+	}
+	if again := template(); !reflect.DeepEqual(again, first) {
+		t.Errorf("unchanged values changed the pod template: %v, was %v", again.Annotations, first.Annotations)
+	}
+
+	env["API_KEY"] = "second"
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", env); err != nil {
+		t.Fatal(err)
+	}
+	changed := template()
+	if changed.Annotations[AnnotationEnvHash] == first.Annotations[AnnotationEnvHash] || changed.Annotations[AnnotationEnvHash] != EnvHash(env) {
+		t.Errorf("a changed value left the pod template at %v", changed.Annotations)
+	}
+	if secretValue("API_KEY") != "second" {
+		t.Errorf("the Secret holds %q", secretValue("API_KEY"))
+	}
+}
+
+// Secrets of resources no longer deployed are pruned, those in use stay,
+// and project teardown deletes them all
+func TestSecretLifecycle(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient()
+	production := webDeployment(7)
+	branch := webDeployment(7)
+	branch.K8sDeploymentName = "project-7-feature"
+	other := webDeployment(8)
+	for _, d := range []*models.Deployment{production, branch, other} {
+		if err := client.CreateDeployment(ctx, d, "app.example.com", map[string]string{"PORT": "8080"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secretExists := func(name string) bool {
+		t.Helper()
+		_, err := clientset.CoreV1().Secrets(Namespace).Get(ctx, SecretName(name), metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	// The branch deployment is still running: its Secret stays even when
+	// no deployment record uses it
+	if pruned, err := client.PruneSecrets(ctx, 7, map[string]bool{"project-7": true}); err != nil || pruned != 0 {
+		t.Errorf("pruned %d, %v", pruned, err)
+	}
+	if err := clientset.AppsV1().Deployments(Namespace).Delete(ctx, "project-7-feature", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if pruned, err := client.PruneSecrets(ctx, 7, map[string]bool{"project-7": true}); err != nil || pruned != 1 {
+		t.Errorf("pruned %d, %v", pruned, err)
+	}
+	if secretExists("project-7-feature") || !secretExists("project-7") {
+		t.Error("pruned the wrong Secret")
+	}
+
+	if err := client.DeleteProjectResources(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if secretExists("project-7") {
+		t.Error("project teardown left its Secret")
+	}
+	if _, err := clientset.AppsV1().Deployments(Namespace).Get(ctx, "project-7", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("project teardown left its Deployment: %v", err)
+	}
+	if !secretExists("project-8") {
+		t.Error("another project's Secret was deleted")
+	}
+}
@@ -0,0 +1,61 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WorkloadUsage is what the pods of a platform Deployment request of the
+// cluster right now
+type WorkloadUsage struct {
+	Name          string // Name of the resources it belongs to (LabelApp)
+	ProjectID     uint
+	Environment   string // Environment*, production when not labeled
+	Replicas      int    // Pods running, rolling out ones included; 0 when scaled to zero
+	CPUMillicores int64  // Requested by all of them
+	MemoryMiB     int64
+}
+
+// ListWorkloadUsage lists the resources requested by the pods of every
+// Deployment the platform created for a project
+func (c *Client) ListWorkloadUsage(ctx context.Context) ([]WorkloadUsage, error) {
+	deployments, err := c.clientset.AppsV1().Deployments(Namespace).List(ctx, platformObjectSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %v", err)
+	}
+	usages := make([]WorkloadUsage, 0, len(deployments.Items))
+	for _, d := range deployments.Items {
+		id, err := strconv.ParseUint(d.Labels[LabelProjectID], 10, 64)
+		if err != nil {
+			continue
+		}
+		usage := WorkloadUsage{
+			Name:        d.Labels[LabelApp],
+			ProjectID:   uint(id),
+			Environment: d.Labels[LabelEnvironment],
+			Replicas:    int(d.Status.Replicas),
+		}
+		if usage.Name == "" {
+			usage.Name = d.Name
+		}
+		if usage.Environment == "" {
+			usage.Environment = EnvironmentProduction
+		}
+		var cpu, memory int64
+		for _, container := range d.Spec.Template.Spec.Containers {
+			if quantity, ok := container.Resources.Requests[corev1.ResourceCPU]; ok {
+				cpu += quantity.MilliValue()
+			}
+			if quantity, ok := container.Resources.Requests[corev1.ResourceMemory]; ok {
+				memory += quantity.Value()
+			}
+		}
+		usage.CPUMillicores = cpu * int64(usage.Replicas)
+		usage.MemoryMiB = (memory * int64(usage.Replicas)) >> 20
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// usageDeployment is a Deployment with replicas pods running, each with a
+// container requesting cpu and memory and a sidecar requesting a bit more
+func usageDeployment(name string, labels map[string]string, replicas int32, cpu, memory string) *appsv1.Deployment {
+	requests := func(cpu, memory string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}}
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: Namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Resources: requests(cpu, memory)},
+			{Name: "proxy", Resources: requests("50m", "64Mi")},
+		}}}},
+		Status: appsv1.DeploymentStatus{Replicas: replicas},
+	}
+}
+
+// The requests of every platform Deployment are those of all its pods,
+// rolling out ones included
+func TestListWorkloadUsage(t *testing.T) {
+	platform := func(projectID, app, environment string) map[string]string {
+		labels := map[string]string{LabelManagedBy: ManagedBy, LabelProjectID: projectID, LabelApp: app}
+		if environment != "" {
+			labels[LabelEnvironment] = environment
+		}
+		return labels
+	}
+	client, _ := fakeClient([]runtime.Object{
+		usageDeployment("project-3", platform("3", "project-3", ""), 3, "250m", "256Mi"),
+		usageDeployment("project-3-pr-12", platform("3", "project-3-pr-12", EnvironmentPreview), 1, "1", "1Gi"),
+		usageDeployment("project-4", platform("4", "", EnvironmentBranch), 0, "500m", "512Mi"),
+		// Not the platform's, or not a project's
+		usageDeployment("ingress-nginx", map[string]string{"app": "ingress-nginx"}, 2, "1", "1Gi"),
+		usageDeployment("placeholder", map[string]string{LabelManagedBy: ManagedBy}, 1, "1", "1Gi"),
+	}...)
+
+	usages, err := client.ListWorkloadUsage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Name < usages[j].Name })
+	want := []WorkloadUsage{
+		{Name: "project-3", ProjectID: 3, Environment: EnvironmentProduction, Replicas: 3, CPUMillicores: 3 * 300, MemoryMiB: 3 * 320},
+		{Name: "project-3-pr-12", ProjectID: 3, Environment: EnvironmentPreview, Replicas: 1, CPUMillicores: 1050, MemoryMiB: 1088},
+		{Name: "project-4", ProjectID: 4, Environment: EnvironmentBranch},
+	}
+	if !reflect.DeepEqual(usages, want) {
+		t.Errorf("got %+v\nwant %+v", usages, want)
+	}
+}
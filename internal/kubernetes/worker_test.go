@@ -0,0 +1,104 @@
+package kubernetes
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// assertNotServed fails unless there is neither Service nor Ingress named name
+func assertNotServed(t *testing.T, clientset *fake.Clientset, name string) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := clientset.CoreV1().Services(Namespace).Get(ctx, name, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Service %s: %v", name, err)
+	}
+	if _, err := clientset.NetworkingV1().Ingresses(Namespace).Get(ctx, name, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("Ingress %s: %v", name, err)
+	}
+}
+
+// A worker is rolled out as a Deployment without port nor probe, and no
+// Service or Ingress, and its rollout completes once its container started
+func TestDeployWorker(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient()
+	deployment := webDeployment(7)
+	deployment.Project.ProcessType = models.ProcessWorker
+	name := ProjectResourceName(7)
+
+	if err := client.CreateDeployment(ctx, deployment, "", map[string]string{"QUEUE": "jobs"}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := d.Spec.Template.Spec.Containers[0]
+	if len(container.Ports) != 0 || container.ReadinessProbe != nil {
+		t.Errorf("worker container has ports %v, probe %v", container.Ports, container.ReadinessProbe)
+	}
+	assertNotServed(t, clientset, name)
+
+	// Visibility changes don't give it an Ingress either
+	deployment.K8sDeploymentName = name
+	if err := client.ApplyVisibility(ctx, deployment, "app.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertNotServed(t, clientset, name)
+
+	// The controller rolls it out; its pod starts
+	d.Status.ObservedGeneration = d.Generation
+	d.Status.Replicas, d.Status.UpdatedReplicas, d.Status.AvailableReplicas = 1, 1, 1
+	if _, err := clientset.AppsV1().Deployments(Namespace).Update(ctx, d, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	started := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-abc12", Namespace: Namespace, Labels: map[string]string{LabelApp: name}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:    "app",
+			Started: &started,
+			State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		}}},
+	}
+	if _, err := clientset.CoreV1().Pods(Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WaitForRollout(ctx, name, 0, time.Second); err != nil {
+		t.Errorf("worker rollout: %v", err)
+	}
+}
+
+// A web project turned into a worker loses the Service and Ingress it had
+func TestWebProjectBecomesWorker(t *testing.T) {
+	ctx := context.Background()
+	client, clientset := fakeClient()
+	deployment := webDeployment(7)
+	name := ProjectResourceName(7)
+
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", map[string]string{"PORT": "8080"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Services(Namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("web Service: %v", err)
+	}
+	if _, err := clientset.NetworkingV1().Ingresses(Namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("web Ingress: %v", err)
+	}
+
+	deployment.Project.ProcessType = models.ProcessWorker
+	if err := client.CreateDeployment(ctx, deployment, "app.example.com", map[string]string{"PORT": "8080"}); err != nil {
+		t.Fatal(err)
+	}
+	assertNotServed(t, clientset, name)
+	if _, err := clientset.AppsV1().Deployments(Namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		t.Errorf("worker Deployment: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package logsink
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/metrics"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sendTimeout = 10 * time.Second
+	maxBackoff  = time.Minute // Longest wait between attempts while the sink is down
+)
+
+// Labels of the entries shipped
+const (
+	LabelSource     = "source"     // SourcePlatform or SourceBuild
+	LabelComponent  = "component"  // Package that logged it, e.g. build
+	LabelProject    = "project"    // Project ID
+	LabelDeployment = "deployment" // Deployment ID
+)
+
+// Sources of the entries shipped
+const (
+	SourcePlatform = "platform" // The platform's own logs
+	SourceBuild    = "build"    // The logs of a build, shipped once it is done
+)
+
+// Entry is one log line shipped to the sink
+type Entry struct {
+	Time   time.Time
+	Labels map[string]string
+	Line   string
+}
+
+// Sink receives batches of entries, oldest first. Send returning an error
+// has the batch sent again later.
+type Sink interface {
+	Send(ctx context.Context, entries []Entry) error
+}
+
+// Shipper hands entries to a sink in the background. Entries wait in a
+// bounded buffer: enqueueing never blocks, and when the sink can't keep up
+// the oldest entries are dropped for the new ones.
+type Shipper struct {
+	sink      Sink
+	batchSize int
+	interval  time.Duration
+
+	mu     sync.Mutex
+	buffer []Entry // Ring of the entries waiting, oldest at head
+	head   int
+	size   int
+	full   chan struct{} // Signaled once a batch is waiting
+
+	shipped atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64 // Attempts the sink refused
+}
+
+// NewShipper returns a shipper sending to sink batchSize entries at a time,
+// at least every interval, holding at most capacity entries meanwhile
+func NewShipper(sink Sink, capacity, batchSize int, interval time.Duration) *Shipper {
+	return &Shipper{
+		sink:      sink,
+		batchSize: batchSize,
+		interval:  interval,
+		buffer:    make([]Entry, capacity),
+		full:      make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds entries to be shipped, dropping the oldest waiting when the
+// buffer is full
+func (s *Shipper) Enqueue(entries ...Entry) {
+	s.mu.Lock()
+	for _, entry := range entries {
+		if s.size == len(s.buffer) {
+			s.buffer[s.head] = Entry{}
+			s.head = (s.head + 1) % len(s.buffer)
+			s.size--
+			s.dropped.Add(1)
+		}
+		s.buffer[(s.head+s.size)%len(s.buffer)] = entry
+		s.size++
+	}
+	batched := s.size >= s.batchSize
+	s.mu.Unlock()
+	if batched {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take removes up to n of the oldest entries waiting
+func (s *Shipper) take(n int) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := make([]Entry, 0, min(n, s.size))
+	for len(batch) < n && s.size > 0 {
+		batch = append(batch, s.buffer[s.head])
+		s.buffer[s.head] = Entry{}
+		s.head = (s.head + 1) % len(s.buffer)
+		s.size--
+	}
+	return batch
+}
+
+// putBack returns a batch the sink refused ahead of the entries waiting.
+// Those that no longer fit are dropped, oldest first.
+func (s *Shipper) putBack(batch []Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(batch) - 1; i >= 0; i-- {
+		if s.size == len(s.buffer) {
+			s.dropped.Add(int64(i + 1))
+			return
+		}
+		s.head = (s.head - 1 + len(s.buffer)) % len(s.buffer)
+		s.buffer[s.head] = batch[i]
+		s.size++
+	}
+}
+
+// Start ships entries until ctx is done, then makes a last attempt at those
+// still waiting
+func (s *Shipper) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		backoff := time.Duration(0)
+		for {
+			select {
+			case <-ctx.Done():
+				flushCtx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+				for s.flush(flushCtx) {
+				}
+				cancel()
+				return
+			case <-ticker.C:
+			case <-s.full:
+			}
+			for s.flush(ctx) {
+				if backoff > 0 {
+					log.Printf("✅ Log sink reachable again, shipping resumed")
+					backoff = 0
+				}
+			}
+			if s.pending() > 0 {
+				// The sink refused the batch: wait before trying again
+				if backoff == 0 {
+					log.Printf("⚠️  Log sink unreachable, holding up to %d entries until it is back", len(s.buffer))
+				}
+				backoff = min(max(2*backoff, s.interval), maxBackoff)
+				select {
+				case <-ctx.Done():
+				case <-time.After(backoff):
+				}
+			}
+		}
+	}()
+}
+
+// flush sends one batch, reporting whether there was one and the sink took
+// it. A refused batch is put back.
+func (s *Shipper) flush(ctx context.Context) bool {
+	batch := s.take(s.batchSize)
+	if len(batch) == 0 {
+		return false
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	if err := s.sink.Send(sendCtx, batch); err != nil {
+		s.failed.Add(1)
+		s.putBack(batch)
+		return false
+	}
+	s.shipped.Add(int64(len(batch)))
+	return true
+}
+
+func (s *Shipper) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// RegisterMetrics exports the shipper's counters
+func (s *Shipper) RegisterMetrics() {
+	counter := func(value *atomic.Int64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(value.Load())}}
+		}
+	}
+	metrics.RegisterGauge("deploy_log_sink_shipped_total", "Log entries the log sink accepted since start", counter(&s.shipped))
+	metrics.RegisterGauge("deploy_log_sink_dropped_total", "Log entries dropped since start because the log sink couldn't keep up", counter(&s.dropped))
+	metrics.RegisterGauge("deploy_log_sink_failures_total", "Batches the log sink refused since start, sent again later", counter(&s.failed))
+	metrics.RegisterGauge("deploy_log_sink_buffered", "Log entries waiting for the log sink", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(s.pending())}}
+	})
+}
+
+// New returns the shipper configured by the LOG_SINK_* settings, nil when
+// LOG_SINK_TYPE is empty
+func New(cfg *config.Config) (*Shipper, error) {
+	var sink Sink
+	switch cfg.LogSinkType {
+	case "":
+		return nil, nil
+	case "loki":
+		sink = NewLokiSink(cfg.LogSinkURL, cfg.LogSinkToken)
+	case "http":
+		sink = NewHTTPSink(cfg.LogSinkURL, cfg.LogSinkToken)
+	default:
+		return nil, fmt.Errorf("unknown LOG_SINK_TYPE %q", cfg.LogSinkType)
+	}
+	return NewShipper(sink, cfg.LogSinkBuffer, cfg.LogSinkBatchSize, cfg.LogSinkFlushInterval), nil
+}
@@ -0,0 +1,221 @@
+package logsink
+
+import (
+	"context"
+	"deploy-platform/internal/metrics"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeSink is a collector taking the batches of an HTTPSink. It refuses
+// the first refuse requests, and holds requests while held is open.
+type fakeSink struct {
+	mu      sync.Mutex
+	refuse  int
+	held    chan struct{}
+	auth    string // Authorization of the last request
+	batches chan []httpEntry
+	started chan struct{} // Signaled as each request arrives
+}
+
+// serveSink serves f, returning its URL
+func serveSink(t *testing.T, f *fakeSink) string {
+	t.Helper()
+	f.batches = make(chan []httpEntry, 100)
+	f.started = make(chan struct{}, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.started <- struct{}{}
+		f.mu.Lock()
+		held := f.held
+		f.auth = r.Header.Get("Authorization")
+		refused := f.refuse > 0
+		if refused {
+			f.refuse--
+		}
+		f.mu.Unlock()
+		if held != nil {
+			<-held
+		}
+		if refused {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []httpEntry
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.batches <- batch
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// next returns the next batch the sink took
+func (f *fakeSink) next(t *testing.T) []string {
+	t.Helper()
+	select {
+	case batch := <-f.batches:
+		lines := make([]string, len(batch))
+		for i, entry := range batch {
+			lines[i] = entry.Line
+		}
+		return lines
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch shipped")
+		return nil
+	}
+}
+
+// nothing checks the sink got no batch for a while
+func (f *fakeSink) nothing(t *testing.T) {
+	t.Helper()
+	select {
+	case batch := <-f.batches:
+		t.Errorf("shipped %+v", batch)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// entries are lines labeled as the platform's
+func entries(lines ...string) []Entry {
+	entries := make([]Entry, len(lines))
+	for i, line := range lines {
+		entries[i] = Entry{Time: time.Now(), Labels: map[string]string{LabelSource: SourcePlatform}, Line: line}
+	}
+	return entries
+}
+
+// Entries go out a batch at a time once a batch is waiting, the rest at the
+// flush interval or on shutdown
+func TestShipperBatches(t *testing.T) {
+	fake := &fakeSink{}
+	shipper := NewShipper(NewHTTPSink(serveSink(t, fake), "s3cret"), 100, 3, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	shipper.Start(ctx)
+
+	// Not a batch yet
+	shipper.Enqueue(entries("1", "2")...)
+	fake.nothing(t)
+	shipper.Enqueue(entries("3", "4", "5", "6", "7")...)
+	for _, want := range []string{"1 2 3", "4 5 6", "7"} {
+		if got := strings.Join(fake.next(t), " "); got != want {
+			t.Errorf("batch %q, want %q", got, want)
+		}
+	}
+	if fake.auth != "Bearer s3cret" {
+		t.Errorf("authorization %q", fake.auth)
+	}
+	cancel()
+
+	// What is still waiting on shutdown is sent
+	fake = &fakeSink{}
+	shipper = NewShipper(NewHTTPSink(serveSink(t, fake), ""), 100, 3, time.Hour)
+	ctx, cancel = context.WithCancel(context.Background())
+	shipper.Start(ctx)
+	shipper.Enqueue(entries("8", "9")...)
+	fake.nothing(t)
+	cancel()
+	if got := strings.Join(fake.next(t), " "); got != "8 9" {
+		t.Errorf("on shutdown: %q", got)
+	}
+}
+
+// A slow sink doesn't slow down logging: the buffer keeps the newest
+// entries, and the dropped ones are counted
+func TestShipperBackpressure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := &fakeSink{held: make(chan struct{})}
+	shipper := NewShipper(NewHTTPSink(serveSink(t, fake), ""), 4, 2, time.Hour)
+	shipper.RegisterMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shipper.Start(ctx)
+
+	shipper.Enqueue(entries("1", "2")...)
+	<-fake.started
+	done := make(chan struct{})
+	go func() {
+		for i := 3; i <= 8; i++ {
+			shipper.Enqueue(entries(fmt.Sprint(i))...)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("enqueueing waited for the sink")
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	metrics.Handler(c)
+	for _, metric := range []string{"deploy_log_sink_dropped_total 2\n", "deploy_log_sink_buffered 4\n", "deploy_log_sink_shipped_total 0\n"} {
+		if !strings.Contains(w.Body.String(), metric) {
+			t.Errorf("metrics don't have %q:\n%s", metric, w.Body.String())
+		}
+	}
+
+	close(fake.held)
+	var got []string
+	for len(got) < 6 {
+		got = append(got, fake.next(t)...)
+	}
+	if strings.Join(got, " ") != "1 2 5 6 7 8" {
+		t.Errorf("shipped %v", got)
+	}
+}
+
+// Batches the sink refuses are sent again, in order, once it is back
+func TestShipperRetries(t *testing.T) {
+	fake := &fakeSink{refuse: 2}
+	shipper := NewShipper(NewHTTPSink(serveSink(t, fake), ""), 10, 2, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shipper.Start(ctx)
+
+	shipper.Enqueue(entries("1", "2", "3")...)
+	if got := strings.Join(append(fake.next(t), fake.next(t)...), " "); got != "1 2 3" {
+		t.Errorf("shipped %q", got)
+	}
+	if shipper.failed.Load() != 2 || shipper.dropped.Load() != 0 {
+		t.Errorf("%d failures, %d dropped", shipper.failed.Load(), shipper.dropped.Load())
+	}
+}
+
+// A refused batch goes back ahead of the entries that came meanwhile, as
+// far as the buffer has room
+func TestShipperPutBack(t *testing.T) {
+	lines := func(entries []Entry) string {
+		var lines []string
+		for _, entry := range entries {
+			lines = append(lines, entry.Line)
+		}
+		return strings.Join(lines, " ")
+	}
+
+	shipper := NewShipper(nil, 4, 2, time.Hour)
+	shipper.Enqueue(entries("1", "2", "3")...)
+	batch := shipper.take(2)
+	shipper.Enqueue(entries("4")...)
+	shipper.putBack(batch)
+	if got := lines(shipper.take(4)); got != "1 2 3 4" || shipper.dropped.Load() != 0 {
+		t.Errorf("got %q, %d dropped", got, shipper.dropped.Load())
+	}
+
+	shipper.Enqueue(entries("1", "2", "3")...)
+	batch = shipper.take(2)
+	shipper.Enqueue(entries("4", "5")...)
+	shipper.putBack(batch)
+	if got := lines(shipper.take(4)); got != "2 3 4 5" || shipper.dropped.Load() != 1 {
+		t.Errorf("full buffer: got %q, %d dropped", got, shipper.dropped.Load())
+	}
+}
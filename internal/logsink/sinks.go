@@ -0,0 +1,122 @@
+package logsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postJSON POSTs payload to url, with token as a bearer token when set
+func postJSON(ctx context.Context, client *http.Client, url, token string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("log sink answered %s", resp.Status)
+	}
+	return nil
+}
+
+// LokiSink pushes entries to Loki's push API, one stream per label set
+type LokiSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewLokiSink returns a sink pushing to url, e.g.
+// "http://loki:3100/loki/api/v1/push"
+func NewLokiSink(url, token string) *LokiSink {
+	return &LokiSink{url: url, token: token, client: &http.Client{Timeout: sendTimeout}}
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"` // [unix nanoseconds, line]
+}
+
+func (s *LokiSink) Send(ctx context.Context, entries []Entry) error {
+	streams := make(map[string]*lokiStream)
+	var order []string
+	for _, entry := range entries {
+		key := streamKey(entry.Labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: entry.Labels}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.Time.UnixNano(), 10), entry.Line})
+	}
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{Streams: make([]*lokiStream, 0, len(order))}
+	for _, key := range order {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	return postJSON(ctx, s.client, s.url, s.token, push)
+}
+
+// streamKey identifies a label set
+func streamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, labels[k])
+	}
+	return b.String()
+}
+
+// HTTPSink POSTs entries as a JSON array, for collectors taking generic
+// JSON (Elasticsearch through Logstash or Vector, Fluent Bit...)
+type HTTPSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTPSink returns a sink POSTing to url
+func NewHTTPSink(url, token string) *HTTPSink {
+	return &HTTPSink{url: url, token: token, client: &http.Client{Timeout: sendTimeout}}
+}
+
+// httpEntry is an entry as HTTPSink sends it
+type httpEntry struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Labels    map[string]string `json:"labels"`
+	Line      string            `json:"line"`
+}
+
+func (s *HTTPSink) Send(ctx context.Context, entries []Entry) error {
+	batch := make([]httpEntry, len(entries))
+	for i, entry := range entries {
+		batch[i] = httpEntry{Timestamp: entry.Time.UTC(), Labels: entry.Labels, Line: entry.Line}
+	}
+	return postJSON(ctx, s.client, s.url, s.token, batch)
+}
@@ -0,0 +1,100 @@
+package logsink
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Loki gets a stream per label set, in the order they first appear, each
+// with its lines in order
+func TestLokiSink(t *testing.T) {
+	var push struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		json.NewDecoder(r.Body).Decode(&push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	at := time.Unix(1760000000, 0)
+	build := map[string]string{LabelSource: SourceBuild, LabelProject: "3", LabelDeployment: "12"}
+	platform := map[string]string{LabelSource: SourcePlatform, LabelComponent: "build"}
+	err := NewLokiSink(server.URL, "s3cret").Send(context.Background(), []Entry{
+		{Time: at, Labels: build, Line: "Cloning"},
+		{Time: at.Add(time.Second), Labels: platform, Line: "Deployment 12 built"},
+		{Time: at.Add(2 * time.Second), Labels: map[string]string{LabelDeployment: "12", LabelProject: "3", LabelSource: SourceBuild}, Line: "Pushing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lokiStream{
+		{Stream: build, Values: [][2]string{{"1760000000000000000", "Cloning"}, {"1760000002000000000", "Pushing"}}},
+		{Stream: platform, Values: [][2]string{{"1760000001000000000", "Deployment 12 built"}}},
+	}
+	if !reflect.DeepEqual(push.Streams, want) {
+		t.Errorf("streams %+v, want %+v", push.Streams, want)
+	}
+	if header.Get("Authorization") != "Bearer s3cret" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers %v", header)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	status := http.StatusOK
+	var batch []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("authorization %q without a token", r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&batch)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL, "")
+	at := time.Date(2026, 10, 16, 14, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	entry := Entry{Time: at, Labels: map[string]string{LabelSource: SourceBuild, LabelProject: "3"}, Line: "Cloning"}
+	if err := sink.Send(context.Background(), []Entry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{{
+		"timestamp": "2026-10-16T12:00:00Z",
+		"labels":    map[string]interface{}{"source": "build", "project": "3"},
+		"line":      "Cloning",
+	}}
+	if !reflect.DeepEqual(batch, want) {
+		t.Errorf("batch %v", batch)
+	}
+
+	status = http.StatusTooManyRequests
+	if err := sink.Send(context.Background(), []Entry{entry}); err == nil || err.Error() != "log sink answered 429 Too Many Requests" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cfg := &config.Config{LogSinkURL: "http://loki:3100/loki/api/v1/push", LogSinkBuffer: 10, LogSinkBatchSize: 2, LogSinkFlushInterval: time.Second}
+	if shipper, err := New(cfg); shipper != nil || err != nil {
+		t.Errorf("no LOG_SINK_TYPE: %v, %v", shipper, err)
+	}
+	for sinkType, want := range map[string]interface{}{"loki": &LokiSink{}, "http": &HTTPSink{}} {
+		cfg.LogSinkType = sinkType
+		shipper, err := New(cfg)
+		if err != nil || reflect.TypeOf(shipper.sink) != reflect.TypeOf(want) || len(shipper.buffer) != 10 || shipper.batchSize != 2 {
+			t.Errorf("%s: %+v, %v", sinkType, shipper, err)
+		}
+	}
+	cfg.LogSinkType = "syslog"
+	if _, err := New(cfg); err == nil || err.Error() != `unknown LOG_SINK_TYPE "syslog"` {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package logsink
+
+import (
+	"context"
+	"deploy-platform/internal/buildlogs"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hooks"
+	"deploy-platform/internal/models"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The platform's logs name the deployment or project they are about as
+// "deployment 12" or "project 3"; the first of each becomes a label
+var (
+	deploymentPattern = regexp.MustCompile(`(?i)\bdeployment #?(\d+)\b`)
+	projectPattern    = regexp.MustCompile(`(?i)\bproject #?(\d+)\b`)
+)
+
+// CapturePlatformLogs ships the standard logger's output as well as writing
+// it where it went so far. Lines are labeled with the package that logged
+// them and, when they name them, their deployment and project.
+func (s *Shipper) CapturePlatformLogs() {
+	log.SetOutput(io.MultiWriter(log.Writer(), platformWriter{s}))
+}
+
+// platformWriter turns each line of the standard logger into an entry
+type platformWriter struct {
+	shipper *Shipper
+}
+
+func (w platformWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	labels := map[string]string{LabelSource: SourcePlatform, LabelComponent: callerComponent()}
+	if m := deploymentPattern.FindStringSubmatch(line); m != nil {
+		labels[LabelDeployment] = m[1]
+	}
+	if m := projectPattern.FindStringSubmatch(line); m != nil {
+		labels[LabelProject] = m[1]
+	}
+	w.shipper.Enqueue(Entry{Time: time.Now(), Labels: labels, Line: line})
+	return len(p), nil
+}
+
+// callerComponent is the last element of the package path of the function
+// that logged, e.g. "build" for deploy-platform/internal/build, "main" for
+// the server itself
+func callerComponent() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		dir, name := path.Split(frame.Function)
+		pkg, _, _ := strings.Cut(name, ".")
+		if pkg != "log" && pkg != "io" && pkg != "logsink" && pkg != "" {
+			return path.Base(dir + pkg)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// ShipBuildLogs ships the logs of builds once their deployment is live or
+// failed, unless its project keeps them local
+func (s *Shipper) ShipBuildLogs() {
+	hooks.Register(hooks.Hook{
+		Name:   "log-sink",
+		Events: []string{hooks.EventDeployed, hooks.EventFailed},
+		Run: func(ctx context.Context, event *hooks.Event) error {
+			var project models.Project
+			if err := database.DB.Select("id", "keep_build_logs_local").First(&project, event.Project.ID).Error; err != nil || project.KeepBuildLogsLocal {
+				return nil
+			}
+			var build models.Build
+			if err := database.DB.Select("id", "logs", "logs_object", "completed_at").Where("deployment_id = ?", event.Deployment.ID).
+				Order("id DESC").First(&build).Error; err != nil {
+				return nil
+			}
+			// The build may not have compacted its output yet
+			if err := buildlogs.Collect(database.DB, &build); err != nil || build.Logs == "" {
+				return err
+			}
+			s.Enqueue(buildEntries(&build, event.Project.ID, event.Deployment.ID)...)
+			return nil
+		},
+	})
+}
+
+// buildEntries splits the logs of build into entries, one per line. Lines
+// carry no time of their own: they get the build's completion, a nanosecond
+// apart to keep their order and keep Loki from deduplicating repeated lines.
+func buildEntries(build *models.Build, projectID, deploymentID uint) []Entry {
+	labels := map[string]string{
+		LabelSource:     SourceBuild,
+		LabelComponent:  "build",
+		LabelProject:    strconv.FormatUint(uint64(projectID), 10),
+		LabelDeployment: strconv.FormatUint(uint64(deploymentID), 10),
+	}
+	completed := time.Now()
+	if build.CompletedAt != nil {
+		completed = *build.CompletedAt
+	}
+	lines := strings.Split(strings.TrimRight(build.Logs, "\n"), "\n")
+	entries := make([]Entry, len(lines))
+	for i, line := range lines {
+		entries[i] = Entry{Time: completed.Add(time.Duration(i)), Labels: labels, Line: line}
+	}
+	return entries
+}
@@ -0,0 +1,77 @@
+package logsink
+
+import (
+	"context"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/hooks"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The platform's log lines are shipped labeled with the package logging
+// them and the deployment and project they name, and still logged locally
+func TestPlatformLogs(t *testing.T) {
+	writer := log.Writer()
+	t.Cleanup(func() { log.SetOutput(writer) })
+	shipper := NewShipper(nil, 10, 10, time.Hour)
+	shipper.CapturePlatformLogs()
+
+	hooks.Register(hooks.Hook{Name: "broken", Events: []string{"test.logged"}, Run: func(context.Context, *hooks.Event) error {
+		return errors.New("no route to host")
+	}})
+	hooks.Dispatch(context.Background(), &hooks.Event{Type: "test.logged", Deployment: hooks.DeploymentPayload{ID: 12}})
+	log.Printf("Cleaning up Project #3")
+
+	shipped := shipper.take(10)
+	if len(shipped) != 2 {
+		t.Fatalf("shipped %+v", shipped)
+	}
+	want := map[string]string{LabelSource: SourcePlatform, LabelComponent: "hooks", LabelDeployment: "12"}
+	if !reflect.DeepEqual(shipped[0].Labels, want) || !strings.HasSuffix(shipped[0].Line, " 🪝 Hook broken failed on test.logged of deployment 12: no route to host") {
+		t.Errorf("shipped %+v", shipped[0])
+	}
+	if labels := shipped[1].Labels; labels[LabelProject] != "3" || labels[LabelDeployment] != "" {
+		t.Errorf("labels %v", labels)
+	}
+}
+
+// Build logs are shipped once the deployment is done, one entry per line in
+// order, unless the project keeps them local
+func TestShipBuildLogs(t *testing.T) {
+	testutil.DB(t)
+	shipper := NewShipper(nil, 10, 10, time.Hour)
+	shipper.ShipBuildLogs()
+	completed := time.Now().Add(-time.Minute)
+	build := func(keepLocal bool) hooks.Event {
+		project := &models.Project{Name: "app", KeepBuildLogsLocal: keepLocal}
+		database.DB.Create(project)
+		deployment := &models.Deployment{ProjectID: project.ID, Status: models.StatusDeployed}
+		database.DB.Create(deployment)
+		database.DB.Create(&models.Build{DeploymentID: deployment.ID, Status: "success", Logs: "Cloning\nBuilding\nBuilding\n", CompletedAt: &completed})
+		return hooks.Event{Type: hooks.EventDeployed, Deployment: hooks.DeploymentPayload{ID: deployment.ID}, Project: hooks.ProjectPayload{ID: project.ID}}
+	}
+
+	shipped := build(false)
+	kept := build(true)
+	hooks.Dispatch(context.Background(), &kept)
+	if entries := shipper.take(10); len(entries) != 0 {
+		t.Errorf("shipped the logs of a project keeping them local: %+v", entries)
+	}
+	hooks.Dispatch(context.Background(), &shipped)
+	entries := shipper.take(10)
+	if len(entries) != 3 {
+		t.Fatalf("shipped %+v", entries)
+	}
+	want := map[string]string{LabelSource: SourceBuild, LabelComponent: "build", LabelProject: "1", LabelDeployment: "1"}
+	for i, line := range []string{"Cloning", "Building", "Building"} {
+		if entries[i].Line != line || !reflect.DeepEqual(entries[i].Labels, want) || !entries[i].Time.Equal(completed.Add(time.Duration(i))) {
+			t.Errorf("entry %d: %+v", i, entries[i])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sample is one value of a gauge, with optional labels
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+type gauge struct {
+	name    string
+	help    string
+	collect func() []Sample
+}
+
+var (
+	mu     sync.Mutex
+	gauges []gauge
+)
+
+// RegisterGauge adds a gauge whose samples are read on every scrape
+func RegisterGauge(name, help string, collect func() []Sample) {
+	mu.Lock()
+	defer mu.Unlock()
+	gauges = append(gauges, gauge{name: name, help: help, collect: collect})
+}
+
+// Handler serves all registered gauges in the Prometheus text format
+func Handler(c *gin.Context) {
+	mu.Lock()
+	registered := append([]gauge(nil), gauges...)
+	mu.Unlock()
+
+	var b strings.Builder
+	for _, g := range registered {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, sample := range g.collect() {
+			fmt.Fprintf(&b, "%s%s %g\n", g.name, formatLabels(sample.Labels), sample.Value)
+		}
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -4,23 +4,46 @@ package models
 // This will contain User, Project, Deployment, Build, Environment, and Hostname models
 
 import (
+	"deploy-platform/internal/textutil"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type User struct {
-	ID           uint      `gorm:"primaryKey" json:"id"`                                    // Primary key, auto-increments
-	GitHubID     *int64    `gorm:"column:github_id;uniqueIndex" json:"github_id,omitempty"` // Unique GitHub user ID (nullable)
-	Username     string    `gorm:"uniqueIndex" json:"username"`                             // Unique GitHub username
-	Email        string    `gorm:"uniqueIndex" json:"email"`                                // Unique email
-	PasswordHash string    `gorm:"column:password_hash;type:text" json:"-"`                 // Password hash (hidden from JSON)
-	AvatarURL    string    `json:"avatar_url"`
-	GitHubToken  string    `gorm:"column:github_token;type:text" json:"-"` // GitHub access token (hidden from JSON)
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           uint       `gorm:"primaryKey" json:"id"`                                    // Primary key, auto-increments
+	GitHubID     *int64     `gorm:"column:github_id;uniqueIndex" json:"github_id,omitempty"` // Unique GitHub user ID (nullable)
+	Username     string     `gorm:"uniqueIndex" json:"username"`                             // Unique GitHub username
+	Email        string     `gorm:"uniqueIndex" json:"email"`                                // Unique email
+	PasswordHash string     `gorm:"column:password_hash;type:text" json:"-"`                 // Password hash (hidden from JSON)
+	AvatarURL    string     `json:"avatar_url"`
+	GitHubToken  string     `gorm:"column:github_token;type:text" json:"-"` // GitHub access token (hidden from JSON)
+	GitHubScopes string     `gorm:"column:github_scopes" json:"-"`          // Comma-separated scopes granted to GitHubToken
+	IsAdmin      bool       `gorm:"default:false" json:"is_admin"`          // Platform administrator
+	PlanID       *uint      `gorm:"index" json:"plan_id,omitempty"`         // Quota plan (nil = default plan)
+	ProjectCount int        `gorm:"default:0" json:"project_count"`         // Counter kept in sync for cheap quota checks
+	LockedUntil  *time.Time `json:"-"`                                      // Password sign-in refused until then, after repeated failures
+	LoginEpoch   int        `gorm:"default:0" json:"-"`                     // Bumped to restart the count of failed sign-ins
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 
+	Plan     *Plan     `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
 	Projects []Project `gorm:"foreignKey:UserID" json:"projects,omitempty"` // One-to-many: User has many Projects
 }
 
+// Plan defines the resource quotas for a tier of users. A limit of 0 means unlimited.
+type Plan struct {
+	ID                   uint      `gorm:"primaryKey" json:"id"`
+	Name                 string    `gorm:"uniqueIndex" json:"name"` // e.g. "free", "pro"
+	MaxProjects          int       `json:"max_projects"`
+	MaxDeploymentsPerDay int       `json:"max_deployments_per_day"` // Per project
+	MaxCustomDomains     int       `json:"max_custom_domains"`      // Per project
+	MaxEnvVars           int       `json:"max_env_vars"`            // Per project
+	IsDefault            bool      `gorm:"default:false" json:"is_default"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
 type Project struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
 	UserID      uint      `gorm:"index" json:"user_id"` // Foreign key to User
@@ -34,28 +57,376 @@ type Project struct {
 	CreatedAt   time.Time `json:"created_at"`                 // Creation timestamp
 	UpdatedAt   time.Time `json:"updated_at"`                 // Last update timestamp
 
+	DeploymentsToday int    `gorm:"default:0" json:"deployments_today"` // Deployments created on DeploymentsDay
+	DeploymentsDay   string `gorm:"size:10" json:"-"`                   // UTC date (YYYY-MM-DD) DeploymentsToday refers to
+
+	// Generic Git hosting (Gitea, bare servers): webhook auth and clone credentials
+	WebhookTokenHash string `gorm:"index" json:"-"`                               // SHA-256 of the generic webhook token
+	DeployKeyPublic  string `gorm:"type:text" json:"deploy_key_public,omitempty"` // authorized_keys line to add on the Git server
+	DeployKeyPrivate string `gorm:"type:text" json:"-"`                           // Encrypted PEM private key
+	CloneToken       string `gorm:"type:text" json:"-"`                           // Encrypted HTTPS access token
+
+	ImagePullSecret string `gorm:"size:253" json:"-"` // Secret the project's pods pull images with, set while it has RegistryCredentials
+
+	LatestDeployment *Deployment `gorm:"-" json:"latest_deployment,omitempty"` // Computed: latest live-linkable deployment, see GetProjects
+	CustomDomains    []string    `gorm:"-" json:"-"`                           // Set for rollouts of its production resources, whose Ingress routes them too
+
+	Ingress IngressSettings `gorm:"embedded;embeddedPrefix:ingress_" json:"ingress"` // Ingress tuning, applied on the next deploy
+
+	StrictImageBudget bool `json:"strict_image_budget"` // Fail deployments whose image exceeds the hard size budget
+	Port              int  `json:"port"`                // Port the app listens on, 0 = detected at build time
+
+	GitHubRepoID *int64 `gorm:"column:github_repo_id;index" json:"github_repo_id,omitempty"` // GitHub's repository ID: unlike owner/name, survives renames and transfers
+
+	Visibility  string `gorm:"size:16;default:public" json:"visibility"` // Visibility*
+	InternalURL string `gorm:"-" json:"internal_url,omitempty"`          // Computed: in-cluster URL of an internal project
+	ProcessType string `gorm:"size:16;default:web" json:"process_type"`  // Process*
+
+	ReleaseCommand string `gorm:"type:text" json:"release_command"` // Runs once per deploy in the new image before its rollout, e.g. migrations
+	PublicBadge    bool   `json:"public_badge"`                     // Serve the deploy status badge at /badge/<slug>.svg
+
+	PlaceholderPrivate bool `json:"placeholder_private"` // The placeholder page shown until a deploy is ready names neither the project nor its last deploy
+
+	// Production deployments wait for an approver once built, and are
+	// cancelled unless approved within the window
+	RequireApproval       bool `json:"require_approval"`
+	ApprovalWindowMinutes int  `json:"approval_window_minutes"` // 0 = APPROVAL_WINDOW
+
+	// Custom metadata added to the project's Kubernetes objects (see kubernetes.ValidateCustomMetadata)
+	Labels      map[string]string `gorm:"serializer:json;type:text" json:"custom_labels,omitempty"`
+	Annotations map[string]string `gorm:"serializer:json;type:text" json:"custom_annotations,omitempty"`
+
+	BuildCommands BuildCommands `gorm:"embedded;embeddedPrefix:build_" json:"build_commands"` // Replace auto-detection when set
+
+	RuntimeVersion string `gorm:"size:32" json:"runtime_version"` // Version of the language generated Dockerfiles build on, e.g. "20" for Node.js 20, "" = the one the repository asks for
+
+	ManifestPatches []ManifestPatch `gorm:"serializer:json;type:text" json:"manifest_patches,omitempty"` // Applied to the rendered Kubernetes objects
+
+	KeepBuildLogsLocal bool `json:"keep_build_logs_local"` // Build logs are never shipped to the platform's LOG_SINK, e.g. for data residency
+
+	PreviewProvisioner *PreviewProvisioner `gorm:"serializer:json;type:text" json:"preview_provisioner,omitempty"` // Sets up resources of their own for branch and preview deployments, nil = none
+
+	DeployPolicy *DeployPolicy `gorm:"serializer:json;type:text" json:"deploy_policy,omitempty"` // Checks pushes deploying to production, nil = none
+
+	AnalysisOnly bool `json:"analysis_only"` // Pushes are analyzed (see Analysis) instead of built and deployed, e.g. while the project is being set up
+
+	BranchDeploys bool `json:"branch_deploys"` // Pushes to other branches than Branch deploy to resources and a hostname of their own; off, they are ignored
+
+	Cluster       string `gorm:"size:63" json:"cluster"`                  // Kubernetes cluster deployed to (config.ClusterConfig), "" = DEFAULT_CLUSTER
+	MigratingFrom string `gorm:"size:63" json:"migrating_from,omitempty"` // Cluster being torn down once the project is live on Cluster
+
+	ArchivedAt *time.Time `gorm:"index" json:"archived_at,omitempty"` // Archived projects keep their history but never deploy, see Archived
+
 	User         User          `gorm:"foreignKey:UserID" json:"user,omitempty"`            // One-to-one: Project belongs to User
 	Deployments  []Deployment  `gorm:"foreignKey:ProjectID" json:"deployments,omitempty"`  // One-to-many: Project has many Deployments
 	Environments []Environment `gorm:"foreignKey:ProjectID" json:"environments,omitempty"` // One-to-many: Project has many Environments
 }
 type Deployment struct {
-	ID                uint      `gorm:"primaryKey" json:"id"`
-	ProjectID         uint      `gorm:"index" json:"project_id"`       // Foreign key to Project
-	Status            string    `gorm:"default:pending" json:"status"` // pending, building, deploying, live, failed
-	CommitSHA         string    `json:"commit_sha"`
-	CommitMsg         string    `json:"commit_msg"`
-	Branch            string    `json:"branch"`
-	Hostname          string    `gorm:"index" json:"hostname"` // Hostname (not unique - can be reused per project)
-	ImageTag          string    `json:"image_tag"`
-	K8sNamespace      string    `json:"k8s_namespace"`
-	K8sDeploymentName string    `json:"k8s_deployment_name"` // Kubernetes deployment name
-	CreatedAt         time.Time `json:"created_at"`          // Creation timestamp
-	UpdatedAt         time.Time `json:"updated_at"`          // Last update timestamp
+	ID                uint             `gorm:"primaryKey" json:"id"`
+	ProjectID         uint             `gorm:"index" json:"project_id"`                      // Foreign key to Project
+	Status            DeploymentStatus `gorm:"default:pending" json:"status"`                // See SetDeploymentStatus for allowed transitions
+	CommitSHA         string           `json:"commit_sha"`                                   // Lowercase hex, see NormalizeCommitSHA
+	CommitShortSHA    string           `gorm:"size:16" json:"commit_short_sha"`              // Its first textutil.ShortSHALength characters
+	CommitMsg         string           `json:"commit_msg"`                                   // Cleaned and cut to MaxCommitMsgBytes, see NormalizeCommitMsg
+	CommitMsgDetail   string           `gorm:"type:text" json:"commit_msg_detail,omitempty"` // The whole cleaned message, when CommitMsg was cut
+	Branch            string           `json:"branch"`
+	Hostname          string           `gorm:"index" json:"hostname"` // Hostname (not unique - can be reused per project)
+	ImageTag          string           `json:"image_tag"`
+	K8sNamespace      string           `json:"k8s_namespace"`
+	K8sDeploymentName string           `json:"k8s_deployment_name"` // Kubernetes deployment name
+	CreatedAt         time.Time        `json:"created_at"`          // Creation timestamp
+	UpdatedAt         time.Time        `json:"updated_at"`          // Last update timestamp
 
 	Project Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
 	Build   Build   `gorm:"foreignKey:DeploymentID" json:"build,omitempty"`
+
+	HeartbeatAgeSeconds *int64 `gorm:"-" json:"heartbeat_age_seconds,omitempty"` // Set by the API while building
+	URL                 string `gorm:"-" json:"url,omitempty"`                   // Full URL of Hostname, set by the API
+	ETASeconds          *int64 `gorm:"-" json:"eta_seconds"`                     // Set by the API until it is live: null without enough history
+	ProgressHint        string `gorm:"-" json:"progress_hint,omitempty"`         // Set by the API: what it is doing
+
+	Trigger string `gorm:"default:push" json:"trigger"`     // TriggerPush or TriggerManual
+	Actor   string `json:"actor,omitempty"`                 // Who triggered it: the pusher, or the user who deployed manually
+	Ref     string `json:"ref,omitempty"`                   // Git ref to clone (refs/heads/..., refs/tags/...), "" = Branch, or all branches without one
+	Target  string `gorm:"size:16" json:"target,omitempty"` // Hostname to deploy to, "" = decided by Branch
+
+	FailureCategory string `gorm:"size:32" json:"failure_category,omitempty"` // Why a failed deployment failed, when known (Failure*)
+	FailureDetail   string `gorm:"type:text" json:"failure_detail,omitempty"` // What the user can do about it, when diagnosed
+
+	BuildCommands   *BuildCommands  `gorm:"serializer:json;type:text" json:"build_commands,omitempty"`   // The project's build commands as of the build, nil = detected
+	ManifestPatches []ManifestPatch `gorm:"serializer:json;type:text" json:"manifest_patches,omitempty"` // The project's manifest patches as of the build
+	SecretRefs      []SecretRef     `gorm:"serializer:json;type:text" json:"secret_refs,omitempty"`      // The env vars resolved from secret references as of the rollout
+
+	Cluster string `gorm:"size:63" json:"cluster,omitempty"` // Kubernetes cluster it was deployed to, "" = the default one
+
+	CheckRunID int64 `json:"check_run_id,omitempty"` // GitHub check run reporting it, -1 while one is being created
+
+	PullRequest       int        `gorm:"index" json:"pull_request,omitempty"` // Pull request it previews, or whose merge commit it deploys
+	DelayedByIncident bool       `json:"delayed_by_incident,omitempty"`       // Created or queued during a platform incident: its wait isn't the platform's normal speed
+	DriftedAt         *time.Time `gorm:"index" json:"drifted_at,omitempty"`   // Live according to the database but its objects are gone from the cluster, see the drift package
+
+	// Production approval gate (see Project.RequireApproval)
+	ApprovalExpiresAt *time.Time `gorm:"index" json:"approval_expires_at,omitempty"` // Cancelled then unless approved
+	ApprovedBy        string     `json:"approved_by,omitempty"`                      // Username of the approver
+	ApprovedAt        *time.Time `json:"approved_at,omitempty"`                      // Set: the image is built, the worker only rolls it out
+
+	// What detection found that the rollout needs, kept for rollouts after
+	// the build's work tree is gone: approved deployments, retried deploys
+	// and deploy-only redeploys
+	DetectedPort int               `json:"-"`
+	DetectedEnv  map[string]string `gorm:"serializer:json;type:text" json:"-"`
+
+	// Rollouts of an image built earlier (ImageTag), without cloning or
+	// building: deploys retried while the cluster API was unavailable, and
+	// deploy-only redeploys of another deployment's image (RedeployOf)
+	DeployOnly       bool       `json:"deploy_only,omitempty"`
+	RedeployOf       *uint      `json:"redeploy_of,omitempty"`
+	RolledBackFromID *uint      `json:"rolled_back_from_id,omitempty"`          // Deployment live when this one rolled back to RedeployOf's image
+	DeployAttempts   int        `json:"deploy_attempts,omitempty"`              // Rollouts that failed on a transient cluster API error
+	DeployRetryAt    *time.Time `gorm:"index" json:"deploy_retry_at,omitempty"` // When a deploy_pending deployment's rollout is retried
+
+	PolicyViolations []string `gorm:"serializer:json;type:text" json:"policy_violations,omitempty"` // Deploy policy rules its push broke, see Project.DeployPolicy
+}
+
+// Commit message limits: the stored message is shown in lists and sent in
+// notifications, the detail keeps what a push can reasonably carry
+const (
+	MaxCommitMsgBytes       = 1024
+	MaxCommitMsgDetailBytes = 64 << 10
+)
+
+// NormalizeCommitMsg cleans the deployment's commit message (see
+// textutil.Clean) and cuts it to MaxCommitMsgBytes, keeping the whole of it
+// in CommitMsgDetail when it was cut. A message that was already normalized,
+// e.g. copied from another deployment, is left as is.
+func (d *Deployment) NormalizeCommitMsg() {
+	full := d.CommitMsg
+	if d.CommitMsgDetail != "" {
+		full = d.CommitMsgDetail
+	}
+	full = textutil.Truncate(textutil.Clean(full), MaxCommitMsgDetailBytes)
+	d.CommitMsg = textutil.Truncate(full, MaxCommitMsgBytes)
+	d.CommitMsgDetail = ""
+	if d.CommitMsg != full {
+		d.CommitMsgDetail = full
+	}
+}
+
+// NormalizeCommitSHA checks the deployment's commit SHA (see
+// textutil.NormalizeSHA), lowercases it and sets its short form. Deployments
+// are never created with an invalid one: builds tag images with it.
+func (d *Deployment) NormalizeCommitSHA() error {
+	sha, err := textutil.NormalizeSHA(d.CommitSHA)
+	if err != nil {
+		return err
+	}
+	d.CommitSHA = sha
+	d.CommitShortSHA = textutil.ShortSHA(sha)
+	return nil
+}
+
+// BuildCommands tell the platform how to build and run a project instead of
+// detecting it, like Netlify or Vercel overrides. A Dockerfile is generated
+// from them on an image of Runtime.
+type BuildCommands struct {
+	Runtime        string `json:"runtime,omitempty"`         // node, python, go or ruby, "" = detected from the repository
+	InstallCommand string `json:"install_command,omitempty"` // e.g. "npm ci"
+	BuildCommand   string `json:"build_command,omitempty"`   // e.g. "npm run build"
+	OutputDir      string `json:"output_dir,omitempty"`      // Static sites: directory served once built, e.g. "dist"
+	StartCommand   string `json:"start_command,omitempty"`   // Apps: runs the server, e.g. "node server.js"
+}
+
+// Set reports whether any build command is set, replacing auto-detection
+func (b BuildCommands) Set() bool {
+	return b != BuildCommands{}
+}
+
+// ManifestPatch patches one of the Kubernetes objects a project's
+// deployments are rolled out as, for what no setting covers (tolerations,
+// an extra volume). Fields the platform owns can't be patched, see
+// kubernetes.ValidateManifestPatches.
+type ManifestPatch struct {
+	Kind  string `json:"kind"`  // Deployment, Service or Ingress
+	Type  string `json:"type"`  // ManifestPatchStrategic or ManifestPatchJSON6902
+	Patch string `json:"patch"` // YAML or JSON
+}
+
+// Types of manifest patches
+const (
+	ManifestPatchStrategic = "strategic" // A partial object, merged the way kubectl patch --type strategic does
+	ManifestPatchJSON6902  = "json6902"  // A list of RFC 6902 operations
+)
+
+// SecretRef is an env var a deployment resolved from a secret reference.
+// The hash of the value tells whether the secret changed between two
+// deployments; the value itself is never stored.
+type SecretRef struct {
+	Key       string `json:"key"`
+	Reference string `json:"reference"` // e.g. ref+vault://secret/data/app#password
+	Hash      string `json:"hash"`      // SHA-256 of the resolved value
+}
+
+// PreviewProvisioner gives a project's branch and preview deployments
+// resources of their own, e.g. a database, instead of production's: the
+// env overrides it produces apply to them only. See the preview package.
+type PreviewProvisioner struct {
+	Type            string            `json:"type"`                       // PreviewProvisionerTemplate or PreviewProvisionerCommand
+	Env             map[string]string `json:"env,omitempty"`              // Env var → template of its value, e.g. DATABASE_URL: "{database}_pr_{pr}"
+	Image           string            `json:"image,omitempty"`            // Command: image the commands run in, "" = the deployment's
+	Command         string            `json:"command,omitempty"`          // Command: provisions, printing further overrides as "::env KEY=VALUE" lines
+	TeardownCommand string            `json:"teardown_command,omitempty"` // Command: frees what Command provisioned, "" = nothing to free
+	TimeoutSeconds  int               `json:"timeout_seconds,omitempty"`  // Command: how long each command may run, 0 = 5 minutes
+}
+
+// DeployPolicy restricts the pushes deploying a project to production.
+// Pushes breaking a rule are blocked (StatusPolicyBlocked) until an approver
+// overrides them, or only warned about. See the policy package.
+type DeployPolicy struct {
+	BlockForcePushes       bool     `json:"block_force_pushes"`        // Pushes rewriting the branch's history
+	RequireVerifiedCommits bool     `json:"require_verified_commits"`  // Commits whose signature GitHub didn't verify
+	AllowedPushers         []string `json:"allowed_pushers,omitempty"` // GitHub logins who may deploy, empty = anyone
+	Enforcement            string   `json:"enforcement"`               // PolicyBlock or PolicyWarn
+}
+
+// Enforcements of deploy policies
+const (
+	PolicyBlock = "block" // Broken rules block the deployment
+	PolicyWarn  = "warn"  // Broken rules are recorded on the deployment, which goes ahead
+)
+
+// Types of preview provisioners
+const (
+	PreviewProvisionerTemplate = "template" // Rewrites env vars from templates
+	PreviewProvisionerCommand  = "command"  // Runs a command as a Job, then rewrites env vars from templates
+)
+
+// Failure categories of deployments
+const (
+	FailureImageTooLarge       = "image_too_large"             // Image over the hard size budget of a strict project
+	FailurePortMismatch        = "port_mismatch"               // App listens on another port than the one it's wired up on
+	FailureAddressInUse        = "address_in_use"              // App couldn't bind its port
+	FailureLoopbackOnly        = "loopback_only"               // App listens on 127.0.0.1, unreachable from outside its container
+	FailureRolloutTimeout      = "rollout_timeout"             // Pods didn't become ready in time, cause unknown
+	FailureCrashLoop           = "crash_loop"                  // App container kept exiting at startup, see its last termination in the build logs
+	FailureLFSNotSupported     = "lfs_not_supported"           // Repository uses Git LFS, disabled on the platform
+	FailureReleaseFailed       = "release_failed"              // The project's release command failed or timed out, the running version was left alone
+	FailureHookFailed          = "hook_failed"                 // A blocking lifecycle hook failed
+	FailureSupplyChain         = "supply_chain"                // The image's SBOM or provenance couldn't be recorded under SUPPLY_CHAIN_STRICT
+	FailurePatchInvalid        = "manifest_patch_invalid"      // The project's manifest patches no longer apply, nothing was rolled out
+	FailurePreviewProvisioning = "preview_provisioning_failed" // The project's preview provisioner failed, nothing was rolled out
+	FailureSecretResolution    = "secret_resolution_failed"    // An env var's secret reference couldn't be resolved, nothing was rolled out
+	FailureClusterUnavailable  = "cluster_unavailable"         // The cluster API stayed unreachable through every retry of the rollout, the image is kept
+)
+
+// Project visibilities
+const (
+	VisibilityPublic   = "public"   // Served through an Ingress on public hostnames
+	VisibilityInternal = "internal" // Only a Service, reached by the owner's other projects over the cluster network
+)
+
+// Internal reports whether the project is only reachable inside the cluster
+func (p *Project) Internal() bool {
+	return p.Visibility == VisibilityInternal
+}
+
+// What a project's container runs
+const (
+	ProcessWeb    = "web"    // A server listening on PORT
+	ProcessWorker = "worker" // A background process (e.g. a queue consumer): no port, Service, Ingress nor hostname
+)
+
+// Worker reports whether the project runs a background process instead of a server
+func (p *Project) Worker() bool {
+	return p.ProcessType == ProcessWorker
+}
+
+// Archived reports whether the project was archived: its resources are
+// gone, its hostnames inactive, and it neither deploys nor counts toward
+// its owner's project quota until unarchived
+func (p *Project) Archived() bool {
+	return p.ArchivedAt != nil
+}
+
+// Served reports whether the project gets hostnames and an Ingress: public
+// projects running a server
+func (p *Project) Served() bool {
+	return !p.Internal() && !p.Worker()
+}
+
+// InternalSiblings returns the other internal projects of p's owner, whose
+// in-cluster URLs p's deployments are given. Workers have no URL.
+func InternalSiblings(db *gorm.DB, p *Project) ([]Project, error) {
+	var siblings []Project
+	err := db.Where("user_id = ? AND id <> ? AND visibility = ? AND process_type <> ?", p.UserID, p.ID, VisibilityInternal, ProcessWorker).Order("id").Find(&siblings).Error
+	return siblings, err
+}
+
+// IngressSettings tune a project's ingress; nil fields keep the controller's
+// defaults. Bounds come from the platform config (see kubernetes.ValidateIngressSettings).
+type IngressSettings struct {
+	ProxyReadTimeout *int  `json:"proxy_read_timeout"` // Seconds
+	ProxySendTimeout *int  `json:"proxy_send_timeout"` // Seconds
+	WebSockets       *bool `json:"websockets"`         // Long-lived upgraded connections
+	SessionAffinity  *bool `json:"session_affinity"`   // Cookie-based sticky routing across replicas
+	MaxBodySizeMB    *int  `json:"max_body_size_mb"`
 }
 
+// What created a deployment
+const (
+	TriggerPush        = "push"         // Webhook for a pushed commit
+	TriggerManual      = "manual"       // Requested from the dashboard or API
+	TriggerPullRequest = "pull_request" // Webhook for a pull request opened or reopened without a pushed commit to deploy
+)
+
+// Deployment targets overriding the branch-based hostname choice
+const (
+	TargetProduction = "production" // The project's production hostname, whatever the branch
+	TargetPreview    = "preview"    // A hostname of its own, for this commit only
+)
+
+// DeploymentEvent is one status transition in a deployment's history
+type DeploymentEvent struct {
+	ID           uint             `gorm:"primaryKey" json:"id"`
+	DeploymentID uint             `gorm:"index" json:"deployment_id"`
+	FromStatus   DeploymentStatus `json:"from_status"`
+	ToStatus     DeploymentStatus `json:"to_status"`
+	Reason       string           `gorm:"type:text" json:"reason,omitempty"` // Error message or what triggered the change
+	CreatedAt    time.Time        `json:"created_at"`
+}
+
+// DeploymentActivation is a period a deployment served its environment: from
+// its rollout until the next deployment of the same resources replaced it,
+// or they were removed. Activations copy what was live, so they outlive the
+// deployment.
+type DeploymentActivation struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	ProjectID    uint   `gorm:"index:idx_activation_project" json:"project_id"`
+	DeploymentID uint   `gorm:"index" json:"deployment_id"`
+	Environment  string `gorm:"size:16" json:"environment"` // production, branch or preview
+	Resource     string `gorm:"size:63" json:"resource"`    // K8sDeploymentName of the deployment
+	Cluster      string `gorm:"size:63" json:"cluster,omitempty"`
+	Branch       string `json:"branch,omitempty"`
+	CommitSHA    string `gorm:"size:64" json:"commit_sha"`
+	ImageTag     string `json:"image_tag"`
+	ImageDigest  string `json:"image_digest,omitempty"` // sha256 of the image, "" for images built before it was recorded
+	Hostname     string `json:"hostname,omitempty"`
+	Actor        string `json:"actor,omitempty"`
+
+	ActivatedAt      time.Time  `gorm:"index:idx_activation_project" json:"activated_at"`
+	DeactivatedAt    *time.Time `gorm:"index" json:"deactivated_at"`                // nil while live
+	ReplacedByID     *uint      `json:"replaced_by_id,omitempty"`                   // Deployment that took its place
+	DeactivateReason string     `gorm:"size:32" json:"deactivate_reason,omitempty"` // Deactivate*
+	Backfilled       bool       `json:"backfilled,omitempty"`                       // Reconstructed from the status history: times are approximate
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// Why activations end
+const (
+	DeactivateReplaced = "replaced"         // A newer rollout of the same resources went live
+	DeactivateBranch   = "branch_deleted"   // The branch's resources were removed
+	DeactivateArchived = "project_archived" // All the project's resources were removed
+)
+
 type Build struct {
 	ID           uint       `gorm:"primaryKey" json:"id"`
 	DeploymentID uint       `gorm:"index" json:"deployment_id"`    // Foreign key to Deployment
@@ -65,13 +436,96 @@ type Build struct {
 	CompletedAt  *time.Time `json:"completed_at"`                  // Completion time
 	CreatedAt    time.Time  `json:"created_at"`                    // Creation timestamp
 	UpdatedAt    time.Time  `json:"updated_at"`                    // Last update timestamp
+
+	LastHeartbeatAt  *time.Time `gorm:"index" json:"last_heartbeat_at"` // Touched by the worker while building
+	Framework        string     `json:"framework,omitempty"`            // Detected framework (nextjs, django, rails)
+	FrameworkVersion string     `json:"framework_version,omitempty"`    // Framework version pinned by the app
+	Runtime          string     `json:"runtime,omitempty"`              // Language runtime a generated Dockerfile built on (node, python, go, ruby)
+	RuntimeVersion   string     `json:"runtime_version,omitempty"`      // Its version, the tag of the base image
+	RuntimeSource    string     `json:"runtime_source,omitempty"`       // Where the version came from: a file of the repository, project settings or default
+
+	ImageDigest    string   `json:"image_digest,omitempty"` // sha256:... ID of the built image
+	ImageSizeBytes int64    `json:"image_size_bytes,omitempty"`
+	ImageLayers    int      `json:"image_layers,omitempty"`
+	Warnings       []string `gorm:"serializer:json;type:text" json:"warnings,omitempty"` // Detection hints and image size advice
+
+	// Logs moved to the build log archive: the object holding them gzipped.
+	// Logs is NULL once they are archived. LogsSize is their uncompressed
+	// size, 0 for logs archived before it was recorded.
+	LogsObject     string     `json:"logs_object,omitempty"`
+	LogsArchivedAt *time.Time `json:"logs_archived_at,omitempty"`
+	LogsSize       int64      `json:"logs_size,omitempty"`
+
+	// How the repository was cloned: from the build host's mirror of it
+	// (cache hit), and how long fetching into the mirror took
+	CloneCacheHit bool  `json:"clone_cache_hit"`
+	CloneFetchMs  int64 `json:"clone_fetch_ms,omitempty"`
+
+	// The Dockerfile the image was built from, whether the repository's,
+	// generated or the project's override (then its revision)
+	Dockerfile           string `gorm:"type:text" json:"dockerfile,omitempty"`
+	DockerfileRevisionID *uint  `json:"dockerfile_revision_id,omitempty"`
+	DockerfilePath       string `json:"dockerfile_path,omitempty"` // In the repository, when it is the repository's own
+
+	// Step timing: the step the build is on and since when, how long each
+	// finished step took, and how long the whole was estimated to take when
+	// it started (nil = not enough history)
+	Step             string           `gorm:"size:16" json:"step,omitempty"` // BuildStep*
+	StepStartedAt    *time.Time       `json:"step_started_at,omitempty"`
+	StepMs           map[string]int64 `gorm:"serializer:json;type:text" json:"step_ms,omitempty"`
+	EstimatedSeconds *int64           `json:"estimated_seconds,omitempty"`
+}
+
+// BuildLogChunk is a piece of the output of a running build, written in
+// batches while it streams. Build.Logs is its output, chunks in Seq order,
+// followed by the Logs column; the chunks are compacted into the column
+// once the build finishes.
+type BuildLogChunk struct {
+	ID        uint   `gorm:"primaryKey"`
+	BuildID   uint   `gorm:"uniqueIndex:idx_build_log_chunk"`
+	Seq       int    `gorm:"uniqueIndex:idx_build_log_chunk"`
+	Data      []byte `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// Steps of a build, in order, timed to estimate how long builds take
+const (
+	BuildStepClone  = "clone"
+	BuildStepDetect = "detect"
+	BuildStepImage  = "image"  // docker build, base image pulls included
+	BuildStepVerify = "verify" // Image checks, SBOM and provenance
+	BuildStepPush   = "push"   // Push to REGISTRY_URL, when set
+	BuildStepDeploy = "deploy" // Rollout to Kubernetes
+)
+
+// BuildSteps lists the BuildStep* in order
+var BuildSteps = []string{BuildStepClone, BuildStepDetect, BuildStepImage, BuildStepVerify, BuildStepPush, BuildStepDeploy}
+
+// Supply chain records of a built image, kept apart from Build so listing
+// builds doesn't load them
+const (
+	ArtifactSBOM       = "sbom"       // CycloneDX JSON of the image's packages
+	ArtifactProvenance = "provenance" // in-toto statement of what the image was built from, in a DSSE envelope
+)
+
+// BuildArtifact is a supply chain record of a successful build, gzipped
+type BuildArtifact struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	BuildID   uint      `gorm:"uniqueIndex:idx_build_artifact" json:"build_id"`
+	Kind      string    `gorm:"size:32;uniqueIndex:idx_build_artifact" json:"kind"` // Artifact* kind
+	MediaType string    `json:"media_type"`
+	Data      []byte    `json:"-"`                           // Gzipped content, empty when kept in storage
+	Object    string    `json:"-"`                           // Storage key of the gzipped content, if any
+	Size      int64     `json:"size"`                        // Uncompressed size
+	SHA256    string    `gorm:"column:sha256" json:"sha256"` // Of the uncompressed content
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Environment struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	ProjectID uint      `gorm:"index" json:"project_id"` // Foreign key to Project
 	Key       string    `json:"key"`
-	Value     string    `gorm:"type:text" json:"value"` // In production, encrypt this!
+	Value     string    `gorm:"type:text" json:"value"` // Encrypted, or a secret reference (ref+...) stored as is
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 
@@ -87,6 +541,313 @@ type Hostname struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 
+	Type   string `gorm:"size:16;default:production;index" json:"type"` // production, alias or preview
+	Branch string `gorm:"index" json:"branch,omitempty"`                // Branch an alias follows
+	URL    string `gorm:"-" json:"url,omitempty"`                       // Full URL, set by the API
+
 	Project    Project    `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
 	Deployment Deployment `gorm:"foreignKey:DeploymentID" json:"deployment,omitempty"`
 }
+
+// AuditLog records security-relevant actions, including every request made
+// while an admin impersonates a user
+type AuditLog struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	UserID         uint      `gorm:"index" json:"user_id"`                   // Acting user (the impersonated user during impersonation)
+	ImpersonatorID *uint     `gorm:"index" json:"impersonator_id,omitempty"` // Admin behind the request, if impersonating
+	Action         string    `gorm:"index" json:"action"`                    // e.g. "request", "impersonation.start"
+	Method         string    `json:"method,omitempty"`
+	Path           string    `json:"path,omitempty"`
+	StatusCode     int       `json:"status_code,omitempty"`
+	IP             string    `json:"ip,omitempty"`
+	Details        string    `gorm:"type:text" json:"details,omitempty"`
+	CreatedAt      time.Time `gorm:"index" json:"created_at"`
+}
+
+// ImpersonationSession is an admin's time-boxed view of another user's account
+type ImpersonationSession struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	AdminID      uint       `gorm:"index" json:"admin_id"`
+	TargetUserID uint       `gorm:"index" json:"target_user_id"`
+	Reason       string     `json:"reason,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	Admin      User `gorm:"foreignKey:AdminID" json:"admin,omitempty"`
+	TargetUser User `gorm:"foreignKey:TargetUserID" json:"target_user,omitempty"`
+}
+
+// RateLimitCounter is one window of a rate limit shared by all replicas
+type RateLimitCounter struct {
+	Key       string    `gorm:"primaryKey;size:255"` // Limiter key and window start
+	Count     int64     `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"index"` // Once the window no longer counts
+}
+
+// DockerfileRevision is one saved version of a project's Dockerfile
+// override. The newest revision is in effect; one with empty Content
+// removed the override.
+type DockerfileRevision struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ProjectID uint      `gorm:"index" json:"project_id"`
+	Content   string    `gorm:"type:text" json:"content"`
+	Force     bool      `json:"force"`      // Used even when the repository has a Dockerfile
+	UserID    uint      `json:"user_id"`    // Who saved it
+	CreatedAt time.Time `json:"created_at"` // When it was saved
+}
+
+// DockerfileOverride returns the Dockerfile override in effect for a
+// project, nil if it has none
+func DockerfileOverride(db *gorm.DB, projectID uint) (*DockerfileRevision, error) {
+	var revision DockerfileRevision
+	err := db.Where("project_id = ?", projectID).Order("id DESC").Limit(1).Find(&revision).Error
+	if err != nil || revision.ID == 0 || revision.Content == "" {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// Backup is one encrypted dump of the platform database
+type Backup struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Name        string     `gorm:"uniqueIndex" json:"name"`     // Object name in the backup storage
+	Trigger     string     `gorm:"size:16" json:"trigger"`      // BackupTriggerManual or BackupTriggerSchedule
+	Status      string     `gorm:"size:16;index" json:"status"` // BackupRunning, BackupCompleted, BackupFailed or BackupPruned
+	SizeBytes   int64      `json:"size_bytes"`                  // Size of the encrypted object
+	SHA256      string     `gorm:"size:64" json:"sha256"`       // Checksum of the encrypted object
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// What started a backup
+const (
+	BackupTriggerManual   = "manual"
+	BackupTriggerSchedule = "schedule"
+)
+
+// Backup statuses
+const (
+	BackupRunning   = "running"
+	BackupCompleted = "completed"
+	BackupFailed    = "failed"
+	BackupPruned    = "pruned" // Deleted from storage by retention
+)
+
+// PullRequestPreview tracks a pull request of a project's repository: its
+// preview is the deployment of its head branch, summed up in a single comment
+// on the pull request that is edited as the preview changes
+type PullRequestPreview struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ProjectID      uint      `gorm:"uniqueIndex:idx_pull_request" json:"project_id"`
+	Number         int       `gorm:"uniqueIndex:idx_pull_request" json:"number"`
+	Title          string    `json:"title"`
+	HeadBranch     string    `gorm:"index" json:"head_branch"`
+	HeadSHA        string    `gorm:"size:40" json:"head_sha"`
+	State          string    `gorm:"size:16" json:"state"` // PullRequestOpen, PullRequestMerged or PullRequestClosed
+	MergeCommitSHA string    `gorm:"size:40;index" json:"merge_commit_sha,omitempty"`
+	CommentID      int64     `json:"comment_id,omitempty"` // The platform's comment on it, 0 = not posted yet, -1 = being posted
+	CommentBody    string    `gorm:"type:text" json:"-"`   // What the comment says, so unchanged ones aren't edited again
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Pull request states
+const (
+	PullRequestOpen   = "open"
+	PullRequestMerged = "merged"
+	PullRequestClosed = "closed" // Closed without merging
+)
+
+// Analysis is what the platform would build and run a pushed commit of a
+// project in analysis-only mode with, found by running detection on it
+// without building anything
+type Analysis struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	ProjectID        uint      `gorm:"index:idx_analysis_project" json:"project_id"`
+	CommitSHA        string    `gorm:"size:64" json:"commit_sha"`
+	CommitMsg        string    `json:"commit_msg"` // Cleaned and cut to MaxCommitMsgBytes
+	Branch           string    `json:"branch"`
+	Actor            string    `json:"actor,omitempty"`       // Who pushed
+	Status           string    `gorm:"size:16" json:"status"` // Analysis*
+	Detector         string    `json:"detector,omitempty"`    // How the image would be built: dockerfile, override, compose, node, python, go
+	Framework        string    `json:"framework,omitempty"`
+	FrameworkVersion string    `json:"framework_version,omitempty"`
+	Runtime          string    `json:"runtime,omitempty"`           // Language runtime a generated Dockerfile would build on
+	RuntimeVersion   string    `json:"runtime_version,omitempty"`   // And its version
+	DockerfileSource string    `json:"dockerfile_source,omitempty"` // repository, generated or override
+	Dockerfile       string    `gorm:"type:text" json:"dockerfile,omitempty"`
+	Port             int       `json:"port,omitempty"` // PORT the app would be given, 0 for workers
+	Warnings         []string  `gorm:"serializer:json;type:text" json:"warnings,omitempty"`
+	Error            string    `gorm:"type:text" json:"error,omitempty"` // Why it failed or was superseded
+	DurationMs       int64     `json:"duration_ms"`
+	CreatedAt        time.Time `gorm:"index:idx_analysis_project" json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Analysis statuses
+const (
+	AnalysisRunning    = "running"
+	AnalysisSucceeded  = "succeeded"
+	AnalysisFailed     = "failed"
+	AnalysisSuperseded = "superseded" // The branch moved on before the commit was cloned
+)
+
+// Incident is an outage of a dependency of the platform, recorded by the
+// health checks from its first failed check to the check it recovered on
+type Incident struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Component string     `gorm:"size:32;index" json:"component"` // IncidentDocker, IncidentKubernetes, IncidentDatabase or IncidentRegistry
+	Target    string     `json:"target,omitempty"`               // Which one of the component: a cluster, the registry host
+	Error     string     `gorm:"type:text" json:"error"`         // What the failing check returned, for admins
+	Message   string     `gorm:"type:text" json:"message,omitempty"`
+	StartedAt time.Time  `gorm:"index" json:"started_at"`
+	EndedAt   *time.Time `gorm:"index" json:"ended_at,omitempty"` // nil while ongoing
+	UpdatedBy *uint      `json:"updated_by,omitempty"`            // Admin who last annotated it
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Dependencies incidents are recorded for
+const (
+	IncidentDocker     = "docker"
+	IncidentKubernetes = "kubernetes"
+	IncidentDatabase   = "database"
+	IncidentRegistry   = "registry"
+)
+
+// UsageSample is what the pods of a project's resources requested of a
+// cluster during one sampling period, the basis of cost estimates. A
+// period is sampled once per resources, whichever platform replica does it.
+type UsageSample struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	ProjectID     uint      `gorm:"index:idx_usage_sample_project" json:"project_id"`
+	Cluster       string    `gorm:"size:63;uniqueIndex:idx_usage_sample" json:"cluster"`
+	Name          string    `gorm:"size:63;uniqueIndex:idx_usage_sample" json:"name"` // Name of the resources, K8sDeploymentName
+	Environment   string    `gorm:"size:16" json:"environment"`                       // production, branch or preview
+	PeriodStart   time.Time `gorm:"uniqueIndex:idx_usage_sample;index:idx_usage_sample_project;index" json:"period_start"`
+	Seconds       int       `json:"seconds"`        // Length of the period
+	Replicas      int       `json:"replicas"`       // Pods running, 0 when scaled to zero
+	CPUMillicores int64     `json:"cpu_millicores"` // Requested by all of them
+	MemoryMiB     int64     `gorm:"column:memory_mib" json:"memory_mib"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// PreviewEnvironment is what a project's preview provisioner set up for the
+// resources of a branch or preview, kept so they are torn down with what
+// they were provisioned with, even once its deployments, the provisioner
+// setting or the project are gone
+type PreviewEnvironment struct {
+	ID            uint               `gorm:"primaryKey" json:"id"`
+	ProjectID     uint               `gorm:"uniqueIndex:idx_preview_environment" json:"project_id"`
+	Name          string             `gorm:"size:63;uniqueIndex:idx_preview_environment" json:"name"` // Name of the resources, K8sDeploymentName
+	DeploymentID  uint               `json:"deployment_id"`                                           // Last provisioned for, maybe deleted since
+	Cluster       string             `gorm:"size:63" json:"cluster,omitempty"`                        // Where its commands run
+	Status        string             `gorm:"size:16;index:idx_preview_environment_due" json:"status"` // PreviewEnvironment* status
+	Provisioner   PreviewProvisioner `gorm:"serializer:json;type:text" json:"provisioner"`            // As of the last provisioning
+	Image         string             `json:"image,omitempty"`                                         // Image the teardown command runs in
+	PullSecret    string             `gorm:"size:253" json:"-"`
+	Env           string             `gorm:"type:text" json:"-"` // Encrypted JSON object: the env vars its commands run with
+	Overrides     string             `gorm:"type:text" json:"-"` // Encrypted JSON object: the env vars set on its deployments
+	Attempts      int                `json:"attempts"`           // Teardown attempts so far
+	NextAttemptAt *time.Time         `gorm:"index:idx_preview_environment_due" json:"next_attempt_at,omitempty"`
+	LastError     string             `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+}
+
+// PreviewEnvironment statuses
+const (
+	PreviewEnvironmentProvisioned    = "provisioned"
+	PreviewEnvironmentFailed         = "failed"          // Provisioning failed, maybe halfway: it is torn down all the same
+	PreviewEnvironmentRetiring       = "retiring"        // Its resources were removed, its teardown is due
+	PreviewEnvironmentTeardownFailed = "teardown_failed" // Every teardown attempt failed: left for an admin
+)
+
+// WebhookEvent is a verified GitHub webhook delivery. Events whose processing
+// fails are retried with backoff, then left dead for admins to look into.
+type WebhookEvent struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Event         string     `gorm:"size:64;index" json:"event"`                        // X-GitHub-Event
+	DeliveryID    string     `gorm:"size:64;index" json:"delivery_id,omitempty"`        // X-GitHub-Delivery
+	Payload       []byte     `json:"-"`                                                 // Body as received
+	Status        string     `gorm:"size:16;index:idx_webhook_event_due" json:"status"` // WebhookEvent* status
+	Attempts      int        `json:"attempts"`                                          // Processing attempts, since the last manual retry
+	NextAttemptAt *time.Time `gorm:"index:idx_webhook_event_due" json:"next_attempt_at,omitempty"`
+	FailureClass  string     `gorm:"size:32;index" json:"failure_class,omitempty"` // WebhookFailure* of the last failed attempt
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	LastStatus    int        `json:"last_status,omitempty"` // HTTP status the last attempt answered
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Webhook event statuses
+const (
+	WebhookEventProcessing = "processing"
+	WebhookEventProcessed  = "processed"
+	WebhookEventFailed     = "failed" // Retried at NextAttemptAt
+	WebhookEventDead       = "dead"   // Not retried until an admin asks
+)
+
+// Why processing a webhook event failed
+const (
+	WebhookFailureMalformed       = "malformed"         // Payload not understood: retrying can't help
+	WebhookFailureProjectNotFound = "project_not_found" // No project of the repository, e.g. deleted mid-processing
+	WebhookFailureInternal        = "internal"          // The platform failed: database, hostnames, a panic
+)
+
+// RegistryCredential lets a project's builds pull base images from a private
+// registry, and its pods pull images from it. One per registry host.
+type RegistryCredential struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	ProjectID  uint       `gorm:"uniqueIndex:idx_registry_credential" json:"project_id"`
+	Host       string     `gorm:"size:255;uniqueIndex:idx_registry_credential" json:"host"` // host[:port] as Dockerfiles name it, docker.io for Docker Hub
+	Username   string     `json:"username"`
+	Password   string     `gorm:"type:text" json:"-"`    // Encrypted password or access token
+	VerifiedAt *time.Time `json:"verified_at,omitempty"` // When the registry last accepted them
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"` // When they were last set, i.e. rotated
+}
+
+// Lease makes its holder, a replica, the only one in a role, such as
+// running the singleton background jobs, until it expires unless renewed
+type Lease struct {
+	Name        string    `gorm:"primaryKey;size:64" json:"name"`
+	Holder      string    `json:"holder"` // Config.InstanceID of the replica
+	AcquiredAt  time.Time `json:"acquired_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Transitions int       `json:"transitions"` // Times it changed hands
+}
+
+// JobRun is a run of a background job. Claim is unique: inserting the run
+// claims the job's interval, so no other replica runs the job for it.
+type JobRun struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Job        string     `gorm:"size:64;index:idx_job_run_job" json:"job"`
+	Claim      string     `gorm:"uniqueIndex" json:"-"` // Job and slot, plus the instance for jobs every replica runs
+	Slot       time.Time  `json:"slot"`                 // Start of the interval it ran for
+	Instance   string     `json:"instance"`
+	StartedAt  time.Time  `gorm:"index:idx_job_run_job" json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"` // nil while running, or if its replica stopped
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	Items      int64      `json:"items"`  // What the run processed, as the job counts: builds reclaimed, logs archived...
+	Manual     bool       `json:"manual"` // Run on demand by an admin rather than for its interval
+}
+
+// BackgroundJob is the status of a background job across replicas and
+// restarts, updated by every run of it
+type BackgroundJob struct {
+	Name            string     `gorm:"primaryKey;size:64" json:"name"`
+	LastStartedAt   *time.Time `json:"last_started_at"`
+	LastSucceededAt *time.Time `json:"last_succeeded_at"`
+	LastError       string     `gorm:"type:text" json:"last_error,omitempty"` // Of the last failed run, kept after it succeeds again
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+	LastInstance    string     `json:"last_instance"`   // Replica of the last run
+	ItemsProcessed  int64      `json:"items_processed"` // By the last successful run
+	Runs            int64      `json:"runs"`
+	Failures        int64      `json:"failures"`
+	StaleAlertedAt  *time.Time `json:"stale_alerted_at,omitempty"` // Admins were alerted it is stale; cleared once it succeeds
+	CreatedAt       time.Time  `json:"created_at"`                 // First run
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzNormalizeCommitMsg(f *testing.F) {
+	for _, s := range []string{
+		"Fix the build",
+		"feat: 🚀 launch\r\n\r\nLong body",
+		strings.Repeat("🎉 שלום עולם مرحبا ", 600), // Over MaxCommitMsgBytes
+		"\xff\xfe broken \xc3",
+		"evil\u202egnp.exe\x00\x1b[31m",
+	} {
+		f.Add(s, "")
+		f.Add("", s)
+	}
+	f.Fuzz(func(t *testing.T, msg, detail string) {
+		d := &Deployment{CommitMsg: msg, CommitMsgDetail: detail}
+		d.NormalizeCommitMsg()
+		if !utf8.ValidString(d.CommitMsg) || !utf8.ValidString(d.CommitMsgDetail) {
+			t.Fatalf("%q / %q isn't valid UTF-8", d.CommitMsg, d.CommitMsgDetail)
+		}
+		if len(d.CommitMsg) > MaxCommitMsgBytes || len(d.CommitMsgDetail) > MaxCommitMsgDetailBytes {
+			t.Fatalf("got %d / %d bytes", len(d.CommitMsg), len(d.CommitMsgDetail))
+		}
+		if d.CommitMsgDetail != "" && len(d.CommitMsgDetail) <= MaxCommitMsgBytes {
+			t.Fatalf("detail %q kept for a message that fits", d.CommitMsgDetail)
+		}
+
+		// Normalizing a stored deployment again changes nothing
+		msg, detail = d.CommitMsg, d.CommitMsgDetail
+		d.NormalizeCommitMsg()
+		if d.CommitMsg != msg || d.CommitMsgDetail != detail {
+			t.Fatalf("normalizing again gave %q / %q", d.CommitMsg, d.CommitMsgDetail)
+		}
+	})
+}
+
+func TestNormalizeCommitSHA(t *testing.T) {
+	d := &Deployment{CommitSHA: "0123456789ABCDEF0123456789abcdef01234567"}
+	if err := d.NormalizeCommitSHA(); err != nil {
+		t.Fatal(err)
+	}
+	if d.CommitSHA != "0123456789abcdef0123456789abcdef01234567" || d.CommitShortSHA != "0123456" {
+		t.Errorf("got %s (%s)", d.CommitSHA, d.CommitShortSHA)
+	}
+	for _, sha := range []string{"", "012345", "not-a-sha", "0123456\n"} {
+		if err := (&Deployment{CommitSHA: sha}).NormalizeCommitSHA(); err == nil {
+			t.Errorf("%q accepted", sha)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeploymentStatus is where a deployment is in its lifecycle
+type DeploymentStatus string
+
+const (
+	StatusPending    DeploymentStatus = "pending"   // Created, not handed to a worker yet
+	StatusQueued     DeploymentStatus = "queued"    // Waiting in the build queue
+	StatusBuilding   DeploymentStatus = "building"  // Cloning and building the image
+	StatusDeploying  DeploymentStatus = "deploying" // Rolling the image out to the cluster
+	StatusDeployed   DeploymentStatus = "deployed"  // Serving traffic
+	StatusFailed     DeploymentStatus = "failed"
+	StatusCancelled  DeploymentStatus = "cancelled"
+	StatusSkipped    DeploymentStatus = "skipped"    // Never built, e.g. superseded by a newer commit
+	StatusSuperseded DeploymentStatus = "superseded" // Build cancelled or rollout skipped for a newer deployment of its branch
+
+	StatusAwaitingApproval DeploymentStatus = "awaiting_approval" // Built for production, waiting for an approver before its rollout
+	StatusRejected         DeploymentStatus = "rejected"          // Refused by an approver, never rolled out
+	StatusPolicyBlocked    DeploymentStatus = "policy_blocked"    // Pushed to production against its project's deploy policy, waiting for an approver to override it before its build
+	StatusDeployPending    DeploymentStatus = "deploy_pending"    // Built, its rollout failed on a transient cluster API error: retried later with the same image
+)
+
+// deploymentTransitions lists the statuses each status may move to; statuses
+// without an entry are terminal. Running deployments go back to queued when
+// their worker is restarted. Approved deployments are queued again and go
+// straight to deploying, their image already built; overridden policy
+// blocks are queued for their build. Rollouts the cluster API was
+// unavailable for wait in deploy_pending and go back to deploying;
+// deploy-only redeploys to production await approval straight from queued.
+// Deployments a shutting down replica won't run, queued or running, and
+// those whose worker died go back to pending to be queued again.
+var deploymentTransitions = map[DeploymentStatus][]DeploymentStatus{
+	StatusPending:          {StatusQueued, StatusBuilding, StatusFailed, StatusCancelled, StatusSkipped, StatusPolicyBlocked},
+	StatusPolicyBlocked:    {StatusQueued, StatusRejected, StatusCancelled, StatusSuperseded},
+	StatusQueued:           {StatusBuilding, StatusDeploying, StatusAwaitingApproval, StatusFailed, StatusCancelled, StatusSkipped, StatusPending},
+	StatusBuilding:         {StatusDeploying, StatusAwaitingApproval, StatusFailed, StatusCancelled, StatusQueued, StatusSuperseded, StatusPending},
+	StatusAwaitingApproval: {StatusQueued, StatusRejected, StatusCancelled, StatusSuperseded},
+	StatusDeploying:        {StatusDeployed, StatusFailed, StatusCancelled, StatusQueued, StatusSuperseded, StatusDeployPending, StatusPending},
+	StatusDeployPending:    {StatusDeploying, StatusFailed, StatusCancelled, StatusSuperseded},
+}
+
+// Terminal reports whether no transition leaves s
+func (s DeploymentStatus) Terminal() bool {
+	return len(deploymentTransitions[s]) == 0
+}
+
+// CanTransition reports whether a deployment may move from one status to another
+func CanTransition(from, to DeploymentStatus) bool {
+	return hasStatus(deploymentTransitions[from], to)
+}
+
+// predecessors lists the statuses that may move to s
+func predecessors(s DeploymentStatus) []DeploymentStatus {
+	var from []DeploymentStatus
+	for status, next := range deploymentTransitions {
+		for _, n := range next {
+			if n == s {
+				from = append(from, status)
+			}
+		}
+	}
+	return from
+}
+
+// TransitionError is returned for a status change the state machine forbids,
+// typically a late write from a build that was already failed or cancelled
+type TransitionError struct {
+	DeploymentID uint
+	From         DeploymentStatus
+	To           DeploymentStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("deployment %d cannot go from %s to %s", e.DeploymentID, e.From, e.To)
+}
+
+// SetDeploymentStatus moves a deployment to a new status and records the
+// transition in its history. The check and the update are a single
+// conditional UPDATE, so concurrent writers can't both win. Setting the
+// current status again is a no-op.
+func SetDeploymentStatus(db *gorm.DB, id uint, to DeploymentStatus, reason string) error {
+	return transition(db, id, predecessors(to), to, reason)
+}
+
+// SetDeploymentStatusFrom is SetDeploymentStatus for a deployment that must
+// still be in status from, e.g. to stop a build without touching a rollout
+func SetDeploymentStatusFrom(db *gorm.DB, id uint, from, to DeploymentStatus, reason string) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{DeploymentID: id, From: from, To: to}
+	}
+	return transition(db, id, []DeploymentStatus{from}, to, reason)
+}
+
+// transition moves a deployment to status to if it is in one of the from
+// statuses. The UPDATE is guarded by the status read, so the history row
+// records the status the deployment actually left.
+func transition(db *gorm.DB, id uint, from []DeploymentStatus, to DeploymentStatus, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var current Deployment
+		if err := tx.Select("id", "status").First(&current, id).Error; err != nil {
+			return err
+		}
+		if current.Status == to {
+			return nil
+		}
+		if !hasStatus(from, current.Status) {
+			return &TransitionError{DeploymentID: id, From: current.Status, To: to}
+		}
+
+		result := tx.Model(&Deployment{}).
+			Where("id = ? AND status = ?", id, current.Status).
+			Updates(map[string]interface{}{"status": to, "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Re-read: the status changed since the first read
+			tx.Select("id", "status").First(&current, id)
+			return &TransitionError{DeploymentID: id, From: current.Status, To: to}
+		}
+
+		return tx.Create(&DeploymentEvent{
+			DeploymentID: id,
+			FromStatus:   current.Status,
+			ToStatus:     to,
+			Reason:       reason,
+		}).Error
+	})
+}
+
+func hasStatus(statuses []DeploymentStatus, s DeploymentStatus) bool {
+	for _, status := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package models_test
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestSetDeploymentStatus(t *testing.T) {
+	testutil.DB(t)
+	tests := []struct {
+		from, to models.DeploymentStatus
+		legal    bool
+	}{
+		{models.StatusPending, models.StatusQueued, true},
+		{models.StatusQueued, models.StatusBuilding, true},
+		{models.StatusBuilding, models.StatusDeploying, true},
+		{models.StatusBuilding, models.StatusPending, true}, // Its worker died
+		{models.StatusDeploying, models.StatusDeployed, true},
+		{models.StatusDeploying, models.StatusDeployPending, true},
+		{models.StatusDeployPending, models.StatusDeploying, true},
+		{models.StatusAwaitingApproval, models.StatusRejected, true},
+		{models.StatusPolicyBlocked, models.StatusQueued, true},
+		{models.StatusDeployed, models.StatusDeploying, false}, // Terminal
+		{models.StatusFailed, models.StatusQueued, false},
+		{models.StatusCancelled, models.StatusBuilding, false},
+		{models.StatusPending, models.StatusDeployed, false},
+		{models.StatusQueued, models.StatusDeployed, false},
+		{models.StatusDeploying, models.StatusBuilding, false},
+		{models.StatusRejected, models.StatusQueued, false},
+	}
+	for _, tt := range tests {
+		if models.CanTransition(tt.from, tt.to) != tt.legal {
+			t.Errorf("CanTransition(%s, %s) = %v", tt.from, tt.to, !tt.legal)
+		}
+		d := &models.Deployment{Status: tt.from}
+		database.DB.Create(d)
+		err := models.SetDeploymentStatus(database.DB, d.ID, tt.to, "test")
+
+		var events []models.DeploymentEvent
+		database.DB.Where("deployment_id = ?", d.ID).Find(&events)
+		database.DB.First(d, d.ID)
+		if tt.legal {
+			if err != nil || d.Status != tt.to {
+				t.Errorf("%s -> %s: %v, now %s", tt.from, tt.to, err, d.Status)
+			}
+			if len(events) != 1 || events[0].FromStatus != tt.from || events[0].ToStatus != tt.to {
+				t.Errorf("%s -> %s recorded %+v", tt.from, tt.to, events)
+			}
+			continue
+		}
+		var transitionErr *models.TransitionError
+		if !errors.As(err, &transitionErr) || transitionErr.From != tt.from || transitionErr.To != tt.to {
+			t.Errorf("%s -> %s: got %v", tt.from, tt.to, err)
+		}
+		if d.Status != tt.from || len(events) != 0 {
+			t.Errorf("%s -> %s refused, yet now %s with %+v", tt.from, tt.to, d.Status, events)
+		}
+	}
+
+	// Setting the current status again records nothing
+	d := &models.Deployment{Status: models.StatusDeployed}
+	database.DB.Create(d)
+	if err := models.SetDeploymentStatus(database.DB, d.ID, models.StatusDeployed, ""); err != nil {
+		t.Error(err)
+	}
+	var events int64
+	database.DB.Model(&models.DeploymentEvent{}).Where("deployment_id = ?", d.ID).Count(&events)
+	if events != 0 {
+		t.Errorf("%d events recorded", events)
+	}
+}
+
+// A status written between the read and the UPDATE of a transition fails
+// it, rather than the history recording a status the deployment never left
+func TestSetDeploymentStatusRace(t *testing.T) {
+	testutil.DB(t)
+	d := &models.Deployment{Status: models.StatusBuilding}
+	database.DB.Create(d)
+
+	raced := false
+	database.DB.Callback().Update().Before("gorm:update").Register("test:race", func(db *gorm.DB) {
+		if raced {
+			return
+		}
+		raced = true
+		// The build finishes meanwhile
+		db.Session(&gorm.Session{NewDB: true}).Model(&models.Deployment{}).
+			Where("id = ?", d.ID).UpdateColumn("status", models.StatusDeploying)
+	})
+
+	// Building and deploying may both go back to pending
+	err := models.SetDeploymentStatus(database.DB, d.ID, models.StatusPending, "worker died")
+	var transitionErr *models.TransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.From != models.StatusDeploying {
+		t.Fatalf("got %v", err)
+	}
+	var events int64
+	database.DB.Model(&models.DeploymentEvent{}).Where("deployment_id = ?", d.ID).Count(&events)
+	if events != 0 {
+		t.Errorf("%d events recorded", events)
+	}
+
+}
@@ -0,0 +1,199 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// SplitHost returns the host of host[:port], without the port or the
+// brackets of an IPv6 literal: "[fd00::1]:8080" and "[fd00::1]" give
+// "fd00::1", "example.com:443" gives "example.com". A bare IPv6 literal is
+// returned as is rather than cut at its last colon.
+func SplitHost(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	if strings.HasPrefix(hostport, "[") && strings.HasSuffix(hostport, "]") {
+		return hostport[1 : len(hostport)-1]
+	}
+	return hostport
+}
+
+// URLHost formats host for the host part of a URL, bracketing IPv6 literals
+func URLHost(host string) string {
+	if addr, err := netip.ParseAddr(host); err == nil && addr.Is6() && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// IsIPLiteral reports whether host is an IP address rather than a name,
+// brackets or not
+func IsIPLiteral(host string) bool {
+	_, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	return err == nil
+}
+
+// Special ranges. IPv4 addresses mapped into IPv6 or embedded in NAT64 and
+// 6to4 addresses are classified by the IPv4 address they reach.
+var (
+	privateRanges = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+		netip.MustParsePrefix("fc00::/7"),      // Unique local addresses
+	}
+	reservedRanges = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("192.0.2.0/24"), // Documentation
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("203.0.113.0/24"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+		netip.MustParsePrefix("100::/64"),      // Discard-only
+		netip.MustParsePrefix("2001:db8::/32"), // Documentation
+		netip.MustParsePrefix("fec0::/10"),     // Deprecated site-local
+	}
+	nat64Range = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour  = netip.MustParsePrefix("2002::/16")
+)
+
+// Class is the kind of network an address belongs to
+type Class string
+
+const (
+	ClassPublic      Class = "public"
+	ClassLoopback    Class = "loopback"
+	ClassPrivate     Class = "private"    // RFC 1918, carrier-grade NAT, IPv6 ULA
+	ClassLinkLocal   Class = "link-local" // Including cloud metadata at 169.254.169.254
+	ClassUnspecified Class = "unspecified"
+	ClassMulticast   Class = "multicast"
+	ClassReserved    Class = "reserved"
+)
+
+// Classify tells which kind of network addr belongs to
+func Classify(addr netip.Addr) Class {
+	addr = addr.Unmap().WithZone("")
+	if addr.Is6() {
+		switch {
+		case nat64Range.Contains(addr):
+			raw := addr.As16()
+			return Classify(netip.AddrFrom4([4]byte(raw[12:])))
+		case sixToFour.Contains(addr):
+			raw := addr.As16()
+			return Classify(netip.AddrFrom4([4]byte(raw[2:6])))
+		}
+	}
+	switch {
+	case !addr.IsValid(), addr.IsUnspecified():
+		return ClassUnspecified
+	case addr.IsLoopback():
+		return ClassLoopback
+	case addr.IsLinkLocalUnicast():
+		return ClassLinkLocal
+	case addr.IsMulticast():
+		return ClassMulticast
+	}
+	for _, prefix := range privateRanges {
+		if prefix.Contains(addr) {
+			return ClassPrivate
+		}
+	}
+	for _, prefix := range reservedRanges {
+		if prefix.Contains(addr) {
+			return ClassReserved
+		}
+	}
+	if addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return ClassReserved
+	}
+	return ClassPublic
+}
+
+// IsPublic reports whether addr is reachable on the internet, i.e. not an
+// address of the platform's own networks
+func IsPublic(addr netip.Addr) bool {
+	return Classify(addr) == ClassPublic
+}
+
+// Resolver looks addresses up; *net.Resolver is one
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// DefaultResolver resolves names for Resolve and CheckPublic
+var DefaultResolver Resolver = net.DefaultResolver
+
+// Resolve returns the IPv4 and IPv6 addresses of host, an IP literal or a
+// name, whose CNAMEs the resolver follows. Both A and AAAA records are looked
+// up, and a host with only one of them (e.g. an IPv6-only one) resolves; only
+// a host with neither is an error.
+func Resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.TrimSuffix(SplitHost(host), ".")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.WithZone("")}, nil
+	}
+	if host == "" {
+		return nil, errors.New("no host to resolve")
+	}
+
+	v4, err4 := DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	v6, err6 := DefaultResolver.LookupNetIP(ctx, "ip6", host)
+	addrs := make([]netip.Addr, 0, len(v4)+len(v6))
+	for _, addr := range append(v4, v6...) {
+		addrs = append(addrs, addr.Unmap())
+	}
+	if len(addrs) == 0 {
+		err := err4
+		if err == nil || isNotFound(err) {
+			err = err6
+		}
+		if err == nil {
+			err = fmt.Errorf("%s has no A or AAAA records", host)
+		}
+		return nil, err
+	}
+	return addrs, nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// PrivateAddressError refuses a host resolving to an address of the
+// platform's own networks
+type PrivateAddressError struct {
+	Host  string
+	Addr  netip.Addr
+	Class Class
+}
+
+func (e *PrivateAddressError) Error() string {
+	if e.Host == e.Addr.String() {
+		return fmt.Sprintf("%s is a %s address", e.Host, e.Class)
+	}
+	return fmt.Sprintf("%s resolves to %s, a %s address", e.Host, e.Addr, e.Class)
+}
+
+// CheckPublic resolves host (see Resolve) and returns a *PrivateAddressError
+// unless every address it has is public: a dual-stack name with one private
+// family would let requests through to it.
+func CheckPublic(ctx context.Context, host string) error {
+	addrs, err := Resolve(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if class := Classify(addr); class != ClassPublic {
+			return &PrivateAddressError{Host: SplitHost(host), Addr: addr, Class: class}
+		}
+	}
+	return nil
+}
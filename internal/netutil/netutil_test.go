@@ -0,0 +1,304 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// zone is a resolver answering from records: A and AAAA addresses by name,
+// and CNAMEs followed to their target as a recursive resolver does
+type zone struct {
+	a, aaaa map[string][]string
+	cname   map[string]string
+	fail    error // Answered for every lookup, when set
+}
+
+func (z *zone) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if z.fail != nil {
+		return nil, z.fail
+	}
+	for hops := 0; z.cname[host] != ""; hops++ {
+		if hops > 8 {
+			return nil, &net.DNSError{Err: "too many CNAMEs", Name: host}
+		}
+		host = z.cname[host]
+	}
+	records := z.a
+	if network == "ip6" {
+		records = z.aaaa
+	}
+	if len(records[host]) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []netip.Addr
+	for _, s := range records[host] {
+		addrs = append(addrs, netip.MustParseAddr(s))
+	}
+	return addrs, nil
+}
+
+// noRecords answers every lookup with no addresses and no error
+type noRecords struct{}
+
+func (noRecords) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return nil, nil
+}
+
+// useZone resolves names from z for the rest of the test
+func useZone(t *testing.T, z Resolver) {
+	t.Helper()
+	previous := DefaultResolver
+	DefaultResolver = z
+	t.Cleanup(func() { DefaultResolver = previous })
+}
+
+var testZone = &zone{
+	a: map[string][]string{
+		"v4.example.com":          {"93.184.216.34"},
+		"dual.example.com":        {"93.184.216.34"},
+		"split.example.com":       {"93.184.216.34"},
+		"internal.example.com":    {"10.0.4.2"},
+		"metadata.example.com":    {"169.254.169.254"},
+		"edge.cdn.example.net":    {"151.101.1.69"},
+		"mapped.example.com":      {"::ffff:8.8.8.8"},
+		"private.cdn.example.net": {"192.168.1.20"},
+	},
+	aaaa: map[string][]string{
+		"v6.example.com":       {"2606:4700::1111"},
+		"dual.example.com":     {"2606:2800:220:1:248:1893:25c8:1946"},
+		"split.example.com":    {"fd12:3456::1"},
+		"ula.example.com":      {"fd00::5"},
+		"edge.cdn.example.net": {"2a04:4e42::261"},
+	},
+	cname: map[string]string{
+		"www.example.com":      "app.example.com",
+		"app.example.com":      "app.cdn.example.net",
+		"app.cdn.example.net":  "edge.cdn.example.net",
+		"sneaky.example.com":   "private.cdn.example.net",
+		"loop.example.com":     "loop2.example.com",
+		"loop2.example.com":    "loop.example.com",
+		"dangling.example.com": "gone.example.net",
+	},
+}
+
+func TestSplitHost(t *testing.T) {
+	tests := []struct {
+		hostport string
+		want     string
+	}{
+		{"example.com", "example.com"},
+		{"example.com:443", "example.com"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"10.0.0.1:8080", "10.0.0.1"},
+		{"fd00::1", "fd00::1"},
+		{"[fd00::1]", "fd00::1"},
+		{"[fd00::1]:8080", "fd00::1"},
+		{"[fe80::1%eth0]:80", "fe80::1%eth0"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := SplitHost(tt.hostport); got != tt.want {
+			t.Errorf("SplitHost(%q) = %q, want %q", tt.hostport, got, tt.want)
+		}
+	}
+}
+
+func TestURLHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"::1", "[::1]"},
+		{"2001:db8::1", "[2001:db8::1]"},
+		{"[2001:db8::1]", "[2001:db8::1]"},
+		{"::ffff:10.0.0.1", "[::ffff:10.0.0.1]"},
+	}
+	for _, tt := range tests {
+		if got := URLHost(tt.host); got != tt.want {
+			t.Errorf("URLHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestIsIPLiteral(t *testing.T) {
+	for host, want := range map[string]bool{
+		"192.168.1.10":    true,
+		"2001:db8::1":     true,
+		"[::1]":           true,
+		"example.com":     false,
+		"10.0.0.1.nip.io": false,
+		"[example.com]":   false,
+		"":                false,
+	} {
+		if got := IsIPLiteral(host); got != want {
+			t.Errorf("IsIPLiteral(%q) = %v", host, got)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		addr string
+		want Class
+	}{
+		// IPv4
+		{"8.8.8.8", ClassPublic},
+		{"93.184.216.34", ClassPublic},
+		{"127.0.0.1", ClassLoopback},
+		{"10.1.2.3", ClassPrivate},
+		{"172.16.0.1", ClassPrivate},
+		{"172.32.0.1", ClassPublic},
+		{"192.168.1.1", ClassPrivate},
+		{"100.64.0.1", ClassPrivate},
+		{"169.254.169.254", ClassLinkLocal},
+		{"0.0.0.0", ClassUnspecified},
+		{"224.0.0.251", ClassMulticast},
+		{"192.0.2.1", ClassReserved},
+		{"198.51.100.7", ClassReserved},
+		{"203.0.113.9", ClassReserved},
+		{"240.0.0.1", ClassReserved},
+		{"255.255.255.255", ClassReserved},
+		// IPv6
+		{"2606:4700::1111", ClassPublic},
+		{"::1", ClassLoopback},
+		{"::", ClassUnspecified},
+		{"fd00::1", ClassPrivate},
+		{"fc00::1", ClassPrivate},
+		{"fe80::1", ClassLinkLocal},
+		{"fe80::1%eth0", ClassLinkLocal},
+		{"ff02::1", ClassMulticast},
+		{"2001:db8::1", ClassReserved},
+		{"100::1", ClassReserved},
+		{"fec0::1", ClassReserved},
+		// IPv4 reached through IPv6
+		{"::ffff:10.0.0.1", ClassPrivate},
+		{"::ffff:127.0.0.1", ClassLoopback},
+		{"::ffff:8.8.8.8", ClassPublic},
+		{"64:ff9b::a00:1", ClassPrivate},
+		{"64:ff9b::a9fe:a9fe", ClassLinkLocal},
+		{"64:ff9b::808:808", ClassPublic},
+		{"2002:a00:1::", ClassPrivate},
+		{"2002:7f00:1::1", ClassLoopback},
+		{"2002:808:808::", ClassPublic},
+	}
+	for _, tt := range tests {
+		addr := netip.MustParseAddr(tt.addr)
+		if got := Classify(addr); got != tt.want {
+			t.Errorf("Classify(%s) = %s, want %s", tt.addr, got, tt.want)
+		}
+		if IsPublic(addr) != (tt.want == ClassPublic) {
+			t.Errorf("IsPublic(%s) = %v", tt.addr, IsPublic(addr))
+		}
+	}
+	if got := Classify(netip.Addr{}); got != ClassUnspecified {
+		t.Errorf("Classify of the zero Addr = %s", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	useZone(t, testZone)
+	tests := []struct {
+		name string
+		host string
+		want []string
+	}{
+		{"v4 only", "v4.example.com", []string{"93.184.216.34"}},
+		{"v6 only", "v6.example.com", []string{"2606:4700::1111"}},
+		{"dual-stack", "dual.example.com", []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"}},
+		{"CNAME chain", "www.example.com", []string{"151.101.1.69", "2a04:4e42::261"}},
+		{"port and trailing dot", "www.example.com.:443", []string{"151.101.1.69", "2a04:4e42::261"}},
+		{"mapped answer", "mapped.example.com", []string{"8.8.8.8"}},
+		{"v4 literal", "10.0.0.1", []string{"10.0.0.1"}},
+		{"v6 literal", "fd00::1", []string{"fd00::1"}},
+		{"bracketed literal with port", "[2001:db8::1]:8443", []string{"2001:db8::1"}},
+		{"literal with zone", "[fe80::1%eth0]", []string{"fe80::1"}},
+	}
+	for _, tt := range tests {
+		addrs, err := Resolve(context.Background(), tt.host)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, addr := range addrs {
+			got = append(got, addr.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Resolve(%q) = %v, want %v", tt.name, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	useZone(t, testZone)
+	for _, host := range []string{"missing.example.com", "dangling.example.com", "loop.example.com"} {
+		if addrs, err := Resolve(context.Background(), host); err == nil {
+			t.Errorf("%s resolved to %v", host, addrs)
+		}
+	}
+	var dnsErr *net.DNSError
+	if _, err := Resolve(context.Background(), "missing.example.com"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("NXDOMAIN: got %v", err)
+	}
+	if _, err := Resolve(context.Background(), ""); err == nil || err.Error() != "no host to resolve" {
+		t.Errorf("empty host: got %v", err)
+	}
+
+	// A failing resolver's error is returned rather than "not found"
+	timeout := &net.DNSError{Err: "i/o timeout", Name: "v4.example.com", IsTimeout: true}
+	useZone(t, &zone{fail: timeout})
+	if _, err := Resolve(context.Background(), "v4.example.com"); !errors.Is(err, timeout) {
+		t.Errorf("failing resolver: got %v", err)
+	}
+
+	// Answered, but with neither family
+	useZone(t, noRecords{})
+	if _, err := Resolve(context.Background(), "example.com"); err == nil || err.Error() != "example.com has no A or AAAA records" {
+		t.Errorf("no records: got %v", err)
+	}
+}
+
+func TestCheckPublic(t *testing.T) {
+	useZone(t, testZone)
+	tests := []struct {
+		host string
+		err  string // Empty when public
+	}{
+		{"v4.example.com", ""},
+		{"v6.example.com", ""},
+		{"dual.example.com", ""},
+		{"www.example.com:443", ""},
+		{"8.8.8.8", ""},
+		{"internal.example.com", "internal.example.com resolves to 10.0.4.2, a private address"},
+		{"metadata.example.com", "metadata.example.com resolves to 169.254.169.254, a link-local address"},
+		{"ula.example.com", "ula.example.com resolves to fd00::5, a private address"},
+		// One private family is enough to refuse a dual-stack name
+		{"split.example.com", "split.example.com resolves to fd12:3456::1, a private address"},
+		{"sneaky.example.com", "sneaky.example.com resolves to 192.168.1.20, a private address"},
+		{"127.0.0.1", "127.0.0.1 is a loopback address"},
+		{"[::1]:8080", "::1 is a loopback address"},
+		{"[64:ff9b::a9fe:a9fe]", "64:ff9b::a9fe:a9fe is a link-local address"},
+	}
+	for _, tt := range tests {
+		err := CheckPublic(context.Background(), tt.host)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.host, err)
+			}
+			continue
+		}
+		var private *PrivateAddressError
+		if !errors.As(err, &private) || err.Error() != tt.err {
+			t.Errorf("%s: got %v, want %q", tt.host, err, tt.err)
+		}
+	}
+	if err := CheckPublic(context.Background(), "missing.example.com"); err == nil {
+		t.Errorf("unresolvable host accepted")
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"deploy-platform/internal/auth"
 	"deploy-platform/internal/config"
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/httpclient"
 	"deploy-platform/internal/models"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -21,6 +24,9 @@ import (
 
 var googleOAuthConfig *oauth2.Config
 
+// googleHTTP carries every call to Google's OAuth endpoints and API
+var googleHTTP = httpclient.New("google")
+
 // InitGoogleOAuth initializes Google OAuth configuration
 func InitGoogleOAuth(cfg *config.Config) {
 	if cfg.GoogleClientID == "" || cfg.GoogleClientSecret == "" {
@@ -41,14 +47,13 @@ func InitGoogleOAuth(cfg *config.Config) {
 // HandleGoogleLogin initiates Google OAuth flow
 func HandleGoogleLogin(c *gin.Context) {
 	if googleOAuthConfig == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Google OAuth not configured",
-		})
+		auth.LoginError(c, auth.LoginMisconfigured, "Google", "", errors.New("GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET are not set"))
 		return
 	}
 
 	state := generateState()
-	c.SetCookie("oauth_state", state, 600, "/", "", false, true)
+	auth.SetCookie(c, "oauth_state", state, 600)
+	auth.RememberNext(c)
 
 	url := googleOAuthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
 	c.Redirect(http.StatusTemporaryRedirect, url)
@@ -56,44 +61,57 @@ func HandleGoogleLogin(c *gin.Context) {
 
 // HandleGoogleCallback handles Google OAuth callback
 func HandleGoogleCallback(c *gin.Context) {
+	if googleOAuthConfig == nil {
+		auth.LoginError(c, auth.LoginMisconfigured, "Google", "", errors.New("GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET are not set"))
+		return
+	}
+	if auth.LoginProviderError(c, "Google", "/auth/google") {
+		return
+	}
+
 	state := c.Query("state")
 	cookieState, _ := c.Cookie("oauth_state")
 
-	if state != cookieState {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+	if state == "" || state != cookieState {
+		auth.LoginError(c, auth.LoginExpired, "Google", "/auth/google", errors.New("invalid state"))
 		return
 	}
 
 	code := c.Query("code")
 	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code not provided"})
+		auth.LoginError(c, auth.LoginExpired, "Google", "/auth/google", errors.New("authorization code not provided"))
 		return
 	}
 
-	token, err := googleOAuthConfig.Exchange(context.Background(), code)
+	// The user is waiting: every call to Google shares one budget
+	ctx, cancel := context.WithTimeout(c.Request.Context(), httpclient.CallTimeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, googleHTTP)
+
+	token, err := googleOAuthConfig.Exchange(ctx, code)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code for token: " + err.Error()})
+		auth.LoginFailed(c, "Google", "/auth/google", fmt.Errorf("exchanging the code for a token: %w", err))
 		return
 	}
 
 	// Get user info from Google
-	client := googleOAuthConfig.Client(context.Background(), token)
-	service, err := googleOAuth2.NewService(context.Background(), option.WithHTTPClient(client))
+	client := googleOAuthConfig.Client(ctx, token)
+	service, err := googleOAuth2.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Google service: " + err.Error()})
+		auth.LoginError(c, auth.LoginInternal, "Google", "/auth/google", fmt.Errorf("creating the Google service: %w", err))
 		return
 	}
 
-	userInfo, err := service.Userinfo.Get().Do()
+	userInfo, err := service.Userinfo.Get().Context(ctx).Do()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info: " + err.Error()})
+		auth.LoginFailed(c, "Google", "/auth/google", fmt.Errorf("getting user info: %w", err))
 		return
 	}
 
 	// Create or update user
 	email := userInfo.Email
 	if email == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Email not provided by Google"})
+		auth.LoginError(c, auth.LoginEmailMissing, "Google", "/auth/google", errors.New("email not provided by Google"))
 		return
 	}
 
@@ -108,14 +126,23 @@ func HandleGoogleCallback(c *gin.Context) {
 		AvatarURL: userInfo.Picture,
 	}
 
+	// Enforce ALLOWED_EMAIL_DOMAINS (existing users only with STRICT_REVALIDATE)
+	verified := userInfo.VerifiedEmail != nil && *userInfo.VerifiedEmail
+	allowErr := auth.CheckEmailDomain(email, verified, userInfo.Hd)
+
 	// Check if user exists by email
 	var existingUser models.User
 	result := database.DB.Where("email = ?", email).First(&existingUser)
 
+	if allowErr != nil && (result.Error != nil || auth.StrictRevalidate()) {
+		auth.LoginDenied(c, email, allowErr)
+		return
+	}
+
 	if result.Error != nil {
 		// User doesn't exist, create new
 		if err := database.DB.Create(dbUser).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			auth.LoginError(c, auth.LoginInternal, "Google", "/auth/google", fmt.Errorf("creating the user: %w", err))
 			return
 		}
 	} else {
@@ -133,12 +160,12 @@ func HandleGoogleCallback(c *gin.Context) {
 	// Generate JWT token
 	jwtToken, err := auth.GenerateToken(dbUser.ID, dbUser.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate JWT token: " + err.Error()})
+		auth.LoginError(c, auth.LoginInternal, "Google", "/auth/google", fmt.Errorf("generating the JWT: %w", err))
 		return
 	}
 
-	// Redirect to dashboard with token
-	c.Redirect(http.StatusTemporaryRedirect, "/dashboard?token="+jwtToken)
+	// Redirect to dashboard (or the deep link the user started from) with token
+	auth.CompleteLogin(c, jwtToken)
 }
 
 func generateState() string {
@@ -0,0 +1,515 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	jwksCacheTTL   = time.Hour   // Signing keys are refetched after this long
+	jwksMinRefetch = time.Minute // Unknown key IDs refetch the keys at most this often
+	oidcFlowMaxAge = 600         // Seconds a login may take, for the flow cookies
+)
+
+// idTokenAlgorithms are the asymmetric algorithms ID tokens are accepted in;
+// HS256 would need the client secret as key and "none" isn't a signature
+var idTokenAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// oidcProvider is a generic OpenID Connect identity provider (Okta, Azure AD,
+// Keycloak, ...), configured from its discovery document
+type oidcProvider struct {
+	// OIDC_ISSUER_URL without its trailing slash; ID tokens are checked
+	// against the issuer as discovered
+	issuer      string
+	name        string        // Shown on the login button
+	oauth2      oauth2.Config // Endpoint is filled in from discovery
+	trustEmail  bool          // A missing email_verified claim counts as verified
+	groupsClaim string
+	adminGroups []string
+	client      *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery         // nil until fetched
+	keys        map[string]interface{} // Signing keys by key ID
+	keysFetched time.Time
+}
+
+// oidcDiscovery is the part of /.well-known/openid-configuration used here
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var oidcSSO *oidcProvider
+
+var errOIDCNotConfigured = errors.New("OIDC_ISSUER_URL, OIDC_CLIENT_ID and OIDC_CLIENT_SECRET are not set")
+
+// InitOIDC enables sign-in through an OpenID Connect provider when
+// OIDC_ISSUER_URL is set. The provider's discovery document is fetched now
+// to report misconfiguration early, and again on login until it succeeds.
+func InitOIDC(cfg *config.Config) {
+	if cfg.OIDCIssuerURL == "" {
+		return
+	}
+	if cfg.OIDCClientID == "" || cfg.OIDCClientSecret == "" {
+		log.Println("⚠️  OIDC sign-in not configured (missing OIDC_CLIENT_ID or OIDC_CLIENT_SECRET)")
+		return
+	}
+
+	oidcSSO = &oidcProvider{
+		issuer: strings.TrimSuffix(cfg.OIDCIssuerURL, "/"),
+		name:   cfg.OIDCProviderName,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCCallbackURL,
+			Scopes:       []string{"openid", "profile", "email"},
+		},
+		trustEmail:  cfg.OIDCTrustEmail,
+		groupsClaim: cfg.OIDCGroupsClaim,
+		adminGroups: cfg.OIDCAdminGroups,
+		client:      httpclient.New("oidc"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), httpclient.CallTimeout)
+	defer cancel()
+	if _, err := oidcSSO.discover(ctx); err != nil {
+		log.Printf("❌ OIDC sign-in: %v", err)
+		return
+	}
+	log.Printf("✅ OIDC sign-in initialized (%s)", oidcSSO.issuer)
+}
+
+// OIDCProviderName is the label of the OIDC login button, "" when OIDC
+// sign-in isn't configured
+func OIDCProviderName() string {
+	if oidcSSO == nil {
+		return ""
+	}
+	return oidcSSO.name
+}
+
+// HandleOIDCLogin starts the authorization code flow with PKCE
+func HandleOIDCLogin(c *gin.Context) {
+	if oidcSSO == nil {
+		auth.LoginError(c, auth.LoginMisconfigured, "single sign-on", "", errOIDCNotConfigured)
+		return
+	}
+	config, err := oidcSSO.config(c.Request.Context())
+	if err != nil {
+		auth.LoginFailed(c, oidcSSO.name, "/auth/oidc", err)
+		return
+	}
+
+	state, nonce, verifier := generateState(), generateState(), oauth2.GenerateVerifier()
+	auth.SetCookie(c, "oidc_state", state, oidcFlowMaxAge)
+	auth.SetCookie(c, "oidc_nonce", nonce, oidcFlowMaxAge)
+	auth.SetCookie(c, "oidc_verifier", verifier, oidcFlowMaxAge)
+	auth.RememberNext(c)
+
+	url := config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce))
+	c.Redirect(http.StatusTemporaryRedirect, url)
+}
+
+// HandleOIDCCallback finishes the flow: the code is exchanged, the ID token
+// verified, and the user matched or provisioned by its verified email
+func HandleOIDCCallback(c *gin.Context) {
+	if oidcSSO == nil {
+		auth.LoginError(c, auth.LoginMisconfigured, "single sign-on", "", errOIDCNotConfigured)
+		return
+	}
+	if auth.LoginProviderError(c, oidcSSO.name, "/auth/oidc") {
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, _ := c.Cookie("oidc_state")
+	nonce, _ := c.Cookie("oidc_nonce")
+	verifier, _ := c.Cookie("oidc_verifier")
+	for _, name := range []string{"oidc_state", "oidc_nonce", "oidc_verifier"} {
+		auth.SetCookie(c, name, "", -1)
+	}
+	if state == "" || state != cookieState || nonce == "" || verifier == "" {
+		auth.LoginError(c, auth.LoginExpired, oidcSSO.name, "/auth/oidc", errors.New("invalid state"))
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		auth.LoginError(c, auth.LoginExpired, oidcSSO.name, "/auth/oidc", errors.New("authorization code not provided"))
+		return
+	}
+
+	// The user is waiting: every call to the provider shares one budget
+	ctx, cancel := context.WithTimeout(c.Request.Context(), httpclient.CallTimeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, oidcSSO.client)
+	config, err := oidcSSO.config(ctx)
+	if err != nil {
+		auth.LoginFailed(c, oidcSSO.name, "/auth/oidc", err)
+		return
+	}
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		auth.LoginFailed(c, oidcSSO.name, "/auth/oidc", fmt.Errorf("token exchange with %s: %w", config.Endpoint.TokenURL, err))
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		auth.LoginError(c, auth.LoginMisconfigured, oidcSSO.name, "/auth/oidc", errors.New("no ID token in the token response (is the openid scope granted?)"))
+		return
+	}
+
+	claims, err := oidcSSO.verifyIDToken(ctx, rawIDToken, nonce)
+	if httpclient.IsTimeout(err) {
+		auth.LoginFailed(c, oidcSSO.name, "/auth/oidc", fmt.Errorf("fetching signing keys: %w", err))
+		return
+	}
+	if err != nil {
+		auth.LoginError(c, auth.LoginMisconfigured, oidcSSO.name, "/auth/oidc", fmt.Errorf("ID token rejected: %w", err))
+		return
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		auth.LoginError(c, auth.LoginMisconfigured, oidcSSO.name, "/auth/oidc", errors.New("no email claim in the ID token (request the email scope)"))
+		return
+	}
+	verified, present := claims["email_verified"].(bool)
+	if !present {
+		verified = oidcSSO.trustEmail
+	}
+	// Users are matched by email, so an unverified one could take over an account
+	if !verified {
+		auth.LoginDenied(c, email, auth.ErrEmailNotVerified)
+		return
+	}
+
+	user, err := provisionOIDCUser(c, claims, email)
+	if err != nil {
+		auth.LoginError(c, auth.LoginInternal, oidcSSO.name, "/auth/oidc", fmt.Errorf("provisioning the user: %w", err))
+		return
+	}
+	if user == nil {
+		return // Sign-in denied
+	}
+
+	jwtToken, err := auth.GenerateToken(user.ID, user.Username)
+	if err != nil {
+		auth.LoginError(c, auth.LoginInternal, oidcSSO.name, "/auth/oidc", fmt.Errorf("generating the JWT: %w", err))
+		return
+	}
+	auth.CompleteLogin(c, jwtToken)
+}
+
+// provisionOIDCUser finds the user with the verified email, creating it on
+// first sign-in, and applies the admin group mapping. Returns nil when the
+// sign-in restrictions deny the user, having rendered the response.
+func provisionOIDCUser(c *gin.Context, claims jwt.MapClaims, email string) (*models.User, error) {
+	username := firstClaim(claims, "name", "preferred_username")
+	if username == "" {
+		username = email
+	}
+	picture, _ := claims["picture"].(string)
+
+	allowErr := auth.CheckEmailDomain(email, true, "")
+	var user models.User
+	found := database.DB.Where("LOWER(email) = LOWER(?)", email).First(&user).Error == nil
+	if allowErr != nil && (!found || auth.StrictRevalidate()) {
+		auth.LoginDenied(c, email, allowErr)
+		return nil, nil
+	}
+
+	if !found {
+		user = models.User{Username: username, Email: email, AvatarURL: picture}
+	} else if picture != "" {
+		user.AvatarURL = picture
+	}
+	if len(oidcSSO.adminGroups) > 0 {
+		// The provider's groups are the source of truth: membership grants and revokes
+		isAdmin := oidcSSO.inAdminGroup(claims)
+		if found && user.IsAdmin != isAdmin {
+			log.Printf("🔑 OIDC: admin role of %s set to %v from groups", email, isAdmin)
+		}
+		user.IsAdmin = isAdmin
+	}
+
+	if !found {
+		return &user, database.DB.Create(&user).Error
+	}
+	return &user, database.DB.Save(&user).Error
+}
+
+// inAdminGroup reports whether the groups claim names an admin group. The
+// claim may be a list or a single string.
+func (p *oidcProvider) inAdminGroup(claims jwt.MapClaims) bool {
+	var groups []string
+	switch value := claims[p.groupsClaim].(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	}
+	for _, group := range groups {
+		for _, admin := range p.adminGroups {
+			if strings.EqualFold(group, admin) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func firstClaim(claims jwt.MapClaims, names ...string) string {
+	for _, name := range names {
+		if value, ok := claims[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// config returns the OAuth2 config with the endpoints from discovery
+func (p *oidcProvider) config(ctx context.Context) (*oauth2.Config, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := p.oauth2
+	config.Endpoint = oauth2.Endpoint{
+		AuthURL:  discovery.AuthorizationEndpoint,
+		TokenURL: discovery.TokenEndpoint,
+	}
+	return &config, nil
+}
+
+// discover fetches the discovery document once it is valid; failures are
+// retried on the next call
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	url := p.issuer + "/.well-known/openid-configuration"
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, url, &discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch the discovery document (check OIDC_ISSUER_URL): %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document at %s is for issuer %q: set OIDC_ISSUER_URL to it", url, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document at %s lacks the authorization, token or JWKS endpoint", url)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// verifyIDToken checks the ID token's signature against the provider's keys,
+// its issuer, audience, expiry and nonce, and returns its claims
+func (p *oidcProvider) verifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods(idTokenAlgorithms),
+		jwt.WithIssuer(discovery.Issuer), // Verbatim: some providers' end in "/"
+		jwt.WithAudience(p.oauth2.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+	// A token for several audiences must have been issued to this client
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.oauth2.ClientID {
+			return nil, errors.New("token was issued to another client")
+		}
+	}
+	return claims, nil
+}
+
+// key returns the signing key kid from the provider's JWKS. Keys are cached;
+// an unknown kid refetches them, since providers rotate keys.
+func (p *oidcProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, known := p.keys[kid]
+	age := time.Since(p.keysFetched)
+	if (known && age < jwksCacheTTL) || (!known && age < jwksMinRefetch) {
+		if !known {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+
+	keys, err := p.fetchKeys(ctx, discovery.JWKSURI)
+	if err != nil {
+		if known {
+			log.Printf("⚠️  OIDC: %v; keeping the cached keys", err)
+			return key, nil
+		}
+		return nil, err
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+	if key, known = keys[kid]; !known {
+		// A token without kid is fine while the provider has a single key
+		if kid == "" && len(keys) == 1 {
+			for _, only := range keys {
+				return only, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// jsonWebKey is an RSA or EC public key of a JWKS
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys reads the signing keys of a JWKS; keys of other types or for
+// encryption are skipped
+func (p *oidcProvider) fetchKeys(ctx context.Context, url string) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, url, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch the signing keys: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("⚠️  OIDC: skipping signing key %q of %s: %v", jwk.Kid, url, err)
+			continue
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable signing keys at %s", url)
+	}
+	return keys, nil
+}
+
+// publicKey decodes the key; nil for key types ID tokens aren't signed with here
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid coordinates")
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// getJSON fetches url into v; errors name the URL and what came back
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		snippet := textutil.Truncate(textutil.Clean(strings.TrimSpace(string(body))), 200)
+		return fmt.Errorf("GET %s: %s: %s", url, resp.Status, snippet)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("GET %s: invalid JSON: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/config"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// testProvider serves a discovery document for issuer (the server's URL with
+// suffix appended) and the JWKS of key
+func testProvider(t *testing.T, suffix string, key *rsa.PrivateKey) (*oidcProvider, string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer := server.URL + suffix
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JWKSURI:               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kid: "k1",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	return &oidcProvider{
+		issuer: strings.TrimSuffix(issuer, "/"),
+		name:   "Test SSO",
+		oauth2: oauth2.Config{ClientID: "platform", ClientSecret: "secret", RedirectURL: "https://deploy.example.com/auth/oidc/callback"},
+		client: server.Client(),
+	}, issuer
+}
+
+func signIDToken(t *testing.T, key *rsa.PrivateKey, issuer, nonce string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   issuer,
+		"aud":   "platform",
+		"sub":   "user-1",
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "k1"
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestVerifyIDTokenIssuer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, suffix := range []string{"", "/"} {
+		p, issuer := testProvider(t, suffix, key)
+		ctx := context.Background()
+
+		if _, err := p.verifyIDToken(ctx, signIDToken(t, key, issuer, "n1"), "n1"); err != nil {
+			t.Errorf("issuer %q: %v", issuer, err)
+		}
+		// iss must be the discovered issuer exactly
+		other := strings.TrimSuffix(issuer, "/")
+		if suffix == "" {
+			other += "/"
+		}
+		if _, err := p.verifyIDToken(ctx, signIDToken(t, key, other, "n1"), "n1"); err == nil {
+			t.Errorf("issuer %q: token from %q accepted", issuer, other)
+		}
+		if _, err := p.verifyIDToken(ctx, signIDToken(t, key, issuer, "n1"), "n2"); err == nil {
+			t.Errorf("issuer %q: nonce mismatch accepted", issuer)
+		}
+	}
+}
+
+func TestOIDCFlowCookiesSecure(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, _ := testProvider(t, "/", key)
+	oidcSSO = provider
+	t.Cleanup(func() { oidcSSO = nil })
+
+	for _, scheme := range []string{"http", "https"} {
+		auth.InitSession(&config.Config{PublicScheme: scheme})
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/auth/oidc", nil)
+		HandleOIDCLogin(c)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) < 3 {
+			t.Fatalf("%s: got %d cookies", scheme, len(cookies))
+		}
+		for _, cookie := range cookies {
+			if cookie.Secure != (scheme == "https") {
+				t.Errorf("%s: cookie %s Secure=%v", scheme, cookie.Name, cookie.Secure)
+			}
+			if !cookie.HttpOnly {
+				t.Errorf("%s: cookie %s not HttpOnly", scheme, cookie.Name)
+			}
+		}
+	}
+	auth.InitSession(&config.Config{})
+}
@@ -0,0 +1,170 @@
+// Package policy judges the pushes deploying a project to production against
+// its deploy policy (models.DeployPolicy). Each rule checks one fact about
+// the push, taken from the webhook payload or, for commit signatures, from
+// GitHub.
+package policy
+
+import (
+	"deploy-platform/internal/models"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxAllowedPushers bounds a policy's allowlist
+const maxAllowedPushers = 100
+
+// loginPattern matches GitHub logins: alphanumerics and single hyphens
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9](?:-?[A-Za-z0-9]){0,38}$`)
+
+// Push is what the rules know of the push that triggered a deployment
+type Push struct {
+	Forced bool   // The push rewrote the branch's history
+	Pusher string // GitHub login of who pushed, "" when unknown
+	// Whether GitHub verified the commit's signature, nil when it couldn't
+	// be asked; only looked up for policies requiring verified commits
+	Verified *bool
+	Reason   string // Why it isn't verified, or couldn't be checked
+}
+
+// Rule checks one fact about a push
+type Rule struct {
+	Name    string
+	Enabled func(policy *models.DeployPolicy) bool
+	// Check returns what the push breaks, "" when it complies
+	Check func(policy *models.DeployPolicy, push *Push) string
+}
+
+// Rule names
+const (
+	RuleForcePush       = "force_push"
+	RuleVerifiedCommit  = "verified_commit"
+	RulePusherAllowlist = "pusher_allowlist"
+)
+
+// Rules are the rules policies enable, in the order they are checked
+var Rules = []Rule{
+	{
+		Name:    RuleForcePush,
+		Enabled: func(policy *models.DeployPolicy) bool { return policy.BlockForcePushes },
+		Check: func(policy *models.DeployPolicy, push *Push) string {
+			if push.Forced {
+				return "the push was forced, rewriting the branch's history"
+			}
+			return ""
+		},
+	},
+	{
+		Name:    RuleVerifiedCommit,
+		Enabled: func(policy *models.DeployPolicy) bool { return policy.RequireVerifiedCommits },
+		Check: func(policy *models.DeployPolicy, push *Push) string {
+			switch {
+			case push.Verified == nil:
+				return "the commit's signature could not be checked: " + push.Reason
+			case !*push.Verified:
+				return "GitHub did not verify the commit's signature (" + push.Reason + ")"
+			}
+			return ""
+		},
+	},
+	{
+		Name:    RulePusherAllowlist,
+		Enabled: func(policy *models.DeployPolicy) bool { return len(policy.AllowedPushers) > 0 },
+		Check: func(policy *models.DeployPolicy, push *Push) string {
+			for _, login := range policy.AllowedPushers {
+				if strings.EqualFold(login, push.Pusher) {
+					return ""
+				}
+			}
+			pusher := push.Pusher
+			if pusher == "" {
+				pusher = "an unknown pusher"
+			}
+			return fmt.Sprintf("%s may not deploy to production, only %s", pusher, strings.Join(policy.AllowedPushers, ", "))
+		},
+	},
+}
+
+// Violation is a rule a push breaks
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Result is what a policy decides about a push
+type Result struct {
+	Violations []Violation
+	Blocked    bool // Rules were broken and the policy blocks rather than warns
+}
+
+// Messages are the messages of the result's violations
+func (r Result) Messages() []string {
+	messages := make([]string, 0, len(r.Violations))
+	for _, v := range r.Violations {
+		messages = append(messages, v.Message)
+	}
+	return messages
+}
+
+// Evaluate checks push against the rules policy enables. A nil policy
+// allows everything.
+func Evaluate(policy *models.DeployPolicy, push *Push) Result {
+	var result Result
+	if policy == nil {
+		return result
+	}
+	for _, rule := range Rules {
+		if !rule.Enabled(policy) {
+			continue
+		}
+		if message := rule.Check(policy, push); message != "" {
+			result.Violations = append(result.Violations, Violation{Rule: rule.Name, Message: message})
+		}
+	}
+	result.Blocked = len(result.Violations) > 0 && policy.Enforcement != models.PolicyWarn
+	return result
+}
+
+// Enabled lists the names of the rules policy enables
+func Enabled(policy *models.DeployPolicy) []string {
+	var names []string
+	if policy == nil {
+		return names
+	}
+	for _, rule := range Rules {
+		if rule.Enabled(policy) {
+			names = append(names, rule.Name)
+		}
+	}
+	return names
+}
+
+// Validate checks a project's deploy policy (nil = none), after trimming its
+// logins and defaulting its enforcement to PolicyBlock
+func Validate(policy *models.DeployPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.Enforcement == "" {
+		policy.Enforcement = models.PolicyBlock
+	}
+	if policy.Enforcement != models.PolicyBlock && policy.Enforcement != models.PolicyWarn {
+		return fmt.Errorf("enforcement must be %s or %s", models.PolicyBlock, models.PolicyWarn)
+	}
+	if len(policy.AllowedPushers) > maxAllowedPushers {
+		return fmt.Errorf("allowed_pushers may list at most %d logins", maxAllowedPushers)
+	}
+	seen := make(map[string]bool, len(policy.AllowedPushers))
+	for i, login := range policy.AllowedPushers {
+		login = strings.TrimSpace(login)
+		if !loginPattern.MatchString(login) {
+			return fmt.Errorf("allowed_pushers: %q is not a GitHub login", login)
+		}
+		if seen[strings.ToLower(login)] {
+			return fmt.Errorf("allowed_pushers: %s is listed twice", login)
+		}
+		seen[strings.ToLower(login)] = true
+		policy.AllowedPushers[i] = login
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"deploy-platform/internal/models"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rule is the rule named name
+func rule(t *testing.T, name string) Rule {
+	t.Helper()
+	for _, r := range Rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	t.Fatalf("no rule %s", name)
+	return Rule{}
+}
+
+func verified(v bool) *bool { return &v }
+
+func TestForcePush(t *testing.T) {
+	r := rule(t, RuleForcePush)
+	if r.Enabled(&models.DeployPolicy{}) || !r.Enabled(&models.DeployPolicy{BlockForcePushes: true}) {
+		t.Error("enabled by the wrong setting")
+	}
+	policy := &models.DeployPolicy{BlockForcePushes: true}
+	if got := r.Check(policy, &Push{}); got != "" {
+		t.Errorf("fast-forward: %q", got)
+	}
+	if got := r.Check(policy, &Push{Forced: true}); got != "the push was forced, rewriting the branch's history" {
+		t.Errorf("forced: %q", got)
+	}
+}
+
+func TestVerifiedCommit(t *testing.T) {
+	r := rule(t, RuleVerifiedCommit)
+	if r.Enabled(&models.DeployPolicy{}) || !r.Enabled(&models.DeployPolicy{RequireVerifiedCommits: true}) {
+		t.Error("enabled by the wrong setting")
+	}
+	policy := &models.DeployPolicy{RequireVerifiedCommits: true}
+	tests := []struct {
+		name string
+		push Push
+		want string
+	}{
+		{"verified", Push{Verified: verified(true), Reason: "valid"}, ""},
+		{"unsigned", Push{Verified: verified(false), Reason: "unsigned"}, "GitHub did not verify the commit's signature (unsigned)"},
+		{"not checked", Push{Reason: "GitHub could not be asked"}, "the commit's signature could not be checked: GitHub could not be asked"},
+	}
+	for _, tt := range tests {
+		if got := r.Check(policy, &tt.push); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPusherAllowlist(t *testing.T) {
+	r := rule(t, RulePusherAllowlist)
+	if r.Enabled(&models.DeployPolicy{}) || !r.Enabled(&models.DeployPolicy{AllowedPushers: []string{"ada"}}) {
+		t.Error("enabled by the wrong setting")
+	}
+	policy := &models.DeployPolicy{AllowedPushers: []string{"ada", "Grace-H"}}
+	tests := []struct {
+		pusher string
+		want   string
+	}{
+		{"ada", ""},
+		{"grace-h", ""}, // Logins are case-insensitive
+		{"mallory", "mallory may not deploy to production, only ada, Grace-H"},
+		{"", "an unknown pusher may not deploy to production, only ada, Grace-H"},
+	}
+	for _, tt := range tests {
+		if got := r.Check(policy, &Push{Pusher: tt.pusher}); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.pusher, got, tt.want)
+		}
+	}
+}
+
+// Every enabled rule is checked, in order; the policy's enforcement
+// decides whether breaking them blocks
+func TestEvaluate(t *testing.T) {
+	push := &Push{Forced: true, Pusher: "mallory", Verified: verified(false), Reason: "unsigned"}
+	if result := Evaluate(nil, push); len(result.Violations) != 0 || result.Blocked {
+		t.Errorf("no policy: %+v", result)
+	}
+	if result := Evaluate(&models.DeployPolicy{Enforcement: models.PolicyBlock}, push); len(result.Violations) != 0 || result.Blocked {
+		t.Errorf("no rule enabled: %+v", result)
+	}
+
+	policy := &models.DeployPolicy{BlockForcePushes: true, RequireVerifiedCommits: true, AllowedPushers: []string{"ada"}, Enforcement: models.PolicyBlock}
+	result := Evaluate(policy, push)
+	var rules []string
+	for _, v := range result.Violations {
+		rules = append(rules, v.Rule)
+	}
+	if !reflect.DeepEqual(rules, []string{RuleForcePush, RuleVerifiedCommit, RulePusherAllowlist}) || !result.Blocked {
+		t.Errorf("block: %+v", result)
+	}
+	if messages := result.Messages(); len(messages) != 3 || messages[2] != "mallory may not deploy to production, only ada" {
+		t.Errorf("messages %q", messages)
+	}
+
+	policy.Enforcement = models.PolicyWarn
+	if result := Evaluate(policy, push); len(result.Violations) != 3 || result.Blocked {
+		t.Errorf("warn: %+v", result)
+	}
+	policy.Enforcement = models.PolicyBlock
+	if result := Evaluate(policy, &Push{Pusher: "ada", Verified: verified(true)}); len(result.Violations) != 0 || result.Blocked {
+		t.Errorf("complying push: %+v", result)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	if names := Enabled(nil); len(names) != 0 {
+		t.Errorf("no policy: %v", names)
+	}
+	names := Enabled(&models.DeployPolicy{BlockForcePushes: true, AllowedPushers: []string{"ada"}})
+	if !reflect.DeepEqual(names, []string{RuleForcePush, RulePusherAllowlist}) {
+		t.Errorf("enabled %v", names)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(nil); err != nil {
+		t.Errorf("no policy: %v", err)
+	}
+	policy := &models.DeployPolicy{AllowedPushers: []string{" ada ", "grace-h"}}
+	if err := Validate(policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Enforcement != models.PolicyBlock || !reflect.DeepEqual(policy.AllowedPushers, []string{"ada", "grace-h"}) {
+		t.Errorf("validated %+v", policy)
+	}
+
+	tooMany := make([]string, maxAllowedPushers+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("user-%d", i)
+	}
+	for _, tt := range []struct {
+		policy models.DeployPolicy
+		want   string
+	}{
+		{models.DeployPolicy{Enforcement: "audit"}, "enforcement must be block or warn"},
+		{models.DeployPolicy{AllowedPushers: tooMany}, "allowed_pushers may list at most 100 logins"},
+		{models.DeployPolicy{AllowedPushers: []string{"ada", ""}}, `allowed_pushers: "" is not a GitHub login`},
+		{models.DeployPolicy{AllowedPushers: []string{"-ada"}}, `allowed_pushers: "-ada" is not a GitHub login`},
+		{models.DeployPolicy{AllowedPushers: []string{"ada--lovelace"}}, `allowed_pushers: "ada--lovelace" is not a GitHub login`},
+		{models.DeployPolicy{AllowedPushers: []string{strings.Repeat("a", 40)}}, "is not a GitHub login"},
+		{models.DeployPolicy{AllowedPushers: []string{"ada", "ADA"}}, "allowed_pushers: ADA is listed twice"},
+	} {
+		if err := Validate(&tt.policy); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: got %v, want %s", tt.policy, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package preview
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secrets"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	sweepInterval    = time.Minute
+	retryBackoff     = time.Minute // Doubled after each failed teardown
+	maxRetryBackoff  = 6 * time.Hour
+	maxAttempts      = 8 // Teardowns failing this often are left for an admin
+	sweepBatch       = 20
+	maxErrorLogBytes = 2048 // Of a failed teardown command's output kept with its error
+)
+
+// Manager provisions the preview environments of deployments and tears
+// them down once retired. A nil Manager provisions nothing.
+type Manager struct {
+	clusters *kubernetes.ClientSet
+}
+
+// NewManager runs provisioner commands on clusters
+func NewManager(clusters *kubernetes.ClientSet) *Manager {
+	return &Manager{clusters: clusters}
+}
+
+// Provision sets up the preview environment of deployment, a branch or
+// preview deployment of a project with a preview provisioner, on the
+// cluster of client, and returns the env vars to override in envVars, its
+// env without the provisioner, along with what the provisioning command
+// logged. A command provisioner runs its command once per preview and
+// provisioner setting: later deployments of the preview reuse its
+// overrides. Any error means the deployment must not roll out.
+func (m *Manager) Provision(ctx context.Context, client *kubernetes.Client, deployment *models.Deployment, envVars map[string]string) (map[string]string, string, error) {
+	provisioner := deployment.Project.PreviewProvisioner
+	if m == nil {
+		return nil, "", errors.New("preview provisioning is not available on this platform")
+	}
+
+	var env models.PreviewEnvironment
+	err := database.DB.WithContext(ctx).Where("project_id = ? AND name = ?", deployment.ProjectID, deployment.K8sDeploymentName).First(&env).Error
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", fmt.Errorf("failed to look up the preview environment: %w", err)
+	}
+	if found && (env.Status == models.PreviewEnvironmentRetiring || env.Status == models.PreviewEnvironmentTeardownFailed) {
+		return nil, "", fmt.Errorf("the previous preview environment of %s is still being torn down (%s), deploy again once it is", env.Name, env.Status)
+	}
+
+	values, err := sourceValues(deployment.ProjectID, envVars)
+	if err != nil {
+		return nil, "", err
+	}
+	placeholders := PlaceholdersOf(deployment)
+	overrides := make(map[string]string, len(provisioner.Env))
+	for key, template := range provisioner.Env {
+		rendered, err := Render(key, template, values[key], placeholders)
+		if err != nil {
+			return nil, "", err
+		}
+		overrides[key] = rendered
+	}
+	jobEnv := commandEnv(values, placeholders, overrides)
+
+	if found && env.Status == models.PreviewEnvironmentProvisioned && reflect.DeepEqual(env.Provisioner, *provisioner) && provisioner.Type == models.PreviewProvisionerCommand {
+		stored, err := decryptEnv(env.Overrides)
+		if err == nil {
+			database.DB.Model(&env).Update("deployment_id", deployment.ID)
+			return stored, "Preview environment " + env.Name + " is already provisioned, reusing it\n", nil
+		}
+		log.Printf("⚠️  Preview environment %s of project %d: %v, provisioning it again", env.Name, deployment.ProjectID, err)
+	}
+
+	env.ProjectID = deployment.ProjectID
+	env.Name = deployment.K8sDeploymentName
+	env.DeploymentID = deployment.ID
+	env.Cluster = deployment.Cluster
+	env.Provisioner = *provisioner
+	env.Image = provisioner.Image
+	if env.Image == "" {
+		env.Image = deployment.ImageTag
+	}
+	env.PullSecret = deployment.Project.ImagePullSecret
+	env.Status = models.PreviewEnvironmentProvisioned
+	env.LastError = ""
+
+	var logs string
+	var provisionErr error
+	if provisioner.Type == models.PreviewProvisionerCommand {
+		logs, provisionErr = client.RunPreviewJob(ctx, &kubernetes.PreviewJob{
+			ProjectID:  env.ProjectID,
+			Preview:    env.Name,
+			Action:     "provision",
+			Image:      env.Image,
+			PullSecret: env.PullSecret,
+			Command:    provisioner.Command,
+			Env:        jobEnv,
+		}, Timeout(provisioner))
+		if provisionErr == nil {
+			var printed map[string]string
+			printed, provisionErr = parseOverrides(logs)
+			for key, value := range printed {
+				overrides[key] = value
+			}
+		}
+		if provisionErr != nil {
+			// Recorded all the same: the command may have provisioned halfway
+			env.Status = models.PreviewEnvironmentFailed
+			env.LastError = provisionErr.Error()
+		}
+	}
+
+	if err := saveEnvironment(&env, found, jobEnv, overrides); err != nil {
+		return nil, logs, err
+	}
+	if provisionErr != nil {
+		return nil, logs, provisionErr
+	}
+	return overrides, logs, nil
+}
+
+// sourceValues are the values env templates rewrite: the deployment's env,
+// then the project's env vars
+func sourceValues(projectID uint, envVars map[string]string) (map[string]string, error) {
+	var vars []models.Environment
+	if err := database.DB.Select("key", "value").Where("project_id = ?", projectID).Find(&vars).Error; err != nil {
+		return nil, fmt.Errorf("failed to load the project's env vars: %w", err)
+	}
+	values := make(map[string]string, len(vars)+len(envVars))
+	for _, v := range vars {
+		values[v.Key] = v.Value
+	}
+	for k, v := range envVars {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// commandEnv is what provisioner commands run with: the env without the
+// provisioner, PREVIEW_NAME, PREVIEW_PR, PREVIEW_BRANCH and PREVIEW_SHA,
+// and the templated overrides as PREVIEW_ENV_<KEY>, e.g. the URL of the
+// database to create as PREVIEW_ENV_DATABASE_URL
+func commandEnv(values map[string]string, p Placeholders, overrides map[string]string) map[string]string {
+	env := make(map[string]string, len(values)+len(overrides)+4)
+	for k, v := range values {
+		env[k] = v
+	}
+	env["PREVIEW_NAME"] = p.Preview
+	env["PREVIEW_PR"] = p.PR
+	env["PREVIEW_BRANCH"] = p.Branch
+	env["PREVIEW_SHA"] = p.SHA
+	for k, v := range overrides {
+		env["PREVIEW_ENV_"+k] = v
+	}
+	return env
+}
+
+// saveEnvironment records env, with the env its commands run with and the
+// overrides of its deployments encrypted
+func saveEnvironment(env *models.PreviewEnvironment, exists bool, jobEnv, overrides map[string]string) error {
+	var err error
+	if env.Env, err = encryptEnv(jobEnv); err != nil {
+		return err
+	}
+	if env.Overrides, err = encryptEnv(overrides); err != nil {
+		return err
+	}
+	if exists {
+		err = database.DB.Model(env).Select("deployment_id", "cluster", "status", "provisioner", "image", "pull_secret", "env", "overrides", "last_error").Updates(env).Error
+	} else {
+		err = database.DB.Create(env).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record the preview environment: %w", err)
+	}
+	return nil
+}
+
+func encryptEnv(env map[string]string) (string, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Encrypt(string(data))
+}
+
+func decryptEnv(value string) (map[string]string, error) {
+	data, err := secrets.Decrypt(value)
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var env map[string]string
+	if err := json.Unmarshal([]byte(data), &env); err != nil {
+		return nil, fmt.Errorf("invalid env: %w", err)
+	}
+	return env, nil
+}
+
+// Retire schedules the teardown of the preview environments of the
+// project's resources named names, once the resources are removed, and
+// starts it. Teardowns that fail are retried with backoff.
+func (m *Manager) Retire(projectID uint, names ...string) {
+	if m == nil || len(names) == 0 {
+		return
+	}
+	m.retire(database.DB.Where("project_id = ? AND name IN ?", projectID, names))
+}
+
+// RetireProject schedules the teardown of all the project's preview
+// environments, once its resources are removed, e.g. as it is archived
+func (m *Manager) RetireProject(projectID uint) {
+	if m == nil {
+		return
+	}
+	m.retire(database.DB.Where("project_id = ?", projectID))
+}
+
+func (m *Manager) retire(query *gorm.DB) {
+	result := query.Model(&models.PreviewEnvironment{}).
+		Where("status IN ?", []string{models.PreviewEnvironmentProvisioned, models.PreviewEnvironmentFailed}).
+		Updates(map[string]any{"status": models.PreviewEnvironmentRetiring, "attempts": 0, "next_attempt_at": time.Now()})
+	if result.Error != nil {
+		log.Printf("❌ Failed to retire preview environments: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		go m.sweep(context.Background())
+	}
+}
+
+// Job retries the due teardowns every minute, on the leader
+func (m *Manager) Job() background.Job {
+	return background.Job{
+		Name:      "preview-teardowns",
+		Interval:  sweepInterval,
+		Singleton: true,
+		Manual:    true,
+		Run: func(ctx context.Context) error {
+			m.sweep(ctx)
+			return nil
+		},
+	}
+}
+
+// sweep tears down the retired preview environments that are due
+func (m *Manager) sweep(ctx context.Context) {
+	var due []models.PreviewEnvironment
+	if err := database.DB.WithContext(ctx).Where("status = ? AND next_attempt_at <= ?", models.PreviewEnvironmentRetiring, time.Now()).
+		Order("next_attempt_at").Limit(sweepBatch).Find(&due).Error; err != nil {
+		log.Printf("⚠️  Failed to list retired preview environments: %v", err)
+		return
+	}
+	for i := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		if m.claim(&due[i]) {
+			m.teardown(ctx, &due[i])
+			background.Processed(ctx, 1)
+		}
+	}
+}
+
+// claim takes env's teardown attempt, unless another replica or sweep did.
+// Its next attempt is pushed past the end of this one, so a replica that
+// dies mid-teardown leaves it to be retried.
+func (m *Manager) claim(env *models.PreviewEnvironment) bool {
+	backoff := retryBackoff << min(env.Attempts, 16)
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	next := time.Now().Add(Timeout(&env.Provisioner) + backoff)
+	result := database.DB.Model(&models.PreviewEnvironment{}).
+		Where("id = ? AND status = ? AND attempts = ?", env.ID, models.PreviewEnvironmentRetiring, env.Attempts).
+		Updates(map[string]any{"attempts": env.Attempts + 1, "next_attempt_at": next})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	env.Attempts++
+	return true
+}
+
+// teardown runs env's teardown command, if any, and forgets env
+func (m *Manager) teardown(ctx context.Context, env *models.PreviewEnvironment) {
+	err := m.runTeardown(ctx, env)
+	if err == nil {
+		if err := database.DB.Delete(env).Error; err != nil {
+			log.Printf("⚠️  Failed to forget preview environment %s of project %d: %v", env.Name, env.ProjectID, err)
+			return
+		}
+		log.Printf("🧹 Preview environment %s of project %d torn down", env.Name, env.ProjectID)
+		return
+	}
+
+	status := models.PreviewEnvironmentRetiring
+	if env.Attempts >= maxAttempts {
+		status = models.PreviewEnvironmentTeardownFailed
+		log.Printf("❌ Preview environment %s of project %d: teardown failed %d times, giving up: %v", env.Name, env.ProjectID, env.Attempts, err)
+	} else {
+		log.Printf("⚠️  Preview environment %s of project %d: teardown failed, retrying: %v", env.Name, env.ProjectID, err)
+	}
+	database.DB.Model(env).Updates(map[string]any{"status": status, "last_error": err.Error()})
+}
+
+// runTeardown runs the teardown command env was provisioned with, on the
+// cluster it was provisioned on, or the default one once that is gone
+// (e.g. the project moved): what it frees lives outside the cluster
+func (m *Manager) runTeardown(ctx context.Context, env *models.PreviewEnvironment) error {
+	command := strings.TrimSpace(env.Provisioner.TeardownCommand)
+	if env.Provisioner.Type != models.PreviewProvisionerCommand || command == "" {
+		return nil
+	}
+	client := m.clusters.Client(env.Cluster)
+	if client == nil {
+		client = m.clusters.Client("")
+	}
+	if client == nil {
+		return fmt.Errorf("cluster %s is not available", env.Cluster)
+	}
+	jobEnv, err := decryptEnv(env.Env)
+	if err != nil {
+		return err
+	}
+	logs, err := client.RunPreviewJob(ctx, &kubernetes.PreviewJob{
+		ProjectID:  env.ProjectID,
+		Preview:    env.Name,
+		Action:     "teardown",
+		Image:      env.Image,
+		PullSecret: env.PullSecret,
+		Command:    command,
+		Env:        jobEnv,
+	}, Timeout(&env.Provisioner))
+	if err != nil && logs != "" {
+		return fmt.Errorf("%w\n%s", err, textTail(logs, maxErrorLogBytes))
+	}
+	return err
+}
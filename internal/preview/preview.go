@@ -0,0 +1,220 @@
+// Package preview gives branch and preview deployments resources of their
+// own instead of production's, e.g. a database per pull request, with their
+// project's preview provisioner (models.PreviewProvisioner):
+//
+//   - template provisioners rewrite env vars, e.g. the database name of
+//     DATABASE_URL with "{database}_pr_{pr}"
+//   - command provisioners first run a command as a Job, e.g. creating that
+//     database, and a teardown command once the preview is retired
+//
+// The overrides apply to the preview's deployments only. What was
+// provisioned is recorded as a models.PreviewEnvironment, which teardowns
+// run from: they don't depend on the preview's deployments, or its
+// project, still existing.
+package preview
+
+import (
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/textutil"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Minute
+	maxTimeout     = time.Hour
+	maxEnv         = 32   // Env var templates per provisioner
+	maxTemplate    = 1024 // Bytes per template
+	maxCommand     = 4096
+	maxImage       = 255
+	maxOverrides   = 64 // Env vars a command may print
+	maxBranch      = 40 // Characters of {branch}
+)
+
+// overridePrefix starts the lines of a provisioning command's output that
+// set env vars, e.g. "::env DATABASE_URL=postgres://..."
+const overridePrefix = "::env "
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+	envNamePattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	nonIdentifier      = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// placeholders env templates may use
+var placeholders = map[string]bool{
+	"preview":  true, // Name of the preview's resources, e.g. project-12-sha-abc1234
+	"pr":       true, // Number of the pull request it previews
+	"branch":   true, // Its branch, as an identifier: lowercase letters, digits and '_'
+	"sha":      true, // Its short commit SHA
+	"value":    true, // The env var's value without the provisioner
+	"database": true, // The database name in the path of the env var's URL, which the template replaces
+}
+
+// Validate checks a project's preview provisioner; nil is none
+func Validate(p *models.PreviewProvisioner) error {
+	if p == nil {
+		return nil
+	}
+	switch p.Type {
+	case models.PreviewProvisionerTemplate:
+		if len(p.Env) == 0 {
+			return errors.New("template provisioners need at least one env var template")
+		}
+		if p.Command != "" || p.TeardownCommand != "" || p.Image != "" || p.TimeoutSeconds != 0 {
+			return errors.New("command, teardown_command, image and timeout_seconds only apply to command provisioners")
+		}
+	case models.PreviewProvisionerCommand:
+		if strings.TrimSpace(p.Command) == "" {
+			return errors.New("command provisioners need a command")
+		}
+		if len(p.Command) > maxCommand || len(p.TeardownCommand) > maxCommand {
+			return fmt.Errorf("command and teardown_command must be at most %d bytes", maxCommand)
+		}
+		if len(p.Image) > maxImage || strings.ContainsAny(p.Image, " \t\r\n") {
+			return fmt.Errorf("image must be an image reference of at most %d characters", maxImage)
+		}
+		if p.TimeoutSeconds < 0 || time.Duration(p.TimeoutSeconds)*time.Second > maxTimeout {
+			return fmt.Errorf("timeout_seconds must be between 1 and %d, or 0 for %s", int(maxTimeout.Seconds()), defaultTimeout)
+		}
+	default:
+		return fmt.Errorf("type must be %s or %s", models.PreviewProvisionerTemplate, models.PreviewProvisionerCommand)
+	}
+
+	if len(p.Env) > maxEnv {
+		return fmt.Errorf("at most %d env var templates are allowed", maxEnv)
+	}
+	for _, key := range slices.Sorted(maps.Keys(p.Env)) {
+		template := p.Env[key]
+		if !envNamePattern.MatchString(key) {
+			return fmt.Errorf("invalid env var name %q", key)
+		}
+		if template == "" || len(template) > maxTemplate {
+			return fmt.Errorf("the template of %s must be 1 to %d bytes", key, maxTemplate)
+		}
+		for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+			if !placeholders[match[1]] {
+				return fmt.Errorf("the template of %s uses {%s}: placeholders are {preview}, {pr}, {branch}, {sha}, {value} and {database}", key, match[1])
+			}
+		}
+		if strings.Contains(template, "{database}") && strings.Contains(template, "{value}") {
+			return fmt.Errorf("the template of %s uses {database} and {value}: {database} templates the database name of the URL only", key)
+		}
+	}
+	return nil
+}
+
+// Timeout is how long each command of p may run
+func Timeout(p *models.PreviewProvisioner) time.Duration {
+	if p.TimeoutSeconds > 0 {
+		return time.Duration(p.TimeoutSeconds) * time.Second
+	}
+	return defaultTimeout
+}
+
+// Placeholders are what env templates are rendered with; empty ones can't be used
+type Placeholders struct {
+	Preview string
+	PR      string
+	Branch  string
+	SHA     string
+}
+
+// PlaceholdersOf returns the placeholders of deployment's preview
+func PlaceholdersOf(deployment *models.Deployment) Placeholders {
+	p := Placeholders{Preview: deployment.K8sDeploymentName, SHA: textutil.ShortSHA(deployment.CommitSHA)}
+	if deployment.PullRequest > 0 {
+		p.PR = strconv.Itoa(deployment.PullRequest)
+	}
+	branch := strings.Trim(nonIdentifier.ReplaceAllString(strings.ToLower(deployment.Branch), "_"), "_")
+	if len(branch) > maxBranch {
+		branch = strings.TrimRight(branch[:maxBranch], "_")
+	}
+	p.Branch = branch
+	return p
+}
+
+// Render renders the template of the env var key, whose value without the
+// provisioner is value. With {database}, value must be a URL naming a
+// database, e.g. postgres://host/app?sslmode=require, and the template
+// renders its new database name, the rest of the URL kept.
+func Render(key, template, value string, p Placeholders) (string, error) {
+	var problem error
+	render := func(current string) string {
+		return placeholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+			name := strings.Trim(match, "{}")
+			var rendered string
+			switch name {
+			case "preview":
+				rendered = p.Preview
+			case "pr":
+				rendered = p.PR
+			case "branch":
+				rendered = p.Branch
+			case "sha":
+				rendered = p.SHA
+			case "value", "database":
+				rendered = current
+			default:
+				return match
+			}
+			if rendered == "" && problem == nil {
+				problem = fmt.Errorf("the template of %s uses {%s}, which this deployment has none of", key, name)
+			}
+			return rendered
+		})
+	}
+
+	if !strings.Contains(template, "{database}") {
+		rendered := render(value)
+		return rendered, problem
+	}
+	u, err := url.Parse(value)
+	database := ""
+	if err == nil {
+		database = strings.TrimPrefix(u.Path, "/")
+	}
+	if u == nil || u.Scheme == "" || database == "" || strings.Contains(database, "/") {
+		return "", fmt.Errorf("the template of %s uses {database}, but its value is not a URL naming a database", key)
+	}
+	u.Path = "/" + render(database)
+	u.RawPath = ""
+	return u.String(), problem
+}
+
+// parseOverrides reads the env vars a provisioning command printed, as
+// "::env KEY=VALUE" lines; the last of a key wins
+func parseOverrides(output string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		rest, ok := strings.CutPrefix(line, overridePrefix)
+		if !ok {
+			continue
+		}
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok || !envNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("the command printed an invalid override %q: expected %sKEY=VALUE", textutil.Truncate(line, 80), overridePrefix)
+		}
+		overrides[key] = value
+		if len(overrides) > maxOverrides {
+			return nil, fmt.Errorf("the command printed more than %d overrides", maxOverrides)
+		}
+	}
+	return overrides, nil
+}
+
+// textTail is the end of s, at most n bytes of it
+func textTail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "…" + s[len(s)-n:]
+}
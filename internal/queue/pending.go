@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"log"
+	"time"
+)
+
+// lostDeploymentGrace is how long a deployment may stay pending before it is
+// taken for lost: handed back by a replica that shut down, reclaimed from a
+// worker that died, or dropped between its creation and its enqueue
+const lostDeploymentGrace = time.Minute
+
+// RequeueLostDeployments queues the deployments pending for
+// lostDeploymentGrace again, ahead of new ones, returning how many
+func (wp *WorkerPool) RequeueLostDeployments(now time.Time) (int, error) {
+	stale := now.Add(-lostDeploymentGrace)
+	var lost []models.Deployment
+	if err := database.DB.Preload("Project").
+		Where("status = ? AND updated_at < ?", models.StatusPending, stale).
+		Order("id").Find(&lost).Error; err != nil {
+		return 0, err
+	}
+	requeued := 0
+	for i := range lost {
+		d := &lost[i]
+		// Taken by one run only
+		claimed := database.DB.Model(&models.Deployment{}).
+			Where("id = ? AND status = ? AND updated_at < ?", d.ID, models.StatusPending, stale).
+			Update("updated_at", now)
+		if claimed.Error != nil || claimed.RowsAffected == 0 {
+			continue
+		}
+		if err := models.SetDeploymentStatus(database.DB, d.ID, models.StatusQueued, "queued again: left pending by a replica that stopped"); err != nil {
+			continue
+		}
+		if err := wp.queue.Enqueue(Job{DeploymentID: d.ID, EnqueuedAt: d.CreatedAt, Priority: PriorityHigh, Production: build.ForProduction(d)}); err != nil {
+			models.SetDeploymentStatus(database.DB, d.ID, models.StatusFailed, "failed to re-queue: "+err.Error())
+			return requeued, err
+		}
+		log.Printf("📥 Deployment %d: left pending, queued again", d.ID)
+		requeued++
+	}
+	return requeued, nil
+}
+
+// LostDeploymentsJob queues lost deployments again every minute, on the
+// leader
+func (wp *WorkerPool) LostDeploymentsJob() background.Job {
+	return background.Job{
+		Name:      "lost-deployments",
+		Interval:  time.Minute,
+		Singleton: true,
+		Manual:    true,
+		Run: func(ctx context.Context) error {
+			requeued, err := wp.RequeueLostDeployments(time.Now())
+			background.Processed(ctx, requeued)
+			return err
+		},
+	}
+}
@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"time"
+)
+
+// A job holds the lease of its project while it builds and deploys, renewed
+// every projectLeaseTTL/3: the lease of a replica that died is taken over
+// once projectLeaseTTL has passed
+const projectLeaseTTL = time.Minute
+
+// projectLeaseRetry is how long a job whose project another job holds waits
+// in the queue before it tries again
+const projectLeaseRetry = 5 * time.Second
+
+// projectLease is the lease of a project a job holds, on whichever replica,
+// so that no two jobs of the project build or roll out at once: a build of
+// an older commit finishing last would replace the newer one
+type projectLease struct {
+	lease  background.Elector
+	holder string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// leaseProject takes the lease of the project of job's deployment for it,
+// renewed until release. Reports false while another job holds it.
+func (wp *WorkerPool) leaseProject(ctx context.Context, job Job) (*projectLease, bool, error) {
+	var projectIDs []uint
+	if err := database.DB.Model(&models.Deployment{}).Where("id = ?", job.DeploymentID).Pluck("project_id", &projectIDs).Error; err != nil {
+		return nil, false, err
+	}
+	if len(projectIDs) == 0 {
+		return nil, true, nil // Deleted, the build fails on its own
+	}
+
+	l := &projectLease{
+		lease:  background.NewDatabaseLease(database.DB, fmt.Sprintf("project-%d", projectIDs[0])),
+		holder: fmt.Sprintf("%s/deployment-%d", wp.instance, job.DeploymentID),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	acquired, err := l.lease.Acquire(ctx, l.holder, projectLeaseTTL)
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+	go l.renew()
+	return l, true, nil
+}
+
+// renew keeps the lease until release
+func (l *projectLease) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(projectLeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), projectLeaseTTL/3)
+			acquired, err := l.lease.Acquire(ctx, l.holder, projectLeaseTTL)
+			cancel()
+			if err != nil {
+				log.Printf("⚠️  %s: failed to renew the project lease: %v", l.holder, err)
+			} else if !acquired {
+				log.Printf("⚠️  %s: lost the project lease, another job took it after it expired", l.holder)
+			}
+		}
+	}
+}
+
+// release gives the lease up; nil leases are those of deleted deployments
+func (l *projectLease) release() {
+	if l == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+	if err := l.lease.Release(context.Background(), l.holder); err != nil {
+		log.Printf("⚠️  %s: failed to release the project lease, it expires in %s: %v", l.holder, projectLeaseTTL, err)
+	}
+}
+
+// waitForProject puts job, whose project another job holds, back in the
+// queue for another try after wp.leaseRetry, giving back the build slot it
+// was admitted with
+func (wp *WorkerPool) waitForProject(job Job) error {
+	wp.slots.Release(1)
+	job.NotBefore = time.Now().Add(wp.leaseRetry)
+	if err := wp.queue.Enqueue(job); err != nil {
+		models.SetDeploymentStatus(database.DB, job.DeploymentID, models.StatusFailed, "failed to re-queue: "+err.Error())
+		return fmt.Errorf("failed to re-queue deployment %d: %w", job.DeploymentID, err)
+	}
+	return nil
+}
@@ -1,68 +1,236 @@
 package queue
 
 import (
+	"container/heap"
 	"context"
 	"sync"
+	"time"
 )
 
-// BuildQueue manages build jobs in a queue
+// Job priorities: higher is served first
+const (
+	PriorityNormal = 0  // Pushed commits
+	PriorityHigh   = 10 // Deployments a user is waiting on, re-queued jobs
+)
+
+// Job is a queued build of a deployment
+type Job struct {
+	DeploymentID uint
+	EnqueuedAt   time.Time // Set by Enqueue when zero
+	NotBefore    time.Time // Not delivered before this time; zero = right away
+	Priority     int
+	Attempt      int  // 0 for the first run
+	Production   bool // Rolls out to its project's production resources
+}
+
+// due reports whether j may be delivered at now
+func (j Job) due(now time.Time) bool {
+	return j.NotBefore.IsZero() || !j.NotBefore.After(now)
+}
+
+// BuildQueue manages build jobs in a queue. Due jobs are delivered
+// production first, then by priority, then in enqueue order; jobs with a
+// NotBefore in the future wait until then.
 type BuildQueue interface {
-	Enqueue(deploymentID uint) error
-	Dequeue(ctx context.Context) (uint, error)
+	Enqueue(job Job) error
+	Dequeue(ctx context.Context) (Job, error)
+	// Size counts every queued job, due or not
 	Size() int
+
+	// Wait blocks until the queue holds a due job, without taking it
+	Wait(ctx context.Context) error
+	// TryDequeue takes the next due job if there is one, without blocking
+	TryDequeue() (Job, bool)
+	// Peek returns the next due job if there is one, without taking it
+	Peek() (Job, bool)
+	// Due returns a channel closed the next time a job becomes due
+	Due() <-chan struct{}
+	// Drain takes every queued job, due or not
+	Drain() []Job
 }
 
-// InMemoryQueue is a simple in-memory queue (for development)
-// In production, use Redis or RabbitMQ
+// InMemoryQueue is the only BuildQueue: jobs are lost with the process,
+// and the lost-deployments job queues their deployments again. There is no
+// Redis-backed queue; one would keep delayed jobs in a sorted set scored by
+// NotBefore, moved to the ready list once due.
 type InMemoryQueue struct {
-	items []uint
-	mu    sync.Mutex
-	cond  *sync.Cond
+	ready   jobHeap // Due jobs, by priority then enqueue order
+	delayed jobHeap // Jobs not due yet, by NotBefore
+	seq     uint64  // Enqueue counter, orders jobs of equal priority
+	timer   *time.Timer
+	mu      sync.Mutex
+	signal  chan struct{} // Closed (and replaced) whenever a job becomes due
 }
 
 func NewInMemoryQueue() *InMemoryQueue {
-	q := &InMemoryQueue{
-		items: make([]uint, 0),
+	return &InMemoryQueue{
+		ready: jobHeap{less: func(a, b queuedJob) bool {
+			// Previews and branch deployments never hold up production
+			if a.Production != b.Production {
+				return a.Production
+			}
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			return a.seq < b.seq
+		}},
+		delayed: jobHeap{less: func(a, b queuedJob) bool {
+			if !a.NotBefore.Equal(b.NotBefore) {
+				return a.NotBefore.Before(b.NotBefore)
+			}
+			return a.seq < b.seq
+		}},
+		signal: make(chan struct{}),
 	}
-	q.cond = sync.NewCond(&q.mu)
-	return q
 }
 
-func (q *InMemoryQueue) Enqueue(deploymentID uint) error {
+func (q *InMemoryQueue) Enqueue(job Job) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.items = append(q.items, deploymentID)
-	q.cond.Signal()
+	if job.EnqueuedAt.IsZero() {
+		job.EnqueuedAt = time.Now()
+	}
+	q.seq++
+	item := queuedJob{Job: job, seq: q.seq}
+	if job.due(time.Now()) {
+		heap.Push(&q.ready, item)
+		q.notify()
+	} else {
+		heap.Push(&q.delayed, item)
+		q.schedule()
+	}
 	return nil
 }
 
-func (q *InMemoryQueue) Dequeue(ctx context.Context) (uint, error) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+// notify wakes up everyone in Wait; callers hold q.mu
+func (q *InMemoryQueue) notify() {
+	close(q.signal)
+	q.signal = make(chan struct{})
+}
+
+// schedule arms the timer for the earliest delayed job; callers hold q.mu
+func (q *InMemoryQueue) schedule() {
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	if q.delayed.Len() == 0 {
+		return
+	}
+	q.timer = time.AfterFunc(time.Until(q.delayed.items[0].NotBefore), func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.promote()
+		q.schedule()
+	})
+}
+
+// promote moves delayed jobs that are due to the ready heap; callers hold q.mu
+func (q *InMemoryQueue) promote() {
+	now := time.Now()
+	promoted := false
+	for q.delayed.Len() > 0 && q.delayed.items[0].due(now) {
+		heap.Push(&q.ready, heap.Pop(&q.delayed))
+		promoted = true
+	}
+	if promoted {
+		q.notify()
+	}
+}
+
+func (q *InMemoryQueue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		if err := q.Wait(ctx); err != nil {
+			return Job{}, err
+		}
+		if job, ok := q.TryDequeue(); ok {
+			return job, nil
+		}
+	}
+}
 
-	for len(q.items) == 0 {
-		// Wait for item or context cancellation
-		done := make(chan struct{})
-		go func() {
-			q.cond.Wait()
-			close(done)
-		}()
+func (q *InMemoryQueue) Wait(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		q.promote()
+		if q.ready.Len() > 0 {
+			q.mu.Unlock()
+			return nil
+		}
+		signal := q.signal
+		q.mu.Unlock()
 
 		select {
 		case <-ctx.Done():
-			return 0, ctx.Err()
-		case <-done:
-			// Item available
+			return ctx.Err()
+		case <-signal:
+			// Job became due, check again: another worker may have taken it
 		}
 	}
+}
 
-	item := q.items[0]
-	q.items = q.items[1:]
-	return item, nil
+func (q *InMemoryQueue) TryDequeue() (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.promote()
+	if q.ready.Len() == 0 {
+		return Job{}, false
+	}
+	return heap.Pop(&q.ready).(queuedJob).Job, true
+}
+
+func (q *InMemoryQueue) Peek() (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.promote()
+	if q.ready.Len() == 0 {
+		return Job{}, false
+	}
+	return q.ready.items[0].Job, true
+}
+
+func (q *InMemoryQueue) Due() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.signal
+}
+
+func (q *InMemoryQueue) Drain() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, q.ready.Len()+q.delayed.Len())
+	for _, item := range append(q.ready.items, q.delayed.items...) {
+		jobs = append(jobs, item.Job)
+	}
+	q.ready.items, q.delayed.items = nil, nil
+	q.schedule()
+	return jobs
 }
 
 func (q *InMemoryQueue) Size() int {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	return len(q.items)
+	return q.ready.Len() + q.delayed.Len()
+}
+
+// queuedJob is a job and its place in enqueue order
+type queuedJob struct {
+	Job
+	seq uint64
+}
+
+// jobHeap is a container/heap of jobs ordered by less
+type jobHeap struct {
+	items []queuedJob
+	less  func(a, b queuedJob) bool
+}
+
+func (h *jobHeap) Len() int           { return len(h.items) }
+func (h *jobHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *jobHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *jobHeap) Push(x any)         { h.items = append(h.items, x.(queuedJob)) }
+func (h *jobHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
 }
@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+	"deploy-platform/internal/background"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"log"
+	"time"
+)
+
+// lostRolloutGrace is how long past its retry time a deploy_pending
+// deployment may wait before its retry is taken for lost, e.g. with the
+// queue of a replica that restarted
+const lostRolloutGrace = 5 * time.Minute
+
+// RequeueLostRollouts queues the rollout retries of the deploy_pending
+// deployments overdue by lostRolloutGrace again, returning how many
+func (wp *WorkerPool) RequeueLostRollouts(now time.Time) (int, error) {
+	overdue := now.Add(-lostRolloutGrace)
+	var lost []models.Deployment
+	if err := database.DB.Preload("Project").
+		Where("status = ? AND deploy_retry_at < ?", models.StatusDeployPending, overdue).
+		Find(&lost).Error; err != nil {
+		return 0, err
+	}
+	requeued := 0
+	for i := range lost {
+		d := &lost[i]
+		// Taken by one run only, and not again until it is overdue again
+		claimed := database.DB.Model(&models.Deployment{}).
+			Where("id = ? AND status = ? AND deploy_retry_at < ?", d.ID, models.StatusDeployPending, overdue).
+			Update("deploy_retry_at", now)
+		if claimed.Error != nil || claimed.RowsAffected == 0 {
+			continue
+		}
+		if err := wp.queue.Enqueue(Job{DeploymentID: d.ID, Priority: PriorityHigh, Production: build.ForProduction(d)}); err != nil {
+			return requeued, err
+		}
+		log.Printf("⏳ Deployment %d: rollout retry lost, queued again", d.ID)
+		requeued++
+	}
+	return requeued, nil
+}
+
+// LostRolloutsJob queues lost rollout retries again every minute, on the
+// leader
+func (wp *WorkerPool) LostRolloutsJob() background.Job {
+	return background.Job{
+		Name:      "lost-rollouts",
+		Interval:  time.Minute,
+		Singleton: true,
+		Manual:    true,
+		Run: func(ctx context.Context) error {
+			requeued, err := wp.RequeueLostRollouts(time.Now())
+			background.Processed(ctx, requeued)
+			return err
+		},
+	}
+}
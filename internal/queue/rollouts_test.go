@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"context"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"deploy-platform/internal/throttle"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// deferringBuilder builds deployments once; their first rollouts find the
+// cluster API unavailable and are deferred by retryIn, the next ones succeed
+type deferringBuilder struct {
+	slots   *throttle.Semaphore
+	retryIn time.Duration
+
+	mu       sync.Mutex
+	builds   int
+	rollouts []time.Time
+}
+
+func (b *deferringBuilder) BuildSlots() *throttle.Semaphore {
+	return b.slots
+}
+
+func (b *deferringBuilder) BuildDeploymentPreemptible(ctx context.Context, deploymentID uint, preemption *build.Preemption) error {
+	defer b.slots.Release(1)
+	var deployment models.Deployment
+	if err := database.DB.First(&deployment, deploymentID).Error; err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !deployment.DeployOnly {
+		b.builds++
+	}
+	b.rollouts = append(b.rollouts, time.Now())
+	if len(b.rollouts) == 1 {
+		retryAt := time.Now().Add(b.retryIn)
+		database.DB.Model(&deployment).Updates(map[string]interface{}{"deploy_only": true, "deploy_attempts": 1, "deploy_retry_at": retryAt})
+		models.SetDeploymentStatus(database.DB, deploymentID, models.StatusDeployPending, "cluster API unavailable")
+		return &build.DeployDeferredError{DeploymentID: deploymentID, Attempt: 1, RetryAt: retryAt, Err: syscall.ECONNREFUSED}
+	}
+	models.SetDeploymentStatus(database.DB, deploymentID, models.StatusDeploying, "")
+	return models.SetDeploymentStatus(database.DB, deploymentID, models.StatusDeployed, "deployed")
+}
+
+// A deferred rollout is queued again for its retry time, ahead of other
+// jobs, and only rolled out then; it isn't failed nor built again
+func TestDeferredRolloutRequeued(t *testing.T) {
+	sharedDB(t)
+	builder := &deferringBuilder{slots: throttle.New("build", 1), retryIn: 200 * time.Millisecond}
+	q := NewInMemoryQueue()
+	pool := NewWorkerPool(q, builder, 1)
+	pool.Start()
+	t.Cleanup(pool.Stop)
+
+	deployment := enqueue(t, q, true)
+	statuses := settle(t, []*models.Deployment{deployment})
+	if statuses[deployment.ID] != models.StatusDeployed {
+		t.Fatalf("deployment is %s", statuses[deployment.ID])
+	}
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	if builder.builds != 1 || len(builder.rollouts) != 2 {
+		t.Fatalf("built %d times, rolled out %d times", builder.builds, len(builder.rollouts))
+	}
+	if wait := builder.rollouts[1].Sub(builder.rollouts[0]); wait < builder.retryIn {
+		t.Errorf("retried after %s, before its retry time", wait)
+	}
+	var failed int64
+	database.DB.Model(&models.DeploymentEvent{}).Where("deployment_id = ? AND to_status = ?", deployment.ID, models.StatusFailed).Count(&failed)
+	if failed != 0 {
+		t.Error("deferred rollout failed")
+	}
+}
+
+// Retries overdue by lostRolloutGrace were lost with a replica's queue:
+// they are queued again, once
+func TestRequeueLostRollouts(t *testing.T) {
+	testutil.DB(t)
+	now := time.Now()
+	pending := func(retryAt time.Time, status models.DeploymentStatus) *models.Deployment {
+		d := &models.Deployment{ProjectID: 1, Status: status, DeployOnly: true, ImageTag: "deploy-1:abc1234", DeployRetryAt: &retryAt}
+		if err := database.DB.Create(d).Error; err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	lost := pending(now.Add(-lostRolloutGrace-time.Minute), models.StatusDeployPending)
+	pending(now.Add(-time.Minute), models.StatusDeployPending)             // Still in a queue
+	pending(now.Add(-lostRolloutGrace-time.Minute), models.StatusDeployed) // Retried since
+	pending(now.Add(-lostRolloutGrace-time.Hour), models.StatusCancelled)  // Given up on
+	q := NewInMemoryQueue()
+	pool := NewWorkerPool(q, &deferringBuilder{slots: throttle.New("build", 1)}, 1)
+
+	requeued, err := pool.RequeueLostRollouts(now)
+	if err != nil || requeued != 1 {
+		t.Fatalf("requeued %d: %v", requeued, err)
+	}
+	job, ok := q.TryDequeue()
+	if !ok || job.DeploymentID != lost.ID || job.Priority != PriorityHigh || !job.Production {
+		t.Errorf("got job %+v", job)
+	}
+	// Claimed: not overdue again until lostRolloutGrace went by
+	if requeued, err := pool.RequeueLostRollouts(now.Add(time.Minute)); requeued != 0 || err != nil {
+		t.Errorf("requeued %d again: %v", requeued, err)
+	}
+	// Then it is, and so is the retry that was still in a queue
+	if requeued, _ := pool.RequeueLostRollouts(now.Add(lostRolloutGrace + time.Minute)); requeued != 2 {
+		t.Errorf("requeued %d once overdue", requeued)
+	}
+}
@@ -4,76 +4,655 @@ import (
 	"context"
 	"deploy-platform/internal/build"
 	"deploy-platform/internal/database"
+	"deploy-platform/internal/hooks"
 	"deploy-platform/internal/models"
+	"deploy-platform/internal/throttle"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"sort"
 	"sync"
+	"time"
 )
 
-// WorkerPool manages multiple build workers
+// Worker states
+const (
+	WorkerIdle     = "idle"     // Waiting for a job
+	WorkerBuilding = "building" // Running a job
+	WorkerPaused   = "paused"   // Not taking jobs while the pool is drained
+)
+
+// ErrWorkerNotFound is returned by Restart for an unknown worker ID
+var ErrWorkerNotFound = errors.New("worker not found")
+
+// errRestarted is the cause a restarted worker's context is cancelled with
+var errRestarted = errors.New("worker restarted")
+
+// errShutdown is the cause the jobs still running when Shutdown gives up on
+// them are cancelled with
+var errShutdown = errors.New("platform shutting down")
+
+// Builder builds and deploys the deployments of jobs, releasing the build
+// slot a job was admitted with once its image is built; a *build.Service
+type Builder interface {
+	BuildDeploymentPreemptible(ctx context.Context, deploymentID uint, preemption *build.Preemption) error
+	BuildSlots() *throttle.Semaphore
+}
+
+// WorkerPool manages multiple build workers. A worker takes a job only once
+// it holds a build slot for it. Reserved slots are kept for production jobs:
+// other jobs borrow them only while no production job runs or waits, and a
+// production job finding no slot free preempts the latest started borrower,
+// which goes back to the queue. A job runs only while it holds the lease of
+// its project, so one job per project builds and deploys at a time across
+// replicas; the others wait in the queue.
 type WorkerPool struct {
-	queue    BuildQueue
-	buildSvc *build.Service
-	workers  int
-	wg       sync.WaitGroup
-	ctx      context.Context
-	cancel   context.CancelFunc
+	queue      BuildQueue
+	buildSvc   Builder
+	slots      *throttle.Semaphore // nil = unlimited
+	reserved   int                 // Build slots kept for production jobs
+	workers    int
+	instance   string        // Names this replica in the project leases its jobs hold
+	leaseRetry time.Duration // How long jobs of a project another job holds wait
+	wg         sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+
+	mu       sync.Mutex
+	active   map[int]*worker
+	nextID   int
+	draining bool
+	resumed  chan struct{} // Closed by Resume, nil unless draining
+}
+
+// worker is the control handle of one worker goroutine
+type worker struct {
+	id           int
+	cancel       context.CancelCauseFunc // Aborts the worker and its current job
+	cancelJob    context.CancelCauseFunc // Aborts the current job only, nil when idle
+	state        string
+	deploymentID uint      // Current job, 0 when idle
+	job          Job       // Current job, valid while deploymentID is set
+	startedAt    time.Time // When the current job was taken
+	preemption   *build.Preemption
+}
+
+// WorkerState is a worker as reported to admins
+type WorkerState struct {
+	ID           int        `json:"id"`
+	State        string     `json:"state"`
+	DeploymentID uint       `json:"deployment_id,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+}
+
+// PoolStatus is a snapshot of the pool, used to follow a drain
+type PoolStatus struct {
+	Draining  bool          `json:"draining"`
+	Drained   bool          `json:"drained"`   // Draining and no job left running
+	InFlight  int           `json:"in_flight"` // Jobs still running
+	QueueSize int           `json:"queue_size"`
+	Slots     *SlotStatus   `json:"slots,omitempty"` // nil when build slots are unlimited
+	Workers   []WorkerState `json:"workers"`
+}
+
+// SlotOccupancy is how many slots of a kind running jobs hold
+type SlotOccupancy struct {
+	Capacity int `json:"capacity"`
+	InUse    int `json:"in_use"`
+}
+
+// SlotStatus splits the build slots jobs hold between those reserved for
+// production jobs and the general ones. Production jobs fill the reserved
+// slots first, the others the general ones, each spilling over into the
+// other kind. Slots large builds take on top of their first aren't counted.
+type SlotStatus struct {
+	Reserved   SlotOccupancy `json:"reserved"`
+	General    SlotOccupancy `json:"general"`
+	Production int           `json:"production"` // Running production jobs
+	Others     int           `json:"others"`     // Running preview and branch jobs
+	Borrowed   int           `json:"borrowed"`   // Reserved slots other jobs hold
 }
 
 // NewWorkerPool creates a new worker pool
-func NewWorkerPool(queue BuildQueue, buildSvc *build.Service, numWorkers int) *WorkerPool {
-	ctx, cancel := context.WithCancel(context.Background())
+func NewWorkerPool(queue BuildQueue, buildSvc Builder, numWorkers int) *WorkerPool {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	instance, _ := os.Hostname()
 	return &WorkerPool{
-		queue:    queue,
-		buildSvc: buildSvc,
-		workers:  numWorkers,
-		ctx:      ctx,
-		cancel:   cancel,
+		queue:      queue,
+		buildSvc:   buildSvc,
+		slots:      buildSvc.BuildSlots(),
+		workers:    numWorkers,
+		instance:   instance,
+		leaseRetry: projectLeaseRetry,
+		ctx:        ctx,
+		cancel:     cancel,
+		active:     make(map[int]*worker),
+	}
+}
+
+// SetInstance names this replica in the project leases its jobs hold,
+// its hostname by default
+func (wp *WorkerPool) SetInstance(instance string) {
+	wp.instance = instance
+}
+
+// SetReservedSlots keeps n build slots for production jobs; at least one
+// slot is left for the others
+func (wp *WorkerPool) SetReservedSlots(n int) {
+	if limit := wp.slots.Capacity() - 1; n > limit {
+		n = limit
+	}
+	if n < 0 {
+		n = 0
+	}
+	wp.mu.Lock()
+	wp.reserved = n
+	wp.mu.Unlock()
+	if n > 0 {
+		log.Printf("✅ %d of %d build slots reserved for production deployments", n, wp.slots.Capacity())
 	}
 }
 
 // Start starts all workers
 func (wp *WorkerPool) Start() {
 	for i := 0; i < wp.workers; i++ {
-		wp.wg.Add(1)
-		go wp.worker(i)
+		wp.spawn()
 	}
 	log.Printf("✅ Started %d build workers", wp.workers)
 }
 
 // Stop stops all workers
 func (wp *WorkerPool) Stop() {
-	wp.cancel()
+	wp.cancel(nil)
 	wp.wg.Wait()
 	log.Println("🛑 All workers stopped")
 }
 
-func (wp *WorkerPool) worker(id int) {
-	defer wp.wg.Done()
-	log.Printf("Worker %d started", id)
+// Shutdown stops the pool for the process to exit. Workers take no new job,
+// running jobs get until ctx is done to finish and are cancelled then. The
+// deployments of the cancelled jobs and of those left in the queue are
+// handed back: pending, for the lost-deployments job of whichever replica
+// leads to queue them again.
+func (wp *WorkerPool) Shutdown(ctx context.Context) {
+	wp.Drain()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+wait:
+	for {
+		inFlight := wp.Status().InFlight
+		if inFlight == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("🛑 Cancelling %d running builds, they are built again after the restart", inFlight)
+			break wait
+		case <-ticker.C:
+		}
+	}
+	wp.cancel(errShutdown)
+	wp.wg.Wait()
+
+	// Jobs requeued while running jobs finished are in the queue too, and
+	// jobs waiting for their project or a rollout retry
+	handedBack := 0
+	for _, job := range wp.queue.Drain() {
+		if handBack(job.DeploymentID, "handed back: the platform shut down before its build started") {
+			handedBack++
+		}
+	}
+	log.Printf("🛑 All workers stopped, %d queued deployments handed back", handedBack)
+}
+
+// handBack returns a deployment this replica won't run to pending, reporting
+// false when it finished or was cancelled in the meantime
+func handBack(deploymentID uint, reason string) bool {
+	err := models.SetDeploymentStatus(database.DB, deploymentID, models.StatusPending, reason)
+	var transition *models.TransitionError
+	if err != nil && !errors.As(err, &transition) {
+		log.Printf("⚠️  Deployment %d not handed back: %v", deploymentID, err)
+	}
+	return err == nil
+}
+
+// spawn starts a worker with a fresh ID and returns it
+func (wp *WorkerPool) spawn() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(wp.ctx)
+	w := &worker{id: wp.nextID, cancel: cancel, state: WorkerIdle}
+	wp.nextID++
+	wp.active[w.id] = w
+
+	wp.wg.Add(1)
+	go wp.worker(ctx, w)
+	return w.id
+}
+
+// Drain stops workers from taking new jobs; running jobs finish normally
+func (wp *WorkerPool) Drain() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if !wp.draining {
+		wp.draining = true
+		wp.resumed = make(chan struct{})
+		log.Println("⏸️  Draining build workers")
+	}
+}
+
+// Resume lets workers take jobs again after Drain
+func (wp *WorkerPool) Resume() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if wp.draining {
+		wp.draining = false
+		close(wp.resumed)
+		wp.resumed = nil
+		log.Println("▶️  Build workers resumed")
+	}
+}
+
+// Draining reports whether Drain is in effect
+func (wp *WorkerPool) Draining() bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return wp.draining
+}
+
+// Status reports every worker and the progress of a drain
+func (wp *WorkerPool) Status() PoolStatus {
+	wp.mu.Lock()
+	status := PoolStatus{Draining: wp.draining, Workers: make([]WorkerState, 0, len(wp.active))}
+	if wp.slots != nil {
+		status.Slots = wp.slotStatus()
+	}
+	for _, w := range wp.active {
+		state := WorkerState{ID: w.id, State: w.state, DeploymentID: w.deploymentID}
+		if w.deploymentID != 0 {
+			startedAt := w.startedAt
+			state.StartedAt = &startedAt
+			status.InFlight++
+		}
+		status.Workers = append(status.Workers, state)
+	}
+	wp.mu.Unlock()
+
+	sort.Slice(status.Workers, func(i, j int) bool { return status.Workers[i].ID < status.Workers[j].ID })
+	status.Drained = status.Draining && status.InFlight == 0
+	status.QueueSize = wp.queue.Size()
+	return status
+}
 
+// Restart cancels worker id's current job, puts the job back at the front of
+// the queue and starts a replacement worker. The old goroutine is abandoned:
+// whatever its job returns is ignored. Returns the re-queued deployment (0 if
+// the worker was idle) and the replacement's ID.
+func (wp *WorkerPool) Restart(id int) (uint, int, error) {
+	wp.mu.Lock()
+	w, ok := wp.active[id]
+	if !ok {
+		wp.mu.Unlock()
+		return 0, 0, ErrWorkerNotFound
+	}
+	delete(wp.active, id)
+	deploymentID, job := w.deploymentID, w.job
+	wp.mu.Unlock()
+
+	w.cancel(errRestarted)
+	log.Printf("🔄 Restarting worker %d", id)
+
+	if deploymentID != 0 {
+		requeued, err := wp.requeue(job, fmt.Sprintf("re-queued: worker %d restarted", id))
+		if err != nil {
+			return 0, 0, err
+		}
+		if !requeued {
+			deploymentID = 0
+		}
+	}
+
+	return deploymentID, wp.spawn(), nil
+}
+
+// requeue puts job back at the front of the queue for another attempt.
+// Reports false when its deployment finished or was cancelled in the
+// meantime, leaving nothing to retry.
+func (wp *WorkerPool) requeue(job Job, reason string) (bool, error) {
+	if err := models.SetDeploymentStatus(database.DB, job.DeploymentID, models.StatusQueued, reason); err != nil {
+		log.Printf("⚠️  Deployment %d not re-queued: %v", job.DeploymentID, err)
+		return false, nil
+	}
+	if err := wp.queue.Enqueue(Job{
+		DeploymentID: job.DeploymentID,
+		EnqueuedAt:   job.EnqueuedAt,
+		Priority:     PriorityHigh,
+		Attempt:      job.Attempt + 1,
+		Production:   job.Production,
+	}); err != nil {
+		models.SetDeploymentStatus(database.DB, job.DeploymentID, models.StatusFailed, "failed to re-queue: "+err.Error())
+		return false, fmt.Errorf("failed to re-queue deployment %d: %w", job.DeploymentID, err)
+	}
+	return true, nil
+}
+
+// retryRollout queues the deploy_pending deployment of job for the retry of
+// its rollout, due when deferred says; its image is already built
+func (wp *WorkerPool) retryRollout(job Job, deferred *build.DeployDeferredError) error {
+	if err := wp.queue.Enqueue(Job{
+		DeploymentID: job.DeploymentID,
+		EnqueuedAt:   job.EnqueuedAt,
+		NotBefore:    deferred.RetryAt,
+		Priority:     PriorityHigh,
+		Attempt:      job.Attempt + 1,
+		Production:   job.Production,
+	}); err != nil {
+		models.SetDeploymentStatus(database.DB, job.DeploymentID, models.StatusFailed, "failed to queue the retry of its rollout: "+err.Error())
+		return fmt.Errorf("failed to queue the rollout retry of deployment %d: %w", job.DeploymentID, err)
+	}
+	log.Printf("⏳ Deployment %d: rollout retried at %s", job.DeploymentID, deferred.RetryAt.Format(time.RFC3339))
+	return nil
+}
+
+// CancelJob cancels the running job of deploymentID with cause, leaving its
+// worker to take the next job. Returns false if no worker runs it.
+func (wp *WorkerPool) CancelJob(deploymentID uint, cause error) bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for _, w := range wp.active {
+		if w.deploymentID == deploymentID && w.cancelJob != nil {
+			w.cancelJob(cause)
+			return true
+		}
+	}
+	return false
+}
+
+// setState records what w is doing while it has no job
+func (wp *WorkerPool) setState(w *worker, state string) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	w.state = state
+	w.deploymentID = 0
+	w.cancelJob = nil
+	w.preemption = nil
+}
+
+// running counts the production and other jobs running; callers hold wp.mu
+func (wp *WorkerPool) running() (production, others int) {
+	for _, w := range wp.active {
+		switch {
+		case w.deploymentID == 0:
+		case w.job.Production:
+			production++
+		default:
+			others++
+		}
+	}
+	return production, others
+}
+
+// general is how many unreserved slots the jobs other than production's
+// may hold while production jobs run; callers hold wp.mu
+func (wp *WorkerPool) general(production int) int {
+	general := wp.slots.Capacity() - wp.reserved
+	if spilled := production - wp.reserved; spilled > 0 {
+		general -= spilled
+	}
+	if general < 0 {
+		return 0
+	}
+	return general
+}
+
+// slotStatus splits the slots running jobs hold; callers hold wp.mu
+func (wp *WorkerPool) slotStatus() *SlotStatus {
+	production, others := wp.running()
+	status := &SlotStatus{
+		Reserved:   SlotOccupancy{Capacity: wp.reserved},
+		General:    SlotOccupancy{Capacity: wp.slots.Capacity() - wp.reserved},
+		Production: production,
+		Others:     others,
+	}
+	inGeneral := min(others, wp.general(production))
+	status.Borrowed = others - inGeneral
+	status.Reserved.InUse = min(production, wp.reserved) + status.Borrowed
+	status.General.InUse = production - min(production, wp.reserved) + inGeneral
+	return status
+}
+
+// admit takes the next due job for w, with a build slot, and records that
+// w runs it under cancelJob and preemption. A job other than production's
+// takes a reserved slot only while no production job runs: none waits, it
+// would come first in the queue. A production job finding no slot free
+// preempts a job borrowing one and waits for it to give the slot back.
+// Returns false when there is no job w may start, or w was retired by
+// Restart.
+func (wp *WorkerPool) admit(w *worker, cancelJob context.CancelCauseFunc, preemption *build.Preemption) (Job, bool) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if wp.active[w.id] != w || wp.draining {
+		return Job{}, false
+	}
+	next, ok := wp.queue.Peek()
+	if !ok {
+		return Job{}, false
+	}
+	production, others := wp.running()
+	if !next.Production && production > 0 && others >= wp.general(production) {
+		return Job{}, false
+	}
+	if !wp.slots.TryAcquire(1) {
+		if next.Production {
+			wp.preempt(production, others)
+		}
+		return Job{}, false
+	}
+	// Workers only dequeue here: the job is next's, or a production job
+	// enqueued since, which may take any slot
+	job, ok := wp.queue.TryDequeue()
+	if !ok {
+		wp.slots.Release(1)
+		return Job{}, false
+	}
+	w.state = WorkerBuilding
+	w.deploymentID = job.DeploymentID
+	w.job = job
+	w.cancelJob = cancelJob
+	w.preemption = preemption
+	w.startedAt = time.Now()
+	return job, true
+}
+
+// preempt takes a reserved slot back from the latest started job borrowing
+// one, which lost the least work, unless a preempted job is already giving
+// its slot back. Jobs past their image build can't be preempted: they are
+// about to give the slot back anyway. Callers hold wp.mu.
+func (wp *WorkerPool) preempt(production, others int) {
+	if wp.reserved == 0 || others <= wp.general(production) {
+		return
+	}
+	var borrowers []*worker
+	for _, w := range wp.active {
+		if w.deploymentID == 0 || w.job.Production || w.preemption == nil {
+			continue
+		}
+		if w.preemption.Preempted() {
+			return
+		}
+		borrowers = append(borrowers, w)
+	}
+	sort.Slice(borrowers, func(i, j int) bool { return borrowers[i].startedAt.After(borrowers[j].startedAt) })
+	for _, w := range borrowers {
+		if w.preemption.Preempt() {
+			log.Printf("⏏️  Worker %d: Deployment %d preempted for a production deployment", w.id, w.deploymentID)
+			return
+		}
+	}
+}
+
+// startedJob is a job a worker took and what stops it
+type startedJob struct {
+	Job
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	preemption *build.Preemption
+}
+
+// next waits until w may start a job and takes it
+func (wp *WorkerPool) next(ctx context.Context, w *worker) (startedJob, error) {
 	for {
+		if err := wp.waitForResume(ctx, w); err != nil {
+			return startedJob{}, err
+		}
+		// Taken before trying, so a change in between still wakes the worker
+		released, due := wp.slots.Released(), wp.queue.Due()
+		if err := wp.queue.Wait(ctx); err != nil {
+			return startedJob{}, err
+		}
+
+		jobCtx, cancelJob := context.WithCancelCause(ctx)
+		preemption := build.NewPreemption(cancelJob)
+		if job, ok := wp.admit(w, cancelJob, preemption); ok {
+			return startedJob{Job: job, ctx: jobCtx, cancel: cancelJob, preemption: preemption}, nil
+		}
+		cancelJob(nil)
+		if wp.Draining() {
+			continue
+		}
+		// Wait for a slot to be released or a job to become due: either
+		// may let w start one
 		select {
-		case <-wp.ctx.Done():
-			log.Printf("Worker %d stopping", id)
+		case <-ctx.Done():
+			return startedJob{}, ctx.Err()
+		case <-released:
+		case <-due:
+		}
+	}
+}
+
+// waitForResume blocks while the pool is drained
+func (wp *WorkerPool) waitForResume(ctx context.Context, w *worker) error {
+	wp.mu.Lock()
+	resumed := wp.resumed
+	wp.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+
+	wp.setState(w, WorkerPaused)
+	defer wp.setState(w, WorkerIdle)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+func (wp *WorkerPool) worker(ctx context.Context, w *worker) {
+	defer wp.wg.Done()
+	log.Printf("Worker %d started", w.id)
+
+	for {
+		// Take a job only once a build slot is free: waiting jobs stay in the
+		// queue, so its size and positions reflect what hasn't started yet
+		job, err := wp.next(ctx, w)
+		if err != nil {
+			log.Printf("Worker %d stopping", w.id)
 			return
-		default:
-			deploymentID, err := wp.queue.Dequeue(wp.ctx)
+		}
+		deploymentID := job.DeploymentID
+
+		lease, leased, err := wp.leaseProject(ctx, job.Job)
+		if ctx.Err() != nil {
+			// Stopped while taking the lease, before the build started
+			lease.release()
+			wp.slots.Release(1)
+			wp.setState(w, WorkerIdle)
+			job.cancel(nil)
+			if errors.Is(context.Cause(ctx), errShutdown) {
+				handBack(deploymentID, "handed back: the platform shut down before its build started")
+			}
+			log.Printf("Worker %d stopping", w.id)
+			return
+		}
+		if err != nil || !leased {
+			wp.setState(w, WorkerIdle)
+			job.cancel(nil)
 			if err != nil {
-				if err == context.Canceled {
-					return
-				}
-				log.Printf("Worker %d: Error dequeuing: %v", id, err)
-				continue
+				log.Printf("⚠️  Worker %d: Deployment %d: failed to lease its project, trying again: %v", w.id, deploymentID, err)
+			} else {
+				log.Printf("⏳ Worker %d: Deployment %d waits for the running job of its project", w.id, deploymentID)
+			}
+			if err := wp.waitForProject(job.Job); err != nil {
+				log.Printf("Worker %d: %v", w.id, err)
 			}
+			continue
+		}
 
-			log.Printf("Worker %d: Processing deployment %d", id, deploymentID)
-			if err := wp.buildSvc.BuildDeployment(wp.ctx, deploymentID); err != nil {
-				log.Printf("Worker %d: Build failed for deployment %d: %v", id, deploymentID, err)
-				// Update deployment status
-				database.DB.Model(&models.Deployment{}).Where("id = ?", deploymentID).Update("status", "failed")
-			} else {
-				log.Printf("Worker %d: Build completed for deployment %d", id, deploymentID)
+		log.Printf("Worker %d: Processing deployment %d (attempt %d)", w.id, deploymentID, job.Attempt+1)
+		err = wp.buildSvc.BuildDeploymentPreemptible(job.ctx, deploymentID, job.preemption)
+		lease.release()
+		wp.setState(w, WorkerIdle)
+		superseded := errors.Is(context.Cause(job.ctx), build.ErrSuperseded)
+		preempted := errors.Is(context.Cause(job.ctx), build.ErrPreempted)
+		job.cancel(nil)
+
+		if errors.Is(context.Cause(ctx), errRestarted) {
+			// Restart already re-queued the job and replaced this worker
+			log.Printf("Worker %d: Abandoned deployment %d after restart", w.id, deploymentID)
+			return
+		}
+		if superseded {
+			// Whoever cancelled the job already marked the deployment superseded
+			log.Printf("Worker %d: Deployment %d superseded by a newer deployment", w.id, deploymentID)
+			continue
+		}
+		if err != nil && errors.Is(context.Cause(ctx), errShutdown) {
+			if handBack(deploymentID, "handed back: the platform shut down during its build") {
+				log.Printf("Worker %d: Deployment %d interrupted by the shutdown, handed back", w.id, deploymentID)
+			}
+			return
+		}
+		if preempted {
+			if _, err := wp.requeue(job.Job, "re-queued: its build slot went to a production deployment"); err != nil {
+				log.Printf("Worker %d: %v", w.id, err)
+			}
+			continue
+		}
+		var refused *models.TransitionError
+		if errors.As(err, &refused) && refused.DeploymentID == deploymentID && refused.From.Terminal() {
+			// Cancelled, failed or skipped for a newer commit while it waited
+			log.Printf("Worker %d: Deployment %d is %s, dropped", w.id, deploymentID, refused.From)
+			continue
+		}
+		var deferred *build.DeployDeferredError
+		if errors.As(err, &deferred) {
+			if err := wp.retryRollout(job.Job, deferred); err != nil {
+				log.Printf("Worker %d: %v", w.id, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Worker %d: Build failed for deployment %d: %v", w.id, deploymentID, err)
+			// Update deployment status; Stop cancels rather than fails
+			status := models.StatusFailed
+			switch {
+			case errors.Is(err, build.ErrSuperseded):
+				status = models.StatusSuperseded
+			case errors.Is(err, context.Canceled):
+				status = models.StatusCancelled
+			}
+			if err := models.SetDeploymentStatus(database.DB, deploymentID, status, err.Error()); err != nil {
+				log.Printf("Worker %d: %v", w.id, err)
+			} else if status == models.StatusFailed {
+				build.CountFailure(err)
+				hooks.Failed(deploymentID)
 			}
+		} else {
+			log.Printf("Worker %d: Build completed for deployment %d", w.id, deploymentID)
 		}
 	}
 }
@@ -0,0 +1,431 @@
+package queue
+
+import (
+	"context"
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"deploy-platform/internal/throttle"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBuilder builds a deployment by waiting buildTime, and deploys it by
+// marking it deployed. It records the deployments built at once for each
+// project, shared by the pools of simulated replicas.
+type fakeBuilder struct {
+	t     *testing.T
+	slots *throttle.Semaphore
+
+	mu        sync.Mutex
+	buildTime time.Duration // Of the builds starting from now on
+	building  map[uint]uint // Project -> deployment building
+	overlaps  int           // Builds started while another of the project ran
+	built     []uint
+	started   map[uint]time.Time // When the first build of each deployment started
+}
+
+func newFakeBuilder(t *testing.T, slots int) *fakeBuilder {
+	return &fakeBuilder{t: t, slots: throttle.New("build", slots), buildTime: 30 * time.Millisecond, building: map[uint]uint{}, started: map[uint]time.Time{}}
+}
+
+func (b *fakeBuilder) BuildSlots() *throttle.Semaphore {
+	return b.slots
+}
+
+func (b *fakeBuilder) BuildDeploymentPreemptible(ctx context.Context, deploymentID uint, preemption *build.Preemption) error {
+	defer b.slots.Release(1)
+	var deployment models.Deployment
+	if err := database.DB.First(&deployment, deploymentID).Error; err != nil {
+		return err
+	}
+	if err := models.SetDeploymentStatus(database.DB, deploymentID, models.StatusBuilding, ""); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if other, ok := b.building[deployment.ProjectID]; ok {
+		b.t.Errorf("deployment %d built while deployment %d of its project was", deploymentID, other)
+		b.overlaps++
+	}
+	b.building[deployment.ProjectID] = deploymentID
+	b.built = append(b.built, deploymentID)
+	if _, ok := b.started[deploymentID]; !ok {
+		b.started[deploymentID] = time.Now()
+	}
+	buildTime := b.buildTime
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.building, deployment.ProjectID)
+		b.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(buildTime):
+	}
+	if err := models.SetDeploymentStatus(database.DB, deploymentID, models.StatusDeploying, ""); err != nil {
+		return err
+	}
+	return models.SetDeploymentStatus(database.DB, deploymentID, models.StatusDeployed, "deployed")
+}
+
+// startPool starts a pool of workers on builder, a replica named instance
+func startPool(t *testing.T, builder *fakeBuilder, instance string, workers int) (*WorkerPool, *InMemoryQueue) {
+	q := NewInMemoryQueue()
+	pool := NewWorkerPool(q, builder, workers)
+	pool.SetInstance(instance)
+	pool.leaseRetry = 10 * time.Millisecond
+	pool.Start()
+	t.Cleanup(pool.Stop)
+	return pool, q
+}
+
+// push creates a deployment of branch and queues it the way webhooks do:
+// older deployments of the branch are superseded and their jobs cancelled
+func push(t *testing.T, pool *WorkerPool, q BuildQueue, project *models.Project, branch string, n int) *models.Deployment {
+	t.Helper()
+	deployment := &models.Deployment{
+		ProjectID: project.ID,
+		Branch:    branch,
+		CommitSHA: fmt.Sprintf("%040x", n),
+		Status:    models.StatusQueued,
+	}
+	if err := database.DB.Create(deployment).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range build.SupersedeBuilds(deployment) {
+		pool.CancelJob(id, build.ErrSuperseded)
+	}
+	if err := q.Enqueue(Job{DeploymentID: deployment.ID, Production: true}); err != nil {
+		t.Fatal(err)
+	}
+	return deployment
+}
+
+// settle waits for every deployment to finish
+func settle(t *testing.T, deployments []*models.Deployment) map[uint]models.DeploymentStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		statuses := map[uint]models.DeploymentStatus{}
+		finished := true
+		for _, d := range deployments {
+			var current models.Deployment
+			database.DB.Select("id", "status").First(&current, d.ID)
+			statuses[d.ID] = current.Status
+			finished = finished && current.Status.Terminal()
+		}
+		if finished {
+			return statuses
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deployments not finished: %v", statuses)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sharedDB opens the test database for the workers writing to it at once:
+// SQLite takes one writer, the others would fail with "database is locked"
+func sharedDB(t *testing.T) {
+	t.Helper()
+	testutil.DB(t)
+	db, err := database.DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+}
+
+func newProject(t *testing.T) *models.Project {
+	t.Helper()
+	project := &models.Project{Name: "app", Slug: "app", Branch: "main"}
+	if err := database.DB.Create(project).Error; err != nil {
+		t.Fatal(err)
+	}
+	return project
+}
+
+// Of commits pushed in quick succession only the newest is deployed, and
+// the builds of the project never overlap
+func TestRapidPushesDeployNewest(t *testing.T) {
+	sharedDB(t)
+	project := newProject(t)
+	builder := newFakeBuilder(t, 3)
+	pool, q := startPool(t, builder, "replica-a", 4)
+
+	var deployments []*models.Deployment
+	for i := 1; i <= 8; i++ {
+		deployments = append(deployments, push(t, pool, q, project, "main", i))
+		time.Sleep(time.Duration(i%3) * 10 * time.Millisecond)
+	}
+	statuses := settle(t, deployments)
+
+	newest := deployments[len(deployments)-1]
+	if statuses[newest.ID] != models.StatusDeployed {
+		t.Fatalf("newest deployment is %s", statuses[newest.ID])
+	}
+	for _, d := range deployments[:len(deployments)-1] {
+		if status := statuses[d.ID]; status != models.StatusSkipped && status != models.StatusSuperseded {
+			t.Errorf("older deployment %d is %s", d.ID, status)
+		}
+	}
+	var live models.Deployment
+	database.DB.Where("project_id = ? AND status = ?", project.ID, models.StatusDeployed).Order("id DESC").First(&live)
+	if live.ID != newest.ID || live.CommitSHA != newest.CommitSHA {
+		t.Errorf("live deployment %d (%s), want %d", live.ID, live.CommitSHA, newest.ID)
+	}
+}
+
+// waitForStatus waits for deployment to reach status
+func waitForStatus(t *testing.T, deployment *models.Deployment, status models.DeploymentStatus) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var current models.Deployment
+		database.DB.Select("id", "status").First(&current, deployment.ID)
+		if current.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deployment %d is %s, want %s", deployment.ID, current.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A push while the build of an older commit runs cancels that build rather
+// than waiting for it: the newer commit is built next and stays live
+func TestPushSupersedesRunningBuild(t *testing.T) {
+	sharedDB(t)
+	project := newProject(t)
+	builder := newFakeBuilder(t, 2)
+	builder.buildTime = time.Hour
+	pool, q := startPool(t, builder, "replica-a", 2)
+
+	older := push(t, pool, q, project, "main", 1)
+	waitForStatus(t, older, models.StatusBuilding)
+	// A build of another branch isn't superseded
+	staging := push(t, pool, q, project, "staging", 2)
+
+	builder.mu.Lock()
+	builder.buildTime = 30 * time.Millisecond
+	builder.mu.Unlock()
+	started := time.Now()
+	newer := push(t, pool, q, project, "main", 3)
+	waitForStatus(t, older, models.StatusSuperseded)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("running build superseded after %s", elapsed)
+	}
+
+	statuses := settle(t, []*models.Deployment{older, staging, newer})
+	if statuses[newer.ID] != models.StatusDeployed || statuses[older.ID] != models.StatusSuperseded {
+		t.Errorf("statuses %v", statuses)
+	}
+	var event models.DeploymentEvent
+	database.DB.Where("deployment_id = ? AND to_status = ?", older.ID, models.StatusSuperseded).First(&event)
+	if want := fmt.Sprintf("superseded by deployment %d (%.7s)", newer.ID, newer.CommitSHA); event.Reason != want {
+		t.Errorf("reason %q, want %q", event.Reason, want)
+	}
+	if builder.overlaps != 0 || len(builder.built) != 3 {
+		t.Errorf("%d overlapping builds of %v", builder.overlaps, builder.built)
+	}
+}
+
+// Deployments of a project that don't supersede each other, of different
+// branches and queued on different replicas, take turns
+func TestProjectLeaseAcrossReplicas(t *testing.T) {
+	sharedDB(t)
+	project := newProject(t)
+	other := &models.Project{Name: "other", Slug: "other"}
+	database.DB.Create(other)
+	builder := newFakeBuilder(t, 4)
+	poolA, queueA := startPool(t, builder, "replica-a", 2)
+	poolB, queueB := startPool(t, builder, "replica-b", 2)
+
+	var deployments []*models.Deployment
+	for i, branch := range []string{"main", "staging", "feature/a", "feature/b"} {
+		pool, q := poolA, queueA
+		if i%2 == 1 {
+			pool, q = poolB, queueB
+		}
+		deployments = append(deployments, push(t, pool, q, project, branch, i))
+	}
+	// Other projects aren't held up
+	otherDeployment := push(t, poolB, queueB, other, "main", 9)
+	statuses := settle(t, append(deployments, otherDeployment))
+
+	for id, status := range statuses {
+		if status != models.StatusDeployed {
+			t.Errorf("deployment %d is %s", id, status)
+		}
+	}
+	if builder.overlaps != 0 || len(builder.built) != 5 {
+		t.Errorf("%d overlapping builds of %v", builder.overlaps, builder.built)
+	}
+	// Workers release the lease after marking the deployment
+	poolA.Stop()
+	poolB.Stop()
+	var lease models.Lease
+	if err := database.DB.First(&lease, "name = ?", fmt.Sprintf("project-%d", project.ID)).Error; err != nil || lease.ExpiresAt.After(time.Now()) {
+		t.Errorf("lease %+v left held: %v", lease, err)
+	}
+}
+
+// Jobs waiting for their project are handed back on shutdown like queued ones
+func TestShutdownHandsBackWaitingJobs(t *testing.T) {
+	sharedDB(t)
+	project := newProject(t)
+	builder := newFakeBuilder(t, 2)
+	builder.buildTime = time.Hour
+	q := NewInMemoryQueue()
+	pool := NewWorkerPool(q, builder, 2)
+	pool.leaseRetry = time.Hour
+	pool.Start()
+
+	running := push(t, pool, q, project, "main", 1)
+	waiting := push(t, pool, q, project, "staging", 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Size() != 1 || pool.Status().InFlight != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d queued, %d running", q.Size(), pool.Status().InFlight)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool.Shutdown(ctx)
+	for _, d := range []*models.Deployment{running, waiting} {
+		var current models.Deployment
+		database.DB.First(&current, d.ID)
+		if current.Status != models.StatusPending {
+			t.Errorf("deployment %d is %s, want handed back", d.ID, current.Status)
+		}
+	}
+}
+
+// enqueue queues a deployment of a new project, to its production resources
+// or a preview
+func enqueue(t *testing.T, q BuildQueue, production bool) *models.Deployment {
+	t.Helper()
+	var count int64
+	database.DB.Model(&models.Project{}).Count(&count)
+	project := &models.Project{Name: "app", Slug: fmt.Sprintf("app-%d", count), Branch: "main"}
+	database.DB.Create(project)
+	deployment := &models.Deployment{ProjectID: project.ID, Branch: "main", CommitSHA: fmt.Sprintf("%040x", count), Status: models.StatusQueued}
+	if !production {
+		deployment.Branch = "feature"
+	}
+	if err := database.DB.Create(deployment).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(Job{DeploymentID: deployment.ID, Production: production}); err != nil {
+		t.Fatal(err)
+	}
+	return deployment
+}
+
+// waitForSlots waits for the pool's slots to be held as want says
+func waitForSlots(t *testing.T, pool *WorkerPool, want SlotStatus) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := pool.Status().Slots
+		if *got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slots %+v, want %+v", *got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// A flood of previews borrows the idle reserved slot. A production job
+// queued behind them starts as soon as the borrower gives the slot back,
+// rather than once a preview build finishes; the borrower is built again
+// later.
+func TestProductionPreemptsPreviewFlood(t *testing.T) {
+	sharedDB(t)
+	builder := newFakeBuilder(t, 3)
+	builder.buildTime = time.Second
+	q := NewInMemoryQueue()
+	pool := NewWorkerPool(q, builder, 4)
+	pool.SetReservedSlots(1)
+	pool.Start()
+	t.Cleanup(pool.Stop)
+
+	var deployments []*models.Deployment
+	for i := 0; i < 8; i++ {
+		deployments = append(deployments, enqueue(t, q, false))
+	}
+	waitForSlots(t, pool, SlotStatus{
+		Reserved: SlotOccupancy{Capacity: 1, InUse: 1},
+		General:  SlotOccupancy{Capacity: 2, InUse: 2},
+		Others:   3, Borrowed: 1,
+	})
+
+	production := enqueue(t, q, true)
+	deployments = append(deployments, production)
+	queued := time.Now()
+	waitForSlots(t, pool, SlotStatus{
+		Reserved:   SlotOccupancy{Capacity: 1, InUse: 1},
+		General:    SlotOccupancy{Capacity: 2, InUse: 2},
+		Production: 1, Others: 2,
+	})
+	waitForStatus(t, production, models.StatusBuilding)
+	builder.mu.Lock()
+	latency := builder.started[production.ID].Sub(queued)
+	builder.buildTime = 20 * time.Millisecond
+	builder.mu.Unlock()
+	// The previews running hold every slot for a second
+	if latency > 500*time.Millisecond {
+		t.Errorf("production started %s after it was queued", latency)
+	}
+
+	for id, status := range settle(t, deployments) {
+		if status != models.StatusDeployed {
+			t.Errorf("deployment %d is %s", id, status)
+		}
+	}
+	var preempted []models.DeploymentEvent
+	database.DB.Where("reason = ?", "re-queued: its build slot went to a production deployment").Find(&preempted)
+	if len(preempted) != 1 {
+		t.Errorf("%d deployments preempted", len(preempted))
+	}
+}
+
+// Previews don't borrow the reserved slot while a production job runs
+func TestReservedSlotsKeptForProduction(t *testing.T) {
+	sharedDB(t)
+	builder := newFakeBuilder(t, 2)
+	builder.buildTime = time.Hour
+	q := NewInMemoryQueue()
+	pool := NewWorkerPool(q, builder, 3)
+	pool.SetReservedSlots(5) // At least one slot is left for the others
+	pool.Start()
+	t.Cleanup(pool.Stop)
+
+	production := enqueue(t, q, true)
+	waitForStatus(t, production, models.StatusBuilding)
+	for i := 0; i < 3; i++ {
+		enqueue(t, q, false)
+	}
+	want := SlotStatus{
+		Reserved:   SlotOccupancy{Capacity: 1, InUse: 1},
+		General:    SlotOccupancy{Capacity: 1, InUse: 1},
+		Production: 1, Others: 1,
+	}
+	waitForSlots(t, pool, want)
+	time.Sleep(100 * time.Millisecond)
+	if status := pool.Status(); *status.Slots != want || status.QueueSize != 2 {
+		t.Errorf("slots %+v, %d queued", *status.Slots, status.QueueSize)
+	}
+}
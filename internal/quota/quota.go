@@ -0,0 +1,338 @@
+package quota
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Quota names, used in error responses and usage listings
+const (
+	Projects          = "projects"
+	DeploymentsPerDay = "deployments_per_day"
+	CustomDomains     = "custom_domains"
+	EnvVars           = "env_vars"
+)
+
+// DefaultPlanName is the plan users without an explicit plan fall under
+const DefaultPlanName = "free"
+
+// ExceededError is returned when an action would go over a plan limit
+type ExceededError struct {
+	Quota string
+	Limit int
+	Used  int
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded (%d of %d used)", e.Quota, e.Used, e.Limit)
+}
+
+// StatusCode is 429 for rate-style quotas that reset on their own, 402 for
+// plan limits that need an upgrade (or cleanup) to lift
+func (e *ExceededError) StatusCode() int {
+	if e.Quota == DeploymentsPerDay {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusPaymentRequired
+}
+
+// Body is the JSON error body returned to clients
+func (e *ExceededError) Body() map[string]interface{} {
+	return map[string]interface{}{
+		"error": e.Error(),
+		"quota": e.Quota,
+		"limit": e.Limit,
+		"used":  e.Used,
+	}
+}
+
+// Usage describes the consumption of a single quota
+type Usage struct {
+	Quota     string `json:"quota"`
+	Limit     int    `json:"limit"` // 0 = unlimited
+	Used      int    `json:"used"`
+	Remaining *int   `json:"remaining"` // nil when unlimited
+}
+
+func newUsage(quota string, limit, used int) Usage {
+	u := Usage{Quota: quota, Limit: limit, Used: used}
+	if limit > 0 {
+		remaining := limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		u.Remaining = &remaining
+	}
+	return u
+}
+
+// Init makes sure the default plan exists with the limits from config and
+// recounts the projects of each user, archived ones left out as they are
+// when archiving
+func Init(cfg *config.Config) error {
+	plan := models.Plan{Name: DefaultPlanName}
+	if err := database.DB.Where("name = ?", DefaultPlanName).FirstOrCreate(&plan).Error; err != nil {
+		return fmt.Errorf("failed to create default plan: %w", err)
+	}
+	plan.IsDefault = true
+	plan.MaxProjects = cfg.FreePlanMaxProjects
+	plan.MaxDeploymentsPerDay = cfg.FreePlanMaxDeploymentsPerDay
+	plan.MaxCustomDomains = cfg.FreePlanMaxCustomDomains
+	plan.MaxEnvVars = cfg.FreePlanMaxEnvVars
+	if err := database.DB.Save(&plan).Error; err != nil {
+		return fmt.Errorf("failed to update default plan: %w", err)
+	}
+
+	if err := database.DB.Exec("UPDATE users SET project_count = (SELECT COUNT(*) FROM projects WHERE projects.user_id = users.id AND projects.archived_at IS NULL)").Error; err != nil {
+		return fmt.Errorf("failed to backfill project counts: %w", err)
+	}
+
+	log.Printf("✅ Quotas initialized (default plan: %d projects, %d deployments/day)", plan.MaxProjects, plan.MaxDeploymentsPerDay)
+	return nil
+}
+
+// PlanForUser returns the user's plan, or the default plan if none is assigned
+func PlanForUser(user *models.User) (*models.Plan, error) {
+	var plan models.Plan
+	if user.PlanID != nil {
+		if err := database.DB.First(&plan, *user.PlanID).Error; err == nil {
+			return &plan, nil
+		}
+	}
+	if err := database.DB.Where("is_default = ?", true).First(&plan).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func planForUserID(userID uint) (*models.Plan, *models.User, error) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, nil, err
+	}
+	plan, err := PlanForUser(&user)
+	return plan, &user, err
+}
+
+func check(quota string, limit, used int) error {
+	if limit > 0 && used >= limit {
+		return &ExceededError{Quota: quota, Limit: limit, Used: used}
+	}
+	return nil
+}
+
+// ReserveProject counts one more project for the user, if their plan allows
+// it. The check and the increment are one conditional UPDATE, so concurrent
+// creations can't both take the last project left. ReleaseProject gives it
+// back when the project isn't created after all.
+func ReserveProject(userID uint) error {
+	plan, _, err := planForUserID(userID)
+	if err != nil {
+		return err
+	}
+	update := database.DB.Model(&models.User{}).Where("id = ?", userID)
+	if plan.MaxProjects > 0 {
+		update = update.Where("project_count < ?", plan.MaxProjects)
+	}
+	result := update.Update("project_count", gorm.Expr("project_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var user models.User
+		if err := database.DB.First(&user, userID).Error; err != nil {
+			return err
+		}
+		return &ExceededError{Quota: Projects, Limit: plan.MaxProjects, Used: user.ProjectCount}
+	}
+	return nil
+}
+
+// ReleaseProject stops counting a project for the user: archived, owned by
+// someone else now, or not created after ReserveProject
+func ReleaseProject(userID uint) {
+	database.DB.Model(&models.User{}).Where("id = ? AND project_count > 0", userID).
+		Update("project_count", gorm.Expr("project_count - 1"))
+}
+
+// CheckDeployment verifies the project may create another deployment today
+func CheckDeployment(project *models.Project) error {
+	plan, _, err := planForUserID(project.UserID)
+	if err != nil {
+		return err
+	}
+	return check(DeploymentsPerDay, plan.MaxDeploymentsPerDay, DeploymentsToday(project))
+}
+
+// CheckEnvVars verifies the project may define another environment variable
+func CheckEnvVars(project *models.Project) error {
+	plan, _, err := planForUserID(project.UserID)
+	if err != nil {
+		return err
+	}
+	var count int64
+	database.DB.Model(&models.Environment{}).Where("project_id = ?", project.ID).Count(&count)
+	return check(EnvVars, plan.MaxEnvVars, int(count))
+}
+
+// CheckCustomDomains verifies the project may add another custom domain given
+// how many it already has
+func CheckCustomDomains(project *models.Project, current int) error {
+	plan, _, err := planForUserID(project.UserID)
+	if err != nil {
+		return err
+	}
+	return check(CustomDomains, plan.MaxCustomDomains, current)
+}
+
+// RecordDeployment bumps the project's daily deployment counter, resetting it
+// when the day has rolled over
+func RecordDeployment(projectID uint) {
+	today := currentDay()
+	database.DB.Model(&models.Project{}).Where("id = ?", projectID).Updates(map[string]interface{}{
+		"deployments_today": gorm.Expr("CASE WHEN deployments_day = ? THEN deployments_today + 1 ELSE 1 END", today),
+		"deployments_day":   today,
+	})
+}
+
+// UsageForUser returns the user's plan and the usage of each user-level quota,
+// plus per-project deployment and env var usage
+func UsageForUser(userID uint) (*models.Plan, []Usage, map[uint][]Usage, error) {
+	plan, user, err := planForUserID(userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	usage := []Usage{newUsage(Projects, plan.MaxProjects, user.ProjectCount)}
+
+	var projects []models.Project
+	if err := database.DB.Where("user_id = ? AND archived_at IS NULL", userID).Find(&projects).Error; err != nil {
+		return nil, nil, nil, err
+	}
+
+	type envCount struct {
+		ProjectID uint
+		Count     int
+	}
+	var envCounts []envCount
+	database.DB.Model(&models.Environment{}).
+		Select("project_id, COUNT(*) AS count").
+		Where("project_id IN (SELECT id FROM projects WHERE user_id = ?)", userID).
+		Group("project_id").
+		Scan(&envCounts)
+	envByProject := make(map[uint]int, len(envCounts))
+	for _, ec := range envCounts {
+		envByProject[ec.ProjectID] = ec.Count
+	}
+
+	perProject := make(map[uint][]Usage, len(projects))
+	for i := range projects {
+		perProject[projects[i].ID] = []Usage{
+			newUsage(DeploymentsPerDay, plan.MaxDeploymentsPerDay, DeploymentsToday(&projects[i])),
+			newUsage(EnvVars, plan.MaxEnvVars, envByProject[projects[i].ID]),
+		}
+	}
+	return plan, usage, perProject, nil
+}
+
+// NearLimit describes a user whose project usage is at or above a threshold
+type NearLimit struct {
+	UserID   uint    `json:"user_id"`
+	Username string  `json:"username"`
+	Email    string  `json:"email"`
+	Plan     string  `json:"plan"`
+	Usage    Usage   `json:"usage"`
+	Ratio    float64 `json:"ratio"`
+}
+
+// UsersNearLimits lists users using at least threshold (0-1) of their project
+// quota or of any single project's daily deployment quota
+func UsersNearLimits(threshold float64) ([]NearLimit, error) {
+	var plans []models.Plan
+	if err := database.DB.Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	plansByID := make(map[uint]models.Plan, len(plans))
+	var defaultPlan models.Plan
+	for _, p := range plans {
+		plansByID[p.ID] = p
+		if p.IsDefault {
+			defaultPlan = p
+		}
+	}
+	planOf := func(u *models.User) models.Plan {
+		if u.PlanID != nil {
+			if p, ok := plansByID[*u.PlanID]; ok {
+				return p
+			}
+		}
+		return defaultPlan
+	}
+
+	var users []models.User
+	if err := database.DB.Where("project_count > 0").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usersByID := make(map[uint]*models.User, len(users))
+
+	var result []NearLimit
+	for i := range users {
+		user := &users[i]
+		usersByID[user.ID] = user
+		plan := planOf(user)
+		if ratio, ok := ratioOf(plan.MaxProjects, user.ProjectCount); ok && ratio >= threshold {
+			result = append(result, NearLimit{
+				UserID: user.ID, Username: user.Username, Email: user.Email, Plan: plan.Name,
+				Usage: newUsage(Projects, plan.MaxProjects, user.ProjectCount), Ratio: ratio,
+			})
+		}
+	}
+
+	// Only projects that deployed today can be close to their daily limit
+	var projects []models.Project
+	if err := database.DB.Where("deployments_day = ? AND deployments_today > 0", currentDay()).Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	for i := range projects {
+		user, ok := usersByID[projects[i].UserID]
+		if !ok {
+			continue
+		}
+		plan := planOf(user)
+		used := projects[i].DeploymentsToday
+		if ratio, ok := ratioOf(plan.MaxDeploymentsPerDay, used); ok && ratio >= threshold {
+			usage := newUsage(DeploymentsPerDay, plan.MaxDeploymentsPerDay, used)
+			usage.Quota = fmt.Sprintf("%s (project %s)", DeploymentsPerDay, projects[i].Slug)
+			result = append(result, NearLimit{
+				UserID: user.ID, Username: user.Username, Email: user.Email, Plan: plan.Name,
+				Usage: usage, Ratio: ratio,
+			})
+		}
+	}
+	return result, nil
+}
+
+func ratioOf(limit, used int) (float64, bool) {
+	if limit <= 0 {
+		return 0, false
+	}
+	return float64(used) / float64(limit), true
+}
+
+// DeploymentsToday is the number of deployments project created today (UTC)
+func DeploymentsToday(project *models.Project) int {
+	if project.DeploymentsDay != currentDay() {
+		return 0
+	}
+	return project.DeploymentsToday
+}
+
+func currentDay() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
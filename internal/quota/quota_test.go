@@ -0,0 +1,135 @@
+package quota
+
+import (
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func projectCount(t *testing.T, userID uint) int {
+	t.Helper()
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.ProjectCount
+}
+
+// The recount at startup leaves archived projects out, as archiving does,
+// so unarchiving after a restart counts the project once
+func TestInitSkipsArchivedProjects(t *testing.T) {
+	testutil.DB(t)
+	cfg := &config.Config{FreePlanMaxProjects: 2}
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	var projects []*models.Project
+	for _, slug := range []string{"app", "api"} {
+		project := &models.Project{Name: slug, Slug: slug, UserID: user.ID}
+		if err := ReserveProject(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		database.DB.Create(project)
+		projects = append(projects, project)
+	}
+
+	// Archive
+	database.DB.Model(projects[0]).Update("archived_at", time.Now())
+	ReleaseProject(user.ID)
+	if got := projectCount(t, user.ID); got != 1 {
+		t.Fatalf("%d projects counted after archiving", got)
+	}
+
+	if err := Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := projectCount(t, user.ID); got != 1 {
+		t.Fatalf("%d projects counted after the restart", got)
+	}
+
+	// Unarchive
+	if err := ReserveProject(user.ID); err != nil {
+		t.Fatalf("unarchiving refused: %v", err)
+	}
+	database.DB.Model(projects[0]).Update("archived_at", nil)
+	if got := projectCount(t, user.ID); got != 2 {
+		t.Errorf("%d projects counted after unarchiving", got)
+	}
+	if err := ReserveProject(user.ID); err == nil {
+		t.Error("a third project allowed by a plan of 2")
+	}
+}
+
+// Concurrent creations can't take more projects than the plan allows between
+// them, and each refusal reports the limit and the projects counted
+func TestReserveProjectConcurrently(t *testing.T) {
+	testutil.DB(t)
+	if err := Init(&config.Config{FreePlanMaxProjects: 3}); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ReserveProject(user.ID)
+			var exceeded *ExceededError
+			switch {
+			case err == nil:
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			case !errors.As(err, &exceeded) || exceeded.Quota != Projects || exceeded.Limit != 3 || exceeded.Used != 3:
+				t.Errorf("refused with %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 3 {
+		t.Errorf("%d projects reserved on a plan of 3", reserved)
+	}
+	if got := projectCount(t, user.ID); got != 3 {
+		t.Errorf("%d projects counted", got)
+	}
+
+	ReleaseProject(user.ID)
+	if err := ReserveProject(user.ID); err != nil {
+		t.Errorf("reserving a released project: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		ReleaseProject(user.ID)
+	}
+	if got := projectCount(t, user.ID); got != 0 {
+		t.Errorf("%d projects counted after releasing them all", got)
+	}
+}
+
+// A limit of 0 is unlimited
+func TestReserveProjectUnlimited(t *testing.T) {
+	testutil.DB(t)
+	database.DB.Create(&models.Plan{Name: "unlimited", IsDefault: true})
+	user := &models.User{Username: "ada", Email: "ada@example.com"}
+	database.DB.Create(user)
+	for i := 0; i < 10; i++ {
+		if err := ReserveProject(user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := projectCount(t, user.ID); got != 10 {
+		t.Errorf("%d projects counted", got)
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"deploy-platform/internal/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pruneInterval is how often expired counters are deleted
+const pruneInterval = time.Minute
+
+// DatabaseStore counts in the platform database, shared by every replica
+// using it. Each hit is a single atomic upsert.
+type DatabaseStore struct {
+	db *gorm.DB
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// NewDatabaseStore creates a store on db (rate_limit_counters table)
+func NewDatabaseStore(db *gorm.DB) *DatabaseStore {
+	return &DatabaseStore{db: db}
+}
+
+func (s *DatabaseStore) Increment(ctx context.Context, key string, windowStart time.Time, window time.Duration) (int64, int64, error) {
+	db := s.db.WithContext(ctx)
+
+	counter := models.RateLimitCounter{
+		Key:       windowKey(key, windowStart),
+		Count:     1,
+		ExpiresAt: windowStart.Add(2 * window), // Counts as the previous window until then
+	}
+	err := db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("rate_limit_counters.count + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "count"}}},
+	).Create(&counter).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var previous []int64
+	if err := db.Model(&models.RateLimitCounter{}).
+		Where("key = ?", windowKey(key, windowStart.Add(-window))).
+		Pluck("count", &previous).Error; err != nil {
+		return 0, 0, err
+	}
+
+	s.prune(db)
+	if len(previous) == 0 {
+		return counter.Count, 0, nil
+	}
+	return counter.Count, previous[0], nil
+}
+
+func (s *DatabaseStore) Peek(ctx context.Context, key string, windowStart time.Time, window time.Duration) (int64, int64, error) {
+	var counters []models.RateLimitCounter
+	currentKey, previousKey := windowKey(key, windowStart), windowKey(key, windowStart.Add(-window))
+	if err := s.db.WithContext(ctx).Where("key IN ?", []string{currentKey, previousKey}).Find(&counters).Error; err != nil {
+		return 0, 0, err
+	}
+	var current, previous int64
+	for _, counter := range counters {
+		if counter.Key == currentKey {
+			current = counter.Count
+		} else {
+			previous = counter.Count
+		}
+	}
+	return current, previous, nil
+}
+
+// prune deletes expired counters, at most once per pruneInterval per replica
+func (s *DatabaseStore) prune(db *gorm.DB) {
+	s.mu.Lock()
+	if time.Since(s.lastPruned) < pruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPruned = time.Now()
+	s.mu.Unlock()
+
+	db.Where("expires_at < ?", time.Now()).Delete(&models.RateLimitCounter{})
+}
@@ -1,54 +1,202 @@
 package ratelimit
 
 import (
+	"context"
+	"deploy-platform/internal/config"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-// Limiter implements a simple token bucket rate limiter
+// Store counts requests per key in fixed windows. Limiters on several
+// replicas sharing a Store share their limits.
+type Store interface {
+	// Increment adds a request to key's window starting at windowStart and
+	// returns the count of that window and of the window before it
+	Increment(ctx context.Context, key string, windowStart time.Time, window time.Duration) (current, previous int64, err error)
+	// Peek returns the same counts without adding a request
+	Peek(ctx context.Context, key string, windowStart time.Time, window time.Duration) (current, previous int64, err error)
+}
+
+// windowKey names the counter of key's window starting at windowStart. The
+// braces keep all windows of a key in one Redis Cluster slot.
+func windowKey(key string, windowStart time.Time) string {
+	return fmt.Sprintf("ratelimit:{%s}:%d", key, windowStart.Unix())
+}
+
+// NewStore picks where limits are counted: Redis when REDIS_URL is set, the
+// platform database when it's shared (DATABASE_URL, i.e. Postgres), this
+// process otherwise. Returns the store and its name for logging.
+func NewStore(cfg *config.Config, db *gorm.DB) (Store, string, error) {
+	switch {
+	case cfg.RedisURL != "":
+		store, err := NewRedisStore(cfg.RedisURL)
+		return store, "redis", err
+	case cfg.DatabaseURL != "" && db != nil:
+		return NewDatabaseStore(db), "database", nil
+	default:
+		return NewMemoryStore(), "memory", nil
+	}
+}
+
+// storeTimeout bounds how long a request waits for the store
+const storeTimeout = 200 * time.Millisecond
+
+// Limiter allows rate requests per key per window, using a sliding window
+// counter: the previous window's count is weighted by how much of it still
+// overlaps the sliding window.
+//
+// Keys over the limit are remembered locally until their window ends, so
+// hot keys (a flood from one IP) are rejected without asking the store.
+// When the store is unreachable requests are allowed (fail-open) with a
+// warning: a broken store must not stop deployments.
 type Limiter struct {
-	rate       int           // requests per duration
-	duration   time.Duration // time window
-	tokens     int           // current tokens
-	lastUpdate time.Time
-	mu         sync.Mutex
+	rate   int
+	window time.Duration
+	store  Store
+
+	mu       sync.Mutex
+	blocked  map[string]time.Time // Key -> when it may be let through again
+	lastWarn time.Time
 }
 
-// NewLimiter creates a new rate limiter
+// NewLimiter creates a rate limiter counting in this process only
 func NewLimiter(rate int, duration time.Duration) *Limiter {
+	return NewSharedLimiter(NewMemoryStore(), rate, duration)
+}
+
+// NewSharedLimiter creates a rate limiter counting in store
+func NewSharedLimiter(store Store, rate int, window time.Duration) *Limiter {
 	return &Limiter{
-		rate:       rate,
-		duration:   duration,
-		tokens:     rate,
-		lastUpdate: time.Now(),
+		rate:    rate,
+		window:  window,
+		store:   store,
+		blocked: make(map[string]time.Time),
 	}
 }
 
-// Allow checks if a request is allowed
+// Allow checks if a request is allowed, counting all requests together
 func (l *Limiter) Allow() bool {
+	return l.AllowKey(context.Background(), "global")
+}
+
+// AllowKey checks if a request for key (an IP, a project) is allowed
+func (l *Limiter) AllowKey(ctx context.Context, key string) bool {
+	allowed, _ := l.Take(ctx, key)
+	return allowed
+}
+
+// Take counts a request for key and reports whether it is allowed. When it
+// isn't, it also returns how long until key's window ends, when requests are
+// counted again.
+func (l *Limiter) Take(ctx context.Context, key string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	until, isBlocked := l.blocked[key]
+	if isBlocked && now.Before(until) {
+		l.mu.Unlock()
+		return false, until.Sub(now)
+	}
+	delete(l.blocked, key)
+	l.mu.Unlock()
+
+	windowStart := now.Truncate(l.window)
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	current, previous, err := l.store.Increment(ctx, key, windowStart, l.window)
+	if err != nil {
+		l.warn(err)
+		return true, 0
+	}
+
+	overlap := 1 - float64(now.Sub(windowStart))/float64(l.window)
+	if float64(previous)*overlap+float64(current) <= float64(l.rate) {
+		return true, 0
+	}
+
+	until = windowStart.Add(l.window)
+	l.mu.Lock()
+	l.blocked[key] = until
+	l.pruneBlocked(now)
+	l.mu.Unlock()
+	return false, until.Sub(now)
+}
+
+// pruneBlocked forgets keys whose block has expired; callers hold l.mu
+func (l *Limiter) pruneBlocked(now time.Time) {
+	for key, until := range l.blocked {
+		if !now.Before(until) {
+			delete(l.blocked, key)
+		}
+	}
+}
+
+// warn logs store failures at most once a minute
+func (l *Limiter) warn(err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if time.Since(l.lastWarn) < time.Minute {
+		return
+	}
+	l.lastWarn = time.Now()
+	log.Printf("⚠️  Rate limit store unavailable, allowing requests: %v", err)
+}
 
-	now := time.Now()
-	elapsed := now.Sub(l.lastUpdate)
+// TrustProxies makes r take client IPs from X-Forwarded-For only when one of
+// proxies (IPs or CIDRs) sets it. With none, the header is ignored: gin
+// trusts it from anyone by default, letting clients pick the IP they are
+// limited as.
+func TrustProxies(r *gin.Engine, proxies []string) error {
+	if len(proxies) == 0 {
+		return r.SetTrustedProxies(nil)
+	}
+	return r.SetTrustedProxies(proxies)
+}
 
-	// Refill tokens based on elapsed time
-	tokensToAdd := int(elapsed / (l.duration / time.Duration(l.rate)))
-	if tokensToAdd > 0 {
-		l.tokens = min(l.tokens+tokensToAdd, l.rate)
-		l.lastUpdate = now
+// ByIP keys requests by client IP: the address of the connection, or the
+// one X-Forwarded-For gives when it comes from a trusted proxy
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser keys requests by authenticated user
+func ByUser(c *gin.Context) string {
+	return "user:" + strconv.FormatUint(uint64(c.GetUint("user_id")), 10)
+}
+
+// ByParam keys requests by a route parameter, e.g. a project token
+func ByParam(name string) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		return name + ":" + c.Param(name)
 	}
+}
 
-	if l.tokens > 0 {
-		l.tokens--
-		return true
+// Scoped keys requests by key within scope, so that limiters sharing a store
+// count the same IP or user separately
+func Scoped(scope string, key func(*gin.Context) string) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		return scope + ":" + key(c)
 	}
-	return false
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// Middleware rejects requests over the limit with 429, telling clients in
+// Retry-After when to try again
+func Middleware(l *Limiter, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter := l.Take(c.Request.Context(), key(c))
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+		c.Next()
 	}
-	return b
 }
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientKey answers with the ByIP key of a request sent from remoteAddr
+func clientKey(t *testing.T, proxies []string, remoteAddr, forwardedFor string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := TrustProxies(r, proxies); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, ByIP(c)) })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Body.String()
+}
+
+func TestByIPIgnoresSpoofedForwardedFor(t *testing.T) {
+	if key := clientKey(t, nil, "203.0.113.7:51000", "1.2.3.4"); key != "ip:203.0.113.7" {
+		t.Fatalf("without trusted proxies got %s", key)
+	}
+}
+
+func TestByIPTrustsConfiguredProxies(t *testing.T) {
+	proxies := []string{"10.0.0.0/8"}
+	if key := clientKey(t, proxies, "10.1.2.3:51000", "198.51.100.4"); key != "ip:198.51.100.4" {
+		t.Fatalf("from a trusted proxy got %s", key)
+	}
+	if key := clientKey(t, proxies, "203.0.113.7:51000", "198.51.100.4"); key != "ip:203.0.113.7" {
+		t.Fatalf("from an untrusted client got %s", key)
+	}
+}
+
+func TestMiddlewareRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(NewLimiter(2, time.Minute), ByIP))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	codes := make([]int, 3)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.7:51000"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		codes[i] = w.Code
+		if w.Code == http.StatusTooManyRequests {
+			retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+			if err != nil || retryAfter < 1 || retryAfter > 60 {
+				t.Fatalf("Retry-After %q", w.Header().Get("Retry-After"))
+			}
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("got %v", codes)
+	}
+}
+
+// A noisy client uses up its own limit only
+func TestKeysDontInterfere(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(50, time.Minute)
+	var wg sync.WaitGroup
+	allowed := make([]int, 10)
+	var mu sync.Mutex
+	for client := range allowed {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requests := 50
+			if client == 0 {
+				requests = 500
+			}
+			for i := 0; i < requests; i++ {
+				if limiter.AllowKey(ctx, fmt.Sprintf("ip:10.0.0.%d", client)) {
+					mu.Lock()
+					allowed[client]++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for client, n := range allowed {
+		if n != 50 {
+			t.Errorf("client %d: %d requests allowed, want 50", client, n)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore counts in this process only, for single-replica setups. Each
+// counter expires on its own: limiters with different windows share it.
+type MemoryStore struct {
+	mu      sync.Mutex
+	counts  map[string]int64     // windowKey -> count
+	expires map[string]time.Time // windowKey -> when it no longer counts
+}
+
+// NewMemoryStore creates an empty in-process store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counts: make(map[string]int64), expires: make(map[string]time.Time)}
+}
+
+func (s *MemoryStore) Increment(ctx context.Context, key string, windowStart time.Time, window time.Duration) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := windowKey(key, windowStart)
+	s.counts[current]++
+	s.expires[current] = windowStart.Add(2 * window) // Counts as the previous window until then
+
+	now := time.Now()
+	for k, expires := range s.expires {
+		if expires.Before(now) {
+			delete(s.counts, k)
+			delete(s.expires, k)
+		}
+	}
+	return s.counts[current], s.counts[windowKey(key, windowStart.Add(-window))], nil
+}
+
+func (s *MemoryStore) Peek(ctx context.Context, key string, windowStart time.Time, window time.Duration) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[windowKey(key, windowStart)], s.counts[windowKey(key, windowStart.Add(-window))], nil
+}
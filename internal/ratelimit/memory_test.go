@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A short window limiter must not prune the counters of a longer one
+// sharing the store, e.g. the auth rate limit and the login throttle
+func TestMemoryStoreKeepsLongerWindows(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limiter := NewSharedLimiter(store, 1000, time.Minute)
+	hourStart := time.Now().Truncate(time.Hour)
+
+	for i := 1; i <= 12; i++ {
+		limiter.Take(ctx, "auth:ip:10.0.0.1")
+		current, _, err := store.Increment(ctx, "login-fail:ip:10.0.0.1", hourStart, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current != int64(i) {
+			t.Fatalf("failure %d counted as %d", i, current)
+		}
+	}
+}
+
+func TestMemoryStorePrunesExpiredWindows(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	old := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	store.Increment(ctx, "k", old, time.Minute)
+	store.Increment(ctx, "k", time.Now().Truncate(time.Minute), time.Minute)
+
+	if _, ok := store.counts[windowKey("k", old)]; ok {
+		t.Fatal("window older than the previous one was kept")
+	}
+}
@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incrementScript bumps the current window (setting its expiry on first
+// use) and reads the previous one in a single round trip
+const incrementScript = `
+local current = redis.call('INCR', KEYS[1])
+if current == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+local previous = redis.call('GET', KEYS[2])
+return {current, tonumber(previous) or 0}`
+
+// RedisStore counts in Redis, shared by every replica using it. It speaks
+// just enough of the Redis protocol for its script and MGET, over a single
+// connection that is redialed after an error.
+type RedisStore struct {
+	addr     string
+	useTLS   bool
+	username string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRedisStore parses a redis:// or rediss:// URL
+// (redis://[user:password@]host:port[/db]); the connection is made on first use
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid REDIS_URL: scheme must be redis or rediss, got %q", u.Scheme)
+	}
+
+	s := &RedisStore{addr: u.Host, useTLS: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if s.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL database %q", path)
+		}
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Increment(ctx context.Context, key string, windowStart time.Time, window time.Duration) (int64, int64, error) {
+	reply, err := s.do(ctx, "EVAL", incrementScript, "2",
+		windowKey(key, windowStart), windowKey(key, windowStart.Add(-window)),
+		strconv.FormatInt((2*window).Milliseconds(), 10))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	current, ok1 := values[0].(int64)
+	previous, ok2 := values[1].(int64)
+	if !ok1 || !ok2 {
+		return 0, 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return current, previous, nil
+}
+
+func (s *RedisStore) Peek(ctx context.Context, key string, windowStart time.Time, window time.Duration) (int64, int64, error) {
+	reply, err := s.do(ctx, "MGET", windowKey(key, windowStart), windowKey(key, windowStart.Add(-window)))
+	if err != nil {
+		return 0, 0, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	var counts [2]int64
+	for i, value := range values {
+		if text, ok := value.(string); ok {
+			if counts[i], err = strconv.ParseInt(text, 10, 64); err != nil {
+				return 0, 0, fmt.Errorf("unexpected redis reply %v", reply)
+			}
+		}
+	}
+	return counts[0], counts[1], nil
+}
+
+// do sends one command and reads its reply
+func (s *RedisStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetDeadline(deadline)
+	} else {
+		s.conn.SetDeadline(time.Time{})
+	}
+
+	reply, err := s.roundTrip(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// Broken connection: drop it, the next call redials
+		s.conn.Close()
+		s.conn = nil
+	}
+	return reply, err
+}
+
+// connect dials and authenticates; callers hold s.mu
+func (s *RedisStore) connect(ctx context.Context) error {
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if s.useTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn, s.rd = conn, bufio.NewReader(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var setup [][]string
+	if s.password != "" {
+		if s.username != "" {
+			setup = append(setup, []string{"AUTH", s.username, s.password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.password})
+		}
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, cmd := range setup {
+		if _, err := s.roundTrip(cmd...); err != nil {
+			conn.Close()
+			s.conn = nil
+			return fmt.Errorf("redis %s: %w", cmd[0], err)
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a command as a RESP array and reads the reply
+func (s *RedisStore) roundTrip(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := s.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return readReply(s.rd)
+}
+
+// redisError is an error reply; the connection stays usable after one
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// readReply parses one RESP reply: integers as int64, bulk strings as
+// string (nil when null), arrays as []interface{}
+func readReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2) // Payload and its CRLF
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readReply(rd); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
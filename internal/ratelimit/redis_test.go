@@ -0,0 +1,345 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server in the test process speaking RESP, with the
+// commands RedisStore sends: AUTH, SELECT, MGET and EVAL of incrementScript,
+// run atomically as Redis runs scripts
+type fakeRedis struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	username string // AUTH required when password is set
+	password string
+	dbs      map[int]map[string]string
+	expiries map[string]time.Duration // PEXPIRE of each key, by "db/key"
+	commands []string
+	conns    []net.Conn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, dbs: map[int]map[string]string{}, expiries: map[string]time.Duration{}}
+	t.Cleanup(f.close)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) url(userinfo, db string) string {
+	if userinfo != "" {
+		userinfo += "@"
+	}
+	return "redis://" + userinfo + f.ln.Addr().String() + db
+}
+
+// dropConnections closes every connection, as a Redis restart would
+func (f *fakeRedis) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeRedis) close() {
+	f.ln.Close()
+	f.dropConnections()
+}
+
+func (f *fakeRedis) get(db int, key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dbs[db][key]
+}
+
+func (f *fakeRedis) expiry(db int, key string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.expiries[fmt.Sprintf("%d/%s", db, key)]
+}
+
+// sent lists the commands received
+func (f *fakeRedis) sent() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.commands, " ")
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	f.mu.Lock()
+	authed, db := f.password == "", 0
+	f.mu.Unlock()
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			return
+		}
+		values, _ := reply.([]interface{})
+		args := make([]string, len(values))
+		for i, value := range values {
+			args[i], _ = value.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, strings.ToUpper(args[0]))
+		var out string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if args[len(args)-1] == f.password && (len(args) == 2 || args[1] == f.username) {
+				authed, out = true, "+OK\r\n"
+			} else {
+				out = "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			out = "+OK\r\n"
+		case cmd == "MGET":
+			out = fmt.Sprintf("*%d\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				out += bulk(f.dbs[db][key])
+			}
+		case cmd == "EVAL" && len(args) == 6 && args[1] == incrementScript && args[2] == "2":
+			out = f.increment(db, args[3], args[4], args[5])
+		default:
+			out = fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+// increment runs incrementScript; callers hold f.mu
+func (f *fakeRedis) increment(db int, currentKey, previousKey, expiry string) string {
+	if f.dbs[db] == nil {
+		f.dbs[db] = map[string]string{}
+	}
+	current, err := strconv.ParseInt(f.dbs[db][currentKey], 10, 64)
+	if err != nil && f.dbs[db][currentKey] != "" {
+		return "-ERR value is not an integer or out of range\r\n"
+	}
+	current++
+	f.dbs[db][currentKey] = strconv.FormatInt(current, 10)
+	if current == 1 {
+		ms, _ := strconv.ParseInt(expiry, 10, 64)
+		f.expiries[fmt.Sprintf("%d/%s", db, currentKey)] = time.Duration(ms) * time.Millisecond
+	}
+	previous, _ := strconv.ParseInt(f.dbs[db][previousKey], 10, 64)
+	return fmt.Sprintf("*2\r\n:%d\r\n:%d\r\n", current, previous)
+}
+
+func bulk(value string) string {
+	if value == "" {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func TestRedisStoreIncrement(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis(t)
+	store, err := NewRedisStore(redis.url("", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	windowStart := time.Date(2026, 10, 16, 12, 1, 0, 0, time.UTC)
+	previousStart := windowStart.Add(-time.Minute)
+
+	if current, previous, err := store.Peek(ctx, "ip:10.0.0.1", windowStart, time.Minute); err != nil || current != 0 || previous != 0 {
+		t.Fatalf("Peek of an unknown key = %d, %d, %v", current, previous, err)
+	}
+	for i := 0; i < 4; i++ {
+		store.Increment(ctx, "ip:10.0.0.1", previousStart, time.Minute)
+	}
+	for i := int64(1); i <= 3; i++ {
+		current, previous, err := store.Increment(ctx, "ip:10.0.0.1", windowStart, time.Minute)
+		if err != nil || current != i || previous != 4 {
+			t.Fatalf("Increment %d = %d, %d, %v", i, current, previous, err)
+		}
+	}
+	if current, previous, err := store.Peek(ctx, "ip:10.0.0.1", windowStart, time.Minute); err != nil || current != 3 || previous != 4 {
+		t.Errorf("Peek = %d, %d, %v", current, previous, err)
+	}
+
+	// A window is kept while it is the current or the previous one
+	if expiry := redis.expiry(0, windowKey("ip:10.0.0.1", windowStart)); expiry != 2*time.Minute {
+		t.Errorf("window expires after %s", expiry)
+	}
+	if got := redis.get(0, windowKey("ip:10.0.0.2", windowStart)); got != "" {
+		t.Errorf("another key counted %s", got)
+	}
+}
+
+// Replicas sharing Redis let through rate requests in all, not rate each
+func TestRedisStoreSimulatedReplicas(t *testing.T) {
+	const (
+		replicas = 5
+		workers  = 8   // Concurrent requests in each replica
+		requests = 400 // In all
+		rate     = 100
+		window   = time.Hour
+	)
+	ctx := context.Background()
+	redis := newFakeRedis(t)
+	windowStart := time.Now().Truncate(window)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for r := 0; r < replicas; r++ {
+		store, err := NewRedisStore(redis.url("", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		limiter := NewSharedLimiter(store, rate, window)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < requests/replicas/workers; i++ {
+					if limiter.AllowKey(ctx, "ip:203.0.113.7") {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if !time.Now().Truncate(window).Equal(windowStart) {
+		t.Skip("the window rolled over during the test")
+	}
+
+	if allowed != rate {
+		t.Errorf("%d requests allowed across %d replicas, want %d", allowed, replicas, rate)
+	}
+	counted, _ := strconv.Atoi(redis.get(0, windowKey("ip:203.0.113.7", windowStart)))
+	if counted < rate+1 || counted > requests {
+		t.Errorf("Redis counted %d requests", counted)
+	}
+}
+
+func TestRedisStoreAuthAndDatabase(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis(t)
+	redis.mu.Lock()
+	redis.username, redis.password = "limiter", "s3cret"
+	redis.mu.Unlock()
+	windowStart := time.Now().Truncate(time.Minute)
+
+	store, err := NewRedisStore(redis.url("limiter:s3cret", "/3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Increment(ctx, "k", windowStart, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if redis.get(3, windowKey("k", windowStart)) != "1" || redis.get(0, windowKey("k", windowStart)) != "" {
+		t.Errorf("not counted in database 3")
+	}
+	if got := redis.sent(); got != "AUTH SELECT EVAL" {
+		t.Errorf("sent %s", got)
+	}
+
+	store, _ = NewRedisStore(redis.url("limiter:wrong", ""))
+	if _, _, err := store.Increment(ctx, "k", windowStart, time.Minute); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("wrong password: %v", err)
+	}
+}
+
+// A dropped connection fails one call, the next one redials
+func TestRedisStoreReconnects(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis(t)
+	store, _ := NewRedisStore(redis.url("", ""))
+	windowStart := time.Now().Truncate(time.Minute)
+	store.Increment(ctx, "k", windowStart, time.Minute)
+
+	redis.dropConnections()
+	var current int64
+	var err error
+	for i := 0; i < 2; i++ {
+		if current, _, err = store.Increment(ctx, "k", windowStart, time.Minute); err == nil {
+			break
+		}
+	}
+	if err != nil || current < 2 {
+		t.Errorf("after reconnecting: %d, %v", current, err)
+	}
+}
+
+// Requests are let through while Redis is unreachable
+func TestRedisStoreFailOpen(t *testing.T) {
+	redis := newFakeRedis(t)
+	store, _ := NewRedisStore(redis.url("", ""))
+	redis.close()
+
+	limiter := NewSharedLimiter(store, 1, time.Minute)
+	for i := 0; i < 3; i++ {
+		if !limiter.AllowKey(context.Background(), "k") {
+			t.Fatalf("request %d rejected with Redis down", i+1)
+		}
+	}
+}
+
+func TestNewRedisStore(t *testing.T) {
+	tests := []struct {
+		url                string
+		addr, user, passwd string
+		db                 int
+		tls                bool
+	}{
+		{url: "redis://cache", addr: "cache:6379"},
+		{url: "rediss://default:pw@cache:6380/2", addr: "cache:6380", user: "default", passwd: "pw", db: 2, tls: true},
+		{url: "redis://:pw@[::1]", addr: "[::1]:6379", passwd: "pw"},
+	}
+	for _, tt := range tests {
+		s, err := NewRedisStore(tt.url)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.url, err)
+		}
+		if s.addr != tt.addr || s.username != tt.user || s.password != tt.passwd || s.db != tt.db || s.useTLS != tt.tls {
+			t.Errorf("%s: got %+v", tt.url, s)
+		}
+	}
+	for _, url := range []string{"http://cache", "redis://cache/db", "::"} {
+		if _, err := NewRedisStore(url); err == nil {
+			t.Errorf("%s accepted", url)
+		}
+	}
+}
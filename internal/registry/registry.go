@@ -0,0 +1,255 @@
+package registry
+
+// Credentials of private image registries projects pull from: checked
+// against the registry when saved, handed to docker builds as auth configs
+// and to pods as a dockerconfigjson pull Secret
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/httpclient"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/netutil"
+	"deploy-platform/internal/secrets"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DockerHub is the host Docker Hub credentials are saved under
+const DockerHub = "docker.io"
+
+// dockerHubAuthKey is the key of Docker Hub in docker auth configs
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// ErrRejected is returned by Verify when the registry refuses the credentials
+var ErrRejected = errors.New("the registry rejected the username or password")
+
+var (
+	hostPattern      = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*(:[0-9]{1,5})?$`)
+	challengeParam   = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	dockerHubAliases = map[string]bool{
+		"docker.io":               true,
+		"index.docker.io":         true,
+		"registry-1.docker.io":    true,
+		"registry.hub.docker.com": true,
+	}
+)
+
+var (
+	privateHosts = map[string]bool{}
+	httpClient   = newHTTPClient()
+)
+
+// Init sets the registries that may be checked on private addresses, from
+// REGISTRY_PRIVATE_HOSTS
+func Init(cfg *config.Config) {
+	privateHosts = make(map[string]bool, len(cfg.RegistryPrivateHosts))
+	for _, host := range cfg.RegistryPrivateHosts {
+		if normalized, err := NormalizeHost(host); err == nil {
+			privateHosts[normalized] = true
+		}
+	}
+}
+
+// newHTTPClient doesn't follow redirects: where they lead isn't checked
+func newHTTPClient() *http.Client {
+	client := httpclient.New("registry")
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return client
+}
+
+// Credentials are a project's username and password for one registry
+type Credentials struct {
+	Host     string
+	Username string
+	Password string
+}
+
+// NormalizeHost returns host as credentials are saved under: lowercase,
+// without scheme nor trailing slash, Docker Hub's hosts as DockerHub. Image
+// references and paths are refused.
+func NormalizeHost(host string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(host))
+	normalized = strings.TrimPrefix(strings.TrimPrefix(normalized, "https://"), "http://")
+	normalized = strings.TrimSuffix(normalized, "/")
+	if dockerHubAliases[normalized] || normalized == "index.docker.io/v1" {
+		return DockerHub, nil
+	}
+	if !hostPattern.MatchString(normalized) || len(normalized) > 255 {
+		return "", fmt.Errorf("registry must be a host[:port] like registry.example.com, got %q", host)
+	}
+	return normalized, nil
+}
+
+// AuthKey is the key of host in docker auth configs: how the daemon looks up
+// the credentials of the registry an image reference names
+func AuthKey(host string) string {
+	if host == DockerHub {
+		return dockerHubAuthKey
+	}
+	return host
+}
+
+// endpoint is where the registry API of host is served
+func endpoint(host string) string {
+	if host == DockerHub {
+		return "registry-1.docker.io"
+	}
+	return host
+}
+
+// Load returns the decrypted registry credentials of a project
+func Load(projectID uint) ([]Credentials, error) {
+	var saved []models.RegistryCredential
+	if err := database.DB.Where("project_id = ?", projectID).Order("host").Find(&saved).Error; err != nil {
+		return nil, err
+	}
+	creds := make([]Credentials, 0, len(saved))
+	for _, credential := range saved {
+		password, err := secrets.Decrypt(credential.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the credentials of %s: %w", credential.Host, err)
+		}
+		creds = append(creds, Credentials{Host: credential.Host, Username: credential.Username, Password: password})
+	}
+	return creds, nil
+}
+
+// DockerConfigJSON renders creds as the .dockerconfigjson of a Kubernetes
+// pull Secret
+func DockerConfigJSON(creds []Credentials) ([]byte, error) {
+	type auth struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	auths := make(map[string]auth, len(creds))
+	for _, c := range creds {
+		auths[AuthKey(c.Host)] = auth{
+			Username: c.Username,
+			Password: c.Password,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password)),
+		}
+	}
+	return json.Marshal(map[string]interface{}{"auths": auths})
+}
+
+// DockerConfig is the .dockerconfigjson of a project's pull Secret, nil when
+// it has no registry credentials
+func DockerConfig(projectID uint) ([]byte, error) {
+	creds, err := Load(projectID)
+	if err != nil || len(creds) == 0 {
+		return nil, err
+	}
+	return DockerConfigJSON(creds)
+}
+
+// Verify checks the registry accepts creds the way docker login does: it
+// pings the registry API and, when it asks for credentials, signs in with
+// them directly or exchanges them for a token. A registry letting anyone in
+// can't tell, and passes. Rejected credentials return ErrRejected.
+func Verify(ctx context.Context, creds Credentials) error {
+	base := "https://" + endpoint(creds.Host)
+	if err := checkAddress(ctx, creds.Host, endpoint(creds.Host)); err != nil {
+		return err
+	}
+
+	resp, err := get(ctx, base+"/v2/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach the registry: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+	default:
+		return fmt.Errorf("%s doesn't look like a registry: /v2/ answered %s", creds.Host, resp.Status)
+	}
+
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch scheme {
+	case "basic":
+		if resp, err = get(ctx, base+"/v2/", &creds); err != nil {
+			return fmt.Errorf("failed to reach the registry: %w", err)
+		}
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || realm.Scheme != "https" || realm.Host == "" {
+			return fmt.Errorf("the registry asked for a token from an invalid realm %q", params["realm"])
+		}
+		if err := checkAddress(ctx, creds.Host, realm.Host); err != nil {
+			return err
+		}
+		query := realm.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		realm.RawQuery = query.Encode()
+		if resp, err = get(ctx, realm.String(), &creds); err != nil {
+			return fmt.Errorf("failed to reach the registry's token server: %w", err)
+		}
+	default:
+		return fmt.Errorf("the registry asked for unsupported %q authentication", scheme)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrRejected
+	default:
+		return fmt.Errorf("the registry answered %s to the credentials", resp.Status)
+	}
+}
+
+// checkAddress refuses to contact target (host[:port]) on a private
+// address, unless the registry host is one of REGISTRY_PRIVATE_HOSTS
+func checkAddress(ctx context.Context, host, target string) error {
+	if privateHosts[host] {
+		return nil
+	}
+	var private *netutil.PrivateAddressError
+	if err := netutil.CheckPublic(ctx, netutil.SplitHost(target)); errors.As(err, &private) {
+		return fmt.Errorf("%w: add %s to REGISTRY_PRIVATE_HOSTS to allow it", err, host)
+	} else if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", netutil.SplitHost(target), err)
+	}
+	return nil
+}
+
+// get sends a GET, with creds as basic auth if not nil. Only the status and
+// headers of the response are kept.
+func get(ctx context.Context, target string, creds *Credentials) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its lowercase scheme
+// and its parameters
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for _, match := range challengeParam.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	return strings.ToLower(scheme), params
+}
@@ -0,0 +1,218 @@
+package registry
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/testutil"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry is a registry API asking for credentials the way real ones
+// do: basic auth on /v2/ itself, or a bearer token from its /token realm
+type fakeRegistry struct {
+	challenge string // WWW-Authenticate scheme of /v2/: "basic", "bearer", "" = open
+	ping      int    // Status of /v2/ when not 401, 0 = 200
+	username  string
+	password  string
+	service   string // Of the last token request
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	valid := ok && username == f.username && password == f.password
+	switch r.URL.Path {
+	case "/v2/":
+		if f.ping != 0 {
+			w.WriteHeader(f.ping)
+			return
+		}
+		switch {
+		case f.challenge == "":
+		case f.challenge == "basic" && valid:
+		case f.challenge == "basic":
+			w.Header().Set("WWW-Authenticate", `Basic realm="Registry Realm"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("WWW-Authenticate", f.challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	case "/token":
+		f.service = r.URL.Query().Get("service")
+		if !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "registry-token"})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveRegistry serves f over TLS as host (127.0.0.1:port), trusted by the
+// package's client and allowed on its private address
+func serveRegistry(t *testing.T, f *fakeRegistry) string {
+	t.Helper()
+	server := httptest.NewTLSServer(f)
+	t.Cleanup(server.Close)
+	previous := httpClient
+	httpClient = server.Client()
+	httpClient.CheckRedirect = newHTTPClient().CheckRedirect
+	host := strings.TrimPrefix(server.URL, "https://")
+	Init(&config.Config{RegistryPrivateHosts: []string{host}})
+	t.Cleanup(func() {
+		httpClient = previous
+		Init(&config.Config{})
+	})
+	return host
+}
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"registry.example.com", "registry.example.com"},
+		{" Registry.Example.com:5000/ ", "registry.example.com:5000"},
+		{"https://ghcr.io", "ghcr.io"},
+		{"docker.io", DockerHub},
+		{"index.docker.io", DockerHub},
+		{"https://index.docker.io/v1/", DockerHub},
+		{"registry-1.docker.io", DockerHub},
+	}
+	for _, tt := range tests {
+		if got, err := NormalizeHost(tt.host); err != nil || got != tt.want {
+			t.Errorf("NormalizeHost(%q) = %q, %v, want %q", tt.host, got, err, tt.want)
+		}
+	}
+	for _, host := range []string{"", "registry.example.com/team/base", "registry.example.com:5000:1", "-bad.example.com", "registry example.com"} {
+		if got, err := NormalizeHost(host); err == nil {
+			t.Errorf("NormalizeHost(%q) = %q", host, got)
+		}
+	}
+}
+
+// The pull Secret and the build's auth configs key Docker Hub the way the
+// daemon and the kubelet look it up
+func TestDockerConfigJSON(t *testing.T) {
+	raw, err := DockerConfigJSON([]Credentials{
+		{Host: DockerHub, Username: "acme", Password: "hub-token"},
+		{Host: "registry.example.com:5000", Username: "ci", Password: "p@ss:word"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		t.Fatal(err)
+	}
+	hub := config.Auths["https://index.docker.io/v1/"]
+	private := config.Auths["registry.example.com:5000"]
+	if len(config.Auths) != 2 || hub.Username != "acme" || hub.Password != "hub-token" {
+		t.Fatalf("auths %+v", config.Auths)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(private.Auth); string(decoded) != "ci:p@ss:word" {
+		t.Errorf("auth %q", decoded)
+	}
+	if AuthKey("ghcr.io") != "ghcr.io" || AuthKey(DockerHub) != "https://index.docker.io/v1/" {
+		t.Errorf("auth keys %q, %q", AuthKey("ghcr.io"), AuthKey(DockerHub))
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		registry  fakeRegistry
+		password  string
+		err       error  // Wanted, for ErrRejected
+		errPrefix string // Wanted, for other errors
+	}{
+		{name: "open", registry: fakeRegistry{}, password: "anything"},
+		{name: "basic", registry: fakeRegistry{challenge: "basic"}, password: "s3cret"},
+		{name: "basic rejected", registry: fakeRegistry{challenge: "basic"}, password: "wrong", err: ErrRejected},
+		{name: "token", registry: fakeRegistry{challenge: `Bearer realm="{realm}",service="registry.example.com"`}, password: "s3cret"},
+		{name: "token rejected", registry: fakeRegistry{challenge: `Bearer realm="{realm}",service="registry.example.com"`}, password: "wrong", err: ErrRejected},
+		{name: "plain http realm", registry: fakeRegistry{challenge: `Bearer realm="http://auth.example.com/token"`}, password: "s3cret", errPrefix: `the registry asked for a token from an invalid realm "http://auth.example.com/token"`},
+		{name: "unsupported scheme", registry: fakeRegistry{challenge: `Negotiate`}, password: "s3cret", errPrefix: `the registry asked for unsupported "negotiate" authentication`},
+		{name: "not a registry", registry: fakeRegistry{ping: http.StatusNotFound}, password: "s3cret", errPrefix: "doesn't look like a registry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := tt.registry
+			fake.username, fake.password = "ci", "s3cret"
+			host := serveRegistry(t, &fake)
+			fake.challenge = strings.ReplaceAll(fake.challenge, "{realm}", "https://"+host+"/token")
+
+			err := Verify(context.Background(), Credentials{Host: host, Username: "ci", Password: tt.password})
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("got %v, want %v", err, tt.err)
+				}
+			case tt.errPrefix != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errPrefix) {
+					t.Errorf("got %v, want %q", err, tt.errPrefix)
+				}
+			case err != nil:
+				t.Errorf("got %v", err)
+			}
+			if strings.HasPrefix(tt.name, "token") && fake.service != "registry.example.com" {
+				t.Errorf("token requested for service %q", fake.service)
+			}
+		})
+	}
+}
+
+// Registries on private addresses are only contacted when allowed
+func TestVerifyPrivateAddress(t *testing.T) {
+	host := serveRegistry(t, &fakeRegistry{})
+	Init(&config.Config{})
+	err := Verify(context.Background(), Credentials{Host: host, Username: "ci", Password: "s3cret"})
+	if err == nil || !strings.Contains(err.Error(), "is a loopback address: add "+host+" to REGISTRY_PRIVATE_HOSTS") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// Credentials are stored encrypted and loaded decrypted, in host order
+func TestLoad(t *testing.T) {
+	testutil.DB(t)
+	if err := secrets.Init(&config.Config{EncryptionKey: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Credentials{{"registry.example.com", "ci", "s3cret"}, {DockerHub, "acme", "hub-token"}} {
+		encrypted, _ := secrets.Encrypt(c.Password)
+		database.DB.Create(&models.RegistryCredential{ProjectID: 7, Host: c.Host, Username: c.Username, Password: encrypted})
+	}
+	// Sealed under another ENCRYPTION_KEY
+	sealed, _ := secrets.Encrypt("other")
+	database.DB.Create(&models.RegistryCredential{ProjectID: 8, Host: "ghcr.io", Username: "other", Password: sealed[:len(sealed)-4] + "AAA="})
+
+	creds, err := Load(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Credentials{{DockerHub, "acme", "hub-token"}, {"registry.example.com", "ci", "s3cret"}}
+	if len(creds) != 2 || creds[0] != want[0] || creds[1] != want[1] {
+		t.Errorf("loaded %+v", creds)
+	}
+	if config, err := DockerConfig(9); err != nil || config != nil {
+		t.Errorf("project without credentials: %s, %v", config, err)
+	}
+	if _, err := Load(8); err == nil {
+		t.Error("loaded credentials that don't decrypt")
+	}
+}
@@ -0,0 +1,142 @@
+package search
+
+import (
+	"deploy-platform/internal/models"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Result types, for the type: operator
+const (
+	TypeProject    = "project"
+	TypeDeployment = "deployment"
+)
+
+const (
+	maxQuery    = 256 // Bytes of a query
+	maxTerms    = 8
+	minTermRune = 2 // Shorter terms match nearly everything
+)
+
+// statuses are the deployment statuses status: accepts
+var statuses = []models.DeploymentStatus{
+	models.StatusPending, models.StatusQueued, models.StatusBuilding, models.StatusAwaitingApproval, models.StatusDeploying,
+	models.StatusDeployed, models.StatusFailed, models.StatusCancelled, models.StatusRejected, models.StatusSkipped,
+	models.StatusSuperseded, models.StatusPolicyBlocked, models.StatusDeployPending,
+}
+
+// Query is a parsed search query: free-text terms, each of which a result
+// must match, and the operators narrowing the results down
+type Query struct {
+	Terms    []string                  // Lowercased; "quoted phrases" are one term
+	Type     string                    // type:project or type:deployment, "" = both
+	Statuses []models.DeploymentStatus // status:failed, repeatable; deployments only
+	Branch   string                    // branch:main, exact; deployments only
+	Project  string                    // project:<slug>, exact
+}
+
+// Parse parses q, e.g. `migration type:deployment status:failed
+// branch:main "add users table"`. Words with an unknown operator, like
+// "fix:", and quoted phrases are searched as text: commit messages are full
+// of both.
+func Parse(q string) (*Query, error) {
+	if len(q) > maxQuery {
+		return nil, fmt.Errorf("query must be at most %d bytes", maxQuery)
+	}
+	query := &Query{}
+	for _, word := range splitWords(q) {
+		key, value, found := strings.Cut(word.text, ":")
+		found = found && !word.quoted
+		switch key = strings.ToLower(key); {
+		case found && key == "type":
+			switch value = strings.ToLower(strings.TrimSuffix(value, "s")); value {
+			case TypeProject, TypeDeployment:
+				query.Type = value
+			default:
+				return nil, fmt.Errorf("type must be %s or %s, got %q", TypeProject, TypeDeployment, value)
+			}
+		case found && key == "status":
+			status := models.DeploymentStatus(strings.ToLower(value))
+			if !slices.Contains(statuses, status) {
+				return nil, fmt.Errorf("unknown status %q", value)
+			}
+			query.Statuses = append(query.Statuses, status)
+		case found && key == "branch":
+			if value == "" {
+				return nil, fmt.Errorf("branch: needs a branch name")
+			}
+			query.Branch = value
+		case found && key == "project":
+			if value == "" {
+				return nil, fmt.Errorf("project: needs a project slug")
+			}
+			query.Project = strings.ToLower(value)
+		default:
+			term := strings.ToLower(word.text)
+			if utf8.RuneCountInString(term) < minTermRune {
+				continue
+			}
+			if len(query.Terms) == maxTerms {
+				return nil, fmt.Errorf("query must have at most %d search terms", maxTerms)
+			}
+			query.Terms = append(query.Terms, term)
+		}
+	}
+
+	if query.Type == TypeProject && query.deploymentOnly() {
+		return nil, fmt.Errorf("status: and branch: only apply to deployments")
+	}
+	if len(query.Terms) == 0 && query.Type == "" && len(query.Statuses) == 0 && query.Branch == "" && query.Project == "" {
+		return nil, fmt.Errorf("query needs a search term of at least %d characters, or an operator", minTermRune)
+	}
+	return query, nil
+}
+
+// Projects reports whether projects are searched
+func (q *Query) Projects() bool {
+	return q.Type != TypeDeployment && !q.deploymentOnly()
+}
+
+// Deployments reports whether deployments are searched
+func (q *Query) Deployments() bool {
+	return q.Type != TypeProject
+}
+
+// deploymentOnly reports whether operators only deployments have are set
+func (q *Query) deploymentOnly() bool {
+	return len(q.Statuses) > 0 || q.Branch != ""
+}
+
+type word struct {
+	text   string
+	quoted bool
+}
+
+// splitWords splits q on whitespace, keeping "quoted phrases" together
+// without their quotes. An unterminated quote runs to the end.
+func splitWords(q string) []word {
+	var words []word
+	var text strings.Builder
+	quoted := false
+	flush := func() {
+		if text.Len() > 0 {
+			words = append(words, word{text: text.String(), quoted: quoted})
+			text.Reset()
+		}
+	}
+	for _, char := range q {
+		switch {
+		case char == '"':
+			flush()
+			quoted = !quoted
+		case !quoted && (char == ' ' || char == '\t' || char == '\n' || char == '\r'):
+			flush()
+		default:
+			text.WriteRune(char)
+		}
+	}
+	flush()
+	return words
+}
@@ -0,0 +1,268 @@
+package search
+
+import (
+	"deploy-platform/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// field is a column results are searched and highlighted in
+type field struct {
+	Name       string // In highlights
+	Column     string
+	Weight     int
+	PrefixOnly bool // Commit SHAs: only "abc123" finds "abc1234…", and only hex terms
+}
+
+// Searched fields. A term scores its weight times 3 on an exact match of a
+// field, times 2 on a prefix and times 1 anywhere else, keeping its best
+// field; a result's score is the sum over the terms.
+var (
+	projectFields = []field{
+		{Name: "name", Column: "name", Weight: 3},
+		{Name: "slug", Column: "slug", Weight: 3},
+		{Name: "repo_url", Column: "repo_url", Weight: 1},
+	}
+	deploymentFields = []field{
+		{Name: "commit_sha", Column: "commit_sha", Weight: 4, PrefixOnly: true},
+		{Name: "branch", Column: "branch", Weight: 2},
+		{Name: "commit_msg", Column: "commit_msg", Weight: 2},
+		{Name: "failure_category", Column: "failure_category", Weight: 1},
+		{Name: "failure_detail", Column: "failure_detail", Weight: 1},
+	}
+)
+
+// minSHAPrefix is the shortest term matched against commit SHAs
+const minSHAPrefix = 4
+
+// Highlight is a match in a field of a result, for the UI to emphasize.
+// Offsets count characters (code points), End excluded.
+type Highlight struct {
+	Field string `json:"field"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// ProjectHit is a project found by a search
+type ProjectHit struct {
+	Project    models.Project
+	Score      int
+	Highlights []Highlight
+}
+
+// DeploymentHit is a deployment found by a search, with its project's ID,
+// name and slug loaded
+type DeploymentHit struct {
+	Deployment models.Deployment
+	Score      int
+	Highlights []Highlight
+}
+
+// Results are the results of a search, best first, ties most recent first
+type Results struct {
+	Projects        []ProjectHit
+	Deployments     []DeploymentHit
+	MoreProjects    bool // More projects matched than the limit
+	MoreDeployments bool
+}
+
+type projectRow struct {
+	models.Project
+	Score int
+}
+
+type deploymentRow struct {
+	models.Deployment
+	Score int
+}
+
+// Run searches the projects and deployments of the user userID for q, at
+// most limit of each. Ownership is part of the queries, so other users'
+// rows are never read. Matching is a case-insensitive LIKE on every
+// dialect; on Postgres, trigram indexes created by database.InitDB serve it.
+func Run(db *gorm.DB, userID uint, q *Query, limit int) (*Results, error) {
+	results := &Results{}
+	if q.Projects() {
+		tx := db.Model(&models.Project{}).Where("user_id = ?", userID)
+		if q.Project != "" {
+			tx = tx.Where("slug = ?", q.Project)
+		}
+		var rows []projectRow
+		if err := match(tx, "projects", projectFields, q.Terms).Limit(limit + 1).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		if len(rows) > limit {
+			rows, results.MoreProjects = rows[:limit], true
+		}
+		for _, row := range rows {
+			results.Projects = append(results.Projects, ProjectHit{
+				Project:    row.Project,
+				Score:      row.Score,
+				Highlights: highlight(projectFields, q.Terms, row.Name, row.Slug, row.RepoURL),
+			})
+		}
+	}
+
+	if q.Deployments() {
+		owned := db.Model(&models.Project{}).Select("id").Where("user_id = ?", userID)
+		if q.Project != "" {
+			owned = owned.Where("slug = ?", q.Project)
+		}
+		tx := db.Model(&models.Deployment{}).Where("project_id IN (?)", owned)
+		if len(q.Statuses) > 0 {
+			tx = tx.Where("status IN ?", q.Statuses)
+		}
+		if q.Branch != "" {
+			tx = tx.Where("branch = ?", q.Branch)
+		}
+		var rows []deploymentRow
+		if err := match(tx, "deployments", deploymentFields, q.Terms).Limit(limit + 1).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		if len(rows) > limit {
+			rows, results.MoreDeployments = rows[:limit], true
+		}
+
+		projects := map[uint]models.Project{}
+		ids := make([]uint, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ProjectID)
+		}
+		if len(ids) > 0 {
+			var found []models.Project
+			if err := db.Select("id", "name", "slug", "archived_at").Where("id IN ? AND user_id = ?", ids, userID).Find(&found).Error; err != nil {
+				return nil, err
+			}
+			for _, project := range found {
+				projects[project.ID] = project
+			}
+		}
+		for _, row := range rows {
+			row.Deployment.Project = projects[row.ProjectID]
+			results.Deployments = append(results.Deployments, DeploymentHit{
+				Deployment: row.Deployment,
+				Score:      row.Score,
+				Highlights: highlight(deploymentFields, q.Terms, row.CommitSHA, row.Branch, row.CommitMsg, row.FailureCategory, row.FailureDetail),
+			})
+		}
+	}
+	return results, nil
+}
+
+// match narrows tx, a query of table, down to the rows where every term
+// matches one of fields, selecting each row's score and ordering by it
+func match(tx *gorm.DB, table string, fields []field, terms []string) *gorm.DB {
+	var scores []string
+	var scoreVars []interface{}
+	for _, term := range terms {
+		var conditions []string
+		var conditionVars []interface{}
+		score := "CASE"
+		for _, arm := range arms(fields, term) {
+			score += " WHEN " + arm.condition + " THEN " + strconv.Itoa(arm.score)
+			scoreVars = append(scoreVars, arm.pattern)
+			if arm.loosest {
+				conditions = append(conditions, arm.condition)
+				conditionVars = append(conditionVars, arm.pattern)
+			}
+		}
+		scores = append(scores, score+" ELSE 0 END")
+		tx = tx.Where("("+strings.Join(conditions, " OR ")+")", conditionVars...)
+	}
+
+	if len(scores) == 0 {
+		tx = tx.Select(table + ".*, 0 AS score")
+	} else {
+		tx = tx.Select(table+".*, ("+strings.Join(scores, " + ")+") AS score", scoreVars...)
+	}
+	return tx.Order("score DESC, " + table + ".created_at DESC, " + table + ".id DESC")
+}
+
+// arm is one way a term can match a field
+type arm struct {
+	condition string
+	pattern   string
+	score     int
+	loosest   bool // The widest match of its field: matching the term means matching one of these
+}
+
+// arms lists the ways term matches fields, best scoring first
+func arms(fields []field, term string) []arm {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+	var all []arm
+	for _, f := range fields {
+		column := "LOWER(" + f.Column + ")"
+		if f.PrefixOnly {
+			if !isHex(term) || len(term) < minSHAPrefix {
+				continue
+			}
+			all = append(all, arm{condition: column + ` LIKE ? ESCAPE '\'`, pattern: escaped + "%", score: 2 * f.Weight, loosest: true})
+			continue
+		}
+		all = append(all,
+			arm{condition: column + " = ?", pattern: term, score: 3 * f.Weight},
+			arm{condition: column + ` LIKE ? ESCAPE '\'`, pattern: escaped + "%", score: 2 * f.Weight},
+			arm{condition: column + ` LIKE ? ESCAPE '\'`, pattern: "%" + escaped + "%", score: f.Weight, loosest: true},
+		)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].score > all[j].score })
+	return all
+}
+
+// highlight finds where terms occur in values, the values of fields in
+// order, merging overlapping matches
+func highlight(fields []field, terms []string, values ...string) []Highlight {
+	highlights := []Highlight{}
+	for i, f := range fields {
+		value := []rune(values[i])
+		var ranges [][2]int
+		for _, term := range terms {
+			needle := []rune(term)
+			if f.PrefixOnly {
+				if isHex(term) && len(term) >= minSHAPrefix && hasRunePrefix(value, needle) {
+					ranges = append(ranges, [2]int{0, len(needle)})
+				}
+				continue
+			}
+			for start := 0; start+len(needle) <= len(value); start++ {
+				if hasRunePrefix(value[start:], needle) {
+					ranges = append(ranges, [2]int{start, start + len(needle)})
+				}
+			}
+		}
+		sort.Slice(ranges, func(a, b int) bool { return ranges[a][0] < ranges[b][0] })
+		for _, r := range ranges {
+			if n := len(highlights); n > 0 && highlights[n-1].Field == f.Name && r[0] <= highlights[n-1].End {
+				highlights[n-1].End = max(highlights[n-1].End, r[1])
+				continue
+			}
+			highlights = append(highlights, Highlight{Field: f.Name, Start: r[0], End: r[1]})
+		}
+	}
+	return highlights
+}
+
+func hasRunePrefix(s, prefix []rune) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i, char := range prefix {
+		if unicode.ToLower(s[i]) != char {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for _, char := range s {
+		if !strings.ContainsRune("0123456789abcdef", char) {
+			return false
+		}
+	}
+	return s != ""
+}
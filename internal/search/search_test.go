@@ -0,0 +1,184 @@
+package search
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q       string
+		want    *Query
+		invalid bool
+	}{
+		{q: `migration type:deployment status:failed branch:main`, want: &Query{Terms: []string{"migration"}, Type: TypeDeployment, Statuses: []models.DeploymentStatus{models.StatusFailed}, Branch: "main"}},
+		{q: `"Add users table" fix: x`, want: &Query{Terms: []string{"add users table", "fix:"}}},
+		{q: `status:Failed status:cancelled`, want: &Query{Statuses: []models.DeploymentStatus{models.StatusFailed, models.StatusCancelled}}},
+		{q: `type:projects project:App`, want: &Query{Type: TypeProject, Project: "app"}},
+		{q: `"type:project"`, want: &Query{Terms: []string{"type:project"}}},
+		{q: `type:build`, invalid: true},
+		{q: `status:exploded`, invalid: true},
+		{q: `branch:`, invalid: true},
+		{q: `type:project status:failed`, invalid: true},
+		{q: `a`, invalid: true}, // Too short to search
+		{q: strings.Repeat("ab ", 9), invalid: true},
+		{q: strings.Repeat("x", maxQuery+1), invalid: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.q)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", tt.q, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, %v, want %+v", tt.q, got, err, tt.want)
+		}
+	}
+}
+
+// searchFixture creates ada's and bob's projects and deployments, alike so
+// that any search of ada's would find bob's if ownership weren't enforced
+func searchFixture(t *testing.T) (ada, bob *models.User) {
+	t.Helper()
+	testutil.DB(t)
+	ada = &models.User{Username: "ada", Email: "ada@example.com"}
+	bob = &models.User{Username: "bob", Email: "bob@example.com"}
+	database.DB.Create(ada)
+	database.DB.Create(bob)
+	for _, user := range []*models.User{ada, bob} {
+		for _, name := range []string{"shop", "shop-api", "blog"} {
+			project := &models.Project{Name: name, Slug: user.Username + "-" + name, RepoURL: "https://github.com/" + user.Username + "/" + name, UserID: user.ID}
+			database.DB.Create(project)
+			for _, d := range []models.Deployment{
+				{CommitSHA: "abcdef1234", Branch: "main", CommitMsg: "Add users table migration", Status: models.StatusFailed, FailureDetail: "migration failed"},
+				{CommitSHA: "0123456789", Branch: "feature/migration", CommitMsg: "Fix typo", Status: models.StatusDeployed},
+			} {
+				d.ProjectID = project.ID
+				database.DB.Create(&d)
+			}
+		}
+	}
+	return ada, bob
+}
+
+func run(t *testing.T, userID uint, q string, limit int) *Results {
+	t.Helper()
+	query, err := Parse(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Run(database.DB, userID, query, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+// Other users' projects and deployments never appear, whatever the query
+func TestRunOwnership(t *testing.T) {
+	ada, bob := searchFixture(t)
+	var bobsProjects []uint
+	database.DB.Model(&models.Project{}).Where("user_id = ?", bob.ID).Pluck("id", &bobsProjects)
+	owned := func(projectID uint) bool {
+		for _, id := range bobsProjects {
+			if id == projectID {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, q := range []string{
+		"shop", "migration", "abcdef", "github.com", "bob", "type:project", "type:deployment",
+		"status:failed", "branch:main", "project:bob-shop", "project:bob-shop migration",
+		`"users table" status:failed branch:main`,
+	} {
+		results := run(t, ada.ID, q, 50)
+		for _, hit := range results.Projects {
+			if hit.Project.UserID != ada.ID {
+				t.Errorf("%q found project %d of user %d", q, hit.Project.ID, hit.Project.UserID)
+			}
+		}
+		for _, hit := range results.Deployments {
+			if !owned(hit.Deployment.ProjectID) || hit.Deployment.Project.ID != hit.Deployment.ProjectID {
+				t.Errorf("%q found deployment %d of project %d", q, hit.Deployment.ID, hit.Deployment.ProjectID)
+			}
+		}
+	}
+	if results := run(t, ada.ID, "project:bob-shop", 50); len(results.Projects)+len(results.Deployments) != 0 {
+		t.Errorf("another user's project by slug: %+v", results)
+	}
+	if results := run(t, ada.ID, "bob", 50); len(results.Projects) != 0 {
+		t.Errorf("another user's repository matched: %+v", results.Projects)
+	}
+}
+
+func TestRunOperatorsAndRanking(t *testing.T) {
+	ada, _ := searchFixture(t)
+
+	results := run(t, ada.ID, "shop", 50)
+	var slugs []string
+	for _, hit := range results.Projects {
+		slugs = append(slugs, hit.Project.Slug)
+	}
+	// The exact name first, then the prefix
+	if strings.Join(slugs, " ") != "ada-shop ada-shop-api" || results.Projects[0].Score <= results.Projects[1].Score {
+		t.Errorf("shop found %v", results.Projects)
+	}
+	if hl := results.Projects[0].Highlights; len(hl) == 0 || hl[0] != (Highlight{Field: "name", Start: 0, End: 4}) {
+		t.Errorf("highlights %+v", hl)
+	}
+
+	results = run(t, ada.ID, "migration status:failed branch:main", 50)
+	if results.Projects != nil || len(results.Deployments) != 3 {
+		t.Fatalf("found %d projects, %d deployments", len(results.Projects), len(results.Deployments))
+	}
+	for _, hit := range results.Deployments {
+		if hit.Deployment.Status != models.StatusFailed || hit.Deployment.Branch != "main" {
+			t.Errorf("found %+v", hit.Deployment)
+		}
+	}
+
+	// Ties rank most recent first, the same on every search
+	first := run(t, ada.ID, "migration", 50)
+	if len(first.Deployments) != 6 {
+		t.Fatalf("migration found %d deployments", len(first.Deployments))
+	}
+	for i := 0; i < 3; i++ {
+		again := run(t, ada.ID, "migration", 50)
+		for j, hit := range again.Deployments {
+			if hit.Deployment.ID != first.Deployments[j].Deployment.ID {
+				t.Fatalf("search %d ranked deployment %d at %d, first %d", i, hit.Deployment.ID, j, first.Deployments[j].Deployment.ID)
+			}
+		}
+	}
+	for j := 1; j < len(first.Deployments); j++ {
+		prev, hit := first.Deployments[j-1], first.Deployments[j]
+		if hit.Score > prev.Score || (hit.Score == prev.Score && hit.Deployment.ID > prev.Deployment.ID) {
+			t.Errorf("deployment %d (%d) ranked after %d (%d)", hit.Deployment.ID, hit.Score, prev.Deployment.ID, prev.Score)
+		}
+	}
+
+	// SHAs match by prefix only
+	if results := run(t, ada.ID, "abcdef type:deployment", 50); len(results.Deployments) != 3 {
+		t.Errorf("SHA prefix found %d deployments", len(results.Deployments))
+	}
+	if results := run(t, ada.ID, "cdef12 type:deployment", 50); len(results.Deployments) != 0 {
+		t.Errorf("the middle of a SHA found %d deployments", len(results.Deployments))
+	}
+
+	// Capped, telling there are more
+	if results := run(t, ada.ID, "migration", 2); len(results.Deployments) != 2 || !results.MoreDeployments {
+		t.Errorf("limit 2 returned %d, more %v", len(results.Deployments), results.MoreDeployments)
+	}
+	// LIKE wildcards are searched literally
+	if results := run(t, ada.ID, "%% type:deployment", 50); len(results.Deployments) != 0 {
+		t.Errorf("%% found %d deployments", len(results.Deployments))
+	}
+}
@@ -0,0 +1,154 @@
+package secretref
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"deploy-platform/internal/httpclient"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AWSSecretsManager reads secrets of AWS Secrets Manager:
+// ref+awssm://arn:aws:secretsmanager:eu-west-1:123456789012:secret:app
+// returns the secret string, and #key a field of a secret holding a JSON
+// object. Names work too, in the configured region; ARNs are read from the
+// region they name. Requests are signed with AWS Signature Version 4.
+type AWSSecretsManager struct {
+	endpoint     string // "" = the regional AWS endpoint
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// NewAWSSecretsManager creates the provider of region's Secrets Manager; an
+// endpoint, e.g. of LocalStack, replaces the AWS one
+func NewAWSSecretsManager(endpoint, region, accessKey, secretKey, sessionToken string) *AWSSecretsManager {
+	return &AWSSecretsManager{
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		client:       httpclient.New("awssm"),
+	}
+}
+
+// Resolve calls GetSecretValue for ref.Path
+func (a *AWSSecretsManager) Resolve(ctx context.Context, ref Ref) (string, error) {
+	region := a.region
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if parts := strings.Split(ref.Path, ":"); len(parts) >= 7 && parts[0] == "arn" && parts[3] != "" {
+		region = parts[3]
+	}
+	endpoint := a.endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint + "/")
+	if err != nil {
+		return "", fmt.Errorf("awssm: invalid endpoint: %w", err)
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": ref.Path})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	a.sign(req, region, body, time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("awssm: %w", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		SecretString *string `json:"SecretString"`
+		Type         string  `json:"__type"`
+		Message      string  `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("awssm %s: invalid response", ref.Path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Error types look like com.amazonaws...#ResourceNotFoundException
+		kind := out.Type[strings.LastIndex(out.Type, "#")+1:]
+		return "", fmt.Errorf("awssm %s: %s: %s %s", ref.Path, resp.Status, kind, out.Message)
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("awssm %s: binary secrets are not supported", ref.Path)
+	}
+	if ref.Key == "" {
+		return *out.SecretString, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("the secret %s is not a JSON object, it has no field %q", ref.Path, ref.Key)
+	}
+	return field(fields, ref)
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (a *AWSSecretsManager) sign(req *http.Request, region string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+
+	// Canonical headers, sorted by name
+	headers := []string{"content-type", "host", "x-amz-date"}
+	values := map[string]string{"content-type": req.Header.Get("Content-Type"), "host": req.URL.Host, "x-amz-date": amzDate}
+	if a.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+		values["x-amz-security-token"] = a.sessionToken
+	}
+	headers = append(headers, "x-amz-target")
+	values["x-amz-target"] = req.Header.Get("X-Amz-Target")
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/secretsmanager/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+a.secretKey), date)
+	for _, part := range []string{region, "secretsmanager", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
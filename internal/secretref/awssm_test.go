@@ -0,0 +1,167 @@
+package secretref
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	awsAccessKey = "AKIDEXAMPLE"
+	awsSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// fakeSecretsManager answers GetSecretValue for its secrets, by name or ARN,
+// checking the Signature Version 4 of each request the way AWS does
+type fakeSecretsManager struct {
+	*httptest.Server
+	secrets map[string]*string // SecretString by secret ID, nil for a binary secret
+
+	mu      sync.Mutex
+	regions []string // Of the credential scopes requests were signed for
+	tokens  []string // Session tokens sent
+}
+
+func newFakeSecretsManager(t *testing.T) *fakeSecretsManager {
+	s := &fakeSecretsManager{secrets: map[string]*string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeSecretsManager) serve(w http.ResponseWriter, r *http.Request) {
+	fail := func(code int, kind, message string) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.secretsmanager#" + kind, "message": message})
+	}
+	body, _ := io.ReadAll(r.Body)
+	region, ok := verifySignature(r, body)
+	if !ok {
+		fail(http.StatusForbidden, "InvalidSignatureException", "The request signature we calculated does not match the signature you provided.")
+		return
+	}
+	s.mu.Lock()
+	s.regions = append(s.regions, region)
+	s.tokens = append(s.tokens, r.Header.Get("X-Amz-Security-Token"))
+	s.mu.Unlock()
+	if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+		fail(http.StatusBadRequest, "InvalidAction", "unknown action")
+		return
+	}
+	var in struct{ SecretId string }
+	json.Unmarshal(body, &in)
+	secret, ok := s.secrets[in.SecretId]
+	if !ok {
+		fail(http.StatusBadRequest, "ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+		return
+	}
+	out := map[string]interface{}{"Name": in.SecretId, "VersionId": "v1"}
+	if secret != nil {
+		out["SecretString"] = *secret
+	} else {
+		out["SecretBinary"] = "AAEC"
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// verifySignature checks the Authorization header of a request signed
+// with awsSecretKey, returning the region of its credential scope
+func verifySignature(r *http.Request, body []byte) (string, bool) {
+	var credential, signedHeaders, signature string
+	for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			signature = value
+		}
+	}
+	scope := strings.Split(credential, "/") // Key ID, date, region, service, aws4_request
+	if len(scope) != 5 || scope[0] != awsAccessKey || scope[3] != "secretsmanager" || scope[4] != "aws4_request" {
+		return "", false
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	payload := sha256.Sum256(body)
+	request := sha256.Sum256([]byte(strings.Join([]string{
+		r.Method, r.URL.EscapedPath(), r.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payload[:]),
+	}, "\n")))
+	toSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + strings.Join(scope[1:], "/") + "\n" + hex.EncodeToString(request[:])
+
+	key := []byte("AWS4" + awsSecretKey)
+	for _, part := range append(scope[1:], toSign) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return scope[2], hmac.Equal([]byte(hex.EncodeToString(key)), []byte(signature))
+}
+
+func secretString(s string) *string {
+	return &s
+}
+
+func TestAWSSecretsManagerContract(t *testing.T) {
+	sm := newFakeSecretsManager(t)
+	sm.secrets["prod/app"] = secretString(`{"password": "hunter2", "user": "ada", "port": 5432}`)
+	contract(t, NewAWSSecretsManager(sm.URL, "eu-west-1", awsAccessKey, awsSecretKey, ""), "prod/app", "prod/missing")
+}
+
+func TestAWSSecretsManager(t *testing.T) {
+	sm := newFakeSecretsManager(t)
+	arn := "arn:aws:secretsmanager:us-east-2:123456789012:secret:prod/app-AbCdEf"
+	sm.secrets[arn] = secretString(`{"password": "hunter2"}`)
+	sm.secrets["api-key"] = secretString("hunter2")
+	sm.secrets["certificate"] = nil
+	ctx := context.Background()
+	provider := NewAWSSecretsManager(sm.URL+"/", "eu-west-1", awsAccessKey, awsSecretKey, "session")
+
+	// ARNs are read from their region, names from the configured one
+	if value, err := provider.Resolve(ctx, Ref{Path: arn, Key: "password"}); err != nil || value != "hunter2" {
+		t.Errorf("got %q, %v", value, err)
+	}
+	// Without a key, the secret string as it is
+	if value, err := provider.Resolve(ctx, Ref{Path: "api-key"}); err != nil || value != "hunter2" {
+		t.Errorf("got %q, %v", value, err)
+	}
+	if strings.Join(sm.regions, ",") != "us-east-2,eu-west-1" || sm.tokens[0] != "session" {
+		t.Errorf("signed for %q with tokens %q", sm.regions, sm.tokens)
+	}
+
+	failures := []struct {
+		provider *AWSSecretsManager
+		ref      Ref
+		want     string
+	}{
+		{provider, Ref{Path: "api-key", Key: "password"}, `the secret api-key is not a JSON object, it has no field "password"`},
+		{provider, Ref{Path: "certificate"}, "awssm certificate: binary secrets are not supported"},
+		{provider, Ref{Path: "missing"}, "awssm missing: 400 Bad Request: ResourceNotFoundException Secrets Manager can't find the specified secret."},
+		{
+			NewAWSSecretsManager(sm.URL, "eu-west-1", awsAccessKey, "wrong", ""), Ref{Path: "api-key"},
+			"awssm api-key: 403 Forbidden: InvalidSignatureException The request signature we calculated does not match the signature you provided.",
+		},
+	}
+	for _, f := range failures {
+		if _, err := f.provider.Resolve(ctx, f.ref); err == nil || err.Error() != f.want {
+			t.Errorf("%+v: got %v, want %q", f.ref, err, f.want)
+		}
+	}
+}
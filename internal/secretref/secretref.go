@@ -0,0 +1,131 @@
+// Package secretref resolves env var values that reference a secret kept
+// elsewhere, e.g. ref+vault://secret/data/app#password or
+// ref+awssm://arn:aws:secretsmanager:...:secret:app, instead of holding it.
+// Only the reference is stored; the value is fetched from its provider at
+// deploy time and never written to the database.
+package secretref
+
+import (
+	"context"
+	"crypto/sha256"
+	"deploy-platform/internal/config"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Prefix marks env var values that are references
+const Prefix = "ref+"
+
+// Ref is a parsed reference: ref+<scheme>://<path>[#<key>]
+type Ref struct {
+	Scheme string // Provider, e.g. vault or awssm
+	Path   string // Where the provider keeps the secret
+	Key    string // Field of the secret, "" = the whole secret
+}
+
+// String is the reference as stored
+func (r Ref) String() string {
+	s := Prefix + r.Scheme + "://" + r.Path
+	if r.Key != "" {
+		s += "#" + r.Key
+	}
+	return s
+}
+
+// Provider fetches the secrets of one scheme. Errors must not include the
+// value of the secret.
+type Provider interface {
+	Resolve(ctx context.Context, ref Ref) (string, error)
+}
+
+// IsRef reports whether value is a reference rather than a literal value
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Parse parses a reference
+func Parse(value string) (Ref, error) {
+	if !IsRef(value) {
+		return Ref{}, errors.New("not a secret reference: it must start with " + Prefix)
+	}
+	scheme, rest, ok := strings.Cut(strings.TrimPrefix(value, Prefix), "://")
+	if !ok || scheme == "" {
+		return Ref{}, errors.New("invalid secret reference: expected ref+<provider>://<path>[#<key>]")
+	}
+	path, key, _ := strings.Cut(rest, "#")
+	if path == "" {
+		return Ref{}, errors.New("invalid secret reference: the path is empty")
+	}
+	return Ref{Scheme: scheme, Path: path, Key: key}, nil
+}
+
+// Hash is a digest of a resolved value, recorded to tell when the secret
+// behind a reference changed between deployments
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Resolver resolves references with the providers configured on the platform
+type Resolver struct {
+	providers map[string]Provider
+}
+
+// NewResolver creates a resolver without providers
+func NewResolver() *Resolver {
+	return &Resolver{providers: map[string]Provider{}}
+}
+
+// FromConfig creates a resolver with the providers configured in cfg
+func FromConfig(cfg *config.Config) *Resolver {
+	r := NewResolver()
+	if cfg.VaultAddr != "" {
+		r.Register("vault", NewVault(cfg.VaultAddr, cfg.VaultNamespace, cfg.VaultToken, cfg.VaultRoleID, cfg.VaultSecretID))
+	}
+	if cfg.AWSAccessKeyID != "" {
+		r.Register("awssm", NewAWSSecretsManager(cfg.AWSSecretsEndpoint, cfg.AWSRegion, cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, cfg.AWSSessionToken))
+	}
+	return r
+}
+
+// Register makes provider resolve the references of scheme
+func (r *Resolver) Register(scheme string, provider Provider) {
+	r.providers[scheme] = provider
+}
+
+// Schemes lists the schemes a provider is registered for, sorted
+func (r *Resolver) Schemes() []string {
+	if r == nil {
+		return nil
+	}
+	schemes := make([]string, 0, len(r.providers))
+	for scheme := range r.providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Validate parses value and checks a provider is configured for it
+func (r *Resolver) Validate(value string) (Ref, error) {
+	ref, err := Parse(value)
+	if err != nil {
+		return Ref{}, err
+	}
+	if r == nil || r.providers[ref.Scheme] == nil {
+		return Ref{}, fmt.Errorf("no secret provider %q is configured on the platform", ref.Scheme)
+	}
+	return ref, nil
+}
+
+// Resolve fetches the value a reference points at
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	ref, err := r.Validate(value)
+	if err != nil {
+		return "", err
+	}
+	return r.providers[ref.Scheme].Resolve(ctx, ref)
+}
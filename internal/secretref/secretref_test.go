@@ -0,0 +1,120 @@
+package secretref
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Ref
+		err   string
+	}{
+		{value: "ref+vault://secret/data/app#password", want: Ref{Scheme: "vault", Path: "secret/data/app", Key: "password"}},
+		{value: "ref+vault://kv/app", want: Ref{Scheme: "vault", Path: "kv/app"}},
+		{value: "ref+awssm://arn:aws:secretsmanager:eu-west-1:123456789012:secret:app-AbCdEf#db#url", want: Ref{Scheme: "awssm", Path: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:app-AbCdEf", Key: "db#url"}},
+		{value: "hunter2", err: "not a secret reference"},
+		{value: "ref+vault:secret/app", err: "expected ref+<provider>://<path>[#<key>]"},
+		{value: "ref+://secret/app", err: "expected ref+<provider>://<path>[#<key>]"},
+		{value: "ref+vault://#password", err: "the path is empty"},
+	}
+	for _, tt := range tests {
+		ref, err := Parse(tt.value)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.value, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || ref != tt.want {
+			t.Errorf("%s: got %+v, %v", tt.value, ref, err)
+		}
+		if tt.want.Key != "db#url" && ref.String() != tt.value {
+			t.Errorf("%s: String() = %s", tt.value, ref.String())
+		}
+	}
+}
+
+// staticProvider resolves references to the values by path
+type staticProvider map[string]string
+
+func (p staticProvider) Resolve(ctx context.Context, ref Ref) (string, error) {
+	return p[ref.Path], nil
+}
+
+func TestResolver(t *testing.T) {
+	var none *Resolver
+	if _, err := none.Validate("ref+vault://secret/app"); err == nil || err.Error() != `no secret provider "vault" is configured on the platform` {
+		t.Errorf("got %v", err)
+	}
+	if schemes := none.Schemes(); schemes != nil {
+		t.Errorf("schemes %v", schemes)
+	}
+
+	r := NewResolver()
+	r.Register("vault", staticProvider{"secret/app": "hunter2"})
+	if value, err := r.Resolve(context.Background(), "ref+vault://secret/app"); err != nil || value != "hunter2" {
+		t.Errorf("got %q, %v", value, err)
+	}
+	if _, err := r.Resolve(context.Background(), "ref+awssm://app"); err == nil {
+		t.Error("resolved a reference without its provider")
+	}
+	if _, err := r.Resolve(context.Background(), "hunter2"); err == nil {
+		t.Error("resolved a literal")
+	}
+
+	configured := FromConfig(&config.Config{VaultAddr: "https://vault.example.com", VaultToken: "root", AWSRegion: "eu-west-1", AWSAccessKeyID: "AKID"})
+	if schemes := configured.Schemes(); !reflect.DeepEqual(schemes, []string{"awssm", "vault"}) {
+		t.Errorf("schemes %v", schemes)
+	}
+	if schemes := FromConfig(&config.Config{}).Schemes(); len(schemes) != 0 {
+		t.Errorf("unconfigured schemes %v", schemes)
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash("hunter2") != "f52fbd32b2b3b86ff88ef6c490628285f482af15ddcb29541f94bcf526a3f6c7" || Hash("hunter2") == Hash("hunter3") {
+		t.Errorf("hash %s", Hash("hunter2"))
+	}
+}
+
+// contract checks what every provider does, resolving references to the
+// secret at app, holding the fields password (hunter2), user and port (a
+// number), and to missing, a secret that doesn't exist. No error includes
+// a secret's value.
+func contract(t *testing.T, provider Provider, app, missing string) {
+	t.Helper()
+	ctx := context.Background()
+	for key, want := range map[string]string{"password": "hunter2", "port": "5432"} {
+		if value, err := provider.Resolve(ctx, Ref{Path: app, Key: key}); err != nil || value != want {
+			t.Errorf("%s#%s: got %q, %v", app, key, value, err)
+		}
+	}
+
+	failures := []struct {
+		ref  Ref
+		want string
+	}{
+		{Ref{Path: app, Key: "token"}, `has no field "token"`},
+		{Ref{Path: missing, Key: "password"}, missing},
+	}
+	for _, f := range failures {
+		_, err := provider.Resolve(ctx, f.ref)
+		if err == nil || !strings.Contains(err.Error(), f.want) {
+			t.Errorf("%+v: got %v, want %q", f.ref, err, f.want)
+		}
+		if err != nil && strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("%+v: the error has the secret: %v", f.ref, err)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := provider.Resolve(cancelled, Ref{Path: app, Key: "password"}); err == nil {
+		t.Error("resolved with a cancelled context")
+	}
+}
@@ -0,0 +1,165 @@
+package secretref
+
+import (
+	"bytes"
+	"context"
+	"deploy-platform/internal/httpclient"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault reads secrets of HashiCorp Vault's KV engine, versions 1 and 2:
+// ref+vault://secret/data/app#password reads the password field of the
+// secret at /v1/secret/data/app. It authenticates with a token, or logs in
+// with an AppRole and renews its token before the lease runs out.
+type Vault struct {
+	addr      string
+	namespace string
+	token     string // Static token, "" = AppRole
+	roleID    string
+	secretID  string
+	client    *http.Client
+
+	mu        sync.Mutex
+	login     string // Token of the last AppRole login
+	loginTill time.Time
+}
+
+// NewVault creates the provider of the Vault at addr, authenticating with
+// token, or with the AppRole roleID and secretID when token is empty
+func NewVault(addr, namespace, token, roleID, secretID string) *Vault {
+	return &Vault{
+		addr:      strings.TrimSuffix(addr, "/"),
+		namespace: namespace,
+		token:     token,
+		roleID:    roleID,
+		secretID:  secretID,
+		client:    httpclient.New("vault"),
+	}
+}
+
+// Resolve reads the secret at ref.Path and returns its field ref.Key. A
+// secret with a single field may leave the key out.
+func (v *Vault) Resolve(ctx context.Context, ref Ref) (string, error) {
+	token, err := v.authToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(ref.Path, "/"), token, nil, &body); err != nil {
+		return "", err
+	}
+	fields := body.Data
+	// KV version 2 nests the fields under data, next to metadata
+	if nested, ok := fields["data"]; ok && fields["metadata"] != nil {
+		fields = nil
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return "", fmt.Errorf("vault: unexpected response for %s", ref.Path)
+		}
+	}
+	return field(fields, ref)
+}
+
+// authToken returns the token requests are sent with, logging in with the
+// AppRole when there is no static token and the last login expires soon
+func (v *Vault) authToken(ctx context.Context) (string, error) {
+	if v.token != "" {
+		return v.token, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.login != "" && time.Now().Before(v.loginTill) {
+		return v.login, nil
+	}
+
+	var body struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	login := map[string]string{"role_id": v.roleID, "secret_id": v.secretID}
+	if err := v.do(ctx, http.MethodPost, "/v1/auth/approle/login", "", login, &body); err != nil {
+		return "", fmt.Errorf("approle login: %w", err)
+	}
+	if body.Auth.ClientToken == "" {
+		return "", errors.New("vault: approle login returned no token")
+	}
+	v.login = body.Auth.ClientToken
+	// Logged in again once 80% of the lease is used
+	v.loginTill = time.Now().Add(time.Duration(body.Auth.LeaseDuration) * time.Second * 4 / 5)
+	return v.login, nil
+}
+
+// do sends a request to the Vault API and decodes its response into out
+func (v *Vault) do(ctx context.Context, method, path, token string, in, out interface{}) error {
+	var reqBody io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Vault's error bodies list messages, never secret values
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&failure)
+		if len(failure.Errors) > 0 {
+			return fmt.Errorf("vault %s: %s: %s", path, resp.Status, strings.Join(failure.Errors, "; "))
+		}
+		return fmt.Errorf("vault %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("vault %s: invalid response", path)
+	}
+	return nil
+}
+
+// field picks the field ref.Key of a secret made of fields, the only one
+// when ref.Key is empty. Fields that aren't strings are returned as JSON.
+func field(fields map[string]json.RawMessage, ref Ref) (string, error) {
+	key := ref.Key
+	if key == "" {
+		if len(fields) != 1 {
+			return "", fmt.Errorf("the secret at %s has %d fields: name one after #", ref.Path, len(fields))
+		}
+		for k := range fields {
+			key = k
+		}
+	}
+	raw, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("the secret at %s has no field %q", ref.Path, key)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,132 @@
+package secretref
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeVault serves secrets of a KV version 1 engine mounted at kv/ and a
+// version 2 engine mounted at secret/, to the root token or the token of an
+// AppRole login
+type fakeVault struct {
+	*httptest.Server
+	secrets map[string]map[string]interface{} // Fields by path under the mount
+	lease   int                               // Of AppRole logins, in seconds
+
+	mu         sync.Mutex
+	logins     int
+	namespaces []string
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	v := &fakeVault{secrets: map[string]map[string]interface{}{}, lease: 3600}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	v.namespaces = append(v.namespaces, r.Header.Get("X-Vault-Namespace"))
+	v.mu.Unlock()
+	reply := func(code int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/auth/approle/login" {
+		var login map[string]string
+		json.NewDecoder(r.Body).Decode(&login)
+		if login["role_id"] != "app-role" || login["secret_id"] != "app-secret" {
+			reply(http.StatusBadRequest, map[string][]string{"errors": {"invalid role or secret ID"}})
+			return
+		}
+		v.mu.Lock()
+		v.logins++
+		v.mu.Unlock()
+		reply(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.approle", "lease_duration": v.lease}})
+		return
+	}
+	if token := r.Header.Get("X-Vault-Token"); token != "root" && token != "s.approle" {
+		reply(http.StatusForbidden, map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	switch path := strings.TrimPrefix(r.URL.Path, "/v1/"); {
+	case strings.HasPrefix(path, "kv/") && v.secrets[strings.TrimPrefix(path, "kv/")] != nil:
+		reply(http.StatusOK, map[string]interface{}{"data": v.secrets[strings.TrimPrefix(path, "kv/")]})
+	case strings.HasPrefix(path, "secret/data/") && v.secrets[strings.TrimPrefix(path, "secret/data/")] != nil:
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"data":     v.secrets[strings.TrimPrefix(path, "secret/data/")],
+			"metadata": map[string]interface{}{"version": 3},
+		}})
+	default:
+		reply(http.StatusNotFound, map[string][]string{"errors": {}})
+	}
+}
+
+func TestVaultContract(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.secrets["app"] = map[string]interface{}{"password": "hunter2", "user": "ada", "port": 5432}
+	for _, engine := range []string{"kv/", "secret/data/"} {
+		t.Run(strings.TrimSuffix(engine, "/"), func(t *testing.T) {
+			contract(t, NewVault(vault.URL+"/", "", "root", "", ""), engine+"app", engine+"missing")
+		})
+	}
+	t.Run("approle", func(t *testing.T) {
+		contract(t, NewVault(vault.URL, "", "", "app-role", "app-secret"), "secret/data/app", "secret/data/missing")
+	})
+}
+
+func TestVault(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.secrets["app"] = map[string]interface{}{"password": "hunter2", "user": "ada"}
+	vault.secrets["token"] = map[string]interface{}{"token": "t0k"}
+	ctx := context.Background()
+
+	// A secret with a single field may leave its key out
+	provider := NewVault(vault.URL, "team-a", "root", "", "")
+	if value, err := provider.Resolve(ctx, Ref{Path: "/secret/data/token"}); err != nil || value != "t0k" {
+		t.Errorf("got %q, %v", value, err)
+	}
+	if _, err := provider.Resolve(ctx, Ref{Path: "secret/data/app"}); err == nil || err.Error() != "the secret at secret/data/app has 2 fields: name one after #" {
+		t.Errorf("got %v", err)
+	}
+	if vault.namespaces[0] != "team-a" {
+		t.Errorf("namespaces %q", vault.namespaces)
+	}
+
+	// Vault's error messages are passed on
+	_, err := NewVault(vault.URL, "", "expired", "", "").Resolve(ctx, Ref{Path: "secret/data/app", Key: "password"})
+	if err == nil || err.Error() != "vault /v1/secret/data/app: 403 Forbidden: permission denied" {
+		t.Errorf("got %v", err)
+	}
+	_, err = NewVault(vault.URL, "", "", "app-role", "wrong").Resolve(ctx, Ref{Path: "secret/data/app", Key: "password"})
+	if err == nil || !strings.Contains(err.Error(), "approle login: vault /v1/auth/approle/login: 400 Bad Request: invalid role or secret ID") {
+		t.Errorf("got %v", err)
+	}
+
+	// AppRole logins are reused until most of their lease is used
+	approle := NewVault(vault.URL, "", "", "app-role", "app-secret")
+	for i := 0; i < 3; i++ {
+		if _, err := approle.Resolve(ctx, Ref{Path: "secret/data/app", Key: "user"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vault.logins != 1 {
+		t.Errorf("%d logins", vault.logins)
+	}
+	vault.lease = 0
+	expiring := NewVault(vault.URL, "", "", "app-role", "app-secret")
+	for i := 0; i < 2; i++ {
+		expiring.Resolve(ctx, Ref{Path: "secret/data/app", Key: "user"})
+	}
+	if vault.logins != 3 {
+		t.Errorf("%d logins with expired leases", vault.logins)
+	}
+}
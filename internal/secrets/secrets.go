@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"deploy-platform/internal/config"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// prefix marks encrypted values, so plaintext stored before encryption was
+// introduced can still be told apart
+const prefix = "enc:v1:"
+
+var gcm cipher.AEAD
+
+// Init derives the encryption key from ENCRYPTION_KEY, falling back to the JWT
+// secret so development setups work without extra configuration
+func Init(cfg *config.Config) error {
+	secret := cfg.EncryptionKey
+	if secret == "" {
+		log.Println("⚠️  ENCRYPTION_KEY not set, deriving the encryption key from JWT_SECRET")
+		secret = cfg.JWTSecret
+	}
+	if secret == "" {
+		return errors.New("no encryption key configured")
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	gcm, err = cipher.NewGCM(block)
+	return err
+}
+
+// Encrypt seals plaintext with AES-GCM; the empty string stays empty
+func Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	if gcm == nil {
+		return "", errors.New("secrets not initialized")
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Values without the encryption
+// prefix are returned unchanged (legacy plaintext).
+func Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+	if gcm == nil {
+		return "", errors.New("secrets not initialized")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value (wrong ENCRYPTION_KEY?): %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
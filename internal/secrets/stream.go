@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Streams are sealed in chunks so large payloads (database backups) never
+// have to fit in memory. Each chunk is written as its length, nonce and
+// sealed bytes; the chunk index and a final flag are authenticated with it,
+// so reordered, dropped or truncated chunks fail to open.
+const (
+	streamMagic     = "DPENC1\n"
+	streamChunkSize = 1 << 20
+)
+
+// NewEncryptWriter returns a writer sealing everything written to it into w.
+// Close must be called to write the final chunk; it doesn't close w.
+func NewEncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	if gcm == nil {
+		return nil, errors.New("secrets not initialized")
+	}
+	if _, err := io.WriteString(w, streamMagic); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, buf: make([]byte, 0, streamChunkSize)}, nil
+}
+
+type encryptWriter struct {
+	w     io.Writer
+	buf   []byte
+	index uint64
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		// A full chunk is only sealed once more data follows, so the last one is always sealed by Close
+		if len(e.buf) == cap(e.buf) && len(p) > 0 {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nil, nonce, e.buf, chunkAAD(e.index, final))
+
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	if _, err := e.w.Write(append(header, nonce...)); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.buf = e.buf[:0]
+	e.index++
+	return nil
+}
+
+// NewDecryptReader returns a reader opening a stream written by
+// NewEncryptWriter. Reads fail if the stream was tampered with or cut short.
+func NewDecryptReader(r io.Reader) (io.Reader, error) {
+	if gcm == nil {
+		return nil, errors.New("secrets not initialized")
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(streamMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != streamMagic {
+		return nil, errors.New("not an encrypted stream")
+	}
+	return &decryptReader{r: br}, nil
+}
+
+type decryptReader struct {
+	r     io.Reader
+	buf   []byte
+	index uint64
+	done  bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// open reads and opens the next chunk
+func (d *decryptReader) open() error {
+	header := make([]byte, 4+gcm.NonceSize())
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return fmt.Errorf("encrypted stream truncated: %w", err)
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > streamChunkSize+uint32(gcm.Overhead()) {
+		return errors.New("invalid encrypted stream chunk")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("encrypted stream truncated: %w", err)
+	}
+
+	nonce := header[4:]
+	plaintext, err := gcm.Open(nil, nonce, sealed, chunkAAD(d.index, false))
+	if err != nil {
+		if plaintext, err = gcm.Open(nil, nonce, sealed, chunkAAD(d.index, true)); err != nil {
+			return fmt.Errorf("failed to decrypt stream (wrong ENCRYPTION_KEY?): %w", err)
+		}
+		d.done = true
+		if _, err := d.r.Read(make([]byte, 1)); err != io.EOF {
+			return errors.New("data after the end of the encrypted stream")
+		}
+	}
+	d.buf = plaintext
+	d.index++
+	return nil
+}
+
+// chunkAAD binds a chunk to its position in the stream
+func chunkAAD(index uint64, final bool) []byte {
+	aad := binary.BigEndian.AppendUint64(nil, index)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
@@ -0,0 +1,438 @@
+package settings
+
+import (
+	"deploy-platform/internal/build"
+	"deploy-platform/internal/kubernetes"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/policy"
+	"deploy-platform/internal/preview"
+	"deploy-platform/internal/quota"
+	"deploy-platform/internal/textutil"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request replaces a project's settings. Omitted or null ingress fields are
+// removed, falling back to the controller defaults.
+type Request struct {
+	Ingress               models.IngressSettings     `json:"ingress"`
+	StrictImageBudget     bool                       `json:"strict_image_budget"`     // Fail deployments over the hard image size budget
+	Port                  int                        `json:"port"`                    // Port the app listens on, 0 = detected at build time
+	Visibility            string                     `json:"visibility"`              // public or internal, omitted = unchanged
+	CustomLabels          map[string]string          `json:"custom_labels"`           // Added to the project's Kubernetes objects
+	CustomAnnotations     map[string]string          `json:"custom_annotations"`      // Added to the project's Kubernetes objects
+	BuildCommands         models.BuildCommands       `json:"build_commands"`          // Replace auto-detection, empty = detect
+	RuntimeVersion        string                     `json:"runtime_version"`         // Version of the language generated Dockerfiles build on, e.g. "20", empty = the one the repository asks for
+	ProcessType           string                     `json:"process_type"`            // web or worker, omitted = unchanged
+	ReleaseCommand        string                     `json:"release_command"`         // Runs before each rollout, e.g. "python manage.py migrate", empty = none
+	PublicBadge           bool                       `json:"public_badge"`            // Serve the deploy status badge to anyone
+	PlaceholderPrivate    bool                       `json:"placeholder_private"`     // Keep the project name and deploy status off its placeholder page
+	RequireApproval       bool                       `json:"require_approval"`        // Production deployments wait for an approver once built
+	ApprovalWindowMinutes int                        `json:"approval_window_minutes"` // Minutes they wait before being cancelled, 0 = APPROVAL_WINDOW
+	ManifestPatches       []models.ManifestPatch     `json:"manifest_patches"`        // Applied to the rendered Kubernetes objects
+	KeepBuildLogsLocal    bool                       `json:"keep_build_logs_local"`   // Never ship build logs to the platform's log sink
+	PreviewProvisioner    *models.PreviewProvisioner `json:"preview_provisioner"`     // Sets up resources of their own for branch and preview deployments, null = none
+	AnalysisOnly          bool                       `json:"analysis_only"`           // Analyze pushes instead of building and deploying them
+	BranchDeploys         bool                       `json:"branch_deploys"`          // Deploy pushes to other branches as branch deployments instead of ignoring them
+	DeployPolicy          *models.DeployPolicy       `json:"deploy_policy"`           // Checks pushes deploying to production, null = none
+}
+
+// maxReleaseCommand bounds a project's release command
+const maxReleaseCommand = 4096
+
+// Problem is a setting the platform refuses
+type Problem struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate checks req against the platform rules, after trimming its release
+// and preview provisioner commands, and returns every problem found, in the
+// order of Request's fields. Settings updates and their dry runs both go
+// through it.
+func Validate(req *Request) []Problem {
+	var problems []Problem
+	add := func(field string, err error) {
+		if err != nil {
+			problems = append(problems, Problem{Field: field, Message: err.Error()})
+		}
+	}
+
+	add("ingress", kubernetes.ValidateIngressSettings(req.Ingress))
+	if req.Port < 0 || req.Port > 65535 {
+		add("port", fmt.Errorf("port must be between 1 and 65535, or 0 to detect it"))
+	}
+	if req.Visibility != "" && req.Visibility != models.VisibilityPublic && req.Visibility != models.VisibilityInternal {
+		add("visibility", fmt.Errorf("visibility must be public or internal"))
+	}
+	add("custom_labels", kubernetes.ValidateCustomMetadata(req.CustomLabels, req.CustomAnnotations))
+	add("build_commands", build.ValidateCommands(req.BuildCommands, req.Port))
+	req.RuntimeVersion = strings.TrimSpace(req.RuntimeVersion)
+	add("runtime_version", build.ValidateRuntimeVersion(req.RuntimeVersion))
+	if req.ProcessType != "" && req.ProcessType != models.ProcessWeb && req.ProcessType != models.ProcessWorker {
+		add("process_type", fmt.Errorf("process_type must be web or worker"))
+	}
+	req.ReleaseCommand = strings.TrimSpace(req.ReleaseCommand)
+	if len(req.ReleaseCommand) > maxReleaseCommand {
+		add("release_command", fmt.Errorf("release_command must be at most %d bytes", maxReleaseCommand))
+	}
+	add("approval_window_minutes", build.ValidateApprovalWindow(req.ApprovalWindowMinutes))
+	add("manifest_patches", kubernetes.ValidateManifestPatches(req.ManifestPatches))
+	if p := req.PreviewProvisioner; p != nil {
+		p.Command = strings.TrimSpace(p.Command)
+		p.TeardownCommand = strings.TrimSpace(p.TeardownCommand)
+		p.Image = strings.TrimSpace(p.Image)
+	}
+	add("preview_provisioner", preview.Validate(req.PreviewProvisioner))
+	add("deploy_policy", policy.Validate(req.DeployPolicy))
+	return problems
+}
+
+// Apply sets the settings of a valid req on project
+func Apply(project *models.Project, req *Request) {
+	project.Ingress = req.Ingress
+	project.StrictImageBudget = req.StrictImageBudget
+	project.Port = req.Port
+	if req.Visibility != "" {
+		project.Visibility = req.Visibility
+	}
+	project.Labels = req.CustomLabels
+	project.Annotations = req.CustomAnnotations
+	project.BuildCommands = req.BuildCommands
+	project.RuntimeVersion = req.RuntimeVersion
+	if req.ProcessType != "" {
+		project.ProcessType = req.ProcessType
+	}
+	project.ReleaseCommand = req.ReleaseCommand
+	project.PublicBadge = req.PublicBadge
+	project.PlaceholderPrivate = req.PlaceholderPrivate
+	project.RequireApproval = req.RequireApproval
+	project.ApprovalWindowMinutes = req.ApprovalWindowMinutes
+	project.ManifestPatches = req.ManifestPatches
+	project.KeepBuildLogsLocal = req.KeepBuildLogsLocal
+	project.PreviewProvisioner = req.PreviewProvisioner
+	project.AnalysisOnly = req.AnalysisOnly
+	project.BranchDeploys = req.BranchDeploys
+	project.DeployPolicy = req.DeployPolicy
+}
+
+// Columns are the project columns Apply sets, for updates to write unset
+// settings as NULL
+var Columns = []string{
+	"ingress_proxy_read_timeout", "ingress_proxy_send_timeout", "ingress_web_sockets",
+	"ingress_session_affinity", "ingress_max_body_size_mb", "strict_image_budget", "port", "visibility",
+	"labels", "annotations", "build_runtime", "build_install_command", "build_build_command",
+	"build_output_dir", "build_start_command", "runtime_version", "process_type", "release_command", "public_badge",
+	"placeholder_private", "require_approval", "approval_window_minutes", "manifest_patches",
+	"keep_build_logs_local", "preview_provisioner", "analysis_only", "branch_deploys",
+	"deploy_policy",
+}
+
+// When a change takes effect
+const (
+	EffectNow        = "now"         // Applied to the cluster or served as soon as saved
+	EffectNextDeploy = "next_deploy" // Picked up by the next deployment
+	EffectRebuild    = "rebuild"     // Needs a new build of the image
+)
+
+// Change is a setting a request changes, and what changing it does
+type Change struct {
+	Field   string `json:"field"`
+	From    any    `json:"from"`
+	To      any    `json:"to"`
+	Effect  string `json:"effect"`  // Effect*
+	Message string `json:"message"` // e.g. "port 8080→3000: pods roll with the new PORT on the next deployment"
+}
+
+// Changes are the changes of a request, see Diff
+type Changes []Change
+
+// Has reports whether any of fields changes
+func (cs Changes) Has(fields ...string) bool {
+	for _, c := range cs {
+		for _, field := range fields {
+			if c.Field == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Diff describes what saving req would change on project, in the order of
+// Request's fields; settings left as they are aren't listed
+func Diff(project *models.Project, req *Request) Changes {
+	var changes Changes
+	add := func(field string, from, to any, fromText, toText, effect, consequence string) {
+		changes = append(changes, Change{
+			Field:   field,
+			From:    from,
+			To:      to,
+			Effect:  effect,
+			Message: fmt.Sprintf("%s %s→%s: %s", field, fromText, toText, consequence),
+		})
+	}
+	next := *project
+	Apply(&next, req)
+
+	// Settings rendering the same annotations change nothing
+	if from, to := kubernetes.IngressAnnotations(project.Ingress), kubernetes.IngressAnnotations(next.Ingress); !maps.Equal(from, to) {
+		add("ingress", project.Ingress, next.Ingress, describeMap(from), describeMap(to), EffectNextDeploy,
+			"the Ingress is updated on the next deployment")
+	}
+	if next.StrictImageBudget != project.StrictImageBudget {
+		consequence := "images over the hard size budget only warn from the next build"
+		if next.StrictImageBudget {
+			consequence = "images over the hard size budget fail deployments from the next build"
+		}
+		add("strict_image_budget", project.StrictImageBudget, next.StrictImageBudget,
+			strconv.FormatBool(project.StrictImageBudget), strconv.FormatBool(next.StrictImageBudget), EffectNextDeploy, consequence)
+	}
+	if next.Port != project.Port {
+		add("port", project.Port, next.Port, describePort(project.Port), describePort(next.Port), EffectNextDeploy,
+			"pods roll with the new PORT on the next deployment")
+	}
+	if next.Visibility != project.Visibility {
+		consequence := "Ingresses are added for its hostnames now"
+		if next.Internal() {
+			consequence = "its Ingresses are removed now; its hostnames stay reserved for when it is public again"
+		}
+		add("visibility", project.Visibility, next.Visibility, project.Visibility, next.Visibility, EffectNow, consequence)
+	}
+	if !maps.Equal(next.Labels, project.Labels) {
+		add("custom_labels", project.Labels, next.Labels, describeMap(project.Labels), describeMap(next.Labels), EffectNow,
+			"patched onto the project's objects now, without restarting pods")
+	}
+	if !maps.Equal(next.Annotations, project.Annotations) {
+		add("custom_annotations", project.Annotations, next.Annotations, describeMap(project.Annotations), describeMap(next.Annotations), EffectNow,
+			"patched onto the project's objects now, without restarting pods")
+	}
+	if next.BuildCommands != project.BuildCommands {
+		add("build_commands", project.BuildCommands, next.BuildCommands, describeCommands(project.BuildCommands), describeCommands(next.BuildCommands), EffectRebuild,
+			"requires a rebuild: running deployments keep their image")
+	}
+	if next.RuntimeVersion != project.RuntimeVersion {
+		add("runtime_version", project.RuntimeVersion, next.RuntimeVersion, describeRuntimeVersion(project.RuntimeVersion), describeRuntimeVersion(next.RuntimeVersion), EffectRebuild,
+			"requires a rebuild: running deployments keep their image; Dockerfiles of the repository or the project's override are left as they are")
+	}
+	if next.ProcessType != project.ProcessType {
+		consequence := "a Service and Ingress are added on the next deployment"
+		if next.Worker() {
+			consequence = "its Service and Ingress are removed on the next deployment, and its hostnames stop serving"
+		}
+		add("process_type", project.ProcessType, next.ProcessType, project.ProcessType, next.ProcessType, EffectNextDeploy, consequence)
+	}
+	if next.ReleaseCommand != project.ReleaseCommand {
+		consequence := "runs before each rollout, from the next deployment"
+		if next.ReleaseCommand == "" {
+			consequence = "no longer runs before rollouts, from the next deployment"
+		}
+		add("release_command", project.ReleaseCommand, next.ReleaseCommand, describeCommand(project.ReleaseCommand), describeCommand(next.ReleaseCommand), EffectNextDeploy, consequence)
+	}
+	if next.PublicBadge != project.PublicBadge {
+		consequence := "the badge shows \"private\" to everyone now"
+		if next.PublicBadge {
+			consequence = "the badge shows the deploy status to anyone now"
+		}
+		add("public_badge", project.PublicBadge, next.PublicBadge,
+			strconv.FormatBool(project.PublicBadge), strconv.FormatBool(next.PublicBadge), EffectNow, consequence)
+	}
+	if next.PlaceholderPrivate != project.PlaceholderPrivate {
+		consequence := "the placeholder page shows the project name and deploy status now"
+		if next.PlaceholderPrivate {
+			consequence = "the placeholder page hides the project name and deploy status now"
+		}
+		add("placeholder_private", project.PlaceholderPrivate, next.PlaceholderPrivate,
+			strconv.FormatBool(project.PlaceholderPrivate), strconv.FormatBool(next.PlaceholderPrivate), EffectNow, consequence)
+	}
+	if next.RequireApproval != project.RequireApproval {
+		consequence := "production deployments roll out once built, from the next build"
+		if next.RequireApproval {
+			consequence = "production deployments wait for an approver once built, from the next build"
+		}
+		add("require_approval", project.RequireApproval, next.RequireApproval,
+			strconv.FormatBool(project.RequireApproval), strconv.FormatBool(next.RequireApproval), EffectNextDeploy, consequence)
+	}
+	if next.ApprovalWindowMinutes != project.ApprovalWindowMinutes {
+		add("approval_window_minutes", project.ApprovalWindowMinutes, next.ApprovalWindowMinutes,
+			describeWindow(project.ApprovalWindowMinutes), describeWindow(next.ApprovalWindowMinutes), EffectNextDeploy,
+			"applies to deployments built from now on; those already awaiting approval keep their deadline")
+	}
+	if !slices.Equal(next.ManifestPatches, project.ManifestPatches) {
+		add("manifest_patches", project.ManifestPatches, next.ManifestPatches,
+			describePatches(project.ManifestPatches), describePatches(next.ManifestPatches), EffectNextDeploy,
+			"applied to the rendered objects from the next build; running deployments keep theirs")
+	}
+	if next.KeepBuildLogsLocal != project.KeepBuildLogsLocal {
+		consequence := "build logs are shipped to the platform's log sink, from the next build"
+		if next.KeepBuildLogsLocal {
+			consequence = "build logs stay on the platform, from the next build; those already shipped stay in the sink"
+		}
+		add("keep_build_logs_local", project.KeepBuildLogsLocal, next.KeepBuildLogsLocal,
+			strconv.FormatBool(project.KeepBuildLogsLocal), strconv.FormatBool(next.KeepBuildLogsLocal), EffectNextDeploy, consequence)
+	}
+	if !reflect.DeepEqual(next.PreviewProvisioner, project.PreviewProvisioner) {
+		consequence := "branch and preview deployments get their environment provisioned from the next deployment; those running keep theirs"
+		if next.PreviewProvisioner == nil {
+			consequence = "branch and preview deployments run with production's env from the next deployment; environments already provisioned are torn down as their previews are retired"
+		}
+		add("preview_provisioner", project.PreviewProvisioner, next.PreviewProvisioner,
+			describeProvisioner(project.PreviewProvisioner), describeProvisioner(next.PreviewProvisioner), EffectNextDeploy, consequence)
+	}
+	if next.AnalysisOnly != project.AnalysisOnly {
+		consequence := "pushes are built and deployed again from the next push; the latest analyzed commit is offered for deployment"
+		if next.AnalysisOnly {
+			consequence = "pushes are only analyzed from the next push, nothing is built; running deployments keep running"
+		}
+		add("analysis_only", project.AnalysisOnly, next.AnalysisOnly,
+			strconv.FormatBool(project.AnalysisOnly), strconv.FormatBool(next.AnalysisOnly), EffectNow, consequence)
+	}
+	if next.BranchDeploys != project.BranchDeploys {
+		consequence := "pushes to other branches than " + project.Branch + " are ignored from now on; running branch deployments keep running until their branch is deleted"
+		if next.BranchDeploys {
+			consequence = "pushes to other branches than " + project.Branch + " deploy to resources and a hostname of their own from now on"
+		}
+		add("branch_deploys", project.BranchDeploys, next.BranchDeploys,
+			strconv.FormatBool(project.BranchDeploys), strconv.FormatBool(next.BranchDeploys), EffectNow, consequence)
+	}
+	if !reflect.DeepEqual(next.DeployPolicy, project.DeployPolicy) {
+		add("deploy_policy", project.DeployPolicy, next.DeployPolicy,
+			describePolicy(project.DeployPolicy), describePolicy(next.DeployPolicy), EffectNextDeploy,
+			"applies to pushes to production from now on; deployments already blocked stay blocked until approved or rejected")
+	}
+	return changes
+}
+
+// Warnings lists what a valid req sets to no effect, and what saving it
+// leads to that is bound to fail, for the dashboard to show before saving
+func Warnings(project *models.Project, req *Request, changes Changes) []string {
+	var warnings []string
+	next := *project
+	Apply(&next, req)
+
+	if next.Worker() && next.Port != 0 {
+		warnings = append(warnings, fmt.Sprintf("workers listen on no port: port %d is ignored", next.Port))
+	}
+	if !next.Served() && len(kubernetes.IngressAnnotations(next.Ingress)) > 0 {
+		warnings = append(warnings, "the project has no Ingress while it is internal or a worker: the ingress settings are ignored")
+	}
+	if next.Worker() && next.BuildCommands.OutputDir != "" {
+		warnings = append(warnings, "static sites are served over HTTP: a worker never serves the output directory")
+	}
+	if !next.RequireApproval && next.ApprovalWindowMinutes != 0 {
+		warnings = append(warnings, "production deployments don't wait for approval: approval_window_minutes is ignored")
+	}
+	patches := func(kind string) bool {
+		return slices.ContainsFunc(next.ManifestPatches, func(p models.ManifestPatch) bool { return p.Kind == kind })
+	}
+	if !next.Served() && patches(kubernetes.KindIngress) {
+		warnings = append(warnings, "the project has no Ingress while it is internal or a worker: its Ingress patches are skipped")
+	}
+	if next.Worker() && patches(kubernetes.KindService) {
+		warnings = append(warnings, "workers have no Service: their Service patches are skipped")
+	}
+	if p := next.PreviewProvisioner; p != nil && p.Type == models.PreviewProvisionerCommand && p.TeardownCommand == "" {
+		warnings = append(warnings, "the preview provisioner has no teardown_command: what its command provisions is never freed")
+	}
+	if p := next.DeployPolicy; p != nil && len(policy.Enabled(p)) == 0 {
+		warnings = append(warnings, "the deploy policy enables no rule: pushes to production are never checked")
+	}
+	if p := next.DeployPolicy; p != nil && p.RequireVerifiedCommits && (next.RepoOwner == "" || next.RepoName == "") {
+		warnings = append(warnings, "the project's repository isn't on GitHub: no commit can be verified, so require_verified_commits breaks every push to production")
+	}
+	if changes.Has("build_commands", "runtime_version") {
+		if err := quota.CheckDeployment(project); err != nil {
+			warnings = append(warnings, "the rebuild the new settings need can't run today: "+err.Error())
+		}
+	}
+	return warnings
+}
+
+// maxDescribed bounds the values quoted in change messages
+const maxDescribed = 40
+
+func describeRuntimeVersion(version string) string {
+	if version == "" {
+		return "detected"
+	}
+	return version
+}
+
+func describePort(port int) string {
+	if port == 0 {
+		return "detected"
+	}
+	return strconv.Itoa(port)
+}
+
+func describeWindow(minutes int) string {
+	if minutes == 0 {
+		return "default"
+	}
+	return (time.Duration(minutes) * time.Minute).String()
+}
+
+func describePatches(patches []models.ManifestPatch) string {
+	if len(patches) == 0 {
+		return "none"
+	}
+	kinds := make([]string, 0, len(patches))
+	for _, patch := range patches {
+		kinds = append(kinds, patch.Kind)
+	}
+	return strings.Join(kinds, ", ")
+}
+
+func describeCommand(command string) string {
+	if command == "" {
+		return "none"
+	}
+	return strconv.Quote(textutil.Truncate(command, maxDescribed))
+}
+
+func describeProvisioner(p *models.PreviewProvisioner) string {
+	switch {
+	case p == nil:
+		return "none"
+	case p.Type == models.PreviewProvisionerCommand:
+		return "command " + describeCommand(p.Command)
+	default:
+		return "template " + describeCommand(strings.Join(slices.Sorted(maps.Keys(p.Env)), ", "))
+	}
+}
+
+func describePolicy(p *models.DeployPolicy) string {
+	rules := policy.Enabled(p)
+	if len(rules) == 0 {
+		return "none"
+	}
+	return p.Enforcement + " " + strings.Join(rules, ", ")
+}
+
+func describeCommands(commands models.BuildCommands) string {
+	switch {
+	case !commands.Set():
+		return "detected"
+	case commands.OutputDir != "":
+		return "static site " + describeCommand(commands.OutputDir)
+	default:
+		return "start " + describeCommand(commands.StartCommand)
+	}
+}
+
+// describeMap lists entries sorted by key, e.g. "team=api, tier=web"
+func describeMap(m map[string]string) string {
+	if len(m) == 0 {
+		return "none"
+	}
+	entries := make([]string, 0, len(m))
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		entries = append(entries, key+"="+m[key])
+	}
+	return describeCommand(strings.Join(entries, ", "))
+}
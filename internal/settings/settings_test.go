@@ -0,0 +1,257 @@
+package settings
+
+import (
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/testutil"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// request decodes a settings request as the API does
+func request(t *testing.T, body string) *Request {
+	t.Helper()
+	var req Request
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	return &req
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		field string // "" = valid
+		err   string
+	}{
+		{"empty", `{}`, "", ""},
+		{"everything", `{
+			"ingress": {"proxy_read_timeout": 300, "websockets": true, "max_body_size_mb": 50},
+			"port": 3000, "visibility": "internal", "process_type": "web",
+			"custom_labels": {"team": "api"}, "custom_annotations": {"example.com/owner": "api"},
+			"build_commands": {"runtime": "node", "start_command": "node server.js"},
+			"runtime_version": " 20 ", "release_command": "npm run migrate",
+			"require_approval": true, "approval_window_minutes": 60,
+			"manifest_patches": [{"kind": "Deployment", "type": "strategic", "patch": "spec:\n  revisionHistoryLimit: 3"}],
+			"preview_provisioner": {"type": "template", "env": {"DATABASE_URL": "postgres://db/app_pr_{pr}"}},
+			"deploy_policy": {"block_force_pushes": true, "enforcement": "block"}
+		}`, "", ""},
+		{"ingress timeout", `{"ingress": {"proxy_read_timeout": 0}}`, "ingress", "between 1 and"},
+		{"body size", `{"ingress": {"max_body_size_mb": 100000}}`, "ingress", "max_body_size_mb must be between 1 and"},
+		{"port", `{"port": 70000}`, "port", "port must be between 1 and 65535"},
+		{"negative port", `{"port": -1}`, "port", "port must be between 1 and 65535"},
+		{"visibility", `{"visibility": "secret"}`, "visibility", "visibility must be public or internal"},
+		{"label", `{"custom_labels": {"team": "api team"}}`, "custom_labels", `invalid value for label "team"`},
+		{"annotation", `{"custom_annotations": {"-owner": "api"}}`, "custom_labels", `invalid annotation "-owner"`},
+		{"runtime", `{"build_commands": {"runtime": "cobol", "start_command": "run"}}`, "build_commands", "runtime must be one of"},
+		{"static site port", `{"port": 3000, "build_commands": {"build_command": "npm run build", "output_dir": "dist"}}`, "build_commands", "static sites are served on port"},
+		{"runtime version", `{"runtime_version": "20; rm -rf /"}`, "runtime_version", "is not allowed on this platform"},
+		{"process type", `{"process_type": "cron"}`, "process_type", "process_type must be web or worker"},
+		{"release command", `{"release_command": "` + strings.Repeat("x", maxReleaseCommand+1) + `"}`, "release_command", "at most 4096 bytes"},
+		{"approval window", `{"require_approval": true, "approval_window_minutes": -5}`, "approval_window_minutes", "approval_window_minutes must be between 1 and"},
+		{"denied patch", `{"manifest_patches": [{"kind": "Deployment", "type": "json6902", "patch": "[{\"op\": \"add\", \"path\": \"/spec/template/spec/hostNetwork\", \"value\": true}]"}]}`, "manifest_patches", "spec.template.spec.hostNetwork is owned by the platform"},
+		{"privileged patch", `{"manifest_patches": [{"kind": "Deployment", "type": "json6902", "patch": "[{\"op\": \"add\", \"path\": \"/spec/template/spec/containers/0/securityContext\", \"value\": {\"privileged\": true}}]"}]}`, "manifest_patches", "privileged containers are not allowed"},
+		{"provisioner", `{"preview_provisioner": {"type": "command"}}`, "preview_provisioner", "command provisioners need a command"},
+		{"policy", `{"deploy_policy": {"block_force_pushes": true, "enforcement": "shout"}}`, "deploy_policy", "enforcement must be block or warn"},
+	}
+	for _, tt := range tests {
+		problems := Validate(request(t, tt.body))
+		if tt.field == "" {
+			if len(problems) > 0 {
+				t.Errorf("%s: %+v", tt.name, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || problems[0].Field != tt.field || !strings.Contains(problems[0].Message, tt.err) {
+			t.Errorf("%s: got %+v, want %s: %q", tt.name, problems, tt.field, tt.err)
+		}
+	}
+}
+
+// Every problem is reported at once, in the order of the request's fields,
+// and commands are trimmed as they are saved
+func TestValidateAll(t *testing.T) {
+	req := request(t, `{
+		"port": 99999, "process_type": "cron", "release_command": "  npm run migrate\n",
+		"runtime_version": " 20 ", "deploy_policy": {"enforcement": "shout"},
+		"preview_provisioner": {"type": "command", "command": " ./provision.sh ", "teardown_command": "\t./teardown.sh"}
+	}`)
+	var fields []string
+	for _, problem := range Validate(req) {
+		fields = append(fields, problem.Field)
+	}
+	if want := []string{"port", "process_type", "deploy_policy"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("problems with %q, want %q", fields, want)
+	}
+	if req.ReleaseCommand != "npm run migrate" || req.RuntimeVersion != "20" || req.PreviewProvisioner.Command != "./provision.sh" || req.PreviewProvisioner.TeardownCommand != "./teardown.sh" {
+		t.Errorf("not trimmed: %+v, %+v", req, req.PreviewProvisioner)
+	}
+}
+
+// current are the settings of a project as a request would send them back
+const current = `{
+	"port": 8080, "visibility": "public", "process_type": "web",
+	"custom_labels": {"team": "api"}, "release_command": "npm run migrate"
+}`
+
+func currentProject(t *testing.T) *models.Project {
+	t.Helper()
+	project := &models.Project{ID: 7, Name: "app", Branch: "main", RepoOwner: "acme", RepoName: "app"}
+	Apply(project, request(t, current))
+	return project
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		changes []string // Messages, in order
+		effects []string
+	}{
+		{"unchanged", current, nil, nil},
+		{"omitted visibility and process type", `{"port": 8080, "custom_labels": {"team": "api"}, "release_command": "npm run migrate"}`, nil, nil},
+		{
+			"port",
+			`{"port": 3000, "custom_labels": {"team": "api"}, "release_command": "npm run migrate"}`,
+			[]string{"port 8080→3000: pods roll with the new PORT on the next deployment"},
+			[]string{EffectNextDeploy},
+		},
+		{
+			"detected port",
+			`{"custom_labels": {"team": "api"}, "release_command": "npm run migrate"}`,
+			[]string{"port 8080→detected: pods roll with the new PORT on the next deployment"},
+			[]string{EffectNextDeploy},
+		},
+		{
+			"build and labels",
+			`{"port": 8080, "custom_labels": {"team": "web", "tier": "frontend"}, "release_command": "npm run migrate",
+			  "build_commands": {"start_command": "node server.js"}, "runtime_version": "22"}`,
+			[]string{
+				`custom_labels "team=api"→"team=web, tier=frontend": patched onto the project's objects now, without restarting pods`,
+				`build_commands detected→start "node server.js": requires a rebuild: running deployments keep their image`,
+				"runtime_version detected→22: requires a rebuild: running deployments keep their image; Dockerfiles of the repository or the project's override are left as they are",
+			},
+			[]string{EffectNow, EffectRebuild, EffectRebuild},
+		},
+		{
+			"internal worker",
+			`{"port": 8080, "visibility": "internal", "process_type": "worker", "custom_labels": {"team": "api"}}`,
+			[]string{
+				"visibility public→internal: its Ingresses are removed now; its hostnames stay reserved for when it is public again",
+				"process_type web→worker: its Service and Ingress are removed on the next deployment, and its hostnames stop serving",
+				`release_command "npm run migrate"→none: no longer runs before rollouts, from the next deployment`,
+			},
+			[]string{EffectNow, EffectNextDeploy, EffectNextDeploy},
+		},
+		{
+			"ingress",
+			`{"port": 8080, "custom_labels": {"team": "api"}, "release_command": "npm run migrate", "ingress": {"websockets": true}}`,
+			[]string{"ingress none→"},
+			[]string{EffectNextDeploy},
+		},
+		{
+			"approval",
+			`{"port": 8080, "custom_labels": {"team": "api"}, "release_command": "npm run migrate", "require_approval": true, "approval_window_minutes": 90}`,
+			[]string{
+				"require_approval false→true: production deployments wait for an approver once built, from the next build",
+				"approval_window_minutes default→1h30m0s: applies to deployments built from now on; those already awaiting approval keep their deadline",
+			},
+			[]string{EffectNextDeploy, EffectNextDeploy},
+		},
+		{
+			"branch deploys",
+			`{"port": 8080, "custom_labels": {"team": "api"}, "release_command": "npm run migrate", "branch_deploys": true}`,
+			[]string{"branch_deploys false→true: pushes to other branches than main deploy to resources and a hostname of their own from now on"},
+			[]string{EffectNow},
+		},
+	}
+	for _, tt := range tests {
+		project := currentProject(t)
+		before := *project
+		changes := Diff(project, request(t, tt.body))
+		if !reflect.DeepEqual(*project, before) {
+			t.Errorf("%s: diffing changed the project", tt.name)
+		}
+		if len(changes) != len(tt.changes) {
+			t.Errorf("%s: got %+v", tt.name, changes)
+			continue
+		}
+		for i, change := range changes {
+			if !strings.HasPrefix(change.Message, tt.changes[i]) || change.Effect != tt.effects[i] {
+				t.Errorf("%s: change %d is %q (%s), want %q (%s)", tt.name, i, change.Message, change.Effect, tt.changes[i], tt.effects[i])
+			}
+		}
+	}
+
+	changes := Diff(currentProject(t), request(t, `{"port": 3000}`))
+	if !changes.Has("release_command", "port") || changes.Has("visibility") {
+		t.Errorf("changes %+v", changes)
+	}
+	if port := changes[0]; port.Field != "port" || port.From != 8080 || port.To != 3000 {
+		t.Errorf("port change %+v", port)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	testutil.DB(t)
+	tests := []struct {
+		name     string
+		body     string
+		warnings []string
+	}{
+		{"none", current, nil},
+		{"worker", `{"port": 8080, "process_type": "worker", "ingress": {"websockets": true},
+			"manifest_patches": [{"kind": "Service", "type": "strategic", "patch": "metadata:\n  labels:\n    tier: web"}]}`, []string{
+			"workers listen on no port: port 8080 is ignored",
+			"the project has no Ingress while it is internal or a worker: the ingress settings are ignored",
+			"workers have no Service: their Service patches are skipped",
+		}},
+		{"internal", `{"visibility": "internal", "manifest_patches": [{"kind": "Ingress", "type": "strategic", "patch": "metadata:\n  labels:\n    tier: web"}]}`, []string{
+			"the project has no Ingress while it is internal or a worker: its Ingress patches are skipped",
+		}},
+		{"approval window", `{"approval_window_minutes": 30}`, []string{
+			"production deployments don't wait for approval: approval_window_minutes is ignored",
+		}},
+		{"provisioner", `{"preview_provisioner": {"type": "command", "command": "./provision.sh"}}`, []string{
+			"the preview provisioner has no teardown_command: what its command provisions is never freed",
+		}},
+		{"policy", `{"deploy_policy": {"enforcement": "warn"}}`, []string{
+			"the deploy policy enables no rule: pushes to production are never checked",
+		}},
+	}
+	for _, tt := range tests {
+		project := currentProject(t)
+		req := request(t, tt.body)
+		if problems := Validate(req); len(problems) > 0 {
+			t.Fatalf("%s: %+v", tt.name, problems)
+		}
+		if got := Warnings(project, req, Diff(project, req)); !reflect.DeepEqual(got, tt.warnings) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.warnings)
+		}
+	}
+
+	// Verified commits need the repository on GitHub
+	project := currentProject(t)
+	project.RepoOwner, project.RepoName = "", ""
+	req := request(t, `{"deploy_policy": {"require_verified_commits": true, "enforcement": "block"}}`)
+	if got := Warnings(project, req, Diff(project, req)); len(got) != 1 || !strings.Contains(got[0], "require_verified_commits breaks every push to production") {
+		t.Errorf("verified commits: %q", got)
+	}
+
+	// A rebuild over the daily quota can't run
+	plan := &models.Plan{Name: "free", MaxDeploymentsPerDay: 1}
+	database.DB.Create(plan)
+	user := &models.User{Username: "ada", Email: "ada@example.com", PlanID: &plan.ID}
+	database.DB.Create(user)
+	project = currentProject(t)
+	project.UserID = user.ID
+	project.DeploymentsToday, project.DeploymentsDay = 1, time.Now().UTC().Format("2006-01-02")
+	req = request(t, `{"runtime_version": "22"}`)
+	if got := Warnings(project, req, Diff(project, req)); len(got) != 1 || !strings.HasPrefix(got[0], "the rebuild the new settings need can't run today: ") {
+		t.Errorf("over quota: %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+)
+
+// Key joins parts into a key, dropping empty parts and stray slashes
+func Key(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part = strings.Trim(part, "/"); part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return path.Join(kept...)
+}
+
+// ContentKey is the content-addressable key of content whose sha256 is sum
+// (hex) under prefix: <prefix>/sha256/<first 2 characters>/<sum><ext>, the
+// fan-out keeping listings of a directory short. Identical content is
+// stored once.
+func ContentKey(prefix, sum, ext string) string {
+	fanout := sum
+	if len(fanout) > 2 {
+		fanout = fanout[:2]
+	}
+	return Key(prefix, "sha256", fanout, sum+ext)
+}
+
+// HashKey returns the ContentKey of data, and its sha256 (hex)
+func HashKey(prefix string, data []byte, ext string) (string, string) {
+	sum := sha256.Sum256(data)
+	hexSum := hex.EncodeToString(sum[:])
+	return ContentKey(prefix, hexSum, ext), hexSum
+}
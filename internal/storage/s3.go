@@ -0,0 +1,543 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the size of multipart upload parts. S3 requires at least
+// 5MB for every part but the last and allows 10,000 parts, so objects up to
+// ~160GB fit.
+const s3PartSize = 16 << 20
+
+// Requests failing with a network error or a 5xx (or 429) status are retried
+// up to s3Attempts times in all, waiting s3RetryBackoff, doubled each time,
+// in between
+const (
+	s3Attempts     = 4
+	s3RetryBackoff = 250 * time.Millisecond
+)
+
+// The S3 client has no timeout for whole requests, which would cut off
+// objects streamed to slow readers: connecting and waiting for the response
+// headers are bounded, the rest is up to the caller's ctx. Headers of part
+// uploads come once the store has the whole part.
+var (
+	s3DialTimeout           = 10 * time.Second
+	s3ResponseHeaderTimeout = time.Minute
+)
+
+// s3FirstReadSize is the buffer Put starts reading an object into, doubled
+// until the object or a part fits
+const s3FirstReadSize = 64 << 10
+
+// Payload hashes: of an empty body, and of presigned URLs' unknown one
+const (
+	emptyPayloadHash    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unsignedPayloadHash = "UNSIGNED-PAYLOAD"
+)
+
+// S3Options locate a bucket of an S3-compatible store
+type S3Options struct {
+	Endpoint  string // e.g. "https://minio.internal:9000", empty = AWS S3 in Region
+	Region    string
+	Bucket    string
+	Prefix    string // Prepended to every key, "" = bucket root
+	AccessKey string
+	SecretKey string
+	PathStyle bool // {endpoint}/{bucket}/{key}, as MinIO and most other stores need, rather than {bucket}.{endpoint}/{key}
+}
+
+// S3 keeps objects in a bucket of an S3-compatible store. Requests are
+// signed with AWS Signature Version 4.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string // Key prefix without slashes at either end, "" = bucket root
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+// NewS3 creates a bucket storage
+func NewS3(options S3Options) *S3 {
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + options.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		u = &url.URL{Scheme: "https", Host: endpoint}
+	}
+	return &S3{
+		endpoint:  u,
+		region:    options.Region,
+		bucket:    options.Bucket,
+		prefix:    strings.Trim(options.Prefix, "/"),
+		accessKey: options.AccessKey,
+		secretKey: options.SecretKey,
+		pathStyle: options.PathStyle,
+		client:    &http.Client{Transport: newS3Transport()},
+	}
+}
+
+func newS3Transport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: s3DialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   s3DialTimeout,
+		ResponseHeaderTimeout: s3ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+func (s *S3) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+// Put uploads small objects in one request and larger ones in parts, so
+// memory use stays at one part whatever the size of the object, and at
+// about the object's size for smaller ones
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := ValidateKey(key); err != nil {
+		return 0, err
+	}
+	key = s.key(key)
+	part, err := readFirstPart(r)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		_, err := s.do(ctx, http.MethodPut, key, nil, part, http.StatusOK)
+		return int64(len(part)), err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	uploadID, err := s.createMultipartUpload(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	total, err := s.uploadParts(ctx, key, uploadID, part, r)
+	if err != nil {
+		// Abandoned parts are billed until aborted
+		s.do(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, http.StatusNoContent)
+		return total, err
+	}
+	return total, nil
+}
+
+// readFirstPart reads r until it ends, with io.EOF or io.ErrUnexpectedEOF,
+// or fills a part, whose buffer then has a capacity of s3PartSize
+func readFirstPart(r io.Reader) ([]byte, error) {
+	buf := make([]byte, 0, s3FirstReadSize)
+	for {
+		n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil || len(buf) == s3PartSize {
+			return buf, err
+		}
+		grown := make([]byte, len(buf), min(2*cap(buf), s3PartSize))
+		copy(grown, buf)
+		buf = grown
+	}
+}
+
+// uploadParts uploads first and the rest of r as parts, then completes the upload
+func (s *S3) uploadParts(ctx context.Context, key, uploadID string, first []byte, r io.Reader) (int64, error) {
+	var complete completeMultipartUpload
+	var total int64
+	buf := first
+	for number := 1; ; number++ {
+		resp, err := s.do(ctx, http.MethodPut, key, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}, buf, http.StatusOK)
+		if err != nil {
+			return total, fmt.Errorf("failed to upload part %d: %w", number, err)
+		}
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		total += int64(len(buf))
+
+		n, err := io.ReadFull(r, first[:cap(first)])
+		if n == 0 && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return total, err
+		}
+		buf = first[:n]
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return total, err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, http.StatusOK)
+	if err != nil {
+		return total, fmt.Errorf("failed to complete upload: %w", err)
+	}
+	// Completion can fail after the 200 status has been sent
+	if bytes.Contains(resp.Body, []byte("<Error>")) {
+		return total, fmt.Errorf("failed to complete upload: %s", firstBytes(resp.Body))
+	}
+	return total, nil
+}
+
+func (s *S3) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, http.StatusOK)
+	if err != nil {
+		return "", fmt.Errorf("failed to start upload: %w", err)
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp.Body, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("failed to start upload: unexpected response %s", firstBytes(resp.Body))
+	}
+	return result.UploadID, nil
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+// GetRange streams the object, or the range of it, with a Range request
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	var resp *http.Response
+	err := s.retry(ctx, func() (bool, error) {
+		req, err := s.newRequest(ctx, http.MethodGet, s.key(key), nil, nil, header)
+		if err != nil {
+			return false, err
+		}
+		if resp, err = s.client.Do(req); err != nil {
+			return ctx.Err() == nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusPartialContent:
+			return false, nil
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return false, ErrNotFound
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return retryable(resp.StatusCode), fmt.Errorf("s3 GET %s: %s: %s", key, resp.Status, firstBytes(body))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	root := ""
+	if s.prefix != "" {
+		root = s.prefix + "/"
+	}
+	query := url.Values{"list-type": {"2"}}
+	if root+prefix != "" {
+		query.Set("prefix", root+prefix)
+	}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(resp.Body, &result); err != nil {
+			return nil, fmt.Errorf("unexpected list response: %w", err)
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, Object{Key: strings.TrimPrefix(c.Key, root), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	_, err := s.do(ctx, http.MethodDelete, s.key(key), nil, nil, http.StatusNoContent, http.StatusOK)
+	return err
+}
+
+// PresignGet signs a GET of the object into its URL's query
+func (s *S3) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("presigned URLs expire within 7 days, not %s", expires)
+	}
+	u := s.objectURL(s.key(key))
+	now := time.Now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = canonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host,
+		"",
+		"host",
+		unsignedPayloadHash,
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + s.signature(canonicalRequest, now)
+	return u.String(), nil
+}
+
+// s3Response is a read response; bodies of the requests made through do are small
+type s3Response struct {
+	Header http.Header
+	Body   []byte
+}
+
+// do sends a signed request and reads the response, failing unless its
+// status is one of expected. Bodies are in memory, so failed requests are
+// sent again.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte, expected ...int) (*s3Response, error) {
+	var response *s3Response
+	err := s.retry(ctx, func() (bool, error) {
+		req, err := s.newRequest(ctx, method, key, query, body, nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return ctx.Err() == nil, err
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return ctx.Err() == nil, err
+		}
+		for _, status := range expected {
+			if resp.StatusCode == status {
+				response = &s3Response{Header: resp.Header, Body: respBody}
+				return false, nil
+			}
+		}
+		return retryable(resp.StatusCode), fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, firstBytes(respBody))
+	})
+	return response, err
+}
+
+// retry calls attempt until it succeeds, fails for good (returns false) or
+// s3Attempts were made
+func (s *S3) retry(ctx context.Context, attempt func() (bool, error)) error {
+	backoff := s3RetryBackoff
+	for n := 1; ; n++ {
+		again, err := attempt()
+		if err == nil || !again || n == s3Attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a request failing with status may succeed if sent again
+func retryable(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// objectURL is the URL of key ("" = the bucket itself)
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		u.Path += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.RawPath = u.EscapedPath()
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + uriEncode(key, false)
+	} else if u.Path == "" {
+		u.Path, u.RawPath = "/", "/"
+	}
+	return &u
+}
+
+// newRequest builds a signed request for key ("" = the bucket itself)
+func (s *S3) newRequest(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header) (*http.Request, error) {
+	u := s.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	s.sign(req, u.EscapedPath(), payloadHash, time.Now().UTC())
+	return req, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (s *S3) sign(req *http.Request, canonicalURI, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.scope(now), signedHeaders, s.signature(canonicalRequest, now)))
+}
+
+// scope is the credential scope of requests signed at now
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs canonicalRequest with the key derived for now's date
+func (s *S3) signature(canonicalRequest string, now time.Time) string {
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key, as signatures require
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters (and "/"
+// unless encodeSlash), the encoding Signature Version 4 is computed over
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// firstBytes shortens an error response for messages
+func firstBytes(body []byte) string {
+	const max = 512
+	if len(body) > max {
+		return string(body[:max]) + "..."
+	}
+	return string(body)
+}
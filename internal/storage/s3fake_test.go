@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeBucket   = "artifacts"
+	fakeListPage = 2 // Keys per list response, so listings page
+)
+
+// fakeS3 is an in-process S3-compatible server of one bucket, path-style,
+// speaking the subset of the API S3 uses, with S3's part size rules
+type fakeS3 struct {
+	t        *testing.T
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	uploads  map[string]map[int][]byte // Parts of the multipart uploads in progress, by upload ID
+	created  int                       // Multipart uploads started
+	failures int                       // The next requests fail with 503
+	requests int
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// newFakeS3 serves a fakeS3 and returns an S3 storage of its bucket, under prefix
+func newFakeS3(t *testing.T, prefix string) (*S3, *fakeS3) {
+	fake := &fakeS3{t: t, objects: map[string]fakeObject{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	s := NewS3(S3Options{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    fakeBucket,
+		Prefix:    prefix,
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+	})
+	return s, fake
+}
+
+func (f *fakeS3) keys() []string {
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "<Error><Code>SlowDown</Code></Error>", http.StatusServiceUnavailable)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+fakeBucket)
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !f.authorized(w, r, body) {
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.created++
+		uploadID := strconv.Itoa(f.created)
+		f.uploads[uploadID] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", fakeBucket, key, uploadID)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.complete(w, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, modTime: time.Now()}
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet:
+		f.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", http.StatusNotImplemented)
+	}
+}
+
+// authorized checks requests are signed, and their payload hash is the body's
+func (f *fakeS3) authorized(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Signature") != "" && query.Get("X-Amz-Credential") != "" && r.Method == http.MethodGet {
+		return true
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return false
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	object, ok := f.objects[key]
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+		return
+	}
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		w.Write(object.data)
+		return
+	}
+	first, last, _ := strings.Cut(spec, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start >= len(object.data) {
+		http.Error(w, "<Error><Code>InvalidRange</Code></Error>", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	end := len(object.data) - 1
+	if last != "" {
+		if end, err = strconv.Atoi(last); err != nil {
+			http.Error(w, "<Error><Code>InvalidRange</Code></Error>", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		end = min(end, len(object.data)-1)
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(object.data)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(object.data[start : end+1])
+}
+
+// complete assembles the parts listed in body, all but the last at least 5MB
+func (f *fakeS3) complete(w http.ResponseWriter, key, uploadID string, body []byte) {
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+		return
+	}
+	var request completeMultipartUpload
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		http.Error(w, "<Error><Code>MalformedXML</Code></Error>", http.StatusBadRequest)
+		return
+	}
+	var data []byte
+	for i, part := range request.Parts {
+		content, ok := parts[part.PartNumber]
+		if !ok || part.PartNumber != i+1 || part.ETag != etag(content) {
+			http.Error(w, "<Error><Code>InvalidPart</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		if len(content) < 5<<20 && i < len(request.Parts)-1 {
+			http.Error(w, "<Error><Code>EntityTooSmall</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		data = append(data, content...)
+	}
+	delete(f.uploads, uploadID)
+	f.objects[key] = fakeObject{data: data, modTime: time.Now()}
+	fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+}
+
+// list answers ListObjectsV2 requests, fakeListPage keys at a time
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", http.StatusNotImplemented)
+		return
+	}
+	var keys []string
+	for _, key := range f.keys() {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	type contents struct {
+		Key          string    `xml:"Key"`
+		Size         int       `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+	var result struct {
+		XMLName               xml.Name   `xml:"ListBucketResult"`
+		Contents              []contents `xml:"Contents"`
+		IsTruncated           bool       `xml:"IsTruncated"`
+		NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	}
+	if len(keys) > fakeListPage {
+		keys = keys[:fakeListPage]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		object := f.objects[key]
+		result.Contents = append(result.Contents, contents{Key: key, Size: len(object.data), LastModified: object.modTime.UTC()})
+	}
+	body, err := xml.Marshal(result)
+	if err != nil {
+		f.t.Error(err)
+	}
+	w.Write(body)
+}
+
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
@@ -0,0 +1,92 @@
+// Package storage keeps the platform's artifacts, such as backups, archived
+// build logs and supply chain records, in object storage: a local directory
+// for single-node installs, or an S3-compatible bucket. Features hold a
+// Storage and never touch the filesystem or the bucket themselves.
+package storage
+
+import (
+	"context"
+	"deploy-platform/internal/config"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Object is a stored object
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Storage stores objects under keys: slash-separated paths, relative to the
+// storage's root, without "." or ".." segments (see ValidateKey)
+type Storage interface {
+	// Put stores everything read from r under key, returning the bytes
+	// written. A failed Put leaves no partial object behind.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes of the object from offset, to its end
+	// when length < 0
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// List returns the objects whose keys start with prefix, ordered by key
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Delete removes an object; removing one that doesn't exist is not an error
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL anyone can GET the object from until expires,
+	// ErrUnsupported by storages that can't hand out URLs
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+var (
+	// ErrNotFound is returned by Get and GetRange for unknown objects
+	ErrNotFound = errors.New("object not found")
+	// ErrUnsupported is returned by PresignGet of storages without URLs
+	ErrUnsupported = errors.New("not supported by this storage")
+)
+
+// Open opens dest, the value of setting: s3://bucket/prefix for a bucket
+// reached with the BACKUP_S3_* endpoint and credentials, a directory
+// (optionally file://) otherwise
+func Open(cfg *config.Config, setting, dest string) (Storage, error) {
+	if rest, ok := strings.CutPrefix(dest, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid %s %q: missing bucket", setting, dest)
+		}
+		if cfg.BackupS3AccessKey == "" || cfg.BackupS3SecretKey == "" {
+			return nil, errors.New("BACKUP_S3_ACCESS_KEY and BACKUP_S3_SECRET_KEY are required for an s3:// destination")
+		}
+		return NewS3(S3Options{
+			Endpoint:  cfg.BackupS3Endpoint,
+			Region:    cfg.BackupS3Region,
+			Bucket:    bucket,
+			Prefix:    prefix,
+			AccessKey: cfg.BackupS3AccessKey,
+			SecretKey: cfg.BackupS3SecretKey,
+			PathStyle: cfg.BackupS3PathStyle,
+		}), nil
+	}
+	return NewLocal(strings.TrimPrefix(dest, "file://"))
+}
+
+// ValidateKey checks key can be stored by every driver
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "\\\x00") {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
+
+// sortObjects orders objects by key
+func sortObjects(objects []Object) {
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+}
@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testContract checks the behavior every Storage promises, on storages
+// made by open, each empty
+func testContract(t *testing.T, open func(t *testing.T) Storage) {
+	ctx := context.Background()
+
+	t.Run("put and get", func(t *testing.T) {
+		s := open(t)
+		n, err := s.Put(ctx, "logs/1.log", strings.NewReader("hello"))
+		if err != nil || n != 5 {
+			t.Fatalf("Put = %d, %v", n, err)
+		}
+		if got := read(t, s, "logs/1.log", 0, -1); got != "hello" {
+			t.Errorf("got %q", got)
+		}
+
+		// Putting again replaces the object
+		if _, err := s.Put(ctx, "logs/1.log", strings.NewReader("bye")); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, s, "logs/1.log", 0, -1); got != "bye" {
+			t.Errorf("got %q after overwriting", got)
+		}
+	})
+
+	t.Run("empty object", func(t *testing.T) {
+		s := open(t)
+		if n, err := s.Put(ctx, "empty", strings.NewReader("")); err != nil || n != 0 {
+			t.Fatalf("Put = %d, %v", n, err)
+		}
+		if got := read(t, s, "empty", 0, -1); got != "" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("multipart object", func(t *testing.T) {
+		s := open(t)
+		data := pattern(2*s3PartSize + 1234)
+		n, err := s.Put(ctx, "backups/big.tar", bytes.NewReader(data))
+		if err != nil || n != int64(len(data)) {
+			t.Fatalf("Put = %d, %v", n, err)
+		}
+		if got := read(t, s, "backups/big.tar", 0, -1); got != string(data) {
+			t.Errorf("read back %d bytes, differing from the %d written", len(got), len(data))
+		}
+		// A range across the boundary of two parts
+		if got := read(t, s, "backups/big.tar", s3PartSize-10, 20); got != string(data[s3PartSize-10:s3PartSize+10]) {
+			t.Errorf("got %q across parts", got)
+		}
+	})
+
+	t.Run("get range", func(t *testing.T) {
+		s := open(t)
+		put(t, s, "range", "0123456789")
+		for _, tt := range []struct {
+			offset, length int64
+			want           string
+		}{
+			{0, -1, "0123456789"},
+			{3, -1, "3456789"},
+			{3, 4, "3456"},
+			{0, 1, "0"},
+			{8, 100, "89"}, // Cut at the end of the object
+			{5, 0, ""},
+		} {
+			if got := read(t, s, "range", tt.offset, tt.length); got != tt.want {
+				t.Errorf("GetRange(%d, %d) = %q, want %q", tt.offset, tt.length, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s := open(t)
+		if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get: %v", err)
+		}
+		if _, err := s.GetRange(ctx, "missing", 2, 3); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetRange: %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		s := open(t)
+		if objects, err := s.List(ctx, ""); err != nil || len(objects) != 0 {
+			t.Fatalf("empty storage lists %v, %v", objects, err)
+		}
+		for _, key := range []string{"b/2", "a/1", "b/1", "b/sub/3", "bb/4", "c"} {
+			put(t, s, key, key)
+		}
+		for prefix, want := range map[string][]string{
+			"":        {"a/1", "b/1", "b/2", "b/sub/3", "bb/4", "c"},
+			"b/":      {"b/1", "b/2", "b/sub/3"},
+			"b":       {"b/1", "b/2", "b/sub/3", "bb/4"},
+			"b/sub/3": {"b/sub/3"},
+			"d":       nil,
+		} {
+			objects, err := s.List(ctx, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+				if object.Size != int64(len(object.Key)) || object.ModTime.IsZero() {
+					t.Errorf("%s listed with size %d, modified %s", object.Key, object.Size, object.ModTime)
+				}
+			}
+			if !reflect.DeepEqual(keys, want) {
+				t.Errorf("List(%q) = %q, want %q", prefix, keys, want)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := open(t)
+		put(t, s, "gone", "x")
+		if err := s.Delete(ctx, "gone"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get(ctx, "gone"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get after Delete: %v", err)
+		}
+		if err := s.Delete(ctx, "gone"); err != nil {
+			t.Errorf("deleting a missing object: %v", err)
+		}
+	})
+
+	t.Run("failed put", func(t *testing.T) {
+		s := open(t)
+		for _, size := range []int{10, s3PartSize + 10} {
+			r := io.MultiReader(bytes.NewReader(pattern(size)), &failingReader{})
+			if _, err := s.Put(ctx, "partial", r); !errors.Is(err, errReadFailed) {
+				t.Fatalf("Put of a failing reader after %d bytes: %v", size, err)
+			}
+			if _, err := s.Get(ctx, "partial"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("failed Put after %d bytes left an object: %v", size, err)
+			}
+			if objects, _ := s.List(ctx, ""); len(objects) != 0 {
+				t.Errorf("failed Put after %d bytes lists %v", size, objects)
+			}
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		s := open(t)
+		for _, key := range []string{"", "/abs", "dir/", "a//b", "../escape", "a/./b", "a\\b", "nul\x00"} {
+			if _, err := s.Put(ctx, key, strings.NewReader("x")); err == nil {
+				t.Errorf("Put(%q) accepted", key)
+			}
+			if _, err := s.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(%q): %v", key, err)
+			}
+			if err := s.Delete(ctx, key); err == nil {
+				t.Errorf("Delete(%q) accepted", key)
+			}
+			if _, err := s.PresignGet(ctx, key, time.Minute); err == nil {
+				t.Errorf("PresignGet(%q) accepted", key)
+			}
+		}
+	})
+
+	t.Run("presigned URL", func(t *testing.T) {
+		s := open(t)
+		put(t, s, "sbom/app.json", `{"sbom":true}`)
+		u, err := s.PresignGet(ctx, "sbom/app.json", time.Hour)
+		if errors.Is(err, ErrUnsupported) {
+			t.Skip("storage can't hand out URLs")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != `{"sbom":true}` {
+			t.Errorf("GET of the presigned URL: %s %q", resp.Status, body)
+		}
+		if _, err := s.PresignGet(ctx, "sbom/app.json", 8*24*time.Hour); err == nil {
+			t.Error("URL valid for 8 days handed out")
+		}
+	})
+}
+
+func TestLocalContract(t *testing.T) {
+	testContract(t, func(t *testing.T) Storage {
+		s, err := NewLocal(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestS3Contract(t *testing.T) {
+	for _, prefix := range []string{"", "/platform/artifacts/"} {
+		t.Run("prefix "+prefix, func(t *testing.T) {
+			testContract(t, func(t *testing.T) Storage {
+				s, _ := newFakeS3(t, prefix)
+				return s
+			})
+		})
+	}
+}
+
+func TestS3Prefix(t *testing.T) {
+	s, fake := newFakeS3(t, "/platform/artifacts/")
+	put(t, s, "logs/1.log", "x")
+	fake.objects["elsewhere"] = fakeObject{} // Outside the prefix
+	if _, ok := fake.objects["platform/artifacts/logs/1.log"]; !ok {
+		t.Errorf("stored as %v", fake.keys())
+	}
+	objects, err := s.List(context.Background(), "")
+	if err != nil || len(objects) != 1 || objects[0].Key != "logs/1.log" {
+		t.Errorf("List = %+v, %v", objects, err)
+	}
+}
+
+func TestS3Retries(t *testing.T) {
+	s, fake := newFakeS3(t, "")
+	fake.failures = s3Attempts - 1
+	put(t, s, "retried", "x")
+	if fake.failures != 0 {
+		t.Errorf("%d failures left", fake.failures)
+	}
+
+	fake.failures = s3Attempts - 1
+	if got := read(t, s, "retried", 0, -1); got != "x" {
+		t.Errorf("got %q", got)
+	}
+
+	fake.failures = s3Attempts
+	if _, err := s.Put(context.Background(), "failed", strings.NewReader("x")); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Put after %d failures: %v", s3Attempts, err)
+	}
+
+	// Missing objects aren't asked for again
+	requests := fake.requests
+	if _, err := s.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) || fake.requests != requests+1 {
+		t.Errorf("Get: %v after %d requests", err, fake.requests-requests)
+	}
+}
+
+func TestS3AbortsFailedUpload(t *testing.T) {
+	s, fake := newFakeS3(t, "")
+	r := io.MultiReader(bytes.NewReader(pattern(s3PartSize+10)), &failingReader{})
+	if _, err := s.Put(context.Background(), "partial", r); !errors.Is(err, errReadFailed) {
+		t.Fatal(err)
+	}
+	if fake.created != 1 || len(fake.uploads) != 0 {
+		t.Errorf("%d uploads started, %d left unaborted", fake.created, len(fake.uploads))
+	}
+}
+
+// Objects stream for as long as their reader takes, only a store slow to
+// answer times out
+func TestS3Timeouts(t *testing.T) {
+	timeout := s3ResponseHeaderTimeout
+	s3ResponseHeaderTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { s3ResponseHeaderTimeout = timeout })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/stalled") {
+			time.Sleep(4 * s3ResponseHeaderTimeout)
+			return
+		}
+		for i := 0; i < 5; i++ {
+			io.WriteString(w, "chunk")
+			w.(http.Flusher).Flush()
+			time.Sleep(2 * s3ResponseHeaderTimeout)
+		}
+	}))
+	t.Cleanup(server.Close)
+	s := NewS3(S3Options{Endpoint: server.URL, Region: "us-east-1", Bucket: "artifacts", PathStyle: true})
+
+	started := time.Now()
+	if got := read(t, s, "slow", 0, -1); got != strings.Repeat("chunk", 5) {
+		t.Errorf("got %q", got)
+	}
+	if elapsed := time.Since(started); elapsed < 10*s3ResponseHeaderTimeout {
+		t.Errorf("streamed in %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := s.Get(ctx, "stalled"); err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Errorf("stalled store: %v", err)
+	}
+}
+
+// Put reads objects smaller than a part into a buffer of about their size
+func TestReadFirstPart(t *testing.T) {
+	for _, tt := range []struct {
+		size, maxCap int
+		err          error
+	}{
+		{0, s3FirstReadSize, io.EOF},
+		{1000, s3FirstReadSize, io.ErrUnexpectedEOF},
+		{s3FirstReadSize, 2 * s3FirstReadSize, io.EOF}, // Full, grown before reading the end
+		{s3FirstReadSize + 1, 2 * s3FirstReadSize, io.ErrUnexpectedEOF},
+		{3 << 20, 4 << 20, io.ErrUnexpectedEOF},
+		{s3PartSize, s3PartSize, nil},
+		{s3PartSize + 1, s3PartSize, nil}, // The rest goes in the next parts
+	} {
+		data := pattern(tt.size)
+		part, err := readFirstPart(bytes.NewReader(data))
+		if err != tt.err || cap(part) > tt.maxCap || !bytes.Equal(part, data[:min(tt.size, s3PartSize)]) {
+			t.Errorf("%d bytes: read %d in a buffer of %d, %v", tt.size, len(part), cap(part), err)
+		}
+		if err == nil && cap(part) != s3PartSize {
+			t.Errorf("%d bytes: parts are read into a buffer of %d", tt.size, cap(part))
+		}
+	}
+}
+
+func TestContentKey(t *testing.T) {
+	key, sum := HashKey("sbom", []byte("hello"), ".json")
+	if sum != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("sum %s", sum)
+	}
+	if key != "sbom/sha256/2c/"+sum+".json" {
+		t.Errorf("key %s", key)
+	}
+	if key := Key("/a/", "", "b/c/", "/"); key != "a/b/c" {
+		t.Errorf("Key = %s", key)
+	}
+	if err := ValidateKey(key); err != nil {
+		t.Error(err)
+	}
+}
+
+func put(t *testing.T, s Storage, key, content string) {
+	t.Helper()
+	if _, err := s.Put(context.Background(), key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func read(t *testing.T, s Storage, key string, offset, length int64) string {
+	t.Helper()
+	r, err := s.GetRange(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatalf("GetRange(%q, %d, %d): %v", key, offset, length, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// pattern is size bytes that differ from one part to the next
+func pattern(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+var errReadFailed = errors.New("read failed")
+
+// failingReader fails, as a connection dropped mid-upload
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	return 0, errReadFailed
+}
@@ -0,0 +1,25 @@
+// Package testutil sets up what the tests of several packages need
+package testutil
+
+import (
+	"deploy-platform/internal/database"
+	"testing"
+
+	"gorm.io/gorm/logger"
+)
+
+// DB points database.DB at a fresh SQLite database, migrated, in a
+// temporary directory the test runs in
+func DB(t testing.TB) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := database.InitDB(""); err != nil {
+		t.Fatalf("failed to create the test database: %v", err)
+	}
+	database.DB.Logger = logger.Discard
+	t.Cleanup(func() {
+		if db, err := database.DB.DB(); err == nil {
+			db.Close()
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package textutil
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ShortSHALength is how many characters of a commit SHA are shown
+const ShortSHALength = 7
+
+// Commit SHAs are accepted abbreviated, from MinSHALength hex characters,
+// up to the 64 of SHA-256 repositories
+const (
+	MinSHALength = 7
+	MaxSHALength = 64
+)
+
+// NormalizeSHA checks sha, from a webhook or a request, is a commit SHA,
+// full or abbreviated, and returns it lowercased as Git prints it
+func NormalizeSHA(sha string) (string, error) {
+	if len(sha) < MinSHALength || len(sha) > MaxSHALength || strings.Trim(sha, "0123456789abcdefABCDEF") != "" {
+		return "", fmt.Errorf("commit SHA must be %d to %d hex characters, got %q", MinSHALength, MaxSHALength, Truncate(Clean(sha), 80))
+	}
+	return strings.ToLower(sha), nil
+}
+
+// ShortSHA abbreviates a commit SHA to ShortSHALength characters. Shorter
+// and empty SHAs are returned as they are rather than panicking.
+func ShortSHA(sha string) string {
+	if len(sha) > ShortSHALength {
+		return sha[:ShortSHALength]
+	}
+	return sha
+}
+
+// Clean makes untrusted text, like commit messages, safe to store and show:
+// invalid UTF-8 is replaced with U+FFFD, and control characters other than
+// newlines and tabs are removed, as are the bidirectional overrides and
+// isolates that make text render in another order than it reads (RTL text
+// itself is kept). \r\n line endings become \n.
+func Clean(s string) string {
+	s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.IsControl(r), isBidiControl(r):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// isBidiControl reports whether r is an embedding, override or isolate
+// (U+202A to U+202E, U+2066 to U+2069)
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// Truncate shortens s to at most maxBytes bytes, cutting between runes and
+// ending with "…" when it cut. s is expected to be valid UTF-8, see Clean.
+func Truncate(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	const ellipsis = "…"
+	if maxBytes < len(ellipsis) {
+		return ""
+	}
+	cut := maxBytes - len(ellipsis)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return strings.TrimRightFunc(s[:cut], unicode.IsSpace) + ellipsis
+}
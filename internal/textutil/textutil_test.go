@@ -0,0 +1,113 @@
+package textutil
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// adversarial are seeds for the fuzz targets of the packages handling
+// commit messages: long, invalid UTF-8, control characters, bidirectional
+// overrides, RTL text, combining marks and joined emoji
+var adversarial = []string{
+	"",
+	"Fix the build",
+	"feat: 🚀 launch\n\nCo-authored-by: Ada",
+	strings.Repeat("🎉 שלום עולם مرحبا ", 600), // About 10KB
+	"\xff\xfe\xfd broken \xc3",
+	"evil\u202egnp.exe",
+	"\u2066isolated\u2069 text",
+	"line\r\nending\rcarriage",
+	"\x00null\x1b[31mred\x7f",
+	"é́́ combining",
+	"👨‍👩‍👧‍👦 family, 🏳️‍🌈 flag",
+	"=HYPERLINK(\"http://evil\")",
+	"\t-tab first",
+}
+
+func FuzzClean(f *testing.F) {
+	for _, s := range adversarial {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		cleaned := Clean(s)
+		if !utf8.ValidString(cleaned) {
+			t.Fatalf("Clean(%q) = %q, not valid UTF-8", s, cleaned)
+		}
+		for _, r := range cleaned {
+			if (unicode.IsControl(r) && r != '\n' && r != '\t') || isBidiControl(r) {
+				t.Fatalf("Clean(%q) = %q keeps %U", s, cleaned, r)
+			}
+		}
+		if again := Clean(cleaned); again != cleaned {
+			t.Fatalf("Clean isn't idempotent on %q: %q", cleaned, again)
+		}
+	})
+}
+
+func FuzzTruncate(f *testing.F) {
+	for _, s := range adversarial {
+		f.Add(s, 7)
+		f.Add(s, 1024)
+	}
+	f.Fuzz(func(t *testing.T, s string, maxBytes int) {
+		s = Clean(s)
+		maxBytes = max(0, maxBytes%(len(s)+8))
+		got := Truncate(s, maxBytes)
+		if len(got) > maxBytes {
+			t.Fatalf("Truncate(%q, %d) = %q, %d bytes", s, maxBytes, got, len(got))
+		}
+		if !utf8.ValidString(got) {
+			t.Fatalf("Truncate(%q, %d) = %q splits a rune", s, maxBytes, got)
+		}
+		if len(s) <= maxBytes && got != s {
+			t.Fatalf("Truncate(%q, %d) = %q, cut a string that fits", s, maxBytes, got)
+		}
+		if !strings.HasPrefix(s, strings.TrimSuffix(got, "…")) {
+			t.Fatalf("Truncate(%q, %d) = %q isn't a prefix", s, maxBytes, got)
+		}
+	})
+}
+
+func FuzzShortSHA(f *testing.F) {
+	for _, s := range []string{"", "abc", "0123456", "0123456789abcdef0123456789abcdef01234567"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, sha string) {
+		short := ShortSHA(sha)
+		if len(short) > ShortSHALength || !strings.HasPrefix(sha, short) || (len(sha) >= ShortSHALength && len(short) != ShortSHALength) {
+			t.Fatalf("ShortSHA(%q) = %q", sha, short)
+		}
+	})
+}
+
+func FuzzNormalizeSHA(f *testing.F) {
+	for _, s := range append(adversarial, "0123456789ABCDEF0123456789abcdef01234567", "012345", "0123456") {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, sha string) {
+		normalized, err := NormalizeSHA(sha)
+		if err != nil {
+			if !utf8.ValidString(err.Error()) || len(err.Error()) > 200 {
+				t.Fatalf("NormalizeSHA(%q) error %q echoes the input raw", sha, err)
+			}
+			return
+		}
+		if len(normalized) < MinSHALength || len(normalized) > MaxSHALength || strings.Trim(normalized, "0123456789abcdef") != "" {
+			t.Fatalf("NormalizeSHA(%q) = %q", sha, normalized)
+		}
+	})
+}
+
+func TestCleanKeepsText(t *testing.T) {
+	for in, want := range map[string]string{
+		"שלום\r\nworld\t🎉": "שלום\nworld\t🎉",
+		"a\u202eb\x00c":    "abc",
+		"\xffok":           "\ufffdok",
+	} {
+		if got := Clean(in); got != want {
+			t.Errorf("Clean(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Semaphore limits how much of a shared resource (the Docker daemon, the
+// cluster API) concurrent jobs may use. Jobs take a weight, heavier jobs
+// occupy more slots. A nil Semaphore never blocks.
+type Semaphore struct {
+	name     string
+	capacity int64
+	sem      *semaphore.Weighted
+	inUse    atomic.Int64
+	waiting  atomic.Int64
+
+	mu       sync.Mutex
+	released chan struct{} // Closed (and replaced) whenever slots are released
+}
+
+// Stats is a snapshot of a semaphore's occupancy
+type Stats struct {
+	Name     string `json:"name"`
+	Capacity int64  `json:"capacity"`
+	InUse    int64  `json:"in_use"`
+	Waiting  int64  `json:"waiting"` // Jobs blocked in Acquire
+}
+
+// New creates a semaphore with capacity slots (at least 1)
+func New(name string, capacity int) *Semaphore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Semaphore{name: name, capacity: int64(capacity), sem: semaphore.NewWeighted(int64(capacity)), released: make(chan struct{})}
+}
+
+// Capacity is how many slots the semaphore has; 0 for a nil semaphore
+func (s *Semaphore) Capacity() int {
+	if s == nil {
+		return 0
+	}
+	return int(s.capacity)
+}
+
+// Clamp limits weight to what the semaphore can ever grant, so a job heavier
+// than the whole capacity still runs (alone) instead of waiting forever
+func (s *Semaphore) Clamp(weight int) int {
+	if s == nil {
+		return weight
+	}
+	if weight < 1 {
+		return 1
+	}
+	if int64(weight) > s.capacity {
+		return int(s.capacity)
+	}
+	return weight
+}
+
+// Acquire blocks until weight slots are free or ctx is done. Waiters are
+// served in order, so a heavy job isn't starved by a stream of light ones.
+func (s *Semaphore) Acquire(ctx context.Context, weight int) error {
+	if s == nil {
+		return nil
+	}
+	weight = s.Clamp(weight)
+	s.waiting.Add(1)
+	defer s.waiting.Add(-1)
+	if err := s.sem.Acquire(ctx, int64(weight)); err != nil {
+		return err
+	}
+	s.inUse.Add(int64(weight))
+	return nil
+}
+
+// TryAcquire takes weight slots if they are free and nobody waits for
+// slots, without blocking
+func (s *Semaphore) TryAcquire(weight int) bool {
+	if s == nil {
+		return true
+	}
+	weight = s.Clamp(weight)
+	if !s.sem.TryAcquire(int64(weight)) {
+		return false
+	}
+	s.inUse.Add(int64(weight))
+	return true
+}
+
+// Release gives back weight slots taken by Acquire or TryAcquire
+func (s *Semaphore) Release(weight int) {
+	if s == nil || weight <= 0 {
+		return
+	}
+	weight = s.Clamp(weight)
+	s.inUse.Add(-int64(weight))
+	s.sem.Release(int64(weight))
+
+	s.mu.Lock()
+	close(s.released)
+	s.released = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// Released returns a channel closed the next time slots are released; nil,
+// never closed, for a nil semaphore
+func (s *Semaphore) Released() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.released
+}
+
+// Stats returns the current occupancy; zero for a nil semaphore
+func (s *Semaphore) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{Name: s.name, Capacity: s.capacity, InUse: s.inUse.Load(), Waiting: s.waiting.Load()}
+}
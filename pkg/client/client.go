@@ -0,0 +1,245 @@
+package client
+
+// Typed Go client of the platform API, for scripts and other services.
+// Model types are the server's own (see types.go); everything is sent and
+// received as the server's JSON.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the platform API as the user a token belongs to
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+	userAgent  string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests through httpClient instead of a default one
+// with a 30s timeout. Streaming calls (command output, logs, exports) go
+// through a copy without its timeout, which would cover reading the whole
+// stream: their ctx bounds them.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithUserAgent sets the User-Agent of requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// New returns a client of the platform at baseURL (e.g.
+// "https://deploy.example.com") authenticating with token, a JWT from Login
+// or the dashboard. An empty token is fine for Login and public endpoints.
+func New(baseURL, token string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("base URL must be http:// or https://, got %q", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		userAgent:  "deploy-platform-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Token is the token requests authenticate with
+func (c *Client) Token() string {
+	return c.token
+}
+
+// Login signs in with an email and password, and authenticates the
+// client's further requests with the token it gets
+func (c *Client) Login(ctx context.Context, email, password string) (*User, error) {
+	var response struct {
+		User  User   `json:"user"`
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/auth/login", nil, map[string]string{"email": email, "password": password}, &response); err != nil {
+		return nil, err
+	}
+	c.token = response.Token
+	return &response.User, nil
+}
+
+// Profile returns who the client is authenticated as
+func (c *Client) Profile(ctx context.Context) (*Profile, error) {
+	var profile Profile
+	if err := c.do(ctx, http.MethodGet, "/api/profile", nil, nil, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// Error codes of the "code" field of error responses
+const (
+	CodeProjectArchived = "project_archived" // The project is archived: unarchive it to deploy
+	CodeTokenExpired    = "token_expired"    // The token expired: sign in again
+	CodeTokenInvalid    = "token_invalid"    // The token will never be accepted, e.g. signed by a retired key
+)
+
+// Error is an error response of the API: its JSON envelope and status code
+type Error struct {
+	StatusCode int       `json:"-"`
+	Message    string    `json:"error"`
+	Code       string    `json:"code,omitempty"`     // Code*, when the error has one
+	Quota      string    `json:"quota,omitempty"`    // The quota exceeded, on 402 and 429 quota errors
+	Limit      int       `json:"limit,omitempty"`    // Of Quota
+	Used       int       `json:"used,omitempty"`     // Of Quota
+	Problems   []Problem `json:"problems,omitempty"` // Every setting refused, on 400s of settings updates
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("platform API: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("platform API: %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 of the API
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsForbidden reports whether err is a 403 of the API: the resource isn't
+// the user's, or the action is disabled
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsUnauthorized reports whether err is a 401 of the API: no token, or an
+// expired or revoked one
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsTokenExpired reports whether err is a 401 of an expired token, which
+// signing in again fixes
+func IsTokenExpired(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == CodeTokenExpired
+}
+
+// IsQuotaExceeded reports whether err refuses an action over a plan quota
+func IsQuotaExceeded(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Quota != ""
+}
+
+// IsProjectArchived reports whether err refuses a deploy of an archived project
+func IsProjectArchived(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == CodeProjectArchived
+}
+
+func hasStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// do sends a request with body as JSON, if not nil, and decodes a successful
+// response into out, if not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send sends a request, returning an *Error for error responses. The caller
+// closes the body of the response.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	return c.sendWith(ctx, c.httpClient, method, path, query, body)
+}
+
+// stream is send for responses read as they come, without the timeout of
+// the HTTP client
+func (c *Client) stream(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	return c.sendWith(ctx, &httpClient, method, path, query, body)
+}
+
+func (c *Client) sendWith(ctx context.Context, httpClient *http.Client, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	u := c.resolve(path)
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(raw, apiErr) != nil && len(raw) > 0 {
+		apiErr.Message = strings.TrimSpace(string(raw)) // Not the JSON envelope, e.g. from a proxy
+	}
+	return nil, apiErr
+}
+
+// resolve is the URL of path, with its query, under the base URL
+func (c *Client) resolve(path string) *url.URL {
+	u := *c.baseURL
+	ref, err := url.Parse(path)
+	if err != nil {
+		u.Path += path
+		return &u
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + ref.Path
+	u.RawQuery = ref.RawQuery
+	return &u
+}
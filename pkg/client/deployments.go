@@ -0,0 +1,191 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// ListDeployments lists the deployments of all the user's projects, newest
+// first
+func (c *Client) ListDeployments(ctx context.Context) ([]DeploymentSummary, error) {
+	var deployments []DeploymentSummary
+	if err := c.do(ctx, http.MethodGet, "/api/deployments", nil, nil, &deployments); err != nil {
+		return nil, err
+	}
+	return deployments, nil
+}
+
+// Deployment returns a deployment, with its build and logs
+func (c *Client) Deployment(ctx context.Context, deploymentID uint) (*Deployment, error) {
+	var deployment Deployment
+	if err := c.do(ctx, http.MethodGet, deploymentPath(deploymentID, ""), nil, nil, &deployment); err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+// DeploymentHistory returns the status transitions of a deployment
+func (c *Client) DeploymentHistory(ctx context.Context, deploymentID uint) (*DeploymentHistory, error) {
+	var history DeploymentHistory
+	if err := c.do(ctx, http.MethodGet, deploymentPath(deploymentID, "/history"), nil, nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// DeploymentPodStatus reads the live status of a deployment's pods from its
+// cluster
+func (c *Client) DeploymentPodStatus(ctx context.Context, deploymentID uint) (*PodStatus, error) {
+	var status PodStatus
+	if err := c.do(ctx, http.MethodGet, deploymentPath(deploymentID, "/status"), nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// DeploymentLogs streams the build logs of a deployment as plain text. The
+// caller closes them.
+func (c *Client) DeploymentLogs(ctx context.Context, deploymentID uint) (io.ReadCloser, error) {
+	resp, err := c.stream(ctx, http.MethodGet, deploymentPath(deploymentID, "/logs"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// DeleteDeployment deletes a finished deployment no hostname serves
+func (c *Client) DeleteDeployment(ctx context.Context, deploymentID uint) error {
+	return c.do(ctx, http.MethodDelete, deploymentPath(deploymentID, ""), nil, nil, nil)
+}
+
+// ApproveDeployment approves a production deployment awaiting approval,
+// which is queued for its rollout, or overrides the deploy policy blocking
+// one, which is queued for its build
+func (c *Client) ApproveDeployment(ctx context.Context, deploymentID uint) (*Deployment, error) {
+	var deployment Deployment
+	if err := c.do(ctx, http.MethodPost, deploymentPath(deploymentID, "/approve"), nil, nil, &deployment); err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+// RejectDeployment rejects a production deployment awaiting approval, with
+// an optional reason recorded in its history
+func (c *Client) RejectDeployment(ctx context.Context, deploymentID uint, reason string) (*Deployment, error) {
+	var deployment Deployment
+	body := map[string]string{"reason": reason}
+	if err := c.do(ctx, http.MethodPost, deploymentPath(deploymentID, "/reject"), nil, body, &deployment); err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+// RedeployDeployment deploys the commit of a deployment again as a new
+// deployment. deployOnly rolls out its image instead of building it again,
+// failing when the image is gone from the build host.
+func (c *Client) RedeployDeployment(ctx context.Context, deploymentID uint, deployOnly bool) (*RedeployResult, error) {
+	var result RedeployResult
+	body := map[string]bool{"deploy_only": deployOnly}
+	if err := c.do(ctx, http.MethodPost, deploymentPath(deploymentID, "/redeploy"), nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RollbackDeployment rolls a project back to the image of its deployment
+// deploymentID, as a new deployment that builds nothing. It fails with a
+// 409 when the image is gone from the build host or already live.
+func (c *Client) RollbackDeployment(ctx context.Context, projectID, deploymentID uint) (*RollbackResult, error) {
+	var result RollbackResult
+	if err := c.do(ctx, http.MethodPost, projectPath(projectID, fmt.Sprintf("rollback/%d", deploymentID)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// WaitForDeployment polls a deployment every interval until it reaches a
+// final status (deployed, failed...), awaits approval or a policy override,
+// or ctx is done, and returns it
+func (c *Client) WaitForDeployment(ctx context.Context, deploymentID uint, interval time.Duration) (*Deployment, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deployment, err := c.Deployment(ctx, deploymentID)
+		if err != nil {
+			return nil, err
+		}
+		if deployment.Status.Terminal() || deployment.Status == StatusAwaitingApproval || deployment.Status == StatusPolicyBlocked {
+			return deployment, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExportDeployments iterates over the deployments of a project created in
+// [from, to) (zero times = the server's defaults), oldest first. Ranges
+// longer than the server exports at once are fetched a window at a time,
+// following its next links. Iteration stops at the first error.
+func (c *Client) ExportDeployments(ctx context.Context, projectID uint, from, to time.Time) iter.Seq2[*ExportedDeployment, error] {
+	query := url.Values{"format": {"json"}}
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339))
+	}
+	return c.exportPages(ctx, projectPath(projectID, "deployments/export")+"?"+query.Encode())
+}
+
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+func (c *Client) exportPages(ctx context.Context, first string) iter.Seq2[*ExportedDeployment, error] {
+	return func(yield func(*ExportedDeployment, error) bool) {
+		for path := first; path != ""; {
+			resp, err := c.stream(ctx, http.MethodGet, path, nil, nil)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			path = ""
+			if match := nextLink.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+				path = match[1]
+			}
+
+			scanner := bufio.NewScanner(resp.Body)
+			scanner.Buffer(make([]byte, 64<<10), 16<<20)
+			for scanner.Scan() {
+				var deployment ExportedDeployment
+				if err := json.Unmarshal(scanner.Bytes(), &deployment); err != nil {
+					resp.Body.Close()
+					yield(nil, fmt.Errorf("failed to decode an exported deployment: %w", err))
+					return
+				}
+				if !yield(&deployment, nil) {
+					resp.Body.Close()
+					return
+				}
+			}
+			resp.Body.Close()
+			if err := scanner.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
+
+func deploymentPath(deploymentID uint, path string) string {
+	return fmt.Sprintf("/api/deployments/%d%s", deploymentID, path)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Event types of a command's stream
+const (
+	ExecStarted = "started" // Job and TimeoutSeconds are set
+	ExecOutput  = "output"  // Data is a chunk of stdout and stderr combined
+	ExecExit    = "exit"    // ExitCode is set, -1 with a Reason when the command was killed
+	ExecError   = "error"   // Error says why the command couldn't run, or its stream broke
+)
+
+// ExecEvent is an event of a command's stream, see RunCommand
+type ExecEvent struct {
+	Type           string `json:"-"` // Exec*
+	Job            string `json:"job,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+	Data           string `json:"data,omitempty"`
+	ExitCode       int    `json:"exit_code"`
+	Reason         string `json:"reason,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// RunCommand runs a one-off command (run by /bin/sh -c) in the image of a
+// project's live production deployment and streams what happens on the
+// returned channel: started, output events, then exit or error, after
+// which the channel is closed. Canceling ctx kills the command. Refusals,
+// e.g. too many commands running, are returned as an *Error.
+func (c *Client) RunCommand(ctx context.Context, projectID uint, command string) (<-chan ExecEvent, error) {
+	resp, err := c.stream(ctx, http.MethodPost, projectPath(projectID, "exec"), nil, map[string]string{"command": command})
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan ExecEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		ended := false
+		err := readEvents(resp.Body, func(name string, data []byte) bool {
+			event := ExecEvent{Type: name}
+			if err := json.Unmarshal(data, &event); err != nil {
+				event = ExecEvent{Type: ExecError, Error: "undecodable " + name + " event: " + err.Error()}
+			}
+			ended = event.Type == ExecExit || event.Type == ExecError
+			select {
+			case events <- event:
+				return !ended
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if !ended && ctx.Err() == nil {
+			message := "the stream ended before the command did"
+			if err != nil {
+				message = "the stream broke: " + err.Error()
+			}
+			select {
+			case events <- ExecEvent{Type: ExecError, Error: message}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return events, nil
+}
+
+// readEvents reads server-sent events from r, calling handle with the name
+// and data of each until it returns false
+func readEvents(r io.Reader, handle func(name string, data []byte) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	name := "message"
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 && !handle(name, []byte(strings.Join(data, "\n"))) {
+				return nil
+			}
+			name, data = "message", nil
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"deploy-platform/internal/api"
+	"deploy-platform/internal/auth"
+	"deploy-platform/internal/config"
+	"deploy-platform/internal/database"
+	"deploy-platform/internal/github"
+	"deploy-platform/internal/hostname"
+	"deploy-platform/internal/models"
+	"deploy-platform/internal/queue"
+	"deploy-platform/internal/quota"
+	"deploy-platform/internal/secrets"
+	"deploy-platform/internal/testutil"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refs resolves refs of acme/app as GitHub would
+type refs map[string]string // Branch -> SHA
+
+func (r refs) ResolveRef(ctx context.Context, owner, repo, ref string) (*github.ResolvedRef, error) {
+	if sha, ok := r[ref]; ok && owner == "acme" && repo == "app" {
+		return &github.ResolvedRef{Kind: github.RefBranch, Name: ref, SHA: sha, Message: "Update " + ref}, nil
+	}
+	return nil, github.ErrRefNotFound
+}
+
+// platform is the API server with its routes as main wires them, a build
+// queue no worker consumes and GitHub faked, with users ada and bob
+type platform struct {
+	t      *testing.T
+	server *httptest.Server
+	queue  *queue.InMemoryQueue
+}
+
+func newPlatform(t *testing.T) *platform {
+	t.Helper()
+	testutil.DB(t)
+	gin.SetMode(gin.TestMode)
+	if err := quota.Init(&config.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.Init(&config.Config{EncryptionKey: "integration"}); err != nil {
+		t.Fatal(err)
+	}
+	auth.InitJWT(&config.Config{JWTSecret: "integration", BaseURL: "https://deploy.example.com"})
+	hash, err := auth.HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Create(&models.User{Username: "ada", Email: "ada@example.com", PasswordHash: hash, GitHubToken: "gho_ada"})
+	database.DB.Create(&models.User{Username: "bob", Email: "bob@example.com", PasswordHash: hash})
+
+	p := &platform{t: t, queue: queue.NewInMemoryQueue()}
+	webhooks := github.NewWebhookHandler(&config.Config{}, github.WebhookDeps{
+		Queue:     p.queue,
+		Hostnames: hostname.NewManager(&config.Config{BaseDomain: "deploy.example.com", PublicScheme: "https"}),
+		RefResolver: func(token string) github.RefResolver {
+			return refs{"main": strings.Repeat("a", 40), "feature": strings.Repeat("b", 40)}
+		},
+	})
+
+	r := gin.New()
+	r.POST("/api/auth/login", api.Login)
+	protected := r.Group("/api", auth.AuthMiddleware())
+	protected.GET("/profile", api.GetProfile)
+	protected.GET("/search", api.Search)
+	protected.GET("/projects", api.GetProjects)
+	protected.POST("/projects", api.CreateProject)
+	protected.POST("/projects/:id/archive", api.ArchiveProject)
+	protected.POST("/projects/:id/unarchive", api.UnarchiveProject)
+	protected.POST("/projects/:id/deployments", webhooks.HandleDeployRef)
+	protected.GET("/projects/:id/settings", api.GetProjectSettings)
+	protected.PUT("/projects/:id/settings", api.UpdateProjectSettings)
+	protected.POST("/projects/:id/settings/validate", api.ValidateProjectSettings)
+	protected.GET("/projects/:id/env", api.GetProjectEnv)
+	protected.POST("/projects/:id/env", api.SetProjectEnv)
+	protected.DELETE("/projects/:id/env/:key", api.DeleteProjectEnv)
+	protected.GET("/deployments", api.GetDeployments)
+	protected.GET("/deployments/:id", api.GetDeployment)
+	protected.GET("/deployments/:id/history", api.GetDeploymentHistory)
+	protected.GET("/deployments/:id/logs", api.GetDeploymentLogs)
+	protected.DELETE("/deployments/:id", api.DeleteDeployment)
+	p.server = httptest.NewServer(r)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// signIn returns a client signed in as the user of email
+func (p *platform) signIn(email string) *Client {
+	p.t.Helper()
+	c, err := New(p.server.URL+"/", "")
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if _, err := c.Login(context.Background(), email, "correct horse battery"); err != nil {
+		p.t.Fatal(err)
+	}
+	return c
+}
+
+// build stands in for a worker: it takes the next job off the queue and
+// builds and rolls it out, or fails it
+func (p *platform) build(logs string, fail bool) {
+	p.t.Helper()
+	job, ok := p.queue.TryDequeue()
+	if !ok {
+		p.t.Fatal("nothing queued")
+	}
+	statuses, buildStatus := []models.DeploymentStatus{models.StatusBuilding, models.StatusDeploying, models.StatusDeployed}, "success"
+	if fail {
+		statuses, buildStatus = []models.DeploymentStatus{models.StatusBuilding, models.StatusFailed}, "failed"
+	}
+	database.DB.Create(&models.Build{DeploymentID: job.DeploymentID, Status: buildStatus, Logs: logs})
+	for _, to := range statuses {
+		if err := models.SetDeploymentStatus(database.DB, job.DeploymentID, to, ""); err != nil {
+			p.t.Fatal(err)
+		}
+	}
+}
+
+// The SDK drives a project from creation to deployments and back, against
+// the API's own handlers
+func TestIntegration(t *testing.T) {
+	p := newPlatform(t)
+	ctx := context.Background()
+	c := p.signIn("ada@example.com")
+
+	profile, err := c.Profile(ctx)
+	if err != nil || profile.Username != "ada" || profile.UserID == 0 {
+		t.Fatalf("Profile = %+v, %v", profile, err)
+	}
+
+	project, err := c.CreateProject(ctx, &CreateProjectRequest{Name: "App", RepoURL: "https://github.com/acme/app", RepoOwner: "acme", RepoName: "app", Branch: "main"})
+	if err != nil || project.Slug != "app" {
+		t.Fatalf("CreateProject = %+v, %v", project, err)
+	}
+	projects, err := c.ListProjects(ctx, "")
+	if err != nil || len(projects) != 1 || projects[0].ID != project.ID {
+		t.Fatalf("ListProjects = %+v, %v", projects, err)
+	}
+
+	// Settings: a dry run first, then the update it described
+	settings, err := c.Settings(ctx, project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.Port = 3000
+	settings.ReleaseCommand = "npm run migrate"
+	validation, err := c.ValidateSettings(ctx, project.ID, settings)
+	if err != nil || !validation.Valid || len(validation.Changes) != 2 {
+		t.Fatalf("ValidateSettings = %+v, %v", validation, err)
+	}
+	if saved, err := c.UpdateSettings(ctx, project.ID, settings); err != nil || saved.Port != 3000 || saved.ReleaseCommand != "npm run migrate" {
+		t.Fatalf("UpdateSettings = %+v, %v", saved, err)
+	}
+	settings.Port = 70000
+	_, err = c.UpdateSettings(ctx, project.ID, settings)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 || len(apiErr.Problems) != 1 || apiErr.Problems[0].Field != "port" {
+		t.Fatalf("invalid port: got %v", err)
+	}
+
+	if _, err := c.SetEnv(ctx, project.ID, "API_KEY", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	env, err := c.Env(ctx, project.ID)
+	if err != nil || len(env) != 1 || env[0].Key != "API_KEY" || env[0].Value == "s3cret" {
+		t.Fatalf("Env = %+v, %v", env, err)
+	}
+
+	// A failed deployment, then a successful one
+	first, err := c.Deploy(ctx, project.ID, &DeployRefRequest{Ref: "feature", Promote: true})
+	if err != nil || first.SHA != strings.Repeat("b", 40) || first.Deployment.Branch != "feature" {
+		t.Fatalf("Deploy = %+v, %v", first, err)
+	}
+	p.build("npm ci\nnpm ERR! missing script: build\n", true)
+	second, err := c.Deploy(ctx, project.ID, &DeployRefRequest{Ref: "main", Promote: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.build("npm ci\nnpm run build\nBuilt in 12s\n", false)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	deployment, err := c.WaitForDeployment(waitCtx, second.Deployment.ID, 10*time.Millisecond)
+	if err != nil || deployment.Status != StatusDeployed || deployment.CommitSHA != strings.Repeat("a", 40) {
+		t.Fatalf("WaitForDeployment = %+v, %v", deployment, err)
+	}
+	history, err := c.DeploymentHistory(ctx, second.Deployment.ID)
+	if err != nil || history.Status != StatusDeployed || len(history.Events) == 0 || history.Events[len(history.Events)-1].ToStatus != StatusDeployed {
+		t.Fatalf("DeploymentHistory = %+v, %v", history, err)
+	}
+	logs, err := c.DeploymentLogs(ctx, second.Deployment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(logs)
+	logs.Close()
+	if !strings.Contains(string(content), "Built in 12s") {
+		t.Errorf("logs %q", content)
+	}
+
+	listed, err := c.ListDeployments(ctx)
+	if err != nil || len(listed) != 2 || listed[0].ID != second.Deployment.ID || listed[1].Status != string(StatusFailed) || listed[0].Project.Slug != "app" {
+		t.Fatalf("ListDeployments = %+v, %v", listed, err)
+	}
+	results, err := c.Search(ctx, "status:failed", 0)
+	if err != nil || results.Deployments == nil || len(results.Deployments.Results) != 1 || results.Deployments.Results[0].ID != first.Deployment.ID {
+		t.Fatalf("Search = %+v, %v", results, err)
+	}
+
+	// The failed deployment can go; asking for it then is a 404
+	if err := c.DeleteDeployment(ctx, first.Deployment.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Deployment(ctx, first.Deployment.ID); !IsNotFound(err) {
+		t.Errorf("deleted deployment: got %v", err)
+	}
+
+	// Archived projects can't deploy
+	if _, err := c.ArchiveProject(ctx, project.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Deploy(ctx, project.ID, &DeployRefRequest{Ref: "main"}); !IsProjectArchived(err) {
+		t.Errorf("deploying an archived project: got %v", err)
+	}
+	if _, err := c.UnarchiveProject(ctx, project.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Deploy(ctx, project.ID, &DeployRefRequest{Ref: "v9"}); !IsNotFound(err) {
+		t.Errorf("unknown ref: got %v", err)
+	}
+
+	// Another user sees none of it
+	bob := p.signIn("bob@example.com")
+	if _, err := bob.Deployment(ctx, second.Deployment.ID); !IsForbidden(err) && !IsNotFound(err) {
+		t.Errorf("bob's view of ada's deployment: got %v", err)
+	}
+	if _, err := bob.Settings(ctx, project.ID); !IsForbidden(err) && !IsNotFound(err) {
+		t.Errorf("bob's view of ada's settings: got %v", err)
+	}
+}
+
+// Errors come back as the typed values of their envelope
+func TestIntegrationErrors(t *testing.T) {
+	p := newPlatform(t)
+	ctx := context.Background()
+
+	anonymous, _ := New(p.server.URL, "")
+	if _, err := anonymous.Profile(ctx); !IsUnauthorized(err) {
+		t.Errorf("without a token: got %v", err)
+	}
+	if _, err := anonymous.Login(ctx, "ada@example.com", "wrong"); !IsUnauthorized(err) {
+		t.Errorf("wrong password: got %v", err)
+	}
+
+	expired, _ := auth.GenerateToken(1, "ada")
+	c, _ := New(p.server.URL, expired[:len(expired)-4]+"AAAA")
+	_, err := c.Profile(ctx)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != CodeTokenInvalid || IsTokenExpired(err) {
+		t.Errorf("tampered token: got %v", err)
+	}
+
+	c = p.signIn("ada@example.com")
+	if _, err := c.Settings(ctx, 404); !IsNotFound(err) {
+		t.Errorf("missing project: got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Event types of a followed deployment's logs
+const (
+	LogOutput = "log"   // Data is the next piece of the build's logs
+	LogDone   = "done"  // Status and BuildStatus are set: the build is over
+	LogError  = "error" // Error says why the logs couldn't be followed further
+)
+
+// LogEvent is an event of a followed deployment's logs, see
+// FollowDeploymentLogs
+type LogEvent struct {
+	Type        string `json:"-"` // Log*
+	Data        string `json:"data,omitempty"`
+	Status      string `json:"status,omitempty"`
+	BuildStatus string `json:"build_status,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// FollowDeploymentLogs streams the build logs of a deployment on the
+// returned channel as the build writes them: log events, from the start of
+// the logs, then done or error, after which the channel is closed. A
+// deployment still queued is followed once its build starts.
+func (c *Client) FollowDeploymentLogs(ctx context.Context, deploymentID uint) (<-chan LogEvent, error) {
+	resp, err := c.stream(ctx, http.MethodGet, deploymentPath(deploymentID, "/logs"), url.Values{"follow": {"true"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan LogEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		ended := false
+		err := readEvents(resp.Body, func(name string, data []byte) bool {
+			event := LogEvent{Type: name}
+			if err := json.Unmarshal(data, &event); err != nil {
+				event = LogEvent{Type: LogError, Error: "undecodable " + name + " event: " + err.Error()}
+			}
+			ended = event.Type == LogDone || event.Type == LogError
+			select {
+			case events <- event:
+				return !ended
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if !ended && ctx.Err() == nil {
+			message := "the stream ended before the build did"
+			if err != nil {
+				message = "the stream broke: " + err.Error()
+			}
+			select {
+			case events <- LogEvent{Type: LogError, Error: message}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return events, nil
+}
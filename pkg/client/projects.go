@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Project states, for ListProjects
+const (
+	StateActive   = "active"
+	StateArchived = "archived"
+	StateAll      = "all"
+)
+
+// ListProjects lists the user's projects in state (State*, "" = active),
+// newest first, each with its latest deployment
+func (c *Client) ListProjects(ctx context.Context, state string) ([]Project, error) {
+	query := url.Values{}
+	if state != "" {
+		query.Set("state", state)
+	}
+	var projects []Project
+	if err := c.do(ctx, http.MethodGet, "/api/projects", query, nil, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// CreateProject creates a project. Creating one for a repository the user
+// already has a project of returns that project.
+func (c *Client) CreateProject(ctx context.Context, req *CreateProjectRequest) (*Project, error) {
+	var project Project
+	if err := c.do(ctx, http.MethodPost, "/api/projects", nil, req, &project); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// ArchiveProject removes a project's resources and refuses its deploys
+// until UnarchiveProject, keeping its history
+func (c *Client) ArchiveProject(ctx context.Context, projectID uint) (*Project, error) {
+	return c.projectAction(ctx, projectID, "archive")
+}
+
+// UnarchiveProject lets an archived project deploy again
+func (c *Client) UnarchiveProject(ctx context.Context, projectID uint) (*Project, error) {
+	return c.projectAction(ctx, projectID, "unarchive")
+}
+
+func (c *Client) projectAction(ctx context.Context, projectID uint, action string) (*Project, error) {
+	var project Project
+	if err := c.do(ctx, http.MethodPost, projectPath(projectID, action), nil, nil, &project); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// Settings returns a project's settings
+func (c *Client) Settings(ctx context.Context, projectID uint) (*Settings, error) {
+	var settings Settings
+	if err := c.do(ctx, http.MethodGet, projectPath(projectID, "settings"), nil, nil, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings replaces a project's settings and returns them as saved.
+// Refused settings are an *Error listing every Problem.
+func (c *Client) UpdateSettings(ctx context.Context, projectID uint, settings *Settings) (*Settings, error) {
+	var saved Settings
+	if err := c.do(ctx, http.MethodPut, projectPath(projectID, "settings"), nil, settings, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// ValidateSettings tells what UpdateSettings would do with settings,
+// without saving anything
+func (c *Client) ValidateSettings(ctx context.Context, projectID uint, settings *Settings) (*SettingsValidation, error) {
+	var validation SettingsValidation
+	if err := c.do(ctx, http.MethodPost, projectPath(projectID, "settings/validate"), nil, settings, &validation); err != nil {
+		return nil, err
+	}
+	return &validation, nil
+}
+
+// Hostnames lists a project's hostnames, production first
+func (c *Client) Hostnames(ctx context.Context, projectID uint) ([]Hostname, error) {
+	var hostnames []Hostname
+	if err := c.do(ctx, http.MethodGet, projectPath(projectID, "hostnames"), nil, nil, &hostnames); err != nil {
+		return nil, err
+	}
+	return hostnames, nil
+}
+
+// RegistryCredentials lists the private registries a project has
+// credentials for
+func (c *Client) RegistryCredentials(ctx context.Context, projectID uint) ([]RegistryCredential, error) {
+	var credentials []RegistryCredential
+	if err := c.do(ctx, http.MethodGet, projectPath(projectID, "registry-credentials"), nil, nil, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// SetRegistryCredential saves a project's credentials for a registry, once
+// the registry accepts them, for its builds to pull base images and its pods
+// to pull images. Setting them again for the same host rotates them, which
+// rotated reports. Credentials the registry refuses are a 422 *Error.
+func (c *Client) SetRegistryCredential(ctx context.Context, projectID uint, req *RegistryCredentialRequest) (credential *RegistryCredential, rotated bool, err error) {
+	var response struct {
+		Credential RegistryCredential `json:"credential"`
+		Rotated    bool               `json:"rotated"`
+	}
+	if err := c.do(ctx, http.MethodPut, projectPath(projectID, "registry-credentials"), nil, req, &response); err != nil {
+		return nil, false, err
+	}
+	return &response.Credential, response.Rotated, nil
+}
+
+// DeleteRegistryCredential removes a project's credentials for a registry
+func (c *Client) DeleteRegistryCredential(ctx context.Context, projectID, credentialID uint) error {
+	return c.do(ctx, http.MethodDelete, projectPath(projectID, fmt.Sprintf("registry-credentials/%d", credentialID)), nil, nil, nil)
+}
+
+// Env lists a project's env vars, by key
+func (c *Client) Env(ctx context.Context, projectID uint) ([]EnvVar, error) {
+	var response struct {
+		Env []EnvVar `json:"env"`
+	}
+	if err := c.do(ctx, http.MethodGet, projectPath(projectID, "env"), nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Env, nil
+}
+
+// SetEnv creates or replaces an env var of a project. value is a literal,
+// stored encrypted, or a secret reference resolved at deploy time, refused
+// with a 422 *Error when its provider isn't configured. The change applies
+// from the next deployment, or the Redeploy returned.
+func (c *Client) SetEnv(ctx context.Context, projectID uint, key, value string) (*EnvVarChange, error) {
+	var change EnvVarChange
+	body := map[string]string{"key": key, "value": value}
+	if err := c.do(ctx, http.MethodPost, projectPath(projectID, "env"), nil, body, &change); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// DeleteEnv removes an env var of a project, returning the redeploy
+// applying the removal, nil when nothing is live in production
+func (c *Client) DeleteEnv(ctx context.Context, projectID uint, key string) (*RedeployOffer, error) {
+	var response struct {
+		Redeploy *RedeployOffer `json:"redeploy"`
+	}
+	if err := c.do(ctx, http.MethodDelete, projectPath(projectID, "env/"+url.PathEscape(key)), nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Redeploy, nil
+}
+
+// Analyses lists the analyses of pushes to a project in analysis-only mode,
+// newest first, of branch ("" = all), at most limit (0 = the server's default)
+func (c *Client) Analyses(ctx context.Context, projectID uint, branch string, limit int) ([]Analysis, error) {
+	query := url.Values{}
+	if branch != "" {
+		query.Set("branch", branch)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var response struct {
+		Analyses []Analysis `json:"analyses"`
+	}
+	if err := c.do(ctx, http.MethodGet, projectPath(projectID, "analyses"), query, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Analyses, nil
+}
+
+// Deploy deploys a ref (branch, tag or commit) of a project's repository,
+// e.g. to redeploy the production branch or roll back to an older commit
+// with Promote set
+func (c *Client) Deploy(ctx context.Context, projectID uint, req *DeployRefRequest) (*DeployResult, error) {
+	var result DeployResult
+	if err := c.do(ctx, http.MethodPost, projectPath(projectID, "deployments"), nil, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Search finds the user's projects and deployments matching q, e.g.
+// `migration status:failed branch:main`, at most limit of each (0 = the
+// server's default)
+func (c *Client) Search(ctx context.Context, q string, limit int) (*SearchResults, error) {
+	query := url.Values{"q": {q}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var results SearchResults
+	if err := c.do(ctx, http.MethodGet, "/api/search", query, nil, &results); err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
+
+func projectPath(projectID uint, path string) string {
+	return fmt.Sprintf("/api/projects/%d/%s", projectID, path)
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		switch r.Method {
+		case http.MethodGet:
+			io.WriteString(w, `{"env":[{"key":"API_KEY","value":"********"},{"key":"DB","value":"ref+vault://db#pw","reference":true}],"secret_providers":["vault"]}`)
+		case http.MethodPost:
+			io.WriteString(w, `{"key":"API_KEY","value":"********","redeploy":{"method":"POST","path":"/api/deployments/42/redeploy","body":{"deploy_only":true}}}`)
+		case http.MethodDelete:
+			io.WriteString(w, `{"message":"Env var deleted"}`)
+		}
+	}))
+	defer server.Close()
+	c, _ := New(server.URL, "token")
+	ctx := context.Background()
+
+	env, err := c.Env(ctx, 7)
+	if err != nil || len(env) != 2 || !env[1].Reference {
+		t.Fatalf("Env = %+v, %v", env, err)
+	}
+	change, err := c.SetEnv(ctx, 7, "API_KEY", "s3cret")
+	if err != nil || change.Key != "API_KEY" || change.Redeploy == nil || change.Redeploy.DeploymentID() != 42 {
+		t.Fatalf("SetEnv = %+v, %v", change, err)
+	}
+	redeploy, err := c.DeleteEnv(ctx, 7, "API_KEY")
+	if err != nil || redeploy != nil {
+		t.Fatalf("DeleteEnv = %+v, %v", redeploy, err)
+	}
+
+	want := []string{
+		"GET /api/projects/7/env ",
+		`POST /api/projects/7/env {"key":"API_KEY","value":"s3cret"}`,
+		"DELETE /api/projects/7/env/API_KEY ",
+	}
+	got, _ := json.Marshal(requests)
+	if wantJSON, _ := json.Marshal(want); string(got) != string(wantJSON) {
+		t.Errorf("sent %s", got)
+	}
+}
@@ -0,0 +1,395 @@
+package client
+
+import (
+	"deploy-platform/internal/models"
+	"fmt"
+	"time"
+)
+
+// Model types, shared with the server
+type (
+	User             = models.User
+	Project          = models.Project
+	Deployment       = models.Deployment
+	DeploymentStatus = models.DeploymentStatus
+	DeploymentEvent  = models.DeploymentEvent
+	Hostname         = models.Hostname
+	IngressSettings  = models.IngressSettings
+	BuildCommands    = models.BuildCommands
+	ManifestPatch    = models.ManifestPatch
+	DeployPolicy     = models.DeployPolicy
+	Analysis         = models.Analysis
+)
+
+// Deployment statuses
+const (
+	StatusPending    = models.StatusPending
+	StatusQueued     = models.StatusQueued
+	StatusBuilding   = models.StatusBuilding
+	StatusDeploying  = models.StatusDeploying
+	StatusDeployed   = models.StatusDeployed
+	StatusFailed     = models.StatusFailed
+	StatusCancelled  = models.StatusCancelled
+	StatusSkipped    = models.StatusSkipped
+	StatusSuperseded = models.StatusSuperseded
+
+	StatusAwaitingApproval = models.StatusAwaitingApproval // See ApproveDeployment
+	StatusRejected         = models.StatusRejected
+	StatusPolicyBlocked    = models.StatusPolicyBlocked // Overridden with ApproveDeployment
+	StatusDeployPending    = models.StatusDeployPending // Its rollout is retried, see Deployment.DeployRetryAt
+)
+
+// The types below mirror response and request bodies the server defines in
+// packages too heavy to import (Gin, Kubernetes); their JSON is the same.
+
+// Profile is who a token authenticates
+type Profile struct {
+	UserID        uint           `json:"user_id"`
+	Username      string         `json:"username"`
+	Impersonation *Impersonation `json:"impersonation,omitempty"` // While an admin acts as the user
+}
+
+// Impersonation is an admin's session acting as a user
+type Impersonation struct {
+	SessionID            uint      `json:"session_id"`
+	ImpersonatorID       uint      `json:"impersonator_id"`
+	ImpersonatorUsername string    `json:"impersonator_username"`
+	ExpiresAt            time.Time `json:"expires_at"`
+	ReadOnly             bool      `json:"read_only"`
+}
+
+// CreateProjectRequest creates a project, see Client.CreateProject
+type CreateProjectRequest struct {
+	Name      string `json:"name"`
+	RepoURL   string `json:"repo_url"`
+	RepoOwner string `json:"repo_owner"`
+	RepoName  string `json:"repo_name"`
+	Branch    string `json:"branch,omitempty"`
+	RepoID    *int64 `json:"repo_id,omitempty"` // GitHub repository ID, when known
+
+	Visibility  string `json:"visibility,omitempty"`   // public (default) or internal
+	Cluster     string `json:"cluster,omitempty"`      // "" = the default one
+	ProcessType string `json:"process_type,omitempty"` // web (default) or worker
+}
+
+// Settings are a project's settings. Sent back to UpdateSettings, omitted
+// ingress fields are removed, and an empty Visibility or ProcessType is
+// left unchanged.
+type Settings struct {
+	Ingress               IngressSettings     `json:"ingress"`
+	StrictImageBudget     bool                `json:"strict_image_budget"`
+	Port                  int                 `json:"port"`
+	Visibility            string              `json:"visibility"`
+	CustomLabels          map[string]string   `json:"custom_labels"`
+	CustomAnnotations     map[string]string   `json:"custom_annotations"`
+	BuildCommands         BuildCommands       `json:"build_commands"`
+	ProcessType           string              `json:"process_type"`
+	ReleaseCommand        string              `json:"release_command"`
+	PublicBadge           bool                `json:"public_badge"`
+	PlaceholderPrivate    bool                `json:"placeholder_private"`
+	RequireApproval       bool                `json:"require_approval"`        // Production deployments wait for ApproveDeployment once built
+	ApprovalWindowMinutes int                 `json:"approval_window_minutes"` // Minutes before they are cancelled, 0 = the platform default
+	ManifestPatches       []ManifestPatch     `json:"manifest_patches"`        // Applied to the rendered Kubernetes objects
+	KeepBuildLogsLocal    bool                `json:"keep_build_logs_local"`   // Build logs are never shipped to the platform's log sink
+	PreviewProvisioner    *PreviewProvisioner `json:"preview_provisioner"`     // Sets up resources of their own for branch and preview deployments, nil = none
+	AnalysisOnly          bool                `json:"analysis_only"`           // Pushes are analyzed instead of built and deployed, see Analyses
+	BranchDeploys         bool                `json:"branch_deploys"`          // Pushes to other branches deploy as branch deployments instead of being ignored
+	DeployPolicy          *DeployPolicy       `json:"deploy_policy"`           // Checks pushes deploying to production, nil = none
+
+	// Read only
+	Cluster             string               `json:"cluster,omitempty"`
+	MigratingFrom       string               `json:"migrating_from,omitempty"`
+	RegistryCredentials []RegistryCredential `json:"registry_credentials,omitempty"` // See SetRegistryCredential
+}
+
+// PreviewProvisioner gives a project's branch and preview deployments
+// resources of their own, e.g. a database. Env templates may use {preview},
+// {pr}, {branch}, {sha}, {value} (the value without the provisioner) and
+// {database} (the database name of the value's URL, which they replace).
+type PreviewProvisioner struct {
+	Type            string            `json:"type"`                       // template or command
+	Env             map[string]string `json:"env,omitempty"`              // Env var → template, e.g. DATABASE_URL: "{database}_pr_{pr}"
+	Image           string            `json:"image,omitempty"`            // Command: image the commands run in, "" = the deployment's
+	Command         string            `json:"command,omitempty"`          // Command: provisions, printing further overrides as "::env KEY=VALUE" lines
+	TeardownCommand string            `json:"teardown_command,omitempty"` // Command: frees what Command provisioned once the preview is retired
+	TimeoutSeconds  int               `json:"timeout_seconds,omitempty"`  // Command: how long each command may run, 0 = 5 minutes
+}
+
+// RegistryCredential is a project's credentials for a private registry, as
+// the server shows them: Password is always masked
+type RegistryCredential struct {
+	ID         uint       `json:"id"`
+	Host       string     `json:"host"`
+	Username   string     `json:"username"`
+	Password   string     `json:"password"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"` // When the registry last accepted them
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// RegistryCredentialRequest sets a project's credentials for a registry
+type RegistryCredentialRequest struct {
+	Host     string `json:"host"` // host[:port], or docker.io
+	Username string `json:"username"`
+	Password string `json:"password"` // Password or access token
+}
+
+// EnvVar is a project's env var as the server shows it: literal values are
+// always masked, secret references are shown as stored
+type EnvVar struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Reference bool      `json:"reference"` // Value is a secret reference, e.g. ref+vault://secret/data/app#password
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EnvVarChange is an env var as saved by SetEnv
+type EnvVarChange struct {
+	EnvVar
+	Redeploy *RedeployOffer `json:"redeploy,omitempty"`
+}
+
+// RedeployOffer is the request rolling out the image live in production
+// again with the project's current env vars, applying a change without a
+// push. RedeployDeployment(ctx, DeploymentID, true) sends it.
+type RedeployOffer struct {
+	Method string                 `json:"method"`
+	Path   string                 `json:"path"` // /api/deployments/:id/redeploy
+	Body   map[string]interface{} `json:"body"`
+}
+
+// DeploymentID is the deployment the offer redeploys, 0 if the path names none
+func (o *RedeployOffer) DeploymentID() uint {
+	var id uint
+	fmt.Sscanf(o.Path, "/api/deployments/%d/redeploy", &id)
+	return id
+}
+
+// Problem is a setting the platform refuses
+type Problem struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// SettingsChange is a setting an update changes, and what changing it does
+type SettingsChange struct {
+	Field   string      `json:"field"`
+	From    interface{} `json:"from"`
+	To      interface{} `json:"to"`
+	Effect  string      `json:"effect"` // now, next_deploy or rebuild
+	Message string      `json:"message"`
+}
+
+// Rebuild is the request rebuilding a project once its build commands changed
+type Rebuild struct {
+	Method string           `json:"method"`
+	Path   string           `json:"path"`
+	Body   DeployRefRequest `json:"body"`
+}
+
+// SettingsValidation is what saving settings would do, see ValidateSettings
+type SettingsValidation struct {
+	Valid    bool             `json:"valid"`
+	Problems []Problem        `json:"problems"`
+	Changes  []SettingsChange `json:"changes"`
+	Warnings []string         `json:"warnings"`
+	Rebuild  *Rebuild         `json:"rebuild,omitempty"`
+
+	DeployAnalyzed *DeployAnalyzed `json:"deploy_analyzed,omitempty"` // Leaving analysis-only mode
+}
+
+// DeployAnalyzed is the request deploying the latest commit analyzed on a
+// project's production branch, offered when it leaves analysis-only mode
+type DeployAnalyzed struct {
+	Method   string           `json:"method"`
+	Path     string           `json:"path"`
+	Body     DeployRefRequest `json:"body"`
+	Analysis Analysis         `json:"analysis"`
+}
+
+// DeployRefRequest deploys a ref of a project's repository
+type DeployRefRequest struct {
+	Ref     string `json:"ref"`
+	Promote bool   `json:"promote,omitempty"` // To the production hostname instead of a preview one
+}
+
+// DeployResult is a deployment triggered by Deploy
+type DeployResult struct {
+	Message     string     `json:"message"`
+	Deployment  Deployment `json:"deployment"`
+	SHA         string     `json:"sha"`
+	Hostname    string     `json:"hostname"`
+	URL         string     `json:"url,omitempty"`
+	InternalURL string     `json:"internal_url,omitempty"`
+}
+
+// RedeployResult is a deployment triggered by RedeployDeployment
+type RedeployResult struct {
+	Message    string     `json:"message"`
+	Deployment Deployment `json:"deployment"`
+	RedeployOf uint       `json:"redeploy_of"`
+	DeployOnly bool       `json:"deploy_only"`
+}
+
+// RollbackResult is a deployment triggered by RollbackDeployment
+type RollbackResult struct {
+	Message          string     `json:"message"`
+	Deployment       Deployment `json:"deployment"`
+	RollbackTo       uint       `json:"rollback_to"`
+	RolledBackFromID *uint      `json:"rolled_back_from_id"` // nil when nothing was live
+}
+
+// DeploymentSummary is a deployment as listed, without build logs
+type DeploymentSummary struct {
+	ID        uint           `json:"id"`
+	ProjectID uint           `json:"project_id"`
+	Status    string         `json:"status"`
+	CommitSHA string         `json:"commit_sha"`
+	CommitMsg string         `json:"commit_msg"`
+	Branch    string         `json:"branch"`
+	Hostname  string         `json:"hostname"`
+	URL       string         `json:"url,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Project   ProjectSummary `json:"project"`
+	Build     *BuildSummary  `json:"build,omitempty"`
+
+	FailureCategory string `json:"failure_category,omitempty"`
+	FailureDetail   string `json:"failure_detail,omitempty"`
+
+	DriftedAt *time.Time `json:"drifted_at,omitempty"` // Found live without its objects in the cluster
+}
+
+// ProjectSummary identifies the project of a listed deployment
+type ProjectSummary struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// BuildSummary is a build without its logs
+type BuildSummary struct {
+	ID               uint   `json:"id"`
+	Status           string `json:"status"`
+	DurationSeconds  *int64 `json:"duration_seconds,omitempty"`
+	Framework        string `json:"framework,omitempty"`
+	FrameworkVersion string `json:"framework_version,omitempty"`
+
+	ImageSizeBytes int64    `json:"image_size_bytes,omitempty"`
+	Warnings       []string `json:"warnings,omitempty"`
+}
+
+// DeploymentHistory is the status transitions of a deployment, oldest first
+type DeploymentHistory struct {
+	DeploymentID uint              `json:"deployment_id"`
+	Status       DeploymentStatus  `json:"status"`
+	Events       []DeploymentEvent `json:"events"`
+}
+
+// PodStatus is how the pods of the resources a deployment was rolled out to
+// are doing right now, as read from its cluster
+type PodStatus struct {
+	DeploymentID uint             `json:"deployment_id"`
+	Status       DeploymentStatus `json:"status"`
+	Cluster      string           `json:"cluster"`
+	Live         bool             `json:"live"` // The pods run this deployment, not a later one
+	Workload     WorkloadStatus   `json:"workload"`
+}
+
+// WorkloadStatus is the replicas and pods of a Kubernetes Deployment
+type WorkloadStatus struct {
+	Name              string    `json:"name"`
+	Image             string    `json:"image"`
+	Replicas          int32     `json:"replicas"` // Desired
+	ReadyReplicas     int32     `json:"ready_replicas"`
+	UpdatedReplicas   int32     `json:"updated_replicas"`
+	AvailableReplicas int32     `json:"available_replicas"`
+	RolledOut         bool      `json:"rolled_out"`
+	Restarts          int32     `json:"restarts"`
+	Pods              []Pod     `json:"pods"` // Newest first
+	LastEvent         *PodEvent `json:"last_event"`
+}
+
+// Pod is the state of one pod of a workload
+type Pod struct {
+	Name            string    `json:"name"`
+	Phase           string    `json:"phase"`
+	Ready           bool      `json:"ready"`
+	Restarts        int32     `json:"restarts"`
+	State           string    `json:"state"`            // "running", or e.g. "CrashLoopBackOff"
+	LastTermination string    `json:"last_termination"` // e.g. "Error, exit code 1: ..."
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// PodEvent is an event Kubernetes reported about a pod
+type PodEvent struct {
+	Pod     string    `json:"pod"`
+	Type    string    `json:"type"` // Normal or Warning
+	Reason  string    `json:"reason"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// ExportedDeployment is a deployment as exported for compliance reports
+type ExportedDeployment struct {
+	ID              uint              `json:"id"`
+	Project         string            `json:"project"` // Slug
+	Environment     string            `json:"environment"`
+	Status          string            `json:"status"`
+	CommitSHA       string            `json:"commit_sha"`
+	CommitMsg       string            `json:"commit_msg"`
+	Branch          string            `json:"branch"`
+	Ref             string            `json:"ref,omitempty"`
+	Trigger         string            `json:"trigger"`
+	Actor           string            `json:"actor"`
+	Cluster         string            `json:"cluster,omitempty"`
+	Hostname        string            `json:"hostname"`
+	CreatedAt       time.Time         `json:"created_at"`
+	FinishedAt      *time.Time        `json:"finished_at"`
+	DurationSeconds *int64            `json:"duration_seconds"`
+	LiveFrom        *time.Time        `json:"live_from"`
+	LiveUntil       *time.Time        `json:"live_until"`
+	FailureCategory string            `json:"failure_category,omitempty"`
+	Events          []DeploymentEvent `json:"events"`
+}
+
+// Highlight is a match in a field of a search result, in characters
+type Highlight struct {
+	Field string `json:"field"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// SearchResults are the results of Search, each group nil when the query
+// didn't search its type
+type SearchResults struct {
+	Projects    *ProjectSearchResults    `json:"projects"`
+	Deployments *DeploymentSearchResults `json:"deployments"`
+}
+
+// ProjectSearchResults are the projects found by Search, best first
+type ProjectSearchResults struct {
+	Results []ProjectSearchResult `json:"results"`
+	More    bool                  `json:"more"` // More matched than the limit
+}
+
+// DeploymentSearchResults are the deployments found by Search, best first
+type DeploymentSearchResults struct {
+	Results []DeploymentSearchResult `json:"results"`
+	More    bool                     `json:"more"` // More matched than the limit
+}
+
+// ProjectSearchResult is a project found by Search
+type ProjectSearchResult struct {
+	ProjectSummary
+	RepoURL    string      `json:"repo_url"`
+	Archived   bool        `json:"archived,omitempty"`
+	Score      int         `json:"score"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// DeploymentSearchResult is a deployment found by Search
+type DeploymentSearchResult struct {
+	DeploymentSummary
+	Score      int         `json:"score"`
+	Highlights []Highlight `json:"highlights"`
+}
@@ -5,7 +5,11 @@ package docker
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
@@ -24,12 +28,34 @@ func NewClient() (*Client, error) {
 	return &Client{cli: cli}, nil
 }
 
-func (c *Client) BuildImage(ctx context.Context, buildContext io.Reader, imageTag string, dockerfile string) error {
+// RegistryAuth is the username and password of a registry base images are
+// pulled from
+type RegistryAuth struct {
+	Username string
+	Password string
+}
+
+// BuildImage builds imageTag from buildContext, copying the daemon's output
+// stream to output (may be nil). Base images are pulled with auths, keyed by
+// registry as in a docker config file; with any, they are always pulled, so
+// the credentials are checked rather than the daemon's cached copy used.
+func (c *Client) BuildImage(ctx context.Context, buildContext io.Reader, imageTag string, dockerfile string, auths map[string]RegistryAuth, output io.Writer) error {
 	buildOptions := types.ImageBuildOptions{
 		Tags:       []string{imageTag},
 		Dockerfile: dockerfile,
 		Remove:     true,
 	}
+	if len(auths) > 0 {
+		buildOptions.PullParent = true
+		buildOptions.AuthConfigs = make(map[string]types.AuthConfig, len(auths))
+		for registry, auth := range auths {
+			buildOptions.AuthConfigs[registry] = types.AuthConfig{
+				Username:      auth.Username,
+				Password:      auth.Password,
+				ServerAddress: registry,
+			}
+		}
+	}
 
 	response, err := c.cli.ImageBuild(ctx, buildContext, buildOptions)
 	if err != nil {
@@ -38,11 +64,109 @@ func (c *Client) BuildImage(ctx context.Context, buildContext io.Reader, imageTa
 	defer response.Body.Close()
 
 	// Read build output (logs)
-	_, err = io.Copy(io.Discard, response.Body)
+	if output == nil {
+		output = io.Discard
+	}
+	_, err = io.Copy(output, response.Body)
 	return err
 }
 
-func (c *Client) PushImage(ctx context.Context, imageTag string) error {
-	// TODO: Implement image push to registry
-	return nil
+// ImageLayer is one step of an image's history, newest first
+type ImageLayer struct {
+	CreatedBy string // Dockerfile instruction that created it
+	Size      int64
+}
+
+// ImageInfo describes a built image
+type ImageInfo struct {
+	ID      string // Content digest of the image config, sha256:...
+	Size    int64
+	Layers  int          // Filesystem layers
+	History []ImageLayer // Every step, including those adding no files
+}
+
+// InspectImage returns the size and history of imageTag
+func (c *Client) InspectImage(ctx context.Context, imageTag string) (*ImageInfo, error) {
+	inspect, _, err := c.cli.ImageInspectWithRaw(ctx, imageTag)
+	if err != nil {
+		return nil, err
+	}
+	history, err := c.cli.ImageHistory(ctx, imageTag)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ImageInfo{ID: inspect.ID, Size: inspect.Size, Layers: len(inspect.RootFS.Layers)}
+	for _, item := range history {
+		info.History = append(info.History, ImageLayer{CreatedBy: item.CreatedBy, Size: item.Size})
+	}
+	return info, nil
+}
+
+// ImageExists reports whether the image ref is on the Docker host
+func (c *Client) ImageExists(ctx context.Context, ref string) (bool, error) {
+	if _, _, err := c.cli.ImageInspectWithRaw(ctx, ref); err != nil {
+		if client.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// RepoDigest returns the registry digest (sha256:...) of the local image
+// ref, the one it was pulled as. Images never pulled or pushed have none.
+func (c *Client) RepoDigest(ctx context.Context, ref string) (string, error) {
+	inspect, _, err := c.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		if _, digest, ok := strings.Cut(repoDigest, "@"); ok {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("image %s has no registry digest", ref)
+}
+
+// ServerVersion returns the daemon's version
+func (c *Client) ServerVersion(ctx context.Context) (string, error) {
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.ServerVersion, nil
+}
+
+// Ping checks the daemon answers
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.cli.Ping(ctx)
+	return err
+}
+
+// PushImage pushes imageTag, named after the registry it goes to, with auth
+// (nil = anonymously), copying the daemon's output stream to output (may be
+// nil). The daemon reports a failed push in that stream, not as an error.
+func (c *Client) PushImage(ctx context.Context, imageTag string, auth *RegistryAuth, output io.Writer) error {
+	authConfig := types.AuthConfig{}
+	if auth != nil {
+		server, _, _ := strings.Cut(imageTag, "/")
+		authConfig = types.AuthConfig{Username: auth.Username, Password: auth.Password, ServerAddress: server}
+	}
+	// The daemon wants the header even for anonymous pushes
+	encoded, err := json.Marshal(authConfig)
+	if err != nil {
+		return err
+	}
+	response, err := c.cli.ImagePush(ctx, imageTag, types.ImagePushOptions{RegistryAuth: base64.URLEncoding.EncodeToString(encoded)})
+	if err != nil {
+		return err
+	}
+	defer response.Close()
+
+	if output == nil {
+		output = io.Discard
+	}
+	_, err = io.Copy(output, response)
+	return err
 }
@@ -0,0 +1,10 @@
+// Package web holds the dashboard's templates and static files, embedded in
+// the binary so it runs from any working directory
+package web
+
+import "embed"
+
+// Files holds templates/ and static/
+//
+//go:embed templates static
+var Files embed.FS
@@ -12,7 +12,7 @@ function checkAuth() {
             window.history.replaceState({}, document.title, window.location.pathname);
             return urlToken;
         }
-        window.location.href = '/login';
+        window.location.href = '/logout';
         return null;
     }
     return token;
@@ -38,7 +38,7 @@ async function apiRequest(endpoint, options = {}) {
         if (response.status === 401) {
             localStorage.removeItem('token');
             localStorage.removeItem('user');
-            window.location.href = '/login';
+            window.location.href = '/logout';
             return null;
         }
 
@@ -90,6 +90,53 @@ async function loadUser() {
     if (usernameEl) {
         usernameEl.textContent = user.username || user.email || 'User';
     }
+
+    showImpersonationBanner();
+}
+
+// Show a "viewing as" banner while an admin impersonates this user
+async function showImpersonationBanner() {
+    const profile = await apiRequest('/profile');
+    const impersonation = profile?.impersonation;
+    if (!impersonation || document.getElementById('impersonationBanner')) {
+        return;
+    }
+
+    const expires = new Date(impersonation.expires_at).toLocaleTimeString();
+    const banner = document.createElement('div');
+    banner.id = 'impersonationBanner';
+    banner.className = 'bg-yellow-500 text-black text-sm text-center py-2';
+    const mode = impersonation.read_only ? 'read-only, ' : '';
+    banner.textContent = `${impersonation.impersonator_username} viewing as ${profile.username} (${mode}until ${expires})`;
+    document.body.prepend(banner);
+}
+
+// Show a banner while the platform is degraded, e.g. "Builds are delayed:
+// container runtime unavailable since 14:02"
+async function showPlatformStatus() {
+    const platform = await apiRequest('/platform-status');
+    const ongoing = (platform?.incidents || []).filter(incident => !incident.ended_at);
+    let banner = document.getElementById('platformStatusBanner');
+    if (ongoing.length === 0) {
+        banner?.remove();
+        return;
+    }
+
+    if (!banner) {
+        banner = document.createElement('div');
+        banner.id = 'platformStatusBanner';
+        banner.className = 'bg-red-600 text-white text-sm text-center py-2';
+        document.body.prepend(banner);
+    }
+    banner.textContent = ongoing.map(incident => {
+        const since = new Date(incident.started_at).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+        return `${incident.summary} since ${since}${incident.message ? ` (${incident.message})` : ''}`;
+    }).join(' · ');
+}
+
+// Escape text (commit messages, branch names) before putting it in HTML
+function escapeHTML(text) {
+    return String(text).replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;').replace(/"/g, '&quot;').replace(/'/g, '&#39;');
 }
 
 // Get status badge HTML (Vercel style)
@@ -100,7 +147,11 @@ function getStatusBadge(status) {
         'building': { label: 'Building', class: 'status-building', text: 'text-blue-400' },
         'deploying': { label: 'Deploying', class: 'status-building', text: 'text-blue-400' },
         'failed': { label: 'Error', class: 'status-error', text: 'text-red-400' },
-        'pending': { label: 'Pending', class: 'status-pending', text: 'text-yellow-400' }
+        'pending': { label: 'Pending', class: 'status-pending', text: 'text-yellow-400' },
+        'queued': { label: 'Queued', class: 'status-pending', text: 'text-yellow-400' },
+        'cancelled': { label: 'Cancelled', class: 'status-pending', text: 'text-gray-400' },
+        'skipped': { label: 'Skipped', class: 'status-pending', text: 'text-gray-400' },
+        'superseded': { label: 'Superseded', class: 'status-pending', text: 'text-gray-400' }
     };
     
     const config = statusConfig[status?.toLowerCase()] || { label: status, class: 'status-pending', text: 'text-gray-400' };
@@ -168,9 +219,9 @@ async function loadProjects() {
 
         container.innerHTML = projects.map(project => {
             const deployments = project.deployments || project.Deployments || [];
-            const latestDeployment = deployments.find(d => (d.hostname || d.Hostname)) || deployments[0];
+            const latestDeployment = project.latest_deployment || deployments[0];
             const hostname = latestDeployment?.hostname || latestDeployment?.Hostname || '';
-            const liveUrl = hostname ? `http://${hostname}` : null;
+            const liveUrl = latestDeployment?.url || null;
             const status = latestDeployment?.status || 'pending';
             
             // Count deployments
@@ -182,6 +233,9 @@ async function loadProjects() {
                         <div class="flex-1">
                             <h3 class="text-lg font-semibold text-white mb-1">${project.name || 'Unnamed Project'}</h3>
                             <p class="text-sm text-gray-400">${project.repo_owner || ''}/${project.repo_name || ''}</p>
+                            ${project.internal_url ? `
+                                <p class="text-xs text-gray-500 mt-1" title="Only reachable from your other projects">Internal · ${project.internal_url}</p>
+                            ` : ''}
                         </div>
                         ${liveUrl ? `
                             <a href="${liveUrl}" target="_blank" 
@@ -253,10 +307,15 @@ async function loadDeployments() {
 
         container.innerHTML = deployments.slice(0, 10).map(deployment => {
             const date = new Date(deployment.created_at).toLocaleString();
-            const commitShort = deployment.commit_sha?.substring(0, 7) || 'N/A';
+            const commitShort = escapeHTML(deployment.commit_sha?.substring(0, 7) || 'N/A');
             const hostname = deployment.hostname || '';
+            const url = deployment.url || '';
             const status = deployment.status || 'pending';
             const projectName = deployment.project?.name || 'Unknown Project';
+            const framework = deployment.build?.framework
+                ? `${deployment.build.framework}${deployment.build.framework_version ? ' ' + deployment.build.framework_version : ''}`
+                : '';
+            const warnings = deployment.build?.warnings || [];
             
             return `
                 <div class="bg-gray-900 border border-gray-800 rounded-lg p-4 hover:border-gray-700 transition-colors">
@@ -266,15 +325,21 @@ async function loadDeployments() {
                                 <h3 class="text-sm font-medium text-white truncate">${projectName}</h3>
                                 ${getStatusBadge(status)}
                             </div>
-                            <p class="text-xs text-gray-400 truncate mb-2">${deployment.commit_msg || 'No commit message'}</p>
+                            <p class="text-xs text-gray-400 truncate mb-2" title="${escapeHTML(deployment.commit_msg_detail || deployment.commit_msg || '')}">${escapeHTML(deployment.commit_msg || 'No commit message')}</p>
+                            ${deployment.failure_detail ? `<p class="text-xs text-red-400 mb-2">${escapeHTML(deployment.failure_detail)}</p>` : ''}
                             <div class="flex items-center space-x-4 text-xs text-gray-500">
-                                <span>${deployment.branch || 'main'}</span>
+                                <span>${escapeHTML(deployment.branch || 'main')}</span>
                                 <span class="font-mono">${commitShort}</span>
+                                ${deployment.pull_request ? `<span class="px-1.5 py-0.5 rounded bg-gray-800 text-gray-300">PR #${deployment.pull_request}</span>` : ''}
+                                ${framework ? `<span class="px-1.5 py-0.5 rounded bg-gray-800 text-gray-300">${framework}</span>` : ''}
+                                ${deployment.delayed_by_incident ? `<span class="px-1.5 py-0.5 rounded bg-red-900 text-red-300" title="Queued during a platform incident, which slowed it down">Delayed by incident</span>` : ''}
+                                ${deployment.drifted_at ? `<span class="px-1.5 py-0.5 rounded bg-yellow-900 text-yellow-300" title="Its objects are gone from the cluster: deploy again to restore them">Drifted</span>` : ''}
+                                ${warnings.length ? `<span class="px-1.5 py-0.5 rounded bg-yellow-900 text-yellow-300" title="${warnings.join('\n').replace(/"/g, '&quot;')}">${warnings.length} warning${warnings.length > 1 ? 's' : ''}</span>` : ''}
                                 <span>${date}</span>
                             </div>
-                            ${hostname ? `
+                            ${url ? `
                                 <div class="mt-3">
-                                    <a href="http://${hostname}" target="_blank" 
+                                    <a href="${url}" target="_blank" 
                                        class="inline-flex items-center text-xs text-green-400 hover:text-green-300">
                                         <svg class="w-3 h-3 mr-1.5" fill="none" stroke="currentColor" viewBox="0 0 24 24">
                                             <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M10 6H6a2 2 0 00-2 2v10a2 2 0 002 2h10a2 2 0 002-2v-4M14 4h6m0 0v6m0-6L10 14"/>
@@ -302,7 +367,7 @@ async function loadDeployments() {
 }
 
 async function refreshData() {
-    await Promise.all([loadProjects(), loadDeployments()]);
+    await Promise.all([loadProjects(), loadDeployments(), showPlatformStatus()]);
 }
 
 // Initialize
@@ -316,7 +381,7 @@ document.addEventListener('DOMContentLoaded', () => {
     document.getElementById('logoutBtn')?.addEventListener('click', () => {
         localStorage.removeItem('token');
         localStorage.removeItem('user');
-        window.location.href = '/login';
+        window.location.href = '/logout';
     });
 
     // Refresh button
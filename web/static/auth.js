@@ -6,6 +6,8 @@ document.addEventListener('DOMContentLoaded', () => {
     const registerForm = document.getElementById('registerForm');
     const loginError = document.getElementById('loginError');
     const registerError = document.getElementById('registerError');
+    // Where to go after signing in (validated server-side)
+    const nextPage = document.body.dataset.next || '/dashboard';
 
     // Tab switching
     loginTab.addEventListener('click', () => {
@@ -48,7 +50,7 @@ document.addEventListener('DOMContentLoaded', () => {
             if (response.ok) {
                 localStorage.setItem('token', result.token);
                 localStorage.setItem('user', JSON.stringify(result.user));
-                window.location.href = '/dashboard';
+                window.location.href = nextPage;
             } else {
                 loginError.textContent = result.error || 'Login failed';
                 loginError.classList.remove('hidden');
@@ -82,7 +84,7 @@ document.addEventListener('DOMContentLoaded', () => {
             if (response.ok) {
                 localStorage.setItem('token', result.token);
                 localStorage.setItem('user', JSON.stringify(result.user));
-                window.location.href = '/dashboard';
+                window.location.href = nextPage;
             } else {
                 registerError.textContent = result.error || 'Registration failed';
                 registerError.classList.remove('hidden');
@@ -92,4 +94,31 @@ document.addEventListener('DOMContentLoaded', () => {
             registerError.classList.remove('hidden');
         }
     });
+
+    showProviders();
 });
+
+// Show the sign-in buttons of the providers configured on the platform. The
+// SSO button stays hidden unless it is; the others stay shown if the config
+// can't be fetched, their own errors explain what's wrong.
+async function showProviders() {
+    let config;
+    try {
+        const response = await fetch('/api/ui-config');
+        if (!response.ok) return;
+        config = await response.json();
+    } catch {
+        return;
+    }
+    const providers = config.providers || {};
+    document.querySelectorAll('[data-provider]').forEach(button => {
+        button.classList.toggle('hidden', !providers[button.dataset.provider]);
+    });
+    document.querySelectorAll('[data-oidc-name]').forEach(label => {
+        label.textContent = providers.oidc_name || 'SSO';
+    });
+    document.querySelectorAll('[data-providers]').forEach(section => {
+        const any = section.querySelector('[data-provider]:not(.hidden)');
+        section.classList.toggle('hidden', !any);
+    });
+}